@@ -0,0 +1,86 @@
+// markets-conformance runs the market protocol conformance checks against a remote peer
+//
+// Usage:
+//
+//	markets-conformance -peer /ip4/1.2.3.4/tcp/1234/p2p/12D3... -miner f01000 -payload bafy...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/conformance"
+)
+
+func main() {
+	peerAddr := flag.String("peer", "", "multiaddr of the peer to check, including its /p2p/ peer ID")
+	miner := flag.String("miner", "", "address of the peer's miner actor")
+	payload := flag.String("payload", conformance.PayloadCID.String(), "payload CID to query for")
+	timeout := flag.Duration("timeout", conformance.DefaultCheckTimeout, "how long each check waits for the peer")
+	flag.Parse()
+
+	if err := run(*peerAddr, *miner, *payload, *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(peerAddr, miner, payload string, timeout time.Duration) error {
+	maddr, err := ma.NewMultiaddr(peerAddr)
+	if err != nil {
+		return xerrors.Errorf("parsing peer address: %w", err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return xerrors.Errorf("parsing peer address: %w", err)
+	}
+	minerAddr, err := address.NewFromString(miner)
+	if err != nil {
+		return xerrors.Errorf("parsing miner address: %w", err)
+	}
+	payloadCID, err := cid.Decode(payload)
+	if err != nil {
+		return xerrors.Errorf("parsing payload CID: %w", err)
+	}
+
+	ctx := context.Background()
+	h, err := libp2p.New(ctx)
+	if err != nil {
+		return xerrors.Errorf("creating libp2p host: %w", err)
+	}
+	defer h.Close() // nolint: errcheck
+
+	if err := h.Connect(ctx, *info); err != nil {
+		return xerrors.Errorf("connecting to peer: %w", err)
+	}
+
+	harness := conformance.NewHarness(h, info.ID, conformance.Params{
+		Miner:      minerAddr,
+		PayloadCID: payloadCID,
+		Timeout:    timeout,
+	})
+	failed := 0
+	for _, result := range harness.Run(ctx) {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL %s (%s): %s\n", result.Name, result.Protocol, result.Err)
+			continue
+		}
+		fmt.Printf("ok   %s (%s)\n", result.Name, result.Protocol)
+	}
+	if failed > 0 {
+		return xerrors.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Package conformance holds golden CBOR encodings of the market network messages, and a
+harness that checks a remote peer's implementation of the market protocols.
+
+The golden vectors are in testdata/vectors.json. Each vector names a message, the
+protocol (or, for retrieval deal vouchers, the voucher type) it is sent on, and its
+hex encoded CBOR. Messages on current protocol versions use map encoding, and
+messages on legacy protocol versions use tuple encoding. The values the vectors
+encode are built by Fixtures, so implementations in other languages can decode each
+vector, compare it to the same values, and check they encode it back to the same
+bytes.
+
+The Harness opens streams to a remote peer over libp2p and checks that it answers
+ask and query requests with well formed messages, on both current and legacy
+protocol versions.
+*/
+package conformance
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"golang.org/x/xerrors"
+)
+
+// Vector is a golden encoding of a network message
+type Vector struct {
+	// Name identifies the vector, and the fixture it encodes
+	Name string `json:"name"`
+	// Protocol is the protocol the message is sent on, or for data transfer vouchers,
+	// the voucher type
+	Protocol protocol.ID `json:"protocol"`
+	// Message is the name of the Go type the message decodes to
+	Message string `json:"message"`
+	// CBOR is the hex encoded CBOR of the message
+	CBOR string `json:"cbor"`
+}
+
+// Bytes returns the decoded CBOR of the vector
+func (v Vector) Bytes() ([]byte, error) {
+	return hex.DecodeString(v.CBOR)
+}
+
+// ReadVectors reads a JSON list of vectors, like testdata/vectors.json
+func ReadVectors(r io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, xerrors.Errorf("decoding vectors: %w", err)
+	}
+	return vectors, nil
+}
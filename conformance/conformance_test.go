@@ -0,0 +1,132 @@
+package conformance_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/conformance"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+func readVectors(t *testing.T) []conformance.Vector {
+	f, err := os.Open("testdata/vectors.json")
+	require.NoError(t, err)
+	defer f.Close() // nolint: errcheck
+	vectors, err := conformance.ReadVectors(f)
+	require.NoError(t, err)
+	return vectors
+}
+
+func TestVectors(t *testing.T) {
+	vectors := readVectors(t)
+	fixtures := conformance.Fixtures()
+	require.Len(t, vectors, len(fixtures))
+
+	for _, vector := range vectors {
+		vector := vector
+		t.Run(vector.Name, func(t *testing.T) {
+			fixture, ok := fixtures[vector.Name]
+			require.True(t, ok, "no fixture for vector")
+			golden, err := vector.Bytes()
+			require.NoError(t, err)
+
+			// the fixture encodes to the golden bytes
+			var buf bytes.Buffer
+			require.NoError(t, fixture.Value.MarshalCBOR(&buf))
+			require.Equal(t, golden, buf.Bytes())
+
+			// the golden bytes decode to a message that encodes back to them
+			decoded := fixture.New()
+			require.NoError(t, decoded.UnmarshalCBOR(bytes.NewReader(golden)))
+			buf.Reset()
+			require.NoError(t, decoded.MarshalCBOR(&buf))
+			require.Equal(t, golden, buf.Bytes())
+		})
+	}
+}
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+	client, err := mn.GenPeer()
+	require.NoError(t, err)
+	provider, err := mn.GenPeer()
+	require.NoError(t, err)
+	require.NoError(t, mn.LinkAll())
+
+	fixtures := conformance.Fixtures()
+	respond := func(request conformance.Message, response string) network.StreamHandler {
+		return func(s network.Stream) {
+			defer s.Close() // nolint: errcheck
+			if err := request.UnmarshalCBOR(bufio.NewReader(s)); err != nil {
+				return
+			}
+			_ = cborutil.WriteCborRPC(s, fixtures[response].Value)
+		}
+	}
+	// the provider only speaks the current protocol versions
+	provider.SetStreamHandler(storagemarket.AskProtocolID, respond(new(smnet.AskRequest), "storage-ask-response"))
+	provider.SetStreamHandler(retrievalmarket.QueryProtocolID, respond(new(retrievalmarket.Query), "retrieval-query-response"))
+
+	t.Run("checks pass against a conforming peer", func(t *testing.T) {
+		h := conformance.NewHarness(client, provider.ID(), conformance.Params{
+			Miner:      conformance.ProviderAddress,
+			PayloadCID: conformance.PayloadCID,
+		})
+		results := h.Run(ctx)
+		require.Len(t, results, len(h.Checks()))
+		for _, result := range results {
+			switch result.Protocol {
+			case storagemarket.AskProtocolID, retrievalmarket.QueryProtocolID:
+				require.NoError(t, result.Err, result.Name)
+			default:
+				require.Error(t, result.Err, result.Name)
+			}
+		}
+	})
+
+	t.Run("checks time out against a silent peer", func(t *testing.T) {
+		silent, err := mn.GenPeer()
+		require.NoError(t, err)
+		require.NoError(t, mn.LinkAll())
+		hang := make(chan struct{})
+		defer close(hang)
+		silent.SetStreamHandler(storagemarket.AskProtocolID, func(s network.Stream) {
+			<-hang
+			_ = s.Reset()
+		})
+
+		h := conformance.NewHarness(client, silent.ID(), conformance.Params{
+			Miner:      conformance.ProviderAddress,
+			PayloadCID: conformance.PayloadCID,
+			Timeout:    100 * time.Millisecond,
+		})
+		for _, result := range h.Run(ctx) {
+			require.Error(t, result.Err, result.Name)
+		}
+	})
+
+	t.Run("ask for another miner fails", func(t *testing.T) {
+		h := conformance.NewHarness(client, provider.ID(), conformance.Params{
+			Miner:      conformance.ClientAddress,
+			PayloadCID: conformance.PayloadCID,
+		})
+		for _, result := range h.Run(ctx) {
+			if result.Protocol == storagemarket.AskProtocolID {
+				require.Error(t, result.Err)
+			}
+		}
+	})
+}
@@ -0,0 +1,372 @@
+package conformance
+
+import (
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	rmmigrations "github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	smmigrations "github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+// Message is a network message that can be encoded and decoded as CBOR
+type Message interface {
+	cbg.CBORMarshaler
+	cbg.CBORUnmarshaler
+}
+
+// Fixture is the value a vector encodes
+type Fixture struct {
+	// Value is the message the vector encodes
+	Value Message
+	// New returns an empty message of the same type, to decode the vector into
+	New func() Message
+}
+
+// The values the fixtures are built from. The CIDs are made from hashes of fixed
+// strings, and the addresses are ID addresses, so other implementations can build
+// the same values
+var (
+	// PayloadCID is a dag-cbor CID with the sha2-256 of "go-fil-markets conformance payload"
+	PayloadCID = mustParseCid("bafyreihwybgzem7tcgcpnkfupoevx3uzemxopi4ppanmbp24xnbgh4d3qy")
+	// PieceCID is an unsealed commitment CID with the sha2-256 of "go-fil-markets conformance piece",
+	// truncated to 254 bits
+	PieceCID = mustParseCid("baga6ea4seaqevj4mi5vh7hfs4fhin5msuib7fl36qqma3i2axzchapshfndzyjy")
+	// ProposalCID is a dag-cbor CID with the sha2-256 of "go-fil-markets conformance proposal"
+	ProposalCID = mustParseCid("bafyreidz4mgpmivfrmb3zjsvdxtjdr7g25r7i3b3ykaeyy2duei7acoosi")
+	// PublishCID is a dag-cbor CID with the sha2-256 of "go-fil-markets conformance publish"
+	PublishCID = mustParseCid("bafyreifofvp4vs7rmkfxo3k6nij3ig6hewrqrb4yumzncwhjs37sfmzkcu")
+
+	// ProviderAddress is the address of the provider and its miner actor
+	ProviderAddress = mustIDAddress(1000)
+	// ClientAddress is the address of the client
+	ClientAddress = mustIDAddress(1001)
+	// PaymentChannelAddress is the address of the retrieval payment channel
+	PaymentChannelAddress = mustIDAddress(1002)
+
+	// Signature is the signature on every signed message. It is not a valid
+	// signature of the message, as the vectors only check encoding
+	Signature = crypto.Signature{
+		Type: crypto.SigTypeSecp256k1,
+		Data: []byte("go-fil-markets conformance signature"),
+	}
+)
+
+const (
+	retrievalDealID      = retrievalmarket.DealID(7)
+	retrievalInterval    = 1 << 20
+	retrievalPaymentOwed = 2 << 20
+)
+
+func mustParseCid(s string) cid.Cid {
+	c, err := cid.Decode(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustIDAddress(id uint64) address.Address {
+	a, err := address.NewIDAddress(id)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func signature() *crypto.Signature {
+	sig := Signature
+	return &sig
+}
+
+func storageAsk() *storagemarket.StorageAsk {
+	return &storagemarket.StorageAsk{
+		Price:         abi.NewTokenAmount(500000000),
+		VerifiedPrice: abi.NewTokenAmount(50000000),
+		MinPieceSize:  256,
+		MaxPieceSize:  32 << 30,
+		Miner:         ProviderAddress,
+		Timestamp:     100,
+		Expiry:        200,
+		SeqNo:         1,
+
+		MinDealDuration: 180 * builtin.EpochsInDay,
+		MaxDealDuration: 540 * builtin.EpochsInDay,
+	}
+}
+
+func clientDealProposal() *market.ClientDealProposal {
+	return &market.ClientDealProposal{
+		Proposal: market.DealProposal{
+			PieceCID:             PieceCID,
+			PieceSize:            2048,
+			VerifiedDeal:         false,
+			Client:               ClientAddress,
+			Provider:             ProviderAddress,
+			Label:                "conformance",
+			StartEpoch:           1000,
+			EndEpoch:             600000,
+			StoragePricePerEpoch: abi.NewTokenAmount(1000),
+			ProviderCollateral:   big.Zero(),
+			ClientCollateral:     big.Zero(),
+		},
+		ClientSignature: Signature,
+	}
+}
+
+func storageResponse() smnet.Response {
+	publishCID := PublishCID
+	return smnet.Response{
+		State:          storagemarket.StorageDealProposalAccepted,
+		Proposal:       ProposalCID,
+		PublishMessage: &publishCID,
+	}
+}
+
+func queryResponse() retrievalmarket.QueryResponse {
+	return retrievalmarket.QueryResponse{
+		Status:                     retrievalmarket.QueryResponseAvailable,
+		PieceCIDFound:              retrievalmarket.QueryItemAvailable,
+		Size:                       1 << 20,
+		PaymentAddress:             ProviderAddress,
+		MinPricePerByte:            abi.NewTokenAmount(2),
+		MaxPaymentInterval:         retrievalInterval,
+		MaxPaymentIntervalIncrease: retrievalInterval,
+		UnsealPrice:                big.Zero(),
+		PriorityPricePerByte:       big.Zero(),
+	}
+}
+
+// Fixtures returns the messages the golden vectors encode, by vector name
+func Fixtures() map[string]Fixture {
+	pieceCID := PieceCID
+	ask := storageAsk()
+	resp := storageResponse()
+	qr := queryResponse()
+
+	return map[string]Fixture{
+		"storage-ask-request": {
+			Value: &smnet.AskRequest{Miner: ProviderAddress},
+			New:   func() Message { return new(smnet.AskRequest) },
+		},
+		"storage-ask-response": {
+			Value: &smnet.AskResponse{Ask: &storagemarket.SignedStorageAsk{Ask: ask, Signature: signature()}},
+			New:   func() Message { return new(smnet.AskResponse) },
+		},
+		"storage-deal-proposal": {
+			Value: &smnet.Proposal{
+				DealProposal: clientDealProposal(),
+				Piece: &storagemarket.DataRef{
+					TransferType: storagemarket.TTGraphsync,
+					Root:         PayloadCID,
+					PieceCid:     &pieceCID,
+					PieceSize:    2032,
+				},
+				FastRetrieval: true,
+			},
+			New: func() Message { return new(smnet.Proposal) },
+		},
+		"storage-deal-response": {
+			Value: &smnet.SignedResponse{Response: resp, Signature: signature()},
+			New:   func() Message { return new(smnet.SignedResponse) },
+		},
+		"storage-deal-status-request": {
+			Value: &smnet.DealStatusRequest{Proposal: ProposalCID, Signature: Signature},
+			New:   func() Message { return new(smnet.DealStatusRequest) },
+		},
+		"storage-deal-status-request-v1.1.0": {
+			Value: &smnet.DealStatusRequest{Proposal: ProposalCID, Signature: Signature},
+			New:   func() Message { return new(smnet.DealStatusRequest) },
+		},
+		"storage-deal-proposal-v1.1.0": {
+			Value: &smmigrations.Proposal1{
+				DealProposal: clientDealProposal(),
+				Piece: &smmigrations.DataRef1{
+					TransferType: storagemarket.TTGraphsync,
+					Root:         PayloadCID,
+					PieceCid:     &pieceCID,
+					PieceSize:    2032,
+				},
+				FastRetrieval: true,
+			},
+			New: func() Message { return new(smmigrations.Proposal1) },
+		},
+		"storage-deal-response-v1.1.0": {
+			Value: &smmigrations.SignedResponse1{
+				Response: smmigrations.Response1{
+					State:          resp.State,
+					Message:        resp.Message,
+					Proposal:       resp.Proposal,
+					PublishMessage: resp.PublishMessage,
+				},
+				Signature: signature(),
+			},
+			New: func() Message { return new(smmigrations.SignedResponse1) },
+		},
+		"storage-ask-request-v1.1.0": {
+			Value: &smnet.AskRequest{Miner: ProviderAddress},
+			New:   func() Message { return new(smnet.AskRequest) },
+		},
+		"storage-ask-response-v1.1.0": {
+			Value: &smmigrations.AskResponse1{Ask: &smmigrations.SignedStorageAsk1{
+				Ask:       smmigrations.StorageAsk2To1(ask),
+				Signature: signature(),
+			}},
+			New: func() Message { return new(smmigrations.AskResponse1) },
+		},
+		"storage-ask-request-v1.0.1": {
+			Value: &smmigrations.AskRequest0{Miner: ProviderAddress},
+			New:   func() Message { return new(smmigrations.AskRequest0) },
+		},
+		"storage-ask-response-v1.0.1": {
+			Value: &smmigrations.AskResponse0{Ask: &smmigrations.SignedStorageAsk0{
+				Ask: &smmigrations.StorageAsk0{
+					Price:         ask.Price,
+					VerifiedPrice: ask.VerifiedPrice,
+					MinPieceSize:  ask.MinPieceSize,
+					MaxPieceSize:  ask.MaxPieceSize,
+					Miner:         ask.Miner,
+					Timestamp:     ask.Timestamp,
+					Expiry:        ask.Expiry,
+					SeqNo:         ask.SeqNo,
+				},
+				Signature: signature(),
+			}},
+			New: func() Message { return new(smmigrations.AskResponse0) },
+		},
+		"storage-deal-proposal-v1.0.1": {
+			Value: &smmigrations.Proposal0{
+				DealProposal: clientDealProposal(),
+				Piece: &smmigrations.DataRef0{
+					TransferType: storagemarket.TTGraphsync,
+					Root:         PayloadCID,
+					PieceCid:     &pieceCID,
+					PieceSize:    2032,
+				},
+				FastRetrieval: true,
+			},
+			New: func() Message { return new(smmigrations.Proposal0) },
+		},
+		"storage-deal-response-v1.0.1": {
+			Value: &smmigrations.SignedResponse0{
+				Response: smmigrations.Response0{
+					State:          resp.State,
+					Message:        resp.Message,
+					Proposal:       resp.Proposal,
+					PublishMessage: resp.PublishMessage,
+				},
+				Signature: signature(),
+			},
+			New: func() Message { return new(smmigrations.SignedResponse0) },
+		},
+		"storage-deal-status-request-v1.0.1": {
+			Value: &smmigrations.DealStatusRequest0{Proposal: ProposalCID, Signature: Signature},
+			New:   func() Message { return new(smmigrations.DealStatusRequest0) },
+		},
+		"retrieval-query": {
+			Value: &retrievalmarket.Query{
+				PayloadCID:  PayloadCID,
+				QueryParams: retrievalmarket.QueryParams{PieceCID: &pieceCID},
+			},
+			New: func() Message { return new(retrievalmarket.Query) },
+		},
+		"retrieval-query-response": {
+			Value: &qr,
+			New:   func() Message { return new(retrievalmarket.QueryResponse) },
+		},
+		"retrieval-query-v1.0.0": {
+			Value: &retrievalmarket.Query{
+				PayloadCID:  PayloadCID,
+				QueryParams: retrievalmarket.QueryParams{PieceCID: &pieceCID},
+			},
+			New: func() Message { return new(retrievalmarket.Query) },
+		},
+		"retrieval-query-response-v1.0.0": {
+			Value: func() *rmmigrations.QueryResponse1 {
+				qr1 := rmmigrations.QueryResponse2To1(qr)
+				return &qr1
+			}(),
+			New: func() Message { return new(rmmigrations.QueryResponse1) },
+		},
+		"retrieval-query-v0.0.1": {
+			Value: &rmmigrations.Query0{
+				PayloadCID:   PayloadCID,
+				QueryParams0: rmmigrations.QueryParams0{PieceCID: &pieceCID},
+			},
+			New: func() Message { return new(rmmigrations.Query0) },
+		},
+		"retrieval-query-response-v0.0.1": {
+			Value: &rmmigrations.QueryResponse0{
+				Status:                     qr.Status,
+				PieceCIDFound:              qr.PieceCIDFound,
+				Size:                       qr.Size,
+				PaymentAddress:             qr.PaymentAddress,
+				MinPricePerByte:            qr.MinPricePerByte,
+				MaxPaymentInterval:         qr.MaxPaymentInterval,
+				MaxPaymentIntervalIncrease: qr.MaxPaymentIntervalIncrease,
+				Message:                    qr.Message,
+				UnsealPrice:                qr.UnsealPrice,
+			},
+			New: func() Message { return new(rmmigrations.QueryResponse0) },
+		},
+		"retrieval-deal-proposal": {
+			Value: &retrievalmarket.DealProposal{
+				PayloadCID: PayloadCID,
+				ID:         retrievalDealID,
+				Params: retrievalmarket.Params{
+					PieceCID:                &pieceCID,
+					PricePerByte:            abi.NewTokenAmount(2),
+					PaymentInterval:         retrievalInterval,
+					PaymentIntervalIncrease: retrievalInterval,
+					UnsealPrice:             big.Zero(),
+				},
+			},
+			New: func() Message { return new(retrievalmarket.DealProposal) },
+		},
+		"retrieval-deal-proposal-v1": {
+			Value: &rmmigrations.DealProposal1{
+				PayloadCID: PayloadCID,
+				ID:         retrievalDealID,
+				Params: rmmigrations.Params1{
+					PieceCID:                &pieceCID,
+					PricePerByte:            abi.NewTokenAmount(2),
+					PaymentInterval:         retrievalInterval,
+					PaymentIntervalIncrease: retrievalInterval,
+					UnsealPrice:             big.Zero(),
+				},
+			},
+			New: func() Message { return new(rmmigrations.DealProposal1) },
+		},
+		"retrieval-deal-response": {
+			Value: &retrievalmarket.DealResponse{
+				Status:      retrievalmarket.DealStatusFundsNeeded,
+				ID:          retrievalDealID,
+				PaymentOwed: abi.NewTokenAmount(retrievalPaymentOwed),
+			},
+			New: func() Message { return new(retrievalmarket.DealResponse) },
+		},
+		"retrieval-deal-payment": {
+			Value: &retrievalmarket.DealPayment{
+				ID:             retrievalDealID,
+				PaymentChannel: PaymentChannelAddress,
+				PaymentVoucher: &paych.SignedVoucher{
+					ChannelAddr: PaymentChannelAddress,
+					Nonce:       1,
+					Amount:      abi.NewTokenAmount(retrievalPaymentOwed),
+					Signature:   signature(),
+				},
+			},
+			New: func() Message { return new(retrievalmarket.DealPayment) },
+		},
+	}
+}
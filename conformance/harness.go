@@ -0,0 +1,232 @@
+package conformance
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	rmmigrations "github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	smmigrations "github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+	smnet "github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+// DefaultCheckTimeout is how long a check waits for the remote peer by default
+const DefaultCheckTimeout = 30 * time.Second
+
+// Params are what the harness asks the remote peer about
+type Params struct {
+	// Miner is the miner actor whose ask is requested
+	Miner address.Address
+	// PayloadCID is the payload retrieval queries are for. The peer need not have it,
+	// but if it does not, the check only covers unavailable responses
+	PayloadCID cid.Cid
+	// Timeout is how long each check waits for the remote peer. Zero means
+	// DefaultCheckTimeout
+	Timeout time.Duration
+}
+
+// Check is one request and response exchange with the remote peer on a protocol
+type Check struct {
+	Name     string
+	Protocol protocol.ID
+	// Exchange writes the request to the stream and checks the response
+	Exchange func(w network.Stream, r *bufio.Reader) error
+}
+
+// Result is the outcome of a check. Err is nil if the check passed
+type Result struct {
+	Name     string
+	Protocol protocol.ID
+	Err      error
+}
+
+// Harness runs conformance checks against a remote peer's implementation of the
+// market protocols
+type Harness struct {
+	host   host.Host
+	peer   peer.ID
+	params Params
+}
+
+// NewHarness returns a harness that checks the peer p, opening streams from h
+func NewHarness(h host.Host, p peer.ID, params Params) *Harness {
+	if params.Timeout == 0 {
+		params.Timeout = DefaultCheckTimeout
+	}
+	return &Harness{host: h, peer: p, params: params}
+}
+
+// Checks returns the checks the harness runs, in order
+func (h *Harness) Checks() []Check {
+	return []Check{
+		{
+			Name:     "storage-ask",
+			Protocol: storagemarket.AskProtocolID,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &smnet.AskRequest{Miner: h.params.Miner}); err != nil {
+					return xerrors.Errorf("writing ask request: %w", err)
+				}
+				var resp smnet.AskResponse
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading ask response: %w", err)
+				}
+				if resp.Ask == nil || resp.Ask.Ask == nil {
+					return xerrors.New("ask response has no ask")
+				}
+				return h.checkAskMiner(resp.Ask.Ask.Miner, resp.Ask.Signature != nil)
+			},
+		},
+		{
+			Name:     "storage-ask-v1.1.0",
+			Protocol: storagemarket.AskProtocolID110,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &smnet.AskRequest{Miner: h.params.Miner}); err != nil {
+					return xerrors.Errorf("writing ask request: %w", err)
+				}
+				var resp smmigrations.AskResponse1
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading ask response: %w", err)
+				}
+				if resp.Ask == nil || resp.Ask.Ask == nil {
+					return xerrors.New("ask response has no ask")
+				}
+				return h.checkAskMiner(resp.Ask.Ask.Miner, resp.Ask.Signature != nil)
+			},
+		},
+		{
+			Name:     "storage-ask-v1.0.1",
+			Protocol: storagemarket.OldAskProtocolID,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &smmigrations.AskRequest0{Miner: h.params.Miner}); err != nil {
+					return xerrors.Errorf("writing ask request: %w", err)
+				}
+				var resp smmigrations.AskResponse0
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading ask response: %w", err)
+				}
+				if resp.Ask == nil || resp.Ask.Ask == nil {
+					return xerrors.New("ask response has no ask")
+				}
+				return h.checkAskMiner(resp.Ask.Ask.Miner, resp.Ask.Signature != nil)
+			},
+		},
+		{
+			Name:     "retrieval-query",
+			Protocol: retrievalmarket.QueryProtocolID,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &retrievalmarket.Query{PayloadCID: h.params.PayloadCID}); err != nil {
+					return xerrors.Errorf("writing query: %w", err)
+				}
+				var resp retrievalmarket.QueryResponse
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading query response: %w", err)
+				}
+				return checkQueryResponseStatus(resp.Status)
+			},
+		},
+		{
+			Name:     "retrieval-query-v1.0.0",
+			Protocol: retrievalmarket.QueryProtocolID100,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &retrievalmarket.Query{PayloadCID: h.params.PayloadCID}); err != nil {
+					return xerrors.Errorf("writing query: %w", err)
+				}
+				var resp rmmigrations.QueryResponse1
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading query response: %w", err)
+				}
+				return checkQueryResponseStatus(resp.Status)
+			},
+		},
+		{
+			Name:     "retrieval-query-v0.0.1",
+			Protocol: retrievalmarket.OldQueryProtocolID,
+			Exchange: func(w network.Stream, r *bufio.Reader) error {
+				if err := cborutil.WriteCborRPC(w, &rmmigrations.Query0{PayloadCID: h.params.PayloadCID}); err != nil {
+					return xerrors.Errorf("writing query: %w", err)
+				}
+				var resp rmmigrations.QueryResponse0
+				if err := resp.UnmarshalCBOR(r); err != nil {
+					return xerrors.Errorf("reading query response: %w", err)
+				}
+				return checkQueryResponseStatus(resp.Status)
+			},
+		},
+	}
+}
+
+// Run runs every check against the remote peer and returns their results. A failed
+// check does not stop the others
+func (h *Harness) Run(ctx context.Context) []Result {
+	checks := h.Checks()
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, Result{
+			Name:     check.Name,
+			Protocol: check.Protocol,
+			Err:      h.run(ctx, check),
+		})
+	}
+	return results
+}
+
+func (h *Harness) run(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, h.params.Timeout)
+	defer cancel()
+
+	s, err := h.host.NewStream(ctx, h.peer, check.Protocol)
+	if err != nil {
+		return xerrors.Errorf("opening stream: %w", err)
+	}
+	defer s.Close() // nolint: errcheck
+
+	// not every transport supports stream deadlines, so the stream is also reset if the
+	// check times out
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Reset()
+		case <-done:
+		}
+	}()
+	return check.Exchange(s, bufio.NewReaderSize(s, 16))
+}
+
+func (h *Harness) checkAskMiner(miner address.Address, signed bool) error {
+	if miner != h.params.Miner {
+		return xerrors.Errorf("ask is for miner %s, requested %s", miner, h.params.Miner)
+	}
+	if !signed {
+		return xerrors.New("ask is not signed")
+	}
+	return nil
+}
+
+func checkQueryResponseStatus(status retrievalmarket.QueryResponseStatus) error {
+	switch status {
+	case retrievalmarket.QueryResponseAvailable,
+		retrievalmarket.QueryResponseUnavailable,
+		retrievalmarket.QueryResponseError,
+		retrievalmarket.QueryResponseBusy:
+		return nil
+	default:
+		return xerrors.Errorf("unknown query response status %d", status)
+	}
+}
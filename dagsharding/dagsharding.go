@@ -0,0 +1,144 @@
+/*
+Package dagsharding splits a DAG too large for one sector into shards that can each
+be stored in a deal of their own, and reassembles the DAG from the shards after
+they are retrieved.
+
+Each shard holds whole subtrees of the DAG, linked from a new root node, so a shard
+can be stored and retrieved like any other payload. The blocks of the DAG that sit
+above the subtrees, whose children did not fit in one shard, are kept in a manifest
+along with the roots of the shards. The manifest is small, and is all that is needed
+to find the shards of the DAG and put it back together.
+*/
+package dagsharding
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"golang.org/x/xerrors"
+)
+
+// ErrBlockTooLarge is returned when splitting a DAG with a block that is larger than
+// a shard may be
+var ErrBlockTooLarge = errors.New("block is larger than the maximum shard size")
+
+// Split splits the DAG under root into shards holding at most maxShardSize bytes of
+// blocks each. The node linking the subtrees in each shard is added to dag, so the
+// shard can be proposed in a storage deal with the shard root as its payload root
+func Split(ctx context.Context, dag ipldformat.DAGService, root cid.Cid, maxShardSize uint64) (*Manifest, error) {
+	s := &splitter{
+		ctx:          ctx,
+		dag:          dag,
+		maxShardSize: maxShardSize,
+		sizes:        make(map[cid.Cid]uint64),
+		manifest:     &Manifest{Root: root},
+	}
+	if err := s.split(root); err != nil {
+		return nil, err
+	}
+	if err := s.closeShard(); err != nil {
+		return nil, err
+	}
+	return s.manifest, nil
+}
+
+type splitter struct {
+	ctx          context.Context
+	dag          ipldformat.DAGService
+	maxShardSize uint64
+	sizes        map[cid.Cid]uint64
+	manifest     *Manifest
+
+	// the shard being filled
+	subtrees  []cid.Cid
+	shardSize uint64
+}
+
+// split adds the DAG under c to shards, keeping each subtree that fits in a shard whole
+func (s *splitter) split(c cid.Cid) error {
+	size, err := s.size(c)
+	if err != nil {
+		return err
+	}
+	if size <= s.maxShardSize {
+		return s.addSubtree(c, size)
+	}
+
+	nd, err := s.dag.Get(s.ctx, c)
+	if err != nil {
+		return xerrors.Errorf("loading block %s: %w", c, err)
+	}
+	if len(nd.Links()) == 0 {
+		return xerrors.Errorf("block %s has %d bytes: %w", c, len(nd.RawData()), ErrBlockTooLarge)
+	}
+	s.manifest.Blocks = append(s.manifest.Blocks, Block{Cid: c, Data: nd.RawData()})
+	for _, link := range nd.Links() {
+		if err := s.split(link.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// size returns the total size of the blocks in the DAG under c. Blocks linked more
+// than once are counted each time, as they may end up in more than one shard
+func (s *splitter) size(c cid.Cid) (uint64, error) {
+	if size, ok := s.sizes[c]; ok {
+		return size, nil
+	}
+	nd, err := s.dag.Get(s.ctx, c)
+	if err != nil {
+		return 0, xerrors.Errorf("loading block %s: %w", c, err)
+	}
+	size := uint64(len(nd.RawData()))
+	for _, link := range nd.Links() {
+		childSize, err := s.size(link.Cid)
+		if err != nil {
+			return 0, err
+		}
+		size += childSize
+	}
+	s.sizes[c] = size
+	return size, nil
+}
+
+func (s *splitter) addSubtree(c cid.Cid, size uint64) error {
+	if s.shardSize+size > s.maxShardSize {
+		if err := s.closeShard(); err != nil {
+			return err
+		}
+	}
+	s.subtrees = append(s.subtrees, c)
+	s.shardSize += size
+	return nil
+}
+
+// closeShard adds the root node of the shard being filled to the DAG service and
+// records the shard in the manifest
+func (s *splitter) closeShard() error {
+	if len(s.subtrees) == 0 {
+		return nil
+	}
+
+	nd := new(merkledag.ProtoNode)
+	for _, subtree := range s.subtrees {
+		if err := nd.AddRawLink("", &ipldformat.Link{Cid: subtree, Size: s.sizes[subtree]}); err != nil {
+			return err
+		}
+	}
+	if err := s.dag.Add(s.ctx, nd); err != nil {
+		return xerrors.Errorf("adding shard root: %w", err)
+	}
+
+	s.manifest.Shards = append(s.manifest.Shards, Shard{
+		Root:     nd.Cid(),
+		Subtrees: s.subtrees,
+		Size:     s.shardSize,
+	})
+	s.subtrees = nil
+	s.shardSize = 0
+	return nil
+}
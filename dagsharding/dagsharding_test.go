@@ -0,0 +1,135 @@
+package dagsharding_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestSplitAndReassemble(t *testing.T) {
+	ctx := context.Background()
+	src, srcDAG := newDAG()
+	root, children := buildTree(ctx, t, srcDAG)
+
+	t.Run("keeps a DAG that fits in one shard whole", func(t *testing.T) {
+		manifest, err := dagsharding.Split(ctx, srcDAG, root, 1<<20)
+		require.NoError(t, err)
+		require.Len(t, manifest.Shards, 1)
+		require.Equal(t, []cid.Cid{root}, manifest.Shards[0].Subtrees)
+		require.Empty(t, manifest.Blocks)
+	})
+
+	t.Run("splits a DAG at subtrees that fit in a shard", func(t *testing.T) {
+		manifest, err := dagsharding.Split(ctx, srcDAG, root, 500)
+		require.NoError(t, err)
+		require.Equal(t, root, manifest.Root)
+		require.Len(t, manifest.Shards, len(children))
+		for i, shard := range manifest.Shards {
+			require.Equal(t, []cid.Cid{children[i]}, shard.Subtrees)
+			require.LessOrEqual(t, shard.Size, uint64(500))
+		}
+		require.Len(t, manifest.Blocks, 1)
+		require.Equal(t, root, manifest.Blocks[0].Cid)
+
+		// the manifest survives a round trip through cbor
+		buf := new(bytes.Buffer)
+		require.NoError(t, manifest.MarshalCBOR(buf))
+		var decoded dagsharding.Manifest
+		require.NoError(t, decoded.UnmarshalCBOR(buf))
+		require.Equal(t, *manifest, decoded)
+
+		// fetch each shard into an empty blockstore, as a retrieval would
+		dst, _ := newDAG()
+		var fetched []cid.Cid
+		err = dagsharding.Fetch(ctx, manifest, dst, func(ctx context.Context, shard dagsharding.Shard) error {
+			fetched = append(fetched, shard.Root)
+			return copyDAG(src, dst, shard.Root)
+		})
+		require.NoError(t, err)
+		require.Len(t, fetched, len(manifest.Shards))
+		has, err := dst.Has(root)
+		require.NoError(t, err)
+		require.True(t, has)
+
+		// shards already fetched are not fetched again
+		err = dagsharding.Fetch(ctx, manifest, dst, func(ctx context.Context, shard dagsharding.Shard) error {
+			t.Fatal("shard should not be fetched again")
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("fails to reassemble a DAG with a missing shard", func(t *testing.T) {
+		manifest, err := dagsharding.Split(ctx, srcDAG, root, 500)
+		require.NoError(t, err)
+
+		dst, _ := newDAG()
+		require.NoError(t, copyDAG(src, dst, manifest.Shards[0].Root))
+		err = dagsharding.Reassemble(ctx, manifest, dst)
+		require.True(t, xerrors.Is(err, dagsharding.ErrIncomplete))
+	})
+
+	t.Run("fails to split a DAG with a block larger than a shard", func(t *testing.T) {
+		_, err := dagsharding.Split(ctx, srcDAG, root, 50)
+		require.True(t, xerrors.Is(err, dagsharding.ErrBlockTooLarge))
+	})
+}
+
+func newDAG() (blockstore.Blockstore, ipldformat.DAGService) {
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	return bs, merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// buildTree builds a root with three children, each linking three leaves of 100 bytes
+func buildTree(ctx context.Context, t *testing.T, dag ipldformat.DAGService) (cid.Cid, []cid.Cid) {
+	root := new(merkledag.ProtoNode)
+	var children []cid.Cid
+	for i := 0; i < 3; i++ {
+		child := new(merkledag.ProtoNode)
+		for j := 0; j < 3; j++ {
+			leaf := merkledag.NewRawNode(shared_testutil.RandomBytes(100))
+			require.NoError(t, dag.Add(ctx, leaf))
+			require.NoError(t, child.AddNodeLink("", leaf))
+		}
+		require.NoError(t, dag.Add(ctx, child))
+		require.NoError(t, root.AddNodeLink("", child))
+		children = append(children, child.Cid())
+	}
+	require.NoError(t, dag.Add(ctx, root))
+	return root.Cid(), children
+}
+
+// copyDAG copies the DAG under root from src to dst
+func copyDAG(src blockstore.Blockstore, dst blockstore.Blockstore, root cid.Cid) error {
+	blk, err := src.Get(root)
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(blk); err != nil {
+		return err
+	}
+	nd, err := ipldformat.Decode(blk)
+	if err != nil {
+		return err
+	}
+	for _, link := range nd.Links() {
+		if err := copyDAG(src, dst, link.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package dagsharding
+
+import (
+	"context"
+	"errors"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"golang.org/x/xerrors"
+)
+
+// ErrIncomplete is returned when reassembling a DAG whose blocks are not all present
+var ErrIncomplete = errors.New("DAG is incomplete")
+
+// FetchFunc retrieves the payload of a shard into the blockstore a DAG is being
+// reassembled in, and returns once the retrieval is finished
+type FetchFunc func(ctx context.Context, shard Shard) error
+
+// Fetch retrieves each shard of a split DAG that is not already in bs, in order,
+// then reassembles the DAG in bs. A fetch that fails part way can be resumed by
+// calling Fetch again with the same blockstore
+func Fetch(ctx context.Context, manifest *Manifest, bs blockstore.Blockstore, fetch FetchFunc) error {
+	dag := offlineDAG(bs)
+	for i, shard := range manifest.Shards {
+		missing, err := firstMissing(ctx, dag, shard.Root)
+		if err != nil {
+			return err
+		}
+		if !missing.Defined() {
+			continue
+		}
+		if err := fetch(ctx, shard); err != nil {
+			return xerrors.Errorf("fetching shard %d (%s): %w", i, shard.Root, err)
+		}
+	}
+	return Reassemble(ctx, manifest, bs)
+}
+
+// Reassemble adds the blocks kept in the manifest to bs, which must already hold
+// the blocks of every shard, and checks that bs now holds the whole DAG that was split
+func Reassemble(ctx context.Context, manifest *Manifest, bs blockstore.Blockstore) error {
+	for _, b := range manifest.Blocks {
+		sum, err := b.Cid.Prefix().Sum(b.Data)
+		if err != nil {
+			return err
+		}
+		if !sum.Equals(b.Cid) {
+			return xerrors.Errorf("manifest block data does not match its CID %s", b.Cid)
+		}
+		blk, err := blocks.NewBlockWithCid(b.Data, b.Cid)
+		if err != nil {
+			return err
+		}
+		if err := bs.Put(blk); err != nil {
+			return xerrors.Errorf("storing manifest block %s: %w", b.Cid, err)
+		}
+	}
+
+	missing, err := firstMissing(ctx, offlineDAG(bs), manifest.Root)
+	if err != nil {
+		return err
+	}
+	if missing.Defined() {
+		return xerrors.Errorf("block %s not found: %w", missing, ErrIncomplete)
+	}
+	return nil
+}
+
+func offlineDAG(bs blockstore.Blockstore) ipldformat.DAGService {
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// firstMissing walks the DAG under root and returns the first block that is not in
+// the DAG service, or cid.Undef if the DAG is complete
+func firstMissing(ctx context.Context, dag ipldformat.DAGService, root cid.Cid) (cid.Cid, error) {
+	visited := cid.NewSet()
+	var walk func(c cid.Cid) (cid.Cid, error)
+	walk = func(c cid.Cid) (cid.Cid, error) {
+		if !visited.Visit(c) {
+			return cid.Undef, nil
+		}
+		nd, err := dag.Get(ctx, c)
+		if xerrors.Is(err, ipldformat.ErrNotFound) {
+			return c, nil
+		}
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("loading block %s: %w", c, err)
+		}
+		for _, link := range nd.Links() {
+			missing, err := walk(link.Cid)
+			if err != nil || missing.Defined() {
+				return missing, err
+			}
+		}
+		return cid.Undef, nil
+	}
+	return walk(root)
+}
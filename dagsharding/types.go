@@ -0,0 +1,35 @@
+package dagsharding
+
+import (
+	"github.com/ipfs/go-cid"
+)
+
+//go:generate cbor-gen-for --map-encoding Manifest Shard Block
+
+// Manifest links the shards a DAG was split into, so the DAG can be reassembled
+// once each shard has been stored and retrieved on its own
+type Manifest struct {
+	// Root is the root of the DAG that was split
+	Root cid.Cid
+	// Shards are the shards the DAG was split into
+	Shards []Shard
+	// Blocks are the blocks of the DAG whose children did not fit in one shard.
+	// They are kept in the manifest, so that every shard holds whole subtrees
+	Blocks []Block
+}
+
+// Shard is one part of a split DAG, stored and retrieved as a payload of its own
+type Shard struct {
+	// Root is the root of the shard's payload, a node that links the shard's subtrees
+	Root cid.Cid
+	// Subtrees are the roots of the subtrees of the split DAG the shard holds
+	Subtrees []cid.Cid
+	// Size is the total size of the blocks in the shard's subtrees
+	Size uint64
+}
+
+// Block is a block of a split DAG kept in its manifest
+type Block struct {
+	Cid  cid.Cid
+	Data []byte
+}
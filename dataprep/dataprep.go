@@ -0,0 +1,579 @@
+/*
+Package dataprep prepares a dataset on disk to be stored in storage deals, so that
+clients do not need separate tools to chunk, pack and commit their data.
+
+A file or directory is imported into UnixFS, then split with the dagsharding package
+if it is too large for one sector. Each shard is written to a CAR, and the CommP of
+each CAR, padded to a piece size, is computed, several at a time. Once every piece is
+ready, each is passed in turn to a handler, which usually proposes a deal for it with
+the piece's DataRef.
+
+A Preparer configured with Encrypt encrypts the contents of each file before it is
+imported, with a data key generated for the job and wrapped by a key the client holds,
+so that providers only ever see encrypted data. The names of files and directories,
+and the sizes of files, are not hidden. The job's envelope holds the wrapped data key,
+and is kept with each deal by ProposeDeals. OpenFile reads a file back once it has been
+retrieved, decrypting it with the envelope.
+
+The progress of a job is saved to a datastore after each step. Preparing a job again
+with the same ID skips the steps that already finished, so a job that was interrupted,
+or whose handler failed, resumes where it stopped. ReportProgress passes each step to
+the caller as it finishes, such as to show a progress bar.
+
+A Preparer configured with Staging writes CARs to a filestore.StagingManager instead
+of outDir, named by the hash of their content, so that jobs preparing the same data
+share the staged CARs. Each job holds a reference to its CARs until Release is called
+for it, once its deals no longer need them.
+*/
+package dataprep
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-car"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-commp-utils/ffiwrapper"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
+	"github.com/filecoin-project/go-fil-markets/envelope"
+	"github.com/filecoin-project/go-fil-markets/filestore"
+)
+
+const (
+	// DefaultChunkSize is the size of the blocks files are chunked into
+	DefaultChunkSize = 1 << 20
+
+	// DefaultConcurrency is how many CARs are written and committed at once
+	DefaultConcurrency = 4
+
+	// framingAllowance sets aside one part in this many of a sector for CAR framing
+	framingAllowance = 16
+)
+
+var linksPerLevel = helpers.DefaultLinksPerBlock
+
+var jobsPrefix = datastore.NewKey("jobs")
+
+// CommPFunc computes the CommP of the data read from r, which is size bytes long,
+// after padding it to a piece size. It returns the CommP and the padded size
+type CommPFunc func(rt abi.RegisteredSealProof, r io.Reader, size uint64) (cid.Cid, abi.UnpaddedPieceSize, error)
+
+// HandlerFunc is passed each piece of a prepared dataset, in order
+type HandlerFunc func(ctx context.Context, job Job, piece Piece) error
+
+// Step is a step of preparing a dataset
+type Step int
+
+const (
+	// StepImported is when the dataset is imported into UnixFS
+	StepImported Step = iota
+	// StepSplit is when the dataset is split into pieces
+	StepSplit
+	// StepCommitted is when a piece's CAR is written and its CommP computed
+	StepCommitted
+)
+
+// ProgressFunc is called when a job finishes a step. piece is the index of the piece
+// a StepCommitted is for. Calls are not made at the same time, and steps skipped
+// because an earlier call to Prepare finished them are not reported
+type ProgressFunc func(job Job, step Step, piece int)
+
+// PrepareOption configures a single call to Prepare
+type PrepareOption func(c *prepareConfig)
+
+type prepareConfig struct {
+	progress ProgressFunc
+}
+
+// ReportProgress sets the function called as the job finishes each step
+func ReportProgress(progress ProgressFunc) PrepareOption {
+	return func(c *prepareConfig) {
+		c.progress = progress
+	}
+}
+
+// Preparer prepares datasets for storage deals
+type Preparer struct {
+	ds          datastore.Batching
+	dag         ipldformat.DAGService
+	outDir      string
+	rt          abi.RegisteredSealProof
+	chunkSize   int64
+	concurrency int
+	commP       CommPFunc
+	keys        envelope.KeyWrapper
+	staging     *filestore.StagingManager
+
+	lk sync.Mutex
+}
+
+// Option configures a Preparer
+type Option func(p *Preparer)
+
+// ChunkSize sets the size of the blocks files are chunked into
+func ChunkSize(size int64) Option {
+	return func(p *Preparer) {
+		p.chunkSize = size
+	}
+}
+
+// Concurrency sets how many CARs are written and committed at once
+func Concurrency(n int) Option {
+	return func(p *Preparer) {
+		p.concurrency = n
+	}
+}
+
+// PieceCommitment sets how the CommP of each CAR is computed. By default it is
+// computed with the proofs library
+func PieceCommitment(commP CommPFunc) Option {
+	return func(p *Preparer) {
+		p.commP = commP
+	}
+}
+
+// Encrypt encrypts the contents of the files of each dataset with a new data key,
+// wrapped by keys
+func Encrypt(keys envelope.KeyWrapper) Option {
+	return func(p *Preparer) {
+		p.keys = keys
+	}
+}
+
+// Staging stages the CARs of each dataset with sm, referenced by the job's ID, rather
+// than writing them to the output directory
+func Staging(sm *filestore.StagingManager) Option {
+	return func(p *Preparer) {
+		p.staging = sm
+	}
+}
+
+// New returns a Preparer that imports datasets into dag, writes their CARs to outDir,
+// and saves the progress of its jobs in ds. Pieces are sized to fit in sectors of the
+// given seal proof type. dag must persist its blocks for a job to be resumed
+func New(ds datastore.Batching, dag ipldformat.DAGService, outDir string, rt abi.RegisteredSealProof, options ...Option) *Preparer {
+	p := &Preparer{
+		ds:          ds,
+		dag:         dag,
+		outDir:      outDir,
+		rt:          rt,
+		chunkSize:   DefaultChunkSize,
+		concurrency: DefaultConcurrency,
+		commP:       generatePieceCommitment,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Prepare prepares the file or directory at source as the job with the given ID, and
+// passes each of its pieces to handle. If the job was prepared before, the steps that
+// finished are skipped, and pieces already handled are not passed again
+func (p *Preparer) Prepare(ctx context.Context, id string, source string, handle HandlerFunc, options ...PrepareOption) (*Job, error) {
+	var cfg prepareConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+	report := func(job *Job, step Step, piece int) {
+		if cfg.progress != nil {
+			cfg.progress(*job, step, piece)
+		}
+	}
+
+	job, err := p.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		job = &Job{ID: id, Source: source}
+	}
+	if job.Source != source {
+		return nil, xerrors.Errorf("job %s is preparing %s, not %s", id, job.Source, source)
+	}
+
+	if !job.Root.Defined() {
+		dataKey, err := p.dataKey(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+		root, err := p.importSource(ctx, source, dataKey)
+		if err != nil {
+			return nil, xerrors.Errorf("importing %s: %w", source, err)
+		}
+		job.Root = root
+		if err := p.save(job); err != nil {
+			return nil, err
+		}
+		report(job, StepImported, 0)
+	} else if p.keys != nil && job.Envelope == nil {
+		return nil, xerrors.Errorf("job %s was imported without encryption", id)
+	}
+
+	if job.Pieces == nil {
+		if err := p.split(ctx, job); err != nil {
+			return nil, err
+		}
+		if err := p.save(job); err != nil {
+			return nil, err
+		}
+		report(job, StepSplit, 0)
+	}
+
+	if err := p.commitPieces(ctx, job, func(piece int) { report(job, StepCommitted, piece) }); err != nil {
+		return nil, err
+	}
+
+	for i := range job.Pieces {
+		if job.Pieces[i].Handled {
+			continue
+		}
+		if err := handle(ctx, *job, job.Pieces[i]); err != nil {
+			return job, xerrors.Errorf("handling piece %d (%s): %w", i, job.Pieces[i].Root, err)
+		}
+		job.Pieces[i].Handled = true
+		if err := p.save(job); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+// Job returns the progress of the job with the given ID
+func (p *Preparer) Job(id string) (*Job, error) {
+	job, err := p.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, xerrors.Errorf("job %s: %w", id, datastore.ErrNotFound)
+	}
+	return job, nil
+}
+
+// Release releases the job's references to its staged CARs, so that they are deleted
+// once no other job or deal uses them. It does nothing if the Preparer does not stage
+// CARs
+func (p *Preparer) Release(id string) error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	job, err := p.Job(id)
+	if err != nil {
+		return err
+	}
+	if p.staging == nil {
+		return nil
+	}
+	for i := range job.Pieces {
+		piece := &job.Pieces[i]
+		if piece.Staged == "" {
+			continue
+		}
+		if err := p.staging.Release(id, piece.Staged); err != nil {
+			return xerrors.Errorf("releasing piece %d (%s): %w", i, piece.Root, err)
+		}
+		piece.Staged = ""
+		if err := p.save(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataKey returns the key to encrypt a job's files with, or nil if the Preparer does
+// not encrypt. A job's envelope is created the first time it is imported
+func (p *Preparer) dataKey(ctx context.Context, job *Job) ([]byte, error) {
+	if p.keys == nil {
+		if job.Envelope != nil {
+			return nil, xerrors.Errorf("job %s is encrypted, but no key wrapper is set", job.ID)
+		}
+		return nil, nil
+	}
+	if job.Envelope == nil {
+		env, dataKey, err := envelope.New(ctx, p.keys)
+		if err != nil {
+			return nil, xerrors.Errorf("creating envelope for job %s: %w", job.ID, err)
+		}
+		job.Envelope = env
+		return dataKey, nil
+	}
+	return envelope.Open(ctx, p.keys, job.Envelope)
+}
+
+// importSource adds the file or directory at path to the DAG service as UnixFS. The
+// contents of files are encrypted with dataKey, unless it is nil
+func (p *Preparer) importSource(ctx context.Context, path string, dataKey []byte) (cid.Cid, error) {
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, p.dag)
+	nd, err := p.importPath(ctx, bufferedDS, path, dataKey)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bufferedDS.Commit(); err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+func (p *Preparer) importPath(ctx context.Context, dag ipldformat.DAGService, path string, dataKey []byte) (ipldformat.Node, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return p.importFile(dag, path, dataKey)
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := uio.NewDirectory(dag)
+	for _, entry := range entries {
+		child, err := p.importPath(ctx, dag, filepath.Join(path, entry.Name()), dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, entry.Name(), child); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if err := dag.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (p *Preparer) importFile(dag ipldformat.DAGService, path string, dataKey []byte) (ipldformat.Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	var r io.Reader = f
+	if dataKey != nil {
+		r, err = envelope.NewEncryptingReader(f, dataKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	params := helpers.DagBuilderParams{
+		Maxlinks:  linksPerLevel,
+		RawLeaves: true,
+		Dagserv:   dag,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(r, p.chunkSize))
+	if err != nil {
+		return nil, err
+	}
+	return balanced.Layout(db)
+}
+
+// split divides the dataset into pieces that each fit in a sector. A dataset that
+// fits in one sector is stored whole
+func (p *Preparer) split(ctx context.Context, job *Job) error {
+	sectorSize, err := p.rt.SectorSize()
+	if err != nil {
+		return err
+	}
+	unpadded := uint64(abi.PaddedPieceSize(sectorSize).Unpadded())
+	manifest, err := dagsharding.Split(ctx, p.dag, job.Root, unpadded-unpadded/framingAllowance)
+	if err != nil {
+		return xerrors.Errorf("splitting %s: %w", job.Root, err)
+	}
+
+	if len(manifest.Shards) == 1 && len(manifest.Blocks) == 0 {
+		job.Pieces = []Piece{{Root: job.Root}}
+		return nil
+	}
+	job.Manifest = manifest
+	job.Pieces = make([]Piece, 0, len(manifest.Shards))
+	for _, shard := range manifest.Shards {
+		job.Pieces = append(job.Pieces, Piece{Root: shard.Root})
+	}
+	return nil
+}
+
+// commitPieces writes the CAR of each piece that does not have a CommP yet and
+// computes its CommP, several pieces at a time. committed is called with the index of
+// each piece once it is saved, holding the lock
+func (p *Preparer) commitPieces(ctx context.Context, job *Job, committed func(piece int)) error {
+	pending := make(chan int, len(job.Pieces))
+	for i, piece := range job.Pieces {
+		if !piece.PieceCid.Defined() {
+			pending <- i
+		}
+	}
+	close(pending)
+
+	errs := make(chan error, len(job.Pieces))
+	var wg sync.WaitGroup
+	for w := 0; w < p.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				if ctx.Err() != nil {
+					return
+				}
+				p.lk.Lock()
+				piece := job.Pieces[i]
+				p.lk.Unlock()
+
+				if err := p.commitPiece(ctx, job.ID, &piece); err != nil {
+					errs <- xerrors.Errorf("preparing piece %d (%s): %w", i, piece.Root, err)
+					continue
+				}
+
+				p.lk.Lock()
+				job.Pieces[i] = piece
+				err := p.save(job)
+				if err == nil {
+					committed(i)
+				}
+				p.lk.Unlock()
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// commitPiece writes a piece's CAR, then computes its CommP
+func (p *Preparer) commitPiece(ctx context.Context, jobID string, piece *Piece) error {
+	if p.staging != nil {
+		return p.commitStagedPiece(ctx, jobID, piece)
+	}
+
+	carPath := filepath.Join(p.outDir, piece.Root.String()+".car")
+	tmp, err := ioutil.TempFile(p.outDir, piece.Root.String()+".car.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	if err := car.WriteCar(ctx, p.dag, []cid.Cid{piece.Root}, tmp); err != nil {
+		_ = tmp.Close()
+		return xerrors.Errorf("writing CAR: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), carPath); err != nil {
+		return err
+	}
+
+	f, err := os.Open(carPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return p.computeCommP(f, carPath, uint64(info.Size()), piece)
+}
+
+// commitStagedPiece stages a piece's CAR, referenced by the job, then computes its
+// CommP. If the job staged the CAR before it was interrupted, staging it again reuses
+// the same file and reference
+func (p *Preparer) commitStagedPiece(ctx context.Context, jobID string, piece *Piece) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(car.WriteCar(ctx, p.dag, []cid.Cid{piece.Root}, pw))
+	}()
+	staged, err := p.staging.Stage(jobID, pr)
+	if err != nil {
+		_ = pr.CloseWithError(err)
+		return xerrors.Errorf("staging CAR: %w", err)
+	}
+	piece.Staged = staged
+
+	f, err := p.staging.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	return p.computeCommP(f, string(f.OsPath()), uint64(f.Size()), piece)
+}
+
+// computeCommP computes the CommP of the CAR read from r, and records it in the piece
+func (p *Preparer) computeCommP(r io.Reader, carPath string, size uint64, piece *Piece) error {
+	pieceCid, pieceSize, err := p.commP(p.rt, r, size)
+	if err != nil {
+		return xerrors.Errorf("computing CommP: %w", err)
+	}
+	piece.CARPath = carPath
+	piece.CARSize = size
+	piece.PieceCid = pieceCid
+	piece.PieceSize = pieceSize
+	return nil
+}
+
+func (p *Preparer) load(id string) (*Job, error) {
+	value, err := p.ds.Get(jobsPrefix.ChildString(id))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("loading job %s: %w", id, err)
+	}
+	var job Job
+	if err := json.Unmarshal(value, &job); err != nil {
+		return nil, xerrors.Errorf("decoding job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+func (p *Preparer) save(job *Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := p.ds.Put(jobsPrefix.ChildString(job.ID), value); err != nil {
+		return xerrors.Errorf("saving job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func generatePieceCommitment(rt abi.RegisteredSealProof, r io.Reader, size uint64) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	paddedReader, paddedSize := padreader.New(r, size)
+	commitment, err := ffiwrapper.GeneratePieceCIDFromFile(rt, paddedReader, paddedSize)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return commitment, paddedSize, nil
+}
@@ -0,0 +1,277 @@
+package dataprep_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/dataprep"
+	"github.com/filecoin-project/go-fil-markets/envelope"
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+type fakeCommP struct {
+	lk    sync.Mutex
+	calls int
+}
+
+func (f *fakeCommP) commP(rt abi.RegisteredSealProof, r io.Reader, size uint64) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	f.lk.Lock()
+	f.calls++
+	f.lk.Unlock()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return blocks.NewBlock(data).Cid(), padreader.PaddedSize(size), nil
+}
+
+func TestPrepare(t *testing.T) {
+	ctx := context.Background()
+	source, err := ioutil.TempDir("", "dataprep-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(source) // nolint: errcheck
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(source, name), shared_testutil.RandomBytes(1000), 0644))
+	}
+
+	newPreparer := func(t *testing.T) (*dataprep.Preparer, *fakeCommP, string) {
+		outDir, err := ioutil.TempDir("", "dataprep-out")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(outDir) })
+		bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+		commP := &fakeCommP{}
+		p := dataprep.New(dss.MutexWrap(datastore.NewMapDatastore()), dag, outDir, abi.RegisteredSealProof_StackedDrg2KiBV1,
+			dataprep.ChunkSize(256), dataprep.PieceCommitment(commP.commP))
+		return p, commP, outDir
+	}
+
+	t.Run("splits a dataset into CARs that fit in a sector", func(t *testing.T) {
+		p, commP, outDir := newPreparer(t)
+		var handled []dataprep.Piece
+		job, err := p.Prepare(ctx, "job", source, func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			handled = append(handled, piece)
+			return nil
+		})
+		require.NoError(t, err)
+		require.NotNil(t, job.Manifest)
+		require.Equal(t, job.Root, job.Manifest.Root)
+		require.Len(t, job.Pieces, 3)
+		require.Equal(t, 3, commP.calls)
+		require.Len(t, handled, 3)
+
+		for i, piece := range handled {
+			require.Equal(t, job.Manifest.Shards[i].Root, piece.Root)
+			require.Equal(t, filepath.Join(outDir, piece.Root.String()+".car"), piece.CARPath)
+			require.LessOrEqual(t, piece.CARSize, uint64(abi.PaddedPieceSize(2048).Unpadded()))
+			require.Equal(t, padreader.PaddedSize(piece.CARSize), piece.PieceSize)
+
+			f, err := os.Open(piece.CARPath)
+			require.NoError(t, err)
+			header, err := car.LoadCar(blockstore.NewBlockstore(datastore.NewMapDatastore()), f)
+			require.NoError(t, f.Close())
+			require.NoError(t, err)
+			require.Equal(t, []cid.Cid{piece.Root}, header.Roots)
+
+			ref := piece.DataRef()
+			require.Equal(t, storagemarket.TTManual, ref.TransferType)
+			require.Equal(t, piece.PieceCid, *ref.PieceCid)
+		}
+
+		saved, err := p.Job("job")
+		require.NoError(t, err)
+		require.Equal(t, job, saved)
+	})
+
+	t.Run("reports each step as it finishes", func(t *testing.T) {
+		p, _, _ := newPreparer(t)
+		var steps []dataprep.Step
+		var committed []int
+		progress := dataprep.ReportProgress(func(job dataprep.Job, step dataprep.Step, piece int) {
+			steps = append(steps, step)
+			if step == dataprep.StepCommitted {
+				require.True(t, job.Pieces[piece].PieceCid.Defined())
+				committed = append(committed, piece)
+			}
+		})
+		noop := func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			return nil
+		}
+		_, err := p.Prepare(ctx, "job", source, noop, progress)
+		require.NoError(t, err)
+		require.Equal(t, []dataprep.Step{dataprep.StepImported, dataprep.StepSplit, dataprep.StepCommitted, dataprep.StepCommitted, dataprep.StepCommitted}, steps)
+		require.ElementsMatch(t, []int{0, 1, 2}, committed)
+
+		// steps finished by an earlier call are not reported again
+		steps = nil
+		_, err = p.Prepare(ctx, "job", source, noop, progress)
+		require.NoError(t, err)
+		require.Empty(t, steps)
+	})
+
+	t.Run("stores a dataset that fits in one sector whole", func(t *testing.T) {
+		p, _, _ := newPreparer(t)
+		job, err := p.Prepare(ctx, "job", filepath.Join(source, "a"), func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			return nil
+		})
+		require.NoError(t, err)
+		require.Nil(t, job.Manifest)
+		require.Len(t, job.Pieces, 1)
+		require.Equal(t, job.Root, job.Pieces[0].Root)
+	})
+
+	t.Run("resumes a job where it stopped", func(t *testing.T) {
+		p, commP, _ := newPreparer(t)
+		var handled []cid.Cid
+		failing := func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			if len(handled) == 1 {
+				return errors.New("something went wrong")
+			}
+			handled = append(handled, piece.Root)
+			return nil
+		}
+		_, err := p.Prepare(ctx, "job", source, failing)
+		require.Error(t, err)
+		require.Len(t, handled, 1)
+
+		job, err := p.Prepare(ctx, "job", source, func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			handled = append(handled, piece.Root)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, handled, 3)
+		for i, piece := range job.Pieces {
+			require.Equal(t, piece.Root, handled[i])
+			require.True(t, piece.Handled)
+		}
+		// pieces are only committed once
+		require.Equal(t, 3, commP.calls)
+	})
+
+	t.Run("shares staged CARs between jobs", func(t *testing.T) {
+		_, commP, outDir := newPreparer(t)
+		store, err := filestore.NewLocalFileStore(filestore.OsPath(outDir))
+		require.NoError(t, err)
+		sm := filestore.NewStagingManager(store, dss.MutexWrap(datastore.NewMapDatastore()))
+		bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+		p := dataprep.New(dss.MutexWrap(datastore.NewMapDatastore()), dag, outDir, abi.RegisteredSealProof_StackedDrg2KiBV1,
+			dataprep.ChunkSize(256), dataprep.PieceCommitment(commP.commP), dataprep.Staging(sm))
+		noop := func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			return nil
+		}
+
+		job1, err := p.Prepare(ctx, "job1", source, noop)
+		require.NoError(t, err)
+		job2, err := p.Prepare(ctx, "job2", source, noop)
+		require.NoError(t, err)
+		require.Len(t, job2.Pieces, len(job1.Pieces))
+		for i, piece := range job1.Pieces {
+			require.True(t, filestore.IsStagedPath(piece.Staged))
+			require.Equal(t, piece.Staged, job2.Pieces[i].Staged)
+			require.Equal(t, filepath.Join(outDir, string(piece.Staged)), piece.CARPath)
+			refs, err := sm.References(piece.Staged)
+			require.NoError(t, err)
+			require.Equal(t, 2, refs)
+		}
+
+		require.NoError(t, p.Release("job1"))
+		for _, piece := range job1.Pieces {
+			_, err := os.Stat(piece.CARPath)
+			require.NoError(t, err)
+		}
+		released, err := p.Job("job1")
+		require.NoError(t, err)
+		require.Empty(t, released.Pieces[0].Staged)
+
+		require.NoError(t, p.Release("job2"))
+		for _, piece := range job1.Pieces {
+			_, err := os.Stat(piece.CARPath)
+			require.True(t, os.IsNotExist(err))
+		}
+	})
+}
+
+func TestPrepareEncrypted(t *testing.T) {
+	ctx := context.Background()
+	source, err := ioutil.TempDir("", "dataprep-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(source) // nolint: errcheck
+	data := shared_testutil.RandomBytes(1000)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "a"), data, 0644))
+	outDir, err := ioutil.TempDir("", "dataprep-out")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir) // nolint: errcheck
+
+	keys, err := envelope.NewLocalKeyWrapper("key", shared_testutil.RandomBytes(envelope.KeySize))
+	require.NoError(t, err)
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	commP := &fakeCommP{}
+	p := dataprep.New(ds, dag, outDir, abi.RegisteredSealProof_StackedDrg2KiBV1,
+		dataprep.ChunkSize(256), dataprep.PieceCommitment(commP.commP), dataprep.Encrypt(keys))
+
+	job, err := p.Prepare(ctx, "job", filepath.Join(source, "a"), func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, job.Envelope)
+	require.Equal(t, envelope.AlgorithmAES256GCMSegmented, job.Envelope.Algorithm)
+
+	// the CAR the provider receives does not hold the file's contents
+	carData, err := ioutil.ReadFile(job.Pieces[0].CARPath)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(carData, data[:64]))
+
+	t.Run("opens the file with the envelope", func(t *testing.T) {
+		r, err := dataprep.OpenFile(ctx, dag, job.Root, job.Envelope, keys)
+		require.NoError(t, err)
+		read, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	})
+
+	t.Run("does not open the file with another key", func(t *testing.T) {
+		other, err := envelope.NewLocalKeyWrapper("key", shared_testutil.RandomBytes(envelope.KeySize))
+		require.NoError(t, err)
+		_, err = dataprep.OpenFile(ctx, dag, job.Root, job.Envelope, other)
+		require.Error(t, err)
+	})
+
+	t.Run("does not encrypt a job imported without encryption", func(t *testing.T) {
+		plain := dataprep.New(ds, dag, outDir, abi.RegisteredSealProof_StackedDrg2KiBV1,
+			dataprep.ChunkSize(256), dataprep.PieceCommitment(commP.commP))
+		_, err := plain.Prepare(ctx, "plain", filepath.Join(source, "a"), func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			return nil
+		})
+		require.NoError(t, err)
+		_, err = p.Prepare(ctx, "plain", filepath.Join(source, "a"), func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+			return nil
+		})
+		require.Error(t, err)
+	})
+}
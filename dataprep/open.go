@@ -0,0 +1,36 @@
+package dataprep
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	uio "github.com/ipfs/go-unixfs/io"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/envelope"
+)
+
+// OpenFile returns a reader of the UnixFS file at root, from a prepared dataset that
+// has been retrieved into dag. If the dataset was encrypted, env is its job's envelope,
+// which is opened with keys to decrypt the file. Reads fail with envelope.ErrDecrypt
+// if the retrieved file was tampered with
+func OpenFile(ctx context.Context, dag ipldformat.DAGService, root cid.Cid, env *envelope.Envelope, keys envelope.KeyWrapper) (io.Reader, error) {
+	nd, err := dag.Get(ctx, root)
+	if err != nil {
+		return nil, xerrors.Errorf("getting file %s: %w", root, err)
+	}
+	r, err := uio.NewDagReader(ctx, nd, dag)
+	if err != nil {
+		return nil, xerrors.Errorf("reading file %s: %w", root, err)
+	}
+	if env == nil {
+		return r, nil
+	}
+	dataKey, err := envelope.Open(ctx, keys, env)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.NewDecryptingReader(r, dataKey)
+}
@@ -0,0 +1,22 @@
+package dataprep
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// ProposeDeals returns a handler that proposes a storage deal for each piece of a
+// prepared dataset with the given client, on the terms in params. The data of each
+// deal is the piece's DataRef, so its CAR must be imported on the provider. The
+// envelope of an encrypted dataset is kept with each client deal
+func ProposeDeals(client storagemarket.StorageClient, params storagemarket.ProposeStorageDealParams) HandlerFunc {
+	return func(ctx context.Context, job Job, piece Piece) error {
+		pieceParams := params
+		pieceParams.Data = piece.DataRef()
+		pieceParams.StoreID = nil
+		pieceParams.Envelope = job.Envelope
+		_, err := client.ProposeStorageDeal(ctx, pieceParams)
+		return err
+	}
+}
@@ -0,0 +1,62 @@
+package dataprep
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
+	"github.com/filecoin-project/go-fil-markets/envelope"
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// Job is the progress of preparing a dataset, saved after each step so that
+// preparation can resume where it stopped
+type Job struct {
+	ID string
+	// Source is the path of the file or directory being prepared
+	Source string
+	// Root is the root of the dataset imported into UnixFS. It is undefined until the
+	// import finishes
+	Root cid.Cid
+	// Manifest links the shards the dataset was split into, if it was too large for
+	// one sector. It is needed to retrieve and reassemble the dataset
+	Manifest *dagsharding.Manifest
+	// Pieces are the pieces the dataset is stored in, one for each shard
+	Pieces []Piece
+	// Envelope holds the wrapped key the dataset's files are encrypted with, if the
+	// Preparer encrypts datasets. It is needed to decrypt the files once retrieved
+	Envelope *envelope.Envelope
+}
+
+// Piece is one CAR of a prepared dataset, sized to fit in a sector
+type Piece struct {
+	// Root is the payload root the CAR holds
+	Root cid.Cid
+	// CARPath is where the CAR was written
+	CARPath string
+	// Staged is the path of the CAR in the Preparer's staging manager, if it stages
+	// CARs, until the job releases it
+	Staged filestore.Path
+	// CARSize is the size of the CAR before it is padded
+	CARSize uint64
+	// PieceCid is the CommP of the padded CAR. It is undefined until it is computed
+	PieceCid cid.Cid
+	// PieceSize is the size of the CAR once padded
+	PieceSize abi.UnpaddedPieceSize
+	// Handled is true once the piece has been passed to the handler
+	Handled bool
+}
+
+// DataRef returns a reference to the piece's data, for a deal that imports the CAR
+// on the provider rather than transferring it
+func (p Piece) DataRef() *storagemarket.DataRef {
+	pieceCid := p.PieceCid
+	return &storagemarket.DataRef{
+		TransferType: storagemarket.TTManual,
+		Root:         p.Root,
+		PieceCid:     &pieceCid,
+		PieceSize:    p.PieceSize,
+	}
+}
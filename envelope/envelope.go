@@ -0,0 +1,128 @@
+/*
+Package envelope encrypts deal data on the client before it is stored, so that private
+data can be stored with public providers.
+
+Data is encrypted with a random data key. The data key is wrapped by a KeyWrapper, with
+a key the client holds, such as one in a local keystore or a KMS, and only the wrapped
+key is kept, in an Envelope. Neither key is ever sent to a provider: providers store
+and serve the encrypted data like any other, and the piece commitment of a deal is
+computed over it. To read the data back after retrieving it, the client opens the
+envelope with the same KeyWrapper and decrypts.
+
+Data is encrypted in segments of 64KiB with AES-256-GCM, each with its own nonce and
+with the last segment marked, so that a stream that was reordered, truncated or
+tampered with fails to decrypt.
+*/
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/xerrors"
+)
+
+// AlgorithmAES256GCMSegmented is the cipher data is encrypted with: AES-256-GCM over
+// 64KiB segments
+const AlgorithmAES256GCMSegmented = "aes-256-gcm-64k"
+
+// KeySize is the size of a data key
+const KeySize = 32
+
+// ErrDecrypt is returned when data or a wrapped key fails to decrypt, because it was
+// tampered with or the key is wrong
+var ErrDecrypt = errors.New("failed to decrypt")
+
+// KeyWrapper wraps data keys with a key it holds, and unwraps them again. The key it
+// holds never leaves it
+type KeyWrapper interface {
+	// WrapKey encrypts a data key, and returns the ID of the key it was wrapped with
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped with the key keyID identifies
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// New generates a data key, and returns it along with the envelope that holds it
+// wrapped by wrapper
+func New(ctx context.Context, wrapper KeyWrapper) (*Envelope, []byte, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, xerrors.Errorf("generating data key: %w", err)
+	}
+	keyID, wrapped, err := wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("wrapping data key: %w", err)
+	}
+	return &Envelope{
+		Algorithm:  AlgorithmAES256GCMSegmented,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+	}, dataKey, nil
+}
+
+// Open unwraps the data key held in an envelope with wrapper
+func Open(ctx context.Context, wrapper KeyWrapper, env *Envelope) ([]byte, error) {
+	if env.Algorithm != AlgorithmAES256GCMSegmented {
+		return nil, xerrors.Errorf("unsupported encryption algorithm %q", env.Algorithm)
+	}
+	dataKey, err := wrapper.UnwrapKey(ctx, env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, xerrors.Errorf("unwrapping data key: %w", err)
+	}
+	if len(dataKey) != KeySize {
+		return nil, xerrors.Errorf("data key is %d bytes, expected %d", len(dataKey), KeySize)
+	}
+	return dataKey, nil
+}
+
+type localKeyWrapper struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// NewLocalKeyWrapper returns a KeyWrapper that wraps data keys with AES-256-GCM, using
+// a 32 byte key held in memory. keyID identifies the key in the envelopes it creates
+func NewLocalKeyWrapper(keyID string, key []byte) (KeyWrapper, error) {
+	if len(key) != KeySize {
+		return nil, xerrors.Errorf("wrapping key is %d bytes, expected %d", len(key), KeySize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &localKeyWrapper{keyID, aead}, nil
+}
+
+func (w *localKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return w.keyID, w.aead.Seal(nonce, nonce, dataKey, []byte(w.keyID)), nil
+}
+
+func (w *localKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != w.keyID {
+		return nil, xerrors.Errorf("data key is wrapped with key %q, not %q", keyID, w.keyID)
+	}
+	if len(wrapped) < w.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, sealed := wrapped[:w.aead.NonceSize()], wrapped[w.aead.NonceSize():]
+	dataKey, err := w.aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,103 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/envelope"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	wrapper, err := envelope.NewLocalKeyWrapper("key1", bytes.Repeat([]byte{1}, envelope.KeySize))
+	require.NoError(t, err)
+
+	env, dataKey, err := envelope.New(ctx, wrapper)
+	require.NoError(t, err)
+	require.Equal(t, "key1", env.KeyID)
+	require.NotContains(t, string(env.WrappedKey), string(dataKey))
+
+	t.Run("opens with the same key", func(t *testing.T) {
+		opened, err := envelope.Open(ctx, wrapper, env)
+		require.NoError(t, err)
+		require.Equal(t, dataKey, opened)
+	})
+
+	t.Run("does not open with another key", func(t *testing.T) {
+		other, err := envelope.NewLocalKeyWrapper("key1", bytes.Repeat([]byte{2}, envelope.KeySize))
+		require.NoError(t, err)
+		_, err = envelope.Open(ctx, other, env)
+		require.True(t, xerrors.Is(err, envelope.ErrDecrypt))
+	})
+
+	t.Run("does not open a tampered envelope", func(t *testing.T) {
+		tampered := *env
+		tampered.WrappedKey = append([]byte{}, env.WrappedKey...)
+		tampered.WrappedKey[len(tampered.WrappedKey)-1] ^= 1
+		_, err := envelope.Open(ctx, wrapper, &tampered)
+		require.True(t, xerrors.Is(err, envelope.ErrDecrypt))
+	})
+}
+
+func TestEncryptingReader(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{3}, envelope.KeySize)
+
+	encrypt := func(t *testing.T, data []byte) []byte {
+		r, err := envelope.NewEncryptingReader(bytes.NewReader(data), dataKey)
+		require.NoError(t, err)
+		encrypted, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return encrypted
+	}
+	decrypt := func(encrypted []byte) ([]byte, error) {
+		r, err := envelope.NewDecryptingReader(bytes.NewReader(encrypted), dataKey)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+
+	for _, size := range []int{0, 1, 64 << 10, 64<<10 + 1, 200 << 10} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+		encrypted := encrypt(t, data)
+		// a few bytes of plaintext can turn up in the ciphertext by chance
+		if size >= 16 {
+			require.False(t, bytes.Contains(encrypted, data))
+		}
+		decrypted, err := decrypt(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, data, decrypted)
+	}
+
+	data := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	encrypted := encrypt(t, data)
+
+	t.Run("fails on tampered data", func(t *testing.T) {
+		tampered := append([]byte{}, encrypted...)
+		tampered[len(tampered)/2] ^= 1
+		_, err := decrypt(tampered)
+		require.True(t, xerrors.Is(err, envelope.ErrDecrypt))
+	})
+
+	t.Run("fails on truncated data", func(t *testing.T) {
+		// cut at the end of the second segment, so every segment left is whole
+		segment := 64<<10 + 16
+		_, err := decrypt(encrypted[:8+2*segment])
+		require.True(t, xerrors.Is(err, envelope.ErrDecrypt))
+	})
+
+	t.Run("fails with another key", func(t *testing.T) {
+		r, err := envelope.NewDecryptingReader(bytes.NewReader(encrypted), bytes.Repeat([]byte{4}, envelope.KeySize))
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		require.True(t, xerrors.Is(err, envelope.ErrDecrypt))
+	})
+}
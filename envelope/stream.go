@@ -0,0 +1,152 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// segmentSize is the size of each segment of data that is encrypted
+	segmentSize = 64 << 10
+
+	// streamVersion is the first byte of an encrypted stream
+	streamVersion = 1
+
+	// prefixSize is the size of the random part of each segment's nonce, which is
+	// written after the version byte. The rest of the nonce is the segment's number
+	// and whether it is the last segment
+	prefixSize = 7
+)
+
+// NewEncryptingReader returns a reader of the data read from r, encrypted with dataKey
+func NewEncryptingReader(r io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 1+prefixSize)
+	header[0] = streamVersion
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	return &segmentReader{
+		src:     r,
+		aead:    aead,
+		seal:    true,
+		prefix:  header[1:],
+		in:      make([]byte, segmentSize+1),
+		inSize:  segmentSize,
+		outBuf:  make([]byte, 0, segmentSize+aead.Overhead()),
+		pending: header,
+	}, nil
+}
+
+// NewDecryptingReader returns a reader of the data read from r, which was encrypted
+// with dataKey. Reads fail with ErrDecrypt if the data was tampered with, or ends early
+func NewDecryptingReader(r io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{
+		src:    r,
+		aead:   aead,
+		in:     make([]byte, segmentSize+aead.Overhead()+1),
+		inSize: segmentSize + aead.Overhead(),
+		outBuf: make([]byte, 0, segmentSize),
+	}, nil
+}
+
+// segmentReader encrypts or decrypts the data read from src one segment at a time. It
+// reads one byte past each segment, to tell whether it is the last one
+type segmentReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	seal   bool
+	prefix []byte
+
+	in      []byte
+	inSize  int
+	carried int
+	counter uint32
+
+	outBuf  []byte
+	pending []byte
+	done    bool
+	err     error
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *segmentReader) next() error {
+	if s.prefix == nil {
+		header := make([]byte, 1+prefixSize)
+		if _, err := io.ReadFull(s.src, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrDecrypt
+			}
+			return err
+		}
+		if header[0] != streamVersion {
+			return xerrors.Errorf("unsupported encrypted stream version %d", header[0])
+		}
+		s.prefix = header[1:]
+	}
+
+	n, err := io.ReadFull(s.src, s.in[s.carried:])
+	n += s.carried
+	last := false
+	switch err {
+	case nil:
+		n = s.inSize
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], s.counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	if s.seal {
+		s.pending = s.aead.Seal(s.outBuf[:0], nonce, s.in[:n], nil)
+	} else {
+		s.pending, err = s.aead.Open(s.outBuf[:0], nonce, s.in[:n], nil)
+		if err != nil {
+			return ErrDecrypt
+		}
+	}
+
+	if last {
+		s.done = true
+		return nil
+	}
+	if s.counter == math.MaxUint32 {
+		return xerrors.New("too many segments to encrypt")
+	}
+	s.counter++
+	s.in[0] = s.in[s.inSize]
+	s.carried = 1
+	return nil
+}
@@ -0,0 +1,16 @@
+package envelope
+
+//go:generate cbor-gen-for --map-encoding Envelope
+
+// Envelope is what a client keeps to decrypt data it encrypted before storing it. The
+// data key the data is encrypted with is only kept wrapped, by a key that never leaves
+// the client
+type Envelope struct {
+	// Algorithm is the cipher the data is encrypted with
+	Algorithm string
+	// KeyID identifies the key the data key is wrapped with, to the KeyWrapper that
+	// wrapped it
+	KeyID string
+	// WrappedKey is the data key, encrypted with the key KeyID identifies
+	WrappedKey []byte
+}
@@ -0,0 +1,188 @@
+package filestore
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const stagedPrefix = "staged-"
+
+// StagingManager stages files in a FileStore under names derived from the
+// sha256 hash of their content, so that deals for the same data share a single
+// staged file. Each staged file is reference counted by the deals using it, and
+// is deleted only when every reference has been released.
+//
+// References are kept in a datastore, so they survive restarts.
+type StagingManager struct {
+	fs     FileStore
+	refs   datastore.Batching
+	delete func(Path) error
+
+	lk sync.Mutex
+}
+
+// StagingOption configures a StagingManager
+type StagingOption func(*StagingManager)
+
+// DeleteStagedWith sets the function a staged file is deleted with once its last
+// reference is released, such as to wait for readers of the file to finish. By
+// default the file is deleted from the FileStore
+func DeleteStagedWith(del func(Path) error) StagingOption {
+	return func(sm *StagingManager) {
+		sm.delete = del
+	}
+}
+
+// NewStagingManager returns a StagingManager for the given FileStore, keeping the
+// references to staged files in refs
+func NewStagingManager(fs FileStore, refs datastore.Batching, options ...StagingOption) *StagingManager {
+	sm := &StagingManager{
+		fs:     fs,
+		refs:   refs,
+		delete: fs.Delete,
+	}
+	for _, option := range options {
+		option(sm)
+	}
+	return sm
+}
+
+// StagedPath returns the path a file with the given content hash is staged at
+func StagedPath(hash []byte) Path {
+	return Path(stagedPrefix + hex.EncodeToString(hash))
+}
+
+// IsStagedPath returns true if the path is one used by a StagingManager
+func IsStagedPath(p Path) bool {
+	return strings.HasPrefix(string(p), stagedPrefix)
+}
+
+// Stage writes the contents of r to the FileStore and adds a reference to the
+// staged file for ref, which is usually a deal identifier. If a file with the same
+// content is already staged, the existing file is reused and the written copy is
+// discarded
+func (sm *StagingManager) Stage(ref string, r io.Reader) (Path, error) {
+	tmp, err := sm.fs.CreateTemp()
+	if err != nil {
+		return Path(""), fmt.Errorf("creating temp file: %w", err)
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(tmp, io.TeeReader(r, hasher))
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = sm.fs.Delete(tmp.Path())
+		return Path(""), fmt.Errorf("writing temp file: %w", err)
+	}
+
+	p := StagedPath(hasher.Sum(nil))
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	if f, err := sm.fs.Open(p); err == nil {
+		// content is already staged, drop the duplicate
+		_ = f.Close()
+		_ = sm.fs.Delete(tmp.Path())
+	} else {
+		dest := filepath.Join(filepath.Dir(string(tmp.OsPath())), string(p))
+		if err := os.Rename(string(tmp.OsPath()), dest); err != nil {
+			_ = sm.fs.Delete(tmp.Path())
+			return Path(""), fmt.Errorf("moving temp file to %s: %w", p, err)
+		}
+	}
+
+	if err := sm.refs.Put(refKey(p, ref), nil); err != nil {
+		return Path(""), fmt.Errorf("recording reference to %s: %w", p, err)
+	}
+	return p, nil
+}
+
+// Acquire adds a reference for ref to a file that is already staged
+func (sm *StagingManager) Acquire(ref string, p Path) error {
+	if !IsStagedPath(p) {
+		return fmt.Errorf("%s is not a staged file", p)
+	}
+
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	f, err := sm.fs.Open(p)
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+
+	return sm.refs.Put(refKey(p, ref), nil)
+}
+
+// Release removes the reference for ref to a staged file, deleting the file
+// if no references remain
+func (sm *StagingManager) Release(ref string, p Path) error {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	key := refKey(p, ref)
+	has, err := sm.refs.Has(key)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("%s does not hold a reference to staged file %s", ref, p)
+	}
+	if err := sm.refs.Delete(key); err != nil {
+		return err
+	}
+	n, err := sm.references(p)
+	if err != nil || n > 0 {
+		return err
+	}
+	return sm.delete(p)
+}
+
+// Open opens a staged file for reading
+func (sm *StagingManager) Open(p Path) (File, error) {
+	if !IsStagedPath(p) {
+		return nil, fmt.Errorf("%s is not a staged file", p)
+	}
+	return sm.fs.Open(p)
+}
+
+// References returns the number of references held to a staged file
+func (sm *StagingManager) References(p Path) (int, error) {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	return sm.references(p)
+}
+
+func (sm *StagingManager) references(p Path) (int, error) {
+	results, err := sm.refs.Query(query.Query{Prefix: datastore.NewKey(string(p)).String(), KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// refKey is the key of ref's reference to the staged file at p. References are
+// encoded so that they may hold any characters
+func refKey(p Path, ref string) datastore.Key {
+	return datastore.NewKey(string(p)).ChildString(base64.RawURLEncoding.EncodeToString([]byte(ref)))
+}
@@ -0,0 +1,98 @@
+package filestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func newStagingStore(t *testing.T) (FileStore, string) {
+	dir, err := ioutil.TempDir("", "staging")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	store, err := NewLocalFileStore(OsPath(dir))
+	require.NoError(t, err)
+	return store, dir
+}
+
+func requireReferences(t *testing.T, sm *StagingManager, p Path, expected int) {
+	n, err := sm.References(p)
+	require.NoError(t, err)
+	require.Equal(t, expected, n)
+}
+
+func Test_StagingDedup(t *testing.T) {
+	store, dir := newStagingStore(t)
+	sm := NewStagingManager(store, dss.MutexWrap(datastore.NewMapDatastore()))
+
+	data := randBytes(64)
+	p1, err := sm.Stage("deal1", bytes.NewReader(data))
+	require.NoError(t, err)
+	p2, err := sm.Stage("deal2", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, p1, p2)
+	require.True(t, IsStagedPath(p1))
+	requireReferences(t, sm, p1, 2)
+
+	other, err := sm.Stage("deal3", bytes.NewReader(randBytes(64)))
+	require.NoError(t, err)
+	require.NotEqual(t, p1, other)
+
+	// only the staged files remain, temp files are cleaned up
+	matches, err := filepath.Glob(filepath.Join(dir, "fstmp*"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	f, err := store.Open(p1)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), f.Size())
+	require.NoError(t, f.Close())
+
+	require.NoError(t, sm.Release("deal1", p1))
+	_, err = os.Stat(filepath.Join(dir, string(p1)))
+	require.NoError(t, err)
+
+	require.Error(t, sm.Release("deal1", p1))
+
+	require.NoError(t, sm.Release("deal2", p1))
+	_, err = os.Stat(filepath.Join(dir, string(p1)))
+	require.True(t, os.IsNotExist(err))
+	requireReferences(t, sm, p1, 0)
+
+	require.NoError(t, sm.Release("deal3", other))
+}
+
+func Test_StagingRestart(t *testing.T) {
+	store, _ := newStagingStore(t)
+	refs := dss.MutexWrap(datastore.NewMapDatastore())
+	sm := NewStagingManager(store, refs)
+
+	data := randBytes(64)
+	p, err := sm.Stage("deal/1", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, sm.Acquire("deal/2", p))
+
+	// a new manager, as after a restart, keeps the references
+	var deleted []Path
+	restarted := NewStagingManager(store, refs, DeleteStagedWith(func(p Path) error {
+		deleted = append(deleted, p)
+		return store.Delete(p)
+	}))
+	requireReferences(t, restarted, p, 2)
+
+	require.Error(t, restarted.Acquire("deal3", Path(existingFile)))
+	require.Error(t, restarted.Acquire("deal3", StagedPath([]byte("missing"))))
+
+	require.NoError(t, restarted.Release("deal/1", p))
+	require.Empty(t, deleted)
+	require.NoError(t, restarted.Release("deal/2", p))
+	require.Equal(t, []Path{p}, deleted)
+	_, err = store.Open(p)
+	require.Error(t, err)
+}
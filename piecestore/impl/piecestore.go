@@ -1,13 +1,17 @@
 package piecestoreimpl
 
 import (
+	"bufio"
 	"context"
+	"io"
+	"sync"
 
 	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
 
 	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
 	versioned "github.com/filecoin-project/go-ds-versioning/pkg/statestore"
@@ -52,6 +56,10 @@ type pieceStore struct {
 	pieces          versioned.StateStore
 	migrateCidInfos func(ctx context.Context) error
 	cidInfos        versioned.StateStore
+
+	// snapshotLk is held for reading while records are changed, and for writing while
+	// a snapshot is taken or restored, so that snapshots are consistent
+	snapshotLk sync.RWMutex
 }
 
 func (ps *pieceStore) Start(ctx context.Context) error {
@@ -82,6 +90,9 @@ func (ps *pieceStore) OnReady(ready shared.ReadyFunc) {
 
 // Store `dealInfo` in the PieceStore with key `pieceCID`.
 func (ps *pieceStore) AddDealForPiece(pieceCID cid.Cid, dealInfo piecestore.DealInfo) error {
+	ps.snapshotLk.RLock()
+	defer ps.snapshotLk.RUnlock()
+
 	return ps.mutatePieceInfo(pieceCID, func(pi *piecestore.PieceInfo) error {
 		for _, di := range pi.Deals {
 			if di == dealInfo {
@@ -95,6 +106,9 @@ func (ps *pieceStore) AddDealForPiece(pieceCID cid.Cid, dealInfo piecestore.Deal
 
 // Store the map of blockLocations in the PieceStore's CIDInfo store, with key `pieceCID`
 func (ps *pieceStore) AddPieceBlockLocations(pieceCID cid.Cid, blockLocations map[cid.Cid]piecestore.BlockLocation) error {
+	ps.snapshotLk.RLock()
+	defer ps.snapshotLk.RUnlock()
+
 	for c, blockLocation := range blockLocations {
 		err := ps.mutateCIDInfo(c, func(ci *piecestore.CIDInfo) error {
 			for _, pbl := range ci.PieceBlockLocations {
@@ -112,6 +126,72 @@ func (ps *pieceStore) AddPieceBlockLocations(pieceCID cid.Cid, blockLocations ma
 	return nil
 }
 
+// Record where a copy of the piece with key `pieceCID` is kept outside of the provider's
+// sectors. An empty location removes the record
+func (ps *pieceStore) SetRemoteLocation(pieceCID cid.Cid, location string) error {
+	ps.snapshotLk.RLock()
+	defer ps.snapshotLk.RUnlock()
+
+	return ps.mutatePieceInfo(pieceCID, func(pi *piecestore.PieceInfo) error {
+		pi.RemoteLocation = location
+		return nil
+	})
+}
+
+// Remove `dealInfo` from the deals recorded for the piece with key `pieceCID`
+func (ps *pieceStore) RemoveDealForPiece(pieceCID cid.Cid, dealInfo piecestore.DealInfo) error {
+	ps.snapshotLk.RLock()
+	defer ps.snapshotLk.RUnlock()
+
+	return ps.pieces.Get(pieceCID).Mutate(func(pi *piecestore.PieceInfo) error {
+		deals := pi.Deals[:0]
+		for _, di := range pi.Deals {
+			if di != dealInfo {
+				deals = append(deals, di)
+			}
+		}
+		pi.Deals = deals
+		return nil
+	})
+}
+
+// Remove the locations of blocks inside the piece with key `pieceCID` from the CID info store
+func (ps *pieceStore) RemovePieceBlockLocations(pieceCID cid.Cid) error {
+	ps.snapshotLk.RLock()
+	defer ps.snapshotLk.RUnlock()
+
+	var cis []piecestore.CIDInfo
+	if err := ps.cidInfos.List(&cis); err != nil {
+		return err
+	}
+	for _, ci := range cis {
+		inPiece := false
+		for _, pbl := range ci.PieceBlockLocations {
+			if pbl.PieceCID.Equals(pieceCID) {
+				inPiece = true
+				break
+			}
+		}
+		if !inPiece {
+			continue
+		}
+		err := ps.cidInfos.Get(ci.CID).Mutate(func(ci *piecestore.CIDInfo) error {
+			locations := ci.PieceBlockLocations[:0]
+			for _, pbl := range ci.PieceBlockLocations {
+				if !pbl.PieceCID.Equals(pieceCID) {
+					locations = append(locations, pbl)
+				}
+			}
+			ci.PieceBlockLocations = locations
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ps *pieceStore) ListPieceInfoKeys() ([]cid.Cid, error) {
 	var pis []piecestore.PieceInfo
 	if err := ps.pieces.List(&pis); err != nil {
@@ -140,6 +220,86 @@ func (ps *pieceStore) ListCidInfoKeys() ([]cid.Cid, error) {
 	return out, nil
 }
 
+// Write every piece and CID record to `w`: a SnapshotHeader, then the PieceInfos,
+// then the CIDInfos. Changes to the store wait until the snapshot is written
+func (ps *pieceStore) Snapshot(w io.Writer) error {
+	ps.snapshotLk.Lock()
+	defer ps.snapshotLk.Unlock()
+
+	var pis []piecestore.PieceInfo
+	if err := ps.pieces.List(&pis); err != nil {
+		return xerrors.Errorf("listing piece infos: %w", err)
+	}
+	var cis []piecestore.CIDInfo
+	if err := ps.cidInfos.List(&cis); err != nil {
+		return xerrors.Errorf("listing cid infos: %w", err)
+	}
+
+	header := piecestore.SnapshotHeader{
+		Version:    piecestore.SnapshotVersion,
+		PieceInfos: uint64(len(pis)),
+		CIDInfos:   uint64(len(cis)),
+	}
+	if err := header.MarshalCBOR(w); err != nil {
+		return xerrors.Errorf("writing snapshot header: %w", err)
+	}
+	for i := range pis {
+		if err := pis[i].MarshalCBOR(w); err != nil {
+			return xerrors.Errorf("writing piece info %s: %w", pis[i].PieceCID, err)
+		}
+	}
+	for i := range cis {
+		if err := cis[i].MarshalCBOR(w); err != nil {
+			return xerrors.Errorf("writing cid info %s: %w", cis[i].CID, err)
+		}
+	}
+	return nil
+}
+
+// Read a snapshot written by Snapshot from `r`, and write its records to the store,
+// replacing any records with the same keys
+func (ps *pieceStore) Restore(r io.Reader) error {
+	ps.snapshotLk.Lock()
+	defer ps.snapshotLk.Unlock()
+
+	r = bufio.NewReader(r)
+	var header piecestore.SnapshotHeader
+	if err := header.UnmarshalCBOR(r); err != nil {
+		return xerrors.Errorf("reading snapshot header: %w", err)
+	}
+	if header.Version != piecestore.SnapshotVersion {
+		return xerrors.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	for i := uint64(0); i < header.PieceInfos; i++ {
+		var pi piecestore.PieceInfo
+		if err := pi.UnmarshalCBOR(r); err != nil {
+			return xerrors.Errorf("reading piece info %d of %d: %w", i+1, header.PieceInfos, err)
+		}
+		err := ps.mutatePieceInfo(pi.PieceCID, func(existing *piecestore.PieceInfo) error {
+			*existing = pi
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("restoring piece info %s: %w", pi.PieceCID, err)
+		}
+	}
+	for i := uint64(0); i < header.CIDInfos; i++ {
+		var ci piecestore.CIDInfo
+		if err := ci.UnmarshalCBOR(r); err != nil {
+			return xerrors.Errorf("reading cid info %d of %d: %w", i+1, header.CIDInfos, err)
+		}
+		err := ps.mutateCIDInfo(ci.CID, func(existing *piecestore.CIDInfo) error {
+			*existing = ci
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("restoring cid info %s: %w", ci.CID, err)
+		}
+	}
+	return nil
+}
+
 // Retrieve the PieceInfo associated with `pieceCID` from the piece info store.
 func (ps *pieceStore) GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error) {
 	var out piecestore.PieceInfo
@@ -1,16 +1,23 @@
 package piecestoreimpl_test
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 	"time"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-statestore"
@@ -79,6 +86,35 @@ func TestStorePieceInfo(t *testing.T) {
 		assert.Len(t, pi.Deals, 1)
 		assert.Equal(t, pi.Deals[0], dealInfo)
 	})
+
+	t.Run("can set remote location", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+		dealInfo := piecestore.DealInfo{
+			DealID:   abi.DealID(rand.Uint64()),
+			SectorID: abi.SectorNumber(rand.Uint64()),
+			Offset:   abi.PaddedPieceSize(rand.Uint64()),
+			Length:   abi.PaddedPieceSize(rand.Uint64()),
+		}
+		err := ps.AddDealForPiece(pieceCid, dealInfo)
+		assert.NoError(t, err)
+
+		err = ps.SetRemoteLocation(pieceCid, "https://example.com/piece.car")
+		assert.NoError(t, err)
+
+		pi, err := ps.GetPieceInfo(pieceCid)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/piece.car", pi.RemoteLocation)
+		assert.Equal(t, []piecestore.DealInfo{dealInfo}, pi.Deals)
+
+		err = ps.SetRemoteLocation(pieceCid, "")
+		assert.NoError(t, err)
+
+		pi, err = ps.GetPieceInfo(pieceCid)
+		assert.NoError(t, err)
+		assert.Empty(t, pi.RemoteLocation)
+	})
 }
 
 func TestStoreCIDInfo(t *testing.T) {
@@ -195,6 +231,304 @@ func TestStoreCIDInfo(t *testing.T) {
 	})
 }
 
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	pieceCids := shared_testutil.GenerateCids(2)
+	testCIDs := shared_testutil.GenerateCids(3)
+	dealInfo := piecestore.DealInfo{
+		DealID:   abi.DealID(rand.Uint64()),
+		SectorID: abi.SectorNumber(rand.Uint64()),
+		Offset:   abi.PaddedPieceSize(rand.Uint64()),
+		Length:   abi.PaddedPieceSize(rand.Uint64()),
+	}
+
+	initializePieceStore := func(t *testing.T, ctx context.Context) piecestore.PieceStore {
+		ps, err := piecestoreimpl.NewPieceStore(datastore.NewMapDatastore())
+		require.NoError(t, err)
+		shared_testutil.StartAndWaitForReady(ctx, t, ps)
+		return ps
+	}
+
+	t.Run("restores all records into another store", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+		require.NoError(t, ps.AddDealForPiece(pieceCids[0], dealInfo))
+		require.NoError(t, ps.SetRemoteLocation(pieceCids[1], "https://example.com/piece.car"))
+		require.NoError(t, ps.AddPieceBlockLocations(pieceCids[0], map[cid.Cid]piecestore.BlockLocation{
+			testCIDs[0]: {RelOffset: 0, BlockSize: 100},
+			testCIDs[1]: {RelOffset: 100, BlockSize: 200},
+		}))
+		require.NoError(t, ps.AddPieceBlockLocations(pieceCids[1], map[cid.Cid]piecestore.BlockLocation{
+			testCIDs[1]: {RelOffset: 0, BlockSize: 200},
+			testCIDs[2]: {RelOffset: 200, BlockSize: 300},
+		}))
+
+		var buf bytes.Buffer
+		require.NoError(t, ps.Snapshot(&buf))
+
+		restored := initializePieceStore(t, ctx)
+		// an existing record is replaced by the one in the snapshot
+		require.NoError(t, restored.SetRemoteLocation(pieceCids[0], "https://example.com/stale.car"))
+		require.NoError(t, restored.Restore(&buf))
+
+		for _, pieceCid := range pieceCids {
+			expected, err := ps.GetPieceInfo(pieceCid)
+			require.NoError(t, err)
+			actual, err := restored.GetPieceInfo(pieceCid)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		}
+		for _, c := range testCIDs {
+			expected, err := ps.GetCIDInfo(c)
+			require.NoError(t, err)
+			actual, err := restored.GetCIDInfo(c)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		}
+	})
+
+	t.Run("rejects unknown snapshot versions", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		var buf bytes.Buffer
+		header := piecestore.SnapshotHeader{Version: piecestore.SnapshotVersion + 1}
+		require.NoError(t, header.MarshalCBOR(&buf))
+
+		ps := initializePieceStore(t, ctx)
+		require.Error(t, ps.Restore(&buf))
+	})
+
+	t.Run("fails on a truncated snapshot", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+		require.NoError(t, ps.AddDealForPiece(pieceCids[0], dealInfo))
+		var buf bytes.Buffer
+		require.NoError(t, ps.Snapshot(&buf))
+
+		restored := initializePieceStore(t, ctx)
+		require.Error(t, restored.Restore(bytes.NewReader(buf.Bytes()[:buf.Len()-1])))
+	})
+}
+
+type fakeSectorChecker struct {
+	terminated map[abi.SectorNumber]bool
+	commPs     map[abi.SectorNumber]cid.Cid
+}
+
+func (fsc *fakeSectorChecker) SectorExists(ctx context.Context, sectorID abi.SectorNumber) (bool, error) {
+	return !fsc.terminated[sectorID], nil
+}
+
+func (fsc *fakeSectorChecker) PieceCommitment(ctx context.Context, sectorID abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (cid.Cid, error) {
+	return fsc.commPs[sectorID], nil
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	pieceCids := shared_testutil.GenerateCids(2)
+	payloadCid := shared_testutil.GenerateCids(1)[0]
+	liveDeal := piecestore.DealInfo{DealID: 1, SectorID: 10, Length: 256}
+	terminatedDeal := piecestore.DealInfo{DealID: 2, SectorID: 20, Length: 256}
+	otherDeal := piecestore.DealInfo{DealID: 3, SectorID: 30, Length: 256}
+	checker := &fakeSectorChecker{
+		terminated: map[abi.SectorNumber]bool{20: true, 30: true},
+		commPs:     map[abi.SectorNumber]cid.Cid{10: pieceCids[0]},
+	}
+
+	initializePieceStore := func(t *testing.T, ctx context.Context) piecestore.PieceStore {
+		ps, err := piecestoreimpl.NewPieceStore(datastore.NewMapDatastore())
+		require.NoError(t, err)
+		shared_testutil.StartAndWaitForReady(ctx, t, ps)
+		require.NoError(t, ps.AddDealForPiece(pieceCids[0], liveDeal))
+		require.NoError(t, ps.AddDealForPiece(pieceCids[0], terminatedDeal))
+		require.NoError(t, ps.AddDealForPiece(pieceCids[1], otherDeal))
+		require.NoError(t, ps.AddPieceBlockLocations(pieceCids[1], map[cid.Cid]piecestore.BlockLocation{
+			payloadCid: {RelOffset: 0, BlockSize: 10},
+		}))
+		return ps
+	}
+
+	t.Run("reports without pruning", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+
+		report, err := piecestore.Verify(ctx, ps, checker, piecestore.VerifyOptions{CommPSampleRate: 1})
+		require.NoError(t, err)
+		require.Equal(t, 2, report.PiecesChecked)
+		require.Equal(t, 3, report.DealsChecked)
+		require.Equal(t, 1, report.CommPChecked)
+		require.Len(t, report.Problems, 2)
+		for _, problem := range report.Problems {
+			require.Equal(t, piecestore.VerifySectorMissing, problem.Kind)
+			require.False(t, problem.Pruned)
+		}
+		require.Empty(t, report.PiecesPruned)
+
+		pi, err := ps.GetPieceInfo(pieceCids[0])
+		require.NoError(t, err)
+		require.Len(t, pi.Deals, 2)
+	})
+
+	t.Run("prunes terminated sectors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+
+		report, err := piecestore.Verify(ctx, ps, checker, piecestore.VerifyOptions{Prune: true})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 2)
+		require.Equal(t, []cid.Cid{pieceCids[1]}, report.PiecesPruned)
+
+		pi, err := ps.GetPieceInfo(pieceCids[0])
+		require.NoError(t, err)
+		require.Equal(t, []piecestore.DealInfo{liveDeal}, pi.Deals)
+
+		pi, err = ps.GetPieceInfo(pieceCids[1])
+		require.NoError(t, err)
+		require.Empty(t, pi.Deals)
+
+		ci, err := ps.GetCIDInfo(payloadCid)
+		require.NoError(t, err)
+		require.Empty(t, ci.PieceBlockLocations)
+	})
+
+	t.Run("reports piece mismatch", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+		mismatched := &fakeSectorChecker{
+			commPs: map[abi.SectorNumber]cid.Cid{10: pieceCids[1], 20: pieceCids[0], 30: pieceCids[1]},
+		}
+
+		report, err := piecestore.Verify(ctx, ps, mismatched, piecestore.VerifyOptions{CommPSampleRate: 1, Prune: true})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		require.Equal(t, piecestore.VerifyPieceMismatch, report.Problems[0].Kind)
+		require.Equal(t, liveDeal, report.Problems[0].Deal)
+		require.False(t, report.Problems[0].Pruned)
+	})
+}
+
+type fakeUnsealer struct {
+	sectors map[abi.SectorNumber][]byte
+}
+
+func (fu *fakeUnsealer) UnsealSector(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	data, ok := fu.sectors[sectorID]
+	if !ok {
+		return nil, xerrors.Errorf("sector %d is not unsealed", sectorID)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+func TestVerifyBlockLocations(t *testing.T) {
+	ctx := context.Background()
+	pieceCids := shared_testutil.GenerateCids(2)
+	blks := []blocks.Block{
+		blocks.NewBlock(shared_testutil.RandomBytes(100)),
+		blocks.NewBlock(shared_testutil.RandomBytes(200)),
+		blocks.NewBlock(shared_testutil.RandomBytes(50)),
+	}
+
+	var carData bytes.Buffer
+	require.NoError(t, car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, &carData))
+	for _, blk := range blks {
+		require.NoError(t, util.LdWrite(&carData, blk.Cid().Bytes(), blk.RawData()))
+	}
+	// the piece's data is the CAR followed by zeros
+	pieceData := make([]byte, abi.PaddedPieceSize(1024).Unpadded())
+	copy(pieceData, carData.Bytes())
+	unsealer := &fakeUnsealer{sectors: map[abi.SectorNumber][]byte{10: pieceData}}
+
+	locations, err := piecestore.IndexCAR(bytes.NewReader(pieceData))
+	require.NoError(t, err)
+	require.Len(t, locations, len(blks))
+	for _, blk := range blks {
+		loc := locations[blk.Cid()]
+		require.Equal(t, blk.RawData(), pieceData[loc.RelOffset:loc.RelOffset+loc.BlockSize])
+	}
+
+	initializePieceStore := func(t *testing.T, ctx context.Context) piecestore.PieceStore {
+		ps, err := piecestoreimpl.NewPieceStore(datastore.NewMapDatastore())
+		require.NoError(t, err)
+		shared_testutil.StartAndWaitForReady(ctx, t, ps)
+		require.NoError(t, ps.AddDealForPiece(pieceCids[0], piecestore.DealInfo{DealID: 1, SectorID: 10, Length: 1024}))
+		// the second block's location is off by one byte
+		shifted := locations[blks[1].Cid()]
+		shifted.RelOffset++
+		corrupted := map[cid.Cid]piecestore.BlockLocation{
+			blks[0].Cid(): locations[blks[0].Cid()],
+			blks[1].Cid(): shifted,
+			blks[2].Cid(): locations[blks[2].Cid()],
+		}
+		require.NoError(t, ps.AddPieceBlockLocations(pieceCids[0], corrupted))
+		return ps
+	}
+
+	t.Run("reports corrupted locations", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+
+		report, err := piecestore.VerifyBlockLocations(ctx, ps, unsealer, piecestore.BlockVerifyOptions{SampleRate: 1})
+		require.NoError(t, err)
+		require.Equal(t, 3, report.CIDsChecked)
+		require.Equal(t, 3, report.LocationsChecked)
+		require.Equal(t, 1, report.PiecesRead)
+		require.Len(t, report.Problems, 1)
+		require.Equal(t, piecestore.BlockMismatch, report.Problems[0].Kind)
+		require.Equal(t, blks[1].Cid(), report.Problems[0].CID)
+		require.Empty(t, report.PiecesRepaired)
+	})
+
+	t.Run("repairs corrupted locations", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+
+		report, err := piecestore.VerifyBlockLocations(ctx, ps, unsealer, piecestore.BlockVerifyOptions{SampleRate: 1, Repair: true})
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		require.Equal(t, []cid.Cid{pieceCids[0]}, report.PiecesRepaired)
+
+		ci, err := ps.GetCIDInfo(blks[1].Cid())
+		require.NoError(t, err)
+		require.Equal(t, []piecestore.PieceBlockLocation{{BlockLocation: locations[blks[1].Cid()], PieceCID: pieceCids[0]}}, ci.PieceBlockLocations)
+
+		report, err = piecestore.VerifyBlockLocations(ctx, ps, unsealer, piecestore.BlockVerifyOptions{SampleRate: 1})
+		require.NoError(t, err)
+		require.Empty(t, report.Problems)
+	})
+
+	t.Run("reports pieces that cannot be read", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		ps := initializePieceStore(t, ctx)
+		require.NoError(t, ps.AddDealForPiece(pieceCids[1], piecestore.DealInfo{DealID: 2, SectorID: 20, Length: 1024}))
+		payloadCid := shared_testutil.GenerateCids(1)[0]
+		require.NoError(t, ps.AddPieceBlockLocations(pieceCids[1], map[cid.Cid]piecestore.BlockLocation{
+			payloadCid: {RelOffset: 0, BlockSize: 10},
+		}))
+
+		report, err := piecestore.VerifyBlockLocations(ctx, ps, unsealer, piecestore.BlockVerifyOptions{SampleRate: 1, Repair: true})
+		require.NoError(t, err)
+		require.Equal(t, 1, report.PiecesRead)
+		require.Len(t, report.Problems, 2)
+		var failed []piecestore.BlockProblem
+		for _, problem := range report.Problems {
+			if problem.Kind == piecestore.BlockCheckFailed {
+				failed = append(failed, problem)
+			}
+		}
+		require.Len(t, failed, 1)
+		require.Equal(t, pieceCids[1], failed[0].PieceCID)
+		require.Equal(t, []cid.Cid{pieceCids[0]}, report.PiecesRepaired)
+	})
+}
+
 func TestMigrations(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -2,6 +2,7 @@ package piecestore
 
 import (
 	"context"
+	"io"
 
 	"github.com/ipfs/go-cid"
 
@@ -10,7 +11,7 @@ import (
 	"github.com/filecoin-project/go-fil-markets/shared"
 )
 
-//go:generate cbor-gen-for --map-encoding PieceInfo DealInfo BlockLocation PieceBlockLocation CIDInfo
+//go:generate cbor-gen-for --map-encoding PieceInfo DealInfo BlockLocation PieceBlockLocation CIDInfo SnapshotHeader
 
 // DealInfo is information about a single deal for a given piece
 type DealInfo struct {
@@ -48,19 +49,46 @@ var CIDInfoUndefined = CIDInfo{}
 type PieceInfo struct {
 	PieceCID cid.Cid
 	Deals    []DealInfo
+	// RemoteLocation is where a copy of the piece's data is kept outside of the
+	// provider's sectors, such as a URL, or empty if there is no remote copy.
+	// A retrieval provider fetches the piece from there when it cannot unseal it
+	RemoteLocation string
 }
 
 // PieceInfoUndefined is piece info with no information
 var PieceInfoUndefined = PieceInfo{}
 
+// SnapshotVersion is the version of the snapshot format written by Snapshot
+const SnapshotVersion = 1
+
+// SnapshotHeader begins a piece store snapshot. It is followed by the given number of
+// PieceInfo records, then the given number of CIDInfo records
+type SnapshotHeader struct {
+	Version    uint64
+	PieceInfos uint64
+	CIDInfos   uint64
+}
+
 // PieceStore is a saved database of piece info that can be modified and queried
 type PieceStore interface {
 	Start(ctx context.Context) error
 	OnReady(ready shared.ReadyFunc)
 	AddDealForPiece(pieceCID cid.Cid, dealInfo DealInfo) error
 	AddPieceBlockLocations(pieceCID cid.Cid, blockLocations map[cid.Cid]BlockLocation) error
+	SetRemoteLocation(pieceCID cid.Cid, location string) error
+	// RemoveDealForPiece removes the record of a deal for the piece, such as when the
+	// deal's sector was terminated
+	RemoveDealForPiece(pieceCID cid.Cid, dealInfo DealInfo) error
+	// RemovePieceBlockLocations removes the locations of all blocks in the piece, so
+	// that payloads are no longer found in it
+	RemovePieceBlockLocations(pieceCID cid.Cid) error
 	GetPieceInfo(pieceCID cid.Cid) (PieceInfo, error)
 	GetCIDInfo(payloadCID cid.Cid) (CIDInfo, error)
 	ListCidInfoKeys() ([]cid.Cid, error)
 	ListPieceInfoKeys() ([]cid.Cid, error)
+	// Snapshot writes every piece and CID record to w, as of a single point in time
+	Snapshot(w io.Writer) error
+	// Restore reads a snapshot written by Snapshot from r and writes its records to the
+	// store, replacing records with the same key. Other records are kept
+	Restore(r io.Reader) error
 }
@@ -18,7 +18,7 @@ func (t *PieceInfo) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{162}); err != nil {
+	if _, err := w.Write([]byte{163}); err != nil {
 		return err
 	}
 
@@ -64,6 +64,29 @@ func (t *PieceInfo) MarshalCBOR(w io.Writer) error {
 			return err
 		}
 	}
+
+	// t.RemoteLocation (string) (string)
+	if len("RemoteLocation") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RemoteLocation\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RemoteLocation"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("RemoteLocation")); err != nil {
+		return err
+	}
+
+	if len(t.RemoteLocation) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.RemoteLocation was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.RemoteLocation))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.RemoteLocation)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -143,6 +166,18 @@ func (t *PieceInfo) UnmarshalCBOR(r io.Reader) error {
 				t.Deals[i] = v
 			}
 
+			// t.RemoteLocation (string) (string)
+		case "RemoteLocation":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.RemoteLocation = string(sval)
+			}
+
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
 		}
@@ -693,3 +728,151 @@ func (t *CIDInfo) UnmarshalCBOR(r io.Reader) error {
 
 	return nil
 }
+func (t *SnapshotHeader) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Version (uint64) (uint64)
+	if len("Version") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Version\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Version"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Version")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Version)); err != nil {
+		return err
+	}
+
+	// t.PieceInfos (uint64) (uint64)
+	if len("PieceInfos") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PieceInfos\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PieceInfos"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PieceInfos")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PieceInfos)); err != nil {
+		return err
+	}
+
+	// t.CIDInfos (uint64) (uint64)
+	if len("CIDInfos") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"CIDInfos\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("CIDInfos"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("CIDInfos")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.CIDInfos)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *SnapshotHeader) UnmarshalCBOR(r io.Reader) error {
+	*t = SnapshotHeader{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("SnapshotHeader: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Version (uint64) (uint64)
+		case "Version":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Version = uint64(extra)
+
+			}
+			// t.PieceInfos (uint64) (uint64)
+		case "PieceInfos":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.PieceInfos = uint64(extra)
+
+			}
+			// t.CIDInfos (uint64) (uint64)
+		case "CIDInfos":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.CIDInfos = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package piecestore
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// SectorChecker is the node interface Verify uses to check piece records against the
+// sectors they point to
+type SectorChecker interface {
+	// SectorExists returns false if the sector no longer holds data, such as when it
+	// was terminated
+	SectorExists(ctx context.Context, sectorID abi.SectorNumber) (bool, error)
+	// PieceCommitment computes the piece CID of the data at the given offset and
+	// length in the sector
+	PieceCommitment(ctx context.Context, sectorID abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (cid.Cid, error)
+}
+
+// VerifyOptions configures a Verify run
+type VerifyOptions struct {
+	// CommPSampleRate is the fraction of deals, from 0 to 1, whose data is read back
+	// from the sector to check that its piece CID matches. Other deals only have the
+	// existence of their sector checked
+	CommPSampleRate float64
+	// Prune removes the records of deals whose sectors no longer exist. Pieces left
+	// with no deals and no remote copy also have their block locations removed, so
+	// that payloads are no longer found in them
+	Prune bool
+	// Rand picks the deals whose piece CID is checked, or nil to seed one from the
+	// current time
+	Rand *rand.Rand
+}
+
+// VerifyProblemKind is the kind of inconsistency Verify found in a deal record
+type VerifyProblemKind uint64
+
+const (
+	// VerifySectorMissing means the deal's sector no longer exists
+	VerifySectorMissing VerifyProblemKind = iota
+	// VerifyPieceMismatch means the data in the deal's sector has a different piece CID
+	VerifyPieceMismatch
+	// VerifyCheckFailed means the deal could not be checked
+	VerifyCheckFailed
+)
+
+// VerifyProblemKinds maps problem kinds to human readable names
+var VerifyProblemKinds = map[VerifyProblemKind]string{
+	VerifySectorMissing: "VerifySectorMissing",
+	VerifyPieceMismatch: "VerifyPieceMismatch",
+	VerifyCheckFailed:   "VerifyCheckFailed",
+}
+
+// VerifyProblem is an inconsistency between a deal record and its sector
+type VerifyProblem struct {
+	PieceCID cid.Cid
+	Deal     DealInfo
+	Kind     VerifyProblemKind
+	Message  string
+	// Pruned is true if the deal record was removed
+	Pruned bool
+}
+
+// VerifyReport is the result of a Verify run
+type VerifyReport struct {
+	PiecesChecked int
+	DealsChecked  int
+	// CommPChecked is the number of deals whose piece CID was recomputed
+	CommPChecked int
+	Problems     []VerifyProblem
+	// PiecesPruned are the pieces whose block locations were removed because no
+	// deals or remote copy were left for them
+	PiecesPruned []cid.Cid
+}
+
+// Verify cross-checks the piece records in the piece store against the sectors they
+// point to, and reports the deal records whose sectors are gone or hold different
+// data. With VerifyOptions.Prune, it also removes the records of deals whose sectors
+// were terminated. Verify can run while the piece store is in use
+func Verify(ctx context.Context, ps PieceStore, checker SectorChecker, opts VerifyOptions) (VerifyReport, error) {
+	var report VerifyReport
+	rnd := opts.Rand
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	pieceCIDs, err := ps.ListPieceInfoKeys()
+	if err != nil {
+		return report, xerrors.Errorf("listing pieces: %w", err)
+	}
+
+	for _, pieceCID := range pieceCIDs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		pieceInfo, err := ps.GetPieceInfo(pieceCID)
+		if err != nil {
+			return report, xerrors.Errorf("getting piece %s: %w", pieceCID, err)
+		}
+		report.PiecesChecked++
+
+		remaining := len(pieceInfo.Deals)
+		for _, deal := range pieceInfo.Deals {
+			report.DealsChecked++
+			problem, checkedCommP := verifyDeal(ctx, checker, pieceCID, deal, rnd.Float64() < opts.CommPSampleRate)
+			if checkedCommP {
+				report.CommPChecked++
+			}
+			if problem == nil {
+				continue
+			}
+			if opts.Prune && problem.Kind == VerifySectorMissing {
+				if err := ps.RemoveDealForPiece(pieceCID, deal); err != nil {
+					return report, xerrors.Errorf("removing deal %d for piece %s: %w", deal.DealID, pieceCID, err)
+				}
+				problem.Pruned = true
+				remaining--
+			}
+			report.Problems = append(report.Problems, *problem)
+		}
+
+		if opts.Prune && remaining == 0 && len(pieceInfo.Deals) > 0 && pieceInfo.RemoteLocation == "" {
+			if err := ps.RemovePieceBlockLocations(pieceCID); err != nil {
+				return report, xerrors.Errorf("removing block locations for piece %s: %w", pieceCID, err)
+			}
+			report.PiecesPruned = append(report.PiecesPruned, pieceCID)
+		}
+	}
+	return report, nil
+}
+
+// verifyDeal checks a deal's sector exists and, if checkCommP is set, that the data
+// in it has the piece's CID. It returns true if the piece CID was recomputed
+func verifyDeal(ctx context.Context, checker SectorChecker, pieceCID cid.Cid, deal DealInfo, checkCommP bool) (*VerifyProblem, bool) {
+	problem := func(kind VerifyProblemKind, msg string) *VerifyProblem {
+		return &VerifyProblem{PieceCID: pieceCID, Deal: deal, Kind: kind, Message: msg}
+	}
+
+	exists, err := checker.SectorExists(ctx, deal.SectorID)
+	if err != nil {
+		return problem(VerifyCheckFailed, xerrors.Errorf("checking sector %d: %w", deal.SectorID, err).Error()), false
+	}
+	if !exists {
+		return problem(VerifySectorMissing, xerrors.Errorf("sector %d does not exist", deal.SectorID).Error()), false
+	}
+	if !checkCommP {
+		return nil, false
+	}
+
+	commP, err := checker.PieceCommitment(ctx, deal.SectorID, deal.Offset, deal.Length)
+	if err != nil {
+		return problem(VerifyCheckFailed, xerrors.Errorf("computing piece CID in sector %d: %w", deal.SectorID, err).Error()), true
+	}
+	if !commP.Equals(pieceCID) {
+		return problem(VerifyPieceMismatch, xerrors.Errorf("sector %d holds piece %s", deal.SectorID, commP).Error()), true
+	}
+	return nil, true
+}
@@ -0,0 +1,308 @@
+package piecestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// PieceUnsealer is the node interface VerifyBlockLocations uses to read the data of a
+// piece back from the sectors its deals are in
+type PieceUnsealer interface {
+	// UnsealSector returns a reader for the unsealed data at the given offset and
+	// length in the sector
+	UnsealSector(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (io.ReadCloser, error)
+}
+
+// BlockVerifyOptions configures a VerifyBlockLocations run
+type BlockVerifyOptions struct {
+	// SampleRate is the fraction of CIDs, from 0 to 1, whose block locations are
+	// checked
+	SampleRate float64
+	// Repair re-indexes the pieces in which a block location was found to be wrong,
+	// by reading the blocks of the CAR in the piece's data and replacing the piece's
+	// block locations with where they actually are
+	Repair bool
+	// Rand picks the CIDs whose block locations are checked, or nil to seed one from
+	// the current time
+	Rand *rand.Rand
+}
+
+// BlockProblemKind is the kind of inconsistency VerifyBlockLocations found in a block
+// location
+type BlockProblemKind uint64
+
+const (
+	// BlockMismatch means the data at the block location does not hash to the CID
+	BlockMismatch BlockProblemKind = iota
+	// BlockOutOfRange means the block location is past the end of the piece's data
+	BlockOutOfRange
+	// BlockCheckFailed means the piece's data could not be read to check the location
+	BlockCheckFailed
+)
+
+// BlockProblemKinds maps block problem kinds to human readable names
+var BlockProblemKinds = map[BlockProblemKind]string{
+	BlockMismatch:    "BlockMismatch",
+	BlockOutOfRange:  "BlockOutOfRange",
+	BlockCheckFailed: "BlockCheckFailed",
+}
+
+// BlockProblem is an inconsistency between a block location and the piece data it
+// points into
+type BlockProblem struct {
+	CID      cid.Cid
+	PieceCID cid.Cid
+	Location BlockLocation
+	Kind     BlockProblemKind
+	Message  string
+}
+
+// BlockVerifyReport is the result of a VerifyBlockLocations run
+type BlockVerifyReport struct {
+	CIDsChecked      int
+	LocationsChecked int
+	// PiecesRead is the number of pieces whose data was read back from a sector
+	PiecesRead int
+	Problems   []BlockProblem
+	// PiecesRepaired are the pieces whose block locations were replaced
+	PiecesRepaired []cid.Cid
+}
+
+type sampledLocation struct {
+	cid      cid.Cid
+	location BlockLocation
+}
+
+// VerifyBlockLocations samples the block locations in the piece store and checks that
+// the data they point to in each piece still hashes to the block's CID, so that a
+// corrupted index is found before retrievals of it fail. Each sampled piece is read
+// back once from the first of its deals' sectors that can be unsealed. With
+// BlockVerifyOptions.Repair, pieces with bad locations are re-indexed from their data.
+// Locations recorded without a block size, which only say which piece holds the
+// payload, are not checked
+func VerifyBlockLocations(ctx context.Context, ps PieceStore, unsealer PieceUnsealer, opts BlockVerifyOptions) (BlockVerifyReport, error) {
+	var report BlockVerifyReport
+	rnd := opts.Rand
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	cids, err := ps.ListCidInfoKeys()
+	if err != nil {
+		return report, xerrors.Errorf("listing CIDs: %w", err)
+	}
+
+	byPiece := make(map[cid.Cid][]sampledLocation)
+	var pieces []cid.Cid
+	for _, c := range cids {
+		if rnd.Float64() >= opts.SampleRate {
+			continue
+		}
+		cidInfo, err := ps.GetCIDInfo(c)
+		if err != nil {
+			return report, xerrors.Errorf("getting CID %s: %w", c, err)
+		}
+		report.CIDsChecked++
+		for _, pbl := range cidInfo.PieceBlockLocations {
+			if pbl.BlockSize == 0 {
+				continue
+			}
+			if _, ok := byPiece[pbl.PieceCID]; !ok {
+				pieces = append(pieces, pbl.PieceCID)
+			}
+			byPiece[pbl.PieceCID] = append(byPiece[pbl.PieceCID], sampledLocation{cid: c, location: pbl.BlockLocation})
+		}
+	}
+
+	for _, pieceCID := range pieces {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		locations := byPiece[pieceCID]
+		report.LocationsChecked += len(locations)
+		problems, read := verifyPieceBlocks(ctx, ps, unsealer, pieceCID, locations)
+		if read {
+			report.PiecesRead++
+		}
+		report.Problems = append(report.Problems, problems...)
+		if !opts.Repair || !read || len(problems) == 0 {
+			continue
+		}
+		if err := repairBlockLocations(ctx, ps, unsealer, pieceCID); err != nil {
+			return report, xerrors.Errorf("repairing block locations for piece %s: %w", pieceCID, err)
+		}
+		report.PiecesRepaired = append(report.PiecesRepaired, pieceCID)
+	}
+	return report, nil
+}
+
+// verifyPieceBlocks reads the piece's data and checks the given block locations in
+// it. It returns false if the data cannot be read, along with a BlockCheckFailed
+// problem for every location
+func verifyPieceBlocks(ctx context.Context, ps PieceStore, unsealer PieceUnsealer, pieceCID cid.Cid, locations []sampledLocation) ([]BlockProblem, bool) {
+	problem := func(l sampledLocation, kind BlockProblemKind, msg string) BlockProblem {
+		return BlockProblem{CID: l.cid, PieceCID: pieceCID, Location: l.location, Kind: kind, Message: msg}
+	}
+
+	reader, err := unsealPiece(ctx, ps, unsealer, pieceCID)
+	if err != nil {
+		problems := make([]BlockProblem, 0, len(locations))
+		for _, l := range locations {
+			problems = append(problems, problem(l, BlockCheckFailed, err.Error()))
+		}
+		return problems, false
+	}
+	defer reader.Close() // nolint: errcheck
+
+	// read the piece once, front to back
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].location.RelOffset < locations[j].location.RelOffset
+	})
+	var problems []BlockProblem
+	var pos uint64
+	var data []byte
+	for i, l := range locations {
+		if l.location.RelOffset < pos {
+			// overlapping locations cannot both be right, and reading backwards would
+			// mean reading the piece again
+			problems = append(problems, problem(l, BlockMismatch, xerrors.Errorf("block at offset %d overlaps the block before it", l.location.RelOffset).Error()))
+			continue
+		}
+		if _, err := io.CopyN(ioutil.Discard, reader, int64(l.location.RelOffset-pos)); err != nil {
+			return append(problems, outOfRange(locations[i:], problem, err)...), true
+		}
+		pos = l.location.RelOffset
+		if uint64(cap(data)) < l.location.BlockSize {
+			data = make([]byte, l.location.BlockSize)
+		}
+		data = data[:l.location.BlockSize]
+		n, err := io.ReadFull(reader, data)
+		pos += uint64(n)
+		if err != nil {
+			return append(problems, outOfRange(locations[i:], problem, err)...), true
+		}
+		actual, err := l.cid.Prefix().Sum(data)
+		if err != nil {
+			problems = append(problems, problem(l, BlockMismatch, xerrors.Errorf("hashing block: %w", err).Error()))
+			continue
+		}
+		if !actual.Equals(l.cid) {
+			problems = append(problems, problem(l, BlockMismatch, xerrors.Errorf("data at offset %d hashes to %s", l.location.RelOffset, actual).Error()))
+		}
+	}
+	return problems, true
+}
+
+// outOfRange returns a BlockOutOfRange problem for each of the given locations, which
+// start at or past where reading the piece's data failed
+func outOfRange(locations []sampledLocation, problem func(sampledLocation, BlockProblemKind, string) BlockProblem, err error) []BlockProblem {
+	problems := make([]BlockProblem, 0, len(locations))
+	for _, l := range locations {
+		problems = append(problems, problem(l, BlockOutOfRange, xerrors.Errorf("reading %d bytes at offset %d: %w", l.location.BlockSize, l.location.RelOffset, err).Error()))
+	}
+	return problems
+}
+
+// repairBlockLocations replaces the piece's block locations with the locations of
+// the blocks of the CAR in its data
+func repairBlockLocations(ctx context.Context, ps PieceStore, unsealer PieceUnsealer, pieceCID cid.Cid) error {
+	reader, err := unsealPiece(ctx, ps, unsealer, pieceCID)
+	if err != nil {
+		return err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	locations, err := IndexCAR(reader)
+	if err != nil {
+		return xerrors.Errorf("indexing piece data: %w", err)
+	}
+	if err := ps.RemovePieceBlockLocations(pieceCID); err != nil {
+		return err
+	}
+	return ps.AddPieceBlockLocations(pieceCID, locations)
+}
+
+// unsealPiece returns a reader for the piece's unpadded data, from the first of its
+// deals' sectors that can be unsealed
+func unsealPiece(ctx context.Context, ps PieceStore, unsealer PieceUnsealer, pieceCID cid.Cid) (io.ReadCloser, error) {
+	pieceInfo, err := ps.GetPieceInfo(pieceCID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting piece: %w", err)
+	}
+	lastErr := xerrors.New("no sectors found to unseal from")
+	for _, deal := range pieceInfo.Deals {
+		reader, err := unsealer.UnsealSector(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+		if err == nil {
+			return reader, nil
+		}
+		lastErr = xerrors.Errorf("unsealing sector %d: %w", deal.SectorID, err)
+	}
+	return nil, lastErr
+}
+
+// IndexCAR reads a CAR from r and returns where the data of each of its blocks is,
+// relative to the start of r. Reading stops at the end of r, or at the zeros that
+// fill a piece after its CAR
+func IndexCAR(r io.Reader) (map[cid.Cid]BlockLocation, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+
+	headerLen, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return nil, xerrors.Errorf("reading CAR header: %w", err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, cr, int64(headerLen)); err != nil {
+		return nil, xerrors.Errorf("reading CAR header: %w", err)
+	}
+
+	locations := make(map[cid.Cid]BlockLocation)
+	for {
+		sectionLen, err := binary.ReadUvarint(cr)
+		if err == io.EOF || (err == nil && sectionLen == 0) {
+			return locations, nil
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("reading block at offset %d: %w", cr.n, err)
+		}
+		section := make([]byte, sectionLen)
+		if _, err := io.ReadFull(cr, section); err != nil {
+			return nil, xerrors.Errorf("reading block at offset %d: %w", cr.n, err)
+		}
+		cidLen, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return nil, xerrors.Errorf("reading CID of block ending at offset %d: %w", cr.n, err)
+		}
+		blockSize := sectionLen - uint64(cidLen)
+		locations[c] = BlockLocation{RelOffset: cr.n - blockSize, BlockSize: blockSize}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r *bufio.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
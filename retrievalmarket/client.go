@@ -2,14 +2,18 @@ package retrievalmarket
 
 import (
 	"context"
+	"io"
 
 	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
 )
 
 // ClientSubscriber is a callback that is registered to listen for retrieval events
@@ -35,6 +39,29 @@ type RetrievalClient interface {
 		params QueryParams,
 	) (QueryResponse, error)
 
+	// QueryInline asks a provider about a payload like Query, and for the payload to be
+	// sent with the response, without a deal, if it is no bigger than maxSize bytes.
+	// The response is signed by the worker of the miner storing the payload. Its Data,
+	// if set, is a CAR file of the payload's whole DAG, checked against the payload CID
+	QueryInline(
+		ctx context.Context,
+		p RetrievalPeer,
+		payloadCID cid.Cid,
+		params QueryParams,
+		maxSize uint64,
+	) (SignedInlineQueryResponse, error)
+
+	// ChallengePossession challenges a provider to prove it holds a payload before
+	// paying it to unseal the payload, by sending the blocks on the given number of
+	// paths through the payload's DAG. It returns an error unless the proof verifies
+	ChallengePossession(
+		ctx context.Context,
+		p RetrievalPeer,
+		payloadCID cid.Cid,
+		params QueryParams,
+		paths uint64,
+	) error
+
 	// Retrieve retrieves all or part of a piece with the given retrieval parameters
 	Retrieve(
 		ctx context.Context,
@@ -47,6 +74,87 @@ type RetrievalClient interface {
 		storeID *multistore.StoreID,
 	) (DealID, error)
 
+	// RetrieveToCAR retrieves all or part of a piece like Retrieve, but writes the blocks
+	// it receives to out as a CAR stream of the given version, in traversal order,
+	// instead of into a store. Only version 1 is supported; any other version fails
+	// with ErrUnsupportedCARVersion before a deal is started
+	RetrieveToCAR(
+		ctx context.Context,
+		payloadCID cid.Cid,
+		params Params,
+		totalFunds abi.TokenAmount,
+		p RetrievalPeer,
+		clientWallet address.Address,
+		minerWallet address.Address,
+		out io.Writer,
+		carVersion uint64,
+	) (DealID, error)
+
+	// RetrieveToPath retrieves the whole UnixFS DAG under payloadCID into a store like
+	// Retrieve, and writes its files to path as the blocks arrive
+	RetrieveToPath(
+		ctx context.Context,
+		payloadCID cid.Cid,
+		params Params,
+		totalFunds abi.TokenAmount,
+		p RetrievalPeer,
+		clientWallet address.Address,
+		minerWallet address.Address,
+		storeID multistore.StoreID,
+		path string,
+	) (DealID, error)
+
+	// RetrieveStreaming retrieves all or part of a piece into a store like Retrieve,
+	// and passes each block to the returned subscription as it arrives, so that
+	// consumers can start reading before the deal completes. At most bufferSize
+	// blocks wait for the consumer before the transfer waits for it
+	RetrieveStreaming(
+		ctx context.Context,
+		payloadCID cid.Cid,
+		params Params,
+		totalFunds abi.TokenAmount,
+		p RetrievalPeer,
+		clientWallet address.Address,
+		minerWallet address.Address,
+		storeID multistore.StoreID,
+		bufferSize int,
+	) (DealID, BlockSubscription, error)
+
+	// RetrieveWithLocal retrieves a payload into a store like Retrieve, but copies the
+	// blocks already in local into the store, and only retrieves the blocks missing. It
+	// returns false, and no deal, if no blocks were missing
+	RetrieveWithLocal(
+		ctx context.Context,
+		payloadCID cid.Cid,
+		params Params,
+		totalFunds abi.TokenAmount,
+		p RetrievalPeer,
+		clientWallet address.Address,
+		minerWallet address.Address,
+		storeID multistore.StoreID,
+		local blockstore.Blockstore,
+	) (DealID, bool, error)
+
+	// RetrieveSharded retrieves each shard of a payload that was split across several
+	// deals into a store, and reassembles the payload there
+	RetrieveSharded(
+		ctx context.Context,
+		manifest *dagsharding.Manifest,
+		plan ShardRetrievalPlanner,
+		storeID multistore.StoreID,
+	) error
+
+	// RetrievePiece retrieves a whole piece by its PieceCID, rather than the DAG inside
+	// it, and writes the piece's data to out in the given format. It returns the
+	// number of bytes written
+	RetrievePiece(
+		ctx context.Context,
+		p RetrievalPeer,
+		pieceCID cid.Cid,
+		format PieceFormat,
+		out io.Writer,
+	) (uint64, error)
+
 	// SubscribeToEvents listens for events that happen related to client retrievals
 	SubscribeToEvents(subscriber ClientSubscriber) Unsubscribe
 
@@ -64,4 +172,8 @@ type RetrievalClient interface {
 
 	// ListDeals returns all deals
 	ListDeals() (map[DealID]ClientDealState, error)
+
+	// DealStateSnapshot returns the client deal state machine definition
+	// along with the number of deals currently in each state
+	DealStateSnapshot() (fsmexport.Snapshot, error)
 }
@@ -106,6 +106,10 @@ const (
 
 	// DealStatusWaitForAcceptanceLegacy means we're waiting to hear the results on the legacy protocol
 	DealStatusWaitForAcceptanceLegacy
+
+	// DealStatusTransferQueued means the deal is waiting for one of the provider's
+	// transfer slots before it unseals and sends data
+	DealStatusTransferQueued
 )
 
 // DealStatuses maps deal status to a human readable representation
@@ -139,4 +143,5 @@ var DealStatuses = map[DealStatus]string{
 	DealStatusCancelled:                    "DealStatusCancelled",
 	DealStatusRetryLegacy:                  "DealStatusRetryLegacy",
 	DealStatusWaitForAcceptanceLegacy:      "DealStatusWaitForAcceptanceLegacy",
+	DealStatusTransferQueued:               "DealStatusTransferQueued",
 }
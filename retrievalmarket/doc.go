@@ -60,6 +60,78 @@ When `Retrieve` is called, it allocates a new DealID from its stored counter, co
 the deal proposal to the provider, initiates tracking of deal state and hands the deal to the Client FSM,
 and returns the DealID which constitutes the identifier for that deal.
 
+`RetrieveToCAR` starts a deal the same way, but streams the blocks the client receives to an io.Writer
+as a CARv1 file, in traversal order, instead of putting them in a store. CARv2 is not supported, and asking for it
+fails with `ErrUnsupportedCARVersion` before any deal is started.
+
+`RetrieveToPath` retrieves the whole UnixFS DAG of a payload into a store, and writes its files to a path on the
+filesystem as the blocks arrive, so there is no separate pass over the store to get the files out. A file is moved
+into place once all of its data is written. Files already in place are skipped, so retrieving to the same path again
+resumes an extraction that was interrupted.
+
+`RetrieveWithLocal` retrieves a payload into a store, but first looks for its blocks in a blockstore the caller
+already has. Blocks found there are copied into the store, and a deal is only started for the blocks still missing.
+For the whole DAG, the deal's selector is narrowed to the subtrees with missing blocks; any other selector is
+retrieved unchanged unless every block it selects is found locally.
+
+`RetrieveStreaming` retrieves a payload into a store, and passes each block to a subscription as it arrives, checked
+against its CID, so a consumer such as a media player can start before the deal completes. For a UnixFS file, each
+block carries the part of the file it holds and its offset. When the consumer falls behind, the transfer waits for it.
+
+Blocks retrieved into a store are checked against their CIDs and written to the store by a small pool of workers,
+so that fast transfers are not held up hashing and writing one block at a time. The number of workers, and how many
+received blocks may wait for one, are set with the `BlockWorkers` client option. The deal only completes once every
+received block is stored.
+
+A client configured with `CheckDiskSpace` checks that its volumes have room for a payload, at the size the provider
+quoted in its answer to `Query`, before retrieving it into a store, and fails the retrieval straight away if they do
+not. The spacecheck package's options reserve the space of retrievals in progress against later ones, and let the
+caller confirm a retrieval that does not fit, such as by prompting the user.
+
+Small payloads, such as directory manifests, can be fetched without a deal with `QueryInline`. The provider answers
+on the inline query protocol with the usual `QueryResponse`, plus the payload's CAR if it is no bigger than the limits
+set by the client and by the provider's `ServeInlinePayloads` option. The response is signed with the worker key of
+the miner, and the client checks the signature and that the CAR holds the payload's whole DAG before returning it.
+
+A provider's piece store can claim a payload whose sectors no longer hold it, so a client paying to unseal the payload
+would pay for nothing. `ChallengePossession` challenges the provider, on the possession protocol, to send the blocks on
+a number of paths from the payload's root down to a leaf, picked with a random nonce so they cannot be prepared ahead.
+The client checks the blocks hash to their CIDs and cover every path. A client configured with `ChallengeBeforeUnseal`
+challenges the provider before starting any deal with an unseal price, and does not start the deal unless the proof
+verifies. Providers answer challenges of up to the number of paths given to `AnswerPossessionChallenges`, reading the
+blocks from an unsealed copy of the piece at the locations in the piece store, and admit challenges like queries. A
+challenge of a payload with no unsealed copy is declined, as answering it would mean unsealing for free.
+
+Web clients and monitoring probes without a libp2p stack can query a provider over HTTP. A provider configured with
+`HTTPQueryGateway` listens on the given address while it is started, and `QueryHandler` returns the same handler for
+mounting on a node's own HTTP server. `GET /retrieval/query?payload=<CID>`, with an optional `piece=<CID>`, returns
+the `QueryResponse` the provider would send over libp2p, as JSON.
+
+A RetrievalClient configured with `VerifyRetrievedPieces` checks the data of deals that retrieve a whole piece into
+a store against the deal's PieceCID, by recomputing the CommP of the data before sending the last payment. The
+result is recorded in the `VerifiedAgainstPiece` field of the deal state, which gives an end to end check that the
+data is what was stored on chain. A deal whose data does not match fails without sending the last payment.
+
+Deals that stop making progress hold funds in their payment channel until they are cancelled. A RetrievalClient
+configured with `DefaultDealTimeouts`, or given limits for one deal with `SetDealTimeouts`, cancels a deal that runs
+for longer than its `MaxDuration` or receives no data for longer than its `MaxStall`. The limit that was exceeded is
+recorded in the `TimeoutReason` field of the deal state before the deal is cancelled.
+
+Deals retrieve part of a payload by giving a selector to `NewParamsV1`. The `shared/selectors` package builds the
+common ones: the entire DAG, a path in a UnixFS directory, a depth limited walk and a byte range of a UnixFS file.
+Its `Walk` lists the blocks a selector selects from a local DAG, and their total size, to estimate what a deal will
+transfer. Selectors that cannot be parsed are rejected by `NewParamsV1`, and by providers validating a deal.
+
+Clients that want a piece itself rather than the DAG inside it, such as repair services and aggregators, can
+retrieve a whole piece by its PieceCID with `RetrievePiece`, outside of a deal. The provider sends the piece's data
+as it was added to the sector, with fr32 padding, or just the CAR at the start of the piece. A RetrievalProvider
+serves whole pieces only when configured with `ServePieces`, which sets which pieces each client may retrieve, as
+pieces are sent without payment, and how many pieces are sent at once.
+
+A single RetrievalProvider can serve several miners, each added with `ServeMiner` along with its own piece store and
+node. Queries, deals and piece requests are routed to the miner whose piece store holds the data, answered with that
+miner's ask and paid to that miner's worker address. Each miner's ask is set with `SetMinerAsk`.
+
 The Retrieval provider receives the deal in `HandleDealStream`. `HandleDealStream` initiates tracking of deal state
 on the Provider side and hands the deal to the Provider FSM, which handles the rest of deal flow.
 
@@ -68,11 +140,114 @@ From this point forward, deal negotiation is completely asynchronous and runs in
 A user of the modules can monitor deal progress through `SubscribeToEvents` methods on RetrievalClient and RetrievalProvider,
 or by simply calling `ListDeals` to get all deal statuses.
 
+A RetrievalProvider delivers events to each subscriber on its own goroutine from a queue, so a slow subscriber cannot hold
+up deals. Queues grow as needed by default, so no events are lost. A provider can bound them with `EventQueue`, so that
+when a subscriber's queue is full, the oldest event is dropped or the subscriber is disconnected, and `EventStats`
+reports how many events were dropped.
+
+Each deal's state handlers run on the deal's own goroutine. A RetrievalProvider configured with `HandlerPool` runs at most
+a set number of handlers at once, starting waiting handlers in the order they arrived. A deal runs one handler at a
+time, so one slow deal holds at most one place and cannot starve the others. `HandlerStats` reports how many handlers
+are running, waiting and running for too long, for providers handling thousands of deals at once.
+
+`NewReadOnlyProvider` opens a RetrievalProvider over the datastore of a provider running in another process, to inspect its
+deal and ask state safely. It registers no network handlers, never runs migrations or deal state handlers, and returns
+`ErrReadOnly` from any operation that would change state.
+
+For health checks and alerting, `DealSummary` on the RetrievalProvider counts the deals in each state and reports
+the deal that has been in each state the longest, along with how many deals have been in their state for longer
+than expected.
+
+`Stats` on the RetrievalProvider reports rolling statistics of deal throughput over the last day: deals accepted per
+hour, deals completed and GiB of data served per day. A RetrievalProvider configured with `PersistStats` keeps the
+counts behind these statistics in a datastore, so that they survive restarts.
+
 The FSMs implement every remaining step in deal negotiation. Importantly, the RetrievalProvider delegates unsealing sectors
 back to the node via the `UnsealSector` method (the node itself likely delegates management of sectors and sealing to an
 implementation of the Storage Mining subsystem of the Filecoin spec). Sectors are unsealed on an as needed basis using
 the `PieceStore` to locate sectors that contain data related to the deal.
 
+A piece can also have a remote copy, such as on an HTTP server or in an S3 bucket, recorded with `SetRemoteLocation`
+on the `PieceStore`. When none of the piece's sectors can be unsealed, a RetrievalProvider configured with
+`RemotePieceFetcherOpt` reads the piece from its remote copy instead.
+
+Until a storage deal's sector is sealed, the storage provider still has the CAR it received for the deal in its
+filestore. When the storage and retrieval providers are given the same `stagedpieces.Registry` with their
+`StagedPieces` options, retrievals of the piece are served from that CAR rather than by unsealing, and the storage
+provider does not delete the CAR until the retrievals reading it have finished.
+
+By default every retrieval is quoted the flat unseal price in the ask. A RetrievalProvider configured with
+`UnsealPricing` instead works out the unseal price for each query and deal from its node's estimate of the work it
+takes to unseal the data, such as the sector's size and whether it is kept on hot or cold storage. The node provides
+these estimates by implementing `UnsealCostEstimator`. `MediumUnsealPricer` quotes nothing for data kept unsealed and a
+multiple of the ask's unseal price for sectors on cold archival storage.
+
+When the client's payment channel cannot cover a payment, other than the last one, the client pays with the funds
+it has and owes the rest with its next payment. A RetrievalProvider configured with `AllowDeferredPayments` keeps
+sending data when the rest is at most one payment interval's worth; otherwise it pauses until the rest is paid.
+
+On fast transfers, pausing for a payment after every interval can dominate the time a retrieval takes. A client
+can set `PaymentBatch` in its deal params to pay for several intervals with one voucher. A RetrievalProvider
+configured with `BatchPayments` then sends that many intervals of data before asking for payment, up to its limit
+on unpaid bytes. Providers from before payment batches are sent the proposal without the batch, and ask for each
+interval to be paid for separately.
+
+A client that leaves a deal while the provider is waiting for its payment, without ever completing the deal, has
+defaulted on it. `ListPaymentDefaults` on the RetrievalProvider reports how many times each client has defaulted,
+and a RetrievalProvider configured with `PersistPaymentDefaults` keeps these counts in a datastore. With
+`PenalizePaymentDefaults`, repeat defaulters must pay a deposit upfront, as part of the unseal price, or have their
+deals rejected.
+
+A RetrievalProvider keeps the record of every deal it has served unless it is configured with `DealRetention`. The
+retention policy removes the records of deals that completed, errored or were cancelled more than a maximum age ago,
+every hour and whenever `CompactDeals` is called, except for deals and clients on its exclusion lists. Removed records
+can be copied to an archive datastore first. The policy can be changed later through `Config.DealRetention`.
+
+Deal records are versioned and migrated to the current version when the client or provider starts. Before upgrading,
+`DryRunClientMigrations` and `DryRunProviderMigrations` in the migrations package report what each deal record would
+migrate to, and which would fail, without writing anything. A RetrievalProvider configured with `MigrationBackup`
+copies its datastore before migrating, and `Restore` in shared/migrationtools rolls the upgrade back from that copy.
+
+To move serving to a replacement instance or host, an operator stops the RetrievalProvider and calls its `ExportDeals`,
+which writes its in-flight deals, with the bytes sent and funds received for each and the blocks unsealed for them,
+and `ImportDeals` on the replacement. Imported deals keep their data transfer channel IDs, so partly paid retrievals
+carry on where they stopped once their clients restart the transfers, as long as the replacement has the same peer ID
+and the data transfer channel records.
+
+Retrieval deals are updated on every payment, and by default each update overwrites the whole deal record. A
+RetrievalClient started with `LogClientDeals`, or a RetrievalProvider started with `LogProviderDeals`, instead writes
+only the fields each update changed to a log kept through shared/eventstore, with a snapshot of the whole deal every so
+many updates. Records written without the option are read as they are until they are next updated, and a deal's log
+is removed when the deal is deleted, such as by `CompactDeals`. `History` and `ValueAt` on a datastore wrapped with
+`New` in shared/eventstore audit each deal's updates and show its state at any earlier point.
+
+A provider can sell priority retrieval by setting `PriorityMultiplier` on its ask. Query responses quote the
+resulting `PriorityPricePerByte`, and a client that sets `Priority` in its deal params and pays at least that price
+has its deal scheduled ahead of deals without priority. The provider's `TransferSlots` option limits how many deals
+unseal and send data at once. Deals beyond the limit wait in DealStatusTransferQueued, and free slots go to queued
+priority deals first. Peers that only speak version 1.0.0 of the query protocol are sent responses without a priority
+price, and a client does not fall back to the legacy proposal for a deal with priority, as it would pay the priority
+price without being given priority.
+
+Deals that read from the same sector can share one unseal pass. A provider configured with `UnsealBatching` holds
+each deal about to unseal for a short window, during which other deals on the same sector join it, and unseals the
+pieces they read, when close enough together in the sector, with one call to the node. The unsealed data is read once
+and handed out to each deal as it is read. `UnsealStats` reports how many unseals deals asked for and how many passes
+served them.
+
+In trusted or private networks, such as a private cluster or a CDN, retrieval can run without payment at all. A client
+that sets `PaymentDisabled` in its deal params, with zero prices, skips setting up a payment channel once the deal is
+accepted and never creates vouchers, and the provider never asks it for payment, so neither side touches the chain. A
+provider only accepts these deals from the peers the function passed to its `AllowPaymentDisabled` option trusts, and
+does not check their params against its ask. A client does not fall back to the legacy proposal for these deals, as a
+provider from before payment could be disabled would ask to be paid.
+
+A provider can add its own checks on the vouchers it receives, such as an enterprise authorization check, with the
+`ValidationPlugin` option. Each plugin implements the Plugin interface in the requestvalidation package and sees every
+deal proposal before the provider's own checks, and every payment before it is redeemed. Plugins run in the order
+they are added, the first to return an error rejects the voucher, and `ValidationPluginStats` reports how many
+vouchers each plugin checked and rejected, and the time it took.
+
 Major Dependencies
 
 Other libraries in go-fil-markets:
@@ -114,6 +114,41 @@ const (
 
 	// ClientEventCancel runs when a user cancels a deal
 	ClientEventCancel
+
+	// ClientEventFundsToppedUp means the client automatically requested more funds for the payment channel
+	// after running out
+	ClientEventFundsToppedUp
+
+	// ClientEventFundsTopUpFailed means the client could not automatically add funds to the payment channel
+	ClientEventFundsTopUpFailed
+
+	// ClientEventDataTransferUpdated happens when the data transfer for a deal changes
+	// status, and every so often while it makes progress
+	ClientEventDataTransferUpdated
+
+	// ClientEventPartialPaymentSent indicates the client paid as much of a payment as the
+	// funds in the payment channel allowed, deferring the rest to its next payment
+	ClientEventPartialPaymentSent
+
+	// ClientEventPieceVerified means the CommP of the retrieved data matched the piece
+	// CID of the deal
+	ClientEventPieceVerified
+
+	// ClientEventPieceNotVerified means the piece CID of the retrieved data could not
+	// be computed, so the data was not verified against the piece CID of the deal
+	ClientEventPieceNotVerified
+
+	// ClientEventTimedOut means the deal exceeded one of its DealTimeouts, and is
+	// followed by ClientEventCancel
+	ClientEventTimedOut
+
+	// ClientEventPaymentChannelSkipped means the deal has payment disabled, so the
+	// client goes straight to receiving data without setting up a payment channel
+	ClientEventPaymentChannelSkipped
+
+	// ClientEventPieceMismatch means the CommP of the retrieved data did not match the
+	// piece CID of the deal, so the deal fails without a last payment
+	ClientEventPieceMismatch
 )
 
 // ClientEvents is a human readable map of client event name -> event description
@@ -152,6 +187,15 @@ var ClientEvents = map[ClientEvent]string{
 	ClientEventVoucherShortfall:              "ClientEventVoucherShortfall",
 	ClientEventRecheckFunds:                  "ClientEventRecheckFunds",
 	ClientEventCancel:                        "ClientEventCancel",
+	ClientEventFundsToppedUp:                 "ClientEventFundsToppedUp",
+	ClientEventFundsTopUpFailed:              "ClientEventFundsTopUpFailed",
+	ClientEventDataTransferUpdated:           "ClientEventDataTransferUpdated",
+	ClientEventPartialPaymentSent:            "ClientEventPartialPaymentSent",
+	ClientEventPieceVerified:                 "ClientEventPieceVerified",
+	ClientEventPieceNotVerified:              "ClientEventPieceNotVerified",
+	ClientEventTimedOut:                      "ClientEventTimedOut",
+	ClientEventPaymentChannelSkipped:         "ClientEventPaymentChannelSkipped",
+	ClientEventPieceMismatch:                 "ClientEventPieceMismatch",
 }
 
 // ProviderEvent is an event that occurs in a deal lifecycle on the provider
@@ -219,6 +263,17 @@ const (
 
 	// ProviderEventClientCancelled happens when the provider gets a cancel message from the client's data transfer
 	ProviderEventClientCancelled
+
+	// ProviderEventDataTransferUpdated happens when the data transfer for a deal changes
+	// status, and every so often while it makes progress
+	ProviderEventDataTransferUpdated
+
+	// ProviderEventTransferQueued happens when a deal is ready to unseal but all of the
+	// provider's transfer slots are taken
+	ProviderEventTransferQueued
+
+	// ProviderEventTransferSlotOpened happens when a queued deal is given a transfer slot
+	ProviderEventTransferSlotOpened
 )
 
 // ProviderEvents is a human readable map of provider event name -> event description
@@ -241,4 +296,7 @@ var ProviderEvents = map[ProviderEvent]string{
 	ProviderEventCleanupComplete:        "ProviderEventCleanupComplete",
 	ProviderEventMultiStoreError:        "ProviderEventMultiStoreError",
 	ProviderEventClientCancelled:        "ProviderEventClientCancelled",
+	ProviderEventDataTransferUpdated:    "ProviderEventDataTransferUpdated",
+	ProviderEventTransferQueued:         "ProviderEventTransferQueued",
+	ProviderEventTransferSlotOpened:     "ProviderEventTransferSlotOpened",
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
 )
 
 // AskStoreImpl implements AskStore, persisting a retrieval Ask
@@ -63,6 +64,24 @@ func NewAskStore(ds datastore.Batching, key datastore.Key) (*AskStoreImpl, error
 	return s, nil
 }
 
+// LoadAskStore returns an instance of AskStoreImpl holding the ask last saved in ds,
+// for reading it without migrating ds or saving a default ask. GetAsk returns nil
+// if no ask was saved
+func LoadAskStore(ds datastore.Batching, key datastore.Key) (*AskStoreImpl, error) {
+	versionedDs, err := migrationtools.AtVersion(ds, versioning.VersionKey("1"))
+	if err != nil {
+		return nil, err
+	}
+	s := &AskStoreImpl{
+		ds:  versionedDs,
+		key: key,
+	}
+	if err := s.tryLoadAsk(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 // SetAsk stores retrieval provider's ask
 func (s *AskStoreImpl) SetAsk(ask *retrievalmarket.Ask) error {
 	s.lk.Lock()
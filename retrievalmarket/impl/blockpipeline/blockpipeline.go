@@ -0,0 +1,187 @@
+/*
+Package blockpipeline checks and stores the blocks received for a retrieval on
+several workers at once, rather than one block at a time.
+
+Graphsync hands each received block to the deal's storer and waits for it to be
+committed before moving on to the next block. For fast transfers, hashing each block
+and writing it to the store one after the other becomes the bottleneck. A Pipeline
+accepts a block as soon as it is received, and a bounded set of workers check that
+the block matches its CID and write it to the underlying store. When the workers fall
+behind, the queue fills and new blocks wait for room, so memory use stays bounded.
+
+Blocks that are queued but not yet stored are served from memory by the pipeline's
+loader, so a traversal that visits a block again still finds it. An error checking
+or storing a block is returned for the next block received, which fails the
+transfer, and by Close.
+*/
+package blockpipeline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"golang.org/x/xerrors"
+)
+
+// ErrClosed is returned when storing blocks on a closed Pipeline
+var ErrClosed = errors.New("block pipeline is closed")
+
+type block struct {
+	lnkCtx ipld.LinkContext
+	cid    cid.Cid
+	data   []byte
+}
+
+// Pipeline checks and stores received blocks on a bounded number of workers
+type Pipeline struct {
+	loader ipld.Loader
+	storer ipld.Storer
+
+	// sendLk is held to queue a block, and taken exclusively to close the queue
+	sendLk    sync.RWMutex
+	queue     chan block
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+
+	lk      sync.Mutex
+	pending map[cid.Cid][]byte
+	err     error
+	closed  bool
+}
+
+// New returns a pipeline that stores blocks with the given loader and storer, using
+// the given number of workers. At most queueSize blocks wait for a worker before
+// storing another block blocks
+func New(loader ipld.Loader, storer ipld.Storer, workers int, queueSize int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pipeline{
+		loader:  loader,
+		storer:  storer,
+		queue:   make(chan block, queueSize),
+		pending: make(map[cid.Cid][]byte),
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Storer returns an IPLD storer that queues each committed block to be checked and
+// stored
+func (p *Pipeline) Storer() ipld.Storer {
+	return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		var buf bytes.Buffer
+		var committer ipld.StoreCommitter = func(lnk ipld.Link) error {
+			c, ok := lnk.(cidlink.Link)
+			if !ok {
+				return xerrors.New("incorrect Link Type")
+			}
+			return p.put(block{lnkCtx: lnkCtx, cid: c.Cid, data: buf.Bytes()})
+		}
+		return &buf, committer, nil
+	}
+}
+
+// Loader returns an IPLD loader that finds blocks still waiting to be stored, as
+// well as those already in the underlying store
+func (p *Pipeline) Loader() ipld.Loader {
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		if c, ok := lnk.(cidlink.Link); ok {
+			p.lk.Lock()
+			data, pending := p.pending[c.Cid]
+			p.lk.Unlock()
+			if pending {
+				return bytes.NewReader(data), nil
+			}
+		}
+		return p.loader(lnk, lnkCtx)
+	}
+}
+
+// Close waits for the queued blocks to be stored, and returns the first error
+// checking or storing a block
+func (p *Pipeline) Close() error {
+	p.closeOnce.Do(func() {
+		p.lk.Lock()
+		p.closed = true
+		p.lk.Unlock()
+
+		p.sendLk.Lock()
+		close(p.queue)
+		p.sendLk.Unlock()
+	})
+	p.workers.Wait()
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.err
+}
+
+func (p *Pipeline) put(b block) error {
+	p.lk.Lock()
+	if p.closed {
+		p.lk.Unlock()
+		return ErrClosed
+	}
+	if p.err != nil {
+		err := p.err
+		p.lk.Unlock()
+		return err
+	}
+	if _, ok := p.pending[b.cid]; ok {
+		p.lk.Unlock()
+		return nil
+	}
+	p.pending[b.cid] = b.data
+	p.lk.Unlock()
+
+	p.sendLk.RLock()
+	defer p.sendLk.RUnlock()
+	p.queue <- b
+	return nil
+}
+
+func (p *Pipeline) work() {
+	defer p.workers.Done()
+	for b := range p.queue {
+		err := p.store(b)
+
+		p.lk.Lock()
+		delete(p.pending, b.cid)
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		p.lk.Unlock()
+	}
+}
+
+// store checks a block matches its CID, then writes it to the underlying store
+func (p *Pipeline) store(b block) error {
+	c, err := b.cid.Prefix().Sum(b.data)
+	if err != nil {
+		return xerrors.Errorf("hashing block %s: %w", b.cid, err)
+	}
+	if !c.Equals(b.cid) {
+		return xerrors.Errorf("block %s does not match its CID", b.cid)
+	}
+
+	w, commit, err := p.storer(b.lnkCtx)
+	if err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.cid, err)
+	}
+	if _, err := w.Write(b.data); err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.cid, err)
+	}
+	if err := commit(cidlink.Link{Cid: b.cid}); err != nil {
+		return xerrors.Errorf("storing block %s: %w", b.cid, err)
+	}
+	return nil
+}
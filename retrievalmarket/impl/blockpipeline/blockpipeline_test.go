@@ -0,0 +1,135 @@
+package blockpipeline_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/blockpipeline"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// memStore is a store whose writes can be held up until release is closed
+type memStore struct {
+	lk      sync.Mutex
+	blocks  map[cid.Cid][]byte
+	release chan struct{}
+}
+
+func newMemStore(held bool) *memStore {
+	release := make(chan struct{})
+	if !held {
+		close(release)
+	}
+	return &memStore{blocks: make(map[cid.Cid][]byte), release: release}
+}
+
+func (ms *memStore) loader(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+	ms.lk.Lock()
+	defer ms.lk.Unlock()
+	data, ok := ms.blocks[lnk.(cidlink.Link).Cid]
+	if !ok {
+		return nil, xerrors.New("not found")
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (ms *memStore) storer(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+	var buf bytes.Buffer
+	return &buf, func(lnk ipld.Link) error {
+		<-ms.release
+		ms.lk.Lock()
+		defer ms.lk.Unlock()
+		ms.blocks[lnk.(cidlink.Link).Cid] = buf.Bytes()
+		return nil
+	}, nil
+}
+
+func TestPipeline(t *testing.T) {
+	testData := tut.NewTestIPLDTree()
+	var all []cid.Cid
+	for lnk := range testData.Storage {
+		all = append(all, lnk.(cidlink.Link).Cid)
+	}
+
+	store := func(t *testing.T, p *blockpipeline.Pipeline, c cid.Cid, data []byte) error {
+		buf, commit, err := p.Storer()(ipld.LinkContext{})
+		require.NoError(t, err)
+		_, err = buf.Write(data)
+		require.NoError(t, err)
+		return commit(cidlink.Link{Cid: c})
+	}
+
+	load := func(t *testing.T, p *blockpipeline.Pipeline, c cid.Cid) []byte {
+		r, err := p.Loader()(cidlink.Link{Cid: c}, ipld.LinkContext{})
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("stores every block", func(t *testing.T) {
+		ms := newMemStore(false)
+		p := blockpipeline.New(ms.loader, ms.storer, 4, 2)
+		for _, c := range all {
+			block, err := testData.Get(c)
+			require.NoError(t, err)
+			require.NoError(t, store(t, p, c, block.RawData()))
+		}
+		require.NoError(t, p.Close())
+		require.Len(t, ms.blocks, len(all))
+		for _, c := range all {
+			block, err := testData.Get(c)
+			require.NoError(t, err)
+			require.Equal(t, block.RawData(), ms.blocks[c])
+			require.Equal(t, block.RawData(), load(t, p, c))
+		}
+	})
+
+	t.Run("loads blocks waiting to be stored", func(t *testing.T) {
+		ms := newMemStore(true)
+		p := blockpipeline.New(ms.loader, ms.storer, 1, 1)
+		block, err := testData.Get(all[0])
+		require.NoError(t, err)
+		require.NoError(t, store(t, p, all[0], block.RawData()))
+		require.Equal(t, block.RawData(), load(t, p, all[0]))
+
+		close(ms.release)
+		require.NoError(t, p.Close())
+		require.Equal(t, block.RawData(), ms.blocks[all[0]])
+	})
+
+	t.Run("fails on a block that does not match its CID", func(t *testing.T) {
+		ms := newMemStore(false)
+		p := blockpipeline.New(ms.loader, ms.storer, 2, 0)
+		require.NoError(t, store(t, p, all[0], []byte("not the block")))
+		require.Error(t, p.Close())
+		require.Empty(t, ms.blocks)
+
+		block, err := testData.Get(all[1])
+		require.NoError(t, err)
+		require.EqualError(t, store(t, p, all[1], block.RawData()), blockpipeline.ErrClosed.Error())
+	})
+
+	t.Run("returns errors for the next block", func(t *testing.T) {
+		ms := newMemStore(false)
+		p := blockpipeline.New(ms.loader, ms.storer, 1, 0)
+		require.NoError(t, store(t, p, all[0], []byte("not the block")))
+		block, err := testData.Get(all[1])
+		require.NoError(t, err)
+		// once the bad block has been checked, later blocks fail
+		require.Eventually(t, func() bool {
+			return store(t, p, all[1], block.RawData()) != nil
+		}, time.Second, time.Millisecond)
+		require.Error(t, p.Close())
+	})
+}
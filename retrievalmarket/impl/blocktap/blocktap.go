@@ -0,0 +1,294 @@
+/*
+Package blocktap passes the blocks of a retrieval to a consumer as they arrive, so
+that the consumer, such as a media player, can start reading before the deal
+completes.
+
+A Tap wraps the store a deal's blocks go to. Each block received is checked against
+its CID and stored, then sent to the consumer. Blocks the traversal visits without
+receiving them, because they are already in the store or were visited before, are
+loaded from the store and sent too, so the consumer sees every block in traversal
+order. When the consumer falls behind and the Tap's buffer is full, sending waits,
+and with it the transfer, until the consumer catches up or cancels.
+
+If the payload is a UnixFS file, each block is sent with the part of the file it
+holds and that part's offset in the file. Offsets follow from the sizes of the
+children each file node lists, so they are right for selectors that visit only part
+of the file, such as those from selectors.FileRange.
+*/
+package blocktap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	unixfspb "github.com/ipfs/go-unixfs/pb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// ErrClosed is returned when storing or loading blocks on a closed Tap
+var ErrClosed = errors.New("block tap is closed")
+
+// expected is a block of a UnixFS file the traversal may visit next, and the offset
+// of its data in the file
+type expected struct {
+	c      cid.Cid
+	offset uint64
+}
+
+// Tap stores the blocks of a retrieval and sends them to a consumer
+type Tap struct {
+	root   cid.Cid
+	loader ipld.Loader
+	storer ipld.Storer
+	blocks chan retrievalmarket.ReceivedBlock
+
+	// sendLk is held to send a block, and taken exclusively to close the channel
+	sendLk       sync.RWMutex
+	blocksClosed bool
+	done         chan struct{}
+	doneOnce     sync.Once
+	cancelled    chan struct{}
+	cancelOnce   sync.Once
+
+	lk     sync.Mutex
+	err    error
+	closed bool
+	isFile bool
+	stack  []expected
+}
+
+var _ retrievalmarket.BlockSubscription = (*Tap)(nil)
+
+// New returns a Tap for the DAG under root that stores and loads blocks with the
+// given storer and loader. At most bufferSize blocks wait for the consumer before
+// sending another block waits
+func New(root cid.Cid, loader ipld.Loader, storer ipld.Storer, bufferSize int) *Tap {
+	return &Tap{
+		root:      root,
+		loader:    loader,
+		storer:    storer,
+		blocks:    make(chan retrievalmarket.ReceivedBlock, bufferSize),
+		done:      make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+// Storer returns an IPLD storer that checks and stores each block, then sends it
+func (t *Tap) Storer() ipld.Storer {
+	return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		var buf bytes.Buffer
+		var committer ipld.StoreCommitter = func(lnk ipld.Link) error {
+			c, ok := lnk.(cidlink.Link)
+			if !ok {
+				return xerrors.New("incorrect Link Type")
+			}
+			if err := t.store(lnkCtx, c.Cid, buf.Bytes()); err != nil {
+				return t.fail(err)
+			}
+			return t.visit(c.Cid, buf.Bytes())
+		}
+		return &buf, committer, nil
+	}
+}
+
+// Loader returns an IPLD loader that loads blocks from the store, and sends each
+// block it loads, as the traversal visits blocks it already has this way
+func (t *Tap) Loader() ipld.Loader {
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		c, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, xerrors.New("incorrect Link Type")
+		}
+		r, err := t.loader(lnk, lnkCtx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.visit(c.Cid, data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+}
+
+// Blocks returns the channel blocks are sent on
+func (t *Tap) Blocks() <-chan retrievalmarket.ReceivedBlock {
+	return t.blocks
+}
+
+// Err returns the error checking or storing a block that stopped the Tap
+func (t *Tap) Err() error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.err
+}
+
+// Cancel stops sending blocks to the consumer and closes the channel blocks are sent
+// on. The deal carries on, and its blocks are still stored
+func (t *Tap) Cancel() {
+	t.cancelOnce.Do(func() {
+		close(t.cancelled)
+		t.closeBlocks()
+	})
+}
+
+// Close stops the Tap accepting blocks and closes the channel blocks are sent on. It
+// returns the error checking or storing a block that stopped the Tap, if any
+func (t *Tap) Close() error {
+	t.doneOnce.Do(func() {
+		t.lk.Lock()
+		t.closed = true
+		t.lk.Unlock()
+
+		close(t.done)
+		t.closeBlocks()
+	})
+	return t.Err()
+}
+
+// closeBlocks closes the channel blocks are sent on, once any send waiting on the
+// consumer has given up
+func (t *Tap) closeBlocks() {
+	t.sendLk.Lock()
+	defer t.sendLk.Unlock()
+	if !t.blocksClosed {
+		t.blocksClosed = true
+		close(t.blocks)
+	}
+}
+
+// store checks a block matches its CID, then writes it to the underlying store
+func (t *Tap) store(lnkCtx ipld.LinkContext, c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return xerrors.Errorf("hashing block %s: %w", c, err)
+	}
+	if !sum.Equals(c) {
+		return xerrors.Errorf("block %s does not match its CID", c)
+	}
+	w, commit, err := t.storer(lnkCtx)
+	if err != nil {
+		return xerrors.Errorf("storing block %s: %w", c, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return xerrors.Errorf("storing block %s: %w", c, err)
+	}
+	if err := commit(cidlink.Link{Cid: c}); err != nil {
+		return xerrors.Errorf("storing block %s: %w", c, err)
+	}
+	return nil
+}
+
+func (t *Tap) fail(err error) error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+// visit sends a block the traversal visited to the consumer, along with the file
+// data it holds
+func (t *Tap) visit(c cid.Cid, data []byte) error {
+	t.lk.Lock()
+	if t.closed {
+		t.lk.Unlock()
+		return ErrClosed
+	}
+	if t.err != nil {
+		err := t.err
+		t.lk.Unlock()
+		return err
+	}
+	block := retrievalmarket.ReceivedBlock{Cid: c, Data: data}
+	fileData, offset, ok := t.fileData(c, data)
+	if ok {
+		block.FileData = fileData
+		block.FileOffset = offset
+	}
+	t.lk.Unlock()
+
+	t.sendLk.RLock()
+	defer t.sendLk.RUnlock()
+	if t.blocksClosed {
+		return nil
+	}
+	select {
+	case t.blocks <- block:
+	case <-t.cancelled:
+	case <-t.done:
+	}
+	return nil
+}
+
+// fileData returns the part of a UnixFS file a block holds and its offset, following
+// the traversal with a stack of the blocks it may visit next. It returns false if
+// the payload is not a UnixFS file, or the block is not part of it
+func (t *Tap) fileData(c cid.Cid, data []byte) ([]byte, uint64, bool) {
+	if c.Equals(t.root) {
+		// a traversal that starts again from the root, such as a restarted
+		// transfer, visits the file from the start again
+		t.isFile = true
+		t.stack = []expected{{c: c}}
+	}
+	if !t.isFile {
+		return nil, 0, false
+	}
+	// blocks the selector does not visit are passed over
+	for len(t.stack) > 0 && !t.stack[len(t.stack)-1].c.Equals(c) {
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+	if len(t.stack) == 0 {
+		return nil, 0, false
+	}
+	next := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return data, next.offset, true
+	case cid.DagProtobuf:
+		nd, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			t.isFile = false
+			return nil, 0, false
+		}
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil || (fsn.Type() != unixfspb.Data_File && fsn.Type() != unixfspb.Data_Raw) {
+			t.isFile = false
+			return nil, 0, false
+		}
+		links := nd.Links()
+		if len(links) != fsn.NumChildren() {
+			t.isFile = false
+			return nil, 0, false
+		}
+		offsets := make([]uint64, len(links))
+		offset := next.offset + uint64(len(fsn.Data()))
+		for i := range links {
+			offsets[i] = offset
+			offset += fsn.BlockSize(i)
+		}
+		for i := len(links) - 1; i >= 0; i-- {
+			t.stack = append(t.stack, expected{c: links[i].Cid, offset: offsets[i]})
+		}
+		return fsn.Data(), next.offset, true
+	default:
+		t.isFile = false
+		return nil, 0, false
+	}
+}
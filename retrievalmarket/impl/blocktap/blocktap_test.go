@@ -0,0 +1,158 @@
+package blocktap_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/blocktap"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+type memStore map[cid.Cid][]byte
+
+func (m memStore) loader(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+	data, ok := m[lnk.(cidlink.Link).Cid]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (m memStore) storer(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+	var buf bytes.Buffer
+	return &buf, func(lnk ipld.Link) error {
+		m[lnk.(cidlink.Link).Cid] = buf.Bytes()
+		return nil
+	}, nil
+}
+
+func importFile(t *testing.T, dag ipldformat.DAGService, data []byte) ipldformat.Node {
+	params := helpers.DagBuilderParams{
+		Maxlinks:  2,
+		RawLeaves: true,
+		Dagserv:   dag,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+	return nd
+}
+
+// traverse stores the blocks of a DAG in the tap depth first, like graphsync
+func traverse(t *testing.T, dag ipldformat.DAGService, tap *blocktap.Tap, root cid.Cid) error {
+	nd, err := dag.Get(context.Background(), root)
+	require.NoError(t, err)
+	w, commit, err := tap.Storer()(ipld.LinkContext{})
+	require.NoError(t, err)
+	_, err = w.Write(nd.RawData())
+	require.NoError(t, err)
+	if err := commit(cidlink.Link{Cid: root}); err != nil {
+		return err
+	}
+	for _, link := range nd.Links() {
+		if err := traverse(t, dag, tap, link.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countBlocks(t *testing.T, dag ipldformat.DAGService, root cid.Cid) int {
+	nd, err := dag.Get(context.Background(), root)
+	require.NoError(t, err)
+	count := 1
+	for _, link := range nd.Links() {
+		count += countBlocks(t, dag, link.Cid)
+	}
+	return count
+}
+
+func TestTap(t *testing.T) {
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	data := tut.RandomBytes(1500)
+	file := importFile(t, dag, data)
+
+	t.Run("sends each block with its file data as it is stored", func(t *testing.T) {
+		store := memStore{}
+		tap := blocktap.New(file.Cid(), store.loader, store.storer, 1)
+		errChan := make(chan error, 1)
+		go func() {
+			err := traverse(t, dag, tap, file.Cid())
+			_ = tap.Close()
+			errChan <- err
+		}()
+
+		read := make([]byte, len(data))
+		var blocks int
+		for block := range tap.Blocks() {
+			blocks++
+			nd, err := dag.Get(context.Background(), block.Cid)
+			require.NoError(t, err)
+			require.Equal(t, nd.RawData(), block.Data)
+			copy(read[block.FileOffset:], block.FileData)
+		}
+		require.NoError(t, <-errChan)
+		require.NoError(t, tap.Err())
+		require.Equal(t, countBlocks(t, dag, file.Cid()), blocks)
+		require.Equal(t, data, read)
+	})
+
+	t.Run("holds up the traversal until the consumer reads or cancels", func(t *testing.T) {
+		store := memStore{}
+		tap := blocktap.New(file.Cid(), store.loader, store.storer, 1)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- traverse(t, dag, tap, file.Cid())
+		}()
+
+		select {
+		case <-errChan:
+			t.Fatal("traversal finished with the consumer behind")
+		case <-time.After(100 * time.Millisecond):
+		}
+		<-tap.Blocks()
+		tap.Cancel()
+		require.NoError(t, <-errChan)
+		for range tap.Blocks() {
+		}
+		require.NoError(t, tap.Close())
+
+		// blocks are still stored once the subscription is cancelled
+		_, err := store.loader(cidlink.Link{Cid: file.Cid()}, ipld.LinkContext{})
+		require.NoError(t, err)
+		require.Len(t, store, countBlocks(t, dag, file.Cid()))
+	})
+
+	t.Run("rejects a block that does not match its CID", func(t *testing.T) {
+		store := memStore{}
+		tap := blocktap.New(file.Cid(), store.loader, store.storer, 1)
+		w, commit, err := tap.Storer()(ipld.LinkContext{})
+		require.NoError(t, err)
+		_, err = w.Write([]byte("not the file"))
+		require.NoError(t, err)
+		require.Error(t, commit(cidlink.Link{Cid: file.Cid()}))
+		require.Empty(t, store)
+		require.Error(t, tap.Close())
+	})
+}
@@ -0,0 +1,126 @@
+package retrievalimpl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+)
+
+// BlockVerificationResult is the outcome of the last check of a miner's block
+// locations
+type BlockVerificationResult struct {
+	Miner    address.Address
+	Finished time.Time
+	Report   piecestore.BlockVerifyReport
+	// Err is set if the check stopped before it finished
+	Err string
+}
+
+type blockVerifier struct {
+	interval time.Duration
+	opts     piecestore.BlockVerifyOptions
+
+	lk      sync.Mutex
+	results map[address.Address]BlockVerificationResult
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// BlockVerification makes the provider check a sample of the block locations in each
+// miner's piece store every interval, by reading the pieces they point into back
+// from the miner's unsealed sectors and checking the blocks' data still hashes to
+// their CIDs. Corrupted locations are logged, and repaired if opts.Repair is set, so
+// that they are fixed before retrievals of them fail. LastBlockVerifications reports
+// the outcome of the last check. It must be passed to NewProvider
+func BlockVerification(interval time.Duration, opts piecestore.BlockVerifyOptions) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.blockVerifier = &blockVerifier{
+			interval: interval,
+			opts:     opts,
+			results:  make(map[address.Address]BlockVerificationResult),
+		}
+	}
+}
+
+// LastBlockVerifications returns the outcome of the last check of each miner's block
+// locations, or nil if the provider does not check them
+func (p *Provider) LastBlockVerifications() []BlockVerificationResult {
+	bv := p.blockVerifier
+	if bv == nil {
+		return nil
+	}
+	bv.lk.Lock()
+	defer bv.lk.Unlock()
+	results := make([]BlockVerificationResult, 0, len(bv.results))
+	for _, miner := range p.miners {
+		if result, ok := bv.results[miner.address]; ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// startBlockVerification checks the block locations every interval until
+// stopBlockVerification is called. The checks outlive the context the provider was
+// started with, which may only cover starting up
+func (p *Provider) startBlockVerification() {
+	bv := p.blockVerifier
+	if bv == nil || bv.interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bv.cancel = cancel
+	bv.done = make(chan struct{})
+	go func() {
+		defer close(bv.done)
+		ticker := time.NewTicker(bv.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.verifyBlockLocations(ctx)
+			}
+		}
+	}()
+}
+
+// stopBlockVerification stops the periodic checks, interrupting one that is running
+func (p *Provider) stopBlockVerification() {
+	bv := p.blockVerifier
+	if bv == nil || bv.cancel == nil {
+		return
+	}
+	bv.cancel()
+	<-bv.done
+	bv.cancel = nil
+}
+
+// verifyBlockLocations checks a sample of each miner's block locations once
+func (p *Provider) verifyBlockLocations(ctx context.Context) {
+	bv := p.blockVerifier
+	for _, miner := range p.miners {
+		report, err := piecestore.VerifyBlockLocations(ctx, miner.pieceStore, miner.node, bv.opts)
+		result := BlockVerificationResult{Miner: miner.address, Finished: time.Now(), Report: report}
+		if err != nil {
+			log.Errorf("verifying block locations for miner %s: %s", miner.address, err)
+			result.Err = err.Error()
+		}
+		for _, problem := range report.Problems {
+			log.Warnf("block %s in piece %s of miner %s: %s: %s", problem.CID, problem.PieceCID, miner.address,
+				piecestore.BlockProblemKinds[problem.Kind], problem.Message)
+		}
+		for _, pieceCID := range report.PiecesRepaired {
+			log.Infof("repaired block locations for piece %s of miner %s", pieceCID, miner.address)
+		}
+
+		bv.lk.Lock()
+		bv.results[miner.address] = result
+		bv.lk.Unlock()
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Package carstream writes the blocks received for a retrieval straight into a CARv1
+stream, rather than into a blockstore.
+
+Graphsync stores each block once, in the order the selector traversal visits it,
+so the stream is deterministic: the same DAG and selector always produce the same
+CAR bytes, identical to a car.SelectiveCar written from a local copy of the DAG.
+
+A traversal may visit the same block more than once, and the second visit loads
+the block back from what was already received. If the destination is also an
+io.ReaderAt, such as an *os.File, those blocks are read back from the destination.
+Otherwise the data of received blocks is kept in memory until the Writer is closed.
+
+Only CARv1 streams are written. CARv2 adds an index after the data, which cannot be
+written until the whole DAG has arrived, so a Writer refuses any other version.
+*/
+package carstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// ErrClosed is returned when storing or loading blocks on a closed Writer
+var ErrClosed = errors.New("car stream is closed")
+
+type location struct {
+	offset int64
+	size   int
+}
+
+// Writer writes received blocks to a CARv1 stream in the order they are stored
+type Writer struct {
+	lk       sync.Mutex
+	out      *countingWriter
+	readerAt io.ReaderAt
+	header   bool
+	root     cid.Cid
+	offsets  map[cid.Cid]location
+	blocks   map[cid.Cid][]byte
+	closed   bool
+}
+
+// NewWriter returns a Writer that streams a CAR of the given version with the given
+// root to out. The header is written along with the first block. Only version 1 is
+// supported; any other version returns retrievalmarket.ErrUnsupportedCARVersion
+func NewWriter(out io.Writer, root cid.Cid, version uint64) (*Writer, error) {
+	if version != 1 {
+		return nil, xerrors.Errorf("writing CARv%d: %w", version, retrievalmarket.ErrUnsupportedCARVersion)
+	}
+	w := &Writer{
+		out:     &countingWriter{w: out},
+		root:    root,
+		offsets: make(map[cid.Cid]location),
+	}
+	if readerAt, ok := out.(io.ReaderAt); ok {
+		w.readerAt = readerAt
+	} else {
+		w.blocks = make(map[cid.Cid][]byte)
+	}
+	return w, nil
+}
+
+// Storer returns an IPLD storer that appends each committed block to the stream
+func (w *Writer) Storer() ipld.Storer {
+	return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		var buf bytes.Buffer
+		var committer ipld.StoreCommitter = func(lnk ipld.Link) error {
+			c, ok := lnk.(cidlink.Link)
+			if !ok {
+				return xerrors.New("incorrect Link Type")
+			}
+			return w.put(c.Cid, buf.Bytes())
+		}
+		return &buf, committer, nil
+	}
+}
+
+// Loader returns an IPLD loader for the blocks already written to the stream
+func (w *Writer) Loader() ipld.Loader {
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		c, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, xerrors.New("incorrect Link Type")
+		}
+		data, err := w.get(c.Cid)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+}
+
+// Close stops the Writer accepting blocks and releases blocks held in memory.
+// It does not close the destination
+func (w *Writer) Close() error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	w.closed = true
+	w.offsets = nil
+	w.blocks = nil
+	return nil
+}
+
+func (w *Writer) put(c cid.Cid, data []byte) error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if _, ok := w.offsets[c]; ok {
+		return nil
+	}
+	if !w.header {
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{w.root}, Version: 1}, w.out); err != nil {
+			return xerrors.Errorf("writing car header: %w", err)
+		}
+		w.header = true
+	}
+	if err := util.LdWrite(w.out, c.Bytes(), data); err != nil {
+		return xerrors.Errorf("writing block %s: %w", c, err)
+	}
+	w.offsets[c] = location{offset: w.out.written - int64(len(data)), size: len(data)}
+	if w.blocks != nil {
+		w.blocks[c] = append([]byte(nil), data...)
+	}
+	return nil
+}
+
+func (w *Writer) get(c cid.Cid) ([]byte, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if w.closed {
+		return nil, ErrClosed
+	}
+	loc, ok := w.offsets[c]
+	if !ok {
+		return nil, xerrors.Errorf("block %s not found", c)
+	}
+	if w.blocks != nil {
+		return w.blocks[c], nil
+	}
+	data := make([]byte, loc.size)
+	if _, err := w.readerAt.ReadAt(data, loc.offset); err != nil {
+		return nil, xerrors.Errorf("reading block %s back from car stream: %w", c, err)
+	}
+	return data, nil
+}
+
+// countingWriter tracks the offset of the next byte written to the destination
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,109 @@
+package carstream_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/carstream"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestWriter(t *testing.T) {
+	testData := tut.NewTestIPLDTree()
+	root := testData.RootNodeLnk.(cidlink.Link).Cid
+
+	// the blocks of the tree in traversal order, as written by a selective car
+	var expected bytes.Buffer
+	var order []cid.Cid
+	err := testData.DumpToCar(&expected, func(block car.Block) error {
+		order = append(order, block.BlockCID)
+		return nil
+	})
+	require.NoError(t, err)
+
+	store := func(t *testing.T, w *carstream.Writer, c cid.Cid) {
+		data, err := testData.Get(c)
+		require.NoError(t, err)
+		buf, commit, err := w.Storer()(ipld.LinkContext{})
+		require.NoError(t, err)
+		_, err = buf.Write(data.RawData())
+		require.NoError(t, err)
+		require.NoError(t, commit(cidlink.Link{Cid: c}))
+	}
+
+	load := func(t *testing.T, w *carstream.Writer, c cid.Cid) []byte {
+		r, err := w.Loader()(cidlink.Link{Cid: c}, ipld.LinkContext{})
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("streams to a writer", func(t *testing.T) {
+		var out bytes.Buffer
+		w, err := carstream.NewWriter(&out, root, 1)
+		require.NoError(t, err)
+		for _, c := range order {
+			store(t, w, c)
+		}
+		// blocks visited again are not written twice
+		store(t, w, order[0])
+		require.Equal(t, expected.Bytes(), out.Bytes())
+
+		for _, c := range order {
+			block, err := testData.Get(c)
+			require.NoError(t, err)
+			require.Equal(t, block.RawData(), load(t, w, c))
+		}
+
+		require.NoError(t, w.Close())
+		_, err = w.Loader()(cidlink.Link{Cid: root}, ipld.LinkContext{})
+		require.EqualError(t, err, carstream.ErrClosed.Error())
+	})
+
+	t.Run("streams to a file", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "retrieval-*.car")
+		require.NoError(t, err)
+		path := file.Name()
+		defer os.Remove(path)
+		defer file.Close()
+
+		w, err := carstream.NewWriter(file, root, 1)
+		require.NoError(t, err)
+		for _, c := range order {
+			store(t, w, c)
+		}
+		for _, c := range order {
+			block, err := testData.Get(c)
+			require.NoError(t, err)
+			require.Equal(t, block.RawData(), load(t, w, c))
+		}
+		require.NoError(t, w.Close())
+
+		written, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, expected.Bytes(), written)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := carstream.NewWriter(&bytes.Buffer{}, root, 2)
+		require.True(t, xerrors.Is(err, retrievalmarket.ErrUnsupportedCARVersion))
+	})
+
+	t.Run("missing block", func(t *testing.T) {
+		w, err := carstream.NewWriter(&bytes.Buffer{}, root, 1)
+		require.NoError(t, err)
+		_, err = w.Loader()(cidlink.Link{Cid: root}, ipld.LinkContext{})
+		require.EqualError(t, err, "block "+root.String()+" not found")
+	})
+}
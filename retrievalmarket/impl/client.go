@@ -2,12 +2,16 @@ package retrievalimpl
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"io"
+	"sync"
 
 	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 
@@ -21,17 +25,29 @@ import (
 	"github.com/filecoin-project/go-statemachine/fsm"
 	"github.com/filecoin-project/go-storedcounter"
 
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
 	"github.com/filecoin-project/go-fil-markets/discovery"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/blockpipeline"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/blocktap"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/carstream"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/clientstates"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/dtutils"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/possession"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/spacecheck"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/unixfsextract"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
 	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 )
 
 var log = logging.Logger("retrieval")
 
+// RetrievalClientOption is a function that configures a retrieval client
+type RetrievalClientOption func(c *Client)
+
 // Client is the production implementation of the RetrievalClient interface
 type Client struct {
 	network       rmnet.RetrievalMarketNetwork
@@ -45,6 +61,36 @@ type Client struct {
 	resolver             discovery.PeerResolver
 	stateMachines        fsm.Group
 	migrateStateMachines func(context.Context) error
+	fundsTopUpLimit      abi.TokenAmount
+	verifyPieces         bool
+	pieceProofType       abi.RegisteredSealProof
+
+	streamsLk sync.Mutex
+	streams   map[retrievalmarket.DealID]blockStream
+
+	blockWorkers   int
+	blockQueueSize int
+	pipelinesLk    sync.Mutex
+	pipelines      map[retrievalmarket.DealID]*blockpipeline.Pipeline
+
+	dealTimeouts retrievalmarket.DealTimeouts
+	timersLk     sync.Mutex
+	timers       map[retrievalmarket.DealID]*dealTimer
+
+	spaceChecker *spacecheck.Checker
+	logDeals     func(datastore.Batching) datastore.Batching
+	quotesLk     sync.Mutex
+	quotes       map[quoteKey]uint64
+	quoteOrder   []quoteKey
+
+	challengePaths uint64
+}
+
+// blockStream takes the blocks received for a deal in place of the deal's store
+type blockStream interface {
+	Loader() ipld.Loader
+	Storer() ipld.Storer
+	Close() error
 }
 
 type internalEvent struct {
@@ -67,6 +113,46 @@ func dispatcher(evt pubsub.Event, subscriberFn pubsub.SubscriberFn) error {
 
 var _ retrievalmarket.RetrievalClient = &Client{}
 
+// AutoTopUpFunds makes the client add funds to a deal's payment channel itself when the
+// deal runs out, instead of waiting for a call to TryRestartInsufficientFunds. At most limit
+// is added for any one deal
+func AutoTopUpFunds(limit abi.TokenAmount) RetrievalClientOption {
+	return func(c *Client) {
+		c.fundsTopUpLimit = limit
+	}
+}
+
+// DefaultBlockWorkers is the number of workers that check and store the blocks
+// received for a deal
+const DefaultBlockWorkers = 4
+
+// DefaultBlockQueueSize is the number of received blocks that wait for a worker before
+// the transfer is held up
+const DefaultBlockQueueSize = 64
+
+// BlockWorkers sets how many workers check and store the blocks received for each
+// deal retrieved into a store, and how many received blocks wait for a worker before
+// the transfer is held up. With zero workers, blocks are checked and stored one at a
+// time as they arrive
+func BlockWorkers(workers int, queueSize int) RetrievalClientOption {
+	return func(c *Client) {
+		c.blockWorkers = workers
+		c.blockQueueSize = queueSize
+	}
+}
+
+// ChallengeBeforeUnseal makes the client challenge the provider to prove it holds a
+// payload, on the given number of paths through the payload's DAG, before it starts
+// a deal with an unseal price. The deal is not started if the provider cannot prove
+// it holds the payload. Providers only answer from copies that are already unsealed,
+// so a provider that would have to unseal to answer declines, and the deal is not
+// started either
+func ChallengeBeforeUnseal(paths uint64) RetrievalClientOption {
+	return func(c *Client) {
+		c.challengePaths = paths
+	}
+}
+
 // NewClient creates a new retrieval client
 func NewClient(
 	network rmnet.RetrievalMarketNetwork,
@@ -76,22 +162,32 @@ func NewClient(
 	resolver discovery.PeerResolver,
 	ds datastore.Batching,
 	storedCounter *storedcounter.StoredCounter,
+	opts ...RetrievalClientOption,
 ) (retrievalmarket.RetrievalClient, error) {
 	c := &Client{
-		network:       network,
-		multiStore:    multiStore,
-		dataTransfer:  dataTransfer,
-		node:          node,
-		resolver:      resolver,
-		storedCounter: storedCounter,
-		subscribers:   pubsub.New(dispatcher),
-		readySub:      pubsub.New(shared.ReadyDispatcher),
+		network:         network,
+		multiStore:      multiStore,
+		dataTransfer:    dataTransfer,
+		node:            node,
+		resolver:        resolver,
+		storedCounter:   storedCounter,
+		subscribers:     pubsub.New(dispatcher),
+		readySub:        pubsub.New(shared.ReadyDispatcher),
+		fundsTopUpLimit: big.Zero(),
+		streams:         make(map[retrievalmarket.DealID]blockStream),
+		blockWorkers:    DefaultBlockWorkers,
+		blockQueueSize:  DefaultBlockQueueSize,
+		pipelines:       make(map[retrievalmarket.DealID]*blockpipeline.Pipeline),
+		timers:          make(map[retrievalmarket.DealID]*dealTimer),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 	retrievalMigrations, err := migrations.ClientMigrations.Build()
 	if err != nil {
 		return nil, err
 	}
-	c.stateMachines, c.migrateStateMachines, err = versionedfsm.NewVersionedFSM(ds, fsm.Parameters{
+	c.stateMachines, c.migrateStateMachines, err = versionedfsm.NewVersionedFSM(dealsDatastore(ds, c.logDeals), fsm.Parameters{
 		Environment:     &clientDealEnvironment{c},
 		StateType:       retrievalmarket.ClientDealState{},
 		StateKeyField:   "Status",
@@ -127,7 +223,7 @@ func NewClient(
 	if err != nil {
 		return nil, err
 	}
-	dataTransfer.SubscribeToEvents(dtutils.ClientDataTransferSubscriber(c.stateMachines))
+	dataTransfer.SubscribeToEvents(dtutils.ClientDataTransferSubscriber(&pipelineFlusher{c}))
 	transportConfigurer := dtutils.TransportConfigurer(network.ID(), &clientStoreGetter{c})
 	err = dataTransfer.RegisterTransportConfigurer(&retrievalmarket.DealProposal{}, transportConfigurer)
 	if err != nil {
@@ -200,7 +296,137 @@ func (c *Client) Query(ctx context.Context, p retrievalmarket.RetrievalPeer, pay
 		return retrievalmarket.QueryResponseUndefined, err
 	}
 
-	return s.ReadQueryResponse()
+	resp, err := s.ReadQueryResponse()
+	if err != nil {
+		return retrievalmarket.QueryResponseUndefined, err
+	}
+	c.recordQuote(p.ID, payloadCID, resp)
+	return resp, nil
+}
+
+// QueryInline asks a provider about a payload, and for the payload to be sent with the
+// response if it is no bigger than maxSize bytes
+func (c *Client) QueryInline(ctx context.Context, p retrievalmarket.RetrievalPeer, payloadCID cid.Cid, params retrievalmarket.QueryParams, maxSize uint64) (retrievalmarket.SignedInlineQueryResponse, error) {
+	err := c.addMultiaddrs(ctx, p)
+	if err != nil {
+		log.Warn(err)
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	s, err := c.network.NewInlineQueryStream(p.ID)
+	if err != nil {
+		log.Warn(err)
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	defer s.Close()
+
+	err = s.WriteInlineQuery(retrievalmarket.InlineQuery{
+		Query: retrievalmarket.Query{
+			PayloadCID:  payloadCID,
+			QueryParams: params,
+		},
+		MaxSize: maxSize,
+	})
+	if err != nil {
+		log.Warn(err)
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+
+	resp, err := s.ReadInlineQueryResponse()
+	if err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	if err := verifyInlineResponse(ctx, c.node, payloadCID, maxSize, resp); err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, xerrors.Errorf("inline query response from %s: %w", p.ID, err)
+	}
+	return resp, nil
+}
+
+// ChallengePossession challenges a provider to prove it holds a payload, by sending
+// the blocks on the given number of paths through the payload's DAG, picked with a
+// random nonce. It returns an error unless the provider sends a proof that verifies
+func (c *Client) ChallengePossession(ctx context.Context, p retrievalmarket.RetrievalPeer, payloadCID cid.Cid, params retrievalmarket.QueryParams, paths uint64) error {
+	err := c.addMultiaddrs(ctx, p)
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	s, err := c.network.NewPossessionStream(p.ID)
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	defer s.Close()
+
+	nonce := make([]byte, possession.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return xerrors.Errorf("generating nonce: %w", err)
+	}
+	err = s.WritePossessionChallenge(retrievalmarket.PossessionChallenge{
+		Query: retrievalmarket.Query{
+			PayloadCID:  payloadCID,
+			QueryParams: params,
+		},
+		Nonce: nonce,
+		Paths: paths,
+	})
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+
+	proof, err := s.ReadPossessionProof()
+	if err != nil {
+		return err
+	}
+	if proof.Status != retrievalmarket.PossessionProofOk {
+		return xerrors.Errorf("provider %s did not prove it holds %s: %s: %s", p.ID, payloadCID, retrievalmarket.PossessionProofStatuses[proof.Status], proof.Message)
+	}
+	if err := possession.Verify(ctx, payloadCID, nonce, paths, proof.Data); err != nil {
+		return xerrors.Errorf("proof of possession of %s from %s: %w", payloadCID, p.ID, err)
+	}
+	return nil
+}
+
+// RetrievePiece asks a provider for a whole piece by its PieceCID and writes the
+// piece's data to out in the given format
+func (c *Client) RetrievePiece(ctx context.Context, p retrievalmarket.RetrievalPeer, pieceCID cid.Cid, format retrievalmarket.PieceFormat, out io.Writer) (uint64, error) {
+	err := c.addMultiaddrs(ctx, p)
+	if err != nil {
+		log.Warn(err)
+		return 0, err
+	}
+	s, err := c.network.NewPieceStream(p.ID)
+	if err != nil {
+		log.Warn(err)
+		return 0, err
+	}
+	defer s.Close()
+
+	err = s.WritePieceRequest(retrievalmarket.PieceRequest{
+		PieceCID: pieceCID,
+		Format:   format,
+	})
+	if err != nil {
+		log.Warn(err)
+		return 0, err
+	}
+
+	resp, err := s.ReadPieceResponse()
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status != retrievalmarket.PieceResponseOk {
+		return 0, xerrors.Errorf("provider did not send piece %s: %s: %s", pieceCID, retrievalmarket.PieceResponseStatuses[resp.Status], resp.Message)
+	}
+
+	n, err := io.Copy(out, s)
+	if err != nil {
+		return uint64(n), xerrors.Errorf("receiving piece %s: %w", pieceCID, err)
+	}
+	if resp.Size != 0 && uint64(n) != resp.Size {
+		return uint64(n), xerrors.Errorf("received %d bytes of piece %s, expected %d", n, pieceCID, resp.Size)
+	}
+	return uint64(n), nil
 }
 
 /*
@@ -232,22 +458,192 @@ From then on, the statemachine controls the deal flow in the client. Other compo
 Documentation of the client state machine can be found at https://godoc.org/github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/clientstates
 */
 func (c *Client) Retrieve(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, storeID *multistore.StoreID) (retrievalmarket.DealID, error) {
+	return c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, storeID, nil)
+}
+
+/*
+RetrieveToCAR initiates a retrieval deal like Retrieve, but instead of putting the
+blocks it receives into a store, the client writes them to out as a CARv1 stream
+rooted at payloadCID, as they arrive and in traversal order. This lets a caller
+pipe a retrieval straight to its destination, such as an HTTP response.
+
+carVersion must be 1. CARv2 is not supported, and asking for it fails with
+retrievalmarket.ErrUnsupportedCARVersion rather than writing a CARv1 stream the
+caller does not expect.
+
+The client stops writing to out once the deal reaches a final state, but does not
+close it. A streamed retrieval cannot resume after the client restarts, and must
+be started again.
+*/
+func (c *Client) RetrieveToCAR(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, out io.Writer, carVersion uint64) (retrievalmarket.DealID, error) {
+	w, err := carstream.NewWriter(out, payloadCID, carVersion)
+	if err != nil {
+		return 0, err
+	}
+	return c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, nil, w)
+}
+
+/*
+RetrieveToPath initiates a retrieval deal for the whole UnixFS DAG under payloadCID
+like Retrieve, and also writes its files to path as the blocks arrive. If the
+payload is a directory, path is the directory its entries are written to.
+
+Blocks are still put in the store, so the deal can be restarted. A file is moved
+into place once all of its data is written, and files already in place are not
+written again, so calling RetrieveToPath again with the same store and path after
+the client restarts resumes the extraction. Extraction stops if the DAG is not
+UnixFS, or the files cannot be written, and the deal fails with it.
+*/
+func (c *Client) RetrieveToPath(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, storeID multistore.StoreID, path string) (retrievalmarket.DealID, error) {
+	whole, err := selectsWholeDAG(params)
+	if err != nil {
+		return 0, err
+	}
+	if !whole {
+		return 0, xerrors.New("only the whole DAG of a payload can be retrieved to a path")
+	}
+	store, err := c.multiStore.Get(storeID)
+	if err != nil {
+		return 0, err
+	}
+	extractor := unixfsextract.New(payloadCID, path, store.Loader, store.Storer)
+	return c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, &storeID, extractor)
+}
+
+/*
+RetrieveStreaming initiates a retrieval deal into a store like Retrieve, and passes
+each block to the returned subscription as the traversal visits it, checked against
+its CID, so the caller can start reading the payload before the deal completes. If
+the payload is a UnixFS file, blocks carry the part of the file they hold and its
+offset.
+
+At most bufferSize blocks wait for the subscriber. Once the buffer is full the
+transfer waits for the subscriber to catch up or cancel the subscription. Cancelling
+the subscription does not cancel the deal, which carries on into the store. The
+subscription's channel is closed once the deal reaches a final state.
+*/
+func (c *Client) RetrieveStreaming(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, storeID multistore.StoreID, bufferSize int) (retrievalmarket.DealID, retrievalmarket.BlockSubscription, error) {
+	store, err := c.multiStore.Get(storeID)
+	if err != nil {
+		return 0, nil, err
+	}
+	tap := blocktap.New(payloadCID, store.Loader, store.Storer, bufferSize)
+	dealID, err := c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, &storeID, tap)
+	if err != nil {
+		return 0, nil, err
+	}
+	return dealID, tap, nil
+}
+
+/*
+RetrieveSharded retrieves a payload that was split across several storage deals
+with the dagsharding package. Each shard not already in the store is retrieved in
+turn, from the peer and with the parameters plan returns for it, and once every
+shard is in the store the payload is reassembled there from the manifest.
+
+RetrieveSharded returns once the payload is reassembled, or when a shard cannot be
+retrieved. Shards retrieved before a failure stay in the store, so calling
+RetrieveSharded again with the same store only retrieves the shards still missing.
+*/
+func (c *Client) RetrieveSharded(ctx context.Context, manifest *dagsharding.Manifest, plan retrievalmarket.ShardRetrievalPlanner, storeID multistore.StoreID) error {
+	store, err := c.multiStore.Get(storeID)
+	if err != nil {
+		return err
+	}
+	return dagsharding.Fetch(ctx, manifest, store.Bstore, func(ctx context.Context, shard dagsharding.Shard) error {
+		r, err := plan(shard)
+		if err != nil {
+			return err
+		}
+		return c.retrieveAndWait(ctx, shard.Root, r, storeID)
+	})
+}
+
+// retrieveAndWait retrieves a payload into a store, and returns once the deal for it
+// reaches a final state
+func (c *Client) retrieveAndWait(ctx context.Context, payloadCID cid.Cid, r retrievalmarket.ShardRetrieval, storeID multistore.StoreID) error {
+	// the deal may finish before Retrieve returns its ID, so record every deal that
+	// finishes until the ID is known
+	var finishedLk sync.Mutex
+	finished := make(map[retrievalmarket.DealID]retrievalmarket.ClientDealState)
+	notify := make(chan struct{}, 1)
+	unsubscribe := c.SubscribeToEvents(func(event retrievalmarket.ClientEvent, state retrievalmarket.ClientDealState) {
+		if !isFinalityState(state.Status) {
+			return
+		}
+		finishedLk.Lock()
+		finished[state.ID] = state
+		finishedLk.Unlock()
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	dealID, err := c.Retrieve(ctx, payloadCID, r.Params, r.TotalFunds, r.Peer, r.ClientWallet, r.MinerWallet, &storeID)
+	if err != nil {
+		return err
+	}
+	for {
+		finishedLk.Lock()
+		state, ok := finished[dealID]
+		finishedLk.Unlock()
+		if ok {
+			if state.Status != retrievalmarket.DealStatusCompleted {
+				return xerrors.Errorf("retrieval deal %d ended with status %s: %s", dealID, retrievalmarket.DealStatuses[state.Status], state.Message)
+			}
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func isFinalityState(status retrievalmarket.DealStatus) bool {
+	for _, finalityState := range clientstates.ClientFinalityStates {
+		if status == finalityState {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) retrieve(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, storeID *multistore.StoreID, stream blockStream) (retrievalmarket.DealID, error) {
+	if err := params.CheckPaymentDisabled(); err != nil {
+		return 0, err
+	}
 	err := c.addMultiaddrs(ctx, p)
 	if err != nil {
 		return 0, err
 	}
+	if c.challengePaths > 0 && !params.UnsealPrice.Nil() && params.UnsealPrice.GreaterThan(big.Zero()) {
+		err := c.ChallengePossession(ctx, p, payloadCID, retrievalmarket.QueryParams{PieceCID: params.PieceCID}, c.challengePaths)
+		if err != nil {
+			return 0, xerrors.Errorf("not paying to unseal: %w", err)
+		}
+	}
 	next, err := c.storedCounter.Next()
 	if err != nil {
 		return 0, err
 	}
 	// make sure the store is loadable
+	var store *multistore.Store
 	if storeID != nil {
-		_, err = c.multiStore.Get(*storeID)
+		store, err = c.multiStore.Get(*storeID)
 		if err != nil {
 			return 0, err
 		}
 	}
 	dealID := retrievalmarket.DealID(next)
+	if store != nil {
+		if err := c.checkDiskSpace(dealID, p.ID, payloadCID); err != nil {
+			return 0, err
+		}
+	}
 	dealState := retrievalmarket.ClientDealState{
 		DealProposal: retrievalmarket.DealProposal{
 			PayloadCID: payloadCID,
@@ -266,16 +662,35 @@ func (c *Client) Retrieve(ctx context.Context, payloadCID cid.Cid, params retrie
 		Sender:           p.ID,
 		UnsealFundsPaid:  big.Zero(),
 		StoreID:          storeID,
+		FundsToppedUp:    big.Zero(),
+	}
+
+	if stream != nil {
+		c.streamsLk.Lock()
+		c.streams[dealID] = stream
+		c.streamsLk.Unlock()
+	} else if store != nil && c.blockWorkers > 0 {
+		c.pipelinesLk.Lock()
+		c.pipelines[dealID] = blockpipeline.New(store.Loader, store.Storer, c.blockWorkers, c.blockQueueSize)
+		c.pipelinesLk.Unlock()
 	}
 
 	// start the deal processing
 	err = c.stateMachines.Begin(dealState.ID, &dealState)
 	if err != nil {
+		_ = c.closeStream(dealID)
+		_ = c.closeBlockPipeline(dealID)
+		c.trackDiskSpace(dealState, true)
 		return 0, err
 	}
+	c.startDealTimer(dealID)
 
 	err = c.stateMachines.Send(dealState.ID, retrievalmarket.ClientEventOpen)
 	if err != nil {
+		c.stopDealTimer(dealID)
+		_ = c.closeStream(dealID)
+		_ = c.closeBlockPipeline(dealID)
+		c.trackDiskSpace(dealState, true)
 		return 0, err
 	}
 
@@ -285,9 +700,66 @@ func (c *Client) Retrieve(ctx context.Context, payloadCID cid.Cid, params retrie
 func (c *Client) notifySubscribers(eventName fsm.EventName, state fsm.StateType) {
 	evt := eventName.(retrievalmarket.ClientEvent)
 	ds := state.(retrievalmarket.ClientDealState)
+	c.dealProgressed(ds.ID, ds.TotalReceived)
+	finished := false
+	for _, finalityState := range clientstates.ClientFinalityStates {
+		if ds.Status == finalityState {
+			finished = true
+			c.stopDealTimer(ds.ID)
+			if err := c.closeStream(ds.ID); err != nil && ds.Status == retrievalmarket.DealStatusCompleted {
+				log.Errorf("writing blocks received for deal %d: %s", ds.ID, err)
+			}
+			if err := c.closeBlockPipeline(ds.ID); err != nil {
+				log.Errorf("storing blocks received for deal %d: %s", ds.ID, err)
+			}
+		}
+	}
+	c.trackDiskSpace(ds, finished)
 	_ = c.subscribers.Publish(internalEvent{evt, ds})
 }
 
+// closeStream stops a deal's block stream, and returns the error it stopped with
+func (c *Client) closeStream(dealID retrievalmarket.DealID) error {
+	c.streamsLk.Lock()
+	stream, ok := c.streams[dealID]
+	delete(c.streams, dealID)
+	c.streamsLk.Unlock()
+	if !ok {
+		return nil
+	}
+	return stream.Close()
+}
+
+// closeBlockPipeline waits for the blocks received for a deal to be stored, and
+// returns the first error checking or storing them
+func (c *Client) closeBlockPipeline(dealID retrievalmarket.DealID) error {
+	c.pipelinesLk.Lock()
+	pipeline, ok := c.pipelines[dealID]
+	delete(c.pipelines, dealID)
+	c.pipelinesLk.Unlock()
+	if !ok {
+		return nil
+	}
+	return pipeline.Close()
+}
+
+// pipelineFlusher passes data transfer events to the client's deals, holding back
+// the event for a deal having received all its blocks until the blocks are stored
+type pipelineFlusher struct {
+	c *Client
+}
+
+func (pf *pipelineFlusher) Send(id interface{}, name fsm.EventName, args ...interface{}) error {
+	if name == retrievalmarket.ClientEventAllBlocksReceived {
+		if dealID, ok := id.(retrievalmarket.DealID); ok {
+			if err := pf.c.closeBlockPipeline(dealID); err != nil {
+				return pf.c.stateMachines.Send(id, retrievalmarket.ClientEventDataTransferError, xerrors.Errorf("storing received blocks: %w", err))
+			}
+		}
+	}
+	return pf.c.stateMachines.Send(id, name, args...)
+}
+
 func (c *Client) addMultiaddrs(ctx context.Context, p retrievalmarket.RetrievalPeer) error {
 	tok, _, err := c.node.GetChainHead(ctx)
 	if err != nil {
@@ -357,6 +829,24 @@ func (c *Client) ListDeals() (map[retrievalmarket.DealID]retrievalmarket.ClientD
 	return dealMap, nil
 }
 
+// DealStateSnapshot returns the client deal state machine definition along
+// with the number of deals currently in each state
+func (c *Client) DealStateSnapshot() (fsmexport.Snapshot, error) {
+	def, err := fsmexport.NewDefinition(ClientFSMParameterSpec, retrievalmarket.DealStatuses, retrievalmarket.ClientEvents, []fsm.StateKey{retrievalmarket.DealStatusNew})
+	if err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	var deals []retrievalmarket.ClientDealState
+	if err := c.stateMachines.List(&deals); err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	current := make([]fsm.StateKey, 0, len(deals))
+	for _, deal := range deals {
+		current = append(current, deal.Status)
+	}
+	return fsmexport.NewSnapshot(def, current), nil
+}
+
 var _ clientstates.ClientDealEnvironment = &clientDealEnvironment{}
 
 type clientDealEnvironment struct {
@@ -369,7 +859,7 @@ func (c *clientDealEnvironment) Node() retrievalmarket.RetrievalClientNode {
 }
 
 func (c *clientDealEnvironment) OpenDataTransfer(ctx context.Context, to peer.ID, proposal *retrievalmarket.DealProposal, legacy bool) (datatransfer.ChannelID, error) {
-	sel := shared.AllSelector()
+	sel := selectors.Entire()
 	if proposal.SelectorSpecified() {
 		var err error
 		sel, err = retrievalmarket.DecodeNode(proposal.Selector)
@@ -380,18 +870,11 @@ func (c *clientDealEnvironment) OpenDataTransfer(ctx context.Context, to peer.ID
 
 	var vouch datatransfer.Voucher = proposal
 	if legacy {
-		vouch = &migrations.DealProposal0{
-			PayloadCID: proposal.PayloadCID,
-			ID:         proposal.ID,
-			Params0: migrations.Params0{
-				Selector:                proposal.Selector,
-				PieceCID:                proposal.PieceCID,
-				PricePerByte:            proposal.PricePerByte,
-				PaymentInterval:         proposal.PaymentInterval,
-				PaymentIntervalIncrease: proposal.PaymentIntervalIncrease,
-				UnsealPrice:             proposal.UnsealPrice,
-			},
+		legacyProposal, err := migrations.DealProposal2To0(*proposal)
+		if err != nil {
+			return datatransfer.ChannelID{}, err
 		}
+		vouch = &legacyProposal
 	}
 	return c.c.dataTransfer.OpenPullDataChannel(ctx, to, vouch, proposal.PayloadCID, sel)
 }
@@ -412,6 +895,11 @@ func (c *clientDealEnvironment) CloseDataTransfer(ctx context.Context, channelID
 	return c.c.dataTransfer.CloseDataTransferChannel(ctx, channelID)
 }
 
+// FundsTopUpLimit returns the most funds the client adds automatically to a deal's payment channel
+func (c *clientDealEnvironment) FundsTopUpLimit() abi.TokenAmount {
+	return c.c.fundsTopUpLimit
+}
+
 type clientStoreGetter struct {
 	c *Client
 }
@@ -428,6 +916,22 @@ func (csg *clientStoreGetter) Get(otherPeer peer.ID, dealID retrievalmarket.Deal
 	return csg.c.multiStore.Get(*deal.StoreID)
 }
 
+func (csg *clientStoreGetter) GetStream(otherPeer peer.ID, dealID retrievalmarket.DealID) (ipld.Loader, ipld.Storer, bool) {
+	csg.c.streamsLk.Lock()
+	defer csg.c.streamsLk.Unlock()
+	stream, ok := csg.c.streams[dealID]
+	if ok {
+		return stream.Loader(), stream.Storer(), true
+	}
+	csg.c.pipelinesLk.Lock()
+	defer csg.c.pipelinesLk.Unlock()
+	pipeline, ok := csg.c.pipelines[dealID]
+	if !ok {
+		return nil, nil, false
+	}
+	return pipeline.Loader(), pipeline.Storer(), true
+}
+
 // ClientFSMParameterSpec is a valid set of parameters for a client deal FSM - used in doc generation
 var ClientFSMParameterSpec = fsm.Parameters{
 	Environment:     &clientDealEnvironment{},
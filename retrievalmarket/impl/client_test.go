@@ -432,6 +432,7 @@ func TestMigrations(t *testing.T) {
 			WaitMsgCID:       nil,
 			VoucherShortfall: voucherShortfalls[i],
 			LegacyProtocol:   true,
+			FundsToppedUp:    big.Zero(),
 		}
 		require.Equal(t, expectedDeal, deal)
 	}
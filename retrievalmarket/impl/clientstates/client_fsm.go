@@ -113,6 +113,8 @@ var ClientEvents = fsm.Events{
 			deal.Message = ""
 			return nil
 		}),
+	fsm.Event(rm.ClientEventPaymentChannelSkipped).
+		From(rm.DealStatusAccepted).To(rm.DealStatusOngoing),
 	fsm.Event(rm.ClientEventAllocateLaneErrored).
 		FromMany(rm.DealStatusPaymentChannelAllocatingLane).
 		To(rm.DealStatusFailing).
@@ -215,6 +217,7 @@ var ClientEvents = fsm.Events{
 	fsm.Event(rm.ClientEventVoucherShortfall).
 		FromMany(rm.DealStatusSendFunds, rm.DealStatusSendFundsLastPayment).To(rm.DealStatusCheckFunds).
 		Action(func(deal *rm.ClientDealState, shortfall abi.TokenAmount) error {
+			deal.VoucherShortfall = shortfall
 			return nil
 		}),
 
@@ -228,22 +231,18 @@ var ClientEvents = fsm.Events{
 		From(rm.DealStatusSendFunds).To(rm.DealStatusOngoing).
 		From(rm.DealStatusSendFundsLastPayment).To(rm.DealStatusFinalizing).
 		Action(func(deal *rm.ClientDealState) error {
-			// paymentRequested = 0
-			// fundsSpent = fundsSpent + paymentRequested
-			// if paymentRequested / pricePerByte >= currentInterval
-			// currentInterval = currentInterval + proposal.intervalIncrease
-			// bytesPaidFor = bytesPaidFor + (paymentRequested / pricePerByte)
-			deal.FundsSpent = big.Add(deal.FundsSpent, deal.PaymentRequested)
-
-			paymentForUnsealing := big.Min(deal.PaymentRequested, big.Sub(deal.UnsealPrice, deal.UnsealFundsPaid))
-
-			bytesPaidFor := big.Div(big.Sub(deal.PaymentRequested, paymentForUnsealing), deal.PricePerByte).Uint64()
-			if bytesPaidFor >= deal.CurrentInterval {
-				deal.CurrentInterval += deal.DealProposal.PaymentIntervalIncrease
+			recordPayment(deal, deal.PaymentRequested)
+			if !deal.VoucherShortfall.Nil() {
+				deal.VoucherShortfall = big.Zero()
 			}
-			deal.BytesPaidFor += bytesPaidFor
-			deal.UnsealFundsPaid = big.Add(deal.UnsealFundsPaid, paymentForUnsealing)
-			deal.PaymentRequested = abi.NewTokenAmount(0)
+			return nil
+		}),
+	fsm.Event(rm.ClientEventPartialPaymentSent).
+		From(rm.DealStatusSendFunds).To(rm.DealStatusOngoing).
+		Action(func(deal *rm.ClientDealState, amount abi.TokenAmount) error {
+			// the provider asks for the rest again with its next payment request
+			deal.VoucherShortfall = big.Sub(deal.PaymentRequested, amount)
+			recordPayment(deal, amount)
 			return nil
 		}),
 
@@ -260,6 +259,27 @@ var ClientEvents = fsm.Events{
 			return nil
 		}),
 
+	// verifying the retrieved data against the deal's piece CID
+	fsm.Event(rm.ClientEventPieceVerified).
+		FromMany(rm.DealStatusSendFundsLastPayment, rm.DealStatusCheckComplete).ToJustRecord().
+		Action(func(deal *rm.ClientDealState) error {
+			deal.VerifiedAgainstPiece = true
+			return nil
+		}),
+	fsm.Event(rm.ClientEventPieceNotVerified).
+		FromMany(rm.DealStatusSendFundsLastPayment, rm.DealStatusCheckComplete).ToJustRecord().
+		Action(func(deal *rm.ClientDealState, err error) error {
+			deal.Message = xerrors.Errorf("verifying retrieved data against piece: %w", err).Error()
+			return nil
+		}),
+	fsm.Event(rm.ClientEventPieceMismatch).
+		From(rm.DealStatusSendFundsLastPayment).To(rm.DealStatusFailing).
+		From(rm.DealStatusCheckComplete).To(rm.DealStatusErrored).
+		Action(func(deal *rm.ClientDealState, err error) error {
+			deal.Message = xerrors.Errorf("verifying retrieved data against piece: %w", err).Error()
+			return nil
+		}),
+
 	// after cancelling a deal is complete
 	fsm.Event(rm.ClientEventCancelComplete).
 		From(rm.DealStatusFailing).To(rm.DealStatusErrored).
@@ -279,14 +299,52 @@ var ClientEvents = fsm.Events{
 		},
 	),
 
-	// user manually cancels retrieval
+	// user manually cancels retrieval, or the client cancels it after it times out
 	fsm.Event(rm.ClientEventCancel).FromAny().To(rm.DealStatusCancelling).Action(func(deal *rm.ClientDealState) error {
-		deal.Message = "Client cancelled retrieval"
+		if deal.TimeoutReason == rm.DealTimeoutNone {
+			deal.Message = "Client cancelled retrieval"
+		}
 		return nil
 	}),
 
+	// the deal exceeded one of its timeouts, and the client is about to cancel it
+	fsm.Event(rm.ClientEventTimedOut).
+		FromAny().ToJustRecord().
+		Action(func(deal *rm.ClientDealState, timeout *rm.DealTimeoutError) error {
+			deal.TimeoutReason = timeout.Reason
+			deal.Message = timeout.Error()
+			return nil
+		}),
+
 	// payment channel receives more money, we believe there may be reason to recheck the funds for this channel
 	fsm.Event(rm.ClientEventRecheckFunds).From(rm.DealStatusInsufficientFunds).To(rm.DealStatusCheckFunds),
+
+	// funds are added to the payment channel automatically, up to the client's top up limit
+	fsm.Event(rm.ClientEventFundsToppedUp).
+		From(rm.DealStatusInsufficientFunds).ToJustRecord().
+		Action(func(deal *rm.ClientDealState, amount abi.TokenAmount) error {
+			deal.FundsToppedUp = big.Add(fundsToppedUp(*deal), amount)
+			deal.Message = fmt.Sprintf("added %s to payment channel", amount.String())
+			return nil
+		}),
+	fsm.Event(rm.ClientEventFundsTopUpFailed).
+		From(rm.DealStatusInsufficientFunds).ToJustRecord().
+		Action(func(deal *rm.ClientDealState, err error) error {
+			deal.Message = xerrors.Errorf("adding funds to payment channel: %w", err).Error()
+			return nil
+		}),
+
+	// the data transfer channel for the deal made progress or changed status
+	fsm.Event(rm.ClientEventDataTransferUpdated).
+		FromAny().ToJustRecord().
+		Action(func(deal *rm.ClientDealState, channelState datatransfer.ChannelState) error {
+			deal.ChannelID = channelState.ChannelID()
+			deal.TransferStatus = channelState.Status()
+			deal.TransferQueued = channelState.Queued()
+			deal.TransferSent = channelState.Sent()
+			deal.TransferReceived = channelState.Received()
+			return nil
+		}),
 }
 
 // ClientFinalityStates are terminal states after which no further events are received
@@ -298,6 +356,27 @@ var ClientFinalityStates = []fsm.StateKey{
 	rm.DealStatusDealNotFound,
 }
 
+// recordPayment records a payment of amount towards the payment requested by the
+// provider, paying for unsealing first and then for the bytes received
+func recordPayment(deal *rm.ClientDealState, amount abi.TokenAmount) {
+	// paymentRequested = 0
+	// fundsSpent = fundsSpent + amount
+	// if amount / pricePerByte >= currentInterval
+	// currentInterval = currentInterval + proposal.intervalIncrease
+	// bytesPaidFor = bytesPaidFor + (amount / pricePerByte)
+	deal.FundsSpent = big.Add(deal.FundsSpent, amount)
+
+	paymentForUnsealing := big.Min(amount, big.Sub(deal.UnsealPrice, deal.UnsealFundsPaid))
+
+	bytesPaidFor := big.Div(big.Sub(amount, paymentForUnsealing), deal.PricePerByte).Uint64()
+	if bytesPaidFor >= deal.CurrentInterval {
+		deal.CurrentInterval += deal.DealProposal.PaymentIntervalIncrease
+	}
+	deal.BytesPaidFor += bytesPaidFor
+	deal.UnsealFundsPaid = big.Add(deal.UnsealFundsPaid, paymentForUnsealing)
+	deal.PaymentRequested = abi.NewTokenAmount(0)
+}
+
 // ClientStateEntryFuncs are the handlers for different states in a retrieval client
 var ClientStateEntryFuncs = fsm.StateEntryFuncs{
 	rm.DealStatusNew:                          ProposeDeal,
@@ -315,4 +394,5 @@ var ClientStateEntryFuncs = fsm.StateEntryFuncs{
 	rm.DealStatusFailing:                      CancelDeal,
 	rm.DealStatusCancelling:                   CancelDeal,
 	rm.DealStatusCheckComplete:                CheckComplete,
+	rm.DealStatusInsufficientFunds:            TopUpFunds,
 }
@@ -3,13 +3,16 @@ package clientstates
 import (
 	"context"
 
+	"github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
 	rm "github.com/filecoin-project/go-fil-markets/retrievalmarket"
 )
@@ -22,6 +25,13 @@ type ClientDealEnvironment interface {
 	OpenDataTransfer(ctx context.Context, to peer.ID, proposal *rm.DealProposal, legacy bool) (datatransfer.ChannelID, error)
 	SendDataTransferVoucher(context.Context, datatransfer.ChannelID, *rm.DealPayment, bool) error
 	CloseDataTransfer(context.Context, datatransfer.ChannelID) error
+	// FundsTopUpLimit is the most the client will automatically add to the payment channel
+	// for a single deal once it runs out of funds. Zero means funds are never added automatically
+	FundsTopUpLimit() abi.TokenAmount
+	// RetrievedPieceCID computes the piece CID of the data retrieved for a deal. It
+	// returns false if the client does not verify retrieved data, or the deal did not
+	// retrieve a whole piece into a store
+	RetrievedPieceCID(ctx context.Context, deal rm.ClientDealState) (cid.Cid, bool, error)
 }
 
 // ProposeDeal sends the proposal to the other party
@@ -29,13 +39,21 @@ func ProposeDeal(ctx fsm.Context, environment ClientDealEnvironment, deal rm.Cli
 	legacy := deal.Status == rm.DealStatusRetryLegacy
 	channelID, err := environment.OpenDataTransfer(ctx.Context(), deal.Sender, &deal.DealProposal, legacy)
 	if err != nil {
+		if legacy {
+			// keep the reason the first proposal was rejected
+			err = xerrors.Errorf("%s; retrying with a legacy proposal: %w", deal.Message, err)
+		}
 		return ctx.Trigger(rm.ClientEventWriteDealProposalErrored, err)
 	}
 	return ctx.Trigger(rm.ClientEventDealProposed, channelID)
 }
 
-// SetupPaymentChannelStart initiates setting up a payment channel for a deal
+// SetupPaymentChannelStart initiates setting up a payment channel for a deal. Deals
+// with payment disabled skip the payment channel, and never touch the chain
 func SetupPaymentChannelStart(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) error {
+	if deal.PaymentDisabled {
+		return ctx.Trigger(rm.ClientEventPaymentChannelSkipped)
+	}
 
 	tok, _, err := environment.Node().GetChainHead(ctx.Context())
 	if err != nil {
@@ -85,10 +103,17 @@ func Ongoing(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientD
 
 // ProcessPaymentRequested processes a request for payment from the provider
 func ProcessPaymentRequested(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) error {
-	// see if we need to send payment
+	// an escrowed final payment is only sent once the complete DAG has been received and verified
+	if deal.EscrowFinalPayment && deal.LastPaymentRequested && !deal.AllBlocksReceived {
+		return nil
+	}
+
+	// see if we need to send payment. A payment the client could only partly cover is
+	// owed already, so the rest is sent as soon as the provider asks for it
 	if deal.TotalReceived-deal.BytesPaidFor >= deal.CurrentInterval ||
 		deal.AllBlocksReceived ||
-		deal.UnsealPrice.GreaterThan(deal.UnsealFundsPaid) {
+		deal.UnsealPrice.GreaterThan(deal.UnsealFundsPaid) ||
+		hasShortfall(deal) {
 		return ctx.Trigger(rm.ClientEventSendFunds)
 	}
 	return nil
@@ -96,6 +121,17 @@ func ProcessPaymentRequested(ctx fsm.Context, environment ClientDealEnvironment,
 
 // SendFunds sends the next amount requested by the provider
 func SendFunds(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) error {
+	if deal.PaymentDisabled {
+		return ctx.Trigger(rm.ClientEventBadPaymentRequested, "payment requested for a deal with payment disabled")
+	}
+
+	// all the data has been received before the last payment, so it can be verified
+	if deal.Status == rm.DealStatusSendFundsLastPayment {
+		if matched, err := verifyPiece(ctx, environment, deal); !matched || err != nil {
+			return err
+		}
+	}
+
 	// check that paymentRequest <= (totalReceived - bytesPaidFor) * pricePerByte + (unsealPrice - unsealFundsPaid), or fail
 	retrievalPrice := big.Mul(abi.NewTokenAmount(int64(deal.TotalReceived-deal.BytesPaidFor)), deal.PricePerByte)
 	unsealPrice := big.Sub(deal.UnsealPrice, deal.UnsealFundsPaid)
@@ -114,23 +150,45 @@ func SendFunds(ctx fsm.Context, environment ClientDealEnvironment, deal rm.Clien
 	voucher, err := environment.Node().CreatePaymentVoucher(ctx.Context(), deal.PaymentInfo.PayCh, big.Add(deal.FundsSpent, deal.PaymentRequested), deal.PaymentInfo.Lane, tok)
 	if err != nil {
 		shortfallErr, ok := err.(rm.ShortfallError)
-		if ok {
+		if !ok {
+			return ctx.Trigger(rm.ClientEventCreateVoucherFailed, err)
+		}
+		// a payment other than the last one is split: the funds in the channel are
+		// sent now, so the provider keeps sending data, and the rest with the next payment
+		partial := big.Sub(deal.PaymentRequested, shortfallErr.Shortfall())
+		if deal.Status != rm.DealStatusSendFunds || partial.LessThanEqual(big.Zero()) {
 			return ctx.Trigger(rm.ClientEventVoucherShortfall, shortfallErr.Shortfall())
 		}
-		return ctx.Trigger(rm.ClientEventCreateVoucherFailed, err)
+		voucher, err = environment.Node().CreatePaymentVoucher(ctx.Context(), deal.PaymentInfo.PayCh, big.Add(deal.FundsSpent, partial), deal.PaymentInfo.Lane, tok)
+		if err != nil {
+			return ctx.Trigger(rm.ClientEventVoucherShortfall, shortfallErr.Shortfall())
+		}
+		if err := sendVoucher(ctx, environment, deal, voucher); err != nil {
+			return ctx.Trigger(rm.ClientEventWriteDealPaymentErrored, err)
+		}
+		return ctx.Trigger(rm.ClientEventPartialPaymentSent, partial)
 	}
 
 	// send payment voucher (or fail)
-	err = environment.SendDataTransferVoucher(ctx.Context(), deal.ChannelID, &rm.DealPayment{
+	if err := sendVoucher(ctx, environment, deal, voucher); err != nil {
+		return ctx.Trigger(rm.ClientEventWriteDealPaymentErrored, err)
+	}
+
+	return ctx.Trigger(rm.ClientEventPaymentSent)
+}
+
+func sendVoucher(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState, voucher *paych.SignedVoucher) error {
+	return environment.SendDataTransferVoucher(ctx.Context(), deal.ChannelID, &rm.DealPayment{
 		ID:             deal.DealProposal.ID,
 		PaymentChannel: deal.PaymentInfo.PayCh,
 		PaymentVoucher: voucher,
 	}, deal.LegacyProtocol)
-	if err != nil {
-		return ctx.Trigger(rm.ClientEventWriteDealPaymentErrored, err)
-	}
+}
 
-	return ctx.Trigger(rm.ClientEventPaymentSent)
+// hasShortfall returns true if part of a payment could not be covered by the funds in the
+// payment channel and is still owed
+func hasShortfall(deal rm.ClientDealState) bool {
+	return !deal.VoucherShortfall.Nil() && deal.VoucherShortfall.GreaterThan(big.Zero())
 }
 
 // CheckFunds examines current available funds in a payment channel after a voucher shortfall to determine
@@ -158,6 +216,40 @@ func CheckFunds(ctx fsm.Context, environment ClientDealEnvironment, deal rm.Clie
 	return ctx.Trigger(rm.ClientEventPaymentChannelAddingFunds, *availableFunds.PendingWaitSentinel, deal.PaymentInfo.PayCh)
 }
 
+// TopUpFunds automatically requests more funds for a payment channel that has run out, as long as
+// the total added for this deal stays within the client's top up limit. Otherwise the deal waits in
+// the insufficient funds state for an external caller to add funds
+func TopUpFunds(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) error {
+	limit := environment.FundsTopUpLimit()
+	if limit.Nil() || limit.LessThanEqual(big.Zero()) {
+		return nil
+	}
+
+	availableFunds, err := environment.Node().CheckAvailableFunds(ctx.Context(), deal.PaymentInfo.PayCh)
+	if err != nil {
+		return ctx.Trigger(rm.ClientEventFundsTopUpFailed, err)
+	}
+	unredeemedFunds := big.Sub(availableFunds.ConfirmedAmt, availableFunds.VoucherReedeemedAmt)
+	totalInFlight := big.Add(availableFunds.PendingAmt, availableFunds.QueuedAmt)
+	shortfall := big.Sub(big.Sub(deal.PaymentRequested, unredeemedFunds), totalInFlight)
+	if shortfall.LessThanEqual(big.Zero()) || big.Add(fundsToppedUp(deal), shortfall).GreaterThan(limit) {
+		return nil
+	}
+
+	tok, _, err := environment.Node().GetChainHead(ctx.Context())
+	if err != nil {
+		return ctx.Trigger(rm.ClientEventFundsTopUpFailed, err)
+	}
+	_, _, err = environment.Node().GetOrCreatePaymentChannel(ctx.Context(), deal.ClientWallet, deal.MinerWallet, shortfall, tok)
+	if err != nil {
+		return ctx.Trigger(rm.ClientEventFundsTopUpFailed, err)
+	}
+	if err := ctx.Trigger(rm.ClientEventFundsToppedUp, shortfall); err != nil {
+		return err
+	}
+	return ctx.Trigger(rm.ClientEventRecheckFunds)
+}
+
 // CancelDeal clears a deal that went wrong for an unknown reason
 func CancelDeal(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) error {
 	// Read next response (or fail)
@@ -175,5 +267,38 @@ func CheckComplete(ctx fsm.Context, environment ClientDealEnvironment, deal rm.C
 		return ctx.Trigger(rm.ClientEventEarlyTermination)
 	}
 
+	if matched, err := verifyPiece(ctx, environment, deal); !matched || err != nil {
+		return err
+	}
 	return ctx.Trigger(rm.ClientEventCompleteVerified)
 }
+
+// verifyPiece recomputes the piece CID of the data retrieved for a deal and records
+// whether it matches the piece CID the deal was made for. It returns false if the data
+// does not match, after failing the deal, so that the caller does not pay for it or
+// complete it. Data whose piece CID cannot be computed is not verified, but the deal goes on
+func verifyPiece(ctx fsm.Context, environment ClientDealEnvironment, deal rm.ClientDealState) (bool, error) {
+	if deal.VerifiedAgainstPiece || deal.PieceCID == nil {
+		return true, nil
+	}
+	pieceCid, ok, err := environment.RetrievedPieceCID(ctx.Context(), deal)
+	if err != nil {
+		return true, ctx.Trigger(rm.ClientEventPieceNotVerified, err)
+	}
+	if !ok {
+		return true, nil
+	}
+	if !pieceCid.Equals(*deal.PieceCID) {
+		return false, ctx.Trigger(rm.ClientEventPieceMismatch, xerrors.Errorf("retrieved data has piece CID %s, expected %s", pieceCid, *deal.PieceCID))
+	}
+	return true, ctx.Trigger(rm.ClientEventPieceVerified)
+}
+
+// fundsToppedUp returns the funds automatically added for a deal, which is unset
+// for deals created before funds could be topped up
+func fundsToppedUp(deal rm.ClientDealState) abi.TokenAmount {
+	if deal.FundsToppedUp.Nil() {
+		return big.Zero()
+	}
+	return deal.FundsToppedUp
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -36,6 +37,7 @@ type fakeEnvironment struct {
 	OpenDataTransferError        error
 	SendDataTransferVoucherError error
 	CloseDataTransferError       error
+	TopUpLimit                   abi.TokenAmount
 }
 
 func (e *fakeEnvironment) Node() retrievalmarket.RetrievalClientNode {
@@ -54,13 +56,32 @@ func (e *fakeEnvironment) CloseDataTransfer(_ context.Context, _ datatransfer.Ch
 	return e.CloseDataTransferError
 }
 
+func (e *fakeEnvironment) FundsTopUpLimit() abi.TokenAmount {
+	return e.TopUpLimit
+}
+
+func (e *fakeEnvironment) RetrievedPieceCID(_ context.Context, _ rm.ClientDealState) (cid.Cid, bool, error) {
+	return cid.Undef, false, nil
+}
+
+// pieceEnvironment is a fakeEnvironment that computes a piece CID for retrieved data
+type pieceEnvironment struct {
+	*fakeEnvironment
+	pieceCid cid.Cid
+	err      error
+}
+
+func (e *pieceEnvironment) RetrievedPieceCID(_ context.Context, _ rm.ClientDealState) (cid.Cid, bool, error) {
+	return e.pieceCid, true, e.err
+}
+
 func TestProposeDeal(t *testing.T) {
 	ctx := context.Background()
 	node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
 	eventMachine, err := fsm.NewEventProcessor(retrievalmarket.ClientDealState{}, "Status", clientstates.ClientEvents)
 	require.NoError(t, err)
 	runProposeDeal := func(t *testing.T, openError error, dealState *retrievalmarket.ClientDealState) {
-		environment := &fakeEnvironment{node, openError, nil, nil}
+		environment := &fakeEnvironment{node, openError, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.ProposeDeal(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -85,6 +106,15 @@ func TestProposeDeal(t *testing.T) {
 		require.Equal(t, dealState.ChannelID.Responder, dealState.Sender)
 	})
 
+	t.Run("legacy proposal error", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusRetryLegacy)
+		dealState.Message = "deal rejected: unknown voucher type"
+		openError := errors.New("provider does not support escrowing the final payment")
+		runProposeDeal(t, openError, dealState)
+		require.Equal(t, "proposing deal: deal rejected: unknown voucher type; retrying with a legacy proposal: provider does not support escrowing the final payment", dealState.Message)
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusErrored)
+	})
+
 	t.Run("data transfer eror", func(t *testing.T) {
 		dealState := makeDealState(retrievalmarket.DealStatusNew)
 		openError := errors.New("something went wrong")
@@ -102,7 +132,7 @@ func TestSetupPaymentChannel(t *testing.T) {
 		params testnodes.TestRetrievalClientNodeParams,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(params)
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.SetupPaymentChannelStart(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -146,6 +176,17 @@ func TestSetupPaymentChannel(t *testing.T) {
 		require.Equal(t, dealState.Status, retrievalmarket.DealStatusFailing)
 	})
 
+	t.Run("payment disabled", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusAccepted)
+		dealState.PaymentDisabled = true
+		envParams := testnodes.TestRetrievalClientNodeParams{
+			PayCh:    address.Undef,
+			PayChErr: errors.New("no chain"),
+		}
+		runSetupPaymentChannel(t, envParams, dealState)
+		require.Empty(t, dealState.Message)
+		require.Equal(t, retrievalmarket.DealStatusOngoing, dealState.Status)
+	})
 }
 
 func TestWaitForPaymentReady(t *testing.T) {
@@ -157,7 +198,7 @@ func TestWaitForPaymentReady(t *testing.T) {
 		params testnodes.TestRetrievalClientNodeParams,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(params)
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.WaitPaymentChannelReady(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -212,7 +253,7 @@ func TestAllocateLane(t *testing.T) {
 		params testnodes.TestRetrievalClientNodeParams,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(params)
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.AllocateLane(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -247,7 +288,7 @@ func TestOngoing(t *testing.T) {
 	runOngoing := func(t *testing.T,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.Ongoing(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -282,7 +323,7 @@ func TestProcessPaymentRequested(t *testing.T) {
 	runProcessPaymentRequested := func(t *testing.T,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.ProcessPaymentRequested(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -309,6 +350,23 @@ func TestProcessPaymentRequested(t *testing.T) {
 		runProcessPaymentRequested(t, dealState)
 		require.Equal(t, dealState.Status, retrievalmarket.DealStatusFundsNeeded)
 	})
+
+	t.Run("escrowed final payment waits for all blocks", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusFundsNeededLastPayment)
+		dealState.EscrowFinalPayment = true
+		dealState.LastPaymentRequested = true
+		runProcessPaymentRequested(t, dealState)
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusFundsNeededLastPayment)
+	})
+
+	t.Run("escrowed final payment sent once all blocks received", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusFundsNeededLastPayment)
+		dealState.EscrowFinalPayment = true
+		dealState.LastPaymentRequested = true
+		dealState.AllBlocksReceived = true
+		runProcessPaymentRequested(t, dealState)
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusSendFundsLastPayment)
+	})
 }
 
 func TestSendFunds(t *testing.T) {
@@ -320,7 +378,7 @@ func TestSendFunds(t *testing.T) {
 		nodeParams testnodes.TestRetrievalClientNodeParams,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(nodeParams)
-		environment := &fakeEnvironment{node, nil, sendDataTransferVoucherError, nil}
+		environment := &fakeEnvironment{node, nil, sendDataTransferVoucherError, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.SendFunds(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -359,6 +417,37 @@ func TestSendFunds(t *testing.T) {
 		require.Equal(t, dealState.Status, retrievalmarket.DealStatusFinalizing)
 	})
 
+	t.Run("last payment for data that does not match the piece", func(t *testing.T) {
+		cids := testnet.GenerateCids(2)
+		dealState := makeDealState(retrievalmarket.DealStatusSendFundsLastPayment)
+		dealState.PieceCID = &cids[0]
+		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{
+			Voucher: testVoucher,
+		})
+		environment := &pieceEnvironment{&fakeEnvironment{node, nil, nil, nil, big.Zero()}, cids[1], nil}
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		err := clientstates.SendFunds(fsmCtx, environment, *dealState)
+		require.NoError(t, err)
+		fsmCtx.ReplayEvents(t, dealState)
+		require.Contains(t, dealState.Message, "retrieved data has piece CID")
+		require.False(t, dealState.VerifiedAgainstPiece)
+		require.Equal(t, dealState.FundsSpent, defaultFundsSpent)
+		require.Equal(t, dealState.PaymentRequested, defaultPaymentRequested)
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusFailing)
+	})
+
+	t.Run("payment disabled", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusSendFunds)
+		dealState.PaymentDisabled = true
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			Voucher: testVoucher,
+		}
+		runSendFunds(t, nil, nodeParams, dealState)
+		require.Equal(t, "payment requested for a deal with payment disabled", dealState.Message)
+		require.Equal(t, defaultFundsSpent, dealState.FundsSpent)
+		require.Equal(t, retrievalmarket.DealStatusFailing, dealState.Status)
+	})
+
 	t.Run("more bytes since last payment than interval works, can charge more", func(t *testing.T) {
 		dealState := makeDealState(retrievalmarket.DealStatusSendFunds)
 		dealState.BytesPaidFor = defaultBytesPaidFor - 500
@@ -431,6 +520,40 @@ func TestSendFunds(t *testing.T) {
 		require.Equal(t, dealState.Status, retrievalmarket.DealStatusCheckFunds)
 	})
 
+	t.Run("shortfall splits payment", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusSendFunds)
+		var sendVoucherError error = nil
+		partialPayment := abi.NewTokenAmount(300000)
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			Voucher:               testVoucher,
+			IntegrationTest:       true,
+			ChannelAvailableFunds: retrievalmarket.ChannelAvailableFunds{ConfirmedAmt: big.Add(defaultFundsSpent, partialPayment)},
+		}
+		runSendFunds(t, sendVoucherError, nodeParams, dealState)
+		require.Empty(t, dealState.Message)
+		require.Equal(t, dealState.PaymentRequested, abi.NewTokenAmount(0))
+		require.Equal(t, dealState.VoucherShortfall, big.Sub(defaultPaymentRequested, partialPayment))
+		require.Equal(t, dealState.FundsSpent, big.Add(defaultFundsSpent, partialPayment))
+		require.Equal(t, dealState.BytesPaidFor, defaultBytesPaidFor+600)
+		require.Equal(t, dealState.CurrentInterval, defaultCurrentInterval)
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusOngoing)
+	})
+
+	t.Run("shortfall on last payment is not split", func(t *testing.T) {
+		dealState := makeDealState(retrievalmarket.DealStatusSendFundsLastPayment)
+		var sendVoucherError error = nil
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			Voucher:               testVoucher,
+			IntegrationTest:       true,
+			ChannelAvailableFunds: retrievalmarket.ChannelAvailableFunds{ConfirmedAmt: big.Add(defaultFundsSpent, abi.NewTokenAmount(300000))},
+		}
+		runSendFunds(t, sendVoucherError, nodeParams, dealState)
+		require.Empty(t, dealState.Message)
+		require.Equal(t, dealState.FundsSpent, defaultFundsSpent)
+		require.Equal(t, dealState.VoucherShortfall, abi.NewTokenAmount(200000))
+		require.Equal(t, dealState.Status, retrievalmarket.DealStatusCheckFunds)
+	})
+
 	t.Run("unable to send payment", func(t *testing.T) {
 		dealState := makeDealState(retrievalmarket.DealStatusSendFunds)
 		sendVoucherError := errors.New("something went wrong")
@@ -451,7 +574,7 @@ func TestCheckFunds(t *testing.T) {
 		params testnodes.TestRetrievalClientNodeParams,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(params)
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.CheckFunds(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -508,6 +631,96 @@ func TestCheckFunds(t *testing.T) {
 	})
 }
 
+func TestTopUpFunds(t *testing.T) {
+	ctx := context.Background()
+	eventMachine, err := fsm.NewEventProcessor(retrievalmarket.ClientDealState{}, "Status", clientstates.ClientEvents)
+	require.NoError(t, err)
+	runTopUpFunds := func(t *testing.T,
+		limit abi.TokenAmount,
+		params testnodes.TestRetrievalClientNodeParams,
+		dealState *retrievalmarket.ClientDealState) {
+		node := testnodes.NewTestRetrievalClientNode(params)
+		environment := &fakeEnvironment{node, nil, nil, nil, limit}
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		err := clientstates.TopUpFunds(fsmCtx, environment, *dealState)
+		require.NoError(t, err)
+		fsmCtx.ReplayEvents(t, dealState)
+	}
+
+	makeInsufficientDeal := func() *retrievalmarket.ClientDealState {
+		dealState := makeDealState(retrievalmarket.DealStatusInsufficientFunds)
+		dealState.PaymentRequested = abi.NewTokenAmount(10000)
+		dealState.FundsToppedUp = abi.NewTokenAmount(0)
+		return dealState
+	}
+	channelFunds := rm.ChannelAvailableFunds{
+		ConfirmedAmt: abi.NewTokenAmount(6000),
+		PendingAmt:   abi.NewTokenAmount(1000),
+	}
+
+	t.Run("adds shortfall and rechecks funds", func(t *testing.T) {
+		dealState := makeInsufficientDeal()
+		var added abi.TokenAmount
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			ChannelAvailableFunds: channelFunds,
+			PaymentChannelRecorder: func(client, miner address.Address, amt abi.TokenAmount) {
+				added = amt
+			},
+		}
+		runTopUpFunds(t, abi.NewTokenAmount(5000), nodeParams, dealState)
+		require.Equal(t, abi.NewTokenAmount(3000), added)
+		require.Equal(t, abi.NewTokenAmount(3000), dealState.FundsToppedUp)
+		require.Equal(t, retrievalmarket.DealStatusCheckFunds, dealState.Status)
+	})
+
+	t.Run("deals without top ups recorded", func(t *testing.T) {
+		dealState := makeInsufficientDeal()
+		dealState.FundsToppedUp = abi.TokenAmount{}
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			ChannelAvailableFunds: channelFunds,
+		}
+		runTopUpFunds(t, abi.NewTokenAmount(5000), nodeParams, dealState)
+		require.Equal(t, abi.NewTokenAmount(3000), dealState.FundsToppedUp)
+		require.Equal(t, retrievalmarket.DealStatusCheckFunds, dealState.Status)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		dealState := makeInsufficientDeal()
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			ChannelAvailableFunds: channelFunds,
+		}
+		runTopUpFunds(t, big.Zero(), nodeParams, dealState)
+		require.Equal(t, abi.NewTokenAmount(0), dealState.FundsToppedUp)
+		require.Equal(t, retrievalmarket.DealStatusInsufficientFunds, dealState.Status)
+	})
+
+	t.Run("shortfall exceeds limit", func(t *testing.T) {
+		dealState := makeInsufficientDeal()
+		dealState.FundsToppedUp = abi.NewTokenAmount(4000)
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			ChannelAvailableFunds: channelFunds,
+			PaymentChannelRecorder: func(client, miner address.Address, amt abi.TokenAmount) {
+				t.Fatal("should not add funds")
+			},
+		}
+		runTopUpFunds(t, abi.NewTokenAmount(5000), nodeParams, dealState)
+		require.Equal(t, abi.NewTokenAmount(4000), dealState.FundsToppedUp)
+		require.Equal(t, retrievalmarket.DealStatusInsufficientFunds, dealState.Status)
+	})
+
+	t.Run("adding funds fails", func(t *testing.T) {
+		dealState := makeInsufficientDeal()
+		nodeParams := testnodes.TestRetrievalClientNodeParams{
+			ChannelAvailableFunds: channelFunds,
+			PayChErr:              errors.New("insufficient wallet balance"),
+		}
+		runTopUpFunds(t, abi.NewTokenAmount(5000), nodeParams, dealState)
+		require.Equal(t, abi.NewTokenAmount(0), dealState.FundsToppedUp)
+		require.Equal(t, retrievalmarket.DealStatusInsufficientFunds, dealState.Status)
+		require.Equal(t, "adding funds to payment channel: insufficient wallet balance", dealState.Message)
+	})
+}
+
 func TestCancelDeal(t *testing.T) {
 	ctx := context.Background()
 	eventMachine, err := fsm.NewEventProcessor(retrievalmarket.ClientDealState{}, "Status", clientstates.ClientEvents)
@@ -516,7 +729,7 @@ func TestCancelDeal(t *testing.T) {
 		closeError error,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
-		environment := &fakeEnvironment{node, nil, nil, closeError}
+		environment := &fakeEnvironment{node, nil, nil, closeError, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.CancelDeal(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -555,7 +768,7 @@ func TestCheckComplete(t *testing.T) {
 	runCheckComplete := func(t *testing.T,
 		dealState *retrievalmarket.ClientDealState) {
 		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
-		environment := &fakeEnvironment{node, nil, nil, nil}
+		environment := &fakeEnvironment{node, nil, nil, nil, big.Zero()}
 		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
 		err := clientstates.CheckComplete(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
@@ -576,6 +789,47 @@ func TestCheckComplete(t *testing.T) {
 		require.Equal(t, retrievalmarket.DealStatusErrored, dealState.Status)
 		require.Equal(t, "Provider sent complete status without sending all data", dealState.Message)
 	})
+
+	runVerifyPiece := func(t *testing.T, pieceCid cid.Cid, pieceErr error, dealState *retrievalmarket.ClientDealState) {
+		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
+		environment := &pieceEnvironment{&fakeEnvironment{node, nil, nil, nil, big.Zero()}, pieceCid, pieceErr}
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		err := clientstates.CheckComplete(fsmCtx, environment, *dealState)
+		require.NoError(t, err)
+		fsmCtx.ReplayEvents(t, dealState)
+	}
+
+	t.Run("when the retrieved data matches the piece", func(t *testing.T) {
+		pieceCid := testnet.GenerateCids(1)[0]
+		dealState := makeDealState(retrievalmarket.DealStatusCheckComplete)
+		dealState.AllBlocksReceived = true
+		dealState.PieceCID = &pieceCid
+		runVerifyPiece(t, pieceCid, nil, dealState)
+		require.Equal(t, retrievalmarket.DealStatusCompleted, dealState.Status)
+		require.True(t, dealState.VerifiedAgainstPiece)
+	})
+
+	t.Run("when the retrieved data does not match the piece", func(t *testing.T) {
+		cids := testnet.GenerateCids(2)
+		dealState := makeDealState(retrievalmarket.DealStatusCheckComplete)
+		dealState.AllBlocksReceived = true
+		dealState.PieceCID = &cids[0]
+		runVerifyPiece(t, cids[1], nil, dealState)
+		require.Equal(t, retrievalmarket.DealStatusErrored, dealState.Status)
+		require.False(t, dealState.VerifiedAgainstPiece)
+		require.Contains(t, dealState.Message, "retrieved data has piece CID")
+	})
+
+	t.Run("when the piece CID cannot be computed", func(t *testing.T) {
+		pieceCid := testnet.GenerateCids(1)[0]
+		dealState := makeDealState(retrievalmarket.DealStatusCheckComplete)
+		dealState.AllBlocksReceived = true
+		dealState.PieceCID = &pieceCid
+		runVerifyPiece(t, cid.Undef, errors.New("something went wrong"), dealState)
+		require.Equal(t, retrievalmarket.DealStatusCompleted, dealState.Status)
+		require.False(t, dealState.VerifiedAgainstPiece)
+		require.Contains(t, dealState.Message, "something went wrong")
+	})
 }
 
 var defaultTotalFunds = abi.NewTokenAmount(4000000)
@@ -0,0 +1,216 @@
+package retrievalimpl
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hannahhoward/go-pubsub"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/queryadmission"
+	"github.com/filecoin-project/go-fil-markets/shared"
+)
+
+// Config is the set of provider tunables that can be changed while a provider is
+// running. Read the current values with Provider.Config, change the fields that
+// need changing and pass the result to Provider.ApplyConfig
+type Config struct {
+	// Ask holds the deal parameters the provider accepts
+	Ask retrievalmarket.Ask
+	// DealDecider is the custom deal decision logic, or nil to accept all valid deals
+	DealDecider DealDecider
+	// QueryAdmission limits how many queries the provider answers at once, or nil to
+	// answer every query
+	QueryAdmission *queryadmission.Controller
+	// Maintenance rejects every new deal. Deals in progress carry on
+	Maintenance bool
+	// MaintenanceUntil is the epoch maintenance ends at. Zero or less keeps the
+	// provider in maintenance until Maintenance is unset
+	MaintenanceUntil abi.ChainEpoch
+	// MaintenanceWindows are scheduled spans of epochs during which the provider is
+	// in maintenance
+	MaintenanceWindows []shared.MaintenanceWindow
+	// AllowDeferredPayments lets clients short of funds defer part of a payment, up
+	// to one interval's worth, to their next payment
+	AllowDeferredPayments bool
+	// MaxUnpaidBytes is the most data the provider sends to a client that batches
+	// payments before asking for payment. Zero pays for each interval separately
+	MaxUnpaidBytes uint64
+	// DealRetention sets how long the records of deals that ended are kept
+	DealRetention retrievalmarket.DealRetentionPolicy
+}
+
+// ConfigChange is the event published when a provider's config is changed
+type ConfigChange struct {
+	Previous Config
+	Current  Config
+}
+
+// ConfigSubscriber is a callback that is called when a provider's config is changed
+type ConfigSubscriber func(ConfigChange)
+
+// maintenance returns when the config puts the provider in maintenance
+func (c Config) maintenance() shared.Maintenance {
+	return shared.Maintenance{Active: c.Maintenance, Until: c.MaintenanceUntil, Windows: c.MaintenanceWindows}
+}
+
+// setMaintenance sets when the config puts the provider in maintenance
+func (c *Config) setMaintenance(m shared.Maintenance) {
+	c.Maintenance = m.Active
+	c.MaintenanceUntil = m.Until
+	c.MaintenanceWindows = m.Windows
+}
+
+func (c Config) validate() error {
+	if err := c.maintenance().Validate(); err != nil {
+		return err
+	}
+	if c.DealRetention.MaxAge < 0 {
+		return xerrors.New("deal retention max age must not be negative")
+	}
+	if c.Ask.PricePerByte.Nil() || c.Ask.UnsealPrice.Nil() {
+		return xerrors.New("ask prices must be set")
+	}
+	if c.Ask.PricePerByte.LessThan(big.Zero()) || c.Ask.UnsealPrice.LessThan(big.Zero()) {
+		return xerrors.New("ask prices must not be negative")
+	}
+	if c.Ask.PaymentInterval == 0 {
+		return xerrors.New("ask payment interval must be positive")
+	}
+	return nil
+}
+
+func askEqual(a, b retrievalmarket.Ask) bool {
+	if a.PricePerByte.Nil() || a.UnsealPrice.Nil() || b.PricePerByte.Nil() || b.UnsealPrice.Nil() {
+		return false
+	}
+	return a.PricePerByte.Equals(b.PricePerByte) &&
+		a.UnsealPrice.Equals(b.UnsealPrice) &&
+		a.PaymentInterval == b.PaymentInterval &&
+		a.PaymentIntervalIncrease == b.PaymentIntervalIncrease &&
+		a.PriorityMultiplier == b.PriorityMultiplier
+}
+
+// Config returns the provider's current tunables
+func (p *Provider) Config() Config {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.config()
+}
+
+// ApplyConfig replaces the provider's tunables with the given config while the
+// provider is running. Either all of the config is applied or, if it is invalid or
+// the ask cannot be saved, none of it is. Subscribers to config changes are notified
+// once the config is applied
+func (p *Provider) ApplyConfig(cfg Config) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if err := cfg.validate(); err != nil {
+		return xerrors.Errorf("invalid provider config: %w", err)
+	}
+
+	p.configLk.Lock()
+	previous := p.config()
+	if !askEqual(previous.Ask, cfg.Ask) {
+		ask := cfg.Ask
+		if err := p.askStore.SetAsk(&ask); err != nil {
+			p.configLk.Unlock()
+			return xerrors.Errorf("setting ask: %w", err)
+		}
+	}
+	p.dealDecider = cfg.DealDecider
+	p.queryAdmission = cfg.QueryAdmission
+	p.maintenance = cfg.maintenance()
+	p.allowDeferredPayments = cfg.AllowDeferredPayments
+	p.maxUnpaidBytes = cfg.MaxUnpaidBytes
+	p.dealRetention = cfg.DealRetention
+	current := p.config()
+	p.configLk.Unlock()
+
+	if err := p.configSub.Publish(ConfigChange{Previous: previous, Current: current}); err != nil {
+		log.Errorf("failed to publish config change: %s", err)
+	}
+	return nil
+}
+
+// EnterMaintenance puts the provider in maintenance straight away, until the given
+// epoch or, if it is zero, until ExitMaintenance is called. New deals are rejected
+// while the provider is in maintenance, but deals in progress carry on
+func (p *Provider) EnterMaintenance(until abi.ChainEpoch) error {
+	cfg := p.Config()
+	cfg.Maintenance = true
+	cfg.MaintenanceUntil = until
+	return p.ApplyConfig(cfg)
+}
+
+// ExitMaintenance takes the provider out of maintenance straight away, including
+// ending early any scheduled maintenance window in progress
+func (p *Provider) ExitMaintenance(ctx context.Context) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	_, curEpoch, err := p.node.GetChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cfg := p.Config()
+	cfg.setMaintenance(cfg.maintenance().Exit(curEpoch))
+	return p.ApplyConfig(cfg)
+}
+
+// currentMaintenance returns when the provider is in maintenance
+func (p *Provider) currentMaintenance() shared.Maintenance {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.maintenance
+}
+
+// SubscribeToConfigChanges registers a listener that is called each time the
+// provider's config is changed with ApplyConfig
+func (p *Provider) SubscribeToConfigChanges(subscriber ConfigSubscriber) retrievalmarket.Unsubscribe {
+	return retrievalmarket.Unsubscribe(p.configSub.Subscribe(subscriber))
+}
+
+// config must be called with configLk held
+func (p *Provider) config() Config {
+	cfg := Config{
+		DealDecider:           p.dealDecider,
+		QueryAdmission:        p.queryAdmission,
+		Maintenance:           p.maintenance.Active,
+		MaintenanceUntil:      p.maintenance.Until,
+		MaintenanceWindows:    p.maintenance.Windows,
+		AllowDeferredPayments: p.allowDeferredPayments,
+		MaxUnpaidBytes:        p.maxUnpaidBytes,
+		DealRetention:         p.dealRetention,
+	}
+	if ask := p.askStore.GetAsk(); ask != nil {
+		cfg.Ask = *ask
+	}
+	return cfg
+}
+
+// admission returns the query admission controller, or nil if queries are not limited
+func (p *Provider) admission() *queryadmission.Controller {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.queryAdmission
+}
+
+func configDispatcher(evt pubsub.Event, fn pubsub.SubscriberFn) error {
+	change, ok := evt.(ConfigChange)
+	if !ok {
+		return errors.New("wrong type of event")
+	}
+	cb, ok := fn.(ConfigSubscriber)
+	if !ok {
+		return errors.New("wrong type of event")
+	}
+	cb(change)
+	return nil
+}
@@ -0,0 +1,43 @@
+package retrievalimpl
+
+import (
+	"github.com/ipfs/go-datastore"
+
+	"github.com/filecoin-project/go-fil-markets/shared/eventstore"
+)
+
+// LogProviderDeals keeps the provider's deals as a log of their changes, with a
+// snapshot of the whole deal every snapshotEvery entries, rather than overwriting each
+// deal on every update, such as on each payment. History and ValueAt of an
+// eventstore.Datastore wrapping the same datastore show how a deal came to its current
+// state. A deal's log is removed when the deal is deleted. It must be passed to
+// NewProvider, and to NewReadOnlyProvider when opening the deals of a provider that
+// logs them
+func LogProviderDeals(snapshotEvery uint64, options ...eventstore.Option) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.logDeals = eventLog(snapshotEvery, options)
+	}
+}
+
+// LogClientDeals keeps the client's deals as a log of their changes, like
+// LogProviderDeals does for a provider. It must be passed to NewClient
+func LogClientDeals(snapshotEvery uint64, options ...eventstore.Option) RetrievalClientOption {
+	return func(c *Client) {
+		c.logDeals = eventLog(snapshotEvery, options)
+	}
+}
+
+func eventLog(snapshotEvery uint64, options []eventstore.Option) func(datastore.Batching) datastore.Batching {
+	return func(ds datastore.Batching) datastore.Batching {
+		return eventstore.New(ds, snapshotEvery, options...)
+	}
+}
+
+// dealsDatastore returns the datastore deals are kept in, which is ds wrapped in an
+// event log if logDeals is set
+func dealsDatastore(ds datastore.Batching, logDeals func(datastore.Batching) datastore.Batching) datastore.Batching {
+	if logDeals == nil {
+		return ds
+	}
+	return logDeals(ds)
+}
@@ -0,0 +1,220 @@
+package retrievalimpl
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// migratableStatuses are the states of the in-flight deals ExportDeals writes.
+// Deals that have not been accepted yet, or are being cleaned up, are left behind
+var migratableStatuses = map[retrievalmarket.DealStatus]bool{
+	retrievalmarket.DealStatusFundsNeededUnseal:      true,
+	retrievalmarket.DealStatusUnsealing:              true,
+	retrievalmarket.DealStatusTransferQueued:         true,
+	retrievalmarket.DealStatusUnsealed:               true,
+	retrievalmarket.DealStatusOngoing:                true,
+	retrievalmarket.DealStatusFundsNeeded:            true,
+	retrievalmarket.DealStatusBlocksComplete:         true,
+	retrievalmarket.DealStatusFundsNeededLastPayment: true,
+	retrievalmarket.DealStatusFinalizing:             true,
+}
+
+// unsealedStatuses are the states in which a deal's data has been unsealed into its
+// store, and is exported with it
+var unsealedStatuses = map[retrievalmarket.DealStatus]bool{
+	retrievalmarket.DealStatusUnsealed:               true,
+	retrievalmarket.DealStatusOngoing:                true,
+	retrievalmarket.DealStatusFundsNeeded:            true,
+	retrievalmarket.DealStatusBlocksComplete:         true,
+	retrievalmarket.DealStatusFundsNeededLastPayment: true,
+}
+
+/*
+ExportDeals writes the provider's in-flight deals to w, so that a replacement
+provider instance can take over serving them with ImportDeals, keeping what clients
+have paid for and been sent.
+
+Each deal is written with the number of bytes the provider has sent on its channel,
+which may be ahead of the deal's last recorded total, and with the blocks unsealed
+for it, if any. Deals that have not been accepted yet, or are being cleaned up, are
+not exported. The provider must be stopped first, so that deals do not progress
+after they are exported.
+
+Deals keep their data transfer channel IDs, which name the provider's peer, so the
+replacement instance must use the same peer ID, and take over the data transfer
+manager's channel records, for clients to restart the transfers.
+*/
+func (p *Provider) ExportDeals(ctx context.Context, w io.Writer) ([]retrievalmarket.ProviderDealIdentifier, error) {
+	if p.readOnly {
+		return nil, ErrReadOnly
+	}
+	var deals []retrievalmarket.ProviderDealState
+	if err := p.stateMachines.List(&deals); err != nil {
+		return nil, xerrors.Errorf("listing deals: %w", err)
+	}
+	var exported []retrievalmarket.ProviderDealIdentifier
+	for _, deal := range deals {
+		if !migratableStatuses[deal.Status] {
+			continue
+		}
+		if sent, ok := p.revalidator.TotalSent(deal.ChannelID); ok && sent > deal.TotalSent {
+			deal.TotalSent = sent
+		}
+		if err := cborutil.WriteCborRPC(w, &deal); err != nil {
+			return exported, xerrors.Errorf("writing deal %s: %w", deal.Identifier(), err)
+		}
+		var bs blockstore.Blockstore
+		if unsealedStatuses[deal.Status] {
+			store, err := p.multiStore.Get(deal.StoreID)
+			if err != nil {
+				return exported, xerrors.Errorf("opening store of deal %s: %w", deal.Identifier(), err)
+			}
+			bs = store.Bstore
+		}
+		if err := writeBlocks(ctx, w, bs); err != nil {
+			return exported, xerrors.Errorf("writing blocks of deal %s: %w", deal.Identifier(), err)
+		}
+		exported = append(exported, deal.Identifier())
+	}
+	return exported, nil
+}
+
+/*
+ImportDeals reads deals written by ExportDeals on another provider instance and
+resumes serving them. Each deal is given a new store for its blocks.
+
+Deals that were unsealing, or waiting for a transfer slot to, are queued for a
+transfer slot again, and unseal their data on this instance. Other deals have their
+channels tracked again, and carry on from the bytes sent and funds received on the
+exporting instance once their clients restart the transfers. Importing stops at the
+first deal that fails, such as one the provider already has, and returns the deals
+imported before it.
+*/
+func (p *Provider) ImportDeals(ctx context.Context, r io.Reader) ([]retrievalmarket.ProviderDealIdentifier, error) {
+	if p.readOnly {
+		return nil, ErrReadOnly
+	}
+	br := bufio.NewReader(r)
+	var imported []retrievalmarket.ProviderDealIdentifier
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return imported, nil
+		}
+		var deal retrievalmarket.ProviderDealState
+		if err := cborutil.ReadCborRPC(br, &deal); err != nil {
+			return imported, xerrors.Errorf("reading deal: %w", err)
+		}
+		if err := p.importDeal(br, deal); err != nil {
+			return imported, xerrors.Errorf("importing deal %s: %w", deal.Identifier(), err)
+		}
+		imported = append(imported, deal.Identifier())
+	}
+}
+
+func (p *Provider) importDeal(br *bufio.Reader, deal retrievalmarket.ProviderDealState) error {
+	storeID := p.multiStore.Next()
+	store, err := p.multiStore.Get(storeID)
+	if err != nil {
+		return xerrors.Errorf("creating store: %w", err)
+	}
+	if err := readBlocks(br, store.Bstore); err != nil {
+		_ = p.multiStore.Delete(storeID)
+		return xerrors.Errorf("reading blocks: %w", err)
+	}
+	deal.StoreID = storeID
+
+	unsealing := deal.Status == retrievalmarket.DealStatusUnsealing || deal.Status == retrievalmarket.DealStatusTransferQueued
+	if unsealing {
+		deal.Status = retrievalmarket.DealStatusTransferQueued
+	}
+	if err := p.stateMachines.Begin(deal.Identifier(), &deal); err != nil {
+		_ = p.multiStore.Delete(storeID)
+		return err
+	}
+	if unsealing {
+		return p.stateMachines.Send(deal.Identifier(), retrievalmarket.ProviderEventTransferSlotOpened)
+	}
+	p.revalidator.TrackChannel(deal)
+	return nil
+}
+
+// writeBlocks writes the number of blocks in bs, followed by the CID and data of
+// each. A nil blockstore is written as having no blocks
+func writeBlocks(ctx context.Context, w io.Writer, bs blockstore.Blockstore) error {
+	var keys []cid.Cid
+	if bs != nil {
+		keysCh, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			return err
+		}
+		for k := range keysCh {
+			keys = append(keys, k)
+		}
+	}
+	if err := cbg.WriteMajorTypeHeader(w, cbg.MajArray, uint64(len(keys))); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		blk, err := bs.Get(k)
+		if err != nil {
+			return xerrors.Errorf("reading block %s: %w", k, err)
+		}
+		if err := cbg.WriteCid(w, k); err != nil {
+			return err
+		}
+		if err := cbg.WriteMajorTypeHeader(w, cbg.MajByteString, uint64(len(blk.RawData()))); err != nil {
+			return err
+		}
+		if _, err := w.Write(blk.RawData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBlocks reads blocks written by writeBlocks into bs
+func readBlocks(br *bufio.Reader, bs blockstore.Blockstore) error {
+	maj, count, err := cbg.CborReadHeader(br)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajArray {
+		return xerrors.New("expected array of blocks")
+	}
+	for i := uint64(0); i < count; i++ {
+		c, err := cbg.ReadCid(br)
+		if err != nil {
+			return err
+		}
+		maj, size, err := cbg.CborReadHeader(br)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajByteString {
+			return xerrors.Errorf("expected data of block %s", c)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return err
+		}
+		if err := bs.Put(blk); err != nil {
+			return xerrors.Errorf("storing block %s: %w", c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package retrievalimpl
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/dealretention"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+)
+
+// dealCompactionInterval is how often a running provider removes the records of
+// deals that are past its retention policy
+const dealCompactionInterval = time.Hour
+
+// DealRetention removes the records of deals that ended, as completed, errored or
+// cancelled, longer ago than the policy's MaxAge, every hour while the provider is
+// running. If archive is not nil, each record is copied to it before it is removed.
+// The policy can be changed later through Config.DealRetention
+func DealRetention(policy retrievalmarket.DealRetentionPolicy, archive datastore.Batching) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.dealRetention = policy
+		p.dealArchive = archive
+	}
+}
+
+// CompactDeals removes the records of deals that are past the provider's retention
+// policy straight away, rather than waiting for the next hourly run. The logs of
+// deals removed from a provider started with LogProviderDeals are removed with them.
+// It does nothing if the policy keeps records forever
+func (p *Provider) CompactDeals(ctx context.Context) (dealretention.Report, error) {
+	if p.readOnly {
+		return dealretention.Report{}, ErrReadOnly
+	}
+	policy := p.retentionPolicy()
+	if policy.MaxAge <= 0 {
+		return dealretention.Report{}, nil
+	}
+	dealsDs, err := migrationtools.AtVersion(p.dealsDs, versioning.VersionKey("1"))
+	if err != nil {
+		return dealretention.Report{}, xerrors.Errorf("opening retrieval provider deals: %w", err)
+	}
+	report, err := p.dealEnds.Compact(ctx, dealsDs, p.dealArchive, policy)
+	if len(report.Removed) > 0 {
+		log.Infof("removed the records of %d retrieval deals past the retention policy", len(report.Removed))
+	}
+	return report, err
+}
+
+// retentionPolicy returns the policy for keeping the records of deals that ended
+func (p *Provider) retentionPolicy() retrievalmarket.DealRetentionPolicy {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.dealRetention
+}
+
+// recordDealEnded notes when a deal ends, so that its record can be removed once it
+// is past the retention policy
+func (p *Provider) recordDealEnded(deal retrievalmarket.ProviderDealState) {
+	if p.readOnly || !dealretention.Ended(deal.Status) || p.retentionPolicy().MaxAge <= 0 {
+		return
+	}
+	if err := p.dealEnds.DealEnded(deal.Identifier()); err != nil {
+		log.Errorf("recording end of retrieval deal %s: %s", deal.Identifier(), err)
+	}
+}
+
+// startDealCompaction removes the records of deals past the retention policy every
+// dealCompactionInterval until stopDealCompaction is called
+func (p *Provider) startDealCompaction() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopCompaction = cancel
+	p.compactionDone = make(chan struct{})
+	go func() {
+		defer close(p.compactionDone)
+		ticker := time.NewTicker(dealCompactionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := p.CompactDeals(ctx); err != nil && ctx.Err() == nil {
+					log.Errorf("removing retrieval deals past the retention policy: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopDealCompaction stops the periodic compaction, interrupting one that is running
+func (p *Provider) stopDealCompaction() {
+	if p.stopCompaction == nil {
+		return
+	}
+	p.stopCompaction()
+	<-p.compactionDone
+	p.stopCompaction = nil
+}
@@ -0,0 +1,176 @@
+/*
+Package dealretention removes the records of retrieval deals that ended long ago, so
+that a provider serving many retrievals does not accumulate records of completed
+deals without end, bloating its datastore and slowing down listing its deals.
+
+A Tracker records when each deal ends, in its own datastore so that the times
+survive restarts. Compact removes the records of deals that ended longer ago than a
+DealRetentionPolicy's MaxAge, unless the policy excludes them, optionally copying
+them to an archive datastore first. A deal that ended before its end was recorded,
+such as before the provider kept a retention policy, is taken to have ended the
+first time Compact sees it.
+*/
+package dealretention
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-statestore"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+var endedPrefix = datastore.NewKey("ended")
+
+// Report is the result of a Compact
+type Report struct {
+	// Removed are the deals whose records were removed
+	Removed []retrievalmarket.ProviderDealIdentifier
+	// Archived is true if the removed records were copied to an archive first
+	Archived bool
+	// Kept is the number of records of ended deals that were kept, because they are
+	// not old enough or are excluded
+	Kept int
+}
+
+// Tracker records when retrieval deals ended, and removes the records of deals that
+// ended long ago
+type Tracker struct {
+	ds datastore.Batching
+
+	lk  sync.Mutex
+	now func() time.Time
+}
+
+// New returns a Tracker that keeps the times deals ended in the given datastore
+func New(ds datastore.Batching) *Tracker {
+	return &Tracker{ds: ds, now: time.Now}
+}
+
+// Ended returns true for the states a provider's deals end in
+func Ended(status retrievalmarket.DealStatus) bool {
+	return status == retrievalmarket.DealStatusCompleted ||
+		status == retrievalmarket.DealStatusErrored ||
+		status == retrievalmarket.DealStatusCancelled
+}
+
+// DealEnded records that a deal ended now, unless its end was already recorded
+func (t *Tracker) DealEnded(deal retrievalmarket.ProviderDealIdentifier) error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	_, err := t.endedAt(deal)
+	return err
+}
+
+// Compact removes from the deal records in dealsDs those of the deals that ended
+// longer ago than the policy's MaxAge and that the policy does not exclude. If
+// archive is not nil, each record is copied to it before it is removed
+func (t *Tracker) Compact(ctx context.Context, dealsDs datastore.Batching, archive datastore.Batching, policy retrievalmarket.DealRetentionPolicy) (Report, error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	report := Report{Archived: archive != nil}
+	deals := statestore.New(dealsDs)
+	var all []retrievalmarket.ProviderDealState
+	if err := deals.List(&all); err != nil {
+		return report, xerrors.Errorf("listing deals: %w", err)
+	}
+
+	excluded := make(map[retrievalmarket.ProviderDealIdentifier]struct{}, len(policy.Exclude))
+	for _, deal := range policy.Exclude {
+		excluded[deal] = struct{}{}
+	}
+	excludedClients := make(map[peer.ID]struct{}, len(policy.ExcludeClients))
+	for _, client := range policy.ExcludeClients {
+		excludedClients[client] = struct{}{}
+	}
+
+	now := t.now()
+	for _, deal := range all {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if !Ended(deal.Status) {
+			continue
+		}
+		id := deal.Identifier()
+		endedAt, err := t.endedAt(id)
+		if err != nil {
+			return report, err
+		}
+		_, isExcluded := excluded[id]
+		_, isExcludedClient := excludedClients[deal.Receiver]
+		if policy.MaxAge <= 0 || isExcluded || isExcludedClient || now.Sub(endedAt) < policy.MaxAge {
+			report.Kept++
+			continue
+		}
+
+		if archive != nil {
+			if err := archiveDeal(statestore.New(archive), deal); err != nil {
+				return report, err
+			}
+		}
+		if err := deals.Get(id).End(); err != nil {
+			return report, xerrors.Errorf("removing record of deal %s: %w", id, err)
+		}
+		if err := t.ds.Delete(endedKey(id)); err != nil {
+			return report, xerrors.Errorf("removing end time of deal %s: %w", id, err)
+		}
+		report.Removed = append(report.Removed, id)
+	}
+	return report, nil
+}
+
+// endedAt returns the time a deal ended, recording now if its end was not recorded.
+// It must be called with lk held
+func (t *Tracker) endedAt(deal retrievalmarket.ProviderDealIdentifier) (time.Time, error) {
+	var endedAt time.Time
+	value, err := t.ds.Get(endedKey(deal))
+	switch err {
+	case nil:
+		if err := json.Unmarshal(value, &endedAt); err != nil {
+			return endedAt, xerrors.Errorf("decoding end time of deal %s: %w", deal, err)
+		}
+		return endedAt, nil
+	case datastore.ErrNotFound:
+	default:
+		return endedAt, xerrors.Errorf("loading end time of deal %s: %w", deal, err)
+	}
+
+	endedAt = t.now()
+	value, err = json.Marshal(endedAt)
+	if err != nil {
+		return endedAt, err
+	}
+	if err := t.ds.Put(endedKey(deal), value); err != nil {
+		return endedAt, xerrors.Errorf("saving end time of deal %s: %w", deal, err)
+	}
+	return endedAt, nil
+}
+
+// archiveDeal copies a deal's record to the archive, unless it is already there
+func archiveDeal(archive *statestore.StateStore, deal retrievalmarket.ProviderDealState) error {
+	id := deal.Identifier()
+	has, err := archive.Has(id)
+	if err != nil {
+		return xerrors.Errorf("checking archive for deal %s: %w", id, err)
+	}
+	if has {
+		return nil
+	}
+	if err := archive.Begin(id, &deal); err != nil {
+		return xerrors.Errorf("archiving deal %s: %w", id, err)
+	}
+	return nil
+}
+
+func endedKey(deal retrievalmarket.ProviderDealIdentifier) datastore.Key {
+	return endedPrefix.ChildString(deal.Receiver.String()).ChildString(deal.DealID.String())
+}
@@ -0,0 +1,122 @@
+package dealretention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-statestore"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	peers := shared_testutil.GeneratePeers(2)
+	payloadCID := shared_testutil.GenerateCids(1)[0]
+	statuses := []retrievalmarket.DealStatus{
+		retrievalmarket.DealStatusCompleted,
+		retrievalmarket.DealStatusErrored,
+		retrievalmarket.DealStatusCancelled,
+		retrievalmarket.DealStatusOngoing,
+	}
+
+	setup := func(t *testing.T) (*Tracker, datastore.Batching, *time.Time) {
+		dealsDs := dss.MutexWrap(datastore.NewMapDatastore())
+		deals := statestore.New(dealsDs)
+		for i, status := range statuses {
+			deal := retrievalmarket.ProviderDealState{
+				DealProposal:  retrievalmarket.DealProposal{PayloadCID: payloadCID, ID: retrievalmarket.DealID(i)},
+				Status:        status,
+				Receiver:      peers[i%2],
+				FundsReceived: big.Zero(),
+			}
+			require.NoError(t, deals.Begin(deal.Identifier(), &deal))
+		}
+		now := time.Now()
+		tracker := New(dss.MutexWrap(datastore.NewMapDatastore()))
+		tracker.now = func() time.Time { return now }
+		return tracker, dealsDs, &now
+	}
+	id := func(i int) retrievalmarket.ProviderDealIdentifier {
+		return retrievalmarket.ProviderDealIdentifier{Receiver: peers[i%2], DealID: retrievalmarket.DealID(i)}
+	}
+	remaining := func(t *testing.T, dealsDs datastore.Batching) int {
+		var deals []retrievalmarket.ProviderDealState
+		require.NoError(t, statestore.New(dealsDs).List(&deals))
+		return len(deals)
+	}
+
+	t.Run("removes deals that ended longer ago than the max age", func(t *testing.T) {
+		tracker, dealsDs, now := setup(t)
+		policy := retrievalmarket.DealRetentionPolicy{MaxAge: time.Hour}
+
+		// deals with no recorded end are taken to have ended now
+		require.NoError(t, tracker.DealEnded(id(0)))
+		report, err := tracker.Compact(ctx, dealsDs, nil, policy)
+		require.NoError(t, err)
+		require.Empty(t, report.Removed)
+		require.Equal(t, 3, report.Kept)
+
+		*now = now.Add(time.Hour)
+		report, err = tracker.Compact(ctx, dealsDs, nil, policy)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []retrievalmarket.ProviderDealIdentifier{id(0), id(1), id(2)}, report.Removed)
+		require.False(t, report.Archived)
+		require.Equal(t, 1, remaining(t, dealsDs))
+	})
+
+	t.Run("keeps excluded deals", func(t *testing.T) {
+		tracker, dealsDs, now := setup(t)
+		policy := retrievalmarket.DealRetentionPolicy{
+			MaxAge:         time.Hour,
+			Exclude:        []retrievalmarket.ProviderDealIdentifier{id(0)},
+			ExcludeClients: []peer.ID{peers[1]},
+		}
+		_, err := tracker.Compact(ctx, dealsDs, nil, policy)
+		require.NoError(t, err)
+
+		*now = now.Add(2 * time.Hour)
+		report, err := tracker.Compact(ctx, dealsDs, nil, policy)
+		require.NoError(t, err)
+		require.Equal(t, []retrievalmarket.ProviderDealIdentifier{id(2)}, report.Removed)
+		require.Equal(t, 2, report.Kept)
+	})
+
+	t.Run("keeps every deal without a max age", func(t *testing.T) {
+		tracker, dealsDs, now := setup(t)
+		_, err := tracker.Compact(ctx, dealsDs, nil, retrievalmarket.DealRetentionPolicy{})
+		require.NoError(t, err)
+		*now = now.Add(365 * 24 * time.Hour)
+		report, err := tracker.Compact(ctx, dealsDs, nil, retrievalmarket.DealRetentionPolicy{})
+		require.NoError(t, err)
+		require.Empty(t, report.Removed)
+		require.Equal(t, len(statuses), remaining(t, dealsDs))
+	})
+
+	t.Run("archives removed deals", func(t *testing.T) {
+		tracker, dealsDs, now := setup(t)
+		archive := dss.MutexWrap(datastore.NewMapDatastore())
+		policy := retrievalmarket.DealRetentionPolicy{MaxAge: time.Hour}
+		_, err := tracker.Compact(ctx, dealsDs, archive, policy)
+		require.NoError(t, err)
+
+		*now = now.Add(time.Hour)
+		report, err := tracker.Compact(ctx, dealsDs, archive, policy)
+		require.NoError(t, err)
+		require.True(t, report.Archived)
+		require.Len(t, report.Removed, 3)
+
+		var archived retrievalmarket.ProviderDealState
+		require.NoError(t, statestore.New(archive).Get(id(1)).Get(&archived))
+		require.Equal(t, retrievalmarket.DealStatusErrored, archived.Status)
+		require.Equal(t, 3, remaining(t, archive))
+	})
+}
@@ -0,0 +1,70 @@
+package retrievalimpl
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared"
+)
+
+// DefaultExpectedDwellTimes are the times deals are expected to stay in a state at
+// most, past which DealSummary reports them as stuck. States that are not listed,
+// such as the one a deal is in while its data is sent, are never stuck
+var DefaultExpectedDwellTimes = map[retrievalmarket.DealStatus]time.Duration{
+	retrievalmarket.DealStatusNew:                    10 * time.Minute,
+	retrievalmarket.DealStatusUnsealing:              4 * time.Hour,
+	retrievalmarket.DealStatusUnsealed:               10 * time.Minute,
+	retrievalmarket.DealStatusFundsNeededUnseal:      time.Hour,
+	retrievalmarket.DealStatusFundsNeeded:            time.Hour,
+	retrievalmarket.DealStatusFundsNeededLastPayment: time.Hour,
+	retrievalmarket.DealStatusBlocksComplete:         time.Hour,
+	retrievalmarket.DealStatusFinalizing:             time.Hour,
+	retrievalmarket.DealStatusCompleting:             10 * time.Minute,
+	retrievalmarket.DealStatusFailing:                10 * time.Minute,
+	retrievalmarket.DealStatusCancelling:             10 * time.Minute,
+}
+
+// ExpectedDwellTimes sets the times deals are expected to stay in the given states
+// at most, replacing the defaults for those states. A time of zero means deals are
+// never stuck in that state
+func ExpectedDwellTimes(times map[retrievalmarket.DealStatus]time.Duration) RetrievalProviderOption {
+	return func(provider *Provider) {
+		for state, dwell := range times {
+			provider.expectedDwellTimes[state] = dwell
+		}
+	}
+}
+
+// DealSummary returns the number of deals in each state, the deal that has been in
+// each state the longest and how many deals have been in their state for longer than
+// expected. The time a deal entered its state is kept in memory, so deals that have
+// not changed state since the provider started are taken to have entered their
+// state when it started
+func (p *Provider) DealSummary() (retrievalmarket.DealSummary, error) {
+	var deals []retrievalmarket.ProviderDealState
+	if err := p.stateMachines.List(&deals); err != nil {
+		return retrievalmarket.DealSummary{}, err
+	}
+
+	now := time.Now()
+	summary := retrievalmarket.DealSummary{
+		States: make(map[retrievalmarket.DealStatus]retrievalmarket.DealStateSummary),
+		Total:  len(deals),
+		Time:   now,
+	}
+	for _, deal := range deals {
+		entered := p.stateTimes.Entered(deal.Identifier(), deal.Status)
+		ss := summary.States[deal.Status]
+		ss.Count++
+		if ss.OldestSince.IsZero() || entered.Before(ss.OldestSince) {
+			ss.OldestDeal = deal.Identifier()
+			ss.OldestSince = entered
+		}
+		if shared.Stuck(entered, p.expectedDwellTimes[deal.Status], now) {
+			ss.Stuck++
+			summary.Stuck++
+		}
+		summary.States[deal.Status] = ss
+	}
+	return summary, nil
+}
@@ -0,0 +1,129 @@
+package retrievalimpl
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// DefaultDealTimeouts sets the limits on how long each retrieval deal may run before
+// the client cancels it, freeing the funds the deal holds in its payment channel.
+// Deals can be given their own limits with SetDealTimeouts
+func DefaultDealTimeouts(timeouts retrievalmarket.DealTimeouts) RetrievalClientOption {
+	return func(c *Client) {
+		c.dealTimeouts = timeouts
+	}
+}
+
+// dealTimer cancels a deal once it exceeds its timeouts
+type dealTimer struct {
+	timeouts retrievalmarket.DealTimeouts
+	started  time.Time
+	received uint64
+	total    *time.Timer
+	stall    *time.Timer
+}
+
+func (t *dealTimer) stop() {
+	if t.total != nil {
+		t.total.Stop()
+	}
+	if t.stall != nil {
+		t.stall.Stop()
+	}
+}
+
+/*
+SetDealTimeouts replaces the limits on how long an in progress deal may run before
+the client cancels it. MaxDuration counts from when the deal was started, and
+MaxStall from now.
+
+When a deal exceeds a limit, the client records the limit in the deal's
+TimeoutReason and cancels the deal as CancelDeal does. Limits are not kept across
+restarts of the client.
+*/
+func (c *Client) SetDealTimeouts(dealID retrievalmarket.DealID, timeouts retrievalmarket.DealTimeouts) error {
+	c.timersLk.Lock()
+	defer c.timersLk.Unlock()
+	timer, ok := c.timers[dealID]
+	if !ok {
+		return xerrors.Errorf("deal %d is not in progress", dealID)
+	}
+	timer.stop()
+	replacement := &dealTimer{started: timer.started, received: timer.received}
+	c.timers[dealID] = replacement
+	c.armDealTimer(dealID, replacement, timeouts)
+	return nil
+}
+
+// startDealTimer starts applying the client's default timeouts to a new deal
+func (c *Client) startDealTimer(dealID retrievalmarket.DealID) {
+	c.timersLk.Lock()
+	defer c.timersLk.Unlock()
+	timer := &dealTimer{started: time.Now()}
+	c.timers[dealID] = timer
+	c.armDealTimer(dealID, timer, c.dealTimeouts)
+}
+
+// armDealTimer sets the timers for a deal's timeouts. It must be called with timersLk held
+func (c *Client) armDealTimer(dealID retrievalmarket.DealID, timer *dealTimer, timeouts retrievalmarket.DealTimeouts) {
+	timer.timeouts = timeouts
+	if timeouts.MaxDuration > 0 {
+		timer.total = time.AfterFunc(timeouts.MaxDuration-time.Since(timer.started), func() {
+			c.timeOut(dealID, timer, retrievalmarket.DealTimeoutMaxDuration, timeouts.MaxDuration)
+		})
+	}
+	if timeouts.MaxStall > 0 {
+		timer.stall = time.AfterFunc(timeouts.MaxStall, func() {
+			c.timeOut(dealID, timer, retrievalmarket.DealTimeoutMaxStall, timeouts.MaxStall)
+		})
+	}
+}
+
+// dealProgressed restarts a deal's stall timer when it has received more data
+func (c *Client) dealProgressed(dealID retrievalmarket.DealID, received uint64) {
+	c.timersLk.Lock()
+	defer c.timersLk.Unlock()
+	timer, ok := c.timers[dealID]
+	if !ok || received <= timer.received {
+		return
+	}
+	timer.received = received
+	if timer.stall != nil {
+		timer.stall.Reset(timer.timeouts.MaxStall)
+	}
+}
+
+// stopDealTimer stops applying timeouts to a deal that has finished
+func (c *Client) stopDealTimer(dealID retrievalmarket.DealID) {
+	c.timersLk.Lock()
+	defer c.timersLk.Unlock()
+	if timer, ok := c.timers[dealID]; ok {
+		timer.stop()
+		delete(c.timers, dealID)
+	}
+}
+
+// timeOut cancels a deal that exceeded one of its timeouts
+func (c *Client) timeOut(dealID retrievalmarket.DealID, timer *dealTimer, reason retrievalmarket.DealTimeoutReason, limit time.Duration) {
+	c.timersLk.Lock()
+	// the deal may have finished, or had its timeouts replaced, in the meantime
+	if c.timers[dealID] != timer {
+		c.timersLk.Unlock()
+		return
+	}
+	timer.stop()
+	delete(c.timers, dealID)
+	c.timersLk.Unlock()
+
+	log.Warnf("cancelling retrieval deal %d: %s", dealID, (&retrievalmarket.DealTimeoutError{Reason: reason, Limit: limit}).Error())
+	err := c.stateMachines.Send(dealID, retrievalmarket.ClientEventTimedOut, &retrievalmarket.DealTimeoutError{Reason: reason, Limit: limit})
+	if err == nil {
+		err = c.stateMachines.Send(dealID, retrievalmarket.ClientEventCancel)
+	}
+	if err != nil {
+		log.Errorf("cancelling retrieval deal %d after timing out: %s", dealID, err)
+	}
+}
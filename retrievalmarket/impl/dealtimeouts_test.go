@@ -0,0 +1,92 @@
+package retrievalimpl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-storedcounter"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestDealTimeouts(t *testing.T) {
+	ctx := context.Background()
+	payloadCID := tut.GenerateCids(1)[0]
+	params := retrievalmarket.NewParamsV0(abi.NewTokenAmount(1), 1<<20, 1<<20)
+	provider := retrievalmarket.RetrievalPeer{Address: address.TestAddress2, ID: peer.ID("provider")}
+
+	newClient := func(t *testing.T, opts ...retrievalimpl.RetrievalClientOption) *retrievalimpl.Client {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		node := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{})
+		node.ExpectKnownAddresses(provider, nil)
+		c, err := retrievalimpl.NewClient(
+			tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+			multiStore,
+			tut.NewTestDataTransfer(),
+			node,
+			&tut.TestPeerResolver{},
+			ds,
+			storedcounter.New(ds, datastore.NewKey("nextDealID")),
+			opts...)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, c)
+		return c.(*retrievalimpl.Client)
+	}
+
+	// retrieve starts a deal, which waits for the provider to accept it until it
+	// times out, and returns the state the deal is cancelled in
+	retrieve := func(t *testing.T, c *retrievalimpl.Client, timeouts *retrievalmarket.DealTimeouts) retrievalmarket.ClientDealState {
+		cancelled := make(chan retrievalmarket.ClientDealState, 1)
+		c.SubscribeToEvents(func(event retrievalmarket.ClientEvent, state retrievalmarket.ClientDealState) {
+			if state.Status == retrievalmarket.DealStatusCancelled {
+				cancelled <- state
+			}
+		})
+		dealID, err := c.Retrieve(ctx, payloadCID, params, abi.NewTokenAmount(1<<20), provider, address.TestAddress, address.TestAddress2, nil)
+		require.NoError(t, err)
+		if timeouts != nil {
+			require.NoError(t, c.SetDealTimeouts(dealID, *timeouts))
+		}
+
+		select {
+		case state := <-cancelled:
+			return state
+		case <-time.After(5 * time.Second):
+			t.Fatal("deal was not cancelled")
+			return retrievalmarket.ClientDealState{}
+		}
+	}
+
+	t.Run("cancels a deal that stalls", func(t *testing.T) {
+		c := newClient(t, retrievalimpl.DefaultDealTimeouts(retrievalmarket.DealTimeouts{MaxStall: 50 * time.Millisecond}))
+		state := retrieve(t, c, nil)
+		require.Equal(t, retrievalmarket.DealTimeoutMaxStall, state.TimeoutReason)
+		require.Equal(t, "retrieval timed out: received no data for 50ms", state.Message)
+	})
+
+	t.Run("cancels a deal that takes too long", func(t *testing.T) {
+		c := newClient(t)
+		state := retrieve(t, c, &retrievalmarket.DealTimeouts{MaxDuration: 50 * time.Millisecond})
+		require.Equal(t, retrievalmarket.DealTimeoutMaxDuration, state.TimeoutReason)
+		require.Equal(t, "retrieval timed out: took longer than 50ms", state.Message)
+	})
+
+	t.Run("cannot set timeouts for a deal not in progress", func(t *testing.T) {
+		c := newClient(t)
+		require.Error(t, c.SetDealTimeouts(retrievalmarket.DealID(1), retrievalmarket.DealTimeouts{MaxStall: time.Second}))
+	})
+}
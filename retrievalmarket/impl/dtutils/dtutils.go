@@ -16,6 +16,7 @@ import (
 
 	rm "github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/shared"
 )
 
 var log = logging.Logger("retrievalmarket_impl")
@@ -48,6 +49,7 @@ func providerEvent(event datatransfer.Event, channelState datatransfer.ChannelSt
 // and update message for the deal -- either moving to staged for a completion
 // event or moving to error if a data transfer error occurs
 func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
+	updates := shared.NewTransferUpdates(shared.DefaultTransferUpdateInterval)
 	return func(event datatransfer.Event, channelState datatransfer.ChannelState) {
 		dealProposal, ok := dealProposalFromVoucher(channelState.Voucher())
 		// if this event is for a transfer not related to storage, ignore
@@ -55,6 +57,15 @@ func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber
 			return
 		}
 
+		// keep the deal's record of the channel's status up to date, and its progress
+		// every so often
+		if updates.Due(channelState) {
+			err := deals.Send(rm.ProviderDealIdentifier{DealID: dealProposal.ID, Receiver: channelState.Recipient()}, rm.ProviderEventDataTransferUpdated, channelState)
+			if err != nil {
+				log.Errorf("processing dt event: %w", err)
+			}
+		}
+
 		if channelState.Status() == datatransfer.Completed {
 			err := deals.Send(rm.ProviderDealIdentifier{DealID: dealProposal.ID, Receiver: channelState.Recipient()}, rm.ProviderEventComplete)
 			if err != nil {
@@ -132,6 +143,7 @@ func clientEvent(event datatransfer.Event, channelState datatransfer.ChannelStat
 // in a storage market deal, then, based on the data transfer event that occurred, it dispatches
 // an event to the appropriate state machine
 func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
+	updates := shared.NewTransferUpdates(shared.DefaultTransferUpdateInterval)
 	return func(event datatransfer.Event, channelState datatransfer.ChannelState) {
 		dealProposal, ok := dealProposalFromVoucher(channelState.Voucher())
 
@@ -140,6 +152,15 @@ func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 			return
 		}
 
+		// keep the deal's record of the channel's status up to date, and its progress
+		// every so often
+		if updates.Due(channelState) {
+			err := deals.Send(dealProposal.ID, rm.ClientEventDataTransferUpdated, channelState)
+			if err != nil {
+				log.Errorf("processing dt event: %w", err)
+			}
+		}
+
 		retrievalEvent, params := clientEvent(event, channelState)
 		if retrievalEvent == noEvent {
 			return
@@ -158,6 +179,12 @@ type StoreGetter interface {
 	Get(otherPeer peer.ID, dealID rm.DealID) (*multistore.Store, error)
 }
 
+// StreamGetter retrieves a loader and storer for a deal whose blocks do not go
+// straight into a store. It is checked before the StoreGetter
+type StreamGetter interface {
+	GetStream(otherPeer peer.ID, dealID rm.DealID) (ipld.Loader, ipld.Storer, bool)
+}
+
 // StoreConfigurableTransport defines the methods needed to
 // configure a data transfer transport use a unique store for a given request
 type StoreConfigurableTransport interface {
@@ -176,6 +203,15 @@ func TransportConfigurer(thisPeer peer.ID, storeGetter StoreGetter) datatransfer
 			return
 		}
 		otherPeer := channelID.OtherParty(thisPeer)
+		if streamGetter, ok := storeGetter.(StreamGetter); ok {
+			if loader, storer, ok := streamGetter.GetStream(otherPeer, dealProposal.ID); ok {
+				err := gsTransport.UseStore(channelID, loader, storer)
+				if err != nil {
+					log.Errorf("attempting to configure data stream: %w", err)
+				}
+				return
+			}
+		}
 		store, err := storeGetter.Get(otherPeer, dealProposal.ID)
 		if err != nil {
 			log.Errorf("attempting to configure data store: %w", err)
@@ -192,18 +228,19 @@ func TransportConfigurer(thisPeer peer.ID, storeGetter StoreGetter) datatransfer
 }
 
 func dealProposalFromVoucher(voucher datatransfer.Voucher) (*rm.DealProposal, bool) {
-	dealProposal, ok := voucher.(*rm.DealProposal)
-	// if this event is for a transfer not related to storage, ignore
-	if ok {
-		return dealProposal, true
-	}
-
-	legacyProposal, ok := voucher.(*migrations.DealProposal0)
-	if !ok {
+	switch v := voucher.(type) {
+	case *rm.DealProposal:
+		return v, true
+	case *migrations.DealProposal1:
+		newProposal := migrations.MigrateDealProposal1To2(*v)
+		return &newProposal, true
+	case *migrations.DealProposal0:
+		newProposal := migrations.MigrateDealProposal0To1(*v)
+		return &newProposal, true
+	default:
+		// if this event is for a transfer not related to retrieval, ignore
 		return nil, false
 	}
-	newProposal := migrations.MigrateDealProposal0To1(*legacyProposal)
-	return &newProposal, true
 }
 
 func dealResponseFromVoucherResult(vres datatransfer.VoucherResult) (*rm.DealResponse, bool) {
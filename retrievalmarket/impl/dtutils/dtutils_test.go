@@ -133,9 +133,13 @@ func TestProviderDataTransferSubscriber(t *testing.T) {
 		t.Run(test, func(t *testing.T) {
 			fdg := &fakeDealGroup{}
 			subscriber := dtutils.ProviderDataTransferSubscriber(fdg)
-			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, shared_testutil.NewTestChannel(data.state))
+			channelState := shared_testutil.NewTestChannel(data.state)
+			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, channelState)
 			if !data.ignored {
 				require.True(t, fdg.called)
+				// the deal's record of the channel is updated before any other event
+				require.Equal(t, fdg.firstEvent, rm.ProviderEventDataTransferUpdated)
+				require.Equal(t, fdg.firstArgs, []interface{}{channelState})
 				require.Equal(t, fdg.lastID, data.expectedID)
 				require.Equal(t, fdg.lastEvent, data.expectedEvent)
 				require.Equal(t, fdg.lastArgs, data.expectedArgs)
@@ -339,9 +343,13 @@ func TestClientDataTransferSubscriber(t *testing.T) {
 		t.Run(test, func(t *testing.T) {
 			fdg := &fakeDealGroup{}
 			subscriber := dtutils.ClientDataTransferSubscriber(fdg)
-			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, shared_testutil.NewTestChannel(data.state))
+			channelState := shared_testutil.NewTestChannel(data.state)
+			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, channelState)
 			if !data.ignored {
 				require.True(t, fdg.called)
+				// the deal's record of the channel is updated before any other event
+				require.Equal(t, fdg.firstEvent, rm.ClientEventDataTransferUpdated)
+				require.Equal(t, fdg.firstArgs, []interface{}{channelState})
 				require.Equal(t, fdg.lastID, data.expectedID)
 				require.Equal(t, fdg.lastEvent, data.expectedEvent)
 				require.Equal(t, fdg.lastArgs, data.expectedArgs)
@@ -355,12 +363,18 @@ func TestClientDataTransferSubscriber(t *testing.T) {
 type fakeDealGroup struct {
 	returnedErr error
 	called      bool
+	firstEvent  fsm.EventName
+	firstArgs   []interface{}
 	lastID      interface{}
 	lastEvent   fsm.EventName
 	lastArgs    []interface{}
 }
 
 func (fdg *fakeDealGroup) Send(id interface{}, name fsm.EventName, args ...interface{}) (err error) {
+	if !fdg.called {
+		fdg.firstEvent = name
+		fdg.firstArgs = args
+	}
 	fdg.lastID = id
 	fdg.lastEvent = name
 	fdg.lastArgs = args
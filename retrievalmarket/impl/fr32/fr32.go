@@ -0,0 +1,93 @@
+/*
+Package fr32 adds fr32 padding to a piece's data as it is read.
+
+Each 254 bits of a piece's data is padded with two zero bits, so that every 32
+bytes of the padded piece is a valid field element. The padding is applied to
+chunks of 127 bytes, each of which becomes 128 bytes, in the same way as the proofs
+library pads data written to a sector.
+*/
+package fr32
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// UnpaddedChunk is the size of a chunk of data before padding
+	UnpaddedChunk = 127
+	// PaddedChunk is the size of a chunk of data after padding
+	PaddedChunk = 128
+)
+
+type padReader struct {
+	src io.Reader
+	in  [UnpaddedChunk]byte
+	out [PaddedChunk]byte
+	buf []byte
+	err error
+}
+
+// NewPadReader returns a reader of the data read from src with fr32 padding. The
+// data must be a whole number of 127 byte chunks
+func NewPadReader(src io.Reader) io.Reader {
+	return &padReader{src: src}
+}
+
+func (pr *padReader) Read(p []byte) (int, error) {
+	for len(pr.buf) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		_, err := io.ReadFull(pr.src, pr.in[:])
+		switch err {
+		case nil:
+			pad(pr.in[:], pr.out[:])
+			pr.buf = pr.out[:]
+		case io.ErrUnexpectedEOF:
+			pr.err = xerrors.Errorf("data is not a whole number of %d byte chunks", UnpaddedChunk)
+		default:
+			pr.err = err
+		}
+	}
+	n := copy(p, pr.buf)
+	pr.buf = pr.buf[n:]
+	return n, nil
+}
+
+// pad pads a 127 byte chunk into 128 bytes, shifting each 254 bit run of data to
+// make room for two zero bits at the top of every 32nd byte
+func pad(in, out []byte) {
+	copy(out[:31], in[:31])
+
+	t := in[31] >> 6
+	out[31] = in[31] & 0x3f
+	var v byte
+
+	for i := 32; i < 64; i++ {
+		v = in[i]
+		out[i] = (v << 2) | t
+		t = v >> 6
+	}
+
+	t = v >> 4
+	out[63] &= 0x3f
+
+	for i := 64; i < 96; i++ {
+		v = in[i]
+		out[i] = (v << 4) | t
+		t = v >> 4
+	}
+
+	t = v >> 2
+	out[95] &= 0x3f
+
+	for i := 96; i < 127; i++ {
+		v = in[i]
+		out[i] = (v << 6) | t
+		t = v >> 2
+	}
+
+	out[127] = t & 0x3f
+}
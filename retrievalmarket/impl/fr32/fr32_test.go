@@ -0,0 +1,40 @@
+package fr32_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/fr32"
+)
+
+func TestPadReader(t *testing.T) {
+	t.Run("pads each chunk with two zero bits every 254 bits", func(t *testing.T) {
+		data := bytes.Repeat([]byte{0xff}, 2*fr32.UnpaddedChunk)
+		padded, err := ioutil.ReadAll(fr32.NewPadReader(bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.Len(t, padded, 2*fr32.PaddedChunk)
+		for i, b := range padded {
+			if i%32 == 31 {
+				require.Equal(t, byte(0x3f), b, "byte %d", i)
+			} else {
+				require.Equal(t, byte(0xff), b, "byte %d", i)
+			}
+		}
+	})
+
+	t.Run("keeps zeros as zeros", func(t *testing.T) {
+		data := make([]byte, 4*fr32.UnpaddedChunk)
+		padded, err := ioutil.ReadAll(fr32.NewPadReader(bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.Equal(t, make([]byte, 4*fr32.PaddedChunk), padded)
+	})
+
+	t.Run("fails on a partial chunk", func(t *testing.T) {
+		data := make([]byte, fr32.UnpaddedChunk+1)
+		_, err := ioutil.ReadAll(fr32.NewPadReader(bytes.NewReader(data)))
+		require.Error(t, err)
+	})
+}
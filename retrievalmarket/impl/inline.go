@@ -0,0 +1,303 @@
+package retrievalimpl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-padreader"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+	"github.com/filecoin-project/go-fil-markets/shared/carcheck"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
+)
+
+// maxInlineSize is the most data sent with an inline query response, well below the
+// largest byte array a response can hold
+const maxInlineSize = 1 << 20
+
+// errTooBigToInline is returned when a payload's CAR is bigger than the inline limit
+var errTooBigToInline = errors.New("payload is too big to send inline")
+
+// ServeInlinePayloads lets clients get payloads of up to maxSize bytes, such as
+// directory manifests, with the response to an inline query, for free and without
+// making a deal. Payloads are only read from pieces no bigger than it takes to hold
+// maxSize bytes, and only from a copy of the piece that is already unsealed: its staged
+// CAR, or a sector the miner's node reports keeping unsealed through
+// retrievalmarket.UnsealCostEstimator. Answering a query never unseals a sector.
+// maxSize is capped at 1MiB
+func ServeInlinePayloads(maxSize uint64) RetrievalProviderOption {
+	return func(provider *Provider) {
+		if maxSize > maxInlineSize {
+			maxSize = maxInlineSize
+		}
+		provider.inlineMaxSize = maxSize
+	}
+}
+
+/*
+HandleInlineQueryStream is called by the network implementation whenever a new query is received on the inline
+query protocol
+
+A Provider handling an `InlineQuery` builds the same `QueryResponse` as for a query on the query protocol, then:
+
+1. If the payload CID is an identity CID of raw data, sends the payload, which is the CID's own digest, whether or
+not the provider stores it.
+
+2. Otherwise, if it was configured with `ServeInlinePayloads`, the payload is available, its piece is small
+enough and has a copy that is already unsealed, reads the CAR at the start of that copy. The CAR is sent if its root is the payload CID and it is within
+both the provider's limit and the client's `MaxSize`.
+
+3. Signs the `InlineQueryResponse` with the worker key of the miner that stores the payload, and writes it to the
+stream. A response that names no payment address, because the query failed, is paid to the miner's worker.
+
+The connection is kept open only as long as the query-response exchange.
+*/
+func (p *Provider) HandleInlineQueryStream(stream rmnet.InlineQueryStream) {
+	defer stream.Close()
+	query, err := stream.ReadInlineQuery()
+	if err != nil {
+		return
+	}
+
+	ctx := context.TODO()
+	resp := retrievalmarket.InlineQueryResponse{PayloadCID: query.Query.PayloadCID}
+	miner := p.miners[0]
+
+	release, admitted := p.admitQuery(stream.RemotePeer())
+	if !admitted {
+		resp.Response = p.queryBusy()
+	} else {
+		defer release()
+
+		var pieceInfo piecestore.PieceInfo
+		var ok bool
+		resp.Response, miner, pieceInfo, ok = p.answerQuery(ctx, query.Query)
+		if !ok {
+			return
+		}
+
+		if data, ok := identityCAR(query.Query.PayloadCID); ok {
+			if uint64(len(data)) <= query.MaxSize {
+				resp.Response.Status = retrievalmarket.QueryResponseAvailable
+				resp.Response.Size = uint64(len(data))
+				resp.Data = data
+			}
+		} else if resp.Response.Status == retrievalmarket.QueryResponseAvailable {
+			limit := p.inlineMaxSize
+			if query.MaxSize < limit {
+				limit = query.MaxSize
+			}
+			data, err := p.readInlinePayload(ctx, miner, pieceInfo, query.Query.PayloadCID, limit)
+			if err != nil {
+				log.Warnf("Inline query: reading payload %s: %s", query.Query.PayloadCID, err)
+			}
+			resp.Data = data
+		}
+	}
+
+	signed, err := signInlineResponse(ctx, miner, resp)
+	if err != nil {
+		log.Errorf("Inline query: signing response: %s", err)
+		return
+	}
+	if err := stream.WriteInlineQueryResponse(signed); err != nil {
+		log.Errorf("Inline query: WriteCborRPC: %s", err)
+	}
+}
+
+// readInlinePayload reads the CAR of a payload from the start of an unsealed copy of
+// its piece, if the payload is the root of the CAR and the CAR is no bigger than
+// limit. It returns nil if the payload cannot be sent inline
+func (p *Provider) readInlinePayload(ctx context.Context, miner *servedMiner, pieceInfo piecestore.PieceInfo, payloadCID cid.Cid, limit uint64) ([]byte, error) {
+	if limit == 0 || pieceInfo.Deals[0].Length > padreader.PaddedSize(limit).Padded() {
+		return nil, nil
+	}
+
+	reader, err := p.readUnsealedPiece(ctx, miner.node, pieceInfo)
+	if err != nil {
+		if xerrors.Is(err, errNotUnsealed) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	out := &cappedBuffer{limit: limit}
+	if err := copyCAR(out, reader); err != nil {
+		if xerrors.Is(err, errTooBigToInline) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// the piece may hold a bigger DAG the payload is part of
+	header, _, err := car.ReadHeader(bufio.NewReader(bytes.NewReader(out.Bytes())))
+	if err != nil {
+		return nil, xerrors.Errorf("reading CAR header: %w", err)
+	}
+	if len(header.Roots) != 1 || !header.Roots[0].Equals(payloadCID) {
+		return nil, nil
+	}
+	return out.Bytes(), nil
+}
+
+// identityCAR returns a CAR holding the payload of an identity CID of raw data, which
+// is the CID's own digest
+func identityCAR(c cid.Cid) ([]byte, bool) {
+	if c.Prefix().Codec != cid.Raw {
+		return nil, false
+	}
+	decoded, err := multihash.Decode(c.Hash())
+	if err != nil || decoded.Code != multihash.IDENTITY {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{c}, Version: 1}, &buf); err != nil {
+		return nil, false
+	}
+	if err := util.LdWrite(&buf, c.Bytes(), decoded.Digest); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// signInlineResponse signs a response with the worker key of the given miner
+func signInlineResponse(ctx context.Context, miner *servedMiner, resp retrievalmarket.InlineQueryResponse) (retrievalmarket.SignedInlineQueryResponse, error) {
+	resp.Miner = miner.address
+	tok, _, err := miner.node.GetChainHead(ctx)
+	if err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	worker, err := miner.node.GetMinerWorkerAddress(ctx, miner.address, tok)
+	if err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	if resp.Response.PaymentAddress == address.Undef {
+		resp.Response.PaymentAddress = worker
+	}
+	msg, err := cborutil.Dump(&resp)
+	if err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	sig, err := miner.node.SignBytes(ctx, worker, msg)
+	if err != nil {
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+	return retrievalmarket.SignedInlineQueryResponse{Response: resp, Signature: sig}, nil
+}
+
+// verifyInlineResponse checks a response to an inline query is for the payload that was
+// asked for and is signed by the worker of the miner it names, and that the payload
+// sent with it, if any, is the payload's whole DAG
+func verifyInlineResponse(ctx context.Context, node retrievalmarket.RetrievalClientNode, payloadCID cid.Cid, maxSize uint64, signed retrievalmarket.SignedInlineQueryResponse) error {
+	resp := signed.Response
+	if !resp.PayloadCID.Equals(payloadCID) {
+		return xerrors.Errorf("response is for payload %s, not %s", resp.PayloadCID, payloadCID)
+	}
+	if signed.Signature == nil {
+		return xerrors.New("response is not signed")
+	}
+
+	tok, _, err := node.GetChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+	worker, err := node.GetMinerWorkerAddress(ctx, resp.Miner, tok)
+	if err != nil {
+		return xerrors.Errorf("looking up worker of miner %s: %w", resp.Miner, err)
+	}
+	msg, err := cborutil.Dump(&resp)
+	if err != nil {
+		return err
+	}
+	verified, err := node.VerifySignature(ctx, *signed.Signature, worker, msg, tok)
+	if err != nil {
+		return xerrors.Errorf("verifying response signature: %w", err)
+	}
+	if !verified {
+		return xerrors.Errorf("response is not signed by the worker of miner %s", resp.Miner)
+	}
+
+	if len(resp.Data) == 0 {
+		return nil
+	}
+	if uint64(len(resp.Data)) > maxSize {
+		return xerrors.Errorf("inline payload of %d bytes is bigger than the limit of %d", len(resp.Data), maxSize)
+	}
+	if err := checkInlineCAR(ctx, payloadCID, resp.Data); err != nil {
+		return xerrors.Errorf("checking inline payload: %w", err)
+	}
+	return nil
+}
+
+// checkInlineCAR checks an inline payload is a CAR of the whole DAG under root, in
+// traversal order, and that every block in it matches its CID
+func checkInlineCAR(ctx context.Context, root cid.Cid, data []byte) error {
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	store := make(inlineStore)
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c, err := blk.Cid().Prefix().Sum(blk.RawData())
+		if err != nil {
+			return err
+		}
+		if !c.Equals(blk.Cid()) {
+			return xerrors.Errorf("block %s does not match its CID", blk.Cid())
+		}
+		store[blk.Cid()] = blk
+	}
+	return carcheck.VerifyCAR(ctx, store, root, selectors.Entire(), bytes.NewReader(data))
+}
+
+// inlineStore is a block store of the blocks of an inline payload
+type inlineStore map[cid.Cid]blocks.Block
+
+func (s inlineStore) Get(c cid.Cid) (blocks.Block, error) {
+	blk, ok := s[c]
+	if !ok {
+		return nil, xerrors.Errorf("block %s is missing", c)
+	}
+	return blk, nil
+}
+
+// cappedBuffer is a buffer that fails writes beyond its limit. It does not embed
+// bytes.Buffer, so copies cannot get around the limit through ReadFrom
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit uint64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if uint64(b.buf.Len()+len(p)) > b.limit {
+		return 0, errTooBigToInline
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
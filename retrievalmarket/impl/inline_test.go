@@ -0,0 +1,257 @@
+package retrievalimpl_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-car"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-storedcounter"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// testInlineQueryStream is an inline query stream that holds a query and a response,
+// and records the responses written to it
+type testInlineQueryStream struct {
+	p         peer.ID
+	query     retrievalmarket.InlineQuery
+	response  retrievalmarket.SignedInlineQueryResponse
+	responses []retrievalmarket.SignedInlineQueryResponse
+}
+
+func (s *testInlineQueryStream) ReadInlineQuery() (retrievalmarket.InlineQuery, error) {
+	return s.query, nil
+}
+
+func (s *testInlineQueryStream) WriteInlineQuery(q retrievalmarket.InlineQuery) error {
+	s.query = q
+	return nil
+}
+
+func (s *testInlineQueryStream) ReadInlineQueryResponse() (retrievalmarket.SignedInlineQueryResponse, error) {
+	return s.response, nil
+}
+
+func (s *testInlineQueryStream) WriteInlineQueryResponse(resp retrievalmarket.SignedInlineQueryResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *testInlineQueryStream) RemotePeer() peer.ID {
+	return s.p
+}
+
+func (s *testInlineQueryStream) Close() error {
+	return nil
+}
+
+func TestHandleInlineQueryStream(t *testing.T) {
+	ctx := context.Background()
+	testData := tut.NewTestIPLDTree()
+	payloadCID := testData.RootNodeLnk.(cidlink.Link).Cid
+	var carData bytes.Buffer
+	require.NoError(t, testData.DumpToCar(&carData))
+
+	pieceCID := tut.GenerateCids(1)[0]
+	length := padreader.PaddedSize(uint64(carData.Len())).Padded()
+	cidInfo := piecestore.CIDInfo{
+		PieceBlockLocations: []piecestore.PieceBlockLocation{{PieceCID: pieceCID}},
+	}
+	piece := piecestore.PieceInfo{
+		PieceCID: pieceCID,
+		Deals: []piecestore.DealInfo{
+			{SectorID: 1, Offset: 0, Length: length},
+		},
+	}
+
+	// newNode returns a node that keeps an unsealed copy of the piece if unsealed is set
+	newNode := func(unsealed bool) *estimatingProviderNode {
+		node := &estimatingProviderNode{
+			TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+			estimate:                  retrievalmarket.UnsealCostEstimate{Unsealed: unsealed},
+		}
+		node.StubUnseal(1, 0, length.Unpadded(), carData.Bytes())
+		return node
+	}
+
+	receive := func(t *testing.T, node retrievalmarket.RetrievalProviderNode, query retrievalmarket.InlineQuery, pieceStore piecestore.PieceStore, opts ...retrievalimpl.RetrievalProviderOption) retrievalmarket.SignedInlineQueryResponse {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
+		p, err := retrievalimpl.NewProvider(address.TestAddress2, node, net, pieceStore, multiStore, tut.NewTestDataTransfer(), ds, opts...)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+
+		stream := &testInlineQueryStream{p: peer.ID("somepeer"), query: query}
+		net.ReceiveInlineQueryStream(stream)
+		require.Len(t, stream.responses, 1)
+		resp := stream.responses[0]
+		require.Equal(t, query.Query.PayloadCID, resp.Response.PayloadCID)
+		require.Equal(t, address.TestAddress2, resp.Response.Miner)
+		require.Equal(t, tut.MakeTestSignature(), resp.Signature)
+		return resp
+	}
+
+	query := retrievalmarket.InlineQuery{
+		Query:   retrievalmarket.Query{PayloadCID: payloadCID},
+		MaxSize: 4096,
+	}
+
+	t.Run("sends small payloads", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, cidInfo)
+		pieceStore.ExpectPiece(pieceCID, piece)
+		resp := receive(t, newNode(true), query, pieceStore, retrievalimpl.ServeInlinePayloads(4096))
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, resp.Response.Response.Status)
+		require.Equal(t, carData.Bytes(), resp.Response.Data)
+		pieceStore.VerifyExpectations(t)
+	})
+
+	t.Run("does not send payloads unless configured to", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, cidInfo)
+		pieceStore.ExpectPiece(pieceCID, piece)
+		resp := receive(t, newNode(true), query, pieceStore)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, resp.Response.Response.Status)
+		require.Empty(t, resp.Response.Data)
+	})
+
+	t.Run("does not unseal to send payloads", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, cidInfo)
+		pieceStore.ExpectPiece(pieceCID, piece)
+		node := newNode(false)
+		resp := receive(t, node, query, pieceStore, retrievalimpl.ServeInlinePayloads(4096))
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, resp.Response.Response.Status)
+		require.Empty(t, resp.Response.Data)
+		node.VerifyExpectations(t)
+	})
+
+	t.Run("does not send payloads bigger than the client allows", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, cidInfo)
+		pieceStore.ExpectPiece(pieceCID, piece)
+		small := query
+		small.MaxSize = uint64(carData.Len()) - 1
+		resp := receive(t, newNode(true), small, pieceStore, retrievalimpl.ServeInlinePayloads(4096))
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, resp.Response.Response.Status)
+		require.Empty(t, resp.Response.Data)
+	})
+
+	t.Run("sends identity payloads it does not store", func(t *testing.T) {
+		mh, err := multihash.Sum([]byte("a tiny manifest"), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		identity := cid.NewCidV1(cid.Raw, mh)
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectMissingCID(identity)
+		resp := receive(t, newNode(false), retrievalmarket.InlineQuery{Query: retrievalmarket.Query{PayloadCID: identity}, MaxSize: 1024}, pieceStore)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, resp.Response.Response.Status)
+
+		cr, err := car.NewCarReader(bytes.NewReader(resp.Response.Data))
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{identity}, cr.Header.Roots)
+		blk, err := cr.Next()
+		require.NoError(t, err)
+		require.Equal(t, []byte("a tiny manifest"), blk.RawData())
+	})
+}
+
+func TestClientQueryInline(t *testing.T) {
+	ctx := context.Background()
+	testData := tut.NewTestIPLDTree()
+	payloadCID := testData.RootNodeLnk.(cidlink.Link).Cid
+	var carData bytes.Buffer
+	require.NoError(t, testData.DumpToCar(&carData))
+	rpeer := retrievalmarket.RetrievalPeer{Address: address.TestAddress2, ID: peer.ID("qwerty")}
+
+	// a CAR of only the payload's root block
+	var partial bytes.Buffer
+	rootOnly := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	sc := car.NewSelectiveCar(ctx, testData, []car.Dag{{Root: payloadCID, Selector: rootOnly}})
+	require.NoError(t, sc.Write(&partial))
+
+	response := func(payload cid.Cid, data []byte) retrievalmarket.SignedInlineQueryResponse {
+		return retrievalmarket.SignedInlineQueryResponse{
+			Response: retrievalmarket.InlineQueryResponse{
+				PayloadCID: payload,
+				Miner:      address.TestAddress2,
+				Response: retrievalmarket.QueryResponse{
+					Status:         retrievalmarket.QueryResponseAvailable,
+					PaymentAddress: address.TestAddress2,
+				},
+				Data: data,
+			},
+			Signature: tut.MakeTestSignature(),
+		}
+	}
+
+	query := func(t *testing.T, resp retrievalmarket.SignedInlineQueryResponse, params testnodes.TestRetrievalClientNodeParams) (retrievalmarket.SignedInlineQueryResponse, error) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		stream := &testInlineQueryStream{response: resp}
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{
+			InlineQueryStreamBuilder: func(peer.ID) (rmnet.InlineQueryStream, error) {
+				return stream, nil
+			},
+		})
+		node := testnodes.NewTestRetrievalClientNode(params)
+		node.ExpectKnownAddresses(rpeer, nil)
+		c, err := retrievalimpl.NewClient(net, multiStore, tut.NewTestDataTransfer(), node, &tut.TestPeerResolver{}, ds, storedcounter.New(ds, datastore.NewKey("nextDealID")))
+		require.NoError(t, err)
+
+		received, err := c.QueryInline(ctx, rpeer, payloadCID, retrievalmarket.QueryParams{}, 4096)
+		require.Equal(t, payloadCID, stream.query.Query.PayloadCID)
+		require.Equal(t, uint64(4096), stream.query.MaxSize)
+		return received, err
+	}
+
+	t.Run("returns a checked payload", func(t *testing.T) {
+		resp := response(payloadCID, carData.Bytes())
+		received, err := query(t, resp, testnodes.TestRetrievalClientNodeParams{})
+		require.NoError(t, err)
+		require.Equal(t, resp, received)
+	})
+
+	t.Run("returns a response without a payload", func(t *testing.T) {
+		resp := response(payloadCID, nil)
+		received, err := query(t, resp, testnodes.TestRetrievalClientNodeParams{})
+		require.NoError(t, err)
+		require.Equal(t, resp, received)
+	})
+
+	t.Run("fails on a bad signature", func(t *testing.T) {
+		_, err := query(t, response(payloadCID, carData.Bytes()), testnodes.TestRetrievalClientNodeParams{VerifySignatureFails: true})
+		require.Error(t, err)
+	})
+
+	t.Run("fails on a response for another payload", func(t *testing.T) {
+		_, err := query(t, response(tut.GenerateCids(1)[0], nil), testnodes.TestRetrievalClientNodeParams{})
+		require.Error(t, err)
+	})
+
+	t.Run("fails on a payload missing blocks", func(t *testing.T) {
+		_, err := query(t, response(payloadCID, partial.Bytes()), testnodes.TestRetrievalClientNodeParams{})
+		require.Error(t, err)
+	})
+}
@@ -43,7 +43,7 @@ func TestClientCanMakeQueryToProvider(t *testing.T) {
 	bgCtx := context.Background()
 	payChAddr := address.TestAddress
 
-	client, expectedCIDs, missingPiece, expectedQR, retrievalPeer, _ := requireSetupTestClientAndProvider(bgCtx, t, payChAddr)
+	client, expectedCIDs, missingPiece, expectedQR, retrievalPeer, _, faults := requireSetupTestClientAndProvider(bgCtx, t, payChAddr)
 
 	t.Run("when piece is found, returns piece and price data", func(t *testing.T) {
 		expectedQR.Status = retrievalmarket.QueryResponseAvailable
@@ -71,6 +71,22 @@ func TestClientCanMakeQueryToProvider(t *testing.T) {
 		assert.Equal(t, expectedQR, actualQR)
 	})
 
+	t.Run("when the query stream is dropped, retries the query", func(t *testing.T) {
+		faults.Inject(tut.Fault{Kind: tut.FaultDropStream, Protocol: retrievalmarket.QueryProtocolID, Occurrence: 1})
+		defer faults.Clear()
+		actualQR, err := client.Query(bgCtx, retrievalPeer, expectedCIDs[0], retrievalmarket.QueryParams{})
+		require.NoError(t, err)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, actualQR.Status)
+		require.Len(t, faults.Injected(), 1)
+	})
+
+	t.Run("when the query stream is reset, returns error", func(t *testing.T) {
+		faults.Inject(tut.Fault{Kind: tut.FaultResetStream, Protocol: retrievalmarket.QueryProtocolID})
+		defer faults.Clear()
+		_, err := client.Query(bgCtx, retrievalPeer, expectedCIDs[0], retrievalmarket.QueryParams{})
+		require.Error(t, err)
+	})
+
 }
 
 func TestProvider_Stop(t *testing.T) {
@@ -79,7 +95,7 @@ func TestProvider_Stop(t *testing.T) {
 	}
 	bgCtx := context.Background()
 	payChAddr := address.TestAddress
-	client, expectedCIDs, _, _, retrievalPeer, provider := requireSetupTestClientAndProvider(bgCtx, t, payChAddr)
+	client, expectedCIDs, _, _, retrievalPeer, provider, _ := requireSetupTestClientAndProvider(bgCtx, t, payChAddr)
 	require.NoError(t, provider.Stop())
 	_, err := client.Query(bgCtx, retrievalPeer, expectedCIDs[0], retrievalmarket.QueryParams{})
 
@@ -91,8 +107,9 @@ func requireSetupTestClientAndProvider(ctx context.Context, t *testing.T, payChA
 	cid.Cid,
 	retrievalmarket.QueryResponse,
 	retrievalmarket.RetrievalPeer,
-	retrievalmarket.RetrievalProvider) {
-	testData := tut.NewLibp2pTestData(ctx, t)
+	retrievalmarket.RetrievalProvider,
+	*tut.FaultInjector) {
+	testData := tut.NewLibp2pTestData(ctx, t, tut.WithFaultInjection())
 	nw1 := rmnet.NewFromLibp2pHost(testData.Host1, rmnet.RetryParameters(100*time.Millisecond, 1*time.Second, 5))
 	cids := tut.GenerateCids(2)
 	rcNode1 := testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{
@@ -163,7 +180,7 @@ func requireSetupTestClientAndProvider(ctx context.Context, t *testing.T, payChA
 		ID:      testData.Host2.ID(),
 	}
 	rcNode1.ExpectKnownAddresses(retrievalPeer, nil)
-	return client, expectedCIDs, missingCID, expectedQR, retrievalPeer, provider
+	return client, expectedCIDs, missingCID, expectedQR, retrievalPeer, provider, testData.Faults1
 }
 
 func TestClientCanMakeDealWithProvider(t *testing.T) {
@@ -196,6 +213,7 @@ func TestClientCanMakeDealWithProvider(t *testing.T) {
 		fundsReplenish          abi.TokenAmount
 		cancelled               bool
 		disableNewDeals         bool
+		faults                  []tut.Fault
 	}{
 		{name: "1 block file retrieval succeeds",
 			filename:    "lorem_under_1_block.txt",
@@ -301,6 +319,12 @@ func TestClientCanMakeDealWithProvider(t *testing.T) {
 			paymentInterval:         9000,
 			paymentIntervalIncrease: 1250,
 		},
+		{name: "multi-block file retrieval succeeds over a slow network",
+			filename:    "lorem.txt",
+			filesize:    19000,
+			voucherAmts: []abi.TokenAmount{abi.NewTokenAmount(10136000), abi.NewTokenAmount(9784000)},
+			faults:      []tut.Fault{{Kind: tut.FaultDelayStream, Latency: 2 * time.Millisecond}},
+		},
 		{name: "multi-block file retrieval succeeds, with provider only accepting legacy deals",
 			filename:        "lorem.txt",
 			filesize:        19000,
@@ -315,7 +339,10 @@ func TestClientCanMakeDealWithProvider(t *testing.T) {
 			clientPaymentChannel, err := address.NewIDAddress(uint64(i * 10))
 			require.NoError(t, err)
 
-			testData := tut.NewLibp2pTestData(bgCtx, t)
+			testData := tut.NewLibp2pTestData(bgCtx, t, tut.WithFaultInjection())
+			for _, fault := range testCase.faults {
+				testData.Faults1.Inject(fault)
+			}
 
 			// Inject a unixFS file on the provider side to its blockstore
 			// obtained via `ls -laf` on this file
@@ -424,11 +451,19 @@ func TestClientCanMakeDealWithProvider(t *testing.T) {
 					return
 				}
 				if state.Status == retrievalmarket.DealStatusInsufficientFunds {
-					if !testCase.fundsReplenish.Nil() {
+					if !testCase.fundsReplenish.Nil() && event == retrievalmarket.ClientEventFundsExpended {
 						clientNode.ResetChannelAvailableFunds(retrievalmarket.ChannelAvailableFunds{
 							ConfirmedAmt: testCase.fundsReplenish,
 						})
-						client.TryRestartInsufficientFunds(state.PaymentInfo.PayCh)
+						// subscribers can be notified before the deal's new state is saved,
+						// so restart the deal once it is saved
+						go func(id retrievalmarket.DealID, payCh address.Address) {
+							assert.Eventually(t, func() bool {
+								deal, err := client.GetDeal(id)
+								return err == nil && deal.Status == retrievalmarket.DealStatusInsufficientFunds
+							}, time.Second, 10*time.Millisecond)
+							assert.NoError(t, client.TryRestartInsufficientFunds(payCh))
+						}(state.ID, state.PaymentInfo.PayCh)
 					}
 					if testCase.cancelled {
 						client.CancelDeal(state.ID)
@@ -540,6 +575,9 @@ CurrentInterval: %d
 			// verify that the nodes we interacted with as expected
 			clientNode.VerifyExpectations(t)
 			providerNode.VerifyExpectations(t)
+			if len(testCase.faults) > 0 {
+				require.NotEmpty(t, testData.Faults1.Injected())
+			}
 			if !testCase.failsUnseal && !testCase.cancelled {
 				if testCase.skipStores {
 					testData.VerifyFileTransferred(t, pieceLink, false, testCase.filesize)
@@ -0,0 +1,88 @@
+package retrievalimpl
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
+)
+
+/*
+RetrieveWithLocal retrieves a payload into a store like Retrieve, but first looks
+for the blocks it selects in local, a blockstore the caller already has, such as a
+node's own blockstore. Blocks found in local are copied into the store rather than
+paid for, and a deal is only started for the blocks that are missing.
+
+For a retrieval of the whole DAG, the deal's selector is narrowed to the subtrees
+with missing blocks, as found by selectors.Subtract. Any other selector is either
+satisfied from local in full, or retrieved unchanged.
+
+It returns false, and no deal, if every block was found in local.
+*/
+func (c *Client) RetrieveWithLocal(ctx context.Context, payloadCID cid.Cid, params retrievalmarket.Params, totalFunds abi.TokenAmount, p retrievalmarket.RetrievalPeer, clientWallet address.Address, minerWallet address.Address, storeID multistore.StoreID, local blockstore.Blockstore) (retrievalmarket.DealID, bool, error) {
+	store, err := c.multiStore.Get(storeID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	whole, err := selectsWholeDAG(params)
+	if err != nil {
+		return 0, false, err
+	}
+	if !whole {
+		sel, err := retrievalmarket.DecodeNode(params.Selector)
+		if err != nil {
+			return 0, false, xerrors.Errorf("decoding selector: %w", err)
+		}
+		cost, err := selectors.Walk(ctx, local, payloadCID, sel)
+		if err == nil {
+			return 0, false, copyBlocks(local, store.Bstore, cost.CIDs)
+		}
+		// some of the blocks selected are missing, so retrieve them all
+		dealID, err := c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, &storeID, nil)
+		return dealID, true, err
+	}
+
+	remainder, err := selectors.Subtract(ctx, local, payloadCID)
+	if err != nil {
+		return 0, false, xerrors.Errorf("finding blocks missing from local blockstore: %w", err)
+	}
+	if err := copyBlocks(local, store.Bstore, remainder.Present); err != nil {
+		return 0, false, err
+	}
+	if remainder.Selector == nil {
+		return 0, false, nil
+	}
+	var missing bytes.Buffer
+	if err := dagcbor.Encoder(remainder.Selector, &missing); err != nil {
+		return 0, false, xerrors.Errorf("encoding selector: %w", err)
+	}
+	params.Selector = &cbg.Deferred{Raw: missing.Bytes()}
+	dealID, err := c.retrieve(ctx, payloadCID, params, totalFunds, p, clientWallet, minerWallet, &storeID, nil)
+	return dealID, true, err
+}
+
+// copyBlocks copies the given blocks from one blockstore to another
+func copyBlocks(from blockstore.Blockstore, to blockstore.Blockstore, cids []cid.Cid) error {
+	for _, c := range cids {
+		block, err := from.Get(c)
+		if err != nil {
+			return xerrors.Errorf("reading block %s from local blockstore: %w", c, err)
+		}
+		if err := to.Put(block); err != nil {
+			return xerrors.Errorf("copying block %s to store: %w", c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package retrievalimpl_test
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-storedcounter"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestClient_RetrieveWithLocal(t *testing.T) {
+	ctx := context.Background()
+	testData := tut.NewTestIPLDTree()
+	payloadCID := testData.RootNodeLnk.(cidlink.Link).Cid
+
+	local := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, local.PutMany([]blocks.Block{
+		testData.RootBlock,
+		testData.MiddleMapBlock,
+		testData.MiddleListBlock,
+		testData.LeafAlphaBlock,
+		testData.LeafBetaBlock,
+	}))
+
+	newClient := func(t *testing.T) (retrievalmarket.RetrievalClient, *multistore.MultiStore) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		client, err := retrievalimpl.NewClient(
+			tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+			multiStore,
+			tut.NewTestDataTransfer(),
+			testnodes.NewTestRetrievalClientNode(testnodes.TestRetrievalClientNodeParams{}),
+			&tut.TestPeerResolver{},
+			ds,
+			storedcounter.New(ds, datastore.NewKey("nextDealID")))
+		require.NoError(t, err)
+		return client, multiStore
+	}
+
+	retrieve := func(t *testing.T, params retrievalmarket.Params) (*multistore.Store, bool) {
+		client, multiStore := newClient(t)
+		storeID := multiStore.Next()
+		store, err := multiStore.Get(storeID)
+		require.NoError(t, err)
+		_, retrieved, err := client.RetrieveWithLocal(ctx, payloadCID, params, abi.NewTokenAmount(1000),
+			retrievalmarket.RetrievalPeer{}, address.TestAddress, address.TestAddress2, storeID, local)
+		require.NoError(t, err)
+		return store, retrieved
+	}
+
+	t.Run("copies the whole DAG from the local blockstore", func(t *testing.T) {
+		store, retrieved := retrieve(t, retrievalmarket.NewParamsV0(abi.NewTokenAmount(1), 100, 100))
+		require.False(t, retrieved)
+		for _, block := range []blocks.Block{testData.RootBlock, testData.MiddleMapBlock, testData.MiddleListBlock, testData.LeafAlphaBlock, testData.LeafBetaBlock} {
+			has, err := store.Bstore.Has(block.Cid())
+			require.NoError(t, err)
+			require.True(t, has)
+		}
+	})
+
+	t.Run("copies the blocks a selector selects from the local blockstore", func(t *testing.T) {
+		sel := selectors.Depth(1)
+		params, err := retrievalmarket.NewParamsV1(abi.NewTokenAmount(1), 100, 100, sel, nil, abi.NewTokenAmount(0))
+		require.NoError(t, err)
+		store, retrieved := retrieve(t, params)
+		require.False(t, retrieved)
+
+		cost, err := selectors.Walk(ctx, local, payloadCID, sel)
+		require.NoError(t, err)
+		for _, c := range cost.CIDs {
+			has, err := store.Bstore.Has(c)
+			require.NoError(t, err)
+			require.True(t, has)
+		}
+	})
+}
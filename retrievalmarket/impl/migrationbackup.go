@@ -0,0 +1,39 @@
+package retrievalimpl
+
+import (
+	"github.com/ipfs/go-datastore"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+)
+
+// MigrationBackup copies the provider's datastore to backup before deals are migrated
+// to a new version, so that the upgrade can be rolled back with migrationtools.Restore.
+// Nothing is copied when there is nothing to migrate
+func MigrationBackup(backup datastore.Batching) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.migrationBackup = backup
+	}
+}
+
+// backupBeforeMigrating snapshots the datastore if a backup is configured and deals
+// have not yet been migrated to target
+func (p *Provider) backupBeforeMigrating(target versioning.VersionKey) error {
+	if p.migrationBackup == nil {
+		return nil
+	}
+	current, err := migrationtools.CurrentVersion(p.ds)
+	if err != nil {
+		return err
+	}
+	if current == target {
+		return nil
+	}
+	log.Infof("backing up retrieval provider datastore before migrating from version %q to %q", current, target)
+	if err := migrationtools.Snapshot(p.ds, p.migrationBackup); err != nil {
+		return xerrors.Errorf("backing up retrieval provider datastore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package retrievalimpl
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/askstore"
+)
+
+// servedMiner is a miner the provider serves retrievals for: the piece store that
+// records where its pieces are, the node that unseals its sectors and its ask
+type servedMiner struct {
+	address    address.Address
+	pieceStore piecestore.PieceStore
+	node       retrievalmarket.RetrievalProviderNode
+	askStore   retrievalmarket.AskStore
+}
+
+// ServeMiner makes the provider serve retrievals for another miner besides the one it
+// was created with, so that one provider can serve several miners. Queries and deals
+// for payloads in the given piece store are answered with the miner's own ask, paid
+// to the miner's worker address, and unseal the miner's sectors with the given node.
+// The miner's ask is set with SetMinerAsk. It must be passed to NewProvider
+func ServeMiner(miner address.Address, pieceStore piecestore.PieceStore, node retrievalmarket.RetrievalProviderNode) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.miners = append(p.miners, &servedMiner{
+			address:    miner,
+			pieceStore: pieceStore,
+			node:       node,
+		})
+	}
+}
+
+// openMinerAskStores opens the ask store of each miner added with ServeMiner
+func (p *Provider) openMinerAskStores() error {
+	seen := make(map[address.Address]struct{}, len(p.miners))
+	for _, miner := range p.miners {
+		if _, ok := seen[miner.address]; ok {
+			return xerrors.Errorf("miner %s is served more than once", miner.address)
+		}
+		seen[miner.address] = struct{}{}
+		if miner.askStore != nil {
+			continue
+		}
+		ds := namespace.Wrap(p.ds, datastore.NewKey("retrieval-miner-asks/"+miner.address.String()))
+		openAskStore := askstore.NewAskStore
+		if p.readOnly {
+			openAskStore = askstore.LoadAskStore
+		}
+		askStore, err := openAskStore(ds, datastore.NewKey("latest"))
+		if err != nil {
+			return xerrors.Errorf("opening ask store for miner %s: %w", miner.address, err)
+		}
+		miner.askStore = askStore
+	}
+	return nil
+}
+
+// Miners returns the addresses of the miners the provider serves retrievals for,
+// starting with the miner it was created with
+func (p *Provider) Miners() []address.Address {
+	miners := make([]address.Address, 0, len(p.miners))
+	for _, miner := range p.miners {
+		miners = append(miners, miner.address)
+	}
+	return miners
+}
+
+// GetMinerAsk returns the deal parameters the provider accepts for retrievals from
+// the given miner
+func (p *Provider) GetMinerAsk(miner address.Address) (*retrievalmarket.Ask, error) {
+	served, err := p.servedMiner(miner)
+	if err != nil {
+		return nil, err
+	}
+	return served.askStore.GetAsk(), nil
+}
+
+// SetMinerAsk sets the deal parameters the provider accepts for retrievals from the
+// given miner
+func (p *Provider) SetMinerAsk(miner address.Address, ask *retrievalmarket.Ask) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	served, err := p.servedMiner(miner)
+	if err != nil {
+		return err
+	}
+	return served.askStore.SetAsk(ask)
+}
+
+func (p *Provider) servedMiner(miner address.Address) (*servedMiner, error) {
+	for _, served := range p.miners {
+		if served.address == miner {
+			return served, nil
+		}
+	}
+	return nil, xerrors.Errorf("miner %s is not served by this provider", miner)
+}
+
+// minerOrDefault returns the served miner with the given address, or the miner the
+// provider was created with if the address is nil, as it is for deals made before
+// the provider served several miners
+func (p *Provider) minerOrDefault(miner *address.Address) *servedMiner {
+	if miner != nil {
+		if served, err := p.servedMiner(*miner); err == nil {
+			return served
+		}
+	}
+	return p.miners[0]
+}
+
+// routePayload finds the miner whose sectors hold the given payload, and the piece
+// it is in. A pieceCID of cid.Undef matches any piece holding the payload. Miners are
+// searched in the order they were added, and the error from the miner the provider
+// was created with is returned if no miner has the payload
+func (p *Provider) routePayload(payloadCID, pieceCID cid.Cid) (*servedMiner, piecestore.PieceInfo, error) {
+	var firstErr error
+	for _, miner := range p.miners {
+		pieceInfo, err := getPieceInfoFromCid(miner.pieceStore, payloadCID, pieceCID)
+		if err == nil {
+			return miner, pieceInfo, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return p.miners[0], piecestore.PieceInfoUndefined, firstErr
+}
+
+// routePiece finds the miner whose sectors hold the piece with the given CID
+func (p *Provider) routePiece(pieceCID cid.Cid) (*servedMiner, piecestore.PieceInfo, error) {
+	var firstErr error
+	for _, miner := range p.miners {
+		pieceInfo, err := miner.pieceStore.GetPieceInfo(pieceCID)
+		if err == nil {
+			return miner, pieceInfo, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return p.miners[0], piecestore.PieceInfoUndefined, firstErr
+}
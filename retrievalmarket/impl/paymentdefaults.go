@@ -0,0 +1,86 @@
+package retrievalimpl
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// PersistPaymentDefaults keeps the record of clients that stopped paying part way
+// through a deal in the given datastore, so that it survives restarts. Without it,
+// the record is kept in memory. It must be passed to NewProvider
+func PersistPaymentDefaults(ds datastore.Batching) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.paymentDefaultsDs = ds
+	}
+}
+
+// PenalizePaymentDefaults makes clients that stopped paying part way through earlier
+// deals pay a deposit upfront, or rejects their deals, according to the given policy
+func PenalizePaymentDefaults(policy retrievalmarket.PaymentDefaultPolicy) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.paymentDefaultPolicy = policy
+	}
+}
+
+// ListPaymentDefaults returns the clients that have stopped paying part way through a
+// deal, the clients with the most defaults first
+func (p *Provider) ListPaymentDefaults() ([]retrievalmarket.ClientPaymentDefaults, error) {
+	return p.paymentDefaults.List()
+}
+
+// recordPaymentDefaults follows the payments a deal is waiting for, and counts a
+// default against the client if the deal ends while waiting
+func (p *Provider) recordPaymentDefaults(evt retrievalmarket.ProviderEvent, deal retrievalmarket.ProviderDealState) {
+	var err error
+	switch {
+	case evt == retrievalmarket.ProviderEventPaymentRequested &&
+		(deal.Status == retrievalmarket.DealStatusFundsNeeded || deal.Status == retrievalmarket.DealStatusFundsNeededLastPayment):
+		err = p.paymentDefaults.PaymentRequested(deal.Identifier())
+	case evt == retrievalmarket.ProviderEventPaymentReceived:
+		err = p.paymentDefaults.PaymentReceived(deal.Identifier())
+	case deal.Status == retrievalmarket.DealStatusCompleted ||
+		deal.Status == retrievalmarket.DealStatusErrored ||
+		deal.Status == retrievalmarket.DealStatusCancelled:
+		var defaulted bool
+		defaulted, err = p.paymentDefaults.DealEnded(deal.Identifier(), deal.Status == retrievalmarket.DealStatusCompleted)
+		if defaulted {
+			log.Warnf("client %s stopped paying for retrieval deal %d", deal.Receiver, deal.ID)
+		}
+	}
+	if err != nil {
+		log.Errorf("recording payment defaults: %s", err)
+	}
+}
+
+// checkPaymentDefaults rejects a deal from a client with too many payment defaults,
+// or without the deposit such a client must pay upfront
+func (p *Provider) checkPaymentDefaults(receiver peer.ID, unsealPrice abi.TokenAmount) error {
+	policy := p.paymentDefaultPolicy
+	if policy.RejectAfter == 0 && policy.DepositAfter == 0 {
+		return nil
+	}
+	record, err := p.paymentDefaults.Defaults(receiver)
+	if err != nil {
+		return err
+	}
+	if policy.RejectAfter > 0 && record.Defaults >= policy.RejectAfter {
+		return fmt.Errorf("client stopped paying for %d earlier deals", record.Defaults)
+	}
+	if policy.DepositAfter > 0 && record.Defaults >= policy.DepositAfter && !policy.Deposit.Nil() {
+		required := policy.Deposit
+		if ask := p.GetAsk(); !ask.UnsealPrice.Nil() {
+			required = big.Add(ask.UnsealPrice, policy.Deposit)
+		}
+		if unsealPrice.LessThan(required) {
+			return fmt.Errorf("client stopped paying for %d earlier deals and must pay %s upfront", record.Defaults, required)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Package paymentdefaults tracks retrieval clients that stop paying part way through a
+deal, so that a provider can make repeat defaulters pay upfront or turn them away.
+
+A deal is waiting for payment from the time the provider requests a payment until the
+client pays it. A deal that ends without completing while it is waiting for payment
+counts as a default against the client. Both the deals waiting for payment and the
+default counts are written to a datastore, so that they survive restarts. Default
+counts are stored as CBOR under the client's peer ID.
+*/
+package paymentdefaults
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+var (
+	awaitingPrefix = datastore.NewKey("awaiting")
+	clientsPrefix  = datastore.NewKey("clients")
+)
+
+// Tracker counts the payment defaults of retrieval clients
+type Tracker struct {
+	ds datastore.Batching
+
+	lk  sync.Mutex
+	now func() time.Time
+}
+
+// New returns a Tracker that keeps its records in the given datastore
+func New(ds datastore.Batching) *Tracker {
+	return &Tracker{ds: ds, now: time.Now}
+}
+
+// PaymentRequested notes that a deal is waiting for the client to pay
+func (t *Tracker) PaymentRequested(deal retrievalmarket.ProviderDealIdentifier) error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if err := t.ds.Put(awaitingKey(deal), []byte{}); err != nil {
+		return xerrors.Errorf("recording payment request for deal %s: %w", deal, err)
+	}
+	return nil
+}
+
+// PaymentReceived notes that the client paid what a deal was waiting for
+func (t *Tracker) PaymentReceived(deal retrievalmarket.ProviderDealIdentifier) error {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if err := t.ds.Delete(awaitingKey(deal)); err != nil {
+		return xerrors.Errorf("recording payment for deal %s: %w", deal, err)
+	}
+	return nil
+}
+
+// DealEnded notes that a deal has ended. If the deal did not complete while it was
+// waiting for payment, a default is counted against the client and DealEnded
+// returns true
+func (t *Tracker) DealEnded(deal retrievalmarket.ProviderDealIdentifier, completed bool) (bool, error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	awaiting, err := t.ds.Has(awaitingKey(deal))
+	if err != nil {
+		return false, xerrors.Errorf("checking payment requests for deal %s: %w", deal, err)
+	}
+	if !awaiting {
+		return false, nil
+	}
+	if err := t.ds.Delete(awaitingKey(deal)); err != nil {
+		return false, xerrors.Errorf("removing payment request for deal %s: %w", deal, err)
+	}
+	if completed {
+		return false, nil
+	}
+
+	record, err := t.get(deal.Receiver)
+	if err != nil {
+		return false, err
+	}
+	record.Defaults++
+	record.LastDeal = deal.DealID
+	record.LastDefault = t.now()
+	value, err := cborutil.Dump(newClientRecord(record))
+	if err != nil {
+		return false, err
+	}
+	if err := t.ds.Put(clientKey(deal.Receiver), value); err != nil {
+		return false, xerrors.Errorf("saving payment defaults of %s: %w", deal.Receiver, err)
+	}
+	return true, nil
+}
+
+// Defaults returns the payment defaults of a client
+func (t *Tracker) Defaults(client peer.ID) (retrievalmarket.ClientPaymentDefaults, error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.get(client)
+}
+
+// List returns the payment defaults of every client that has defaulted, the clients
+// with the most defaults first
+func (t *Tracker) List() ([]retrievalmarket.ClientPaymentDefaults, error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	results, err := t.ds.Query(query.Query{Prefix: clientsPrefix.String()})
+	if err != nil {
+		return nil, xerrors.Errorf("listing payment defaults: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, xerrors.Errorf("listing payment defaults: %w", err)
+	}
+	records := make([]retrievalmarket.ClientPaymentDefaults, 0, len(entries))
+	for _, entry := range entries {
+		var record clientRecord
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &record); err != nil {
+			return nil, xerrors.Errorf("decoding payment defaults %s: %w", entry.Key, err)
+		}
+		records = append(records, record.defaults())
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Defaults > records[j].Defaults
+	})
+	return records, nil
+}
+
+func (t *Tracker) get(client peer.ID) (retrievalmarket.ClientPaymentDefaults, error) {
+	record := retrievalmarket.ClientPaymentDefaults{Client: client}
+	value, err := t.ds.Get(clientKey(client))
+	if err == datastore.ErrNotFound {
+		return record, nil
+	}
+	if err != nil {
+		return record, xerrors.Errorf("loading payment defaults of %s: %w", client, err)
+	}
+	var stored clientRecord
+	if err := cborutil.ReadCborRPC(bytes.NewReader(value), &stored); err != nil {
+		return record, xerrors.Errorf("decoding payment defaults of %s: %w", client, err)
+	}
+	return stored.defaults(), nil
+}
+
+func awaitingKey(deal retrievalmarket.ProviderDealIdentifier) datastore.Key {
+	return awaitingPrefix.ChildString(deal.Receiver.String()).ChildString(deal.DealID.String())
+}
+
+func clientKey(client peer.ID) datastore.Key {
+	return clientsPrefix.ChildString(client.String())
+}
@@ -0,0 +1,86 @@
+package paymentdefaults_test
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/paymentdefaults"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestTracker(t *testing.T) {
+	peers := shared_testutil.GeneratePeers(2)
+	deal := func(p int, id retrievalmarket.DealID) retrievalmarket.ProviderDealIdentifier {
+		return retrievalmarket.ProviderDealIdentifier{Receiver: peers[p], DealID: id}
+	}
+
+	t.Run("counts deals that end while waiting for payment", func(t *testing.T) {
+		tracker := paymentdefaults.New(dss.MutexWrap(datastore.NewMapDatastore()))
+
+		require.NoError(t, tracker.PaymentRequested(deal(0, 1)))
+		defaulted, err := tracker.DealEnded(deal(0, 1), false)
+		require.NoError(t, err)
+		require.True(t, defaulted)
+
+		// ending the same deal again does not count twice
+		defaulted, err = tracker.DealEnded(deal(0, 1), false)
+		require.NoError(t, err)
+		require.False(t, defaulted)
+
+		record, err := tracker.Defaults(peers[0])
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), record.Defaults)
+		require.Equal(t, retrievalmarket.DealID(1), record.LastDeal)
+	})
+
+	t.Run("does not count deals that were paid or completed", func(t *testing.T) {
+		tracker := paymentdefaults.New(dss.MutexWrap(datastore.NewMapDatastore()))
+
+		require.NoError(t, tracker.PaymentRequested(deal(0, 1)))
+		require.NoError(t, tracker.PaymentReceived(deal(0, 1)))
+		defaulted, err := tracker.DealEnded(deal(0, 1), false)
+		require.NoError(t, err)
+		require.False(t, defaulted)
+
+		require.NoError(t, tracker.PaymentRequested(deal(0, 2)))
+		defaulted, err = tracker.DealEnded(deal(0, 2), true)
+		require.NoError(t, err)
+		require.False(t, defaulted)
+
+		record, err := tracker.Defaults(peers[0])
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), record.Defaults)
+		records, err := tracker.List()
+		require.NoError(t, err)
+		require.Empty(t, records)
+	})
+
+	t.Run("keeps records across restarts", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		tracker := paymentdefaults.New(ds)
+		require.NoError(t, tracker.PaymentRequested(deal(0, 1)))
+		require.NoError(t, tracker.PaymentRequested(deal(1, 1)))
+		_, err := tracker.DealEnded(deal(1, 1), false)
+		require.NoError(t, err)
+
+		restarted := paymentdefaults.New(ds)
+		require.NoError(t, restarted.PaymentRequested(deal(1, 2)))
+		_, err = restarted.DealEnded(deal(1, 2), false)
+		require.NoError(t, err)
+		defaulted, err := restarted.DealEnded(deal(0, 1), false)
+		require.NoError(t, err)
+		require.True(t, defaulted)
+
+		records, err := restarted.List()
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, peers[1], records[0].Client)
+		require.Equal(t, uint64(2), records[0].Defaults)
+		require.Equal(t, peers[0], records[1].Client)
+		require.Equal(t, uint64(1), records[1].Defaults)
+	})
+}
@@ -0,0 +1,39 @@
+package paymentdefaults
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+//go:generate cbor-gen-for --map-encoding clientRecord
+
+// clientRecord is how the payment defaults of a client are stored. The client's peer
+// ID is kept as a plain string, so that the record of any peer ID can be read back
+type clientRecord struct {
+	Client      string
+	Defaults    uint64
+	LastDeal    retrievalmarket.DealID
+	LastDefault cbg.CborTime
+}
+
+func newClientRecord(defaults retrievalmarket.ClientPaymentDefaults) *clientRecord {
+	return &clientRecord{
+		Client:      string(defaults.Client),
+		Defaults:    defaults.Defaults,
+		LastDeal:    defaults.LastDeal,
+		LastDefault: cbg.CborTime(defaults.LastDefault),
+	}
+}
+
+func (r *clientRecord) defaults() retrievalmarket.ClientPaymentDefaults {
+	return retrievalmarket.ClientPaymentDefaults{
+		Client:      peer.ID(r.Client),
+		Defaults:    r.Defaults,
+		LastDeal:    r.LastDeal,
+		LastDefault: time.Time(r.LastDefault),
+	}
+}
@@ -0,0 +1,240 @@
+package retrievalimpl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/fr32"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+)
+
+// maxCARSectionSize is the largest section read from the CAR in a piece, the same
+// limit go-car applies
+const maxCARSectionSize = 32 << 20
+
+// DefaultMaxPieceStreams is the number of pieces a provider sends at once if
+// ServePieces is not given a limit
+const DefaultMaxPieceStreams = 4
+
+// ServePieces lets clients retrieve whole pieces by PieceCID, for clients that want
+// the piece itself rather than the DAG inside it. Pieces are sent without a deal or
+// payment, unsealing them if needed, so only the pieces allow allows are served to
+// each client, and no pieces are served if allow is nil. At most maxStreams pieces,
+// or DefaultMaxPieceStreams if it is zero, are sent at once; requests beyond that are
+// rejected until a piece has been sent
+func ServePieces(allow func(client peer.ID, pieceCID cid.Cid) bool, maxStreams uint64) RetrievalProviderOption {
+	return func(provider *Provider) {
+		if maxStreams == 0 {
+			maxStreams = DefaultMaxPieceStreams
+		}
+		provider.pieceAccess = allow
+		provider.pieceStreams = make(chan struct{}, maxStreams)
+	}
+}
+
+/*
+HandlePieceStream is called by the network implementation whenever a new request is received on
+the piece protocol
+
+A Provider handling a `PieceRequest` does the following:
+
+1. Checks that it was configured with `ServePieces`, that it serves the piece to the client, and that it is not
+already sending as many pieces as it sends at once.
+
+2. Looks up the piece in the piece store of each miner it serves and unseals it from the first sector that can be unsealed,
+or reads its remote copy.
+
+3. Writes a `PieceResponse` with the size of the data, followed by the piece's data in the requested format.
+
+The connection is closed once the piece has been sent.
+*/
+func (p *Provider) HandlePieceStream(stream rmnet.PieceStream) {
+	defer stream.Close()
+	req, err := stream.ReadPieceRequest()
+	if err != nil {
+		return
+	}
+
+	respond := func(status retrievalmarket.PieceResponseStatus, message string) {
+		err := stream.WritePieceResponse(retrievalmarket.PieceResponse{Status: status, Message: message})
+		if err != nil {
+			log.Errorf("Piece retrieval: writing response: %s", err)
+		}
+	}
+
+	if p.pieceAccess == nil {
+		respond(retrievalmarket.PieceResponseRejected, "provider does not serve whole pieces")
+		return
+	}
+	if !p.pieceAccess(stream.RemotePeer(), req.PieceCID) {
+		respond(retrievalmarket.PieceResponseRejected, "piece is not available to this client")
+		return
+	}
+	if _, ok := retrievalmarket.PieceFormats[req.Format]; !ok {
+		respond(retrievalmarket.PieceResponseRejected, "unknown piece format")
+		return
+	}
+	select {
+	case p.pieceStreams <- struct{}{}:
+		defer func() { <-p.pieceStreams }()
+	default:
+		respond(retrievalmarket.PieceResponseRejected, queryBusyMessage)
+		return
+	}
+
+	miner, pieceInfo, err := p.routePiece(req.PieceCID)
+	if err != nil {
+		if xerrors.Is(err, retrievalmarket.ErrNotFound) || xerrors.Is(err, datastore.ErrNotFound) {
+			respond(retrievalmarket.PieceResponseNotFound, "piece not found")
+			return
+		}
+		log.Errorf("Piece retrieval: GetPieceInfo: %s", err)
+		respond(retrievalmarket.PieceResponseError, err.Error())
+		return
+	}
+	if len(pieceInfo.Deals) == 0 {
+		respond(retrievalmarket.PieceResponseNotFound, "piece not found")
+		return
+	}
+
+	ctx := context.TODO()
+	reader, err := p.readPiece(ctx, miner.node, pieceInfo)
+	if err != nil {
+		log.Errorf("Piece retrieval: reading piece %s: %s", req.PieceCID, err)
+		respond(retrievalmarket.PieceResponseError, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	// a remote copy may hold only the start of the piece, so the unsealed length is
+	// made up with the zeros that fill the rest of the piece
+	length := pieceInfo.Deals[0].Length
+	data := io.LimitReader(io.MultiReader(reader, zeroReader{}), int64(length.Unpadded()))
+
+	resp := retrievalmarket.PieceResponse{Status: retrievalmarket.PieceResponseOk}
+	switch req.Format {
+	case retrievalmarket.PieceFormatUnpadded:
+		resp.Size = uint64(length.Unpadded())
+	case retrievalmarket.PieceFormatPadded:
+		resp.Size = uint64(length)
+		data = fr32.NewPadReader(data)
+	}
+	if err := stream.WritePieceResponse(resp); err != nil {
+		log.Errorf("Piece retrieval: writing response: %s", err)
+		return
+	}
+
+	if req.Format == retrievalmarket.PieceFormatCAR {
+		err = copyCAR(stream, data)
+	} else {
+		_, err = io.Copy(stream, data)
+	}
+	if err != nil {
+		log.Errorf("Piece retrieval: sending piece %s: %s", req.PieceCID, err)
+	}
+}
+
+// readPiece reads a piece from its staged CAR, or unseals it from the first sector
+// that can be unsealed with the given node, falling back to the piece's remote copy
+// if it has one
+func (p *Provider) readPiece(ctx context.Context, node retrievalmarket.RetrievalProviderNode, pieceInfo piecestore.PieceInfo) (io.ReadCloser, error) {
+	if p.stagedPieces != nil {
+		if staged, ok := p.stagedPieces.Open(pieceInfo.PieceCID); ok {
+			return staged, nil
+		}
+	}
+	lastErr := xerrors.New("no sectors found to unseal from")
+	for _, deal := range pieceInfo.Deals {
+		reader, err := node.UnsealSector(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+		if err == nil {
+			return reader, nil
+		}
+		lastErr = err
+	}
+	if pieceInfo.RemoteLocation == "" || p.remotePieceFetcher == nil {
+		return nil, lastErr
+	}
+	remote, err := p.remotePieceFetcher.FetchPiece(ctx, pieceInfo.PieceCID, pieceInfo.RemoteLocation)
+	if err != nil {
+		return nil, xerrors.Errorf("unsealing piece: %s; fetching remote copy: %w", lastErr, err)
+	}
+	return remote, nil
+}
+
+// errNotUnsealed is returned when a piece has no copy that can be read without
+// unsealing a sector
+var errNotUnsealed = errors.New("piece has no unsealed copy")
+
+// readUnsealedPiece reads a piece from its staged CAR, or from a sector the given node
+// keeps an unsealed copy of. It never unseals a sector, so it is safe to use for data
+// no unseal price has been paid for
+func (p *Provider) readUnsealedPiece(ctx context.Context, node retrievalmarket.RetrievalProviderNode, pieceInfo piecestore.PieceInfo) (io.ReadCloser, error) {
+	if p.stagedPieces != nil {
+		if staged, ok := p.stagedPieces.Open(pieceInfo.PieceCID); ok {
+			return staged, nil
+		}
+	}
+	estimator, ok := node.(retrievalmarket.UnsealCostEstimator)
+	if !ok {
+		return nil, errNotUnsealed
+	}
+	for _, deal := range pieceInfo.Deals {
+		estimate, err := estimator.EstimateUnsealCost(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+		if err != nil || !estimate.Unsealed {
+			continue
+		}
+		reader, err := node.UnsealSector(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+		if err == nil {
+			return reader, nil
+		}
+	}
+	return nil, errNotUnsealed
+}
+
+// copyCAR copies the CAR at the start of a piece's data, stopping at the zeros that
+// fill the rest of the piece
+func copyCAR(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	var header [binary.MaxVarintLen64]byte
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if size > maxCARSectionSize {
+			return xerrors.Errorf("CAR section of %d bytes is larger than the limit of %d", size, maxCARSectionSize)
+		}
+		n := binary.PutUvarint(header[:], size)
+		if _, err := w.Write(header[:n]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, br, int64(size)); err != nil {
+			return err
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
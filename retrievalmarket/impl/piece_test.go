@@ -0,0 +1,151 @@
+package retrievalimpl_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// testPieceStream is a piece stream that holds a request and records the response
+// and data written to it
+type testPieceStream struct {
+	bytes.Buffer
+	p         peer.ID
+	req       retrievalmarket.PieceRequest
+	responses []retrievalmarket.PieceResponse
+}
+
+func (s *testPieceStream) ReadPieceRequest() (retrievalmarket.PieceRequest, error) {
+	return s.req, nil
+}
+
+func (s *testPieceStream) WritePieceRequest(req retrievalmarket.PieceRequest) error {
+	s.req = req
+	return nil
+}
+
+func (s *testPieceStream) ReadPieceResponse() (retrievalmarket.PieceResponse, error) {
+	return s.responses[0], nil
+}
+
+func (s *testPieceStream) WritePieceResponse(resp retrievalmarket.PieceResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *testPieceStream) RemotePeer() peer.ID {
+	return s.p
+}
+
+func (s *testPieceStream) Close() error {
+	return nil
+}
+
+func TestHandlePieceStream(t *testing.T) {
+	ctx := context.Background()
+	pieceCID := tut.GenerateCids(1)[0]
+	client := peer.ID("somepeer")
+
+	// a CAR with a header section and one block section, followed by the zeros that
+	// fill the rest of the piece
+	car := []byte{3, 1, 2, 3, 2, 4, 5}
+	length := abi.PaddedPieceSize(128)
+	unpadded := make([]byte, length.Unpadded())
+	copy(unpadded, car)
+	// the CAR is within the first 31 bytes of the piece, which fr32 padding leaves as
+	// they are
+	padded := make([]byte, length)
+	copy(padded, car)
+
+	piece := piecestore.PieceInfo{
+		PieceCID: pieceCID,
+		Deals: []piecestore.DealInfo{
+			{SectorID: 1, Offset: 0, Length: length},
+		},
+	}
+
+	allowAll := func(peer.ID, cid.Cid) bool { return true }
+
+	receive := func(t *testing.T, req retrievalmarket.PieceRequest, pieceStore piecestore.PieceStore, opts ...retrievalimpl.RetrievalProviderOption) *testPieceStream {
+		node := testnodes.NewTestRetrievalProviderNode()
+		node.StubUnseal(1, 0, length.Unpadded(), car)
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
+		p, err := retrievalimpl.NewProvider(address.TestAddress2, node, net, pieceStore, multiStore, tut.NewTestDataTransfer(), ds, opts...)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+
+		stream := &testPieceStream{p: client, req: req}
+		net.ReceivePieceStream(stream)
+		require.Len(t, stream.responses, 1)
+		return stream
+	}
+
+	t.Run("rejects requests unless pieces are served", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		stream := receive(t, retrievalmarket.PieceRequest{PieceCID: pieceCID}, pieceStore)
+		require.Equal(t, retrievalmarket.PieceResponseRejected, stream.responses[0].Status)
+		require.Empty(t, stream.Bytes())
+	})
+
+	t.Run("rejects requests without an allow list", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		stream := receive(t, retrievalmarket.PieceRequest{PieceCID: pieceCID}, pieceStore, retrievalimpl.ServePieces(nil, 0))
+		require.Equal(t, retrievalmarket.PieceResponseRejected, stream.responses[0].Status)
+		require.Empty(t, stream.Bytes())
+	})
+
+	t.Run("rejects pieces the client is not allowed", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		allow := func(p peer.ID, c cid.Cid) bool { return p != client }
+		stream := receive(t, retrievalmarket.PieceRequest{PieceCID: pieceCID}, pieceStore, retrievalimpl.ServePieces(allow, 0))
+		require.Equal(t, retrievalmarket.PieceResponseRejected, stream.responses[0].Status)
+	})
+
+	t.Run("reports missing pieces", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectMissingPiece(pieceCID)
+		stream := receive(t, retrievalmarket.PieceRequest{PieceCID: pieceCID}, pieceStore, retrievalimpl.ServePieces(allowAll, 0))
+		require.Equal(t, retrievalmarket.PieceResponseNotFound, stream.responses[0].Status)
+		pieceStore.VerifyExpectations(t)
+	})
+
+	testCases := []struct {
+		format  retrievalmarket.PieceFormat
+		expSize uint64
+		expData []byte
+	}{
+		{retrievalmarket.PieceFormatUnpadded, uint64(length.Unpadded()), unpadded},
+		{retrievalmarket.PieceFormatPadded, uint64(length), padded},
+		{retrievalmarket.PieceFormatCAR, 0, car},
+	}
+	for _, tc := range testCases {
+		t.Run("sends "+retrievalmarket.PieceFormats[tc.format], func(t *testing.T) {
+			pieceStore := tut.NewTestPieceStore()
+			pieceStore.ExpectPiece(pieceCID, piece)
+			req := retrievalmarket.PieceRequest{PieceCID: pieceCID, Format: tc.format}
+			stream := receive(t, req, pieceStore, retrievalimpl.ServePieces(allowAll, 0))
+			require.Equal(t, retrievalmarket.PieceResponse{Status: retrievalmarket.PieceResponseOk, Size: tc.expSize}, stream.responses[0])
+			require.Equal(t, tc.expData, stream.Bytes())
+			pieceStore.VerifyExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package retrievalimpl
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-commp-utils/pieceio"
+	"github.com/filecoin-project/go-commp-utils/pieceio/cario"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
+)
+
+// VerifyRetrievedPieces makes the client recompute the CommP of the data it retrieves
+// for a deal with a piece CID, and record in the deal's VerifiedAgainstPiece field
+// whether it matches. Only deals that retrieve a whole piece into a store can be
+// verified: the payload CID must be the root of the piece's payload, and the selector
+// must select the entire DAG. The CommP is computed for sectors of the given seal
+// proof type. The data is checked before the last payment for the deal is sent
+func VerifyRetrievedPieces(rt abi.RegisteredSealProof) RetrievalClientOption {
+	return func(c *Client) {
+		c.verifyPieces = true
+		c.pieceProofType = rt
+	}
+}
+
+func (c *clientDealEnvironment) RetrievedPieceCID(ctx context.Context, deal retrievalmarket.ClientDealState) (cid.Cid, bool, error) {
+	if !c.c.verifyPieces || deal.PieceCID == nil || deal.StoreID == nil {
+		return cid.Undef, false, nil
+	}
+	whole, err := selectsWholeDAG(deal.Params)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	if !whole {
+		return cid.Undef, false, nil
+	}
+
+	pio := pieceio.NewPieceIO(cario.NewCarIO(), nil, c.c.multiStore)
+	pieceCid, _, err := pio.GeneratePieceCommitment(c.c.pieceProofType, deal.PayloadCID, selectors.Entire(), deal.StoreID)
+	if err != nil {
+		return cid.Undef, false, xerrors.Errorf("generating CommP: %w", err)
+	}
+	return pieceCid, true, nil
+}
+
+// selectsWholeDAG returns true if the deal parameters select the entire DAG under
+// the payload CID
+func selectsWholeDAG(params retrievalmarket.Params) (bool, error) {
+	if !params.SelectorSpecified() {
+		return true, nil
+	}
+	var all bytes.Buffer
+	if err := dagcbor.Encoder(selectors.Entire(), &all); err != nil {
+		return false, xerrors.Errorf("encoding selector: %w", err)
+	}
+	return bytes.Equal(params.Selector.Raw, all.Bytes()), nil
+}
@@ -0,0 +1,191 @@
+package retrievalimpl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/possession"
+	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+)
+
+// AnswerPossessionChallenges lets clients challenge the provider to prove it holds a
+// payload before they pay to unseal it, by sending the blocks on up to maxPaths
+// paths through the payload's DAG. Challenges are answered only from a copy of the
+// payload's piece that is already unsealed, as answering is free, and are admitted
+// like queries
+func AnswerPossessionChallenges(maxPaths uint64) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.possessionMaxPaths = maxPaths
+	}
+}
+
+/*
+HandlePossessionStream is called by the network implementation whenever a new challenge is received on the
+possession protocol
+
+A Provider handling a `PossessionChallenge` does the following:
+
+1. Declines the challenge if it was not configured with `AnswerPossessionChallenges`, the challenge asks for more
+paths than it answers, or it is too busy to answer queries.
+
+2. Looks up the piece that holds the payload, the same way as for a query.
+
+3. Walks the challenged paths from the payload's root block, reading each block from an unsealed copy of the piece
+at the location the piece store records for it, and writes a `PossessionProof` holding the blocks in a CAR. The
+challenge is declined if the piece has no unsealed copy, since unsealing one for a free challenge would let clients
+make the provider unseal without paying.
+
+The connection is closed once the proof has been sent.
+*/
+func (p *Provider) HandlePossessionStream(stream rmnet.PossessionStream) {
+	defer stream.Close()
+	challenge, err := stream.ReadPossessionChallenge()
+	if err != nil {
+		return
+	}
+
+	respond := func(status retrievalmarket.PossessionProofStatus, message string, data []byte) {
+		err := stream.WritePossessionProof(retrievalmarket.PossessionProof{Status: status, Message: message, Data: data})
+		if err != nil {
+			log.Errorf("Possession challenge: writing proof: %s", err)
+		}
+	}
+
+	if p.possessionMaxPaths == 0 {
+		respond(retrievalmarket.PossessionProofDeclined, "provider does not answer possession challenges", nil)
+		return
+	}
+	if challenge.Paths == 0 || challenge.Paths > p.possessionMaxPaths {
+		respond(retrievalmarket.PossessionProofDeclined, fmt.Sprintf("provider proves between 1 and %d paths", p.possessionMaxPaths), nil)
+		return
+	}
+	release, admitted := p.admitQuery(stream.RemotePeer())
+	if !admitted {
+		respond(retrievalmarket.PossessionProofDeclined, queryBusyMessage, nil)
+		return
+	}
+	defer release()
+
+	payloadCID := challenge.Query.PayloadCID
+	pieceCID := cid.Undef
+	if challenge.Query.PieceCID != nil {
+		pieceCID = *challenge.Query.PieceCID
+	}
+	miner, pieceInfo, err := p.routePayload(payloadCID, pieceCID)
+	if err != nil {
+		if xerrors.Is(err, retrievalmarket.ErrNotFound) || xerrors.Is(err, datastore.ErrNotFound) {
+			respond(retrievalmarket.PossessionProofNotFound, "payload not found", nil)
+			return
+		}
+		log.Errorf("Possession challenge: GetRefs: %s", err)
+		respond(retrievalmarket.PossessionProofError, err.Error(), nil)
+		return
+	}
+	if len(pieceInfo.Deals) == 0 {
+		respond(retrievalmarket.PossessionProofNotFound, "payload not found", nil)
+		return
+	}
+
+	ctx := context.TODO()
+	reader := &pieceBlockReader{ctx: ctx, p: p, miner: miner, pieceInfo: pieceInfo}
+	defer reader.Close()
+	walked, err := possession.Walk(ctx, payloadCID, challenge.Nonce, challenge.Paths, reader.Get)
+	if xerrors.Is(err, errNotUnsealed) {
+		respond(retrievalmarket.PossessionProofDeclined, "payload has no unsealed copy to prove possession from", nil)
+		return
+	}
+	if err != nil {
+		log.Warnf("Possession challenge: walking payload %s: %s", payloadCID, err)
+		respond(retrievalmarket.PossessionProofError, err.Error(), nil)
+		return
+	}
+	data, err := possession.WriteProof(payloadCID, walked)
+	if err != nil {
+		respond(retrievalmarket.PossessionProofError, err.Error(), nil)
+		return
+	}
+	if len(data) > cbg.ByteArrayMaxLen {
+		respond(retrievalmarket.PossessionProofError, "proof is too large to send, challenge fewer paths", nil)
+		return
+	}
+	respond(retrievalmarket.PossessionProofOk, "", data)
+}
+
+// pieceBlockReader reads blocks from an unsealed copy of a piece at the locations the
+// piece store records for them. It reads the copy forward, opening it again to read a
+// block before the last one read, and never unseals a sector
+type pieceBlockReader struct {
+	ctx       context.Context
+	p         *Provider
+	miner     *servedMiner
+	pieceInfo piecestore.PieceInfo
+
+	reader io.ReadCloser
+	pos    uint64
+}
+
+// Get reads the block with the given CID from the piece
+func (r *pieceBlockReader) Get(c cid.Cid) (blocks.Block, error) {
+	cidInfo, err := r.miner.pieceStore.GetCIDInfo(c)
+	if err != nil {
+		return nil, xerrors.Errorf("get cid info: %w", err)
+	}
+	var location *piecestore.BlockLocation
+	for _, pbl := range cidInfo.PieceBlockLocations {
+		if pbl.PieceCID.Equals(r.pieceInfo.PieceCID) && pbl.BlockSize > 0 {
+			location = &pbl.BlockLocation
+			break
+		}
+	}
+	if location == nil {
+		return nil, xerrors.Errorf("no location recorded in piece %s", r.pieceInfo.PieceCID)
+	}
+	if location.BlockSize > maxCARSectionSize {
+		return nil, xerrors.Errorf("block of %d bytes is larger than the limit of %d", location.BlockSize, maxCARSectionSize)
+	}
+
+	if r.reader == nil || location.RelOffset < r.pos {
+		r.Close()
+		r.reader, err = r.p.readUnsealedPiece(r.ctx, r.miner.node, r.pieceInfo)
+		if err != nil {
+			return nil, xerrors.Errorf("reading piece %s: %w", r.pieceInfo.PieceCID, err)
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, r.reader, int64(location.RelOffset-r.pos)); err != nil {
+		return nil, xerrors.Errorf("reading piece %s: %w", r.pieceInfo.PieceCID, err)
+	}
+	r.pos = location.RelOffset
+	data := make([]byte, location.BlockSize)
+	n, err := io.ReadFull(r.reader, data)
+	r.pos += uint64(n)
+	if err != nil {
+		return nil, xerrors.Errorf("reading %d bytes at offset %d: %w", location.BlockSize, location.RelOffset, err)
+	}
+	actual, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, xerrors.Errorf("hashing block: %w", err)
+	}
+	if !actual.Equals(c) {
+		return nil, xerrors.Errorf("data at offset %d hashes to %s", location.RelOffset, actual)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// Close closes the piece, if it is open
+func (r *pieceBlockReader) Close() {
+	if r.reader != nil {
+		_ = r.reader.Close()
+		r.reader = nil
+		r.pos = 0
+	}
+}
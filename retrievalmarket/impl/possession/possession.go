@@ -0,0 +1,146 @@
+/*
+Package possession lets a retrieval client check that a provider still holds a
+payload before paying it to unseal the payload, so that a client does not pay to
+unseal a piece from a provider whose piece store claims data it no longer has.
+
+The client challenges the provider with a random nonce and a number of paths. Walk
+follows each path from the payload's root block down to a leaf, picking the link it
+follows at each block by hashing the nonce with the path and the depth, so the
+provider cannot know which blocks it will need before it is challenged. The provider
+sends the blocks on the paths in a CAR, written by WriteProof, and Verify checks the
+blocks hash to their CIDs and that the same walk over them finds every block it
+needs.
+
+Blocks with no links, or in a codec the walk cannot decode, end a path.
+*/
+package possession
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	_ "github.com/ipfs/go-merkledag" // registers the dag-pb, raw and dag-cbor decoders
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
+)
+
+// MaxDepth is the most blocks a path goes down before it ends
+const MaxDepth = 64
+
+// NonceSize is the size of the nonces clients challenge providers with
+const NonceSize = 32
+
+// Getter returns the block with the given CID
+type Getter func(cid.Cid) (blocks.Block, error)
+
+// Walk returns the blocks on the given number of paths from the root block down to a
+// leaf, in the order they are first reached, without repeats
+func Walk(ctx context.Context, root cid.Cid, nonce []byte, paths uint64, get Getter) ([]blocks.Block, error) {
+	seen := make(map[cid.Cid]blocks.Block)
+	var walked []blocks.Block
+	for path := uint64(0); path < paths; path++ {
+		next := root
+		for depth := 0; depth < MaxDepth; depth++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			block, ok := seen[next]
+			if !ok {
+				var err error
+				block, err = get(next)
+				if err != nil {
+					return nil, xerrors.Errorf("getting block %s: %w", next, err)
+				}
+				seen[next] = block
+				walked = append(walked, block)
+			}
+			links := links(block)
+			if len(links) == 0 {
+				break
+			}
+			next = links[childIndex(nonce, path, depth, len(links))].Cid
+		}
+	}
+	return walked, nil
+}
+
+// WriteProof writes the blocks walked for a challenge into a CAR rooted at the root
+func WriteProof(root cid.Cid, walked []blocks.Block) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, &buf); err != nil {
+		return nil, xerrors.Errorf("writing CAR header: %w", err)
+	}
+	for _, block := range walked {
+		if err := util.LdWrite(&buf, block.Cid().Bytes(), block.RawData()); err != nil {
+			return nil, xerrors.Errorf("writing block %s: %w", block.Cid(), err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Verify checks that a proof is a CAR rooted at the root, holding every block on the
+// challenged paths, and that each of its blocks hashes to its CID
+func Verify(ctx context.Context, root cid.Cid, nonce []byte, paths uint64, proof []byte) error {
+	cr, err := car.NewCarReader(bufio.NewReader(bytes.NewReader(proof)))
+	if err != nil {
+		return xerrors.Errorf("reading proof: %w", err)
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(root) {
+		return xerrors.Errorf("proof is not rooted at %s", root)
+	}
+	sent := make(map[cid.Cid]blocks.Block)
+	for {
+		block, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return xerrors.Errorf("reading proof: %w", err)
+		}
+		actual, err := block.Cid().Prefix().Sum(block.RawData())
+		if err != nil {
+			return xerrors.Errorf("hashing block %s: %w", block.Cid(), err)
+		}
+		if !actual.Equals(block.Cid()) {
+			return xerrors.Errorf("block %s in proof hashes to %s", block.Cid(), actual)
+		}
+		sent[block.Cid()] = block
+	}
+
+	_, err = Walk(ctx, root, nonce, paths, func(c cid.Cid) (blocks.Block, error) {
+		block, ok := sent[c]
+		if !ok {
+			return nil, xerrors.New("missing from proof")
+		}
+		return block, nil
+	})
+	return err
+}
+
+// links returns the links of a block, or none if it cannot be decoded
+func links(block blocks.Block) []*ipldformat.Link {
+	nd, err := ipldformat.Decode(block)
+	if err != nil {
+		return nil
+	}
+	return nd.Links()
+}
+
+// childIndex picks the link a path follows from a block at the given depth
+func childIndex(nonce []byte, path uint64, depth int, links int) int {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], path)
+	binary.BigEndian.PutUint64(buf[8:], uint64(depth))
+	h := sha256.New()
+	_, _ = h.Write(nonce)
+	_, _ = h.Write(buf[:])
+	return int(binary.BigEndian.Uint64(h.Sum(nil)[:8]) % uint64(links))
+}
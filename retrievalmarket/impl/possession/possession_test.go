@@ -0,0 +1,92 @@
+package possession
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestProof(t *testing.T) {
+	ctx := context.Background()
+	nonce := shared_testutil.RandomBytes(NonceSize)
+
+	// a root with three children, each linking three leaves
+	dag := make(map[cid.Cid]blocks.Block)
+	root := new(merkledag.ProtoNode)
+	for i := 0; i < 3; i++ {
+		child := new(merkledag.ProtoNode)
+		for j := 0; j < 3; j++ {
+			leaf := merkledag.NewRawNode(shared_testutil.RandomBytes(100))
+			dag[leaf.Cid()] = leaf
+			require.NoError(t, child.AddNodeLink("", leaf))
+		}
+		dag[child.Cid()] = child
+		require.NoError(t, root.AddNodeLink("", child))
+	}
+	dag[root.Cid()] = root
+	get := func(c cid.Cid) (blocks.Block, error) {
+		block, ok := dag[c]
+		if !ok {
+			return nil, xerrors.New("not found")
+		}
+		return block, nil
+	}
+
+	t.Run("verifies the blocks on the paths", func(t *testing.T) {
+		walked, err := Walk(ctx, root.Cid(), nonce, 4, get)
+		require.NoError(t, err)
+		// every path goes through the root and one child to a leaf
+		require.GreaterOrEqual(t, len(walked), 3)
+		require.Equal(t, root.Cid(), walked[0].Cid())
+
+		proof, err := WriteProof(root.Cid(), walked)
+		require.NoError(t, err)
+		require.NoError(t, Verify(ctx, root.Cid(), nonce, 4, proof))
+	})
+
+	t.Run("fails without a block on the paths", func(t *testing.T) {
+		walked, err := Walk(ctx, root.Cid(), nonce, 4, get)
+		require.NoError(t, err)
+		proof, err := WriteProof(root.Cid(), walked[:len(walked)-1])
+		require.NoError(t, err)
+		require.Error(t, Verify(ctx, root.Cid(), nonce, 4, proof))
+	})
+
+	t.Run("fails with a block that does not match its CID", func(t *testing.T) {
+		walked, err := Walk(ctx, root.Cid(), nonce, 1, get)
+		require.NoError(t, err)
+		leaf := walked[len(walked)-1]
+		forged, err := blocks.NewBlockWithCid(shared_testutil.RandomBytes(100), leaf.Cid())
+		require.NoError(t, err)
+		walked[len(walked)-1] = forged
+		proof, err := WriteProof(root.Cid(), walked)
+		require.NoError(t, err)
+		require.Error(t, Verify(ctx, root.Cid(), nonce, 1, proof))
+	})
+
+	t.Run("fails for another root", func(t *testing.T) {
+		walked, err := Walk(ctx, root.Cid(), nonce, 1, get)
+		require.NoError(t, err)
+		proof, err := WriteProof(walked[1].Cid(), walked)
+		require.NoError(t, err)
+		require.Error(t, Verify(ctx, root.Cid(), nonce, 1, proof))
+	})
+
+	t.Run("fails if the provider is missing a block", func(t *testing.T) {
+		missing := func(c cid.Cid) (blocks.Block, error) {
+			if c.Equals(root.Cid()) {
+				return root, nil
+			}
+			return nil, xerrors.New("not found")
+		}
+		_, err := Walk(ctx, root.Cid(), nonce, 1, missing)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,150 @@
+package retrievalimpl_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-padreader"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/possession"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// testPossessionStream is a possession stream that holds a challenge, and records the
+// proofs written to it
+type testPossessionStream struct {
+	p         peer.ID
+	challenge retrievalmarket.PossessionChallenge
+	proofs    []retrievalmarket.PossessionProof
+}
+
+func (s *testPossessionStream) ReadPossessionChallenge() (retrievalmarket.PossessionChallenge, error) {
+	return s.challenge, nil
+}
+
+func (s *testPossessionStream) WritePossessionChallenge(c retrievalmarket.PossessionChallenge) error {
+	s.challenge = c
+	return nil
+}
+
+func (s *testPossessionStream) ReadPossessionProof() (retrievalmarket.PossessionProof, error) {
+	return s.proofs[0], nil
+}
+
+func (s *testPossessionStream) WritePossessionProof(proof retrievalmarket.PossessionProof) error {
+	s.proofs = append(s.proofs, proof)
+	return nil
+}
+
+func (s *testPossessionStream) RemotePeer() peer.ID {
+	return s.p
+}
+
+func (s *testPossessionStream) Close() error {
+	return nil
+}
+
+func TestHandlePossessionStream(t *testing.T) {
+	ctx := context.Background()
+	testData := tut.NewTestIPLDTree()
+	payloadCID := testData.RootNodeLnk.(cidlink.Link).Cid
+	var carData bytes.Buffer
+	require.NoError(t, testData.DumpToCar(&carData))
+	locations, err := piecestore.IndexCAR(bytes.NewReader(carData.Bytes()))
+	require.NoError(t, err)
+
+	pieceCID := tut.GenerateCids(1)[0]
+	length := padreader.PaddedSize(uint64(carData.Len())).Padded()
+	piece := piecestore.PieceInfo{
+		PieceCID: pieceCID,
+		Deals: []piecestore.DealInfo{
+			{SectorID: 1, Offset: 0, Length: length},
+		},
+	}
+	newPieceStore := func() *tut.TestPieceStore {
+		pieceStore := tut.NewTestPieceStore()
+		for c, location := range locations {
+			pieceStore.StubCID(c, piecestore.CIDInfo{
+				CID:                 c,
+				PieceBlockLocations: []piecestore.PieceBlockLocation{{BlockLocation: location, PieceCID: pieceCID}},
+			})
+		}
+		pieceStore.StubPiece(pieceCID, piece)
+		return pieceStore
+	}
+	nonce := tut.RandomBytes(possession.NonceSize)
+	challenge := retrievalmarket.PossessionChallenge{
+		Query: retrievalmarket.Query{PayloadCID: payloadCID},
+		Nonce: nonce,
+		Paths: 3,
+	}
+
+	receive := func(t *testing.T, unsealed bool, pieceData []byte, pieceStore piecestore.PieceStore, opts ...retrievalimpl.RetrievalProviderOption) retrievalmarket.PossessionProof {
+		node := &estimatingProviderNode{
+			TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+			estimate:                  retrievalmarket.UnsealCostEstimate{Unsealed: unsealed},
+		}
+		node.StubUnseal(1, 0, length.Unpadded(), pieceData)
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
+		p, err := retrievalimpl.NewProvider(address.TestAddress2, node, net, pieceStore, multiStore, tut.NewTestDataTransfer(), ds, opts...)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+
+		stream := &testPossessionStream{p: peer.ID("somepeer"), challenge: challenge}
+		net.ReceivePossessionStream(stream)
+		require.Len(t, stream.proofs, 1)
+		return stream.proofs[0]
+	}
+
+	t.Run("proves possession of the blocks on the paths", func(t *testing.T) {
+		proof := receive(t, true, carData.Bytes(), newPieceStore(), retrievalimpl.AnswerPossessionChallenges(4))
+		require.Equal(t, retrievalmarket.PossessionProofOk, proof.Status, proof.Message)
+		require.NoError(t, possession.Verify(ctx, payloadCID, nonce, challenge.Paths, proof.Data))
+	})
+
+	t.Run("declines unless configured to answer", func(t *testing.T) {
+		proof := receive(t, true, carData.Bytes(), newPieceStore())
+		require.Equal(t, retrievalmarket.PossessionProofDeclined, proof.Status)
+		require.Empty(t, proof.Data)
+	})
+
+	t.Run("declines challenges of too many paths", func(t *testing.T) {
+		proof := receive(t, true, carData.Bytes(), newPieceStore(), retrievalimpl.AnswerPossessionChallenges(2))
+		require.Equal(t, retrievalmarket.PossessionProofDeclined, proof.Status)
+	})
+
+	t.Run("declines payloads with no unsealed copy", func(t *testing.T) {
+		proof := receive(t, false, carData.Bytes(), newPieceStore(), retrievalimpl.AnswerPossessionChallenges(4))
+		require.Equal(t, retrievalmarket.PossessionProofDeclined, proof.Status)
+		require.Empty(t, proof.Data)
+	})
+
+	t.Run("reports payloads it does not have", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectMissingCID(payloadCID)
+		proof := receive(t, true, carData.Bytes(), pieceStore, retrievalimpl.AnswerPossessionChallenges(4))
+		require.Equal(t, retrievalmarket.PossessionProofNotFound, proof.Status)
+	})
+
+	t.Run("fails when the piece no longer holds the blocks", func(t *testing.T) {
+		proof := receive(t, true, make([]byte, carData.Len()), newPieceStore(), retrievalimpl.AnswerPossessionChallenges(4))
+		require.Equal(t, retrievalmarket.PossessionProofError, proof.Status)
+		require.Empty(t, proof.Data)
+	})
+}
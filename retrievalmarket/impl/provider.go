@@ -3,11 +3,16 @@ package retrievalimpl
 import (
 	"context"
 	"errors"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -15,17 +20,29 @@ import (
 	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
 	versionedfsm "github.com/filecoin-project/go-ds-versioning/pkg/fsm"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-statemachine/fsm"
 
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/askstore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/dealretention"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/dtutils"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/paymentdefaults"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/providerstates"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/queryadmission"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/requestvalidation"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/transferscheduler"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/unsealbatch"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
 	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
+	"github.com/filecoin-project/go-fil-markets/shared/stagedpieces"
 )
 
 // RetrievalProviderOption is a function that configures a retrieval provider
@@ -43,14 +60,72 @@ type Provider struct {
 	requestValidator     *requestvalidation.ProviderRequestValidator
 	revalidator          *requestvalidation.ProviderRevalidator
 	minerAddress         address.Address
-	pieceStore           piecestore.PieceStore
 	readySub             *pubsub.PubSub
-	subscribers          *pubsub.PubSub
+	subscribers          *eventbus.Bus
+	eventQueueSize       int
+	eventOverflowPolicy  eventbus.OverflowPolicy
+	handlerPool          *handlerpool.Pool
+	ds                   datastore.Batching
+	logDeals             func(datastore.Batching) datastore.Batching
+	dealsDs              datastore.Batching
 	stateMachines        fsm.Group
 	migrateStateMachines func(context.Context) error
-	dealDecider          DealDecider
+	migrationBackup      datastore.Batching
 	askStore             retrievalmarket.AskStore
+	miners               []*servedMiner
 	disableNewDeals      bool
+	configSub            *pubsub.PubSub
+
+	// configLk guards the tunables that can be changed with ApplyConfig
+	configLk       sync.RWMutex
+	dealDecider    DealDecider
+	queryAdmission *queryadmission.Controller
+
+	maintenance shared.Maintenance
+	// epochs caches the chain epoch maintenance is checked at
+	epochs *shared.EpochCache
+
+	allowDeferredPayments bool
+	maxUnpaidBytes        uint64
+	paymentDisabledPeers  func(client peer.ID) bool
+
+	validationPlugins *requestvalidation.PluginChain
+
+	remotePieceFetcher retrievalmarket.RemotePieceFetcher
+	stagedPieces       *stagedpieces.Registry
+	pieceAccess        func(client peer.ID, pieceCID cid.Cid) bool
+	pieceStreams       chan struct{}
+	inlineMaxSize      uint64
+	possessionMaxPaths uint64
+
+	stateTimes         *shared.StateTimes
+	expectedDwellTimes map[retrievalmarket.DealStatus]time.Duration
+
+	statsDs datastore.Batching
+	stats   *dealstats.Recorder
+
+	paymentDefaultsDs    datastore.Batching
+	paymentDefaults      *paymentdefaults.Tracker
+	paymentDefaultPolicy retrievalmarket.PaymentDefaultPolicy
+
+	dealRetention  retrievalmarket.DealRetentionPolicy
+	dealArchive    datastore.Batching
+	dealEnds       *dealretention.Tracker
+	stopCompaction context.CancelFunc
+	compactionDone chan struct{}
+
+	unsealPricer retrievalmarket.UnsealPricer
+
+	transferScheduler *transferscheduler.Scheduler
+	unsealBatcher     *unsealbatch.Batcher
+
+	blockVerifier *blockVerifier
+
+	queryGatewayAddr string
+	queryGateway     *http.Server
+
+	// readOnly is set on providers opened with NewReadOnlyProvider
+	readOnly bool
 }
 
 type internalProviderEvent struct {
@@ -87,6 +162,115 @@ func DisableNewDeals() RetrievalProviderOption {
 	}
 }
 
+// QueryAdmission limits how many queries the provider answers at once, shedding
+// queries with a busy response once their priority class is over budget
+func QueryAdmission(controller *queryadmission.Controller) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.queryAdmission = controller
+	}
+}
+
+// MaintenanceWindows schedules spans of epochs during which a retrieval provider is in
+// maintenance. New deals are rejected during a window, but deals in progress carry on
+func MaintenanceWindows(windows ...shared.MaintenanceWindow) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.maintenance.Windows = windows
+	}
+}
+
+// EventQueue sets how many events are queued for each subscriber to the provider's
+// events, and what happens to a subscriber that falls so far behind that its queue
+// is full. Events are delivered to each subscriber on its own goroutine, so a slow
+// subscriber never holds up deals. By default queues are unbounded and no events are
+// dropped. It must be passed to NewProvider
+func EventQueue(size int, policy eventbus.OverflowPolicy) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.eventQueueSize = size
+		provider.eventOverflowPolicy = policy
+	}
+}
+
+// HandlerPool limits the provider to running at most workers deal state handlers at
+// once, or any number if workers is zero. Handlers are started in the order they
+// arrive, so that a slow deal cannot hold up the others. HandlerStats reports how
+// many handlers are running and waiting, and how many have run for longer than
+// slowAfter
+func HandlerPool(workers int, slowAfter time.Duration) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.handlerPool.Configure(workers, slowAfter)
+	}
+}
+
+// AllowDeferredPayments lets clients whose payment channel is short of funds pay
+// part of an interval and defer the rest, up to one interval's worth, to their next
+// payment. The transfer carries on instead of pausing until the rest is paid
+func AllowDeferredPayments() RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.allowDeferredPayments = true
+	}
+}
+
+// BatchPayments lets clients pay for several payment intervals with one voucher, as
+// set by PaymentBatch in their deal params, so that fast transfers pause for payment
+// less often. The provider sends at most maxUnpaidBytes of data that has not been
+// paid for, or one interval's worth if that is more
+func BatchPayments(maxUnpaidBytes uint64) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.maxUnpaidBytes = maxUnpaidBytes
+	}
+}
+
+// AllowPaymentDisabled lets the peers trusted returns true for make deals with
+// PaymentDisabled set in their params, for private clusters and CDNs where clients
+// are authenticated by other means. These deals are served for free: their params
+// are not checked against the ask, and the provider never asks for payment or
+// touches the chain for them. Deals with payment disabled from other peers are
+// rejected
+func AllowPaymentDisabled(trusted func(client peer.ID) bool) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.paymentDisabledPeers = trusted
+	}
+}
+
+// ValidationPlugin adds a custom validation step to the checks the provider runs on
+// the deal proposals and payments it receives, such as checking an authorization
+// token. Plugins run in the order they are added, before the provider's own checks,
+// and the first to return an error rejects the proposal or payment.
+// ValidationPluginStats reports how often each plugin ran and rejected a voucher
+func ValidationPlugin(name string, plugin requestvalidation.Plugin) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.validationPlugins.Register(name, plugin)
+	}
+}
+
+// TransferSlots limits how many deals the provider unseals and sends data for at once,
+// or lets any number run if slots is zero. Deals beyond the limit wait in the
+// DealStatusTransferQueued state, and deals that pay for priority are given slots
+// ahead of the rest. TransferStats reports how many deals hold and wait for slots
+func TransferSlots(slots int) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.startQueuedTransfers(provider.transferScheduler.SetSlots(slots))
+	}
+}
+
+// RemotePieceFetcherOpt sets the fetcher used to read pieces from their remote copy,
+// when a piece has a remote location and cannot be unsealed
+func RemotePieceFetcherOpt(fetcher retrievalmarket.RemotePieceFetcher) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.remotePieceFetcher = fetcher
+	}
+}
+
+// StagedPieces makes the provider serve pieces from the CAR files a storage provider
+// staged for them while they are still in its filestore, rather than unsealing them.
+// The storage provider must be given the same registry, so that it does not delete a
+// staged file while a retrieval is reading it
+func StagedPieces(registry *stagedpieces.Registry) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.stagedPieces = registry
+	}
+}
+
 // NewProvider returns a new retrieval Provider
 func NewProvider(minerAddress address.Address,
 	node retrievalmarket.RetrievalProviderNode,
@@ -97,16 +281,29 @@ func NewProvider(minerAddress address.Address,
 	ds datastore.Batching,
 	opts ...RetrievalProviderOption,
 ) (retrievalmarket.RetrievalProvider, error) {
-
 	p := &Provider{
 		multiStore:   multiStore,
 		dataTransfer: dataTransfer,
 		node:         node,
 		network:      network,
 		minerAddress: minerAddress,
-		pieceStore:   pieceStore,
-		subscribers:  pubsub.New(providerDispatcher),
 		readySub:     pubsub.New(shared.ReadyDispatcher),
+		handlerPool:  handlerpool.New(0, 0),
+		configSub:    pubsub.New(configDispatcher),
+		ds:           ds,
+		stateTimes:   shared.NewStateTimes(),
+
+		transferScheduler:  transferscheduler.New(0),
+		unsealBatcher:      unsealbatch.New(0, 0),
+		validationPlugins:  requestvalidation.NewPluginChain(),
+		expectedDwellTimes: make(map[retrievalmarket.DealStatus]time.Duration, len(DefaultExpectedDwellTimes)),
+	}
+	p.epochs = shared.NewEpochCache(func(ctx context.Context) (abi.ChainEpoch, error) {
+		_, epoch, err := p.node.GetChainHead(ctx)
+		return epoch, err
+	}, shared.DefaultEpochCacheTTL)
+	for state, dwell := range DefaultExpectedDwellTimes {
+		p.expectedDwellTimes[state] = dwell
 	}
 
 	err := shared.MoveKey(ds, "retrieval-ask", "retrieval-ask/latest")
@@ -118,24 +315,53 @@ func NewProvider(minerAddress address.Address,
 		return nil, err
 	}
 	p.askStore = askStore
+	p.miners = []*servedMiner{{
+		address:    minerAddress,
+		pieceStore: pieceStore,
+		node:       node,
+		askStore:   askStore,
+	}}
+
+	p.Configure(opts...)
+	err = p.openMinerAskStores()
+	if err != nil {
+		return nil, err
+	}
 
-	retrievalMigrations, err := migrations.ProviderMigrations.Build()
+	var minerAskKeys []string
+	for _, miner := range p.miners[1:] {
+		minerAskKeys = append(minerAskKeys, migrations.MinerAskFilterKeys(miner.address)...)
+	}
+	retrievalMigrations, err := migrations.ProviderMigrationsFiltering(minerAskKeys).Build()
 	if err != nil {
 		return nil, err
 	}
-	p.stateMachines, p.migrateStateMachines, err = versionedfsm.NewVersionedFSM(ds, fsm.Parameters{
+	p.dealsDs = dealsDatastore(ds, p.logDeals)
+	p.stateMachines, p.migrateStateMachines, err = versionedfsm.NewVersionedFSM(p.dealsDs, fsm.Parameters{
 		Environment:     &providerDealEnvironment{p},
 		StateType:       retrievalmarket.ProviderDealState{},
 		StateKeyField:   "Status",
 		Events:          providerstates.ProviderEvents,
-		StateEntryFuncs: providerstates.ProviderStateEntryFuncs,
+		StateEntryFuncs: p.handlerPool.Wrap(providerstates.ProviderStateEntryFuncs),
 		FinalityStates:  providerstates.ProviderFinalityStates,
 		Notifier:        p.notifySubscribers,
 	}, retrievalMigrations, versioning.VersionKey("1"))
 	if err != nil {
 		return nil, err
 	}
-	p.Configure(opts...)
+	p.subscribers = eventbus.New(providerDispatcher, p.eventQueueSize, p.eventOverflowPolicy)
+	if p.statsDs == nil {
+		p.statsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	p.stats, err = dealstats.New(p.statsDs)
+	if err != nil {
+		return nil, err
+	}
+	if p.paymentDefaultsDs == nil {
+		p.paymentDefaultsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	p.paymentDefaults = paymentdefaults.New(p.paymentDefaultsDs)
+	p.dealEnds = dealretention.New(namespace.Wrap(ds, datastore.NewKey("deal-retention")))
 	p.requestValidator = requestvalidation.NewProviderRequestValidator(&providerValidationEnvironment{p})
 	transportConfigurer := dtutils.TransportConfigurer(network.ID(), &providerStoreGetter{p})
 	p.revalidator = requestvalidation.NewProviderRevalidator(&providerRevalidatorEnvironment{p})
@@ -158,6 +384,10 @@ func NewProvider(minerAddress address.Address,
 		if err != nil {
 			return nil, err
 		}
+		err = p.dataTransfer.RegisterVoucherType(&migrations.DealProposal1{}, p.requestValidator)
+		if err != nil {
+			return nil, err
+		}
 
 		err = p.dataTransfer.RegisterRevalidator(&retrievalmarket.DealPayment{}, p.revalidator)
 		if err != nil {
@@ -177,6 +407,10 @@ func NewProvider(minerAddress address.Address,
 		if err != nil {
 			return nil, err
 		}
+		err = p.dataTransfer.RegisterTransportConfigurer(&migrations.DealProposal1{}, transportConfigurer)
+		if err != nil {
+			return nil, err
+		}
 	}
 	err = p.dataTransfer.RegisterVoucherResultType(&migrations.DealResponse0{})
 	if err != nil {
@@ -192,14 +426,29 @@ func NewProvider(minerAddress address.Address,
 
 // Stop stops handling incoming requests.
 func (p *Provider) Stop() error {
+	if p.readOnly {
+		return p.stateMachines.Stop(context.TODO())
+	}
+	p.stopBlockVerification()
+	p.stopDealCompaction()
+	if err := p.stopQueryGateway(); err != nil {
+		log.Warnf("stopping HTTP query gateway: %s", err)
+	}
 	return p.network.StopHandlingRequests()
 }
 
 // Start begins listening for deals on the given host.
 // Start must be called in order to accept incoming deals.
 func (p *Provider) Start(ctx context.Context) error {
+	if p.readOnly {
+		// there is nothing to start, but listeners still expect to hear the provider is ready
+		return p.readySub.Publish(nil)
+	}
 	go func() {
-		err := p.migrateStateMachines(ctx)
+		err := p.backupBeforeMigrating(versioning.VersionKey("1"))
+		if err == nil {
+			err = p.migrateStateMachines(ctx)
+		}
 		if err != nil {
 			log.Errorf("Migrating retrieval provider state machines: %s", err.Error())
 		}
@@ -208,6 +457,20 @@ func (p *Provider) Start(ctx context.Context) error {
 			log.Warnf("Publish retrieval provider ready event: %s", err.Error())
 		}
 	}()
+	p.startBlockVerification()
+	p.startDealCompaction()
+	if err := p.startQueryGateway(); err != nil {
+		return xerrors.Errorf("starting HTTP query gateway: %w", err)
+	}
+	if err := p.network.SetPieceDelegate(p); err != nil {
+		return err
+	}
+	if err := p.network.SetInlineQueryDelegate(p); err != nil {
+		return err
+	}
+	if err := p.network.SetPossessionDelegate(p); err != nil {
+		return err
+	}
 	return p.network.SetDelegate(p)
 }
 
@@ -219,7 +482,71 @@ func (p *Provider) OnReady(ready shared.ReadyFunc) {
 func (p *Provider) notifySubscribers(eventName fsm.EventName, state fsm.StateType) {
 	evt := eventName.(retrievalmarket.ProviderEvent)
 	ds := state.(retrievalmarket.ProviderDealState)
-	_ = p.subscribers.Publish(internalProviderEvent{evt, ds})
+	if isProviderFinalityState(ds.Status) {
+		p.stateTimes.Forget(ds.Identifier())
+	} else {
+		p.stateTimes.Record(ds.Identifier(), ds.Status)
+	}
+	p.recordStats(evt, ds)
+	p.recordPaymentDefaults(evt, ds)
+	p.recordDealEnded(ds)
+	if evt == retrievalmarket.ProviderEventPaymentReceived {
+		if admission := p.admission(); admission != nil {
+			admission.RecordPayment(ds.Receiver)
+		}
+	}
+	if !holdsTransferSlot(ds.Status) {
+		p.startQueuedTransfers(p.transferScheduler.Release(ds.Identifier()))
+	}
+	p.subscribers.Publish(internalProviderEvent{evt, ds})
+}
+
+// holdsTransferSlot returns true for the states in which a deal holds, or is queued
+// for, one of the provider's transfer slots
+func holdsTransferSlot(status retrievalmarket.DealStatus) bool {
+	switch status {
+	case retrievalmarket.DealStatusTransferQueued,
+		retrievalmarket.DealStatusUnsealing,
+		retrievalmarket.DealStatusUnsealed,
+		retrievalmarket.DealStatusOngoing,
+		retrievalmarket.DealStatusFundsNeeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// startQueuedTransfers tells queued deals that they have been given a transfer slot
+func (p *Provider) startQueuedTransfers(deals []retrievalmarket.ProviderDealIdentifier) {
+	for _, deal := range deals {
+		if err := p.stateMachines.Send(deal, retrievalmarket.ProviderEventTransferSlotOpened); err != nil {
+			log.Errorf("starting queued transfer for deal %s: %s", deal, err)
+		}
+	}
+}
+
+// TransferStats returns how many deals hold one of the provider's transfer slots, and
+// how many are waiting for one
+func (p *Provider) TransferStats() transferscheduler.Stats {
+	return p.transferScheduler.Stats()
+}
+
+// EventStats returns the number of events published to subscribers, and the number
+// dropped because a subscriber fell behind
+func (p *Provider) EventStats() eventbus.Stats {
+	return p.subscribers.Stats()
+}
+
+// HandlerStats returns how many deal state handlers are running, and how many are
+// waiting for a worker
+func (p *Provider) HandlerStats() handlerpool.Stats {
+	return p.handlerPool.Stats()
+}
+
+// ValidationPluginStats returns how many vouchers each validation plugin checked and
+// rejected, and the time it spent checking them
+func (p *Provider) ValidationPluginStats() []requestvalidation.PluginStats {
+	return p.validationPlugins.Stats()
 }
 
 // SubscribeToEvents listens for events that happen related to client retrievals
@@ -234,6 +561,10 @@ func (p *Provider) GetAsk() *retrievalmarket.Ask {
 
 // SetAsk sets the deal parameters this provider accepts
 func (p *Provider) SetAsk(ask *retrievalmarket.Ask) {
+	if p.readOnly {
+		log.Warnf("Error setting retrieval ask: %s", ErrReadOnly)
+		return
+	}
 	err := p.askStore.SetAsk(ask)
 
 	if err != nil {
@@ -252,19 +583,41 @@ func (p *Provider) ListDeals() map[retrievalmarket.ProviderDealIdentifier]retrie
 	return dealMap
 }
 
+// DealStateSnapshot returns the provider deal state machine definition along
+// with the number of deals currently in each state
+func (p *Provider) DealStateSnapshot() (fsmexport.Snapshot, error) {
+	def, err := fsmexport.NewDefinition(ProviderFSMParameterSpec, retrievalmarket.DealStatuses, retrievalmarket.ProviderEvents, []fsm.StateKey{retrievalmarket.DealStatusNew})
+	if err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	var deals []retrievalmarket.ProviderDealState
+	if err := p.stateMachines.List(&deals); err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	current := make([]fsm.StateKey, 0, len(deals))
+	for _, deal := range deals {
+		current = append(current, deal.Status)
+	}
+	return fsmexport.NewSnapshot(def, current), nil
+}
+
 /*
 HandleQueryStream is called by the network implementation whenever a new message is received on the query protocol
 
 A Provider handling a retrieval `Query` does the following:
 
-1. Get the node's chain head in order to get its miner worker address.
+1. Look in the piece store of each miner it serves to determine if it can serve the given payload CID,
+and which miner's sectors hold it.
 
-2. Look in its piece store to determine if it can serve the given payload CID.
+2. Get the node's chain head in order to get that miner's worker address.
 
-3. Combine these results with its existing parameters for retrieval deals to construct a `retrievalmarket.QueryResponse` struct.
+3. Combine these results with that miner's parameters for retrieval deals to construct a `retrievalmarket.QueryResponse` struct.
 
 4. Writes this response to the `Query` stream.
 
+If the provider was configured with a query admission controller, a query whose priority
+class is over its concurrency budget skips these steps and gets a `QueryResponseBusy` response.
+
 The connection is kept open only as long as the query-response exchange.
 */
 func (p *Provider) HandleQueryStream(stream rmnet.RetrievalQueryStream) {
@@ -274,7 +627,65 @@ func (p *Provider) HandleQueryStream(stream rmnet.RetrievalQueryStream) {
 		return
 	}
 
-	ask := p.GetAsk()
+	release, admitted := p.admitQuery(stream.RemotePeer())
+	if !admitted {
+		if err := stream.WriteQueryResponse(p.queryBusy()); err != nil {
+			log.Errorf("Retrieval query: WriteCborRPC: %s", err)
+		}
+		return
+	}
+	defer release()
+
+	answer, _, _, ok := p.answerQuery(context.TODO(), query)
+	if !ok {
+		return
+	}
+	if err := stream.WriteQueryResponse(answer); err != nil {
+		log.Errorf("Retrieval query: WriteCborRPC: %s", err)
+		return
+	}
+}
+
+// queryBusyMessage is the message of the response to a query the provider is too
+// busy to answer
+const queryBusyMessage = "provider is busy, try again later"
+
+// queryBusy is the response to a query the provider is too busy to answer. It gives
+// the provider's own address as the payment address rather than looking up its
+// worker, so that shedding a query never touches the chain
+func (p *Provider) queryBusy() retrievalmarket.QueryResponse {
+	return retrievalmarket.QueryResponse{
+		Status:               retrievalmarket.QueryResponseBusy,
+		PieceCIDFound:        retrievalmarket.QueryItemUnavailable,
+		PaymentAddress:       p.minerAddress,
+		MinPricePerByte:      big.Zero(),
+		UnsealPrice:          big.Zero(),
+		PriorityPricePerByte: big.Zero(),
+		Message:              queryBusyMessage,
+	}
+}
+
+// admitQuery checks the provider has room to answer a query from the given peer. The
+// returned function must be called once the query has been answered
+func (p *Provider) admitQuery(client peer.ID) (func(), bool) {
+	admission := p.admission()
+	if admission == nil {
+		return func() {}, true
+	}
+	return admission.Admit(client)
+}
+
+// answerQuery builds the response to a query, and returns it with the miner that
+// stores the payload and the payload's piece. It returns false if the provider
+// cannot respond at all
+func (p *Provider) answerQuery(ctx context.Context, query retrievalmarket.Query) (retrievalmarket.QueryResponse, *servedMiner, piecestore.PieceInfo, bool) {
+	pieceCID := cid.Undef
+	if query.PieceCID != nil {
+		pieceCID = *query.PieceCID
+	}
+	miner, pieceInfo, pieceErr := p.routePayload(query.PayloadCID, pieceCID)
+
+	ask := miner.askStore.GetAsk()
 
 	answer := retrievalmarket.QueryResponse{
 		Status:                     retrievalmarket.QueryResponseUnavailable,
@@ -283,17 +694,16 @@ func (p *Provider) HandleQueryStream(stream rmnet.RetrievalQueryStream) {
 		MaxPaymentInterval:         ask.PaymentInterval,
 		MaxPaymentIntervalIncrease: ask.PaymentIntervalIncrease,
 		UnsealPrice:                ask.UnsealPrice,
+		PriorityPricePerByte:       ask.PriorityPricePerByte(),
 	}
 
-	ctx := context.TODO()
-
-	tok, _, err := p.node.GetChainHead(ctx)
+	tok, _, err := miner.node.GetChainHead(ctx)
 	if err != nil {
 		log.Errorf("Retrieval query: GetChainHead: %s", err)
-		return
+		return answer, miner, pieceInfo, false
 	}
 
-	paymentAddress, err := p.node.GetMinerWorkerAddress(ctx, p.minerAddress, tok)
+	paymentAddress, err := miner.node.GetMinerWorkerAddress(ctx, miner.address, tok)
 	if err != nil {
 		log.Errorf("Retrieval query: Lookup Payment Address: %s", err)
 		answer.Status = retrievalmarket.QueryResponseError
@@ -301,34 +711,28 @@ func (p *Provider) HandleQueryStream(stream rmnet.RetrievalQueryStream) {
 	} else {
 		answer.PaymentAddress = paymentAddress
 
-		pieceCID := cid.Undef
-		if query.PieceCID != nil {
-			pieceCID = *query.PieceCID
-		}
-		pieceInfo, err := getPieceInfoFromCid(p.pieceStore, query.PayloadCID, pieceCID)
-
-		if err == nil && len(pieceInfo.Deals) > 0 {
+		if pieceErr == nil && len(pieceInfo.Deals) > 0 {
 			answer.Status = retrievalmarket.QueryResponseAvailable
 			// TODO: get price, look for already unsealed ref to reduce work
 			answer.Size = uint64(pieceInfo.Deals[0].Length) // TODO: verify on intermediate
 			answer.PieceCIDFound = retrievalmarket.QueryItemAvailable
+			answer.UnsealPrice = p.unsealPrice(ctx, miner, *ask, pieceInfo)
 		}
 
-		if err != nil && !xerrors.Is(err, retrievalmarket.ErrNotFound) {
-			log.Errorf("Retrieval query: GetRefs: %s", err)
+		if pieceErr != nil && !xerrors.Is(pieceErr, retrievalmarket.ErrNotFound) {
+			log.Errorf("Retrieval query: GetRefs: %s", pieceErr)
 			answer.Status = retrievalmarket.QueryResponseError
-			answer.Message = err.Error()
+			answer.Message = pieceErr.Error()
 		}
 
 	}
-	if err := stream.WriteQueryResponse(answer); err != nil {
-		log.Errorf("Retrieval query: WriteCborRPC: %s", err)
-		return
-	}
+	return answer, miner, pieceInfo, true
 }
 
 // Configure reconfigures a provider after initialization
 func (p *Provider) Configure(opts ...RetrievalProviderOption) {
+	p.configLk.Lock()
+	defer p.configLk.Unlock()
 	for _, opt := range opts {
 		opt(p)
 	}
@@ -342,3 +746,12 @@ var ProviderFSMParameterSpec = fsm.Parameters{
 	Events:          providerstates.ProviderEvents,
 	StateEntryFuncs: providerstates.ProviderStateEntryFuncs,
 }
+
+func isProviderFinalityState(status retrievalmarket.DealStatus) bool {
+	for _, finalityState := range providerstates.ProviderFinalityStates {
+		if status == finalityState {
+			return true
+		}
+	}
+	return false
+}
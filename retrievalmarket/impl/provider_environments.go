@@ -10,6 +10,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-commp-utils/pieceio/cario"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
@@ -29,18 +30,33 @@ type providerValidationEnvironment struct {
 	p *Provider
 }
 
-func (pve *providerValidationEnvironment) GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, error) {
+// GetPiece finds the piece holding the given payload, and the miner whose sectors hold it
+func (pve *providerValidationEnvironment) GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, address.Address, error) {
 	inPieceCid := cid.Undef
 	if pieceCID != nil {
 		inPieceCid = *pieceCID
 	}
-	return getPieceInfoFromCid(pve.p.pieceStore, c, inPieceCid)
+	miner, pieceInfo, err := pve.p.routePayload(c, inPieceCid)
+	return pieceInfo, miner.address, err
 }
 
-// CheckDealParams verifies the given deal params are acceptable
-func (pve *providerValidationEnvironment) CheckDealParams(pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount) error {
-	ask := pve.p.GetAsk()
-	if pricePerByte.LessThan(ask.PricePerByte) {
+// CheckDealParams verifies the given deal params are acceptable to the given miner
+// for retrieving from the given piece. A priority deal must pay the ask's priority
+// price per byte
+func (pve *providerValidationEnvironment) CheckDealParams(miner address.Address, pieceInfo piecestore.PieceInfo, pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount, priority bool) error {
+	served, err := pve.p.servedMiner(miner)
+	if err != nil {
+		return err
+	}
+	ask := served.askStore.GetAsk()
+	minPricePerByte := ask.PricePerByte
+	if priority {
+		if ask.PriorityMultiplier == 0 {
+			return errors.New("Priority retrieval not offered")
+		}
+		minPricePerByte = ask.PriorityPricePerByte()
+	}
+	if pricePerByte.LessThan(minPricePerByte) {
 		return errors.New("Price per byte too low")
 	}
 	if paymentInterval > ask.PaymentInterval {
@@ -49,18 +65,52 @@ func (pve *providerValidationEnvironment) CheckDealParams(pricePerByte abi.Token
 	if paymentIntervalIncrease > ask.PaymentIntervalIncrease {
 		return errors.New("Payment interval increase too large")
 	}
-	if !ask.UnsealPrice.Nil() && unsealPrice.LessThan(ask.UnsealPrice) {
+	if askUnsealPrice := pve.p.unsealPrice(context.TODO(), served, *ask, pieceInfo); !askUnsealPrice.Nil() && unsealPrice.LessThan(askUnsealPrice) {
 		return errors.New("Unseal price too small")
 	}
 	return nil
 }
 
+// CheckPaymentDisabled verifies the provider serves deals with payment disabled to
+// the given peer, and that the params ask for nothing to be paid
+func (pve *providerValidationEnvironment) CheckPaymentDisabled(receiver peer.ID, params retrievalmarket.Params) error {
+	trusted := pve.p.paymentDisabledPeers
+	if trusted == nil || !trusted(receiver) {
+		return errors.New("Deals with payment disabled not accepted")
+	}
+	return params.CheckPaymentDisabled()
+}
+
+// CheckPaymentDefaults verifies a client that stopped paying for earlier deals may
+// make a deal with the given unseal price
+func (pve *providerValidationEnvironment) CheckPaymentDefaults(receiver peer.ID, unsealPrice abi.TokenAmount) error {
+	return pve.p.checkPaymentDefaults(receiver, unsealPrice)
+}
+
 // RunDealDecisioningLogic runs custom deal decision logic to decide if a deal is accepted, if present
 func (pve *providerValidationEnvironment) RunDealDecisioningLogic(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error) {
-	if pve.p.dealDecider == nil {
+	pve.p.configLk.RLock()
+	decider := pve.p.dealDecider
+	pve.p.configLk.RUnlock()
+	if decider == nil {
 		return true, "", nil
 	}
-	return pve.p.dealDecider(ctx, state)
+	return decider(ctx, state)
+}
+
+// Maintenance returns true if the provider is in maintenance at the current epoch. The
+// chain head is only looked up if maintenance is scheduled, and is cached for a while
+func (pve *providerValidationEnvironment) Maintenance(ctx context.Context) (bool, abi.ChainEpoch, error) {
+	maintenance := pve.p.currentMaintenance()
+	if !maintenance.Scheduled() {
+		return false, 0, nil
+	}
+	curEpoch, err := pve.p.epochs.Epoch(ctx)
+	if err != nil {
+		return false, 0, xerrors.Errorf("getting chain head: %w", err)
+	}
+	inMaintenance, until := maintenance.At(curEpoch)
+	return inMaintenance, until, nil
 }
 
 // StateMachines returns the FSM Group to begin tracking with
@@ -84,6 +134,11 @@ func (pve *providerValidationEnvironment) NextStoreID() (multistore.StoreID, err
 	return storeID, err
 }
 
+// Plugins returns the provider's validation plugins
+func (pve *providerValidationEnvironment) Plugins() *requestvalidation.PluginChain {
+	return pve.p.validationPlugins
+}
+
 type providerRevalidatorEnvironment struct {
 	p *Provider
 }
@@ -102,6 +157,22 @@ func (pre *providerRevalidatorEnvironment) Get(dealID retrievalmarket.ProviderDe
 	return deal, err
 }
 
+func (pre *providerRevalidatorEnvironment) AllowDeferredPayments() bool {
+	pre.p.configLk.RLock()
+	defer pre.p.configLk.RUnlock()
+	return pre.p.allowDeferredPayments
+}
+
+func (pre *providerRevalidatorEnvironment) MaxUnpaidBytes() uint64 {
+	pre.p.configLk.RLock()
+	defer pre.p.configLk.RUnlock()
+	return pre.p.maxUnpaidBytes
+}
+
+func (pre *providerRevalidatorEnvironment) Plugins() *requestvalidation.PluginChain {
+	return pre.p.validationPlugins
+}
+
 var _ providerstates.ProviderDealEnvironment = new(providerDealEnvironment)
 
 type providerDealEnvironment struct {
@@ -113,6 +184,14 @@ func (pde *providerDealEnvironment) Node() retrievalmarket.RetrievalProviderNode
 	return pde.p.node
 }
 
+// UnsealSector unseals part of a sector with the node of the given miner, or of the
+// miner the provider was created with if miner is nil, batched with the other deals
+// reading from the same sector
+func (pde *providerDealEnvironment) UnsealSector(ctx context.Context, miner *address.Address, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	served := pde.p.minerOrDefault(miner)
+	return pde.p.unsealBatcher.Unseal(ctx, served.address, served.node, sectorID, offset, length)
+}
+
 func (pde *providerDealEnvironment) ReadIntoBlockstore(storeID multistore.StoreID, pieceData io.Reader) error {
 	store, err := pde.p.multiStore.Get(storeID)
 	if err != nil {
@@ -134,6 +213,23 @@ func (pde *providerDealEnvironment) ReadIntoBlockstore(storeID multistore.StoreI
 	return err
 }
 
+// FetchRemotePiece reads the piece from the remote copy recorded in its PieceInfo
+func (pde *providerDealEnvironment) FetchRemotePiece(ctx context.Context, pieceInfo piecestore.PieceInfo) (io.ReadCloser, error) {
+	if pde.p.remotePieceFetcher == nil {
+		return nil, xerrors.Errorf("piece %s has a remote copy at %s, but no remote piece fetcher is configured", pieceInfo.PieceCID, pieceInfo.RemoteLocation)
+	}
+	return pde.p.remotePieceFetcher.FetchPiece(ctx, pieceInfo.PieceCID, pieceInfo.RemoteLocation)
+}
+
+// OpenStagedPiece returns a reader for the CAR a storage provider staged for the piece,
+// if it is still in the filestore
+func (pde *providerDealEnvironment) OpenStagedPiece(pieceCID cid.Cid) (io.ReadCloser, bool) {
+	if pde.p.stagedPieces == nil {
+		return nil, false
+	}
+	return pde.p.stagedPieces.Open(pieceCID)
+}
+
 func (pde *providerDealEnvironment) TrackTransfer(deal retrievalmarket.ProviderDealState) error {
 	pde.p.revalidator.TrackChannel(deal)
 	return nil
@@ -152,6 +248,10 @@ func (pde *providerDealEnvironment) CloseDataTransfer(ctx context.Context, chid
 	return pde.p.dataTransfer.CloseDataTransferChannel(ctx, chid)
 }
 
+func (pde *providerDealEnvironment) TransferSlot(deal retrievalmarket.ProviderDealState) bool {
+	return pde.p.transferScheduler.Acquire(deal.Identifier(), deal.Priority)
+}
+
 func (pde *providerDealEnvironment) DeleteStore(storeID multistore.StoreID) error {
 	return pde.p.multiStore.Delete(storeID)
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
 	dss "github.com/ipfs/go-datastore/sync"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -29,11 +30,14 @@ import (
 	piecemigrations "github.com/filecoin-project/go-fil-markets/piecestore/migrations"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/queryadmission"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/requestvalidation"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/eventstore"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
 	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
 )
 
@@ -77,14 +81,14 @@ func TestHandleQueryStream(t *testing.T) {
 		return qs
 	}
 
-	receiveStreamOnProvider := func(t *testing.T, qs network.RetrievalQueryStream, pieceStore piecestore.PieceStore) {
+	receiveStreamOnProvider := func(t *testing.T, qs network.RetrievalQueryStream, pieceStore piecestore.PieceStore, opts ...retrievalimpl.RetrievalProviderOption) {
 		node := testnodes.NewTestRetrievalProviderNode()
 		ds := dss.MutexWrap(datastore.NewMapDatastore())
 		multiStore, err := multistore.NewMultiDstore(ds)
 		require.NoError(t, err)
 		dt := tut.NewTestDataTransfer()
 		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
-		c, err := retrievalimpl.NewProvider(expectedAddress, node, net, pieceStore, multiStore, dt, ds)
+		c, err := retrievalimpl.NewProvider(expectedAddress, node, net, pieceStore, multiStore, dt, ds, opts...)
 		require.NoError(t, err)
 		ask := c.GetAsk()
 
@@ -186,6 +190,7 @@ func TestHandleQueryStream(t *testing.T) {
 			tc.expResp.MaxPaymentInterval = expectedPaymentInterval
 			tc.expResp.MaxPaymentIntervalIncrease = expectedPaymentIntervalIncrease
 			tc.expResp.UnsealPrice = big.Zero()
+			tc.expResp.PriorityPricePerByte = big.Zero()
 			assert.Equal(t, tc.expResp, actualResp)
 		})
 	}
@@ -206,6 +211,73 @@ func TestHandleQueryStream(t *testing.T) {
 		require.NotEmpty(t, response.Message)
 	})
 
+	t.Run("query shed when class is over budget", func(t *testing.T) {
+		qs := readWriteQueryStream()
+		err := qs.WriteQuery(retrievalmarket.Query{
+			PayloadCID: payloadCID,
+		})
+		require.NoError(t, err)
+		pieceStore := tut.NewTestPieceStore()
+		controller := queryadmission.NewController(queryadmission.Budget(queryadmission.ClassAnonymous, 0))
+
+		receiveStreamOnProvider(t, qs, pieceStore, retrievalimpl.QueryAdmission(controller))
+
+		response, err := qs.ReadQueryResponse()
+		require.NoError(t, err)
+		require.Equal(t, retrievalmarket.QueryResponseBusy, response.Status)
+		require.Equal(t, retrievalmarket.QueryItemUnavailable, response.PieceCIDFound)
+		require.Equal(t, expectedAddress, response.PaymentAddress)
+		require.NoError(t, response.MarshalCBOR(new(bytes.Buffer)))
+		pieceStore.VerifyExpectations(t)
+	})
+
+	t.Run("known client admitted when anonymous queries are shed", func(t *testing.T) {
+		qs := readWriteQueryStream()
+		err := qs.WriteQuery(retrievalmarket.Query{
+			PayloadCID: payloadCID,
+		})
+		require.NoError(t, err)
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, expectedCIDInfo)
+		pieceStore.ExpectPiece(expectedPieceCID, expectedPiece)
+		controller := queryadmission.NewController(
+			queryadmission.Budget(queryadmission.ClassAnonymous, 0),
+			queryadmission.KnownClients(expectedPeer),
+		)
+
+		receiveStreamOnProvider(t, qs, pieceStore, retrievalimpl.QueryAdmission(controller))
+
+		response, err := qs.ReadQueryResponse()
+		require.NoError(t, err)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, response.Status)
+		pieceStore.VerifyExpectations(t)
+	})
+
+	t.Run("routes query to the miner holding the piece", func(t *testing.T) {
+		qs := readWriteQueryStream()
+		err := qs.WriteQuery(retrievalmarket.Query{
+			PayloadCID: payloadCID,
+		})
+		require.NoError(t, err)
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectMissingCID(payloadCID)
+		otherMiner := address.TestAddress
+		otherPieceStore := tut.NewTestPieceStore()
+		otherPieceStore.ExpectCID(payloadCID, expectedCIDInfo)
+		otherPieceStore.ExpectPiece(expectedPieceCID, expectedPiece)
+		otherNode := testnodes.NewTestRetrievalProviderNode()
+
+		receiveStreamOnProvider(t, qs, pieceStore, retrievalimpl.ServeMiner(otherMiner, otherPieceStore, otherNode))
+
+		response, err := qs.ReadQueryResponse()
+		require.NoError(t, err)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, response.Status)
+		require.Equal(t, otherMiner, response.PaymentAddress)
+		require.Equal(t, retrievalmarket.DefaultPricePerByte, response.MinPricePerByte)
+		pieceStore.VerifyExpectations(t)
+		otherPieceStore.VerifyExpectations(t)
+	})
+
 	t.Run("when ReadDealStatusRequest fails", func(t *testing.T) {
 		qs := readWriteQueryStream()
 		pieceStore := tut.NewTestPieceStore()
@@ -261,7 +333,7 @@ func TestProvider_Construct(t *testing.T) {
 	require.True(t, ok)
 	_, ok = dt.RegisteredVoucherResultTypes[1].(*migrations.DealResponse0)
 	require.True(t, ok)
-	require.Len(t, dt.RegisteredVoucherTypes, 2)
+	require.Len(t, dt.RegisteredVoucherTypes, 3)
 	_, ok = dt.RegisteredVoucherTypes[0].VoucherType.(*retrievalmarket.DealProposal)
 	require.True(t, ok)
 	_, ok = dt.RegisteredVoucherTypes[0].Validator.(*requestvalidation.ProviderRequestValidator)
@@ -270,6 +342,10 @@ func TestProvider_Construct(t *testing.T) {
 	require.True(t, ok)
 	_, ok = dt.RegisteredVoucherTypes[1].Validator.(*requestvalidation.ProviderRequestValidator)
 	require.True(t, ok)
+	_, ok = dt.RegisteredVoucherTypes[2].VoucherType.(*migrations.DealProposal1)
+	require.True(t, ok)
+	_, ok = dt.RegisteredVoucherTypes[2].Validator.(*requestvalidation.ProviderRequestValidator)
+	require.True(t, ok)
 	require.Len(t, dt.RegisteredRevalidators, 2)
 	_, ok = dt.RegisteredRevalidators[0].VoucherType.(*retrievalmarket.DealPayment)
 	require.True(t, ok)
@@ -277,9 +353,12 @@ func TestProvider_Construct(t *testing.T) {
 	require.True(t, ok)
 	_, ok = dt.RegisteredRevalidators[1].VoucherType.(*migrations.DealPayment0)
 	require.True(t, ok)
-	require.Len(t, dt.RegisteredTransportConfigurers, 2)
+	require.Len(t, dt.RegisteredTransportConfigurers, 3)
 	_, ok = dt.RegisteredTransportConfigurers[0].VoucherType.(*retrievalmarket.DealProposal)
-	_, ok = dt.RegisteredTransportConfigurers[1].VoucherType.(*migrations.DealProposal0)
+	require.True(t, ok)
+	_, ok = dt.RegisteredTransportConfigurers[1].VoucherType.(*migrations.DealProposal1)
+	require.True(t, ok)
+	_, ok = dt.RegisteredTransportConfigurers[2].VoucherType.(*migrations.DealProposal0)
 
 	require.True(t, ok)
 }
@@ -322,6 +401,446 @@ func TestProviderConfigOpts(t *testing.T) {
 	require.NotNil(t, p)
 }
 
+func TestProviderApplyConfig(t *testing.T) {
+	ds := datastore.NewMapDatastore()
+	multiStore, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	rp, err := retrievalimpl.NewProvider(
+		spect.NewIDAddr(t, 2344),
+		testnodes.NewTestRetrievalProviderNode(),
+		tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+		tut.NewTestPieceStore(),
+		multiStore,
+		tut.NewTestDataTransfer(),
+		ds,
+	)
+	require.NoError(t, err)
+	p := rp.(*retrievalimpl.Provider)
+
+	var changes []retrievalimpl.ConfigChange
+	p.SubscribeToConfigChanges(func(change retrievalimpl.ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	previous := p.Config()
+	require.Equal(t, retrievalmarket.DefaultPricePerByte, previous.Ask.PricePerByte)
+	require.Nil(t, previous.QueryAdmission)
+
+	cfg := previous
+	cfg.Ask.PricePerByte = abi.NewTokenAmount(7)
+	cfg.QueryAdmission = queryadmission.NewController()
+	require.NoError(t, p.ApplyConfig(cfg))
+	require.Equal(t, abi.NewTokenAmount(7), p.GetAsk().PricePerByte)
+	require.Equal(t, cfg.QueryAdmission, p.Config().QueryAdmission)
+	require.Len(t, changes, 1)
+	require.Equal(t, previous, changes[0].Previous)
+	require.Equal(t, abi.NewTokenAmount(7), changes[0].Current.Ask.PricePerByte)
+
+	// an invalid config changes nothing
+	invalid := p.Config()
+	invalid.Ask.PaymentInterval = 0
+	invalid.QueryAdmission = nil
+	require.Error(t, p.ApplyConfig(invalid))
+	require.NotNil(t, p.Config().QueryAdmission)
+	require.Equal(t, retrievalmarket.DefaultPaymentInterval, p.GetAsk().PaymentInterval)
+	require.Len(t, changes, 1)
+}
+
+func TestProviderMaintenance(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMapDatastore()
+	multiStore, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	rp, err := retrievalimpl.NewProvider(
+		spect.NewIDAddr(t, 2344),
+		testnodes.NewTestRetrievalProviderNode(),
+		tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+		tut.NewTestPieceStore(),
+		multiStore,
+		tut.NewTestDataTransfer(),
+		ds,
+		retrievalimpl.MaintenanceWindows(
+			shared.MaintenanceWindow{Start: -10, End: 10},
+			shared.MaintenanceWindow{Start: 100, End: 200},
+		),
+	)
+	require.NoError(t, err)
+	p := rp.(*retrievalimpl.Provider)
+
+	// exiting maintenance ends the window in progress, but keeps later windows
+	require.NoError(t, p.ExitMaintenance(ctx))
+	require.Equal(t, []shared.MaintenanceWindow{
+		{Start: -10, End: 0},
+		{Start: 100, End: 200},
+	}, p.Config().MaintenanceWindows)
+
+	require.NoError(t, p.EnterMaintenance(50))
+	cfg := p.Config()
+	require.True(t, cfg.Maintenance)
+	require.Equal(t, abi.ChainEpoch(50), cfg.MaintenanceUntil)
+
+	require.NoError(t, p.ExitMaintenance(ctx))
+	require.False(t, p.Config().Maintenance)
+
+	// windows must end after they start
+	cfg = p.Config()
+	cfg.MaintenanceWindows = []shared.MaintenanceWindow{{Start: 5, End: 5}}
+	require.Error(t, p.ApplyConfig(cfg))
+}
+
+func TestProviderDealSummary(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	multiStore, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	namespaced := tut.DatastoreAtVersion(t, ds, "1")
+
+	params, err := retrievalmarket.NewParamsV1(abi.NewTokenAmount(1), 1000, 100, shared.AllSelector(), nil, big.Zero())
+	require.NoError(t, err)
+	statuses := []retrievalmarket.DealStatus{
+		retrievalmarket.DealStatusCompleted,
+		retrievalmarket.DealStatusCompleted,
+		retrievalmarket.DealStatusErrored,
+	}
+	for i, status := range statuses {
+		deal := retrievalmarket.ProviderDealState{
+			DealProposal: retrievalmarket.DealProposal{
+				PayloadCID: tut.GenerateCids(1)[0],
+				ID:         retrievalmarket.DealID(i),
+				Params:     params,
+			},
+			Status:        status,
+			Receiver:      tut.GeneratePeers(1)[0],
+			FundsReceived: big.Zero(),
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.Identifier().String()), buf.Bytes()))
+	}
+
+	p, err := retrievalimpl.NewProvider(
+		spect.NewIDAddr(t, 2344),
+		testnodes.NewTestRetrievalProviderNode(),
+		tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+		tut.NewTestPieceStore(),
+		multiStore,
+		tut.NewTestDataTransfer(),
+		ds,
+		retrievalimpl.ExpectedDwellTimes(map[retrievalmarket.DealStatus]time.Duration{
+			retrievalmarket.DealStatusCompleted: time.Nanosecond,
+		}),
+	)
+	require.NoError(t, err)
+	tut.StartAndWaitForReady(ctx, t, p)
+	time.Sleep(time.Millisecond)
+
+	summary, err := p.DealSummary()
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.Total)
+	require.Equal(t, 2, summary.Stuck)
+	require.Len(t, summary.States, 2)
+
+	completed := summary.States[retrievalmarket.DealStatusCompleted]
+	require.Equal(t, 2, completed.Count)
+	require.Equal(t, 2, completed.Stuck)
+	require.False(t, completed.OldestSince.After(summary.Time))
+
+	errored := summary.States[retrievalmarket.DealStatusErrored]
+	require.Equal(t, 1, errored.Count)
+	require.Equal(t, 0, errored.Stuck)
+}
+
+func TestProviderDealRetention(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	testCases := map[string]struct {
+		dealsDs func(ds datastore.Batching) datastore.Batching
+		opts    []retrievalimpl.RetrievalProviderOption
+	}{
+		"overwritten deals": {
+			dealsDs: func(ds datastore.Batching) datastore.Batching { return ds },
+		},
+		"logged deals": {
+			dealsDs: func(ds datastore.Batching) datastore.Batching { return eventstore.New(ds, 2) },
+			opts:    []retrievalimpl.RetrievalProviderOption{retrievalimpl.LogProviderDeals(2)},
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			ds := dss.MutexWrap(datastore.NewMapDatastore())
+			multiStore, err := multistore.NewMultiDstore(ds)
+			require.NoError(t, err)
+			namespaced := tut.DatastoreAtVersion(t, data.dealsDs(ds), "1")
+
+			params, err := retrievalmarket.NewParamsV1(abi.NewTokenAmount(1), 1000, 100, shared.AllSelector(), nil, big.Zero())
+			require.NoError(t, err)
+			statuses := []retrievalmarket.DealStatus{
+				retrievalmarket.DealStatusCompleted,
+				retrievalmarket.DealStatusErrored,
+				retrievalmarket.DealStatusOngoing,
+			}
+			var ids []retrievalmarket.ProviderDealIdentifier
+			for i, status := range statuses {
+				deal := retrievalmarket.ProviderDealState{
+					DealProposal: retrievalmarket.DealProposal{
+						PayloadCID: tut.GenerateCids(1)[0],
+						ID:         retrievalmarket.DealID(i),
+						Params:     params,
+					},
+					Status:        status,
+					Receiver:      tut.GeneratePeers(1)[0],
+					FundsReceived: big.Zero(),
+				}
+				// several updates, so that logged deals have entries and snapshots
+				for _, funds := range []int64{0, 10, 20} {
+					deal.FundsReceived = big.NewInt(funds)
+					buf := new(bytes.Buffer)
+					require.NoError(t, deal.MarshalCBOR(buf))
+					require.NoError(t, namespaced.Put(datastore.NewKey(deal.Identifier().String()), buf.Bytes()))
+				}
+				ids = append(ids, deal.Identifier())
+			}
+
+			archive := dss.MutexWrap(datastore.NewMapDatastore())
+			opts := append([]retrievalimpl.RetrievalProviderOption{
+				retrievalimpl.DealRetention(retrievalmarket.DealRetentionPolicy{
+					MaxAge:  time.Millisecond,
+					Exclude: []retrievalmarket.ProviderDealIdentifier{ids[1]},
+				}, archive),
+			}, data.opts...)
+			rp, err := retrievalimpl.NewProvider(
+				spect.NewIDAddr(t, 2344),
+				testnodes.NewTestRetrievalProviderNode(),
+				tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+				tut.NewTestPieceStore(),
+				multiStore,
+				tut.NewTestDataTransfer(),
+				ds,
+				opts...,
+			)
+			require.NoError(t, err)
+			tut.StartAndWaitForReady(ctx, t, rp)
+			p := rp.(*retrievalimpl.Provider)
+
+			// deals that ended before retention was set up are taken to end when first seen
+			report, err := p.CompactDeals(ctx)
+			require.NoError(t, err)
+			require.Empty(t, report.Removed)
+			require.Equal(t, 2, report.Kept)
+
+			time.Sleep(10 * time.Millisecond)
+			report, err = p.CompactDeals(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []retrievalmarket.ProviderDealIdentifier{ids[0]}, report.Removed)
+			require.True(t, report.Archived)
+
+			deals := p.ListDeals()
+			require.Len(t, deals, 2)
+			require.NotContains(t, deals, ids[0])
+			has, err := archive.Has(datastore.NewKey(ids[0].String()))
+			require.NoError(t, err)
+			require.True(t, has)
+
+			// nothing of the removed deal is left in the datastore
+			results, err := ds.Query(query.Query{KeysOnly: true})
+			require.NoError(t, err)
+			entries, err := results.Rest()
+			require.NoError(t, err)
+			for _, entry := range entries {
+				require.NotContains(t, entry.Key, ids[0].String())
+			}
+
+			// a negative max age is invalid
+			cfg := p.Config()
+			cfg.DealRetention.MaxAge = -time.Hour
+			require.Error(t, p.ApplyConfig(cfg))
+			require.NoError(t, p.Stop())
+		})
+	}
+}
+
+func TestReadOnlyProvider(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	minerAddr := spect.NewIDAddr(t, 2344)
+
+	t.Run("fails before deals are migrated", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		_, err := retrievalimpl.NewReadOnlyProvider(minerAddr, ds)
+		require.Error(t, err)
+
+		version, err := migrationtools.CurrentVersion(ds)
+		require.NoError(t, err)
+		require.Equal(t, "", string(version))
+	})
+
+	t.Run("reads deals and ask of a running provider", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		namespaced := tut.DatastoreAtVersion(t, ds, "1")
+
+		params, err := retrievalmarket.NewParamsV1(abi.NewTokenAmount(1), 1000, 100, shared.AllSelector(), nil, big.Zero())
+		require.NoError(t, err)
+		deal := retrievalmarket.ProviderDealState{
+			DealProposal: retrievalmarket.DealProposal{
+				PayloadCID: tut.GenerateCids(1)[0],
+				ID:         retrievalmarket.DealID(10),
+				Params:     params,
+			},
+			Status:        retrievalmarket.DealStatusOngoing,
+			Receiver:      tut.GeneratePeers(1)[0],
+			FundsReceived: big.Zero(),
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.Identifier().String()), buf.Bytes()))
+
+		p, err := retrievalimpl.NewProvider(
+			minerAddr,
+			testnodes.NewTestRetrievalProviderNode(),
+			tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+			tut.NewTestPieceStore(),
+			multiStore,
+			tut.NewTestDataTransfer(),
+			ds,
+		)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+		ask := &retrievalmarket.Ask{
+			PricePerByte:            abi.NewTokenAmount(7),
+			UnsealPrice:             abi.NewTokenAmount(3),
+			PaymentInterval:         100,
+			PaymentIntervalIncrease: 10,
+		}
+		p.SetAsk(ask)
+
+		readOnly, err := retrievalimpl.NewReadOnlyProvider(minerAddr, ds)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, readOnly)
+
+		deals := readOnly.ListDeals()
+		require.Len(t, deals, 1)
+		require.Equal(t, retrievalmarket.DealStatusOngoing, deals[deal.Identifier()].Status)
+		require.Equal(t, ask, readOnly.GetAsk())
+
+		readOnly.SetAsk(&retrievalmarket.Ask{PricePerByte: abi.NewTokenAmount(1)})
+		require.Equal(t, ask, readOnly.GetAsk())
+		require.Equal(t, retrievalimpl.ErrReadOnly, readOnly.SetMinerAsk(minerAddr, ask))
+		require.NoError(t, readOnly.Stop())
+	})
+}
+
+func TestProviderDealMigration(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	minerAddr := spect.NewIDAddr(t, 2344)
+
+	newProvider := func(ds datastore.Batching, multiStore *multistore.MultiStore) retrievalmarket.RetrievalProvider {
+		p, err := retrievalimpl.NewProvider(
+			minerAddr,
+			testnodes.NewTestRetrievalProviderNode(),
+			tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{}),
+			tut.NewTestPieceStore(),
+			multiStore,
+			tut.NewTestDataTransfer(),
+			ds,
+		)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+		return p
+	}
+
+	oldDs := dss.MutexWrap(datastore.NewMapDatastore())
+	oldMultiStore, err := multistore.NewMultiDstore(oldDs)
+	require.NoError(t, err)
+	namespaced := tut.DatastoreAtVersion(t, oldDs, "1")
+
+	// the ongoing deal has part of its data sent, and its blocks unsealed into its store
+	storeID := oldMultiStore.Next()
+	store, err := oldMultiStore.Get(storeID)
+	require.NoError(t, err)
+	blks := tut.GenerateBlocksOfSize(3, 100)
+	for _, blk := range blks {
+		require.NoError(t, store.Bstore.Put(blk))
+	}
+
+	params, err := retrievalmarket.NewParamsV1(abi.NewTokenAmount(1), 1000, 100, shared.AllSelector(), nil, big.Zero())
+	require.NoError(t, err)
+	peers := tut.GeneratePeers(2)
+	putDeal := func(deal retrievalmarket.ProviderDealState) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.Identifier().String()), buf.Bytes()))
+	}
+	makeDeal := func(id retrievalmarket.DealID, status retrievalmarket.DealStatus) retrievalmarket.ProviderDealState {
+		return retrievalmarket.ProviderDealState{
+			DealProposal: retrievalmarket.DealProposal{
+				PayloadCID: tut.GenerateCids(1)[0],
+				ID:         id,
+				Params:     params,
+			},
+			ChannelID:     datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: datatransfer.TransferID(id)},
+			PieceInfo:     &piecestore.PieceInfo{PieceCID: tut.GenerateCids(1)[0]},
+			Status:        status,
+			Receiver:      peers[0],
+			FundsReceived: big.Zero(),
+		}
+	}
+	ongoing := makeDeal(1, retrievalmarket.DealStatusOngoing)
+	ongoing.StoreID = storeID
+	ongoing.TotalSent = 1500
+	ongoing.FundsReceived = abi.NewTokenAmount(1000)
+	unsealing := makeDeal(2, retrievalmarket.DealStatusUnsealing)
+	putDeal(ongoing)
+	putDeal(unsealing)
+	putDeal(makeDeal(3, retrievalmarket.DealStatusCompleted))
+	putDeal(makeDeal(4, retrievalmarket.DealStatusNew))
+
+	oldProvider := newProvider(oldDs, oldMultiStore)
+	require.NoError(t, oldProvider.Stop())
+
+	exported := new(bytes.Buffer)
+	ids, err := oldProvider.ExportDeals(ctx, exported)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []retrievalmarket.ProviderDealIdentifier{ongoing.Identifier(), unsealing.Identifier()}, ids)
+
+	newDs := dss.MutexWrap(datastore.NewMapDatastore())
+	newMultiStore, err := multistore.NewMultiDstore(newDs)
+	require.NoError(t, err)
+	replacement := newProvider(newDs, newMultiStore)
+	exportedBytes := exported.Bytes()
+	ids, err = replacement.ImportDeals(ctx, bytes.NewReader(exportedBytes))
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+
+	deals := replacement.ListDeals()
+	imported := deals[ongoing.Identifier()]
+	require.Equal(t, retrievalmarket.DealStatusOngoing, imported.Status)
+	require.Equal(t, ongoing.ChannelID, imported.ChannelID)
+	require.Equal(t, ongoing.TotalSent, imported.TotalSent)
+	require.Equal(t, ongoing.FundsReceived, imported.FundsReceived)
+	importedStore, err := newMultiStore.Get(imported.StoreID)
+	require.NoError(t, err)
+	for _, blk := range blks {
+		has, err := importedStore.Bstore.Has(blk.Cid())
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	require.Contains(t, deals, unsealing.Identifier())
+
+	// deals the provider already has are not imported again
+	ids, err = replacement.ImportDeals(ctx, bytes.NewReader(exportedBytes))
+	require.Error(t, err)
+	require.Empty(t, ids)
+}
+
 // loadPieceCIDS sets expectations to receive expectedPieceCID and 3 other random PieceCIDs to
 // disinguish the case of a PayloadCID is found but the PieceCID is not
 func loadPieceCIDS(t *testing.T, pieceStore *tut.TestPieceStore, expPayloadCID, expectedPieceCID cid.Cid) {
@@ -464,6 +983,21 @@ func TestProviderMigrations(t *testing.T) {
 	require.NoError(t, err)
 	err = providerDs.Put(datastore.NewKey("retrieval-ask"), askBuf.Bytes())
 	require.NoError(t, err)
+
+	// a dry run reports every deal as it would be migrated, without migrating it
+	report, err := migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.True(t, report.Pending())
+	require.Len(t, report.Records, numDeals)
+	require.Zero(t, report.Failed)
+	for _, record := range report.Records {
+		require.IsType(t, &retrievalmarket.ProviderDealState{}, record.Migrated)
+	}
+	report, err = migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.Len(t, report.Records, numDeals)
+
+	backupDs := dss.MutexWrap(datastore.NewMapDatastore())
 	retrievalProvider, err := retrievalimpl.NewProvider(
 		spect.NewIDAddr(t, 2344),
 		testnodes.NewTestRetrievalProviderNode(),
@@ -472,6 +1006,7 @@ func TestProviderMigrations(t *testing.T) {
 		multiStore,
 		dt,
 		providerDs,
+		retrievalimpl.MigrationBackup(backupDs),
 	)
 	require.NoError(t, err)
 	tut.StartAndWaitForReady(ctx, t, retrievalProvider)
@@ -526,4 +1061,17 @@ func TestProviderMigrations(t *testing.T) {
 		PaymentIntervalIncrease: oldAsk.PaymentIntervalIncrease,
 	}
 	require.Equal(t, expectedAsk, ask)
+
+	report, err = migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.False(t, report.Pending())
+	require.Empty(t, report.Records)
+
+	// rolling back restores the deals as they were before migration
+	err = migrationtools.Restore(providerDs, backupDs)
+	require.NoError(t, err)
+	report, err = migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.True(t, report.Pending())
+	require.Len(t, report.Records, numDeals)
 }
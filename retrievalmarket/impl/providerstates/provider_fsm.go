@@ -37,6 +37,20 @@ var ProviderEvents = fsm.Events{
 			return nil
 		}),
 
+	// waiting for a transfer slot
+	fsm.Event(rm.ProviderEventTransferQueued).
+		From(rm.DealStatusUnsealing).To(rm.DealStatusTransferQueued).
+		Action(func(deal *rm.ProviderDealState) error {
+			deal.Message = "transfer queued behind other transfers"
+			return nil
+		}),
+	fsm.Event(rm.ProviderEventTransferSlotOpened).
+		From(rm.DealStatusTransferQueued).To(rm.DealStatusUnsealing).
+		Action(func(deal *rm.ProviderDealState) error {
+			deal.Message = ""
+			return nil
+		}),
+
 	//unsealing
 	fsm.Event(rm.ProviderEventUnsealError).
 		From(rm.DealStatusUnsealing).To(rm.DealStatusFailing).
@@ -115,12 +129,25 @@ var ProviderEvents = fsm.Events{
 			return nil
 		},
 	),
+
+	// the data transfer channel for the deal made progress or changed status
+	fsm.Event(rm.ProviderEventDataTransferUpdated).
+		FromAny().ToJustRecord().
+		Action(func(deal *rm.ProviderDealState, channelState datatransfer.ChannelState) error {
+			deal.ChannelID = channelState.ChannelID()
+			deal.TransferStatus = channelState.Status()
+			deal.TransferQueued = channelState.Queued()
+			deal.TransferSent = channelState.Sent()
+			deal.TransferReceived = channelState.Received()
+			return nil
+		}),
 }
 
 // ProviderStateEntryFuncs are the handlers for different states in a retrieval provider
 var ProviderStateEntryFuncs = fsm.StateEntryFuncs{
 	rm.DealStatusFundsNeededUnseal: TrackTransfer,
 	rm.DealStatusUnsealing:         UnsealData,
+	rm.DealStatusTransferQueued:    WaitForTransferSlot,
 	rm.DealStatusUnsealed:          UnpauseDeal,
 	rm.DealStatusFailing:           CancelDeal,
 	rm.DealStatusCancelling:        CancelDeal,
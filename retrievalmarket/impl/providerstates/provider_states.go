@@ -5,10 +5,13 @@ import (
 	"errors"
 	"io"
 
+	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-statemachine"
 	"github.com/filecoin-project/go-statemachine/fsm"
 
@@ -21,18 +24,30 @@ import (
 type ProviderDealEnvironment interface {
 	// Node returns the node interface for this deal
 	Node() rm.RetrievalProviderNode
+	// UnsealSector unseals part of a sector of the given miner, or of the miner the
+	// provider was created with if miner is nil, along with the other deals reading
+	// from the same sector
+	UnsealSector(ctx context.Context, miner *address.Address, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error)
 	ReadIntoBlockstore(storeID multistore.StoreID, pieceData io.Reader) error
+	// FetchRemotePiece reads a piece from the remote copy recorded in its PieceInfo
+	FetchRemotePiece(ctx context.Context, pieceInfo piecestore.PieceInfo) (io.ReadCloser, error)
+	// OpenStagedPiece returns a reader for the CAR a storage provider staged for the
+	// piece, or false if it is no longer staged
+	OpenStagedPiece(pieceCID cid.Cid) (io.ReadCloser, bool)
 	TrackTransfer(deal rm.ProviderDealState) error
 	UntrackTransfer(deal rm.ProviderDealState) error
 	DeleteStore(storeID multistore.StoreID) error
 	ResumeDataTransfer(context.Context, datatransfer.ChannelID) error
 	CloseDataTransfer(context.Context, datatransfer.ChannelID) error
+	// TransferSlot returns true if the deal holds one of the provider's transfer
+	// slots, queueing it for one otherwise
+	TransferSlot(deal rm.ProviderDealState) bool
 }
 
-func firstSuccessfulUnseal(ctx context.Context, node rm.RetrievalProviderNode, pieceInfo piecestore.PieceInfo) (io.Reader, error) {
+func firstSuccessfulUnseal(ctx context.Context, environment ProviderDealEnvironment, miner *address.Address, pieceInfo piecestore.PieceInfo) (io.ReadCloser, error) {
 	lastErr := xerrors.New("no sectors found to unseal from")
 	for _, deal := range pieceInfo.Deals {
-		reader, err := node.UnsealSector(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+		reader, err := environment.UnsealSector(ctx, miner, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
 		if err == nil {
 			return reader, nil
 		}
@@ -41,12 +56,33 @@ func firstSuccessfulUnseal(ctx context.Context, node rm.RetrievalProviderNode, p
 	return nil, lastErr
 }
 
-// UnsealData unseals the piece containing data for retrieval as needed
+// UnsealData unseals the piece containing data for retrieval as needed, falling back to
+// the piece's remote copy if it has one and cannot be unsealed. Pieces whose CAR is
+// still staged by the storage provider are read from it instead of being unsealed.
+// Deals wait for a transfer slot before unsealing
 func UnsealData(ctx fsm.Context, environment ProviderDealEnvironment, deal rm.ProviderDealState) error {
-	reader, err := firstSuccessfulUnseal(ctx.Context(), environment.Node(), *deal.PieceInfo)
+	if !environment.TransferSlot(deal) {
+		return ctx.Trigger(rm.ProviderEventTransferQueued)
+	}
+	if staged, ok := environment.OpenStagedPiece(deal.PieceInfo.PieceCID); ok {
+		defer staged.Close()
+		if err := environment.ReadIntoBlockstore(deal.StoreID, staged); err != nil {
+			return ctx.Trigger(rm.ProviderEventUnsealError, err)
+		}
+		return ctx.Trigger(rm.ProviderEventUnsealComplete)
+	}
+	reader, err := firstSuccessfulUnseal(ctx.Context(), environment, deal.Miner, *deal.PieceInfo)
 	if err != nil {
-		return ctx.Trigger(rm.ProviderEventUnsealError, err)
+		if deal.PieceInfo.RemoteLocation == "" {
+			return ctx.Trigger(rm.ProviderEventUnsealError, err)
+		}
+		remote, rerr := environment.FetchRemotePiece(ctx.Context(), *deal.PieceInfo)
+		if rerr != nil {
+			return ctx.Trigger(rm.ProviderEventUnsealError, xerrors.Errorf("unsealing piece: %s; fetching remote copy: %w", err, rerr))
+		}
+		reader = remote
 	}
+	defer reader.Close()
 	err = environment.ReadIntoBlockstore(deal.StoreID, reader)
 	if err != nil {
 		return ctx.Trigger(rm.ProviderEventUnsealError, err)
@@ -54,6 +90,15 @@ func UnsealData(ctx fsm.Context, environment ProviderDealEnvironment, deal rm.Pr
 	return ctx.Trigger(rm.ProviderEventUnsealComplete)
 }
 
+// WaitForTransferSlot checks whether a queued deal can start unsealing. Queued deals
+// are otherwise started as other transfers finish
+func WaitForTransferSlot(ctx fsm.Context, environment ProviderDealEnvironment, deal rm.ProviderDealState) error {
+	if environment.TransferSlot(deal) {
+		return ctx.Trigger(rm.ProviderEventTransferSlotOpened)
+	}
+	return nil
+}
+
 // TrackTransfer resumes a deal so we can start sending data after its unsealed
 func TrackTransfer(ctx fsm.Context, environment ProviderDealEnvironment, deal rm.ProviderDealState) error {
 	err := environment.TrackTransfer(deal)
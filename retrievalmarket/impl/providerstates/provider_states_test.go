@@ -88,6 +88,38 @@ func TestUnsealData(t *testing.T) {
 		require.Equal(t, dealState.Status, rm.DealStatusFailing)
 		require.Equal(t, dealState.Message, "Could not unseal")
 	})
+	t.Run("falls back to remote copy", func(t *testing.T) {
+		node := testnodes.NewTestRetrievalProviderNode()
+		node.ExpectFailedUnseal(sectorID, offset.Unpadded(), length.Unpadded())
+		dealState := makeDeal()
+		dealState.PieceInfo.RemoteLocation = "https://example.com/piece.car"
+		setupEnv := func(fe *rmtesting.TestProviderDealEnvironment) {
+			fe.FetchRemotePieceData = data
+		}
+		runUnsealData(t, node, setupEnv, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusUnsealed)
+	})
+	t.Run("reads staged piece", func(t *testing.T) {
+		node := testnodes.NewTestRetrievalProviderNode()
+		dealState := makeDeal()
+		setupEnv := func(fe *rmtesting.TestProviderDealEnvironment) {
+			fe.StagedPieceData = data
+		}
+		runUnsealData(t, node, setupEnv, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusUnsealed)
+	})
+	t.Run("remote copy error", func(t *testing.T) {
+		node := testnodes.NewTestRetrievalProviderNode()
+		node.ExpectFailedUnseal(sectorID, offset.Unpadded(), length.Unpadded())
+		dealState := makeDeal()
+		dealState.PieceInfo.RemoteLocation = "https://example.com/piece.car"
+		setupEnv := func(fe *rmtesting.TestProviderDealEnvironment) {
+			fe.FetchRemotePieceError = errors.New("remote unavailable")
+		}
+		runUnsealData(t, node, setupEnv, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusFailing)
+		require.Equal(t, dealState.Message, "unsealing piece: Could not unseal; fetching remote copy: remote unavailable")
+	})
 	t.Run("ReadIntoBlockstore error", func(t *testing.T) {
 		node := testnodes.NewTestRetrievalProviderNode()
 		node.ExpectUnseal(sectorID, offset.Unpadded(), length.Unpadded(), data)
@@ -99,6 +131,41 @@ func TestUnsealData(t *testing.T) {
 		require.Equal(t, dealState.Status, rm.DealStatusFailing)
 		require.Equal(t, dealState.Message, "Something went wrong")
 	})
+	t.Run("transfer slots full", func(t *testing.T) {
+		node := testnodes.NewTestRetrievalProviderNode()
+		dealState := makeDeal()
+		setupEnv := func(fe *rmtesting.TestProviderDealEnvironment) {
+			fe.TransferSlotsFull = true
+		}
+		runUnsealData(t, node, setupEnv, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusTransferQueued)
+	})
+}
+
+func TestWaitForTransferSlot(t *testing.T) {
+	ctx := context.Background()
+	eventMachine, err := fsm.NewEventProcessor(rm.ProviderDealState{}, "Status", providerstates.ProviderEvents)
+	require.NoError(t, err)
+	runWaitForTransferSlot := func(t *testing.T, slotsFull bool, dealState *rm.ProviderDealState) {
+		environment := rmtesting.NewTestProviderDealEnvironment(testnodes.NewTestRetrievalProviderNode())
+		environment.TransferSlotsFull = slotsFull
+		fsmCtx := fsmtest.NewTestContext(ctx, eventMachine)
+		err := providerstates.WaitForTransferSlot(fsmCtx, environment, *dealState)
+		require.NoError(t, err)
+		fsmCtx.ReplayEvents(t, dealState)
+	}
+
+	t.Run("slot opened", func(t *testing.T) {
+		dealState := &rm.ProviderDealState{Status: rm.DealStatusTransferQueued, Message: "transfer queued behind other transfers"}
+		runWaitForTransferSlot(t, false, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusUnsealing)
+		require.Empty(t, dealState.Message)
+	})
+	t.Run("still queued", func(t *testing.T) {
+		dealState := &rm.ProviderDealState{Status: rm.DealStatusTransferQueued}
+		runWaitForTransferSlot(t, true, dealState)
+		require.Equal(t, dealState.Status, rm.DealStatusTransferQueued)
+	})
 }
 
 func TestUnpauseDeal(t *testing.T) {
@@ -0,0 +1,165 @@
+/*
+Package queryadmission limits how many retrieval queries a provider answers at once.
+
+Each incoming query is classified by the peer that sent it into a priority class:
+known clients the provider has configured, peers that recently paid for a retrieval,
+and everyone else. Each class has its own concurrency budget. When a class's budget
+is used up, further queries in that class are shed with a busy response, so a flood
+of anonymous queries cannot slow down answers to the provider's paying clients.
+*/
+package queryadmission
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Class is the priority class of a query
+type Class uint64
+
+const (
+	// ClassAnonymous is a query from a peer the provider knows nothing about
+	ClassAnonymous Class = iota
+
+	// ClassRecentPayer is a query from a peer that recently paid for a retrieval
+	ClassRecentPayer
+
+	// ClassKnownClient is a query from a peer the provider has configured as a known client
+	ClassKnownClient
+)
+
+// Classes is a human readable map of class -> name
+var Classes = map[Class]string{
+	ClassAnonymous:   "ClassAnonymous",
+	ClassRecentPayer: "ClassRecentPayer",
+	ClassKnownClient: "ClassKnownClient",
+}
+
+func (c Class) String() string {
+	return Classes[c]
+}
+
+// DefaultRecentPaymentWindow is how long a peer is classed as a recent payer
+// after its last payment
+const DefaultRecentPaymentWindow = 24 * time.Hour
+
+// DefaultBudgets are the concurrency budgets for each class if none are set
+var DefaultBudgets = map[Class]int{
+	ClassAnonymous:   16,
+	ClassRecentPayer: 32,
+	ClassKnownClient: 64,
+}
+
+// Option configures a Controller
+type Option func(*Controller)
+
+// KnownClients sets the peers whose queries are classed as known clients
+func KnownClients(peers ...peer.ID) Option {
+	return func(c *Controller) {
+		for _, p := range peers {
+			c.knownClients[p] = struct{}{}
+		}
+	}
+}
+
+// Budget sets the number of queries in a class that are answered concurrently.
+// A budget of zero sheds every query in the class
+func Budget(class Class, concurrent int) Option {
+	return func(c *Controller) {
+		c.budgets[class] = concurrent
+	}
+}
+
+// RecentPaymentWindow sets how long a peer is classed as a recent payer after paying
+func RecentPaymentWindow(window time.Duration) Option {
+	return func(c *Controller) {
+		c.recentPaymentWindow = window
+	}
+}
+
+// Controller classifies queries and admits or sheds them according to the
+// concurrency budget of their class
+type Controller struct {
+	recentPaymentWindow time.Duration
+	knownClients        map[peer.ID]struct{}
+	budgets             map[Class]int
+
+	lk           sync.Mutex
+	inFlight     map[Class]int
+	lastPayments map[peer.ID]time.Time
+	now          func() time.Time
+}
+
+// NewController returns a new admission controller
+func NewController(options ...Option) *Controller {
+	c := &Controller{
+		recentPaymentWindow: DefaultRecentPaymentWindow,
+		knownClients:        make(map[peer.ID]struct{}),
+		budgets:             make(map[Class]int, len(DefaultBudgets)),
+		inFlight:            make(map[Class]int),
+		lastPayments:        make(map[peer.ID]time.Time),
+		now:                 time.Now,
+	}
+	for class, budget := range DefaultBudgets {
+		c.budgets[class] = budget
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// RecordPayment notes that a peer paid for a retrieval, so its queries are
+// classed as a recent payer's for the payment window
+func (c *Controller) RecordPayment(p peer.ID) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.lastPayments[p] = c.now()
+}
+
+// Classify returns the priority class of a query from the given peer
+func (c *Controller) Classify(p peer.ID) Class {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.classify(p)
+}
+
+// Admit decides whether to answer a query from the given peer. If the query is
+// admitted, the caller must call the returned release function once the query has
+// been answered. If not, the query should be shed with a busy response.
+func (c *Controller) Admit(p peer.ID) (release func(), admitted bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	class := c.classify(p)
+	if c.inFlight[class] >= c.budgets[class] {
+		return nil, false
+	}
+	c.inFlight[class]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.lk.Lock()
+			c.inFlight[class]--
+			c.lk.Unlock()
+		})
+	}, true
+}
+
+func (c *Controller) classify(p peer.ID) Class {
+	if _, ok := c.knownClients[p]; ok {
+		return ClassKnownClient
+	}
+	if last, ok := c.lastPayments[p]; ok {
+		if c.now().Sub(last) < c.recentPaymentWindow {
+			return ClassRecentPayer
+		}
+		delete(c.lastPayments, p)
+	}
+	return ClassAnonymous
+}
@@ -0,0 +1,75 @@
+package queryadmission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestClassify(t *testing.T) {
+	peers := shared_testutil.GeneratePeers(3)
+	known, payer, anonymous := peers[0], peers[1], peers[2]
+
+	now := time.Now()
+	c := NewController(KnownClients(known), RecentPaymentWindow(time.Hour))
+	c.now = func() time.Time { return now }
+
+	c.RecordPayment(payer)
+	c.RecordPayment(known)
+	require.Equal(t, ClassKnownClient, c.Classify(known))
+	require.Equal(t, ClassRecentPayer, c.Classify(payer))
+	require.Equal(t, ClassAnonymous, c.Classify(anonymous))
+
+	now = now.Add(time.Hour)
+	require.Equal(t, ClassAnonymous, c.Classify(payer))
+	require.Equal(t, ClassKnownClient, c.Classify(known))
+}
+
+func TestAdmit(t *testing.T) {
+	peers := shared_testutil.GeneratePeers(3)
+	known, anonymous, otherAnonymous := peers[0], peers[1], peers[2]
+
+	c := NewController(
+		KnownClients(known),
+		Budget(ClassAnonymous, 1),
+		Budget(ClassKnownClient, 1),
+	)
+
+	release, admitted := c.Admit(anonymous)
+	require.True(t, admitted)
+
+	// the anonymous budget is used up, but the known client budget is separate
+	_, admitted = c.Admit(otherAnonymous)
+	require.False(t, admitted)
+	releaseKnown, admitted := c.Admit(known)
+	require.True(t, admitted)
+	_, admitted = c.Admit(known)
+	require.False(t, admitted)
+
+	// releasing more than once only frees one slot
+	release()
+	release()
+	release, admitted = c.Admit(otherAnonymous)
+	require.True(t, admitted)
+	_, admitted = c.Admit(anonymous)
+	require.False(t, admitted)
+
+	release()
+	releaseKnown()
+	_, admitted = c.Admit(known)
+	require.True(t, admitted)
+}
+
+func TestDefaultBudgets(t *testing.T) {
+	c := NewController()
+	anonymous := shared_testutil.GeneratePeers(1)[0]
+	for i := 0; i < DefaultBudgets[ClassAnonymous]; i++ {
+		_, admitted := c.Admit(anonymous)
+		require.True(t, admitted)
+	}
+	_, admitted := c.Admit(anonymous)
+	require.False(t, admitted)
+}
@@ -0,0 +1,118 @@
+package retrievalimpl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// QueryHTTPPath is the path the HTTP query gateway answers retrieval queries on
+const QueryHTTPPath = "/retrieval/query"
+
+// HTTPQueryGateway has the provider answer retrieval queries over HTTP on the given
+// listen address while it is started, for web clients and monitoring probes that
+// have no libp2p stack. See QueryHandler. It must be passed to NewProvider
+func HTTPQueryGateway(listenAddr string) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.queryGatewayAddr = listenAddr
+	}
+}
+
+/*
+QueryHandler returns an HTTP handler that answers retrieval queries like
+HandleQueryStream does, for mounting on a node's own HTTP server. It answers GET
+requests on QueryHTTPPath:
+
+	GET /retrieval/query?payload=<payload CID>[&piece=<piece CID>]
+
+with the same QueryResponse sent over libp2p, encoded as JSON. Queries are admitted
+like queries from an unknown peer, identified by the remote host, and a query the
+provider is too busy to answer gets a busy response with status 503.
+*/
+func (p *Provider) QueryHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(QueryHTTPPath, p.handleHTTPQuery)
+	return mux
+}
+
+func (p *Provider) handleHTTPQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	payloadCID, err := cid.Decode(params.Get("payload"))
+	if err != nil {
+		http.Error(w, "invalid payload CID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := retrievalmarket.Query{PayloadCID: payloadCID}
+	if piece := params.Get("piece"); piece != "" {
+		pieceCID, err := cid.Decode(piece)
+		if err != nil {
+			http.Error(w, "invalid piece CID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.PieceCID = &pieceCID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	release, admitted := p.admitQuery(peer.ID("http:" + host))
+	if !admitted {
+		writeQueryResponse(w, http.StatusServiceUnavailable, p.queryBusy())
+		return
+	}
+	defer release()
+
+	answer, _, _, ok := p.answerQuery(r.Context(), query)
+	if !ok {
+		http.Error(w, "provider cannot answer queries", http.StatusInternalServerError)
+		return
+	}
+	writeQueryResponse(w, http.StatusOK, answer)
+}
+
+func writeQueryResponse(w http.ResponseWriter, status int, response retrievalmarket.QueryResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Warnf("writing HTTP query response: %s", err)
+	}
+}
+
+// startQueryGateway starts serving queries over HTTP, if the provider was configured
+// with HTTPQueryGateway
+func (p *Provider) startQueryGateway() error {
+	if p.queryGatewayAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", p.queryGatewayAddr)
+	if err != nil {
+		return err
+	}
+	p.queryGateway = &http.Server{Handler: p.QueryHandler()}
+	go func() {
+		if err := p.queryGateway.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("serving retrieval queries over HTTP: %s", err)
+		}
+	}()
+	return nil
+}
+
+func (p *Provider) stopQueryGateway() error {
+	if p.queryGateway == nil {
+		return nil
+	}
+	return p.queryGateway.Shutdown(context.TODO())
+}
@@ -0,0 +1,98 @@
+package retrievalimpl_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/queryadmission"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestQueryHandler(t *testing.T) {
+	ctx := context.Background()
+	payloadCID := tut.GenerateCids(1)[0]
+	pieceCID := tut.GenerateCids(1)[0]
+
+	newServer := func(t *testing.T, pieceStore piecestore.PieceStore, opts ...retrievalimpl.RetrievalProviderOption) *httptest.Server {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
+		p, err := retrievalimpl.NewProvider(address.TestAddress2, testnodes.NewTestRetrievalProviderNode(), net, pieceStore, multiStore, tut.NewTestDataTransfer(), ds, opts...)
+		require.NoError(t, err)
+		tut.StartAndWaitForReady(ctx, t, p)
+		return httptest.NewServer(p.(*retrievalimpl.Provider).QueryHandler())
+	}
+
+	query := func(t *testing.T, server *httptest.Server, params url.Values) (int, retrievalmarket.QueryResponse) {
+		resp, err := http.Get(server.URL + retrievalimpl.QueryHTTPPath + "?" + params.Encode())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var response retrievalmarket.QueryResponse
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusServiceUnavailable {
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		}
+		return resp.StatusCode, response
+	}
+
+	t.Run("answers queries", func(t *testing.T) {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, piecestore.CIDInfo{
+			PieceBlockLocations: []piecestore.PieceBlockLocation{{PieceCID: pieceCID}},
+		})
+		pieceStore.ExpectPiece(pieceCID, piecestore.PieceInfo{
+			Deals: []piecestore.DealInfo{{Length: abi.PaddedPieceSize(1234)}},
+		})
+		server := newServer(t, pieceStore)
+		defer server.Close()
+
+		status, response := query(t, server, url.Values{"payload": {payloadCID.String()}})
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, response.Status)
+		require.Equal(t, retrievalmarket.QueryItemAvailable, response.PieceCIDFound)
+		require.Equal(t, uint64(1234), response.Size)
+		require.Equal(t, address.TestAddress2, response.PaymentAddress)
+		require.Equal(t, retrievalmarket.DefaultPricePerByte, response.MinPricePerByte)
+		pieceStore.VerifyExpectations(t)
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		server := newServer(t, tut.NewTestPieceStore())
+		defer server.Close()
+		status, _ := query(t, server, url.Values{"payload": {"not a cid"}})
+		require.Equal(t, http.StatusBadRequest, status)
+		status, _ = query(t, server, url.Values{"payload": {payloadCID.String()}, "piece": {"not a cid"}})
+		require.Equal(t, http.StatusBadRequest, status)
+
+		resp, err := http.Post(server.URL+retrievalimpl.QueryHTTPPath, "text/plain", nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("sheds queries when busy", func(t *testing.T) {
+		controller := queryadmission.NewController(queryadmission.Budget(queryadmission.ClassAnonymous, 0))
+		server := newServer(t, tut.NewTestPieceStore(), retrievalimpl.QueryAdmission(controller))
+		defer server.Close()
+		status, response := query(t, server, url.Values{"payload": {payloadCID.String()}})
+		require.Equal(t, http.StatusServiceUnavailable, status)
+		require.Equal(t, retrievalmarket.QueryResponseBusy, response.Status)
+	})
+}
@@ -0,0 +1,101 @@
+package retrievalimpl
+
+import (
+	"time"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dss "github.com/ipfs/go-datastore/sync"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+	"github.com/filecoin-project/go-statemachine/fsm"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/askstore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/paymentdefaults"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/providerstates"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/unsealbatch"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+)
+
+// ErrReadOnly is returned by a provider opened with NewReadOnlyProvider for
+// operations that would change its deals, asks or config
+var ErrReadOnly = xerrors.New("retrieval provider is read-only")
+
+// NewReadOnlyProvider opens the deals and asks of a retrieval provider for
+// inspection, such as from a tool running alongside the provider that owns the
+// datastore. It registers no network handlers or data transfer vouchers and never
+// runs migrations, so it fails unless the provider has already migrated the
+// datastore. Deal state handlers never run, and operations that would change
+// anything return ErrReadOnly
+func NewReadOnlyProvider(minerAddress address.Address, ds datastore.Batching, opts ...RetrievalProviderOption) (retrievalmarket.RetrievalProvider, error) {
+	p := &Provider{
+		minerAddress: minerAddress,
+		readySub:     pubsub.New(shared.ReadyDispatcher),
+		handlerPool:  handlerpool.New(0, 0),
+		configSub:    pubsub.New(configDispatcher),
+		ds:           ds,
+		stateTimes:   shared.NewStateTimes(),
+		readOnly:     true,
+
+		unsealBatcher:      unsealbatch.New(0, 0),
+		expectedDwellTimes: make(map[retrievalmarket.DealStatus]time.Duration, len(DefaultExpectedDwellTimes)),
+	}
+	for state, dwell := range DefaultExpectedDwellTimes {
+		p.expectedDwellTimes[state] = dwell
+	}
+
+	askStore, err := askstore.LoadAskStore(namespace.Wrap(ds, datastore.NewKey("retrieval-ask")), datastore.NewKey("latest"))
+	if err != nil {
+		return nil, xerrors.Errorf("opening retrieval ask: %w", err)
+	}
+	p.askStore = askStore
+	p.miners = []*servedMiner{{
+		address:  minerAddress,
+		askStore: askStore,
+	}}
+
+	p.Configure(opts...)
+	err = p.openMinerAskStores()
+	if err != nil {
+		return nil, err
+	}
+
+	p.dealsDs = dealsDatastore(ds, p.logDeals)
+	dealsDs, err := migrationtools.AtVersion(p.dealsDs, versioning.VersionKey("1"))
+	if err != nil {
+		return nil, xerrors.Errorf("opening retrieval provider deals: %w", err)
+	}
+	p.stateMachines, err = fsm.New(dealsDs, fsm.Parameters{
+		Environment:     &providerDealEnvironment{p},
+		StateType:       retrievalmarket.ProviderDealState{},
+		StateKeyField:   "Status",
+		Events:          providerstates.ProviderEvents,
+		StateEntryFuncs: fsm.StateEntryFuncs{},
+		FinalityStates:  providerstates.ProviderFinalityStates,
+		Notifier:        p.notifySubscribers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.subscribers = eventbus.New(providerDispatcher, p.eventQueueSize, p.eventOverflowPolicy)
+	if p.statsDs == nil {
+		p.statsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	p.stats, err = dealstats.New(p.statsDs)
+	if err != nil {
+		return nil, err
+	}
+	if p.paymentDefaultsDs == nil {
+		p.paymentDefaultsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	p.paymentDefaults = paymentdefaults.New(p.paymentDefaultsDs)
+	return p, nil
+}
@@ -0,0 +1,65 @@
+/*
+Package remotepiece fetches pieces a retrieval provider keeps a copy of outside its
+sectors, for example when sealed copies are archived offsite and can no longer be
+unsealed on the node.
+
+The location of a piece's remote copy is recorded in its PieceInfo in the piecestore.
+HTTPFetcher reads pieces from http and https URLs, which covers plain HTTP servers as
+well as blob stores such as S3 that serve objects over public or pre-signed URLs. Other
+kinds of storage can be supported by implementing retrievalmarket.RemotePieceFetcher.
+*/
+package remotepiece
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+var _ retrievalmarket.RemotePieceFetcher = new(HTTPFetcher)
+
+// HTTPFetcher fetches pieces from http and https URLs
+type HTTPFetcher struct {
+	client *http.Client
+}
+
+// NewHTTPFetcher returns a fetcher that makes requests with the given client, or with
+// http.DefaultClient if client is nil
+func NewHTTPFetcher(client *http.Client) *HTTPFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPFetcher{client: client}
+}
+
+// FetchPiece requests the piece at location and returns the response body, which the
+// caller must close
+func (f *HTTPFetcher) FetchPiece(ctx context.Context, pieceCID cid.Cid, location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing location of piece %s: %w", pieceCID, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, xerrors.Errorf("unsupported scheme %q in location of piece %s", u.Scheme, pieceCID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("fetching piece %s: %w", pieceCID, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, xerrors.Errorf("fetching piece %s: unexpected status %s", pieceCID, resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,50 @@
+package remotepiece_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/remotepiece"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	ctx := context.Background()
+	pieceCID := shared_testutil.GenerateCids(1)[0]
+	data := shared_testutil.RandomBytes(1024)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/piece" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	fetcher := remotepiece.NewHTTPFetcher(srv.Client())
+
+	t.Run("fetches a piece", func(t *testing.T) {
+		reader, err := fetcher.FetchPiece(ctx, pieceCID, srv.URL+"/piece")
+		require.NoError(t, err)
+		defer reader.Close()
+		fetched, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, data, fetched)
+	})
+
+	t.Run("fails when the piece is not found", func(t *testing.T) {
+		_, err := fetcher.FetchPiece(ctx, pieceCID, srv.URL+"/missing")
+		require.EqualError(t, err, "fetching piece "+pieceCID.String()+": unexpected status 404 Not Found")
+	})
+
+	t.Run("rejects unsupported schemes", func(t *testing.T) {
+		_, err := fetcher.FetchPiece(ctx, pieceCID, "file:///tmp/piece")
+		require.EqualError(t, err, "unsupported scheme \"file\" in location of piece "+pieceCID.String())
+	})
+}
@@ -0,0 +1,117 @@
+package requestvalidation
+
+import (
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	rm "github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// Plugin is a custom validation step a provider runs on the vouchers it receives,
+// such as checking an authorization token for the client. Returning an error
+// rejects the voucher
+type Plugin interface {
+	// ValidateProposal checks a deal proposal received from receiver, before the
+	// provider's own checks
+	ValidateProposal(receiver peer.ID, proposal rm.DealProposal) error
+	// ValidatePayment checks a payment received for a deal, before it is redeemed
+	ValidatePayment(deal rm.ProviderDealState, payment rm.DealPayment) error
+}
+
+// PluginStats are the counts and time spent for one plugin in a PluginChain
+type PluginStats struct {
+	Name string
+	// Checked is the number of vouchers the plugin checked, and Rejected the number
+	// of those it rejected
+	Checked  uint64
+	Rejected uint64
+	// Time is the total time the plugin spent checking vouchers
+	Time time.Duration
+}
+
+type registeredPlugin struct {
+	plugin Plugin
+	stats  PluginStats
+}
+
+// PluginChain runs a provider's validation plugins on each voucher in the order they
+// were registered, stopping at the first plugin that rejects it. A nil PluginChain
+// has no plugins
+type PluginChain struct {
+	lk      sync.Mutex
+	plugins []*registeredPlugin
+}
+
+// NewPluginChain returns a PluginChain with no plugins
+func NewPluginChain() *PluginChain {
+	return &PluginChain{}
+}
+
+// Register adds a plugin to the end of the chain, under a name its stats are
+// reported with
+func (pc *PluginChain) Register(name string, plugin Plugin) {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	pc.plugins = append(pc.plugins, &registeredPlugin{plugin: plugin, stats: PluginStats{Name: name}})
+}
+
+// Stats returns the stats of each plugin, in the order they run
+func (pc *PluginChain) Stats() []PluginStats {
+	if pc == nil {
+		return nil
+	}
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	stats := make([]PluginStats, 0, len(pc.plugins))
+	for _, rp := range pc.plugins {
+		stats = append(stats, rp.stats)
+	}
+	return stats
+}
+
+// ValidateProposal runs each plugin on a deal proposal
+func (pc *PluginChain) ValidateProposal(receiver peer.ID, proposal rm.DealProposal) error {
+	return pc.run(func(plugin Plugin) error {
+		return plugin.ValidateProposal(receiver, proposal)
+	})
+}
+
+// ValidatePayment runs each plugin on a payment for a deal
+func (pc *PluginChain) ValidatePayment(deal rm.ProviderDealState, payment rm.DealPayment) error {
+	return pc.run(func(plugin Plugin) error {
+		return plugin.ValidatePayment(deal, payment)
+	})
+}
+
+func (pc *PluginChain) run(validate func(plugin Plugin) error) error {
+	if pc == nil {
+		return nil
+	}
+	pc.lk.Lock()
+	plugins := append([]*registeredPlugin{}, pc.plugins...)
+	pc.lk.Unlock()
+
+	// plugins are run without the lock held, so that a slow plugin does not hold up
+	// vouchers for other deals
+	for _, rp := range plugins {
+		start := time.Now()
+		err := validate(rp.plugin)
+		elapsed := time.Since(start)
+
+		pc.lk.Lock()
+		rp.stats.Checked++
+		rp.stats.Time += elapsed
+		if err != nil {
+			rp.stats.Rejected++
+		}
+		pc.lk.Unlock()
+
+		if err != nil {
+			return xerrors.Errorf("rejected by %s: %w", rp.stats.Name, err)
+		}
+	}
+	return nil
+}
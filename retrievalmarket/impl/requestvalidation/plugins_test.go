@@ -0,0 +1,72 @@
+package requestvalidation_test
+
+import (
+	"errors"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	rm "github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/requestvalidation"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// testPlugin is a validation plugin that rejects every voucher with the given errors,
+// and records the proposals it checks
+type testPlugin struct {
+	proposalErr error
+	paymentErr  error
+	proposals   []rm.DealProposal
+}
+
+func (tp *testPlugin) ValidateProposal(receiver peer.ID, proposal rm.DealProposal) error {
+	tp.proposals = append(tp.proposals, proposal)
+	return tp.proposalErr
+}
+
+func (tp *testPlugin) ValidatePayment(deal rm.ProviderDealState, payment rm.DealPayment) error {
+	return tp.paymentErr
+}
+
+func TestPluginChain(t *testing.T) {
+	receiver := shared_testutil.GeneratePeers(1)[0]
+	proposal := shared_testutil.MakeTestDealProposal()
+
+	t.Run("runs plugins in order until one rejects", func(t *testing.T) {
+		first := &testPlugin{}
+		second := &testPlugin{proposalErr: errors.New("no auth token")}
+		third := &testPlugin{}
+		chain := requestvalidation.NewPluginChain()
+		chain.Register("first", first)
+		chain.Register("second", second)
+		chain.Register("third", third)
+
+		require.EqualError(t, chain.ValidateProposal(receiver, proposal), "rejected by second: no auth token")
+		require.Len(t, first.proposals, 1)
+		require.Len(t, second.proposals, 1)
+		require.Empty(t, third.proposals)
+
+		stats := chain.Stats()
+		require.Len(t, stats, 3)
+		require.Equal(t, "first", stats[0].Name)
+		require.Equal(t, uint64(1), stats[0].Checked)
+		require.Zero(t, stats[0].Rejected)
+		require.Equal(t, uint64(1), stats[1].Checked)
+		require.Equal(t, uint64(1), stats[1].Rejected)
+		require.Zero(t, stats[2].Checked)
+	})
+
+	t.Run("counts payments", func(t *testing.T) {
+		chain := requestvalidation.NewPluginChain()
+		chain.Register("payments", &testPlugin{})
+		require.NoError(t, chain.ValidatePayment(rm.ProviderDealState{}, rm.DealPayment{}))
+		require.Equal(t, uint64(1), chain.Stats()[0].Checked)
+	})
+
+	t.Run("nil chain has no plugins", func(t *testing.T) {
+		var chain *requestvalidation.PluginChain
+		require.NoError(t, chain.ValidateProposal(receiver, proposal))
+		require.Nil(t, chain.Stats())
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -19,27 +20,43 @@ import (
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 )
 
 var allSelectorBytes []byte
 
 func init() {
 	buf := new(bytes.Buffer)
-	_ = dagcbor.Encoder(shared.AllSelector(), buf)
+	_ = dagcbor.Encoder(selectors.Entire(), buf)
 	allSelectorBytes = buf.Bytes()
 }
 
 // ValidationEnvironment contains the dependencies needed to validate deals
 type ValidationEnvironment interface {
-	GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, error)
-	// CheckDealParams verifies the given deal params are acceptable
-	CheckDealParams(pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount) error
+	// GetPiece finds the piece holding the given payload, and the miner whose sectors
+	// hold the piece
+	GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, address.Address, error)
+	// CheckDealParams verifies the given deal params are acceptable to the given miner
+	// for retrieving from the given piece, including the priority price if the deal
+	// asks for priority
+	CheckDealParams(miner address.Address, pieceInfo piecestore.PieceInfo, pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount, priority bool) error
+	// CheckPaymentDisabled verifies deals with payment disabled are accepted from the
+	// given peer, and that the params ask for nothing to be paid
+	CheckPaymentDisabled(receiver peer.ID, params retrievalmarket.Params) error
+	// CheckPaymentDefaults verifies a client that stopped paying for earlier deals
+	// may make a deal with the given unseal price
+	CheckPaymentDefaults(receiver peer.ID, unsealPrice abi.TokenAmount) error
 	// RunDealDecisioningLogic runs custom deal decision logic to decide if a deal is accepted, if present
 	RunDealDecisioningLogic(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error)
+	// Maintenance returns true if the provider is in maintenance and not accepting new
+	// deals, along with the epoch the maintenance ends at, or zero if it is not known
+	Maintenance(ctx context.Context) (bool, abi.ChainEpoch, error)
 	// StateMachines returns the FSM Group to begin tracking with
 	BeginTracking(pds retrievalmarket.ProviderDealState) error
 	// NextStoreID allocates a store for this deal
 	NextStoreID() (multistore.StoreID, error)
+	// Plugins returns the custom validation steps run on each proposal
+	Plugins() *PluginChain
 }
 
 // ProviderRequestValidator validates incoming requests for the Retrieval Provider
@@ -59,16 +76,20 @@ func (rv *ProviderRequestValidator) ValidatePush(sender peer.ID, voucher datatra
 
 // ValidatePull validates a pull request received from the peer that will receive data
 func (rv *ProviderRequestValidator) ValidatePull(receiver peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.VoucherResult, error) {
-	proposal, ok := voucher.(*retrievalmarket.DealProposal)
+	var proposal *retrievalmarket.DealProposal
 	var legacyProtocol bool
-	if !ok {
-		legacyProposal, ok := voucher.(*migrations.DealProposal0)
-		if !ok {
-			return nil, errors.New("wrong voucher type")
-		}
-		newProposal := migrations.MigrateDealProposal0To1(*legacyProposal)
+	switch v := voucher.(type) {
+	case *retrievalmarket.DealProposal:
+		proposal = v
+	case *migrations.DealProposal1:
+		newProposal := migrations.MigrateDealProposal1To2(*v)
+		proposal = &newProposal
+	case *migrations.DealProposal0:
+		newProposal := migrations.MigrateDealProposal0To1(*v)
 		proposal = &newProposal
 		legacyProtocol = true
+	default:
+		return nil, errors.New("wrong voucher type")
 	}
 	response, err := rv.validatePull(receiver, proposal, legacyProtocol, baseCid, selector)
 	if response == nil {
@@ -91,6 +112,9 @@ func (rv *ProviderRequestValidator) validatePull(receiver peer.ID, proposal *ret
 	if proposal.PayloadCID != baseCid {
 		return nil, errors.New("incorrect CID for this proposal")
 	}
+	if err := selectors.Validate(selector); err != nil {
+		return nil, err
+	}
 
 	buf := new(bytes.Buffer)
 	err := dagcbor.Encoder(selector, buf)
@@ -136,23 +160,20 @@ func (rv *ProviderRequestValidator) validatePull(receiver peer.ID, proposal *ret
 }
 
 func (rv *ProviderRequestValidator) acceptDeal(deal *retrievalmarket.ProviderDealState) (retrievalmarket.DealStatus, error) {
-	// check that the deal parameters match our required parameters or
-	// reject outright
-	err := rv.env.CheckDealParams(deal.PricePerByte, deal.PaymentInterval, deal.PaymentIntervalIncrease, deal.UnsealPrice)
-	if err != nil {
-		return retrievalmarket.DealStatusRejected, err
-	}
-
-	accepted, reason, err := rv.env.RunDealDecisioningLogic(context.TODO(), *deal)
+	inMaintenance, until, err := rv.env.Maintenance(context.TODO())
 	if err != nil {
 		return retrievalmarket.DealStatusErrored, err
 	}
-	if !accepted {
-		return retrievalmarket.DealStatusRejected, errors.New(reason)
+	if inMaintenance {
+		return retrievalmarket.DealStatusRejected, &shared.MaintenanceError{Until: until}
+	}
+
+	if err := rv.env.Plugins().ValidateProposal(deal.Receiver, deal.DealProposal); err != nil {
+		return retrievalmarket.DealStatusRejected, err
 	}
 
 	// verify we have the piece
-	pieceInfo, err := rv.env.GetPiece(deal.PayloadCID, deal.PieceCID)
+	pieceInfo, miner, err := rv.env.GetPiece(deal.PayloadCID, deal.PieceCID)
 	if err != nil {
 		if err == retrievalmarket.ErrNotFound {
 			return retrievalmarket.DealStatusDealNotFound, err
@@ -161,6 +182,36 @@ func (rv *ProviderRequestValidator) acceptDeal(deal *retrievalmarket.ProviderDea
 	}
 
 	deal.PieceInfo = &pieceInfo
+	deal.Miner = &miner
+
+	// check that the deal parameters match the required parameters of the miner
+	// holding the piece or reject outright. Deals without payment are not held to
+	// the miner's prices or the client's payment record, but only trusted peers may
+	// make them
+	if deal.PaymentDisabled {
+		err = rv.env.CheckPaymentDisabled(deal.Receiver, deal.Params)
+		if err != nil {
+			return retrievalmarket.DealStatusRejected, err
+		}
+	} else {
+		err = rv.env.CheckDealParams(miner, pieceInfo, deal.PricePerByte, deal.PaymentInterval, deal.PaymentIntervalIncrease, deal.UnsealPrice, deal.Priority)
+		if err != nil {
+			return retrievalmarket.DealStatusRejected, err
+		}
+
+		err = rv.env.CheckPaymentDefaults(deal.Receiver, deal.UnsealPrice)
+		if err != nil {
+			return retrievalmarket.DealStatusRejected, err
+		}
+	}
+
+	accepted, reason, err := rv.env.RunDealDecisioningLogic(context.TODO(), *deal)
+	if err != nil {
+		return retrievalmarket.DealStatusErrored, err
+	}
+	if !accepted {
+		return retrievalmarket.DealStatusRejected, errors.New(reason)
+	}
 
 	deal.StoreID, err = rv.env.NextStoreID()
 	if err != nil {
@@ -12,6 +12,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	"github.com/stretchr/testify/require"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -48,6 +49,20 @@ func TestValidatePull(t *testing.T) {
 			UnsealPrice:             proposal.UnsealPrice,
 		},
 	}
+	proposal1 := migrations.DealProposal1{
+		PayloadCID: proposal.PayloadCID,
+		ID:         proposal.ID,
+		Params: migrations.Params1{
+			Selector:                proposal.Selector,
+			PieceCID:                proposal.PieceCID,
+			PricePerByte:            proposal.PricePerByte,
+			PaymentInterval:         proposal.PaymentInterval,
+			PaymentIntervalIncrease: proposal.PaymentIntervalIncrease,
+			UnsealPrice:             proposal.UnsealPrice,
+		},
+	}
+	paymentDisabledProposal := proposal
+	paymentDisabledProposal.PaymentDisabled = true
 	testCases := map[string]struct {
 		fve                   fakeValidationEnvironment
 		sender                peer.ID
@@ -71,6 +86,21 @@ func TestValidatePull(t *testing.T) {
 			voucher:       &proposal,
 			expectedError: errors.New("incorrect selector for this proposal"),
 		},
+		"rejected by plugin": {
+			fve: fakeValidationEnvironment{
+				RunDealDecisioningLogicAccepted: true,
+				PluginChain:                     rejectingPlugins(&testPlugin{proposalErr: errors.New("no auth token")}),
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &proposal,
+			expectedError: errors.New("rejected by auth: no auth token"),
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status:  retrievalmarket.DealStatusRejected,
+				ID:      proposal.ID,
+				Message: "rejected by auth: no auth token",
+			},
+		},
 		"get piece other err": {
 			fve: fakeValidationEnvironment{
 				RunDealDecisioningLogicAccepted: true,
@@ -101,6 +131,21 @@ func TestValidatePull(t *testing.T) {
 				Message: retrievalmarket.ErrNotFound.Error(),
 			},
 		},
+		"provider in maintenance": {
+			fve: fakeValidationEnvironment{
+				InMaintenance:    true,
+				MaintenanceUntil: abi.ChainEpoch(120),
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &proposal,
+			expectedError: errors.New("provider is in maintenance until epoch 120"),
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status:  retrievalmarket.DealStatusRejected,
+				ID:      proposal.ID,
+				Message: "provider is in maintenance until epoch 120",
+			},
+		},
 		"check deal params err": {
 			fve: fakeValidationEnvironment{
 				CheckDealParamsError: errors.New("something went wrong"),
@@ -115,6 +160,48 @@ func TestValidatePull(t *testing.T) {
 				Message: "something went wrong",
 			},
 		},
+		"repeat payment defaulter": {
+			fve: fakeValidationEnvironment{
+				CheckPaymentDefaultsError: errors.New("client stopped paying for 3 earlier deals"),
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &proposal,
+			expectedError: errors.New("client stopped paying for 3 earlier deals"),
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status:  retrievalmarket.DealStatusRejected,
+				ID:      proposal.ID,
+				Message: "client stopped paying for 3 earlier deals",
+			},
+		},
+		"payment disabled from untrusted peer": {
+			fve: fakeValidationEnvironment{
+				CheckPaymentDisabledError: errors.New("Deals with payment disabled not accepted"),
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &paymentDisabledProposal,
+			expectedError: errors.New("Deals with payment disabled not accepted"),
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status:  retrievalmarket.DealStatusRejected,
+				ID:      proposal.ID,
+				Message: "Deals with payment disabled not accepted",
+			},
+		},
+		"payment disabled skips deal params": {
+			fve: fakeValidationEnvironment{
+				CheckDealParamsError:            errors.New("Price per byte too low"),
+				RunDealDecisioningLogicAccepted: true,
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &paymentDisabledProposal,
+			expectedError: datatransfer.ErrPause,
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status: retrievalmarket.DealStatusAccepted,
+				ID:     proposal.ID,
+			},
+		},
 		"run deal decioning error": {
 			fve: fakeValidationEnvironment{
 				RunDealDecisioningLogicError: errors.New("something went wrong"),
@@ -181,6 +268,19 @@ func TestValidatePull(t *testing.T) {
 				ID:     proposal.ID,
 			},
 		},
+		"success, version 1 proposal": {
+			fve: fakeValidationEnvironment{
+				RunDealDecisioningLogicAccepted: true,
+			},
+			baseCid:       proposal.PayloadCID,
+			selector:      shared.AllSelector(),
+			voucher:       &proposal1,
+			expectedError: datatransfer.ErrPause,
+			expectedVoucherResult: &retrievalmarket.DealResponse{
+				Status: retrievalmarket.DealStatusAccepted,
+				ID:     proposal.ID,
+			},
+		},
 		"success, legacyProposal": {
 			fve: fakeValidationEnvironment{
 				RunDealDecisioningLogicAccepted: true,
@@ -212,30 +312,48 @@ func TestValidatePull(t *testing.T) {
 
 type fakeValidationEnvironment struct {
 	PieceInfo                         piecestore.PieceInfo
+	Miner                             address.Address
 	GetPieceErr                       error
 	CheckDealParamsError              error
+	CheckPaymentDisabledError         error
+	CheckPaymentDefaultsError         error
 	RunDealDecisioningLogicAccepted   bool
 	RunDealDecisioningLogicFailReason string
 	RunDealDecisioningLogicError      error
 	BeginTrackingError                error
 	NextStoreIDValue                  multistore.StoreID
 	NextStoreIDError                  error
+	InMaintenance                     bool
+	MaintenanceUntil                  abi.ChainEpoch
+	PluginChain                       *requestvalidation.PluginChain
 }
 
-func (fve *fakeValidationEnvironment) GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, error) {
-	return fve.PieceInfo, fve.GetPieceErr
+func (fve *fakeValidationEnvironment) GetPiece(c cid.Cid, pieceCID *cid.Cid) (piecestore.PieceInfo, address.Address, error) {
+	return fve.PieceInfo, fve.Miner, fve.GetPieceErr
 }
 
 // CheckDealParams verifies the given deal params are acceptable
-func (fve *fakeValidationEnvironment) CheckDealParams(pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount) error {
+func (fve *fakeValidationEnvironment) CheckDealParams(miner address.Address, pieceInfo piecestore.PieceInfo, pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64, unsealPrice abi.TokenAmount, priority bool) error {
 	return fve.CheckDealParamsError
 }
 
+func (fve *fakeValidationEnvironment) CheckPaymentDisabled(receiver peer.ID, params retrievalmarket.Params) error {
+	return fve.CheckPaymentDisabledError
+}
+
+func (fve *fakeValidationEnvironment) CheckPaymentDefaults(receiver peer.ID, unsealPrice abi.TokenAmount) error {
+	return fve.CheckPaymentDefaultsError
+}
+
 // RunDealDecisioningLogic runs custom deal decision logic to decide if a deal is accepted, if present
 func (fve *fakeValidationEnvironment) RunDealDecisioningLogic(ctx context.Context, state retrievalmarket.ProviderDealState) (bool, string, error) {
 	return fve.RunDealDecisioningLogicAccepted, fve.RunDealDecisioningLogicFailReason, fve.RunDealDecisioningLogicError
 }
 
+func (fve *fakeValidationEnvironment) Maintenance(ctx context.Context) (bool, abi.ChainEpoch, error) {
+	return fve.InMaintenance, fve.MaintenanceUntil, nil
+}
+
 // StateMachines returns the FSM Group to begin tracking with
 func (fve *fakeValidationEnvironment) BeginTracking(pds retrievalmarket.ProviderDealState) error {
 	return fve.BeginTrackingError
@@ -244,3 +362,13 @@ func (fve *fakeValidationEnvironment) BeginTracking(pds retrievalmarket.Provider
 func (fve *fakeValidationEnvironment) NextStoreID() (multistore.StoreID, error) {
 	return fve.NextStoreIDValue, fve.NextStoreIDError
 }
+
+func (fve *fakeValidationEnvironment) Plugins() *requestvalidation.PluginChain {
+	return fve.PluginChain
+}
+
+func rejectingPlugins(plugin requestvalidation.Plugin) *requestvalidation.PluginChain {
+	chain := requestvalidation.NewPluginChain()
+	chain.Register("auth", plugin)
+	return chain
+}
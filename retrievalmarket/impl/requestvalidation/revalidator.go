@@ -20,6 +20,10 @@ type RevalidatorEnvironment interface {
 	Node() rm.RetrievalProviderNode
 	SendEvent(dealID rm.ProviderDealIdentifier, evt rm.ProviderEvent, args ...interface{}) error
 	Get(dealID rm.ProviderDealIdentifier) (rm.ProviderDealState, error)
+	AllowDeferredPayments() bool
+	MaxUnpaidBytes() uint64
+	// Plugins returns the custom validation steps run on each payment
+	Plugins() *PluginChain
 }
 
 type channelData struct {
@@ -27,9 +31,13 @@ type channelData struct {
 	totalSent      uint64
 	totalPaidFor   uint64
 	interval       uint64
+	batch          uint64
 	pricePerByte   abi.TokenAmount
 	reload         bool
 	legacyProtocol bool
+	escrow         bool
+	// paymentDisabled is set for deals that are never asked for payment
+	paymentDisabled bool
 }
 
 // ProviderRevalidator defines data transfer revalidation logic in the context of
@@ -68,6 +76,19 @@ func (pr *ProviderRevalidator) UntrackChannel(deal rm.ProviderDealState) {
 	delete(pr.trackedChannels, deal.ChannelID)
 }
 
+// TotalSent returns the number of bytes sent so far on a tracked channel, which may
+// be ahead of the total last recorded in the deal's state, or false if the channel
+// is not tracked
+func (pr *ProviderRevalidator) TotalSent(chid datatransfer.ChannelID) (uint64, bool) {
+	pr.trackedChannelsLk.RLock()
+	defer pr.trackedChannelsLk.RUnlock()
+	channel, ok := pr.trackedChannels[chid]
+	if !ok {
+		return 0, false
+	}
+	return channel.totalSent, true
+}
+
 func (pr *ProviderRevalidator) loadDealState(channel *channelData) error {
 	if !channel.reload {
 		return nil
@@ -84,10 +105,46 @@ func (pr *ProviderRevalidator) loadDealState(channel *channelData) error {
 func (pr *ProviderRevalidator) writeDealState(deal rm.ProviderDealState) {
 	channel := pr.trackedChannels[deal.ChannelID]
 	channel.totalSent = deal.TotalSent
+	channel.legacyProtocol = deal.LegacyProtocol
+	channel.paymentDisabled = deal.PaymentDisabled
+	if channel.paymentDisabled {
+		// nothing is paid for, and there is no price to divide by
+		return
+	}
 	channel.totalPaidFor = big.Div(big.Max(big.Sub(deal.FundsReceived, deal.UnsealPrice), big.Zero()), deal.PricePerByte).Uint64()
 	channel.interval = deal.CurrentInterval
+	channel.batch = deal.PaymentBatch
 	channel.pricePerByte = deal.PricePerByte
-	channel.legacyProtocol = deal.LegacyProtocol
+	channel.escrow = deal.EscrowFinalPayment
+}
+
+// escrowedBytes returns the number of bytes the provider will send beyond what
+// has been paid for without pausing. When the final payment is escrowed, the client
+// is extended one payment interval of credit, so that the transfer can finish
+// before the final voucher is sent
+func escrowedBytes(escrow bool, interval uint64) uint64 {
+	if !escrow {
+		return 0
+	}
+	return interval
+}
+
+// unpaidBytesAllowed returns the number of bytes the provider sends beyond what has
+// been paid for before asking for payment. A client that batches payments is sent
+// several intervals at once, up to the provider's limit on unpaid bytes, but never
+// less than one interval
+func (pr *ProviderRevalidator) unpaidBytesAllowed(channel *channelData) uint64 {
+	if channel.batch <= 1 {
+		return channel.interval
+	}
+	allowed := channel.interval * channel.batch
+	if limit := pr.env.MaxUnpaidBytes(); allowed > limit {
+		allowed = limit
+	}
+	if allowed < channel.interval {
+		return channel.interval
+	}
+	return allowed
 }
 
 // Revalidate revalidates a request with a new voucher
@@ -98,6 +155,9 @@ func (pr *ProviderRevalidator) Revalidate(channelID datatransfer.ChannelID, vouc
 	if !ok {
 		return nil, nil
 	}
+	if channel.paymentDisabled {
+		return nil, errors.New("deal has payment disabled")
+	}
 
 	// read payment, or fail
 	payment, ok := voucher.(*rm.DealPayment)
@@ -132,10 +192,21 @@ func (pr *ProviderRevalidator) processPayment(dealID rm.ProviderDealIdentifier,
 		return errorDealResponse(dealID, err), err
 	}
 
+	if err := pr.env.Plugins().ValidatePayment(deal, *payment); err != nil {
+		return errorDealResponse(dealID, err), err
+	}
+
 	// attempt to redeem voucher
 	// (totalSent * pricePerByte + unsealPrice) - fundsReceived
 	paymentOwed := big.Sub(big.Add(big.Mul(abi.NewTokenAmount(int64(deal.TotalSent)), deal.PricePerByte), deal.UnsealPrice), deal.FundsReceived)
-	received, err := pr.env.Node().SavePaymentVoucher(context.TODO(), payment.PaymentChannel, payment.PaymentVoucher, nil, paymentOwed, tok)
+	// the escrowed interval is only owed with the last payment
+	if deal.Status == rm.DealStatusFundsNeeded {
+		escrowed := big.Mul(abi.NewTokenAmount(int64(escrowedBytes(deal.EscrowFinalPayment, deal.CurrentInterval))), deal.PricePerByte)
+		paymentOwed = big.Max(big.Sub(paymentOwed, escrowed), big.Zero())
+	}
+	// a payment short by no more than can be deferred must still be saved
+	minimum := big.Max(big.Sub(paymentOwed, pr.maxDeferred(deal)), big.Zero())
+	received, err := pr.env.Node().SavePaymentVoucher(context.TODO(), payment.PaymentChannel, payment.PaymentVoucher, nil, minimum, tok)
 	if err != nil {
 		_ = pr.env.SendEvent(dealID, rm.ProviderEventSaveVoucherFailed, err)
 		return errorDealResponse(dealID, err), err
@@ -149,7 +220,7 @@ func (pr *ProviderRevalidator) processPayment(dealID rm.ProviderDealIdentifier,
 	}
 
 	// check if all payments are received to continue the deal, or send updated required payment
-	if received.LessThan(paymentOwed) {
+	if received.LessThan(paymentOwed) && !pr.canDefer(deal, received, paymentOwed) {
 		_ = pr.env.SendEvent(dealID, rm.ProviderEventPartialPaymentReceived, received)
 		return &rm.DealResponse{
 			ID:          deal.ID,
@@ -169,6 +240,27 @@ func (pr *ProviderRevalidator) processPayment(dealID rm.ProviderDealIdentifier,
 	return nil, nil
 }
 
+// canDefer returns true if the rest of a partial payment can be deferred to the
+// next payment, so the transfer carries on. At most one interval's worth can be
+// deferred, and never the last payment
+func (pr *ProviderRevalidator) canDefer(deal rm.ProviderDealState, received abi.TokenAmount, paymentOwed abi.TokenAmount) bool {
+	if !received.GreaterThan(big.Zero()) {
+		return false
+	}
+	maxDeferred := pr.maxDeferred(deal)
+	return maxDeferred.GreaterThan(big.Zero()) && big.Sub(paymentOwed, received).LessThanEqual(maxDeferred)
+}
+
+// maxDeferred returns how much of the payment owed for a deal can be deferred to its
+// next payment: one interval's worth, or nothing for the last payment or if the
+// provider does not allow deferred payments
+func (pr *ProviderRevalidator) maxDeferred(deal rm.ProviderDealState) abi.TokenAmount {
+	if deal.Status != rm.DealStatusFundsNeeded || !pr.env.AllowDeferredPayments() {
+		return big.Zero()
+	}
+	return big.Mul(abi.NewTokenAmount(int64(deal.CurrentInterval)), deal.PricePerByte)
+}
+
 func errorDealResponse(dealID rm.ProviderDealIdentifier, err error) *rm.DealResponse {
 	return &rm.DealResponse{
 		ID:      dealID.DealID,
@@ -195,8 +287,9 @@ func (pr *ProviderRevalidator) OnPullDataSent(chid datatransfer.ChannelID, addit
 	}
 
 	channel.totalSent += additionalBytesSent
-	if channel.totalSent-channel.totalPaidFor >= channel.interval {
-		paymentOwed := big.Mul(abi.NewTokenAmount(int64(channel.totalSent-channel.totalPaidFor)), channel.pricePerByte)
+	escrowed := escrowedBytes(channel.escrow, channel.interval)
+	if !channel.paymentDisabled && channel.totalSent-channel.totalPaidFor >= pr.unpaidBytesAllowed(channel)+escrowed {
+		paymentOwed := big.Mul(abi.NewTokenAmount(int64(channel.totalSent-channel.totalPaidFor-escrowed)), channel.pricePerByte)
 		err := pr.env.SendEvent(channel.dealID, rm.ProviderEventPaymentRequested, channel.totalSent)
 		if err != nil {
 			return true, nil, err
@@ -240,6 +333,12 @@ func (pr *ProviderRevalidator) OnComplete(chid datatransfer.ChannelID) (bool, da
 		return true, nil, err
 	}
 
+	if channel.paymentDisabled {
+		return true, finalResponse(&rm.DealResponse{
+			ID:     channel.dealID.DealID,
+			Status: rm.DealStatusCompleted,
+		}, channel.legacyProtocol), nil
+	}
 	paymentOwed := big.Mul(abi.NewTokenAmount(int64(channel.totalSent-channel.totalPaidFor)), channel.pricePerByte)
 	if paymentOwed.Equals(big.Zero()) {
 		return true, finalResponse(&rm.DealResponse{
@@ -34,8 +34,14 @@ func TestOnPullDataSent(t *testing.T) {
 	deal := *makeDealState(rm.DealStatusOngoing)
 	legacyDeal := deal
 	legacyDeal.LegacyProtocol = true
+	batchedDeal := deal
+	batchedDeal.PaymentBatch = 3
+	paymentDisabledDeal := deal
+	paymentDisabledDeal.PaymentDisabled = true
+	paymentDisabledDeal.PricePerByte = big.Zero()
 	testCases := map[string]struct {
 		noSend          bool
+		maxUnpaidBytes  uint64
 		expectedID      rm.ProviderDealIdentifier
 		expectedEvent   rm.ProviderEvent
 		expectedArgs    []interface{}
@@ -90,14 +96,66 @@ func TestOnPullDataSent(t *testing.T) {
 			},
 			expectedHandled: true,
 		},
+		"batched payment not yet due": {
+			deal:            batchedDeal,
+			channelID:       batchedDeal.ChannelID,
+			maxUnpaidBytes:  10 * defaultCurrentInterval,
+			expectedID:      batchedDeal.Identifier(),
+			expectedEvent:   rm.ProviderEventBlockSent,
+			expectedArgs:    []interface{}{batchedDeal.TotalSent + 2*defaultCurrentInterval},
+			dataAmount:      2 * defaultCurrentInterval,
+			expectedHandled: true,
+		},
+		"request batched payment": {
+			deal:           batchedDeal,
+			channelID:      batchedDeal.ChannelID,
+			maxUnpaidBytes: 10 * defaultCurrentInterval,
+			expectedID:     batchedDeal.Identifier(),
+			expectedEvent:  rm.ProviderEventPaymentRequested,
+			expectedArgs:   []interface{}{batchedDeal.TotalSent + 3*defaultCurrentInterval},
+			dataAmount:     3 * defaultCurrentInterval,
+			expectedError:  datatransfer.ErrPause,
+			expectedResult: &rm.DealResponse{
+				ID:          batchedDeal.ID,
+				Status:      rm.DealStatusFundsNeeded,
+				PaymentOwed: big.Mul(defaultPaymentPerInterval, big.NewInt(3)),
+			},
+			expectedHandled: true,
+		},
+		"batched payment limited by max unpaid bytes": {
+			deal:           batchedDeal,
+			channelID:      batchedDeal.ChannelID,
+			maxUnpaidBytes: 2 * defaultCurrentInterval,
+			expectedID:     batchedDeal.Identifier(),
+			expectedEvent:  rm.ProviderEventPaymentRequested,
+			expectedArgs:   []interface{}{batchedDeal.TotalSent + 2*defaultCurrentInterval},
+			dataAmount:     2 * defaultCurrentInterval,
+			expectedError:  datatransfer.ErrPause,
+			expectedResult: &rm.DealResponse{
+				ID:          batchedDeal.ID,
+				Status:      rm.DealStatusFundsNeeded,
+				PaymentOwed: big.Mul(defaultPaymentPerInterval, big.NewInt(2)),
+			},
+			expectedHandled: true,
+		},
+		"payment disabled never requests payment": {
+			deal:            paymentDisabledDeal,
+			channelID:       paymentDisabledDeal.ChannelID,
+			expectedID:      paymentDisabledDeal.Identifier(),
+			expectedEvent:   rm.ProviderEventBlockSent,
+			expectedArgs:    []interface{}{paymentDisabledDeal.TotalSent + 10*defaultCurrentInterval},
+			dataAmount:      10 * defaultCurrentInterval,
+			expectedHandled: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
 			tn := testnodes.NewTestRetrievalProviderNode()
 			fre := &fakeRevalidatorEnvironment{
-				node:         tn,
-				returnedDeal: data.deal,
-				getError:     nil,
+				node:           tn,
+				returnedDeal:   data.deal,
+				getError:       nil,
+				maxUnpaidBytes: data.maxUnpaidBytes,
 			}
 			revalidator := requestvalidation.NewProviderRevalidator(fre)
 			revalidator.TrackChannel(data.deal)
@@ -127,6 +185,9 @@ func TestOnComplete(t *testing.T) {
 	deal := *makeDealState(rm.DealStatusOngoing)
 	legacyDeal := deal
 	legacyDeal.LegacyProtocol = true
+	paymentDisabledDeal := deal
+	paymentDisabledDeal.PaymentDisabled = true
+	paymentDisabledDeal.PricePerByte = big.Zero()
 	channelID := deal.ChannelID
 	testCases := map[string]struct {
 		expectedEvents []eventSent
@@ -230,6 +291,26 @@ func TestOnComplete(t *testing.T) {
 			deal:      legacyDeal,
 			channelID: channelID,
 		},
+		"payment disabled": {
+			unpaidAmount: uint64(500),
+			expectedEvents: []eventSent{
+				{
+					ID:    deal.Identifier(),
+					Event: rm.ProviderEventBlockSent,
+					Args:  []interface{}{deal.TotalSent + 500},
+				},
+				{
+					ID:    deal.Identifier(),
+					Event: rm.ProviderEventBlocksCompleted,
+				},
+			},
+			expectedResult: &rm.DealResponse{
+				ID:     deal.ID,
+				Status: rm.DealStatusCompleted,
+			},
+			deal:      paymentDisabledDeal,
+			channelID: channelID,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -276,18 +357,23 @@ func TestRevalidate(t *testing.T) {
 	}
 	lastPaymentDeal := deal
 	lastPaymentDeal.Status = rm.DealStatusFundsNeededLastPayment
+	paymentDisabledDeal := deal
+	paymentDisabledDeal.PaymentDisabled = true
+	paymentDisabledDeal.PricePerByte = big.Zero()
 	testCases := map[string]struct {
-		configureTestNode func(tn *testnodes.TestRetrievalProviderNode)
-		noSend            bool
-		expectedID        rm.ProviderDealIdentifier
-		expectedEvent     rm.ProviderEvent
-		expectedArgs      []interface{}
-		getError          error
-		deal              rm.ProviderDealState
-		channelID         datatransfer.ChannelID
-		voucher           datatransfer.Voucher
-		expectedResult    datatransfer.VoucherResult
-		expectedError     error
+		configureTestNode     func(tn *testnodes.TestRetrievalProviderNode)
+		noSend                bool
+		expectedID            rm.ProviderDealIdentifier
+		expectedEvent         rm.ProviderEvent
+		expectedArgs          []interface{}
+		getError              error
+		allowDeferredPayments bool
+		plugins               *requestvalidation.PluginChain
+		deal                  rm.ProviderDealState
+		channelID             datatransfer.ChannelID
+		voucher               datatransfer.Voucher
+		expectedResult        datatransfer.VoucherResult
+		expectedError         error
 	}{
 		"not tracked": {
 			deal:      deal,
@@ -300,6 +386,13 @@ func TestRevalidate(t *testing.T) {
 			noSend:        true,
 			expectedError: errors.New("wrong voucher type"),
 		},
+		"payment disabled": {
+			deal:          paymentDisabledDeal,
+			channelID:     paymentDisabledDeal.ChannelID,
+			voucher:       payment,
+			noSend:        true,
+			expectedError: errors.New("deal has payment disabled"),
+		},
 		"error getting chain head": {
 			configureTestNode: func(tn *testnodes.TestRetrievalProviderNode) {
 				tn.ChainHeadError = errors.New("something went wrong")
@@ -334,6 +427,19 @@ func TestRevalidate(t *testing.T) {
 				Message: "something went wrong",
 			},
 		},
+		"rejected by plugin": {
+			deal:          deal,
+			channelID:     deal.ChannelID,
+			voucher:       payment,
+			plugins:       rejectingPlugins(&testPlugin{paymentErr: errors.New("token expired")}),
+			noSend:        true,
+			expectedError: errors.New("rejected by auth: token expired"),
+			expectedResult: &rm.DealResponse{
+				ID:      deal.ID,
+				Status:  rm.DealStatusErrored,
+				Message: "rejected by auth: token expired",
+			},
+		},
 		"payment voucher error": {
 			configureTestNode: func(tn *testnodes.TestRetrievalProviderNode) {
 				_ = tn.ExpectVoucher(payCh, voucher, nil, defaultPaymentPerInterval, abi.NewTokenAmount(0), errors.New("your money's no good here"))
@@ -385,6 +491,37 @@ func TestRevalidate(t *testing.T) {
 				PaymentOwed: big.Sub(defaultPaymentPerInterval, smallerPayment),
 			},
 		},
+		"not enough funds send, rest deferred": {
+			configureTestNode: func(tn *testnodes.TestRetrievalProviderNode) {
+				// up to an interval's worth can be deferred, so any amount is saved
+				_ = tn.ExpectVoucher(payCh, voucher, nil, big.Zero(), smallerPayment, nil)
+			},
+			allowDeferredPayments: true,
+			deal:                  deal,
+			channelID:             deal.ChannelID,
+			voucher:               payment,
+			expectedID:            deal.Identifier(),
+			expectedEvent:         rm.ProviderEventPaymentReceived,
+			expectedArgs:          []interface{}{smallerPayment},
+		},
+		"not enough funds send, last payment not deferred": {
+			configureTestNode: func(tn *testnodes.TestRetrievalProviderNode) {
+				_ = tn.ExpectVoucher(payCh, voucher, nil, defaultPaymentPerInterval, smallerPayment, nil)
+			},
+			allowDeferredPayments: true,
+			deal:                  lastPaymentDeal,
+			channelID:             deal.ChannelID,
+			voucher:               payment,
+			expectedError:         datatransfer.ErrPause,
+			expectedID:            deal.Identifier(),
+			expectedEvent:         rm.ProviderEventPartialPaymentReceived,
+			expectedArgs:          []interface{}{smallerPayment},
+			expectedResult: &rm.DealResponse{
+				ID:          deal.ID,
+				Status:      lastPaymentDeal.Status,
+				PaymentOwed: big.Sub(defaultPaymentPerInterval, smallerPayment),
+			},
+		},
 		"not enough funds send, legacyPayment": {
 			configureTestNode: func(tn *testnodes.TestRetrievalProviderNode) {
 				_ = tn.ExpectVoucher(payCh, voucher, nil, defaultPaymentPerInterval, smallerPayment, nil)
@@ -463,9 +600,11 @@ func TestRevalidate(t *testing.T) {
 				data.configureTestNode(tn)
 			}
 			fre := &fakeRevalidatorEnvironment{
-				node:         tn,
-				returnedDeal: data.deal,
-				getError:     data.getError,
+				node:                  tn,
+				returnedDeal:          data.deal,
+				getError:              data.getError,
+				allowDeferredPayments: data.allowDeferredPayments,
+				plugins:               data.plugins,
 			}
 			revalidator := requestvalidation.NewProviderRevalidator(fre)
 			revalidator.TrackChannel(data.deal)
@@ -497,11 +636,14 @@ type eventSent struct {
 	Args  []interface{}
 }
 type fakeRevalidatorEnvironment struct {
-	node           rm.RetrievalProviderNode
-	sentEvents     []eventSent
-	sendEventError error
-	returnedDeal   rm.ProviderDealState
-	getError       error
+	node                  rm.RetrievalProviderNode
+	sentEvents            []eventSent
+	sendEventError        error
+	returnedDeal          rm.ProviderDealState
+	getError              error
+	allowDeferredPayments bool
+	maxUnpaidBytes        uint64
+	plugins               *requestvalidation.PluginChain
 }
 
 func (fre *fakeRevalidatorEnvironment) Node() rm.RetrievalProviderNode {
@@ -517,6 +659,18 @@ func (fre *fakeRevalidatorEnvironment) Get(dealID rm.ProviderDealIdentifier) (rm
 	return fre.returnedDeal, fre.getError
 }
 
+func (fre *fakeRevalidatorEnvironment) AllowDeferredPayments() bool {
+	return fre.allowDeferredPayments
+}
+
+func (fre *fakeRevalidatorEnvironment) MaxUnpaidBytes() uint64 {
+	return fre.maxUnpaidBytes
+}
+
+func (fre *fakeRevalidatorEnvironment) Plugins() *requestvalidation.PluginChain {
+	return fre.plugins
+}
+
 var dealID = retrievalmarket.DealID(10)
 var defaultCurrentInterval = uint64(1000)
 var defaultIntervalIncrease = uint64(500)
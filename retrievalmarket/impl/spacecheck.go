@@ -0,0 +1,79 @@
+package retrievalimpl
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/spacecheck"
+)
+
+// maxQuotes is the number of payload sizes quoted by providers that the client
+// remembers, for checking disk space when it retrieves the payloads
+const maxQuotes = 1024
+
+// CheckDiskSpace makes the client check that the volumes holding the given paths,
+// such as the multistore directory, have room for a payload, plus margin bytes,
+// before retrieving it into a store. The size checked is the one the provider quoted
+// when the client last queried it for the payload, so retrievals from providers the
+// client has not queried are not checked, nor are retrievals streamed to a CAR. See
+// the spacecheck package for reserving space and confirming retrievals that do not
+// fit
+func CheckDiskSpace(paths []string, margin uint64, options ...spacecheck.Option) RetrievalClientOption {
+	return func(c *Client) {
+		c.spaceChecker = spacecheck.New(paths, margin, options...)
+		c.quotes = make(map[quoteKey]uint64)
+	}
+}
+
+type quoteKey struct {
+	p       peer.ID
+	payload cid.Cid
+}
+
+// recordQuote remembers the payload size a provider quoted, dropping the oldest
+// quote once maxQuotes are remembered
+func (c *Client) recordQuote(p peer.ID, payloadCID cid.Cid, resp retrievalmarket.QueryResponse) {
+	if c.spaceChecker == nil || resp.Status != retrievalmarket.QueryResponseAvailable {
+		return
+	}
+	key := quoteKey{p, payloadCID}
+	c.quotesLk.Lock()
+	defer c.quotesLk.Unlock()
+	if _, ok := c.quotes[key]; !ok {
+		if len(c.quoteOrder) >= maxQuotes {
+			delete(c.quotes, c.quoteOrder[0])
+			c.quoteOrder = c.quoteOrder[1:]
+		}
+		c.quoteOrder = append(c.quoteOrder, key)
+	}
+	c.quotes[key] = resp.Size
+}
+
+// checkDiskSpace checks that a retrieval fits on disk, if the provider quoted its
+// size
+func (c *Client) checkDiskSpace(dealID retrievalmarket.DealID, p peer.ID, payloadCID cid.Cid) error {
+	if c.spaceChecker == nil {
+		return nil
+	}
+	c.quotesLk.Lock()
+	size, ok := c.quotes[quoteKey{p, payloadCID}]
+	c.quotesLk.Unlock()
+	if !ok {
+		return nil
+	}
+	return c.spaceChecker.Check(dealID, size)
+}
+
+// trackDiskSpace releases the space reserved for a retrieval as its data arrives,
+// and once it finishes
+func (c *Client) trackDiskSpace(ds retrievalmarket.ClientDealState, finished bool) {
+	if c.spaceChecker == nil {
+		return
+	}
+	if finished {
+		c.spaceChecker.Release(ds.ID)
+		return
+	}
+	c.spaceChecker.Progress(ds.ID, ds.TotalReceived)
+}
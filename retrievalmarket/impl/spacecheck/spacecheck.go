@@ -0,0 +1,177 @@
+/*
+Package spacecheck checks that a retrieval client has room on disk for a payload
+before it starts retrieving it, so that a paid retrieval does not run the disk out
+of space halfway through.
+
+A Checker is given the volumes the client's stores are on. Before a retrieval
+starts, it checks that each volume has room for the size the provider quoted for the
+payload, plus a safety margin. A retrieval that does not fit fails straight away
+with ErrInsufficientSpace, unless a ConfirmFunc decides to go ahead anyway, such as
+after prompting the user.
+
+With ReserveSpace, the space each retrieval still needs is set aside until the
+retrieval finishes, and the checks for later retrievals count it as taken, so that
+retrievals running at the same time cannot each be let through on the same free
+space. Reservations are kept in memory and only cover this client's retrievals.
+*/
+package spacecheck
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/freespace"
+)
+
+// ErrInsufficientSpace is returned for a retrieval that does not fit on disk
+var ErrInsufficientSpace = errors.New("not enough disk space for retrieval")
+
+// FreeSpaceFunc returns the number of bytes free for unprivileged users on the volume
+// holding path
+type FreeSpaceFunc func(path string) (uint64, error)
+
+// Shortfall describes a retrieval that does not fit on a volume
+type Shortfall struct {
+	Path string
+	// Free is the space free on the volume, and Reserved the part of it set aside
+	// for other retrievals
+	Free     uint64
+	Reserved uint64
+	// Needed is the space the retrieval needs, including the margin
+	Needed uint64
+}
+
+// ConfirmFunc decides whether a retrieval that does not fit on disk goes ahead
+// anyway
+type ConfirmFunc func(deal retrievalmarket.DealID, shortfall Shortfall) bool
+
+type reservation struct {
+	size     uint64
+	received uint64
+}
+
+// outstanding returns the space the retrieval still needs
+func (r reservation) outstanding() uint64 {
+	if r.received >= r.size {
+		return 0
+	}
+	return r.size - r.received
+}
+
+// Checker checks that retrievals fit on the volumes holding the client's stores
+type Checker struct {
+	paths     []string
+	margin    uint64
+	freeSpace FreeSpaceFunc
+	reserve   bool
+	confirm   ConfirmFunc
+
+	lk           sync.Mutex
+	reservations map[retrievalmarket.DealID]reservation
+}
+
+// Option configures a Checker
+type Option func(c *Checker)
+
+// FreeSpace sets how the free space on a volume is read
+func FreeSpace(freeSpace FreeSpaceFunc) Option {
+	return func(c *Checker) {
+		c.freeSpace = freeSpace
+	}
+}
+
+// ReserveSpace sets aside the space each retrieval still needs until it finishes
+func ReserveSpace() Option {
+	return func(c *Checker) {
+		c.reserve = true
+	}
+}
+
+// Confirm sets the function that decides whether retrievals that do not fit go
+// ahead anyway. Without one, they fail
+func Confirm(confirm ConfirmFunc) Option {
+	return func(c *Checker) {
+		c.confirm = confirm
+	}
+}
+
+// New returns a Checker for the volumes holding the given paths, which keeps margin
+// bytes free on each on top of the space retrievals need
+func New(paths []string, margin uint64, options ...Option) *Checker {
+	c := &Checker{
+		paths:        paths,
+		margin:       margin,
+		freeSpace:    freespace.Available,
+		reservations: make(map[retrievalmarket.DealID]reservation),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Check checks that a retrieval of size bytes fits on every volume, and reserves
+// the space for it if the Checker reserves space. A volume that cannot be checked
+// fails the check
+func (c *Checker) Check(deal retrievalmarket.DealID, size uint64) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	reserved := c.reserved()
+	needed := size + c.margin
+	for _, path := range c.paths {
+		free, err := c.freeSpace(path)
+		if err != nil {
+			return xerrors.Errorf("checking free space at %s: %w", path, err)
+		}
+		if free >= reserved && free-reserved >= needed {
+			continue
+		}
+		shortfall := Shortfall{Path: path, Free: free, Reserved: reserved, Needed: needed}
+		if c.confirm != nil && c.confirm(deal, shortfall) {
+			continue
+		}
+		return fmt.Errorf("%w: %s has %d bytes free, %d of them reserved, and retrieval needs %d", ErrInsufficientSpace, path, free, reserved, needed)
+	}
+	if c.reserve {
+		c.reservations[deal] = reservation{size: size}
+	}
+	return nil
+}
+
+// Progress records that a retrieval has received the given number of bytes, which
+// no longer need to be reserved for it
+func (c *Checker) Progress(deal retrievalmarket.DealID, received uint64) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if r, ok := c.reservations[deal]; ok {
+		r.received = received
+		c.reservations[deal] = r
+	}
+}
+
+// Release releases the space reserved for a retrieval, once it finishes
+func (c *Checker) Release(deal retrievalmarket.DealID) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	delete(c.reservations, deal)
+}
+
+// Reserved returns the space reserved for retrievals in progress
+func (c *Checker) Reserved() uint64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.reserved()
+}
+
+func (c *Checker) reserved() uint64 {
+	var total uint64
+	for _, r := range c.reservations {
+		total += r.outstanding()
+	}
+	return total
+}
@@ -0,0 +1,67 @@
+package spacecheck_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/spacecheck"
+)
+
+func TestChecker(t *testing.T) {
+	free := map[string]uint64{"/stores": 1000, "/extract": 5000}
+	freeSpace := func(path string) (uint64, error) {
+		return free[path], nil
+	}
+	paths := []string{"/stores", "/extract"}
+
+	t.Run("fails retrievals that do not fit", func(t *testing.T) {
+		c := spacecheck.New(paths, 100, spacecheck.FreeSpace(freeSpace))
+		require.NoError(t, c.Check(1, 900))
+		err := c.Check(2, 901)
+		require.True(t, errors.Is(err, spacecheck.ErrInsufficientSpace))
+		require.EqualError(t, err, "not enough disk space for retrieval: /stores has 1000 bytes free, 0 of them reserved, and retrieval needs 1001")
+		// without reservations, every retrieval is checked against all the free space
+		require.NoError(t, c.Check(3, 900))
+		require.Zero(t, c.Reserved())
+	})
+
+	t.Run("reserves space until retrievals finish", func(t *testing.T) {
+		c := spacecheck.New(paths, 0, spacecheck.FreeSpace(freeSpace), spacecheck.ReserveSpace())
+		require.NoError(t, c.Check(1, 600))
+		require.Equal(t, uint64(600), c.Reserved())
+		require.True(t, errors.Is(c.Check(2, 600), spacecheck.ErrInsufficientSpace))
+
+		// data received is taken off the reservation, as it now takes up free space
+		c.Progress(1, 200)
+		require.Equal(t, uint64(400), c.Reserved())
+
+		c.Release(1)
+		require.Zero(t, c.Reserved())
+		require.NoError(t, c.Check(2, 600))
+	})
+
+	t.Run("confirms retrievals that do not fit", func(t *testing.T) {
+		var shortfalls []spacecheck.Shortfall
+		confirm := func(deal retrievalmarket.DealID, shortfall spacecheck.Shortfall) bool {
+			shortfalls = append(shortfalls, shortfall)
+			return deal == 1
+		}
+		c := spacecheck.New(paths, 0, spacecheck.FreeSpace(freeSpace), spacecheck.Confirm(confirm))
+		require.NoError(t, c.Check(1, 2000))
+		require.True(t, errors.Is(c.Check(2, 2000), spacecheck.ErrInsufficientSpace))
+		require.Equal(t, []spacecheck.Shortfall{
+			{Path: "/stores", Free: 1000, Needed: 2000},
+			{Path: "/stores", Free: 1000, Needed: 2000},
+		}, shortfalls)
+	})
+
+	t.Run("fails when a volume cannot be checked", func(t *testing.T) {
+		c := spacecheck.New(paths, 0, spacecheck.FreeSpace(func(string) (uint64, error) {
+			return 0, errors.New("no such volume")
+		}))
+		require.EqualError(t, c.Check(1, 1), "checking free space at /stores: no such volume")
+	})
+}
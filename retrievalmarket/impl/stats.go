@@ -0,0 +1,59 @@
+package retrievalimpl
+
+import (
+	"time"
+
+	"github.com/ipfs/go-datastore"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+)
+
+// counters of deal activity kept for Stats
+const (
+	statDealsAccepted  = "deals-accepted"
+	statDealsCompleted = "deals-completed"
+	statBytesServed    = "bytes-served"
+)
+
+// PersistStats keeps the provider's deal statistics in the given datastore, so that
+// they survive restarts. Without it, statistics are kept in memory. It must be
+// passed to NewProvider
+func PersistStats(ds datastore.Batching) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.statsDs = ds
+	}
+}
+
+// recordStats counts the deal activity an event represents
+func (p *Provider) recordStats(evt retrievalmarket.ProviderEvent, deal retrievalmarket.ProviderDealState) {
+	counts := make(dealstats.Counts)
+	switch evt {
+	case retrievalmarket.ProviderEventDealAccepted:
+		counts[statDealsAccepted] = 1
+	case retrievalmarket.ProviderEventCleanupComplete:
+		counts[statDealsCompleted] = 1
+		counts[statBytesServed] = deal.TotalSent
+	}
+	for counter, n := range counts {
+		if err := p.stats.Add(counter, n); err != nil {
+			log.Warnf("recording deal stats: %s", err)
+		}
+	}
+}
+
+// Stats returns rolling statistics of the provider's deal throughput over the last
+// day
+func (p *Provider) Stats() retrievalmarket.ProviderStats {
+	window := dealstats.DefaultWindow
+	stats := retrievalmarket.ProviderStats{
+		Window:         window,
+		Time:           time.Now(),
+		DealsAccepted:  p.stats.Sum(statDealsAccepted, window),
+		DealsCompleted: p.stats.Sum(statDealsCompleted, window),
+		BytesServed:    p.stats.Sum(statBytesServed, window),
+	}
+	stats.DealsAcceptedPerHour = dealstats.PerHour(stats.DealsAccepted, window)
+	stats.GiBServedPerDay = dealstats.GiBPerDay(stats.BytesServed, window)
+	return stats
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
@@ -35,6 +36,7 @@ type TestRetrievalClientNode struct {
 	checkAvailableFundsErr            error
 	fundsAdded                        abi.TokenAmount
 	intergrationTest                  bool
+	verifySignatureFails              bool
 	knownAddreses                     map[retrievalmarket.RetrievalPeer][]ma.Multiaddr
 	receivedKnownAddresses            map[retrievalmarket.RetrievalPeer]struct{}
 	expectedKnownAddresses            map[retrievalmarket.RetrievalPeer]struct{}
@@ -60,6 +62,7 @@ type TestRetrievalClientNodeParams struct {
 	ChannelAvailableFunds       retrievalmarket.ChannelAvailableFunds
 	CheckAvailableFundsErr      error
 	IntegrationTest             bool
+	VerifySignatureFails        bool
 }
 
 var _ retrievalmarket.RetrievalClientNode = &TestRetrievalClientNode{}
@@ -83,6 +86,7 @@ func NewTestRetrievalClientNode(params TestRetrievalClientNodeParams) *TestRetri
 		channelAvailableFunds:           addZeroesToAvailableFunds(params.ChannelAvailableFunds),
 		checkAvailableFundsErr:          params.CheckAvailableFundsErr,
 		intergrationTest:                params.IntegrationTest,
+		verifySignatureFails:            params.VerifySignatureFails,
 		knownAddreses:                   map[retrievalmarket.RetrievalPeer][]ma.Multiaddr{},
 		expectedKnownAddresses:          map[retrievalmarket.RetrievalPeer]struct{}{},
 		receivedKnownAddresses:          map[retrievalmarket.RetrievalPeer]struct{}{},
@@ -170,6 +174,16 @@ func (trcn *TestRetrievalClientNode) GetKnownAddresses(ctx context.Context, p re
 	return addrs, nil
 }
 
+// GetMinerWorkerAddress translates an address
+func (trcn *TestRetrievalClientNode) GetMinerWorkerAddress(ctx context.Context, miner address.Address, tok shared.TipSetToken) (address.Address, error) {
+	return miner, nil
+}
+
+// VerifySignature pretends to verify a signature, failing if the node was set up to
+func (trcn *TestRetrievalClientNode) VerifySignature(ctx context.Context, signature crypto.Signature, signer address.Address, plaintext []byte, tok shared.TipSetToken) (bool, error) {
+	return !trcn.verifySignatureFails, nil
+}
+
 // ResetChannelAvailableFunds is a way to manually change the funds in the payment channel
 func (trcn *TestRetrievalClientNode) ResetChannelAvailableFunds(channelAvailableFunds retrievalmarket.ChannelAvailableFunds) {
 	trcn.channelAvailableFunds = addZeroesToAvailableFunds(channelAvailableFunds)
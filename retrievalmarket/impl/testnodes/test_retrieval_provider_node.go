@@ -13,10 +13,12 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
 )
 
 type expectedVoucherKey struct {
@@ -41,6 +43,7 @@ type voucherResult struct {
 // responses are mocked
 type TestRetrievalProviderNode struct {
 	ChainHeadError   error
+	SignBytesError   error
 	sectorStubs      map[sectorKey][]byte
 	expectations     map[sectorKey]struct{}
 	received         map[sectorKey]struct{}
@@ -122,6 +125,14 @@ func (trpn *TestRetrievalProviderNode) GetMinerWorkerAddress(ctx context.Context
 	return addr, nil
 }
 
+// SignBytes simulates signing data by returning a test signature
+func (trpn *TestRetrievalProviderNode) SignBytes(ctx context.Context, signer address.Address, b []byte) (*crypto.Signature, error) {
+	if trpn.SignBytesError != nil {
+		return nil, trpn.SignBytesError
+	}
+	return shared_testutil.MakeTestSignature(), nil
+}
+
 // GetChainHead returns a mock value for the chain head
 func (trpn *TestRetrievalProviderNode) GetChainHead(ctx context.Context) (shared.TipSetToken, abi.ChainEpoch, error) {
 	return []byte{42}, 0, trpn.ChainHeadError
@@ -0,0 +1,159 @@
+/*
+Package transferscheduler decides the order in which a retrieval provider starts
+sending data for its deals, so that clients who pay for priority are served ahead
+of the rest.
+
+The provider has a number of transfer slots. A deal that is ready to start while all
+of the slots are taken waits in one of two queues: deals that asked for priority
+wait in the priority queue, and the rest in the normal queue. When a deal finishes
+transferring, its slot goes to the deal at the head of the priority queue, or to the
+deal at the head of the normal queue if no priority deals are waiting. Deals in the
+same queue are given slots in the order they were queued.
+*/
+package transferscheduler
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// Stats is a snapshot of a scheduler's slots and queues
+type Stats struct {
+	// Slots is the number of transfer slots, or zero if transfers are not limited
+	Slots int
+	// Active is the number of deals that hold a slot
+	Active int
+	// QueuedPriority is the number of priority deals waiting for a slot
+	QueuedPriority int
+	// Queued is the number of deals without priority waiting for a slot
+	Queued int
+}
+
+// Scheduler tracks the provider's transfer slots and the deals waiting for them
+type Scheduler struct {
+	lk       sync.Mutex
+	slots    int
+	active   map[retrievalmarket.ProviderDealIdentifier]struct{}
+	priority []retrievalmarket.ProviderDealIdentifier
+	normal   []retrievalmarket.ProviderDealIdentifier
+}
+
+// New returns a Scheduler with the given number of transfer slots. Zero slots
+// starts every transfer at once
+func New(slots int) *Scheduler {
+	return &Scheduler{
+		slots:  slots,
+		active: make(map[retrievalmarket.ProviderDealIdentifier]struct{}),
+	}
+}
+
+// Acquire returns true if the deal holds a transfer slot, giving it one if a slot is
+// free and no deals that would go before it are queued. Otherwise the deal is queued
+// behind the deals of the same priority, and Acquire returns false
+func (s *Scheduler) Acquire(deal retrievalmarket.ProviderDealIdentifier, priority bool) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.active[deal]; ok {
+		return true
+	}
+
+	queue := &s.normal
+	if priority {
+		queue = &s.priority
+	}
+	position := indexOf(*queue, deal)
+	if position == -1 {
+		position = len(*queue)
+		*queue = append(*queue, deal)
+	}
+	ahead := position
+	if !priority {
+		ahead += len(s.priority)
+	}
+	if ahead == 0 && s.free() {
+		*queue = (*queue)[1:]
+		s.active[deal] = struct{}{}
+		return true
+	}
+	return false
+}
+
+// Release frees the deal's transfer slot, or removes it from its queue. The queued
+// deals that are given the slots this frees are returned
+func (s *Scheduler) Release(deal retrievalmarket.ProviderDealIdentifier) []retrievalmarket.ProviderDealIdentifier {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if _, ok := s.active[deal]; !ok {
+		s.priority = remove(s.priority, deal)
+		s.normal = remove(s.normal, deal)
+		return nil
+	}
+	delete(s.active, deal)
+	return s.startQueued()
+}
+
+// SetSlots changes the number of transfer slots. If that frees slots for queued
+// deals, they are given the slots and returned. Lowering the number of slots does
+// not take slots from deals that already hold them
+func (s *Scheduler) SetSlots(slots int) []retrievalmarket.ProviderDealIdentifier {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.slots = slots
+	return s.startQueued()
+}
+
+// Stats returns the scheduler's current slots and queue lengths
+func (s *Scheduler) Stats() Stats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return Stats{
+		Slots:          s.slots,
+		Active:         len(s.active),
+		QueuedPriority: len(s.priority),
+		Queued:         len(s.normal),
+	}
+}
+
+func (s *Scheduler) free() bool {
+	return s.slots <= 0 || len(s.active) < s.slots
+}
+
+// startQueued gives free slots to queued deals, priority deals first
+func (s *Scheduler) startQueued() []retrievalmarket.ProviderDealIdentifier {
+	var started []retrievalmarket.ProviderDealIdentifier
+	for s.free() {
+		var next retrievalmarket.ProviderDealIdentifier
+		switch {
+		case len(s.priority) > 0:
+			next, s.priority = s.priority[0], s.priority[1:]
+		case len(s.normal) > 0:
+			next, s.normal = s.normal[0], s.normal[1:]
+		default:
+			return started
+		}
+		s.active[next] = struct{}{}
+		started = append(started, next)
+	}
+	return started
+}
+
+func indexOf(queue []retrievalmarket.ProviderDealIdentifier, deal retrievalmarket.ProviderDealIdentifier) int {
+	for i, queued := range queue {
+		if queued == deal {
+			return i
+		}
+	}
+	return -1
+}
+
+func remove(queue []retrievalmarket.ProviderDealIdentifier, deal retrievalmarket.ProviderDealIdentifier) []retrievalmarket.ProviderDealIdentifier {
+	if i := indexOf(queue, deal); i != -1 {
+		return append(queue[:i], queue[i+1:]...)
+	}
+	return queue
+}
@@ -0,0 +1,57 @@
+package transferscheduler_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/transferscheduler"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestScheduler(t *testing.T) {
+	client := shared_testutil.GeneratePeers(1)[0]
+	deal := func(id uint64) retrievalmarket.ProviderDealIdentifier {
+		return retrievalmarket.ProviderDealIdentifier{Receiver: client, DealID: retrievalmarket.DealID(id)}
+	}
+
+	s := transferscheduler.New(2)
+	require.True(t, s.Acquire(deal(1), false))
+	require.True(t, s.Acquire(deal(2), false))
+
+	// acquiring again is idempotent
+	require.True(t, s.Acquire(deal(1), false))
+
+	// the slots are full, so the next deals queue
+	require.False(t, s.Acquire(deal(3), false))
+	require.False(t, s.Acquire(deal(4), false))
+	require.False(t, s.Acquire(deal(5), true))
+	require.Equal(t, transferscheduler.Stats{Slots: 2, Active: 2, QueuedPriority: 1, Queued: 2}, s.Stats())
+
+	// the priority deal gets the first slot to open, even though it queued last
+	require.Equal(t, []retrievalmarket.ProviderDealIdentifier{deal(5)}, s.Release(deal(1)))
+	require.True(t, s.Acquire(deal(5), true))
+
+	// then the other deals go in the order they queued
+	require.Equal(t, []retrievalmarket.ProviderDealIdentifier{deal(3)}, s.Release(deal(2)))
+
+	// removing a queued deal does not open a slot
+	require.Empty(t, s.Release(deal(4)))
+	require.Equal(t, transferscheduler.Stats{Slots: 2, Active: 2}, s.Stats())
+
+	// priority deals that queue later still go first
+	require.False(t, s.Acquire(deal(6), true))
+	require.Equal(t, []retrievalmarket.ProviderDealIdentifier{deal(6)}, s.Release(deal(3)))
+	require.False(t, s.Acquire(deal(7), false))
+	require.False(t, s.Acquire(deal(8), true))
+
+	// raising the number of slots starts queued deals, priority deals first
+	require.Equal(t, []retrievalmarket.ProviderDealIdentifier{deal(8), deal(7)}, s.SetSlots(4))
+
+	// no limit starts every deal at once
+	s = transferscheduler.New(0)
+	for i := uint64(0); i < 10; i++ {
+		require.True(t, s.Acquire(deal(i), i%2 == 0))
+	}
+}
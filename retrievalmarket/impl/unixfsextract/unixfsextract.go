@@ -0,0 +1,312 @@
+/*
+Package unixfsextract writes the files of a UnixFS DAG to the filesystem as its
+blocks are retrieved, rather than in a separate pass over the store afterwards.
+
+Graphsync visits the blocks of a DAG depth first, in link order, so an Extractor
+follows the traversal with a stack of the blocks it expects next. Directories are
+created when they are visited, and the data of a file is appended as each of its
+blocks is visited. A file is written to a ".part" file next to its path, and moved
+into place once all of its data is written.
+
+Blocks still go to the store the Extractor wraps, so an extraction resumes when the
+retrieval does. A traversal that starts again from the root, as a restarted
+transfer does, starts the extraction again: files already in place with the size
+the DAG gives them are skipped, and files that were not finished are written again.
+
+Only the whole DAG of a payload can be extracted. Symlinks and sharded directories
+are not supported.
+*/
+package unixfsextract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	unixfspb "github.com/ipfs/go-unixfs/pb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"golang.org/x/xerrors"
+)
+
+// ErrIncomplete is returned when closing an Extractor before the whole DAG was visited
+var ErrIncomplete = errors.New("extraction is incomplete")
+
+const partSuffix = ".part"
+
+// expected is a block the traversal visits next. Blocks with a file are part of the
+// file's data, and blocks without one are an entry written at path. An expected
+// block with no cid marks the end of a file's data
+type expected struct {
+	c    cid.Cid
+	path string
+	file *file
+}
+
+type file struct {
+	path    string
+	size    uint64
+	written uint64
+	out     *os.File
+}
+
+// Extractor writes a UnixFS DAG to the filesystem as its blocks are visited
+type Extractor struct {
+	root   cid.Cid
+	path   string
+	loader ipld.Loader
+	storer ipld.Storer
+
+	lk    sync.Mutex
+	stack []expected
+	open  *file
+	err   error
+}
+
+// New returns an Extractor that writes the DAG under root to path, and stores and
+// loads blocks with the given storer and loader. If root is a directory, path is
+// the directory its entries are written to
+func New(root cid.Cid, path string, loader ipld.Loader, storer ipld.Storer) *Extractor {
+	return &Extractor{
+		root:   root,
+		path:   path,
+		loader: loader,
+		storer: storer,
+	}
+}
+
+// Storer returns an IPLD storer that stores each block and then extracts it
+func (e *Extractor) Storer() ipld.Storer {
+	return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		w, commit, err := e.storer(lnkCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buf bytes.Buffer
+		var committer ipld.StoreCommitter = func(lnk ipld.Link) error {
+			c, ok := lnk.(cidlink.Link)
+			if !ok {
+				return xerrors.New("incorrect Link Type")
+			}
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			if err := commit(lnk); err != nil {
+				return err
+			}
+			return e.visit(c.Cid, buf.Bytes())
+		}
+		return &buf, committer, nil
+	}
+}
+
+// Loader returns an IPLD loader that loads blocks from the store, and extracts each
+// block it loads, as the traversal visits blocks it already has this way
+func (e *Extractor) Loader() ipld.Loader {
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		c, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, xerrors.New("incorrect Link Type")
+		}
+		r, err := e.loader(lnk, lnkCtx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.visit(c.Cid, data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+}
+
+// Close stops the extraction. It returns the error that stopped the extraction, or
+// ErrIncomplete if the traversal had not visited the whole DAG. A file being written
+// is left in its ".part" file
+func (e *Extractor) Close() error {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	e.closeOpen()
+	if e.err != nil {
+		return e.err
+	}
+	if e.stack == nil || len(e.stack) > 0 {
+		return ErrIncomplete
+	}
+	return nil
+}
+
+func (e *Extractor) visit(c cid.Cid, data []byte) error {
+	e.lk.Lock()
+	defer e.lk.Unlock()
+
+	if e.err != nil {
+		return e.err
+	}
+	if c.Equals(e.root) {
+		e.closeOpen()
+		e.stack = []expected{{c: e.root, path: e.path}}
+	}
+	if len(e.stack) == 0 {
+		e.err = xerrors.Errorf("block %s is not part of the DAG being extracted", c)
+		return e.err
+	}
+	next := e.pop()
+	if !next.c.Equals(c) {
+		e.err = xerrors.Errorf("block %s visited out of order, expected %s", c, next.c)
+		return e.err
+	}
+	if err := e.extract(next, c, data); err != nil {
+		e.err = xerrors.Errorf("extracting block %s to %s: %w", c, next.path, err)
+		return e.err
+	}
+	// finish the files whose data is all written
+	for len(e.stack) > 0 && !e.stack[len(e.stack)-1].c.Defined() {
+		if err := e.finish(e.pop().file); err != nil {
+			e.err = err
+			return e.err
+		}
+	}
+	return nil
+}
+
+func (e *Extractor) extract(next expected, c cid.Cid, data []byte) error {
+	var links []*expected
+	var fileData []byte
+	fileSize := uint64(len(data))
+	isDir := false
+
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		fileData = data
+	case cid.DagProtobuf:
+		nd, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			return err
+		}
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return err
+		}
+		switch fsn.Type() {
+		case unixfspb.Data_Directory:
+			if next.file != nil {
+				return xerrors.New("directory inside a file")
+			}
+			isDir = true
+		case unixfspb.Data_File, unixfspb.Data_Raw:
+			fileData = fsn.Data()
+			fileSize = fsn.FileSize()
+		default:
+			return xerrors.Errorf("unsupported unixfs node type %s", fsn.Type())
+		}
+		for _, link := range nd.Links() {
+			child := &expected{c: link.Cid, file: next.file}
+			if isDir {
+				if err := checkName(link.Name); err != nil {
+					return err
+				}
+				child.path = filepath.Join(next.path, link.Name)
+			}
+			links = append(links, child)
+		}
+	default:
+		return xerrors.Errorf("unsupported codec %d", c.Prefix().Codec)
+	}
+
+	f := next.file
+	switch {
+	case isDir:
+		if err := os.MkdirAll(next.path, 0755); err != nil {
+			return err
+		}
+	case f == nil:
+		var err error
+		f, err = e.create(next.path, fileSize)
+		if err != nil {
+			return err
+		}
+		e.stack = append(e.stack, expected{file: f})
+		for _, link := range links {
+			link.file = f
+		}
+	}
+	if f != nil && f.out != nil && len(fileData) > 0 {
+		if _, err := f.out.Write(fileData); err != nil {
+			return err
+		}
+		f.written += uint64(len(fileData))
+	}
+	for i := len(links) - 1; i >= 0; i-- {
+		e.stack = append(e.stack, *links[i])
+	}
+	return nil
+}
+
+// create starts writing a file, unless the file is already in place with its size
+func (e *Extractor) create(path string, size uint64) (*file, error) {
+	f := &file{path: path, size: size}
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && uint64(info.Size()) == size {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(path + partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	f.out = out
+	e.open = f
+	return f, nil
+}
+
+func (e *Extractor) finish(f *file) error {
+	if f.out == nil {
+		return nil
+	}
+	e.open = nil
+	if err := f.out.Close(); err != nil {
+		return xerrors.Errorf("closing %s: %w", f.path, err)
+	}
+	if f.written != f.size {
+		return xerrors.Errorf("wrote %d bytes to %s, expected %d", f.written, f.path, f.size)
+	}
+	if err := os.Rename(f.path+partSuffix, f.path); err != nil {
+		return xerrors.Errorf("moving %s into place: %w", f.path, err)
+	}
+	return nil
+}
+
+func (e *Extractor) closeOpen() {
+	if e.open != nil {
+		_ = e.open.out.Close()
+		e.open = nil
+	}
+}
+
+func (e *Extractor) pop() expected {
+	next := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return next
+}
+
+// checkName makes sure a directory entry stays inside its directory
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return xerrors.Errorf("invalid directory entry name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package unixfsextract_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/unixfsextract"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+type memStore map[cid.Cid][]byte
+
+func (m memStore) loader(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+	data, ok := m[lnk.(cidlink.Link).Cid]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (m memStore) storer(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+	var buf bytes.Buffer
+	return &buf, func(lnk ipld.Link) error {
+		m[lnk.(cidlink.Link).Cid] = buf.Bytes()
+		return nil
+	}, nil
+}
+
+func importFile(t *testing.T, dag ipldformat.DAGService, data []byte) ipldformat.Node {
+	params := helpers.DagBuilderParams{
+		Maxlinks:  2,
+		RawLeaves: true,
+		Dagserv:   dag,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+	return nd
+}
+
+func importDir(t *testing.T, dag ipldformat.DAGService, entries map[string]ipldformat.Node) ipldformat.Node {
+	ctx := context.Background()
+	dir := uio.NewDirectory(dag)
+	for name, nd := range entries {
+		require.NoError(t, dir.AddChild(ctx, name, nd))
+	}
+	nd, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dag.Add(ctx, nd))
+	return nd
+}
+
+// traverse visits the blocks of a DAG depth first like graphsync, storing blocks the
+// first time they are visited and loading them after that. It stops after limit
+// visits if limit is positive
+func traverse(t *testing.T, dag ipldformat.DAGService, e *unixfsextract.Extractor, seen map[cid.Cid]bool, root cid.Cid, limit int) error {
+	visits := 0
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if limit > 0 && visits == limit {
+			return nil
+		}
+		visits++
+		nd, err := dag.Get(context.Background(), c)
+		require.NoError(t, err)
+		if seen[c] {
+			if _, err := e.Loader()(cidlink.Link{Cid: c}, ipld.LinkContext{}); err != nil {
+				return err
+			}
+		} else {
+			seen[c] = true
+			w, commit, err := e.Storer()(ipld.LinkContext{})
+			require.NoError(t, err)
+			_, err = w.Write(nd.RawData())
+			require.NoError(t, err)
+			if err := commit(cidlink.Link{Cid: c}); err != nil {
+				return err
+			}
+		}
+		for _, link := range nd.Links() {
+			if err := walk(link.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+func TestExtractor(t *testing.T) {
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	a := tut.RandomBytes(1500)
+	b := tut.RandomBytes(100)
+	sub := importDir(t, dag, map[string]ipldformat.Node{
+		"b": importFile(t, dag, b),
+		"c": importFile(t, dag, a),
+	})
+	root := importDir(t, dag, map[string]ipldformat.Node{
+		"a":   importFile(t, dag, a),
+		"sub": sub,
+	})
+
+	newExtractor := func(t *testing.T) (*unixfsextract.Extractor, string) {
+		dir, err := ioutil.TempDir("", "unixfsextract")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		out := filepath.Join(dir, "out")
+		store := memStore{}
+		return unixfsextract.New(root.Cid(), out, store.loader, store.storer), out
+	}
+
+	requireFile := func(t *testing.T, path string, data []byte) {
+		read, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		_, err = os.Stat(path + ".part")
+		require.True(t, os.IsNotExist(err))
+	}
+
+	t.Run("extracts a directory as its blocks are visited", func(t *testing.T) {
+		e, out := newExtractor(t)
+		require.NoError(t, traverse(t, dag, e, map[cid.Cid]bool{}, root.Cid(), 0))
+		require.NoError(t, e.Close())
+		requireFile(t, filepath.Join(out, "a"), a)
+		requireFile(t, filepath.Join(out, "sub", "b"), b)
+		requireFile(t, filepath.Join(out, "sub", "c"), a)
+	})
+
+	t.Run("extracts a single file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "unixfsextract")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir) // nolint: errcheck
+		file := importFile(t, dag, a)
+		out := filepath.Join(dir, "file")
+		store := memStore{}
+		e := unixfsextract.New(file.Cid(), out, store.loader, store.storer)
+		require.NoError(t, traverse(t, dag, e, map[cid.Cid]bool{}, file.Cid(), 0))
+		require.NoError(t, e.Close())
+		requireFile(t, out, a)
+	})
+
+	t.Run("starts again when the traversal restarts", func(t *testing.T) {
+		e, out := newExtractor(t)
+		seen := map[cid.Cid]bool{}
+		require.NoError(t, traverse(t, dag, e, seen, root.Cid(), 4))
+		// a file in place with the right size is not written again
+		require.NoError(t, os.MkdirAll(filepath.Join(out, "sub"), 0755))
+		inPlace := bytes.Repeat([]byte{1}, len(b))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(out, "sub", "b"), inPlace, 0644))
+
+		require.NoError(t, traverse(t, dag, e, seen, root.Cid(), 0))
+		require.NoError(t, e.Close())
+		requireFile(t, filepath.Join(out, "a"), a)
+		requireFile(t, filepath.Join(out, "sub", "b"), inPlace)
+		requireFile(t, filepath.Join(out, "sub", "c"), a)
+	})
+
+	t.Run("is incomplete until the whole DAG is visited", func(t *testing.T) {
+		e, _ := newExtractor(t)
+		require.Equal(t, unixfsextract.ErrIncomplete, e.Close())
+		e, _ = newExtractor(t)
+		require.NoError(t, traverse(t, dag, e, map[cid.Cid]bool{}, root.Cid(), 3))
+		require.Equal(t, unixfsextract.ErrIncomplete, e.Close())
+	})
+
+	t.Run("fails on a block outside the DAG", func(t *testing.T) {
+		e, _ := newExtractor(t)
+		require.Error(t, traverse(t, dag, e, map[cid.Cid]bool{}, sub.Cid(), 0))
+		require.Error(t, e.Close())
+	})
+}
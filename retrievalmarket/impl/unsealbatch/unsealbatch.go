@@ -0,0 +1,291 @@
+/*
+Package unsealbatch schedules the unsealing a retrieval provider does for its deals,
+so that deals reading from the same sector are served from one unseal pass rather
+than the sector being unsealed once for each of them.
+
+A Batcher holds each request to unseal part of a sector for a short window, during
+which later requests for the same sector join it. When the window closes, the
+requests are sorted by offset, and ranges that overlap or lie within the batcher's
+max gap of each other are merged into spans. Each span is unsealed with a single
+call to the node. Its data is read once and handed out as it is read, each request
+getting only its own range, so a request that reads slowly holds up the others
+sharing its span. A request with a span to itself is given the node's reader as is.
+
+A Batcher without a window unseals every request on its own as it arrives.
+*/
+package unsealbatch
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// readSize is how much of a shared span is read and handed out at a time
+const readSize = 1 << 20
+
+// Node unseals part of a sector, as a RetrievalProviderNode does
+type Node interface {
+	UnsealSector(ctx context.Context, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error)
+}
+
+// Stats is a snapshot of the unsealing a batcher has done
+type Stats struct {
+	// Requests is the number of requests to unseal part of a sector
+	Requests uint64
+	// Passes is the number of calls made to the node to unseal them
+	Passes uint64
+	// Shared is the number of requests served from a pass shared with other requests
+	Shared uint64
+	// Waiting is the number of requests waiting for their window to close
+	Waiting int
+}
+
+type sectorKey struct {
+	miner  address.Address
+	sector abi.SectorNumber
+}
+
+type result struct {
+	reader io.ReadCloser
+	err    error
+}
+
+type request struct {
+	ctx    context.Context
+	offset abi.UnpaddedPieceSize
+	length abi.UnpaddedPieceSize
+	result chan result
+}
+
+func (r *request) end() abi.UnpaddedPieceSize {
+	return r.offset + r.length
+}
+
+type batch struct {
+	node     Node
+	requests []*request
+}
+
+type span struct {
+	start    abi.UnpaddedPieceSize
+	end      abi.UnpaddedPieceSize
+	requests []*request
+}
+
+// Batcher gathers requests to unseal the same sector into shared unseal passes
+type Batcher struct {
+	lk     sync.Mutex
+	window time.Duration
+	maxGap abi.UnpaddedPieceSize
+	open   map[sectorKey]*batch
+	stats  Stats
+}
+
+// New returns a Batcher that holds requests for the given window for others on the
+// same sector to join, and merges ranges up to maxGap apart into one pass. Without a
+// window, every request is unsealed as it arrives
+func New(window time.Duration, maxGap abi.UnpaddedPieceSize) *Batcher {
+	return &Batcher{
+		window: window,
+		maxGap: maxGap,
+		open:   make(map[sectorKey]*batch),
+	}
+}
+
+// Configure changes the window and max gap of requests made from now on
+func (b *Batcher) Configure(window time.Duration, maxGap abi.UnpaddedPieceSize) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.window = window
+	b.maxGap = maxGap
+}
+
+// Unseal returns a reader for the given range of a miner's sector, unsealed with the
+// given node along with the other requests for the sector made within the window.
+// The reader must be closed, and read to the end or closed early for the other
+// requests sharing its pass to carry on
+func (b *Batcher) Unseal(ctx context.Context, miner address.Address, node Node, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	b.lk.Lock()
+	b.stats.Requests++
+	if b.window <= 0 {
+		b.stats.Passes++
+		b.lk.Unlock()
+		return node.UnsealSector(ctx, sectorID, offset, length)
+	}
+	key := sectorKey{miner: miner, sector: sectorID}
+	bt, ok := b.open[key]
+	if !ok {
+		bt = &batch{node: node}
+		b.open[key] = bt
+		time.AfterFunc(b.window, func() { b.run(key, bt) })
+	}
+	req := &request{ctx: ctx, offset: offset, length: length, result: make(chan result, 1)}
+	bt.requests = append(bt.requests, req)
+	b.lk.Unlock()
+
+	select {
+	case res := <-req.result:
+		return res.reader, res.err
+	case <-ctx.Done():
+		// close the reader the pass hands out, so the requests sharing it carry on
+		go func() {
+			if res := <-req.result; res.reader != nil {
+				_ = res.reader.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the unsealing the batcher has done so far
+func (b *Batcher) Stats() Stats {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	stats := b.stats
+	for _, bt := range b.open {
+		stats.Waiting += len(bt.requests)
+	}
+	return stats
+}
+
+// run closes a batch to new requests and starts a pass for each of its spans
+func (b *Batcher) run(key sectorKey, bt *batch) {
+	b.lk.Lock()
+	delete(b.open, key)
+	spans := merge(bt.requests, b.maxGap)
+	b.stats.Passes += uint64(len(spans))
+	for _, s := range spans {
+		if len(s.requests) > 1 {
+			b.stats.Shared += uint64(len(s.requests))
+		}
+	}
+	b.lk.Unlock()
+
+	for _, s := range spans {
+		go serve(bt.node, key.sector, s)
+	}
+}
+
+// merge sorts requests by offset and merges those that overlap or lie within maxGap
+// of each other into spans
+func merge(requests []*request, maxGap abi.UnpaddedPieceSize) []span {
+	sorted := append([]*request(nil), requests...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].offset < sorted[j].offset
+	})
+	var spans []span
+	for _, req := range sorted {
+		if n := len(spans); n > 0 && req.offset <= spans[n-1].end+maxGap {
+			last := &spans[n-1]
+			last.requests = append(last.requests, req)
+			if req.end() > last.end {
+				last.end = req.end()
+			}
+			continue
+		}
+		spans = append(spans, span{start: req.offset, end: req.end(), requests: []*request{req}})
+	}
+	return spans
+}
+
+// serve unseals a span and hands each of its requests a reader for its range. A
+// shared unseal is not cancelled by one of its requests giving up, only once every
+// reader is closed
+func serve(node Node, sectorID abi.SectorNumber, s span) {
+	if len(s.requests) == 1 {
+		req := s.requests[0]
+		reader, err := node.UnsealSector(req.ctx, sectorID, req.offset, req.length)
+		req.result <- result{reader: reader, err: err}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, err := node.UnsealSector(ctx, sectorID, s.start, s.end-s.start)
+	if err != nil {
+		for _, req := range s.requests {
+			req.result <- result{err: err}
+		}
+		return
+	}
+	defer reader.Close()
+
+	writers := make([]*io.PipeWriter, len(s.requests))
+	for i, req := range s.requests {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		req.result <- result{reader: pr}
+	}
+	err = fanOut(reader, s, writers)
+	for _, w := range writers {
+		// writers already closed keep the error they were closed with
+		_ = w.CloseWithError(err)
+	}
+}
+
+// fanOut reads a span's data and writes each request's range of it to the request's
+// writer, closing each writer once its range is written. It returns early once every
+// reader is closed
+func fanOut(data io.Reader, s span, writers []*io.PipeWriter) error {
+	buf := make([]byte, readSize)
+	done := make([]bool, len(writers))
+	reading := len(writers)
+	pos := s.start
+	for pos < s.end && reading > 0 {
+		want := abi.UnpaddedPieceSize(len(buf))
+		if s.end-pos < want {
+			want = s.end - pos
+		}
+		n, err := data.Read(buf[:want])
+		chunkEnd := pos + abi.UnpaddedPieceSize(n)
+		for i, req := range s.requests {
+			if done[i] {
+				continue
+			}
+			from, to := maxSize(req.offset, pos), minSize(req.end(), chunkEnd)
+			if from < to {
+				if _, werr := writers[i].Write(buf[from-pos : to-pos]); werr != nil {
+					done[i] = true
+					reading--
+					continue
+				}
+			}
+			if req.end() <= chunkEnd {
+				_ = writers[i].Close()
+				done[i] = true
+				reading--
+			}
+		}
+		pos = chunkEnd
+		if err == io.EOF {
+			if pos < s.end {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func minSize(a, b abi.UnpaddedPieceSize) abi.UnpaddedPieceSize {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxSize(a, b abi.UnpaddedPieceSize) abi.UnpaddedPieceSize {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package unsealbatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+type unsealCall struct {
+	sector abi.SectorNumber
+	offset abi.UnpaddedPieceSize
+	length abi.UnpaddedPieceSize
+}
+
+type fakeNode struct {
+	lk     sync.Mutex
+	sector []byte
+	err    error
+	calls  []unsealCall
+}
+
+func (n *fakeNode) UnsealSector(ctx context.Context, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.calls = append(n.calls, unsealCall{sector: sectorID, offset: offset, length: length})
+	if n.err != nil {
+		return nil, n.err
+	}
+	return ioutil.NopCloser(io.NewSectionReader(bytes.NewReader(n.sector), int64(offset), int64(length))), nil
+}
+
+type unsealRange struct {
+	sector abi.SectorNumber
+	offset abi.UnpaddedPieceSize
+	length abi.UnpaddedPieceSize
+}
+
+// unsealAll makes a request for each range at once and reads each to the end
+func unsealAll(b *Batcher, node Node, ranges []unsealRange) ([][]byte, []error) {
+	ctx := context.Background()
+	data := make([][]byte, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r unsealRange) {
+			defer wg.Done()
+			reader, err := b.Unseal(ctx, address.TestAddress, node, r.sector, r.offset, r.length)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer reader.Close()
+			data[i], errs[i] = ioutil.ReadAll(reader)
+		}(i, r)
+	}
+	wg.Wait()
+	return data, errs
+}
+
+func TestBatcher(t *testing.T) {
+	sector := shared_testutil.RandomBytes(3 * readSize)
+
+	t.Run("unseals each request without a window", func(t *testing.T) {
+		node := &fakeNode{sector: sector}
+		b := New(0, 0)
+		ranges := []unsealRange{{sector: 1, offset: 0, length: 100}, {sector: 1, offset: 50, length: 100}}
+		data, errs := unsealAll(b, node, ranges)
+		for i, r := range ranges {
+			require.NoError(t, errs[i])
+			require.Equal(t, sector[r.offset:r.offset+r.length], data[i])
+		}
+		require.Len(t, node.calls, 2)
+		require.Equal(t, Stats{Requests: 2, Passes: 2}, b.Stats())
+	})
+
+	t.Run("serves requests on the same sector from one pass", func(t *testing.T) {
+		node := &fakeNode{sector: sector}
+		b := New(50*time.Millisecond, 0)
+		ranges := []unsealRange{
+			{sector: 1, offset: readSize + 10, length: readSize},
+			{sector: 1, offset: 0, length: 2 * readSize},
+			{sector: 1, offset: 100, length: 200},
+		}
+		data, errs := unsealAll(b, node, ranges)
+		for i, r := range ranges {
+			require.NoError(t, errs[i])
+			require.Equal(t, sector[r.offset:r.offset+r.length], data[i])
+		}
+		require.Equal(t, []unsealCall{{sector: 1, offset: 0, length: 2*readSize + 10}}, node.calls)
+		require.Equal(t, Stats{Requests: 3, Passes: 1, Shared: 3}, b.Stats())
+	})
+
+	t.Run("unseals other sectors and distant ranges separately", func(t *testing.T) {
+		node := &fakeNode{sector: sector}
+		b := New(50*time.Millisecond, 100)
+		ranges := []unsealRange{
+			{sector: 1, offset: 0, length: 100},
+			{sector: 1, offset: 150, length: 100},
+			{sector: 1, offset: 1000, length: 100},
+			{sector: 2, offset: 0, length: 100},
+		}
+		data, errs := unsealAll(b, node, ranges)
+		for i, r := range ranges {
+			require.NoError(t, errs[i])
+			require.Equal(t, sector[r.offset:r.offset+r.length], data[i])
+		}
+		require.ElementsMatch(t, []unsealCall{
+			{sector: 1, offset: 0, length: 250},
+			{sector: 1, offset: 1000, length: 100},
+			{sector: 2, offset: 0, length: 100},
+		}, node.calls)
+		require.Equal(t, Stats{Requests: 4, Passes: 3, Shared: 2}, b.Stats())
+	})
+
+	t.Run("fails every request sharing a failed pass", func(t *testing.T) {
+		node := &fakeNode{sector: sector, err: errors.New("could not unseal")}
+		b := New(50*time.Millisecond, 0)
+		_, errs := unsealAll(b, node, []unsealRange{{sector: 1, offset: 0, length: 100}, {sector: 1, offset: 0, length: 50}})
+		for _, err := range errs {
+			require.EqualError(t, err, "could not unseal")
+		}
+		require.Len(t, node.calls, 1)
+	})
+
+	t.Run("carries on when a reader is closed early", func(t *testing.T) {
+		node := &fakeNode{sector: sector}
+		b := New(50*time.Millisecond, 0)
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		var data []byte
+		var err error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reader, uerr := b.Unseal(ctx, address.TestAddress, node, 1, 0, 2*readSize)
+			require.NoError(t, uerr)
+			require.NoError(t, reader.Close())
+		}()
+		go func() {
+			defer wg.Done()
+			var reader io.ReadCloser
+			reader, err = b.Unseal(ctx, address.TestAddress, node, 1, 0, 3*readSize)
+			if err == nil {
+				data, err = ioutil.ReadAll(reader)
+			}
+		}()
+		wg.Wait()
+		require.NoError(t, err)
+		require.Equal(t, sector, data)
+		require.Len(t, node.calls, 1)
+	})
+
+	t.Run("gives up waiting when the context is cancelled", func(t *testing.T) {
+		node := &fakeNode{sector: sector}
+		b := New(time.Hour, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := b.Unseal(ctx, address.TestAddress, node, 1, 0, 100)
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, 1, b.Stats().Waiting)
+	})
+}
@@ -0,0 +1,26 @@
+package retrievalimpl
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/unsealbatch"
+)
+
+// UnsealBatching makes deals that read from the same sector share one unseal pass.
+// A deal about to unseal waits up to window for other deals on the same sector to
+// join it, and pieces up to maxGap bytes apart in the sector are unsealed with one
+// call to the node, the data between them being read and dropped. Without it, each
+// deal unseals its piece on its own. UnsealStats reports how many unseals were saved
+func UnsealBatching(window time.Duration, maxGap abi.UnpaddedPieceSize) RetrievalProviderOption {
+	return func(provider *Provider) {
+		provider.unsealBatcher.Configure(window, maxGap)
+	}
+}
+
+// UnsealStats returns how many sector ranges deals asked to unseal, and how many
+// unseal passes served them
+func (p *Provider) UnsealStats() unsealbatch.Stats {
+	return p.unsealBatcher.Stats()
+}
@@ -0,0 +1,47 @@
+package retrievalimpl
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+// UnsealPricing makes the provider quote each query and accept each deal at an unseal
+// price the given pricer works out from the node's estimate of the work it takes to
+// unseal the data, rather than at the flat unseal price in the ask. A miner whose node
+// does not implement retrievalmarket.UnsealCostEstimator is quoted its ask's unseal
+// price. It must be passed to NewProvider
+func UnsealPricing(pricer retrievalmarket.UnsealPricer) RetrievalProviderOption {
+	return func(p *Provider) {
+		p.unsealPricer = pricer
+	}
+}
+
+// unsealPrice returns the unseal price the given miner asks to retrieve from the
+// given piece. The first deal in the piece is priced, as it is the first the provider
+// tries to unseal
+func (p *Provider) unsealPrice(ctx context.Context, miner *servedMiner, ask retrievalmarket.Ask, pieceInfo piecestore.PieceInfo) abi.TokenAmount {
+	if p.unsealPricer == nil || len(pieceInfo.Deals) == 0 {
+		return ask.UnsealPrice
+	}
+	estimator, ok := miner.node.(retrievalmarket.UnsealCostEstimator)
+	if !ok {
+		return ask.UnsealPrice
+	}
+	deal := pieceInfo.Deals[0]
+	estimate, err := estimator.EstimateUnsealCost(ctx, deal.SectorID, deal.Offset.Unpadded(), deal.Length.Unpadded())
+	if err != nil {
+		log.Warnf("estimating cost to unseal sector %d, quoting the ask's unseal price: %s", deal.SectorID, err)
+		return ask.UnsealPrice
+	}
+	price := p.unsealPricer(ask, estimate)
+	if price.Nil() || price.LessThan(big.Zero()) {
+		log.Warnf("unseal pricer gave an invalid price for sector %d, quoting the ask's unseal price", deal.SectorID)
+		return ask.UnsealPrice
+	}
+	return price
+}
@@ -0,0 +1,143 @@
+package retrievalimpl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/testnodes"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+// estimatingProviderNode is a test provider node that estimates the cost of unsealing
+type estimatingProviderNode struct {
+	*testnodes.TestRetrievalProviderNode
+	estimate retrievalmarket.UnsealCostEstimate
+	err      error
+}
+
+func (n *estimatingProviderNode) EstimateUnsealCost(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (retrievalmarket.UnsealCostEstimate, error) {
+	return n.estimate, n.err
+}
+
+func TestUnsealPricing(t *testing.T) {
+	ctx := context.Background()
+	payloadCID := tut.GenerateCids(1)[0]
+	pieceCID := tut.GenerateCids(1)[0]
+	cidInfo := piecestore.CIDInfo{
+		PieceBlockLocations: []piecestore.PieceBlockLocation{{PieceCID: pieceCID}},
+	}
+	piece := piecestore.PieceInfo{
+		PieceCID: pieceCID,
+		Deals:    []piecestore.DealInfo{{SectorID: 7, Length: 1024}},
+	}
+	askUnsealPrice := abi.NewTokenAmount(1000)
+
+	query := func(t *testing.T, node retrievalmarket.RetrievalProviderNode, opts ...retrievalimpl.RetrievalProviderOption) retrievalmarket.QueryResponse {
+		pieceStore := tut.NewTestPieceStore()
+		pieceStore.ExpectCID(payloadCID, cidInfo)
+		pieceStore.ExpectPiece(pieceCID, piece)
+
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		multiStore, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		net := tut.NewTestRetrievalMarketNetwork(tut.TestNetworkParams{})
+		p, err := retrievalimpl.NewProvider(address.TestAddress2, node, net, pieceStore, multiStore, tut.NewTestDataTransfer(), ds, opts...)
+		require.NoError(t, err)
+		ask := p.GetAsk()
+		ask.UnsealPrice = askUnsealPrice
+		p.SetAsk(ask)
+		tut.StartAndWaitForReady(ctx, t, p)
+
+		qRead, qWrite := tut.QueryReadWriter()
+		qrRead, qrWrite := tut.QueryResponseReadWriter()
+		qs := tut.NewTestRetrievalQueryStream(tut.TestQueryStreamParams{
+			PeerID:     peer.ID("client"),
+			Reader:     qRead,
+			Writer:     qWrite,
+			RespReader: qrRead,
+			RespWriter: qrWrite,
+		})
+		require.NoError(t, qs.WriteQuery(retrievalmarket.Query{PayloadCID: payloadCID}))
+		net.ReceiveQueryStream(qs)
+
+		response, err := qs.ReadQueryResponse()
+		require.NoError(t, err)
+		require.Equal(t, retrievalmarket.QueryResponseAvailable, response.Status)
+		pieceStore.VerifyExpectations(t)
+		return response
+	}
+
+	pricer := retrievalimpl.UnsealPricing(retrievalmarket.MediumUnsealPricer(5))
+
+	testCases := map[string]struct {
+		node     retrievalmarket.RetrievalProviderNode
+		opts     []retrievalimpl.RetrievalProviderOption
+		expPrice abi.TokenAmount
+	}{
+		"ask price without a pricer": {
+			node: &estimatingProviderNode{
+				TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+				estimate:                  retrievalmarket.UnsealCostEstimate{Medium: retrievalmarket.StorageMediumCold},
+			},
+			expPrice: askUnsealPrice,
+		},
+		"ask price when the node cannot estimate": {
+			node:     testnodes.NewTestRetrievalProviderNode(),
+			opts:     []retrievalimpl.RetrievalProviderOption{pricer},
+			expPrice: askUnsealPrice,
+		},
+		"ask price when estimating fails": {
+			node: &estimatingProviderNode{
+				TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+				err:                       errors.New("something went wrong"),
+			},
+			opts:     []retrievalimpl.RetrievalProviderOption{pricer},
+			expPrice: askUnsealPrice,
+		},
+		"ask price for hot storage": {
+			node: &estimatingProviderNode{
+				TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+				estimate:                  retrievalmarket.UnsealCostEstimate{SectorSize: 32 << 30, Medium: retrievalmarket.StorageMediumHot},
+			},
+			opts:     []retrievalimpl.RetrievalProviderOption{pricer},
+			expPrice: askUnsealPrice,
+		},
+		"higher price for cold storage": {
+			node: &estimatingProviderNode{
+				TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+				estimate:                  retrievalmarket.UnsealCostEstimate{SectorSize: 32 << 30, Medium: retrievalmarket.StorageMediumCold},
+			},
+			opts:     []retrievalimpl.RetrievalProviderOption{pricer},
+			expPrice: big.Mul(askUnsealPrice, big.NewInt(5)),
+		},
+		"free when already unsealed": {
+			node: &estimatingProviderNode{
+				TestRetrievalProviderNode: testnodes.NewTestRetrievalProviderNode(),
+				estimate:                  retrievalmarket.UnsealCostEstimate{Medium: retrievalmarket.StorageMediumCold, Unsealed: true},
+			},
+			opts:     []retrievalimpl.RetrievalProviderOption{pricer},
+			expPrice: big.Zero(),
+		},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			response := query(t, tc.node, tc.opts...)
+			require.True(t, tc.expPrice.Equals(response.UnsealPrice), "expected %s, got %s", tc.expPrice, response.UnsealPrice)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package migrations
 
 import (
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
@@ -11,11 +12,13 @@ import (
 	"github.com/filecoin-project/go-ds-versioning/pkg/versioned"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	piecemigrations "github.com/filecoin-project/go-fil-markets/piecestore/migrations"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
 )
 
 //go:generate cbor-gen-for Query0 QueryResponse0 DealProposal0 DealResponse0 Params0 QueryParams0 DealPayment0 ClientDealState0 ProviderDealState0 PaymentInfo0 RetrievalPeer0 Ask0
@@ -188,6 +191,7 @@ func MigrateQueryResponse0To1(oldQr QueryResponse0) retrievalmarket.QueryRespons
 		MaxPaymentIntervalIncrease: oldQr.MaxPaymentIntervalIncrease,
 		Message:                    oldQr.Message,
 		UnsealPrice:                oldQr.UnsealPrice,
+		PriorityPricePerByte:       big.Zero(),
 	}
 }
 
@@ -270,6 +274,7 @@ func MigrateClientDealState0To1(oldDs *ClientDealState0) (*retrievalmarket.Clien
 		WaitMsgCID:           oldDs.WaitMsgCID,
 		VoucherShortfall:     oldDs.VoucherShortfall,
 		LegacyProtocol:       true,
+		FundsToppedUp:        big.Zero(),
 	}, nil
 }
 
@@ -313,13 +318,43 @@ var ClientMigrations = versioned.BuilderList{
 	versioned.NewVersionedBuilder(MigrateClientDealState0To1, versioning.VersionKey("1")),
 }
 
+// ProviderFilterKeys are the keys in the provider's store of retrieval deals that
+// hold the retrieval ask rather than deals
+var ProviderFilterKeys = []string{"/retrieval-ask", "/retrieval-ask/latest", "/retrieval-ask/1/latest", "/retrieval-ask/versions/current"}
+
+// MinerAskFilterKeys are the keys in the provider's store of retrieval deals that hold
+// the retrieval ask of a miner served besides the one the provider was created with
+func MinerAskFilterKeys(miner address.Address) []string {
+	prefix := "/retrieval-miner-asks/" + miner.String()
+	return []string{prefix + "/latest", prefix + "/1/latest", prefix + "/versions/current"}
+}
+
 // ProviderMigrations are migrations for the providers's store of retrieval deals
-var ProviderMigrations = versioned.BuilderList{
-	versioned.NewVersionedBuilder(MigrateProviderDealState0To1, versioning.VersionKey("1")).
-		FilterKeys([]string{"/retrieval-ask", "/retrieval-ask/latest", "/retrieval-ask/1/latest", "/retrieval-ask/versions/current"}),
+var ProviderMigrations = ProviderMigrationsFiltering(nil)
+
+// ProviderMigrationsFiltering are migrations for the provider's store of retrieval
+// deals that also skip the given keys, besides ProviderFilterKeys
+func ProviderMigrationsFiltering(keys []string) versioned.BuilderList {
+	filterKeys := append(append([]string{}, ProviderFilterKeys...), keys...)
+	return versioned.BuilderList{
+		versioned.NewVersionedBuilder(MigrateProviderDealState0To1, versioning.VersionKey("1")).
+			FilterKeys(filterKeys),
+	}
 }
 
 // AskMigrations are migrations for the providers's retrieval ask
 var AskMigrations = versioned.BuilderList{
 	versioned.NewVersionedBuilder(MigrateAsk0To1, versioning.VersionKey("1")),
 }
+
+// DryRunClientMigrations reports what the client's store of retrieval deals would
+// migrate to, without migrating it
+func DryRunClientMigrations(ds datastore.Batching) (migrationtools.Report, error) {
+	return migrationtools.DryRun(ds, MigrateClientDealState0To1, versioning.VersionKey("1"), nil)
+}
+
+// DryRunProviderMigrations reports what the provider's store of retrieval deals would
+// migrate to, without migrating it
+func DryRunProviderMigrations(ds datastore.Batching) (migrationtools.Report, error) {
+	return migrationtools.DryRun(ds, MigrateProviderDealState0To1, versioning.VersionKey("1"), ProviderFilterKeys)
+}
@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+//go:generate cbor-gen-for --map-encoding Params1 DealProposal1
+
+// Params1 is version 1 of Params, sent in deal proposals before clients could ask
+// for the final payment to be escrowed, pay for several intervals per voucher, ask
+// for priority or disable payment
+type Params1 struct {
+	Selector                *cbg.Deferred
+	PieceCID                *cid.Cid
+	PricePerByte            abi.TokenAmount
+	PaymentInterval         uint64
+	PaymentIntervalIncrease uint64
+	UnsealPrice             abi.TokenAmount
+}
+
+// DealProposal1 is version 1 of DealProposal
+type DealProposal1 struct {
+	PayloadCID cid.Cid
+	ID         retrievalmarket.DealID
+	// Params is named for the embedded params of DealProposal, which it is sent as
+	Params Params1
+}
+
+// Type method makes DealProposal1 usable as a voucher
+func (dp *DealProposal1) Type() datatransfer.TypeIdentifier {
+	return "RetrievalDealProposal/1"
+}
+
+// MigrateDealProposal1To2 migrates a deal proposal from a client that does not know
+// about escrowed or batched payments, priority or disabled payment to one without
+// priority that pays for each interval as it is sent
+func MigrateDealProposal1To2(oldDp DealProposal1) retrievalmarket.DealProposal {
+	return retrievalmarket.DealProposal{
+		PayloadCID: oldDp.PayloadCID,
+		ID:         oldDp.ID,
+		Params: retrievalmarket.Params{
+			Selector:                oldDp.Params.Selector,
+			PieceCID:                oldDp.Params.PieceCID,
+			PricePerByte:            oldDp.Params.PricePerByte,
+			PaymentInterval:         oldDp.Params.PaymentInterval,
+			PaymentIntervalIncrease: oldDp.Params.PaymentIntervalIncrease,
+			UnsealPrice:             oldDp.Params.UnsealPrice,
+		},
+	}
+}
+
+// DealProposal2To0 converts a deal proposal to the legacy proposal every earlier
+// provider accepts. It fails for proposals that ask for something earlier providers
+// do not support, rather than leaving it out of the deal. Payment batches are left
+// out, as earlier providers ask for each interval to be paid for separately
+func DealProposal2To0(dp retrievalmarket.DealProposal) (DealProposal0, error) {
+	if dp.EscrowFinalPayment {
+		return DealProposal0{}, xerrors.New("provider does not support escrowing the final payment")
+	}
+	if dp.Priority {
+		return DealProposal0{}, xerrors.New("provider does not support priority retrieval")
+	}
+	if dp.PaymentDisabled {
+		return DealProposal0{}, xerrors.New("provider does not support retrieval without payment")
+	}
+	return DealProposal0{
+		PayloadCID: dp.PayloadCID,
+		ID:         dp.ID,
+		Params0: Params0{
+			Selector:                dp.Selector,
+			PieceCID:                dp.PieceCID,
+			PricePerByte:            dp.PricePerByte,
+			PaymentInterval:         dp.PaymentInterval,
+			PaymentIntervalIncrease: dp.PaymentIntervalIncrease,
+			UnsealPrice:             dp.UnsealPrice,
+		},
+	}, nil
+}
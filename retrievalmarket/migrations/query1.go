@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+)
+
+//go:generate cbor-gen-for --map-encoding QueryResponse1
+
+// QueryResponse1 is version 1 of QueryResponse, sent on the query protocol before
+// responses quoted a priority price
+type QueryResponse1 struct {
+	Status        retrievalmarket.QueryResponseStatus
+	PieceCIDFound retrievalmarket.QueryItemStatus
+
+	Size uint64
+
+	PaymentAddress             address.Address
+	MinPricePerByte            abi.TokenAmount
+	MaxPaymentInterval         uint64
+	MaxPaymentIntervalIncrease uint64
+	Message                    string
+	UnsealPrice                abi.TokenAmount
+}
+
+// MigrateQueryResponse1To2 migrates a query response without a priority price to
+// a query response that does not offer priority retrieval
+func MigrateQueryResponse1To2(oldQr QueryResponse1) retrievalmarket.QueryResponse {
+	return retrievalmarket.QueryResponse{
+		Status:                     oldQr.Status,
+		PieceCIDFound:              oldQr.PieceCIDFound,
+		Size:                       oldQr.Size,
+		PaymentAddress:             oldQr.PaymentAddress,
+		MinPricePerByte:            oldQr.MinPricePerByte,
+		MaxPaymentInterval:         oldQr.MaxPaymentInterval,
+		MaxPaymentIntervalIncrease: oldQr.MaxPaymentIntervalIncrease,
+		Message:                    oldQr.Message,
+		UnsealPrice:                oldQr.UnsealPrice,
+		PriorityPricePerByte:       big.Zero(),
+	}
+}
+
+// QueryResponse2To1 converts a query response to one without a priority price, for
+// peers that only speak the query protocol from before responses quoted one
+func QueryResponse2To1(qr retrievalmarket.QueryResponse) QueryResponse1 {
+	return QueryResponse1{
+		Status:                     qr.Status,
+		PieceCIDFound:              qr.PieceCIDFound,
+		Size:                       qr.Size,
+		PaymentAddress:             qr.PaymentAddress,
+		MinPricePerByte:            qr.MinPricePerByte,
+		MaxPaymentInterval:         qr.MaxPaymentInterval,
+		MaxPaymentIntervalIncrease: qr.MaxPaymentIntervalIncrease,
+		Message:                    qr.Message,
+		UnsealPrice:                qr.UnsealPrice,
+	}
+}
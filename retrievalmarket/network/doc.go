@@ -4,6 +4,12 @@ Package network providers an abstraction over a libp2p host for managing retriev
 network.go - defines the interfaces that must be implemented to serve as a retrieval network
 deal-stream.go - implements the `RetrievalDealStream` interface, a data stream for retrieval deal traffic only
 query-stream.go  - implements the `RetrievalQueryStream` interface, a data stream for retrieval query traffic only
+possession_stream.go - implements the `PossessionStream` interface, for challenging a provider to prove it holds a payload
 libp2p_impl.go - provides the production implementation of the `RetrievalMarketNetwork` interface.
+protocols.go - registers each version of the retrieval market protocols, and the stream type that speaks it, with shared/protoregistry
+fuzz.go - the go-fuzz entry point for the messages read from retrieval market streams, built with the gofuzz tag
+
+Messages are read from streams with shared/cborlimit, which rejects messages over its size and nesting limits
+before decoding them, so that a malformed message from a peer cannot crash the node or exhaust its memory.
 */
 package network
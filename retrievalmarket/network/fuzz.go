@@ -0,0 +1,42 @@
+//go:build gofuzz
+// +build gofuzz
+
+package network
+
+import (
+	"bytes"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+// fuzzMessages are the messages read from retrieval market streams
+var fuzzMessages = []func() cbg.CBORUnmarshaler{
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.Query) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.QueryResponse) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.PieceRequest) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.PieceResponse) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.InlineQuery) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.SignedInlineQueryResponse) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.PossessionChallenge) },
+	func() cbg.CBORUnmarshaler { return new(retrievalmarket.PossessionProof) },
+	func() cbg.CBORUnmarshaler { return new(migrations.Query0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.QueryResponse0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.QueryResponse1) },
+}
+
+// Fuzz is the entry point for go-fuzz. The first byte of data picks the message to
+// decode the rest of it as, the way the network reads messages from streams
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	msg := fuzzMessages[int(data[0])%len(fuzzMessages)]()
+	if err := cborlimit.Read(bytes.NewReader(data[1:]), msg); err != nil {
+		return 0
+	}
+	return 1
+}
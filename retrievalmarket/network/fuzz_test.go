@@ -0,0 +1,83 @@
+package network_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestMessageDecodingFuzz(t *testing.T) {
+	cids := shared_testutil.GenerateCids(2)
+	query := retrievalmarket.NewQueryV1(cids[0], &cids[1])
+	queryResponse := shared_testutil.MakeTestQueryResponse()
+	pieceRequest := retrievalmarket.PieceRequest{PieceCID: cids[1], Format: retrievalmarket.PieceFormatCAR}
+	pieceResponse := retrievalmarket.PieceResponse{Status: retrievalmarket.PieceResponseOk, Size: 1024, Message: "sending piece"}
+	inlineQuery := retrievalmarket.InlineQuery{Query: query, MaxSize: 1 << 20}
+	inlineResponse := retrievalmarket.SignedInlineQueryResponse{
+		Response: retrievalmarket.InlineQueryResponse{
+			PayloadCID: cids[0],
+			Miner:      address.TestAddress,
+			Response:   queryResponse,
+			Data:       []byte("inline payload"),
+		},
+		Signature: shared_testutil.MakeTestSignature(),
+	}
+	challenge := retrievalmarket.PossessionChallenge{Query: query, Nonce: []byte("nonce"), Paths: 4}
+	proof := retrievalmarket.PossessionProof{Status: retrievalmarket.PossessionProofOk, Data: []byte("proof blocks")}
+
+	testCases := map[string]struct {
+		newMsg func() cbg.CBORUnmarshaler
+		seed   cbg.CBORMarshaler
+	}{
+		"Query": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.Query) },
+			seed:   &query,
+		},
+		"QueryResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.QueryResponse) },
+			seed:   &queryResponse,
+		},
+		"PieceRequest": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.PieceRequest) },
+			seed:   &pieceRequest,
+		},
+		"PieceResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.PieceResponse) },
+			seed:   &pieceResponse,
+		},
+		"InlineQuery": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.InlineQuery) },
+			seed:   &inlineQuery,
+		},
+		"SignedInlineQueryResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.SignedInlineQueryResponse) },
+			seed:   &inlineResponse,
+		},
+		"PossessionChallenge": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.PossessionChallenge) },
+			seed:   &challenge,
+		},
+		"PossessionProof": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(retrievalmarket.PossessionProof) },
+			seed:   &proof,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := cborutil.Dump(tc.seed)
+			require.NoError(t, err)
+			shared_testutil.FuzzDecoder(t, func(data []byte) error {
+				return cborlimit.Read(bytes.NewReader(data), tc.newMsg())
+			}, [][]byte{encoded}, 2000)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type inlineQueryStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ InlineQueryStream = (*inlineQueryStream)(nil)
+
+func (qs *inlineQueryStream) ReadInlineQuery() (retrievalmarket.InlineQuery, error) {
+	var q retrievalmarket.InlineQuery
+
+	if err := cborlimit.Read(qs.buffered, &q); err != nil {
+		log.Warn(err)
+		return retrievalmarket.InlineQuery{}, err
+	}
+
+	return q, nil
+}
+
+func (qs *inlineQueryStream) WriteInlineQuery(q retrievalmarket.InlineQuery) error {
+	return cborutil.WriteCborRPC(qs.rw, &q)
+}
+
+func (qs *inlineQueryStream) ReadInlineQueryResponse() (retrievalmarket.SignedInlineQueryResponse, error) {
+	var resp retrievalmarket.SignedInlineQueryResponse
+
+	if err := cborlimit.Read(qs.buffered, &resp); err != nil {
+		log.Warn(err)
+		return retrievalmarket.SignedInlineQueryResponse{}, err
+	}
+
+	return resp, nil
+}
+
+func (qs *inlineQueryStream) WriteInlineQueryResponse(resp retrievalmarket.SignedInlineQueryResponse) error {
+	return cborutil.WriteCborRPC(qs.rw, &resp)
+}
+
+func (qs *inlineQueryStream) RemotePeer() peer.ID {
+	return qs.p
+}
+
+func (qs *inlineQueryStream) Close() error {
+	return qs.rw.Close()
+}
@@ -1,7 +1,6 @@
 package network
 
 import (
-	"bufio"
 	"context"
 	"time"
 
@@ -14,7 +13,7 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/xerrors"
 
-	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/protoregistry"
 )
 
 const defaultMaxStreamOpenAttempts = 5
@@ -46,15 +45,14 @@ func SupportedProtocols(supportedProtocols []protocol.ID) Option {
 // NewFromLibp2pHost constructs a new instance of the RetrievalMarketNetwork from a
 // libp2p host
 func NewFromLibp2pHost(h host.Host, options ...Option) RetrievalMarketNetwork {
+	protocols := newProtocolRegistry()
 	impl := &libp2pRetrievalMarketNetwork{
 		host:                  h,
+		protocols:             protocols,
 		maxStreamOpenAttempts: defaultMaxStreamOpenAttempts,
 		minAttemptDuration:    defaultMinAttemptDuration,
 		maxAttemptDuration:    defaultMaxAttemptDuration,
-		supportedProtocols: []protocol.ID{
-			retrievalmarket.QueryProtocolID,
-			retrievalmarket.OldQueryProtocolID,
-		},
+		supportedProtocols:    protocols.IDs(queryProtocol, true),
 	}
 	for _, option := range options {
 		option(impl)
@@ -67,8 +65,13 @@ func NewFromLibp2pHost(h host.Host, options ...Option) RetrievalMarketNetwork {
 // It implements the RetrievalMarketNetwork API.
 type libp2pRetrievalMarketNetwork struct {
 	host host.Host
+	// protocols are the versions of each protocol the network speaks, and their codecs
+	protocols *protoregistry.Registry
 	// inbound messages from the network are forwarded to the receiver
 	receiver              RetrievalReceiver
+	pieceReceiver         PieceReceiver
+	inlineQueryReceiver   InlineQueryReceiver
+	possessionReceiver    PossessionReceiver
 	maxStreamOpenAttempts float64
 	minAttemptDuration    time.Duration
 	maxAttemptDuration    time.Duration
@@ -82,11 +85,65 @@ func (impl *libp2pRetrievalMarketNetwork) NewQueryStream(id peer.ID) (RetrievalQ
 		log.Warn(err)
 		return nil, err
 	}
-	buffered := bufio.NewReaderSize(s, 16)
-	if s.Protocol() == retrievalmarket.OldQueryProtocolID {
-		return &oldQueryStream{p: id, rw: s, buffered: buffered}, nil
+	qs, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return qs.(RetrievalQueryStream), nil
+}
+
+// NewPieceStream creates a new PieceStream using the provided peer.ID
+func (impl *libp2pRetrievalMarketNetwork) NewPieceStream(id peer.ID) (PieceStream, error) {
+	s, err := impl.openStream(context.Background(), id, impl.protocols.IDs(pieceProtocol, true))
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	ps, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return ps.(PieceStream), nil
+}
+
+// NewInlineQueryStream creates a new InlineQueryStream using the provided peer.ID
+func (impl *libp2pRetrievalMarketNetwork) NewInlineQueryStream(id peer.ID) (InlineQueryStream, error) {
+	s, err := impl.openStream(context.Background(), id, impl.protocols.IDs(inlineQueryProtocol, true))
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	qs, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return qs.(InlineQueryStream), nil
+}
+
+// NewPossessionStream creates a new PossessionStream using the provided peer.ID
+func (impl *libp2pRetrievalMarketNetwork) NewPossessionStream(id peer.ID) (PossessionStream, error) {
+	s, err := impl.openStream(context.Background(), id, impl.protocols.IDs(possessionProtocol, true))
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	ps, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return ps.(PossessionStream), nil
+}
+
+// wrap wraps a stream in the codec for the protocol version negotiated on it. The
+// stream is reset if the network has no codec for it
+func (impl *libp2pRetrievalMarketNetwork) wrap(id peer.ID, s network.Stream) (interface{}, error) {
+	wrapped, err := impl.protocols.Wrap(id, s)
+	if err != nil {
+		log.Warn(err)
+		s.Reset() // nolint: errcheck,gosec
+		return nil, err
 	}
-	return &queryStream{p: id, rw: s, buffered: buffered}, nil
+	return wrapped, nil
 }
 
 func (impl *libp2pRetrievalMarketNetwork) openStream(ctx context.Context, id peer.ID, protocols []protocol.ID) (network.Stream, error) {
@@ -126,13 +183,52 @@ func (impl *libp2pRetrievalMarketNetwork) SetDelegate(r RetrievalReceiver) error
 	return nil
 }
 
-// StopHandlingRequests unsets the RetrievalReceiver and would perform any other necessary
-// shutdown logic.
+// SetPieceDelegate sets a PieceReceiver to handle requests for whole pieces
+func (impl *libp2pRetrievalMarketNetwork) SetPieceDelegate(r PieceReceiver) error {
+	impl.pieceReceiver = r
+	for _, proto := range impl.protocols.IDs(pieceProtocol, true) {
+		impl.host.SetStreamHandler(proto, impl.handleNewPieceStream)
+	}
+	return nil
+}
+
+// SetInlineQueryDelegate sets an InlineQueryReceiver to handle queries for payloads sent inline
+func (impl *libp2pRetrievalMarketNetwork) SetInlineQueryDelegate(r InlineQueryReceiver) error {
+	impl.inlineQueryReceiver = r
+	for _, proto := range impl.protocols.IDs(inlineQueryProtocol, true) {
+		impl.host.SetStreamHandler(proto, impl.handleNewInlineQueryStream)
+	}
+	return nil
+}
+
+// SetPossessionDelegate sets a PossessionReceiver to handle challenges to prove possession
+func (impl *libp2pRetrievalMarketNetwork) SetPossessionDelegate(r PossessionReceiver) error {
+	impl.possessionReceiver = r
+	for _, proto := range impl.protocols.IDs(possessionProtocol, true) {
+		impl.host.SetStreamHandler(proto, impl.handleNewPossessionStream)
+	}
+	return nil
+}
+
+// StopHandlingRequests unsets the RetrievalReceiver, PieceReceiver, InlineQueryReceiver and
+// PossessionReceiver and would perform any other necessary shutdown logic.
 func (impl *libp2pRetrievalMarketNetwork) StopHandlingRequests() error {
 	impl.receiver = nil
 	for _, proto := range impl.supportedProtocols {
 		impl.host.RemoveStreamHandler(proto)
 	}
+	impl.pieceReceiver = nil
+	for _, proto := range impl.protocols.IDs(pieceProtocol, true) {
+		impl.host.RemoveStreamHandler(proto)
+	}
+	impl.inlineQueryReceiver = nil
+	for _, proto := range impl.protocols.IDs(inlineQueryProtocol, true) {
+		impl.host.RemoveStreamHandler(proto)
+	}
+	impl.possessionReceiver = nil
+	for _, proto := range impl.protocols.IDs(possessionProtocol, true) {
+		impl.host.RemoveStreamHandler(proto)
+	}
 	return nil
 }
 
@@ -142,15 +238,42 @@ func (impl *libp2pRetrievalMarketNetwork) handleNewQueryStream(s network.Stream)
 		s.Reset() // nolint: errcheck,gosec
 		return
 	}
-	remotePID := s.Conn().RemotePeer()
-	buffered := bufio.NewReaderSize(s, 16)
-	var qs RetrievalQueryStream
-	if s.Protocol() == retrievalmarket.OldQueryProtocolID {
-		qs = &oldQueryStream{remotePID, s, buffered}
-	} else {
-		qs = &queryStream{remotePID, s, buffered}
+	if qs, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.receiver.HandleQueryStream(qs.(RetrievalQueryStream))
+	}
+}
+
+func (impl *libp2pRetrievalMarketNetwork) handleNewPieceStream(s network.Stream) {
+	if impl.pieceReceiver == nil {
+		log.Warn("no piece receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	if ps, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.pieceReceiver.HandlePieceStream(ps.(PieceStream))
+	}
+}
+
+func (impl *libp2pRetrievalMarketNetwork) handleNewInlineQueryStream(s network.Stream) {
+	if impl.inlineQueryReceiver == nil {
+		log.Warn("no inline query receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	if qs, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.inlineQueryReceiver.HandleInlineQueryStream(qs.(InlineQueryStream))
+	}
+}
+
+func (impl *libp2pRetrievalMarketNetwork) handleNewPossessionStream(s network.Stream) {
+	if impl.possessionReceiver == nil {
+		log.Warn("no possession receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	if ps, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.possessionReceiver.HandlePossessionStream(ps.(PossessionStream))
 	}
-	impl.receiver.HandleQueryStream(qs)
 }
 
 func (impl *libp2pRetrievalMarketNetwork) ID() peer.ID {
@@ -33,6 +33,7 @@ func TestQueryStreamSendReceiveQuery(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnlyV100    bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -41,6 +42,9 @@ func TestQueryStreamSendReceiveQuery(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports v1.0.0 queries": {
+			receiverOnlyV100: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -51,9 +55,12 @@ func TestQueryStreamSendReceiveQuery(t *testing.T) {
 			} else {
 				fromNetwork = network.NewFromLibp2pHost(td.Host1)
 			}
-			if data.receiverDisabledNew {
+			switch {
+			case data.receiverDisabledNew:
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedProtocols([]protocol.ID{retrievalmarket.OldQueryProtocolID}))
-			} else {
+			case data.receiverOnlyV100:
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedProtocols([]protocol.ID{retrievalmarket.QueryProtocolID100}))
+			default:
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
 			toHost := td.Host2.ID()
@@ -83,6 +90,7 @@ func TestQueryStreamSendReceiveQueryResponse(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnlyV100    bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -91,6 +99,9 @@ func TestQueryStreamSendReceiveQueryResponse(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports v1.0.0 queries": {
+			receiverOnlyV100: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -101,9 +112,12 @@ func TestQueryStreamSendReceiveQueryResponse(t *testing.T) {
 			} else {
 				fromNetwork = network.NewFromLibp2pHost(td.Host1)
 			}
-			if data.receiverDisabledNew {
+			switch {
+			case data.receiverDisabledNew:
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedProtocols([]protocol.ID{retrievalmarket.OldQueryProtocolID}))
-			} else {
+			case data.receiverOnlyV100:
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedProtocols([]protocol.ID{retrievalmarket.QueryProtocolID100}))
+			default:
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
 			toHost := td.Host2.ID()
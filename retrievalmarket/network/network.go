@@ -1,6 +1,8 @@
 package network
 
 import (
+	"io"
+
 	"github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 
@@ -17,6 +19,43 @@ type RetrievalQueryStream interface {
 	WriteQuery(retrievalmarket.Query) error
 	ReadQueryResponse() (retrievalmarket.QueryResponse, error)
 	WriteQueryResponse(retrievalmarket.QueryResponse) error
+	RemotePeer() peer.ID
+	Close() error
+}
+
+// PieceStream is the API needed to request a whole piece and send or receive its
+// data. The piece's data follows a PieceResponse with status PieceResponseOk, and
+// is read and written on the stream itself
+type PieceStream interface {
+	io.Reader
+	io.Writer
+	ReadPieceRequest() (retrievalmarket.PieceRequest, error)
+	WritePieceRequest(retrievalmarket.PieceRequest) error
+	ReadPieceResponse() (retrievalmarket.PieceResponse, error)
+	WritePieceResponse(retrievalmarket.PieceResponse) error
+	RemotePeer() peer.ID
+	Close() error
+}
+
+// InlineQueryStream is the API needed to send and receive queries for payloads small
+// enough to be sent with the query response
+type InlineQueryStream interface {
+	ReadInlineQuery() (retrievalmarket.InlineQuery, error)
+	WriteInlineQuery(retrievalmarket.InlineQuery) error
+	ReadInlineQueryResponse() (retrievalmarket.SignedInlineQueryResponse, error)
+	WriteInlineQueryResponse(retrievalmarket.SignedInlineQueryResponse) error
+	RemotePeer() peer.ID
+	Close() error
+}
+
+// PossessionStream is the API needed to challenge a provider to prove it holds a
+// payload, and to answer the challenge
+type PossessionStream interface {
+	ReadPossessionChallenge() (retrievalmarket.PossessionChallenge, error)
+	WritePossessionChallenge(retrievalmarket.PossessionChallenge) error
+	ReadPossessionProof() (retrievalmarket.PossessionProof, error)
+	WritePossessionProof(retrievalmarket.PossessionProof) error
+	RemotePeer() peer.ID
 	Close() error
 }
 
@@ -28,6 +67,27 @@ type RetrievalReceiver interface {
 	HandleQueryStream(RetrievalQueryStream)
 }
 
+// PieceReceiver is the API for handling requests for whole pieces
+type PieceReceiver interface {
+	// HandlePieceStream reads a piece request from the PieceStream provided and
+	// sends the piece in response
+	HandlePieceStream(PieceStream)
+}
+
+// InlineQueryReceiver is the API for handling queries for payloads sent inline
+type InlineQueryReceiver interface {
+	// HandleInlineQueryStream reads a query from the InlineQueryStream provided and
+	// answers it, with the payload if it is small enough
+	HandleInlineQueryStream(InlineQueryStream)
+}
+
+// PossessionReceiver is the API for handling challenges to prove possession of a payload
+type PossessionReceiver interface {
+	// HandlePossessionStream reads a challenge from the PossessionStream provided and
+	// answers it with a proof
+	HandlePossessionStream(PossessionStream)
+}
+
 // RetrievalMarketNetwork is the API for creating query and deal streams and
 // delegating responders to those streams.
 type RetrievalMarketNetwork interface {
@@ -37,8 +97,26 @@ type RetrievalMarketNetwork interface {
 	// SetDelegate sets a RetrievalReceiver implementer to handle stream data
 	SetDelegate(RetrievalReceiver) error
 
-	// StopHandlingRequests unsets the RetrievalReceiver and would perform any other necessary
-	// shutdown logic.
+	// NewPieceStream creates a new PieceStream implementer using the provided peer.ID
+	NewPieceStream(peer.ID) (PieceStream, error)
+
+	// SetPieceDelegate sets a PieceReceiver implementer to handle requests for whole pieces
+	SetPieceDelegate(PieceReceiver) error
+
+	// NewInlineQueryStream creates a new InlineQueryStream implementer using the provided peer.ID
+	NewInlineQueryStream(peer.ID) (InlineQueryStream, error)
+
+	// SetInlineQueryDelegate sets an InlineQueryReceiver implementer to handle queries for payloads sent inline
+	SetInlineQueryDelegate(InlineQueryReceiver) error
+
+	// NewPossessionStream creates a new PossessionStream implementer using the provided peer.ID
+	NewPossessionStream(peer.ID) (PossessionStream, error)
+
+	// SetPossessionDelegate sets a PossessionReceiver implementer to handle challenges to prove possession
+	SetPossessionDelegate(PossessionReceiver) error
+
+	// StopHandlingRequests unsets the RetrievalReceiver, PieceReceiver, InlineQueryReceiver and
+	// PossessionReceiver and would perform any other necessary shutdown logic.
 	StopHandlingRequests() error
 
 	// ID returns the peer id of the host for this network
@@ -10,6 +10,7 @@ import (
 
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 )
 
 type oldQueryStream struct {
@@ -23,7 +24,7 @@ var _ RetrievalQueryStream = (*oldQueryStream)(nil)
 func (qs *oldQueryStream) ReadQuery() (retrievalmarket.Query, error) {
 	var q migrations.Query0
 
-	if err := q.UnmarshalCBOR(qs.buffered); err != nil {
+	if err := cborlimit.Read(qs.buffered, &q); err != nil {
 		log.Warn(err)
 		return retrievalmarket.QueryUndefined, err
 
@@ -46,7 +47,7 @@ func (qs *oldQueryStream) WriteQuery(newQ retrievalmarket.Query) error {
 func (qs *oldQueryStream) ReadQueryResponse() (retrievalmarket.QueryResponse, error) {
 	var resp migrations.QueryResponse0
 
-	if err := resp.UnmarshalCBOR(qs.buffered); err != nil {
+	if err := cborlimit.Read(qs.buffered, &resp); err != nil {
 		log.Warn(err)
 		return retrievalmarket.QueryResponseUndefined, err
 	}
@@ -69,6 +70,10 @@ func (qs *oldQueryStream) WriteQueryResponse(newQr retrievalmarket.QueryResponse
 	return cborutil.WriteCborRPC(qs.rw, &qr)
 }
 
+func (qs *oldQueryStream) RemotePeer() peer.ID {
+	return qs.p
+}
+
 func (qs *oldQueryStream) Close() error {
 	return qs.rw.Close()
 }
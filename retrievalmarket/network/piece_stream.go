@@ -0,0 +1,69 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type pieceStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ PieceStream = (*pieceStream)(nil)
+
+func (ps *pieceStream) ReadPieceRequest() (retrievalmarket.PieceRequest, error) {
+	var req retrievalmarket.PieceRequest
+
+	if err := cborlimit.Read(ps.buffered, &req); err != nil {
+		log.Warn(err)
+		return retrievalmarket.PieceRequest{}, err
+	}
+
+	return req, nil
+}
+
+func (ps *pieceStream) WritePieceRequest(req retrievalmarket.PieceRequest) error {
+	return cborutil.WriteCborRPC(ps.rw, &req)
+}
+
+func (ps *pieceStream) ReadPieceResponse() (retrievalmarket.PieceResponse, error) {
+	var resp retrievalmarket.PieceResponse
+
+	if err := cborlimit.Read(ps.buffered, &resp); err != nil {
+		log.Warn(err)
+		return retrievalmarket.PieceResponseUndefined, err
+	}
+
+	return resp, nil
+}
+
+func (ps *pieceStream) WritePieceResponse(resp retrievalmarket.PieceResponse) error {
+	return cborutil.WriteCborRPC(ps.rw, &resp)
+}
+
+// Read reads the piece's data, which follows the response. It must read through the
+// buffer that the response was read from
+func (ps *pieceStream) Read(p []byte) (int, error) {
+	return ps.buffered.Read(p)
+}
+
+func (ps *pieceStream) Write(p []byte) (int, error) {
+	return ps.rw.Write(p)
+}
+
+func (ps *pieceStream) RemotePeer() peer.ID {
+	return ps.p
+}
+
+func (ps *pieceStream) Close() error {
+	return ps.rw.Close()
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type possessionStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ PossessionStream = (*possessionStream)(nil)
+
+func (ps *possessionStream) ReadPossessionChallenge() (retrievalmarket.PossessionChallenge, error) {
+	var c retrievalmarket.PossessionChallenge
+
+	if err := cborlimit.Read(ps.buffered, &c); err != nil {
+		log.Warn(err)
+		return retrievalmarket.PossessionChallenge{}, err
+	}
+
+	return c, nil
+}
+
+func (ps *possessionStream) WritePossessionChallenge(c retrievalmarket.PossessionChallenge) error {
+	return cborutil.WriteCborRPC(ps.rw, &c)
+}
+
+func (ps *possessionStream) ReadPossessionProof() (retrievalmarket.PossessionProof, error) {
+	var proof retrievalmarket.PossessionProof
+
+	if err := cborlimit.Read(ps.buffered, &proof); err != nil {
+		log.Warn(err)
+		return retrievalmarket.PossessionProof{}, err
+	}
+
+	return proof, nil
+}
+
+func (ps *possessionStream) WritePossessionProof(proof retrievalmarket.PossessionProof) error {
+	return cborutil.WriteCborRPC(ps.rw, &proof)
+}
+
+func (ps *possessionStream) RemotePeer() peer.ID {
+	return ps.p
+}
+
+func (ps *possessionStream) Close() error {
+	return ps.rw.Close()
+}
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/protoregistry"
+)
+
+// The names of the retrieval market protocols, which are their IDs without the version
+const (
+	queryProtocol       = "/fil/retrieval/qry"
+	pieceProtocol       = "/fil/retrieval/piece"
+	inlineQueryProtocol = "/fil/retrieval/qry-inline"
+	possessionProtocol  = "/fil/retrieval/possession"
+)
+
+// newProtocolRegistry returns a registry of every version of the retrieval market
+// protocols, with the codec that speaks each one
+func newProtocolRegistry() *protoregistry.Registry {
+	r := protoregistry.New()
+
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.QueryProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &queryStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.QueryProtocolID100, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &queryStream100{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.OldQueryProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &oldQueryStream{p: p, rw: s, buffered: buffered}
+	}})
+
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.PieceProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &pieceStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.InlineQueryProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &inlineQueryStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: retrievalmarket.PossessionProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &possessionStream{p: p, rw: s, buffered: buffered}
+	}})
+	return r
+}
@@ -9,6 +9,7 @@ import (
 	cborutil "github.com/filecoin-project/go-cbor-util"
 
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 )
 
 type queryStream struct {
@@ -22,7 +23,7 @@ var _ RetrievalQueryStream = (*queryStream)(nil)
 func (qs *queryStream) ReadQuery() (retrievalmarket.Query, error) {
 	var q retrievalmarket.Query
 
-	if err := q.UnmarshalCBOR(qs.buffered); err != nil {
+	if err := cborlimit.Read(qs.buffered, &q); err != nil {
 		log.Warn(err)
 		return retrievalmarket.QueryUndefined, err
 
@@ -38,7 +39,7 @@ func (qs *queryStream) WriteQuery(q retrievalmarket.Query) error {
 func (qs *queryStream) ReadQueryResponse() (retrievalmarket.QueryResponse, error) {
 	var resp retrievalmarket.QueryResponse
 
-	if err := resp.UnmarshalCBOR(qs.buffered); err != nil {
+	if err := cborlimit.Read(qs.buffered, &resp); err != nil {
 		log.Warn(err)
 		return retrievalmarket.QueryResponseUndefined, err
 	}
@@ -50,6 +51,10 @@ func (qs *queryStream) WriteQueryResponse(qr retrievalmarket.QueryResponse) erro
 	return cborutil.WriteCborRPC(qs.rw, &qr)
 }
 
+func (qs *queryStream) RemotePeer() peer.ID {
+	return qs.p
+}
+
 func (qs *queryStream) Close() error {
 	return qs.rw.Close()
 }
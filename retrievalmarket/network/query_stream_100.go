@@ -0,0 +1,64 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+// queryStream100 speaks version 1.0.0 of the query protocol, whose responses
+// quote no priority price
+type queryStream100 struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ RetrievalQueryStream = (*queryStream100)(nil)
+
+func (qs *queryStream100) ReadQuery() (retrievalmarket.Query, error) {
+	var q retrievalmarket.Query
+
+	if err := cborlimit.Read(qs.buffered, &q); err != nil {
+		log.Warn(err)
+		return retrievalmarket.QueryUndefined, err
+
+	}
+
+	return q, nil
+}
+
+func (qs *queryStream100) WriteQuery(q retrievalmarket.Query) error {
+	return cborutil.WriteCborRPC(qs.rw, &q)
+}
+
+func (qs *queryStream100) ReadQueryResponse() (retrievalmarket.QueryResponse, error) {
+	var resp migrations.QueryResponse1
+
+	if err := cborlimit.Read(qs.buffered, &resp); err != nil {
+		log.Warn(err)
+		return retrievalmarket.QueryResponseUndefined, err
+	}
+
+	return migrations.MigrateQueryResponse1To2(resp), nil
+}
+
+func (qs *queryStream100) WriteQueryResponse(qr retrievalmarket.QueryResponse) error {
+	oldQr := migrations.QueryResponse2To1(qr)
+	return cborutil.WriteCborRPC(qs.rw, &oldQr)
+}
+
+func (qs *queryStream100) RemotePeer() peer.ID {
+	return qs.p
+}
+
+func (qs *queryStream100) Close() error {
+	return qs.rw.Close()
+}
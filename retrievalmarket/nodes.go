@@ -9,6 +9,7 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
@@ -42,6 +43,12 @@ type RetrievalClientNode interface {
 
 	// GetKnownAddresses gets any on known multiaddrs for a given address, so we can add to the peer store
 	GetKnownAddresses(ctx context.Context, p RetrievalPeer, tok shared.TipSetToken) ([]ma.Multiaddr, error)
+
+	// GetMinerWorkerAddress returns the worker address associated with a miner
+	GetMinerWorkerAddress(ctx context.Context, miner address.Address, tok shared.TipSetToken) (address.Address, error)
+
+	// VerifySignature verifies a given set of data was signed properly by a given address's private key
+	VerifySignature(ctx context.Context, signature crypto.Signature, signer address.Address, plaintext []byte, tok shared.TipSetToken) (bool, error)
 }
 
 // RetrievalProviderNode are the node depedencies for a RetrevalProvider
@@ -52,4 +59,52 @@ type RetrievalProviderNode interface {
 	GetMinerWorkerAddress(ctx context.Context, miner address.Address, tok shared.TipSetToken) (address.Address, error)
 	UnsealSector(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (io.ReadCloser, error)
 	SavePaymentVoucher(ctx context.Context, paymentChannel address.Address, voucher *paych.SignedVoucher, proof []byte, expectedAmount abi.TokenAmount, tok shared.TipSetToken) (abi.TokenAmount, error)
+
+	// SignBytes signs the given data with the given address's private key
+	SignBytes(ctx context.Context, signer address.Address, b []byte) (*crypto.Signature, error)
+}
+
+// RemotePieceFetcher fetches the data of pieces a provider keeps a copy of outside its
+// sectors, such as on an HTTP server or in an S3 bucket, so they can be retrieved when
+// they cannot be unsealed
+type RemotePieceFetcher interface {
+	// FetchPiece returns a reader for the data of the piece at the given location, as
+	// recorded in the piece's PieceInfo
+	FetchPiece(ctx context.Context, pieceCID cid.Cid, location string) (io.ReadCloser, error)
+}
+
+// StorageMedium is the kind of storage a sector is kept on
+type StorageMedium uint64
+
+const (
+	// StorageMediumUnknown means the node does not know what the sector is kept on
+	StorageMediumUnknown StorageMedium = iota
+
+	// StorageMediumHot is fast storage, such as local disks, that a sector can be
+	// unsealed from straight away
+	StorageMediumHot
+
+	// StorageMediumCold is slow archival storage that a sector must be brought back
+	// from before it can be unsealed
+	StorageMediumCold
+)
+
+// UnsealCostEstimate is a node's estimate of the work it takes to unseal data from
+// a sector
+type UnsealCostEstimate struct {
+	// SectorSize is the size of the sector holding the data
+	SectorSize abi.SectorSize
+	// Medium is the kind of storage the sector is kept on
+	Medium StorageMedium
+	// Unsealed is true if the node keeps an unsealed copy of the data, so no
+	// unsealing is needed
+	Unsealed bool
+}
+
+// UnsealCostEstimator is implemented by provider nodes that can estimate the work it
+// takes to unseal data, so that a provider can price unsealing for each retrieval
+type UnsealCostEstimator interface {
+	// EstimateUnsealCost estimates the work it takes to unseal the data at the given
+	// offset and length in the given sector
+	EstimateUnsealCost(ctx context.Context, sectorID abi.SectorNumber, offset abi.UnpaddedPieceSize, length abi.UnpaddedPieceSize) (UnsealCostEstimate, error)
 }
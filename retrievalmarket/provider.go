@@ -2,8 +2,14 @@ package retrievalmarket
 
 import (
 	"context"
+	"io"
+
+	"github.com/filecoin-project/go-address"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
 )
 
 // ProviderSubscriber is a callback that is registered to listen for retrieval events on a provider
@@ -27,10 +33,49 @@ type RetrievalProvider interface {
 	// GetAsk returns the retrieval providers pricing information
 	GetAsk() *Ask
 
+	// Miners returns the miners the provider serves retrievals for
+	Miners() []address.Address
+
+	// SetMinerAsk sets the retrieval payment parameters that the given miner will accept
+	SetMinerAsk(miner address.Address, ask *Ask) error
+
+	// GetMinerAsk returns the pricing information of the given miner
+	GetMinerAsk(miner address.Address) (*Ask, error)
+
 	// SubscribeToEvents listens for events that happen related to client retrievals
 	SubscribeToEvents(subscriber ProviderSubscriber) Unsubscribe
 
 	ListDeals() map[ProviderDealIdentifier]ProviderDealState
+
+	// DealStateSnapshot returns the provider deal state machine definition
+	// along with the number of deals currently in each state
+	DealStateSnapshot() (fsmexport.Snapshot, error)
+
+	// DealSummary returns the number of deals in each state, the oldest deal in each
+	// state and how many deals have been in their state for longer than expected
+	DealSummary() (DealSummary, error)
+
+	// Stats returns rolling statistics of the provider's deal throughput
+	Stats() ProviderStats
+
+	// EventStats returns the number of events published to subscribers, and the
+	// number dropped because a subscriber fell behind
+	EventStats() eventbus.Stats
+
+	// HandlerStats returns how many deal state handlers are running, and how many
+	// are waiting for a worker
+	HandlerStats() handlerpool.Stats
+
+	// ListPaymentDefaults returns the clients that have stopped paying part way
+	// through a deal, with how many times they have done so
+	ListPaymentDefaults() ([]ClientPaymentDefaults, error)
+
+	// ExportDeals writes the provider's in-flight deals to w, so that another
+	// provider instance can take over serving them with ImportDeals
+	ExportDeals(ctx context.Context, w io.Writer) ([]ProviderDealIdentifier, error)
+
+	// ImportDeals reads deals written by ExportDeals and resumes serving them
+	ImportDeals(ctx context.Context, r io.Reader) ([]ProviderDealIdentifier, error)
 }
 
 // AskStore is an interface which provides access to a persisted retrieval Ask
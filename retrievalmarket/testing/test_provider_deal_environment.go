@@ -2,12 +2,19 @@
 package testing
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/go-fil-markets/piecestore"
 	rm "github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	retrievalimpl "github.com/filecoin-project/go-fil-markets/retrievalmarket/impl"
 )
@@ -22,6 +29,10 @@ type TestProviderDealEnvironment struct {
 	UntrackTransferError    error
 	CloseDataTransferError  error
 	DeleteStoreError        error
+	FetchRemotePieceData    []byte
+	FetchRemotePieceError   error
+	StagedPieceData         []byte
+	TransferSlotsFull       bool
 }
 
 // NewTestProviderDealEnvironment returns a new TestProviderDealEnvironment instance
@@ -36,6 +47,11 @@ func (te *TestProviderDealEnvironment) Node() rm.RetrievalProviderNode {
 	return te.node
 }
 
+// UnsealSector unseals with the provider node instance, whichever miner it is asked for
+func (te *TestProviderDealEnvironment) UnsealSector(ctx context.Context, miner *address.Address, sectorID abi.SectorNumber, offset, length abi.UnpaddedPieceSize) (io.ReadCloser, error) {
+	return te.node.UnsealSector(ctx, sectorID, offset, length)
+}
+
 func (te *TestProviderDealEnvironment) DeleteStore(storeID multistore.StoreID) error {
 	return te.DeleteStoreError
 }
@@ -44,6 +60,21 @@ func (te *TestProviderDealEnvironment) ReadIntoBlockstore(storeID multistore.Sto
 	return te.ReadIntoBlockstoreError
 }
 
+func (te *TestProviderDealEnvironment) FetchRemotePiece(_ context.Context, _ piecestore.PieceInfo) (io.ReadCloser, error) {
+	if te.FetchRemotePieceError != nil {
+		return nil, te.FetchRemotePieceError
+	}
+	return ioutil.NopCloser(bytes.NewReader(te.FetchRemotePieceData)), nil
+}
+
+// OpenStagedPiece returns StagedPieceData, if it is set
+func (te *TestProviderDealEnvironment) OpenStagedPiece(_ cid.Cid) (io.ReadCloser, bool) {
+	if te.StagedPieceData == nil {
+		return nil, false
+	}
+	return ioutil.NopCloser(bytes.NewReader(te.StagedPieceData)), true
+}
+
 func (te *TestProviderDealEnvironment) TrackTransfer(deal rm.ProviderDealState) error {
 	return te.TrackTransferError
 }
@@ -60,6 +91,11 @@ func (te *TestProviderDealEnvironment) CloseDataTransfer(_ context.Context, _ da
 	return te.CloseDataTransferError
 }
 
+// TransferSlot returns true unless TransferSlotsFull is set
+func (te *TestProviderDealEnvironment) TransferSlot(deal rm.ProviderDealState) bool {
+	return !te.TransferSlotsFull
+}
+
 // TrivialTestDecider is a shortest possible DealDecider that accepts all deals
 var TrivialTestDecider retrievalimpl.DealDecider = func(_ context.Context, _ rm.ProviderDealState) (bool, string, error) {
 	return true, "", nil
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -18,20 +19,38 @@ import (
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/paych"
 
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 )
 
-//go:generate cbor-gen-for --map-encoding Query QueryResponse DealProposal DealResponse Params QueryParams DealPayment ClientDealState ProviderDealState PaymentInfo RetrievalPeer Ask
+//go:generate cbor-gen-for --map-encoding Query QueryResponse DealProposal DealResponse Params QueryParams DealPayment ClientDealState ProviderDealState PaymentInfo RetrievalPeer Ask PieceRequest PieceResponse InlineQuery InlineQueryResponse SignedInlineQueryResponse PossessionChallenge PossessionProof
 
 // QueryProtocolID is the protocol for querying information about retrieval
 // deal parameters
-const QueryProtocolID = protocol.ID("/fil/retrieval/qry/1.0.0")
+const QueryProtocolID = protocol.ID("/fil/retrieval/qry/1.1.0")
+
+// QueryProtocolID100 is the version of the query protocol before query responses
+// quoted a priority price. Responses sent on it leave the priority price out
+const QueryProtocolID100 = protocol.ID("/fil/retrieval/qry/1.0.0")
 
 // OldQueryProtocolID is the old query protocol for tuple structs
 const OldQueryProtocolID = protocol.ID("/fil/retrieval/qry/0.0.1")
 
+// PieceProtocolID is the protocol for retrieving a whole piece by its PieceCID
+const PieceProtocolID = protocol.ID("/fil/retrieval/piece/1.0.0")
+
+// InlineQueryProtocolID is the protocol for querying a provider for a payload small
+// enough to be sent with the query response
+const InlineQueryProtocolID = protocol.ID("/fil/retrieval/qry-inline/1.1.0")
+
+// PossessionProtocolID is the protocol for challenging a provider to prove it holds
+// a payload before paying it to unseal the payload
+const PossessionProtocolID = protocol.ID("/fil/retrieval/possession/1.0.0")
+
 // Unsubscribe is a function that unsubscribes a subscriber for either the
 // client or the provider
 type Unsubscribe func()
@@ -66,6 +85,65 @@ type ClientDealState struct {
 	WaitMsgCID           *cid.Cid // the CID of any message the client deal is waiting for
 	VoucherShortfall     abi.TokenAmount
 	LegacyProtocol       bool
+	FundsToppedUp        abi.TokenAmount // funds added to the payment channel automatically after running out
+
+	// TransferStatus, TransferQueued, TransferSent and TransferReceived are the
+	// status and byte counts of the data transfer channel for the deal, as last
+	// reported by the data transfer manager. The byte counts are updated every few
+	// seconds while the status is unchanged
+	TransferStatus   datatransfer.Status
+	TransferQueued   uint64
+	TransferSent     uint64
+	TransferReceived uint64
+
+	// VerifiedAgainstPiece is true if the client recomputed the CommP of the data
+	// it retrieved and found it matches the deal's piece CID
+	VerifiedAgainstPiece bool
+
+	// TimeoutReason is the limit the deal exceeded, if the client cancelled it for
+	// running too long
+	TimeoutReason DealTimeoutReason
+}
+
+// DealTimeouts limit how long a client waits on a retrieval deal before cancelling
+// it. A limit left at zero is not applied
+type DealTimeouts struct {
+	// MaxDuration is how long the deal may run in total
+	MaxDuration time.Duration
+	// MaxStall is how long the deal may go without receiving any data
+	MaxStall time.Duration
+}
+
+// DealTimeoutReason is the limit a client retrieval deal exceeded
+type DealTimeoutReason uint64
+
+const (
+	// DealTimeoutNone means the deal has not timed out
+	DealTimeoutNone DealTimeoutReason = iota
+
+	// DealTimeoutMaxDuration means the deal ran for longer than its MaxDuration
+	DealTimeoutMaxDuration
+
+	// DealTimeoutMaxStall means the deal received no data for longer than its MaxStall
+	DealTimeoutMaxStall
+)
+
+// DealTimeoutError is the reason a client cancels a retrieval deal that exceeded
+// one of its DealTimeouts
+type DealTimeoutError struct {
+	Reason DealTimeoutReason
+	Limit  time.Duration
+}
+
+func (e *DealTimeoutError) Error() string {
+	switch e.Reason {
+	case DealTimeoutMaxDuration:
+		return fmt.Sprintf("retrieval timed out: took longer than %s", e.Limit)
+	case DealTimeoutMaxStall:
+		return fmt.Sprintf("retrieval timed out: received no data for %s", e.Limit)
+	default:
+		return fmt.Sprintf("retrieval timed out after %s", e.Limit)
+	}
 }
 
 // ProviderDealState is the current state of a deal from the point of view
@@ -82,6 +160,19 @@ type ProviderDealState struct {
 	Message         string
 	CurrentInterval uint64
 	LegacyProtocol  bool
+
+	// TransferStatus, TransferQueued, TransferSent and TransferReceived are the
+	// status and byte counts of the data transfer channel for the deal, as last
+	// reported by the data transfer manager. The byte counts are updated every few
+	// seconds while the status is unchanged
+	TransferStatus   datatransfer.Status
+	TransferQueued   uint64
+	TransferSent     uint64
+	TransferReceived uint64
+
+	// Miner is the miner whose sectors hold the deal's piece, or nil for the miner
+	// the provider was created with
+	Miner *address.Address
 }
 
 // Identifier provides a unique id for this provider deal
@@ -107,6 +198,44 @@ type RetrievalPeer struct {
 	PieceCID *cid.Cid
 }
 
+// ShardRetrieval is what is needed to retrieve one shard of a payload that was
+// split across several deals
+type ShardRetrieval struct {
+	Peer         RetrievalPeer
+	Params       Params
+	TotalFunds   abi.TokenAmount
+	ClientWallet address.Address
+	MinerWallet  address.Address
+}
+
+// ShardRetrievalPlanner picks the provider and parameters to retrieve a shard with
+type ShardRetrievalPlanner func(shard dagsharding.Shard) (ShardRetrieval, error)
+
+// ReceivedBlock is a block visited by an in-flight retrieval, checked against its CID
+type ReceivedBlock struct {
+	Cid  cid.Cid
+	Data []byte
+	// FileData is the part of a UnixFS file held by the block, at FileOffset in the
+	// file. It is nil for blocks without file data, and when the payload is not a
+	// UnixFS file
+	FileData   []byte
+	FileOffset uint64
+}
+
+// BlockSubscription passes the blocks of an in-flight retrieval to a consumer as the
+// traversal visits them. The transfer waits while the consumer falls behind
+type BlockSubscription interface {
+	// Blocks returns the channel blocks are passed on, which is closed once the deal
+	// finishes or the subscription is cancelled
+	Blocks() <-chan ReceivedBlock
+	// Err returns the error checking or storing a block that stopped the
+	// subscription, once Blocks is closed
+	Err() error
+	// Cancel stops passing blocks to the consumer, and lets the transfer carry on
+	// without waiting for it
+	Cancel()
+}
+
 // QueryResponseStatus indicates whether a queried piece is available
 type QueryResponseStatus uint64
 
@@ -121,6 +250,10 @@ const (
 
 	// QueryResponseError indicates something went wrong generating a query response
 	QueryResponseError
+
+	// QueryResponseBusy indicates the provider is too busy to answer the query
+	// right now, and the client may try again later
+	QueryResponseBusy
 )
 
 // QueryItemStatus (V1) indicates whether the requested part of a piece (payload or selector)
@@ -193,11 +326,148 @@ type QueryResponse struct {
 	MaxPaymentIntervalIncrease uint64
 	Message                    string
 	UnsealPrice                abi.TokenAmount
+	// PriorityPricePerByte is the least price per byte of a deal that asks for
+	// Priority, or zero if the provider does not offer priority retrieval
+	PriorityPricePerByte abi.TokenAmount
 }
 
 // QueryResponseUndefined is an empty QueryResponse
 var QueryResponseUndefined = QueryResponse{}
 
+// PieceFormat is the form in which a whole piece is retrieved
+type PieceFormat uint64
+
+const (
+	// PieceFormatUnpadded is the piece's data as it was added to the sector, before
+	// fr32 padding, including the zeros that fill it to the piece size
+	PieceFormatUnpadded PieceFormat = iota
+
+	// PieceFormatPadded is the piece's data with fr32 padding, as it is hashed to
+	// compute the PieceCID
+	PieceFormatPadded
+
+	// PieceFormatCAR is the CAR file at the start of the piece's data, without the
+	// zeros that fill it to the piece size
+	PieceFormatCAR
+)
+
+// PieceFormats maps piece formats to their names
+var PieceFormats = map[PieceFormat]string{
+	PieceFormatUnpadded: "PieceFormatUnpadded",
+	PieceFormatPadded:   "PieceFormatPadded",
+	PieceFormatCAR:      "PieceFormatCAR",
+}
+
+// PieceRequest asks a provider for a whole piece
+type PieceRequest struct {
+	PieceCID cid.Cid
+	Format   PieceFormat
+}
+
+// PieceResponseStatus indicates whether a provider is sending a requested piece
+type PieceResponseStatus uint64
+
+const (
+	// PieceResponseOk means the piece's data follows the response
+	PieceResponseOk PieceResponseStatus = iota
+
+	// PieceResponseRejected means the provider does not serve the piece to the client
+	PieceResponseRejected
+
+	// PieceResponseNotFound means the provider does not have the piece
+	PieceResponseNotFound
+
+	// PieceResponseError means the provider could not read the piece
+	PieceResponseError
+)
+
+// PieceResponseStatuses maps piece response statuses to their names
+var PieceResponseStatuses = map[PieceResponseStatus]string{
+	PieceResponseOk:       "PieceResponseOk",
+	PieceResponseRejected: "PieceResponseRejected",
+	PieceResponseNotFound: "PieceResponseNotFound",
+	PieceResponseError:    "PieceResponseError",
+}
+
+// PieceResponse answers a PieceRequest. When the status is PieceResponseOk, the
+// piece's data follows it on the stream. Size is the number of bytes of data, or
+// zero if it is not known in advance, as for PieceFormatCAR
+type PieceResponse struct {
+	Status  PieceResponseStatus
+	Size    uint64
+	Message string
+}
+
+// PieceResponseUndefined is an empty PieceResponse
+var PieceResponseUndefined = PieceResponse{}
+
+// InlineQuery asks a provider about a payload like a Query, and for the payload to be
+// sent with the response, without a deal, if it is no bigger than MaxSize bytes
+type InlineQuery struct {
+	Query   Query
+	MaxSize uint64
+}
+
+// InlineQueryResponse answers an InlineQuery. Miner is the miner whose worker signs
+// the response. Data is a CAR file of the payload's whole DAG when the provider sends
+// the payload inline, and is empty otherwise
+type InlineQueryResponse struct {
+	PayloadCID cid.Cid
+	Miner      address.Address
+	Response   QueryResponse
+	Data       []byte
+}
+
+// SignedInlineQueryResponse is an InlineQueryResponse signed by the miner's worker
+type SignedInlineQueryResponse struct {
+	Response  InlineQueryResponse
+	Signature *crypto.Signature
+}
+
+// PossessionChallenge asks a provider to prove it holds the payload of a query, by
+// sending the blocks on Paths paths from the payload's root block down to a leaf. At
+// each block, Nonce picks the link the path follows
+type PossessionChallenge struct {
+	Query Query
+	Nonce []byte
+	Paths uint64
+}
+
+// PossessionProofStatus indicates whether a provider answered a PossessionChallenge
+type PossessionProofStatus uint64
+
+const (
+	// PossessionProofOk means the proof holds the blocks on the challenged paths
+	PossessionProofOk PossessionProofStatus = iota
+
+	// PossessionProofDeclined means the provider does not answer the challenge, such
+	// as when it asks for more paths than the provider sends or the provider is busy
+	PossessionProofDeclined
+
+	// PossessionProofNotFound means the provider does not have the payload
+	PossessionProofNotFound
+
+	// PossessionProofError means the provider could not read the payload's blocks
+	PossessionProofError
+)
+
+// PossessionProofStatuses maps possession proof statuses to their names
+var PossessionProofStatuses = map[PossessionProofStatus]string{
+	PossessionProofOk:       "PossessionProofOk",
+	PossessionProofDeclined: "PossessionProofDeclined",
+	PossessionProofNotFound: "PossessionProofNotFound",
+	PossessionProofError:    "PossessionProofError",
+}
+
+// PossessionProof answers a PossessionChallenge. When the status is
+// PossessionProofOk, Data is a CAR file rooted at the payload CID holding the blocks
+// on the challenged paths
+type PossessionProof struct {
+	Status  PossessionProofStatus
+	Message string
+	Data    []byte
+}
+
 // PieceRetrievalPrice is the total price to retrieve the piece (size * MinPricePerByte + UnsealedPrice)
 func (qr QueryResponse) PieceRetrievalPrice() abi.TokenAmount {
 	return big.Add(big.Mul(qr.MinPricePerByte, abi.NewTokenAmount(int64(qr.Size))), qr.UnsealPrice)
@@ -237,12 +507,45 @@ type Params struct {
 	PaymentInterval         uint64 // when to request payment
 	PaymentIntervalIncrease uint64
 	UnsealPrice             abi.TokenAmount
+	// EscrowFinalPayment requests that the provider send the last payment
+	// interval of data before asking for payment, so that the client only pays
+	// in full once it has received and verified the complete DAG
+	EscrowFinalPayment bool
+	// PaymentBatch is the number of payment intervals the client pays for with each
+	// voucher. The provider sends up to this many intervals of data before asking for
+	// payment, as long as the unpaid data stays within its limit. Zero or one pays
+	// for each interval separately
+	PaymentBatch uint64
+	// Priority asks the provider to schedule the deal's transfer ahead of deals
+	// without priority. The price per byte must be at least the provider's
+	// PriorityPricePerByte
+	Priority bool
+	// PaymentDisabled asks for a deal without any payment, for trusted or private
+	// networks. The client sets up no payment channel and sends no vouchers, and the
+	// provider never asks for payment, so neither side touches the chain. The prices
+	// must be zero, and providers only accept it from peers they trust
+	PaymentDisabled bool
 }
 
 func (p Params) SelectorSpecified() bool {
 	return p.Selector != nil && !bytes.Equal(p.Selector.Raw, cbg.CborNull)
 }
 
+// CheckPaymentDisabled verifies that params with payment disabled ask for nothing to
+// be paid
+func (p Params) CheckPaymentDisabled() error {
+	if !p.PaymentDisabled {
+		return nil
+	}
+	if !p.PricePerByte.Nil() && p.PricePerByte.GreaterThan(big.Zero()) {
+		return xerrors.New("price per byte must be zero when payment is disabled")
+	}
+	if !p.UnsealPrice.Nil() && p.UnsealPrice.GreaterThan(big.Zero()) {
+		return xerrors.New("unseal price must be zero when payment is disabled")
+	}
+	return nil
+}
+
 // NewParamsV0 generates parameters for a retrieval deal, which is always a whole piece deal
 func NewParamsV0(pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIntervalIncrease uint64) Params {
 	return Params{
@@ -260,6 +563,9 @@ func NewParamsV1(pricePerByte abi.TokenAmount, paymentInterval uint64, paymentIn
 	if sel == nil {
 		return Params{}, xerrors.New("selector required for NewParamsV1")
 	}
+	if err := selectors.Validate(sel); err != nil {
+		return Params{}, err
+	}
 
 	err := dagcbor.Encoder(sel, &buffer)
 	if err != nil {
@@ -290,9 +596,11 @@ type DealProposal struct {
 	Params
 }
 
-// Type method makes DealProposal usable as a voucher
+// Type method makes DealProposal usable as a voucher. Version 2 of the proposal
+// added the params for escrowed final payments, payment batches, priority and
+// disabled payment
 func (dp *DealProposal) Type() datatransfer.TypeIdentifier {
-	return "RetrievalDealProposal/1"
+	return "RetrievalDealProposal/2"
 }
 
 // DealProposalUndefined is an undefined deal proposal
@@ -338,6 +646,10 @@ var (
 
 	// ErrVerification means a retrieval contained a block response that did not verify
 	ErrVerification = errors.New("Error when verify data")
+
+	// ErrUnsupportedCARVersion means a retrieval was asked to write a version of the
+	// CAR format other than CARv1, the only version the client can write
+	ErrUnsupportedCARVersion = errors.New("unsupported CAR version")
 )
 
 type Ask struct {
@@ -345,6 +657,38 @@ type Ask struct {
 	UnsealPrice             abi.TokenAmount
 	PaymentInterval         uint64
 	PaymentIntervalIncrease uint64
+	// PriorityMultiplier is how many times PricePerByte a deal pays to have its
+	// transfer scheduled ahead of others. Zero offers no priority retrieval
+	PriorityMultiplier uint64
+}
+
+// PriorityPricePerByte is the least price per byte of a deal that asks for priority,
+// or zero if the ask offers no priority retrieval
+func (a Ask) PriorityPricePerByte() abi.TokenAmount {
+	if a.PriorityMultiplier == 0 || a.PricePerByte.Nil() {
+		return big.Zero()
+	}
+	return big.Mul(a.PricePerByte, big.NewIntUnsigned(a.PriorityMultiplier))
+}
+
+// UnsealPricer works out the unseal price quoted for a retrieval from the provider's
+// ask and the node's estimate of the work it takes to unseal the data
+type UnsealPricer func(ask Ask, estimate UnsealCostEstimate) abi.TokenAmount
+
+// MediumUnsealPricer returns an UnsealPricer that quotes nothing for data the node
+// keeps unsealed, the ask's unseal price for sectors on hot storage or storage it does
+// not know, and coldMultiple times the ask's unseal price for sectors on cold storage
+func MediumUnsealPricer(coldMultiple uint64) UnsealPricer {
+	return func(ask Ask, estimate UnsealCostEstimate) abi.TokenAmount {
+		switch {
+		case estimate.Unsealed:
+			return big.Zero()
+		case estimate.Medium == StorageMediumCold:
+			return big.Mul(ask.UnsealPrice, big.NewIntUnsigned(coldMultiple))
+		default:
+			return ask.UnsealPrice
+		}
+	}
 }
 
 // ShortfallErorr is an error that indicates a short fall of funds
@@ -381,3 +725,78 @@ type ChannelAvailableFunds struct {
 	// and in the local datastore
 	VoucherReedeemedAmt abi.TokenAmount
 }
+
+// DealSummary summarizes the deals a retrieval provider is tracking by the state they
+// are in, for health checks and alerting
+type DealSummary struct {
+	// States maps each state that has deals in it to a summary of those deals
+	States map[DealStatus]DealStateSummary
+	// Total is the number of deals tracked
+	Total int
+	// Stuck is the number of deals that have been in their state for longer than expected
+	Stuck int
+	Time  time.Time
+}
+
+// DealStateSummary summarizes the deals in one state
+type DealStateSummary struct {
+	Count int
+	// OldestDeal is the deal that has been in the state the longest, and OldestSince
+	// the time it entered the state
+	OldestDeal  ProviderDealIdentifier
+	OldestSince time.Time
+	// Stuck is the number of deals that have been in the state for longer than its
+	// expected dwell time
+	Stuck int
+}
+
+// ProviderStats are rolling statistics of a retrieval provider's deal throughput, for
+// operator dashboards
+type ProviderStats struct {
+	// Window is the period the statistics cover, ending at Time
+	Window time.Duration
+	Time   time.Time
+
+	DealsAccepted        uint64
+	DealsAcceptedPerHour float64
+	DealsCompleted       uint64
+	// BytesServed is the data sent for deals that completed
+	BytesServed     uint64
+	GiBServedPerDay float64
+}
+
+// ClientPaymentDefaults records how often a client stopped paying part way through a
+// retrieval deal, that is, left a deal that was waiting for its payment without ever
+// completing it
+type ClientPaymentDefaults struct {
+	Client      peer.ID
+	Defaults    uint64
+	LastDeal    DealID
+	LastDefault time.Time
+}
+
+// PaymentDefaultPolicy sets how a provider treats clients that stopped paying part way
+// through earlier deals
+type PaymentDefaultPolicy struct {
+	// DepositAfter is the number of defaults after which a client must pay Deposit
+	// upfront, on top of the unseal price the provider asks. Zero never requires
+	// a deposit
+	DepositAfter uint64
+	Deposit      abi.TokenAmount
+
+	// RejectAfter is the number of defaults after which the provider rejects deals
+	// from a client. Zero never rejects
+	RejectAfter uint64
+}
+
+// DealRetentionPolicy sets how long a provider keeps the records of deals that have
+// ended, as completed, errored or cancelled
+type DealRetentionPolicy struct {
+	// MaxAge is how long a deal's record is kept after the deal ends. Zero keeps
+	// records forever
+	MaxAge time.Duration
+	// Exclude lists deals whose records are kept however old they are
+	Exclude []ProviderDealIdentifier
+	// ExcludeClients lists clients whose deals' records are kept however old they are
+	ExcludeClients []peer.ID
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 
+	address "github.com/filecoin-project/go-address"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
 	piecestore "github.com/filecoin-project/go-fil-markets/piecestore"
 	multistore "github.com/filecoin-project/go-multistore"
+	crypto "github.com/filecoin-project/go-state-types/crypto"
 	paych "github.com/filecoin-project/specs-actors/actors/builtin/paych"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -130,7 +133,7 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{169}); err != nil {
+	if _, err := w.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -286,6 +289,22 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 	if err := t.UnsealPrice.MarshalCBOR(w); err != nil {
 		return err
 	}
+
+	// t.PriorityPricePerByte (big.Int) (struct)
+	if len("PriorityPricePerByte") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PriorityPricePerByte\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PriorityPricePerByte"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PriorityPricePerByte")); err != nil {
+		return err
+	}
+
+	if err := t.PriorityPricePerByte.MarshalCBOR(w); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -438,6 +457,16 @@ func (t *QueryResponse) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.PriorityPricePerByte (big.Int) (struct)
+		case "PriorityPricePerByte":
+
+			{
+
+				if err := t.PriorityPricePerByte.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.PriorityPricePerByte: %w", err)
+				}
+
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
@@ -767,7 +796,7 @@ func (t *Params) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{166}); err != nil {
+	if _, err := w.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -874,6 +903,70 @@ func (t *Params) MarshalCBOR(w io.Writer) error {
 	if err := t.UnsealPrice.MarshalCBOR(w); err != nil {
 		return err
 	}
+
+	// t.EscrowFinalPayment (bool) (bool)
+	if len("EscrowFinalPayment") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"EscrowFinalPayment\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("EscrowFinalPayment"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("EscrowFinalPayment")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.EscrowFinalPayment); err != nil {
+		return err
+	}
+
+	// t.PaymentBatch (uint64) (uint64)
+	if len("PaymentBatch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PaymentBatch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PaymentBatch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PaymentBatch")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PaymentBatch)); err != nil {
+		return err
+	}
+
+	// t.Priority (bool) (bool)
+	if len("Priority") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Priority\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Priority"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Priority")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Priority); err != nil {
+		return err
+	}
+
+	// t.PaymentDisabled (bool) (bool)
+	if len("PaymentDisabled") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PaymentDisabled\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PaymentDisabled"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PaymentDisabled")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.PaymentDisabled); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -994,6 +1087,75 @@ func (t *Params) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.EscrowFinalPayment (bool) (bool)
+		case "EscrowFinalPayment":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.EscrowFinalPayment = false
+			case 21:
+				t.EscrowFinalPayment = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.PaymentBatch (uint64) (uint64)
+		case "PaymentBatch":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.PaymentBatch = uint64(extra)
+
+			}
+			// t.Priority (bool) (bool)
+		case "Priority":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Priority = false
+			case 21:
+				t.Priority = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.PaymentDisabled (bool) (bool)
+		case "PaymentDisabled":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.PaymentDisabled = false
+			case 21:
+				t.PaymentDisabled = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
@@ -1254,7 +1416,7 @@ func (t *ClientDealState) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{181}); err != nil {
+	if _, err := w.Write([]byte{184, 28}); err != nil {
 		return err
 	}
 
@@ -1621,6 +1783,119 @@ func (t *ClientDealState) MarshalCBOR(w io.Writer) error {
 	if err := cbg.WriteBool(w, t.LegacyProtocol); err != nil {
 		return err
 	}
+
+	// t.FundsToppedUp (big.Int) (struct)
+	if len("FundsToppedUp") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"FundsToppedUp\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("FundsToppedUp"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("FundsToppedUp")); err != nil {
+		return err
+	}
+
+	if err := t.FundsToppedUp.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.TransferStatus (datatransfer.Status) (uint64)
+	if len("TransferStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferStatus\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferStatus"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferStatus")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferStatus)); err != nil {
+		return err
+	}
+
+	// t.TransferQueued (uint64) (uint64)
+	if len("TransferQueued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferQueued\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferQueued"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferQueued")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferQueued)); err != nil {
+		return err
+	}
+
+	// t.TransferSent (uint64) (uint64)
+	if len("TransferSent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferSent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferSent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferSent")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferSent)); err != nil {
+		return err
+	}
+
+	// t.TransferReceived (uint64) (uint64)
+	if len("TransferReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferReceived\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferReceived"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferReceived")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferReceived)); err != nil {
+		return err
+	}
+
+	// t.VerifiedAgainstPiece (bool) (bool)
+	if len("VerifiedAgainstPiece") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"VerifiedAgainstPiece\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("VerifiedAgainstPiece"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("VerifiedAgainstPiece")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.VerifiedAgainstPiece); err != nil {
+		return err
+	}
+
+	// t.TimeoutReason (retrievalmarket.DealTimeoutReason) (uint64)
+	if len("TimeoutReason") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TimeoutReason\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TimeoutReason"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TimeoutReason")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TimeoutReason)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1951,71 +2226,174 @@ func (t *ClientDealState) UnmarshalCBOR(r io.Reader) error {
 			default:
 				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
 			}
+			// t.FundsToppedUp (big.Int) (struct)
+		case "FundsToppedUp":
 
-		default:
-			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
-		}
-	}
-
-	return nil
-}
-func (t *ProviderDealState) MarshalCBOR(w io.Writer) error {
-	if t == nil {
-		_, err := w.Write(cbg.CborNull)
-		return err
-	}
-	if _, err := w.Write([]byte{171}); err != nil {
-		return err
-	}
+			{
 
-	scratch := make([]byte, 9)
+				if err := t.FundsToppedUp.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.FundsToppedUp: %w", err)
+				}
 
-	// t.DealProposal (retrievalmarket.DealProposal) (struct)
-	if len("DealProposal") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"DealProposal\" was too long")
-	}
+			}
+			// t.TransferStatus (datatransfer.Status) (uint64)
+		case "TransferStatus":
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("DealProposal"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("DealProposal")); err != nil {
-		return err
-	}
+			{
 
-	if err := t.DealProposal.MarshalCBOR(w); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferStatus = datatransfer.Status(extra)
 
-	// t.StoreID (multistore.StoreID) (uint64)
-	if len("StoreID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"StoreID\" was too long")
-	}
+			}
+			// t.TransferQueued (uint64) (uint64)
+		case "TransferQueued":
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("StoreID"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("StoreID")); err != nil {
-		return err
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.StoreID)); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferQueued = uint64(extra)
 
-	// t.ChannelID (datatransfer.ChannelID) (struct)
-	if len("ChannelID") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"ChannelID\" was too long")
-	}
+			}
+			// t.TransferSent (uint64) (uint64)
+		case "TransferSent":
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ChannelID"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("ChannelID")); err != nil {
-		return err
-	}
+			{
 
-	if err := t.ChannelID.MarshalCBOR(w); err != nil {
-		return err
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferSent = uint64(extra)
+
+			}
+			// t.TransferReceived (uint64) (uint64)
+		case "TransferReceived":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferReceived = uint64(extra)
+
+			}
+			// t.VerifiedAgainstPiece (bool) (bool)
+		case "VerifiedAgainstPiece":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.VerifiedAgainstPiece = false
+			case 21:
+				t.VerifiedAgainstPiece = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.TimeoutReason (retrievalmarket.DealTimeoutReason) (uint64)
+		case "TimeoutReason":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TimeoutReason = DealTimeoutReason(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ProviderDealState) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{176}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.DealProposal (retrievalmarket.DealProposal) (struct)
+	if len("DealProposal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealProposal\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("DealProposal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealProposal")); err != nil {
+		return err
+	}
+
+	if err := t.DealProposal.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.StoreID (multistore.StoreID) (uint64)
+	if len("StoreID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"StoreID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("StoreID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("StoreID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.StoreID)); err != nil {
+		return err
+	}
+
+	// t.ChannelID (datatransfer.ChannelID) (struct)
+	if len("ChannelID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ChannelID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ChannelID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ChannelID")); err != nil {
+		return err
+	}
+
+	if err := t.ChannelID.MarshalCBOR(w); err != nil {
+		return err
 	}
 
 	// t.PieceInfo (piecestore.PieceInfo) (struct)
@@ -2159,6 +2537,86 @@ func (t *ProviderDealState) MarshalCBOR(w io.Writer) error {
 	if err := cbg.WriteBool(w, t.LegacyProtocol); err != nil {
 		return err
 	}
+
+	// t.TransferStatus (datatransfer.Status) (uint64)
+	if len("TransferStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferStatus\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferStatus"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferStatus")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferStatus)); err != nil {
+		return err
+	}
+
+	// t.TransferQueued (uint64) (uint64)
+	if len("TransferQueued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferQueued\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferQueued"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferQueued")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferQueued)); err != nil {
+		return err
+	}
+
+	// t.TransferSent (uint64) (uint64)
+	if len("TransferSent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferSent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferSent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferSent")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferSent)); err != nil {
+		return err
+	}
+
+	// t.TransferReceived (uint64) (uint64)
+	if len("TransferReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferReceived\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferReceived"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferReceived")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferReceived)); err != nil {
+		return err
+	}
+
+	// t.Miner (address.Address) (struct)
+	if len("Miner") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Miner\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Miner"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Miner")); err != nil {
+		return err
+	}
+
+	if err := t.Miner.MarshalCBOR(w); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -2345,6 +2803,86 @@ func (t *ProviderDealState) UnmarshalCBOR(r io.Reader) error {
 			default:
 				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
 			}
+			// t.TransferStatus (datatransfer.Status) (uint64)
+		case "TransferStatus":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferStatus = datatransfer.Status(extra)
+
+			}
+			// t.TransferQueued (uint64) (uint64)
+		case "TransferQueued":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferQueued = uint64(extra)
+
+			}
+			// t.TransferSent (uint64) (uint64)
+		case "TransferSent":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferSent = uint64(extra)
+
+			}
+			// t.TransferReceived (uint64) (uint64)
+		case "TransferReceived":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferReceived = uint64(extra)
+
+			}
+			// t.Miner (address.Address) (struct)
+		case "Miner":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Miner = new(address.Address)
+					if err := t.Miner.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Miner pointer: %w", err)
+					}
+				}
+
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
@@ -2630,7 +3168,7 @@ func (t *Ask) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{164}); err != nil {
+	if _, err := w.Write([]byte{165}); err != nil {
 		return err
 	}
 
@@ -2700,6 +3238,22 @@ func (t *Ask) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.PriorityMultiplier (uint64) (uint64)
+	if len("PriorityMultiplier") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PriorityMultiplier\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PriorityMultiplier"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PriorityMultiplier")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PriorityMultiplier)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -2786,6 +3340,1018 @@ func (t *Ask) UnmarshalCBOR(r io.Reader) error {
 				t.PaymentIntervalIncrease = uint64(extra)
 
 			}
+			// t.PriorityMultiplier (uint64) (uint64)
+		case "PriorityMultiplier":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.PriorityMultiplier = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *PieceRequest) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.PieceCID (cid.Cid) (struct)
+	if len("PieceCID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PieceCID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PieceCID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PieceCID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.PieceCID); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PieceCID: %w", err)
+	}
+
+	// t.Format (retrievalmarket.PieceFormat) (uint64)
+	if len("Format") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Format\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Format"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Format")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Format)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *PieceRequest) UnmarshalCBOR(r io.Reader) error {
+	*t = PieceRequest{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PieceRequest: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.PieceCID (cid.Cid) (struct)
+		case "PieceCID":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.PieceCID: %w", err)
+				}
+
+				t.PieceCID = c
+
+			}
+			// t.Format (retrievalmarket.PieceFormat) (uint64)
+		case "Format":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Format = PieceFormat(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *PieceResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Status (retrievalmarket.PieceResponseStatus) (uint64)
+	if len("Status") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Status\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Status"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Status")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Status)); err != nil {
+		return err
+	}
+
+	// t.Size (uint64) (uint64)
+	if len("Size") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Size\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Size"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Size")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Size)); err != nil {
+		return err
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *PieceResponse) UnmarshalCBOR(r io.Reader) error {
+	*t = PieceResponse{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PieceResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Status (retrievalmarket.PieceResponseStatus) (uint64)
+		case "Status":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Status = PieceResponseStatus(extra)
+
+			}
+			// t.Size (uint64) (uint64)
+		case "Size":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Size = uint64(extra)
+
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *InlineQuery) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Query (retrievalmarket.Query) (struct)
+	if len("Query") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Query\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Query"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Query")); err != nil {
+		return err
+	}
+
+	if err := t.Query.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.MaxSize (uint64) (uint64)
+	if len("MaxSize") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MaxSize\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("MaxSize"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("MaxSize")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.MaxSize)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *InlineQuery) UnmarshalCBOR(r io.Reader) error {
+	*t = InlineQuery{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("InlineQuery: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Query (retrievalmarket.Query) (struct)
+		case "Query":
+
+			{
+
+				if err := t.Query.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Query: %w", err)
+				}
+
+			}
+			// t.MaxSize (uint64) (uint64)
+		case "MaxSize":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.MaxSize = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *InlineQueryResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{164}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.PayloadCID (cid.Cid) (struct)
+	if len("PayloadCID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PayloadCID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PayloadCID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PayloadCID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.PayloadCID); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PayloadCID: %w", err)
+	}
+
+	// t.Miner (address.Address) (struct)
+	if len("Miner") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Miner\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Miner"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Miner")); err != nil {
+		return err
+	}
+
+	if err := t.Miner.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Response (retrievalmarket.QueryResponse) (struct)
+	if len("Response") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Response\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Response"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Response")); err != nil {
+		return err
+	}
+
+	if err := t.Response.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Data ([]uint8) (slice)
+	if len("Data") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Data\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Data"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Data")); err != nil {
+		return err
+	}
+
+	if len(t.Data) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.Data was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.Data))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.Data[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *InlineQueryResponse) UnmarshalCBOR(r io.Reader) error {
+	*t = InlineQueryResponse{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("InlineQueryResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.PayloadCID (cid.Cid) (struct)
+		case "PayloadCID":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.PayloadCID: %w", err)
+				}
+
+				t.PayloadCID = c
+
+			}
+			// t.Miner (address.Address) (struct)
+		case "Miner":
+
+			{
+
+				if err := t.Miner.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Miner: %w", err)
+				}
+
+			}
+			// t.Response (retrievalmarket.QueryResponse) (struct)
+		case "Response":
+
+			{
+
+				if err := t.Response.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Response: %w", err)
+				}
+
+			}
+			// t.Data ([]uint8) (slice)
+		case "Data":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.Data: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.Data = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.Data[:]); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *SignedInlineQueryResponse) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Response (retrievalmarket.InlineQueryResponse) (struct)
+	if len("Response") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Response\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Response"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Response")); err != nil {
+		return err
+	}
+
+	if err := t.Response.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Signature (crypto.Signature) (struct)
+	if len("Signature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Signature\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Signature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Signature")); err != nil {
+		return err
+	}
+
+	if err := t.Signature.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *SignedInlineQueryResponse) UnmarshalCBOR(r io.Reader) error {
+	*t = SignedInlineQueryResponse{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("SignedInlineQueryResponse: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Response (retrievalmarket.InlineQueryResponse) (struct)
+		case "Response":
+
+			{
+
+				if err := t.Response.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Response: %w", err)
+				}
+
+			}
+			// t.Signature (crypto.Signature) (struct)
+		case "Signature":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Signature = new(crypto.Signature)
+					if err := t.Signature.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Signature pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *PossessionChallenge) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Query (retrievalmarket.Query) (struct)
+	if len("Query") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Query\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Query"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Query")); err != nil {
+		return err
+	}
+
+	if err := t.Query.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Nonce ([]uint8) (slice)
+	if len("Nonce") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Nonce\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Nonce"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Nonce")); err != nil {
+		return err
+	}
+
+	if len(t.Nonce) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.Nonce was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.Nonce))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.Nonce[:]); err != nil {
+		return err
+	}
+
+	// t.Paths (uint64) (uint64)
+	if len("Paths") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Paths\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Paths"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Paths")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Paths)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *PossessionChallenge) UnmarshalCBOR(r io.Reader) error {
+	*t = PossessionChallenge{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PossessionChallenge: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Query (retrievalmarket.Query) (struct)
+		case "Query":
+
+			{
+
+				if err := t.Query.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Query: %w", err)
+				}
+
+			}
+			// t.Nonce ([]uint8) (slice)
+		case "Nonce":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.Nonce: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.Nonce = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.Nonce[:]); err != nil {
+				return err
+			}
+			// t.Paths (uint64) (uint64)
+		case "Paths":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Paths = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *PossessionProof) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Status (retrievalmarket.PossessionProofStatus) (uint64)
+	if len("Status") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Status\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Status"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Status")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Status)); err != nil {
+		return err
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+
+	// t.Data ([]uint8) (slice)
+	if len("Data") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Data\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Data"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Data")); err != nil {
+		return err
+	}
+
+	if len(t.Data) > cbg.ByteArrayMaxLen {
+		return xerrors.Errorf("Byte array in field t.Data was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajByteString, uint64(len(t.Data))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(t.Data[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *PossessionProof) UnmarshalCBOR(r io.Reader) error {
+	*t = PossessionProof{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("PossessionProof: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Status (retrievalmarket.PossessionProofStatus) (uint64)
+		case "Status":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Status = PossessionProofStatus(extra)
+
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+			// t.Data ([]uint8) (slice)
+		case "Data":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.ByteArrayMaxLen {
+				return fmt.Errorf("t.Data: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.Data = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(br, t.Data[:]); err != nil {
+				return err
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
@@ -0,0 +1,142 @@
+/*
+Package carcheck checks that the CAR file generated for a DAG is the same every time
+it is generated.
+
+The piece CID of a deal is the commitment of the CAR file for the deal's DAG. The
+client computes it before proposing the deal, and the provider computes it again from
+the data it receives. Both sides only agree if the DAG is written to the CAR in the
+same order, with the same block data, each time. A blockstore that returns different
+bytes for a block, or a DAG whose links are not in a fixed order, produces a different
+CAR and the provider rejects the deal with a CommP mismatch.
+
+VerifyCommP generates the piece commitment for a DAG twice, and checks both runs write
+the same blocks in the same order and give the same piece CID. VerifyCAR checks that
+an existing CAR file is exactly the CAR generated for a DAG.
+*/
+package carcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// ErrNotDeterministic is returned when generating the CAR for a DAG twice gives
+// different results
+var ErrNotDeterministic = errors.New("CAR generation is not deterministic")
+
+// ErrMismatch is returned when a CAR or piece CID is not the one generated for a DAG
+var ErrMismatch = errors.New("CAR does not match the DAG")
+
+// CommPFunc generates the piece commitment for a DAG, calling the given functions
+// for each block written to the CAR, such as pieceio.PieceIO's
+// GeneratePieceCommitment
+type CommPFunc func(abi.RegisteredSealProof, cid.Cid, ipld.Node, *multistore.StoreID, ...car.OnNewCarBlockFunc) (cid.Cid, abi.UnpaddedPieceSize, error)
+
+type blockRef struct {
+	cid    cid.Cid
+	offset uint64
+	size   uint64
+}
+
+// VerifyCommP generates the piece commitment for a DAG twice and returns it, after
+// checking both runs wrote the same blocks at the same offsets and gave the same piece
+// CID and size. If expected is set, the piece CID must also match it
+func VerifyCommP(generate CommPFunc, rt abi.RegisteredSealProof, root cid.Cid, selector ipld.Node, storeID *multistore.StoreID, expected *cid.Cid) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	var first []blockRef
+	commP, size, err := generate(rt, root, selector, storeID, func(block car.Block) error {
+		first = append(first, blockRef{block.BlockCID, block.Offset, block.Size})
+		return nil
+	})
+	if err != nil {
+		return cid.Undef, 0, xerrors.Errorf("generating CommP: %w", err)
+	}
+
+	// the first difference is kept, as the generator may wrap the error it is given
+	i := 0
+	var diff error
+	commP2, size2, err := generate(rt, root, selector, storeID, func(block car.Block) error {
+		if i >= len(first) {
+			diff = xerrors.Errorf("block %s written after the %d blocks of the first run: %w", block.BlockCID, len(first), ErrNotDeterministic)
+			return diff
+		}
+		if want := first[i]; !want.cid.Equals(block.BlockCID) || want.offset != block.Offset || want.size != block.Size {
+			diff = xerrors.Errorf("block %d is %s (%d bytes at offset %d), was %s (%d bytes at offset %d): %w", i, block.BlockCID, block.Size, block.Offset, want.cid, want.size, want.offset, ErrNotDeterministic)
+			return diff
+		}
+		i++
+		return nil
+	})
+	if diff != nil {
+		return cid.Undef, 0, diff
+	}
+	if err != nil {
+		return cid.Undef, 0, xerrors.Errorf("generating CommP again: %w", err)
+	}
+	if i != len(first) {
+		return cid.Undef, 0, xerrors.Errorf("%d blocks written, then %d: %w", len(first), i, ErrNotDeterministic)
+	}
+	if !commP.Equals(commP2) || size != size2 {
+		return cid.Undef, 0, xerrors.Errorf("piece CID %s (size %d), then %s (size %d): %w", commP, size, commP2, size2, ErrNotDeterministic)
+	}
+
+	if expected != nil && !expected.Equals(commP) {
+		return cid.Undef, 0, xerrors.Errorf("piece CID is %s, expected %s: %w", commP, *expected, ErrMismatch)
+	}
+	return commP, size, nil
+}
+
+// VerifyCAR checks the CAR file read from r is the CAR generated for the DAG under
+// root in store, with the same blocks in the same order
+func VerifyCAR(ctx context.Context, store car.ReadStore, root cid.Cid, selector ipld.Node, r io.Reader) error {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return xerrors.Errorf("reading CAR header: %w", err)
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(root) {
+		return xerrors.Errorf("CAR roots are %v, expected %s: %w", cr.Header.Roots, root, ErrMismatch)
+	}
+
+	i := 0
+	var diff error
+	sc := car.NewSelectiveCar(ctx, store, []car.Dag{{Root: root, Selector: selector}})
+	err = sc.Write(ioutil.Discard, func(block car.Block) error {
+		got, err := cr.Next()
+		switch {
+		case err == io.EOF:
+			diff = xerrors.Errorf("CAR ends after %d blocks: %w", i, ErrMismatch)
+		case err != nil:
+			diff = xerrors.Errorf("reading block %d: %w", i, err)
+		case !got.Cid().Equals(block.BlockCID):
+			diff = xerrors.Errorf("block %d is %s, expected %s: %w", i, got.Cid(), block.BlockCID, ErrMismatch)
+		case !bytes.Equal(got.RawData(), block.Data):
+			diff = xerrors.Errorf("block %d (%s) has different data: %w", i, block.BlockCID, ErrMismatch)
+		}
+		i++
+		return diff
+	})
+	if diff != nil {
+		return diff
+	}
+	if err != nil {
+		return xerrors.Errorf("generating CAR: %w", err)
+	}
+
+	if extra, err := cr.Next(); err != io.EOF {
+		if err != nil {
+			return xerrors.Errorf("reading block %d: %w", i, err)
+		}
+		return xerrors.Errorf("CAR has extra block %s after %d blocks: %w", extra.Cid(), i, ErrMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package carcheck_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/carcheck"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestVerifyCAR(t *testing.T) {
+	ctx := context.Background()
+	testData := tut.NewTestIPLDTree()
+	root := testData.RootNodeLnk.(cidlink.Link).Cid
+	var expected bytes.Buffer
+	require.NoError(t, testData.DumpToCar(&expected))
+
+	t.Run("matches the generated CAR", func(t *testing.T) {
+		err := carcheck.VerifyCAR(ctx, testData, root, shared.AllSelector(), bytes.NewReader(expected.Bytes()))
+		require.NoError(t, err)
+	})
+
+	t.Run("fails for a different root", func(t *testing.T) {
+		other := testData.LeafAlphaLnk.(cidlink.Link).Cid
+		err := carcheck.VerifyCAR(ctx, testData, other, shared.AllSelector(), bytes.NewReader(expected.Bytes()))
+		require.True(t, xerrors.Is(err, carcheck.ErrMismatch))
+	})
+
+	// only the root block
+	rootOnly := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+
+	t.Run("fails for a CAR missing blocks", func(t *testing.T) {
+		var short bytes.Buffer
+		sc := car.NewSelectiveCar(ctx, testData, []car.Dag{{Root: root, Selector: rootOnly}})
+		require.NoError(t, sc.Write(&short))
+		err := carcheck.VerifyCAR(ctx, testData, root, shared.AllSelector(), &short)
+		require.True(t, xerrors.Is(err, carcheck.ErrMismatch))
+	})
+
+	t.Run("fails for a CAR with extra blocks", func(t *testing.T) {
+		err := carcheck.VerifyCAR(ctx, testData, root, rootOnly, bytes.NewReader(expected.Bytes()))
+		require.True(t, xerrors.Is(err, carcheck.ErrMismatch))
+	})
+}
+
+func TestVerifyCommP(t *testing.T) {
+	testData := tut.NewTestIPLDTree()
+	root := testData.RootNodeLnk.(cidlink.Link).Cid
+	pieceCid := tut.GenerateCids(1)[0]
+
+	// generator writes the test tree as a CAR, with each run's blocks in the
+	// order given by orders
+	generator := func(orders ...[]int) carcheck.CommPFunc {
+		run := 0
+		return func(rt abi.RegisteredSealProof, c cid.Cid, selector ipld.Node, storeID *multistore.StoreID, onBlocks ...car.OnNewCarBlockFunc) (cid.Cid, abi.UnpaddedPieceSize, error) {
+			var blocks []car.Block
+			err := testData.DumpToCar(&bytes.Buffer{}, func(block car.Block) error {
+				blocks = append(blocks, block)
+				return nil
+			})
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			order := orders[run%len(orders)]
+			run++
+			for _, i := range order {
+				for _, onBlock := range onBlocks {
+					if err := onBlock(blocks[i]); err != nil {
+						return cid.Undef, 0, xerrors.Errorf("writing CAR: %w", err)
+					}
+				}
+			}
+			return pieceCid, abi.UnpaddedPieceSize(len(order)), nil
+		}
+	}
+
+	t.Run("passes when both runs match", func(t *testing.T) {
+		commP, size, err := carcheck.VerifyCommP(generator([]int{0, 1, 2, 3}), abi.RegisteredSealProof_StackedDrg2KiBV1, root, shared.AllSelector(), nil, &pieceCid)
+		require.NoError(t, err)
+		require.Equal(t, pieceCid, commP)
+		require.Equal(t, abi.UnpaddedPieceSize(4), size)
+	})
+
+	t.Run("fails when blocks are written in a different order", func(t *testing.T) {
+		_, _, err := carcheck.VerifyCommP(generator([]int{0, 1, 2, 3}, []int{0, 2, 1, 3}), abi.RegisteredSealProof_StackedDrg2KiBV1, root, shared.AllSelector(), nil, nil)
+		require.True(t, xerrors.Is(err, carcheck.ErrNotDeterministic))
+	})
+
+	t.Run("fails when a run writes fewer blocks", func(t *testing.T) {
+		_, _, err := carcheck.VerifyCommP(generator([]int{0, 1, 2, 3}, []int{0, 1, 2}), abi.RegisteredSealProof_StackedDrg2KiBV1, root, shared.AllSelector(), nil, nil)
+		require.True(t, xerrors.Is(err, carcheck.ErrNotDeterministic))
+	})
+
+	t.Run("fails when the piece CID is not the expected one", func(t *testing.T) {
+		other := tut.GenerateCids(1)[0]
+		_, _, err := carcheck.VerifyCommP(generator([]int{0, 1, 2, 3}), abi.RegisteredSealProof_StackedDrg2KiBV1, root, shared.AllSelector(), nil, &other)
+		require.True(t, xerrors.Is(err, carcheck.ErrMismatch))
+	})
+}
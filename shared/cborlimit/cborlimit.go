@@ -0,0 +1,215 @@
+/*
+Package cborlimit reads CBOR messages from peers within limits on their size and
+nesting, so that a malformed or hostile message cannot crash the node or make it run
+out of memory.
+
+The generated decoders trust the lengths a message declares: a byte string or array
+header is enough to make them allocate room for its contents before any of the
+contents arrive. Read first copies a single CBOR item off the stream, checking as it
+goes that the item fits within the size limit, that no length it declares is longer
+than the bytes left under the limit, and that it is not nested too deeply. Only then
+is the item decoded, from memory, so that everything the decoder allocates is bounded
+by the bytes actually received. A panic in the decoder is returned as an error.
+
+Indefinite length items are rejected, as the generated encoders never write them.
+*/
+package cborlimit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// DefaultMaxSize is the size limit on messages. It leaves room for the largest byte
+// string the generated decoders accept, 2 MiB, with the rest of a message around it
+const DefaultMaxSize = 4 << 20
+
+// DefaultMaxDepth is the limit on how deeply the items in a message are nested
+const DefaultMaxDepth = 32
+
+// ErrTooLarge is returned for a message larger than the size limit, or declaring a
+// length that does not fit within it
+var ErrTooLarge = errors.New("message exceeds size limit")
+
+// ErrTooDeep is returned for a message nested more deeply than the depth limit
+var ErrTooDeep = errors.New("message exceeds nesting limit")
+
+// ErrIndefiniteLength is returned for a message containing an indefinite length item
+var ErrIndefiniteLength = errors.New("indefinite length items are not supported")
+
+// Limits bound the messages Read accepts
+type Limits struct {
+	// MaxSize is the largest message, in bytes
+	MaxSize uint64
+	// MaxDepth is how deeply items may be nested in arrays, maps and tags
+	MaxDepth int
+}
+
+// DefaultLimits are the limits Read applies
+var DefaultLimits = Limits{MaxSize: DefaultMaxSize, MaxDepth: DefaultMaxDepth}
+
+// Read reads a single message from r into v, within DefaultLimits
+func Read(r io.Reader, v cbg.CBORUnmarshaler) error {
+	return ReadWithLimits(r, v, DefaultLimits)
+}
+
+// ReadWithLimits reads a single message from r into v, within limits. Zero limits
+// are replaced with the defaults
+func ReadWithLimits(r io.Reader, v cbg.CBORUnmarshaler, limits Limits) error {
+	data, err := ReadRaw(r, limits)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, v)
+}
+
+// Unmarshal decodes data into v, returning a panic in the decoder as an error
+func Unmarshal(data []byte, v cbg.CBORUnmarshaler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("decoding message: panic: %v", r)
+		}
+	}()
+	return v.UnmarshalCBOR(bytes.NewReader(data))
+}
+
+// ReadRaw reads the bytes of a single CBOR item from r, within limits, without
+// decoding it. It reads nothing past the end of the item. It returns io.EOF if r
+// ends before the item starts, and io.ErrUnexpectedEOF if it ends within the item
+func ReadRaw(r io.Reader, limits Limits) ([]byte, error) {
+	if limits.MaxSize == 0 {
+		limits.MaxSize = DefaultMaxSize
+	}
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	s := &scanner{r: r, limits: limits}
+	if br, ok := r.(io.ByteReader); ok {
+		s.br = br
+	} else {
+		s.br = &byteReader{r: r}
+	}
+	if err := s.item(0); err != nil {
+		if err == io.EOF && len(s.buf) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return s.buf, nil
+}
+
+type scanner struct {
+	r      io.Reader
+	br     io.ByteReader
+	limits Limits
+	buf    []byte
+}
+
+// remaining returns the number of bytes left under the size limit
+func (s *scanner) remaining() uint64 {
+	return s.limits.MaxSize - uint64(len(s.buf))
+}
+
+func (s *scanner) readByte() (byte, error) {
+	if s.remaining() == 0 {
+		return 0, xerrors.Errorf("%w of %d bytes", ErrTooLarge, s.limits.MaxSize)
+	}
+	b, err := s.br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.buf = append(s.buf, b)
+	return b, nil
+}
+
+func (s *scanner) header() (byte, uint64, error) {
+	b, err := s.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	maj := b >> 5
+	info := b & 31
+	switch {
+	case info < 24:
+		return maj, uint64(info), nil
+	case info <= 27:
+		var extra uint64
+		for i := 0; i < 1<<(info-24); i++ {
+			b, err := s.readByte()
+			if err != nil {
+				return 0, 0, err
+			}
+			extra = extra<<8 | uint64(b)
+		}
+		return maj, extra, nil
+	case info == 31:
+		return 0, 0, ErrIndefiniteLength
+	default:
+		return 0, 0, xerrors.Errorf("invalid additional information %d in header", info)
+	}
+}
+
+func (s *scanner) item(depth int) error {
+	if depth > s.limits.MaxDepth {
+		return xerrors.Errorf("%w of %d", ErrTooDeep, s.limits.MaxDepth)
+	}
+	maj, extra, err := s.header()
+	if err != nil {
+		return err
+	}
+	switch maj {
+	case cbg.MajByteString, cbg.MajTextString:
+		if extra > s.remaining() {
+			return xerrors.Errorf("%w of %d bytes: string of %d bytes", ErrTooLarge, s.limits.MaxSize, extra)
+		}
+		start := len(s.buf)
+		s.buf = append(s.buf, make([]byte, extra)...)
+		if _, err := io.ReadFull(s.r, s.buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	case cbg.MajArray:
+		// every element takes at least a byte
+		if extra > s.remaining() {
+			return xerrors.Errorf("%w of %d bytes: array of %d items", ErrTooLarge, s.limits.MaxSize, extra)
+		}
+		for i := uint64(0); i < extra; i++ {
+			if err := s.item(depth + 1); err != nil {
+				return err
+			}
+		}
+	case cbg.MajMap:
+		// every key and value takes at least a byte
+		if extra > s.remaining()/2 {
+			return xerrors.Errorf("%w of %d bytes: map of %d entries", ErrTooLarge, s.limits.MaxSize, extra)
+		}
+		for i := uint64(0); i < 2*extra; i++ {
+			if err := s.item(depth + 1); err != nil {
+				return err
+			}
+		}
+	case cbg.MajTag:
+		return s.item(depth + 1)
+	}
+	// integers and simple values are all header
+	return nil
+}
+
+// byteReader reads single bytes from a reader that cannot, without reading ahead
+type byteReader struct {
+	r   io.Reader
+	one [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(br.r, br.one[:]); err != nil {
+		return 0, err
+	}
+	return br.one[0], nil
+}
@@ -0,0 +1,103 @@
+package cborlimit_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+func header(maj byte, extra uint64) []byte {
+	var buf bytes.Buffer
+	_ = cbg.WriteMajorTypeHeader(&buf, maj, extra)
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	ask := shared_testutil.MakeTestStorageAsk()
+	encoded, err := cborutil.Dump(ask)
+	require.NoError(t, err)
+
+	t.Run("reads consecutive messages", func(t *testing.T) {
+		r := bufio.NewReaderSize(bytes.NewReader(append(append([]byte{}, encoded...), encoded...)), 16)
+		for i := 0; i < 2; i++ {
+			var out storagemarket.StorageAsk
+			require.NoError(t, cborlimit.Read(r, &out))
+			require.Equal(t, *ask, out)
+		}
+		var out storagemarket.StorageAsk
+		require.Equal(t, io.EOF, cborlimit.Read(r, &out))
+	})
+
+	t.Run("reads from readers that cannot read single bytes", func(t *testing.T) {
+		var out storagemarket.StorageAsk
+		require.NoError(t, cborlimit.Read(io.MultiReader(bytes.NewReader(encoded)), &out))
+		require.Equal(t, *ask, out)
+	})
+
+	testCases := map[string]struct {
+		data   []byte
+		limits cborlimit.Limits
+		err    error
+	}{
+		"message over the size limit": {
+			data:   encoded,
+			limits: cborlimit.Limits{MaxSize: uint64(len(encoded) - 1)},
+			err:    cborlimit.ErrTooLarge,
+		},
+		"string longer than the size limit": {
+			data: header(cbg.MajByteString, cborlimit.DefaultMaxSize),
+			err:  cborlimit.ErrTooLarge,
+		},
+		"array with more items than could fit": {
+			data: header(cbg.MajArray, 1<<40),
+			err:  cborlimit.ErrTooLarge,
+		},
+		"map with more entries than could fit": {
+			data:   append(header(cbg.MajMap, 8), 0, 0, 0, 0),
+			limits: cborlimit.Limits{MaxSize: 16},
+			err:    cborlimit.ErrTooLarge,
+		},
+		"nested too deeply": {
+			data:   bytes.Repeat(header(cbg.MajArray, 1), 10),
+			limits: cborlimit.Limits{MaxDepth: 8},
+			err:    cborlimit.ErrTooDeep,
+		},
+		"indefinite length": {
+			data: []byte{0x9f, 0x01, 0xff},
+			err:  cborlimit.ErrIndefiniteLength,
+		},
+		"truncated": {
+			data: encoded[:len(encoded)/2],
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var out storagemarket.StorageAsk
+			err := cborlimit.ReadWithLimits(bytes.NewReader(tc.data), &out, tc.limits)
+			require.True(t, errors.Is(err, tc.err), "expected %s, got %v", tc.err, err)
+		})
+	}
+
+	t.Run("decoder panics are returned as errors", func(t *testing.T) {
+		err := cborlimit.Unmarshal(encoded, panicker{})
+		require.EqualError(t, err, "decoding message: panic: boom")
+	})
+}
+
+type panicker struct{}
+
+func (panicker) UnmarshalCBOR(io.Reader) error {
+	panic("boom")
+}
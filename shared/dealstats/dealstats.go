@@ -0,0 +1,144 @@
+/*
+Package dealstats keeps rolling counts of deal activity, such as the number of deals
+accepted or the bytes of data transferred for them, so that providers can report
+their throughput to operators.
+
+Counts are kept in hourly buckets, and each bucket is written to a datastore as CBOR
+as it changes, so that statistics survive restarts. Buckets older than Retention are
+discarded.
+*/
+package dealstats
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+)
+
+// DefaultWindow is the period providers report statistics over
+const DefaultWindow = 24 * time.Hour
+
+// Retention is how long hourly counts are kept
+const Retention = 7 * 24 * time.Hour
+
+// Counts are the values of named counters
+type Counts map[string]uint64
+
+// Recorder records counts of deal activity by the hour
+type Recorder struct {
+	ds datastore.Datastore
+
+	lk    sync.Mutex
+	hours map[int64]Counts
+	now   func() time.Time
+}
+
+// New returns a Recorder that persists its counts to ds, loading the counts already
+// in it
+func New(ds datastore.Datastore) (*Recorder, error) {
+	r := &Recorder{
+		ds:    ds,
+		hours: make(map[int64]Counts),
+		now:   time.Now,
+	}
+	results, err := ds.Query(query.Query{})
+	if err != nil {
+		return nil, xerrors.Errorf("listing deal stats: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, xerrors.Errorf("listing deal stats: %w", err)
+	}
+	for _, entry := range entries {
+		hour, err := strconv.ParseInt(datastore.NewKey(entry.Key).BaseNamespace(), 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing deal stats key %s: %w", entry.Key, err)
+		}
+		var stored hourCounts
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &stored); err != nil {
+			return nil, xerrors.Errorf("decoding deal stats for %s: %w", entry.Key, err)
+		}
+		r.hours[hour] = stored.counts()
+	}
+	return r, nil
+}
+
+// Add adds n to the named counter for the current hour
+func (r *Recorder) Add(counter string, n uint64) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	hour := r.now().Truncate(time.Hour).Unix()
+	counts, ok := r.hours[hour]
+	if !ok {
+		counts = make(Counts)
+		r.hours[hour] = counts
+		if err := r.prune(hour); err != nil {
+			return err
+		}
+	}
+	counts[counter] += n
+
+	value, err := cborutil.Dump(newHourCounts(counts))
+	if err != nil {
+		return err
+	}
+	if err := r.ds.Put(hourKey(hour), value); err != nil {
+		return xerrors.Errorf("saving deal stats: %w", err)
+	}
+	return nil
+}
+
+// Sum returns the total of the named counter over the given window, ending with the
+// current hour
+func (r *Recorder) Sum(counter string, window time.Duration) uint64 {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	from := r.now().Truncate(time.Hour).Add(time.Hour - window).Unix()
+	var sum uint64
+	for hour, counts := range r.hours {
+		if hour >= from {
+			sum += counts[counter]
+		}
+	}
+	return sum
+}
+
+// PerHour returns the hourly rate of n events over the given window
+func PerHour(n uint64, window time.Duration) float64 {
+	return float64(n) / window.Hours()
+}
+
+// GiBPerDay returns the daily rate, in GiB, of the given number of bytes over the
+// given window
+func GiBPerDay(bytes uint64, window time.Duration) float64 {
+	return float64(bytes) / (1 << 30) / (window.Hours() / 24)
+}
+
+// prune discards the buckets that are older than Retention. It must be called with
+// lk held
+func (r *Recorder) prune(current int64) error {
+	oldest := current - int64(Retention/time.Second)
+	for hour := range r.hours {
+		if hour > oldest {
+			continue
+		}
+		delete(r.hours, hour)
+		if err := r.ds.Delete(hourKey(hour)); err != nil {
+			return xerrors.Errorf("removing old deal stats: %w", err)
+		}
+	}
+	return nil
+}
+
+func hourKey(hour int64) datastore.Key {
+	return datastore.NewKey(strconv.FormatInt(hour, 10))
+}
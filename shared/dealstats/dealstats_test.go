@@ -0,0 +1,53 @@
+package dealstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	start := time.Date(2021, 1, 1, 12, 30, 0, 0, time.UTC)
+	now := start
+
+	r, err := New(ds)
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.Add("accepted", 1))
+	require.NoError(t, r.Add("bytes", 100))
+	now = now.Add(time.Hour)
+	require.NoError(t, r.Add("accepted", 2))
+
+	require.Equal(t, uint64(3), r.Sum("accepted", DefaultWindow))
+	require.Equal(t, uint64(2), r.Sum("accepted", time.Hour))
+	require.Equal(t, uint64(100), r.Sum("bytes", DefaultWindow))
+	require.Zero(t, r.Sum("other", DefaultWindow))
+
+	// counts are reloaded after a restart
+	r, err = New(ds)
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	require.Equal(t, uint64(3), r.Sum("accepted", DefaultWindow))
+
+	// counts fall out of the window as time passes
+	now = start.Add(DefaultWindow)
+	require.Equal(t, uint64(2), r.Sum("accepted", DefaultWindow))
+
+	// and are discarded once they are older than Retention
+	now = start.Add(Retention + 2*time.Hour)
+	require.NoError(t, r.Add("accepted", 1))
+	r, err = New(ds)
+	require.NoError(t, err)
+	require.Len(t, r.hours, 1)
+}
+
+func TestRates(t *testing.T) {
+	require.Equal(t, 2.0, PerHour(48, DefaultWindow))
+	require.Equal(t, 3.0, GiBPerDay(3<<30, DefaultWindow))
+	require.Equal(t, 1.5, GiBPerDay(3<<30, 48*time.Hour))
+}
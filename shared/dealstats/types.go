@@ -0,0 +1,35 @@
+package dealstats
+
+import "sort"
+
+//go:generate cbor-gen-for --map-encoding hourCounts counter
+
+// hourCounts is how the counts of an hour are stored
+type hourCounts struct {
+	Counters []counter
+}
+
+// counter is the stored value of a named counter
+type counter struct {
+	Name  string
+	Count uint64
+}
+
+func newHourCounts(counts Counts) *hourCounts {
+	stored := &hourCounts{Counters: make([]counter, 0, len(counts))}
+	for name, count := range counts {
+		stored.Counters = append(stored.Counters, counter{Name: name, Count: count})
+	}
+	sort.Slice(stored.Counters, func(i, j int) bool {
+		return stored.Counters[i].Name < stored.Counters[j].Name
+	})
+	return stored
+}
+
+func (h *hourCounts) counts() Counts {
+	counts := make(Counts, len(h.Counters))
+	for _, c := range h.Counters {
+		counts[c.Name] = c.Count
+	}
+	return counts
+}
@@ -0,0 +1,215 @@
+/*
+Package eventbus delivers deal events to subscribers without letting a slow
+subscriber hold up the deal state machines that publish them.
+
+Publishing an event never waits on a subscriber. Each subscriber has its own queue
+of events and a goroutine that delivers them in the order they were published.
+By default queues grow as needed, so every subscriber sees every event. A bus can
+instead bound its queues: when a subscriber is still handling an earlier event and
+its queue is full, the bus applies its overflow policy, and either drops the oldest
+queued event to make room, or disconnects the subscriber. The number of events
+dropped is kept for monitoring.
+*/
+package eventbus
+
+import (
+	"sync"
+
+	"github.com/hannahhoward/go-pubsub"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("eventbus")
+
+// DefaultQueueSize is how many events are queued for a subscriber before a
+// bounded overflow policy applies
+const DefaultQueueSize = 1024
+
+// OverflowPolicy is what happens when a subscriber's queue is full
+type OverflowPolicy uint64
+
+const (
+	// Unbounded grows the queue as needed, so no events are dropped
+	Unbounded OverflowPolicy = iota
+
+	// DropOldest drops the oldest event in the queue to make room for the new one
+	DropOldest
+
+	// Disconnect unsubscribes the subscriber, dropping its queued events
+	Disconnect
+)
+
+// OverflowPolicies maps overflow policies to their names
+var OverflowPolicies = map[OverflowPolicy]string{
+	Unbounded:  "Unbounded",
+	DropOldest: "DropOldest",
+	Disconnect: "Disconnect",
+}
+
+func (p OverflowPolicy) String() string {
+	return OverflowPolicies[p]
+}
+
+// Stats count the events a bus has published and dropped
+type Stats struct {
+	Subscribers int
+	Published   uint64
+	// Dropped is the number of events that were not delivered to a subscriber
+	// because its queue was full or it was disconnected
+	Dropped uint64
+	// Disconnected is the number of subscribers disconnected for falling behind
+	Disconnected uint64
+}
+
+// Bus publishes events to subscribers through per subscriber queues
+type Bus struct {
+	dispatcher pubsub.Dispatcher
+	queueSize  int
+	policy     OverflowPolicy
+
+	lk           sync.Mutex
+	nextID       uint64
+	subscribers  map[uint64]*subscriber
+	published    uint64
+	dropped      uint64
+	disconnected uint64
+}
+
+// New returns a bus that delivers events with the given dispatcher, queueing up to
+// queueSize events for each subscriber that is still handling an earlier event
+// before the overflow policy applies. A queueSize of zero uses DefaultQueueSize
+func New(dispatcher pubsub.Dispatcher, queueSize int, policy OverflowPolicy) *Bus {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Bus{
+		dispatcher:  dispatcher,
+		queueSize:   queueSize,
+		policy:      policy,
+		subscribers: make(map[uint64]*subscriber),
+	}
+}
+
+// Subscribe adds a subscriber, and returns a function that removes it. Events
+// already queued for a removed subscriber are not delivered
+func (b *Bus) Subscribe(fn pubsub.SubscriberFn) pubsub.Unsubscribe {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	s := &subscriber{
+		fn:     fn,
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	b.subscribers[id] = s
+	go b.deliver(s)
+
+	return func() {
+		b.lk.Lock()
+		defer b.lk.Unlock()
+		b.remove(id)
+	}
+}
+
+// Publish queues an event for every subscriber. It does not wait for the event to
+// be delivered
+func (b *Bus) Publish(evt pubsub.Event) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.published++
+	for id, s := range b.subscribers {
+		// a subscriber that is not handling an event is only waiting to be scheduled,
+		// and has not fallen behind
+		if b.policy != Unbounded && s.delivering && len(s.queue) >= b.queueSize {
+			switch b.policy {
+			case Disconnect:
+				log.Warnf("disconnecting event subscriber that fell %d events behind", len(s.queue))
+				b.dropped += uint64(len(s.queue)) + 1
+				b.disconnected++
+				b.remove(id)
+				continue
+			default:
+				s.queue[0] = nil
+				s.queue = s.queue[1:]
+				b.dropped++
+			}
+		}
+		s.queue = append(s.queue, evt)
+		select {
+		case s.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stats returns the number of events published and dropped so far
+func (b *Bus) Stats() Stats {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return Stats{
+		Subscribers:  len(b.subscribers),
+		Published:    b.published,
+		Dropped:      b.dropped,
+		Disconnected: b.disconnected,
+	}
+}
+
+type subscriber struct {
+	fn         pubsub.SubscriberFn
+	queue      []pubsub.Event
+	delivering bool
+	signal     chan struct{}
+	done       chan struct{}
+}
+
+// remove stops delivery to a subscriber. It must be called with lk held
+func (b *Bus) remove(id uint64) {
+	s, ok := b.subscribers[id]
+	if !ok {
+		return
+	}
+	delete(b.subscribers, id)
+	s.queue = nil
+	close(s.done)
+}
+
+// deliver runs the subscriber on each event queued for it, until it is removed
+func (b *Bus) deliver(s *subscriber) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.signal:
+		}
+
+		for {
+			b.lk.Lock()
+			if len(s.queue) == 0 {
+				b.lk.Unlock()
+				break
+			}
+			evt := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.delivering = true
+			b.lk.Unlock()
+
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if err := b.dispatcher(evt, s.fn); err != nil {
+				log.Errorf("delivering event: %s", err)
+			}
+
+			b.lk.Lock()
+			s.delivering = false
+			b.lk.Unlock()
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package eventbus_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+)
+
+type subscriberFn func(int)
+
+func dispatcher(evt pubsub.Event, fn pubsub.SubscriberFn) error {
+	n, ok := evt.(int)
+	if !ok {
+		return errors.New("wrong type of event")
+	}
+	cb, ok := fn.(subscriberFn)
+	if !ok {
+		return errors.New("wrong type of callback")
+	}
+	cb(n)
+	return nil
+}
+
+func receive(t *testing.T, events <-chan int) int {
+	select {
+	case n := <-events:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+	return 0
+}
+
+func TestBus(t *testing.T) {
+	t.Run("delivers events in order without waiting for subscribers", func(t *testing.T) {
+		bus := eventbus.New(dispatcher, 0, eventbus.DropOldest)
+		events := make(chan int, 16)
+		unblock := make(chan struct{})
+		bus.Subscribe(subscriberFn(func(n int) {
+			<-unblock
+			events <- n
+		}))
+
+		for i := 0; i < 3; i++ {
+			bus.Publish(i)
+		}
+		close(unblock)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, i, receive(t, events))
+		}
+	})
+
+	t.Run("drops the oldest events for a subscriber that falls behind", func(t *testing.T) {
+		bus := eventbus.New(dispatcher, 2, eventbus.DropOldest)
+		events := make(chan int, 16)
+		unblock := make(chan struct{})
+		bus.Subscribe(subscriberFn(func(n int) {
+			<-unblock
+			events <- n
+		}))
+
+		// the first event is taken off the queue, then blocks the subscriber
+		bus.Publish(0)
+		time.Sleep(10 * time.Millisecond)
+		for i := 1; i < 5; i++ {
+			bus.Publish(i)
+		}
+		close(unblock)
+		require.Equal(t, 0, receive(t, events))
+		require.Equal(t, 3, receive(t, events))
+		require.Equal(t, 4, receive(t, events))
+
+		stats := bus.Stats()
+		require.Equal(t, uint64(5), stats.Published)
+		require.Equal(t, uint64(2), stats.Dropped)
+		require.Equal(t, 1, stats.Subscribers)
+	})
+
+	t.Run("disconnects a subscriber that falls behind", func(t *testing.T) {
+		bus := eventbus.New(dispatcher, 2, eventbus.Disconnect)
+		slow := make(chan int, 16)
+		unblock := make(chan struct{})
+		bus.Subscribe(subscriberFn(func(n int) {
+			<-unblock
+			slow <- n
+		}))
+		fast := make(chan int, 16)
+		bus.Subscribe(subscriberFn(func(n int) {
+			fast <- n
+		}))
+
+		bus.Publish(0)
+		time.Sleep(10 * time.Millisecond)
+		for i := 1; i < 4; i++ {
+			bus.Publish(i)
+		}
+		close(unblock)
+		for i := 0; i < 4; i++ {
+			require.Equal(t, i, receive(t, fast))
+		}
+		require.Equal(t, 0, receive(t, slow))
+
+		stats := bus.Stats()
+		require.Equal(t, 1, stats.Subscribers)
+		require.Equal(t, uint64(1), stats.Disconnected)
+		require.Equal(t, uint64(3), stats.Dropped)
+	})
+
+	t.Run("queues every event for a slow subscriber by default", func(t *testing.T) {
+		bus := eventbus.New(dispatcher, 2, eventbus.Unbounded)
+		events := make(chan int, 16)
+		unblock := make(chan struct{})
+		bus.Subscribe(subscriberFn(func(n int) {
+			<-unblock
+			events <- n
+		}))
+
+		for i := 0; i < 5; i++ {
+			bus.Publish(i)
+		}
+		close(unblock)
+		for i := 0; i < 5; i++ {
+			require.Equal(t, i, receive(t, events))
+		}
+		require.Zero(t, bus.Stats().Dropped)
+	})
+
+	t.Run("stops delivering to subscribers that unsubscribe", func(t *testing.T) {
+		bus := eventbus.New(dispatcher, 0, eventbus.DropOldest)
+		events := make(chan int, 16)
+		unsub := bus.Subscribe(subscriberFn(func(n int) {
+			events <- n
+		}))
+		bus.Publish(0)
+		require.Equal(t, 0, receive(t, events))
+		unsub()
+		bus.Publish(1)
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, events)
+		require.Equal(t, 0, bus.Stats().Subscribers)
+	})
+}
@@ -0,0 +1,718 @@
+/*
+Package eventstore keeps deal state as an append-only log of changes, with
+periodic snapshots, instead of overwriting the whole state on every update.
+
+A Datastore wraps another datastore. Each value written to it that is a CBOR map,
+as the deal states of both markets are, is kept as a log of entries, one for each
+Put. An entry holds only the fields of the map the Put changed, so a deal that is
+updated often with small changes, such as a retrieval deal on each payment, writes
+little more than those changes. Every so many entries, and whenever the fields of
+the value change shape, an entry holds a snapshot of the whole value instead.
+
+Reads return the latest value as if it had been overwritten, so a Datastore can be
+passed to anything that expects the datastore it wraps. The storage and retrieval
+clients and providers keep their deals in one when given their LogClientDeals or
+LogProviderDeals option. History and ValueAt inspect the log of a key, to audit how a
+deal came to its current state or see the state it was in at any earlier point.
+Values that are not CBOR maps are stored as they are, and values already in the
+wrapped datastore are read as they are until they are next written.
+
+Deleting a key removes its log and snapshots along with its value, so the log of a
+key only grows while the key is in use.
+
+The latest values of the keys read or written most recently are cached, up to a
+set number of keys, so that the log is not replayed on every read. A cached value
+is only used while the key's log has not moved on, so several Datastores can wrap
+the same datastore, such as a provider and a read-only view of its deals.
+*/
+package eventstore
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+)
+
+// DefaultSnapshotEvery is how many entries a key's log has between snapshots by default
+const DefaultSnapshotEvery = 16
+
+// DefaultCacheSize is how many keys' latest values are cached by default
+const DefaultCacheSize = 1024
+
+// ErrReservedKey is returned when writing a key under the prefix the log is kept at
+var ErrReservedKey = xerrors.New("key is reserved for the event log")
+
+var (
+	rootKey      = datastore.NewKey("/eventstore")
+	headsKey     = rootKey.ChildString("heads")
+	logKey       = rootKey.ChildString("log")
+	snapshotsKey = rootKey.ChildString("snapshots")
+)
+
+// Datastore is a datastore that keeps CBOR map values as a log of changes with
+// periodic snapshots
+type Datastore struct {
+	ds            datastore.Batching
+	snapshotEvery uint64
+	cacheSize     int
+
+	lk sync.Mutex
+	// latest caches the latest values of the keys used most recently, with the
+	// most recent at the front, so that the log is not replayed on every read
+	latest     *list.List
+	latestKeys map[datastore.Key]*list.Element
+}
+
+// cached is the latest value of a key as of the given entry of its log
+type cached struct {
+	key   datastore.Key
+	seq   uint64
+	value []byte
+}
+
+var _ datastore.Batching = (*Datastore)(nil)
+
+// Option configures a Datastore
+type Option func(*Datastore)
+
+// CacheSize sets how many keys' latest values are cached. Zero turns the cache off
+func CacheSize(size int) Option {
+	return func(d *Datastore) {
+		d.cacheSize = size
+	}
+}
+
+// New returns a datastore that keeps CBOR map values written to it as a log of
+// changes in ds, with a snapshot of the whole value every snapshotEvery entries.
+// Zero means DefaultSnapshotEvery
+func New(ds datastore.Batching, snapshotEvery uint64, options ...Option) *Datastore {
+	if snapshotEvery == 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+	d := &Datastore{
+		ds:            ds,
+		snapshotEvery: snapshotEvery,
+		cacheSize:     DefaultCacheSize,
+		latest:        list.New(),
+		latestKeys:    make(map[datastore.Key]*list.Element),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// Get returns the latest value of the key
+func (d *Datastore) Get(key datastore.Key) ([]byte, error) {
+	if isReserved(key) {
+		return nil, datastore.ErrNotFound
+	}
+	value, logged, err := d.loggedValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if !logged {
+		return d.ds.Get(key)
+	}
+	return value, nil
+}
+
+// Has returns true if the key has a value
+func (d *Datastore) Has(key datastore.Key) (bool, error) {
+	if isReserved(key) {
+		return false, nil
+	}
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	head, found, err := d.head(key)
+	if err != nil {
+		return false, err
+	}
+	if found && !head.Deleted {
+		return true, nil
+	}
+	return d.ds.Has(key)
+}
+
+// GetSize returns the size of the latest value of the key
+func (d *Datastore) GetSize(key datastore.Key) (int, error) {
+	value, err := d.Get(key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+// Put writes the value of the key. A CBOR map is appended to the key's log, and any
+// other value is stored as it is
+func (d *Datastore) Put(key datastore.Key, value []byte) error {
+	if isReserved(key) {
+		return ErrReservedKey
+	}
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return d.put(key, value)
+}
+
+// Delete deletes the key, removing its log and snapshots
+func (d *Datastore) Delete(key datastore.Key) error {
+	if isReserved(key) {
+		return nil
+	}
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return d.delete(key)
+}
+
+// Query returns the latest values of the keys that match the query. Values are read
+// as the results are, so the datastore is not locked while the query is open
+func (d *Datastore) Query(q query.Query) (query.Results, error) {
+	heads, err := d.ds.Query(query.Query{Prefix: headsKey.Child(datastore.NewKey(q.Prefix)).String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	plain, err := d.ds.Query(query.Query{Prefix: q.Prefix})
+	if err != nil {
+		heads.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	headsDone := false
+	next := func() (query.Result, bool) {
+		for !headsDone {
+			result, ok := heads.NextSync()
+			if !ok {
+				headsDone = true
+				break
+			}
+			if result.Error != nil {
+				return result, true
+			}
+			key := datastore.NewKey(result.Key[len(headsKey.String()):])
+			value, logged, err := d.loggedValue(key)
+			if err != nil {
+				return query.Result{Error: err}, true
+			}
+			if !logged {
+				continue
+			}
+			return query.Result{Entry: query.Entry{Key: key.String(), Value: value, Size: len(value)}}, true
+		}
+		// a key with a log has no value of its own in the wrapped datastore
+		for {
+			result, ok := plain.NextSync()
+			if !ok || result.Error != nil {
+				return result, ok
+			}
+			if !isReserved(datastore.NewKey(result.Key)) {
+				return result, true
+			}
+		}
+	}
+	closeAll := func() error {
+		err := heads.Close()
+		if plainErr := plain.Close(); err == nil {
+			err = plainErr
+		}
+		return err
+	}
+	return query.NaiveQueryApply(q, query.ResultsFromIterator(q, query.Iterator{Next: next, Close: closeAll})), nil
+}
+
+// Sync flushes the values under the prefix, and the logs, to the wrapped datastore
+func (d *Datastore) Sync(prefix datastore.Key) error {
+	if err := d.ds.Sync(prefix); err != nil {
+		return err
+	}
+	return d.ds.Sync(rootKey)
+}
+
+// Close drops the cache of latest values. It does not close the wrapped datastore
+func (d *Datastore) Close() error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.latest.Init()
+	d.latestKeys = make(map[datastore.Key]*list.Element)
+	return nil
+}
+
+// Batch returns a batch whose writes are made in order when it is committed
+func (d *Datastore) Batch() (datastore.Batch, error) {
+	return &batch{d: d}, nil
+}
+
+// History returns the log of the key, oldest entry first. It returns an empty log
+// for a key that has not been written since it was wrapped or last deleted
+func (d *Datastore) History(key datastore.Key) ([]Entry, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	head, found, err := d.head(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	entries := make([]Entry, 0, head.Seq)
+	for seq := uint64(1); seq <= head.Seq; seq++ {
+		entry, err := d.entry(key, seq)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ValueAt returns the value the key had once the entry with the given sequence
+// number was written. It returns datastore.ErrNotFound if the key was deleted at
+// that point
+func (d *Datastore) ValueAt(key datastore.Key, seq uint64) ([]byte, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	head, found, err := d.head(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found || seq == 0 || seq > head.Seq {
+		return nil, xerrors.Errorf("%s has no log entry %d", key, seq)
+	}
+
+	// find the latest snapshot at or before seq by walking back from seq
+	entries := make([]Entry, 0, d.snapshotEvery)
+	for s := seq; ; s-- {
+		entry, err := d.entry(key, s)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Deleted {
+			if s == seq {
+				return nil, datastore.ErrNotFound
+			}
+			return nil, xerrors.Errorf("log of %s has changes after a delete at entry %d", key, s)
+		}
+		entries = append(entries, entry)
+		if entry.Snapshot {
+			break
+		}
+		if s == 1 {
+			return nil, xerrors.Errorf("log of %s does not start with a snapshot", key)
+		}
+	}
+	snapshot := entries[len(entries)-1]
+	value, err := d.ds.Get(snapshotKey(key, snapshot.Seq))
+	if err != nil {
+		return nil, xerrors.Errorf("reading snapshot %d of %s: %w", snapshot.Seq, key, err)
+	}
+	for i := len(entries) - 2; i >= 0; i-- {
+		value, err = applyChanges(value, entries[i].Changes)
+		if err != nil {
+			return nil, xerrors.Errorf("applying entry %d of %s: %w", entries[i].Seq, key, err)
+		}
+	}
+	return value, nil
+}
+
+func (d *Datastore) put(key datastore.Key, value []byte) error {
+	head, found, err := d.head(key)
+	if err != nil {
+		return err
+	}
+	fields, isMap := mapFields(value)
+
+	b, err := d.ds.Batch()
+	if err != nil {
+		return err
+	}
+	if !isMap {
+		// values that are not CBOR maps are stored as they are, replacing the key's log
+		if found {
+			if err := d.purge(b, key); err != nil {
+				return err
+			}
+		}
+		if err := b.Put(key, value); err != nil {
+			return err
+		}
+		if err := b.Commit(); err != nil {
+			return err
+		}
+		d.uncache(key)
+		return nil
+	}
+
+	var previous []byte
+	if found && !head.Deleted {
+		previous, err = d.latestValue(key, head)
+		if err != nil {
+			return err
+		}
+	}
+	if !found || head.Deleted {
+		// a value written before the key was wrapped, or since its log ended, is
+		// replaced by the log
+		has, err := d.ds.Has(key)
+		if err != nil {
+			return err
+		}
+		if has {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	entry := Entry{Snapshot: true}
+	if previous != nil && head.Seq+1-head.SnapshotSeq < d.snapshotEvery {
+		if changes, ok := diff(previous, fields); ok && changesSize(changes) < len(value) {
+			entry = Entry{Changes: changes}
+		}
+	}
+	if entry.Snapshot {
+		if err := b.Put(snapshotKey(key, head.Seq+1), value); err != nil {
+			return err
+		}
+	}
+	if err := d.appendEntry(b, key, &head, entry); err != nil {
+		return err
+	}
+	if err := b.Commit(); err != nil {
+		return err
+	}
+	d.cache(key, head.Seq, append([]byte(nil), value...))
+	return nil
+}
+
+func (d *Datastore) delete(key datastore.Key) error {
+	head, found, err := d.head(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return d.ds.Delete(key)
+	}
+	b, err := d.ds.Batch()
+	if err != nil {
+		return err
+	}
+	if err := d.purge(b, key); err != nil {
+		return err
+	}
+	if head.Deleted {
+		// the log ended with a delete, and the key may have a value of its own since
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(); err != nil {
+		return err
+	}
+	d.uncache(key)
+	return nil
+}
+
+// purge removes the key's log entries, snapshots and head in the batch
+func (d *Datastore) purge(b datastore.Batch, key datastore.Key) error {
+	for _, prefix := range []datastore.Key{logKey.Child(key), snapshotsKey.Child(key)} {
+		results, err := d.ds.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		entries, err := results.Rest()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// the logs of keys below this one are kept
+			entryKey := datastore.NewKey(entry.Key)
+			if !entryKey.Parent().Equal(prefix) {
+				continue
+			}
+			if err := b.Delete(entryKey); err != nil {
+				return err
+			}
+		}
+	}
+	return b.Delete(headsKey.Child(key))
+}
+
+// appendEntry adds the entry to the key's log in the batch, numbering it and
+// moving the head past it
+func (d *Datastore) appendEntry(b datastore.Batch, key datastore.Key, head *Head, entry Entry) error {
+	entry.Seq = head.Seq + 1
+	entry.Time = time.Now().UnixNano()
+	head.Seq = entry.Seq
+	head.Deleted = entry.Deleted
+	if entry.Snapshot {
+		head.SnapshotSeq = entry.Seq
+	}
+	entryBytes, err := cborutil.Dump(&entry)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(entryKey(key, entry.Seq), entryBytes); err != nil {
+		return err
+	}
+	headBytes, err := cborutil.Dump(head)
+	if err != nil {
+		return err
+	}
+	return b.Put(headsKey.Child(key), headBytes)
+}
+
+func (d *Datastore) head(key datastore.Key) (Head, bool, error) {
+	var head Head
+	headBytes, err := d.ds.Get(headsKey.Child(key))
+	if err == datastore.ErrNotFound {
+		return head, false, nil
+	}
+	if err != nil {
+		return head, false, err
+	}
+	if err := head.UnmarshalCBOR(bytes.NewReader(headBytes)); err != nil {
+		return head, false, xerrors.Errorf("reading log head of %s: %w", key, err)
+	}
+	return head, true, nil
+}
+
+func (d *Datastore) entry(key datastore.Key, seq uint64) (Entry, error) {
+	var entry Entry
+	entryBytes, err := d.ds.Get(entryKey(key, seq))
+	if err != nil {
+		return entry, xerrors.Errorf("reading entry %d of %s: %w", seq, key, err)
+	}
+	if err := entry.UnmarshalCBOR(bytes.NewReader(entryBytes)); err != nil {
+		return entry, xerrors.Errorf("reading entry %d of %s: %w", seq, key, err)
+	}
+	return entry, nil
+}
+
+// loggedValue returns a copy of the latest value of the key, or false if the key has
+// no log or its log ended with a delete
+func (d *Datastore) loggedValue(key datastore.Key) ([]byte, bool, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	head, found, err := d.head(key)
+	if err != nil || !found || head.Deleted {
+		return nil, false, err
+	}
+	value, err := d.latestValue(key, head)
+	if err != nil {
+		return nil, false, err
+	}
+	return append([]byte(nil), value...), true, nil
+}
+
+// latestValue returns the value of the key from the cache, or replays the log since
+// the latest snapshot. The returned value must not be modified
+func (d *Datastore) latestValue(key datastore.Key, head Head) ([]byte, error) {
+	if elem, ok := d.latestKeys[key]; ok {
+		c := elem.Value.(*cached)
+		if c.seq == head.Seq {
+			d.latest.MoveToFront(elem)
+			return c.value, nil
+		}
+	}
+	value, err := d.ds.Get(snapshotKey(key, head.SnapshotSeq))
+	if err != nil {
+		return nil, xerrors.Errorf("reading snapshot %d of %s: %w", head.SnapshotSeq, key, err)
+	}
+	for seq := head.SnapshotSeq + 1; seq <= head.Seq; seq++ {
+		entry, err := d.entry(key, seq)
+		if err != nil {
+			return nil, err
+		}
+		value, err = applyChanges(value, entry.Changes)
+		if err != nil {
+			return nil, xerrors.Errorf("applying entry %d of %s: %w", seq, key, err)
+		}
+	}
+	d.cache(key, head.Seq, value)
+	return value, nil
+}
+
+// cache keeps the value of the key as of the given entry, dropping the values of the
+// keys used least recently if the cache is full
+func (d *Datastore) cache(key datastore.Key, seq uint64, value []byte) {
+	if d.cacheSize <= 0 {
+		return
+	}
+	if elem, ok := d.latestKeys[key]; ok {
+		elem.Value = &cached{key: key, seq: seq, value: value}
+		d.latest.MoveToFront(elem)
+		return
+	}
+	d.latestKeys[key] = d.latest.PushFront(&cached{key: key, seq: seq, value: value})
+	for d.latest.Len() > d.cacheSize {
+		d.uncache(d.latest.Back().Value.(*cached).key)
+	}
+}
+
+func (d *Datastore) uncache(key datastore.Key) {
+	if elem, ok := d.latestKeys[key]; ok {
+		d.latest.Remove(elem)
+		delete(d.latestKeys, key)
+	}
+}
+
+func isReserved(key datastore.Key) bool {
+	return key.Equal(rootKey) || rootKey.IsAncestorOf(key)
+}
+
+func entryKey(key datastore.Key, seq uint64) datastore.Key {
+	return logKey.Child(key).ChildString(fmt.Sprintf("%020d", seq))
+}
+
+func snapshotKey(key datastore.Key, seq uint64) datastore.Key {
+	return snapshotsKey.Child(key).ChildString(fmt.Sprintf("%020d", seq))
+}
+
+// field is a field of a CBOR map, with its value still encoded
+type field struct {
+	name  string
+	value []byte
+}
+
+// mapFields splits a CBOR map with string keys into its fields, in order. It returns
+// false if the value is anything else
+func mapFields(value []byte) ([]field, bool) {
+	r := bytes.NewReader(value)
+	scratch := make([]byte, 8)
+	maj, n, err := cbg.CborReadHeaderBuf(r, scratch)
+	if err != nil || maj != cbg.MajMap || n > cbg.MaxLength {
+		return nil, false
+	}
+	fields := make([]field, 0, n)
+	for i := uint64(0); i < n; i++ {
+		name, err := cbg.ReadStringBuf(r, scratch)
+		if err != nil {
+			return nil, false
+		}
+		var fieldValue cbg.Deferred
+		if err := fieldValue.UnmarshalCBOR(r); err != nil {
+			return nil, false
+		}
+		fields = append(fields, field{name: name, value: fieldValue.Raw})
+	}
+	if r.Len() != 0 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// diff returns the fields that changed from the previous value. It returns false if
+// the fields of the two values are not the same, in the same order, as only the
+// values of fields are logged
+func diff(previous []byte, fields []field) ([]FieldChange, bool) {
+	previousFields, ok := mapFields(previous)
+	if !ok || len(previousFields) != len(fields) {
+		return nil, false
+	}
+	var changes []FieldChange
+	for i, f := range fields {
+		if previousFields[i].name != f.name {
+			return nil, false
+		}
+		if !bytes.Equal(previousFields[i].value, f.value) {
+			changes = append(changes, FieldChange{Field: f.name, Value: f.value})
+		}
+	}
+	return changes, true
+}
+
+func changesSize(changes []FieldChange) int {
+	size := 0
+	for _, change := range changes {
+		size += len(change.Field) + len(change.Value)
+	}
+	return size
+}
+
+// applyChanges returns the value with the given fields changed
+func applyChanges(value []byte, changes []FieldChange) ([]byte, error) {
+	if len(changes) == 0 {
+		return value, nil
+	}
+	fields, ok := mapFields(value)
+	if !ok {
+		return nil, xerrors.New("value is not a CBOR map")
+	}
+	for _, change := range changes {
+		found := false
+		for i := range fields {
+			if fields[i].name == change.Field {
+				fields[i].value = change.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, xerrors.Errorf("value has no field %q", change.Field)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := cbg.WriteMajorTypeHeader(&buf, cbg.MajMap, uint64(len(fields))); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if err := cbg.WriteMajorTypeHeader(&buf, cbg.MajTextString, uint64(len(f.name))); err != nil {
+			return nil, err
+		}
+		buf.WriteString(f.name)
+		buf.Write(f.value)
+	}
+	return buf.Bytes(), nil
+}
+
+// batch collects writes and makes them in order when committed
+type batch struct {
+	d   *Datastore
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    datastore.Key
+	value  []byte
+	delete bool
+}
+
+func (b *batch) Put(key datastore.Key, value []byte) error {
+	if isReserved(key) {
+		return ErrReservedKey
+	}
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+func (b *batch) Delete(key datastore.Key) error {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+	return nil
+}
+
+func (b *batch) Commit() error {
+	b.d.lk.Lock()
+	defer b.d.lk.Unlock()
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			if isReserved(op.key) {
+				continue
+			}
+			err = b.d.delete(op.key)
+		} else {
+			err = b.d.put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	b.ops = nil
+	return nil
+}
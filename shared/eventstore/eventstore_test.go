@@ -0,0 +1,216 @@
+package eventstore_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/shared/eventstore"
+)
+
+func dealInfo(t *testing.T, sector abi.SectorNumber, length abi.PaddedPieceSize) []byte {
+	b, err := cborutil.Dump(&piecestore.DealInfo{DealID: 1, SectorID: sector, Offset: 0, Length: length})
+	require.NoError(t, err)
+	return b
+}
+
+func readDealInfo(t *testing.T, b []byte) piecestore.DealInfo {
+	var deal piecestore.DealInfo
+	require.NoError(t, deal.UnmarshalCBOR(bytes.NewReader(b)))
+	return deal
+}
+
+func TestDatastore(t *testing.T) {
+	key := datastore.NewKey("/1/deal")
+
+	t.Run("logs changed fields and reads the latest value", func(t *testing.T) {
+		base := dss.MutexWrap(datastore.NewMapDatastore())
+		ds := eventstore.New(base, 0)
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 128)))
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 256)))
+		require.NoError(t, ds.Put(key, dealInfo(t, 2, 256)))
+
+		value, err := ds.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 2, 256), value)
+
+		history, err := ds.History(key)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		require.True(t, history[0].Snapshot)
+		require.False(t, history[1].Snapshot)
+		require.Len(t, history[1].Changes, 1)
+		require.Equal(t, "Length", history[1].Changes[0].Field)
+		require.Len(t, history[2].Changes, 1)
+		require.Equal(t, "SectorID", history[2].Changes[0].Field)
+
+		// the value is replayed from the log without the cache
+		reopened := eventstore.New(base, 0)
+		value, err = reopened.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 2, 256), value)
+
+		has, err := base.Has(key)
+		require.NoError(t, err)
+		require.False(t, has)
+	})
+
+	t.Run("reads earlier values", func(t *testing.T) {
+		ds := eventstore.New(dss.MutexWrap(datastore.NewMapDatastore()), 3)
+		for i := 1; i <= 7; i++ {
+			require.NoError(t, ds.Put(key, dealInfo(t, abi.SectorNumber(i), 128)))
+		}
+		history, err := ds.History(key)
+		require.NoError(t, err)
+		for i, entry := range history {
+			require.Equal(t, uint64(i+1), entry.Seq)
+			require.Equal(t, i%3 == 0, entry.Snapshot)
+		}
+		for i := 1; i <= 7; i++ {
+			value, err := ds.ValueAt(key, uint64(i))
+			require.NoError(t, err)
+			require.Equal(t, abi.SectorNumber(i), readDealInfo(t, value).SectorID)
+		}
+		_, err = ds.ValueAt(key, 8)
+		require.Error(t, err)
+	})
+
+	t.Run("removes the log of deleted keys", func(t *testing.T) {
+		base := dss.MutexWrap(datastore.NewMapDatastore())
+		ds := eventstore.New(base, 2)
+		childKey := key.ChildString("child")
+		require.NoError(t, ds.Put(childKey, dealInfo(t, 3, 128)))
+		for i := 1; i <= 5; i++ {
+			require.NoError(t, ds.Put(key, dealInfo(t, abi.SectorNumber(i), 128)))
+		}
+		require.NoError(t, ds.Delete(key))
+
+		has, err := ds.Has(key)
+		require.NoError(t, err)
+		require.False(t, has)
+		_, err = ds.Get(key)
+		require.Equal(t, datastore.ErrNotFound, err)
+		history, err := ds.History(key)
+		require.NoError(t, err)
+		require.Empty(t, history)
+
+		// only the log of the key below it is left
+		results, err := base.Query(query.Query{KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := results.Rest()
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		value, err := ds.Get(childKey)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 3, 128), value)
+
+		// a key written again starts a new log
+		require.NoError(t, ds.Put(key, dealInfo(t, 6, 128)))
+		history, err = ds.History(key)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.True(t, history[0].Snapshot)
+		value, err = ds.ValueAt(key, 1)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 6, 128), value)
+	})
+
+	t.Run("values written before wrapping and other values", func(t *testing.T) {
+		base := dss.MutexWrap(datastore.NewMapDatastore())
+		require.NoError(t, base.Put(key, dealInfo(t, 1, 128)))
+		plainKey := datastore.NewKey("/versions/current")
+		ds := eventstore.New(base, 0)
+		require.NoError(t, ds.Put(plainKey, []byte("1")))
+
+		value, err := ds.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 1, 128), value)
+		value, err = base.Get(plainKey)
+		require.NoError(t, err)
+		require.Equal(t, []byte("1"), value)
+
+		otherKey := datastore.NewKey("/1/other")
+		require.NoError(t, ds.Put(otherKey, dealInfo(t, 3, 128)))
+		require.NoError(t, ds.Put(key, dealInfo(t, 2, 128)))
+
+		results, err := ds.Query(query.Query{Prefix: "/1"})
+		require.NoError(t, err)
+		entries, err := results.Rest()
+		require.NoError(t, err)
+		values := make(map[string][]byte, len(entries))
+		for _, entry := range entries {
+			values[entry.Key] = entry.Value
+		}
+		require.Equal(t, map[string][]byte{
+			key.String():      dealInfo(t, 2, 128),
+			otherKey.String(): dealInfo(t, 3, 128),
+		}, values)
+
+		require.Equal(t, eventstore.ErrReservedKey, ds.Put(datastore.NewKey("/eventstore/heads/1/deal"), []byte("1")))
+	})
+
+	t.Run("cached values follow writes through other datastores", func(t *testing.T) {
+		base := dss.MutexWrap(datastore.NewMapDatastore())
+		ds := eventstore.New(base, 0, eventstore.CacheSize(1))
+		other := eventstore.New(base, 0)
+		otherKey := datastore.NewKey("/1/other")
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 128)))
+		require.NoError(t, ds.Put(otherKey, dealInfo(t, 2, 128)))
+
+		value, err := other.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 1, 128), value)
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 256)))
+		value, err = other.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 1, 256), value)
+
+		require.NoError(t, other.Put(otherKey, dealInfo(t, 3, 128)))
+		value, err = ds.Get(otherKey)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 3, 128), value)
+		value, err = ds.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, dealInfo(t, 1, 256), value)
+	})
+
+	t.Run("writes while a query is open", func(t *testing.T) {
+		ds := eventstore.New(dss.MutexWrap(datastore.NewMapDatastore()), 0)
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 128)))
+		results, err := ds.Query(query.Query{Prefix: "/1"})
+		require.NoError(t, err)
+		require.NoError(t, ds.Put(key, dealInfo(t, 2, 128)))
+		entries, err := results.Rest()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, dealInfo(t, 2, 128), entries[0].Value)
+	})
+
+	t.Run("values stored as they are after a log ends", func(t *testing.T) {
+		ds := eventstore.New(dss.MutexWrap(datastore.NewMapDatastore()), 0)
+		require.NoError(t, ds.Put(key, dealInfo(t, 1, 128)))
+		require.NoError(t, ds.Put(key, []byte("1")))
+		value, err := ds.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("1"), value)
+		has, err := ds.Has(key)
+		require.NoError(t, err)
+		require.True(t, has)
+
+		require.NoError(t, ds.Put(key, dealInfo(t, 2, 128)))
+		results, err := ds.Query(query.Query{Prefix: "/1"})
+		require.NoError(t, err)
+		entries, err := results.Rest()
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, dealInfo(t, 2, 128), entries[0].Value)
+	})
+}
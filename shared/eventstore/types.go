@@ -0,0 +1,37 @@
+package eventstore
+
+//go:generate cbor-gen-for --map-encoding Head Entry FieldChange
+
+// Head records where the log of a key stands
+type Head struct {
+	// Seq is the sequence number of the latest entry in the log
+	Seq uint64
+	// SnapshotSeq is the sequence number of the latest entry with a snapshot
+	SnapshotSeq uint64
+	// Deleted is true if the key was deleted by the latest entry
+	Deleted bool
+}
+
+// Entry is one write to a key, in the order the writes were made
+type Entry struct {
+	// Seq numbers the entries of a key from one
+	Seq uint64
+	// Time is when the write was made, in nanoseconds since the Unix epoch
+	Time int64
+	// Snapshot is true if the whole value was stored with the entry, rather than
+	// the fields that changed
+	Snapshot bool
+	// Deleted is true if the entry deleted the key
+	Deleted bool
+	// Changes are the fields of the value the write changed, for entries without a
+	// snapshot
+	Changes []FieldChange
+}
+
+// FieldChange is the new value of one field of a CBOR map
+type FieldChange struct {
+	// Field is the name of the field
+	Field string
+	// Value is the CBOR encoding of the field's new value
+	Value []byte
+}
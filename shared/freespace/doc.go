@@ -0,0 +1,3 @@
+// Package freespace reads how much space is free on the volume holding a path, for
+// checks that keep deals from filling a disk
+package freespace
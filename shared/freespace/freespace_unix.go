@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package freespace
+
+import (
+	"syscall"
+)
+
+// Available returns the number of bytes free for unprivileged users on the volume
+// holding path
+func Available(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
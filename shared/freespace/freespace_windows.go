@@ -0,0 +1,11 @@
+package freespace
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// Available returns the number of bytes free for unprivileged users on the volume
+// holding path
+func Available(path string) (uint64, error) {
+	return 0, xerrors.New("checking free disk space is not supported on windows")
+}
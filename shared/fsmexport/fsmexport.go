@@ -0,0 +1,358 @@
+/*
+Package fsmexport exports deal state machine definitions, together with the live
+population of deals in each state, as DOT graphs or JSON snapshots.
+
+Definitions are built by walking the same fsm.Parameters the state machines run
+with, and that the static state diagrams in the docs folder are generated from, so
+runtime introspection always matches the state machines that are actually running.
+*/
+package fsmexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-statemachine/fsm"
+)
+
+// State is a single state in a state machine definition
+type State struct {
+	// Key is the string form of the underlying fsm.StateKey
+	Key  string
+	Name string
+	// EntryFunc is the name of the handler run on entering this state, if any
+	EntryFunc string `json:",omitempty"`
+	Start     bool
+	Final     bool
+}
+
+// Transition is an event that moves a deal from one state to another
+type Transition struct {
+	From  string
+	To    string
+	Event string
+}
+
+// Definition describes the states and transitions of a state machine
+type Definition struct {
+	States      []State
+	Transitions []Transition
+}
+
+// NewDefinition builds a Definition by walking the events and state entry funcs of
+// the given state machine parameters. States are named from stateNameMap and
+// events from eventNameMap, as for fsm.GenerateUML. Events that can happen in any
+// state are listed as transitions from each state that is not final and has no
+// transition of its own for the event. Events that only record are left out
+func NewDefinition(parameters fsm.Parameters, stateNameMap interface{}, eventNameMap interface{}, startStates []fsm.StateKey) (Definition, error) {
+	if err := fsm.VerifyStateParameters(parameters); err != nil {
+		return Definition{}, err
+	}
+	if err := fsm.VerifyEventParameters(parameters.StateType, parameters.StateKeyField, parameters.Events); err != nil {
+		return Definition{}, err
+	}
+	stateNames, stateKeys, err := nameMap(stateNameMap)
+	if err != nil {
+		return Definition{}, xerrors.Errorf("reading state names: %w", err)
+	}
+	eventNames, _, err := nameMap(eventNameMap)
+	if err != nil {
+		return Definition{}, xerrors.Errorf("reading event names: %w", err)
+	}
+
+	events := make([]eventSpec, 0, len(parameters.Events))
+	seen := make(map[string]bool)
+	var states []fsm.StateKey
+	addState := func(key string) error {
+		if seen[key] {
+			return nil
+		}
+		state, ok := stateKeys[key]
+		if !ok {
+			return xerrors.Errorf("state %s has no name", key)
+		}
+		seen[key] = true
+		states = append(states, state)
+		return nil
+	}
+	for _, evt := range parameters.Events {
+		spec, err := readEvent(evt)
+		if err != nil {
+			return Definition{}, err
+		}
+		if _, ok := eventNames[spec.name]; !ok {
+			return Definition{}, xerrors.Errorf("event %s has no name", spec.name)
+		}
+		for _, t := range spec.transitions {
+			if t.from != anyState {
+				if err := addState(t.from); err != nil {
+					return Definition{}, err
+				}
+			}
+			if t.to != noChange && t.to != justRecord {
+				if err := addState(t.to); err != nil {
+					return Definition{}, err
+				}
+			}
+		}
+		events = append(events, spec)
+	}
+	sort.Slice(states, func(i, j int) bool { return StateKeyCmp(states[i], states[j]) })
+
+	final := make(map[string]bool, len(parameters.FinalityStates))
+	for _, state := range parameters.FinalityStates {
+		final[stateKey(reflect.ValueOf(state))] = true
+	}
+	start := make(map[string]bool, len(startStates))
+	for _, state := range startStates {
+		start[stateKey(reflect.ValueOf(state))] = true
+	}
+
+	var def Definition
+	keys := make([]string, 0, len(states))
+	for _, state := range states {
+		key := stateKey(reflect.ValueOf(state))
+		keys = append(keys, key)
+		var entryFunc string
+		if handler, ok := parameters.StateEntryFuncs[state]; ok {
+			entryFunc = funcName(handler)
+		}
+		def.States = append(def.States, State{
+			Key:       key,
+			Name:      stateNames[key],
+			EntryFunc: entryFunc,
+			Start:     start[key],
+			Final:     final[key],
+		})
+	}
+
+	for _, spec := range events {
+		event := eventNames[spec.name]
+		from := make(map[string]string, len(spec.transitions))
+		for _, t := range spec.transitions {
+			from[t.from] = t.to
+		}
+		for _, key := range keys {
+			to, ok := from[key]
+			if !ok && !final[key] {
+				to, ok = from[anyState]
+			}
+			if !ok || to == justRecord {
+				continue
+			}
+			if to == noChange {
+				to = key
+			}
+			def.Transitions = append(def.Transitions, Transition{From: key, To: to, Event: event})
+		}
+	}
+	return def, nil
+}
+
+// StateKeyCmp orders the state keys of deal state machines, which are numbers, by
+// their value
+func StateKeyCmp(a, b fsm.StateKey) bool {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isUint(av) && isUint(bv):
+		return av.Uint() < bv.Uint()
+	case isInt(av) && isInt(bv):
+		return av.Int() < bv.Int()
+	default:
+		return stateKey(av) < stateKey(bv)
+	}
+}
+
+// the transitions of an event are keyed by these in place of a state when they
+// are not from or to a specific state
+const (
+	anyState   = "*"
+	noChange   = "-"
+	justRecord = "+"
+)
+
+type transitionSpec struct {
+	from, to string
+}
+
+type eventSpec struct {
+	name        string
+	transitions []transitionSpec
+}
+
+// readEvent reads the name and transitions of an event built with fsm.Event. The
+// fsm package does not export them, so they are read by reflection, without
+// converting them back to interfaces
+func readEvent(evt fsm.EventBuilder) (eventSpec, error) {
+	v := reflect.ValueOf(evt)
+	if v.Kind() != reflect.Struct {
+		return eventSpec{}, xerrors.Errorf("unexpected event builder %T", evt)
+	}
+	name := v.FieldByName("name")
+	transitions := v.FieldByName("transitionsSoFar")
+	if !name.IsValid() || name.Kind() != reflect.Interface || !transitions.IsValid() || transitions.Kind() != reflect.Map {
+		return eventSpec{}, xerrors.Errorf("unexpected event builder %T", evt)
+	}
+	spec := eventSpec{name: stateKey(name.Elem())}
+	iter := transitions.MapRange()
+	for iter.Next() {
+		var t transitionSpec
+		if src := iter.Key(); src.IsNil() {
+			t.from = anyState
+		} else {
+			t.from = stateKey(src.Elem())
+		}
+		switch dst := iter.Value(); {
+		case dst.IsNil():
+			t.to = noChange
+		case dst.Elem().Kind() == reflect.Struct:
+			// only ToJustRecord transitions end in a struct
+			t.to = justRecord
+		default:
+			t.to = stateKey(dst.Elem())
+		}
+		spec.transitions = append(spec.transitions, t)
+	}
+	return spec, nil
+}
+
+// nameMap reads a map of state keys or event names to strings, keyed by stateKey,
+// along with the original keys
+func nameMap(m interface{}) (map[string]string, map[string]fsm.StateKey, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map || v.Type().Elem().Kind() != reflect.String {
+		return nil, nil, xerrors.Errorf("expected a map to strings, got %T", m)
+	}
+	names := make(map[string]string, v.Len())
+	keys := make(map[string]fsm.StateKey, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := stateKey(iter.Key())
+		names[key] = iter.Value().String()
+		keys[key] = iter.Key().Interface()
+	}
+	return names, keys, nil
+}
+
+// stateKey returns the string form of a state key or event name. It only reads
+// the value's kind, so it works on values read from unexported fields
+func stateKey(v reflect.Value) string {
+	switch {
+	case isUint(v):
+		return strconv.FormatUint(v.Uint(), 10)
+	case isInt(v):
+		return strconv.FormatInt(v.Int(), 10)
+	case v.Kind() == reflect.String:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// funcName returns the name of a state entry func, without its package
+func funcName(handler fsm.StateEntryFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return strings.TrimPrefix(filepath.Ext(name), ".")
+}
+
+// Snapshot is a state machine definition together with the number of deals
+// currently in each state
+type Snapshot struct {
+	Definition
+	// Counts maps state names to the number of deals in that state
+	Counts map[string]int
+	Time   time.Time
+}
+
+// NewSnapshot counts the given current deal states against a definition. States
+// that are not part of the definition are counted under their string key
+func NewSnapshot(def Definition, current []fsm.StateKey) Snapshot {
+	names := make(map[string]string, len(def.States))
+	for _, state := range def.States {
+		names[state.Key] = state.Name
+	}
+	counts := make(map[string]int)
+	for _, key := range current {
+		k := stateKey(reflect.ValueOf(key))
+		name, ok := names[k]
+		if !ok {
+			name = k
+		}
+		counts[name]++
+	}
+	return Snapshot{Definition: def, Counts: counts, Time: time.Now()}
+}
+
+// WriteJSON writes the snapshot as JSON
+func (s Snapshot) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// WriteDOT writes the snapshot as a Graphviz DOT graph. Each state is labelled
+// with the number of deals currently in it, and states with live deals are
+// highlighted
+func (s Snapshot) WriteDOT(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "digraph deals {")
+	fmt.Fprintln(buf, "\trankdir=LR;")
+	fmt.Fprintln(buf, "\tnode [shape=box, style=rounded];")
+	for _, state := range s.States {
+		count := s.Counts[state.Name]
+		attrs := []string{fmt.Sprintf("label=%q", fmt.Sprintf("%s\n%d", state.Name, count))}
+		if count > 0 {
+			attrs = append(attrs, "style=\"rounded,filled\"", "fillcolor=lightblue")
+		}
+		if state.Final {
+			attrs = append(attrs, "peripheries=2")
+		}
+		fmt.Fprintf(buf, "\t%q [%s];\n", state.Key, strings.Join(attrs, ", "))
+	}
+
+	// collapse multiple events between the same pair of states into one edge
+	type edge struct{ from, to string }
+	events := make(map[edge][]string)
+	var edges []edge
+	for _, t := range s.Transitions {
+		e := edge{t.From, t.To}
+		if _, ok := events[e]; !ok {
+			edges = append(edges, e)
+		}
+		events[e] = append(events[e], t.Event)
+	}
+	for _, e := range edges {
+		names := events[e]
+		sort.Strings(names)
+		fmt.Fprintf(buf, "\t%q -> %q [label=%q];\n", e.from, e.to, strings.Join(names, "\n"))
+	}
+	fmt.Fprintln(buf, "}")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,133 @@
+package fsmexport_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-statemachine/fsm"
+
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+)
+
+type testStatus uint64
+
+const (
+	statusNew testStatus = iota
+	statusOngoing
+	statusCompleted
+	statusErrored
+)
+
+var testStatuses = map[testStatus]string{
+	statusNew:       "New",
+	statusOngoing:   "Ongoing",
+	statusCompleted: "Completed",
+	statusErrored:   "Errored",
+}
+
+type testEvent uint64
+
+const (
+	eventOpen testEvent = iota
+	eventBlockSent
+	eventComplete
+	eventCancel
+	eventError
+	eventRestart
+)
+
+var testEvents = map[testEvent]string{
+	eventOpen:      "EventOpen",
+	eventBlockSent: "EventBlockSent",
+	eventComplete:  "EventComplete",
+	eventCancel:    "EventCancel",
+	eventError:     "EventError",
+	eventRestart:   "EventRestart",
+}
+
+type testDeal struct {
+	Status testStatus
+}
+
+type testEnvironment struct{}
+
+func TrackTransfer(ctx fsm.Context, environment testEnvironment, deal testDeal) error {
+	return nil
+}
+
+var testParameters = fsm.Parameters{
+	Environment:   testEnvironment{},
+	StateType:     testDeal{},
+	StateKeyField: "Status",
+	Events: fsm.Events{
+		fsm.Event(eventOpen).From(statusNew).To(statusOngoing),
+		fsm.Event(eventBlockSent).From(statusOngoing).ToNoChange(),
+		fsm.Event(eventComplete).From(statusOngoing).To(statusCompleted),
+		fsm.Event(eventCancel).From(statusOngoing).To(statusCompleted),
+		fsm.Event(eventError).FromAny().To(statusErrored).From(statusNew).ToJustRecord(),
+		fsm.Event(eventRestart).FromAny().ToJustRecord(),
+	},
+	StateEntryFuncs: fsm.StateEntryFuncs{
+		statusOngoing: TrackTransfer,
+	},
+	FinalityStates: []fsm.StateKey{statusCompleted, statusErrored},
+}
+
+func TestNewDefinition(t *testing.T) {
+	def, err := fsmexport.NewDefinition(testParameters, testStatuses, testEvents, []fsm.StateKey{statusNew})
+	require.NoError(t, err)
+	require.Equal(t, []fsmexport.State{
+		{Key: "0", Name: "New", Start: true},
+		{Key: "1", Name: "Ongoing", EntryFunc: "TrackTransfer"},
+		{Key: "2", Name: "Completed", Final: true},
+		{Key: "3", Name: "Errored", Final: true},
+	}, def.States)
+	require.Equal(t, []fsmexport.Transition{
+		{From: "0", To: "1", Event: "EventOpen"},
+		{From: "1", To: "1", Event: "EventBlockSent"},
+		{From: "1", To: "2", Event: "EventComplete"},
+		{From: "1", To: "2", Event: "EventCancel"},
+		{From: "1", To: "3", Event: "EventError"},
+	}, def.Transitions)
+
+	t.Run("missing names", func(t *testing.T) {
+		_, err := fsmexport.NewDefinition(testParameters, map[testStatus]string{statusNew: "New"}, testEvents, nil)
+		require.Error(t, err)
+		_, err = fsmexport.NewDefinition(testParameters, testStatuses, map[testEvent]string{}, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestStateKeyCmp(t *testing.T) {
+	require.True(t, fsmexport.StateKeyCmp(statusOngoing, statusErrored))
+	require.False(t, fsmexport.StateKeyCmp(statusErrored, statusOngoing))
+	require.False(t, fsmexport.StateKeyCmp(statusNew, statusNew))
+}
+
+func TestSnapshot(t *testing.T) {
+	def, err := fsmexport.NewDefinition(testParameters, testStatuses, testEvents, []fsm.StateKey{statusNew})
+	require.NoError(t, err)
+
+	snapshot := fsmexport.NewSnapshot(def, []fsm.StateKey{uint64(1), uint64(1), uint64(2), uint64(9)})
+	require.Equal(t, map[string]int{"Ongoing": 2, "Completed": 1, "9": 1}, snapshot.Counts)
+
+	t.Run("dot", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, snapshot.WriteDOT(buf))
+		out := buf.String()
+		require.True(t, strings.HasPrefix(out, "digraph deals {"))
+		require.Contains(t, out, `"0" [label="New\n0"];`)
+		require.Contains(t, out, `"1" [label="Ongoing\n2", style="rounded,filled", fillcolor=lightblue];`)
+		require.Contains(t, out, `"2" [label="Completed\n1", style="rounded,filled", fillcolor=lightblue, peripheries=2];`)
+		require.Contains(t, out, `"1" -> "2" [label="EventCancel\nEventComplete"];`)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, snapshot.WriteJSON(buf))
+		require.Contains(t, buf.String(), `"Counts":{"9":1,"Completed":1,"Ongoing":2}`)
+	})
+}
@@ -0,0 +1,181 @@
+/*
+Package handlerpool limits how many deal state handlers a provider runs at once.
+
+Every deal's state machine runs the handler for the state the deal enters on its
+own goroutine. A provider with thousands of deals in flight can have thousands of
+handlers competing for the node, the disk and the network at once. A Pool wraps a
+state machine's handlers so that at most a set number of them run at the same time,
+and the rest wait their turn.
+
+Handlers are started in the order they arrive, so no deal is passed over for long.
+A deal runs one handler at a time, so one slow deal holds at most one place in the
+pool and cannot starve the others. A slow handler keeps its place until it returns,
+so the pool never runs more handlers than its limit. The number of handlers
+running, waiting, and running for longer than the pool's slow time is kept for
+monitoring.
+*/
+package handlerpool
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-statemachine/fsm"
+)
+
+// Stats describe how busy a pool is
+type Stats struct {
+	// Workers is the most handlers the pool runs at once, or zero if it is not limited
+	Workers int
+	// Running is the number of handlers holding a place in the pool
+	Running int
+	// Slow is the number of running handlers that have run for longer than the slow time
+	Slow int
+	// Queued is the number of handlers waiting for a place in the pool
+	Queued int
+	// PeakQueued is the most handlers that have waited at once
+	PeakQueued int
+	// Handled is the number of handlers that have finished
+	Handled uint64
+	// SlowHandled is the number of handlers that ran for longer than the slow time
+	SlowHandled uint64
+}
+
+// Pool runs state handlers with a limit on how many run at once
+type Pool struct {
+	lk          sync.Mutex
+	workers     int
+	slowAfter   time.Duration
+	running     int
+	slow        int
+	waiting     *list.List
+	peakQueued  int
+	handled     uint64
+	slowHandled uint64
+}
+
+// New returns a pool that runs at most workers handlers at once, or any number if
+// workers is zero. A handler that runs for longer than slowAfter is counted as slow,
+// unless slowAfter is zero
+func New(workers int, slowAfter time.Duration) *Pool {
+	return &Pool{
+		workers:   workers,
+		slowAfter: slowAfter,
+		waiting:   list.New(),
+	}
+}
+
+// Configure changes the number of workers and the slow time of a pool that is in
+// use. Waiting handlers are started straight away if the pool grew
+func (p *Pool) Configure(workers int, slowAfter time.Duration) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.workers = workers
+	p.slowAfter = slowAfter
+	p.startWaiting()
+}
+
+// Stats returns how many handlers are running and waiting in the pool
+func (p *Pool) Stats() Stats {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return Stats{
+		Workers:     p.workers,
+		Running:     p.running,
+		Slow:        p.slow,
+		Queued:      p.waiting.Len(),
+		PeakQueued:  p.peakQueued,
+		Handled:     p.handled,
+		SlowHandled: p.slowHandled,
+	}
+}
+
+// Wrap returns state entry funcs that run the given funcs in the pool. The wrapped
+// funcs have the same types as the originals, so they can be passed to a state
+// machine in their place
+func (p *Pool) Wrap(funcs fsm.StateEntryFuncs) fsm.StateEntryFuncs {
+	wrapped := make(fsm.StateEntryFuncs, len(funcs))
+	for state, f := range funcs {
+		fn := reflect.ValueOf(f)
+		if fn.Kind() != reflect.Func {
+			wrapped[state] = f
+			continue
+		}
+		wrapped[state] = reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+			return p.run(fn, args)
+		}).Interface()
+	}
+	return wrapped
+}
+
+func (p *Pool) run(fn reflect.Value, args []reflect.Value) []reflect.Value {
+	slowAfter := p.acquire()
+
+	// slow and finished are guarded by lk
+	var slow, finished bool
+	if slowAfter > 0 {
+		t := time.AfterFunc(slowAfter, func() {
+			p.lk.Lock()
+			defer p.lk.Unlock()
+			if !finished {
+				slow = true
+				p.slow++
+			}
+		})
+		defer t.Stop()
+	}
+
+	defer func() {
+		p.lk.Lock()
+		defer p.lk.Unlock()
+		finished = true
+		if slow {
+			p.slow--
+			p.slowHandled++
+		}
+		p.handled++
+		p.running--
+		p.startWaiting()
+	}()
+
+	return fn.Call(args)
+}
+
+// acquire waits for a place in the pool, and returns the slow time to run with
+func (p *Pool) acquire() time.Duration {
+	p.lk.Lock()
+	if p.waiting.Len() == 0 && p.hasRoom() {
+		p.running++
+		slowAfter := p.slowAfter
+		p.lk.Unlock()
+		return slowAfter
+	}
+	ready := make(chan struct{})
+	p.waiting.PushBack(ready)
+	if p.waiting.Len() > p.peakQueued {
+		p.peakQueued = p.waiting.Len()
+	}
+	p.lk.Unlock()
+
+	<-ready
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.slowAfter
+}
+
+func (p *Pool) hasRoom() bool {
+	return p.workers <= 0 || p.running < p.workers
+}
+
+// startWaiting gives places in the pool to waiting handlers, oldest first. It must
+// be called with lk held
+func (p *Pool) startWaiting() {
+	for p.waiting.Len() > 0 && p.hasRoom() {
+		ready := p.waiting.Remove(p.waiting.Front()).(chan struct{})
+		p.running++
+		close(ready)
+	}
+}
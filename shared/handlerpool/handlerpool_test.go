@@ -0,0 +1,130 @@
+package handlerpool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-statemachine/fsm"
+
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
+)
+
+type handler func(ctx fsm.Context, env struct{}, n int) error
+
+func runHandler(funcs fsm.StateEntryFuncs, n int) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- funcs["state"].(handler)(nil, struct{}{}, n)
+	}()
+	return done
+}
+
+func waitForStats(t *testing.T, pool *handlerpool.Pool, check func(handlerpool.Stats) bool) handlerpool.Stats {
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats := pool.Stats()
+		if check(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool stats never matched, last: %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Run("limits handlers running at once and starts waiting handlers in order", func(t *testing.T) {
+		pool := handlerpool.New(1, 0)
+		unblock := make(chan struct{})
+		started := make(chan int, 3)
+		funcs := pool.Wrap(fsm.StateEntryFuncs{
+			"state": handler(func(ctx fsm.Context, env struct{}, n int) error {
+				started <- n
+				<-unblock
+				return nil
+			}),
+		})
+
+		first := runHandler(funcs, 0)
+		require.Equal(t, 0, <-started)
+		second := runHandler(funcs, 1)
+		waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Queued == 1 })
+		third := runHandler(funcs, 2)
+		stats := waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Queued == 2 })
+		require.Equal(t, 1, stats.Running)
+		require.Equal(t, 2, stats.PeakQueued)
+
+		close(unblock)
+		require.NoError(t, <-first)
+		require.NoError(t, <-second)
+		require.NoError(t, <-third)
+		require.Equal(t, 1, <-started)
+		require.Equal(t, 2, <-started)
+
+		stats = pool.Stats()
+		require.Equal(t, 0, stats.Running)
+		require.Equal(t, 0, stats.Queued)
+		require.Equal(t, uint64(3), stats.Handled)
+	})
+
+	t.Run("slow handler keeps its place", func(t *testing.T) {
+		pool := handlerpool.New(1, 10*time.Millisecond)
+		unblock := make(chan struct{})
+		funcs := pool.Wrap(fsm.StateEntryFuncs{
+			"state": handler(func(ctx fsm.Context, env struct{}, n int) error {
+				if n == 0 {
+					<-unblock
+				}
+				return nil
+			}),
+		})
+
+		slow := runHandler(funcs, 0)
+		waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Slow == 1 })
+		waiting := runHandler(funcs, 1)
+		stats := waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Queued == 1 })
+		require.Equal(t, 1, stats.Running)
+		select {
+		case <-waiting:
+			t.Fatal("handler ran while a slow handler held the only place")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(unblock)
+		require.NoError(t, <-slow)
+		require.NoError(t, <-waiting)
+		stats = pool.Stats()
+		require.Equal(t, 0, stats.Running)
+		require.Equal(t, 0, stats.Slow)
+		require.Equal(t, uint64(1), stats.SlowHandled)
+		require.Equal(t, uint64(2), stats.Handled)
+	})
+
+	t.Run("configure starts waiting handlers", func(t *testing.T) {
+		pool := handlerpool.New(1, 0)
+		unblock := make(chan struct{})
+		funcs := pool.Wrap(fsm.StateEntryFuncs{
+			"state": handler(func(ctx fsm.Context, env struct{}, n int) error {
+				if n == 0 {
+					<-unblock
+				}
+				return nil
+			}),
+		})
+
+		blocked := runHandler(funcs, 0)
+		waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Running == 1 })
+		waiting := runHandler(funcs, 1)
+		waitForStats(t, pool, func(s handlerpool.Stats) bool { return s.Queued == 1 })
+
+		pool.Configure(0, 0)
+		require.NoError(t, <-waiting)
+		require.Equal(t, 0, pool.Stats().Workers)
+
+		close(unblock)
+		require.NoError(t, <-blocked)
+	})
+}
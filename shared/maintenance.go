@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// DefaultEpochCacheTTL is how long an EpochCache keeps the chain epoch before asking
+// the node for it again
+const DefaultEpochCacheTTL = 5 * time.Second
+
+// Maintenance is when a provider is in maintenance. While Active is set the provider
+// is in maintenance until the epoch Until, or until Active is unset if Until is zero
+// or less. The provider is also in maintenance during each of its Windows
+type Maintenance struct {
+	Active  bool
+	Until   abi.ChainEpoch
+	Windows []MaintenanceWindow
+}
+
+// Validate checks that every window ends after it starts
+func (m Maintenance) Validate() error {
+	for _, w := range m.Windows {
+		if w.End <= w.Start {
+			return xerrors.Errorf("maintenance window ending at %d must end after its start %d", w.End, w.Start)
+		}
+	}
+	return nil
+}
+
+// Scheduled returns true if the provider is or may later be in maintenance, so that
+// checking for maintenance needs the chain epoch
+func (m Maintenance) Scheduled() bool {
+	return m.Active || len(m.Windows) > 0
+}
+
+// At returns true if the provider is in maintenance at the epoch, along with the
+// epoch the maintenance ends at, or zero if it is not known
+func (m Maintenance) At(epoch abi.ChainEpoch) (bool, abi.ChainEpoch) {
+	if m.Active && (m.Until <= 0 || epoch < m.Until) {
+		return true, m.Until
+	}
+	return MaintenanceUntil(m.Windows, epoch)
+}
+
+// Exit returns the maintenance left once the provider leaves maintenance at the
+// epoch. Maintenance started straight away ends, and a window in progress ends early
+func (m Maintenance) Exit(epoch abi.ChainEpoch) Maintenance {
+	windows := make([]MaintenanceWindow, 0, len(m.Windows))
+	for _, w := range m.Windows {
+		if w.Contains(epoch) {
+			if w.Start == epoch {
+				continue
+			}
+			w.End = epoch
+		}
+		windows = append(windows, w)
+	}
+	return Maintenance{Windows: windows}
+}
+
+// MaintenanceWindow is a span of epochs during which a provider is in maintenance and
+// does not accept new deals. Deals already in progress carry on as normal. The window
+// starts at Start and ends before End
+type MaintenanceWindow struct {
+	Start abi.ChainEpoch
+	End   abi.ChainEpoch
+}
+
+// Contains returns true if the epoch is within the window
+func (w MaintenanceWindow) Contains(epoch abi.ChainEpoch) bool {
+	return epoch >= w.Start && epoch < w.End
+}
+
+// MaintenanceUntil returns true if the epoch is within one of the windows, along with
+// the epoch the maintenance ends at. Windows that overlap or follow on from one
+// another are treated as one
+func MaintenanceUntil(windows []MaintenanceWindow, epoch abi.ChainEpoch) (bool, abi.ChainEpoch) {
+	inMaintenance := false
+	until := epoch
+	for extended := true; extended; {
+		extended = false
+		for _, w := range windows {
+			if w.Contains(until) {
+				inMaintenance = true
+				until = w.End
+				extended = true
+			}
+		}
+	}
+	return inMaintenance, until
+}
+
+// MaintenanceError rejects a deal because the provider is in maintenance. Until is the
+// epoch the maintenance ends at, or zero if the provider has not said when it ends
+type MaintenanceError struct {
+	Until abi.ChainEpoch
+}
+
+func (e *MaintenanceError) Error() string {
+	if e.Until <= 0 {
+		return "provider is in maintenance"
+	}
+	return fmt.Sprintf("provider is in maintenance until epoch %d", e.Until)
+}
+
+// EpochCache keeps the chain epoch for a while, so that checks made for every
+// proposal do not each ask the node for the chain head
+type EpochCache struct {
+	chainEpoch func(ctx context.Context) (abi.ChainEpoch, error)
+	ttl        time.Duration
+
+	lk      sync.Mutex
+	epoch   abi.ChainEpoch
+	fetched time.Time
+}
+
+// NewEpochCache returns an EpochCache that gets the chain epoch with chainEpoch and
+// keeps it for ttl
+func NewEpochCache(chainEpoch func(ctx context.Context) (abi.ChainEpoch, error), ttl time.Duration) *EpochCache {
+	return &EpochCache{chainEpoch: chainEpoch, ttl: ttl}
+}
+
+// Epoch returns the chain epoch, getting it again if it is older than the TTL
+func (c *EpochCache) Epoch(ctx context.Context) (abi.ChainEpoch, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if !c.fetched.IsZero() && time.Since(c.fetched) < c.ttl {
+		return c.epoch, nil
+	}
+	epoch, err := c.chainEpoch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.epoch = epoch
+	c.fetched = time.Now()
+	return epoch, nil
+}
@@ -0,0 +1,204 @@
+/*
+Package migrationtools reduces the risk of upgrading a deal datastore to a new
+version.
+
+DryRun reports what each deal record in a datastore that has not yet been migrated
+would migrate to, and which records would fail, without writing anything. Snapshot
+copies a datastore before it is migrated, and Restore puts the copy back if the
+upgrade has to be rolled back.
+*/
+package migrationtools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+)
+
+// VersionKey is the key at which a versioned datastore records its current version
+var VersionKey = datastore.NewKey("/versions/current")
+
+// RecordReport is the outcome of migrating a single record
+type RecordReport struct {
+	Key string
+	// Migrated is the record as it would be after migration, if migration succeeds
+	Migrated interface{} `json:",omitempty"`
+	// Error is why migration would fail, if it fails
+	Error string `json:",omitempty"`
+}
+
+// Report is the outcome of a dry run of a migration
+type Report struct {
+	CurrentVersion versioning.VersionKey
+	TargetVersion  versioning.VersionKey
+	Records        []RecordReport
+	Failed         int
+}
+
+// Pending is true if the datastore has not been migrated to the target version
+func (r Report) Pending() bool {
+	return r.CurrentVersion != r.TargetVersion
+}
+
+// CurrentVersion returns the version a datastore is at. A datastore that has never
+// been migrated is at version ""
+func CurrentVersion(ds datastore.Datastore) (versioning.VersionKey, error) {
+	value, err := ds.Get(VersionKey)
+	if err == datastore.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", xerrors.Errorf("reading datastore version: %w", err)
+	}
+	return versioning.VersionKey(value), nil
+}
+
+// AtVersion returns the records of a datastore that has been migrated to version,
+// for reading them without running migrations. It fails if the datastore is at any
+// other version
+func AtVersion(ds datastore.Batching, version versioning.VersionKey) (datastore.Batching, error) {
+	current, err := CurrentVersion(ds)
+	if err != nil {
+		return nil, err
+	}
+	if current != version {
+		return nil, xerrors.Errorf("datastore is at version %q, not %q", current, version)
+	}
+	return namespace.Wrap(ds, datastore.NewKey(string(version))), nil
+}
+
+// DryRun reports what the records in an unversioned datastore would become if
+// migrated to target with the given migration. migrate must have the form
+// func(*Old) (*New, error), where Old can be decoded from CBOR. Keys in filterKeys
+// are not deal records and are skipped, as they are by the migration itself.
+// Nothing is written to the datastore
+func DryRun(ds datastore.Batching, migrate interface{}, target versioning.VersionKey, filterKeys []string) (Report, error) {
+	migrateFunc := reflect.ValueOf(migrate)
+	migrateType := migrateFunc.Type()
+	if migrateType.Kind() != reflect.Func || migrateType.NumIn() != 1 || migrateType.NumOut() != 2 ||
+		migrateType.In(0).Kind() != reflect.Ptr ||
+		!migrateType.Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return Report{}, xerrors.Errorf("migration must have the form func(*Old) (*New, error), got %s", migrateType)
+	}
+	oldType := migrateType.In(0).Elem()
+	if !reflect.PtrTo(oldType).Implements(reflect.TypeOf((*cbg.CBORUnmarshaler)(nil)).Elem()) {
+		return Report{}, xerrors.Errorf("%s cannot be decoded from CBOR", oldType)
+	}
+
+	current, err := CurrentVersion(ds)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{CurrentVersion: current, TargetVersion: target}
+	if current == target {
+		return report, nil
+	}
+	if current != "" {
+		return Report{}, xerrors.Errorf("cannot dry run a migration from version %s", current)
+	}
+
+	skip := make(map[string]struct{}, len(filterKeys))
+	for _, key := range filterKeys {
+		skip[datastore.NewKey(key).String()] = struct{}{}
+	}
+	targetPrefix := datastore.NewKey(string(target)).String() + "/"
+
+	results, err := ds.Query(query.Query{})
+	if err != nil {
+		return Report{}, xerrors.Errorf("listing records: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return Report{}, xerrors.Errorf("listing records: %w", err)
+	}
+	for _, entry := range entries {
+		key := datastore.NewKey(entry.Key).String()
+		if _, ok := skip[key]; ok || key == VersionKey.String() || strings.HasPrefix(key, targetPrefix) {
+			continue
+		}
+		record := RecordReport{Key: key}
+		if err := migrateRecord(migrateFunc, oldType, entry.Value, &record); err != nil {
+			record.Error = err.Error()
+			report.Failed++
+		}
+		report.Records = append(report.Records, record)
+	}
+	return report, nil
+}
+
+func migrateRecord(migrateFunc reflect.Value, oldType reflect.Type, value []byte, record *RecordReport) error {
+	old := reflect.New(oldType)
+	if err := old.Interface().(cbg.CBORUnmarshaler).UnmarshalCBOR(bytes.NewReader(value)); err != nil {
+		return xerrors.Errorf("decoding record: %w", err)
+	}
+	out := migrateFunc.Call([]reflect.Value{old})
+	if errOut := out[1]; !errOut.IsNil() {
+		return errOut.Interface().(error)
+	}
+	record.Migrated = out[0].Interface()
+	return nil
+}
+
+// Snapshot copies every entry in ds to backup, so that the datastore can be rolled
+// back with Restore if a migration goes wrong
+func Snapshot(ds datastore.Datastore, backup datastore.Batching) error {
+	return copyAll(ds, backup)
+}
+
+// Restore rolls ds back to a snapshot taken with Snapshot, removing any entries
+// written since
+func Restore(ds datastore.Batching, backup datastore.Datastore) error {
+	results, err := ds.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return xerrors.Errorf("listing records: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return xerrors.Errorf("listing records: %w", err)
+	}
+	batch, err := ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := batch.Delete(datastore.NewKey(entry.Key)); err != nil {
+			return xerrors.Errorf("removing %s: %w", entry.Key, err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return xerrors.Errorf("removing records: %w", err)
+	}
+	return copyAll(backup, ds)
+}
+
+func copyAll(from datastore.Datastore, to datastore.Batching) error {
+	results, err := from.Query(query.Query{})
+	if err != nil {
+		return xerrors.Errorf("listing records: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return xerrors.Errorf("listing records: %w", err)
+	}
+	batch, err := to.Batch()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := batch.Put(datastore.NewKey(entry.Key), entry.Value); err != nil {
+			return xerrors.Errorf("copying %s: %w", entry.Key, err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return xerrors.Errorf("copying records: %w", err)
+	}
+	return nil
+}
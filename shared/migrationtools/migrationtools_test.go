@@ -0,0 +1,123 @@
+package migrationtools_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+func migrateAsk(old *migrations.StorageAsk0) (*storagemarket.StorageAsk, error) {
+	if old.SeqNo == 0 {
+		return nil, errors.New("no sequence number")
+	}
+	return migrations.MigrateStorageAsk1To2(migrations.MigrateStorageAsk0To1(old)), nil
+}
+
+func putAsk(t *testing.T, ds datastore.Datastore, key string, seqNo uint64) {
+	ask := &migrations.StorageAsk0{
+		Price:         abi.NewTokenAmount(10),
+		VerifiedPrice: abi.NewTokenAmount(5),
+		Miner:         address.TestAddress,
+		SeqNo:         seqNo,
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, ask.MarshalCBOR(buf))
+	require.NoError(t, ds.Put(datastore.NewKey(key), buf.Bytes()))
+}
+
+func keys(t *testing.T, ds datastore.Datastore) []string {
+	results, err := ds.Query(query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+	return keys
+}
+
+func TestDryRun(t *testing.T) {
+	target := versioning.VersionKey("1")
+
+	t.Run("reports migrated and failed records", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		putAsk(t, ds, "/good", 1)
+		putAsk(t, ds, "/bad", 0)
+		putAsk(t, ds, "/filtered", 0)
+		require.NoError(t, ds.Put(datastore.NewKey("/undecodable"), []byte("not cbor")))
+		before := keys(t, ds)
+
+		report, err := migrationtools.DryRun(ds, migrateAsk, target, []string{"/filtered"})
+		require.NoError(t, err)
+		require.True(t, report.Pending())
+		require.Equal(t, versioning.VersionKey(""), report.CurrentVersion)
+		require.Len(t, report.Records, 3)
+		require.Equal(t, 2, report.Failed)
+
+		records := make(map[string]migrationtools.RecordReport)
+		for _, record := range report.Records {
+			records[record.Key] = record
+		}
+		require.Empty(t, records["/good"].Error)
+		require.Equal(t, uint64(1), records["/good"].Migrated.(*storagemarket.StorageAsk).SeqNo)
+		require.Equal(t, "no sequence number", records["/bad"].Error)
+		require.Nil(t, records["/bad"].Migrated)
+		require.NotEmpty(t, records["/undecodable"].Error)
+
+		// nothing is written
+		require.ElementsMatch(t, before, keys(t, ds))
+	})
+
+	t.Run("nothing pending at the target version", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		require.NoError(t, ds.Put(migrationtools.VersionKey, []byte(target)))
+		putAsk(t, ds, "/1/good", 1)
+
+		report, err := migrationtools.DryRun(ds, migrateAsk, target, nil)
+		require.NoError(t, err)
+		require.False(t, report.Pending())
+		require.Empty(t, report.Records)
+	})
+
+	t.Run("rejects migrations of the wrong form", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		_, err := migrationtools.DryRun(ds, migrations.MigrateStorageAsk0To1, target, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	putAsk(t, ds, "/a", 1)
+	putAsk(t, ds, "/b", 2)
+	before := keys(t, ds)
+
+	backup := dss.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, migrationtools.Snapshot(ds, backup))
+	require.ElementsMatch(t, before, keys(t, backup))
+
+	// simulate a migration
+	require.NoError(t, ds.Delete(datastore.NewKey("/a")))
+	putAsk(t, ds, "/1/a", 1)
+	require.NoError(t, ds.Put(migrationtools.VersionKey, []byte("1")))
+
+	require.NoError(t, migrationtools.Restore(ds, backup))
+	require.ElementsMatch(t, before, keys(t, ds))
+	version, err := migrationtools.CurrentVersion(ds)
+	require.NoError(t, err)
+	require.Equal(t, versioning.VersionKey(""), version)
+}
@@ -0,0 +1,176 @@
+/*
+Package protoregistry keeps the versions of the libp2p protocols a market network
+speaks, and the codec that speaks each one.
+
+A protocol's versions share an ID but for the version in its last path segment, such
+as /fil/storage/ask/1.1.0 and /fil/storage/ask/1.2.0. A Registry orders the versions
+of each protocol newest first, which is the order a network offers them in when it
+opens a stream, so that libp2p negotiates the newest version both peers speak. When a
+stream is opened or accepted, the Registry wraps it in the codec for the version that
+was negotiated.
+
+Adding a version of a protocol is then one call to Register. The stream constructors
+and handlers of the network pick it up, without a switch on protocol IDs in each.
+*/
+package protoregistry
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"golang.org/x/xerrors"
+)
+
+// Codec wraps a stream opened or accepted on one version of a protocol, with the
+// remote peer and a buffered reader on the stream, in the type that reads and writes
+// that version's messages
+type Codec func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{}
+
+// Version is one version of a protocol
+type Version struct {
+	ID protocol.ID
+	// Codec wraps streams of this version. It is nil for versions the network
+	// handles itself, such as those that carry several streams on one
+	Codec Codec
+	// Legacy versions are only spoken to peers that have not upgraded, and can be
+	// turned off together
+	Legacy bool
+}
+
+// Registry keeps the versions of a network's protocols
+type Registry struct {
+	lk        sync.RWMutex
+	protocols map[string][]Version
+	versions  map[protocol.ID]Version
+}
+
+// New returns an empty Registry
+func New() *Registry {
+	return &Registry{
+		protocols: make(map[string][]Version),
+		versions:  make(map[protocol.ID]Version),
+	}
+}
+
+// Register adds a version of a protocol, replacing the version with the same ID if
+// there is one. The ID must end in a version number of dot separated integers
+func (r *Registry) Register(v Version) error {
+	name, _, err := parseID(v.ID)
+	if err != nil {
+		return err
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	versions := r.protocols[name][:0:0]
+	for _, existing := range r.protocols[name] {
+		if existing.ID != v.ID {
+			versions = append(versions, existing)
+		}
+	}
+	versions = append(versions, v)
+	sort.SliceStable(versions, func(i, j int) bool {
+		_, a, _ := parseID(versions[i].ID)
+		_, b, _ := parseID(versions[j].ID)
+		return compareVersions(a, b) > 0
+	})
+	r.protocols[name] = versions
+	r.versions[v.ID] = v
+	return nil
+}
+
+// MustRegister is like Register, but panics if the ID has no version number. It is
+// for registering the versions a network is built with
+func (r *Registry) MustRegister(v Version) {
+	if err := r.Register(v); err != nil {
+		panic(err)
+	}
+}
+
+// IDs returns the IDs of the versions of the protocol with the given name, which is
+// its ID without the version, newest first. Legacy versions are left out unless
+// legacy is true
+func (r *Registry) IDs(name string, legacy bool) []protocol.ID {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	var ids []protocol.ID
+	for _, v := range r.protocols[strings.TrimSuffix(name, "/")] {
+		if v.Legacy && !legacy {
+			continue
+		}
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+// Version returns the registered version with the given ID
+func (r *Registry) Version(id protocol.ID) (Version, bool) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	v, ok := r.versions[id]
+	return v, ok
+}
+
+// WithoutLegacy returns the given IDs that are not of legacy versions
+func (r *Registry) WithoutLegacy(ids []protocol.ID) []protocol.ID {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	var current []protocol.ID
+	for _, id := range ids {
+		if !r.versions[id].Legacy {
+			current = append(current, id)
+		}
+	}
+	return current
+}
+
+// Wrap wraps a stream in the codec for the version of the protocol negotiated on it
+func (r *Registry) Wrap(p peer.ID, s network.Stream) (interface{}, error) {
+	v, ok := r.Version(s.Protocol())
+	if !ok || v.Codec == nil {
+		return nil, xerrors.Errorf("no codec for protocol %s", s.Protocol())
+	}
+	return v.Codec(p, s, bufio.NewReaderSize(s, 16)), nil
+}
+
+// parseID splits a protocol ID into its name and version number
+func parseID(id protocol.ID) (string, []int, error) {
+	i := strings.LastIndex(string(id), "/")
+	if i <= 0 {
+		return "", nil, xerrors.Errorf("protocol %s has no version", id)
+	}
+	parts := strings.Split(string(id)[i+1:], ".")
+	number := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return "", nil, xerrors.Errorf("protocol %s has an invalid version", id)
+		}
+		number = append(number, n)
+	}
+	return string(id)[:i], number, nil
+}
+
+// compareVersions returns a positive number if a is newer than b, a negative number
+// if it is older, and zero if they are the same. Missing trailing parts count as zero
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package protoregistry_test
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared/protoregistry"
+)
+
+func TestRegistry(t *testing.T) {
+	newRegistry := func(t *testing.T, ids ...protocol.ID) *protoregistry.Registry {
+		r := protoregistry.New()
+		for _, id := range ids {
+			require.NoError(t, r.Register(protoregistry.Version{ID: id, Legacy: id == "/fil/test/1.0.1"}))
+		}
+		return r
+	}
+
+	t.Run("orders versions newest first", func(t *testing.T) {
+		r := newRegistry(t, "/fil/test/1.0.1", "/fil/test/1.10.0", "/fil/test/1.2.0", "/fil/other/1.0.0", "/fil/test/2.0")
+		require.Equal(t, []protocol.ID{"/fil/test/2.0", "/fil/test/1.10.0", "/fil/test/1.2.0", "/fil/test/1.0.1"}, r.IDs("/fil/test", true))
+		require.Equal(t, []protocol.ID{"/fil/other/1.0.0"}, r.IDs("/fil/other/", true))
+		require.Empty(t, r.IDs("/fil/missing", true))
+	})
+
+	t.Run("leaves out legacy versions", func(t *testing.T) {
+		r := newRegistry(t, "/fil/test/1.0.1", "/fil/test/1.1.0")
+		require.Equal(t, []protocol.ID{"/fil/test/1.1.0"}, r.IDs("/fil/test", false))
+		require.Equal(t, []protocol.ID{"/fil/other/1.0.0", "/fil/test/1.1.0"}, r.WithoutLegacy([]protocol.ID{"/fil/test/1.0.1", "/fil/other/1.0.0", "/fil/test/1.1.0"}))
+	})
+
+	t.Run("replaces a version registered again", func(t *testing.T) {
+		r := newRegistry(t, "/fil/test/1.0.1", "/fil/test/1.1.0")
+		require.NoError(t, r.Register(protoregistry.Version{ID: "/fil/test/1.0.1"}))
+		require.Equal(t, []protocol.ID{"/fil/test/1.1.0", "/fil/test/1.0.1"}, r.IDs("/fil/test", false))
+		v, ok := r.Version("/fil/test/1.0.1")
+		require.True(t, ok)
+		require.False(t, v.Legacy)
+		_, ok = r.Version("/fil/test/1.2.0")
+		require.False(t, ok)
+	})
+
+	t.Run("rejects IDs without a version", func(t *testing.T) {
+		r := protoregistry.New()
+		require.Error(t, r.Register(protoregistry.Version{ID: "test"}))
+		require.Error(t, r.Register(protoregistry.Version{ID: "/fil/test/latest"}))
+		require.Panics(t, func() {
+			r.MustRegister(protoregistry.Version{ID: "/fil/test/1.x"})
+		})
+	})
+}
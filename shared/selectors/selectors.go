@@ -0,0 +1,353 @@
+/*
+Package selectors builds the IPLD selectors the markets use to pick out the part of a
+DAG a retrieval deal transfers, or a storage deal commits to.
+
+Entire and Depth work on any DAG. UnixFSPath selects the DAG under a path in a UnixFS
+directory tree, and FileRange the blocks of a UnixFS file holding a range of its
+bytes. A selector cannot match directory entries by name, or file blocks by offset,
+so both select by the index of links in dag-pb nodes. The indexes are found from the
+DAG with ResolveUnixFSPath and FileRange, by whoever has its blocks at hand, such as a
+client that retrieved the directories first.
+
+Validate checks a selector can be parsed before it is sent to a peer, and Walk
+traverses a selector over a DAG in a local store to list the blocks it selects and
+estimate the cost of transferring them.
+
+Subtract takes the blocks already in a local store away from the entire DAG under a
+root, leaving a selector for the blocks still missing, so that a retrieval only
+transfers those. Like UnixFSPath, it follows the links of dag-pb nodes by index.
+*/
+package selectors
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	unixfspb "github.com/ipfs/go-unixfs/pb"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	"golang.org/x/xerrors"
+)
+
+func newBuilder() builder.SelectorSpecBuilder {
+	return builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+}
+
+func entire(ssb builder.SelectorSpecBuilder) builder.SelectorSpec {
+	return ssb.ExploreRecursive(selector.RecursionLimitNone(),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+}
+
+// Entire selects every block of a DAG
+func Entire() ipld.Node {
+	return entire(newBuilder()).Node()
+}
+
+// Depth selects the nodes of a DAG fewer than depth steps from the root, each step
+// being into a map field, a list element or the target of a link. Following a link
+// out of a dag-pb node takes three steps, through Links, the link's index and Hash,
+// so Depth(3*n+1) selects the blocks at most n links below the root
+func Depth(depth int) ipld.Node {
+	ssb := newBuilder()
+	return ssb.ExploreRecursive(selector.RecursionLimitDepth(depth),
+		ssb.ExploreAll(ssb.ExploreRecursiveEdge())).
+		Node()
+}
+
+// followLink selects the target of the link at index in a dag-pb node with next
+func followLink(ssb builder.SelectorSpecBuilder, index int, next builder.SelectorSpec) builder.SelectorSpec {
+	return ssb.ExploreIndex(index, ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Hash", next)
+	}))
+}
+
+func exploreLinks(ssb builder.SelectorSpecBuilder, next builder.SelectorSpec) builder.SelectorSpec {
+	return ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Links", next)
+	})
+}
+
+// UnixFSPath selects the directories on a path in a UnixFS DAG, and the entire DAG
+// under the end of the path. The path is given as the index of the link to follow in
+// each directory, as returned by ResolveUnixFSPath
+func UnixFSPath(linkIndexes ...int) ipld.Node {
+	ssb := newBuilder()
+	spec := entire(ssb)
+	for i := len(linkIndexes) - 1; i >= 0; i-- {
+		spec = exploreLinks(ssb, followLink(ssb, linkIndexes[i], spec))
+	}
+	return spec.Node()
+}
+
+func loadProtoNode(store car.ReadStore, c cid.Cid) (*merkledag.ProtoNode, *unixfs.FSNode, error) {
+	blk, err := store.Get(c)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading %s: %w", c, err)
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, nil, nil
+	}
+	pn, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding %s: %w", c, err)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding UnixFS data of %s: %w", c, err)
+	}
+	return pn, fsn, nil
+}
+
+// ResolveUnixFSPath finds the index of the link to follow in each directory on a
+// slash separated path under root, for UnixFSPath. Sharded directories are not
+// supported
+func ResolveUnixFSPath(store car.ReadStore, root cid.Cid, path string) ([]int, error) {
+	var indexes []int
+	c := root
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		pn, fsn, err := loadProtoNode(store, c)
+		if err != nil {
+			return nil, err
+		}
+		if pn == nil || fsn.Type() != unixfspb.Data_Directory {
+			if fsn != nil && fsn.Type() == unixfspb.Data_HAMTShard {
+				return nil, xerrors.Errorf("resolving %q: sharded directories are not supported", name)
+			}
+			return nil, xerrors.Errorf("resolving %q: %s is not a directory", name, c)
+		}
+		index := -1
+		for i, l := range pn.Links() {
+			if l.Name == name {
+				index = i
+				c = l.Cid
+				break
+			}
+		}
+		if index < 0 {
+			return nil, xerrors.Errorf("resolving %q: no such entry", name)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// FileRange selects the blocks of the UnixFS file under root that hold the length
+// bytes starting at offset, along with the blocks linking to them from the root
+func FileRange(store car.ReadStore, root cid.Cid, offset uint64, length uint64) (ipld.Node, error) {
+	if length == 0 {
+		return nil, xerrors.New("range is empty")
+	}
+	ssb := newBuilder()
+	spec, err := fileRange(ssb, store, root, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return nil, xerrors.Errorf("range at offset %d is past the end of the file", offset)
+	}
+	return spec.Node(), nil
+}
+
+// fileRange returns the selector for a range of the file under c, relative to the
+// start of c's data, or nil if no data under c is in the range
+func fileRange(ssb builder.SelectorSpecBuilder, store car.ReadStore, c cid.Cid, offset uint64, length uint64) (builder.SelectorSpec, error) {
+	pn, fsn, err := loadProtoNode(store, c)
+	if err != nil {
+		return nil, err
+	}
+	if pn == nil || len(pn.Links()) == 0 {
+		// a leaf holds all its data itself
+		return ssb.Matcher(), nil
+	}
+	if fsn.Type() != unixfspb.Data_File && fsn.Type() != unixfspb.Data_Raw {
+		return nil, xerrors.Errorf("%s is not a file", c)
+	}
+	if fsn.NumChildren() != len(pn.Links()) {
+		return nil, xerrors.Errorf("%s has %d links but sizes for %d", c, len(pn.Links()), fsn.NumChildren())
+	}
+
+	var members []builder.SelectorSpec
+	start := uint64(len(fsn.Data()))
+	for i := range pn.Links() {
+		end := start + fsn.BlockSize(i)
+		if end > offset && start < offset+length {
+			childOffset := uint64(0)
+			if offset > start {
+				childOffset = offset - start
+			}
+			child, err := fileRange(ssb, store, pn.Links()[i].Cid, childOffset, offset+length-start-childOffset)
+			if err != nil {
+				return nil, err
+			}
+			if child != nil {
+				members = append(members, followLink(ssb, i, child))
+			}
+		}
+		start = end
+	}
+	switch len(members) {
+	case 0:
+		return nil, nil
+	case 1:
+		return exploreLinks(ssb, members[0]), nil
+	default:
+		return exploreLinks(ssb, ssb.ExploreUnion(members...)), nil
+	}
+}
+
+// Validate checks that a selector can be parsed
+func Validate(sel ipld.Node) error {
+	if sel == nil {
+		return xerrors.New("no selector")
+	}
+	if _, err := selector.ParseSelector(sel); err != nil {
+		return xerrors.Errorf("invalid selector: %w", err)
+	}
+	return nil
+}
+
+// Cost is what transferring the blocks a selector selects from a DAG takes
+type Cost struct {
+	// CIDs are the blocks visited, in traversal order, each listed once
+	CIDs []cid.Cid
+	// Bytes is the total size of the blocks' data
+	Bytes uint64
+}
+
+// Walk traverses a selector over the DAG under root in store, the same way a
+// transfer or CAR file does, and returns the blocks it visits without copying their data
+func Walk(ctx context.Context, store car.ReadStore, root cid.Cid, sel ipld.Node) (Cost, error) {
+	if err := Validate(sel); err != nil {
+		return Cost{}, err
+	}
+	var cost Cost
+	sc := car.NewSelectiveCar(ctx, store, []car.Dag{{Root: root, Selector: sel}})
+	err := sc.Write(ioutil.Discard, func(block car.Block) error {
+		cost.CIDs = append(cost.CIDs, block.BlockCID)
+		cost.Bytes += uint64(len(block.Data))
+		return nil
+	})
+	if err != nil {
+		return Cost{}, xerrors.Errorf("walking DAG: %w", err)
+	}
+	return cost, nil
+}
+
+// Remainder is what is left of the entire DAG under a root once the blocks already in
+// a store are taken away
+type Remainder struct {
+	// Selector selects the blocks missing from the store, along with the blocks on
+	// the way to them from the root. It is nil if no blocks are missing
+	Selector ipld.Node
+	// Present are the blocks of the DAG found in the store, each listed once
+	Present []cid.Cid
+}
+
+// Subtract finds the blocks of the entire DAG under root that are missing from store.
+// The links of dag-pb nodes are followed one by one, so that only the subtrees with
+// missing blocks are selected. The DAG under a block of any other codec is selected
+// whole if any block in it is missing, or cannot be read
+func Subtract(ctx context.Context, store car.ReadStore, root cid.Cid) (Remainder, error) {
+	s := &subtraction{
+		ctx:     ctx,
+		ssb:     newBuilder(),
+		store:   store,
+		visited: make(map[cid.Cid]builder.SelectorSpec),
+	}
+	spec, err := s.subtract(root)
+	if err != nil {
+		return Remainder{}, err
+	}
+	remainder := Remainder{Present: s.present}
+	if spec != nil {
+		remainder.Selector = spec.Node()
+	}
+	return remainder, nil
+}
+
+type subtraction struct {
+	ctx     context.Context
+	ssb     builder.SelectorSpecBuilder
+	store   car.ReadStore
+	visited map[cid.Cid]builder.SelectorSpec
+	present []cid.Cid
+}
+
+// subtract returns the selector for the missing blocks under c, or nil if none are
+// missing
+func (s *subtraction) subtract(c cid.Cid) (builder.SelectorSpec, error) {
+	if spec, ok := s.visited[c]; ok {
+		return spec, nil
+	}
+	spec, err := s.subtractBlock(c)
+	if err != nil {
+		return nil, err
+	}
+	s.visited[c] = spec
+	return spec, nil
+}
+
+func (s *subtraction) subtractBlock(c cid.Cid) (builder.SelectorSpec, error) {
+	blk, err := s.store.Get(c)
+	if err != nil {
+		if errors.Is(err, blockstore.ErrNotFound) {
+			return entire(s.ssb), nil
+		}
+		return nil, xerrors.Errorf("loading %s: %w", c, err)
+	}
+
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		s.present = append(s.present, c)
+		return nil, nil
+	case cid.DagProtobuf:
+	default:
+		cost, err := Walk(s.ctx, s.store, c, Entire())
+		if err != nil {
+			return entire(s.ssb), nil
+		}
+		for _, present := range cost.CIDs {
+			if _, ok := s.visited[present]; !ok {
+				s.visited[present] = nil
+				s.present = append(s.present, present)
+			}
+		}
+		return nil, nil
+	}
+
+	s.present = append(s.present, c)
+	pn, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s: %w", c, err)
+	}
+	var members []builder.SelectorSpec
+	for i, l := range pn.Links() {
+		child, err := s.subtract(l.Cid)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			members = append(members, followLink(s.ssb, i, child))
+		}
+	}
+	switch len(members) {
+	case 0:
+		return nil, nil
+	case 1:
+		return exploreLinks(s.ssb, members[0]), nil
+	default:
+		return exploreLinks(s.ssb, s.ssb.ExploreUnion(members...)), nil
+	}
+}
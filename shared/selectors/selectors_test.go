@@ -0,0 +1,190 @@
+package selectors_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
+	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func importFile(t *testing.T, dag ipldformat.DAGService, data []byte) ipldformat.Node {
+	params := helpers.DagBuilderParams{
+		Maxlinks:  2,
+		RawLeaves: true,
+		Dagserv:   dag,
+	}
+	db, err := params.New(chunk.NewSizeSplitter(bytes.NewReader(data), 256))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+	return nd
+}
+
+func importDir(t *testing.T, dag ipldformat.DAGService, entries map[string]ipldformat.Node) ipldformat.Node {
+	ctx := context.Background()
+	dir := uio.NewDirectory(dag)
+	for name, nd := range entries {
+		require.NoError(t, dir.AddChild(ctx, name, nd))
+	}
+	nd, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dag.Add(ctx, nd))
+	return nd
+}
+
+func TestSelectors(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	// 8 leaves of 256 bytes, under a balanced tree three links deep
+	data := tut.RandomBytes(2048)
+	file := importFile(t, dag, data)
+	other := importFile(t, dag, tut.RandomBytes(100))
+	sub := importDir(t, dag, map[string]ipldformat.Node{"file": file})
+	root := importDir(t, dag, map[string]ipldformat.Node{"a": other, "sub": sub})
+
+	t.Run("entire DAG", func(t *testing.T) {
+		cost, err := selectors.Walk(ctx, bs, file.Cid(), selectors.Entire())
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 15)
+		require.Equal(t, file.Cid(), cost.CIDs[0])
+
+		cost, err = selectors.Walk(ctx, bs, root.Cid(), selectors.Entire())
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 18)
+	})
+
+	t.Run("depth limited", func(t *testing.T) {
+		cost, err := selectors.Walk(ctx, bs, file.Cid(), selectors.Depth(1))
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{file.Cid()}, cost.CIDs)
+
+		cost, err = selectors.Walk(ctx, bs, file.Cid(), selectors.Depth(3*2+1))
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 7)
+	})
+
+	t.Run("UnixFS path", func(t *testing.T) {
+		path, err := selectors.ResolveUnixFSPath(bs, root.Cid(), "/sub/file")
+		require.NoError(t, err)
+		require.Len(t, path, 2)
+
+		cost, err := selectors.Walk(ctx, bs, root.Cid(), selectors.UnixFSPath(path...))
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 17)
+		require.Equal(t, []cid.Cid{root.Cid(), sub.Cid(), file.Cid()}, cost.CIDs[:3])
+		require.NotContains(t, cost.CIDs, other.Cid())
+
+		_, err = selectors.ResolveUnixFSPath(bs, root.Cid(), "sub/missing")
+		require.EqualError(t, err, `resolving "missing": no such entry`)
+		_, err = selectors.ResolveUnixFSPath(bs, root.Cid(), "sub/file/more")
+		require.Error(t, err)
+	})
+
+	t.Run("byte range of a file", func(t *testing.T) {
+		// the second leaf only
+		sel, err := selectors.FileRange(bs, file.Cid(), 300, 100)
+		require.NoError(t, err)
+		cost, err := selectors.Walk(ctx, bs, file.Cid(), sel)
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 4)
+		leaf, err := bs.Get(cost.CIDs[3])
+		require.NoError(t, err)
+		require.Equal(t, data[256:512], leaf.RawData())
+
+		// the fourth and fifth leaves, on either side of the root
+		sel, err = selectors.FileRange(bs, file.Cid(), 1000, 100)
+		require.NoError(t, err)
+		cost, err = selectors.Walk(ctx, bs, file.Cid(), sel)
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 7)
+
+		_, err = selectors.FileRange(bs, file.Cid(), 2048, 1)
+		require.Error(t, err)
+		_, err = selectors.FileRange(bs, file.Cid(), 0, 0)
+		require.Error(t, err)
+	})
+
+	t.Run("estimates cost", func(t *testing.T) {
+		cost, err := selectors.Walk(ctx, bs, file.Cid(), selectors.Entire())
+		require.NoError(t, err)
+		var size uint64
+		for _, c := range cost.CIDs {
+			blk, err := bs.Get(c)
+			require.NoError(t, err)
+			size += uint64(len(blk.RawData()))
+		}
+		require.Equal(t, size, cost.Bytes)
+		require.Greater(t, cost.Bytes, uint64(len(data)))
+	})
+
+	t.Run("validates selectors", func(t *testing.T) {
+		require.NoError(t, selectors.Validate(selectors.Entire()))
+		require.NoError(t, selectors.Validate(selectors.UnixFSPath(1, 0)))
+		require.Error(t, selectors.Validate(nil))
+		require.Error(t, selectors.Validate(basicnode.NewString("not a selector")))
+
+		_, err := selectors.Walk(ctx, bs, file.Cid(), basicnode.NewString("not a selector"))
+		require.Error(t, err)
+	})
+
+	t.Run("subtracts blocks already in a store", func(t *testing.T) {
+		all, err := selectors.Walk(ctx, bs, root.Cid(), selectors.Entire())
+		require.NoError(t, err)
+		fileCost, err := selectors.Walk(ctx, bs, file.Cid(), selectors.Entire())
+		require.NoError(t, err)
+		missingLeaf := fileCost.CIDs[len(fileCost.CIDs)-1]
+
+		partial := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		for _, c := range all.CIDs {
+			if c.Equals(missingLeaf) {
+				continue
+			}
+			blk, err := bs.Get(c)
+			require.NoError(t, err)
+			require.NoError(t, partial.Put(blk))
+		}
+
+		remainder, err := selectors.Subtract(ctx, partial, root.Cid())
+		require.NoError(t, err)
+		require.Len(t, remainder.Present, len(all.CIDs)-1)
+		require.NotNil(t, remainder.Selector)
+		// only the path to the missing leaf is transferred along with it
+		cost, err := selectors.Walk(ctx, bs, root.Cid(), remainder.Selector)
+		require.NoError(t, err)
+		require.Len(t, cost.CIDs, 6)
+		require.Equal(t, root.Cid(), cost.CIDs[0])
+		require.Equal(t, missingLeaf, cost.CIDs[5])
+
+		remainder, err = selectors.Subtract(ctx, bs, root.Cid())
+		require.NoError(t, err)
+		require.Nil(t, remainder.Selector)
+		require.Len(t, remainder.Present, len(all.CIDs))
+
+		empty := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		remainder, err = selectors.Subtract(ctx, empty, root.Cid())
+		require.NoError(t, err)
+		require.Empty(t, remainder.Present)
+		cost, err = selectors.Walk(ctx, bs, root.Cid(), remainder.Selector)
+		require.NoError(t, err)
+		require.Equal(t, all.CIDs, cost.CIDs)
+	})
+}
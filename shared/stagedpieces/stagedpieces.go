@@ -0,0 +1,175 @@
+/*
+Package stagedpieces lets a retrieval provider serve a piece from the CAR file a
+storage provider staged for it, instead of unsealing it.
+
+A storage provider keeps the CAR file it received for a deal in its filestore until
+the deal's sector is sealed. For that time, retrievals of the piece can read the CAR
+straight from the filestore, which is far cheaper than unsealing, and is the only
+copy of the data that can be read at all until the sector is sealed.
+
+A Registry maps piece CIDs to the staged CAR files that hold them. The storage
+provider adds a piece's file once the deal has been handed off, and deletes it
+through the registry when the deal is cleaned up. The retrieval provider opens the
+file through the registry, and holds a reference to it until it has finished
+reading. A file that is deleted while retrievals are reading it is only removed
+from the filestore once the last of them is done, and no new retrievals are served
+from it in the meantime.
+*/
+package stagedpieces
+
+import (
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/filestore"
+)
+
+var log = logging.Logger("stagedpieces")
+
+// Registry tracks the staged CAR files of pieces and the retrievals reading them
+type Registry struct {
+	fs filestore.FileStore
+
+	lk      sync.Mutex
+	byPiece map[cid.Cid][]filestore.Path
+	files   map[filestore.Path]*stagedFile
+}
+
+type stagedFile struct {
+	pieceCID cid.Cid
+	readers  int
+	// deleted is set when the file is deleted while it is being read
+	deleted bool
+}
+
+// New returns a Registry of files in the given filestore
+func New(fs filestore.FileStore) *Registry {
+	return &Registry{
+		fs:      fs,
+		byPiece: make(map[cid.Cid][]filestore.Path),
+		files:   make(map[filestore.Path]*stagedFile),
+	}
+}
+
+// Add records that the file at path is a staged CAR holding the given piece
+func (r *Registry) Add(pieceCID cid.Cid, path filestore.Path) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if _, ok := r.files[path]; ok {
+		return
+	}
+	r.files[path] = &stagedFile{pieceCID: pieceCID}
+	r.byPiece[pieceCID] = append(r.byPiece[pieceCID], path)
+}
+
+// Open returns a reader for a staged CAR holding the piece, or false if there is
+// none. The file is not removed from the filestore until the reader is closed
+func (r *Registry) Open(pieceCID cid.Cid) (io.ReadCloser, bool) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for {
+		paths := r.byPiece[pieceCID]
+		if len(paths) == 0 {
+			return nil, false
+		}
+		// the most recently staged file is the least likely to be cleaned up soon
+		path := paths[len(paths)-1]
+		file, err := r.fs.Open(path)
+		if err != nil {
+			// the file was moved or removed outside of the registry
+			log.Warnf("opening staged piece %s at path %s: %s", pieceCID, path, err)
+			r.forget(path)
+			continue
+		}
+		r.files[path].readers++
+		return &stagedReader{File: file, registry: r, path: path}, true
+	}
+}
+
+// Delete removes the file at path from the filestore, or, if retrievals are
+// reading it, once they have finished. Files that were never added are removed
+// straight away
+func (r *Registry) Delete(path filestore.Path) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if sf, ok := r.files[path]; ok && sf.readers > 0 {
+		sf.deleted = true
+		r.unlist(path, sf.pieceCID)
+		return nil
+	}
+	r.forget(path)
+	return r.fs.Delete(path)
+}
+
+// release drops a reader's reference to the file at path, deleting the file if it
+// was deleted while it was being read
+func (r *Registry) release(path filestore.Path) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	sf, ok := r.files[path]
+	if !ok {
+		return nil
+	}
+	sf.readers--
+	if sf.readers > 0 || !sf.deleted {
+		return nil
+	}
+	delete(r.files, path)
+	if err := r.fs.Delete(path); err != nil {
+		log.Warnf("deleting staged piece at path %s: %s", path, err)
+		return xerrors.Errorf("deleting staged piece at path %s: %w", path, err)
+	}
+	return nil
+}
+
+// forget stops tracking the file at path
+func (r *Registry) forget(path filestore.Path) {
+	sf, ok := r.files[path]
+	if !ok {
+		return
+	}
+	delete(r.files, path)
+	r.unlist(path, sf.pieceCID)
+}
+
+// unlist stops new retrievals of the piece from being served from the file at path
+func (r *Registry) unlist(path filestore.Path, pieceCID cid.Cid) {
+	paths := r.byPiece[pieceCID]
+	for i, p := range paths {
+		if p == path {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+	if len(paths) == 0 {
+		delete(r.byPiece, pieceCID)
+		return
+	}
+	r.byPiece[pieceCID] = paths
+}
+
+// stagedReader holds a reference to a staged file until it is closed
+type stagedReader struct {
+	filestore.File
+	registry *Registry
+	path     filestore.Path
+	once     sync.Once
+}
+
+func (sr *stagedReader) Close() error {
+	err := sr.File.Close()
+	sr.once.Do(func() {
+		if rerr := sr.registry.release(sr.path); rerr != nil && err == nil {
+			err = rerr
+		}
+	})
+	return err
+}
@@ -0,0 +1,105 @@
+package stagedpieces_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared/stagedpieces"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stagedpieces")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	fs, err := filestore.NewLocalFileStore(filestore.OsPath(dir))
+	require.NoError(t, err)
+
+	stage := func(path filestore.Path, data []byte) {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	exists := func(path filestore.Path) bool {
+		f, err := fs.Open(path)
+		if err != nil {
+			return false
+		}
+		require.NoError(t, f.Close())
+		return true
+	}
+
+	pieces := shared_testutil.GenerateCids(2)
+	data := shared_testutil.RandomBytes(100)
+	registry := stagedpieces.New(fs)
+
+	t.Run("serves added pieces", func(t *testing.T) {
+		stage("piece-a", data)
+		registry.Add(pieces[0], "piece-a")
+
+		r, ok := registry.Open(pieces[0])
+		require.True(t, ok)
+		read, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		require.NoError(t, r.Close())
+
+		_, ok = registry.Open(pieces[1])
+		require.False(t, ok)
+	})
+
+	t.Run("delete waits for readers", func(t *testing.T) {
+		r1, ok := registry.Open(pieces[0])
+		require.True(t, ok)
+		r2, ok := registry.Open(pieces[0])
+		require.True(t, ok)
+
+		require.NoError(t, registry.Delete("piece-a"))
+		require.True(t, exists("piece-a"))
+
+		// no new retrievals are served from a deleted file
+		_, ok = registry.Open(pieces[0])
+		require.False(t, ok)
+
+		require.NoError(t, r1.Close())
+		require.True(t, exists("piece-a"))
+		require.NoError(t, r2.Close())
+		require.False(t, exists("piece-a"))
+	})
+
+	t.Run("delete without readers", func(t *testing.T) {
+		stage("piece-b", data)
+		registry.Add(pieces[1], "piece-b")
+		require.NoError(t, registry.Delete("piece-b"))
+		require.False(t, exists("piece-b"))
+		_, ok := registry.Open(pieces[1])
+		require.False(t, ok)
+
+		// files that were never added are deleted too
+		stage("piece-c", data)
+		require.NoError(t, registry.Delete("piece-c"))
+		require.False(t, exists("piece-c"))
+	})
+
+	t.Run("forgets files removed outside the registry", func(t *testing.T) {
+		stage("piece-d", data)
+		registry.Add(pieces[1], "piece-d")
+		registry.Add(pieces[1], "piece-e")
+
+		// piece-e does not exist, so the older piece-d is served
+		r, ok := registry.Open(pieces[1])
+		require.True(t, ok)
+		require.NoError(t, r.Close())
+
+		require.NoError(t, fs.Delete("piece-d"))
+		_, ok = registry.Open(pieces[1])
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"sync"
+	"time"
+)
+
+// StateTimes records when deals entered the state they are in, so that deals that
+// have been in a state for too long can be found. Times are kept in memory: a deal
+// that has not changed state since its StateTimes was created is taken to have
+// entered its state when the StateTimes was created. Deals that have finished are
+// forgotten, so the times kept are only those of deals in progress
+type StateTimes struct {
+	lk      sync.Mutex
+	start   time.Time
+	entered map[interface{}]stateTime
+}
+
+type stateTime struct {
+	state interface{}
+	at    time.Time
+}
+
+// NewStateTimes returns a new StateTimes
+func NewStateTimes() *StateTimes {
+	return &StateTimes{
+		start:   time.Now(),
+		entered: make(map[interface{}]stateTime),
+	}
+}
+
+// Record records that the deal with the given id is in the given state, noting the
+// time if the deal was last recorded in a different state
+func (st *StateTimes) Record(id interface{}, state interface{}) {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	if last, ok := st.entered[id]; ok && last.state == state {
+		return
+	}
+	st.entered[id] = stateTime{state: state, at: time.Now()}
+}
+
+// Forget removes the time recorded for the deal with the given id, once the deal has
+// reached a terminal state and can no longer be stuck
+func (st *StateTimes) Forget(id interface{}) {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	delete(st.entered, id)
+}
+
+// Entered returns the time the deal with the given id entered the given state
+func (st *StateTimes) Entered(id interface{}, state interface{}) time.Time {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	if last, ok := st.entered[id]; ok && last.state == state {
+		return last.at
+	}
+	return st.start
+}
+
+// Stuck returns true if a deal that entered a state at the given time has been in it
+// for longer than the state's expected dwell time. A state with no expected dwell
+// time, or a dwell time of zero, is never stuck
+func Stuck(entered time.Time, dwell time.Duration, now time.Time) bool {
+	return dwell > 0 && now.Sub(entered) > dwell
+}
@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+// DefaultTransferUpdateInterval is the least time between updates of a deal's record
+// of its data transfer channel for progress alone
+const DefaultTransferUpdateInterval = 5 * time.Second
+
+// TransferUpdates decides when a deal's record of its data transfer channel is
+// updated, so that the record is not written again for every block sent or received.
+// An update is due when the channel's status changes, and otherwise at most once an
+// interval while the channel makes progress. Channels that have finished are forgotten
+type TransferUpdates struct {
+	lk       sync.Mutex
+	interval time.Duration
+	updated  map[datatransfer.ChannelID]transferUpdate
+}
+
+type transferUpdate struct {
+	status datatransfer.Status
+	at     time.Time
+}
+
+// NewTransferUpdates returns a TransferUpdates that updates the record of a channel
+// whose status is unchanged at most once every interval
+func NewTransferUpdates(interval time.Duration) *TransferUpdates {
+	return &TransferUpdates{
+		interval: interval,
+		updated:  make(map[datatransfer.ChannelID]transferUpdate),
+	}
+}
+
+// Due returns true if the deal's record of the channel should be updated to the given
+// channel state, and if so notes that it was
+func (tu *TransferUpdates) Due(channelState datatransfer.ChannelState) bool {
+	tu.lk.Lock()
+	defer tu.lk.Unlock()
+	chid := channelState.ChannelID()
+	status := channelState.Status()
+	now := time.Now()
+	last, ok := tu.updated[chid]
+	due := !ok || last.status != status || now.Sub(last.at) >= tu.interval
+
+	switch status {
+	case datatransfer.Completed, datatransfer.Failed, datatransfer.Cancelled:
+		delete(tu.updated, chid)
+	default:
+		if due {
+			tu.updated[chid] = transferUpdate{status: status, at: now}
+		}
+	}
+	return due
+}
@@ -0,0 +1,314 @@
+package shared_testutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+)
+
+// ErrStreamDropped is returned when opening a stream that a FaultInjector dropped
+var ErrStreamDropped = errors.New("stream dropped by fault injector")
+
+// ErrStreamReset is returned when reading or writing a stream that a FaultInjector reset
+var ErrStreamReset = errors.New("stream reset by fault injector")
+
+// FaultKind is a kind of fault a FaultInjector can inject into a stream
+type FaultKind uint64
+
+const (
+	// FaultDropStream fails to open the stream, as if the other peer could not be
+	// reached. Inbound streams are reset before their handler sees them
+	FaultDropStream FaultKind = iota
+
+	// FaultResetStream resets the stream once AfterBytes bytes have been read from or
+	// written to it
+	FaultResetStream
+
+	// FaultDelayStream delays every read from and write to the stream by Latency
+	FaultDelayStream
+)
+
+// Fault describes which streams a FaultInjector disrupts, and how
+type Fault struct {
+	Kind FaultKind
+	// Protocol limits the fault to streams for the protocol. Empty matches streams
+	// for any protocol
+	Protocol protocol.ID
+	// Direction limits the fault to streams opened by (DirOutbound) or accepted by
+	// (DirInbound) the host. DirUnknown matches both
+	Direction network.Direction
+	// Occurrence limits the fault to the nth matching stream, counting from 1. Zero
+	// applies the fault to every matching stream
+	Occurrence int
+	// AfterBytes is how many bytes pass through the stream before it is reset
+	AfterBytes int
+	// Latency is how long each read and write is delayed
+	Latency time.Duration
+}
+
+type faultState struct {
+	fault   Fault
+	matched int
+}
+
+// FaultInjector injects faults into the streams of the hosts it wraps, so tests can
+// check how deal flows recover from network failures. Faults are applied in the
+// order streams are opened and accepted, so a test that opens the same streams
+// always sees the same faults
+type FaultInjector struct {
+	lk       sync.Mutex
+	faults   []*faultState
+	injected []Fault
+}
+
+// NewFaultInjector returns a FaultInjector with no faults
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{}
+}
+
+// Inject adds a fault to apply to streams opened or accepted from now on
+func (fi *FaultInjector) Inject(fault Fault) {
+	fi.lk.Lock()
+	defer fi.lk.Unlock()
+	fi.faults = append(fi.faults, &faultState{fault: fault})
+}
+
+// Clear removes all faults, leaving streams that are already faulty as they are
+func (fi *FaultInjector) Clear() {
+	fi.lk.Lock()
+	defer fi.lk.Unlock()
+	fi.faults = nil
+}
+
+// Injected returns the faults that were applied to streams, in the order the
+// streams were opened or accepted
+func (fi *FaultInjector) Injected() []Fault {
+	fi.lk.Lock()
+	defer fi.lk.Unlock()
+	return append([]Fault(nil), fi.injected...)
+}
+
+// WrapHost returns a host that injects the FaultInjector's faults into the streams
+// it opens and accepts
+func (fi *FaultInjector) WrapHost(h host.Host) host.Host {
+	return &faultyHost{Host: h, fi: fi}
+}
+
+// match returns the faults that apply to a new stream
+func (fi *FaultInjector) match(dir network.Direction, protocols []protocol.ID) []Fault {
+	fi.lk.Lock()
+	defer fi.lk.Unlock()
+
+	var faults []Fault
+	for _, fs := range fi.faults {
+		if fs.fault.Direction != network.DirUnknown && fs.fault.Direction != dir {
+			continue
+		}
+		if !matchesProtocol(fs.fault.Protocol, protocols) {
+			continue
+		}
+		fs.matched++
+		if fs.fault.Occurrence != 0 && fs.fault.Occurrence != fs.matched {
+			continue
+		}
+		faults = append(faults, fs.fault)
+		fi.injected = append(fi.injected, fs.fault)
+	}
+	return faults
+}
+
+func matchesProtocol(want protocol.ID, protocols []protocol.ID) bool {
+	if want == "" {
+		return true
+	}
+	for _, p := range protocols {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+type faultyHost struct {
+	host.Host
+	fi *FaultInjector
+}
+
+func (h *faultyHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	faults := h.fi.match(network.DirOutbound, pids)
+	for _, fault := range faults {
+		if fault.Kind == FaultDropStream {
+			return nil, ErrStreamDropped
+		}
+	}
+	// a host negotiates the protocol of a stream lazily, along with the first bytes
+	// written, once identify tells it the peer supports the protocol. A reset before
+	// the negotiation is delivered would drop the stream before the other peer's
+	// handler sees it, so the negotiation is finished first
+	h.waitForIdentify(ctx, p)
+	lazy, err := h.Host.Peerstore().SupportsProtocols(p, protocolStrings(pids)...)
+	if err != nil {
+		return nil, err
+	}
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	if len(lazy) > 0 && resets(faults) {
+		if _, err := s.Read(nil); err != nil {
+			_ = s.Reset()
+			return nil, err
+		}
+	}
+	return newFaultyStream(s, faults), nil
+}
+
+func (h *faultyHost) waitForIdentify(ctx context.Context, p peer.ID) {
+	ids, ok := h.Host.(interface{ IDService() *identify.IDService })
+	if !ok {
+		return
+	}
+	for _, c := range h.Host.Network().ConnsToPeer(p) {
+		select {
+		case <-ids.IDService().IdentifyWait(c):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func resets(faults []Fault) bool {
+	for _, fault := range faults {
+		if fault.Kind == FaultResetStream {
+			return true
+		}
+	}
+	return false
+}
+
+func protocolStrings(pids []protocol.ID) []string {
+	strs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		strs = append(strs, string(pid))
+	}
+	return strs
+}
+
+func (h *faultyHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, h.wrapHandler(handler))
+}
+
+func (h *faultyHost) SetStreamHandlerMatch(pid protocol.ID, match func(string) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, h.wrapHandler(handler))
+}
+
+func (h *faultyHost) wrapHandler(handler network.StreamHandler) network.StreamHandler {
+	return func(s network.Stream) {
+		faults := h.fi.match(network.DirInbound, []protocol.ID{s.Protocol()})
+		for _, fault := range faults {
+			if fault.Kind == FaultDropStream {
+				_ = s.Reset()
+				return
+			}
+		}
+		handler(newFaultyStream(s, faults))
+	}
+}
+
+type faultyStream struct {
+	network.Stream
+
+	lk         sync.Mutex
+	resetAfter int
+	latency    time.Duration
+	passed     int
+	reset      bool
+}
+
+func newFaultyStream(s network.Stream, faults []Fault) network.Stream {
+	if len(faults) == 0 {
+		return s
+	}
+	fs := &faultyStream{Stream: s, resetAfter: -1}
+	for _, fault := range faults {
+		switch fault.Kind {
+		case FaultResetStream:
+			if fs.resetAfter == -1 || fault.AfterBytes < fs.resetAfter {
+				fs.resetAfter = fault.AfterBytes
+			}
+		case FaultDelayStream:
+			fs.latency += fault.Latency
+		}
+	}
+	return fs
+}
+
+func (s *faultyStream) Read(p []byte) (int, error) {
+	limit, err := s.before(len(p))
+	if err != nil {
+		return 0, err
+	}
+	read, err := s.Stream.Read(p[:limit])
+	s.after(read)
+	return read, err
+}
+
+func (s *faultyStream) Write(p []byte) (int, error) {
+	limit, err := s.before(len(p))
+	if err != nil {
+		return 0, err
+	}
+	written, err := s.Stream.Write(p[:limit])
+	s.after(written)
+	if err == nil && written < len(p) {
+		err = ErrStreamReset
+	}
+	return written, err
+}
+
+// before waits out the stream's latency and returns how many of the next n bytes
+// may pass before the stream reaches its reset point
+func (s *faultyStream) before(n int) (int, error) {
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.resetAfter != -1 && s.passed >= s.resetAfter {
+		s.resetLocked()
+	}
+	if s.reset {
+		return 0, ErrStreamReset
+	}
+	if s.resetAfter != -1 && s.resetAfter-s.passed < n {
+		n = s.resetAfter - s.passed
+	}
+	return n, nil
+}
+
+// after records bytes that passed through the stream, and resets the stream once
+// it reaches its reset point
+func (s *faultyStream) after(n int) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.passed += n
+	if s.resetAfter != -1 && s.passed >= s.resetAfter {
+		s.resetLocked()
+	}
+}
+
+func (s *faultyStream) resetLocked() {
+	if s.reset {
+		return
+	}
+	s.reset = true
+	_ = s.Stream.Reset()
+}
@@ -0,0 +1,118 @@
+package shared_testutil_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestFaultInjector(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type read struct {
+		data []byte
+		err  error
+	}
+	setup := func(t *testing.T, pid protocol.ID) (*shared_testutil.Libp2pTestData, chan read) {
+		td := shared_testutil.NewLibp2pTestData(ctx, t, shared_testutil.WithFaultInjection())
+		require.NoError(t, td.MockNet.ConnectAllButSelf())
+		received := make(chan read, 4)
+		td.Host2.SetStreamHandler(pid, func(s network.Stream) {
+			data, err := ioutil.ReadAll(s)
+			received <- read{data, err}
+		})
+		return td, received
+	}
+	receive := func(t *testing.T, received chan read) read {
+		select {
+		case r := <-received:
+			return r
+		case <-ctx.Done():
+			t.Fatal("handler did not finish reading the stream")
+			return read{}
+		}
+	}
+
+	t.Run("drops the nth stream", func(t *testing.T) {
+		pid := protocol.ID("/test/drop")
+		td, received := setup(t, pid)
+		td.Faults1.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDropStream, Protocol: pid, Occurrence: 1})
+
+		_, err := td.Host1.NewStream(ctx, td.Host2.ID(), pid)
+		require.Equal(t, shared_testutil.ErrStreamDropped, err)
+
+		s, err := td.Host1.NewStream(ctx, td.Host2.ID(), pid)
+		require.NoError(t, err)
+		_, err = s.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		r := receive(t, received)
+		require.NoError(t, r.err)
+		require.Equal(t, []byte("hello"), r.data)
+		require.Len(t, td.Faults1.Injected(), 1)
+	})
+
+	t.Run("resets a stream part way through", func(t *testing.T) {
+		pid := protocol.ID("/test/reset")
+		td, received := setup(t, pid)
+		td.Faults1.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultResetStream, Protocol: pid, AfterBytes: 4})
+
+		s, err := td.Host1.NewStream(ctx, td.Host2.ID(), pid)
+		require.NoError(t, err)
+		n, err := s.Write([]byte("hello world"))
+		require.Equal(t, 4, n)
+		require.Equal(t, shared_testutil.ErrStreamReset, err)
+		_, err = s.Write([]byte("again"))
+		require.Equal(t, shared_testutil.ErrStreamReset, err)
+
+		// the reset may discard bytes the other side had not read yet
+		r := receive(t, received)
+		require.Error(t, r.err)
+		require.LessOrEqual(t, len(r.data), 4)
+		require.Equal(t, []byte("hell")[:len(r.data)], r.data)
+	})
+
+	t.Run("drops inbound streams before the handler sees them", func(t *testing.T) {
+		pid := protocol.ID("/test/inbound")
+		td, received := setup(t, pid)
+		td.Faults2.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDropStream, Direction: network.DirInbound})
+
+		s, err := td.Host1.NewStream(ctx, td.Host2.ID(), pid)
+		require.NoError(t, err)
+		_, _ = s.Write([]byte("hello"))
+		_ = s.Close()
+
+		select {
+		case <-received:
+			t.Fatal("handler should not see a dropped stream")
+		case <-time.After(100 * time.Millisecond):
+		}
+		require.Len(t, td.Faults2.Injected(), 1)
+		require.Empty(t, td.Faults1.Injected())
+	})
+
+	t.Run("delays streams", func(t *testing.T) {
+		pid := protocol.ID("/test/delay")
+		td, received := setup(t, pid)
+		td.Faults1.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDelayStream, Latency: 50 * time.Millisecond})
+
+		s, err := td.Host1.NewStream(ctx, td.Host2.ID(), pid)
+		require.NoError(t, err)
+		start := time.Now()
+		_, err = s.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+		require.NoError(t, s.Close())
+		r := receive(t, received)
+		require.NoError(t, r.err)
+		require.Equal(t, []byte("hello"), r.data)
+	})
+}
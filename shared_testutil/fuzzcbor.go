@@ -0,0 +1,59 @@
+package shared_testutil
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// MutateCBOR returns a copy of data with a random change of the kind that trips up
+// CBOR decoders: flipped bits, truncation, a header declaring a huge length, or
+// spliced in random bytes
+func MutateCBOR(rnd *rand.Rand, data []byte) []byte {
+	out := append([]byte{}, data...)
+	if len(out) == 0 {
+		return []byte{byte(rnd.Intn(256))}
+	}
+	pos := rnd.Intn(len(out))
+	switch rnd.Intn(4) {
+	case 0:
+		out[pos] ^= 1 << uint(rnd.Intn(8))
+	case 1:
+		out = out[:pos]
+	case 2:
+		// a byte string, text string, array or map header with an 8 byte length
+		huge := []byte{byte(2+rnd.Intn(4))<<5 | 27, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		out = append(out[:pos], append(huge, out[pos:]...)...)
+	default:
+		splice := make([]byte, 1+rnd.Intn(8))
+		rnd.Read(splice)
+		out = append(out[:pos], append(splice, out[pos:]...)...)
+	}
+	return out
+}
+
+// FuzzDecoder checks that decode accepts each of the seeds, then decodes iterations
+// random mutations of them, failing the test if decode panics on any. Mutations are
+// stacked, so that later iterations stray further from the seeds
+func FuzzDecoder(t *testing.T, decode func([]byte) error, seeds [][]byte, iterations int) {
+	for _, seed := range seeds {
+		require.NoError(t, decode(seed))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < iterations; i++ {
+		data := seeds[rnd.Intn(len(seeds))]
+		for n := 1 + rnd.Intn(4); n > 0; n-- {
+			data = MutateCBOR(rnd, data)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("decoding %s panicked: %v", hex.EncodeToString(data), r)
+				}
+			}()
+			_ = decode(data)
+		}()
+	}
+}
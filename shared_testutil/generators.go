@@ -81,6 +81,7 @@ func MakeTestQueryResponse() retrievalmarket.QueryResponse {
 		MaxPaymentInterval:         rand.Uint64(),
 		MaxPaymentIntervalIncrease: rand.Uint64(),
 		UnsealPrice:                big.Zero(),
+		PriorityPricePerByte:       big.Zero(),
 	}
 }
 
@@ -252,7 +253,7 @@ func MakeTestSignedStorageAsk() *storagemarket.SignedStorageAsk {
 func MakeTestStorageNetworkProposal() smnet.Proposal {
 	return smnet.Proposal{
 		DealProposal: MakeTestClientDealProposal(),
-		Piece:        &storagemarket.DataRef{Root: GenerateCids(1)[0]},
+		Piece:        &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: GenerateCids(1)[0]},
 	}
 }
 
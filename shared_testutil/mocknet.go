@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
@@ -65,10 +66,43 @@ type Libp2pTestData struct {
 	Host2                   host.Host
 	OrigBytes               []byte
 
+	// Faults1 and Faults2 inject faults into the streams of Host1 and Host2 when the
+	// test data is created WithFaultInjection
+	Faults1 *FaultInjector
+	Faults2 *FaultInjector
+
 	MockNet mocknet.Mocknet
 }
 
-func NewLibp2pTestData(ctx context.Context, t *testing.T) *Libp2pTestData {
+type libp2pTestDataConfig struct {
+	linkLatency    time.Duration
+	faultInjection bool
+}
+
+// Libp2pTestDataOption configures the network of a Libp2pTestData
+type Libp2pTestDataOption func(*libp2pTestDataConfig)
+
+// WithLinkLatency adds the given latency to every message sent between the hosts
+func WithLinkLatency(latency time.Duration) Libp2pTestDataOption {
+	return func(cfg *libp2pTestDataConfig) {
+		cfg.linkLatency = latency
+	}
+}
+
+// WithFaultInjection wraps Host1 and Host2 so that faults added to Faults1 and
+// Faults2 are injected into their streams
+func WithFaultInjection() Libp2pTestDataOption {
+	return func(cfg *libp2pTestDataConfig) {
+		cfg.faultInjection = true
+	}
+}
+
+func NewLibp2pTestData(ctx context.Context, t *testing.T, options ...Libp2pTestDataOption) *Libp2pTestData {
+	var cfg libp2pTestDataConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+
 	testData := &Libp2pTestData{}
 	testData.Ctx = ctx
 	makeLoader := func(bs bstore.Blockstore) ipld.Loader {
@@ -135,6 +169,9 @@ func NewLibp2pTestData(ctx context.Context, t *testing.T) *Libp2pTestData {
 	testData.Storer2 = makeStorer(testData.Bs2)
 
 	mn := mocknet.New(ctx)
+	if cfg.linkLatency > 0 {
+		mn.SetLinkDefaults(mocknet.LinkOptions{Latency: cfg.linkLatency})
+	}
 
 	// setup network
 	testData.Host1, err = mn.GenPeer()
@@ -146,6 +183,13 @@ func NewLibp2pTestData(ctx context.Context, t *testing.T) *Libp2pTestData {
 	err = mn.LinkAll()
 	require.NoError(t, err)
 
+	if cfg.faultInjection {
+		testData.Faults1 = NewFaultInjector()
+		testData.Faults2 = NewFaultInjector()
+		testData.Host1 = testData.Faults1.WrapHost(testData.Host1)
+		testData.Host2 = testData.Faults2.WrapHost(testData.Host2)
+	}
+
 	testData.DTNet1 = dtnet.NewFromLibp2pHost(testData.Host1)
 	testData.DTNet2 = dtnet.NewFromLibp2pHost(testData.Host2)
 
@@ -94,6 +94,9 @@ func (trqs *TestRetrievalQueryStream) WriteQueryResponse(newResp rm.QueryRespons
 	return trqs.respWriter(newResp)
 }
 
+// RemotePeer returns the peer ID the stream was set up with.
+func (trqs *TestRetrievalQueryStream) RemotePeer() peer.ID { return trqs.p }
+
 // Close closes the stream (does nothing for test).
 func (trqs *TestRetrievalQueryStream) Close() error { return nil }
 
@@ -142,31 +145,61 @@ type TestDealStreamParams struct {
 // QueryStreamBuilder is a function that builds retrieval query streams.
 type QueryStreamBuilder func(peer.ID) (rmnet.RetrievalQueryStream, error)
 
+// PieceStreamBuilder is a function that builds piece streams.
+type PieceStreamBuilder func(peer.ID) (rmnet.PieceStream, error)
+
+// InlineQueryStreamBuilder is a function that builds inline query streams.
+type InlineQueryStreamBuilder func(peer.ID) (rmnet.InlineQueryStream, error)
+
+// PossessionStreamBuilder is a function that builds possession streams.
+type PossessionStreamBuilder func(peer.ID) (rmnet.PossessionStream, error)
+
 // TestRetrievalMarketNetwork is a test network that has stubbed behavior
 // for testing the retrieval market implementation
 type TestRetrievalMarketNetwork struct {
-	receiver  rmnet.RetrievalReceiver
-	qsbuilder QueryStreamBuilder
+	receiver            rmnet.RetrievalReceiver
+	pieceReceiver       rmnet.PieceReceiver
+	inlineQueryReceiver rmnet.InlineQueryReceiver
+	possessionReceiver  rmnet.PossessionReceiver
+	qsbuilder           QueryStreamBuilder
+	psbuilder           PieceStreamBuilder
+	iqsbuilder          InlineQueryStreamBuilder
+	possbuilder         PossessionStreamBuilder
 }
 
 // TestNetworkParams are parameters for setting up a test network. All
 // parameters other than the receiver are optional
 type TestNetworkParams struct {
-	QueryStreamBuilder QueryStreamBuilder
-	Receiver           rmnet.RetrievalReceiver
+	QueryStreamBuilder       QueryStreamBuilder
+	PieceStreamBuilder       PieceStreamBuilder
+	InlineQueryStreamBuilder InlineQueryStreamBuilder
+	PossessionStreamBuilder  PossessionStreamBuilder
+	Receiver                 rmnet.RetrievalReceiver
 }
 
 // NewTestRetrievalMarketNetwork returns a new TestRetrievalMarketNetwork with the
 // behavior specified by the paramaters, or default behaviors if not specified.
 func NewTestRetrievalMarketNetwork(params TestNetworkParams) *TestRetrievalMarketNetwork {
 	trmn := TestRetrievalMarketNetwork{
-		qsbuilder: TrivialNewQueryStream,
-		receiver:  params.Receiver,
+		qsbuilder:   TrivialNewQueryStream,
+		psbuilder:   FailNewPieceStream,
+		iqsbuilder:  FailNewInlineQueryStream,
+		possbuilder: FailNewPossessionStream,
+		receiver:    params.Receiver,
 	}
 
 	if params.QueryStreamBuilder != nil {
 		trmn.qsbuilder = params.QueryStreamBuilder
 	}
+	if params.PieceStreamBuilder != nil {
+		trmn.psbuilder = params.PieceStreamBuilder
+	}
+	if params.InlineQueryStreamBuilder != nil {
+		trmn.iqsbuilder = params.InlineQueryStreamBuilder
+	}
+	if params.PossessionStreamBuilder != nil {
+		trmn.possbuilder = params.PossessionStreamBuilder
+	}
 	return &trmn
 }
 
@@ -176,6 +209,21 @@ func (trmn *TestRetrievalMarketNetwork) NewQueryStream(id peer.ID) (rmnet.Retrie
 	return trmn.qsbuilder(id)
 }
 
+// NewPieceStream returns a piece stream from the piece stream builder
+func (trmn *TestRetrievalMarketNetwork) NewPieceStream(id peer.ID) (rmnet.PieceStream, error) {
+	return trmn.psbuilder(id)
+}
+
+// NewInlineQueryStream returns an inline query stream from the inline query stream builder
+func (trmn *TestRetrievalMarketNetwork) NewInlineQueryStream(id peer.ID) (rmnet.InlineQueryStream, error) {
+	return trmn.iqsbuilder(id)
+}
+
+// NewPossessionStream returns a possession stream from the possession stream builder
+func (trmn *TestRetrievalMarketNetwork) NewPossessionStream(id peer.ID) (rmnet.PossessionStream, error) {
+	return trmn.possbuilder(id)
+}
+
 // SetDelegate sets the market receiver
 func (trmn *TestRetrievalMarketNetwork) SetDelegate(r rmnet.RetrievalReceiver) error {
 	trmn.receiver = r
@@ -187,9 +235,45 @@ func (trmn *TestRetrievalMarketNetwork) ReceiveQueryStream(qs rmnet.RetrievalQue
 	trmn.receiver.HandleQueryStream(qs)
 }
 
-// StopHandlingRequests sets receiver to nil
+// SetPieceDelegate sets the piece receiver
+func (trmn *TestRetrievalMarketNetwork) SetPieceDelegate(r rmnet.PieceReceiver) error {
+	trmn.pieceReceiver = r
+	return nil
+}
+
+// ReceivePieceStream simulates receiving a piece stream
+func (trmn *TestRetrievalMarketNetwork) ReceivePieceStream(ps rmnet.PieceStream) {
+	trmn.pieceReceiver.HandlePieceStream(ps)
+}
+
+// SetInlineQueryDelegate sets the inline query receiver
+func (trmn *TestRetrievalMarketNetwork) SetInlineQueryDelegate(r rmnet.InlineQueryReceiver) error {
+	trmn.inlineQueryReceiver = r
+	return nil
+}
+
+// ReceiveInlineQueryStream simulates receiving an inline query stream
+func (trmn *TestRetrievalMarketNetwork) ReceiveInlineQueryStream(qs rmnet.InlineQueryStream) {
+	trmn.inlineQueryReceiver.HandleInlineQueryStream(qs)
+}
+
+// SetPossessionDelegate sets the possession receiver
+func (trmn *TestRetrievalMarketNetwork) SetPossessionDelegate(r rmnet.PossessionReceiver) error {
+	trmn.possessionReceiver = r
+	return nil
+}
+
+// ReceivePossessionStream simulates receiving a possession stream
+func (trmn *TestRetrievalMarketNetwork) ReceivePossessionStream(ps rmnet.PossessionStream) {
+	trmn.possessionReceiver.HandlePossessionStream(ps)
+}
+
+// StopHandlingRequests sets receivers to nil
 func (trmn *TestRetrievalMarketNetwork) StopHandlingRequests() error {
 	trmn.receiver = nil
+	trmn.pieceReceiver = nil
+	trmn.inlineQueryReceiver = nil
+	trmn.possessionReceiver = nil
 	return nil
 }
 
@@ -211,6 +295,21 @@ func FailNewQueryStream(peer.ID) (rmnet.RetrievalQueryStream, error) {
 	return nil, errors.New("new query stream failed")
 }
 
+// FailNewPieceStream always fails
+func FailNewPieceStream(peer.ID) (rmnet.PieceStream, error) {
+	return nil, errors.New("new piece stream failed")
+}
+
+// FailNewInlineQueryStream always fails
+func FailNewInlineQueryStream(peer.ID) (rmnet.InlineQueryStream, error) {
+	return nil, errors.New("new inline query stream failed")
+}
+
+// FailNewPossessionStream always fails
+func FailNewPossessionStream(peer.ID) (rmnet.PossessionStream, error) {
+	return nil, errors.New("new possession stream failed")
+}
+
 // FailQueryReader always fails
 func FailQueryReader() (rm.Query, error) {
 	return rm.QueryUndefined, errors.New("read query failed")
@@ -3,6 +3,7 @@ package shared_testutil
 import (
 	"context"
 	"errors"
+	"io"
 	"testing"
 
 	"github.com/ipfs/go-cid"
@@ -105,6 +106,47 @@ func (tps *TestPieceStore) AddPieceBlockLocations(pieceCID cid.Cid, blockLocatio
 	return tps.addPieceBlockLocationsError
 }
 
+// SetRemoteLocation sets the remote location on a stubbed piece
+func (tps *TestPieceStore) SetRemoteLocation(pieceCID cid.Cid, location string) error {
+	pio := tps.piecesStubbed[pieceCID]
+	pio.PieceCID = pieceCID
+	pio.RemoteLocation = location
+	tps.piecesStubbed[pieceCID] = pio
+	return nil
+}
+
+// RemoveDealForPiece removes the deal from a stubbed piece
+func (tps *TestPieceStore) RemoveDealForPiece(pieceCID cid.Cid, dealInfo piecestore.DealInfo) error {
+	pio, ok := tps.piecesStubbed[pieceCID]
+	if !ok {
+		return retrievalmarket.ErrNotFound
+	}
+	deals := make([]piecestore.DealInfo, 0, len(pio.Deals))
+	for _, di := range pio.Deals {
+		if di != dealInfo {
+			deals = append(deals, di)
+		}
+	}
+	pio.Deals = deals
+	tps.piecesStubbed[pieceCID] = pio
+	return nil
+}
+
+// RemovePieceBlockLocations removes the locations in the piece from stubbed CIDs
+func (tps *TestPieceStore) RemovePieceBlockLocations(pieceCID cid.Cid) error {
+	for c, cio := range tps.cidInfosStubbed {
+		locations := make([]piecestore.PieceBlockLocation, 0, len(cio.PieceBlockLocations))
+		for _, pbl := range cio.PieceBlockLocations {
+			if !pbl.PieceCID.Equals(pieceCID) {
+				locations = append(locations, pbl)
+			}
+		}
+		cio.PieceBlockLocations = locations
+		tps.cidInfosStubbed[c] = cio
+	}
+	return nil
+}
+
 // GetPieceInfo returns a piece info if it's been stubbed
 func (tps *TestPieceStore) GetPieceInfo(pieceCID cid.Cid) (piecestore.PieceInfo, error) {
 	if tps.getPieceInfoError != nil {
@@ -147,6 +189,14 @@ func (tps *TestPieceStore) ListPieceInfoKeys() ([]cid.Cid, error) {
 	panic("do not call me")
 }
 
+func (tps *TestPieceStore) Snapshot(w io.Writer) error {
+	panic("do not call me")
+}
+
+func (tps *TestPieceStore) Restore(r io.Reader) error {
+	panic("do not call me")
+}
+
 func (tps *TestPieceStore) Start(ctx context.Context) error {
 	return nil
 }
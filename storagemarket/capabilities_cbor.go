@@ -0,0 +1,194 @@
+package storagemarket
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// cbor-gen does not support slices of strings, so ProviderCapabilities is encoded here
+// by hand, as the map cbor-gen writes for other types with --map-encoding
+
+// MarshalCBOR writes the capabilities as a CBOR map
+func (t *ProviderCapabilities) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{167}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+	if err := writeStringSlice(w, scratch, "Protocols", t.Protocols); err != nil {
+		return err
+	}
+	if err := writeStringSlice(w, scratch, "TransferTypes", t.TransferTypes); err != nil {
+		return err
+	}
+	if err := writeKey(w, scratch, "MinPieceSize"); err != nil {
+		return err
+	}
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.MinPieceSize)); err != nil {
+		return err
+	}
+	if err := writeKey(w, scratch, "MaxPieceSize"); err != nil {
+		return err
+	}
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.MaxPieceSize)); err != nil {
+		return err
+	}
+	if err := writeKey(w, scratch, "VerifiedOnly"); err != nil {
+		return err
+	}
+	if err := cbg.WriteBool(w, t.VerifiedOnly); err != nil {
+		return err
+	}
+	if err := writeKey(w, scratch, "RetrievalFreeTier"); err != nil {
+		return err
+	}
+	if err := cbg.WriteBool(w, t.RetrievalFreeTier); err != nil {
+		return err
+	}
+	return writeStringSlice(w, scratch, "Currencies", t.Currencies)
+}
+
+// UnmarshalCBOR reads capabilities written by MarshalCBOR
+func (t *ProviderCapabilities) UnmarshalCBOR(r io.Reader) error {
+	*t = ProviderCapabilities{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ProviderCapabilities: map struct too large (%d)", extra)
+	}
+
+	n := extra
+	for i := uint64(0); i < n; i++ {
+		name, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+
+		switch name {
+		case "Protocols":
+			t.Protocols, err = readStringSlice(br, scratch, name)
+		case "TransferTypes":
+			t.TransferTypes, err = readStringSlice(br, scratch, name)
+		case "MinPieceSize":
+			var size uint64
+			size, err = readUint64(br, scratch)
+			t.MinPieceSize = abi.PaddedPieceSize(size)
+		case "MaxPieceSize":
+			var size uint64
+			size, err = readUint64(br, scratch)
+			t.MaxPieceSize = abi.PaddedPieceSize(size)
+		case "VerifiedOnly":
+			t.VerifiedOnly, err = readBool(br, scratch)
+		case "RetrievalFreeTier":
+			t.RetrievalFreeTier, err = readBool(br, scratch)
+		case "Currencies":
+			t.Currencies, err = readStringSlice(br, scratch, name)
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeKey(w io.Writer, scratch []byte, key string) error {
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(key))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, key)
+	return err
+}
+
+func writeStringSlice(w io.Writer, scratch []byte, key string, values []string) error {
+	if err := writeKey(w, scratch, key); err != nil {
+		return err
+	}
+	if len(values) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.%s was too long", key)
+	}
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(values))); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if len(v) > cbg.MaxLength {
+			return xerrors.Errorf("Value in field t.%s was too long", key)
+		}
+		if err := writeKey(w, scratch, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readStringSlice(br cbg.BytePeeker, scratch []byte, key string) ([]string, error) {
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return nil, err
+	}
+	if extra > cbg.MaxLength {
+		return nil, fmt.Errorf("t.%s: array too large (%d)", key, extra)
+	}
+	if maj != cbg.MajArray {
+		return nil, fmt.Errorf("expected cbor array")
+	}
+	if extra == 0 {
+		return nil, nil
+	}
+	values := make([]string, extra)
+	for i := range values {
+		values[i], err = cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func readUint64(br cbg.BytePeeker, scratch []byte) (uint64, error) {
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, fmt.Errorf("wrong type for uint64 field")
+	}
+	return extra, nil
+}
+
+func readBool(br cbg.BytePeeker, scratch []byte) (bool, error) {
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return false, err
+	}
+	if maj != cbg.MajOther {
+		return false, fmt.Errorf("booleans must be major type 7")
+	}
+	switch extra {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	default:
+		return false, fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+	}
+}
@@ -6,14 +6,68 @@ import (
 	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
 )
 
 // ClientSubscriber is a callback that is run when events are emitted on a StorageClient
 type ClientSubscriber func(event ClientEvent, deal ClientDeal)
 
+// DealLifecycleHooks receives the milestones in the lifecycle of each of a client's deals,
+// so that external orchestration systems can track deals without polling.
+//
+// Unlike subscribers, hooks are delivered at least once: each event is persisted before
+// delivery and is redelivered, in order, until its hook returns nil, including after
+// the client restarts. Receivers should use the event's Sequence to discard duplicates.
+type DealLifecycleHooks interface {
+	// OnProposed is called when the deal proposal was sent to the provider
+	OnProposed(ctx context.Context, event DealLifecycleEvent) error
+
+	// OnAccepted is called when the provider accepts the deal
+	OnAccepted(ctx context.Context, event DealLifecycleEvent) error
+
+	// OnTransferStarted is called when the transfer of deal data to the provider starts
+	OnTransferStarted(ctx context.Context, event DealLifecycleEvent) error
+
+	// OnPublished is called when the deal is published on chain
+	OnPublished(ctx context.Context, event DealLifecycleEvent) error
+
+	// OnActive is called when the deal becomes active on chain
+	OnActive(ctx context.Context, event DealLifecycleEvent) error
+
+	// OnFailed is called when the deal terminates in failure. The event's Message holds the reason
+	OnFailed(ctx context.Context, event DealLifecycleEvent) error
+}
+
+// RenewalSubscriber is a callback that is run when a StorageClient renews, or fails
+// to renew, a deal that is nearing its end
+type RenewalSubscriber func(event RenewalEvent, renewal DealRenewal)
+
+// RenewalPolicy decides whether a client's active deal that is nearing its end is
+// renewed, and on what terms
+type RenewalPolicy interface {
+	// Renew returns the proposal for a deal to replace the given deal, which may be
+	// with the same or a different provider, or false to let the deal expire. Only
+	// the proposal fields of the returned ScheduledDeal are used
+	Renew(ctx context.Context, deal ClientDeal, epoch abi.ChainEpoch) (ScheduledDeal, bool, error)
+}
+
+// ProposalSigner asks for deal proposals to be signed by a signer that needs
+// confirmation before it signs, such as a hardware wallet, without waiting for the
+// signature
+type ProposalSigner interface {
+	// RequestSignature asks for a deal proposal to be signed by the given signer. Once
+	// it is signed, the signature must be passed to the client's SubmitDealSignature
+	// along with the pending proposal CID. It is asked again for a proposal that is still
+	// waiting when the client restarts
+	RequestSignature(ctx context.Context, pendingProposalCid cid.Cid, signer address.Address, proposal market.DealProposal) error
+}
+
 // StorageClient is a client interface for making storage deals with a StorageProvider
 type StorageClient interface {
 
@@ -33,18 +87,53 @@ type StorageClient interface {
 	// ListLocalDeals lists deals initiated by this storage client
 	ListLocalDeals(ctx context.Context) ([]ClientDeal, error)
 
+	// DealStateSnapshot returns the client deal state machine definition
+	// along with the number of deals currently in each state
+	DealStateSnapshot() (fsmexport.Snapshot, error)
+
 	// GetLocalDeal lists deals that are in progress or rejected
 	GetLocalDeal(ctx context.Context, cid cid.Cid) (ClientDeal, error)
 
 	// GetAsk returns the current ask for a storage provider
 	GetAsk(ctx context.Context, info StorageProviderInfo) (*StorageAsk, error)
 
+	// GetProviderCapabilities returns the features a storage provider advertises,
+	// which are cached for a while after they are fetched
+	GetProviderCapabilities(ctx context.Context, info StorageProviderInfo) (*ProviderCapabilities, error)
+
 	// GetProviderDealState queries a provider for the current state of a client's deal
 	GetProviderDealState(ctx context.Context, proposalCid cid.Cid) (*ProviderDealState, error)
 
 	// ProposeStorageDeal initiates deal negotiation with a Storage Provider
 	ProposeStorageDeal(ctx context.Context, params ProposeStorageDealParams) (*ProposeStorageDealResult, error)
 
+	// ProposeShardedStorageDeal splits a payload too large for one of the provider's
+	// sectors into shards, and proposes a deal with the same terms for each shard
+	ProposeShardedStorageDeal(ctx context.Context, params ProposeStorageDealParams) (*ProposeShardedStorageDealResult, error)
+
+	// ProposeStorageDealFromPath prepares the file or directory at path for storage
+	// and proposes a deal with the given provider for each piece of it, in one
+	// pipeline that resumes where it stopped when it is run again
+	ProposeStorageDealFromPath(ctx context.Context, path string, info *StorageProviderInfo, params ProposeStorageDealFromPathParams) (*ProposeStorageDealFromPathResult, error)
+
+	// EstimateDealCost returns a breakdown of what a deal with the given parameters is
+	// expected to cost, without proposing it
+	EstimateDealCost(ctx context.Context, params ProposeStorageDealParams) (*DealCostEstimate, error)
+
+	// EstimatePadding returns how much larger than its data the piece of a deal for the
+	// given data ref would be, without proposing it
+	EstimatePadding(ctx context.Context, data *DataRef, storeID *multistore.StoreID) (*PaddingEstimate, error)
+
+	// ScheduleStorageDeal saves a deal proposal to be sent to a Storage Provider once
+	// the schedule is reached, and returns the ID of the scheduled deal
+	ScheduleStorageDeal(ctx context.Context, params ProposeStorageDealParams, schedule DealSchedule) (uint64, error)
+
+	// ListScheduledDeals lists the deal proposals the client has scheduled
+	ListScheduledDeals(ctx context.Context) ([]ScheduledDeal, error)
+
+	// CancelScheduledDeal removes a scheduled deal proposal, so that it is not sent
+	CancelScheduledDeal(ctx context.Context, id uint64) error
+
 	// GetPaymentEscrow returns the current funds available for deal payment
 	GetPaymentEscrow(ctx context.Context, addr address.Address) (Balance, error)
 
@@ -53,4 +142,18 @@ type StorageClient interface {
 
 	// SubscribeToEvents listens for events that happen related to storage deals on a provider
 	SubscribeToEvents(subscriber ClientSubscriber) shared.Unsubscribe
+
+	// ListDealRenewals lists the deals nearing their end that the client has renewed,
+	// or tried to renew
+	ListDealRenewals(ctx context.Context) ([]DealRenewal, error)
+
+	// SubscribeToRenewalEvents listens for the renewal of deals nearing their end
+	SubscribeToRenewalEvents(subscriber RenewalSubscriber) shared.Unsubscribe
+
+	// SubmitDealSignature passes the signature for a deal proposal that is awaiting one,
+	// and proposes the signed deal. It returns the CID of the signed proposal
+	SubmitDealSignature(ctx context.Context, pendingProposalCid cid.Cid, signature crypto.Signature) (cid.Cid, error)
+
+	// CancelDealSignature stops waiting for a deal proposal to be signed
+	CancelDealSignature(ctx context.Context, pendingProposalCid cid.Cid) error
 }
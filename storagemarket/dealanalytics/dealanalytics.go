@@ -0,0 +1,303 @@
+/*
+Package dealanalytics aggregates the outcomes of a storage client's deals by
+provider, so that clients can pick providers that take and complete their deals,
+and users can tell which providers keep failing them.
+
+An Analytics subscribes to the client's deal events. For each provider it counts
+the deals the client started with it, how many the provider accepted or rejected,
+how many became active and how long they took to, and how many failed, broken down
+by the event that failed them. Counts are written to a datastore as they change, so
+that they survive restarts.
+
+Histories converts the counts to the histories providerselect scores candidates by,
+and providerselect.SetHistories puts them on candidates in place of the histories
+gathered from the client's local deal records, which forget how deals got to where
+they are.
+*/
+package dealanalytics
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/providerselect"
+)
+
+var log = logging.Logger("dealanalytics")
+
+// ProviderSummary counts the outcomes of a client's deals with a provider
+type ProviderSummary struct {
+	// Deals is the number of deals the client started with the provider
+	Deals uint64
+	// Accepted and Rejected are the number of deals the provider accepted and
+	// rejected
+	Accepted uint64
+	Rejected uint64
+	// Activated is the number of deals that became active
+	Activated uint64
+	// TimedActivations is the number of activated deals whose creation time is known,
+	// and TimeToActive the total time they took to become active
+	TimedActivations uint64
+	TimeToActive     time.Duration
+	// Failed is the number of deals that failed, including those that failed after
+	// they became active, which are also counted in FailedWhileActive
+	Failed            uint64
+	FailedWhileActive uint64
+	// FailureReasons counts failed deals by the name of the event that failed them
+	FailureReasons map[string]uint64
+	// LastFailure is when a deal with the provider last failed
+	LastFailure time.Time
+}
+
+// AcceptanceRate returns the fraction of the deals the provider accepted or
+// rejected that it accepted, or zero if it has not answered any
+func (ps ProviderSummary) AcceptanceRate() float64 {
+	if ps.Accepted+ps.Rejected == 0 {
+		return 0
+	}
+	return float64(ps.Accepted) / float64(ps.Accepted+ps.Rejected)
+}
+
+// MeanTimeToActive returns the mean time deals with the provider took to become
+// active, or zero if none is known
+func (ps ProviderSummary) MeanTimeToActive() time.Duration {
+	if ps.TimedActivations == 0 {
+		return 0
+	}
+	return ps.TimeToActive / time.Duration(ps.TimedActivations)
+}
+
+// History returns the summary as a history providerselect can score
+func (ps ProviderSummary) History() providerselect.History {
+	succeeded := saturatingSub(ps.Activated, ps.FailedWhileActive)
+	return providerselect.History{
+		Succeeded:        int(succeeded),
+		Failed:           int(ps.Failed),
+		InProgress:       int(saturatingSub(ps.Deals, succeeded+ps.Failed)),
+		MeanTimeToActive: ps.MeanTimeToActive(),
+	}
+}
+
+// failureStates are the states a deal is in once it has failed
+var failureStates = map[storagemarket.StorageDealStatus]bool{
+	storagemarket.StorageDealFailing: true,
+	storagemarket.StorageDealError:   true,
+	storagemarket.StorageDealSlashed: true,
+}
+
+// finalStates are the states deals never leave
+var finalStates = map[storagemarket.StorageDealStatus]bool{
+	storagemarket.StorageDealError:   true,
+	storagemarket.StorageDealSlashed: true,
+	storagemarket.StorageDealExpired: true,
+}
+
+// Analytics aggregates a client's deal events by provider
+type Analytics struct {
+	ds datastore.Datastore
+
+	lk        sync.Mutex
+	providers map[address.Address]*ProviderSummary
+	// states are the last known states of the deals that have not reached a final
+	// state, so that each deal's failure is only counted once
+	states map[cid.Cid]storagemarket.StorageDealStatus
+}
+
+// New returns an Analytics that persists its counts to ds, loading the counts
+// already in it
+func New(ds datastore.Datastore) (*Analytics, error) {
+	a := &Analytics{
+		ds:        ds,
+		providers: make(map[address.Address]*ProviderSummary),
+		states:    make(map[cid.Cid]storagemarket.StorageDealStatus),
+	}
+	results, err := ds.Query(query.Query{})
+	if err != nil {
+		return nil, xerrors.Errorf("listing deal analytics: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, xerrors.Errorf("listing deal analytics: %w", err)
+	}
+	for _, entry := range entries {
+		provider, err := address.NewFromString(datastore.NewKey(entry.Key).BaseNamespace())
+		if err != nil {
+			return nil, xerrors.Errorf("parsing deal analytics key %s: %w", entry.Key, err)
+		}
+		var summary ProviderSummary
+		if err := json.Unmarshal(entry.Value, &summary); err != nil {
+			return nil, xerrors.Errorf("decoding deal analytics for %s: %w", provider, err)
+		}
+		a.providers[provider] = &summary
+	}
+	return a, nil
+}
+
+// OnEvent records a client deal event. It is a storagemarket.ClientSubscriber, to
+// be passed to the client's SubscribeToEvents
+func (a *Analytics) OnEvent(event storagemarket.ClientEvent, deal storagemarket.ClientDeal) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	prev, known := a.states[deal.ProposalCid]
+	if finalStates[deal.State] {
+		delete(a.states, deal.ProposalCid)
+	} else {
+		a.states[deal.ProposalCid] = deal.State
+	}
+
+	provider := deal.Proposal.Provider
+	summary, ok := a.providers[provider]
+	if !ok {
+		summary = &ProviderSummary{}
+	}
+	changed := true
+	switch event {
+	case storagemarket.ClientEventOpen, storagemarket.ClientEventAwaitSignature:
+		summary.Deals++
+	case storagemarket.ClientEventDealAccepted:
+		summary.Accepted++
+	case storagemarket.ClientEventDealRejected:
+		summary.Rejected++
+	case storagemarket.ClientEventDealActivated:
+		summary.Activated++
+		if created := time.Time(deal.CreationTime); !created.IsZero() {
+			summary.TimedActivations++
+			summary.TimeToActive += time.Since(created)
+		}
+	default:
+		changed = false
+	}
+	// deals first seen after a restart may have failed before it, and been counted
+	// then
+	if known && failureStates[deal.State] && !failureStates[prev] {
+		summary.Failed++
+		if prev == storagemarket.StorageDealActive {
+			summary.FailedWhileActive++
+		}
+		if summary.FailureReasons == nil {
+			summary.FailureReasons = make(map[string]uint64)
+		}
+		summary.FailureReasons[storagemarket.ClientEvents[event]]++
+		summary.LastFailure = time.Now()
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	a.providers[provider] = summary
+	if err := a.save(provider, summary); err != nil {
+		log.Warnf("recording deal analytics: %s", err)
+	}
+}
+
+// Provider returns the summary of the client's deals with the provider, or false if
+// the client has none
+func (a *Analytics) Provider(provider address.Address) (ProviderSummary, bool) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	summary, ok := a.providers[provider]
+	if !ok {
+		return ProviderSummary{}, false
+	}
+	return summary.copy(), true
+}
+
+// Providers returns the summaries of the client's deals with each provider
+func (a *Analytics) Providers() map[address.Address]ProviderSummary {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	summaries := make(map[address.Address]ProviderSummary, len(a.providers))
+	for provider, summary := range a.providers {
+		summaries[provider] = summary.copy()
+	}
+	return summaries
+}
+
+// Histories returns the histories of the client's deals with each provider, for
+// providerselect.SetHistories
+func (a *Analytics) Histories() map[address.Address]providerselect.History {
+	summaries := a.Providers()
+	histories := make(map[address.Address]providerselect.History, len(summaries))
+	for provider, summary := range summaries {
+		histories[provider] = summary.History()
+	}
+	return histories
+}
+
+// Failing returns the providers with at least minFinished finished deals whose
+// success rate is below maxSuccessRate, worst first
+func (a *Analytics) Failing(minFinished int, maxSuccessRate float64) []address.Address {
+	histories := a.Histories()
+	var failing []address.Address
+	for provider, h := range histories {
+		if h.Succeeded+h.Failed >= minFinished && h.SuccessRate() < maxSuccessRate {
+			failing = append(failing, provider)
+		}
+	}
+	sort.Slice(failing, func(i, j int) bool {
+		ri, rj := histories[failing[i]].SuccessRate(), histories[failing[j]].SuccessRate()
+		if ri != rj {
+			return ri < rj
+		}
+		return failing[i].String() < failing[j].String()
+	})
+	return failing
+}
+
+// Reset discards the counts for the provider
+func (a *Analytics) Reset(provider address.Address) error {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	delete(a.providers, provider)
+	if err := a.ds.Delete(datastore.NewKey(provider.String())); err != nil {
+		return xerrors.Errorf("removing deal analytics for %s: %w", provider, err)
+	}
+	return nil
+}
+
+// save writes the provider's summary to the datastore. It must be called with lk
+// held
+func (a *Analytics) save(provider address.Address, summary *ProviderSummary) error {
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := a.ds.Put(datastore.NewKey(provider.String()), value); err != nil {
+		return xerrors.Errorf("saving deal analytics for %s: %w", provider, err)
+	}
+	return nil
+}
+
+func (ps *ProviderSummary) copy() ProviderSummary {
+	out := *ps
+	if ps.FailureReasons != nil {
+		out.FailureReasons = make(map[string]uint64, len(ps.FailureReasons))
+		for reason, n := range ps.FailureReasons {
+			out.FailureReasons[reason] = n
+		}
+	}
+	return out
+}
+
+func saturatingSub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,106 @@
+package dealanalytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/dealanalytics"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/providerselect"
+)
+
+func TestAnalytics(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	a, err := dealanalytics.New(ds)
+	require.NoError(t, err)
+
+	good, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	bad, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	// run sends the deal through the given events, setting its state after each
+	run := func(provider address.Address, steps ...interface{}) {
+		deal := storagemarket.ClientDeal{
+			ProposalCid:  shared_testutil.GenerateCids(1)[0],
+			CreationTime: cbg.CborTime(time.Now().Add(-time.Hour)),
+		}
+		deal.Proposal.Provider = provider
+		for i := 0; i < len(steps); i += 2 {
+			deal.State = steps[i+1].(storagemarket.StorageDealStatus)
+			a.OnEvent(steps[i].(storagemarket.ClientEvent), deal)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		run(good,
+			storagemarket.ClientEventOpen, storagemarket.StorageDealReserveClientFunds,
+			storagemarket.ClientEventDealAccepted, storagemarket.StorageDealProposalAccepted,
+			storagemarket.ClientEventDealActivated, storagemarket.StorageDealActive)
+	}
+	run(good,
+		storagemarket.ClientEventOpen, storagemarket.StorageDealReserveClientFunds,
+		storagemarket.ClientEventDealAccepted, storagemarket.StorageDealProposalAccepted,
+		storagemarket.ClientEventDealActivated, storagemarket.StorageDealActive,
+		storagemarket.ClientEventDealSlashed, storagemarket.StorageDealSlashed)
+	run(bad,
+		storagemarket.ClientEventOpen, storagemarket.StorageDealReserveClientFunds,
+		storagemarket.ClientEventDealRejected, storagemarket.StorageDealFailing,
+		storagemarket.ClientEventFailed, storagemarket.StorageDealError)
+	run(bad,
+		storagemarket.ClientEventOpen, storagemarket.StorageDealReserveClientFunds,
+		storagemarket.ClientEventDealAccepted, storagemarket.StorageDealProposalAccepted,
+		storagemarket.ClientEventProviderDealFailed, storagemarket.StorageDealFailing,
+		storagemarket.ClientEventRestart, storagemarket.StorageDealFailing,
+		storagemarket.ClientEventFailed, storagemarket.StorageDealError)
+	run(bad,
+		storagemarket.ClientEventOpen, storagemarket.StorageDealReserveClientFunds)
+
+	summary, ok := a.Provider(good)
+	require.True(t, ok)
+	require.Equal(t, uint64(3), summary.Deals)
+	require.Equal(t, float64(1), summary.AcceptanceRate())
+	require.Equal(t, uint64(3), summary.TimedActivations)
+	require.InDelta(t, float64(time.Hour), float64(summary.MeanTimeToActive()), float64(time.Minute))
+	require.Equal(t, map[string]uint64{"ClientEventDealSlashed": 1}, summary.FailureReasons)
+	require.Equal(t, providerselect.History{Succeeded: 2, Failed: 1, MeanTimeToActive: summary.MeanTimeToActive()}, summary.History())
+
+	summary, ok = a.Provider(bad)
+	require.True(t, ok)
+	require.Equal(t, 0.5, summary.AcceptanceRate())
+	require.Zero(t, summary.MeanTimeToActive())
+	require.Equal(t, map[string]uint64{
+		"ClientEventDealRejected":       1,
+		"ClientEventProviderDealFailed": 1,
+	}, summary.FailureReasons)
+	require.Equal(t, providerselect.History{Failed: 2, InProgress: 1}, summary.History())
+
+	require.Equal(t, []address.Address{bad}, a.Failing(2, 0.5))
+	require.Empty(t, a.Failing(5, 0.5))
+
+	// counts are reloaded after a restart
+	a, err = dealanalytics.New(ds)
+	require.NoError(t, err)
+	require.Len(t, a.Providers(), 2)
+	histories := a.Histories()
+	require.Equal(t, 2, histories[bad].Failed)
+
+	candidates := []providerselect.Candidate{{Info: storagemarket.StorageProviderInfo{Address: good}}}
+	providerselect.SetHistories(candidates, histories)
+	require.Equal(t, 2, candidates[0].History.Succeeded)
+
+	require.NoError(t, a.Reset(bad))
+	_, ok = a.Provider(bad)
+	require.False(t, ok)
+	a, err = dealanalytics.New(ds)
+	require.NoError(t, err)
+	require.Len(t, a.Providers(), 1)
+}
@@ -105,6 +105,23 @@ const (
 
 	// StorageDealAwaitingPreCommit means a deal is ready and must be pre-committed
 	StorageDealAwaitingPreCommit
+
+	// StorageDealTransferQueued means the provider accepted a deal but will not receive its data
+	// until other transfers from the same client finish
+	StorageDealTransferQueued
+
+	// StorageDealProposalRetryWait means the provider rejected a deal for a transient reason
+	// and asked for it to be proposed again later. The client waits before resending the
+	// proposal, and the provider waits for it to be resent
+	StorageDealProposalRetryWait
+
+	// StorageDealAwaitingSignature means the client is waiting for its deal proposal to be
+	// signed by a signer that needs confirmation, such as a hardware wallet
+	StorageDealAwaitingSignature
+
+	// StorageDealSigned means a deal proposal that was awaiting a signature was signed, and
+	// the deal carries on under the CID of the signed proposal
+	StorageDealSigned
 )
 
 // DealStates maps StorageDealStatus codes to string names
@@ -139,4 +156,8 @@ var DealStates = map[StorageDealStatus]string{
 	StorageDealFinalizing:              "StorageDealFinalizing",
 	StorageDealClientTransferRestart:   "StorageDealClientTransferRestart",
 	StorageDealProviderTransferRestart: "StorageDealProviderTransferRestart",
+	StorageDealTransferQueued:          "StorageDealTransferQueued",
+	StorageDealProposalRetryWait:       "StorageDealProposalRetryWait",
+	StorageDealAwaitingSignature:       "StorageDealAwaitingSignature",
+	StorageDealSigned:                  "StorageDealSigned",
 }
@@ -49,6 +49,16 @@ querying the chain state for active storage miners.
 `QueryAsk` queries a single provider for more specific details about the kinds of deals they accept, as
 expressed through a `StorageAsk`.
 
+Besides prices and piece sizes, an ask can set the minimum and maximum duration of the deals the provider accepts,
+with the `MinDealDuration` and `MaxDealDuration` options. Proposals outside those limits are rejected without any
+custom deal decision logic. Clients on the previous version of the ask protocol receive the ask without the limits.
+
+`GetProviderCapabilities` fetches the features a provider supports, such as the transfer types it accepts,
+the piece sizes it stores and whether it only takes verified deals, so that a client can choose terms the
+provider will accept before proposing. Providers advertise these on the capabilities protocol, and the
+client caches them for a while after fetching them. Unless a provider sets them with `AdvertiseCapabilities`, they
+are derived from its configuration, such as the protocols its network handles and the piece sizes of its ask.
+
 Deal Flow
 
 The primary mechanism for initiating storage deals is the `ProposeStorageDeal` method on the StorageClient.
@@ -57,28 +67,256 @@ When `ProposeStorageDeal` is called, it constructs and signs a DealProposal, ini
 and hands the deal to the Client FSM, returning the CID of the DealProposal which constitutes the identifier for
 that deal.
 
+Before proposing, a client can call `EstimateDealCost` with the same parameters to get a breakdown of what the deal
+will cost: its storage cost and collateral, the fee of the message reserving escrow for it, and for verified deals the
+datacap it will use.
+
+A deal is priced on its padded piece size, which can be close to double the size of the data. The data is stored as a
+CAR, which is filled with zeros to the next power of two and then fr32 padded. `EstimatePadding` shows a client how
+large the piece for a `DataRef` will be, and how much of it is padding, before it proposes a deal. Deals record the
+size of their CAR as `PayloadSize` alongside the piece size in their proposal: the client when it proposes the deal, and
+the provider when it hands the deal off for sealing.
+
+The provider collateral a client proposes is chosen from the bounds the node reports are allowed on chain for the
+deal, with the `CollateralStrategy` in `ProposeStorageDealParams`: the given collateral, which is checked against the
+bounds before the deal is proposed, the chain minimum, a multiple of it, or the chain maximum.
+
+A client configured with `LimitDeals` refuses to propose deals that cost more per GiB per epoch, last longer or go
+to a provider other than its limits allow, so that a bug in the code driving the client cannot commit it to an absurd
+deal. `SetDealLimits` changes the limits while the client is running.
+
+A client can also call `ScheduleStorageDeal` to send a proposal later, once a given time or chain epoch is reached.
+Scheduled proposals are kept until they are sent, and can be listed with `ListScheduledDeals` or withdrawn with
+`CancelScheduledDeal`. A scheduled proposal is sent at most once. One the client was in the middle of sending
+when it stopped is not sent again, as it may already have reached the provider.
+
+A client configured with `AutoRenewDeals` watches its active deals and, once one is close to its EndEpoch, asks a
+RenewalPolicy whether to renew it and on what terms, then proposes the replacement deal to the same or a different
+provider. `ListDealRenewals` links each old deal to its replacement, and `SubscribeToRenewalEvents` reports renewals
+as they are proposed, declined or fail.
+
+A client configured with `BlockFailingProviders` keeps a list of providers it will not propose deals to, which
+`ProposeStorageDeal` checks and `ListProviders` leaves out. A provider whose deals fail several times in a row is
+added to the list for a while, and `BlockProvider` and `UnblockProvider` change the list by hand.
+`ExportBlocklist` and `ImportBlocklist` share the list between clients. See the blocklist package for details.
+
+A client configured with `AsyncProposalSigning` does not need a signature while `ProposeStorageDeal` runs, which
+suits signers that need confirmation, such as hardware wallets. The deal waits in StorageDealAwaitingSignature,
+under the CID of the unsigned proposal, while the ProposalSigner asks for the signature. `SubmitDealSignature`
+proposes the signed deal under the CID of the signed proposal, and `CancelDealSignature` gives up on it. A deal
+that is not signed within the timeout fails.
+
+A provider that rejects a proposal for a transient reason, such as maintenance or a client without enough funds,
+tells the client how many epochs to wait before trying again. Clients configured with `ResubmitRejectedProposals`
+wait that long and send the same proposal again, instead of failing the deal. The provider waits twice as long for
+the proposal before failing the deal. Clients on version 1.1.0 of the deal protocol are not told to wait, and give up
+on the deal.
+
+Brokers that bill for deals outside the chain can set `Invoice` in `ProposeStorageDealParams` to an external invoice ID,
+the currency it is denominated in and a reference to the price quote used. The invoice is sent with the proposal and
+kept on the deal by both sides, so it appears on the MinerDeal in provider events and can be reconciled with the
+billing system. It plays no part in validating the deal.
+
+Providers started with the `NotifyClients` option push a signed notification to the client over the deal notification
+protocol when a deal is accepted, published, activated or fails. Clients check the notification is signed by the
+provider's worker and move the deal on straight away, instead of waiting for their next poll of the deal's state.
+Clients keep polling, so a missed notification only delays the deal.
+
+The staged data of a provider's deals can be moved to a different filestore, such as a bigger disk, with
+`stagedmove.Move` while the provider is stopped. Each file is copied and checked against a hash of the original
+before the deal is updated to point at the copy, and the originals are removed once every deal using them has moved.
+
+Data imported with `ImportDataForDeal` is staged by a `filestore.StagingManager` under a name derived from its
+content, so offline deals for the same data share one staged file. Each deal holds a reference to the file, and it
+is deleted once the last of those deals is cleaned up. Clients can stage the CARs they prepare in the same way with
+the `Staging` option of the `dataprep` package.
+
+Marketplaces that negotiate deals themselves, such as over an HTTP API, can hand the signed proposal to the provider
+with `AddPreAcceptedDeal`. The deal skips the proposal exchange and the provider's decision logic, and goes straight
+to waiting for its data, which is imported with `ImportDataForDeal` or pushed by the client over data transfer.
+
+A deal fails with a CommP mismatch when the client's data does not write the same CAR file every time, such as
+when a blockstore returns different bytes for a block. Clients started with the `CheckDeterministicCAR` option
+generate the piece commitment twice more before signing a proposal, and refuse the deal if the runs differ. The
+`carcheck` package has the same checks for tools, and can compare a CAR file against the DAG it was made from.
+
+Clients can keep private data from the providers that store it by encrypting it first. The `dataprep` package,
+with the `Encrypt` option, encrypts files with a data key wrapped by a key the client holds, and keeps the
+`envelope.Envelope` holding the wrapped key with each deal, in the `Envelope` of `ProposeStorageDealParams` and
+`ClientDeal`. The envelope is never sent to the provider, and piece commitments are computed over the encrypted data.
+
+Deals a node publishes in the same message wait for that message together: the provider starts one wait on the
+node for each publish message, and calls back each deal waiting on it when the message lands. Each deal then finds
+its own ID in the message's return value: the node reads back the proposals in the message with
+`GetPublishedProposals`, so that each deal finds its ID by its position in the message, and a deal the market actor
+dropped from the message as invalid fails on its own while the rest of the message's deals go ahead.
+
+A client can have another peer, such as a data preparation service, send a deal's data for it by setting the
+`TransferAgent` of the deal's `DataRef` to that peer, along with the piece CID and size. The client does not start a
+transfer for the deal; it passes the proposal CID to the agent, which pushes the data with a
+`StorageDataTransferVoucher` for the proposal. Providers accept the push from either the client or its agent.
+
+Providers can schedule maintenance windows, or enter and leave maintenance straight away. Proposals received during
+maintenance are rejected with the epoch the maintenance ends at, and clients are asked to wait until then before
+trying again. Deals already in progress carry on.
+
+A provider configured with `DiskSpaceWatchdog` pauses deal intake in the same way while any of its volumes is low
+on free space, and resumes once every volume has room again. `SubscribeToDiskSpaceEvents` notifies the operator
+when intake is paused and resumed.
+
+`LimitIntake` caps how many deals a provider accepts per hour and how many bytes per day, in total and from each
+client, to keep intake matched to sealing throughput. The quotas can also be changed through `Config.IntakeQuotas`.
+A proposal that would exceed a quota is rejected with the quota and the time it will fit, and the client is asked
+to try again then.
+
+A payload too large for one of the provider's sectors can be stored with `ProposeShardedStorageDeal`, which splits
+it into shards and proposes a deal for each. It returns a manifest of the shards, which the retrieval client's
+`RetrieveSharded` uses to retrieve the shards and reassemble the payload.
+
+`ProposeStorageDealFromPath` runs the whole of a deal's data preparation and proposal as one pipeline, for a client
+configured with a `dataprep.Preparer` through the `DataPreparer` option. The file or directory at a path is imported,
+split into pieces that fit in the provider's sectors, written to CARs and committed, and a deal is proposed for each
+piece, transferring its CAR from a new store by default. Progress is reported as each stage finishes, and running the
+pipeline again with the same path and job ID resumes it, without proposing the pieces already proposed again.
+
 After some preparation steps, the FSM will send the deal proposal to the StorageProvider, which receives the deal
 in `HandleDealStream`. `HandleDealStream` initiates tracking of deal state on the Provider side and hands the deal to
 the Provider FSM, which handles the rest of deal flow.
 
+When both sides support the multiplexed deal protocol, a client sends all of its proposals to a provider over one
+long-lived stream, rather than opening a stream for each proposal. Each proposal and its response carry an ID that
+correlates them, so a client making many deals with one provider does not churn streams.
+
+A client configured with `TransferBandwidthLimit` limits the rate at which it sends deal data, for all deals
+together and for each deal, so that deals made in the background do not saturate its uplink. A transfer that gets
+ahead of a limit is paused until it is back within it.
+
+A client can send deal data with transports other than its own graphsync manager, registered with
+`DataTransferTransport`. A deal uses the transport named by the `TransferType` of its `DataRef`. If the
+`TransferType` is empty, the client picks the first of its transports that the provider advertises in its
+capabilities and records the choice in the deal. Providers reject proposals whose transfer type they do not accept.
+
 From this point forward, deal negotiation is completely asynchronous and runs in the FSMs.
 
 A user of the modules can monitor deal progress through `SubscribeToEvents` methods on StorageClient and StorageProvider,
 or by simply calling `ListLocalDeals` to get all deal statuses.
 
+The dealanalytics package subscribes to a StorageClient's events and aggregates the outcomes of its deals by provider:
+how many deals each provider accepted, how long they took to become active and which events failed them. Its histories
+can replace those the providerselect package gathers from local deal records when ranking providers.
+
+A StorageClient asks the provider for the state of a deal with `GetProviderDealState`, which the FSM also uses while
+waiting for the deal to be published and sealed. If the provider cannot answer on the current deal status protocol, the
+client asks again on the previous version, translating the answer. The version the provider answered on is recorded in
+the `DealStatusProtocol` field of the deal, and later queries for the deal start with it. Deal states sent on version
+1.1.0 of the protocol leave out the progress of the deal's data transfer and sealing, so a client does not poll providers
+that answer on it for sealing progress.
+
+A StorageProvider delivers events to each subscriber on its own goroutine from a queue, so a slow subscriber cannot hold
+up deals. Queues grow as needed by default, so no events are lost. A provider can bound them with `EventQueue`, so that
+when a subscriber's queue is full, the oldest event is dropped or the subscriber is disconnected, and `EventStats`
+reports how many events were dropped.
+
+Each deal's state handlers run on the deal's own goroutine. A StorageProvider configured with `HandlerPool` runs at most
+a set number of handlers at once, starting waiting handlers in the order they arrived. A deal runs one handler at a
+time, so one slow deal holds at most one place and cannot starve the others. `HandlerStats` reports how many handlers
+are running, waiting and running for too long, for providers handling thousands of deals at once.
+
+A StorageProvider configured with `RetryTransientNodeErrors` retries node calls made by deal state handlers that fail
+for a transient reason, such as a refused connection while the node restarts, with backoff, instead of failing the
+deal. Nodes can mark their errors with `noderetry.Transient` or `noderetry.Permanent`. Calls that may have taken
+effect before they failed, such as publishing deals or reserving funds, are never retried.
+
+`NewReadOnlyProvider` opens a StorageProvider over the datastore of a provider running in another process, to inspect its
+deal state safely. It registers no network handlers, never runs migrations or deal state handlers, and returns
+`ErrReadOnly` from any operation that would change state.
+
+For health checks and alerting, `DealSummary` on the StorageProvider counts the deals in each state and reports
+the deal that has been in each state the longest, along with how many deals have been in their state for longer
+than expected.
+
+For operator UIs, `DealPipeline` lists each deal in progress with its state, when it entered it, the state it moves to
+next and when it is expected to, and what it is waiting on: the deal decision, the client, a transfer slot, a data
+transfer channel, a chain message or sealing, along with the channel, message or sector it waits on.
+
+`Stats` on the StorageProvider reports rolling statistics of deal throughput over the last day: deals accepted per
+hour, GiB of piece data ingested per day and the average time from proposal to activation. A provider configured with
+`PersistStats` keeps the counts behind these statistics in a datastore, so that they survive restarts.
+
+The log lines the provider emits while handling a deal are also kept with the deal, the most recent 100 by default,
+and `GetDealLogs` returns them for a proposal CID, so that a single deal can be debugged without searching the node's
+logs. A provider configured with `PersistDealLogs` keeps them in a datastore, so that they survive restarts.
+
+When a deal is stuck, `InspectDeal` returns it along with when it entered its state, whether it has been there longer
+than expected, its logs and the overrides that apply to it. `ForceFailDeal` fails the deal, `ForceRetryDeal` runs its
+state's handler again, and `ForceAdvanceDeal` moves it on to its next state, such as to wait for the data of a deal whose
+transfer is queued. Deals waiting on chain are only retried, so that their sector is checked again. Each override takes a reason, which is recorded in the deal's logs.
+
 The FSMs implement every step in deal negotiation up to deal publishing. However, adding the deal to a sector and sealing
 it is handled outside this module. When a deal is published, the StorageProvider calls `OnDealComplete` on the StorageProviderNode
 interface (the node itself likely delegates management of sectors and sealing to an implementation of the Storage Mining subsystem
 of the Filecoin spec). At this point, the markets implementations essentially shift to being monitors of deal progression:
 they wait to see and record when the deal becomes active and later expired or slashed.
 
+While a client waits for a deal to become active, it polls the provider for how far it has got sealing the deal's
+sector, and records the latest report in the deal's `SealingProgress`. A provider reports whether the sector has been
+pre-committed, and one configured with `ReportSealingProgress` also reports the epoch it expects the deal to become
+active at, so that the client can see when a deal will miss its start epoch.
+
 When a deal becomes active on chain, the provider records the location of where it's stored in a sector in the PieceStore,
 so that it's available for retrieval.
 
+A provider configured with `AnnounceDeals` also publishes an announcement of each deal that becomes active through
+an Announcer, such as a publisher of advertisements to a content index, so that clients can find the deal's data
+for retrieval. The announcement holds the piece and payload CIDs, the deal ID and the provider's retrieval endpoints.
+
+Verifying that the data received for a deal matches the proposal's piece CID means hashing the whole piece. A provider
+configured with `OffloadCommPVerification` submits this work to an external CommPVerifier, such as a pool of workers,
+and polls it until it is done, rather than hashing the piece itself.
+
+A deal for a piece the provider has already sealed, such as a renewal or a replica, can skip the transfer and reuse
+the sealed copy by proposing the `TTExistingPiece` transfer type. Deals with other transfer types always receive data. A provider configured with `CheckExistingPieces` first spot checks that the sector still holds the
+piece with a PieceHoldChecker, such as by unsealing a range of it or checking the sector is still provable. Copies that
+fail the check are not reused, and a deal that asked to skip its transfer is rejected if no copy passes.
+
+Deal records are versioned and migrated to the current version when the client or provider starts. Before upgrading,
+`DryRunClientMigrations` and `DryRunProviderMigrations` in the migrations package report what each deal record would
+migrate to, and which would fail, without writing anything. A provider configured with `MigrationBackup` copies its
+datastore before migrating, and `Restore` in shared/migrationtools rolls the upgrade back from that copy.
+
+By default each update to a deal overwrites its whole record. A StorageClient started with `LogClientDeals`, or a
+StorageProvider started with `LogProviderDeals`, instead keeps each deal through shared/eventstore as a log of the
+fields each update changed, with a snapshot of the whole deal every so many updates. Records written without the
+option are read as they are until they are next updated, and a deal's log is removed when the deal is deleted.
+`History` and `ValueAt` on a datastore wrapped with `New` in shared/eventstore show how a deal came to its current
+state and what state it was in at any earlier update.
+
+A deal proposal is signed by its client address, but nothing in it ties it to the peer that sends it. A provider
+configured with `AuthenticateClientPeers` binds each client address to the peer that proves it acts for the address,
+and rejects proposals for the address sent by any other peer. A client configured with `SignPeerBinding` sends a
+signature by its address over its peer ID with each proposal, which binds the address to the client's peer even if it
+was bound to another one. Only these signatures bind an address, so a peer relaying a client's signed proposal cannot
+lock the client out. Providers on version 1.1.0 of the deal protocol are sent proposals without the signature. See the
+peerbinding package for details.
+
+Each deal reserves its provider collateral before it is published, and waits for an AddFunds message when the
+provider's market balance is short. A provider configured with `PreProvisionFunds` instead estimates the collateral
+its accepted deals will reserve, plus headroom for more deals at its ask's maximum piece size, and tops up the balance
+in a single message ahead of them. `FundingEstimate` reports the estimate. See the fundprovision package for details.
+
+Collateral is reserved from the miner's worker address. A provider configured with `FundFromWallets` reserves it from
+one of a set of wallets instead, picked for each deal in turn, by fewest funding messages in flight, or by a wallet
+dedicated to the deal's client, so that funding messages go out in parallel and collateral pools can be kept apart.
+The wallet is recorded in the deal's `FundingWallet`, and `PendingFundingMessages` reports the messages in flight
+from each wallet. See the fundwallets package for details.
+
 Major Dependencies
 
 Other libraries in go-fil-markets:
 
+https://github.com/filecoin-project/go-fil-markets/tree/master/dagsharding - used to split payloads too large
+for one sector into shards, and to reassemble them after retrieval.
+
 https://github.com/filecoin-project/go-fil-markets/tree/master/filestore - used to store pieces and other
 temporary data before it's transferred to either a sector or the PieceStore.
 
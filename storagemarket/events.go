@@ -105,6 +105,65 @@ const (
 
 	// ClientEventDataTransferCancelled happens when a data transfer is cancelled
 	ClientEventDataTransferCancelled
+
+	// ClientEventResendProposal happens when restart negotiation finds the provider never
+	// saw the data transfer begin, so the proposal is sent again to get a fresh response
+	ClientEventResendProposal
+
+	// ClientEventRestartNegotiationFailed happens when restart negotiation finds the deal
+	// cannot be resumed
+	ClientEventRestartNegotiationFailed
+
+	// ClientEventTransferQueued happens when the provider has accepted a deal but queued
+	// its data transfer behind other transfers from the client
+	ClientEventTransferQueued
+
+	// ClientEventTransferSlotOpened happens when the provider is ready to receive data for
+	// a deal whose transfer was queued
+	ClientEventTransferSlotOpened
+
+	// ClientEventProposalRetryLater happens when the provider rejects a deal for a transient
+	// reason and the client will propose it again once the provider's retry interval passes
+	ClientEventProposalRetryLater
+
+	// ClientEventResubmitProposal happens when the client is ready to propose a deal again
+	// after the provider asked it to retry later
+	ClientEventResubmitProposal
+
+	// ClientEventDataTransferUpdated happens when the data transfer for a deal changes
+	// status, and every so often while it makes progress
+	ClientEventDataTransferUpdated
+
+	// ClientEventAwaitSignature happens when a deal proposal is waiting to be signed by a
+	// signer that needs confirmation
+	ClientEventAwaitSignature
+
+	// ClientEventSignatureRequestFailed happens when the signer could not be asked to sign
+	// a deal proposal
+	ClientEventSignatureRequestFailed
+
+	// ClientEventSignatureReceived happens when the signature for a deal proposal arrives
+	// and the signed deal is proposed
+	ClientEventSignatureReceived
+
+	// ClientEventSignatureTimedOut happens when a deal proposal is not signed in time
+	ClientEventSignatureTimedOut
+
+	// ClientEventSignatureCancelled happens when the client stops waiting for a deal
+	// proposal to be signed
+	ClientEventSignatureCancelled
+
+	// ClientEventSealingProgress happens when the provider reports a change in how far
+	// it has got sealing the deal's sector
+	ClientEventSealingProgress
+
+	// ClientEventProviderDealFailed happens when the provider notifies the client that
+	// it failed a deal it had already accepted
+	ClientEventProviderDealFailed
+
+	// ClientEventDealStatusProtocol happens when the provider answers a deal status
+	// query on a different version of the deal status protocol than it last did
+	ClientEventDealStatusProtocol
 )
 
 // ClientEvents maps client event codes to string names
@@ -130,6 +189,8 @@ var ClientEvents = map[ClientEvent]string{
 	ClientEventDealAccepted:               "ClientEventDealAccepted",
 	ClientEventDealPublishFailed:          "ClientEventDealPublishFailed",
 	ClientEventDealPublished:              "ClientEventDealPublished",
+	ClientEventDealPrecommitFailed:        "ClientEventDealPrecommitFailed",
+	ClientEventDealPrecommitted:           "ClientEventDealPrecommitted",
 	ClientEventDealActivationFailed:       "ClientEventDealActivationFailed",
 	ClientEventDealActivated:              "ClientEventDealActivated",
 	ClientEventDealCompletionFailed:       "ClientEventDealCompletionFailed",
@@ -141,6 +202,21 @@ var ClientEvents = map[ClientEvent]string{
 	ClientEventDataTransferRestartFailed:  "ClientEventDataTransferRestartFailed",
 	ClientEventDataTransferStalled:        "ClientEventDataTransferStalled",
 	ClientEventDataTransferCancelled:      "ClientEventDataTransferCancelled",
+	ClientEventResendProposal:             "ClientEventResendProposal",
+	ClientEventRestartNegotiationFailed:   "ClientEventRestartNegotiationFailed",
+	ClientEventTransferQueued:             "ClientEventTransferQueued",
+	ClientEventTransferSlotOpened:         "ClientEventTransferSlotOpened",
+	ClientEventProposalRetryLater:         "ClientEventProposalRetryLater",
+	ClientEventResubmitProposal:           "ClientEventResubmitProposal",
+	ClientEventDataTransferUpdated:        "ClientEventDataTransferUpdated",
+	ClientEventAwaitSignature:             "ClientEventAwaitSignature",
+	ClientEventSignatureRequestFailed:     "ClientEventSignatureRequestFailed",
+	ClientEventSignatureReceived:          "ClientEventSignatureReceived",
+	ClientEventSignatureTimedOut:          "ClientEventSignatureTimedOut",
+	ClientEventSignatureCancelled:         "ClientEventSignatureCancelled",
+	ClientEventSealingProgress:            "ClientEventSealingProgress",
+	ClientEventProviderDealFailed:         "ClientEventProviderDealFailed",
+	ClientEventDealStatusProtocol:         "ClientEventDealStatusProtocol",
 }
 
 // ProviderEvent is an event that happens in the provider's deal state machine
@@ -272,6 +348,61 @@ const (
 
 	// ProviderEventDataTransferCancelled happens when a data transfer is cancelled
 	ProviderEventDataTransferCancelled
+
+	// ProviderEventRestartNegotiationFailed happens when restart negotiation finds the deal
+	// cannot be resumed
+	ProviderEventRestartNegotiationFailed
+
+	// ProviderEventExistingPieceFound happens when a provider already has the piece for a deal
+	// sealed, so it can skip receiving the deal's data
+	ProviderEventExistingPieceFound
+
+	// ProviderEventTransferQueued happens when a deal is accepted while the client already
+	// has as many transfers in progress as the provider allows
+	ProviderEventTransferQueued
+
+	// ProviderEventTransferSlotOpened happens when a queued deal may start transferring data
+	ProviderEventTransferSlotOpened
+
+	// ProviderEventAwaitingResubmission happens when a deal rejected for a transient reason
+	// has been cleaned up and the provider waits for the client to propose it again
+	ProviderEventAwaitingResubmission
+
+	// ProviderEventProposalResubmitted happens when a client proposes again a deal the
+	// provider asked it to retry later
+	ProviderEventProposalResubmitted
+
+	// ProviderEventDataTransferUpdated happens when the data transfer for a deal changes
+	// status, and every so often while it makes progress
+	ProviderEventDataTransferUpdated
+
+	// ProviderEventCommPSubmitted happens when the deal's data is submitted to an
+	// external CommPVerifier to compute its piece commitment
+	ProviderEventCommPSubmitted
+
+	// ProviderEventPreAcceptedDealAdded happens when a deal agreed to outside of the deal
+	// protocol is added to the provider, ready to receive its data
+	ProviderEventPreAcceptedDealAdded
+
+	// ProviderEventOperatorFailed happens when an operator forces a stuck deal to fail
+	ProviderEventOperatorFailed
+
+	// ProviderEventOperatorRetried happens when an operator forces the provider to run
+	// the handler of a stuck deal's state again
+	ProviderEventOperatorRetried
+
+	// ProviderEventOperatorAdvanced happens when an operator moves a stuck deal on to
+	// the next state, having checked that what the deal was waiting for happened
+	ProviderEventOperatorAdvanced
+
+	// ProviderEventResubmissionExpired happens when a client does not propose again a
+	// deal the provider asked it to retry later, in time
+	ProviderEventResubmissionExpired
+
+	// ProviderEventResubmissionWaitElapsed happens when the time the provider waits for
+	// a deal to be proposed again may have run out. It does nothing to deals that have
+	// moved on
+	ProviderEventResubmissionWaitElapsed
 )
 
 // ProviderEvents maps provider event codes to string names
@@ -301,6 +432,8 @@ var ProviderEvents = map[ProviderEvent]string{
 	ProviderEventFileStoreErrored:          "ProviderEventFileStoreErrored",
 	ProviderEventDealHandoffFailed:         "ProviderEventDealHandoffFailed",
 	ProviderEventDealHandedOff:             "ProviderEventDealHandedOff",
+	ProviderEventDealPrecommitFailed:       "ProviderEventDealPrecommitFailed",
+	ProviderEventDealPrecommitted:          "ProviderEventDealPrecommitted",
 	ProviderEventDealActivationFailed:      "ProviderEventDealActivationFailed",
 	ProviderEventDealActivated:             "ProviderEventDealActivated",
 	ProviderEventPieceStoreErrored:         "ProviderEventPieceStoreErrored",
@@ -316,4 +449,63 @@ var ProviderEvents = map[ProviderEvent]string{
 	ProviderEventDataTransferRestartFailed: "ProviderEventDataTransferRestartFailed",
 	ProviderEventDataTransferStalled:       "ProviderEventDataTransferStalled",
 	ProviderEventDataTransferCancelled:     "ProviderEventDataTransferCancelled",
+	ProviderEventRestartNegotiationFailed:  "ProviderEventRestartNegotiationFailed",
+	ProviderEventExistingPieceFound:        "ProviderEventExistingPieceFound",
+	ProviderEventTransferQueued:            "ProviderEventTransferQueued",
+	ProviderEventTransferSlotOpened:        "ProviderEventTransferSlotOpened",
+	ProviderEventAwaitingResubmission:      "ProviderEventAwaitingResubmission",
+	ProviderEventProposalResubmitted:       "ProviderEventProposalResubmitted",
+	ProviderEventDataTransferUpdated:       "ProviderEventDataTransferUpdated",
+	ProviderEventCommPSubmitted:            "ProviderEventCommPSubmitted",
+	ProviderEventPreAcceptedDealAdded:      "ProviderEventPreAcceptedDealAdded",
+	ProviderEventOperatorFailed:            "ProviderEventOperatorFailed",
+	ProviderEventOperatorRetried:           "ProviderEventOperatorRetried",
+	ProviderEventOperatorAdvanced:          "ProviderEventOperatorAdvanced",
+	ProviderEventResubmissionExpired:       "ProviderEventResubmissionExpired",
+	ProviderEventResubmissionWaitElapsed:   "ProviderEventResubmissionWaitElapsed",
+}
+
+// RenewalEvent is an event in the renewal of a client's deal that is nearing its end
+type RenewalEvent uint64
+
+const (
+	// RenewalEventProposed happens when a deal to replace one nearing its end is proposed
+	RenewalEventProposed RenewalEvent = iota
+
+	// RenewalEventDeclined happens when the renewal policy chooses not to renew a deal
+	RenewalEventDeclined
+
+	// RenewalEventFailed happens when an attempt to renew a deal fails. The renewal is
+	// tried again until the deal ends
+	RenewalEventFailed
+)
+
+// RenewalEvents maps renewal event codes to string names
+var RenewalEvents = map[RenewalEvent]string{
+	RenewalEventProposed: "RenewalEventProposed",
+	RenewalEventDeclined: "RenewalEventDeclined",
+	RenewalEventFailed:   "RenewalEventFailed",
+}
+
+// DiskSpaceEvent is an event about the free disk space on a storage provider's volumes
+type DiskSpaceEvent uint64
+
+const (
+	// DiskSpaceEventLow happens when a volume falls below the minimum free space, and
+	// the provider pauses deal intake
+	DiskSpaceEventLow DiskSpaceEvent = iota
+
+	// DiskSpaceEventRecovered happens when every volume has enough free space again,
+	// and the provider resumes deal intake
+	DiskSpaceEventRecovered
+
+	// DiskSpaceEventCheckFailed happens when the free space on a volume cannot be read
+	DiskSpaceEventCheckFailed
+)
+
+// DiskSpaceEvents maps disk space event codes to string names
+var DiskSpaceEvents = map[DiskSpaceEvent]string{
+	DiskSpaceEventLow:         "DiskSpaceEventLow",
+	DiskSpaceEventRecovered:   "DiskSpaceEventRecovered",
+	DiskSpaceEventCheckFailed: "DiskSpaceEventCheckFailed",
 }
@@ -0,0 +1,44 @@
+package storageimpl
+
+import (
+	"context"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// announceTimeout is how long an announcer has to publish a deal's announcement
+const announceTimeout = time.Minute
+
+// AnnounceDeals publishes an announcement through the given announcer each time a
+// deal becomes active, so the deal's data can be found for retrieval. The given
+// addresses are announced as the endpoints to retrieve the data from
+func AnnounceDeals(announcer storagemarket.Announcer, addrs ...ma.Multiaddr) StorageProviderOption {
+	return func(p *Provider) {
+		p.announcer = announcer
+		p.announceAddrs = addrs
+	}
+}
+
+// announce publishes the announcement of a deal that became active. Announcing is
+// best effort: a deal whose announcement fails is still active
+func (p *Provider) announce(deal storagemarket.MinerDeal) {
+	ctx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	defer cancel()
+
+	announcement := storagemarket.Announcement{
+		PieceCID:      deal.Proposal.PieceCID,
+		PayloadCID:    deal.Ref.Root,
+		DealID:        deal.DealID,
+		ProposalCid:   deal.ProposalCid,
+		Provider:      deal.Proposal.Provider,
+		FastRetrieval: deal.FastRetrieval,
+		PeerID:        p.net.ID(),
+		Addrs:         p.announceAddrs,
+	}
+	if err := p.announcer.Announce(ctx, announcement); err != nil {
+		log.Errorf("announcing deal %s: %s", deal.ProposalCid, err)
+	}
+}
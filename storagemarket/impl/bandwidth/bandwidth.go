@@ -0,0 +1,148 @@
+/*
+Package bandwidth limits the rate at which a storage client sends deal data, so that
+deals made in the background do not saturate the client's uplink.
+
+A Limiter meters the bytes sent on each data transfer channel against a rate for all
+channels together and a rate for each channel. When a channel gets ahead of either
+rate by more than Burst, the Limiter pauses the channel for as long as it takes the
+rate to catch up, then resumes it.
+*/
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+)
+
+var log = logging.Logger("storagemarket_impl")
+
+// Burst is how far ahead of its rate a channel may get before it is paused
+const Burst = time.Second
+
+// ChannelPauser pauses and resumes data transfer channels. A datatransfer.Manager
+// is a ChannelPauser
+type ChannelPauser interface {
+	PauseDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error
+	ResumeDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error
+}
+
+// bucket meters bytes against a rate. Each byte metered pushes back the time at
+// which the bytes metered so far will have been sent at the rate
+type bucket struct {
+	rate     uint64
+	caughtUp time.Time
+}
+
+// meter meters n bytes sent at now, and returns how long sending must wait for the
+// rate to catch up
+func (b *bucket) meter(n uint64, now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	if b.caughtUp.Before(now) {
+		b.caughtUp = now
+	}
+	b.caughtUp = b.caughtUp.Add(time.Duration(float64(n) / float64(b.rate) * float64(time.Second)))
+	ahead := b.caughtUp.Sub(now) - Burst
+	if ahead < 0 {
+		return 0
+	}
+	return ahead
+}
+
+type channel struct {
+	sent   uint64
+	bucket bucket
+	paused bool
+}
+
+// Limiter limits the rate at which data is sent on data transfer channels
+type Limiter struct {
+	pauser      ChannelPauser
+	channelRate uint64
+
+	lk        sync.Mutex
+	total     bucket
+	channels  map[datatransfer.ChannelID]*channel
+	now       func() time.Time
+	afterFunc func(time.Duration, func())
+}
+
+// New returns a Limiter that limits the data sent on all channels together to
+// totalRate and on each channel to channelRate, both in bytes per second. A rate of
+// zero does not limit
+func New(pauser ChannelPauser, totalRate uint64, channelRate uint64) *Limiter {
+	return &Limiter{
+		pauser:      pauser,
+		channelRate: channelRate,
+		total:       bucket{rate: totalRate},
+		channels:    make(map[datatransfer.ChannelID]*channel),
+		now:         time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Record records that a channel has sent the given number of bytes in total, and
+// pauses the channel if it is ahead of either rate
+func (l *Limiter) Record(chid datatransfer.ChannelID, sent uint64) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	ch, ok := l.channels[chid]
+	if !ok {
+		ch = &channel{bucket: bucket{rate: l.channelRate}}
+		l.channels[chid] = ch
+	}
+	if sent <= ch.sent {
+		return
+	}
+	n := sent - ch.sent
+	ch.sent = sent
+
+	now := l.now()
+	wait := l.total.meter(n, now)
+	if channelWait := ch.bucket.meter(n, now); channelWait > wait {
+		wait = channelWait
+	}
+	if wait == 0 || ch.paused {
+		return
+	}
+
+	ch.paused = true
+	go func() {
+		if err := l.pauser.PauseDataTransferChannel(context.TODO(), chid); err != nil {
+			log.Warnf("pausing transfer %s to limit bandwidth: %s", chid, err)
+		}
+		l.afterFunc(wait, func() { l.resume(chid) })
+	}()
+}
+
+// Forget stops tracking a channel that has finished
+func (l *Limiter) Forget(chid datatransfer.ChannelID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	delete(l.channels, chid)
+}
+
+func (l *Limiter) resume(chid datatransfer.ChannelID) {
+	l.lk.Lock()
+	ch, ok := l.channels[chid]
+	if ok {
+		ch.paused = false
+	}
+	l.lk.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := l.pauser.ResumeDataTransferChannel(context.TODO(), chid); err != nil {
+		log.Warnf("resuming transfer %s after limiting bandwidth: %s", chid, err)
+	}
+}
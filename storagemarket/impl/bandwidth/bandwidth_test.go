@@ -0,0 +1,106 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+type pauseCall struct {
+	chid   datatransfer.ChannelID
+	resume bool
+}
+
+type fakePauser struct {
+	calls chan pauseCall
+}
+
+func (fp *fakePauser) PauseDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	fp.calls <- pauseCall{chid, false}
+	return nil
+}
+
+func (fp *fakePauser) ResumeDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	fp.calls <- pauseCall{chid, true}
+	return nil
+}
+
+type delayed struct {
+	wait time.Duration
+	f    func()
+}
+
+func newTestLimiter(totalRate uint64, channelRate uint64) (*Limiter, *fakePauser, chan delayed, *time.Time) {
+	pauser := &fakePauser{calls: make(chan pauseCall, 8)}
+	l := New(pauser, totalRate, channelRate)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	delays := make(chan delayed, 8)
+	l.afterFunc = func(wait time.Duration, f func()) {
+		delays <- delayed{wait, f}
+	}
+	return l, pauser, delays, &now
+}
+
+func TestLimiter(t *testing.T) {
+	chids := []datatransfer.ChannelID{shared_testutil.MakeTestChannelID(), shared_testutil.MakeTestChannelID()}
+
+	t.Run("pauses a channel ahead of its rate until the rate catches up", func(t *testing.T) {
+		l, pauser, delays, now := newTestLimiter(0, 1000)
+
+		// a second's worth of data is within the burst
+		l.Record(chids[0], 1000)
+		require.Empty(t, pauser.calls)
+
+		// three seconds' worth sent at once is two seconds past the burst
+		l.Record(chids[0], 3000)
+		require.Equal(t, pauseCall{chids[0], false}, <-pauser.calls)
+		d := <-delays
+		require.Equal(t, 2*time.Second, d.wait)
+
+		// more data sent while paused does not pause again
+		l.Record(chids[0], 3500)
+		require.Empty(t, pauser.calls)
+
+		// other channels have their own rate
+		l.Record(chids[1], 1000)
+		require.Empty(t, pauser.calls)
+
+		*now = now.Add(d.wait)
+		d.f()
+		require.Equal(t, pauseCall{chids[0], true}, <-pauser.calls)
+	})
+
+	t.Run("limits all channels together", func(t *testing.T) {
+		l, pauser, delays, _ := newTestLimiter(1000, 0)
+
+		l.Record(chids[0], 1000)
+		l.Record(chids[1], 1500)
+		require.Equal(t, pauseCall{chids[1], false}, <-pauser.calls)
+		d := <-delays
+		require.Equal(t, 1500*time.Millisecond, d.wait)
+	})
+
+	t.Run("does not resume a channel that finished", func(t *testing.T) {
+		l, pauser, delays, _ := newTestLimiter(0, 1000)
+
+		l.Record(chids[0], 5000)
+		require.Equal(t, pauseCall{chids[0], false}, <-pauser.calls)
+		d := <-delays
+		l.Forget(chids[0])
+		d.f()
+		require.Empty(t, pauser.calls)
+	})
+
+	t.Run("zero rates do not limit", func(t *testing.T) {
+		l, pauser, _, _ := newTestLimiter(0, 0)
+		l.Record(chids[0], 1<<40)
+		require.Empty(t, pauser.calls)
+	})
+}
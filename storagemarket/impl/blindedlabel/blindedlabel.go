@@ -0,0 +1,90 @@
+/*
+Package blindedlabel creates and verifies blinded deal labels, which let a storage
+client commit to a deal's payload CID on chain without revealing it.
+
+A normal deal label is the payload CID in cleartext. A blinded label is instead
+an HMAC of the payload CID, keyed with an opening derived from a secret only the
+client holds:
+
+	opening = HMAC-SHA256(secret, payloadCID)
+	label   = Prefix + multibase(HMAC-SHA256(opening, payloadCID))
+
+To prove later that a deal stores a given payload, the client reveals the payload
+CID and the opening for that deal. Anyone can then check the correspondence with
+Verify. Revealing the opening for one payload says nothing about the secret or
+about the labels of the client's other deals.
+*/
+package blindedlabel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multibase"
+	"golang.org/x/xerrors"
+)
+
+// Prefix marks a deal label as blinded
+const Prefix = "blinded:"
+
+// Opening returns the value a client reveals to prove a blinded label commits to
+// the given payload CID
+func Opening(secret []byte, payloadCID cid.Cid) []byte {
+	return mac(secret, payloadCID)
+}
+
+// New returns a blinded label for the given payload CID, along with the opening
+// that proves the correspondence
+func New(secret []byte, payloadCID cid.Cid) (string, []byte, error) {
+	if len(secret) == 0 {
+		return "", nil, xerrors.New("secret for blinding label must not be empty")
+	}
+	opening := Opening(secret, payloadCID)
+	encoded, err := multibase.Encode(multibase.Base64url, mac(opening, payloadCID))
+	if err != nil {
+		return "", nil, err
+	}
+	return Prefix + encoded, opening, nil
+}
+
+// IsBlinded returns true if the label is a blinded label rather than a cleartext one
+func IsBlinded(label string) bool {
+	return strings.HasPrefix(label, Prefix)
+}
+
+// Decode returns the commitment in a blinded label, or an error if the label
+// is not a well formed blinded label
+func Decode(label string) ([]byte, error) {
+	if !IsBlinded(label) {
+		return nil, xerrors.New("label is not blinded")
+	}
+	_, commitment, err := multibase.Decode(strings.TrimPrefix(label, Prefix))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding blinded label: %w", err)
+	}
+	if len(commitment) != sha256.Size {
+		return nil, xerrors.Errorf("blinded label commitment must be %d bytes, is %d", sha256.Size, len(commitment))
+	}
+	return commitment, nil
+}
+
+// Verify checks that a blinded label commits to the given payload CID, using the
+// opening revealed by the client
+func Verify(label string, payloadCID cid.Cid, opening []byte) error {
+	commitment, err := Decode(label)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(commitment, mac(opening, payloadCID)) {
+		return xerrors.New("blinded label does not match payload CID")
+	}
+	return nil
+}
+
+func mac(key []byte, payloadCID cid.Cid) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write(payloadCID.Bytes())
+	return h.Sum(nil)
+}
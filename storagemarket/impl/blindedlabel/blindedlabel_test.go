@@ -0,0 +1,57 @@
+package blindedlabel_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blindedlabel"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
+)
+
+func TestBlindedLabel(t *testing.T) {
+	cids := shared_testutil.GenerateCids(2)
+	payloadCID, otherCID := cids[0], cids[1]
+	secret := []byte("client secret")
+
+	label, opening, err := blindedlabel.New(secret, payloadCID)
+	require.NoError(t, err)
+	require.True(t, blindedlabel.IsBlinded(label))
+	require.NotContains(t, label, payloadCID.String())
+	require.LessOrEqual(t, len(label), providerstates.DealMaxLabelSize)
+	require.Equal(t, blindedlabel.Opening(secret, payloadCID), opening)
+
+	t.Run("deterministic", func(t *testing.T) {
+		again, _, err := blindedlabel.New(secret, payloadCID)
+		require.NoError(t, err)
+		require.Equal(t, label, again)
+
+		otherSecret, _, err := blindedlabel.New([]byte("another secret"), payloadCID)
+		require.NoError(t, err)
+		require.NotEqual(t, label, otherSecret)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		require.NoError(t, blindedlabel.Verify(label, payloadCID, opening))
+		require.EqualError(t, blindedlabel.Verify(label, otherCID, opening), "blinded label does not match payload CID")
+		require.EqualError(t, blindedlabel.Verify(label, payloadCID, blindedlabel.Opening(secret, otherCID)), "blinded label does not match payload CID")
+		require.EqualError(t, blindedlabel.Verify(payloadCID.String(), payloadCID, opening), "label is not blinded")
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		commitment, err := blindedlabel.Decode(label)
+		require.NoError(t, err)
+		require.Len(t, commitment, 32)
+
+		_, err = blindedlabel.Decode(blindedlabel.Prefix + "not multibase!")
+		require.Error(t, err)
+		_, err = blindedlabel.Decode(blindedlabel.Prefix + "uAAAA")
+		require.EqualError(t, err, "blinded label commitment must be 32 bytes, is 3")
+	})
+
+	t.Run("empty secret", func(t *testing.T) {
+		_, _, err := blindedlabel.New(nil, payloadCID)
+		require.Error(t, err)
+	})
+}
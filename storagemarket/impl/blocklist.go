@@ -0,0 +1,100 @@
+package storageimpl
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blocklist"
+)
+
+// errBlocklistDisabled is returned by the blocklist methods of a client configured
+// without BlockFailingProviders
+var errBlocklistDisabled = xerrors.New("provider blocklist is not enabled")
+
+// BlockFailingProviders has the client keep a list of providers it does not propose
+// deals to, and block providers whose deals keep failing. Blocked providers are left
+// out of ListProviders. The list is kept in the client's datastore, so this option
+// only takes effect when passed to NewClient
+func BlockFailingProviders(options ...blocklist.Option) StorageClientOption {
+	return func(c *Client) {
+		c.blocklistEnabled = true
+		c.blocklistOptions = options
+	}
+}
+
+// BlockProvider stops the client proposing deals to a provider, until expires, or
+// until it is unblocked if expires is zero
+func (c *Client) BlockProvider(ctx context.Context, provider address.Address, reason string, expires time.Time) error {
+	if c.blocklist == nil {
+		return errBlocklistDisabled
+	}
+	return c.blocklist.Block(provider, reason, expires)
+}
+
+// UnblockProvider lets the client propose deals to a blocked provider again
+func (c *Client) UnblockProvider(ctx context.Context, provider address.Address) error {
+	if c.blocklist == nil {
+		return errBlocklistDisabled
+	}
+	return c.blocklist.Unblock(provider)
+}
+
+// ListBlockedProviders lists the providers the client does not propose deals to. It
+// is empty unless the client was configured with BlockFailingProviders
+func (c *Client) ListBlockedProviders(ctx context.Context) ([]blocklist.Entry, error) {
+	if c.blocklist == nil {
+		return nil, nil
+	}
+	return c.blocklist.List()
+}
+
+// ExportBlocklist returns the client's blocked providers, for sharing with other
+// clients through ImportBlocklist
+func (c *Client) ExportBlocklist(ctx context.Context) ([]blocklist.Entry, error) {
+	if c.blocklist == nil {
+		return nil, errBlocklistDisabled
+	}
+	return c.blocklist.Export()
+}
+
+// ImportBlocklist adds providers blocked by another client to the client's list
+func (c *Client) ImportBlocklist(ctx context.Context, entries []blocklist.Entry) error {
+	if c.blocklist == nil {
+		return errBlocklistDisabled
+	}
+	return c.blocklist.Import(entries)
+}
+
+// checkBlocklist returns an error if the provider is blocked
+func (c *Client) checkBlocklist(provider address.Address) error {
+	if c.blocklist == nil {
+		return nil
+	}
+	return c.blocklist.Check(provider)
+}
+
+// recordProviderOutcome counts failed deals towards blocking their provider
+func (c *Client) recordProviderOutcome(evt storagemarket.ClientEvent, deal storagemarket.ClientDeal) {
+	if c.blocklist == nil {
+		return
+	}
+	provider := deal.Proposal.Provider
+	switch evt {
+	case storagemarket.ClientEventFailed:
+		blocked, err := c.blocklist.RecordFailure(provider, deal.Message)
+		if err != nil {
+			log.Errorf("recording failed deal %s with provider %s: %s", deal.ProposalCid, provider, err)
+			return
+		}
+		if blocked {
+			log.Warnf("blocked provider %s after deal %s failed: %s", provider, deal.ProposalCid, deal.Message)
+		}
+	case storagemarket.ClientEventDealActivated:
+		c.blocklist.RecordSuccess(provider)
+	}
+}
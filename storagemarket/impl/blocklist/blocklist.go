@@ -0,0 +1,304 @@
+/*
+Package blocklist keeps a storage client's list of providers it will not propose
+deals to.
+
+Providers are added to the list automatically when the client's deals with them keep
+failing: a provider whose deals fail Threshold times in a row, within the failure
+window, is blocked for the block duration. A deal with the provider becoming active
+starts the count over. Failure counts are kept in memory, and start over when the
+client restarts.
+
+The operator can also block and unblock providers by hand. Manual entries do not
+expire unless given an expiry, and unblocking a provider also clears its failure
+count. Entries can be exported and imported into another client's list, so that
+nodes run by the same operator can share what they have learned.
+
+Entries are written to a datastore, so that they survive restarts.
+*/
+package blocklist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// DefaultThreshold is the number of failed deals in a row that block a provider
+const DefaultThreshold = 3
+
+// DefaultFailureWindow is the period within which failures must fall to count
+// towards blocking a provider
+const DefaultFailureWindow = 24 * time.Hour
+
+// DefaultBlockDuration is how long a provider is blocked for when it is blocked
+// automatically
+const DefaultBlockDuration = 7 * 24 * time.Hour
+
+// ErrBlocked is returned when proposing a deal to a blocked provider
+var ErrBlocked = errors.New("provider is blocked")
+
+// Entry is a blocked provider
+type Entry struct {
+	Provider address.Address
+	Reason   string
+	// Manual is true for providers blocked by the operator, rather than for failed
+	// deals
+	Manual bool
+	Added  time.Time
+	// Expires is when the provider is unblocked, or zero if it stays blocked until
+	// it is unblocked by hand
+	Expires time.Time
+}
+
+// Expired returns true if the entry has expired at the given time
+func (e Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+type failures struct {
+	count int
+	first time.Time
+}
+
+// Blocklist is a storage client's list of blocked providers
+type Blocklist struct {
+	ds            datastore.Batching
+	threshold     int
+	window        time.Duration
+	blockDuration time.Duration
+	now           func() time.Time
+
+	lk       sync.Mutex
+	failures map[address.Address]failures
+}
+
+// Option configures a Blocklist
+type Option func(b *Blocklist)
+
+// Threshold sets the number of failed deals in a row, within window, that block a
+// provider. A threshold of zero turns off blocking providers automatically
+func Threshold(n int, window time.Duration) Option {
+	return func(b *Blocklist) {
+		b.threshold = n
+		b.window = window
+	}
+}
+
+// BlockDuration sets how long a provider is blocked for when it is blocked
+// automatically
+func BlockDuration(d time.Duration) Option {
+	return func(b *Blocklist) {
+		b.blockDuration = d
+	}
+}
+
+// New returns a Blocklist that keeps its entries in the given datastore
+func New(ds datastore.Batching, options ...Option) *Blocklist {
+	b := &Blocklist{
+		ds:            ds,
+		threshold:     DefaultThreshold,
+		window:        DefaultFailureWindow,
+		blockDuration: DefaultBlockDuration,
+		now:           time.Now,
+		failures:      make(map[address.Address]failures),
+	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+// Check returns an error wrapping ErrBlocked if the provider is blocked
+func (b *Blocklist) Check(provider address.Address) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	entry, ok, err := b.get(provider)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if entry.Expires.IsZero() {
+		return fmt.Errorf("%w: %s: %s", ErrBlocked, provider, entry.Reason)
+	}
+	return fmt.Errorf("%w until %s: %s: %s", ErrBlocked, entry.Expires.Format(time.RFC3339), provider, entry.Reason)
+}
+
+// RecordFailure counts a failed deal with the provider, and blocks the provider if
+// its deals have failed Threshold times in a row. It returns true if the provider
+// was blocked
+func (b *Blocklist) RecordFailure(provider address.Address, reason string) (bool, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if b.threshold <= 0 {
+		return false, nil
+	}
+	now := b.now()
+	f := b.failures[provider]
+	if f.count == 0 || now.Sub(f.first) > b.window {
+		f = failures{first: now}
+	}
+	f.count++
+	if f.count < b.threshold {
+		b.failures[provider] = f
+		return false, nil
+	}
+	delete(b.failures, provider)
+
+	if _, ok, err := b.get(provider); err != nil || ok {
+		return false, err
+	}
+	entry := Entry{
+		Provider: provider,
+		Reason:   fmt.Sprintf("%d deals failed in a row, the last with: %s", f.count, reason),
+		Added:    now,
+		Expires:  now.Add(b.blockDuration),
+	}
+	if err := b.put(entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecordSuccess starts the count of failed deals with the provider over
+func (b *Blocklist) RecordSuccess(provider address.Address) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	delete(b.failures, provider)
+}
+
+// Block blocks a provider by hand, until expires, or until it is unblocked if
+// expires is zero. It replaces any entry the provider already has
+func (b *Blocklist) Block(provider address.Address, reason string, expires time.Time) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.put(Entry{
+		Provider: provider,
+		Reason:   reason,
+		Manual:   true,
+		Added:    b.now(),
+		Expires:  expires,
+	})
+}
+
+// Unblock unblocks a provider, and clears its count of failed deals
+func (b *Blocklist) Unblock(provider address.Address) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	delete(b.failures, provider)
+	if err := b.ds.Delete(key(provider)); err != nil {
+		return xerrors.Errorf("unblocking provider %s: %w", provider, err)
+	}
+	return nil
+}
+
+// List returns the providers that are blocked, in the order they were blocked.
+// Expired entries are removed
+func (b *Blocklist) List() ([]Entry, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	results, err := b.ds.Query(query.Query{})
+	if err != nil {
+		return nil, xerrors.Errorf("listing blocked providers: %w", err)
+	}
+	defer results.Close() // nolint: errcheck
+
+	now := b.now()
+	var entries []Entry
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, xerrors.Errorf("listing blocked providers: %w", result.Error)
+		}
+		var entry Entry
+		if err := json.Unmarshal(result.Value, &entry); err != nil {
+			return nil, xerrors.Errorf("decoding blocked provider: %w", err)
+		}
+		if entry.Expired(now) {
+			if err := b.ds.Delete(datastore.NewKey(result.Key)); err != nil {
+				return nil, xerrors.Errorf("removing expired entry for provider %s: %w", entry.Provider, err)
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Added.Equal(entries[j].Added) {
+			return entries[i].Added.Before(entries[j].Added)
+		}
+		return entries[i].Provider.String() < entries[j].Provider.String()
+	})
+	return entries, nil
+}
+
+// Export returns the blocked providers, for importing into another client's list
+func (b *Blocklist) Export() ([]Entry, error) {
+	return b.List()
+}
+
+// Import adds exported entries to the list. A provider that is already blocked
+// keeps whichever of its entries expires later. Expired entries are skipped
+func (b *Blocklist) Import(entries []Entry) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	now := b.now()
+	for _, entry := range entries {
+		if entry.Expired(now) {
+			continue
+		}
+		existing, ok, err := b.get(entry.Provider)
+		if err != nil {
+			return err
+		}
+		if ok && (existing.Expires.IsZero() || (!entry.Expires.IsZero() && entry.Expires.Before(existing.Expires))) {
+			continue
+		}
+		if err := b.put(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the provider's entry, if it has one that has not expired
+func (b *Blocklist) get(provider address.Address) (Entry, bool, error) {
+	value, err := b.ds.Get(key(provider))
+	if err == datastore.ErrNotFound {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, xerrors.Errorf("reading entry for provider %s: %w", provider, err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return Entry{}, false, xerrors.Errorf("decoding entry for provider %s: %w", provider, err)
+	}
+	if entry.Expired(b.now()) {
+		return Entry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+func (b *Blocklist) put(entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := b.ds.Put(key(entry.Provider), value); err != nil {
+		return xerrors.Errorf("blocking provider %s: %w", entry.Provider, err)
+	}
+	return nil
+}
+
+func key(provider address.Address) datastore.Key {
+	return datastore.NewKey(provider.String())
+}
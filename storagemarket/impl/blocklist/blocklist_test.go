@@ -0,0 +1,100 @@
+package blocklist
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestBlocklist(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	newBlocklist := func(options ...Option) *Blocklist {
+		b := New(dss.MutexWrap(datastore.NewMapDatastore()), options...)
+		b.now = func() time.Time { return now }
+		return b
+	}
+
+	t.Run("blocks providers whose deals keep failing", func(t *testing.T) {
+		b := newBlocklist(Threshold(2, time.Hour), BlockDuration(24*time.Hour))
+		blocked, err := b.RecordFailure(provider, "first")
+		require.NoError(t, err)
+		require.False(t, blocked)
+		require.NoError(t, b.Check(provider))
+
+		blocked, err = b.RecordFailure(provider, "transfer failed")
+		require.NoError(t, err)
+		require.True(t, blocked)
+		err = b.Check(provider)
+		require.True(t, errors.Is(err, ErrBlocked))
+		require.EqualError(t, err, "provider is blocked until 2021-01-02T00:00:00Z: "+provider.String()+": 2 deals failed in a row, the last with: transfer failed")
+		require.NoError(t, b.Check(other))
+
+		// the entry expires
+		now = now.Add(24 * time.Hour)
+		require.NoError(t, b.Check(provider))
+		entries, err := b.List()
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("successes and old failures start the count over", func(t *testing.T) {
+		b := newBlocklist(Threshold(2, time.Hour))
+		_, err := b.RecordFailure(provider, "failed")
+		require.NoError(t, err)
+		b.RecordSuccess(provider)
+		blocked, err := b.RecordFailure(provider, "failed")
+		require.NoError(t, err)
+		require.False(t, blocked)
+
+		now = now.Add(2 * time.Hour)
+		blocked, err = b.RecordFailure(provider, "failed")
+		require.NoError(t, err)
+		require.False(t, blocked)
+	})
+
+	t.Run("blocks and unblocks by hand", func(t *testing.T) {
+		b := newBlocklist(Threshold(1, time.Hour))
+		require.NoError(t, b.Block(provider, "too slow", time.Time{}))
+		require.EqualError(t, b.Check(provider), "provider is blocked: "+provider.String()+": too slow")
+
+		// failures do not replace a manual entry
+		_, err := b.RecordFailure(provider, "failed")
+		require.NoError(t, err)
+		entries, err := b.List()
+		require.NoError(t, err)
+		require.Equal(t, []Entry{{Provider: provider, Reason: "too slow", Manual: true, Added: now}}, entries)
+
+		require.NoError(t, b.Unblock(provider))
+		require.NoError(t, b.Check(provider))
+	})
+
+	t.Run("imports exported entries", func(t *testing.T) {
+		from := newBlocklist()
+		require.NoError(t, from.Block(provider, "too slow", now.Add(time.Hour)))
+		require.NoError(t, from.Block(other, "bad data", time.Time{}))
+		exported, err := from.Export()
+		require.NoError(t, err)
+		require.Len(t, exported, 2)
+
+		to := newBlocklist()
+		require.NoError(t, to.Block(provider, "unreachable", now.Add(2*time.Hour)))
+		require.NoError(t, to.Import(exported))
+		entries, err := to.List()
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		// the entry that expires later is kept
+		require.Equal(t, "unreachable", entries[0].Reason)
+		require.Equal(t, "bad data", entries[1].Reason)
+	})
+}
@@ -0,0 +1,135 @@
+package storageimpl
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+// DefaultCapabilitiesCacheTTL is how long a client keeps the capabilities a provider
+// advertised before fetching them again
+const DefaultCapabilitiesCacheTTL = 10 * time.Minute
+
+// AdvertiseCapabilities sets the capabilities the provider advertises on the
+// capabilities protocol, in place of those derived from its configuration. Piece
+// sizes left at zero are filled in from the current ask
+func AdvertiseCapabilities(capabilities storagemarket.ProviderCapabilities) StorageProviderOption {
+	return func(p *Provider) {
+		p.capabilities = &capabilities
+	}
+}
+
+// CapabilitiesCacheTTL sets how long the client keeps the capabilities a provider
+// advertised before fetching them again
+func CapabilitiesCacheTTL(ttl time.Duration) StorageClientOption {
+	return func(c *Client) {
+		c.capabilitiesTTL = ttl
+	}
+}
+
+/*
+HandleCapabilitiesStream is called by the network implementation whenever a client
+opens a stream on the capabilities protocol
+
+The Provider writes the capabilities it advertises in a CapabilitiesResponse. Unless
+they were set with AdvertiseCapabilities, they are derived from the provider's
+configuration: the protocols its network handles, the transfer types its node
+supports, and the piece sizes of its current ask. There is no request, and the connection is kept open only as
+long as it takes to write the response.
+*/
+func (p *Provider) HandleCapabilitiesStream(s network.CapabilitiesStream) {
+	defer s.Close()
+	resp := network.CapabilitiesResponse{Capabilities: p.advertisedCapabilities()}
+	if err := s.WriteCapabilitiesResponse(resp); err != nil {
+		log.Errorf("failed to write capabilities response: %s", err)
+	}
+}
+
+func (p *Provider) advertisedCapabilities() storagemarket.ProviderCapabilities {
+	capabilities := p.configuredCapabilities()
+	if p.capabilities != nil {
+		capabilities = *p.capabilities
+	}
+	if ask := p.storedAsk.GetAsk(); ask != nil && ask.Ask != nil {
+		if capabilities.MinPieceSize == 0 {
+			capabilities.MinPieceSize = ask.Ask.MinPieceSize
+		}
+		if capabilities.MaxPieceSize == 0 {
+			capabilities.MaxPieceSize = ask.Ask.MaxPieceSize
+		}
+	}
+	return capabilities
+}
+
+// configuredCapabilities returns the capabilities of the provider as it is set up.
+// Deal data can always be sent with graphsync or imported manually, while deals that
+// reuse an existing piece need a node that can add them to sealed sectors. Payment is
+// only taken in FIL
+func (p *Provider) configuredCapabilities() storagemarket.ProviderCapabilities {
+	protocols := p.net.ProviderProtocols()
+	capabilities := storagemarket.ProviderCapabilities{
+		Protocols:     make([]string, 0, len(protocols)),
+		TransferTypes: []string{storagemarket.TTGraphsync, storagemarket.TTManual},
+		Currencies:    []string{"FIL"},
+	}
+	for _, protocol := range protocols {
+		capabilities.Protocols = append(capabilities.Protocols, string(protocol))
+	}
+	if _, ok := p.spn.(storagemarket.ExistingPieceNode); ok {
+		capabilities.TransferTypes = append(capabilities.TransferTypes, storagemarket.TTExistingPiece)
+	}
+	return capabilities
+}
+
+type cachedCapabilities struct {
+	capabilities storagemarket.ProviderCapabilities
+	fetched      time.Time
+}
+
+// GetProviderCapabilities returns the capabilities a provider advertises. They are
+// fetched on the capabilities protocol, then cached for the TTL set with
+// CapabilitiesCacheTTL
+func (c *Client) GetProviderCapabilities(ctx context.Context, info storagemarket.StorageProviderInfo) (*storagemarket.ProviderCapabilities, error) {
+	if capabilities, ok := c.cachedCapabilities(info.PeerID); ok {
+		return &capabilities, nil
+	}
+
+	if len(info.Addrs) > 0 {
+		c.net.AddAddrs(info.PeerID, info.Addrs)
+	}
+	s, err := c.net.NewCapabilitiesStream(ctx, info.PeerID)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open stream to miner: %w", err)
+	}
+	defer s.Close() // nolint
+
+	resp, err := s.ReadCapabilitiesResponse()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read capabilities response: %w", err)
+	}
+
+	c.capabilitiesLk.Lock()
+	c.capabilitiesCache[info.PeerID] = cachedCapabilities{capabilities: resp.Capabilities, fetched: time.Now()}
+	c.capabilitiesLk.Unlock()
+	return &resp.Capabilities, nil
+}
+
+func (c *Client) cachedCapabilities(p peer.ID) (storagemarket.ProviderCapabilities, bool) {
+	c.capabilitiesLk.Lock()
+	defer c.capabilitiesLk.Unlock()
+
+	cached, ok := c.capabilitiesCache[p]
+	if !ok {
+		return storagemarket.ProviderCapabilities{}, false
+	}
+	if time.Since(cached.fetched) > c.capabilitiesTTL {
+		delete(c.capabilitiesCache, p)
+		return storagemarket.ProviderCapabilities{}, false
+	}
+	return cached.capabilities, true
+}
@@ -3,13 +3,21 @@ package storageimpl
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 
@@ -23,17 +31,32 @@ import (
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-statemachine/fsm"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
+	"github.com/filecoin-project/go-fil-markets/dataprep"
 	discoveryimpl "github.com/filecoin-project/go-fil-markets/discovery/impl"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/carcheck"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/bandwidth"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blindedlabel"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blocklist"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/clientstates"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/clientutils"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/collateral"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrenewal"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealschedule"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dtutils"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/lifecycle"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/peerbinding"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
@@ -45,14 +68,18 @@ var log = logging.Logger("storagemarket_impl")
 const DefaultPollingInterval = 30 * time.Second
 
 var _ storagemarket.StorageClient = &Client{}
+var _ network.DealRestartReceiver = &Client{}
 
 // Client is the production implementation of the StorageClient interface
 type Client struct {
 	net network.StorageMarketNetwork
 
 	dataTransfer         datatransfer.Manager
+	transports           map[string]datatransfer.Manager
+	transferTypes        []string
 	multiStore           *multistore.MultiStore
 	discovery            *discoveryimpl.Local
+	bs                   blockstore.Blockstore
 	pio                  pieceio.PieceIO
 	node                 storagemarket.StorageClientNode
 	pubSub               *pubsub.PubSub
@@ -60,8 +87,41 @@ type Client struct {
 	statemachines        fsm.Group
 	migrateStateMachines func(context.Context) error
 	pollingInterval      time.Duration
-
-	unsubDataTransfer datatransfer.Unsubscribe
+	maxResubmissions     uint64
+	labelSecret          []byte
+	lifecycleHooks       storagemarket.DealLifecycleHooks
+	lifecycle            *lifecycle.Outbox
+	scheduler            *dealschedule.Scheduler
+	renewalPolicy        storagemarket.RenewalPolicy
+	renewalOptions       []dealrenewal.Option
+	renewals             *dealrenewal.Manager
+	blocklistEnabled     bool
+	blocklistOptions     []blocklist.Option
+	blocklist            *blocklist.Blocklist
+	renewalSub           *pubsub.PubSub
+	totalBandwidth       uint64
+	dealBandwidth        uint64
+	proposalSigner       storagemarket.ProposalSigner
+	signatureTimeout     time.Duration
+	checkCAR             bool
+	signPeerBinding      bool
+	dataPreparer         *dataprep.Preparer
+	logDeals             func(datastore.Batching) datastore.Batching
+
+	peerSignaturesLk sync.Mutex
+	peerSignatures   map[address.Address]*crypto.Signature
+
+	signatureLk     sync.Mutex
+	signatureTimers map[cid.Cid]*time.Timer
+
+	capabilitiesTTL   time.Duration
+	capabilitiesLk    sync.Mutex
+	capabilitiesCache map[peer.ID]cachedCapabilities
+
+	dealLimitsLk sync.RWMutex
+	dealLimits   storagemarket.ClientDealLimits
+
+	unsubDataTransfer []datatransfer.Unsubscribe
 }
 
 // StorageClientOption allows custom configuration of a storage client
@@ -75,6 +135,70 @@ func DealPollingInterval(t time.Duration) StorageClientOption {
 	}
 }
 
+// BlindDealLabels makes the client put a blinded commitment to the payload CID in the
+// label of each deal it proposes, instead of the payload CID itself. The commitment is
+// keyed with the given secret, and blindedlabel.Opening returns the value to reveal to
+// later prove which payload a deal stores
+func BlindDealLabels(secret []byte) StorageClientOption {
+	return func(c *Client) {
+		c.labelSecret = secret
+	}
+}
+
+// ResubmitRejectedProposals makes the client propose a deal again when the provider
+// rejects it for a transient reason and asks the client to retry later. The client
+// waits for as long as the provider asks, and proposes the same deal at most
+// maxResubmissions times before letting it fail. By default such deals fail at once
+func ResubmitRejectedProposals(maxResubmissions uint64) StorageClientOption {
+	return func(c *Client) {
+		c.maxResubmissions = maxResubmissions
+	}
+}
+
+// LifecycleHooks registers hooks that receive the lifecycle events of the client's
+// deals with at-least-once delivery. Undelivered events are kept in the client's
+// datastore, so this option only takes effect when passed to NewClient
+func LifecycleHooks(hooks storagemarket.DealLifecycleHooks) StorageClientOption {
+	return func(c *Client) {
+		c.lifecycleHooks = hooks
+	}
+}
+
+// TransferBandwidthLimit limits the rate, in bytes per second, at which the client
+// sends deal data to providers: totalRate for all deals together and dealRate for
+// each deal. Transfers that get ahead of a limit are paused until they are back
+// within it. A rate of zero does not limit. A client with several data transfer
+// transports limits each transport separately
+func TransferBandwidthLimit(totalRate uint64, dealRate uint64) StorageClientOption {
+	return func(c *Client) {
+		c.totalBandwidth = totalRate
+		c.dealBandwidth = dealRate
+	}
+}
+
+// CheckDeterministicCAR makes the client generate the piece commitment of a deal's
+// data twice more before signing the proposal, and refuse the deal unless both runs
+// write the same CAR and give the same piece CID as the proposal. It catches data that
+// would fail on the provider with a CommP mismatch, at the cost of reading the data
+// twice more for each deal. Deals with a manual transfer or an existing piece are not
+// checked, as the client may not have their data
+func CheckDeterministicCAR() StorageClientOption {
+	return func(c *Client) {
+		c.checkCAR = true
+	}
+}
+
+// SignPeerBinding makes the client send, with each proposal, a signature by the
+// deal's client address over the client's peer ID, so that providers that
+// authenticate proposing peers accept proposals for the address from this peer even
+// when it was bound to another one. The client node must be able to sign with the
+// client address
+func SignPeerBinding() StorageClientOption {
+	return func(c *Client) {
+		c.signPeerBinding = true
+	}
+}
+
 // NewClient creates a new storage client
 func NewClient(
 	net network.StorageMarketNetwork,
@@ -91,22 +215,33 @@ func NewClient(
 	c := &Client{
 		net:             net,
 		dataTransfer:    dataTransfer,
+		transports:      map[string]datatransfer.Manager{storagemarket.TTGraphsync: dataTransfer},
+		transferTypes:   []string{storagemarket.TTGraphsync},
 		multiStore:      multiStore,
 		discovery:       discovery,
 		node:            scn,
+		bs:              bs,
 		pio:             pio,
 		pubSub:          pubsub.New(clientDispatcher),
 		readySub:        pubsub.New(shared.ReadyDispatcher),
+		renewalSub:      pubsub.New(renewalDispatcher),
 		pollingInterval: DefaultPollingInterval,
+		signatureTimers: make(map[cid.Cid]*time.Timer),
+
+		capabilitiesTTL:   DefaultCapabilitiesCacheTTL,
+		capabilitiesCache: make(map[peer.ID]cachedCapabilities),
 	}
+	c.Configure(options...)
+
 	storageMigrations, err := migrations.ClientMigrations.Build()
 	if err != nil {
 		return nil, err
 	}
 	c.statemachines, c.migrateStateMachines, err = newClientStateMachine(
-		ds,
+		dealsDatastore(ds, c.logDeals),
 		&clientDealEnvironment{c},
 		c.dispatch,
+		c.recordingLifecycle(clientstates.ClientStateEntryFuncs),
 		storageMigrations,
 		versioning.VersionKey("1"),
 	)
@@ -114,27 +249,94 @@ func NewClient(
 		return nil, err
 	}
 
-	c.Configure(options...)
-
-	// register a data transfer event handler -- this will send events to the state machines based on DT events
-	c.unsubDataTransfer = dataTransfer.SubscribeToEvents(dtutils.ClientDataTransferSubscriber(c.statemachines))
+	if c.lifecycleHooks != nil {
+		c.lifecycle, err = lifecycle.New(namespace.Wrap(ds, datastore.NewKey("lifecycle-outbox")), c.lifecycleHooks)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err = dataTransfer.RegisterVoucherType(&requestvalidation.StorageDataTransferVoucher{}, requestvalidation.NewUnifiedRequestValidator(nil, &clientPullDeals{c}))
+	c.scheduler, err = dealschedule.New(namespace.Wrap(ds, datastore.NewKey("scheduled-deals")), c.proposeScheduledDeal, c.chainEpoch)
 	if err != nil {
 		return nil, err
 	}
 
-	err = dataTransfer.RegisterTransportConfigurer(&requestvalidation.StorageDataTransferVoucher{}, dtutils.TransportConfigurer(&clientStoreGetter{c}))
-	if err != nil {
-		return nil, err
+	if c.blocklistEnabled {
+		c.blocklist = blocklist.New(namespace.Wrap(ds, datastore.NewKey("provider-blocklist")), c.blocklistOptions...)
+	}
+
+	if c.renewalPolicy != nil {
+		c.renewals = dealrenewal.New(namespace.Wrap(ds, datastore.NewKey("deal-renewals")), c.renewalPolicy,
+			c.ListLocalDeals, c.chainEpoch, c.proposeScheduledDeal, c.notifyRenewal, c.renewalOptions...)
+	}
+
+	for _, transferType := range c.transferTypes {
+		if err := c.registerTransport(c.transports[transferType]); err != nil {
+			return nil, xerrors.Errorf("registering %s transport: %w", transferType, err)
+		}
 	}
 
 	return c, nil
 }
 
+// registerTransport subscribes the client to a data transfer manager's events and
+// registers the client's voucher type with it
+func (c *Client) registerTransport(dataTransfer datatransfer.Manager) error {
+	// register a data transfer event handler -- this will send events to the state machines based on DT events
+	c.unsubDataTransfer = append(c.unsubDataTransfer, dataTransfer.SubscribeToEvents(dtutils.ClientDataTransferSubscriber(c.statemachines)))
+	if c.totalBandwidth > 0 || c.dealBandwidth > 0 {
+		limiter := bandwidth.New(dataTransfer, c.totalBandwidth, c.dealBandwidth)
+		c.unsubDataTransfer = append(c.unsubDataTransfer, dataTransfer.SubscribeToEvents(dtutils.ClientBandwidthSubscriber(limiter)))
+	}
+
+	err := dataTransfer.RegisterVoucherType(&requestvalidation.StorageDataTransferVoucher{}, requestvalidation.NewUnifiedRequestValidator(nil, &clientPullDeals{c}))
+	if err != nil {
+		return err
+	}
+
+	return dataTransfer.RegisterTransportConfigurer(&requestvalidation.StorageDataTransferVoucher{}, dtutils.TransportConfigurer(&clientStoreGetter{c}))
+}
+
 // Start initializes deal processing on a StorageClient, runs migrations and restarts
 // in progress deals
+// peerSignature returns the client address's signature binding this client's peer
+// to it, or nil if the client does not sign peer bindings
+func (c *Client) peerSignature(ctx context.Context, client address.Address) (*crypto.Signature, error) {
+	if !c.signPeerBinding {
+		return nil, nil
+	}
+	c.peerSignaturesLk.Lock()
+	defer c.peerSignaturesLk.Unlock()
+	if sig, ok := c.peerSignatures[client]; ok {
+		return sig, nil
+	}
+	sig, err := c.node.SignBytes(ctx, client, peerbinding.SigningBytes(c.net.ID()))
+	if err != nil {
+		return nil, xerrors.Errorf("signing peer binding: %w", err)
+	}
+	if c.peerSignatures == nil {
+		c.peerSignatures = make(map[address.Address]*crypto.Signature)
+	}
+	c.peerSignatures[client] = sig
+	return sig, nil
+}
+
 func (c *Client) Start(ctx context.Context) error {
+	err := c.net.SetDealRestartDelegate(c)
+	if err != nil {
+		return err
+	}
+	err = c.net.SetDealNotificationDelegate(c)
+	if err != nil {
+		return err
+	}
+	if c.lifecycle != nil {
+		c.lifecycle.Start(ctx)
+	}
+	c.scheduler.Start(ctx)
+	if c.renewals != nil {
+		c.renewals.Start(ctx)
+	}
 	go func() {
 		err := c.start(ctx)
 		if err != nil {
@@ -151,7 +353,17 @@ func (c *Client) OnReady(ready shared.ReadyFunc) {
 
 // Stop ends deal processing on a StorageClient
 func (c *Client) Stop() error {
-	c.unsubDataTransfer()
+	for _, unsub := range c.unsubDataTransfer {
+		unsub()
+	}
+	if c.lifecycle != nil {
+		c.lifecycle.Stop()
+	}
+	c.scheduler.Stop()
+	if c.renewals != nil {
+		c.renewals.Stop()
+	}
+	c.stopSignatureTimers()
 	return c.statemachines.Stop(context.TODO())
 }
 
@@ -172,6 +384,12 @@ func (c *Client) ListProviders(ctx context.Context) (<-chan storagemarket.Storag
 	go func() {
 		defer close(out)
 		for _, p := range providers {
+			if err := c.checkBlocklist(p.Address); err != nil {
+				if !xerrors.Is(err, blocklist.ErrBlocked) {
+					log.Warnf("checking blocklist for provider %s: %s", p.Address, err)
+				}
+				continue
+			}
 			select {
 			case out <- *p:
 			case <-ctx.Done():
@@ -192,6 +410,24 @@ func (c *Client) ListLocalDeals(ctx context.Context) ([]storagemarket.ClientDeal
 	return out, nil
 }
 
+// DealStateSnapshot returns the client deal state machine definition along
+// with the number of deals currently in each state
+func (c *Client) DealStateSnapshot() (fsmexport.Snapshot, error) {
+	def, err := fsmexport.NewDefinition(ClientFSMParameterSpec, storagemarket.DealStates, storagemarket.ClientEvents, []fsm.StateKey{storagemarket.StorageDealUnknown})
+	if err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	var deals []storagemarket.ClientDeal
+	if err := c.statemachines.List(&deals); err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	current := make([]fsm.StateKey, 0, len(deals))
+	for _, deal := range deals {
+		current = append(current, deal.State)
+	}
+	return fsmexport.NewSnapshot(def, current), nil
+}
+
 // GetLocalDeal lists deals that are in progress or rejected
 func (c *Client) GetLocalDeal(ctx context.Context, cid cid.Cid) (storagemarket.ClientDeal, error) {
 	var out storagemarket.ClientDeal
@@ -251,7 +487,10 @@ func (c *Client) GetAsk(ctx context.Context, info storagemarket.StorageProviderI
 	return out.Ask.Ask, nil
 }
 
-// GetProviderDealState queries a provider for the current state of a client's deal
+// GetProviderDealState queries a provider for the current state of a client's deal.
+// It asks on the deal status protocol the provider last answered on, and falls back
+// to the previous version of the protocol if the provider cannot answer on the
+// current one. The protocol that was answered on is recorded in the deal
 func (c *Client) GetProviderDealState(ctx context.Context, proposalCid cid.Cid) (*storagemarket.ProviderDealState, error) {
 	var deal storagemarket.ClientDeal
 	err := c.statemachines.Get(proposalCid).Get(&deal)
@@ -259,40 +498,66 @@ func (c *Client) GetProviderDealState(ctx context.Context, proposalCid cid.Cid)
 		return nil, xerrors.Errorf("could not get client deal state: %w", err)
 	}
 
-	s, err := c.net.NewDealStatusStream(ctx, deal.Miner)
+	var protocols []protocol.ID
+	if deal.DealStatusProtocol == storagemarket.OldDealStatusProtocolID {
+		protocols = []protocol.ID{storagemarket.OldDealStatusProtocolID, storagemarket.DealStatusProtocolID}
+	}
+	resp, proto, err := c.queryDealStatus(ctx, deal, proposalCid, protocols)
+	if err != nil && proto == storagemarket.DealStatusProtocolID {
+		log.Warnf("querying deal %s status on %s failed, retrying on %s: %s", proposalCid, proto, storagemarket.OldDealStatusProtocolID, err)
+		resp, proto, err = c.queryDealStatus(ctx, deal, proposalCid, []protocol.ID{storagemarket.OldDealStatusProtocolID})
+	}
 	if err != nil {
-		return nil, xerrors.Errorf("failed to open stream to miner: %w", err)
+		return nil, err
+	}
+
+	if string(proto) != deal.DealStatusProtocol {
+		if err := c.statemachines.Send(proposalCid, storagemarket.ClientEventDealStatusProtocol, string(proto)); err != nil {
+			log.Warnf("recording deal status protocol of deal %s: %s", proposalCid, err)
+		}
 	}
+	return resp, nil
+}
+
+// queryDealStatus asks a provider for the state of a deal on the first of the given
+// protocols it supports. It returns the protocol the stream was opened on, with an
+// error only if the provider failed to answer on it
+func (c *Client) queryDealStatus(ctx context.Context, deal storagemarket.ClientDeal, proposalCid cid.Cid, protocols []protocol.ID) (*storagemarket.ProviderDealState, protocol.ID, error) {
+	s, err := c.net.NewDealStatusStream(ctx, deal.Miner, protocols...)
+	if err != nil {
+		return nil, "", xerrors.Errorf("failed to open stream to miner: %w", err)
+	}
+	defer s.Close() // nolint: errcheck
 
 	buf, err := cborutil.Dump(&deal.ProposalCid)
 	if err != nil {
-		return nil, xerrors.Errorf("failed serialize deal status request: %w", err)
+		return nil, "", xerrors.Errorf("failed serialize deal status request: %w", err)
 	}
 
 	signature, err := c.node.SignBytes(ctx, deal.Proposal.Client, buf)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to sign deal status request: %w", err)
+		return nil, "", xerrors.Errorf("failed to sign deal status request: %w", err)
 	}
 
 	if err := s.WriteDealStatusRequest(network.DealStatusRequest{Proposal: proposalCid, Signature: *signature}); err != nil {
-		return nil, xerrors.Errorf("failed to send deal status request: %w", err)
+		return nil, s.Protocol(), xerrors.Errorf("failed to send deal status request: %w", err)
 	}
 
 	resp, origBytes, err := s.ReadDealStatusResponse()
 	if err != nil {
-		return nil, xerrors.Errorf("failed to read deal status response: %w", err)
+		return nil, s.Protocol(), xerrors.Errorf("failed to read deal status response: %w", err)
 	}
 
 	valid, err := c.verifyStatusResponseSignature(ctx, deal.MinerWorker, resp, origBytes)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if !valid {
-		return nil, xerrors.Errorf("invalid deal status response signature")
+		return nil, "", xerrors.Errorf("invalid deal status response signature")
 	}
 
-	return &resp.DealState, nil
+	return &resp.DealState, s.Protocol(), nil
 }
 
 /*
@@ -321,6 +586,10 @@ a merkle root for the resulting data)
 
 8. Records the Provider as a possible peer for retrieving this data in the future
 
+A client configured with `AsyncProposalSigning` does not sign the proposal in step 3. Instead it tracks the deal
+by the CID of the unsigned proposal and triggers a `ClientEventAwaitSignature` event, which asks the proposal
+signer for a signature. The signed deal is opened by `SubmitDealSignature`.
+
 From then on, the statemachine controls the deal flow in the client. Other components may listen for events in this flow by calling
 `SubscribeToEvents` on the Client. The Client also provides access to the node and network and other functionality through
 its implementation of the Client FSM's ClientDealEnvironment.
@@ -328,29 +597,53 @@ its implementation of the Client FSM's ClientDealEnvironment.
 Documentation of the client state machine can be found at https://godoc.org/github.com/filecoin-project/go-fil-markets/storagemarket/impl/clientstates
 */
 func (c *Client) ProposeStorageDeal(ctx context.Context, params storagemarket.ProposeStorageDealParams) (*storagemarket.ProposeStorageDealResult, error) {
+	if err := c.checkBlocklist(params.Info.Address); err != nil {
+		return nil, err
+	}
+
 	err := c.addMultiaddrs(ctx, params.Info.Address)
 	if err != nil {
 		return nil, xerrors.Errorf("looking up addresses: %w", err)
 	}
 
-	commP, pieceSize, err := clientutils.CommP(ctx, c.pio, params.Rt, params.Data, params.StoreID)
+	params.Data, err = c.selectTransferType(ctx, params.Info, params.Data)
+	if err != nil {
+		return nil, err
+	}
+	if params.Data.TransferAgent != "" && (params.Data.TransferType == storagemarket.TTManual || params.Data.TransferType == storagemarket.TTExistingPiece) {
+		return nil, xerrors.Errorf("a transfer agent cannot send data for a deal with transfer type %s", params.Data.TransferType)
+	}
+
+	commP, pieceSize, payloadSize, err := clientutils.CommPWithPayloadSize(ctx, c.pio, params.Rt, params.Data, params.StoreID)
 	if err != nil {
 		return nil, xerrors.Errorf("computing commP failed: %w", err)
 	}
 
+	if c.checkCAR && params.Data.TransferType != storagemarket.TTManual && params.Data.TransferType != storagemarket.TTExistingPiece && params.Data.TransferAgent == "" {
+		if _, _, err := carcheck.VerifyCommP(c.pio.GeneratePieceCommitment, params.Rt, params.Data.Root, selectors.Entire(), params.StoreID, &commP); err != nil {
+			return nil, xerrors.Errorf("checking deal data: %w", err)
+		}
+	}
+
 	if uint64(pieceSize.Padded()) > params.Info.SectorSize {
 		return nil, fmt.Errorf("cannot propose a deal whose piece size (%d) is greater than sector size (%d)", pieceSize.Padded(), params.Info.SectorSize)
 	}
 
-	pcMin := params.Collateral
-	if pcMin.Int == nil || pcMin.IsZero() {
-		pcMin, _, err = c.node.DealProviderCollateralBounds(ctx, pieceSize.Padded(), params.VerifiedDeal)
-		if err != nil {
-			return nil, xerrors.Errorf("computing deal provider collateral bound failed: %w", err)
-		}
+	if err := clientutils.CheckDealLimits(c.DealLimits(), params, pieceSize.Padded()); err != nil {
+		return nil, xerrors.Errorf("deal refused by client limits: %w", err)
 	}
 
-	label, err := clientutils.LabelField(params.Data.Root)
+	providerCollateral, err := c.providerCollateral(ctx, params, pieceSize.Padded())
+	if err != nil {
+		return nil, err
+	}
+
+	var label string
+	if len(c.labelSecret) > 0 {
+		label, _, err = blindedlabel.New(c.labelSecret, params.Data.Root)
+	} else {
+		label, err = clientutils.LabelField(params.Data.Root)
+	}
 	if err != nil {
 		return nil, xerrors.Errorf("creating label field in proposal: %w", err)
 	}
@@ -364,24 +657,13 @@ func (c *Client) ProposeStorageDeal(ctx context.Context, params storagemarket.Pr
 		StartEpoch:           params.StartEpoch,
 		EndEpoch:             params.EndEpoch,
 		StoragePricePerEpoch: params.Price,
-		ProviderCollateral:   pcMin,
+		ProviderCollateral:   providerCollateral,
 		ClientCollateral:     big.Zero(),
 		VerifiedDeal:         params.VerifiedDeal,
 	}
 
-	clientDealProposal, err := c.node.SignProposal(ctx, params.Addr, dealProposal)
-	if err != nil {
-		return nil, xerrors.Errorf("signing deal proposal failed: %w", err)
-	}
-
-	proposalNd, err := cborutil.AsIpld(clientDealProposal)
-	if err != nil {
-		return nil, xerrors.Errorf("getting proposal node failed: %w", err)
-	}
-
 	deal := &storagemarket.ClientDeal{
-		ProposalCid:        proposalNd.Cid(),
-		ClientDealProposal: *clientDealProposal,
+		ClientDealProposal: market.ClientDealProposal{Proposal: dealProposal},
 		State:              storagemarket.StorageDealUnknown,
 		Miner:              params.Info.PeerID,
 		MinerWorker:        params.Info.Worker,
@@ -389,16 +671,18 @@ func (c *Client) ProposeStorageDeal(ctx context.Context, params storagemarket.Pr
 		FastRetrieval:      params.FastRetrieval,
 		StoreID:            params.StoreID,
 		CreationTime:       curTime(),
+		Invoice:            params.Invoice,
+		Envelope:           params.Envelope,
+		PayloadSize:        payloadSize,
 	}
 
-	err = c.statemachines.Begin(proposalNd.Cid(), deal)
-	if err != nil {
-		return nil, xerrors.Errorf("setting up deal tracking: %w", err)
+	if c.proposalSigner != nil {
+		err = c.proposePendingSignature(deal)
+	} else {
+		err = c.proposeSigned(ctx, deal)
 	}
-
-	err = c.statemachines.Send(deal.ProposalCid, storagemarket.ClientEventOpen)
 	if err != nil {
-		return nil, xerrors.Errorf("initializing state machine: %w", err)
+		return nil, err
 	}
 
 	return &storagemarket.ProposeStorageDealResult{
@@ -410,6 +694,280 @@ func (c *Client) ProposeStorageDeal(ctx context.Context, params storagemarket.Pr
 		})
 }
 
+// proposeSigned signs a deal's proposal with the node and hands the deal to the client FSM
+func (c *Client) proposeSigned(ctx context.Context, deal *storagemarket.ClientDeal) error {
+	clientDealProposal, err := c.node.SignProposal(ctx, deal.Proposal.Client, deal.Proposal)
+	if err != nil {
+		return xerrors.Errorf("signing deal proposal failed: %w", err)
+	}
+
+	proposalNd, err := cborutil.AsIpld(clientDealProposal)
+	if err != nil {
+		return xerrors.Errorf("getting proposal node failed: %w", err)
+	}
+
+	deal.ProposalCid = proposalNd.Cid()
+	deal.ClientDealProposal = *clientDealProposal
+	return c.beginDeal(deal, storagemarket.ClientEventOpen)
+}
+
+// beginDeal begins tracking a deal and starts its state machine with the given event
+func (c *Client) beginDeal(deal *storagemarket.ClientDeal, event storagemarket.ClientEvent) error {
+	err := c.statemachines.Begin(deal.ProposalCid, deal)
+	if err != nil {
+		return xerrors.Errorf("setting up deal tracking: %w", err)
+	}
+
+	err = c.statemachines.Send(deal.ProposalCid, event)
+	if err != nil {
+		return xerrors.Errorf("initializing state machine: %w", err)
+	}
+	return nil
+}
+
+// providerCollateral returns the provider collateral to propose for a deal, chosen from
+// the bounds the node reports are allowed on chain with the strategy in the params
+func (c *Client) providerCollateral(ctx context.Context, params storagemarket.ProposeStorageDealParams, pieceSize abi.PaddedPieceSize) (abi.TokenAmount, error) {
+	pcMin, pcMax, err := c.node.DealProviderCollateralBounds(ctx, pieceSize, params.VerifiedDeal)
+	if err != nil {
+		return abi.TokenAmount{}, xerrors.Errorf("computing deal provider collateral bound failed: %w", err)
+	}
+	return collateral.ProposalCollateral(params, pcMin, pcMax)
+}
+
+// EstimateDealCost computes the piece for a deal as ProposeStorageDeal would, and returns a
+// breakdown of what the deal is expected to cost the client, including the fee of the
+// message reserving its escrow and, for verified deals, the datacap it would use
+func (c *Client) EstimateDealCost(ctx context.Context, params storagemarket.ProposeStorageDealParams) (*storagemarket.DealCostEstimate, error) {
+	_, pieceSize, payloadSize, err := clientutils.CommPWithPayloadSize(ctx, c.pio, params.Rt, params.Data, params.StoreID)
+	if err != nil {
+		return nil, xerrors.Errorf("computing commP failed: %w", err)
+	}
+
+	if uint64(pieceSize.Padded()) > params.Info.SectorSize {
+		return nil, fmt.Errorf("cannot propose a deal whose piece size (%d) is greater than sector size (%d)", pieceSize.Padded(), params.Info.SectorSize)
+	}
+
+	providerCollateral, err := c.providerCollateral(ctx, params, pieceSize.Padded())
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := market.DealProposal{
+		PieceSize:            pieceSize.Padded(),
+		Client:               params.Addr,
+		Provider:             params.Info.Address,
+		StartEpoch:           params.StartEpoch,
+		EndEpoch:             params.EndEpoch,
+		StoragePricePerEpoch: params.Price,
+		ProviderCollateral:   providerCollateral,
+		ClientCollateral:     big.Zero(),
+		VerifiedDeal:         params.VerifiedDeal,
+	}
+	escrow := proposal.ClientBalanceRequirement()
+
+	tok, _, err := c.node.GetChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	balance, err := c.node.GetBalance(ctx, params.Addr, tok)
+	if err != nil {
+		return nil, xerrors.Errorf("getting escrow balance: %w", err)
+	}
+
+	fee, err := c.node.EstimateReserveFundsFee(ctx, params.Addr, params.Addr, escrow)
+	if err != nil {
+		return nil, xerrors.Errorf("estimating reserve funds fee: %w", err)
+	}
+
+	estimate := &storagemarket.DealCostEstimate{
+		PieceSize:          proposal.PieceSize,
+		PayloadSize:        payloadSize,
+		StorageCost:        proposal.TotalStorageFee(),
+		ClientCollateral:   proposal.ClientCollateral,
+		ProviderCollateral: proposal.ProviderCollateral,
+		EscrowRequired:     escrow,
+		EscrowBalance:      balance,
+		ReserveFundsFee:    fee,
+		Total:              big.Add(escrow, fee),
+		DataCap:            big.Zero(),
+	}
+
+	if params.VerifiedDeal {
+		estimate.DataCap = abi.NewStoragePower(int64(proposal.PieceSize))
+		estimate.DataCapAvailable, err = c.node.GetDataCap(ctx, params.Addr, tok)
+		if err != nil {
+			return nil, xerrors.Errorf("getting datacap: %w", err)
+		}
+	}
+
+	return estimate, nil
+}
+
+// EstimatePadding returns how much larger than its data the piece of a deal for the
+// given data ref would be, so that users can see why a deal is priced on more bytes
+// than their file before they propose it. It only reads the blocks of the data to size
+// the CAR, rather than computing the piece commitment
+func (c *Client) EstimatePadding(ctx context.Context, data *storagemarket.DataRef, storeID *multistore.StoreID) (*storagemarket.PaddingEstimate, error) {
+	return clientutils.EstimatePadding(c.pio, data, storeID)
+}
+
+// ProposeShardedStorageDeal splits a payload too large for one of the provider's sectors
+// into shards, using the dagsharding package, and proposes a deal with the same terms for
+// each shard. The shards are proposed in order, and if one fails the result holds the
+// manifest and the proposals made so far, so the caller can retry the rest. The manifest
+// is needed to retrieve and reassemble the payload, and must be kept by the caller
+func (c *Client) ProposeShardedStorageDeal(ctx context.Context, params storagemarket.ProposeStorageDealParams) (*storagemarket.ProposeShardedStorageDealResult, error) {
+	if params.Data == nil || params.Data.TransferType != storagemarket.TTGraphsync {
+		return nil, xerrors.New("sharded deals must transfer their payload with graphsync")
+	}
+	if params.Data.PieceCid != nil {
+		return nil, xerrors.New("sharded deals cannot set a piece CID for their payload")
+	}
+
+	dag, err := c.dagForStore(params.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := dagsharding.Split(ctx, dag, params.Data.Root, maxShardSize(params.Info.SectorSize))
+	if err != nil {
+		return nil, xerrors.Errorf("splitting payload: %w", err)
+	}
+
+	result := &storagemarket.ProposeShardedStorageDealResult{Manifest: manifest}
+	for i, shard := range manifest.Shards {
+		shardParams := params
+		shardParams.Data = &storagemarket.DataRef{
+			TransferType: params.Data.TransferType,
+			Root:         shard.Root,
+		}
+		res, err := c.ProposeStorageDeal(ctx, shardParams)
+		if err != nil {
+			return result, xerrors.Errorf("proposing deal for shard %d (%s): %w", i, shard.Root, err)
+		}
+		result.ProposalCids = append(result.ProposalCids, res.ProposalCid)
+	}
+	return result, nil
+}
+
+// dagForStore returns the DAG service for the store a deal's payload is in, or for the
+// client's blockstore if no store is given
+func (c *Client) dagForStore(storeID *multistore.StoreID) (ipldformat.DAGService, error) {
+	if storeID == nil {
+		return merkledag.NewDAGService(blockservice.New(c.bs, offline.Exchange(c.bs))), nil
+	}
+	store, err := c.multiStore.Get(*storeID)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open store %d: %w", *storeID, err)
+	}
+	return store.DAG, nil
+}
+
+// maxShardSize is the most payload bytes to put in a shard for a sector of the given
+// size. Sector capacity is lost to fr32 padding, and some is left for the CAR framing
+// each block of the shard is written with
+func maxShardSize(sectorSize uint64) uint64 {
+	unpadded := uint64(abi.PaddedPieceSize(sectorSize).Unpadded())
+	return unpadded - unpadded/shardFramingAllowance
+}
+
+// shardFramingAllowance sets aside one part in this many of a sector for CAR framing
+const shardFramingAllowance = 16
+
+// ScheduleStorageDeal saves a deal proposal to be sent to a Storage Provider once the
+// schedule is reached, and returns the ID of the scheduled deal. The proposal is built
+// and signed when it is sent, using the provider's miner info at that time, so the
+// data for the deal only needs to be ready by then. Scheduled deals survive restarts
+// of the client. A proposal that cannot be sent is retried until it succeeds or the
+// scheduled deal is cancelled
+func (c *Client) ScheduleStorageDeal(ctx context.Context, params storagemarket.ProposeStorageDealParams, schedule storagemarket.DealSchedule) (uint64, error) {
+	if params.Info == nil {
+		return 0, xerrors.New("scheduled deal must have a provider")
+	}
+	if params.Data == nil {
+		return 0, xerrors.New("scheduled deal must have a data reference")
+	}
+
+	// a schedule without a time can be sent at any time
+	notBefore := time.Unix(0, 0)
+	if !schedule.NotBefore.IsZero() {
+		notBefore = schedule.NotBefore
+	}
+
+	return c.scheduler.Schedule(storagemarket.ScheduledDeal{
+		Client:         params.Addr,
+		Provider:       params.Info.Address,
+		Data:           params.Data,
+		StartEpoch:     params.StartEpoch,
+		EndEpoch:       params.EndEpoch,
+		Price:          params.Price,
+		Collateral:     params.Collateral,
+		Rt:             params.Rt,
+		FastRetrieval:  params.FastRetrieval,
+		VerifiedDeal:   params.VerifiedDeal,
+		StoreID:        params.StoreID,
+		NotBefore:      cbg.CborTime(notBefore.UTC()),
+		NotBeforeEpoch: schedule.NotBeforeEpoch,
+		Invoice:        params.Invoice,
+		Envelope:       params.Envelope,
+	})
+}
+
+// ListScheduledDeals lists the deal proposals the client has scheduled. Deals that
+// have been sent have their ProposalCid set
+func (c *Client) ListScheduledDeals(ctx context.Context) ([]storagemarket.ScheduledDeal, error) {
+	return c.scheduler.List()
+}
+
+// CancelScheduledDeal removes a scheduled deal proposal, so that it is not sent. It
+// does not affect a deal that was already proposed
+func (c *Client) CancelScheduledDeal(ctx context.Context, id uint64) error {
+	return c.scheduler.Cancel(id)
+}
+
+// proposeScheduledDeal sends the proposal for a scheduled deal that is due
+func (c *Client) proposeScheduledDeal(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error) {
+	tok, _, err := c.node.GetChainHead(ctx)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting chain head: %w", err)
+	}
+	info, err := c.node.GetMinerInfo(ctx, deal.Provider, tok)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("looking up provider %s: %w", deal.Provider, err)
+	}
+
+	result, err := c.ProposeStorageDeal(ctx, storagemarket.ProposeStorageDealParams{
+		Addr:          deal.Client,
+		Info:          info,
+		Data:          deal.Data,
+		StartEpoch:    deal.StartEpoch,
+		EndEpoch:      deal.EndEpoch,
+		Price:         deal.Price,
+		Collateral:    deal.Collateral,
+		Rt:            deal.Rt,
+		FastRetrieval: deal.FastRetrieval,
+		VerifiedDeal:  deal.VerifiedDeal,
+		StoreID:       deal.StoreID,
+		Invoice:       deal.Invoice,
+		Envelope:      deal.Envelope,
+	})
+	if result == nil {
+		return cid.Undef, err
+	}
+	// the deal was started even if the provider could not be recorded as a
+	// retrieval peer, so it must not be proposed again
+	if err != nil {
+		log.Warnf("recording provider of scheduled deal %d for retrieval: %s", deal.ID, err)
+	}
+	return result.ProposalCid, nil
+}
+
+func (c *Client) chainEpoch(ctx context.Context) (abi.ChainEpoch, error) {
+	_, epoch, err := c.node.GetChainHead(ctx)
+	return epoch, err
+}
+
 func curTime() cbg.CborTime {
 	now := time.Now()
 	return cbg.CborTime(time.Unix(0, now.UnixNano()).UTC())
@@ -525,6 +1083,117 @@ func (c *Client) dispatch(eventName fsm.EventName, deal fsm.StateType) {
 	if err := c.pubSub.Publish(pubSubEvt); err != nil {
 		log.Errorf("failed to publish event %d", evt)
 	}
+
+	c.recordProviderOutcome(evt, realDeal)
+}
+
+// recordingLifecycle wraps the client's state entry funcs so a deal entering a state
+// that marks a lifecycle stage is recorded in the lifecycle outbox before the state
+// is handled. Entry funcs run again for deals that are not final when the client
+// restarts, so a stage is recorded even if the client stopped right after the deal
+// entered its state
+func (c *Client) recordingLifecycle(entryFuncs fsm.StateEntryFuncs) fsm.StateEntryFuncs {
+	wrapped := make(fsm.StateEntryFuncs, len(entryFuncs))
+	for state, entryFunc := range entryFuncs {
+		wrapped[state] = entryFunc
+	}
+	for _, state := range lifecycle.States {
+		handler, _ := entryFuncs[state].(func(fsm.Context, clientstates.ClientDealEnvironment, storagemarket.ClientDeal) error)
+		wrapped[state] = func(ctx fsm.Context, environment clientstates.ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+			if c.lifecycle != nil {
+				if err := c.lifecycle.Record(deal); err != nil {
+					log.Errorf("failed to record deal lifecycle event: %s", err)
+				}
+			}
+			if handler == nil {
+				return nil
+			}
+			return handler(ctx, environment, deal)
+		}
+	}
+	return wrapped
+}
+
+/*
+HandleDealRestartStream is called by the network implementation whenever a provider
+that restarted sends its view of a deal on the deal restart protocol
+
+A Client handling a `DealRestartRequest` does the following:
+
+1. Loads the deal from the Client FSM and checks the request came from the deal's provider
+
+2. Writes its own view of the deal onto the DealRestartStream. If it has no record
+of the deal, the view has the state StorageDealUnknown
+
+3. Reconciles the provider's view with its own. It fails the deal if it cannot be
+resumed, and restarts the data transfer if both parties were mid transfer
+
+The connection is kept open only as long as the request-response exchange.
+*/
+func (c *Client) HandleDealRestartStream(s network.DealRestartStream) {
+	ctx := context.TODO()
+	defer s.Close()
+	request, err := s.ReadDealRestartRequest()
+	if err != nil {
+		log.Errorf("failed to read DealRestartRequest from incoming stream: %s", err)
+		return
+	}
+
+	var deal storagemarket.ClientDeal
+	found := c.statemachines.Get(request.View.Proposal).Get(&deal) == nil
+	if found && deal.Miner != s.RemotePeer() {
+		log.Errorf("deal restart request for deal %s from peer %s, which is not the deal provider", deal.ProposalCid, s.RemotePeer())
+		return
+	}
+
+	view := network.DealView{Proposal: request.View.Proposal}
+	if found {
+		view = c.dealView(ctx, deal)
+	}
+	if err := s.WriteDealRestartResponse(network.DealRestartResponse{View: view}); err != nil {
+		log.Warnf("failed to write deal restart response: %s", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	resolution, reason := dealrestart.Reconcile(view, request.View)
+	log.Infof("provider restarted deal %s: %s", deal.ProposalCid, resolution)
+	switch resolution {
+	case dealrestart.ResolutionFail:
+		switch deal.State {
+		case storagemarket.StorageDealStartDataTransfer, storagemarket.StorageDealTransferring, storagemarket.StorageDealClientTransferRestart:
+			err = c.statemachines.Send(deal.ProposalCid, storagemarket.ClientEventRestartNegotiationFailed, reason)
+		}
+	case dealrestart.ResolutionRestartTransfer:
+		// the client is responsible for restarting the transfer
+		if deal.State == storagemarket.StorageDealTransferring {
+			err = c.statemachines.Send(deal.ProposalCid, storagemarket.ClientEventRestart)
+		}
+	}
+	if err != nil {
+		log.Errorf("failed to resume deal %s after restart negotiation: %s", deal.ProposalCid, err)
+	}
+}
+
+// dealView is the client's view of a deal for restart negotiation
+func (c *Client) dealView(ctx context.Context, deal storagemarket.ClientDeal) network.DealView {
+	view := network.DealView{
+		Proposal:          deal.ProposalCid,
+		State:             deal.State,
+		Message:           deal.Message,
+		TransferChannelID: deal.TransferChannelID,
+	}
+	if deal.TransferChannelID != nil {
+		chst, err := c.transport(deal.DataRef.TransferType).ChannelState(ctx, *deal.TransferChannelID)
+		if err != nil {
+			log.Warnf("getting state of transfer channel for deal %s: %s", deal.ProposalCid, err)
+		} else {
+			view.BytesTransferred = chst.Sent()
+		}
+	}
+	return view
 }
 
 func (c *Client) verifyStatusResponseSignature(ctx context.Context, miner address.Address, response network.DealStatusResponse, origBytes []byte) (bool, error) {
@@ -558,13 +1227,13 @@ func (c *Client) addMultiaddrs(ctx context.Context, providerAddr address.Address
 	return nil
 }
 
-func newClientStateMachine(ds datastore.Batching, env fsm.Environment, notifier fsm.Notifier, storageMigrations versioning.VersionedMigrationList, target versioning.VersionKey) (fsm.Group, func(context.Context) error, error) {
+func newClientStateMachine(ds datastore.Batching, env fsm.Environment, notifier fsm.Notifier, entryFuncs fsm.StateEntryFuncs, storageMigrations versioning.VersionedMigrationList, target versioning.VersionKey) (fsm.Group, func(context.Context) error, error) {
 	return versionedfsm.NewVersionedFSM(ds, fsm.Parameters{
 		Environment:     env,
 		StateType:       storagemarket.ClientDeal{},
 		StateKeyField:   "State",
 		Events:          clientstates.ClientEvents,
-		StateEntryFuncs: clientstates.ClientStateEntryFuncs,
+		StateEntryFuncs: entryFuncs,
 		FinalityStates:  clientstates.ClientFinalityStates,
 		Notifier:        notifier,
 	}, storageMigrations, target)
@@ -8,10 +8,13 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p-core/peer"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/crypto"
 
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
 
@@ -31,24 +34,42 @@ func (c *clientDealEnvironment) Node() storagemarket.StorageClientNode {
 	return c.c.node
 }
 
-func (c *clientDealEnvironment) StartDataTransfer(ctx context.Context, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID,
+func (c *clientDealEnvironment) StartDataTransfer(ctx context.Context, transferType string, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID,
 	error) {
-	chid, err := c.c.dataTransfer.OpenPushDataChannel(ctx, to, voucher, baseCid, selector)
+	chid, err := c.c.transport(transferType).OpenPushDataChannel(ctx, to, voucher, baseCid, selector)
 	return chid, err
 }
 
-func (c *clientDealEnvironment) RestartDataTransfer(ctx context.Context, channelId datatransfer.ChannelID) error {
-	return c.c.dataTransfer.RestartDataTransferChannel(ctx, channelId)
+func (c *clientDealEnvironment) RestartDataTransfer(ctx context.Context, transferType string, channelId datatransfer.ChannelID) error {
+	return c.c.transport(transferType).RestartDataTransferChannel(ctx, channelId)
 }
 
 func (c *clientDealEnvironment) GetProviderDealState(ctx context.Context, proposalCid cid.Cid) (*storagemarket.ProviderDealState, error) {
 	return c.c.GetProviderDealState(ctx, proposalCid)
 }
 
+func (c *clientDealEnvironment) NegotiateRestart(ctx context.Context, deal storagemarket.ClientDeal) (network.DealView, network.DealView, error) {
+	clientView := c.c.dealView(ctx, deal)
+	providerView, err := dealrestart.Negotiate(ctx, c.c.net, deal.Miner, clientView)
+	return clientView, providerView, err
+}
+
 func (c *clientDealEnvironment) PollingInterval() time.Duration {
 	return c.c.pollingInterval
 }
 
+func (c *clientDealEnvironment) MaxProposalResubmissions() uint64 {
+	return c.c.maxResubmissions
+}
+
+func (c *clientDealEnvironment) PeerSignature(ctx context.Context, client address.Address) (*crypto.Signature, error) {
+	return c.c.peerSignature(ctx, client)
+}
+
+func (c *clientDealEnvironment) RequestSignature(ctx context.Context, deal storagemarket.ClientDeal) error {
+	return c.c.requestSignature(ctx, deal)
+}
+
 type clientStoreGetter struct {
 	c *Client
 }
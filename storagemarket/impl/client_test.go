@@ -14,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/exp/rand"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
@@ -25,6 +26,7 @@ import (
 	"github.com/filecoin-project/go-fil-markets/shared_testutil"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	storageimpl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blocklist"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/testharness/dependencies"
@@ -165,3 +167,46 @@ func TestClient_Migrations(t *testing.T) {
 		require.Equal(t, expectedDeal, deal)
 	}
 }
+
+func TestClient_BlockFailingProviders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "", noOpDelay,
+		noOpDelay)
+
+	client, err := storageimpl.NewClient(
+		network.NewFromLibp2pHost(deps.TestData.Host1, network.RetryParameters(0, 0, 0)),
+		deps.TestData.Bs1,
+		deps.TestData.MultiStore1,
+		deps.DTClient,
+		deps.PeerResolver,
+		namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/client")),
+		deps.ClientNode,
+		storageimpl.BlockFailingProviders(),
+	)
+	require.NoError(t, err)
+
+	listProviders := func() []storagemarket.StorageProviderInfo {
+		providers, err := client.ListProviders(ctx)
+		require.NoError(t, err)
+		var out []storagemarket.StorageProviderInfo
+		for provider := range providers {
+			out = append(out, provider)
+		}
+		return out
+	}
+	require.Equal(t, []storagemarket.StorageProviderInfo{deps.ProviderInfo}, listProviders())
+
+	require.NoError(t, client.BlockProvider(ctx, deps.ProviderAddr, "bad data", time.Time{}))
+	blocked, err := client.ListBlockedProviders(ctx)
+	require.NoError(t, err)
+	require.Len(t, blocked, 1)
+	require.Equal(t, deps.ProviderAddr, blocked[0].Provider)
+	require.Empty(t, listProviders())
+
+	_, err = client.ProposeStorageDeal(ctx, storagemarket.ProposeStorageDealParams{Info: &deps.ProviderInfo})
+	require.True(t, xerrors.Is(err, blocklist.ErrBlocked))
+
+	require.NoError(t, client.UnblockProvider(ctx, deps.ProviderAddr))
+	require.Equal(t, []storagemarket.StorageProviderInfo{deps.ProviderInfo}, listProviders())
+}
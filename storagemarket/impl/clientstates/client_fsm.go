@@ -2,6 +2,7 @@ package clientstates
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
@@ -10,6 +11,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 )
@@ -18,6 +20,32 @@ import (
 var ClientEvents = fsm.Events{
 	fsm.Event(storagemarket.ClientEventOpen).
 		From(storagemarket.StorageDealUnknown).To(storagemarket.StorageDealReserveClientFunds),
+	fsm.Event(storagemarket.ClientEventAwaitSignature).
+		From(storagemarket.StorageDealUnknown).To(storagemarket.StorageDealAwaitingSignature),
+	fsm.Event(storagemarket.ClientEventSignatureRequestFailed).
+		From(storagemarket.StorageDealAwaitingSignature).To(storagemarket.StorageDealError).
+		Action(func(deal *storagemarket.ClientDeal, err error) error {
+			deal.Message = xerrors.Errorf("requesting signature for deal proposal failed: %w", err).Error()
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventSignatureReceived).
+		From(storagemarket.StorageDealAwaitingSignature).To(storagemarket.StorageDealSigned).
+		Action(func(deal *storagemarket.ClientDeal, signedProposalCid cid.Cid) error {
+			deal.SignedProposalCid = &signedProposalCid
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventSignatureTimedOut).
+		From(storagemarket.StorageDealAwaitingSignature).To(storagemarket.StorageDealError).
+		Action(func(deal *storagemarket.ClientDeal) error {
+			deal.Message = "timed out waiting for deal proposal to be signed"
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventSignatureCancelled).
+		From(storagemarket.StorageDealAwaitingSignature).To(storagemarket.StorageDealError).
+		Action(func(deal *storagemarket.ClientDeal) error {
+			deal.Message = "signing deal proposal was cancelled"
+			return nil
+		}),
 	fsm.Event(storagemarket.ClientEventFundingInitiated).
 		From(storagemarket.StorageDealReserveClientFunds).To(storagemarket.StorageDealClientFunding).
 		Action(func(deal *storagemarket.ClientDeal, mcid cid.Cid) error {
@@ -68,6 +96,32 @@ var ClientEvents = fsm.Events{
 		}),
 	fsm.Event(storagemarket.ClientEventInitiateDataTransfer).
 		From(storagemarket.StorageDealFundsReserved).To(storagemarket.StorageDealStartDataTransfer),
+	fsm.Event(storagemarket.ClientEventTransferQueued).
+		FromMany(storagemarket.StorageDealFundsReserved, storagemarket.StorageDealTransferQueued).To(storagemarket.StorageDealTransferQueued).
+		Action(func(deal *storagemarket.ClientDeal, providerMessage string) error {
+			deal.Message = providerMessage
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventProposalRetryLater).
+		From(storagemarket.StorageDealFundsReserved).To(storagemarket.StorageDealProposalRetryWait).
+		Action(func(deal *storagemarket.ClientDeal, providerMessage string, retryAfter abi.ChainEpoch) error {
+			deal.Message = xerrors.Errorf("provider asked to propose the deal again in %d epochs: %s", retryAfter, providerMessage).Error()
+			deal.ResubmitCount++
+			deal.ResubmitAt = storagemarket.OptionalTime(time.Now().Add(time.Duration(retryAfter*builtin.EpochDurationSeconds) * time.Second))
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventResubmitProposal).
+		From(storagemarket.StorageDealProposalRetryWait).To(storagemarket.StorageDealFundsReserved).
+		Action(func(deal *storagemarket.ClientDeal) error {
+			deal.ResubmitAt = storagemarket.OptionalTime{}
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventTransferSlotOpened).
+		From(storagemarket.StorageDealTransferQueued).To(storagemarket.StorageDealStartDataTransfer).
+		Action(func(deal *storagemarket.ClientDeal) error {
+			deal.Message = ""
+			return nil
+		}),
 
 	fsm.Event(storagemarket.ClientEventUnexpectedDealState).
 		From(storagemarket.StorageDealFundsReserved).To(storagemarket.StorageDealFailing).
@@ -125,8 +179,26 @@ var ClientEvents = fsm.Events{
 		}),
 
 	fsm.Event(storagemarket.ClientEventDataTransferComplete).
-		FromMany(storagemarket.StorageDealTransferring, storagemarket.StorageDealStartDataTransfer).
+		FromMany(storagemarket.StorageDealTransferring, storagemarket.StorageDealStartDataTransfer, storagemarket.StorageDealClientTransferRestart).
 		To(storagemarket.StorageDealCheckForAcceptance),
+	fsm.Event(storagemarket.ClientEventResendProposal).
+		From(storagemarket.StorageDealClientTransferRestart).To(storagemarket.StorageDealFundsReserved).
+		Action(func(deal *storagemarket.ClientDeal) error {
+			deal.TransferChannelID = nil
+			deal.Message = "provider did not receive data transfer request, resending proposal"
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventRestartNegotiationFailed).
+		FromMany(
+			storagemarket.StorageDealStartDataTransfer,
+			storagemarket.StorageDealTransferring,
+			storagemarket.StorageDealClientTransferRestart,
+		).
+		To(storagemarket.StorageDealFailing).
+		Action(func(deal *storagemarket.ClientDeal, reason string) error {
+			deal.Message = xerrors.Errorf("restarting deal: %s", reason).Error()
+			return nil
+		}),
 	fsm.Event(storagemarket.ClientEventWaitForDealState).
 		From(storagemarket.StorageDealCheckForAcceptance).ToNoChange().
 		Action(func(deal *storagemarket.ClientDeal, pollError bool, providerState storagemarket.StorageDealStatus) error {
@@ -144,7 +216,7 @@ var ClientEvents = fsm.Events{
 			return nil
 		}),
 	fsm.Event(storagemarket.ClientEventDealRejected).
-		From(storagemarket.StorageDealCheckForAcceptance).To(storagemarket.StorageDealFailing).
+		FromMany(storagemarket.StorageDealCheckForAcceptance, storagemarket.StorageDealTransferQueued).To(storagemarket.StorageDealFailing).
 		Action(func(deal *storagemarket.ClientDeal, state storagemarket.StorageDealStatus, reason string) error {
 			deal.Message = xerrors.Errorf("deal failed: (State=%d) %s", state, reason).Error()
 			return nil
@@ -195,6 +267,27 @@ var ClientEvents = fsm.Events{
 	fsm.Event(storagemarket.ClientEventDealActivated).
 		FromMany(storagemarket.StorageDealAwaitingPreCommit, storagemarket.StorageDealSealing).
 		To(storagemarket.StorageDealActive),
+	fsm.Event(storagemarket.ClientEventSealingProgress).
+		FromAny().ToJustRecord().
+		Action(func(deal *storagemarket.ClientDeal, progress storagemarket.SealingProgress) error {
+			deal.SealingProgress = &progress
+			if progress.MissesStartEpoch(deal.Proposal.StartEpoch) {
+				deal.Message = fmt.Sprintf("provider expects deal to activate at epoch %d, after its start epoch %d", progress.EstimatedActivation, deal.Proposal.StartEpoch)
+			}
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventDealStatusProtocol).
+		FromAny().ToJustRecord().
+		Action(func(deal *storagemarket.ClientDeal, protocol string) error {
+			deal.DealStatusProtocol = protocol
+			return nil
+		}),
+	fsm.Event(storagemarket.ClientEventProviderDealFailed).
+		FromMany(storagemarket.StorageDealProposalAccepted, storagemarket.StorageDealAwaitingPreCommit, storagemarket.StorageDealSealing).To(storagemarket.StorageDealFailing).
+		Action(func(deal *storagemarket.ClientDeal, state storagemarket.StorageDealStatus, reason string) error {
+			deal.Message = xerrors.Errorf("provider failed deal: (State=%d) %s", state, reason).Error()
+			return nil
+		}),
 	fsm.Event(storagemarket.ClientEventDealSlashed).
 		From(storagemarket.StorageDealActive).To(storagemarket.StorageDealSlashed).
 		Action(func(deal *storagemarket.ClientDeal, slashEpoch abi.ChainEpoch) error {
@@ -213,13 +306,27 @@ var ClientEvents = fsm.Events{
 		From(storagemarket.StorageDealFailing).To(storagemarket.StorageDealError),
 	fsm.Event(storagemarket.ClientEventRestart).From(storagemarket.StorageDealTransferring).To(storagemarket.StorageDealClientTransferRestart).
 		FromAny().ToNoChange(),
+	fsm.Event(storagemarket.ClientEventDataTransferUpdated).
+		FromAny().ToJustRecord().
+		Action(func(deal *storagemarket.ClientDeal, channelState datatransfer.ChannelState) error {
+			channelID := channelState.ChannelID()
+			deal.TransferChannelID = &channelID
+			deal.TransferStatus = channelState.Status()
+			deal.TransferQueued = channelState.Queued()
+			deal.TransferSent = channelState.Sent()
+			deal.TransferReceived = channelState.Received()
+			return nil
+		}),
 }
 
 // ClientStateEntryFuncs are the handlers for different states in a storage client
 var ClientStateEntryFuncs = fsm.StateEntryFuncs{
+	storagemarket.StorageDealAwaitingSignature:     RequestSignature,
 	storagemarket.StorageDealReserveClientFunds:    ReserveClientFunds,
 	storagemarket.StorageDealClientFunding:         WaitForFunding,
 	storagemarket.StorageDealFundsReserved:         ProposeDeal,
+	storagemarket.StorageDealTransferQueued:        WaitForTransferSlot,
+	storagemarket.StorageDealProposalRetryWait:     WaitToResubmitProposal,
 	storagemarket.StorageDealStartDataTransfer:     InitiateDataTransfer,
 	storagemarket.StorageDealClientTransferRestart: RestartDataTransfer,
 	storagemarket.StorageDealCheckForAcceptance:    CheckForDealAcceptance,
@@ -236,4 +343,5 @@ var ClientFinalityStates = []fsm.StateKey{
 	storagemarket.StorageDealSlashed,
 	storagemarket.StorageDealExpired,
 	storagemarket.StorageDealError,
+	storagemarket.StorageDealSigned,
 }
@@ -10,13 +10,16 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-statemachine/fsm"
 
-	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
@@ -28,16 +31,30 @@ var log = logging.Logger("storagemarket_impl")
 type ClientDealEnvironment interface {
 	Node() storagemarket.StorageClientNode
 	NewDealStream(ctx context.Context, p peer.ID) (network.StorageDealStream, error)
-	StartDataTransfer(ctx context.Context, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error)
-	RestartDataTransfer(ctx context.Context, chid datatransfer.ChannelID) error
+	StartDataTransfer(ctx context.Context, transferType string, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error)
+	RestartDataTransfer(ctx context.Context, transferType string, chid datatransfer.ChannelID) error
 	GetProviderDealState(ctx context.Context, proposalCid cid.Cid) (*storagemarket.ProviderDealState, error)
+	NegotiateRestart(ctx context.Context, deal storagemarket.ClientDeal) (clientView network.DealView, providerView network.DealView, err error)
 	PollingInterval() time.Duration
+	MaxProposalResubmissions() uint64
+	RequestSignature(ctx context.Context, deal storagemarket.ClientDeal) error
+	PeerSignature(ctx context.Context, client address.Address) (*crypto.Signature, error)
 	network.PeerTagger
 }
 
 // ClientStateEntryFunc is the type for all state entry functions on a storage client
 type ClientStateEntryFunc func(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error
 
+// RequestSignature asks the client's proposal signer to sign the deal proposal. The
+// signature arrives later, or the request times out or is cancelled
+func RequestSignature(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	err := environment.RequestSignature(ctx.Context(), deal)
+	if err != nil {
+		return ctx.Trigger(storagemarket.ClientEventSignatureRequestFailed, err)
+	}
+	return nil
+}
+
 // ReserveClientFunds attempts to reserve funds for this deal and ensure they are available in the Storage Market Actor
 func ReserveClientFunds(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
 	node := environment.Node()
@@ -79,8 +96,15 @@ func ProposeDeal(ctx fsm.Context, environment ClientDealEnvironment, deal storag
 		DealProposal:  &deal.ClientDealProposal,
 		Piece:         deal.DataRef,
 		FastRetrieval: deal.FastRetrieval,
+		Invoice:       deal.Invoice,
 	}
 
+	peerSig, err := environment.PeerSignature(ctx.Context(), deal.Proposal.Client)
+	if err != nil {
+		return ctx.Trigger(storagemarket.ClientEventWriteProposalFailed, err)
+	}
+	proposal.ClientPeerSignature = peerSig
+
 	s, err := environment.NewDealStream(ctx.Context(), deal.Miner)
 	if err != nil {
 		return ctx.Trigger(storagemarket.ClientEventWriteProposalFailed, err)
@@ -116,6 +140,15 @@ func ProposeDeal(ctx fsm.Context, environment ClientDealEnvironment, deal storag
 		return ctx.Trigger(storagemarket.ClientEventResponseVerificationFailed)
 	}
 
+	if isFailed(resp.Response.State) && resp.Response.RetryAfter > 0 && deal.ResubmitCount < environment.MaxProposalResubmissions() {
+		environment.UntagPeer(deal.Miner, deal.ProposalCid.String())
+		return ctx.Trigger(storagemarket.ClientEventProposalRetryLater, resp.Response.Message, resp.Response.RetryAfter)
+	}
+
+	if resp.Response.State == storagemarket.StorageDealTransferQueued {
+		return ctx.Trigger(storagemarket.ClientEventTransferQueued, resp.Response.Message)
+	}
+
 	if resp.Response.State != storagemarket.StorageDealWaitingForData {
 		return ctx.Trigger(storagemarket.ClientEventUnexpectedDealState, resp.Response.State, resp.Response.Message)
 	}
@@ -123,26 +156,126 @@ func ProposeDeal(ctx fsm.Context, environment ClientDealEnvironment, deal storag
 	return ctx.Trigger(storagemarket.ClientEventInitiateDataTransfer)
 }
 
-// RestartDataTransfer restarts a data transfer to the provider that was initiated earlier
-func RestartDataTransfer(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
-	log.Infof("restarting data transfer for deal deal %s", deal.ProposalCid)
+// WaitToResubmitProposal waits until the provider is ready for a deal it rejected for
+// a transient reason, then proposes the deal again
+func WaitToResubmitProposal(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	t := time.NewTimer(time.Until(deal.ResubmitAt.Time()))
 
-	if deal.TransferChannelID == nil {
-		return ctx.Trigger(storagemarket.ClientEventDataTransferRestartFailed, xerrors.New("channelId on client deal is nil"))
-	}
+	go func() {
+		select {
+		case <-t.C:
+			_ = ctx.Trigger(storagemarket.ClientEventResubmitProposal)
+		case <-ctx.Context().Done():
+			t.Stop()
+			return
+		}
+	}()
 
-	// restart the push data transfer. This will complete asynchronously and the
-	// completion of the data transfer will trigger a change in deal state
-	err := environment.RestartDataTransfer(ctx.Context(),
-		*deal.TransferChannelID,
-	)
+	return nil
+}
+
+// WaitForTransferSlot polls the provider until it is ready to receive data for a deal
+// whose transfer it queued behind other transfers from this client
+func WaitForTransferSlot(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	dealState, err := environment.GetProviderDealState(ctx.Context(), deal.ProposalCid)
 	if err != nil {
-		return ctx.Trigger(storagemarket.ClientEventDataTransferRestartFailed, err)
+		log.Warnf("error when querying provider deal state: %s", err)
+		return waitForTransferSlotAgain(ctx, environment, deal.Message)
+	}
+
+	if isFailed(dealState.State) {
+		return ctx.Trigger(storagemarket.ClientEventDealRejected, dealState.State, dealState.Message)
 	}
 
+	if dealState.State == storagemarket.StorageDealWaitingForData {
+		return ctx.Trigger(storagemarket.ClientEventTransferSlotOpened)
+	}
+
+	return waitForTransferSlotAgain(ctx, environment, dealState.Message)
+}
+
+func waitForTransferSlotAgain(ctx fsm.Context, environment ClientDealEnvironment, providerMessage string) error {
+	t := time.NewTimer(environment.PollingInterval())
+
+	go func() {
+		select {
+		case <-t.C:
+			_ = ctx.Trigger(storagemarket.ClientEventTransferQueued, providerMessage)
+		case <-ctx.Context().Done():
+			t.Stop()
+			return
+		}
+	}()
+
+	return nil
+}
+
+// RestartDataTransfer negotiates with the provider how to resume a deal that was
+// transferring data when the client restarted, and restarts the data transfer to
+// the provider if needed
+func RestartDataTransfer(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	// negotiating waits on the provider, so it runs outside the state handler, which
+	// would otherwise hold up every other event for the deal until the provider answers
+	go func() {
+		clientView, providerView, err := environment.NegotiateRestart(ctx.Context(), deal)
+		if err != nil {
+			// the provider may not support restart negotiation, fall back to restarting the transfer
+			log.Warnf("negotiating restart of deal %s with provider: %s", deal.ProposalCid, err)
+		} else {
+			resolution, reason := dealrestart.Reconcile(clientView, providerView)
+			log.Infof("resuming deal %s after restart: %s", deal.ProposalCid, resolution)
+			switch resolution {
+			case dealrestart.ResolutionFail:
+				_ = ctx.Trigger(storagemarket.ClientEventRestartNegotiationFailed, reason)
+				return
+			case dealrestart.ResolutionResendResponse:
+				_ = ctx.Trigger(storagemarket.ClientEventResendProposal)
+				return
+			case dealrestart.ResolutionSkipTransfer:
+				_ = ctx.Trigger(storagemarket.ClientEventDataTransferComplete)
+				return
+			}
+		}
+
+		log.Infof("restarting data transfer for deal deal %s", deal.ProposalCid)
+
+		channelID := deal.TransferChannelID
+		if channelID == nil {
+			// the client lost track of the transfer, so resume it on the channel the
+			// provider reports receiving the data on
+			channelID, err = providerTransferChannel(ctx, environment, deal)
+			if err != nil {
+				_ = ctx.Trigger(storagemarket.ClientEventDataTransferRestartFailed, err)
+				return
+			}
+		}
+
+		// restart the push data transfer. This will complete asynchronously and the
+		// completion of the data transfer will trigger a change in deal state
+		err = environment.RestartDataTransfer(ctx.Context(), deal.DataRef.TransferType, *channelID)
+		if err != nil {
+			_ = ctx.Trigger(storagemarket.ClientEventDataTransferRestartFailed, err)
+		}
+	}()
+
 	return nil
 }
 
+// providerTransferChannel asks the provider which data transfer channel it is
+// receiving a deal's data on
+func providerTransferChannel(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) (*datatransfer.ChannelID, error) {
+	providerState, err := environment.GetProviderDealState(ctx.Context(), deal.ProposalCid)
+	if err != nil {
+		return nil, xerrors.Errorf("channelId on client deal is nil, and getting it from the provider failed: %w", err)
+	}
+	if providerState.TransferChannelID == nil {
+		return nil, xerrors.New("channelId on client deal is nil, and the provider is not receiving data for the deal")
+	}
+	log.Infof("resuming data transfer for deal %s on channel %s, provider has received %d bytes",
+		deal.ProposalCid, providerState.TransferChannelID, providerState.TransferReceived)
+	return providerState.TransferChannelID, nil
+}
+
 // InitiateDataTransfer initiates data transfer to the provider
 func InitiateDataTransfer(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
 	if deal.DataRef.TransferType == storagemarket.TTManual {
@@ -150,15 +283,26 @@ func InitiateDataTransfer(ctx fsm.Context, environment ClientDealEnvironment, de
 		return ctx.Trigger(storagemarket.ClientEventDataTransferComplete)
 	}
 
+	if deal.DataRef.TransferType == storagemarket.TTExistingPiece {
+		log.Infof("no data transfer for deal %s, provider has existing piece", deal.ProposalCid)
+		return ctx.Trigger(storagemarket.ClientEventDataTransferComplete)
+	}
+
+	if deal.DataRef.TransferAgent != "" {
+		log.Infof("data for deal %s is sent by transfer agent %s", deal.ProposalCid, deal.DataRef.TransferAgent)
+		return ctx.Trigger(storagemarket.ClientEventDataTransferComplete)
+	}
+
 	log.Infof("sending data for a deal %s", deal.ProposalCid)
 
 	// initiate a push data transfer. This will complete asynchronously and the
 	// completion of the data transfer will trigger a change in deal state
 	_, err := environment.StartDataTransfer(ctx.Context(),
+		deal.DataRef.TransferType,
 		deal.Miner,
 		&requestvalidation.StorageDataTransferVoucher{Proposal: deal.ProposalCid},
 		deal.DataRef.Root,
-		shared.AllSelector(),
+		selectors.Entire(),
 	)
 
 	if err != nil {
@@ -192,6 +336,26 @@ func CheckForDealAcceptance(ctx fsm.Context, environment ClientDealEnvironment,
 	return waitAgain(ctx, environment, false, dealState.State)
 }
 
+// ProviderNotificationEvent returns the event a deal state notification pushed by
+// the provider sends to the client's deal, and false if the notification does not
+// move the deal on from the state it is in
+func ProviderNotificationEvent(deal storagemarket.ClientDeal, providerState storagemarket.StorageDealStatus, message string, publishCid *cid.Cid) (storagemarket.ClientEvent, []interface{}, bool) {
+	switch deal.State {
+	case storagemarket.StorageDealCheckForAcceptance:
+		if isFailed(providerState) {
+			return storagemarket.ClientEventDealRejected, []interface{}{providerState, message}, true
+		}
+		if isAccepted(providerState) {
+			return storagemarket.ClientEventDealAccepted, []interface{}{publishCid}, true
+		}
+	case storagemarket.StorageDealProposalAccepted, storagemarket.StorageDealAwaitingPreCommit, storagemarket.StorageDealSealing:
+		if isFailed(providerState) {
+			return storagemarket.ClientEventProviderDealFailed, []interface{}{providerState, message}, true
+		}
+	}
+	return 0, nil, false
+}
+
 func waitAgain(ctx fsm.Context, environment ClientDealEnvironment, pollError bool, providerState storagemarket.StorageDealStatus) error {
 	t := time.NewTimer(environment.PollingInterval())
 
@@ -226,7 +390,9 @@ func ValidateDealPublished(ctx fsm.Context, environment ClientDealEnvironment, d
 
 // VerifyDealPreCommitted verifies that a deal has been pre-committed
 func VerifyDealPreCommitted(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	stopPolling := startSealingProgressPolling(ctx, environment, deal)
 	cb := func(sectorNumber abi.SectorNumber, isActive bool, err error) {
+		stopPolling()
 		// It's possible that
 		// - we miss the pre-commit message and have to wait for prove-commit
 		// - the deal is already active (for example if the node is restarted
@@ -245,6 +411,7 @@ func VerifyDealPreCommitted(ctx fsm.Context, environment ClientDealEnvironment,
 	err := environment.Node().OnDealSectorPreCommitted(ctx.Context(), deal.Proposal.Provider, deal.DealID, deal.Proposal, deal.PublishMessage, cb)
 
 	if err != nil {
+		stopPolling()
 		return ctx.Trigger(storagemarket.ClientEventDealPrecommitFailed, err)
 	}
 	return nil
@@ -252,7 +419,9 @@ func VerifyDealPreCommitted(ctx fsm.Context, environment ClientDealEnvironment,
 
 // VerifyDealActivated confirms that a deal was successfully committed to a sector and is active
 func VerifyDealActivated(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
+	stopPolling := startSealingProgressPolling(ctx, environment, deal)
 	cb := func(err error) {
+		stopPolling()
 		if err != nil {
 			_ = ctx.Trigger(storagemarket.ClientEventDealActivationFailed, err)
 		} else {
@@ -261,12 +430,52 @@ func VerifyDealActivated(ctx fsm.Context, environment ClientDealEnvironment, dea
 	}
 
 	if err := environment.Node().OnDealSectorCommitted(ctx.Context(), deal.Proposal.Provider, deal.DealID, deal.SectorNumber, deal.Proposal, deal.PublishMessage, cb); err != nil {
+		stopPolling()
 		return ctx.Trigger(storagemarket.ClientEventDealActivationFailed, err)
 	}
 
 	return nil
 }
 
+// startSealingProgressPolling polls the provider for how far it has got sealing the
+// deal's sector, and records each change in the deal, until the returned function is
+// called. Providers that answered on a deal status protocol from before sealing
+// progress was reported are not polled
+func startSealingProgressPolling(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) context.CancelFunc {
+	pollCtx, stopPolling := context.WithCancel(ctx.Context())
+	switch deal.DealStatusProtocol {
+	case storagemarket.OldDealStatusProtocolID, storagemarket.DealStatusProtocolID110:
+		return stopPolling
+	}
+	go func() {
+		last := deal.SealingProgress
+		for {
+			t := time.NewTimer(environment.PollingInterval())
+			select {
+			case <-t.C:
+			case <-pollCtx.Done():
+				t.Stop()
+				return
+			}
+
+			dealState, err := environment.GetProviderDealState(pollCtx, deal.ProposalCid)
+			if err != nil {
+				log.Warnf("polling provider for sealing progress of deal %s: %s", deal.ProposalCid, err)
+				continue
+			}
+			if dealState == nil || dealState.Sealing == nil || pollCtx.Err() != nil {
+				continue
+			}
+			if last != nil && *last == *dealState.Sealing {
+				continue
+			}
+			last = dealState.Sealing
+			_ = ctx.Trigger(storagemarket.ClientEventSealingProgress, *dealState.Sealing)
+		}
+	}()
+	return stopPolling
+}
+
 // WaitForDealCompletion waits for the deal to be slashed or to expire
 func WaitForDealCompletion(ctx fsm.Context, environment ClientDealEnvironment, deal storagemarket.ClientDeal) error {
 	node := environment.Node()
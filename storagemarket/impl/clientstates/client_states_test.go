@@ -16,6 +16,7 @@ import (
 	cborutil "github.com/filecoin-project/go-cbor-util"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-statemachine/fsm"
 	fsmtest "github.com/filecoin-project/go-statemachine/fsm/testutil"
@@ -30,6 +31,26 @@ import (
 
 var clientDealProposal = tut.MakeTestClientDealProposal()
 
+func TestRequestSignature(t *testing.T) {
+	t.Run("waits for the signature", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealAwaitingSignature, clientstates.RequestSignature, testCase{
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAwaitingSignature, deal.State)
+				assert.Equal(t, 1, env.requestSignatureCalls)
+			},
+		})
+	})
+	t.Run("fails if the signer cannot be asked", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealAwaitingSignature, clientstates.RequestSignature, testCase{
+			envParams: envParams{requestSignatureError: errors.New("device not connected")},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealError, deal.State)
+				assert.Equal(t, "requesting signature for deal proposal failed: device not connected", deal.Message)
+			},
+		})
+	})
+}
+
 func TestReserveClientFunds(t *testing.T) {
 	t.Run("immediately succeeds", func(t *testing.T) {
 		runAndInspect(t, storagemarket.StorageDealReserveClientFunds, clientstates.ReserveClientFunds, testCase{
@@ -130,6 +151,63 @@ func TestProposeDeal(t *testing.T) {
 			},
 		})
 	})
+	t.Run("sends the deal's invoice", func(t *testing.T) {
+		var sentProposal *smnet.Proposal
+		invoice := &storagemarket.InvoiceMetadata{InvoiceID: "INV-0042", Currency: "USD"}
+
+		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
+			ResponseReader: testResponseReader(t, responseParams{
+				state:    storagemarket.StorageDealWaitingForData,
+				proposal: clientDealProposal,
+			}),
+			ProposalWriter: func(proposal smnet.Proposal) error {
+				sentProposal = &proposal
+				return nil
+			},
+		})
+
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams:   envParams{dealStream: ds},
+			stateParams: dealStateParams{invoice: invoice},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealStartDataTransfer, deal.State)
+				assert.Equal(t, invoice, sentProposal.Invoice)
+				assert.Equal(t, invoice, deal.Invoice)
+			},
+		})
+	})
+	t.Run("sends the client's peer signature", func(t *testing.T) {
+		var sentProposal *smnet.Proposal
+		peerSig := &crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte("peer binding")}
+
+		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
+			ResponseReader: testResponseReader(t, responseParams{
+				state:    storagemarket.StorageDealWaitingForData,
+				proposal: clientDealProposal,
+			}),
+			ProposalWriter: func(proposal smnet.Proposal) error {
+				sentProposal = &proposal
+				return nil
+			},
+		})
+
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams: envParams{dealStream: ds, peerSignature: peerSig},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealStartDataTransfer, deal.State)
+				assert.Equal(t, peerSig, sentProposal.ClientPeerSignature)
+			},
+		})
+	})
+	t.Run("signing the peer binding fails", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams: envParams{peerSignatureError: errors.New("key not found")},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealError, deal.State)
+				assert.Equal(t, "sending proposal to storage provider failed: key not found", deal.Message)
+			},
+		})
+	})
 	t.Run("write proposal fails fails", func(t *testing.T) {
 		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
 			ProposalWriter: tut.FailStorageProposalWriter,
@@ -216,6 +294,123 @@ func TestProposeDeal(t *testing.T) {
 			},
 		})
 	})
+	t.Run("waits when the provider queues the transfer", func(t *testing.T) {
+		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
+			ResponseReader: testResponseReader(t, responseParams{
+				proposal: clientDealProposal,
+				state:    storagemarket.StorageDealTransferQueued,
+				message:  "transfer queued behind other transfers from this client",
+			}),
+		})
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams: envParams{
+				dealStream: ds,
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealTransferQueued, deal.State)
+				assert.Equal(t, "transfer queued behind other transfers from this client", deal.Message)
+			},
+		})
+	})
+	t.Run("waits to propose again when the provider asks to retry later", func(t *testing.T) {
+		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
+			ResponseReader: testResponseReader(t, responseParams{
+				proposal:   clientDealProposal,
+				state:      storagemarket.StorageDealFailing,
+				message:    "deal rejected: provider is in maintenance",
+				retryAfter: 10,
+			}),
+		})
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams: envParams{
+				dealStream:       ds,
+				maxResubmissions: 1,
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealProposalRetryWait, deal.State)
+				assert.Equal(t, "provider asked to propose the deal again in 10 epochs: deal rejected: provider is in maintenance", deal.Message)
+				assert.Equal(t, uint64(1), deal.ResubmitCount)
+				assert.True(t, time.Time(deal.ResubmitAt).After(time.Now()))
+				assert.Len(t, env.peerTagger.UntagCalls, 1)
+			},
+		})
+	})
+	t.Run("fails when the client does not resubmit proposals", func(t *testing.T) {
+		ds := tut.NewTestStorageDealStream(tut.TestStorageDealStreamParams{
+			ResponseReader: testResponseReader(t, responseParams{
+				proposal:   clientDealProposal,
+				state:      storagemarket.StorageDealFailing,
+				message:    "deal rejected: provider is in maintenance",
+				retryAfter: 10,
+			}),
+		})
+		runAndInspect(t, storagemarket.StorageDealFundsReserved, clientstates.ProposeDeal, testCase{
+			envParams: envParams{
+				dealStream: ds,
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				assert.Equal(t, uint64(0), deal.ResubmitCount)
+			},
+		})
+	})
+}
+
+func TestWaitToResubmitProposal(t *testing.T) {
+	t.Run("proposes the deal again once the retry interval passes", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealProposalRetryWait, clientstates.WaitToResubmitProposal, testCase{
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFundsReserved, deal.State)
+			},
+		})
+	})
+}
+
+func TestWaitForTransferSlot(t *testing.T) {
+	proposalCid := tut.GenerateCid(t, clientDealProposal)
+
+	makeProviderDealState := func(status storagemarket.StorageDealStatus, message string) *storagemarket.ProviderDealState {
+		return &storagemarket.ProviderDealState{
+			State:       status,
+			Message:     message,
+			Proposal:    &clientDealProposal.Proposal,
+			ProposalCid: &proposalCid,
+		}
+	}
+
+	t.Run("starts the transfer when the provider opens a slot", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealTransferQueued, clientstates.WaitForTransferSlot, testCase{
+			envParams: envParams{
+				providerDealState: makeProviderDealState(storagemarket.StorageDealWaitingForData, ""),
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealStartDataTransfer, deal.State)
+				assert.Equal(t, "", deal.Message)
+			},
+		})
+	})
+
+	t.Run("keeps waiting while the transfer is queued", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealTransferQueued, clientstates.WaitForTransferSlot, testCase{
+			envParams: envParams{
+				providerDealState: makeProviderDealState(storagemarket.StorageDealTransferQueued, "transfer queued behind other transfers from this client"),
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealTransferQueued, deal.State)
+			},
+		})
+	})
+
+	t.Run("fails when the provider fails the deal", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealTransferQueued, clientstates.WaitForTransferSlot, testCase{
+			envParams: envParams{
+				providerDealState: makeProviderDealState(storagemarket.StorageDealFailing, "deal expired while queued"),
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+			},
+		})
+	})
 }
 
 func TestInitiateDataTransfer(t *testing.T) {
@@ -226,6 +421,7 @@ func TestInitiateDataTransfer(t *testing.T) {
 			},
 			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
 				assert.Len(t, env.startDataTransferCalls, 1)
+				assert.Equal(t, env.startDataTransferCalls[0].transferType, deal.DataRef.TransferType)
 				assert.Equal(t, env.startDataTransferCalls[0].to, deal.Miner)
 				assert.Equal(t, env.startDataTransferCalls[0].baseCid, deal.DataRef.Root)
 				assert.Equal(t, storagemarket.StorageDealStartDataTransfer, deal.State)
@@ -245,6 +441,18 @@ func TestInitiateDataTransfer(t *testing.T) {
 		})
 	})
 
+	t.Run("starts polling for acceptance when a transfer agent sends the data", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealStartDataTransfer, clientstates.InitiateDataTransfer, testCase{
+			stateParams: dealStateParams{
+				transferAgent: peer.ID("agent"),
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealCheckForAcceptance, deal.State)
+				assert.Len(t, env.startDataTransferCalls, 0)
+			},
+		})
+	})
+
 	t.Run("fails if it can't initiate data transfer", func(t *testing.T) {
 		runAndInspect(t, storagemarket.StorageDealStartDataTransfer, clientstates.InitiateDataTransfer, testCase{
 			envParams: envParams{
@@ -273,6 +481,91 @@ func TestRestartDataTransfer(t *testing.T) {
 			},
 		})
 	})
+
+	t.Run("restarts transfer when provider is mid transfer", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			envParams: envParams{
+				providerView: &smnet.DealView{
+					State:             storagemarket.StorageDealProviderTransferRestart,
+					TransferChannelID: &datatransfer.ChannelID{},
+				},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 1)
+				tut.AssertDealState(t, storagemarket.StorageDealClientTransferRestart, deal.State)
+			},
+		})
+	})
+
+	t.Run("resumes the provider's channel when the client lost track of it", func(t *testing.T) {
+		channelID := datatransfer.ChannelID{ID: 5}
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			stateParams: dealStateParams{noTransferChannel: true},
+			envParams: envParams{
+				providerDealState: &storagemarket.ProviderDealState{
+					State:             storagemarket.StorageDealTransferring,
+					TransferChannelID: &channelID,
+					TransferReceived:  1024,
+				},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 1)
+				assert.Equal(t, channelID, env.restartDataTransferCalls[0].channelId)
+				tut.AssertDealState(t, storagemarket.StorageDealClientTransferRestart, deal.State)
+			},
+		})
+	})
+
+	t.Run("fails when neither client nor provider has the transfer channel", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			stateParams: dealStateParams{noTransferChannel: true},
+			envParams: envParams{
+				providerDealState: &storagemarket.ProviderDealState{State: storagemarket.StorageDealTransferring},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 0)
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+			},
+		})
+	})
+
+	t.Run("resends proposal when provider never saw transfer", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			envParams: envParams{
+				providerView: &smnet.DealView{State: storagemarket.StorageDealWaitingForData},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 0)
+				assert.Nil(t, deal.TransferChannelID)
+				tut.AssertDealState(t, storagemarket.StorageDealFundsReserved, deal.State)
+			},
+		})
+	})
+
+	t.Run("skips transfer when provider already has data", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			envParams: envParams{
+				providerView: &smnet.DealView{State: storagemarket.StorageDealVerifyData},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 0)
+				tut.AssertDealState(t, storagemarket.StorageDealCheckForAcceptance, deal.State)
+			},
+		})
+	})
+
+	t.Run("fails when provider has no record of deal", func(t *testing.T) {
+		runAndInspect(t, storagemarket.StorageDealClientTransferRestart, clientstates.RestartDataTransfer, testCase{
+			envParams: envParams{
+				providerView: &smnet.DealView{State: storagemarket.StorageDealUnknown},
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.Len(t, env.restartDataTransferCalls, 0)
+				assert.Equal(t, "restarting deal: provider has no record of the deal", deal.Message)
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+			},
+		})
+	})
 }
 
 func TestCheckForDealAcceptance(t *testing.T) {
@@ -478,6 +771,60 @@ func TestVerifyDealActivated(t *testing.T) {
 			},
 		})
 	})
+	t.Run("records sealing progress while waiting", func(t *testing.T) {
+		progress := storagemarket.SealingProgress{
+			Stage:               storagemarket.SealingWaitingProveCommit,
+			PreCommitEpoch:      abi.ChainEpoch(100),
+			EstimatedActivation: abi.ChainEpoch(150),
+		}
+		runAndInspect(t, storagemarket.StorageDealSealing, clientstates.VerifyDealActivated, testCase{
+			nodeParams: nodeParams{DealCommittedDelay: 5 * time.Millisecond},
+			envParams: envParams{
+				providerDealState: &storagemarket.ProviderDealState{Sealing: &progress},
+				pollingInterval:   time.Millisecond,
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealActive, deal.State)
+				if assert.NotNil(t, deal.SealingProgress) {
+					assert.Equal(t, progress, *deal.SealingProgress)
+				}
+			},
+		})
+	})
+	t.Run("does not poll providers without sealing progress", func(t *testing.T) {
+		progress := storagemarket.SealingProgress{
+			Stage: storagemarket.SealingPreCommitted,
+		}
+		runAndInspect(t, storagemarket.StorageDealSealing, clientstates.VerifyDealActivated, testCase{
+			nodeParams: nodeParams{DealCommittedDelay: 5 * time.Millisecond},
+			envParams: envParams{
+				providerDealState: &storagemarket.ProviderDealState{Sealing: &progress},
+				pollingInterval:   time.Millisecond,
+			},
+			stateParams: dealStateParams{dealStatusProtocol: storagemarket.DealStatusProtocolID110},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealActive, deal.State)
+				assert.Nil(t, deal.SealingProgress)
+			},
+		})
+	})
+	t.Run("warns when activation misses the start epoch", func(t *testing.T) {
+		progress := storagemarket.SealingProgress{
+			Stage:               storagemarket.SealingPreCommitted,
+			EstimatedActivation: abi.ChainEpoch(1 << 40),
+		}
+		runAndInspect(t, storagemarket.StorageDealSealing, clientstates.VerifyDealActivated, testCase{
+			nodeParams: nodeParams{DealCommittedDelay: 5 * time.Millisecond},
+			envParams: envParams{
+				providerDealState: &storagemarket.ProviderDealState{Sealing: &progress},
+				pollingInterval:   time.Millisecond,
+			},
+			inspector: func(deal storagemarket.ClientDeal, env *fakeEnvironment) {
+				assert.NotNil(t, deal.SealingProgress)
+				assert.Contains(t, deal.Message, "after its start epoch")
+			},
+		})
+	})
 }
 
 func TestWaitForDealCompletion(t *testing.T) {
@@ -552,6 +899,38 @@ func TestFailDeal(t *testing.T) {
 	})
 }
 
+func TestProviderNotificationEvent(t *testing.T) {
+	publishCid := tut.GenerateCids(1)[0]
+	t.Run("accepts a deal waiting for acceptance", func(t *testing.T) {
+		deal := storagemarket.ClientDeal{State: storagemarket.StorageDealCheckForAcceptance}
+		evt, args, ok := clientstates.ProviderNotificationEvent(deal, storagemarket.StorageDealStaged, "", &publishCid)
+		assert.True(t, ok)
+		assert.Equal(t, storagemarket.ClientEventDealAccepted, evt)
+		assert.Equal(t, []interface{}{&publishCid}, args)
+	})
+	t.Run("rejects a deal waiting for acceptance", func(t *testing.T) {
+		deal := storagemarket.ClientDeal{State: storagemarket.StorageDealCheckForAcceptance}
+		evt, args, ok := clientstates.ProviderNotificationEvent(deal, storagemarket.StorageDealError, "out of space", nil)
+		assert.True(t, ok)
+		assert.Equal(t, storagemarket.ClientEventDealRejected, evt)
+		assert.Equal(t, []interface{}{storagemarket.StorageDealError, "out of space"}, args)
+	})
+	t.Run("fails an accepted deal", func(t *testing.T) {
+		deal := storagemarket.ClientDeal{State: storagemarket.StorageDealSealing}
+		evt, _, ok := clientstates.ProviderNotificationEvent(deal, storagemarket.StorageDealError, "sealing failed", nil)
+		assert.True(t, ok)
+		assert.Equal(t, storagemarket.ClientEventProviderDealFailed, evt)
+	})
+	t.Run("ignores notifications that do not move the deal on", func(t *testing.T) {
+		deal := storagemarket.ClientDeal{State: storagemarket.StorageDealTransferring}
+		_, _, ok := clientstates.ProviderNotificationEvent(deal, storagemarket.StorageDealWaitingForData, "", nil)
+		assert.False(t, ok)
+		deal = storagemarket.ClientDeal{State: storagemarket.StorageDealSealing}
+		_, _, ok = clientstates.ProviderNotificationEvent(deal, storagemarket.StorageDealActive, "", nil)
+		assert.False(t, ok)
+	})
+}
+
 type envParams struct {
 	dealStream               *tut.TestStorageDealStream
 	startDataTransferError   error
@@ -561,12 +940,24 @@ type envParams struct {
 	providerDealState        *storagemarket.ProviderDealState
 	getDealStatusErr         error
 	pollingInterval          time.Duration
+	maxResubmissions         uint64
+	requestSignatureError    error
+	peerSignature            *crypto.Signature
+	peerSignatureError       error
+	// providerView is the provider's view of the deal returned by restart negotiation.
+	// If it is nil the provider is treated as unreachable
+	providerView *smnet.DealView
 }
 
 type dealStateParams struct {
 	addFundsCid   *cid.Cid
 	reserveFunds  bool
 	fastRetrieval bool
+	invoice       *storagemarket.InvoiceMetadata
+	transferAgent peer.ID
+	// noTransferChannel leaves the deal without a record of its transfer channel
+	noTransferChannel  bool
+	dealStatusProtocol string
 }
 
 type executor func(t *testing.T,
@@ -589,7 +980,13 @@ func makeExecutor(ctx context.Context,
 		assert.NoError(t, err)
 		dealState.AddFundsCid = &tut.GenerateCids(1)[0]
 		dealState.FastRetrieval = dealParams.fastRetrieval
+		dealState.Invoice = dealParams.invoice
+		dealState.DataRef.TransferAgent = dealParams.transferAgent
+		dealState.DealStatusProtocol = dealParams.dealStatusProtocol
 		dealState.TransferChannelID = &datatransfer.ChannelID{}
+		if dealParams.noTransferChannel {
+			dealState.TransferChannelID = nil
+		}
 
 		if dealParams.addFundsCid != nil {
 			dealState.AddFundsCid = dealParams.addFundsCid
@@ -606,7 +1003,12 @@ func makeExecutor(ctx context.Context,
 			providerDealState:          envParams.providerDealState,
 			getDealStatusErr:           envParams.getDealStatusErr,
 			pollingInterval:            envParams.pollingInterval,
+			maxResubmissions:           envParams.maxResubmissions,
 			peerTagger:                 tut.NewTestPeerTagger(),
+			providerView:               envParams.providerView,
+			requestSignatureError:      envParams.requestSignatureError,
+			peerSignature:              envParams.peerSignature,
+			peerSignatureError:         envParams.peerSignatureError,
 		}
 
 		if environment.pollingInterval == 0 {
@@ -642,6 +1044,7 @@ type nodeParams struct {
 	DealPreCommittedAsyncError error
 	DealCommittedSyncError     error
 	DealCommittedAsyncError    error
+	DealCommittedDelay         time.Duration
 	WaitForDealCompletionError error
 	OnDealExpiredError         error
 	OnDealSlashedError         error
@@ -671,6 +1074,12 @@ func makeNode(params nodeParams) *testnodes.FakeClientNode {
 	out.DealPreCommittedAsyncError = params.DealPreCommittedAsyncError
 	out.DealCommittedSyncError = params.DealCommittedSyncError
 	out.DealCommittedAsyncError = params.DealCommittedAsyncError
+	if params.DealCommittedDelay > 0 {
+		committed := make(chan struct{})
+		out.DelayFakeCommonNode.OnDealSectorCommitted = true
+		out.DelayFakeCommonNode.OnDealSectorCommittedChan = committed
+		time.AfterFunc(params.DealCommittedDelay, func() { close(committed) })
+	}
 	out.WaitForDealCompletionError = params.WaitForDealCompletionError
 	out.OnDealExpiredError = params.OnDealExpiredError
 	out.OnDealSlashedError = params.OnDealSlashedError
@@ -693,32 +1102,43 @@ type fakeEnvironment struct {
 	providerDealState *storagemarket.ProviderDealState
 	getDealStatusErr  error
 	pollingInterval   time.Duration
+	maxResubmissions  uint64
 	peerTagger        *tut.TestPeerTagger
+	providerView      *smnet.DealView
+
+	requestSignatureError error
+	requestSignatureCalls int
+
+	peerSignature      *crypto.Signature
+	peerSignatureError error
 }
 
 type dataTransferParams struct {
-	to       peer.ID
-	voucher  datatransfer.Voucher
-	baseCid  cid.Cid
-	selector ipld.Node
+	transferType string
+	to           peer.ID
+	voucher      datatransfer.Voucher
+	baseCid      cid.Cid
+	selector     ipld.Node
 }
 
 type restartDataTransferParams struct {
-	channelId datatransfer.ChannelID
+	transferType string
+	channelId    datatransfer.ChannelID
 }
 
-func (fe *fakeEnvironment) StartDataTransfer(_ context.Context, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error) {
+func (fe *fakeEnvironment) StartDataTransfer(_ context.Context, transferType string, to peer.ID, voucher datatransfer.Voucher, baseCid cid.Cid, selector ipld.Node) (datatransfer.ChannelID, error) {
 	fe.startDataTransferCalls = append(fe.startDataTransferCalls, dataTransferParams{
-		to:       to,
-		voucher:  voucher,
-		baseCid:  baseCid,
-		selector: selector,
+		transferType: transferType,
+		to:           to,
+		voucher:      voucher,
+		baseCid:      baseCid,
+		selector:     selector,
 	})
 	return fe.startDataTransferChannelId, fe.startDataTransferError
 }
 
-func (fe *fakeEnvironment) RestartDataTransfer(_ context.Context, channelId datatransfer.ChannelID) error {
-	fe.restartDataTransferCalls = append(fe.restartDataTransferCalls, restartDataTransferParams{channelId: channelId})
+func (fe *fakeEnvironment) RestartDataTransfer(_ context.Context, transferType string, channelId datatransfer.ChannelID) error {
+	fe.restartDataTransferCalls = append(fe.restartDataTransferCalls, restartDataTransferParams{transferType: transferType, channelId: channelId})
 
 	return fe.restartDataTransferError
 }
@@ -742,10 +1162,35 @@ func (fe *fakeEnvironment) GetProviderDealState(_ context.Context, _ cid.Cid) (*
 	return fe.providerDealState, nil
 }
 
+func (fe *fakeEnvironment) NegotiateRestart(_ context.Context, deal storagemarket.ClientDeal) (smnet.DealView, smnet.DealView, error) {
+	clientView := smnet.DealView{
+		Proposal:          deal.ProposalCid,
+		State:             deal.State,
+		TransferChannelID: deal.TransferChannelID,
+	}
+	if fe.providerView == nil {
+		return clientView, smnet.DealView{}, xerrors.New("provider unreachable")
+	}
+	return clientView, *fe.providerView, nil
+}
+
 func (fe *fakeEnvironment) PollingInterval() time.Duration {
 	return fe.pollingInterval
 }
 
+func (fe *fakeEnvironment) MaxProposalResubmissions() uint64 {
+	return fe.maxResubmissions
+}
+
+func (fe *fakeEnvironment) RequestSignature(_ context.Context, _ storagemarket.ClientDeal) error {
+	fe.requestSignatureCalls++
+	return fe.requestSignatureError
+}
+
+func (fe *fakeEnvironment) PeerSignature(_ context.Context, _ address.Address) (*crypto.Signature, error) {
+	return fe.peerSignature, fe.peerSignatureError
+}
+
 func (fe *fakeEnvironment) TagPeer(id peer.ID, ident string) {
 	fe.peerTagger.TagPeer(id, ident)
 }
@@ -762,6 +1207,7 @@ type responseParams struct {
 	message        string
 	publishMessage *cid.Cid
 	proposalCid    cid.Cid
+	retryAfter     abi.ChainEpoch
 }
 
 func testResponseReader(t *testing.T, params responseParams) tut.StorageDealResponseReader {
@@ -770,6 +1216,7 @@ func testResponseReader(t *testing.T, params responseParams) tut.StorageDealResp
 		Proposal:       params.proposalCid,
 		Message:        params.message,
 		PublishMessage: params.publishMessage,
+		RetryAfter:     params.retryAfter,
 	}
 
 	if response.Proposal == cid.Undef {
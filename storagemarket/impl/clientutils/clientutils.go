@@ -5,6 +5,7 @@ import (
 	"context"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
 	"github.com/multiformats/go-multibase"
 	"golang.org/x/xerrors"
 
@@ -13,29 +14,89 @@ import (
 	"github.com/filecoin-project/go-commp-utils/pieceio"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/crypto"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
 
 // CommP calculates the commP for a given dataref
 func CommP(ctx context.Context, pieceIO pieceio.PieceIO, rt abi.RegisteredSealProof, data *storagemarket.DataRef, storeID *multistore.StoreID) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	commp, pieceSize, _, err := CommPWithPayloadSize(ctx, pieceIO, rt, data, storeID)
+	return commp, pieceSize, err
+}
+
+// CommPWithPayloadSize calculates the commP for a given dataref like CommP, and also
+// returns the size of its data as a CAR. The payload size is zero if the dataref
+// gives the piece CID, as the data is then not read
+func CommPWithPayloadSize(ctx context.Context, pieceIO pieceio.PieceIO, rt abi.RegisteredSealProof, data *storagemarket.DataRef, storeID *multistore.StoreID) (cid.Cid, abi.UnpaddedPieceSize, uint64, error) {
 	if data.PieceCid != nil {
-		return *data.PieceCid, data.PieceSize, nil
+		return *data.PieceCid, data.PieceSize, 0, nil
 	}
 
-	if data.TransferType == storagemarket.TTManual {
-		return cid.Undef, 0, xerrors.New("Piece CID and size must be set for manual transfer")
+	if err := checkDataAvailable(data); err != nil {
+		return cid.Undef, 0, 0, err
+	}
+
+	var payloadSize uint64
+	recordSize := func(block car.Block) error {
+		payloadSize = block.Offset + block.Size
+		return nil
+	}
+	commp, paddedSize, err := pieceIO.GeneratePieceCommitment(rt, data.Root, selectors.Entire(), storeID, recordSize)
+	if err != nil {
+		return cid.Undef, 0, 0, xerrors.Errorf("generating CommP: %w", err)
+	}
+
+	return commp, paddedSize, payloadSize, nil
+}
+
+// EstimatePadding returns how much a deal for the given dataref would be padded,
+// without generating the piece commitment. If the dataref gives the piece CID, only
+// the piece size is known
+func EstimatePadding(pieceIO pieceio.PieceIO, data *storagemarket.DataRef, storeID *multistore.StoreID) (*storagemarket.PaddingEstimate, error) {
+	if data.PieceCid != nil {
+		return &storagemarket.PaddingEstimate{
+			UnpaddedPieceSize: data.PieceSize,
+			PieceSize:         data.PieceSize.Padded(),
+		}, nil
 	}
 
-	commp, paddedSize, err := pieceIO.GeneratePieceCommitment(rt, data.Root, shared.AllSelector(), storeID)
+	if err := checkDataAvailable(data); err != nil {
+		return nil, err
+	}
+
+	// the size of the CAR is known once its blocks have been traversed, before any of
+	// it is written, so the reader is closed straight away
+	reader, payloadSize, err, writeErrChan := pieceIO.GeneratePieceReader(data.Root, selectors.Entire(), storeID)
 	if err != nil {
-		return cid.Undef, 0, xerrors.Errorf("generating CommP: %w", err)
+		return nil, xerrors.Errorf("reading payload: %w", err)
+	}
+	_ = reader.Close()
+	<-writeErrChan
+
+	estimate := storagemarket.NewPaddingEstimate(payloadSize)
+	return &estimate, nil
+}
+
+// checkDataAvailable returns an error if the client cannot read the data of a
+// dataref that does not give the piece CID
+func checkDataAvailable(data *storagemarket.DataRef) error {
+	if data.TransferType == storagemarket.TTManual {
+		return xerrors.New("Piece CID and size must be set for manual transfer")
+	}
+
+	if data.TransferType == storagemarket.TTExistingPiece {
+		return xerrors.New("Piece CID and size must be set for a deal with an existing piece")
 	}
 
-	return commp, paddedSize, nil
+	if data.TransferAgent != "" {
+		return xerrors.New("Piece CID and size must be set for a deal whose data is sent by a transfer agent")
+	}
+	return nil
 }
 
 // VerifyFunc is a function that can validate a signature for a given address and bytes
@@ -69,3 +130,37 @@ func LabelField(payloadCID cid.Cid) (string, error) {
 	}
 	return payloadCID.StringOfBase(multibase.Base64)
 }
+
+// CheckDealLimits returns an error if a deal with the given parameters and padded
+// piece size breaks any of the given limits
+func CheckDealLimits(limits storagemarket.ClientDealLimits, params storagemarket.ProposeStorageDealParams, pieceSize abi.PaddedPieceSize) error {
+	if len(limits.AllowedProviders) > 0 {
+		allowed := false
+		for _, provider := range limits.AllowedProviders {
+			if provider == params.Info.Address {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return xerrors.Errorf("provider %s is not in the list of allowed providers", params.Info.Address)
+		}
+	}
+
+	duration := params.EndEpoch - params.StartEpoch
+	if limits.MaxDuration > 0 && duration > limits.MaxDuration {
+		return xerrors.Errorf("deal duration %d is greater than the maximum of %d epochs", duration, limits.MaxDuration)
+	}
+
+	if !limits.MaxPricePerEpochPerGiB.Nil() && !params.Price.Nil() {
+		// compare price / size with max / GiB without dividing, so no precision is lost
+		price := big.Mul(params.Price, big.NewInt(1<<30))
+		max := big.Mul(limits.MaxPricePerEpochPerGiB, big.NewIntUnsigned(uint64(pieceSize)))
+		if price.GreaterThan(max) {
+			return xerrors.Errorf("deal price %s per epoch for a piece of %d bytes is more than the maximum of %s per GiB per epoch",
+				params.Price, pieceSize, limits.MaxPricePerEpochPerGiB)
+		}
+	}
+
+	return nil
+}
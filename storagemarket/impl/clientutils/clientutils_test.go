@@ -1,10 +1,12 @@
 package clientutils_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"testing"
 
@@ -13,8 +15,10 @@ import (
 	"github.com/ipld/go-ipld-prime"
 	"github.com/stretchr/testify/require"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/shared_testutil"
@@ -69,6 +73,18 @@ func TestCommP(t *testing.T) {
 			require.Equal(t, ressize, pieceSize)
 		})
 
+		t.Run("with payload size", func(t *testing.T) {
+			pieceCid := shared_testutil.GenerateCids(1)[0]
+			pieceSize := abi.UnpaddedPieceSize(254)
+			storeID := multistore.StoreID(4)
+			pieceIO := &testPieceIO{t, proofType, root, allSelector, &storeID, pieceCid, pieceSize, nil}
+			respcid, ressize, payloadSize, err := clientutils.CommPWithPayloadSize(ctx, pieceIO, proofType, data, &storeID)
+			require.NoError(t, err)
+			require.Equal(t, pieceCid, respcid)
+			require.Equal(t, pieceSize, ressize)
+			require.Equal(t, uint64(150), payloadSize)
+		})
+
 		t.Run("when pieceIO fails", func(t *testing.T) {
 			expectedMsg := "something went wrong"
 			storeID := multistore.StoreID(4)
@@ -97,6 +113,14 @@ func (t *testPieceIO) GeneratePieceCommitment(rt abi.RegisteredSealProof, payloa
 	require.Equal(t.t, payloadCid, t.expectedPayloadCid)
 	require.Equal(t.t, selector, t.expectedSelector)
 	require.Equal(t.t, storeID, t.expectedStoreID)
+	if t.err == nil {
+		// a CAR whose last block section starts at 100 and is 50 bytes long
+		for _, onNewCarBlock := range userOnNewCarBlocks {
+			if err := onNewCarBlock(car.Block{Offset: 100, Size: 50}); err != nil {
+				return cid.Undef, 0, err
+			}
+		}
+	}
 	return t.pieceCID, t.pieceSize, t.err
 }
 
@@ -108,6 +132,55 @@ func (t *testPieceIO) ReadPiece(storeID *multistore.StoreID, r io.Reader) (cid.C
 	panic("not implemented")
 }
 
+type sizedPieceIO struct {
+	testPieceIO
+	payloadSize uint64
+}
+
+func (s *sizedPieceIO) GeneratePieceReader(cid.Cid, ipld.Node, *multistore.StoreID, ...car.OnNewCarBlockFunc) (io.ReadCloser, uint64, error, <-chan error) {
+	writeErr := make(chan error, 1)
+	writeErr <- io.ErrClosedPipe
+	return ioutil.NopCloser(bytes.NewReader(nil)), s.payloadSize, nil, writeErr
+}
+
+func TestEstimatePadding(t *testing.T) {
+	t.Run("from the payload", func(t *testing.T) {
+		data := &storagemarket.DataRef{
+			TransferType: storagemarket.TTGraphsync,
+			Root:         shared_testutil.GenerateCids(1)[0],
+		}
+		estimate, err := clientutils.EstimatePadding(&sizedPieceIO{payloadSize: 600}, data, nil)
+		require.NoError(t, err)
+		require.Equal(t, &storagemarket.PaddingEstimate{
+			PayloadSize:       600,
+			UnpaddedPieceSize: 1016,
+			PieceSize:         1024,
+			Overhead:          424,
+		}, estimate)
+	})
+
+	t.Run("from the piece size", func(t *testing.T) {
+		pieceCid := shared_testutil.GenerateCids(1)[0]
+		data := &storagemarket.DataRef{
+			TransferType: storagemarket.TTManual,
+			PieceCid:     &pieceCid,
+			PieceSize:    abi.PaddedPieceSize(2048).Unpadded(),
+		}
+		estimate, err := clientutils.EstimatePadding(nil, data, nil)
+		require.NoError(t, err)
+		require.Equal(t, &storagemarket.PaddingEstimate{
+			UnpaddedPieceSize: 2032,
+			PieceSize:         2048,
+		}, estimate)
+	})
+
+	t.Run("manual transfer without a piece CID", func(t *testing.T) {
+		data := &storagemarket.DataRef{TransferType: storagemarket.TTManual}
+		_, err := clientutils.EstimatePadding(nil, data, nil)
+		require.EqualError(t, err, "Piece CID and size must be set for manual transfer")
+	})
+}
+
 func TestLabelField(t *testing.T) {
 	payloadCID := shared_testutil.GenerateCids(1)[0]
 	label, err := clientutils.LabelField(payloadCID)
@@ -116,3 +189,51 @@ func TestLabelField(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, payloadCID.Equals(resultCid))
 }
+
+func TestCheckDealLimits(t *testing.T) {
+	provider := address.TestAddress
+	params := storagemarket.ProposeStorageDealParams{
+		Info:       &storagemarket.StorageProviderInfo{Address: provider},
+		StartEpoch: 100,
+		EndEpoch:   1100,
+		Price:      big.NewInt(10),
+	}
+	// a quarter of a GiB
+	pieceSize := abi.PaddedPieceSize(1 << 28)
+
+	testCases := map[string]struct {
+		limits      storagemarket.ClientDealLimits
+		expectedErr string
+	}{
+		"no limits": {},
+		"within all limits": {
+			limits: storagemarket.ClientDealLimits{
+				MaxPricePerEpochPerGiB: big.NewInt(40),
+				MaxDuration:            1000,
+				AllowedProviders:       []address.Address{address.TestAddress2, provider},
+			},
+		},
+		"price too high": {
+			limits:      storagemarket.ClientDealLimits{MaxPricePerEpochPerGiB: big.NewInt(39)},
+			expectedErr: "deal price 10 per epoch for a piece of 268435456 bytes is more than the maximum of 39 per GiB per epoch",
+		},
+		"duration too long": {
+			limits:      storagemarket.ClientDealLimits{MaxDuration: 999},
+			expectedErr: "deal duration 1000 is greater than the maximum of 999 epochs",
+		},
+		"provider not allowed": {
+			limits:      storagemarket.ClientDealLimits{AllowedProviders: []address.Address{address.TestAddress2}},
+			expectedErr: fmt.Sprintf("provider %s is not in the list of allowed providers", provider),
+		},
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := clientutils.CheckDealLimits(data.limits, params, pieceSize)
+			if data.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, data.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Package collateral provides implementations of storagemarket.CollateralPolicy, which a
+storage provider uses to decide how much collateral it commits to each deal.
+
+The provider first checks a proposal's collateral against the minimum and maximum
+allowed on chain, then asks its policy to narrow those bounds. Policies can be composed,
+for example to use a fixed multiple of the chain minimum for most clients and
+override it for a few trusted ones.
+
+Clients use ProposalCollateral to choose the provider collateral they propose, from
+the same chain bounds, with the CollateralStrategy in their proposal parameters.
+*/
+package collateral
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+type chainBounds struct{}
+
+// ChainBounds accepts any provider collateral allowed on chain. It is the policy a
+// provider uses when no other is configured
+func ChainBounds() storagemarket.CollateralPolicy {
+	return chainBounds{}
+}
+
+func (chainBounds) ProviderCollateralBounds(ctx context.Context, deal storagemarket.MinerDeal, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error) {
+	return chainMin, chainMax, nil
+}
+
+type chainMinimum struct{}
+
+// ChainMinimum only accepts proposals that commit exactly the minimum provider
+// collateral allowed on chain
+func ChainMinimum() storagemarket.CollateralPolicy {
+	return chainMinimum{}
+}
+
+func (chainMinimum) ProviderCollateralBounds(ctx context.Context, deal storagemarket.MinerDeal, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error) {
+	return chainMin, chainMin, nil
+}
+
+type fixedMultiple struct {
+	multiple uint64
+}
+
+// FixedMultiple accepts provider collateral from the chain minimum up to the given
+// multiple of it, capped at the chain maximum
+func FixedMultiple(multiple uint64) storagemarket.CollateralPolicy {
+	return fixedMultiple{multiple}
+}
+
+func (fm fixedMultiple) ProviderCollateralBounds(ctx context.Context, deal storagemarket.MinerDeal, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error) {
+	max := big.Mul(chainMin, big.NewIntUnsigned(fm.multiple))
+	if max.LessThan(chainMin) {
+		max = chainMin
+	}
+	return chainMin, big.Min(max, chainMax), nil
+}
+
+type perClient struct {
+	defaultPolicy storagemarket.CollateralPolicy
+	overrides     map[address.Address]storagemarket.CollateralPolicy
+}
+
+// PerClient applies the policy set for a deal's client in overrides, or defaultPolicy
+// if the client has none
+func PerClient(defaultPolicy storagemarket.CollateralPolicy, overrides map[address.Address]storagemarket.CollateralPolicy) storagemarket.CollateralPolicy {
+	return perClient{defaultPolicy, overrides}
+}
+
+func (pc perClient) ProviderCollateralBounds(ctx context.Context, deal storagemarket.MinerDeal, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error) {
+	policy, ok := pc.overrides[deal.Proposal.Client]
+	if !ok {
+		policy = pc.defaultPolicy
+	}
+	return policy.ProviderCollateralBounds(ctx, deal, chainMin, chainMax)
+}
+
+// ProposalCollateral returns the provider collateral a client proposes for a deal, chosen
+// from the minimum and maximum allowed on chain with the strategy in params
+func ProposalCollateral(params storagemarket.ProposeStorageDealParams, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, error) {
+	switch params.CollateralStrategy {
+	case storagemarket.CollateralGiven:
+		if params.Collateral.Int == nil || params.Collateral.IsZero() {
+			return chainMin, nil
+		}
+		if params.Collateral.LessThan(chainMin) || params.Collateral.GreaterThan(chainMax) {
+			return abi.TokenAmount{}, xerrors.Errorf("provider collateral %s is outside the bounds allowed on chain (%s to %s)", params.Collateral, chainMin, chainMax)
+		}
+		return params.Collateral, nil
+	case storagemarket.CollateralChainMinimum:
+		return chainMin, nil
+	case storagemarket.CollateralChainMultiple:
+		if params.CollateralMultiple == 0 {
+			return abi.TokenAmount{}, xerrors.New("no collateral multiple given")
+		}
+		_, max, err := FixedMultiple(params.CollateralMultiple).ProviderCollateralBounds(context.TODO(), storagemarket.MinerDeal{}, chainMin, chainMax)
+		return max, err
+	case storagemarket.CollateralChainMaximum:
+		return chainMax, nil
+	default:
+		return abi.TokenAmount{}, xerrors.Errorf("unknown collateral strategy %d", params.CollateralStrategy)
+	}
+}
@@ -0,0 +1,160 @@
+package collateral_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/collateral"
+)
+
+func TestPolicies(t *testing.T) {
+	ctx := context.Background()
+	trustedClient := address.TestAddress
+	otherClient := address.TestAddress2
+	chainMin := abi.NewTokenAmount(100)
+	chainMax := abi.NewTokenAmount(1000)
+
+	dealFor := func(client address.Address) storagemarket.MinerDeal {
+		return storagemarket.MinerDeal{
+			ClientDealProposal: market.ClientDealProposal{
+				Proposal: market.DealProposal{Client: client},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		policy      storagemarket.CollateralPolicy
+		client      address.Address
+		expectedMin abi.TokenAmount
+		expectedMax abi.TokenAmount
+	}{
+		"chain bounds": {
+			policy:      collateral.ChainBounds(),
+			expectedMin: chainMin,
+			expectedMax: chainMax,
+		},
+		"chain minimum": {
+			policy:      collateral.ChainMinimum(),
+			expectedMin: chainMin,
+			expectedMax: chainMin,
+		},
+		"fixed multiple": {
+			policy:      collateral.FixedMultiple(3),
+			expectedMin: chainMin,
+			expectedMax: abi.NewTokenAmount(300),
+		},
+		"fixed multiple capped at chain maximum": {
+			policy:      collateral.FixedMultiple(20),
+			expectedMin: chainMin,
+			expectedMax: chainMax,
+		},
+		"fixed multiple of zero": {
+			policy:      collateral.FixedMultiple(0),
+			expectedMin: chainMin,
+			expectedMax: chainMin,
+		},
+		"per client override": {
+			policy: collateral.PerClient(collateral.ChainMinimum(), map[address.Address]storagemarket.CollateralPolicy{
+				trustedClient: collateral.FixedMultiple(2),
+			}),
+			client:      trustedClient,
+			expectedMin: chainMin,
+			expectedMax: abi.NewTokenAmount(200),
+		},
+		"per client default": {
+			policy: collateral.PerClient(collateral.ChainMinimum(), map[address.Address]storagemarket.CollateralPolicy{
+				trustedClient: collateral.FixedMultiple(2),
+			}),
+			client:      otherClient,
+			expectedMin: chainMin,
+			expectedMax: chainMin,
+		},
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			min, max, err := data.policy.ProviderCollateralBounds(ctx, dealFor(data.client), chainMin, chainMax)
+			require.NoError(t, err)
+			require.Equal(t, data.expectedMin, min)
+			require.Equal(t, data.expectedMax, max)
+		})
+	}
+}
+
+func TestProposalCollateral(t *testing.T) {
+	chainMin := abi.NewTokenAmount(100)
+	chainMax := abi.NewTokenAmount(1000)
+
+	testCases := map[string]struct {
+		params           storagemarket.ProposeStorageDealParams
+		expectedErr      bool
+		expectedProposed abi.TokenAmount
+	}{
+		"given collateral": {
+			params:           storagemarket.ProposeStorageDealParams{Collateral: abi.NewTokenAmount(500)},
+			expectedProposed: abi.NewTokenAmount(500),
+		},
+		"no given collateral proposes chain minimum": {
+			params:           storagemarket.ProposeStorageDealParams{},
+			expectedProposed: chainMin,
+		},
+		"given collateral below chain minimum": {
+			params:      storagemarket.ProposeStorageDealParams{Collateral: abi.NewTokenAmount(50)},
+			expectedErr: true,
+		},
+		"given collateral above chain maximum": {
+			params:      storagemarket.ProposeStorageDealParams{Collateral: abi.NewTokenAmount(5000)},
+			expectedErr: true,
+		},
+		"chain minimum ignores given collateral": {
+			params: storagemarket.ProposeStorageDealParams{
+				Collateral:         abi.NewTokenAmount(5000),
+				CollateralStrategy: storagemarket.CollateralChainMinimum,
+			},
+			expectedProposed: chainMin,
+		},
+		"chain multiple": {
+			params: storagemarket.ProposeStorageDealParams{
+				CollateralStrategy: storagemarket.CollateralChainMultiple,
+				CollateralMultiple: 3,
+			},
+			expectedProposed: abi.NewTokenAmount(300),
+		},
+		"chain multiple capped at chain maximum": {
+			params: storagemarket.ProposeStorageDealParams{
+				CollateralStrategy: storagemarket.CollateralChainMultiple,
+				CollateralMultiple: 30,
+			},
+			expectedProposed: chainMax,
+		},
+		"chain multiple without a multiple": {
+			params:      storagemarket.ProposeStorageDealParams{CollateralStrategy: storagemarket.CollateralChainMultiple},
+			expectedErr: true,
+		},
+		"chain maximum": {
+			params:           storagemarket.ProposeStorageDealParams{CollateralStrategy: storagemarket.CollateralChainMaximum},
+			expectedProposed: chainMax,
+		},
+		"unknown strategy": {
+			params:      storagemarket.ProposeStorageDealParams{CollateralStrategy: storagemarket.CollateralChainMaximum + 1},
+			expectedErr: true,
+		},
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			proposed, err := collateral.ProposalCollateral(data.params, chainMin, chainMax)
+			if data.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, data.expectedProposed, proposed)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package storageimpl
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DefaultCommPPollInterval is how often a provider polls an external CommPVerifier
+// for the piece commitment of a deal's data
+const DefaultCommPPollInterval = 30 * time.Second
+
+// OffloadCommPVerification has a provider compute the piece commitments of the data
+// it receives for deals on an external CommPVerifier, instead of hashing the data
+// itself. Submitted jobs are polled at the given interval, or at
+// DefaultCommPPollInterval if it is zero. Providers with universal retrieval enabled
+// still compute piece commitments themselves, as they record the location of every
+// block in the piece while doing so
+func OffloadCommPVerification(verifier storagemarket.CommPVerifier, pollInterval time.Duration) StorageProviderOption {
+	return func(p *Provider) {
+		if pollInterval <= 0 {
+			pollInterval = DefaultCommPPollInterval
+		}
+		p.commPVerifier = verifier
+		p.commPPollInterval = pollInterval
+	}
+}
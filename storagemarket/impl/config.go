@@ -0,0 +1,291 @@
+package storageimpl
+
+import (
+	"context"
+	"math"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/intakequota"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/transferlimit"
+)
+
+// AskConfig is the storage ask a provider advertises
+type AskConfig struct {
+	Price         abi.TokenAmount
+	VerifiedPrice abi.TokenAmount
+	Duration      abi.ChainEpoch
+	MinPieceSize  abi.PaddedPieceSize
+	MaxPieceSize  abi.PaddedPieceSize
+	// MinDealDuration and MaxDealDuration limit the duration of the deals the
+	// provider accepts. Zero means no limit
+	MinDealDuration abi.ChainEpoch
+	MaxDealDuration abi.ChainEpoch
+}
+
+// Config is the set of provider tunables that can be changed while a provider is
+// running. Read the current values with Provider.Config, change the fields that
+// need changing and pass the result to Provider.ApplyConfig
+type Config struct {
+	// Ask is the storage ask the provider advertises. An ask with no price leaves
+	// the provider's ask unchanged
+	Ask AskConfig
+	// MaxConcurrentTransfersPerClient limits how many deals from the same client the
+	// provider receives data for at once. Zero or less means no limit
+	MaxConcurrentTransfersPerClient int
+	// DealDecider is the custom deal decision logic, or nil to accept all valid deals
+	DealDecider DealDeciderFunc
+	// CollateralPolicy decides the provider collateral to accept, or nil to accept
+	// the chain bounds
+	CollateralPolicy storagemarket.CollateralPolicy
+	// DryRun rejects every deal after deciding on it
+	DryRun bool
+	// Maintenance rejects every deal, asking clients to propose it again later
+	Maintenance bool
+	// MaintenanceUntil is the epoch maintenance ends at. Zero or less keeps the
+	// provider in maintenance until Maintenance is unset
+	MaintenanceUntil abi.ChainEpoch
+	// MaintenanceWindows are scheduled spans of epochs during which the provider is
+	// in maintenance
+	MaintenanceWindows []shared.MaintenanceWindow
+	// RejectionRetryAfter is how many epochs clients are asked to wait before
+	// proposing again a deal rejected for a transient reason. Zero or less makes
+	// those rejections final
+	RejectionRetryAfter abi.ChainEpoch
+	// AskGracePeriod is how many epochs after the ask is changed proposals that
+	// meet the previous ask are still accepted. Zero or less checks proposals
+	// against the current ask only
+	AskGracePeriod abi.ChainEpoch
+	// IntakeQuotas limit how many deals the provider accepts over time. Deals
+	// accepted before the quotas are changed still count against them
+	IntakeQuotas storagemarket.IntakeQuotas
+}
+
+// ConfigChange is the event published when a provider's config is changed
+type ConfigChange struct {
+	Previous Config
+	Current  Config
+}
+
+// ConfigSubscriber is a callback that is called when a provider's config is changed
+type ConfigSubscriber func(ConfigChange)
+
+// maintenance returns when the config puts the provider in maintenance
+func (c Config) maintenance() shared.Maintenance {
+	return shared.Maintenance{Active: c.Maintenance, Until: c.MaintenanceUntil, Windows: c.MaintenanceWindows}
+}
+
+// setMaintenance sets when the config puts the provider in maintenance
+func (c *Config) setMaintenance(m shared.Maintenance) {
+	c.Maintenance = m.Active
+	c.MaintenanceUntil = m.Until
+	c.MaintenanceWindows = m.Windows
+}
+
+func (c Config) validate() error {
+	if err := c.maintenance().Validate(); err != nil {
+		return err
+	}
+	if c.Ask.Price.Nil() {
+		return nil
+	}
+	if c.Ask.VerifiedPrice.Nil() {
+		return xerrors.New("ask verified price must be set along with price")
+	}
+	if c.Ask.Price.LessThan(big.Zero()) || c.Ask.VerifiedPrice.LessThan(big.Zero()) {
+		return xerrors.New("ask prices must not be negative")
+	}
+	if c.Ask.Duration <= 0 {
+		return xerrors.Errorf("ask duration must be positive, got %d", c.Ask.Duration)
+	}
+	if c.Ask.MinPieceSize > c.Ask.MaxPieceSize {
+		return xerrors.Errorf("ask min piece size %d is greater than max piece size %d", c.Ask.MinPieceSize, c.Ask.MaxPieceSize)
+	}
+	if c.Ask.MinDealDuration < 0 || c.Ask.MaxDealDuration < 0 {
+		return xerrors.New("ask deal durations must not be negative")
+	}
+	if c.Ask.MaxDealDuration != 0 && c.Ask.MinDealDuration > c.Ask.MaxDealDuration {
+		return xerrors.Errorf("ask min deal duration %d is greater than max deal duration %d", c.Ask.MinDealDuration, c.Ask.MaxDealDuration)
+	}
+	return nil
+}
+
+func (a AskConfig) equal(other AskConfig) bool {
+	if a.Price.Nil() || other.Price.Nil() || a.VerifiedPrice.Nil() || other.VerifiedPrice.Nil() {
+		return a.Price.Nil() && other.Price.Nil()
+	}
+	return a.Price.Equals(other.Price) &&
+		a.VerifiedPrice.Equals(other.VerifiedPrice) &&
+		a.Duration == other.Duration &&
+		a.MinPieceSize == other.MinPieceSize &&
+		a.MaxPieceSize == other.MaxPieceSize &&
+		a.MinDealDuration == other.MinDealDuration &&
+		a.MaxDealDuration == other.MaxDealDuration
+}
+
+// Config returns the provider's current tunables
+func (p *Provider) Config() Config {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.config()
+}
+
+// ApplyConfig replaces the provider's tunables with the given config while the
+// provider is running. Either all of the config is applied or, if it is invalid or
+// the ask cannot be set, none of it is. Subscribers to config changes are notified
+// once the config is applied
+func (p *Provider) ApplyConfig(cfg Config) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if err := cfg.validate(); err != nil {
+		return xerrors.Errorf("invalid provider config: %w", err)
+	}
+
+	p.configLk.Lock()
+	previous := p.config()
+	if !cfg.Ask.Price.Nil() && !previous.Ask.equal(cfg.Ask) {
+		err := p.storedAsk.SetAsk(cfg.Ask.Price, cfg.Ask.VerifiedPrice, cfg.Ask.Duration,
+			storagemarket.MinPieceSize(cfg.Ask.MinPieceSize), storagemarket.MaxPieceSize(cfg.Ask.MaxPieceSize),
+			storagemarket.MinDealDuration(cfg.Ask.MinDealDuration), storagemarket.MaxDealDuration(cfg.Ask.MaxDealDuration))
+		if err != nil {
+			p.configLk.Unlock()
+			return xerrors.Errorf("setting ask: %w", err)
+		}
+	}
+	started := p.setMaxConcurrentTransfersPerClient(cfg.MaxConcurrentTransfersPerClient)
+	p.customDealDeciderFunc = cfg.DealDecider
+	p.collateralPolicy = cfg.CollateralPolicy
+	p.dryRun = cfg.DryRun
+	p.maintenance = cfg.maintenance()
+	p.rejectionRetryAfter = cfg.RejectionRetryAfter
+	p.askGracePeriod = cfg.AskGracePeriod
+	if p.intakeQuotas != nil {
+		p.intakeQuotas.SetQuotas(cfg.IntakeQuotas)
+	} else if cfg.IntakeQuotas != (storagemarket.IntakeQuotas{}) {
+		p.intakeQuotas = intakequota.New(cfg.IntakeQuotas)
+	}
+	current := p.config()
+	p.configLk.Unlock()
+
+	for _, proposalCid := range started {
+		if err := p.deals.Send(proposalCid, storagemarket.ProviderEventTransferSlotOpened); err != nil {
+			log.Errorf("starting queued transfer for deal %s: %s", proposalCid, err)
+		}
+	}
+
+	if err := p.configSub.Publish(ConfigChange{Previous: previous, Current: current}); err != nil {
+		log.Errorf("failed to publish config change: %s", err)
+	}
+	return nil
+}
+
+// EnterMaintenance puts the provider in maintenance straight away, until the given
+// epoch or, if it is zero, until ExitMaintenance is called. New proposals are rejected
+// while the provider is in maintenance, but deals in progress carry on
+func (p *Provider) EnterMaintenance(until abi.ChainEpoch) error {
+	cfg := p.Config()
+	cfg.Maintenance = true
+	cfg.MaintenanceUntil = until
+	return p.ApplyConfig(cfg)
+}
+
+// ExitMaintenance takes the provider out of maintenance straight away, including
+// ending early any scheduled maintenance window in progress
+func (p *Provider) ExitMaintenance(ctx context.Context) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	_, curEpoch, err := p.spn.GetChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cfg := p.Config()
+	cfg.setMaintenance(cfg.maintenance().Exit(curEpoch))
+	return p.ApplyConfig(cfg)
+}
+
+// SubscribeToConfigChanges registers a listener that is called each time the
+// provider's config is changed with ApplyConfig
+func (p *Provider) SubscribeToConfigChanges(subscriber ConfigSubscriber) shared.Unsubscribe {
+	return shared.Unsubscribe(p.configSub.Subscribe(subscriber))
+}
+
+// config must be called with configLk held
+func (p *Provider) config() Config {
+	cfg := Config{
+		DealDecider:         p.customDealDeciderFunc,
+		CollateralPolicy:    p.collateralPolicy,
+		DryRun:              p.dryRun,
+		Maintenance:         p.maintenance.Active,
+		MaintenanceUntil:    p.maintenance.Until,
+		MaintenanceWindows:  p.maintenance.Windows,
+		RejectionRetryAfter: p.rejectionRetryAfter,
+		AskGracePeriod:      p.askGracePeriod,
+	}
+	if ask := p.GetAsk(); ask != nil && ask.Ask != nil {
+		cfg.Ask = AskConfig{
+			Price:         ask.Ask.Price,
+			VerifiedPrice: ask.Ask.VerifiedPrice,
+			Duration:      ask.Ask.Expiry - ask.Ask.Timestamp,
+			MinPieceSize:  ask.Ask.MinPieceSize,
+			MaxPieceSize:  ask.Ask.MaxPieceSize,
+
+			MinDealDuration: ask.Ask.MinDealDuration,
+			MaxDealDuration: ask.Ask.MaxDealDuration,
+		}
+	}
+	if p.transferLimiter != nil {
+		cfg.MaxConcurrentTransfersPerClient = p.transferLimiter.MaxPerClient()
+	}
+	if p.intakeQuotas != nil {
+		cfg.IntakeQuotas = p.intakeQuotas.Quotas()
+	}
+	return cfg
+}
+
+// setMaxConcurrentTransfersPerClient changes the per client transfer limit and returns
+// the queued deals that may now start transferring. It must be called with configLk held
+func (p *Provider) setMaxConcurrentTransfersPerClient(max int) []cid.Cid {
+	switch {
+	case max <= 0 && p.transferLimiter == nil:
+		return nil
+	case max <= 0:
+		// lifting the limit starts every queued deal
+		started := p.transferLimiter.SetMaxPerClient(math.MaxInt32)
+		p.transferLimiter = nil
+		return started
+	case p.transferLimiter == nil:
+		p.transferLimiter = transferlimit.New(max)
+		var deals []storagemarket.MinerDeal
+		if err := p.deals.List(&deals); err != nil {
+			log.Errorf("listing deals to restore transfer slots: %s", err)
+			return nil
+		}
+		p.restoreTransferSlots(deals)
+		return nil
+	default:
+		return p.transferLimiter.SetMaxPerClient(max)
+	}
+}
+
+func configDispatcher(evt pubsub.Event, fn pubsub.SubscriberFn) error {
+	change, ok := evt.(ConfigChange)
+	if !ok {
+		return xerrors.New("wrong type of event")
+	}
+	cb, ok := fn.(ConfigSubscriber)
+	if !ok {
+		return xerrors.New("wrong type of callback")
+	}
+	cb(change)
+	return nil
+}
@@ -0,0 +1,196 @@
+package storageimpl
+
+import (
+	"context"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-multistore"
+
+	"github.com/filecoin-project/go-fil-markets/dataprep"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DataPreparer sets the Preparer that ProposeStorageDealFromPath imports, splits and
+// commits data with. Its seal proof type should match the sector size of the
+// providers deals are proposed to
+func DataPreparer(preparer *dataprep.Preparer) StorageClientOption {
+	return func(c *Client) {
+		c.dataPreparer = preparer
+	}
+}
+
+/*
+ProposeStorageDealFromPath prepares the file or directory at path for storage, and
+proposes a deal with the given provider for each piece of it, on the terms in params.
+
+The data is imported into UnixFS, split into pieces that each fit in a sector, and
+each piece is written to a CAR and committed, by the client's DataPreparer. Each
+piece is then proposed as a deal. With graphsync transfers, the piece's CAR is first
+loaded into a new store, which the deal transfers its data from. Progress is passed
+to params.Progress as each stage finishes, and the deals can be followed through
+transfer and sealing with SubscribeToEvents.
+
+The pipeline's progress is saved after each stage, so calling
+ProposeStorageDealFromPath again with the same path and JobID, after it fails or the
+client restarts, skips the stages that finished and the pieces already proposed. The
+result lists the proposals of every piece, including those proposed by earlier
+calls.
+*/
+func (c *Client) ProposeStorageDealFromPath(ctx context.Context, path string, info *storagemarket.StorageProviderInfo, params storagemarket.ProposeStorageDealFromPathParams) (*storagemarket.ProposeStorageDealFromPathResult, error) {
+	if c.dataPreparer == nil {
+		return nil, xerrors.New("client has no data preparer to prepare the path with")
+	}
+	if info == nil {
+		return nil, xerrors.New("deals from a path must have a provider")
+	}
+	jobID := params.JobID
+	if jobID == "" {
+		jobID = path
+	}
+	transferType := params.TransferType
+	if transferType == "" {
+		transferType = storagemarket.TTGraphsync
+	}
+	if transferType != storagemarket.TTGraphsync && transferType != storagemarket.TTManual {
+		return nil, xerrors.Errorf("deals from a path cannot use transfer type %s", transferType)
+	}
+
+	progress := func(stage storagemarket.PathDealStage, job dataprep.Job, piece int, proposalCid cid.Cid) {
+		if params.Progress == nil {
+			return
+		}
+		params.Progress(storagemarket.PathDealProgress{
+			JobID:       jobID,
+			Stage:       stage,
+			Root:        job.Root,
+			Piece:       piece,
+			Pieces:      len(job.Pieces),
+			ProposalCid: proposalCid,
+		})
+	}
+	stages := map[dataprep.Step]storagemarket.PathDealStage{
+		dataprep.StepImported:  storagemarket.PathDealImported,
+		dataprep.StepSplit:     storagemarket.PathDealSplit,
+		dataprep.StepCommitted: storagemarket.PathDealPieceReady,
+	}
+
+	propose := func(ctx context.Context, job dataprep.Job, piece dataprep.Piece) error {
+		proposalCid, err := c.proposePreparedPiece(ctx, job, piece, info, params.Deal, transferType)
+		if err != nil {
+			return err
+		}
+		progress(storagemarket.PathDealProposed, job, pieceIndex(job, piece), proposalCid)
+		return nil
+	}
+	job, err := c.dataPreparer.Prepare(ctx, jobID, path, propose, dataprep.ReportProgress(func(job dataprep.Job, step dataprep.Step, piece int) {
+		progress(stages[step], job, piece, cid.Undef)
+	}))
+	if job == nil {
+		return nil, err
+	}
+
+	result := &storagemarket.ProposeStorageDealFromPathResult{Root: job.Root, Manifest: job.Manifest}
+	proposals, listErr := c.pieceProposals(ctx, job, info)
+	if listErr != nil {
+		if err == nil {
+			err = listErr
+		}
+		return result, err
+	}
+	result.ProposalCids = proposals
+	return result, err
+}
+
+// proposePreparedPiece proposes a deal for one piece of a prepared dataset
+func (c *Client) proposePreparedPiece(ctx context.Context, job dataprep.Job, piece dataprep.Piece, info *storagemarket.StorageProviderInfo, params storagemarket.ProposeStorageDealParams, transferType string) (cid.Cid, error) {
+	if uint64(piece.PieceSize.Padded()) > info.SectorSize {
+		return cid.Undef, xerrors.Errorf("piece of %d bytes does not fit in the provider's %d byte sectors", piece.PieceSize.Padded(), info.SectorSize)
+	}
+
+	params.Info = info
+	params.Data = piece.DataRef()
+	params.StoreID = nil
+	params.Envelope = job.Envelope
+	if transferType == storagemarket.TTGraphsync {
+		storeID, err := c.loadPieceCAR(piece)
+		if err != nil {
+			return cid.Undef, err
+		}
+		// the client commits the deal's data from the store, as it is transferred
+		params.Data = &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: piece.Root}
+		params.StoreID = &storeID
+	}
+
+	res, err := c.ProposeStorageDeal(ctx, params)
+	if err != nil {
+		if params.StoreID != nil {
+			_ = c.multiStore.Delete(*params.StoreID)
+		}
+		return cid.Undef, err
+	}
+	return res.ProposalCid, nil
+}
+
+// loadPieceCAR loads the CAR of a piece into a new store
+func (c *Client) loadPieceCAR(piece dataprep.Piece) (multistore.StoreID, error) {
+	storeID := c.multiStore.Next()
+	store, err := c.multiStore.Get(storeID)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to open store %d: %w", storeID, err)
+	}
+	f, err := os.Open(piece.CARPath)
+	if err != nil {
+		_ = c.multiStore.Delete(storeID)
+		return 0, err
+	}
+	defer f.Close() // nolint: errcheck
+	if _, err := car.LoadCar(store.Bstore, f); err != nil {
+		_ = c.multiStore.Delete(storeID)
+		return 0, xerrors.Errorf("loading CAR %s: %w", piece.CARPath, err)
+	}
+	return storeID, nil
+}
+
+// pieceProposals returns the proposal of the most recent deal with the provider for
+// each piece of a job that has been proposed, in piece order
+func (c *Client) pieceProposals(ctx context.Context, job *dataprep.Job, info *storagemarket.StorageProviderInfo) ([]cid.Cid, error) {
+	deals, err := c.ListLocalDeals(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing deals: %w", err)
+	}
+	latest := make(map[cid.Cid]storagemarket.ClientDeal)
+	for _, deal := range deals {
+		if deal.DataRef == nil || deal.Proposal.Provider != info.Address {
+			continue
+		}
+		if prev, ok := latest[deal.DataRef.Root]; ok && !prev.CreationTime.Time().Before(deal.CreationTime.Time()) {
+			continue
+		}
+		latest[deal.DataRef.Root] = deal
+	}
+
+	var proposals []cid.Cid
+	for _, piece := range job.Pieces {
+		if !piece.Handled {
+			continue
+		}
+		if deal, ok := latest[piece.Root]; ok {
+			proposals = append(proposals, deal.ProposalCid)
+		}
+	}
+	return proposals, nil
+}
+
+// pieceIndex returns the index of a piece in its job
+func pieceIndex(job dataprep.Job, piece dataprep.Piece) int {
+	for i := range job.Pieces {
+		if job.Pieces[i].Root == piece.Root {
+			return i
+		}
+	}
+	return -1
+}
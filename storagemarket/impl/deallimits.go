@@ -0,0 +1,41 @@
+package storageimpl
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// LimitDeals makes the client refuse to propose deals that break the given limits.
+// The limits can be changed later with Client.SetDealLimits
+func LimitDeals(limits storagemarket.ClientDealLimits) StorageClientOption {
+	return func(c *Client) {
+		c.dealLimits = limits
+	}
+}
+
+// DealLimits returns the limits the client checks deals against before proposing them
+func (c *Client) DealLimits() storagemarket.ClientDealLimits {
+	c.dealLimitsLk.RLock()
+	defer c.dealLimitsLk.RUnlock()
+	return c.dealLimits
+}
+
+// SetDealLimits replaces the limits the client checks deals against while the client
+// is running. Deals already proposed are not affected
+func (c *Client) SetDealLimits(limits storagemarket.ClientDealLimits) error {
+	if limits.MaxDuration < 0 {
+		return xerrors.Errorf("invalid deal limits: max duration must not be negative, got %d", limits.MaxDuration)
+	}
+	if !limits.MaxPricePerEpochPerGiB.Nil() && limits.MaxPricePerEpochPerGiB.LessThan(big.Zero()) {
+		return xerrors.New("invalid deal limits: max price must not be negative")
+	}
+	limits.AllowedProviders = append(limits.AllowedProviders[:0:0], limits.AllowedProviders...)
+
+	c.dealLimitsLk.Lock()
+	c.dealLimits = limits
+	c.dealLimitsLk.Unlock()
+	return nil
+}
@@ -0,0 +1,44 @@
+package storageimpl
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/deallog"
+)
+
+// PersistDealLogs keeps the log lines the provider emits for each deal in the given
+// datastore, so that they survive restarts along with the deals. Without it, deal
+// logs are kept in memory. It must be passed to NewProvider
+func PersistDealLogs(ds datastore.Batching) StorageProviderOption {
+	return func(p *Provider) {
+		p.dealLogsDs = ds
+	}
+}
+
+// DealLogEntries sets the number of log lines kept for each deal, the most recent
+// ones. It must be passed to NewProvider
+func DealLogEntries(n int) StorageProviderOption {
+	return func(p *Provider) {
+		p.dealLogMaxEntries = n
+	}
+}
+
+func (p *Provider) newDealLog() *deallog.Log {
+	if p.dealLogsDs == nil {
+		p.dealLogsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	var options []deallog.Option
+	if p.dealLogMaxEntries > 0 {
+		options = append(options, deallog.MaxEntries(p.dealLogMaxEntries))
+	}
+	return deallog.New(p.dealLogsDs, options...)
+}
+
+// GetDealLogs returns the most recent log lines the provider emitted while handling
+// the deal with the given proposal CID, oldest first
+func (p *Provider) GetDealLogs(proposalCid cid.Cid) ([]storagemarket.DealLogEntry, error) {
+	return p.dealLogs.Entries(proposalCid)
+}
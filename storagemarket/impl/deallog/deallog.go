@@ -0,0 +1,131 @@
+/*
+Package deallog keeps the log lines a storage provider emits while handling each
+deal, so that support can read back what happened to a single deal without
+searching the node's logs.
+
+Lines are recorded against the proposal CID of the deal they are about, in a buffer
+per deal that keeps the most recent MaxEntries lines. Buffers are written to a
+datastore as lines are recorded, so that they survive restarts along with the deals.
+*/
+package deallog
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DefaultMaxEntries is the number of lines kept for each deal
+const DefaultMaxEntries = 100
+
+// MaxMessageLength is the longest message kept for a line. Longer messages are
+// truncated
+const MaxMessageLength = 1024
+
+// Log levels of recorded lines
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Log records the log lines emitted for each deal
+type Log struct {
+	ds         datastore.Batching
+	maxEntries int
+
+	lk sync.Mutex
+}
+
+// Option configures a Log
+type Option func(l *Log)
+
+// MaxEntries sets the number of lines kept for each deal
+func MaxEntries(n int) Option {
+	return func(l *Log) {
+		l.maxEntries = n
+	}
+}
+
+// New returns a Log that keeps its buffers in the given datastore
+func New(ds datastore.Batching, options ...Option) *Log {
+	l := &Log{
+		ds:         ds,
+		maxEntries: DefaultMaxEntries,
+	}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+// Record adds a line to the buffer of the deal with the given proposal CID,
+// dropping the oldest line if the buffer is full
+func (l *Log) Record(proposalCid cid.Cid, level string, message string) error {
+	if len(message) > MaxMessageLength {
+		message = message[:MaxMessageLength]
+	}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	entries, err := l.entries(proposalCid)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, storagemarket.DealLogEntry{Time: time.Now(), Level: level, Message: message})
+	if len(entries) > l.maxEntries {
+		entries = entries[len(entries)-l.maxEntries:]
+	}
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := l.ds.Put(key(proposalCid), value); err != nil {
+		return xerrors.Errorf("writing log of deal %s: %w", proposalCid, err)
+	}
+	return nil
+}
+
+// Entries returns the lines recorded for the deal with the given proposal CID,
+// oldest first
+func (l *Log) Entries(proposalCid cid.Cid) ([]storagemarket.DealLogEntry, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.entries(proposalCid)
+}
+
+// Delete drops the lines recorded for the deal with the given proposal CID
+func (l *Log) Delete(proposalCid cid.Cid) error {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if err := l.ds.Delete(key(proposalCid)); err != nil {
+		return xerrors.Errorf("deleting log of deal %s: %w", proposalCid, err)
+	}
+	return nil
+}
+
+func (l *Log) entries(proposalCid cid.Cid) ([]storagemarket.DealLogEntry, error) {
+	value, err := l.ds.Get(key(proposalCid))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("reading log of deal %s: %w", proposalCid, err)
+	}
+	var entries []storagemarket.DealLogEntry
+	if err := json.Unmarshal(value, &entries); err != nil {
+		return nil, xerrors.Errorf("decoding log of deal %s: %w", proposalCid, err)
+	}
+	return entries, nil
+}
+
+func key(proposalCid cid.Cid) datastore.Key {
+	return datastore.NewKey(proposalCid.String())
+}
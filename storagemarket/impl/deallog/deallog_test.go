@@ -0,0 +1,49 @@
+package deallog_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/deallog"
+)
+
+func TestLog(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	deals := shared_testutil.GenerateCids(2)
+	l := deallog.New(ds, deallog.MaxEntries(2))
+
+	entries, err := l.Entries(deals[0])
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, l.Record(deals[0], deallog.LevelInfo, "first"))
+	require.NoError(t, l.Record(deals[1], deallog.LevelInfo, "other deal"))
+	require.NoError(t, l.Record(deals[0], deallog.LevelWarn, "second"))
+	require.NoError(t, l.Record(deals[0], deallog.LevelError, strings.Repeat("x", deallog.MaxMessageLength+1)))
+
+	// the oldest line is dropped, and long messages are truncated
+	entries, err = l.Entries(deals[0])
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, deallog.LevelWarn, entries[0].Level)
+	require.Equal(t, "second", entries[0].Message)
+	require.Equal(t, deallog.LevelError, entries[1].Level)
+	require.Len(t, entries[1].Message, deallog.MaxMessageLength)
+	require.False(t, entries[1].Time.Before(entries[0].Time))
+
+	// lines survive a restart
+	entries, err = deallog.New(ds).Entries(deals[1])
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "other deal", entries[0].Message)
+
+	require.NoError(t, l.Delete(deals[1]))
+	entries, err = l.Entries(deals[1])
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
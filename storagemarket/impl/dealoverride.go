@@ -0,0 +1,117 @@
+package storageimpl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-statemachine/fsm"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/deallog"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
+)
+
+// InspectDeal returns a deal with how long it has been in its state, its recent log
+// lines and which operator overrides can be applied to it
+func (p *Provider) InspectDeal(proposalCid cid.Cid) (storagemarket.DealInspection, error) {
+	var deal storagemarket.MinerDeal
+	if err := p.deals.Get(proposalCid).Get(&deal); err != nil {
+		return storagemarket.DealInspection{}, xerrors.Errorf("getting deal %s: %w", proposalCid, err)
+	}
+	logs, err := p.dealLogs.Entries(proposalCid)
+	if err != nil {
+		return storagemarket.DealInspection{}, err
+	}
+	entered := p.stateTimes.Entered(proposalCid, deal.State)
+	return storagemarket.DealInspection{
+		Deal:      deal,
+		Entered:   entered,
+		Stuck:     shared.Stuck(entered, p.expectedDwellTimes[deal.State], time.Now()),
+		CanFail:   inStates(deal.State, providerstates.OperatorFailStates),
+		CanRetry:  inStates(deal.State, providerstates.OperatorRetryStates),
+		AdvanceTo: providerstates.OperatorAdvances[deal.State],
+		Logs:      logs,
+	}, nil
+}
+
+// ForceFailDeal fails a stuck deal, cleaning up after it as for any failed deal. The
+// reason is recorded in the deal's message and log. Deals that are active on chain,
+// or that already ended, cannot be failed
+func (p *Provider) ForceFailDeal(proposalCid cid.Cid, reason string) error {
+	return p.override(proposalCid, reason, func(deal storagemarket.MinerDeal) (string, error) {
+		if !inStates(deal.State, providerstates.OperatorFailStates) {
+			return "", xerrors.Errorf("deal %s in state %s cannot be failed", proposalCid, storagemarket.DealStates[deal.State])
+		}
+		return "operator failed deal", p.deals.Send(proposalCid, storagemarket.ProviderEventOperatorFailed, reason)
+	})
+}
+
+// ForceRetryDeal runs the handler of a stuck deal's state again, as when the
+// provider restarts. The reason is recorded in the deal's log. Only the states whose
+// handlers can safely run again can be retried
+func (p *Provider) ForceRetryDeal(proposalCid cid.Cid, reason string) error {
+	return p.override(proposalCid, reason, func(deal storagemarket.MinerDeal) (string, error) {
+		if !inStates(deal.State, providerstates.OperatorRetryStates) {
+			return "", xerrors.Errorf("deal %s in state %s cannot be retried", proposalCid, storagemarket.DealStates[deal.State])
+		}
+		return "operator retried deal", p.deals.Send(proposalCid, storagemarket.ProviderEventOperatorRetried)
+	})
+}
+
+// ForceAdvanceDeal moves a stuck deal on to the given state, which must be the state
+// the deal moves to once what it waits for happens, such as to wait for the data of
+// a deal whose transfer is queued. Deals waiting on chain cannot be advanced, only
+// retried. The reason is recorded in the deal's message and log
+func (p *Provider) ForceAdvanceDeal(proposalCid cid.Cid, to storagemarket.StorageDealStatus, reason string) error {
+	return p.override(proposalCid, reason, func(deal storagemarket.MinerDeal) (string, error) {
+		next, ok := providerstates.OperatorAdvances[deal.State]
+		if !ok {
+			return "", xerrors.Errorf("deal %s in state %s cannot be advanced", proposalCid, storagemarket.DealStates[deal.State])
+		}
+		if next != to {
+			return "", xerrors.Errorf("deal %s in state %s can only be advanced to %s, not %s", proposalCid, storagemarket.DealStates[deal.State], storagemarket.DealStates[next], storagemarket.DealStates[to])
+		}
+		action := fmt.Sprintf("operator advanced deal to %s", storagemarket.DealStates[to])
+		return action, p.deals.Send(proposalCid, storagemarket.ProviderEventOperatorAdvanced, reason)
+	})
+}
+
+// override applies an operator override to a deal, if apply allows it in the deal's
+// current state, and records it in the deal's log. apply returns what was done. The
+// deal can change state between the check and the override being applied, in which
+// case the state machine drops the override
+func (p *Provider) override(proposalCid cid.Cid, reason string, apply func(deal storagemarket.MinerDeal) (string, error)) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if reason == "" {
+		return xerrors.New("a reason is required to override a deal")
+	}
+	var deal storagemarket.MinerDeal
+	if err := p.deals.Get(proposalCid).Get(&deal); err != nil {
+		return xerrors.Errorf("getting deal %s: %w", proposalCid, err)
+	}
+	action, err := apply(deal)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s in state %s: %s", action, storagemarket.DealStates[deal.State], reason)
+	log.Warnf("deal %s: %s", proposalCid, message)
+	if err := p.dealLogs.Record(proposalCid, deallog.LevelWarn, message); err != nil {
+		log.Warnf("recording log line of deal %s: %s", proposalCid, err)
+	}
+	return nil
+}
+
+func inStates(state storagemarket.StorageDealStatus, states []fsm.StateKey) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package storageimpl
+
+import (
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// pipelineNext are the states deals in progress move to next if all goes well.
+// Deals in states that are not listed are not in progress
+var pipelineNext = map[storagemarket.StorageDealStatus]storagemarket.StorageDealStatus{
+	storagemarket.StorageDealValidating:              storagemarket.StorageDealAcceptWait,
+	storagemarket.StorageDealAcceptWait:              storagemarket.StorageDealWaitingForData,
+	storagemarket.StorageDealTransferQueued:          storagemarket.StorageDealWaitingForData,
+	storagemarket.StorageDealWaitingForData:          storagemarket.StorageDealTransferring,
+	storagemarket.StorageDealTransferring:            storagemarket.StorageDealVerifyData,
+	storagemarket.StorageDealProviderTransferRestart: storagemarket.StorageDealTransferring,
+	storagemarket.StorageDealVerifyData:              storagemarket.StorageDealReserveProviderFunds,
+	storagemarket.StorageDealReserveProviderFunds:    storagemarket.StorageDealPublish,
+	storagemarket.StorageDealProviderFunding:         storagemarket.StorageDealPublish,
+	storagemarket.StorageDealPublish:                 storagemarket.StorageDealPublishing,
+	storagemarket.StorageDealPublishing:              storagemarket.StorageDealStaged,
+	storagemarket.StorageDealStaged:                  storagemarket.StorageDealAwaitingPreCommit,
+	storagemarket.StorageDealAwaitingPreCommit:       storagemarket.StorageDealSealing,
+	storagemarket.StorageDealSealing:                 storagemarket.StorageDealFinalizing,
+	storagemarket.StorageDealFinalizing:              storagemarket.StorageDealActive,
+	storagemarket.StorageDealRejecting:               storagemarket.StorageDealFailing,
+	storagemarket.StorageDealFailing:                 storagemarket.StorageDealError,
+	storagemarket.StorageDealProposalRetryWait:       storagemarket.StorageDealValidating,
+}
+
+// pipelineBlockers are what deals wait on in each state. Deals in states that are
+// not listed are being worked on by the provider
+var pipelineBlockers = map[storagemarket.StorageDealStatus]storagemarket.DealBlocker{
+	storagemarket.StorageDealAcceptWait:              storagemarket.DealBlockerDecision,
+	storagemarket.StorageDealTransferQueued:          storagemarket.DealBlockerTransferSlot,
+	storagemarket.StorageDealWaitingForData:          storagemarket.DealBlockerClient,
+	storagemarket.StorageDealProposalRetryWait:       storagemarket.DealBlockerClient,
+	storagemarket.StorageDealTransferring:            storagemarket.DealBlockerTransfer,
+	storagemarket.StorageDealProviderTransferRestart: storagemarket.DealBlockerTransfer,
+	storagemarket.StorageDealProviderFunding:         storagemarket.DealBlockerChainMessage,
+	storagemarket.StorageDealPublishing:              storagemarket.DealBlockerChainMessage,
+	storagemarket.StorageDealStaged:                  storagemarket.DealBlockerSealing,
+	storagemarket.StorageDealAwaitingPreCommit:       storagemarket.DealBlockerSealing,
+	storagemarket.StorageDealSealing:                 storagemarket.DealBlockerSealing,
+}
+
+// DealPipeline returns the deals in progress, with where each is in the deal flow
+// and what it is waiting on. As with DealSummary, the time a deal entered its state
+// is kept in memory, so deals that have not changed state since the provider
+// started are taken to have entered their state when it started
+func (p *Provider) DealPipeline() (storagemarket.DealPipeline, error) {
+	deals, err := p.ListLocalDeals()
+	if err != nil {
+		return storagemarket.DealPipeline{}, err
+	}
+
+	now := time.Now()
+	pipeline := storagemarket.DealPipeline{Time: now}
+	for _, deal := range deals {
+		next, ok := pipelineNext[deal.State]
+		if !ok {
+			continue
+		}
+		// data for manual transfers is imported and verified in one go
+		if deal.State == storagemarket.StorageDealWaitingForData && deal.Ref != nil && deal.Ref.TransferType == storagemarket.TTManual {
+			next = storagemarket.StorageDealVerifyData
+		}
+		entered := p.stateTimes.Entered(deal.ProposalCid, deal.State)
+		dwell := p.expectedDwellTimes[deal.State]
+		pd := storagemarket.PipelineDeal{
+			ProposalCid: deal.ProposalCid,
+			Client:      deal.Client,
+			State:       deal.State,
+			Entered:     entered,
+			Next:        next,
+			Stuck:       shared.Stuck(entered, dwell, now),
+			Blocker:     pipelineBlockers[deal.State],
+		}
+		if dwell > 0 {
+			pd.ExpectedBy = entered.Add(dwell)
+		}
+		switch pd.Blocker {
+		case storagemarket.DealBlockerChainMessage:
+			pd.Message = deal.PublishCid
+			if deal.State == storagemarket.StorageDealProviderFunding {
+				pd.Message = deal.AddFundsCid
+			}
+		case storagemarket.DealBlockerTransfer:
+			pd.TransferChannelID = deal.TransferChannelId
+		case storagemarket.DealBlockerSealing:
+			pd.SectorNumber = deal.SectorNumber
+		}
+		pipeline.Deals = append(pipeline.Deals, pd)
+	}
+	sort.SliceStable(pipeline.Deals, func(i, j int) bool {
+		return pipeline.Deals[i].Entered.Before(pipeline.Deals[j].Entered)
+	})
+	return pipeline, nil
+}
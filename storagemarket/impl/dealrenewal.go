@@ -0,0 +1,62 @@
+package storageimpl
+
+import (
+	"context"
+
+	"github.com/hannahhoward/go-pubsub"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrenewal"
+)
+
+// AutoRenewDeals has the client propose a replacement for each of its active deals
+// that is nearing its end, on the terms the given policy returns. Renewals are
+// recorded in the client's datastore, so this option only takes effect when passed
+// to NewClient
+func AutoRenewDeals(policy storagemarket.RenewalPolicy, options ...dealrenewal.Option) StorageClientOption {
+	return func(c *Client) {
+		c.renewalPolicy = policy
+		c.renewalOptions = options
+	}
+}
+
+// ListDealRenewals lists the deals nearing their end that the client has renewed,
+// or tried to renew. It is empty unless the client was configured with
+// AutoRenewDeals
+func (c *Client) ListDealRenewals(ctx context.Context) ([]storagemarket.DealRenewal, error) {
+	if c.renewals == nil {
+		return nil, nil
+	}
+	return c.renewals.List()
+}
+
+// SubscribeToRenewalEvents listens for the renewal of deals nearing their end
+func (c *Client) SubscribeToRenewalEvents(subscriber storagemarket.RenewalSubscriber) shared.Unsubscribe {
+	return shared.Unsubscribe(c.renewalSub.Subscribe(subscriber))
+}
+
+func (c *Client) notifyRenewal(event storagemarket.RenewalEvent, renewal storagemarket.DealRenewal) {
+	if err := c.renewalSub.Publish(internalRenewalEvent{event, renewal}); err != nil {
+		log.Errorf("failed to publish renewal event %s: %s", storagemarket.RenewalEvents[event], err)
+	}
+}
+
+type internalRenewalEvent struct {
+	evt     storagemarket.RenewalEvent
+	renewal storagemarket.DealRenewal
+}
+
+func renewalDispatcher(evt pubsub.Event, fn pubsub.SubscriberFn) error {
+	ie, ok := evt.(internalRenewalEvent)
+	if !ok {
+		return xerrors.New("wrong type of event")
+	}
+	cb, ok := fn.(storagemarket.RenewalSubscriber)
+	if !ok {
+		return xerrors.New("wrong type of callback")
+	}
+	cb(ie.evt, ie.renewal)
+	return nil
+}
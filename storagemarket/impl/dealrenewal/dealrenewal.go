@@ -0,0 +1,273 @@
+/*
+Package dealrenewal renews a storage client's deals before they end, so that data
+stays stored without the client having to watch for expiring deals.
+
+A Manager checks the client's active deals at a fixed interval. Once a deal is
+within the renewal lead of its EndEpoch, the Manager asks a RenewalPolicy whether
+and how to renew it, proposes the replacement deal, and records the link between
+the old and the new deal in the datastore. A renewal that fails is tried again at
+the next check, until the deal ends.
+*/
+package dealrenewal
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("storagemarket_dealrenewal")
+
+// DefaultCheckInterval is how often the manager looks for deals to renew
+const DefaultCheckInterval = 10 * time.Minute
+
+// DefaultRenewalLead is how many epochs before a deal ends the manager renews it,
+// which is about a week
+const DefaultRenewalLead = abi.ChainEpoch(7 * 2880)
+
+// ListDealsFunc returns the client's deals
+type ListDealsFunc func(ctx context.Context) ([]storagemarket.ClientDeal, error)
+
+// ChainHeadFunc returns the current chain epoch
+type ChainHeadFunc func(ctx context.Context) (abi.ChainEpoch, error)
+
+// ProposeFunc sends the proposal for a replacement deal and returns its proposal CID
+type ProposeFunc func(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error)
+
+// NotifyFunc is called with each renewal event
+type NotifyFunc func(event storagemarket.RenewalEvent, renewal storagemarket.DealRenewal)
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// CheckInterval sets how often the manager looks for deals to renew
+func CheckInterval(interval time.Duration) Option {
+	return func(m *Manager) {
+		m.checkInterval = interval
+	}
+}
+
+// RenewalLead sets how many epochs before a deal ends the manager renews it
+func RenewalLead(lead abi.ChainEpoch) Option {
+	return func(m *Manager) {
+		m.lead = lead
+	}
+}
+
+// Manager renews a client's deals as they near their end
+type Manager struct {
+	ds            datastore.Batching
+	policy        storagemarket.RenewalPolicy
+	listDeals     ListDealsFunc
+	chainHead     ChainHeadFunc
+	propose       ProposeFunc
+	notify        NotifyFunc
+	checkInterval time.Duration
+	lead          abi.ChainEpoch
+
+	lk     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns a manager that renews deals according to policy and records the
+// renewals in the given datastore
+func New(ds datastore.Batching, policy storagemarket.RenewalPolicy, listDeals ListDealsFunc, chainHead ChainHeadFunc, propose ProposeFunc, notify NotifyFunc, options ...Option) *Manager {
+	m := &Manager{
+		ds:            ds,
+		policy:        policy,
+		listDeals:     listDeals,
+		chainHead:     chainHead,
+		propose:       propose,
+		notify:        notify,
+		checkInterval: DefaultCheckInterval,
+		lead:          DefaultRenewalLead,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// List returns the deals the manager has renewed, or tried to renew, ordered by the
+// epoch of the last attempt
+func (m *Manager) List() ([]storagemarket.DealRenewal, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.load()
+}
+
+// Start begins renewing deals as they near their end
+func (m *Manager) Start(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx)
+}
+
+// Stop ends renewing deals
+func (m *Manager) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+func (m *Manager) run(ctx context.Context) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		m.checkRenewals(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkRenewals renews each active deal that is within the renewal lead of its end
+// and has not been renewed or declined
+func (m *Manager) checkRenewals(ctx context.Context) {
+	deals, err := m.listDeals(ctx)
+	if err != nil {
+		log.Errorf("listing deals to renew: %s", err)
+		return
+	}
+	epoch, err := m.chainHead(ctx)
+	if err != nil {
+		log.Warnf("getting chain head to check deal renewals: %s", err)
+		return
+	}
+
+	for _, deal := range deals {
+		end := deal.Proposal.EndEpoch
+		if deal.State != storagemarket.StorageDealActive || epoch < end-m.lead || epoch >= end {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		m.renewDeal(ctx, deal, epoch)
+	}
+}
+
+func (m *Manager) renewDeal(ctx context.Context, deal storagemarket.ClientDeal, epoch abi.ChainEpoch) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	renewal, err := m.get(deal.ProposalCid)
+	if err != nil {
+		if !xerrors.Is(err, datastore.ErrNotFound) {
+			log.Errorf("loading renewal of deal %s: %s", deal.ProposalCid, err)
+			return
+		}
+		// the provider is replaced with the one the policy chooses, if it renews the deal
+		renewal = storagemarket.DealRenewal{OldDeal: deal.ProposalCid, Provider: deal.Proposal.Provider}
+	}
+	if renewal.NewDeal != nil || renewal.Declined {
+		return
+	}
+	renewal.Epoch = epoch
+
+	event := m.attempt(ctx, deal, epoch, &renewal)
+	if err := m.save(renewal); err != nil {
+		log.Errorf("saving renewal of deal %s: %s", deal.ProposalCid, err)
+	}
+	if m.notify != nil {
+		m.notify(event, renewal)
+	}
+}
+
+// attempt asks the policy how to renew a deal and proposes the replacement, and
+// returns the resulting event
+func (m *Manager) attempt(ctx context.Context, deal storagemarket.ClientDeal, epoch abi.ChainEpoch, renewal *storagemarket.DealRenewal) storagemarket.RenewalEvent {
+	replacement, renew, err := m.policy.Renew(ctx, deal, epoch)
+	if err != nil {
+		log.Warnf("deciding renewal of deal %s failed, retrying in %s: %s", deal.ProposalCid, m.checkInterval, err)
+		renewal.Message = err.Error()
+		return storagemarket.RenewalEventFailed
+	}
+	if !renew {
+		log.Infof("renewal policy declined to renew deal %s", deal.ProposalCid)
+		renewal.Declined = true
+		renewal.Message = ""
+		return storagemarket.RenewalEventDeclined
+	}
+
+	renewal.Provider = replacement.Provider
+	proposalCid, err := m.propose(ctx, replacement)
+	if err != nil {
+		log.Warnf("proposing renewal of deal %s failed, retrying in %s: %s", deal.ProposalCid, m.checkInterval, err)
+		renewal.Message = err.Error()
+		return storagemarket.RenewalEventFailed
+	}
+	log.Infof("proposed deal %s to renew deal %s", proposalCid, deal.ProposalCid)
+	renewal.NewDeal = &proposalCid
+	renewal.Message = ""
+	return storagemarket.RenewalEventProposed
+}
+
+func (m *Manager) save(renewal storagemarket.DealRenewal) error {
+	b, err := cborutil.Dump(&renewal)
+	if err != nil {
+		return err
+	}
+	return m.ds.Put(key(renewal.OldDeal), b)
+}
+
+func (m *Manager) get(oldDeal cid.Cid) (storagemarket.DealRenewal, error) {
+	var renewal storagemarket.DealRenewal
+	b, err := m.ds.Get(key(oldDeal))
+	if err != nil {
+		return renewal, err
+	}
+	err = cborutil.ReadCborRPC(bytes.NewReader(b), &renewal)
+	return renewal, err
+}
+
+// load returns the recorded renewals ordered by the epoch of the last attempt
+func (m *Manager) load() ([]storagemarket.DealRenewal, error) {
+	results, err := m.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	renewals := make([]storagemarket.DealRenewal, 0, len(entries))
+	for _, entry := range entries {
+		var renewal storagemarket.DealRenewal
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &renewal); err != nil {
+			return nil, xerrors.Errorf("reading deal renewal %s: %w", entry.Key, err)
+		}
+		renewals = append(renewals, renewal)
+	}
+	sort.SliceStable(renewals, func(i, j int) bool {
+		return renewals[i].Epoch < renewals[j].Epoch
+	})
+	return renewals, nil
+}
+
+func key(oldDeal cid.Cid) datastore.Key {
+	return datastore.NewKey(oldDeal.String())
+}
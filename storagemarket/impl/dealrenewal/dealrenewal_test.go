@@ -0,0 +1,215 @@
+package dealrenewal_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrenewal"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	clientAddr, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	providerAddr, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+	root := shared_testutil.GenerateCids(1)[0]
+	makeDeal := func(state storagemarket.StorageDealStatus, endEpoch abi.ChainEpoch) storagemarket.ClientDeal {
+		return storagemarket.ClientDeal{
+			ClientDealProposal: market.ClientDealProposal{
+				Proposal: market.DealProposal{
+					Client:               clientAddr,
+					Provider:             providerAddr,
+					StartEpoch:           endEpoch - 1000,
+					EndEpoch:             endEpoch,
+					StoragePricePerEpoch: big.NewInt(10),
+				},
+			},
+			ProposalCid: shared_testutil.GenerateCids(1)[0],
+			State:       state,
+			DataRef:     &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: root},
+		}
+	}
+	chainHead := func(ctx context.Context) (abi.ChainEpoch, error) {
+		return 1000, nil
+	}
+
+	t.Run("renews active deals nearing their end", func(t *testing.T) {
+		expiring := makeDeal(storagemarket.StorageDealActive, 1050)
+		deals := []storagemarket.ClientDeal{
+			expiring,
+			makeDeal(storagemarket.StorageDealActive, 5000),
+			makeDeal(storagemarket.StorageDealActive, 900),
+			makeDeal(storagemarket.StorageDealSealing, 1050),
+		}
+		proposer := newRecordingProposer()
+		events := newRecordingSubscriber()
+		m := dealrenewal.New(dss.MutexWrap(datastore.NewMapDatastore()), dealrenewal.SameProvider(abi.RegisteredSealProof_StackedDrg2KiBV1),
+			listDeals(deals), chainHead, proposer.propose, events.notify,
+			dealrenewal.CheckInterval(10*time.Millisecond), dealrenewal.RenewalLead(100))
+		m.Start(ctx)
+		defer m.Stop()
+
+		proposed := proposer.waitFor(t, 1)
+		require.Equal(t, providerAddr, proposed[0].Provider)
+		require.Equal(t, abi.ChainEpoch(1050), proposed[0].StartEpoch)
+		require.Equal(t, abi.ChainEpoch(2050), proposed[0].EndEpoch)
+		require.Equal(t, expiring.DataRef, proposed[0].Data)
+
+		evt := events.waitFor(t)
+		require.Equal(t, storagemarket.RenewalEventProposed, evt.event)
+		require.Equal(t, expiring.ProposalCid, evt.renewal.OldDeal)
+		require.NotNil(t, evt.renewal.NewDeal)
+
+		// a renewed deal is not renewed again
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, proposer.waitFor(t, 0))
+
+		renewals, err := m.List()
+		require.NoError(t, err)
+		require.Len(t, renewals, 1)
+		require.Equal(t, evt.renewal, renewals[0])
+	})
+
+	t.Run("retries renewals that fail", func(t *testing.T) {
+		proposer := newRecordingProposer()
+		proposer.failures = 1
+		events := newRecordingSubscriber()
+		m := dealrenewal.New(dss.MutexWrap(datastore.NewMapDatastore()), dealrenewal.SameProvider(abi.RegisteredSealProof_StackedDrg2KiBV1),
+			listDeals([]storagemarket.ClientDeal{makeDeal(storagemarket.StorageDealActive, 1050)}), chainHead, proposer.propose, events.notify,
+			dealrenewal.CheckInterval(10*time.Millisecond), dealrenewal.RenewalLead(100))
+		m.Start(ctx)
+		defer m.Stop()
+
+		evt := events.waitFor(t)
+		require.Equal(t, storagemarket.RenewalEventFailed, evt.event)
+		require.Equal(t, "provider offline", evt.renewal.Message)
+
+		proposer.waitFor(t, 1)
+		evt = events.waitFor(t)
+		require.Equal(t, storagemarket.RenewalEventProposed, evt.event)
+		require.Equal(t, "", evt.renewal.Message)
+	})
+
+	t.Run("records deals the policy declines to renew", func(t *testing.T) {
+		proposer := newRecordingProposer()
+		events := newRecordingSubscriber()
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		deals := listDeals([]storagemarket.ClientDeal{makeDeal(storagemarket.StorageDealActive, 1050)})
+		m := dealrenewal.New(ds, declinePolicy{}, deals, chainHead, proposer.propose, events.notify,
+			dealrenewal.CheckInterval(10*time.Millisecond), dealrenewal.RenewalLead(100))
+		m.Start(ctx)
+
+		evt := events.waitFor(t)
+		require.Equal(t, storagemarket.RenewalEventDeclined, evt.event)
+		require.True(t, evt.renewal.Declined)
+		m.Stop()
+
+		// a declined deal stays declined after a restart
+		restarted := dealrenewal.New(ds, dealrenewal.SameProvider(abi.RegisteredSealProof_StackedDrg2KiBV1), deals, chainHead, proposer.propose, events.notify,
+			dealrenewal.CheckInterval(10*time.Millisecond), dealrenewal.RenewalLead(100))
+		restarted.Start(ctx)
+		time.Sleep(50 * time.Millisecond)
+		restarted.Stop()
+		require.Empty(t, proposer.waitFor(t, 0))
+
+		renewals, err := restarted.List()
+		require.NoError(t, err)
+		require.Len(t, renewals, 1)
+		require.True(t, renewals[0].Declined)
+		require.Equal(t, providerAddr, renewals[0].Provider)
+	})
+}
+
+func listDeals(deals []storagemarket.ClientDeal) dealrenewal.ListDealsFunc {
+	return func(ctx context.Context) ([]storagemarket.ClientDeal, error) {
+		return deals, nil
+	}
+}
+
+type declinePolicy struct{}
+
+func (declinePolicy) Renew(ctx context.Context, deal storagemarket.ClientDeal, epoch abi.ChainEpoch) (storagemarket.ScheduledDeal, bool, error) {
+	return storagemarket.ScheduledDeal{}, false, nil
+}
+
+type recordingProposer struct {
+	lk       sync.Mutex
+	failures int
+	proposed []storagemarket.ScheduledDeal
+	notify   chan struct{}
+}
+
+func newRecordingProposer() *recordingProposer {
+	return &recordingProposer{notify: make(chan struct{}, 16)}
+}
+
+func (p *recordingProposer) propose(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return cid.Undef, errors.New("provider offline")
+	}
+	p.proposed = append(p.proposed, deal)
+	p.notify <- struct{}{}
+	return shared_testutil.GenerateCids(1)[0], nil
+}
+
+// waitFor waits for count more deals to be proposed and returns them
+func (p *recordingProposer) waitFor(t *testing.T, count int) []storagemarket.ScheduledDeal {
+	for i := 0; i < count; i++ {
+		select {
+		case <-p.notify:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d renewals to be proposed, got %d", count, i)
+		}
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	proposed := p.proposed
+	p.proposed = nil
+	return proposed
+}
+
+type renewalEvent struct {
+	event   storagemarket.RenewalEvent
+	renewal storagemarket.DealRenewal
+}
+
+type recordingSubscriber struct {
+	events chan renewalEvent
+}
+
+func newRecordingSubscriber() *recordingSubscriber {
+	return &recordingSubscriber{events: make(chan renewalEvent, 16)}
+}
+
+func (s *recordingSubscriber) notify(event storagemarket.RenewalEvent, renewal storagemarket.DealRenewal) {
+	s.events <- renewalEvent{event, renewal}
+}
+
+func (s *recordingSubscriber) waitFor(t *testing.T) renewalEvent {
+	select {
+	case evt := <-s.events:
+		return evt
+	case <-time.After(time.Second):
+		t.Fatal("expected a renewal event")
+	}
+	return renewalEvent{}
+}
@@ -0,0 +1,42 @@
+package dealrenewal
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+type sameProvider struct {
+	rt abi.RegisteredSealProof
+}
+
+// SameProvider returns a policy that renews every deal with the same provider on the
+// same price. The replacement deal starts when the old deal ends, lasts as long, and
+// has the minimum provider collateral at the time it is proposed. Its data is sent
+// from the same data reference and store, so the client must still have the data
+// for the deal, and it keeps the envelope of encrypted data. rt is the seal proof
+// type of the provider
+func SameProvider(rt abi.RegisteredSealProof) storagemarket.RenewalPolicy {
+	return &sameProvider{rt}
+}
+
+func (p *sameProvider) Renew(ctx context.Context, deal storagemarket.ClientDeal, epoch abi.ChainEpoch) (storagemarket.ScheduledDeal, bool, error) {
+	proposal := deal.Proposal
+	return storagemarket.ScheduledDeal{
+		Client:        proposal.Client,
+		Provider:      proposal.Provider,
+		Data:          deal.DataRef,
+		StartEpoch:    proposal.EndEpoch,
+		EndEpoch:      proposal.EndEpoch + (proposal.EndEpoch - proposal.StartEpoch),
+		Price:         proposal.StoragePricePerEpoch,
+		Collateral:    big.Zero(),
+		Rt:            p.rt,
+		FastRetrieval: deal.FastRetrieval,
+		VerifiedDeal:  proposal.VerifiedDeal,
+		StoreID:       deal.StoreID,
+		Envelope:      deal.Envelope,
+	}, true, nil
+}
@@ -0,0 +1,171 @@
+/*
+Package dealrestart decides how a storage deal resumes after the client or the
+provider restarts.
+
+When either party restarts in the middle of a deal, it sends its view of the
+deal (state, transfer channel, bytes transferred) to the other party on the
+deal restart protocol, and receives the other party's view in response. Both
+parties then call Reconcile on the same pair of views. Because Reconcile is
+deterministic, the client and provider agree on a single resume point instead
+of each guessing independently.
+*/
+package dealrestart
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+// Resolution is the agreed way to resume a deal after a restart
+type Resolution uint64
+
+const (
+	// ResolutionContinue means both views are consistent and each party carries
+	// on from its current state
+	ResolutionContinue Resolution = iota
+
+	// ResolutionRestartTransfer means both parties are mid transfer on the same
+	// channel. The client restarts the data transfer if it was transferring, and
+	// the provider restarts it if the client was still starting it
+	ResolutionRestartTransfer
+
+	// ResolutionResendResponse means the provider never saw the transfer begin, so
+	// the client proposes again and the provider resends its response, after which
+	// the client starts a new transfer
+	ResolutionResendResponse
+
+	// ResolutionSkipTransfer means the provider already has all the data, so the
+	// client moves on to waiting for the deal to be accepted
+	ResolutionSkipTransfer
+
+	// ResolutionFail means the deal cannot be resumed and both parties fail it
+	ResolutionFail
+)
+
+// Resolutions maps resolution codes to string names
+var Resolutions = map[Resolution]string{
+	ResolutionContinue:        "ResolutionContinue",
+	ResolutionRestartTransfer: "ResolutionRestartTransfer",
+	ResolutionResendResponse:  "ResolutionResendResponse",
+	ResolutionSkipTransfer:    "ResolutionSkipTransfer",
+	ResolutionFail:            "ResolutionFail",
+}
+
+func (r Resolution) String() string {
+	return Resolutions[r]
+}
+
+type phase int
+
+const (
+	phaseUnknown phase = iota
+	phaseFailed
+	phaseBeforeTransfer
+	phaseTransfer
+	phaseAfterTransfer
+)
+
+func dealPhase(state storagemarket.StorageDealStatus) phase {
+	switch state {
+	case storagemarket.StorageDealUnknown:
+		return phaseUnknown
+	case storagemarket.StorageDealFailing,
+		storagemarket.StorageDealError,
+		storagemarket.StorageDealRejecting,
+		storagemarket.StorageDealProposalRejected,
+		storagemarket.StorageDealProposalNotFound:
+		return phaseFailed
+	case storagemarket.StorageDealAwaitingSignature,
+		storagemarket.StorageDealReserveClientFunds,
+		storagemarket.StorageDealClientFunding,
+		storagemarket.StorageDealFundsReserved,
+		storagemarket.StorageDealProposalRetryWait,
+		storagemarket.StorageDealValidating,
+		storagemarket.StorageDealAcceptWait,
+		storagemarket.StorageDealWaitingForData:
+		return phaseBeforeTransfer
+	case storagemarket.StorageDealStartDataTransfer,
+		storagemarket.StorageDealTransferring,
+		storagemarket.StorageDealClientTransferRestart,
+		storagemarket.StorageDealProviderTransferRestart:
+		return phaseTransfer
+	default:
+		return phaseAfterTransfer
+	}
+}
+
+// Reconcile decides how to resume a deal from the client's and the provider's
+// views of it. For ResolutionFail, it also returns the reason the deal failed
+func Reconcile(client network.DealView, provider network.DealView) (Resolution, string) {
+	clientPhase := dealPhase(client.State)
+	providerPhase := dealPhase(provider.State)
+
+	switch {
+	case providerPhase == phaseUnknown:
+		return ResolutionFail, "provider has no record of the deal"
+	case clientPhase == phaseUnknown:
+		return ResolutionFail, "client has no record of the deal"
+	case providerPhase == phaseFailed:
+		return ResolutionFail, fmt.Sprintf("provider deal failed in state %s: %s", storagemarket.DealStates[provider.State], provider.Message)
+	case clientPhase == phaseFailed:
+		return ResolutionFail, fmt.Sprintf("client deal failed in state %s: %s", storagemarket.DealStates[client.State], client.Message)
+	}
+
+	if clientPhase == phaseTransfer {
+		switch providerPhase {
+		case phaseBeforeTransfer:
+			return ResolutionResendResponse, ""
+		case phaseTransfer:
+			if client.TransferChannelID != nil && provider.TransferChannelID != nil && *client.TransferChannelID != *provider.TransferChannelID {
+				return ResolutionFail, fmt.Sprintf("client and provider disagree on transfer channel: %s != %s", client.TransferChannelID, provider.TransferChannelID)
+			}
+			return ResolutionRestartTransfer, ""
+		case phaseAfterTransfer:
+			return ResolutionSkipTransfer, ""
+		}
+	}
+
+	if clientPhase == phaseAfterTransfer && providerPhase == phaseTransfer {
+		return ResolutionFail, fmt.Sprintf("client finished sending data but provider only received %d of %d bytes", provider.BytesTransferred, client.BytesTransferred)
+	}
+
+	return ResolutionContinue, ""
+}
+
+// NegotiationTimeout is how long a party waits for the other party's view of a
+// deal before resuming the deal on its own
+const NegotiationTimeout = 30 * time.Second
+
+// Negotiate sends a party's own view of a deal to the other party on the deal
+// restart protocol, and returns the other party's view
+func Negotiate(ctx context.Context, net network.StorageMarketNetwork, to peer.ID, view network.DealView) (network.DealView, error) {
+	ctx, cancel := context.WithTimeout(ctx, NegotiationTimeout)
+	defer cancel()
+
+	s, err := net.NewDealRestartStream(ctx, to)
+	if err != nil {
+		return network.DealView{}, xerrors.Errorf("opening deal restart stream: %w", err)
+	}
+	defer s.Close() // nolint: errcheck
+
+	if err := s.WriteDealRestartRequest(network.DealRestartRequest{View: view}); err != nil {
+		return network.DealView{}, xerrors.Errorf("sending deal restart request: %w", err)
+	}
+
+	resp, err := s.ReadDealRestartResponse()
+	if err != nil {
+		return network.DealView{}, xerrors.Errorf("reading deal restart response: %w", err)
+	}
+
+	if resp.View.Proposal != view.Proposal {
+		return network.DealView{}, xerrors.Errorf("deal restart response for wrong proposal: %s != %s", resp.View.Proposal, view.Proposal)
+	}
+	return resp.View, nil
+}
@@ -0,0 +1,90 @@
+package dealrestart_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+func TestReconcile(t *testing.T) {
+	peers := shared_testutil.GeneratePeers(2)
+	channelID := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 1}
+	otherChannelID := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 2}
+
+	view := func(state storagemarket.StorageDealStatus, chid *datatransfer.ChannelID, bytes uint64) network.DealView {
+		return network.DealView{State: state, TransferChannelID: chid, BytesTransferred: bytes}
+	}
+
+	testCases := map[string]struct {
+		client             network.DealView
+		provider           network.DealView
+		expectedResolution dealrestart.Resolution
+		expectedReason     string
+	}{
+		"both mid transfer": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 100),
+			provider:           view(storagemarket.StorageDealTransferring, &channelID, 50),
+			expectedResolution: dealrestart.ResolutionRestartTransfer,
+		},
+		"provider never saw transfer": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 0),
+			provider:           view(storagemarket.StorageDealWaitingForData, nil, 0),
+			expectedResolution: dealrestart.ResolutionResendResponse,
+		},
+		"provider already has data": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 100),
+			provider:           view(storagemarket.StorageDealVerifyData, &channelID, 100),
+			expectedResolution: dealrestart.ResolutionSkipTransfer,
+		},
+		"provider lost the deal": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 100),
+			provider:           view(storagemarket.StorageDealUnknown, nil, 0),
+			expectedResolution: dealrestart.ResolutionFail,
+			expectedReason:     "provider has no record of the deal",
+		},
+		"client lost the deal": {
+			client:             view(storagemarket.StorageDealUnknown, nil, 0),
+			provider:           view(storagemarket.StorageDealProviderTransferRestart, &channelID, 50),
+			expectedResolution: dealrestart.ResolutionFail,
+			expectedReason:     "client has no record of the deal",
+		},
+		"provider failed": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 100),
+			provider:           network.DealView{State: storagemarket.StorageDealFailing, Message: "out of disk"},
+			expectedResolution: dealrestart.ResolutionFail,
+			expectedReason:     "provider deal failed in state StorageDealFailing: out of disk",
+		},
+		"different channels": {
+			client:             view(storagemarket.StorageDealClientTransferRestart, &channelID, 100),
+			provider:           view(storagemarket.StorageDealProviderTransferRestart, &otherChannelID, 50),
+			expectedResolution: dealrestart.ResolutionFail,
+		},
+		"client finished but provider did not": {
+			client:             view(storagemarket.StorageDealCheckForAcceptance, &channelID, 100),
+			provider:           view(storagemarket.StorageDealProviderTransferRestart, &channelID, 50),
+			expectedResolution: dealrestart.ResolutionFail,
+			expectedReason:     "client finished sending data but provider only received 50 of 100 bytes",
+		},
+		"both past transfer": {
+			client:             view(storagemarket.StorageDealCheckForAcceptance, &channelID, 100),
+			provider:           view(storagemarket.StorageDealPublishing, &channelID, 100),
+			expectedResolution: dealrestart.ResolutionContinue,
+		},
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			resolution, reason := dealrestart.Reconcile(data.client, data.provider)
+			require.Equal(t, data.expectedResolution, resolution)
+			if data.expectedReason != "" {
+				require.Equal(t, data.expectedReason, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+/*
+Package dealschedule holds the storage deal proposals a client has scheduled to send
+later, and sends each one once its schedule is reached.
+
+Scheduled deals are kept in the datastore, so deals still waiting when the client
+shuts down are sent after it starts again. The scheduler checks for deals that are
+due at a fixed interval. A deal whose proposal fails, for example because its data
+is not ready yet, is tried again at the next check.
+
+A deal is marked as proposing in the datastore before its proposal is sent, and its
+proposal CID is saved once it has been, so a deal is never sent twice. If the result
+cannot be saved, the scheduler keeps it and saves it again at the next check rather
+than sending the proposal again. A deal that was still marked as proposing when the
+client stopped may or may not have been sent, so it is left for the user to cancel
+and schedule again.
+*/
+package dealschedule
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("storagemarket_dealschedule")
+
+// DefaultCheckInterval is how often the scheduler looks for deals that are due
+const DefaultCheckInterval = 30 * time.Second
+
+// ErrNotFound is returned when cancelling a scheduled deal that does not exist
+var ErrNotFound = errors.New("scheduled deal not found")
+
+// interruptedMessage is recorded for deals the client stopped sending part way through
+const interruptedMessage = "the client stopped while sending the proposal, which may have been sent; cancel the deal and schedule it again to send it"
+
+// ProposeFunc sends the proposal for a scheduled deal and returns its proposal CID
+type ProposeFunc func(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error)
+
+// ChainHeadFunc returns the current chain epoch
+type ChainHeadFunc func(ctx context.Context) (abi.ChainEpoch, error)
+
+// Option configures a Scheduler
+type Option func(*Scheduler)
+
+// CheckInterval sets how often the scheduler looks for deals that are due
+func CheckInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		s.checkInterval = interval
+	}
+}
+
+// Scheduler persists scheduled deals and proposes them when they are due
+type Scheduler struct {
+	ds            datastore.Batching
+	propose       ProposeFunc
+	chainHead     ChainHeadFunc
+	checkInterval time.Duration
+	now           func() time.Time
+
+	lk   sync.Mutex
+	next uint64
+	// results are the outcomes of sending proposals that have yet to be saved
+	results map[uint64]result
+	wake    chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New returns a scheduler that stores scheduled deals in the given datastore. Deals
+// left in the datastore by a previous scheduler are proposed once they are due
+func New(ds datastore.Batching, propose ProposeFunc, chainHead ChainHeadFunc, options ...Option) (*Scheduler, error) {
+	s := &Scheduler{
+		ds:            ds,
+		propose:       propose,
+		chainHead:     chainHead,
+		checkInterval: DefaultCheckInterval,
+		now:           time.Now,
+		results:       make(map[uint64]result),
+		wake:          make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	deals, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(deals) > 0 {
+		s.next = deals[len(deals)-1].ID + 1
+	}
+	for _, deal := range deals {
+		if deal.Proposing && deal.ProposalCid == nil && deal.Message != interruptedMessage {
+			log.Warnf("scheduled deal %d was being proposed when the client stopped, it will not be proposed again", deal.ID)
+			deal.Message = interruptedMessage
+			if err := s.save(deal); err != nil {
+				return nil, xerrors.Errorf("saving scheduled deal %d: %w", deal.ID, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// Schedule saves a deal to be proposed once its schedule is reached, and returns
+// the ID assigned to it
+func (s *Scheduler) Schedule(deal storagemarket.ScheduledDeal) (uint64, error) {
+	s.lk.Lock()
+	deal.ID = s.next
+	deal.Proposing = false
+	deal.ProposalCid = nil
+	deal.Message = ""
+	err := s.save(deal)
+	if err == nil {
+		s.next++
+	}
+	s.lk.Unlock()
+	if err != nil {
+		return 0, xerrors.Errorf("saving scheduled deal: %w", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return deal.ID, nil
+}
+
+// List returns the scheduled deals in the order they were scheduled, including
+// those that have been proposed
+func (s *Scheduler) List() ([]storagemarket.ScheduledDeal, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.load()
+}
+
+// Cancel removes a scheduled deal. A deal that is still waiting will not be proposed.
+// Cancelling a deal that is being or was already proposed only removes its record
+func (s *Scheduler) Cancel(id uint64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	has, err := s.ds.Has(key(id))
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ErrNotFound
+	}
+	return s.ds.Delete(key(id))
+}
+
+// Start begins proposing deals as they become due
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.run(ctx)
+}
+
+// Stop ends proposing deals. Deals that were not proposed stay scheduled
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.proposeDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// proposeDue proposes each waiting deal whose schedule has been reached
+func (s *Scheduler) proposeDue(ctx context.Context) {
+	s.lk.Lock()
+	s.saveResults()
+	deals, err := s.load()
+	s.lk.Unlock()
+	if err != nil {
+		log.Errorf("loading scheduled deals: %s", err)
+		return
+	}
+
+	now := s.now()
+	var epoch abi.ChainEpoch
+	var epochErr error
+	epochFetched := false
+	for _, deal := range deals {
+		if deal.Proposing || deal.ProposalCid != nil || now.Before(time.Time(deal.NotBefore)) {
+			continue
+		}
+		if deal.NotBeforeEpoch > 0 {
+			if !epochFetched {
+				epoch, epochErr = s.chainHead(ctx)
+				epochFetched = true
+			}
+			if epochErr != nil {
+				log.Warnf("getting chain head to check scheduled deals: %s", epochErr)
+				continue
+			}
+			if epoch < deal.NotBeforeEpoch {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.proposeDeal(ctx, deal.ID)
+	}
+}
+
+// result is the outcome of sending the proposal for a scheduled deal
+type result struct {
+	proposalCid *cid.Cid
+	message     string
+}
+
+// proposeDeal marks a deal as proposing, sends its proposal without holding the lock,
+// then saves the outcome
+func (s *Scheduler) proposeDeal(ctx context.Context, id uint64) {
+	deal, ok := s.claim(id)
+	if !ok {
+		return
+	}
+
+	var res result
+	proposalCid, err := s.propose(ctx, deal)
+	if err != nil {
+		log.Warnf("proposing scheduled deal %d failed, retrying in %s: %s", id, s.checkInterval, err)
+		res.message = err.Error()
+	} else {
+		log.Infof("proposed scheduled deal %d as %s", id, proposalCid)
+		res.proposalCid = &proposalCid
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.results[id] = res
+	s.saveResults()
+}
+
+// claim marks a deal that is waiting as proposing, and returns it
+func (s *Scheduler) claim(id uint64) (storagemarket.ScheduledDeal, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	// the deal may have been cancelled since the schedule was checked
+	deal, err := s.get(id)
+	if err != nil {
+		if !xerrors.Is(err, datastore.ErrNotFound) {
+			log.Errorf("loading scheduled deal %d: %s", id, err)
+		}
+		return deal, false
+	}
+	if deal.Proposing || deal.ProposalCid != nil {
+		return deal, false
+	}
+
+	deal.Proposing = true
+	if err := s.save(deal); err != nil {
+		log.Errorf("saving scheduled deal %d: %s", id, err)
+		return deal, false
+	}
+	return deal, true
+}
+
+// saveResults saves the outcomes of sending proposals to their deals, keeping those
+// that cannot be saved to try again. s.lk must be held
+func (s *Scheduler) saveResults() {
+	for id, res := range s.results {
+		deal, err := s.get(id)
+		if err != nil {
+			if xerrors.Is(err, datastore.ErrNotFound) {
+				// the deal was cancelled while it was being proposed
+				delete(s.results, id)
+				continue
+			}
+			log.Errorf("loading scheduled deal %d: %s", id, err)
+			continue
+		}
+		deal.Proposing = false
+		deal.ProposalCid = res.proposalCid
+		deal.Message = res.message
+		if err := s.save(deal); err != nil {
+			log.Errorf("saving scheduled deal %d: %s", id, err)
+			continue
+		}
+		delete(s.results, id)
+	}
+}
+
+func (s *Scheduler) save(deal storagemarket.ScheduledDeal) error {
+	b, err := cborutil.Dump(&deal)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(key(deal.ID), b)
+}
+
+func (s *Scheduler) get(id uint64) (storagemarket.ScheduledDeal, error) {
+	var deal storagemarket.ScheduledDeal
+	b, err := s.ds.Get(key(id))
+	if err != nil {
+		return deal, err
+	}
+	err = cborutil.ReadCborRPC(bytes.NewReader(b), &deal)
+	return deal, err
+}
+
+// load returns the scheduled deals in the order they were scheduled
+func (s *Scheduler) load() ([]storagemarket.ScheduledDeal, error) {
+	results, err := s.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	deals := make([]storagemarket.ScheduledDeal, 0, len(entries))
+	for _, entry := range entries {
+		var deal storagemarket.ScheduledDeal
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &deal); err != nil {
+			return nil, xerrors.Errorf("reading scheduled deal %s: %w", entry.Key, err)
+		}
+		deals = append(deals, deal)
+	}
+	sort.Slice(deals, func(i, j int) bool {
+		return deals[i].ID < deals[j].ID
+	})
+	return deals, nil
+}
+
+func key(id uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%d", id))
+}
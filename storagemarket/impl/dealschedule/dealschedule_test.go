@@ -0,0 +1,285 @@
+package dealschedule_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealschedule"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	storeID := multistore.StoreID(3)
+	root := shared_testutil.GenerateCids(1)[0]
+	clientAddr, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	providerAddr, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+	makeDeal := func(notBefore time.Time, notBeforeEpoch abi.ChainEpoch) storagemarket.ScheduledDeal {
+		return storagemarket.ScheduledDeal{
+			Client:         clientAddr,
+			Provider:       providerAddr,
+			Data:           &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: root},
+			StartEpoch:     100,
+			EndEpoch:       200,
+			Price:          big.NewInt(10),
+			Collateral:     big.Zero(),
+			StoreID:        &storeID,
+			NotBefore:      cbg.CborTime(notBefore.UTC()),
+			NotBeforeEpoch: notBeforeEpoch,
+		}
+	}
+	past := time.Unix(0, 0)
+	future := time.Now().Add(time.Hour)
+
+	t.Run("proposes deals once they are due", func(t *testing.T) {
+		proposer := newRecordingProposer()
+		epoch := abi.ChainEpoch(10)
+		var epochLk sync.Mutex
+		chainHead := func(ctx context.Context) (abi.ChainEpoch, error) {
+			epochLk.Lock()
+			defer epochLk.Unlock()
+			return epoch, nil
+		}
+		s, err := dealschedule.New(dss.MutexWrap(datastore.NewMapDatastore()), proposer.propose, chainHead, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+
+		dueID, err := s.Schedule(makeDeal(past, 0))
+		require.NoError(t, err)
+		laterID, err := s.Schedule(makeDeal(future, 0))
+		require.NoError(t, err)
+		epochID, err := s.Schedule(makeDeal(past, 20))
+		require.NoError(t, err)
+
+		s.Start(ctx)
+		defer s.Stop()
+
+		proposed := proposer.waitFor(t, 1)
+		require.Equal(t, dueID, proposed[0].ID)
+		require.Equal(t, &storeID, proposed[0].StoreID)
+
+		epochLk.Lock()
+		epoch = 20
+		epochLk.Unlock()
+		proposed = proposer.waitFor(t, 1)
+		require.Equal(t, epochID, proposed[0].ID)
+
+		deals, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, deals, 3)
+		require.NotNil(t, deals[0].ProposalCid)
+		require.Equal(t, laterID, deals[1].ID)
+		require.Nil(t, deals[1].ProposalCid)
+		require.NotNil(t, deals[2].ProposalCid)
+	})
+
+	t.Run("retries deals that fail to propose", func(t *testing.T) {
+		proposer := newRecordingProposer()
+		proposer.failures = 2
+		s, err := dealschedule.New(dss.MutexWrap(datastore.NewMapDatastore()), proposer.propose, nil, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+		s.Start(ctx)
+		defer s.Stop()
+
+		id, err := s.Schedule(makeDeal(past, 0))
+		require.NoError(t, err)
+		proposed := proposer.waitFor(t, 1)
+		require.Equal(t, id, proposed[0].ID)
+
+		deals, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, deals, 1)
+		require.Equal(t, "", deals[0].Message)
+	})
+
+	t.Run("cancelled deals are not proposed", func(t *testing.T) {
+		proposer := newRecordingProposer()
+		s, err := dealschedule.New(dss.MutexWrap(datastore.NewMapDatastore()), proposer.propose, nil, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+
+		id, err := s.Schedule(makeDeal(past, 0))
+		require.NoError(t, err)
+		require.NoError(t, s.Cancel(id))
+		require.Equal(t, dealschedule.ErrNotFound, s.Cancel(id))
+
+		s.Start(ctx)
+		time.Sleep(50 * time.Millisecond)
+		s.Stop()
+		require.Empty(t, proposer.waitFor(t, 0))
+	})
+
+	t.Run("does not hold the lock while proposing", func(t *testing.T) {
+		var s *dealschedule.Scheduler
+		listed := make(chan []storagemarket.ScheduledDeal, 1)
+		propose := func(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error) {
+			deals, err := s.List()
+			require.NoError(t, err)
+			listed <- deals
+			return shared_testutil.GenerateCids(1)[0], nil
+		}
+		s, err = dealschedule.New(dss.MutexWrap(datastore.NewMapDatastore()), propose, nil, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+		_, err = s.Schedule(makeDeal(past, 0))
+		require.NoError(t, err)
+		s.Start(ctx)
+		defer s.Stop()
+
+		select {
+		case deals := <-listed:
+			require.Len(t, deals, 1)
+			require.True(t, deals[0].Proposing)
+		case <-time.After(time.Second):
+			t.Fatal("scheduled deal was not proposed")
+		}
+	})
+
+	t.Run("saves the result again rather than proposing again", func(t *testing.T) {
+		ds := &failingDatastore{Batching: dss.MutexWrap(datastore.NewMapDatastore())}
+		proposer := newRecordingProposer()
+		propose := func(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error) {
+			ds.failPuts(1)
+			return proposer.propose(ctx, deal)
+		}
+		s, err := dealschedule.New(ds, propose, nil, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+		_, err = s.Schedule(makeDeal(past, 0))
+		require.NoError(t, err)
+		s.Start(ctx)
+		defer s.Stop()
+
+		require.Len(t, proposer.waitFor(t, 1), 1)
+		require.Eventually(t, func() bool {
+			deals, err := s.List()
+			require.NoError(t, err)
+			return deals[0].ProposalCid != nil && !deals[0].Proposing
+		}, time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, proposer.waitFor(t, 0))
+	})
+
+	t.Run("does not propose deals interrupted while proposing", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		interrupted := makeDeal(past, 0)
+		interrupted.Proposing = true
+		b, err := cborutil.Dump(&interrupted)
+		require.NoError(t, err)
+		require.NoError(t, ds.Put(datastore.NewKey("0"), b))
+
+		proposer := newRecordingProposer()
+		s, err := dealschedule.New(ds, proposer.propose, nil, dealschedule.CheckInterval(10*time.Millisecond))
+		require.NoError(t, err)
+		s.Start(ctx)
+		time.Sleep(50 * time.Millisecond)
+		s.Stop()
+		require.Empty(t, proposer.waitFor(t, 0))
+
+		deals, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, deals, 1)
+		require.True(t, deals[0].Proposing)
+		require.Nil(t, deals[0].ProposalCid)
+		require.NotEmpty(t, deals[0].Message)
+	})
+
+	t.Run("keeps scheduled deals across restarts", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		s, err := dealschedule.New(ds, newRecordingProposer().propose, nil)
+		require.NoError(t, err)
+		first, err := s.Schedule(makeDeal(future, 0))
+		require.NoError(t, err)
+
+		restarted, err := dealschedule.New(ds, newRecordingProposer().propose, nil)
+		require.NoError(t, err)
+		second, err := restarted.Schedule(makeDeal(future, 0))
+		require.NoError(t, err)
+		require.Equal(t, first+1, second)
+
+		deals, err := restarted.List()
+		require.NoError(t, err)
+		require.Len(t, deals, 2)
+		require.Equal(t, makeDeal(future, 0).Data, deals[0].Data)
+	})
+}
+
+// failingDatastore fails the next puts once told to
+type failingDatastore struct {
+	datastore.Batching
+
+	lk    sync.Mutex
+	fails int
+}
+
+func (ds *failingDatastore) failPuts(n int) {
+	ds.lk.Lock()
+	defer ds.lk.Unlock()
+	ds.fails = n
+}
+
+func (ds *failingDatastore) Put(key datastore.Key, value []byte) error {
+	ds.lk.Lock()
+	fail := ds.fails > 0
+	if fail {
+		ds.fails--
+	}
+	ds.lk.Unlock()
+	if fail {
+		return errors.New("datastore unavailable")
+	}
+	return ds.Batching.Put(key, value)
+}
+
+type recordingProposer struct {
+	lk       sync.Mutex
+	failures int
+	proposed []storagemarket.ScheduledDeal
+	notify   chan struct{}
+}
+
+func newRecordingProposer() *recordingProposer {
+	return &recordingProposer{notify: make(chan struct{}, 16)}
+}
+
+func (p *recordingProposer) propose(ctx context.Context, deal storagemarket.ScheduledDeal) (cid.Cid, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return cid.Undef, errors.New("data not ready")
+	}
+	p.proposed = append(p.proposed, deal)
+	p.notify <- struct{}{}
+	return shared_testutil.GenerateCids(1)[0], nil
+}
+
+// waitFor waits for count more deals to be proposed and returns them
+func (p *recordingProposer) waitFor(t *testing.T, count int) []storagemarket.ScheduledDeal {
+	for i := 0; i < count; i++ {
+		select {
+		case <-p.notify:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d scheduled deals to be proposed, got %d", count, i)
+		}
+	}
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	proposed := p.proposed
+	p.proposed = nil
+	return proposed
+}
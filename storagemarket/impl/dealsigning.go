@@ -0,0 +1,179 @@
+package storageimpl
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DefaultSignatureTimeout is how long a deal proposal waits to be signed by an
+// asynchronous proposal signer before the deal fails
+const DefaultSignatureTimeout = time.Hour
+
+// AsyncProposalSigning has the client ask the given signer to sign each deal proposal,
+// rather than signing it with the node while the deal is proposed. This suits signers
+// that need confirmation before they sign, such as hardware wallets. The deal waits in
+// StorageDealAwaitingSignature until the signature is passed to SubmitDealSignature,
+// and fails if it is not signed within timeout. A timeout of zero uses
+// DefaultSignatureTimeout
+func AsyncProposalSigning(signer storagemarket.ProposalSigner, timeout time.Duration) StorageClientOption {
+	return func(c *Client) {
+		if timeout == 0 {
+			timeout = DefaultSignatureTimeout
+		}
+		c.proposalSigner = signer
+		c.signatureTimeout = timeout
+	}
+}
+
+// SubmitDealSignature passes the signature for a deal proposal that is awaiting one.
+// The signed deal is proposed under the CID of the signed proposal, which is returned,
+// and the pending deal records that CID in SignedProposalCid
+func (c *Client) SubmitDealSignature(ctx context.Context, pendingProposalCid cid.Cid, signature crypto.Signature) (cid.Cid, error) {
+	var pending storagemarket.ClientDeal
+	if err := c.statemachines.GetSync(ctx, pendingProposalCid, &pending); err != nil {
+		return cid.Undef, xerrors.Errorf("getting pending deal %s: %w", pendingProposalCid, err)
+	}
+	if pending.State != storagemarket.StorageDealAwaitingSignature {
+		return cid.Undef, xerrors.Errorf("deal %s is not awaiting a signature: %s", pendingProposalCid, storagemarket.DealStates[pending.State])
+	}
+
+	buf, err := cborutil.Dump(&pending.Proposal)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("serializing deal proposal: %w", err)
+	}
+	tok, _, err := c.node.GetChainHead(ctx)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting chain head: %w", err)
+	}
+	valid, err := c.node.VerifySignature(ctx, signature, pending.Proposal.Client, buf, tok)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("verifying deal proposal signature: %w", err)
+	}
+	if !valid {
+		return cid.Undef, xerrors.New("signature does not match deal proposal")
+	}
+
+	clientDealProposal := &market.ClientDealProposal{
+		Proposal:        pending.Proposal,
+		ClientSignature: signature,
+	}
+	proposalNd, err := cborutil.AsIpld(clientDealProposal)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting proposal node failed: %w", err)
+	}
+	c.stopSignatureTimer(pendingProposalCid)
+
+	deal := &storagemarket.ClientDeal{
+		ProposalCid:        proposalNd.Cid(),
+		ClientDealProposal: *clientDealProposal,
+		State:              storagemarket.StorageDealUnknown,
+		Miner:              pending.Miner,
+		MinerWorker:        pending.MinerWorker,
+		DataRef:            pending.DataRef,
+		FastRetrieval:      pending.FastRetrieval,
+		StoreID:            pending.StoreID,
+		CreationTime:       curTime(),
+		Invoice:            pending.Invoice,
+		Envelope:           pending.Envelope,
+	}
+	if err := c.beginDeal(deal, storagemarket.ClientEventOpen); err != nil {
+		return cid.Undef, err
+	}
+	if err := c.statemachines.Send(pendingProposalCid, storagemarket.ClientEventSignatureReceived, deal.ProposalCid); err != nil {
+		return cid.Undef, xerrors.Errorf("recording signature for deal %s: %w", pendingProposalCid, err)
+	}
+	return deal.ProposalCid, nil
+}
+
+// CancelDealSignature stops waiting for a deal proposal to be signed, and fails the
+// pending deal
+func (c *Client) CancelDealSignature(ctx context.Context, pendingProposalCid cid.Cid) error {
+	var pending storagemarket.ClientDeal
+	if err := c.statemachines.GetSync(ctx, pendingProposalCid, &pending); err != nil {
+		return xerrors.Errorf("getting pending deal %s: %w", pendingProposalCid, err)
+	}
+	if pending.State != storagemarket.StorageDealAwaitingSignature {
+		return xerrors.Errorf("deal %s is not awaiting a signature: %s", pendingProposalCid, storagemarket.DealStates[pending.State])
+	}
+	c.stopSignatureTimer(pendingProposalCid)
+	return c.statemachines.Send(pendingProposalCid, storagemarket.ClientEventSignatureCancelled)
+}
+
+// proposePendingSignature begins tracking a deal whose proposal is waiting to be signed
+// by the proposal signer. Until it is signed, the deal is tracked under the CID of the
+// proposal with a placeholder signature
+func (c *Client) proposePendingSignature(deal *storagemarket.ClientDeal) error {
+	deal.ClientSignature = crypto.Signature{Type: placeholderSigType(deal.Proposal.Client)}
+	proposalNd, err := cborutil.AsIpld(&deal.ClientDealProposal)
+	if err != nil {
+		return xerrors.Errorf("getting proposal node failed: %w", err)
+	}
+	deal.ProposalCid = proposalNd.Cid()
+	return c.beginDeal(deal, storagemarket.ClientEventAwaitSignature)
+}
+
+// requestSignature asks the proposal signer to sign a pending deal's proposal, and
+// fails the deal if it is not signed before the signature timeout, counted from when
+// the deal was proposed
+func (c *Client) requestSignature(ctx context.Context, deal storagemarket.ClientDeal) error {
+	if c.proposalSigner == nil {
+		return xerrors.New("client has no proposal signer")
+	}
+	if err := c.proposalSigner.RequestSignature(ctx, deal.ProposalCid, deal.Proposal.Client, deal.Proposal); err != nil {
+		return err
+	}
+
+	proposalCid := deal.ProposalCid
+	remaining := time.Until(time.Time(deal.CreationTime).Add(c.signatureTimeout))
+	timer := time.AfterFunc(remaining, func() {
+		c.stopSignatureTimer(proposalCid)
+		if err := c.statemachines.Send(proposalCid, storagemarket.ClientEventSignatureTimedOut); err != nil {
+			log.Errorf("timing out signature for deal %s: %s", proposalCid, err)
+		}
+	})
+
+	c.signatureLk.Lock()
+	defer c.signatureLk.Unlock()
+	if previous, ok := c.signatureTimers[proposalCid]; ok {
+		previous.Stop()
+	}
+	c.signatureTimers[proposalCid] = timer
+	return nil
+}
+
+func (c *Client) stopSignatureTimer(proposalCid cid.Cid) {
+	c.signatureLk.Lock()
+	defer c.signatureLk.Unlock()
+	if timer, ok := c.signatureTimers[proposalCid]; ok {
+		timer.Stop()
+		delete(c.signatureTimers, proposalCid)
+	}
+}
+
+func (c *Client) stopSignatureTimers() {
+	c.signatureLk.Lock()
+	defer c.signatureLk.Unlock()
+	for proposalCid, timer := range c.signatureTimers {
+		timer.Stop()
+		delete(c.signatureTimers, proposalCid)
+	}
+}
+
+// placeholderSigType is the signature type a signer's signature will have, so that the
+// placeholder signature of a pending deal can be stored and loaded like a real one
+func placeholderSigType(signer address.Address) crypto.SigType {
+	if signer.Protocol() == address.BLS {
+		return crypto.SigTypeBLS
+	}
+	return crypto.SigTypeSecp256k1
+}
@@ -0,0 +1,72 @@
+package storageimpl
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DefaultExpectedDwellTimes are the times deals are expected to stay in a state at
+// most, past which DealSummary reports them as stuck. States that are not listed,
+// such as those a deal waits in while its data is transferred, are never stuck
+var DefaultExpectedDwellTimes = map[storagemarket.StorageDealStatus]time.Duration{
+	storagemarket.StorageDealValidating:              10 * time.Minute,
+	storagemarket.StorageDealAcceptWait:              10 * time.Minute,
+	storagemarket.StorageDealVerifyData:              time.Hour,
+	storagemarket.StorageDealReserveProviderFunds:    time.Hour,
+	storagemarket.StorageDealProviderFunding:         time.Hour,
+	storagemarket.StorageDealPublish:                 time.Hour,
+	storagemarket.StorageDealPublishing:              time.Hour,
+	storagemarket.StorageDealStaged:                  24 * time.Hour,
+	storagemarket.StorageDealAwaitingPreCommit:       24 * time.Hour,
+	storagemarket.StorageDealSealing:                 48 * time.Hour,
+	storagemarket.StorageDealFinalizing:              time.Hour,
+	storagemarket.StorageDealProviderTransferRestart: time.Hour,
+	storagemarket.StorageDealFailing:                 10 * time.Minute,
+}
+
+// ExpectedDwellTimes sets the times deals are expected to stay in the given states
+// at most, replacing the defaults for those states. A time of zero means deals are
+// never stuck in that state
+func ExpectedDwellTimes(times map[storagemarket.StorageDealStatus]time.Duration) StorageProviderOption {
+	return func(p *Provider) {
+		for state, dwell := range times {
+			p.expectedDwellTimes[state] = dwell
+		}
+	}
+}
+
+// DealSummary returns the number of deals in each state, the deal that has been in
+// each state the longest and how many deals have been in their state for longer than
+// expected. The time a deal entered its state is kept in memory, so deals that have
+// not changed state since the provider started are taken to have entered their
+// state when it started
+func (p *Provider) DealSummary() (storagemarket.DealSummary, error) {
+	deals, err := p.ListLocalDeals()
+	if err != nil {
+		return storagemarket.DealSummary{}, err
+	}
+
+	now := time.Now()
+	summary := storagemarket.DealSummary{
+		States: make(map[storagemarket.StorageDealStatus]storagemarket.DealStateSummary),
+		Total:  len(deals),
+		Time:   now,
+	}
+	for _, deal := range deals {
+		entered := p.stateTimes.Entered(deal.ProposalCid, deal.State)
+		ss := summary.States[deal.State]
+		ss.Count++
+		if ss.OldestSince.IsZero() || entered.Before(ss.OldestSince) {
+			ss.OldestDeal = deal.ProposalCid
+			ss.OldestSince = entered
+		}
+		if shared.Stuck(entered, p.expectedDwellTimes[deal.State], now) {
+			ss.Stuck++
+			summary.Stuck++
+		}
+		summary.States[deal.State] = ss
+	}
+	return summary, nil
+}
@@ -0,0 +1,51 @@
+package storageimpl
+
+import (
+	"github.com/hannahhoward/go-pubsub"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/diskspace"
+)
+
+// DiskSpaceWatchdog pauses deal intake while any of the volumes holding the given
+// paths, such as the filestore and multistore directories, has less than minFree bytes
+// free. Proposals are rejected with a request to retry later until every volume has
+// resumeFree bytes free. SubscribeToDiskSpaceEvents notifies when intake is paused
+// and resumed. It must be passed to NewProvider
+func DiskSpaceWatchdog(paths []string, minFree uint64, resumeFree uint64, options ...diskspace.Option) StorageProviderOption {
+	return func(p *Provider) {
+		p.diskSpace = diskspace.New(paths, minFree, resumeFree, p.notifyDiskSpace, options...)
+	}
+}
+
+// SubscribeToDiskSpaceEvents listens for deal intake being paused and resumed because
+// of the free disk space on the provider's volumes
+func (p *Provider) SubscribeToDiskSpaceEvents(subscriber storagemarket.DiskSpaceSubscriber) shared.Unsubscribe {
+	return shared.Unsubscribe(p.diskSpaceSub.Subscribe(subscriber))
+}
+
+func (p *Provider) notifyDiskSpace(event storagemarket.DiskSpaceEvent, status storagemarket.DiskSpaceStatus) {
+	if err := p.diskSpaceSub.Publish(internalDiskSpaceEvent{event, status}); err != nil {
+		log.Errorf("failed to publish disk space event %s: %s", storagemarket.DiskSpaceEvents[event], err)
+	}
+}
+
+type internalDiskSpaceEvent struct {
+	evt    storagemarket.DiskSpaceEvent
+	status storagemarket.DiskSpaceStatus
+}
+
+func diskSpaceDispatcher(evt pubsub.Event, fn pubsub.SubscriberFn) error {
+	ie, ok := evt.(internalDiskSpaceEvent)
+	if !ok {
+		return xerrors.New("wrong type of event")
+	}
+	cb, ok := fn.(storagemarket.DiskSpaceSubscriber)
+	if !ok {
+		return xerrors.New("wrong type of callback")
+	}
+	cb(ie.evt, ie.status)
+	return nil
+}
@@ -0,0 +1,172 @@
+/*
+Package diskspace watches the free space on a storage provider's volumes, so that the
+provider can stop taking new deals before it runs out of room for their data.
+
+The watcher checks each volume periodically. Once any volume has less than the
+minimum free space, intake is paused, and stays paused until every volume has at
+least the resume threshold free. Keeping the resume threshold above the minimum stops
+intake from flapping on and off as deals fill and free space.
+*/
+package diskspace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+
+	"github.com/filecoin-project/go-fil-markets/shared/freespace"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("diskspace")
+
+// DefaultCheckInterval is how often the free space on each volume is checked
+const DefaultCheckInterval = time.Minute
+
+// FreeSpaceFunc returns the number of bytes free for unprivileged users on the volume
+// holding path
+type FreeSpaceFunc func(path string) (uint64, error)
+
+// NotifyFunc is called when intake is paused or resumed, or a volume cannot be checked
+type NotifyFunc func(event storagemarket.DiskSpaceEvent, status storagemarket.DiskSpaceStatus)
+
+// Watcher pauses deal intake while a volume is low on free space
+type Watcher struct {
+	paths      []string
+	minFree    uint64
+	resumeFree uint64
+	interval   time.Duration
+	freeSpace  FreeSpaceFunc
+	notify     NotifyFunc
+
+	lk     sync.Mutex
+	status storagemarket.DiskSpaceStatus
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Option configures a Watcher
+type Option func(w *Watcher)
+
+// CheckInterval sets how often the free space on each volume is checked
+func CheckInterval(interval time.Duration) Option {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// FreeSpace sets how the free space on a volume is read
+func FreeSpace(freeSpace FreeSpaceFunc) Option {
+	return func(w *Watcher) {
+		w.freeSpace = freeSpace
+	}
+}
+
+// New returns a Watcher for the volumes holding the given paths. Intake is paused
+// when a volume has less than minFree bytes free, and resumed once every volume has
+// resumeFree bytes free. A resumeFree below minFree is raised to minFree
+func New(paths []string, minFree uint64, resumeFree uint64, notify NotifyFunc, options ...Option) *Watcher {
+	if resumeFree < minFree {
+		resumeFree = minFree
+	}
+	w := &Watcher{
+		paths:      paths,
+		minFree:    minFree,
+		resumeFree: resumeFree,
+		interval:   DefaultCheckInterval,
+		freeSpace:  freespace.Available,
+		notify:     notify,
+		status:     storagemarket.DiskSpaceStatus{MinFree: minFree},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(w)
+	}
+	return w
+}
+
+// Start checks the volumes straight away, then periodically until Stop is called
+func (w *Watcher) Start() {
+	w.Check()
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops checking the volumes
+func (w *Watcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+// Status returns the result of the last check
+func (w *Watcher) Status() storagemarket.DiskSpaceStatus {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.status
+}
+
+// Paused returns true, and the reason, while intake is paused
+func (w *Watcher) Paused() (bool, string) {
+	status := w.Status()
+	if !status.Paused {
+		return false, ""
+	}
+	return true, fmt.Sprintf("provider is low on disk space: %d bytes free at %s, needs %d", status.Free, status.Path, status.MinFree)
+}
+
+// Check reads the free space on each volume, and pauses or resumes intake. A volume
+// that cannot be checked leaves intake as it is
+func (w *Watcher) Check() {
+	var lowest storagemarket.DiskSpaceStatus
+	for i, path := range w.paths {
+		free, err := w.freeSpace(path)
+		if err != nil {
+			log.Errorf("checking free space at %s: %s", path, err)
+			w.notify(storagemarket.DiskSpaceEventCheckFailed, storagemarket.DiskSpaceStatus{
+				Paused:  w.Status().Paused,
+				Path:    path,
+				MinFree: w.minFree,
+				Message: err.Error(),
+			})
+			return
+		}
+		if i == 0 || free < lowest.Free {
+			lowest = storagemarket.DiskSpaceStatus{Path: path, Free: free, MinFree: w.minFree}
+		}
+	}
+
+	w.lk.Lock()
+	wasPaused := w.status.Paused
+	switch {
+	case lowest.Free < w.minFree:
+		lowest.Paused = true
+	case wasPaused && lowest.Free < w.resumeFree:
+		lowest.Paused = true
+	}
+	w.status = lowest
+	w.lk.Unlock()
+
+	if lowest.Paused && !wasPaused {
+		log.Warnf("pausing deal intake: %d bytes free at %s", lowest.Free, lowest.Path)
+		w.notify(storagemarket.DiskSpaceEventLow, lowest)
+	}
+	if !lowest.Paused && wasPaused {
+		log.Infof("resuming deal intake: %d bytes free at %s", lowest.Free, lowest.Path)
+		w.notify(storagemarket.DiskSpaceEventRecovered, lowest)
+	}
+}
@@ -0,0 +1,73 @@
+package diskspace_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/diskspace"
+)
+
+type volumes struct {
+	lk   sync.Mutex
+	free map[string]uint64
+	err  error
+}
+
+func (v *volumes) set(path string, free uint64) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	v.free[path] = free
+}
+
+func (v *volumes) freeSpace(path string) (uint64, error) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	return v.free[path], v.err
+}
+
+func TestWatcher(t *testing.T) {
+	vols := &volumes{free: map[string]uint64{"/filestore": 1000, "/multistore": 1000}}
+	var events []storagemarket.DiskSpaceEvent
+	w := diskspace.New([]string{"/filestore", "/multistore"}, 100, 300, func(event storagemarket.DiskSpaceEvent, status storagemarket.DiskSpaceStatus) {
+		events = append(events, event)
+	}, diskspace.FreeSpace(vols.freeSpace))
+
+	w.Check()
+	paused, _ := w.Paused()
+	require.False(t, paused)
+	require.Empty(t, events)
+
+	// a single volume running low pauses intake
+	vols.set("/multistore", 50)
+	w.Check()
+	paused, reason := w.Paused()
+	require.True(t, paused)
+	require.Equal(t, "provider is low on disk space: 50 bytes free at /multistore, needs 100", reason)
+	require.Equal(t, []storagemarket.DiskSpaceEvent{storagemarket.DiskSpaceEventLow}, events)
+
+	// intake stays paused until there is enough space to resume
+	vols.set("/multistore", 200)
+	w.Check()
+	paused, _ = w.Paused()
+	require.True(t, paused)
+	require.Len(t, events, 1)
+
+	// a volume that cannot be checked leaves intake paused
+	vols.err = errors.New("something went wrong")
+	w.Check()
+	paused, _ = w.Paused()
+	require.True(t, paused)
+	require.Equal(t, storagemarket.DiskSpaceEventCheckFailed, events[1])
+	vols.err = nil
+
+	vols.set("/multistore", 300)
+	w.Check()
+	paused, _ = w.Paused()
+	require.False(t, paused)
+	require.Equal(t, storagemarket.DiskSpaceEventRecovered, events[2])
+	require.Equal(t, storagemarket.DiskSpaceStatus{Path: "/multistore", Free: 300, MinFree: 100}, w.Status())
+}
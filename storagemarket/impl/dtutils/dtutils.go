@@ -13,6 +13,7 @@ import (
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-statemachine/fsm"
 
+	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
 )
@@ -30,6 +31,7 @@ type EventReceiver interface {
 // and update message for the deal -- either moving to staged for a completion
 // event or moving to error if a data transfer error occurs
 func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
+	updates := shared.NewTransferUpdates(shared.DefaultTransferUpdateInterval)
 	return func(event datatransfer.Event, channelState datatransfer.ChannelState) {
 		voucher, ok := channelState.Voucher().(*requestvalidation.StorageDataTransferVoucher)
 		// if this event is for a transfer not related to storage, ignore
@@ -37,6 +39,15 @@ func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber
 			return
 		}
 
+		// keep the deal's record of the channel's status up to date, and its progress
+		// every so often
+		if updates.Due(channelState) {
+			err := deals.Send(voucher.Proposal, storagemarket.ProviderEventDataTransferUpdated, channelState)
+			if err != nil {
+				log.Errorf("processing dt event: %w", err)
+			}
+		}
+
 		if channelState.Status() == datatransfer.Completed {
 			err := deals.Send(voucher.Proposal, storagemarket.ProviderEventDataTransferCompleted)
 			if err != nil {
@@ -45,7 +56,7 @@ func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber
 		}
 
 		// Translate from data transfer events to provider FSM events
-		// Note: We ignore data transfer progress events (they do not affect deal state)
+		// Note: progress events only update the deal's record of the channel, above
 		err := func() error {
 			switch event.Code {
 			case datatransfer.Cancel:
@@ -73,6 +84,7 @@ func ProviderDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber
 // in a storage market deal, then, based on the data transfer event that occurred, it dispatches
 // an event to the appropriate state machine
 func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
+	updates := shared.NewTransferUpdates(shared.DefaultTransferUpdateInterval)
 	return func(event datatransfer.Event, channelState datatransfer.ChannelState) {
 		voucher, ok := channelState.Voucher().(*requestvalidation.StorageDataTransferVoucher)
 		// if this event is for a transfer not related to storage, ignore
@@ -80,6 +92,15 @@ func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 			return
 		}
 
+		// keep the deal's record of the channel's status up to date, and its progress
+		// every so often
+		if updates.Due(channelState) {
+			err := deals.Send(voucher.Proposal, storagemarket.ClientEventDataTransferUpdated, channelState)
+			if err != nil {
+				log.Errorf("processing dt event: %w", err)
+			}
+		}
+
 		if channelState.Status() == datatransfer.Completed {
 			err := deals.Send(voucher.Proposal, storagemarket.ClientEventDataTransferComplete)
 			if err != nil {
@@ -88,7 +109,7 @@ func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 		}
 
 		// Translate from data transfer events to client FSM events
-		// Note: We ignore data transfer progress events (they do not affect deal state)
+		// Note: progress events only update the deal's record of the channel, above
 		err := func() error {
 			switch event.Code {
 			case datatransfer.Cancel:
@@ -111,6 +132,33 @@ func ClientDataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 	}
 }
 
+// BandwidthLimiter meters the data sent on data transfer channels
+type BandwidthLimiter interface {
+	Record(chid datatransfer.ChannelID, sent uint64)
+	Forget(chid datatransfer.ChannelID)
+}
+
+// ClientBandwidthSubscriber is the function called when an event occurs in a data
+// transfer sent by a client -- it reports the data sent for storage deals to the
+// bandwidth limiter, and stops tracking transfers once they finish
+func ClientBandwidthSubscriber(limiter BandwidthLimiter) datatransfer.Subscriber {
+	return func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+		// if this event is for a transfer not related to storage, ignore
+		if _, ok := channelState.Voucher().(*requestvalidation.StorageDataTransferVoucher); !ok {
+			return
+		}
+
+		switch channelState.Status() {
+		case datatransfer.Completed, datatransfer.Failed, datatransfer.Cancelled:
+			limiter.Forget(channelState.ChannelID())
+			return
+		}
+		if event.Code == datatransfer.DataSent {
+			limiter.Record(channelState.ChannelID(), channelState.Sent())
+		}
+	}
+}
+
 // StoreGetter retrieves the store for a given proposal cid
 type StoreGetter interface {
 	Get(proposalCid cid.Cid) (*multistore.Store, error)
@@ -85,11 +85,12 @@ func TestProviderDataTransferSubscriber(t *testing.T) {
 		"data received": {
 			code:   datatransfer.DataReceived,
 			status: datatransfer.Ongoing,
-			called: false,
+			called: true,
 			voucher: &requestvalidation.StorageDataTransferVoucher{
 				Proposal: expectedProposalCID,
 			},
-			expectedID: expectedProposalCID,
+			expectedID:    expectedProposalCID,
+			expectedEvent: storagemarket.ProviderEventDataTransferUpdated,
 		},
 		"error event": {
 			code:    datatransfer.Error,
@@ -106,25 +107,35 @@ func TestProviderDataTransferSubscriber(t *testing.T) {
 		"other event": {
 			code:   datatransfer.DataSent,
 			status: datatransfer.Ongoing,
-			called: false,
+			called: true,
 			voucher: &requestvalidation.StorageDataTransferVoucher{
 				Proposal: expectedProposalCID,
 			},
+			expectedID:    expectedProposalCID,
+			expectedEvent: storagemarket.ProviderEventDataTransferUpdated,
 		},
 	}
 	for test, data := range tests {
 		t.Run(test, func(t *testing.T) {
 			fdg := &fakeDealGroup{}
 			subscriber := dtutils.ProviderDataTransferSubscriber(fdg)
-			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, shared_testutil.NewTestChannel(
+			channelState := shared_testutil.NewTestChannel(
 				shared_testutil.TestChannelParams{Vouchers: []datatransfer.Voucher{data.voucher}, Status: data.status,
 					Sender: init, Recipient: resp, TransferID: tid, IsPull: false},
-			))
+			)
+			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, channelState)
 			if data.called {
 				require.True(t, fdg.called)
+				// the deal's record of the channel is updated before any other event
+				require.Equal(t, fdg.firstEvent, storagemarket.ProviderEventDataTransferUpdated)
+				require.Equal(t, fdg.firstArgs, []interface{}{channelState})
+				expectedArgs := data.expectedArgs
+				if data.expectedEvent == storagemarket.ProviderEventDataTransferUpdated {
+					expectedArgs = []interface{}{channelState}
+				}
 				require.Equal(t, fdg.lastID, data.expectedID)
 				require.Equal(t, fdg.lastEvent, data.expectedEvent)
-				require.Equal(t, fdg.lastArgs, data.expectedArgs)
+				require.Equal(t, fdg.lastArgs, expectedArgs)
 			} else {
 				require.False(t, fdg.called)
 			}
@@ -132,6 +143,36 @@ func TestProviderDataTransferSubscriber(t *testing.T) {
 	}
 }
 
+func TestProviderDataTransferSubscriberProgress(t *testing.T) {
+	ps := shared_testutil.GeneratePeers(2)
+	proposalCID := shared_testutil.GenerateCids(1)[0]
+	channel := func(status datatransfer.Status) datatransfer.ChannelState {
+		return shared_testutil.NewTestChannel(shared_testutil.TestChannelParams{
+			Vouchers:   []datatransfer.Voucher{&requestvalidation.StorageDataTransferVoucher{Proposal: proposalCID}},
+			Status:     status,
+			Sender:     ps[0],
+			Recipient:  ps[1],
+			TransferID: datatransfer.TransferID(1),
+		})
+	}
+	fdg := &fakeDealGroup{}
+	subscriber := dtutils.ProviderDataTransferSubscriber(fdg)
+
+	subscriber(datatransfer.Event{Code: datatransfer.DataReceived}, channel(datatransfer.Ongoing))
+	require.True(t, fdg.called)
+	require.Equal(t, storagemarket.ProviderEventDataTransferUpdated, fdg.lastEvent)
+
+	// more progress soon after does not update the deal again
+	*fdg = fakeDealGroup{}
+	subscriber(datatransfer.Event{Code: datatransfer.DataReceived}, channel(datatransfer.Ongoing))
+	require.False(t, fdg.called)
+
+	// a change of status does
+	subscriber(datatransfer.Event{Code: datatransfer.PauseInitiator}, channel(datatransfer.InitiatorPaused))
+	require.True(t, fdg.called)
+	require.Equal(t, storagemarket.ProviderEventDataTransferUpdated, fdg.lastEvent)
+}
+
 func TestClientDataTransferSubscriber(t *testing.T) {
 	ps := shared_testutil.GeneratePeers(2)
 	init := ps[0]
@@ -210,10 +251,12 @@ func TestClientDataTransferSubscriber(t *testing.T) {
 		"other event": {
 			code:   datatransfer.DataReceived,
 			status: datatransfer.Ongoing,
-			called: false,
+			called: true,
 			voucher: &requestvalidation.StorageDataTransferVoucher{
 				Proposal: expectedProposalCID,
 			},
+			expectedID:    expectedProposalCID,
+			expectedEvent: storagemarket.ClientEventDataTransferUpdated,
 		},
 	}
 
@@ -221,15 +264,23 @@ func TestClientDataTransferSubscriber(t *testing.T) {
 		t.Run(test, func(t *testing.T) {
 			fdg := &fakeDealGroup{}
 			subscriber := dtutils.ClientDataTransferSubscriber(fdg)
-			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, shared_testutil.NewTestChannel(
+			channelState := shared_testutil.NewTestChannel(
 				shared_testutil.TestChannelParams{Vouchers: []datatransfer.Voucher{data.voucher}, Status: data.status,
 					Sender: init, Recipient: resp, TransferID: tid, IsPull: false},
-			))
+			)
+			subscriber(datatransfer.Event{Code: data.code, Message: data.message}, channelState)
 			if data.called {
 				require.True(t, fdg.called)
+				// the deal's record of the channel is updated before any other event
+				require.Equal(t, fdg.firstEvent, storagemarket.ClientEventDataTransferUpdated)
+				require.Equal(t, fdg.firstArgs, []interface{}{channelState})
+				expectedArgs := data.expectedArgs
+				if data.expectedEvent == storagemarket.ClientEventDataTransferUpdated {
+					expectedArgs = []interface{}{channelState}
+				}
 				require.Equal(t, fdg.lastID, data.expectedID)
 				require.Equal(t, fdg.lastEvent, data.expectedEvent)
-				require.Equal(t, fdg.lastArgs, data.expectedArgs)
+				require.Equal(t, fdg.lastArgs, expectedArgs)
 			} else {
 				require.False(t, fdg.called)
 			}
@@ -237,6 +288,60 @@ func TestClientDataTransferSubscriber(t *testing.T) {
 	}
 }
 
+func TestClientBandwidthSubscriber(t *testing.T) {
+	ps := shared_testutil.GeneratePeers(2)
+	voucher := &requestvalidation.StorageDataTransferVoucher{Proposal: shared_testutil.GenerateCids(1)[0]}
+	tests := map[string]struct {
+		code           datatransfer.EventCode
+		status         datatransfer.Status
+		voucher        datatransfer.Voucher
+		expectedSent   []uint64
+		expectedForget bool
+	}{
+		"not a storage voucher": {
+			code:   datatransfer.DataSent,
+			status: datatransfer.Ongoing,
+		},
+		"data sent": {
+			code:         datatransfer.DataSent,
+			status:       datatransfer.Ongoing,
+			voucher:      voucher,
+			expectedSent: []uint64{1000},
+		},
+		"other event": {
+			code:    datatransfer.Accept,
+			status:  datatransfer.Ongoing,
+			voucher: voucher,
+		},
+		"transfer completed": {
+			code:           datatransfer.Complete,
+			status:         datatransfer.Completed,
+			voucher:        voucher,
+			expectedForget: true,
+		},
+		"transfer failed": {
+			code:           datatransfer.Error,
+			status:         datatransfer.Failed,
+			voucher:        voucher,
+			expectedForget: true,
+		},
+	}
+
+	for test, data := range tests {
+		t.Run(test, func(t *testing.T) {
+			limiter := &fakeBandwidthLimiter{}
+			subscriber := dtutils.ClientBandwidthSubscriber(limiter)
+			channelState := shared_testutil.NewTestChannel(
+				shared_testutil.TestChannelParams{Vouchers: []datatransfer.Voucher{data.voucher}, Status: data.status,
+					Sender: ps[0], Recipient: ps[1], TransferID: datatransfer.TransferID(1), Sent: 1000},
+			)
+			subscriber(datatransfer.Event{Code: data.code}, channelState)
+			require.Equal(t, data.expectedSent, limiter.sent)
+			require.Equal(t, data.expectedForget, limiter.forgotten)
+		})
+	}
+}
+
 func TestTransportConfigurer(t *testing.T) {
 	expectedProposalCID := shared_testutil.GenerateCids(1)[0]
 	expectedChannelID := shared_testutil.MakeTestChannelID()
@@ -307,12 +412,18 @@ func TestTransportConfigurer(t *testing.T) {
 type fakeDealGroup struct {
 	returnedErr error
 	called      bool
+	firstEvent  fsm.EventName
+	firstArgs   []interface{}
 	lastID      interface{}
 	lastEvent   fsm.EventName
 	lastArgs    []interface{}
 }
 
 func (fdg *fakeDealGroup) Send(id interface{}, name fsm.EventName, args ...interface{}) (err error) {
+	if !fdg.called {
+		fdg.firstEvent = name
+		fdg.firstArgs = args
+	}
 	fdg.lastID = id
 	fdg.lastEvent = name
 	fdg.lastArgs = args
@@ -320,6 +431,19 @@ func (fdg *fakeDealGroup) Send(id interface{}, name fsm.EventName, args ...inter
 	return fdg.returnedErr
 }
 
+type fakeBandwidthLimiter struct {
+	sent      []uint64
+	forgotten bool
+}
+
+func (fbl *fakeBandwidthLimiter) Record(chid datatransfer.ChannelID, sent uint64) {
+	fbl.sent = append(fbl.sent, sent)
+}
+
+func (fbl *fakeBandwidthLimiter) Forget(chid datatransfer.ChannelID) {
+	fbl.forgotten = true
+}
+
 type fakeStoreGetter struct {
 	lastProposalCid cid.Cid
 	returnedErr     error
@@ -0,0 +1,29 @@
+package storageimpl
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundprovision"
+)
+
+// PreProvisionFunds makes the provider top up its market balance ahead of the deals
+// it has accepted, so that they find the funds already available when they reach
+// ReserveProviderFunds, rather than each waiting on a message of its own. Funding is
+// estimated periodically and whenever a deal is accepted; see the fundprovision
+// package. It must be passed to NewProvider
+func PreProvisionFunds(options ...fundprovision.Option) StorageProviderOption {
+	return func(p *Provider) {
+		p.fundProvisioner = fundprovision.New(p.spn, p.actor, p.ListLocalDeals, p.GetAsk, options...)
+	}
+}
+
+// FundingEstimate returns the funding the provider's accepted deals are expected to
+// need, and how much the provider would add to its market balance to cover it. It
+// returns false if the provider does not pre-provision funds
+func (p *Provider) FundingEstimate(ctx context.Context) (fundprovision.Estimate, bool, error) {
+	if p.fundProvisioner == nil {
+		return fundprovision.Estimate{}, false, nil
+	}
+	estimate, err := p.fundProvisioner.Estimate(ctx)
+	return estimate, true, err
+}
@@ -0,0 +1,299 @@
+/*
+Package fundprovision tops up a storage provider's market balance ahead of the deals
+that will need it, so that deals do not wait on an AddFunds message when they reach
+ReserveProviderFunds.
+
+A Provisioner estimates the collateral the provider's accepted deals will reserve
+before they are published: the collateral of deals that have not reserved funds yet,
+plus the funds reserved by deals that are not published yet, which the market actor
+has not locked. To that it adds headroom for a number of deals the provider has not
+accepted yet, each needing the minimum collateral for a piece of the largest size the
+provider's ask takes. If the provider's available market balance falls short of the
+estimate, the shortfall is added to it in a single message.
+
+Estimates are made periodically, and whenever the provider accepts a deal. While a
+top up message is waiting to land, no further top ups are sent.
+*/
+package fundprovision
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("fundprovision")
+
+// DefaultCheckInterval is how often the provider's funding is estimated, besides
+// whenever it accepts a deal
+const DefaultCheckInterval = 10 * time.Minute
+
+// DealsFunc returns the provider's deals
+type DealsFunc func() ([]storagemarket.MinerDeal, error)
+
+// AskFunc returns the provider's current ask, or nil if it has none
+type AskFunc func() *storagemarket.SignedStorageAsk
+
+// pendingStates are the states of accepted deals that will reserve funds before
+// they are published
+var pendingStates = map[storagemarket.StorageDealStatus]bool{
+	storagemarket.StorageDealProposalAccepted:        true,
+	storagemarket.StorageDealWaitingForData:          true,
+	storagemarket.StorageDealTransferQueued:          true,
+	storagemarket.StorageDealTransferring:            true,
+	storagemarket.StorageDealProviderTransferRestart: true,
+	storagemarket.StorageDealVerifyData:              true,
+	storagemarket.StorageDealReserveProviderFunds:    true,
+}
+
+// Estimate is the funding a Provisioner expects the provider's deals to need
+type Estimate struct {
+	// Pending is the collateral of accepted deals that have not reserved funds yet
+	Pending abi.TokenAmount
+	// Reserved is the funds reserved by deals that are not published yet
+	Reserved abi.TokenAmount
+	// Headroom is the collateral kept available for deals not accepted yet
+	Headroom abi.TokenAmount
+	// Available is the provider's available market balance
+	Available abi.TokenAmount
+	// TopUp is the amount to add to the market balance to cover the rest, after
+	// any cap on top ups
+	TopUp abi.TokenAmount
+}
+
+// Provisioner tops up a provider's market balance ahead of its deals
+type Provisioner struct {
+	node     storagemarket.StorageProviderNode
+	provider address.Address
+	deals    DealsFunc
+	ask      AskFunc
+	interval time.Duration
+	headroom uint64
+	maxTopUp abi.TokenAmount
+
+	lk      sync.Mutex
+	topUpLk sync.Mutex
+	pending cid.Cid
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	poke   chan struct{}
+	waits  sync.WaitGroup
+	done   chan struct{}
+}
+
+// Option configures a Provisioner
+type Option func(p *Provisioner)
+
+// CheckInterval sets how often the provider's funding is estimated, besides
+// whenever it accepts a deal
+func CheckInterval(interval time.Duration) Option {
+	return func(p *Provisioner) {
+		p.interval = interval
+	}
+}
+
+// HeadroomDeals keeps enough collateral available for n more deals than the
+// provider has accepted, each for a piece of the largest size its ask takes
+func HeadroomDeals(n uint64) Option {
+	return func(p *Provisioner) {
+		p.headroom = n
+	}
+}
+
+// MaxTopUp caps the amount added to the market balance in a single message
+func MaxTopUp(amount abi.TokenAmount) Option {
+	return func(p *Provisioner) {
+		p.maxTopUp = amount
+	}
+}
+
+// New returns a Provisioner that tops up the market balance of the provider address
+// through node, for the deals returned by deals and the ask returned by ask
+func New(node storagemarket.StorageProviderNode, provider address.Address, deals DealsFunc, ask AskFunc, options ...Option) *Provisioner {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Provisioner{
+		node:     node,
+		provider: provider,
+		deals:    deals,
+		ask:      ask,
+		interval: DefaultCheckInterval,
+		maxTopUp: big.Zero(),
+		ctx:      ctx,
+		cancel:   cancel,
+		poke:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Start estimates the provider's funding straight away, then periodically and when
+// poked, until Stop is called
+func (p *Provisioner) Start() {
+	go func() {
+		defer close(p.done)
+		p.check()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-ticker.C:
+				p.check()
+			case <-p.poke:
+				p.check()
+			}
+		}
+	}()
+}
+
+// Stop stops estimating the provider's funding, and waiting for top up messages
+func (p *Provisioner) Stop() {
+	p.cancel()
+	<-p.done
+	p.waits.Wait()
+}
+
+// Poke asks for the provider's funding to be estimated again, such as when it
+// accepts a deal. It does not wait for the estimate
+func (p *Provisioner) Poke() {
+	select {
+	case p.poke <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the CID of the top up message waiting to land, or false if there
+// is none
+func (p *Provisioner) Pending() (cid.Cid, bool) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	return p.pending, p.pending != cid.Undef
+}
+
+func (p *Provisioner) check() {
+	if _, err := p.Check(p.ctx); err != nil {
+		log.Errorf("pre-provisioning funds for %s: %s", p.provider, err)
+	}
+}
+
+// Check estimates the provider's funding, and sends a message to top up its market
+// balance if it falls short. It returns the CID of the message, or cid.Undef if none
+// was sent because the balance is enough or an earlier top up is waiting to land
+func (p *Provisioner) Check(ctx context.Context) (cid.Cid, error) {
+	p.topUpLk.Lock()
+	defer p.topUpLk.Unlock()
+
+	if _, ok := p.Pending(); ok {
+		return cid.Undef, nil
+	}
+	estimate, err := p.Estimate(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if estimate.TopUp.LessThanEqual(big.Zero()) {
+		return cid.Undef, nil
+	}
+
+	mcid, err := p.node.AddFunds(ctx, p.provider, estimate.TopUp)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("adding %s to market balance: %w", estimate.TopUp, err)
+	}
+	log.Infof("adding %s to market balance of %s ahead of deals, in message %s", estimate.TopUp, p.provider, mcid)
+
+	p.lk.Lock()
+	p.pending = mcid
+	p.lk.Unlock()
+	p.waits.Add(1)
+	go p.wait(mcid)
+	return mcid, nil
+}
+
+func (p *Provisioner) wait(mcid cid.Cid) {
+	defer p.waits.Done()
+	defer func() {
+		p.lk.Lock()
+		p.pending = cid.Undef
+		p.lk.Unlock()
+	}()
+	err := p.node.WaitForMessage(p.ctx, mcid, func(code exitcode.ExitCode, _ []byte, _ cid.Cid, err error) error {
+		if err != nil {
+			return err
+		}
+		if code != exitcode.Ok {
+			return xerrors.Errorf("exit code: %s", code)
+		}
+		return nil
+	})
+	if err != nil && p.ctx.Err() == nil {
+		log.Errorf("adding funds to market balance of %s in message %s: %s", p.provider, mcid, err)
+	}
+}
+
+// Estimate returns the funding the provider's deals are expected to need, and how
+// much to add to its market balance to cover it
+func (p *Provisioner) Estimate(ctx context.Context) (Estimate, error) {
+	estimate := Estimate{
+		Pending:  big.Zero(),
+		Reserved: big.Zero(),
+		Headroom: big.Zero(),
+		TopUp:    big.Zero(),
+	}
+
+	deals, err := p.deals()
+	if err != nil {
+		return Estimate{}, xerrors.Errorf("listing deals: %w", err)
+	}
+	for _, deal := range deals {
+		reserved := !deal.FundsReserved.Nil() && deal.FundsReserved.GreaterThan(big.Zero())
+		if reserved {
+			estimate.Reserved = big.Add(estimate.Reserved, deal.FundsReserved)
+		} else if pendingStates[deal.State] {
+			estimate.Pending = big.Add(estimate.Pending, deal.Proposal.ProviderCollateral)
+		}
+	}
+
+	if p.headroom > 0 {
+		if ask := p.ask(); ask != nil && ask.Ask != nil {
+			minCollateral, _, err := p.node.DealProviderCollateralBounds(ctx, ask.Ask.MaxPieceSize, false)
+			if err != nil {
+				return Estimate{}, xerrors.Errorf("getting collateral bounds: %w", err)
+			}
+			estimate.Headroom = big.Mul(minCollateral, big.NewIntUnsigned(p.headroom))
+		}
+	}
+
+	tok, _, err := p.node.GetChainHead(ctx)
+	if err != nil {
+		return Estimate{}, xerrors.Errorf("getting chain head: %w", err)
+	}
+	balance, err := p.node.GetBalance(ctx, p.provider, tok)
+	if err != nil {
+		return Estimate{}, xerrors.Errorf("getting market balance: %w", err)
+	}
+	estimate.Available = balance.Available
+
+	needed := big.Sum(estimate.Pending, estimate.Reserved, estimate.Headroom)
+	if shortfall := big.Sub(needed, balance.Available); shortfall.GreaterThan(big.Zero()) {
+		estimate.TopUp = shortfall
+		if !p.maxTopUp.Nil() && p.maxTopUp.GreaterThan(big.Zero()) && shortfall.GreaterThan(p.maxTopUp) {
+			estimate.TopUp = p.maxTopUp
+		}
+	}
+	return estimate, nil
+}
@@ -0,0 +1,151 @@
+package fundprovision_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundprovision"
+)
+
+type fakeNode struct {
+	storagemarket.StorageProviderNode
+	minCollateral abi.TokenAmount
+	landed        chan struct{}
+
+	lk        sync.Mutex
+	available abi.TokenAmount
+	added     []abi.TokenAmount
+}
+
+func (fn *fakeNode) GetChainHead(ctx context.Context) (shared.TipSetToken, abi.ChainEpoch, error) {
+	return shared.TipSetToken{1}, 10, nil
+}
+
+func (fn *fakeNode) GetBalance(ctx context.Context, addr address.Address, tok shared.TipSetToken) (storagemarket.Balance, error) {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	return storagemarket.Balance{Locked: big.Zero(), Available: fn.available}, nil
+}
+
+func (fn *fakeNode) DealProviderCollateralBounds(ctx context.Context, size abi.PaddedPieceSize, isVerified bool) (abi.TokenAmount, abi.TokenAmount, error) {
+	return big.Mul(fn.minCollateral, big.NewIntUnsigned(uint64(size))), big.Zero(), nil
+}
+
+func (fn *fakeNode) AddFunds(ctx context.Context, addr address.Address, amount abi.TokenAmount) (cid.Cid, error) {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	fn.added = append(fn.added, amount)
+	return shared_testutil.GenerateCids(1)[0], nil
+}
+
+func (fn *fakeNode) WaitForMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-fn.landed:
+	}
+	fn.lk.Lock()
+	fn.available = big.Add(fn.available, fn.added[len(fn.added)-1])
+	fn.lk.Unlock()
+	return onCompletion(exitcode.Ok, nil, mcid, nil)
+}
+
+func deal(state storagemarket.StorageDealStatus, collateral int64, reserved abi.TokenAmount) storagemarket.MinerDeal {
+	return storagemarket.MinerDeal{
+		ClientDealProposal: market.ClientDealProposal{
+			Proposal: market.DealProposal{ProviderCollateral: abi.NewTokenAmount(collateral)},
+		},
+		State:         state,
+		FundsReserved: reserved,
+	}
+}
+
+func TestProvisioner(t *testing.T) {
+	ctx := context.Background()
+	deals := []storagemarket.MinerDeal{
+		// accepted deals yet to reserve funds
+		deal(storagemarket.StorageDealTransferring, 100, big.Int{}),
+		deal(storagemarket.StorageDealReserveProviderFunds, 50, big.Zero()),
+		// reserved, but not yet locked by publishing
+		deal(storagemarket.StorageDealPublishing, 200, abi.NewTokenAmount(200)),
+		// neither accepted, nor holding funds
+		deal(storagemarket.StorageDealValidating, 1000, big.Zero()),
+		deal(storagemarket.StorageDealActive, 1000, big.Zero()),
+	}
+	dealsFunc := func() ([]storagemarket.MinerDeal, error) { return deals, nil }
+	ask := &storagemarket.SignedStorageAsk{Ask: &storagemarket.StorageAsk{MaxPieceSize: 4}}
+	askFunc := func() *storagemarket.SignedStorageAsk { return ask }
+
+	t.Run("estimates pending, reserved and headroom collateral", func(t *testing.T) {
+		node := &fakeNode{minCollateral: abi.NewTokenAmount(10), available: abi.NewTokenAmount(300)}
+		p := fundprovision.New(node, address.TestAddress, dealsFunc, askFunc, fundprovision.HeadroomDeals(2))
+		estimate, err := p.Estimate(ctx)
+		require.NoError(t, err)
+		require.Equal(t, abi.NewTokenAmount(150), estimate.Pending)
+		require.Equal(t, abi.NewTokenAmount(200), estimate.Reserved)
+		require.Equal(t, abi.NewTokenAmount(80), estimate.Headroom)
+		require.Equal(t, abi.NewTokenAmount(300), estimate.Available)
+		require.Equal(t, abi.NewTokenAmount(130), estimate.TopUp)
+	})
+
+	t.Run("tops up once while the message lands", func(t *testing.T) {
+		node := &fakeNode{minCollateral: abi.NewTokenAmount(10), available: abi.NewTokenAmount(100), landed: make(chan struct{})}
+		p := fundprovision.New(node, address.TestAddress, dealsFunc, askFunc)
+
+		mcid, err := p.Check(ctx)
+		require.NoError(t, err)
+		require.NotEqual(t, cid.Undef, mcid)
+		pending, ok := p.Pending()
+		require.True(t, ok)
+		require.Equal(t, mcid, pending)
+
+		mcid, err = p.Check(ctx)
+		require.NoError(t, err)
+		require.Equal(t, cid.Undef, mcid)
+		require.Equal(t, []abi.TokenAmount{abi.NewTokenAmount(250)}, node.added)
+
+		close(node.landed)
+		require.Eventually(t, func() bool {
+			_, ok := p.Pending()
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+
+		// the balance now covers the deals
+		mcid, err = p.Check(ctx)
+		require.NoError(t, err)
+		require.Equal(t, cid.Undef, mcid)
+		require.Len(t, node.added, 1)
+	})
+
+	t.Run("caps top ups", func(t *testing.T) {
+		node := &fakeNode{minCollateral: abi.NewTokenAmount(10), available: big.Zero()}
+		p := fundprovision.New(node, address.TestAddress, dealsFunc, askFunc, fundprovision.MaxTopUp(abi.NewTokenAmount(100)))
+		estimate, err := p.Estimate(ctx)
+		require.NoError(t, err)
+		require.Equal(t, abi.NewTokenAmount(100), estimate.TopUp)
+	})
+
+	t.Run("no ask, no headroom", func(t *testing.T) {
+		node := &fakeNode{minCollateral: abi.NewTokenAmount(10), available: abi.NewTokenAmount(1000)}
+		noAsk := func() *storagemarket.SignedStorageAsk { return nil }
+		p := fundprovision.New(node, address.TestAddress, dealsFunc, noAsk, fundprovision.HeadroomDeals(2))
+		estimate, err := p.Estimate(ctx)
+		require.NoError(t, err)
+		require.True(t, estimate.Headroom.IsZero())
+		require.True(t, estimate.TopUp.IsZero())
+	})
+}
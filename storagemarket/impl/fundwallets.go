@@ -0,0 +1,51 @@
+package storageimpl
+
+import (
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundwallets"
+)
+
+// FundFromWallets makes the provider reserve the collateral for each deal from one of
+// the given wallets, picked following policy, instead of from the miner's worker
+// address, so that funding messages for different deals can be sent in parallel. The
+// wallet picked is recorded in the deal's FundingWallet; see the fundwallets package
+func FundFromWallets(wallets []address.Address, policy fundwallets.Policy, options ...fundwallets.Option) StorageProviderOption {
+	return func(p *Provider) {
+		p.fundingWallets = fundwallets.New(wallets, policy, options...)
+	}
+}
+
+// PendingFundingMessages returns the number of funding messages in flight from each of
+// the provider's funding wallets. It returns nil if the provider funds deals from the
+// miner's worker address
+func (p *Provider) PendingFundingMessages() map[address.Address]int {
+	if p.fundingWallets == nil {
+		return nil
+	}
+	return p.fundingWallets.Pending()
+}
+
+// trackFundingMessage counts a deal's funding message as in flight from its funding
+// wallet while the deal waits for it to land
+func (p *Provider) trackFundingMessage(deal storagemarket.MinerDeal) {
+	if p.fundingWallets == nil || deal.FundingWallet == nil {
+		return
+	}
+	if deal.State == storagemarket.StorageDealProviderFunding {
+		p.fundingWallets.Sent(deal.ProposalCid, *deal.FundingWallet)
+		return
+	}
+	p.fundingWallets.Landed(deal.ProposalCid)
+}
+
+// restoreFundingMessages counts the funding messages of deals that were waiting for
+// them when the provider restarted
+func (p *Provider) restoreFundingMessages(deals []storagemarket.MinerDeal) {
+	for _, deal := range deals {
+		if deal.State == storagemarket.StorageDealProviderFunding {
+			p.trackFundingMessage(deal)
+		}
+	}
+}
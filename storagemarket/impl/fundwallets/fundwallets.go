@@ -0,0 +1,188 @@
+/*
+Package fundwallets picks which of a set of wallets a storage provider reserves each
+deal's collateral from, so that a large provider can send funding messages from
+several wallets in parallel rather than queueing them all on one wallet's nonce, and
+can keep the collateral for some clients in wallets of their own.
+
+A Selector picks a wallet for each deal following its Policy. RoundRobin takes the
+wallets in turn. LeastPending takes the wallet with the fewest funding messages still
+waiting to land, which is the wallet whose next message is least likely to queue
+behind others. PerClient funds the deals of clients given a wallet with Dedicate from
+that wallet only, and spreads the deals of other clients over the wallets not
+dedicated to anyone, in turn.
+
+Funding messages in flight are counted in memory, from when a deal's message is sent
+until it lands or the deal fails, so the provider restores them for deals that were
+waiting on funding when it restarted.
+*/
+package fundwallets
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// Policy is how a Selector picks a wallet for a deal
+type Policy int
+
+const (
+	// RoundRobin takes the wallets in turn
+	RoundRobin Policy = iota
+	// LeastPending takes the wallet with the fewest funding messages in flight
+	LeastPending
+	// PerClient funds each client's deals from the wallet dedicated to the client,
+	// and the deals of other clients from the wallets not dedicated to anyone
+	PerClient
+)
+
+// Selector picks the wallet to fund each deal from
+type Selector struct {
+	wallets   []address.Address
+	policy    Policy
+	dedicated map[address.Address]address.Address
+
+	lk      sync.Mutex
+	next    int
+	pending map[cid.Cid]address.Address
+}
+
+// Option configures a Selector
+type Option func(s *Selector)
+
+// Dedicate funds the deals of a client from the given wallet, under the PerClient
+// policy. The wallet is added to the Selector's wallets if it is not one already
+func Dedicate(client address.Address, wallet address.Address) Option {
+	return func(s *Selector) {
+		s.dedicated[client] = wallet
+	}
+}
+
+// New returns a Selector that picks from the given wallets following policy
+func New(wallets []address.Address, policy Policy, options ...Option) *Selector {
+	s := &Selector{
+		wallets:   append([]address.Address{}, wallets...),
+		policy:    policy,
+		dedicated: make(map[address.Address]address.Address),
+		pending:   make(map[cid.Cid]address.Address),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	for _, wallet := range s.dedicated {
+		if !s.has(wallet) {
+			s.wallets = append(s.wallets, wallet)
+		}
+	}
+	return s
+}
+
+func (s *Selector) has(wallet address.Address) bool {
+	for _, w := range s.wallets {
+		if w == wallet {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the wallet to fund a deal from the given client from. It returns
+// false if there is no wallet for the deal, such as when every wallet is dedicated
+// to other clients
+func (s *Selector) Select(client address.Address) (address.Address, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	switch s.policy {
+	case LeastPending:
+		return s.leastPending()
+	case PerClient:
+		if wallet, ok := s.dedicated[client]; ok {
+			return wallet, true
+		}
+		return s.roundRobin(s.shared())
+	default:
+		return s.roundRobin(s.wallets)
+	}
+}
+
+// shared returns the wallets not dedicated to any client
+func (s *Selector) shared() []address.Address {
+	dedicated := make(map[address.Address]struct{}, len(s.dedicated))
+	for _, wallet := range s.dedicated {
+		dedicated[wallet] = struct{}{}
+	}
+	var shared []address.Address
+	for _, wallet := range s.wallets {
+		if _, ok := dedicated[wallet]; !ok {
+			shared = append(shared, wallet)
+		}
+	}
+	return shared
+}
+
+func (s *Selector) roundRobin(wallets []address.Address) (address.Address, bool) {
+	if len(wallets) == 0 {
+		return address.Undef, false
+	}
+	wallet := wallets[s.next%len(wallets)]
+	s.next++
+	return wallet, true
+}
+
+// leastPending returns the wallet with the fewest messages in flight, taking wallets
+// that tie in turn
+func (s *Selector) leastPending() (address.Address, bool) {
+	if len(s.wallets) == 0 {
+		return address.Undef, false
+	}
+	counts := s.counts()
+	best := -1
+	for i := range s.wallets {
+		idx := (s.next + i) % len(s.wallets)
+		if best == -1 || counts[s.wallets[idx]] < counts[s.wallets[best]] {
+			best = idx
+		}
+	}
+	s.next = best + 1
+	return s.wallets[best], true
+}
+
+func (s *Selector) counts() map[address.Address]int {
+	counts := make(map[address.Address]int, len(s.wallets))
+	for _, wallet := range s.pending {
+		counts[wallet]++
+	}
+	return counts
+}
+
+// Sent records that a funding message for a deal was sent from wallet, and is in
+// flight until Landed is called for the deal
+func (s *Selector) Sent(proposalCid cid.Cid, wallet address.Address) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.pending[proposalCid] = wallet
+}
+
+// Landed records that the funding message for a deal is no longer in flight, because
+// it landed or the deal failed. It does nothing for deals with no message in flight
+func (s *Selector) Landed(proposalCid cid.Cid) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.pending, proposalCid)
+}
+
+// Pending returns the number of funding messages in flight from each wallet
+func (s *Selector) Pending() map[address.Address]int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	counts := s.counts()
+	for _, wallet := range s.wallets {
+		if _, ok := counts[wallet]; !ok {
+			counts[wallet] = 0
+		}
+	}
+	return counts
+}
@@ -0,0 +1,78 @@
+package fundwallets_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundwallets"
+)
+
+func idAddr(t *testing.T, id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return addr
+}
+
+func TestSelector(t *testing.T) {
+	wallets := []address.Address{
+		idAddr(t, 101),
+		idAddr(t, 102),
+		idAddr(t, 103),
+	}
+	client := idAddr(t, 200)
+	otherClient := idAddr(t, 201)
+
+	selectAll := func(s *fundwallets.Selector, client address.Address, n int) []address.Address {
+		var picked []address.Address
+		for i := 0; i < n; i++ {
+			wallet, ok := s.Select(client)
+			require.True(t, ok)
+			picked = append(picked, wallet)
+		}
+		return picked
+	}
+
+	t.Run("round robin takes wallets in turn", func(t *testing.T) {
+		s := fundwallets.New(wallets, fundwallets.RoundRobin)
+		require.Equal(t, append(wallets, wallets[0]), selectAll(s, client, 4))
+	})
+
+	t.Run("least pending takes the wallet with fewest messages in flight", func(t *testing.T) {
+		s := fundwallets.New(wallets, fundwallets.LeastPending)
+		cids := shared_testutil.GenerateCids(3)
+		s.Sent(cids[0], wallets[0])
+		s.Sent(cids[1], wallets[0])
+		s.Sent(cids[2], wallets[1])
+
+		wallet, ok := s.Select(client)
+		require.True(t, ok)
+		require.Equal(t, wallets[2], wallet)
+
+		// a deal's message is only counted once, however often it is recorded
+		s.Sent(cids[2], wallets[1])
+		s.Landed(cids[0])
+		s.Landed(cids[1])
+		require.Equal(t, map[address.Address]int{wallets[0]: 0, wallets[1]: 1, wallets[2]: 0}, s.Pending())
+
+		// wallets that tie are taken in turn
+		require.Equal(t, []address.Address{wallets[0], wallets[2]}, selectAll(s, client, 2))
+	})
+
+	t.Run("per client keeps dedicated wallets for their clients", func(t *testing.T) {
+		dedicated := idAddr(t, 104)
+		s := fundwallets.New(wallets, fundwallets.PerClient, fundwallets.Dedicate(client, dedicated), fundwallets.Dedicate(otherClient, wallets[0]))
+		require.Equal(t, []address.Address{dedicated, dedicated}, selectAll(s, client, 2))
+		require.Equal(t, []address.Address{wallets[0]}, selectAll(s, otherClient, 1))
+		require.Equal(t, []address.Address{wallets[1], wallets[2], wallets[1]}, selectAll(s, idAddr(t, 202), 3))
+	})
+
+	t.Run("no wallet left for clients without one", func(t *testing.T) {
+		s := fundwallets.New(nil, fundwallets.PerClient, fundwallets.Dedicate(client, wallets[0]))
+		_, ok := s.Select(otherClient)
+		require.False(t, ok)
+	})
+}
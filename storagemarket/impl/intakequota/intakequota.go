@@ -0,0 +1,177 @@
+/*
+Package intakequota limits how many deals a storage provider accepts over time, so
+that deal intake stays matched to the provider's sealing throughput.
+
+A Tracker keeps the deals it admitted over the last day. A deal is admitted if
+counting it keeps every quota, the number of deals in the last hour and the bytes
+in the last day, both overall and for the deal's client. Otherwise it is turned
+away with the time the oldest admissions in its way leave their window, which is
+when the deal will fit.
+
+Admissions are kept in memory, so a provider that restarts starts its quotas
+afresh.
+*/
+package intakequota
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+const (
+	hour = time.Hour
+	day  = 24 * time.Hour
+)
+
+type admission struct {
+	proposalCid cid.Cid
+	client      address.Address
+	size        uint64
+	at          time.Time
+}
+
+// Tracker counts the deals a provider admits against its intake quotas
+type Tracker struct {
+	lk        sync.Mutex
+	quotas    storagemarket.IntakeQuotas
+	admitted  []admission
+	proposals map[cid.Cid]struct{}
+	now       func() time.Time
+}
+
+// New returns a Tracker that admits deals within the given quotas
+func New(quotas storagemarket.IntakeQuotas) *Tracker {
+	return &Tracker{
+		quotas:    quotas,
+		proposals: make(map[cid.Cid]struct{}),
+		now:       time.Now,
+	}
+}
+
+// Quotas returns the quotas deals are admitted within
+func (t *Tracker) Quotas() storagemarket.IntakeQuotas {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return t.quotas
+}
+
+// SetQuotas changes the quotas later deals are admitted within. Deals already
+// admitted still count against the new quotas
+func (t *Tracker) SetQuotas(quotas storagemarket.IntakeQuotas) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.quotas = quotas
+}
+
+// Admit counts a deal against the quotas and returns true if it keeps them all.
+// Otherwise the deal is not counted, and Admit returns false with the quota it
+// would exceed and the time it will fit. The time is zero if the deal is too big to
+// ever fit. Admitting a deal that was already admitted returns true
+func (t *Tracker) Admit(proposalCid cid.Cid, client address.Address, size uint64) (bool, time.Time, string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if _, ok := t.proposals[proposalCid]; ok {
+		return true, time.Time{}, ""
+	}
+	now := t.now()
+	t.prune(now)
+
+	var retryAt time.Time
+	var reasons []string
+	exceeded := func(fits time.Time, reason string) {
+		reasons = append(reasons, reason)
+		if fits.After(retryAt) {
+			retryAt = fits
+		}
+	}
+	never := false
+	check := func(admitted []admission, maxDeals uint64, maxBytes uint64, scope string) {
+		if maxDeals > 0 {
+			if fits, ok := fitsDeals(admitted, now, maxDeals); !ok {
+				exceeded(fits, fmt.Sprintf("at most %d deals per hour%s", maxDeals, scope))
+			}
+		}
+		if maxBytes > 0 {
+			if size > maxBytes {
+				never = true
+				reasons = append(reasons, fmt.Sprintf("at most %d bytes per day%s", maxBytes, scope))
+			} else if fits, ok := fitsBytes(admitted, now, maxBytes, size); !ok {
+				exceeded(fits, fmt.Sprintf("at most %d bytes per day%s", maxBytes, scope))
+			}
+		}
+	}
+	check(t.admitted, t.quotas.MaxDealsPerHour, t.quotas.MaxBytesPerDay, "")
+	if t.quotas.MaxDealsPerClientPerHour > 0 || t.quotas.MaxBytesPerClientPerDay > 0 {
+		var fromClient []admission
+		for _, a := range t.admitted {
+			if a.client == client {
+				fromClient = append(fromClient, a)
+			}
+		}
+		check(fromClient, t.quotas.MaxDealsPerClientPerHour, t.quotas.MaxBytesPerClientPerDay, " from a client")
+	}
+
+	if len(reasons) > 0 {
+		if never {
+			retryAt = time.Time{}
+		}
+		return false, retryAt, strings.Join(reasons, ", ")
+	}
+	t.admitted = append(t.admitted, admission{proposalCid: proposalCid, client: client, size: size, at: now})
+	t.proposals[proposalCid] = struct{}{}
+	return true, time.Time{}, ""
+}
+
+// prune forgets admissions that have left every window
+func (t *Tracker) prune(now time.Time) {
+	i := 0
+	for i < len(t.admitted) && !t.admitted[i].at.After(now.Add(-day)) {
+		delete(t.proposals, t.admitted[i].proposalCid)
+		i++
+	}
+	t.admitted = t.admitted[i:]
+}
+
+// fitsDeals returns true if one more deal keeps the number of deals in the last
+// hour within max, or otherwise the time it will
+func fitsDeals(admitted []admission, now time.Time, max uint64) (time.Time, bool) {
+	var inWindow []admission
+	for _, a := range admitted {
+		if a.at.After(now.Add(-hour)) {
+			inWindow = append(inWindow, a)
+		}
+	}
+	if uint64(len(inWindow)) < max {
+		return time.Time{}, true
+	}
+	// the deal fits once all but max-1 of the deals in the window have left it
+	return inWindow[uint64(len(inWindow))-max].at.Add(hour), false
+}
+
+// fitsBytes returns true if a deal of the given size keeps the bytes in the last
+// day within max, or otherwise the time it will. size must not be over max
+func fitsBytes(admitted []admission, now time.Time, max uint64, size uint64) (time.Time, bool) {
+	var total uint64
+	for _, a := range admitted {
+		total += a.size
+	}
+	if total+size <= max {
+		return time.Time{}, true
+	}
+	for _, a := range admitted {
+		total -= a.size
+		if total+size <= max {
+			return a.at.Add(day), false
+		}
+	}
+	return now, false
+}
@@ -0,0 +1,95 @@
+package intakequota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+func TestTracker(t *testing.T) {
+	client, otherClient := address.TestAddress, address.TestAddress2
+	deals := shared_testutil.GenerateCids(6)
+
+	newTracker := func(quotas storagemarket.IntakeQuotas) (*Tracker, *time.Time) {
+		now := time.Now()
+		tr := New(quotas)
+		tr.now = func() time.Time { return now }
+		return tr, &now
+	}
+
+	t.Run("limits deals per hour", func(t *testing.T) {
+		tr, now := newTracker(storagemarket.IntakeQuotas{MaxDealsPerHour: 2})
+		start := *now
+		ok, _, _ := tr.Admit(deals[0], client, 1)
+		require.True(t, ok)
+		*now = now.Add(10 * time.Minute)
+		ok, _, _ = tr.Admit(deals[1], otherClient, 1)
+		require.True(t, ok)
+
+		ok, retryAt, reason := tr.Admit(deals[2], client, 1)
+		require.False(t, ok)
+		require.Equal(t, start.Add(time.Hour), retryAt)
+		require.Equal(t, "at most 2 deals per hour", reason)
+
+		// admitting again is idempotent
+		ok, _, _ = tr.Admit(deals[0], client, 1)
+		require.True(t, ok)
+
+		*now = retryAt
+		ok, _, _ = tr.Admit(deals[2], client, 1)
+		require.True(t, ok)
+	})
+
+	t.Run("limits bytes per day", func(t *testing.T) {
+		tr, now := newTracker(storagemarket.IntakeQuotas{MaxBytesPerDay: 100})
+		start := *now
+		ok, _, _ := tr.Admit(deals[0], client, 40)
+		require.True(t, ok)
+		*now = now.Add(time.Hour)
+		ok, _, _ = tr.Admit(deals[1], client, 40)
+		require.True(t, ok)
+
+		// the deal fits once both earlier deals leave the window
+		ok, retryAt, reason := tr.Admit(deals[2], client, 70)
+		require.False(t, ok)
+		require.Equal(t, start.Add(25*time.Hour), retryAt)
+		require.Equal(t, "at most 100 bytes per day", reason)
+
+		ok, _, _ = tr.Admit(deals[3], client, 20)
+		require.True(t, ok)
+
+		// a deal over the quota never fits
+		ok, retryAt, _ = tr.Admit(deals[4], client, 101)
+		require.False(t, ok)
+		require.True(t, retryAt.IsZero())
+	})
+
+	t.Run("limits each client", func(t *testing.T) {
+		tr, _ := newTracker(storagemarket.IntakeQuotas{MaxDealsPerClientPerHour: 1, MaxBytesPerClientPerDay: 100})
+		ok, _, _ := tr.Admit(deals[0], client, 10)
+		require.True(t, ok)
+		ok, _, reason := tr.Admit(deals[1], client, 10)
+		require.False(t, ok)
+		require.Equal(t, "at most 1 deals per hour from a client", reason)
+		ok, _, _ = tr.Admit(deals[2], otherClient, 10)
+		require.True(t, ok)
+	})
+
+	t.Run("applies changed quotas", func(t *testing.T) {
+		tr, _ := newTracker(storagemarket.IntakeQuotas{})
+		for _, deal := range deals[:3] {
+			ok, _, _ := tr.Admit(deal, client, 10)
+			require.True(t, ok)
+		}
+		tr.SetQuotas(storagemarket.IntakeQuotas{MaxDealsPerHour: 3})
+		require.Equal(t, storagemarket.IntakeQuotas{MaxDealsPerHour: 3}, tr.Quotas())
+		ok, _, _ := tr.Admit(deals[3], client, 10)
+		require.False(t, ok)
+	})
+}
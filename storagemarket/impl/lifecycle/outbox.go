@@ -0,0 +1,296 @@
+/*
+Package lifecycle delivers the lifecycle events of a storage client's deals to
+DealLifecycleHooks with at-least-once semantics.
+
+Events are recorded as deals enter the states that mark each stage, and appended to
+an outbox in the datastore before they are delivered, and removed only once their
+hook returns without error. A stage is recorded once per deal, so it can be recorded
+again each time the deal's state is handled, including after a restart. A hook that fails is retried
+until it succeeds, and events still in the outbox when the client shuts down are
+delivered when it starts again. Events are delivered one at a time, in the order
+they were recorded.
+*/
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("storagemarket_lifecycle")
+
+// DefaultRetryInterval is how long the outbox waits before delivering an event
+// again after its hook failed
+const DefaultRetryInterval = 10 * time.Second
+
+// Stage returns the lifecycle stage a client deal reaches when it enters the given
+// state, or false if the state does not mark a lifecycle stage
+func Stage(state storagemarket.StorageDealStatus) (storagemarket.DealLifecycleStage, bool) {
+	switch state {
+	case storagemarket.StorageDealStartDataTransfer:
+		return storagemarket.DealLifecycleProposed, true
+	case storagemarket.StorageDealProposalAccepted:
+		return storagemarket.DealLifecycleAccepted, true
+	case storagemarket.StorageDealTransferring:
+		return storagemarket.DealLifecycleTransferStarted, true
+	case storagemarket.StorageDealAwaitingPreCommit:
+		return storagemarket.DealLifecyclePublished, true
+	case storagemarket.StorageDealActive:
+		return storagemarket.DealLifecycleActive, true
+	case storagemarket.StorageDealFailing:
+		return storagemarket.DealLifecycleFailed, true
+	default:
+		return 0, false
+	}
+}
+
+// States lists the client deal states that mark a lifecycle stage
+var States = []storagemarket.StorageDealStatus{
+	storagemarket.StorageDealStartDataTransfer,
+	storagemarket.StorageDealProposalAccepted,
+	storagemarket.StorageDealTransferring,
+	storagemarket.StorageDealAwaitingPreCommit,
+	storagemarket.StorageDealActive,
+	storagemarket.StorageDealFailing,
+}
+
+// Option configures an Outbox
+type Option func(*Outbox)
+
+// RetryInterval sets how long the outbox waits before delivering an event again
+// after its hook failed
+func RetryInterval(interval time.Duration) Option {
+	return func(o *Outbox) {
+		o.retryInterval = interval
+	}
+}
+
+// Outbox persists deal lifecycle events and delivers them to DealLifecycleHooks
+type Outbox struct {
+	ds            datastore.Batching
+	hooks         storagemarket.DealLifecycleHooks
+	retryInterval time.Duration
+
+	lk      sync.Mutex
+	next    uint64
+	pending chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New returns an outbox that stores events in the given datastore. Events left in
+// the datastore by a previous outbox are delivered once the new outbox is started
+func New(ds datastore.Batching, hooks storagemarket.DealLifecycleHooks, options ...Option) (*Outbox, error) {
+	o := &Outbox{
+		ds:            ds,
+		hooks:         hooks,
+		retryInterval: DefaultRetryInterval,
+		pending:       make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(o)
+	}
+
+	events, err := o.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		o.next = events[len(events)-1].Sequence + 1
+	}
+	return o, nil
+}
+
+// Record adds an event to the outbox if the deal's state marks a lifecycle stage
+// that has not already been recorded for the deal
+func (o *Outbox) Record(deal storagemarket.ClientDeal) error {
+	stage, ok := Stage(deal.State)
+	if !ok {
+		return nil
+	}
+
+	o.lk.Lock()
+	recorded, err := o.ds.Has(recordedKey(deal, stage))
+	if err != nil || recorded {
+		o.lk.Unlock()
+		if err != nil {
+			return xerrors.Errorf("checking %s for deal %s: %w", stage, deal.ProposalCid, err)
+		}
+		return nil
+	}
+	evt := storagemarket.DealLifecycleEvent{
+		Sequence:       o.next,
+		Stage:          stage,
+		ProposalCid:    deal.ProposalCid,
+		Provider:       deal.Proposal.Provider,
+		PieceCID:       deal.Proposal.PieceCID,
+		DealID:         deal.DealID,
+		PublishMessage: deal.PublishMessage,
+		State:          deal.State,
+		Message:        deal.Message,
+	}
+	if deal.DataRef != nil {
+		evt.PayloadCID = deal.DataRef.Root
+	}
+	err = o.save(deal, evt)
+	if err == nil {
+		o.next++
+	}
+	o.lk.Unlock()
+	if err != nil {
+		return xerrors.Errorf("recording %s for deal %s: %w", stage, deal.ProposalCid, err)
+	}
+
+	select {
+	case o.pending <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start begins delivering events in the outbox
+func (o *Outbox) Start(ctx context.Context) {
+	ctx, o.cancel = context.WithCancel(ctx)
+	o.done = make(chan struct{})
+	go o.run(ctx)
+}
+
+// Stop ends delivery. Events that were not delivered stay in the outbox
+func (o *Outbox) Stop() {
+	if o.cancel == nil {
+		return
+	}
+	o.cancel()
+	<-o.done
+}
+
+func (o *Outbox) run(ctx context.Context) {
+	defer close(o.done)
+
+	for {
+		events, err := o.load()
+		if err != nil {
+			log.Errorf("loading deal lifecycle events: %s", err)
+		}
+		for _, evt := range events {
+			if !o.deliverUntilDone(ctx, evt) {
+				return
+			}
+			if err := o.ds.Delete(key(evt.Sequence)); err != nil {
+				log.Errorf("removing delivered deal lifecycle event %d: %s", evt.Sequence, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.pending:
+		}
+	}
+}
+
+// deliverUntilDone delivers an event, retrying until its hook succeeds. It returns
+// false if the outbox stopped first
+func (o *Outbox) deliverUntilDone(ctx context.Context, evt storagemarket.DealLifecycleEvent) bool {
+	for {
+		err := o.deliver(ctx, evt)
+		if err == nil {
+			return true
+		}
+		log.Warnf("delivering %s for deal %s failed, retrying in %s: %s", evt.Stage, evt.ProposalCid, o.retryInterval, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(o.retryInterval):
+		}
+	}
+}
+
+func (o *Outbox) deliver(ctx context.Context, evt storagemarket.DealLifecycleEvent) error {
+	switch evt.Stage {
+	case storagemarket.DealLifecycleProposed:
+		return o.hooks.OnProposed(ctx, evt)
+	case storagemarket.DealLifecycleAccepted:
+		return o.hooks.OnAccepted(ctx, evt)
+	case storagemarket.DealLifecycleTransferStarted:
+		return o.hooks.OnTransferStarted(ctx, evt)
+	case storagemarket.DealLifecyclePublished:
+		return o.hooks.OnPublished(ctx, evt)
+	case storagemarket.DealLifecycleActive:
+		return o.hooks.OnActive(ctx, evt)
+	case storagemarket.DealLifecycleFailed:
+		return o.hooks.OnFailed(ctx, evt)
+	default:
+		log.Errorf("dropping deal lifecycle event %d with unknown stage %d", evt.Sequence, evt.Stage)
+		return nil
+	}
+}
+
+// save writes the event together with a marker that the deal reached its stage, so
+// the stage is not recorded again
+func (o *Outbox) save(deal storagemarket.ClientDeal, evt storagemarket.DealLifecycleEvent) error {
+	b, err := cborutil.Dump(&evt)
+	if err != nil {
+		return err
+	}
+	batch, err := o.ds.Batch()
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(key(evt.Sequence), b); err != nil {
+		return err
+	}
+	if err := batch.Put(recordedKey(deal, evt.Stage), nil); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// load returns the events in the outbox in the order they were recorded
+func (o *Outbox) load() ([]storagemarket.DealLifecycleEvent, error) {
+	results, err := o.ds.Query(query.Query{Prefix: eventsPrefix})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]storagemarket.DealLifecycleEvent, 0, len(entries))
+	for _, entry := range entries {
+		var evt storagemarket.DealLifecycleEvent
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &evt); err != nil {
+			return nil, xerrors.Errorf("reading deal lifecycle event %s: %w", entry.Key, err)
+		}
+		events = append(events, evt)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Sequence < events[j].Sequence
+	})
+	return events, nil
+}
+
+const eventsPrefix = "/events"
+
+func key(sequence uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s/%d", eventsPrefix, sequence))
+}
+
+func recordedKey(deal storagemarket.ClientDeal, stage storagemarket.DealLifecycleStage) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("/recorded/%s/%d", deal.ProposalCid, stage))
+}
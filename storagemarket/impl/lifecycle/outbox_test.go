@@ -0,0 +1,205 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/lifecycle"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	deal := storagemarket.ClientDeal{
+		ProposalCid: shared_testutil.GenerateCids(1)[0],
+		DataRef:     &storagemarket.DataRef{Root: shared_testutil.GenerateCids(1)[0]},
+		DealID:      abi.DealID(42),
+	}
+	deal.Proposal.Client = address.TestAddress
+	deal.Proposal.Provider = address.TestAddress2
+	deal.Proposal.PieceCID = shared_testutil.GenerateCids(1)[0]
+	inState := func(state storagemarket.StorageDealStatus) storagemarket.ClientDeal {
+		d := deal
+		d.State = state
+		return d
+	}
+
+	t.Run("delivers lifecycle events in order", func(t *testing.T) {
+		hooks := newRecordingHooks()
+		outbox, err := lifecycle.New(dss.MutexWrap(datastore.NewMapDatastore()), hooks)
+		require.NoError(t, err)
+		outbox.Start(ctx)
+		defer outbox.Stop()
+
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealReserveClientFunds)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealStartDataTransfer)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealProposalAccepted)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealActive)))
+
+		delivered := hooks.waitFor(t, 3)
+		require.Equal(t, []storagemarket.DealLifecycleStage{
+			storagemarket.DealLifecycleProposed,
+			storagemarket.DealLifecycleAccepted,
+			storagemarket.DealLifecycleActive,
+		}, stages(delivered))
+		require.Equal(t, []uint64{0, 1, 2}, sequences(delivered))
+		require.Equal(t, deal.ProposalCid, delivered[0].ProposalCid)
+		require.Equal(t, deal.DataRef.Root, delivered[0].PayloadCID)
+		require.Equal(t, deal.DealID, delivered[2].DealID)
+	})
+
+	t.Run("retries failed hooks", func(t *testing.T) {
+		hooks := newRecordingHooks()
+		hooks.failures = 2
+		outbox, err := lifecycle.New(dss.MutexWrap(datastore.NewMapDatastore()), hooks, lifecycle.RetryInterval(time.Millisecond))
+		require.NoError(t, err)
+		outbox.Start(ctx)
+		defer outbox.Stop()
+
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealFailing)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealAwaitingPreCommit)))
+
+		delivered := hooks.waitFor(t, 2)
+		require.Equal(t, []storagemarket.DealLifecycleStage{
+			storagemarket.DealLifecycleFailed,
+			storagemarket.DealLifecyclePublished,
+		}, stages(delivered))
+	})
+
+	t.Run("delivers events recorded before a restart", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		outbox, err := lifecycle.New(ds, newRecordingHooks())
+		require.NoError(t, err)
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealStartDataTransfer)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealTransferring)))
+
+		hooks := newRecordingHooks()
+		restarted, err := lifecycle.New(ds, hooks)
+		require.NoError(t, err)
+		restarted.Start(ctx)
+		defer restarted.Stop()
+		require.NoError(t, restarted.Record(inState(storagemarket.StorageDealProposalAccepted)))
+
+		delivered := hooks.waitFor(t, 3)
+		require.Equal(t, []storagemarket.DealLifecycleStage{
+			storagemarket.DealLifecycleProposed,
+			storagemarket.DealLifecycleTransferStarted,
+			storagemarket.DealLifecycleAccepted,
+		}, stages(delivered))
+		require.Equal(t, []uint64{0, 1, 2}, sequences(delivered))
+	})
+
+	t.Run("records each stage of a deal once", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		outbox, err := lifecycle.New(ds, newRecordingHooks())
+		require.NoError(t, err)
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealStartDataTransfer)))
+		require.NoError(t, outbox.Record(inState(storagemarket.StorageDealStartDataTransfer)))
+
+		hooks := newRecordingHooks()
+		restarted, err := lifecycle.New(ds, hooks)
+		require.NoError(t, err)
+		restarted.Start(ctx)
+		defer restarted.Stop()
+		require.NoError(t, restarted.Record(inState(storagemarket.StorageDealStartDataTransfer)))
+		require.NoError(t, restarted.Record(inState(storagemarket.StorageDealTransferring)))
+
+		delivered := hooks.waitFor(t, 2)
+		require.Equal(t, []storagemarket.DealLifecycleStage{
+			storagemarket.DealLifecycleProposed,
+			storagemarket.DealLifecycleTransferStarted,
+		}, stages(delivered))
+		select {
+		case <-hooks.notify:
+			t.Fatal("expected each stage to be delivered once")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+type recordingHooks struct {
+	lk        sync.Mutex
+	failures  int
+	delivered []storagemarket.DealLifecycleEvent
+	notify    chan struct{}
+}
+
+func newRecordingHooks() *recordingHooks {
+	return &recordingHooks{notify: make(chan struct{}, 16)}
+}
+
+func (h *recordingHooks) record(event storagemarket.DealLifecycleEvent) error {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	if h.failures > 0 {
+		h.failures--
+		return errors.New("orchestrator unavailable")
+	}
+	h.delivered = append(h.delivered, event)
+	h.notify <- struct{}{}
+	return nil
+}
+
+func (h *recordingHooks) waitFor(t *testing.T, count int) []storagemarket.DealLifecycleEvent {
+	for i := 0; i < count; i++ {
+		select {
+		case <-h.notify:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d lifecycle events, got %d", count, i)
+		}
+	}
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	return append([]storagemarket.DealLifecycleEvent{}, h.delivered...)
+}
+
+func (h *recordingHooks) OnProposed(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func (h *recordingHooks) OnAccepted(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func (h *recordingHooks) OnTransferStarted(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func (h *recordingHooks) OnPublished(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func (h *recordingHooks) OnActive(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func (h *recordingHooks) OnFailed(ctx context.Context, event storagemarket.DealLifecycleEvent) error {
+	return h.record(event)
+}
+
+func stages(events []storagemarket.DealLifecycleEvent) []storagemarket.DealLifecycleStage {
+	out := make([]storagemarket.DealLifecycleStage, 0, len(events))
+	for _, evt := range events {
+		out = append(out, evt.Stage)
+	}
+	return out
+}
+
+func sequences(events []storagemarket.DealLifecycleEvent) []uint64 {
+	out := make([]uint64, 0, len(events))
+	for _, evt := range events {
+		out = append(out, evt.Sequence)
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+/*
+Package msgwait shares waits for the same chain message between deals.
+
+A node may publish several deals in one message, so several deals can wait for the
+same publish message at once. Rather than each deal subscribing to the node for the
+message, the first deal to wait starts one wait on the node, and deals that wait for
+the message while it is in progress join it. When the message lands, each deal's
+callback is called in turn, in the order the deals started waiting.
+*/
+package msgwait
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+)
+
+// CompletionFunc is called when a message lands on chain, or waiting for it fails
+type CompletionFunc func(exitcode.ExitCode, []byte, cid.Cid, error) error
+
+// WaitFunc waits for a message on chain, like a node's WaitForMessage
+type WaitFunc func(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error
+
+type messageWait struct {
+	callbacks []CompletionFunc
+}
+
+// Waiter shares waits for the same message
+type Waiter struct {
+	wait WaitFunc
+
+	lk    sync.Mutex
+	waits map[cid.Cid]*messageWait
+}
+
+// New returns a Waiter that waits for messages with wait
+func New(wait WaitFunc) *Waiter {
+	return &Waiter{
+		wait:  wait,
+		waits: make(map[cid.Cid]*messageWait),
+	}
+}
+
+// WaitForMessage calls onCompletion when the message lands on chain. If a wait for
+// the message is already in progress, onCompletion joins it and WaitForMessage
+// returns straight away. Otherwise a new wait is started with ctx, which all the
+// callbacks that join it share
+func (w *Waiter) WaitForMessage(ctx context.Context, mcid cid.Cid, onCompletion CompletionFunc) error {
+	w.lk.Lock()
+	if mw, ok := w.waits[mcid]; ok {
+		mw.callbacks = append(mw.callbacks, onCompletion)
+		w.lk.Unlock()
+		return nil
+	}
+	mw := &messageWait{callbacks: []CompletionFunc{onCompletion}}
+	w.waits[mcid] = mw
+	w.lk.Unlock()
+
+	err := w.wait(ctx, mcid, func(code exitcode.ExitCode, ret []byte, finalCid cid.Cid, err error) error {
+		var firstErr error
+		for _, callback := range w.finish(mcid, mw) {
+			if cbErr := callback(code, ret, finalCid, err); cbErr != nil && firstErr == nil {
+				firstErr = cbErr
+			}
+		}
+		return firstErr
+	})
+	if err != nil {
+		// the error is returned to the caller that started the wait, and passed to the
+		// callbacks of those that joined it
+		callbacks := w.finish(mcid, mw)
+		if len(callbacks) > 1 {
+			for _, callback := range callbacks[1:] {
+				_ = callback(exitcode.Ok, nil, cid.Undef, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Waiting returns how many messages are being waited for
+func (w *Waiter) Waiting() int {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return len(w.waits)
+}
+
+// finish ends a wait, so later callers start a new one, and returns its callbacks. It
+// returns nil if the wait has already ended
+func (w *Waiter) finish(mcid cid.Cid, mw *messageWait) []CompletionFunc {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	if w.waits[mcid] != mw {
+		return nil
+	}
+	delete(w.waits, mcid)
+	return mw.callbacks
+}
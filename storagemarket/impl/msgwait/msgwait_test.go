@@ -0,0 +1,111 @@
+package msgwait_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/msgwait"
+)
+
+// fakeNode holds the callback of each wait started on it, until the test completes it
+type fakeNode struct {
+	startErr error
+	waits    map[cid.Cid][]func(exitcode.ExitCode, []byte, cid.Cid, error) error
+	started  int
+}
+
+func (n *fakeNode) WaitForMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	n.started++
+	if n.startErr != nil {
+		return n.startErr
+	}
+	n.waits[mcid] = append(n.waits[mcid], onCompletion)
+	return nil
+}
+
+func (n *fakeNode) complete(mcid cid.Cid, ret []byte) error {
+	callbacks := n.waits[mcid]
+	delete(n.waits, mcid)
+	for _, cb := range callbacks {
+		if err := cb(exitcode.Ok, ret, mcid, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type recorder struct {
+	calls []string
+	rets  [][]byte
+	errs  []error
+}
+
+func (r *recorder) callback(name string, cbErr error) msgwait.CompletionFunc {
+	return func(code exitcode.ExitCode, ret []byte, finalCid cid.Cid, err error) error {
+		r.calls = append(r.calls, name)
+		r.rets = append(r.rets, ret)
+		r.errs = append(r.errs, err)
+		return cbErr
+	}
+}
+
+func TestWaiter(t *testing.T) {
+	ctx := context.Background()
+	cids := shared_testutil.GenerateCids(2)
+
+	t.Run("shares one wait between callers", func(t *testing.T) {
+		node := &fakeNode{waits: make(map[cid.Cid][]func(exitcode.ExitCode, []byte, cid.Cid, error) error)}
+		w := msgwait.New(node.WaitForMessage)
+		rec := &recorder{}
+		require.NoError(t, w.WaitForMessage(ctx, cids[0], rec.callback("a", nil)))
+		require.NoError(t, w.WaitForMessage(ctx, cids[0], rec.callback("b", nil)))
+		require.NoError(t, w.WaitForMessage(ctx, cids[1], rec.callback("c", nil)))
+		require.Equal(t, 2, node.started)
+		require.Equal(t, 2, w.Waiting())
+
+		require.NoError(t, node.complete(cids[0], []byte("ret")))
+		require.Equal(t, []string{"a", "b"}, rec.calls)
+		require.Equal(t, [][]byte{[]byte("ret"), []byte("ret")}, rec.rets)
+		require.Equal(t, 1, w.Waiting())
+
+		// a wait started after the message landed is a new one
+		require.NoError(t, w.WaitForMessage(ctx, cids[0], rec.callback("d", nil)))
+		require.Equal(t, 3, node.started)
+		require.NoError(t, node.complete(cids[0], []byte("ret")))
+		require.Equal(t, []string{"a", "b", "d"}, rec.calls)
+	})
+
+	t.Run("calls every callback when one fails", func(t *testing.T) {
+		node := &fakeNode{waits: make(map[cid.Cid][]func(exitcode.ExitCode, []byte, cid.Cid, error) error)}
+		w := msgwait.New(node.WaitForMessage)
+		rec := &recorder{}
+		cbErr := errors.New("callback failed")
+		require.NoError(t, w.WaitForMessage(ctx, cids[0], rec.callback("a", cbErr)))
+		require.NoError(t, w.WaitForMessage(ctx, cids[0], rec.callback("b", nil)))
+		require.Equal(t, cbErr, node.complete(cids[0], nil))
+		require.Equal(t, []string{"a", "b"}, rec.calls)
+	})
+
+	t.Run("passes an error starting the wait to callers that joined it", func(t *testing.T) {
+		startErr := errors.New("node unavailable")
+		rec := &recorder{}
+		var w *msgwait.Waiter
+		w = msgwait.New(func(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+			// another deal joins while the wait is being started
+			require.NoError(t, w.WaitForMessage(ctx, mcid, rec.callback("b", nil)))
+			return startErr
+		})
+		err := w.WaitForMessage(ctx, cids[0], rec.callback("a", nil))
+		require.Equal(t, startErr, err)
+		require.Equal(t, []string{"b"}, rec.calls)
+		require.Equal(t, []error{startErr}, rec.errs)
+		require.Equal(t, 0, w.Waiting())
+	})
+}
@@ -0,0 +1,350 @@
+/*
+Package noderetry retries the calls a storage provider's deal state handlers make to
+its node when they fail for a transient reason, such as the node restarting, so that
+a brief outage does not fail every deal that was in flight.
+
+Each error returned by the node is classified as transient or permanent. Nodes can
+mark their errors with Transient or Permanent. Errors that are not marked are
+transient if they come from the network connection to the node, such as a refused
+or reset connection, or a stream that ended early, and permanent otherwise.
+
+Only calls that are safe to make again are retried: reads of chain state, signing,
+and registering for chain events whose callback has not yet run. Calls that send
+messages or hand data to the node, such as PublishDeals, ReserveFunds and
+OnDealComplete, may have taken effect before they failed, so their errors are
+returned straight away.
+*/
+package noderetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/jpillora/backoff"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/builtin/verifreg"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("noderetry")
+
+// ErrorClass says whether a failed node call is worth retrying
+type ErrorClass uint64
+
+const (
+	// ErrorPermanent means the call will fail again if it is retried
+	ErrorPermanent ErrorClass = iota
+	// ErrorTransient means the call may succeed if it is retried
+	ErrorTransient
+)
+
+type classifiedError struct {
+	err   error
+	class ErrorClass
+}
+
+func (ce *classifiedError) Error() string { return ce.err.Error() }
+func (ce *classifiedError) Unwrap() error { return ce.err }
+
+// Transient marks an error returned by a node as transient
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, class: ErrorTransient}
+}
+
+// Permanent marks an error returned by a node as permanent, so that it is not
+// retried even if it came from the network connection to the node
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, class: ErrorPermanent}
+}
+
+// Classify returns the class of an error returned by a node: the class it was
+// marked with, transient for errors from the network connection to the node, and
+// permanent for any other error
+func Classify(err error) ErrorClass {
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.class
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorTransient
+	}
+	for _, transient := range []error{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE, io.ErrUnexpectedEOF, io.EOF} {
+		if errors.Is(err, transient) {
+			return ErrorTransient
+		}
+	}
+	return ErrorPermanent
+}
+
+// Policy configures how failed node calls are retried
+type Policy struct {
+	// Attempts is the most times a call is made, including the first. One or less
+	// turns retries off
+	Attempts int
+	// MinBackoff and MaxBackoff bound the wait between attempts, which doubles from
+	// MinBackoff after each attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Classify decides which errors are retried, or nil to use Classify
+	Classify func(error) ErrorClass
+}
+
+// DefaultPolicy retries for about a minute, long enough for a node to restart
+var DefaultPolicy = Policy{
+	Attempts:   8,
+	MinBackoff: time.Second,
+	MaxBackoff: 15 * time.Second,
+}
+
+// Wrap returns a node that retries calls to node that fail with a transient error,
+// following the given policy. If node can add deals to existing pieces, so can the
+// returned node
+func Wrap(node storagemarket.StorageProviderNode, policy Policy) storagemarket.StorageProviderNode {
+	if policy.Classify == nil {
+		policy.Classify = Classify
+	}
+	rn := &retryingNode{StorageProviderNode: node, policy: policy}
+	if epn, ok := node.(storagemarket.ExistingPieceNode); ok {
+		return &retryingExistingPieceNode{retryingNode: rn, existing: epn}
+	}
+	return rn
+}
+
+type retryingNode struct {
+	storagemarket.StorageProviderNode
+	policy Policy
+}
+
+// retry calls f until it succeeds, fails with an error that is not transient, or
+// the attempts run out
+func (rn *retryingNode) retry(ctx context.Context, method string, f func() error) error {
+	b := &backoff.Backoff{
+		Min:    rn.policy.MinBackoff,
+		Max:    rn.policy.MaxBackoff,
+		Factor: 2,
+		Jitter: true,
+	}
+	for {
+		err := f()
+		if err == nil || rn.policy.Classify(err) != ErrorTransient {
+			return err
+		}
+		attempt := int(b.Attempt()) + 1
+		if attempt >= rn.policy.Attempts {
+			if attempt > 1 {
+				return xerrors.Errorf("%s failed after %d attempts: %w", method, attempt, err)
+			}
+			return err
+		}
+		wait := b.Duration()
+		log.Warnf("%s failed with a transient error, retrying in %s (attempt %d of %d): %s", method, wait, attempt, rn.policy.Attempts, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// once tracks whether a callback given to the node has run, after which the call
+// that registered it cannot be retried
+type once struct {
+	lk  sync.Mutex
+	ran bool
+}
+
+func (o *once) run() {
+	o.lk.Lock()
+	o.ran = true
+	o.lk.Unlock()
+}
+
+// retryable wraps f so that its error is only treated as transient while the
+// callback has not run
+func (o *once) retryable(f func() error) func() error {
+	return func() error {
+		err := f()
+		o.lk.Lock()
+		defer o.lk.Unlock()
+		if err != nil && o.ran {
+			return Permanent(err)
+		}
+		return err
+	}
+}
+
+func (rn *retryingNode) GetChainHead(ctx context.Context) (shared.TipSetToken, abi.ChainEpoch, error) {
+	var tok shared.TipSetToken
+	var epoch abi.ChainEpoch
+	err := rn.retry(ctx, "GetChainHead", func() (err error) {
+		tok, epoch, err = rn.StorageProviderNode.GetChainHead(ctx)
+		return err
+	})
+	return tok, epoch, err
+}
+
+func (rn *retryingNode) GetBalance(ctx context.Context, addr address.Address, tok shared.TipSetToken) (storagemarket.Balance, error) {
+	var balance storagemarket.Balance
+	err := rn.retry(ctx, "GetBalance", func() (err error) {
+		balance, err = rn.StorageProviderNode.GetBalance(ctx, addr, tok)
+		return err
+	})
+	return balance, err
+}
+
+func (rn *retryingNode) VerifySignature(ctx context.Context, signature crypto.Signature, signer address.Address, plaintext []byte, tok shared.TipSetToken) (bool, error) {
+	var valid bool
+	err := rn.retry(ctx, "VerifySignature", func() (err error) {
+		valid, err = rn.StorageProviderNode.VerifySignature(ctx, signature, signer, plaintext, tok)
+		return err
+	})
+	return valid, err
+}
+
+func (rn *retryingNode) SignBytes(ctx context.Context, signer address.Address, b []byte) (*crypto.Signature, error) {
+	var sig *crypto.Signature
+	err := rn.retry(ctx, "SignBytes", func() (err error) {
+		sig, err = rn.StorageProviderNode.SignBytes(ctx, signer, b)
+		return err
+	})
+	return sig, err
+}
+
+func (rn *retryingNode) DealProviderCollateralBounds(ctx context.Context, size abi.PaddedPieceSize, isVerified bool) (abi.TokenAmount, abi.TokenAmount, error) {
+	var min, max abi.TokenAmount
+	err := rn.retry(ctx, "DealProviderCollateralBounds", func() (err error) {
+		min, max, err = rn.StorageProviderNode.DealProviderCollateralBounds(ctx, size, isVerified)
+		return err
+	})
+	return min, max, err
+}
+
+func (rn *retryingNode) GetMinerWorkerAddress(ctx context.Context, addr address.Address, tok shared.TipSetToken) (address.Address, error) {
+	var worker address.Address
+	err := rn.retry(ctx, "GetMinerWorkerAddress", func() (err error) {
+		worker, err = rn.StorageProviderNode.GetMinerWorkerAddress(ctx, addr, tok)
+		return err
+	})
+	return worker, err
+}
+
+func (rn *retryingNode) LocatePieceForDealWithinSector(ctx context.Context, dealID abi.DealID, tok shared.TipSetToken) (abi.SectorNumber, abi.PaddedPieceSize, abi.PaddedPieceSize, error) {
+	var sectorID abi.SectorNumber
+	var offset, length abi.PaddedPieceSize
+	err := rn.retry(ctx, "LocatePieceForDealWithinSector", func() (err error) {
+		sectorID, offset, length, err = rn.StorageProviderNode.LocatePieceForDealWithinSector(ctx, dealID, tok)
+		return err
+	})
+	return sectorID, offset, length, err
+}
+
+func (rn *retryingNode) GetDataCap(ctx context.Context, addr address.Address, tok shared.TipSetToken) (*verifreg.DataCap, error) {
+	var dataCap *verifreg.DataCap
+	err := rn.retry(ctx, "GetDataCap", func() (err error) {
+		dataCap, err = rn.StorageProviderNode.GetDataCap(ctx, addr, tok)
+		return err
+	})
+	return dataCap, err
+}
+
+func (rn *retryingNode) GetProofType(ctx context.Context, addr address.Address, tok shared.TipSetToken) (abi.RegisteredSealProof, error) {
+	var proofType abi.RegisteredSealProof
+	err := rn.retry(ctx, "GetProofType", func() (err error) {
+		proofType, err = rn.StorageProviderNode.GetProofType(ctx, addr, tok)
+		return err
+	})
+	return proofType, err
+}
+
+// WaitForMessage is retried until the message is found. Once onCompletion has run,
+// its error is returned without retrying
+func (rn *retryingNode) WaitForMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	var o once
+	return rn.retry(ctx, "WaitForMessage", o.retryable(func() error {
+		return rn.StorageProviderNode.WaitForMessage(ctx, mcid, func(code exitcode.ExitCode, ret []byte, finalCid cid.Cid, err error) error {
+			o.run()
+			return onCompletion(code, ret, finalCid, err)
+		})
+	}))
+}
+
+// OnDealSectorPreCommitted is retried if registering for the event fails
+func (rn *retryingNode) OnDealSectorPreCommitted(ctx context.Context, provider address.Address, dealID abi.DealID, proposal market.DealProposal, publishCid *cid.Cid, cb storagemarket.DealSectorPreCommittedCallback) error {
+	var o once
+	return rn.retry(ctx, "OnDealSectorPreCommitted", o.retryable(func() error {
+		return rn.StorageProviderNode.OnDealSectorPreCommitted(ctx, provider, dealID, proposal, publishCid, func(sectorNumber abi.SectorNumber, isActive bool, err error) {
+			o.run()
+			cb(sectorNumber, isActive, err)
+		})
+	}))
+}
+
+// OnDealSectorCommitted is retried if registering for the event fails
+func (rn *retryingNode) OnDealSectorCommitted(ctx context.Context, provider address.Address, dealID abi.DealID, sectorNumber abi.SectorNumber, proposal market.DealProposal, publishCid *cid.Cid, cb storagemarket.DealSectorCommittedCallback) error {
+	var o once
+	return rn.retry(ctx, "OnDealSectorCommitted", o.retryable(func() error {
+		return rn.StorageProviderNode.OnDealSectorCommitted(ctx, provider, dealID, sectorNumber, proposal, publishCid, func(err error) {
+			o.run()
+			cb(err)
+		})
+	}))
+}
+
+// OnDealExpiredOrSlashed is retried if registering for the events fails
+func (rn *retryingNode) OnDealExpiredOrSlashed(ctx context.Context, dealID abi.DealID, onDealExpired storagemarket.DealExpiredCallback, onDealSlashed storagemarket.DealSlashedCallback) error {
+	var o once
+	return rn.retry(ctx, "OnDealExpiredOrSlashed", o.retryable(func() error {
+		return rn.StorageProviderNode.OnDealExpiredOrSlashed(ctx, dealID, func(err error) {
+			o.run()
+			onDealExpired(err)
+		}, func(slashEpoch abi.ChainEpoch, err error) {
+			o.run()
+			onDealSlashed(slashEpoch, err)
+		})
+	}))
+}
+
+type retryingExistingPieceNode struct {
+	*retryingNode
+	existing storagemarket.ExistingPieceNode
+}
+
+// OnDealCompleteWithExistingPiece is not retried, as the deal may have been added to
+// the sector before the call failed
+func (rn *retryingExistingPieceNode) OnDealCompleteWithExistingPiece(ctx context.Context, deal storagemarket.MinerDeal, sectorNumber abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (*storagemarket.PackingResult, error) {
+	return rn.existing.OnDealCompleteWithExistingPiece(ctx, deal, sectorNumber, offset, length)
+}
+
+// GetPublishedProposals is retried, as it only reads chain state
+func (rn *retryingNode) GetPublishedProposals(ctx context.Context, publishCid cid.Cid) ([]market.ClientDealProposal, error) {
+	var proposals []market.ClientDealProposal
+	err := rn.retry(ctx, "GetPublishedProposals", func() (err error) {
+		proposals, err = rn.StorageProviderNode.GetPublishedProposals(ctx, publishCid)
+		return err
+	})
+	return proposals, err
+}
@@ -0,0 +1,160 @@
+package noderetry_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/noderetry"
+)
+
+func TestClassify(t *testing.T) {
+	connRefused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	testCases := map[string]struct {
+		err   error
+		class noderetry.ErrorClass
+	}{
+		"unmarked error":         {errors.New("actor not found"), noderetry.ErrorPermanent},
+		"marked transient":       {noderetry.Transient(errors.New("busy")), noderetry.ErrorTransient},
+		"wrapped transient mark": {xerrors.Errorf("calling node: %w", noderetry.Transient(errors.New("busy"))), noderetry.ErrorTransient},
+		"network error":          {xerrors.Errorf("sendRequest failed: %w", connRefused), noderetry.ErrorTransient},
+		"connection reset":       {xerrors.Errorf("reading response: %w", syscall.ECONNRESET), noderetry.ErrorTransient},
+		"marked permanent":       {noderetry.Permanent(connRefused), noderetry.ErrorPermanent},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.class, noderetry.Classify(tc.err))
+		})
+	}
+}
+
+type fakeNode struct {
+	storagemarket.StorageProviderNode
+	errs     []error
+	calls    int
+	runFirst bool
+}
+
+func (fn *fakeNode) nextErr() error {
+	fn.calls++
+	if len(fn.errs) == 0 {
+		return nil
+	}
+	err := fn.errs[0]
+	fn.errs = fn.errs[1:]
+	return err
+}
+
+func (fn *fakeNode) GetChainHead(ctx context.Context) (shared.TipSetToken, abi.ChainEpoch, error) {
+	if err := fn.nextErr(); err != nil {
+		return nil, 0, err
+	}
+	return shared.TipSetToken{1}, 10, nil
+}
+
+func (fn *fakeNode) PublishDeals(ctx context.Context, deal storagemarket.MinerDeal) (cid.Cid, error) {
+	return cid.Undef, fn.nextErr()
+}
+
+func (fn *fakeNode) GetPublishedProposals(context.Context, cid.Cid) ([]market.ClientDealProposal, error) {
+	if err := fn.nextErr(); err != nil {
+		return nil, err
+	}
+	return make([]market.ClientDealProposal, 2), nil
+}
+
+func (fn *fakeNode) WaitForMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	if fn.runFirst {
+		_ = onCompletion(exitcode.Ok, nil, mcid, nil)
+	}
+	return fn.nextErr()
+}
+
+type fakeExistingPieceNode struct {
+	fakeNode
+}
+
+func (fn *fakeExistingPieceNode) OnDealCompleteWithExistingPiece(context.Context, storagemarket.MinerDeal, abi.SectorNumber, abi.PaddedPieceSize, abi.PaddedPieceSize) (*storagemarket.PackingResult, error) {
+	return &storagemarket.PackingResult{SectorNumber: 7}, nil
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	policy := noderetry.Policy{Attempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	transient := noderetry.Transient(errors.New("node restarting"))
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		fn := &fakeNode{errs: []error{transient, transient}}
+		tok, epoch, err := noderetry.Wrap(fn, policy).GetChainHead(ctx)
+		require.NoError(t, err)
+		require.Equal(t, shared.TipSetToken{1}, tok)
+		require.Equal(t, abi.ChainEpoch(10), epoch)
+		require.Equal(t, 3, fn.calls)
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		fn := &fakeNode{errs: []error{transient, transient, transient}}
+		_, _, err := noderetry.Wrap(fn, policy).GetChainHead(ctx)
+		require.EqualError(t, err, "GetChainHead failed after 3 attempts: node restarting")
+		require.True(t, errors.Is(err, transient))
+		require.Equal(t, 3, fn.calls)
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		fn := &fakeNode{errs: []error{errors.New("bad address")}}
+		_, _, err := noderetry.Wrap(fn, policy).GetChainHead(ctx)
+		require.EqualError(t, err, "bad address")
+		require.Equal(t, 1, fn.calls)
+	})
+
+	t.Run("does not retry calls that may have taken effect", func(t *testing.T) {
+		fn := &fakeNode{errs: []error{transient}}
+		_, err := noderetry.Wrap(fn, policy).PublishDeals(ctx, storagemarket.MinerDeal{})
+		require.Equal(t, transient, err)
+		require.Equal(t, 1, fn.calls)
+	})
+
+	t.Run("retries waits whose callback has not run", func(t *testing.T) {
+		fn := &fakeNode{errs: []error{transient}}
+		err := noderetry.Wrap(fn, policy).WaitForMessage(ctx, cid.Undef, func(exitcode.ExitCode, []byte, cid.Cid, error) error { return nil })
+		require.NoError(t, err)
+		require.Equal(t, 2, fn.calls)
+
+		fn = &fakeNode{errs: []error{transient}, runFirst: true}
+		err = noderetry.Wrap(fn, policy).WaitForMessage(ctx, cid.Undef, func(exitcode.ExitCode, []byte, cid.Cid, error) error { return nil })
+		require.EqualError(t, err, "node restarting")
+		require.Equal(t, 1, fn.calls)
+	})
+
+	t.Run("keeps existing piece support", func(t *testing.T) {
+		_, ok := noderetry.Wrap(&fakeNode{}, policy).(storagemarket.ExistingPieceNode)
+		require.False(t, ok)
+
+		wrapped, ok := noderetry.Wrap(&fakeExistingPieceNode{}, policy).(storagemarket.ExistingPieceNode)
+		require.True(t, ok)
+		res, err := wrapped.OnDealCompleteWithExistingPiece(ctx, storagemarket.MinerDeal{}, 0, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, abi.SectorNumber(7), res.SectorNumber)
+	})
+
+	t.Run("retries published proposal lookups", func(t *testing.T) {
+		fn := &fakeExistingPieceNode{fakeNode{errs: []error{transient}}}
+		proposals, err := noderetry.Wrap(fn, policy).GetPublishedProposals(ctx, cid.Undef)
+		require.NoError(t, err)
+		require.Len(t, proposals, 2)
+		require.Equal(t, 2, fn.calls)
+	})
+}
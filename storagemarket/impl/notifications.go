@@ -0,0 +1,139 @@
+package storageimpl
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/clientstates"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+// notifyTimeout is how long a provider has to deliver a deal notification to a client
+const notifyTimeout = 30 * time.Second
+
+// NotifyClients makes the provider push a signed notification to a deal's client
+// each time the deal is accepted, published, activated or fails, so the client does
+// not have to poll for the deal's state. Clients that do not speak the deal
+// notification protocol keep polling
+func NotifyClients() StorageProviderOption {
+	return func(p *Provider) {
+		p.notifyClients = true
+	}
+}
+
+// notifiesClient returns true if a deal that just had the given event should be
+// reported to its client
+func notifiesClient(evt storagemarket.ProviderEvent) bool {
+	switch evt {
+	case storagemarket.ProviderEventDataRequested,
+		storagemarket.ProviderEventTransferQueued,
+		storagemarket.ProviderEventExistingPieceFound,
+		storagemarket.ProviderEventDealPublished,
+		storagemarket.ProviderEventFinalized,
+		storagemarket.ProviderEventFailed:
+		return true
+	}
+	return false
+}
+
+// notifyClient pushes the state of a deal to its client. Notifying is best effort:
+// a client that misses a notification finds the deal's state by polling
+func (p *Provider) notifyClient(deal storagemarket.MinerDeal) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	notification := network.DealNotification{
+		Proposal:   deal.ProposalCid,
+		State:      deal.State,
+		Message:    deal.Message,
+		DealID:     deal.DealID,
+		PublishCid: deal.PublishCid,
+	}
+	signature, err := p.sign(ctx, &notification)
+	if err != nil {
+		log.Errorf("signing notification for deal %s: %s", deal.ProposalCid, err)
+		return
+	}
+
+	s, err := p.net.NewDealNotificationStream(ctx, deal.Client)
+	if err != nil {
+		log.Debugf("opening notification stream for deal %s: %s", deal.ProposalCid, err)
+		return
+	}
+	defer s.Close() // nolint: errcheck
+
+	if err := s.WriteDealNotification(network.SignedDealNotification{Notification: notification, Signature: signature}); err != nil {
+		log.Warnf("sending notification for deal %s: %s", deal.ProposalCid, err)
+	}
+}
+
+/*
+HandleDealNotificationStream is called by the network implementation whenever a
+provider pushes the state of a deal to the client.
+
+The notification is dropped unless it comes from the deal's provider and is signed
+by the provider's worker. A notification that moves the deal on, such as the
+provider accepting or failing the deal, is sent to the deal's state machine in
+place of the state the client would otherwise find by polling
+*/
+func (c *Client) HandleDealNotificationStream(s network.DealNotificationStream) {
+	ctx := context.TODO()
+	defer s.Close()
+	signed, err := s.ReadDealNotification()
+	if err != nil {
+		log.Errorf("failed to read DealNotification from incoming stream: %s", err)
+		return
+	}
+	notification := signed.Notification
+
+	var deal storagemarket.ClientDeal
+	if err := c.statemachines.Get(notification.Proposal).Get(&deal); err != nil {
+		log.Warnf("notification for unknown deal %s: %s", notification.Proposal, err)
+		return
+	}
+	if deal.Miner != s.RemotePeer() {
+		log.Errorf("notification for deal %s from peer %s, which is not the deal provider", deal.ProposalCid, s.RemotePeer())
+		return
+	}
+	if err := c.verifyNotification(ctx, deal, signed); err != nil {
+		log.Errorf("notification for deal %s: %s", deal.ProposalCid, err)
+		return
+	}
+
+	evt, args, ok := clientstates.ProviderNotificationEvent(deal, notification.State, notification.Message, notification.PublishCid)
+	if !ok {
+		log.Debugf("provider reports deal %s is %s", deal.ProposalCid, storagemarket.DealStates[notification.State])
+		return
+	}
+	if err := c.statemachines.Send(deal.ProposalCid, evt, args...); err != nil {
+		log.Errorf("applying notification for deal %s: %s", deal.ProposalCid, err)
+	}
+}
+
+// verifyNotification checks a deal notification was signed by the deal provider's worker
+func (c *Client) verifyNotification(ctx context.Context, deal storagemarket.ClientDeal, signed network.SignedDealNotification) error {
+	if signed.Signature == nil {
+		return xerrors.New("notification is not signed")
+	}
+	buf, err := cborutil.Dump(&signed.Notification)
+	if err != nil {
+		return xerrors.Errorf("serializing notification: %w", err)
+	}
+	tok, _, err := c.node.GetChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+	valid, err := c.node.VerifySignature(ctx, *signed.Signature, deal.MinerWorker, buf, tok)
+	if err != nil {
+		return xerrors.Errorf("validating signature: %w", err)
+	}
+	if !valid {
+		return xerrors.New("invalid notification signature")
+	}
+	return nil
+}
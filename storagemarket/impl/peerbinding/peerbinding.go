@@ -0,0 +1,138 @@
+/*
+Package peerbinding binds storage client addresses to the libp2p peers that propose
+deals for them, so that a provider can tell a client's own proposals from copies of
+them sent by another peer.
+
+A deal proposal is signed by its client address, but nothing in it ties it to the
+peer that sends it. A peer that gets hold of someone else's signed proposal can send
+it first, and have the provider pull the deal's data from it instead. A Binder
+records which peer each client address proposes deals from, and rejects proposals
+from any other peer.
+
+A client proves a peer acts for its address by signing the peer's SigningBytes with
+the address, and sending the signature with its proposals. A proposal that carries a
+valid signature binds the address to the peer sending it, replacing any earlier
+binding. Only a signature binds an address, as a peer relaying someone else's
+proposal could otherwise bind the address to itself and lock the client out. Proposals
+without a signature are rejected if the Binder requires signatures, and otherwise are
+accepted from the peer the address is bound to, or from any peer while it is not
+bound.
+*/
+package peerbinding
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+)
+
+// signingPrefix keeps the bytes a client signs to bind a peer from being mistaken
+// for any other message
+const signingPrefix = "fil-storage-client-peer:"
+
+// SigningBytes returns the bytes a client address signs to bind the given peer to it
+func SigningBytes(p peer.ID) []byte {
+	return append([]byte(signingPrefix), []byte(p)...)
+}
+
+// Mode is how strictly a Binder authenticates the peers proposing deals
+type Mode uint64
+
+const (
+	// TrustOnFirstUse accepts proposals without a signature for client addresses no
+	// signature has bound yet. Once a signature binds an address, proposals for it
+	// from other peers must carry a signature
+	TrustOnFirstUse Mode = iota
+
+	// RequireSignature rejects proposals that do not carry a signature
+	RequireSignature
+)
+
+// VerifyFunc checks that sig is a signature by the client address over data
+type VerifyFunc func(sig crypto.Signature, data []byte) (bool, error)
+
+// Binder records the peer each client address is bound to
+type Binder struct {
+	ds   datastore.Batching
+	mode Mode
+
+	lk sync.Mutex
+}
+
+// New returns a Binder that keeps its bindings in ds
+func New(ds datastore.Batching, mode Mode) *Binder {
+	return &Binder{ds: ds, mode: mode}
+}
+
+// Authenticate checks that the peer p may propose deals for the client address,
+// and records the binding. sig is the signature the peer sent with its proposal,
+// or nil if it sent none
+func (b *Binder) Authenticate(client address.Address, p peer.ID, sig *crypto.Signature, verify VerifyFunc) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if sig != nil {
+		ok, err := verify(*sig, SigningBytes(p))
+		if err != nil {
+			return xerrors.Errorf("verifying peer signature: %w", err)
+		}
+		if !ok {
+			return xerrors.Errorf("peer signature is not from client address %s", client)
+		}
+		return b.bind(client, p)
+	}
+
+	if b.mode == RequireSignature {
+		return xerrors.New("proposal has no peer signature")
+	}
+	bound, ok, err := b.get(client)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if bound != p {
+		return xerrors.Errorf("client address %s is bound to peer %s, not %s", client, bound, p)
+	}
+	return nil
+}
+
+// Bound returns the peer the client address is bound to, or false if it is not
+// bound to any peer
+func (b *Binder) Bound(client address.Address) (peer.ID, bool, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.get(client)
+}
+
+// Unbind removes the binding of the client address, so that the next peer to
+// propose a deal for it is bound to it
+func (b *Binder) Unbind(client address.Address) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.ds.Delete(datastore.NewKey(client.String()))
+}
+
+func (b *Binder) get(client address.Address) (peer.ID, bool, error) {
+	data, err := b.ds.Get(datastore.NewKey(client.String()))
+	if err == datastore.ErrNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, xerrors.Errorf("reading peer binding of %s: %w", client, err)
+	}
+	return peer.ID(data), true, nil
+}
+
+func (b *Binder) bind(client address.Address, p peer.ID) error {
+	if err := b.ds.Put(datastore.NewKey(client.String()), []byte(p)); err != nil {
+		return xerrors.Errorf("recording peer binding of %s: %w", client, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package peerbinding_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/peerbinding"
+)
+
+func TestAuthenticate(t *testing.T) {
+	client := address.TestAddress
+	peers := shared_testutil.GeneratePeers(2)
+	owner, other := peers[0], peers[1]
+
+	// signatures are valid if they sign the given peer's binding bytes
+	signFor := func(signed []byte) *crypto.Signature {
+		return &crypto.Signature{Type: crypto.SigTypeBLS, Data: signed}
+	}
+	verify := func(sig crypto.Signature, data []byte) (bool, error) {
+		return bytes.Equal(sig.Data, data), nil
+	}
+
+	t.Run("trust on first use", func(t *testing.T) {
+		binder := peerbinding.New(dss.MutexWrap(datastore.NewMapDatastore()), peerbinding.TrustOnFirstUse)
+
+		// proposals without a signature are accepted but do not bind the address
+		require.NoError(t, binder.Authenticate(client, other, nil, verify))
+		_, ok, err := binder.Bound(client)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, binder.Authenticate(client, owner, signFor(peerbinding.SigningBytes(owner)), verify))
+		bound, ok, err := binder.Bound(client)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, owner, bound)
+
+		require.NoError(t, binder.Authenticate(client, owner, nil, verify))
+		err = binder.Authenticate(client, other, nil, verify)
+		require.EqualError(t, err, "client address "+client.String()+" is bound to peer "+owner.String()+", not "+other.String())
+
+		// a signature for the wrong peer does not rebind the address
+		err = binder.Authenticate(client, other, signFor(peerbinding.SigningBytes(owner)), verify)
+		require.EqualError(t, err, "peer signature is not from client address "+client.String())
+
+		require.NoError(t, binder.Authenticate(client, other, signFor(peerbinding.SigningBytes(other)), verify))
+		bound, _, err = binder.Bound(client)
+		require.NoError(t, err)
+		require.Equal(t, other, bound)
+
+		require.NoError(t, binder.Unbind(client))
+		_, ok, err = binder.Bound(client)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("require signature", func(t *testing.T) {
+		binder := peerbinding.New(dss.MutexWrap(datastore.NewMapDatastore()), peerbinding.RequireSignature)
+
+		err := binder.Authenticate(client, owner, nil, verify)
+		require.EqualError(t, err, "proposal has no peer signature")
+		require.NoError(t, binder.Authenticate(client, owner, signFor(peerbinding.SigningBytes(owner)), verify))
+
+		verifyErr := errors.New("no key for address")
+		err = binder.Authenticate(client, owner, signFor(peerbinding.SigningBytes(owner)), func(crypto.Signature, []byte) (bool, error) {
+			return false, verifyErr
+		})
+		require.True(t, errors.Is(err, verifyErr))
+	})
+}
@@ -0,0 +1,16 @@
+package storageimpl
+
+import (
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// CheckExistingPieces has a provider spot check that a sector still holds a piece
+// with the given checker, before it accepts a deal that reuses its sealed copy of the
+// piece rather than receiving the data. Copies of the piece that fail the check are
+// passed over, and a deal with an existing piece transfer is rejected if none pass.
+// Without a checker, a provider trusts its piece store
+func CheckExistingPieces(checker storagemarket.PieceHoldChecker) StorageProviderOption {
+	return func(p *Provider) {
+		p.pieceHoldChecker = checker
+	}
+}
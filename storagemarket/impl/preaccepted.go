@@ -0,0 +1,84 @@
+package storageimpl
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerutils"
+)
+
+/*
+AddPreAcceptedDeal starts a deal the provider agreed to outside of the deal protocol,
+such as through a marketplace's HTTP API, and returns the deal's proposal CID.
+
+The deal skips validation and the provider's decision logic, which the marketplace is
+trusted to have done, and goes straight to waiting for its data. Only the client's
+signature on the proposal is checked, as the deal cannot be published without it. The
+data of a deal with a manual transfer is imported with ImportDataForDeal. For other
+transfer types, the client pushes the data over data transfer from the given peer.
+Deals for pieces the provider already has are not supported.
+*/
+func (p *Provider) AddPreAcceptedDeal(ctx context.Context, proposal market.ClientDealProposal, ref *storagemarket.DataRef, client peer.ID) (cid.Cid, error) {
+	if p.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
+	if ref == nil {
+		return cid.Undef, xerrors.New("pre-accepted deal has no data reference")
+	}
+	if ref.TransferType == storagemarket.TTExistingPiece {
+		return cid.Undef, xerrors.New("pre-accepted deals cannot reuse an existing piece")
+	}
+	if ref.TransferType != storagemarket.TTManual && client == "" {
+		return cid.Undef, xerrors.Errorf("pre-accepted deal with transfer type %s needs the client peer that sends the data", ref.TransferType)
+	}
+	if proposal.Proposal.Provider != p.actor {
+		return cid.Undef, xerrors.Errorf("incorrect provider for deal: %s != %s", proposal.Proposal.Provider, p.actor)
+	}
+
+	tok, _, err := p.spn.GetChainHead(ctx)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting chain head: %w", err)
+	}
+	if err := providerutils.VerifyProposal(ctx, proposal, tok, p.spn.VerifySignature); err != nil {
+		return cid.Undef, xerrors.Errorf("verifying proposal signature: %w", err)
+	}
+
+	proposalNd, err := cborutil.AsIpld(&proposal)
+	if err != nil {
+		return cid.Undef, err
+	}
+	proposalCid := proposalNd.Cid()
+	var existing storagemarket.MinerDeal
+	if err := p.deals.Get(proposalCid).Get(&existing); err == nil {
+		return cid.Undef, xerrors.Errorf("deal %s already exists", proposalCid)
+	}
+
+	storeIDForDeal, err := p.newStoreForDeal(ref)
+	if err != nil {
+		return cid.Undef, err
+	}
+	deal := &storagemarket.MinerDeal{
+		Client:             client,
+		Miner:              p.net.ID(),
+		ClientDealProposal: proposal,
+		ProposalCid:        proposalCid,
+		State:              storagemarket.StorageDealUnknown,
+		Ref:                ref,
+		StoreID:            storeIDForDeal,
+		CreationTime:       curTime(),
+	}
+	if err := p.deals.Begin(proposalCid, deal); err != nil {
+		return cid.Undef, err
+	}
+	if err := p.deals.Send(proposalCid, storagemarket.ProviderEventPreAcceptedDealAdded); err != nil {
+		return cid.Undef, err
+	}
+	return proposalCid, nil
+}
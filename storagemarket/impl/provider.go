@@ -4,10 +4,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/hannahhoward/go-pubsub"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -28,22 +34,39 @@ import (
 	"github.com/filecoin-project/go-fil-markets/filestore"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
+	"github.com/filecoin-project/go-fil-markets/shared/stagedpieces"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/connmanager"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/deallog"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/diskspace"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dtutils"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundprovision"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/fundwallets"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/intakequota"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/msgwait"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/noderetry"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/peerbinding"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerutils"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/requestvalidation"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/transferlimit"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
 
 var _ storagemarket.StorageProvider = &Provider{}
 var _ network.StorageReceiver = &Provider{}
+var _ network.DealRestartReceiver = &Provider{}
 
 // StoredAsk is an interface which provides access to a StorageAsk
 type StoredAsk interface {
 	GetAsk() *storagemarket.SignedStorageAsk
+	GetAskAt(epoch abi.ChainEpoch) *storagemarket.SignedStorageAsk
 	SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount, duration abi.ChainEpoch, options ...storagemarket.StorageAskOption) error
 }
 
@@ -52,23 +75,75 @@ type Provider struct {
 	net network.StorageMarketNetwork
 
 	spn                       storagemarket.StorageProviderNode
+	retryingNode              storagemarket.StorageProviderNode
 	fs                        filestore.FileStore
 	multiStore                *multistore.MultiStore
 	pio                       pieceio.PieceIO
 	pieceStore                piecestore.PieceStore
+	stagedPieces              *stagedpieces.Registry
+	staging                   *filestore.StagingManager
 	conns                     *connmanager.ConnManager
 	storedAsk                 StoredAsk
 	actor                     address.Address
 	dataTransfer              datatransfer.Manager
 	universalRetrievalEnabled bool
-	customDealDeciderFunc     DealDeciderFunc
-	pubSub                    *pubsub.PubSub
+	pubSub                    *eventbus.Bus
+	eventQueueSize            int
+	eventOverflowPolicy       eventbus.OverflowPolicy
+	handlerPool               *handlerpool.Pool
 	readySub                  *pubsub.PubSub
-
-	deals        fsm.Group
-	migrateDeals func(context.Context) error
+	configSub                 *pubsub.PubSub
+	diskSpaceSub              *pubsub.PubSub
+	diskSpace                 *diskspace.Watcher
+	fundProvisioner           *fundprovision.Provisioner
+	fundingWallets            *fundwallets.Selector
+	publishWaiter             *msgwait.Waiter
+	peerBinder                *peerbinding.Binder
+
+	// configLk guards the tunables that can be changed with ApplyConfig
+	configLk              sync.RWMutex
+	customDealDeciderFunc DealDeciderFunc
+	collateralPolicy      storagemarket.CollateralPolicy
+	transferLimiter       *transferlimit.Limiter
+	intakeQuotas          *intakequota.Tracker
+	dryRun                bool
+	maintenance           shared.Maintenance
+	rejectionRetryAfter   abi.ChainEpoch
+	askGracePeriod        abi.ChainEpoch
+
+	ds              datastore.Batching
+	deals           fsm.Group
+	migrateDeals    func(context.Context) error
+	migrationBackup datastore.Batching
+
+	stateTimes         *shared.StateTimes
+	expectedDwellTimes map[storagemarket.StorageDealStatus]time.Duration
+
+	announcer     storagemarket.Announcer
+	announceAddrs []ma.Multiaddr
+	notifyClients bool
+	capabilities  *storagemarket.ProviderCapabilities
+
+	commPVerifier     storagemarket.CommPVerifier
+	commPPollInterval time.Duration
+
+	sealingReporter storagemarket.SealingProgressReporter
+
+	pieceHoldChecker storagemarket.PieceHoldChecker
+
+	statsDs datastore.Batching
+	stats   *dealstats.Recorder
+
+	logDeals func(datastore.Batching) datastore.Batching
+
+	dealLogsDs        datastore.Batching
+	dealLogMaxEntries int
+	dealLogs          *deallog.Log
 
 	unsubDataTransfer datatransfer.Unsubscribe
+
+	// readOnly is set on providers opened with NewReadOnlyProvider
+	readOnly bool
 }
 
 // StorageProviderOption allows custom configuration of a storage provider
@@ -98,6 +173,150 @@ func CustomDealDecisionLogic(decider DealDeciderFunc) StorageProviderOption {
 	}
 }
 
+// CustomCollateralPolicy sets the policy a provider uses to decide how much collateral
+// it commits to a deal. By default a provider accepts any collateral allowed on chain
+func CustomCollateralPolicy(policy storagemarket.CollateralPolicy) StorageProviderOption {
+	return func(p *Provider) {
+		p.collateralPolicy = policy
+	}
+}
+
+// MaxConcurrentTransfersPerClient limits how many deals from the same client a provider
+// receives data for at once. Deals accepted beyond the limit are held in the
+// StorageDealTransferQueued state, and the client is told when the transfer is expected
+// to start. Clients that do not understand a queued response fail those deals
+func MaxConcurrentTransfersPerClient(max int) StorageProviderOption {
+	return func(p *Provider) {
+		if max <= 0 {
+			p.transferLimiter = nil
+			return
+		}
+		p.transferLimiter = transferlimit.New(max)
+	}
+}
+
+// LimitIntake caps how many deals a provider accepts per hour and how many bytes per
+// day, overall and from each client, so that intake keeps pace with sealing. Deals
+// over a quota are rejected when they are validated, and the client is asked to
+// propose them again once they fit
+func LimitIntake(quotas storagemarket.IntakeQuotas) StorageProviderOption {
+	return func(p *Provider) {
+		p.intakeQuotas = intakequota.New(quotas)
+	}
+}
+
+// EnableDryRunMode causes a storage provider to run every incoming proposal through
+// full validation and custom decision logic, but to always reject it afterwards.
+// The outcome that would have been reached is logged, so operators can test their
+// ask and acceptance policy against real client traffic before going live
+func EnableDryRunMode() StorageProviderOption {
+	return func(p *Provider) {
+		p.dryRun = true
+	}
+}
+
+// MaintenanceWindows schedules spans of epochs during which a storage provider is in
+// maintenance. Proposals received during a window are rejected, and clients are asked
+// to propose them again once the window ends. Deals already in progress carry on
+func MaintenanceWindows(windows ...shared.MaintenanceWindow) StorageProviderOption {
+	return func(p *Provider) {
+		p.maintenance.Windows = windows
+	}
+}
+
+// EventQueue sets how many events are queued for each subscriber to the provider's
+// events, and what happens to a subscriber that falls so far behind that its queue
+// is full. Events are delivered to each subscriber on its own goroutine, so a slow
+// subscriber never holds up deals. By default queues are unbounded and no events are
+// dropped. It must be passed to NewProvider
+func EventQueue(size int, policy eventbus.OverflowPolicy) StorageProviderOption {
+	return func(p *Provider) {
+		p.eventQueueSize = size
+		p.eventOverflowPolicy = policy
+	}
+}
+
+// HandlerPool limits the provider to running at most workers deal state handlers at
+// once, or any number if workers is zero. Handlers are started in the order they
+// arrive, so that a slow deal cannot hold up the others. HandlerStats reports how
+// many handlers are running and waiting, and how many have run for longer than
+// slowAfter
+func HandlerPool(workers int, slowAfter time.Duration) StorageProviderOption {
+	return func(p *Provider) {
+		p.handlerPool.Configure(workers, slowAfter)
+	}
+}
+
+// DefaultRejectionRetryAfter is how many epochs a provider asks clients to wait
+// before proposing again a deal it rejected for a transient reason
+const DefaultRejectionRetryAfter = abi.ChainEpoch(60)
+
+// RejectionRetryAfter sets how many epochs a provider asks clients to wait before
+// proposing again a deal it rejected for a transient reason, such as maintenance or
+// a client without enough funds. Zero or less makes those rejections final, like
+// any other rejection
+func RejectionRetryAfter(epochs abi.ChainEpoch) StorageProviderOption {
+	return func(p *Provider) {
+		p.rejectionRetryAfter = epochs
+	}
+}
+
+// DefaultAskGracePeriod is how many epochs after an ask is changed a provider still
+// accepts proposals that meet the previous ask
+const DefaultAskGracePeriod = abi.ChainEpoch(10)
+
+// AskGracePeriod sets how many epochs after an ask is changed a provider still
+// accepts proposals that meet the previous ask, so that clients who proposed a deal
+// just before the change are not rejected for it. Zero or less checks proposals
+// against the current ask only
+func AskGracePeriod(epochs abi.ChainEpoch) StorageProviderOption {
+	return func(p *Provider) {
+		p.askGracePeriod = epochs
+	}
+}
+
+// StagedPieces makes the provider add the CAR it staged for each deal to the given
+// registry once the deal is handed off, so that a retrieval provider given the same
+// registry can serve the piece from it until the sector is sealed. The CAR is then
+// deleted through the registry, once retrievals reading it have finished
+func StagedPieces(registry *stagedpieces.Registry) StorageProviderOption {
+	return func(p *Provider) {
+		p.stagedPieces = registry
+	}
+}
+
+// RetryTransientNodeErrors makes deal state handlers retry node calls that fail with a
+// transient error, such as while the node restarts, with backoff following policy,
+// rather than failing the deal. Only calls that are safe to repeat are retried; see
+// the noderetry package. It must be passed to NewProvider
+func RetryTransientNodeErrors(policy noderetry.Policy) StorageProviderOption {
+	return func(p *Provider) {
+		p.retryingNode = noderetry.Wrap(p.spn, policy)
+		p.publishWaiter = msgwait.New(p.retryingNode.WaitForMessage)
+	}
+}
+
+// AuthenticateClientPeers makes the provider check that the peer sending a proposal
+// acts for the proposal's client address, and record in ds the peer each client
+// address binds with a signature. mode sets whether proposals without a signature are
+// accepted for client addresses that are not bound yet, or proposals must carry a
+// signature by the client address binding the peer to it. ClientPeerBinding reports
+// the peer a client address is bound to
+func AuthenticateClientPeers(ds datastore.Batching, mode peerbinding.Mode) StorageProviderOption {
+	return func(p *Provider) {
+		p.peerBinder = peerbinding.New(ds, mode)
+	}
+}
+
+// ClientPeerBinding returns the peer the client address is bound to, or false if it
+// is not bound to a peer or the provider does not authenticate client peers
+func (p *Provider) ClientPeerBinding(client address.Address) (peer.ID, bool, error) {
+	if p.peerBinder == nil {
+		return "", false, nil
+	}
+	return p.peerBinder.Bound(client)
+}
+
 // NewProvider returns a new storage provider
 func NewProvider(net network.StorageMarketNetwork,
 	ds datastore.Batching,
@@ -124,24 +343,49 @@ func NewProvider(net network.StorageMarketNetwork,
 		storedAsk:    storedAsk,
 		actor:        minerAddress,
 		dataTransfer: dataTransfer,
-		pubSub:       pubsub.New(providerDispatcher),
 		readySub:     pubsub.New(shared.ReadyDispatcher),
+		configSub:    pubsub.New(configDispatcher),
+		diskSpaceSub: pubsub.New(diskSpaceDispatcher),
+		handlerPool:  handlerpool.New(0, 0),
+
+		rejectionRetryAfter: DefaultRejectionRetryAfter,
+		askGracePeriod:      DefaultAskGracePeriod,
+		publishWaiter:       msgwait.New(spn.WaitForMessage),
+		ds:                  ds,
+		stateTimes:          shared.NewStateTimes(),
+		expectedDwellTimes:  make(map[storagemarket.StorageDealStatus]time.Duration, len(DefaultExpectedDwellTimes)),
+	}
+	for state, dwell := range DefaultExpectedDwellTimes {
+		h.expectedDwellTimes[state] = dwell
 	}
+	h.staging = filestore.NewStagingManager(fs, namespace.Wrap(ds, datastore.NewKey("staged-refs")), filestore.DeleteStagedWith(h.deletePieceFile))
+	h.Configure(options...)
+
 	storageMigrations, err := migrations.ProviderMigrations.Build()
 	if err != nil {
 		return nil, err
 	}
 	h.deals, h.migrateDeals, err = newProviderStateMachine(
-		ds,
+		dealsDatastore(ds, h.logDeals),
 		&providerDealEnvironment{h},
 		h.dispatch,
+		h.handlerPool,
 		storageMigrations,
 		versioning.VersionKey("1"),
 	)
 	if err != nil {
 		return nil, err
 	}
-	h.Configure(options...)
+
+	h.pubSub = eventbus.New(providerDispatcher, h.eventQueueSize, h.eventOverflowPolicy)
+	if h.statsDs == nil {
+		h.statsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	h.stats, err = dealstats.New(h.statsDs)
+	if err != nil {
+		return nil, err
+	}
+	h.dealLogs = h.newDealLog()
 
 	// register a data transfer event handler -- this will send events to the state machines based on DT events
 	h.unsubDataTransfer = dataTransfer.SubscribeToEvents(dtutils.ProviderDataTransferSubscriber(h.deals))
@@ -163,10 +407,24 @@ func NewProvider(net network.StorageMarketNetwork,
 // It also registers the provider with a StorageMarketNetwork so it can receive incoming
 // messages on the storage market's libp2p protocols
 func (p *Provider) Start(ctx context.Context) error {
+	if p.readOnly {
+		// there is nothing to start, but listeners still expect to hear the provider is ready
+		return p.readySub.Publish(nil)
+	}
 	err := p.net.SetDelegate(p)
 	if err != nil {
 		return err
 	}
+	err = p.net.SetDealRestartDelegate(p)
+	if err != nil {
+		return err
+	}
+	if p.diskSpace != nil {
+		p.diskSpace.Start()
+	}
+	if p.fundProvisioner != nil {
+		p.fundProvisioner.Start()
+	}
 	go func() {
 		err := p.start(ctx)
 		if err != nil {
@@ -228,31 +486,31 @@ func (p *Provider) receiveDeal(s network.StorageDealStream) error {
 	// Check if we are already tracking this deal
 	var md storagemarket.MinerDeal
 	if err := p.deals.Get(proposalNd.Cid()).Get(&md); err == nil {
+		if md.State == storagemarket.StorageDealProposalRetryWait {
+			// We asked the client to propose this deal again later, so validate it again
+			return p.reconsiderDeal(s, proposal, proposalNd.Cid())
+		}
 		// We are already tracking this deal, for some reason it was re-proposed, perhaps because of a client restart
 		// this is ok, just send a response back.
 		return p.resendProposalResponse(s, &md)
 	}
 
-	var storeIDForDeal *multistore.StoreID
-	if proposal.Piece.TransferType != storagemarket.TTManual {
-		nextStoreID := p.multiStore.Next()
-		// make sure store is initialized, even if we don't use it yet
-		_, err = p.multiStore.Get(nextStoreID)
-		if err != nil {
-			return err
-		}
-		storeIDForDeal = &nextStoreID
+	storeIDForDeal, err := p.newStoreForDeal(proposal.Piece)
+	if err != nil {
+		return err
 	}
 	deal := &storagemarket.MinerDeal{
-		Client:             s.RemotePeer(),
-		Miner:              p.net.ID(),
-		ClientDealProposal: *proposal.DealProposal,
-		ProposalCid:        proposalNd.Cid(),
-		State:              storagemarket.StorageDealUnknown,
-		Ref:                proposal.Piece,
-		FastRetrieval:      proposal.FastRetrieval,
-		StoreID:            storeIDForDeal,
-		CreationTime:       curTime(),
+		Client:              s.RemotePeer(),
+		Miner:               p.net.ID(),
+		ClientDealProposal:  *proposal.DealProposal,
+		ProposalCid:         proposalNd.Cid(),
+		State:               storagemarket.StorageDealUnknown,
+		Ref:                 proposal.Piece,
+		FastRetrieval:       proposal.FastRetrieval,
+		StoreID:             storeIDForDeal,
+		CreationTime:        curTime(),
+		Invoice:             proposal.Invoice,
+		ClientPeerSignature: proposal.ClientPeerSignature,
 	}
 
 	err = p.deals.Begin(proposalNd.Cid(), deal)
@@ -266,9 +524,46 @@ func (p *Provider) receiveDeal(s network.StorageDealStream) error {
 	return p.deals.Send(proposalNd.Cid(), storagemarket.ProviderEventOpen)
 }
 
+// reconsiderDeal restarts validation of a deal that was rejected for a transient
+// reason, when the client proposes it again
+func (p *Provider) reconsiderDeal(s network.StorageDealStream, proposal network.Proposal, proposalCid cid.Cid) error {
+	storeIDForDeal, err := p.newStoreForDeal(proposal.Piece)
+	if err != nil {
+		return err
+	}
+	err = p.conns.AddStream(proposalCid, s)
+	if err != nil {
+		return err
+	}
+	return p.deals.Send(proposalCid, storagemarket.ProviderEventProposalResubmitted, storeIDForDeal)
+}
+
+// newStoreForDeal allocates a store to receive a deal's data in, for deals whose
+// data is transferred over the network
+func (p *Provider) newStoreForDeal(ref *storagemarket.DataRef) (*multistore.StoreID, error) {
+	if ref.TransferType == storagemarket.TTManual || ref.TransferType == storagemarket.TTExistingPiece {
+		return nil, nil
+	}
+	nextStoreID := p.multiStore.Next()
+	// make sure store is initialized, even if we don't use it yet
+	if _, err := p.multiStore.Get(nextStoreID); err != nil {
+		return nil, err
+	}
+	return &nextStoreID, nil
+}
+
 // Stop terminates processing of deals on a StorageProvider
 func (p *Provider) Stop() error {
+	if p.readOnly {
+		return p.deals.Stop(context.TODO())
+	}
 	p.unsubDataTransfer()
+	if p.diskSpace != nil {
+		p.diskSpace.Stop()
+	}
+	if p.fundProvisioner != nil {
+		p.fundProvisioner.Stop()
+	}
 	err := p.deals.Stop(context.TODO())
 	if err != nil {
 		return err
@@ -280,44 +575,43 @@ func (p *Provider) Stop() error {
 // It will verify that the data in the passed io.Reader matches the expected piece
 // cid for the given deal or it will error
 func (p *Provider) ImportDataForDeal(ctx context.Context, propCid cid.Cid, data io.Reader) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
 	// TODO: be able to check if we have enough disk space
 	var d storagemarket.MinerDeal
 	if err := p.deals.Get(propCid).Get(&d); err != nil {
 		return xerrors.Errorf("failed getting deal %s: %w", propCid, err)
 	}
 
-	tempfi, err := p.fs.CreateTemp()
+	// deals for the same data share one staged file
+	ref := propCid.String()
+	path, err := p.staging.Stage(ref, data)
 	if err != nil {
-		return xerrors.Errorf("failed to create temp file for data import: %w", err)
+		return xerrors.Errorf("importing deal data failed: %w", err)
 	}
 	cleanup := func() {
-		_ = tempfi.Close()
-		_ = p.fs.Delete(tempfi.Path())
-	}
-
-	n, err := io.Copy(tempfi, data)
-	if err != nil {
-		cleanup()
-		return xerrors.Errorf("importing deal data failed: %w", err)
+		if err := p.staging.Release(ref, path); err != nil {
+			log.Warnf("releasing imported data for deal %s: %s", propCid, err)
+		}
 	}
 
-	_ = n // TODO: verify n?
-
-	pieceSize := uint64(tempfi.Size())
-
-	_, err = tempfi.Seek(0, io.SeekStart)
+	file, err := p.fs.Open(path)
 	if err != nil {
 		cleanup()
-		return xerrors.Errorf("failed to seek through temp imported file: %w", err)
+		return xerrors.Errorf("failed to open imported file: %w", err)
 	}
+	pieceSize := uint64(file.Size())
 
 	proofType, err := p.spn.GetProofType(ctx, p.actor, nil)
 	if err != nil {
+		_ = file.Close()
 		cleanup()
 		return xerrors.Errorf("failed to determine proof type: %w", err)
 	}
 
-	pieceCid, err := generatePieceCommitment(proofType, tempfi, pieceSize)
+	pieceCid, err := generatePieceCommitment(proofType, file, pieceSize)
+	_ = file.Close()
 	if err != nil {
 		cleanup()
 		return xerrors.Errorf("failed to generate commP: %w", err)
@@ -329,7 +623,7 @@ func (p *Provider) ImportDataForDeal(ctx context.Context, propCid cid.Cid, data
 		return xerrors.Errorf("given data does not match expected commP (got: %x, expected %x)", pieceCid, d.Proposal.PieceCID)
 	}
 
-	return p.deals.Send(propCid, storagemarket.ProviderEventVerifiedData, tempfi.Path(), filestore.Path(""))
+	return p.deals.Send(propCid, storagemarket.ProviderEventVerifiedData, path, filestore.Path(""))
 }
 
 func generatePieceCommitment(rt abi.RegisteredSealProof, rd io.Reader, pieceSize uint64) (cid.Cid, error) {
@@ -343,11 +637,17 @@ func generatePieceCommitment(rt abi.RegisteredSealProof, rd io.Reader, pieceSize
 
 // GetAsk returns the storage miner's ask, or nil if one does not exist.
 func (p *Provider) GetAsk() *storagemarket.SignedStorageAsk {
+	if p.storedAsk == nil {
+		return nil
+	}
 	return p.storedAsk.GetAsk()
 }
 
 // AddStorageCollateral adds storage collateral
 func (p *Provider) AddStorageCollateral(ctx context.Context, amount abi.TokenAmount) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
 	done := make(chan error, 1)
 
 	mcid, err := p.spn.AddFunds(ctx, p.actor, amount)
@@ -373,8 +673,12 @@ func (p *Provider) AddStorageCollateral(ctx context.Context, amount abi.TokenAmo
 	return <-done
 }
 
-// GetStorageCollateral returns the current collateral balance
+// GetStorageCollateral returns the current collateral balance. A read-only provider
+// has no node to ask, so it returns ErrReadOnly
 func (p *Provider) GetStorageCollateral(ctx context.Context) (storagemarket.Balance, error) {
+	if p.readOnly {
+		return storagemarket.Balance{}, ErrReadOnly
+	}
 	tok, _, err := p.spn.GetChainHead(ctx)
 	if err != nil {
 		return storagemarket.Balance{}, err
@@ -392,9 +696,30 @@ func (p *Provider) ListLocalDeals() ([]storagemarket.MinerDeal, error) {
 	return out, nil
 }
 
+// DealStateSnapshot returns the provider deal state machine definition along
+// with the number of deals currently in each state
+func (p *Provider) DealStateSnapshot() (fsmexport.Snapshot, error) {
+	def, err := fsmexport.NewDefinition(ProviderFSMParameterSpec, storagemarket.DealStates, storagemarket.ProviderEvents, []fsm.StateKey{storagemarket.StorageDealUnknown})
+	if err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	deals, err := p.ListLocalDeals()
+	if err != nil {
+		return fsmexport.Snapshot{}, err
+	}
+	current := make([]fsm.StateKey, 0, len(deals))
+	for _, deal := range deals {
+		current = append(current, deal.State)
+	}
+	return fsmexport.NewSnapshot(def, current), nil
+}
+
 // SetAsk configures the storage miner's ask with the provided price,
 // duration, and options. Any previously-existing ask is replaced.
 func (p *Provider) SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount, duration abi.ChainEpoch, options ...storagemarket.StorageAskOption) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
 	return p.storedAsk.SetAsk(price, verifiedPrice, duration, options...)
 }
 
@@ -495,6 +820,11 @@ func (p *Provider) HandleDealStatusStream(s network.DealStatusStream) {
 		PublishCid:    md.PublishCid,
 		DealID:        md.DealID,
 		FastRetrieval: md.FastRetrieval,
+
+		TransferChannelID: md.TransferChannelId,
+		TransferReceived:  md.TransferReceived,
+
+		Sealing: p.sealingProgress(ctx, md),
 	}
 
 	signature, err := p.sign(ctx, &dealState)
@@ -514,9 +844,87 @@ func (p *Provider) HandleDealStatusStream(s network.DealStatusStream) {
 	}
 }
 
+/*
+HandleDealRestartStream is called by the network implementation whenever a client
+that restarted sends its view of a deal on the deal restart protocol
+
+A Provider handling a `DealRestartRequest` does the following:
+
+1. Loads the deal from the Provider FSM and checks the request came from the deal's client
+
+2. Writes its own view of the deal onto the DealRestartStream. If it has no record
+of the deal, the view has the state StorageDealUnknown
+
+3. Reconciles the client's view with its own, and fails the deal if it cannot be resumed.
+If the transfer needs to be restarted, the client restarts it
+
+The connection is kept open only as long as the request-response exchange.
+*/
+func (p *Provider) HandleDealRestartStream(s network.DealRestartStream) {
+	ctx := context.TODO()
+	defer s.Close()
+	request, err := s.ReadDealRestartRequest()
+	if err != nil {
+		log.Errorf("failed to read DealRestartRequest from incoming stream: %s", err)
+		return
+	}
+
+	var md storagemarket.MinerDeal
+	found := p.deals.Get(request.View.Proposal).Get(&md) == nil
+	if found && md.Client != s.RemotePeer() {
+		log.Errorf("deal restart request for deal %s from peer %s, which is not the deal client", md.ProposalCid, s.RemotePeer())
+		return
+	}
+
+	view := network.DealView{Proposal: request.View.Proposal}
+	if found {
+		view = p.dealView(ctx, md)
+	}
+	if err := s.WriteDealRestartResponse(network.DealRestartResponse{View: view}); err != nil {
+		log.Warnf("failed to write deal restart response: %s", err)
+		return
+	}
+	if !found {
+		return
+	}
+
+	resolution, reason := dealrestart.Reconcile(request.View, view)
+	log.Infof("client restarted deal %s: %s", md.ProposalCid, resolution)
+	if resolution != dealrestart.ResolutionFail {
+		return
+	}
+	switch md.State {
+	case storagemarket.StorageDealWaitingForData, storagemarket.StorageDealTransferring, storagemarket.StorageDealProviderTransferRestart:
+		if err := p.deals.Send(md.ProposalCid, storagemarket.ProviderEventRestartNegotiationFailed, reason); err != nil {
+			log.Errorf("failed to fail deal %s after restart negotiation: %s", md.ProposalCid, err)
+		}
+	}
+}
+
+// dealView is the provider's view of a deal for restart negotiation
+func (p *Provider) dealView(ctx context.Context, deal storagemarket.MinerDeal) network.DealView {
+	view := network.DealView{
+		Proposal:          deal.ProposalCid,
+		State:             deal.State,
+		Message:           deal.Message,
+		TransferChannelID: deal.TransferChannelId,
+	}
+	if deal.TransferChannelId != nil {
+		chst, err := p.dataTransfer.ChannelState(ctx, *deal.TransferChannelId)
+		if err != nil {
+			log.Warnf("getting state of transfer channel for deal %s: %s", deal.ProposalCid, err)
+		} else {
+			view.BytesTransferred = chst.Received()
+		}
+	}
+	return view
+}
+
 // Configure applies the given list of StorageProviderOptions after a StorageProvider
 // is initialized
 func (p *Provider) Configure(options ...StorageProviderOption) {
+	p.configLk.Lock()
+	defer p.configLk.Unlock()
 	for _, option := range options {
 		option(p)
 	}
@@ -535,8 +943,20 @@ func (p *Provider) SubscribeToEvents(subscriber storagemarket.ProviderSubscriber
 	return shared.Unsubscribe(p.pubSub.Subscribe(subscriber))
 }
 
+// EventStats returns the number of events published to subscribers, and the number
+// dropped because a subscriber fell behind
+func (p *Provider) EventStats() eventbus.Stats {
+	return p.pubSub.Stats()
+}
+
+// HandlerStats returns how many deal state handlers are running, and how many are
+// waiting for a worker
+func (p *Provider) HandlerStats() handlerpool.Stats {
+	return p.handlerPool.Stats()
+}
+
 // dispatch puts the fsm event into a form that pubSub can consume,
-// then publishes the event
+// then publishes the event. Publishing does not wait for subscribers
 func (p *Provider) dispatch(eventName fsm.EventName, deal fsm.StateType) {
 	evt, ok := eventName.(storagemarket.ProviderEvent)
 	if !ok {
@@ -546,15 +966,79 @@ func (p *Provider) dispatch(eventName fsm.EventName, deal fsm.StateType) {
 	if !ok {
 		log.Errorf("not a MinerDeal %v", deal)
 	}
+	if isFinalityState(realDeal.State) {
+		p.stateTimes.Forget(realDeal.ProposalCid)
+	} else {
+		p.stateTimes.Record(realDeal.ProposalCid, realDeal.State)
+	}
+	p.recordStats(evt, realDeal)
 	pubSubEvt := internalProviderEvent{evt, realDeal}
 
-	if err := p.pubSub.Publish(pubSubEvt); err != nil {
-		log.Errorf("failed to publish event %d", evt)
+	p.pubSub.Publish(pubSubEvt)
+
+	if evt == storagemarket.ProviderEventDealAccepted && p.fundProvisioner != nil {
+		p.fundProvisioner.Poke()
+	}
+
+	p.trackFundingMessage(realDeal)
+
+	if evt == storagemarket.ProviderEventFinalized && p.announcer != nil {
+		go p.announce(realDeal)
+	}
+
+	if p.notifyClients && notifiesClient(evt) {
+		go p.notifyClient(realDeal)
+	}
+
+	if limiter := p.limiter(); limiter != nil && !holdsTransferSlot(realDeal.State) {
+		if next, ok := limiter.Release(realDeal.Client, realDeal.ProposalCid); ok {
+			if err := p.deals.Send(next, storagemarket.ProviderEventTransferSlotOpened); err != nil {
+				log.Errorf("starting queued transfer for deal %s: %s", next, err)
+			}
+		}
+	}
+}
+
+// holdsTransferSlot returns true for the states in which a deal holds, or is queued
+// for, one of its client's transfer slots
+func holdsTransferSlot(state storagemarket.StorageDealStatus) bool {
+	switch state {
+	case storagemarket.StorageDealAcceptWait,
+		storagemarket.StorageDealTransferQueued,
+		storagemarket.StorageDealWaitingForData,
+		storagemarket.StorageDealTransferring,
+		storagemarket.StorageDealProviderTransferRestart:
+		return true
+	default:
+		return false
+	}
+}
+
+// limiter returns the per client transfer limiter, or nil if transfers are not limited
+func (p *Provider) limiter() *transferlimit.Limiter {
+	p.configLk.RLock()
+	defer p.configLk.RUnlock()
+	return p.transferLimiter
+}
+
+// restoreTransferSlots gives the deals that were receiving data their transfer slots
+// back. It must be called with configLk held
+func (p *Provider) restoreTransferSlots(deals []storagemarket.MinerDeal) {
+	if p.transferLimiter == nil {
+		return
+	}
+	for _, deal := range deals {
+		if deal.State != storagemarket.StorageDealTransferQueued && holdsTransferSlot(deal.State) {
+			p.transferLimiter.Restore(deal.Client, deal.ProposalCid)
+		}
 	}
 }
 
 func (p *Provider) start(ctx context.Context) error {
-	err := p.migrateDeals(ctx)
+	err := p.backupBeforeMigrating(versioning.VersionKey("1"))
+	if err == nil {
+		err = p.migrateDeals(ctx)
+	}
 	publishErr := p.readySub.Publish(err)
 	if publishErr != nil {
 		log.Warnf("Publish storage provider ready event: %s", err.Error())
@@ -575,11 +1059,20 @@ func (p *Provider) restartDeals() error {
 		return err
 	}
 
+	// deals that were receiving data keep their transfer slots, so they are restored
+	// before queued deals are restarted
+	p.configLk.RLock()
+	p.restoreTransferSlots(deals)
+	p.configLk.RUnlock()
+	p.restoreFundingMessages(deals)
+
 	for _, deal := range deals {
 		if p.deals.IsTerminated(deal) {
 			continue
 		}
 
+		p.restagePiece(deal)
+
 		err = p.deals.Send(deal.ProposalCid, storagemarket.ProviderEventRestart)
 		if err != nil {
 			return err
@@ -588,6 +1081,27 @@ func (p *Provider) restartDeals() error {
 	return nil
 }
 
+// deletePieceFile deletes a staged CAR that no deal uses any more, once retrievals
+// reading it have finished
+func (p *Provider) deletePieceFile(path filestore.Path) error {
+	if p.stagedPieces != nil {
+		return p.stagedPieces.Delete(path)
+	}
+	return p.fs.Delete(path)
+}
+
+// restagePiece adds the staged CAR of a deal that was handed off before the provider
+// restarted back to the staged pieces registry, if its sector is not yet sealed
+func (p *Provider) restagePiece(deal storagemarket.MinerDeal) {
+	if p.stagedPieces == nil || deal.PiecePath == "" || deal.PieceReused {
+		return
+	}
+	switch deal.State {
+	case storagemarket.StorageDealAwaitingPreCommit, storagemarket.StorageDealSealing:
+		p.stagedPieces.Add(deal.Proposal.PieceCID, deal.PiecePath)
+	}
+}
+
 func (p *Provider) sign(ctx context.Context, data interface{}) (*crypto.Signature, error) {
 	tok, _, err := p.spn.GetChainHead(ctx)
 	if err != nil {
@@ -598,7 +1112,7 @@ func (p *Provider) sign(ctx context.Context, data interface{}) (*crypto.Signatur
 }
 
 func (p *Provider) resendProposalResponse(s network.StorageDealStream, md *storagemarket.MinerDeal) error {
-	resp := &network.Response{State: md.State, Message: md.Message, Proposal: md.ProposalCid}
+	resp := &network.Response{State: md.State, Message: md.Message, Proposal: md.ProposalCid, RetryAfter: md.RetryAfter}
 	sig, err := p.sign(context.TODO(), resp)
 	if err != nil {
 		return xerrors.Errorf("failed to sign response message: %w", err)
@@ -613,13 +1127,13 @@ func (p *Provider) resendProposalResponse(s network.StorageDealStream, md *stora
 	return err
 }
 
-func newProviderStateMachine(ds datastore.Batching, env fsm.Environment, notifier fsm.Notifier, storageMigrations versioning.VersionedMigrationList, target versioning.VersionKey) (fsm.Group, func(context.Context) error, error) {
+func newProviderStateMachine(ds datastore.Batching, env fsm.Environment, notifier fsm.Notifier, pool *handlerpool.Pool, storageMigrations versioning.VersionedMigrationList, target versioning.VersionKey) (fsm.Group, func(context.Context) error, error) {
 	return versionedfsm.NewVersionedFSM(ds, fsm.Parameters{
 		Environment:     env,
 		StateType:       storagemarket.MinerDeal{},
 		StateKeyField:   "State",
 		Events:          providerstates.ProviderEvents,
-		StateEntryFuncs: providerstates.ProviderStateEntryFuncs,
+		StateEntryFuncs: pool.Wrap(providerstates.ProviderStateEntryFuncs),
 		FinalityStates:  providerstates.ProviderFinalityStates,
 		Notifier:        notifier,
 	}, storageMigrations, target)
@@ -652,3 +1166,12 @@ var ProviderFSMParameterSpec = fsm.Parameters{
 	StateEntryFuncs: providerstates.ProviderStateEntryFuncs,
 	FinalityStates:  providerstates.ProviderFinalityStates,
 }
+
+func isFinalityState(state storagemarket.StorageDealStatus) bool {
+	for _, finalityState := range providerstates.ProviderFinalityStates {
+		if state == finalityState {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -13,10 +14,16 @@ import (
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
 
 	"github.com/filecoin-project/go-fil-markets/filestore"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/collateral"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerutils"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
@@ -40,6 +47,9 @@ func (p *providerDealEnvironment) Address() address.Address {
 }
 
 func (p *providerDealEnvironment) Node() storagemarket.StorageProviderNode {
+	if p.p.retryingNode != nil {
+		return p.p.retryingNode
+	}
 	return p.p.spn
 }
 
@@ -51,6 +61,23 @@ func (p *providerDealEnvironment) Ask() storagemarket.StorageAsk {
 	return *sask.Ask
 }
 
+func (p *providerDealEnvironment) AskAt(epoch abi.ChainEpoch) storagemarket.StorageAsk {
+	sask := p.p.storedAsk.GetAskAt(epoch)
+	if sask == nil {
+		return storagemarket.StorageAskUndefined
+	}
+	return *sask.Ask
+}
+
+func (p *providerDealEnvironment) AskGracePeriod() abi.ChainEpoch {
+	p.p.configLk.RLock()
+	defer p.p.configLk.RUnlock()
+	if p.p.askGracePeriod < 0 {
+		return 0
+	}
+	return p.p.askGracePeriod
+}
+
 func (p *providerDealEnvironment) DeleteStore(storeID multistore.StoreID) error {
 	return p.p.multiStore.Delete(storeID)
 }
@@ -67,6 +94,17 @@ func (p *providerDealEnvironment) GeneratePieceCommitment(storeID *multistore.St
 	return pieceCid, filestore.Path(""), err
 }
 
+func (p *providerDealEnvironment) CommPVerifier() (storagemarket.CommPVerifier, time.Duration) {
+	if p.p.universalRetrievalEnabled {
+		return nil, 0
+	}
+	return p.p.commPVerifier, p.p.commPPollInterval
+}
+
+func (p *providerDealEnvironment) PieceHoldChecker() storagemarket.PieceHoldChecker {
+	return p.p.pieceHoldChecker
+}
+
 func (p *providerDealEnvironment) GeneratePieceReader(storeID *multistore.StoreID, payloadCid cid.Cid, selector ipld.Node) (io.ReadCloser, uint64, error, <-chan error) {
 	return p.p.pio.GeneratePieceReader(payloadCid, selector, storeID)
 }
@@ -75,6 +113,34 @@ func (p *providerDealEnvironment) FileStore() filestore.FileStore {
 	return p.p.fs
 }
 
+// StagePieceForRetrieval lets retrievals read the piece from its staged CAR until
+// the CAR is deleted
+func (p *providerDealEnvironment) StagePieceForRetrieval(pieceCID cid.Cid, path filestore.Path) {
+	if p.p.stagedPieces != nil {
+		p.p.stagedPieces.Add(pieceCID, path)
+	}
+}
+
+// DeletePiece releases the deal's staged CAR, which is deleted once no other deal
+// uses it and retrievals reading it have finished
+func (p *providerDealEnvironment) DeletePiece(proposalCid cid.Cid, path filestore.Path) error {
+	if filestore.IsStagedPath(path) {
+		return p.p.staging.Release(proposalCid.String(), path)
+	}
+	return p.p.deletePieceFile(path)
+}
+
+// AuthenticateClientPeer checks that the peer that proposed the deal acts for the
+// deal's client address, if the provider authenticates client peers
+func (p *providerDealEnvironment) AuthenticateClientPeer(ctx context.Context, deal storagemarket.MinerDeal, tok shared.TipSetToken) error {
+	if p.p.peerBinder == nil {
+		return nil
+	}
+	return p.p.peerBinder.Authenticate(deal.Proposal.Client, deal.Client, deal.ClientPeerSignature, func(sig crypto.Signature, data []byte) (bool, error) {
+		return p.Node().VerifySignature(ctx, sig, deal.Proposal.Client, data, tok)
+	})
+}
+
 func (p *providerDealEnvironment) PieceStore() piecestore.PieceStore {
 	return p.p.pieceStore
 }
@@ -108,10 +174,108 @@ func (p *providerDealEnvironment) Disconnect(proposalCid cid.Cid) error {
 }
 
 func (p *providerDealEnvironment) RunCustomDecisionLogic(ctx context.Context, deal storagemarket.MinerDeal) (bool, string, error) {
-	if p.p.customDealDeciderFunc == nil {
+	p.p.configLk.RLock()
+	decider := p.p.customDealDeciderFunc
+	p.p.configLk.RUnlock()
+	if decider == nil {
 		return true, "", nil
 	}
-	return p.p.customDealDeciderFunc(ctx, deal)
+	return decider(ctx, deal)
+}
+
+func (p *providerDealEnvironment) CollateralPolicy() storagemarket.CollateralPolicy {
+	p.p.configLk.RLock()
+	defer p.p.configLk.RUnlock()
+	if p.p.collateralPolicy == nil {
+		return collateral.ChainBounds()
+	}
+	return p.p.collateralPolicy
+}
+
+func (p *providerDealEnvironment) TransferSlot(deal storagemarket.MinerDeal) (bool, time.Time) {
+	limiter := p.p.limiter()
+	if limiter == nil {
+		return true, time.Time{}
+	}
+	return limiter.Acquire(deal.Client, deal.ProposalCid)
+}
+
+func (p *providerDealEnvironment) FundingWallet(deal storagemarket.MinerDeal) (address.Address, bool) {
+	if p.p.fundingWallets == nil {
+		return address.Undef, false
+	}
+	return p.p.fundingWallets.Select(deal.Proposal.Client)
+}
+
+func (p *providerDealEnvironment) WaitForPublishMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	return p.p.publishWaiter.WaitForMessage(ctx, mcid, onCompletion)
+}
+
+func (p *providerDealEnvironment) NegotiateRestart(ctx context.Context, deal storagemarket.MinerDeal) (network.DealView, network.DealView, error) {
+	providerView := p.p.dealView(ctx, deal)
+	clientView, err := dealrestart.Negotiate(ctx, p.p.net, deal.Client, providerView)
+	return clientView, providerView, err
+}
+
+func (p *providerDealEnvironment) DryRun() bool {
+	p.p.configLk.RLock()
+	defer p.p.configLk.RUnlock()
+	return p.p.dryRun
+}
+
+func (p *providerDealEnvironment) Maintenance(epoch abi.ChainEpoch) (bool, abi.ChainEpoch) {
+	p.p.configLk.RLock()
+	defer p.p.configLk.RUnlock()
+	return p.p.maintenance.At(epoch)
+}
+
+func (p *providerDealEnvironment) IntakePaused() (bool, string) {
+	if p.p.diskSpace == nil {
+		return false, ""
+	}
+	return p.p.diskSpace.Paused()
+}
+
+func (p *providerDealEnvironment) AdmitToIntakeQuotas(deal storagemarket.MinerDeal) (bool, time.Time, string) {
+	p.p.configLk.RLock()
+	tracker := p.p.intakeQuotas
+	p.p.configLk.RUnlock()
+	if tracker == nil {
+		return true, time.Time{}, ""
+	}
+	return tracker.Admit(deal.ProposalCid, deal.Proposal.Client, uint64(deal.Proposal.PieceSize))
+}
+
+// AcceptsTransferType returns true if the provider advertises the transfer type.
+// Deals for an existing piece are always accepted here, as they transfer no data
+func (p *providerDealEnvironment) AcceptsTransferType(transferType string) bool {
+	if transferType == storagemarket.TTExistingPiece {
+		return true
+	}
+	if transferType == "" {
+		transferType = storagemarket.TTGraphsync
+	}
+	for _, tt := range p.p.advertisedCapabilities().TransferTypes {
+		if tt == transferType {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *providerDealEnvironment) RejectionRetryAfter() abi.ChainEpoch {
+	p.p.configLk.RLock()
+	defer p.p.configLk.RUnlock()
+	if p.p.rejectionRetryAfter < 0 {
+		return 0
+	}
+	return p.p.rejectionRetryAfter
+}
+
+func (p *providerDealEnvironment) RecordDealLog(proposalCid cid.Cid, level string, message string) {
+	if err := p.p.dealLogs.Record(proposalCid, level, message); err != nil {
+		log.Warnf("recording log line of deal %s: %s", proposalCid, err)
+	}
 }
 
 func (p *providerDealEnvironment) TagPeer(id peer.ID, s string) {
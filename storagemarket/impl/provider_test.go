@@ -9,19 +9,26 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
+	dss "github.com/ipfs/go-datastore/sync"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/exp/rand"
 
+	"github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/shared/eventstore"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
 	"github.com/filecoin-project/go-fil-markets/shared_testutil"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	storageimpl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
@@ -43,6 +50,460 @@ func TestConfigure(t *testing.T) {
 	assert.True(t, p.UniversalRetrievalEnabled())
 }
 
+func TestApplyConfig(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+		noOpDelay, noOpDelay)
+
+	sp, err := storageimpl.NewProvider(
+		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+		namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider")),
+		deps.Fs,
+		deps.TestData.MultiStore2,
+		deps.PieceStore,
+		deps.DTProvider,
+		deps.ProviderNode,
+		deps.ProviderAddr,
+		deps.StoredAsk,
+		storageimpl.MaxConcurrentTransfersPerClient(2),
+	)
+	require.NoError(t, err)
+	provider := sp.(*storageimpl.Provider)
+
+	var changes []storageimpl.ConfigChange
+	provider.SubscribeToConfigChanges(func(change storageimpl.ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	previous := provider.Config()
+	require.Equal(t, 2, previous.MaxConcurrentTransfersPerClient)
+	require.False(t, previous.DryRun)
+
+	cfg := previous
+	cfg.Ask.Price = big.NewInt(1000)
+	cfg.Ask.MaxPieceSize = 1 << 30
+	cfg.Ask.MaxDealDuration = 365 * builtin.EpochsInDay
+	cfg.MaxConcurrentTransfersPerClient = 4
+	cfg.DryRun = true
+	require.NoError(t, provider.ApplyConfig(cfg))
+
+	current := provider.Config()
+	require.Equal(t, 4, current.MaxConcurrentTransfersPerClient)
+	require.True(t, current.DryRun)
+	ask := provider.GetAsk().Ask
+	require.Equal(t, big.NewInt(1000), ask.Price)
+	require.Equal(t, abi.PaddedPieceSize(1<<30), ask.MaxPieceSize)
+	require.Equal(t, abi.ChainEpoch(365*builtin.EpochsInDay), ask.MaxDealDuration)
+	require.Equal(t, previous.Ask.Duration, ask.Expiry-ask.Timestamp)
+
+	require.Len(t, changes, 1)
+	require.Equal(t, 2, changes[0].Previous.MaxConcurrentTransfersPerClient)
+	require.Equal(t, 4, changes[0].Current.MaxConcurrentTransfersPerClient)
+
+	// an invalid config changes nothing
+	invalid := current
+	invalid.Ask.MinPieceSize = invalid.Ask.MaxPieceSize * 2
+	invalid.DryRun = false
+	require.Error(t, provider.ApplyConfig(invalid))
+	require.True(t, provider.Config().DryRun)
+	require.Equal(t, ask.SeqNo, provider.GetAsk().Ask.SeqNo)
+	require.Len(t, changes, 1)
+
+	// removing the limit
+	cfg = provider.Config()
+	cfg.MaxConcurrentTransfersPerClient = 0
+	require.NoError(t, provider.ApplyConfig(cfg))
+	require.Equal(t, 0, provider.Config().MaxConcurrentTransfersPerClient)
+	require.Equal(t, ask.SeqNo, provider.GetAsk().Ask.SeqNo)
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+		noOpDelay, noOpDelay)
+	_, epoch, err := deps.ProviderNode.GetChainHead(ctx)
+	require.NoError(t, err)
+
+	sp, err := storageimpl.NewProvider(
+		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+		namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider")),
+		deps.Fs,
+		deps.TestData.MultiStore2,
+		deps.PieceStore,
+		deps.DTProvider,
+		deps.ProviderNode,
+		deps.ProviderAddr,
+		deps.StoredAsk,
+		storageimpl.MaintenanceWindows(
+			shared.MaintenanceWindow{Start: epoch - 10, End: epoch + 10},
+			shared.MaintenanceWindow{Start: epoch + 100, End: epoch + 200},
+		),
+	)
+	require.NoError(t, err)
+	provider := sp.(*storageimpl.Provider)
+
+	// exiting maintenance ends the window in progress, but keeps later windows
+	require.NoError(t, provider.ExitMaintenance(ctx))
+	require.Equal(t, []shared.MaintenanceWindow{
+		{Start: epoch - 10, End: epoch},
+		{Start: epoch + 100, End: epoch + 200},
+	}, provider.Config().MaintenanceWindows)
+
+	require.NoError(t, provider.EnterMaintenance(epoch+50))
+	cfg := provider.Config()
+	require.True(t, cfg.Maintenance)
+	require.Equal(t, epoch+50, cfg.MaintenanceUntil)
+
+	require.NoError(t, provider.ExitMaintenance(ctx))
+	require.False(t, provider.Config().Maintenance)
+
+	// windows must end after they start
+	cfg = provider.Config()
+	cfg.MaintenanceWindows = []shared.MaintenanceWindow{{Start: epoch, End: epoch}}
+	require.Error(t, provider.ApplyConfig(cfg))
+}
+
+func TestDealSummary(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+		noOpDelay, noOpDelay)
+	var providerDs datastore.Batching = namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider"))
+	namespaced := shared_testutil.DatastoreAtVersion(t, providerDs, "1")
+
+	states := []storagemarket.StorageDealStatus{
+		storagemarket.StorageDealExpired,
+		storagemarket.StorageDealExpired,
+		storagemarket.StorageDealError,
+	}
+	for _, state := range states {
+		proposal := shared_testutil.MakeTestClientDealProposal()
+		proposalNd, err := cborutil.AsIpld(proposal)
+		require.NoError(t, err)
+		deal := storagemarket.MinerDeal{
+			ClientDealProposal: *proposal,
+			ProposalCid:        proposalNd.Cid(),
+			State:              state,
+			Ref: &storagemarket.DataRef{
+				TransferType: storagemarket.TTGraphsync,
+				Root:         shared_testutil.GenerateCids(1)[0],
+			},
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+	}
+
+	provider, err := storageimpl.NewProvider(
+		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+		providerDs,
+		deps.Fs,
+		deps.TestData.MultiStore2,
+		deps.PieceStore,
+		deps.DTProvider,
+		deps.ProviderNode,
+		deps.ProviderAddr,
+		deps.StoredAsk,
+		storageimpl.ExpectedDwellTimes(map[storagemarket.StorageDealStatus]time.Duration{
+			storagemarket.StorageDealExpired: time.Nanosecond,
+		}),
+	)
+	require.NoError(t, err)
+	shared_testutil.StartAndWaitForReady(ctx, t, provider)
+	time.Sleep(time.Millisecond)
+
+	summary, err := provider.DealSummary()
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.Total)
+	require.Equal(t, 2, summary.Stuck)
+	require.Len(t, summary.States, 2)
+
+	expired := summary.States[storagemarket.StorageDealExpired]
+	require.Equal(t, 2, expired.Count)
+	require.Equal(t, 2, expired.Stuck)
+	require.True(t, expired.OldestDeal.Defined())
+	require.False(t, expired.OldestSince.After(summary.Time))
+
+	failed := summary.States[storagemarket.StorageDealError]
+	require.Equal(t, 1, failed.Count)
+	require.Equal(t, 0, failed.Stuck)
+}
+
+func TestDealPipeline(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	namespaced := shared_testutil.DatastoreAtVersion(t, ds, "1")
+
+	publishCid := shared_testutil.GenerateCids(1)[0]
+	channelID := shared_testutil.MakeTestChannelID()
+	putDeal := func(state storagemarket.StorageDealStatus, transferType string, setup func(*storagemarket.MinerDeal)) cid.Cid {
+		proposal := shared_testutil.MakeTestClientDealProposal()
+		proposalNd, err := cborutil.AsIpld(proposal)
+		require.NoError(t, err)
+		deal := storagemarket.MinerDeal{
+			ClientDealProposal: *proposal,
+			ProposalCid:        proposalNd.Cid(),
+			State:              state,
+			Ref: &storagemarket.DataRef{
+				TransferType: transferType,
+				Root:         shared_testutil.GenerateCids(1)[0],
+			},
+		}
+		if setup != nil {
+			setup(&deal)
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+		return deal.ProposalCid
+	}
+	publishing := putDeal(storagemarket.StorageDealPublishing, storagemarket.TTGraphsync, func(deal *storagemarket.MinerDeal) {
+		deal.PublishCid = &publishCid
+	})
+	transferring := putDeal(storagemarket.StorageDealTransferring, storagemarket.TTGraphsync, func(deal *storagemarket.MinerDeal) {
+		deal.TransferChannelId = &channelID
+	})
+	sealing := putDeal(storagemarket.StorageDealSealing, storagemarket.TTGraphsync, func(deal *storagemarket.MinerDeal) {
+		deal.SectorNumber = 7
+	})
+	manual := putDeal(storagemarket.StorageDealWaitingForData, storagemarket.TTManual, nil)
+	putDeal(storagemarket.StorageDealActive, storagemarket.TTGraphsync, nil)
+	putDeal(storagemarket.StorageDealError, storagemarket.TTGraphsync, nil)
+
+	provider, err := storageimpl.NewReadOnlyProvider(ds, nil)
+	require.NoError(t, err)
+	shared_testutil.StartAndWaitForReady(ctx, t, provider)
+	defer func() {
+		require.NoError(t, provider.Stop())
+	}()
+
+	pipeline, err := provider.DealPipeline()
+	require.NoError(t, err)
+	require.Len(t, pipeline.Deals, 4)
+	deals := make(map[cid.Cid]storagemarket.PipelineDeal)
+	for _, deal := range pipeline.Deals {
+		require.False(t, deal.Entered.After(pipeline.Time))
+		deals[deal.ProposalCid] = deal
+	}
+
+	require.Equal(t, storagemarket.StorageDealStaged, deals[publishing].Next)
+	require.Equal(t, storagemarket.DealBlockerChainMessage, deals[publishing].Blocker)
+	require.Equal(t, &publishCid, deals[publishing].Message)
+	require.Equal(t, deals[publishing].Entered.Add(time.Hour), deals[publishing].ExpectedBy)
+
+	require.Equal(t, storagemarket.StorageDealVerifyData, deals[transferring].Next)
+	require.Equal(t, storagemarket.DealBlockerTransfer, deals[transferring].Blocker)
+	require.Equal(t, &channelID, deals[transferring].TransferChannelID)
+	require.True(t, deals[transferring].ExpectedBy.IsZero())
+
+	require.Equal(t, storagemarket.StorageDealFinalizing, deals[sealing].Next)
+	require.Equal(t, storagemarket.DealBlockerSealing, deals[sealing].Blocker)
+	require.Equal(t, abi.SectorNumber(7), deals[sealing].SectorNumber)
+
+	require.Equal(t, storagemarket.StorageDealVerifyData, deals[manual].Next)
+	require.Equal(t, storagemarket.DealBlockerClient, deals[manual].Blocker)
+}
+
+func TestDealOverrides(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	namespaced := shared_testutil.DatastoreAtVersion(t, ds, "1")
+
+	putDeal := func(state storagemarket.StorageDealStatus) cid.Cid {
+		proposal := shared_testutil.MakeTestClientDealProposal()
+		proposalNd, err := cborutil.AsIpld(proposal)
+		require.NoError(t, err)
+		deal := storagemarket.MinerDeal{
+			ClientDealProposal: *proposal,
+			ProposalCid:        proposalNd.Cid(),
+			State:              state,
+			Ref: &storagemarket.DataRef{
+				TransferType: storagemarket.TTGraphsync,
+				Root:         shared_testutil.GenerateCids(1)[0],
+			},
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+		return deal.ProposalCid
+	}
+	funding := putDeal(storagemarket.StorageDealProviderFunding)
+	sealing := putDeal(storagemarket.StorageDealSealing)
+	active := putDeal(storagemarket.StorageDealActive)
+	expired := putDeal(storagemarket.StorageDealExpired)
+
+	provider, err := storageimpl.NewReadOnlyProvider(ds, nil)
+	require.NoError(t, err)
+	shared_testutil.StartAndWaitForReady(ctx, t, provider)
+	defer func() {
+		require.NoError(t, provider.Stop())
+	}()
+
+	inspection, err := provider.InspectDeal(funding)
+	require.NoError(t, err)
+	require.Equal(t, funding, inspection.Deal.ProposalCid)
+	require.True(t, inspection.CanFail)
+	require.True(t, inspection.CanRetry)
+	require.Equal(t, storagemarket.StorageDealPublish, inspection.AdvanceTo)
+	require.Empty(t, inspection.Logs)
+
+	inspection, err = provider.InspectDeal(sealing)
+	require.NoError(t, err)
+	require.True(t, inspection.CanRetry)
+	require.Equal(t, storagemarket.StorageDealUnknown, inspection.AdvanceTo)
+
+	inspection, err = provider.InspectDeal(active)
+	require.NoError(t, err)
+	require.False(t, inspection.CanFail)
+	require.True(t, inspection.CanRetry)
+	require.Equal(t, storagemarket.StorageDealUnknown, inspection.AdvanceTo)
+
+	inspection, err = provider.InspectDeal(expired)
+	require.NoError(t, err)
+	require.False(t, inspection.CanFail)
+	require.False(t, inspection.CanRetry)
+
+	_, err = provider.InspectDeal(shared_testutil.GenerateCids(1)[0])
+	require.Error(t, err)
+
+	require.Equal(t, storageimpl.ErrReadOnly, provider.ForceFailDeal(funding, "stuck"))
+	require.Equal(t, storageimpl.ErrReadOnly, provider.ForceRetryDeal(funding, "stuck"))
+	require.Equal(t, storageimpl.ErrReadOnly, provider.ForceAdvanceDeal(funding, storagemarket.StorageDealPublish, "funds added"))
+}
+
+func TestReadOnlyProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fails before deals are migrated", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		_, err := storageimpl.NewReadOnlyProvider(ds, nil)
+		require.Error(t, err)
+
+		version, err := migrationtools.CurrentVersion(ds)
+		require.NoError(t, err)
+		require.Equal(t, "", string(version))
+	})
+
+	t.Run("lists deals without changing them", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		namespaced := shared_testutil.DatastoreAtVersion(t, ds, "1")
+		proposal := shared_testutil.MakeTestClientDealProposal()
+		proposalNd, err := cborutil.AsIpld(proposal)
+		require.NoError(t, err)
+		deal := storagemarket.MinerDeal{
+			ClientDealProposal: *proposal,
+			ProposalCid:        proposalNd.Cid(),
+			State:              storagemarket.StorageDealTransferring,
+			Ref: &storagemarket.DataRef{
+				TransferType: storagemarket.TTGraphsync,
+				Root:         shared_testutil.GenerateCids(1)[0],
+			},
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+
+		provider, err := storageimpl.NewReadOnlyProvider(ds, nil)
+		require.NoError(t, err)
+		shared_testutil.StartAndWaitForReady(ctx, t, provider)
+
+		deals, err := provider.ListLocalDeals()
+		require.NoError(t, err)
+		require.Len(t, deals, 1)
+		require.Equal(t, deal.ProposalCid, deals[0].ProposalCid)
+		require.Equal(t, storagemarket.StorageDealTransferring, deals[0].State)
+		require.Nil(t, provider.GetAsk())
+
+		err = provider.ImportDataForDeal(ctx, deal.ProposalCid, bytes.NewReader(nil))
+		require.Equal(t, storageimpl.ErrReadOnly, err)
+		err = provider.SetAsk(big.NewInt(1), big.NewInt(1), 100)
+		require.Equal(t, storageimpl.ErrReadOnly, err)
+		require.Equal(t, storageimpl.ErrReadOnly, provider.AddStorageCollateral(ctx, big.NewInt(1)))
+
+		stored, err := namespaced.Get(datastore.NewKey(deal.ProposalCid.String()))
+		require.NoError(t, err)
+		require.Equal(t, buf.Bytes(), stored)
+		require.NoError(t, provider.Stop())
+	})
+
+	t.Run("lists the deals of a provider that logs them", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		namespaced := shared_testutil.DatastoreAtVersion(t, eventstore.New(ds, 0), "1")
+		proposal := shared_testutil.MakeTestClientDealProposal()
+		proposalNd, err := cborutil.AsIpld(proposal)
+		require.NoError(t, err)
+		deal := storagemarket.MinerDeal{
+			ClientDealProposal: *proposal,
+			ProposalCid:        proposalNd.Cid(),
+			State:              storagemarket.StorageDealSealing,
+		}
+		buf := new(bytes.Buffer)
+		require.NoError(t, deal.MarshalCBOR(buf))
+		require.NoError(t, namespaced.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+
+		// logged deals are only read with the option
+		provider, err := storageimpl.NewReadOnlyProvider(ds, nil)
+		require.NoError(t, err)
+		deals, err := provider.ListLocalDeals()
+		require.NoError(t, err)
+		require.Empty(t, deals)
+
+		provider, err = storageimpl.NewReadOnlyProvider(ds, nil, storageimpl.LogProviderDeals(0))
+		require.NoError(t, err)
+		deals, err = provider.ListLocalDeals()
+		require.NoError(t, err)
+		require.Len(t, deals, 1)
+		require.Equal(t, storagemarket.StorageDealSealing, deals[0].State)
+	})
+}
+
+func TestProviderStats(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+		noOpDelay, noOpDelay)
+	providerDs := namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider"))
+
+	// stats recorded before the provider restarted
+	statsDs := namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider-stats"))
+	recorder, err := dealstats.New(statsDs)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Add("deals-accepted", 48))
+	require.NoError(t, recorder.Add("bytes-ingested", 2<<30))
+	require.NoError(t, recorder.Add("deals-activated", 3))
+	require.NoError(t, recorder.Add("timed-activations", 2))
+	require.NoError(t, recorder.Add("time-to-active", uint64(4*time.Hour)))
+
+	provider, err := storageimpl.NewProvider(
+		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+		providerDs,
+		deps.Fs,
+		deps.TestData.MultiStore2,
+		deps.PieceStore,
+		deps.DTProvider,
+		deps.ProviderNode,
+		deps.ProviderAddr,
+		deps.StoredAsk,
+		storageimpl.PersistStats(statsDs),
+	)
+	require.NoError(t, err)
+
+	stats := provider.Stats()
+	require.Equal(t, dealstats.DefaultWindow, stats.Window)
+	require.Equal(t, uint64(48), stats.DealsAccepted)
+	require.Equal(t, 2.0, stats.DealsAcceptedPerHour)
+	require.Equal(t, uint64(2<<30), stats.BytesIngested)
+	require.Equal(t, 2.0, stats.GiBIngestedPerDay)
+	require.Equal(t, uint64(3), stats.DealsActivated)
+	require.Equal(t, 2*time.Hour, stats.AverageTimeToActive)
+}
+
 func TestProvider_Migrations(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -127,6 +588,18 @@ func TestProvider_Migrations(t *testing.T) {
 		err = providerDs.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes())
 		require.NoError(t, err)
 	}
+
+	// a dry run reports every deal as it would be migrated, without migrating it
+	report, err := migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.True(t, report.Pending())
+	require.Len(t, report.Records, numDeals)
+	require.Zero(t, report.Failed)
+	for _, record := range report.Records {
+		require.IsType(t, &storagemarket.MinerDeal{}, record.Migrated)
+	}
+
+	backupDs := dss.MutexWrap(datastore.NewMapDatastore())
 	provider, err := storageimpl.NewProvider(
 		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
 		providerDs,
@@ -137,6 +610,7 @@ func TestProvider_Migrations(t *testing.T) {
 		deps.ProviderNode,
 		deps.ProviderAddr,
 		deps.StoredAsk,
+		storageimpl.MigrationBackup(backupDs),
 	)
 	require.NoError(t, err)
 
@@ -176,6 +650,18 @@ func TestProvider_Migrations(t *testing.T) {
 		}
 		require.Equal(t, expectedDeal, deal)
 	}
+
+	report, err = migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.False(t, report.Pending())
+
+	// rolling back restores the deals as they were before migration
+	err = migrationtools.Restore(providerDs, backupDs)
+	require.NoError(t, err)
+	report, err = migrations.DryRunProviderMigrations(providerDs)
+	require.NoError(t, err)
+	require.True(t, report.Pending())
+	require.Len(t, report.Records, numDeals)
 }
 
 func TestHandleDealStream(t *testing.T) {
@@ -254,4 +740,125 @@ func TestHandleDealStream(t *testing.T) {
 
 		require.Equal(t, 1, responseWriteCount)
 	})
+
+	t.Run("carries the invoice sent with a proposal to provider events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+			noOpDelay, noOpDelay)
+
+		provider, err := storageimpl.NewProvider(
+			network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+			namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider")),
+			deps.Fs,
+			deps.TestData.MultiStore2,
+			deps.PieceStore,
+			deps.DTProvider,
+			deps.ProviderNode,
+			deps.ProviderAddr,
+			deps.StoredAsk,
+		)
+		require.NoError(t, err)
+		impl := provider.(*storageimpl.Provider)
+		shared_testutil.StartAndWaitForReady(ctx, t, impl)
+
+		opened := make(chan storagemarket.MinerDeal, 1)
+		impl.SubscribeToEvents(func(event storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+			if event == storagemarket.ProviderEventOpen {
+				opened <- deal
+			}
+		})
+
+		invoice := &storagemarket.InvoiceMetadata{
+			InvoiceID:      "INV-0042",
+			Currency:       "USD",
+			QuoteReference: "quote-7",
+		}
+		s := shared_testutil.NewTestStorageDealStream(shared_testutil.TestStorageDealStreamParams{
+			ProposalReader: func() (network.Proposal, error) {
+				return network.Proposal{
+					DealProposal: shared_testutil.MakeTestClientDealProposal(),
+					Piece: &storagemarket.DataRef{
+						TransferType: storagemarket.TTManual,
+						Root:         shared_testutil.GenerateCids(1)[0],
+					},
+					Invoice: invoice,
+				}, nil
+			},
+		})
+		impl.HandleDealStream(s)
+
+		select {
+		case deal := <-opened:
+			require.Equal(t, invoice, deal.Invoice)
+		case <-ctx.Done():
+			t.Fatal("deal was not opened")
+		}
+	})
+}
+
+func TestAddPreAcceptedDeal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deps := dependencies.NewDependenciesWithTestData(t, ctx, shared_testutil.NewLibp2pTestData(ctx, t), testnodes.NewStorageMarketState(), "",
+		noOpDelay, noOpDelay)
+
+	provider, err := storageimpl.NewProvider(
+		network.NewFromLibp2pHost(deps.TestData.Host2, network.RetryParameters(0, 0, 0)),
+		namespace.Wrap(deps.TestData.Ds1, datastore.NewKey("/deals/provider")),
+		deps.Fs,
+		deps.TestData.MultiStore2,
+		deps.PieceStore,
+		deps.DTProvider,
+		deps.ProviderNode,
+		deps.ProviderAddr,
+		deps.StoredAsk,
+	)
+	require.NoError(t, err)
+	shared_testutil.StartAndWaitForReady(ctx, t, provider)
+
+	added := make(chan storagemarket.MinerDeal, 1)
+	provider.SubscribeToEvents(func(event storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+		if event == storagemarket.ProviderEventPreAcceptedDealAdded {
+			added <- deal
+		}
+	})
+
+	proposal := *shared_testutil.MakeTestClientDealProposal()
+	manualRef := &storagemarket.DataRef{
+		TransferType: storagemarket.TTManual,
+		Root:         shared_testutil.GenerateCids(1)[0],
+	}
+
+	proposalCid, err := provider.AddPreAcceptedDeal(ctx, proposal, manualRef, "")
+	require.NoError(t, err)
+	select {
+	case deal := <-added:
+		require.Equal(t, proposalCid, deal.ProposalCid)
+		require.Equal(t, storagemarket.StorageDealWaitingForData, deal.State)
+		require.Equal(t, manualRef, deal.Ref)
+	case <-ctx.Done():
+		t.Fatal("deal was not added")
+	}
+
+	_, err = provider.AddPreAcceptedDeal(ctx, proposal, manualRef, "")
+	require.Error(t, err, "deal is added twice")
+
+	otherProvider := *shared_testutil.MakeTestClientDealProposal()
+	otherProvider.Proposal.Provider = address.TestAddress
+	_, err = provider.AddPreAcceptedDeal(ctx, otherProvider, manualRef, "")
+	require.Error(t, err, "deal is for another provider")
+
+	graphsyncRef := &storagemarket.DataRef{
+		TransferType: storagemarket.TTGraphsync,
+		Root:         shared_testutil.GenerateCids(1)[0],
+	}
+	proposal.Proposal.Label = "no client peer"
+	_, err = provider.AddPreAcceptedDeal(ctx, proposal, graphsyncRef, "")
+	require.Error(t, err, "network transfer without a client peer")
+
+	deps.ProviderNode.VerifySignatureFails = true
+	proposal.Proposal.Label = "bad signature"
+	_, err = provider.AddPreAcceptedDeal(ctx, proposal, manualRef, "")
+	require.Error(t, err, "proposal signature does not verify")
 }
@@ -1,13 +1,18 @@
 package providerstates
 
 import (
+	"time"
+
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 
 	"github.com/filecoin-project/go-fil-markets/filestore"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
@@ -16,6 +21,7 @@ import (
 // ProviderEvents are the events that can happen in a storage provider
 var ProviderEvents = fsm.Events{
 	fsm.Event(storagemarket.ProviderEventOpen).From(storagemarket.StorageDealUnknown).To(storagemarket.StorageDealValidating),
+	fsm.Event(storagemarket.ProviderEventPreAcceptedDealAdded).From(storagemarket.StorageDealUnknown).To(storagemarket.StorageDealWaitingForData),
 	fsm.Event(storagemarket.ProviderEventNodeErrored).FromAny().To(storagemarket.StorageDealFailing).
 		Action(func(deal *storagemarket.MinerDeal, err error) error {
 			deal.Message = xerrors.Errorf("error calling node: %w", err).Error()
@@ -25,6 +31,11 @@ var ProviderEvents = fsm.Events{
 		FromMany(storagemarket.StorageDealValidating, storagemarket.StorageDealVerifyData, storagemarket.StorageDealAcceptWait).To(storagemarket.StorageDealRejecting).
 		Action(func(deal *storagemarket.MinerDeal, err error) error {
 			deal.Message = xerrors.Errorf("deal rejected: %w", err).Error()
+			deal.RetryAfter = 0
+			var retryLater *storagemarket.RetryLaterError
+			if xerrors.As(err, &retryLater) {
+				deal.RetryAfter = retryLater.RetryAfter
+			}
 			return nil
 		}),
 	fsm.Event(storagemarket.ProviderEventRejectionSent).
@@ -33,6 +44,24 @@ var ProviderEvents = fsm.Events{
 		From(storagemarket.StorageDealValidating).To(storagemarket.StorageDealAcceptWait),
 	fsm.Event(storagemarket.ProviderEventDataRequested).
 		From(storagemarket.StorageDealAcceptWait).To(storagemarket.StorageDealWaitingForData),
+	fsm.Event(storagemarket.ProviderEventExistingPieceFound).
+		From(storagemarket.StorageDealAcceptWait).To(storagemarket.StorageDealReserveProviderFunds).
+		Action(func(deal *storagemarket.MinerDeal) error {
+			deal.PieceReused = true
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventTransferQueued).
+		From(storagemarket.StorageDealAcceptWait).To(storagemarket.StorageDealTransferQueued).
+		Action(func(deal *storagemarket.MinerDeal, message string) error {
+			deal.Message = message
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventTransferSlotOpened).
+		From(storagemarket.StorageDealTransferQueued).To(storagemarket.StorageDealWaitingForData).
+		Action(func(deal *storagemarket.MinerDeal) error {
+			deal.Message = ""
+			return nil
+		}),
 	fsm.Event(storagemarket.ProviderEventDataTransferFailed).
 		From(storagemarket.StorageDealTransferring).To(storagemarket.StorageDealFailing).
 		Action(func(deal *storagemarket.MinerDeal, err error) error {
@@ -62,6 +91,18 @@ var ProviderEvents = fsm.Events{
 			return nil
 		}),
 
+	fsm.Event(storagemarket.ProviderEventRestartNegotiationFailed).
+		FromMany(
+			storagemarket.StorageDealWaitingForData,
+			storagemarket.StorageDealTransferring,
+			storagemarket.StorageDealProviderTransferRestart,
+		).
+		To(storagemarket.StorageDealFailing).
+		Action(func(deal *storagemarket.MinerDeal, reason string) error {
+			deal.Message = xerrors.Errorf("restarting deal: %s", reason).Error()
+			return nil
+		}),
+
 	fsm.Event(storagemarket.ProviderEventDataTransferStalled).
 		From(storagemarket.StorageDealTransferring).ToJustRecord().Action(func(deal *storagemarket.MinerDeal) error {
 		deal.Message = "data transfer appears to be stalled. attempt restart"
@@ -90,6 +131,12 @@ var ProviderEvents = fsm.Events{
 			deal.Message = xerrors.Errorf("deal data verification failed: %w", err).Error()
 			return nil
 		}),
+	fsm.Event(storagemarket.ProviderEventCommPSubmitted).
+		From(storagemarket.StorageDealVerifyData).ToJustRecord().
+		Action(func(deal *storagemarket.MinerDeal, jobID string) error {
+			deal.CommPJob = jobID
+			return nil
+		}),
 	fsm.Event(storagemarket.ProviderEventVerifiedData).
 		FromMany(storagemarket.StorageDealVerifyData, storagemarket.StorageDealWaitingForData).To(storagemarket.StorageDealReserveProviderFunds).
 		Action(func(deal *storagemarket.MinerDeal, path filestore.Path, metadataPath filestore.Path) error {
@@ -155,8 +202,9 @@ var ProviderEvents = fsm.Events{
 		}),
 	fsm.Event(storagemarket.ProviderEventDealHandedOff).
 		From(storagemarket.StorageDealStaged).To(storagemarket.StorageDealAwaitingPreCommit).
-		Action(func(deal *storagemarket.MinerDeal) error {
+		Action(func(deal *storagemarket.MinerDeal, payloadSize uint64) error {
 			deal.AvailableForRetrieval = true
+			deal.PayloadSize = payloadSize
 			return nil
 		}),
 	fsm.Event(storagemarket.ProviderEventDealPrecommitFailed).
@@ -202,6 +250,34 @@ var ProviderEvents = fsm.Events{
 		}),
 
 	fsm.Event(storagemarket.ProviderEventFailed).From(storagemarket.StorageDealFailing).To(storagemarket.StorageDealError),
+	fsm.Event(storagemarket.ProviderEventAwaitingResubmission).
+		From(storagemarket.StorageDealFailing).To(storagemarket.StorageDealProposalRetryWait).
+		Action(func(deal *storagemarket.MinerDeal) error {
+			// the client is given as long again as it was asked to wait to propose the deal
+			resubmitWait := time.Duration(2*deal.RetryAfter*builtin.EpochDurationSeconds) * time.Second
+			deal.ResubmitBy = storagemarket.OptionalTime(time.Now().Add(resubmitWait))
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventProposalResubmitted).
+		From(storagemarket.StorageDealProposalRetryWait).To(storagemarket.StorageDealValidating).
+		Action(func(deal *storagemarket.MinerDeal, storeID *multistore.StoreID) error {
+			deal.StoreID = storeID
+			deal.Message = ""
+			deal.RetryAfter = 0
+			deal.ResubmitBy = storagemarket.OptionalTime{}
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventResubmissionExpired).
+		From(storagemarket.StorageDealProposalRetryWait).To(storagemarket.StorageDealError).
+		Action(func(deal *storagemarket.MinerDeal) error {
+			deal.Message = xerrors.Errorf("client did not propose the deal again: %s", deal.Message).Error()
+			deal.RetryAfter = 0
+			deal.ResubmitBy = storagemarket.OptionalTime{}
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventResubmissionWaitElapsed).
+		From(storagemarket.StorageDealProposalRetryWait).ToNoChange().
+		FromAny().ToJustRecord(),
 	fsm.Event(storagemarket.ProviderEventRestart).
 		FromMany(storagemarket.StorageDealValidating, storagemarket.StorageDealAcceptWait, storagemarket.StorageDealRejecting).To(storagemarket.StorageDealError).
 		From(storagemarket.StorageDealTransferring).To(storagemarket.StorageDealProviderTransferRestart).
@@ -215,12 +291,15 @@ var ProviderEvents = fsm.Events{
 		}),
 	fsm.Event(storagemarket.ProviderEventFundsReserved).
 		From(storagemarket.StorageDealReserveProviderFunds).ToJustRecord().
-		Action(func(deal *storagemarket.MinerDeal, fundsReserved abi.TokenAmount) error {
+		Action(func(deal *storagemarket.MinerDeal, fundsReserved abi.TokenAmount, fundingWallet address.Address) error {
 			if deal.FundsReserved.Nil() {
 				deal.FundsReserved = fundsReserved
 			} else {
 				deal.FundsReserved = big.Add(deal.FundsReserved, fundsReserved)
 			}
+			if fundingWallet != address.Undef {
+				deal.FundingWallet = &fundingWallet
+			}
 			return nil
 		}),
 	fsm.Event(storagemarket.ProviderEventFundsReleased).
@@ -229,12 +308,41 @@ var ProviderEvents = fsm.Events{
 			deal.FundsReserved = big.Subtract(deal.FundsReserved, fundsReleased)
 			return nil
 		}),
+	fsm.Event(storagemarket.ProviderEventOperatorFailed).
+		FromMany(OperatorFailStates...).To(storagemarket.StorageDealFailing).
+		Action(func(deal *storagemarket.MinerDeal, reason string) error {
+			deal.Message = xerrors.Errorf("failed by operator: %s", reason).Error()
+			// the client is not asked to propose the deal again
+			deal.RetryAfter = 0
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventOperatorRetried).
+		FromMany(OperatorRetryStates...).ToNoChange(),
+	fsm.Event(storagemarket.ProviderEventOperatorAdvanced).
+		From(storagemarket.StorageDealTransferQueued).To(storagemarket.StorageDealWaitingForData).
+		From(storagemarket.StorageDealProviderFunding).To(storagemarket.StorageDealPublish).
+		Action(func(deal *storagemarket.MinerDeal, reason string) error {
+			deal.Message = xerrors.Errorf("advanced by operator: %s", reason).Error()
+			return nil
+		}),
+	fsm.Event(storagemarket.ProviderEventDataTransferUpdated).
+		FromAny().ToJustRecord().
+		Action(func(deal *storagemarket.MinerDeal, channelState datatransfer.ChannelState) error {
+			channelID := channelState.ChannelID()
+			deal.TransferChannelId = &channelID
+			deal.TransferStatus = channelState.Status()
+			deal.TransferQueued = channelState.Queued()
+			deal.TransferSent = channelState.Sent()
+			deal.TransferReceived = channelState.Received()
+			return nil
+		}),
 }
 
 // ProviderStateEntryFuncs are the handlers for different states in a storage client
 var ProviderStateEntryFuncs = fsm.StateEntryFuncs{
 	storagemarket.StorageDealValidating:              ValidateDealProposal,
 	storagemarket.StorageDealAcceptWait:              DecideOnProposal,
+	storagemarket.StorageDealTransferQueued:          WaitForTransferSlot,
 	storagemarket.StorageDealVerifyData:              VerifyData,
 	storagemarket.StorageDealReserveProviderFunds:    ReserveProviderFunds,
 	storagemarket.StorageDealProviderFunding:         WaitForFunding,
@@ -248,6 +356,7 @@ var ProviderStateEntryFuncs = fsm.StateEntryFuncs{
 	storagemarket.StorageDealActive:                  WaitForDealCompletion,
 	storagemarket.StorageDealFailing:                 FailDeal,
 	storagemarket.StorageDealProviderTransferRestart: RestartDataTransfer,
+	storagemarket.StorageDealProposalRetryWait:       WaitForResubmission,
 }
 
 // ProviderFinalityStates are the states that terminate deal processing for a deal.
@@ -257,3 +366,52 @@ var ProviderFinalityStates = []fsm.StateKey{
 	storagemarket.StorageDealSlashed,
 	storagemarket.StorageDealExpired,
 }
+
+// OperatorFailStates are the states an operator can force a deal to fail from. Deals
+// that are active on chain, or that already ended or are failing, cannot be failed
+var OperatorFailStates = []fsm.StateKey{
+	storagemarket.StorageDealValidating,
+	storagemarket.StorageDealAcceptWait,
+	storagemarket.StorageDealRejecting,
+	storagemarket.StorageDealTransferQueued,
+	storagemarket.StorageDealWaitingForData,
+	storagemarket.StorageDealTransferring,
+	storagemarket.StorageDealProviderTransferRestart,
+	storagemarket.StorageDealVerifyData,
+	storagemarket.StorageDealReserveProviderFunds,
+	storagemarket.StorageDealProviderFunding,
+	storagemarket.StorageDealPublish,
+	storagemarket.StorageDealPublishing,
+	storagemarket.StorageDealStaged,
+	storagemarket.StorageDealAwaitingPreCommit,
+	storagemarket.StorageDealSealing,
+	storagemarket.StorageDealProposalRetryWait,
+}
+
+// OperatorRetryStates are the states an operator can force the handler of to run
+// again. Their handlers can run more than once, as they do when the provider restarts
+var OperatorRetryStates = []fsm.StateKey{
+	storagemarket.StorageDealTransferQueued,
+	storagemarket.StorageDealProviderTransferRestart,
+	storagemarket.StorageDealVerifyData,
+	storagemarket.StorageDealReserveProviderFunds,
+	storagemarket.StorageDealProviderFunding,
+	storagemarket.StorageDealPublish,
+	storagemarket.StorageDealPublishing,
+	storagemarket.StorageDealStaged,
+	storagemarket.StorageDealAwaitingPreCommit,
+	storagemarket.StorageDealSealing,
+	storagemarket.StorageDealFinalizing,
+	storagemarket.StorageDealActive,
+	storagemarket.StorageDealFailing,
+}
+
+// OperatorAdvances are the states an operator can move a deal on from, to the state
+// the deal would have moved to once what it waits for happened. None of them need
+// anything the event the deal waits for would have recorded, nor assume anything
+// about the chain: deals waiting for their sector to be precommitted or sealed
+// are retried instead, so that the chain is checked again
+var OperatorAdvances = map[storagemarket.StorageDealStatus]storagemarket.StorageDealStatus{
+	storagemarket.StorageDealTransferQueued:  storagemarket.StorageDealWaitingForData,
+	storagemarket.StorageDealProviderFunding: storagemarket.StorageDealPublish,
+}
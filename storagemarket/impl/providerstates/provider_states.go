@@ -1,11 +1,11 @@
 package providerstates
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
@@ -13,6 +13,7 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	padreader "github.com/filecoin-project/go-padreader"
@@ -20,13 +21,18 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 	market2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/filestore"
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/selectors"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blindedlabel"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/deallog"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/dealrestart"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerutils"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
@@ -43,20 +49,76 @@ type ProviderDealEnvironment interface {
 	Address() address.Address
 	Node() storagemarket.StorageProviderNode
 	Ask() storagemarket.StorageAsk
+	AskAt(epoch abi.ChainEpoch) storagemarket.StorageAsk
+	AskGracePeriod() abi.ChainEpoch
 	DeleteStore(storeID multistore.StoreID) error
 	GeneratePieceCommitment(storeID *multistore.StoreID, payloadCid cid.Cid, selector ipld.Node) (cid.Cid, filestore.Path, error)
 	GeneratePieceReader(storeID *multistore.StoreID, payloadCid cid.Cid, selector ipld.Node) (io.ReadCloser, uint64, error, <-chan error)
+	CommPVerifier() (verifier storagemarket.CommPVerifier, pollInterval time.Duration)
+	// PieceHoldChecker returns the checker that spot checks sealed copies of a piece
+	// before a deal reuses one, or nil if they are not checked
+	PieceHoldChecker() storagemarket.PieceHoldChecker
 	SendSignedResponse(ctx context.Context, response *network.Response) error
 	Disconnect(proposalCid cid.Cid) error
 	FileStore() filestore.FileStore
 	PieceStore() piecestore.PieceStore
+	// AuthenticateClientPeer checks that the peer that proposed the deal acts for the
+	// deal's client address
+	AuthenticateClientPeer(ctx context.Context, deal storagemarket.MinerDeal, tok shared.TipSetToken) error
+	// StagePieceForRetrieval lets retrievals read a piece from its staged CAR
+	StagePieceForRetrieval(pieceCID cid.Cid, path filestore.Path)
+	// DeletePiece releases the deal's staged CAR, which is deleted once no other deal
+	// uses it and retrievals reading it have finished
+	DeletePiece(proposalCid cid.Cid, path filestore.Path) error
 	RunCustomDecisionLogic(context.Context, storagemarket.MinerDeal) (bool, string, error)
+	CollateralPolicy() storagemarket.CollateralPolicy
+	TransferSlot(deal storagemarket.MinerDeal) (bool, time.Time)
+	// FundingWallet returns the wallet to reserve a deal's collateral from, or false
+	// to reserve it from the miner's worker address
+	FundingWallet(deal storagemarket.MinerDeal) (address.Address, bool)
+	DryRun() bool
+	Maintenance(epoch abi.ChainEpoch) (bool, abi.ChainEpoch)
+	IntakePaused() (bool, string)
+	// AdmitToIntakeQuotas counts a deal against the provider's intake quotas. If the
+	// deal would exceed one it returns false, with the quota and the time the deal
+	// fits, which is zero if it never will
+	AdmitToIntakeQuotas(deal storagemarket.MinerDeal) (bool, time.Time, string)
+	AcceptsTransferType(transferType string) bool
+	RejectionRetryAfter() abi.ChainEpoch
+	NegotiateRestart(ctx context.Context, deal storagemarket.MinerDeal) (clientView network.DealView, providerView network.DealView, err error)
+	// WaitForPublishMessage waits for a publish message like the node's WaitForMessage,
+	// sharing one wait between the deals published in the same message
+	WaitForPublishMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error
+	// RecordDealLog keeps a log line emitted while handling a deal with the deal, so
+	// that it can be read back with GetDealLogs
+	RecordDealLog(proposalCid cid.Cid, level string, message string)
 	network.PeerTagger
 }
 
 // ProviderStateEntryFunc is the signature for a StateEntryFunc in the provider FSM
 type ProviderStateEntryFunc func(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error
 
+// dealInfof logs a line about a deal, and records it with the deal
+func dealInfof(environment ProviderDealEnvironment, deal storagemarket.MinerDeal, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Info(message)
+	environment.RecordDealLog(deal.ProposalCid, deallog.LevelInfo, message)
+}
+
+// dealWarnf logs a warning about a deal, and records it with the deal
+func dealWarnf(environment ProviderDealEnvironment, deal storagemarket.MinerDeal, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Warn(message)
+	environment.RecordDealLog(deal.ProposalCid, deallog.LevelWarn, message)
+}
+
+// dealErrorf logs an error about a deal, and records it with the deal
+func dealErrorf(environment ProviderDealEnvironment, deal storagemarket.MinerDeal, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Error(message)
+	environment.RecordDealLog(deal.ProposalCid, deallog.LevelError, message)
+}
+
 // ValidateDealProposal validates a proposed deal against the provider criteria
 func ValidateDealProposal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
 	environment.TagPeer(deal.Client, deal.ProposalCid.String())
@@ -66,20 +128,42 @@ func ValidateDealProposal(ctx fsm.Context, environment ProviderDealEnvironment,
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("node error getting most recent state id: %w", err))
 	}
 
+	if inMaintenance, until := environment.Maintenance(curEpoch); inMaintenance {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, maintenanceRejection(environment, curEpoch, until))
+	}
+
+	if paused, reason := environment.IntakePaused(); paused {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, retryLater(environment, reason))
+	}
+
 	if err := providerutils.VerifyProposal(ctx.Context(), deal.ClientDealProposal, tok, environment.Node().VerifySignature); err != nil {
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("verifying StorageDealProposal: %w", err))
 	}
 
+	if err := environment.AuthenticateClientPeer(ctx.Context(), deal, tok); err != nil {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("authenticating client peer: %w", err))
+	}
+
 	proposal := deal.Proposal
 
 	if proposal.Provider != environment.Address() {
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("incorrect provider for deal"))
 	}
 
+	if deal.Ref != nil && !environment.AcceptsTransferType(deal.Ref.TransferType) {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("transfer type %q is not accepted", deal.Ref.TransferType))
+	}
+
 	if len(proposal.Label) > DealMaxLabelSize {
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("deal label can be at most %d bytes, is %d", DealMaxLabelSize, len(proposal.Label)))
 	}
 
+	if blindedlabel.IsBlinded(proposal.Label) {
+		if _, err := blindedlabel.Decode(proposal.Label); err != nil {
+			return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("invalid blinded label: %w", err))
+		}
+	}
+
 	if err := proposal.PieceSize.Validate(); err != nil {
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("proposal piece size is invalid: %w", err))
 	}
@@ -118,25 +202,28 @@ func ValidateDealProposal(ctx fsm.Context, environment ProviderDealEnvironment,
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("proposed provider collateral above maximum: %s > %s", proposal.ProviderCollateral, pcMax))
 	}
 
-	askPrice := environment.Ask().Price
-	if deal.Proposal.VerifiedDeal {
-		askPrice = environment.Ask().VerifiedPrice
+	policyMin, policyMax, err := environment.CollateralPolicy().ProviderCollateralBounds(ctx.Context(), deal, pcMin, pcMax)
+	if err != nil {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("applying collateral policy: %w", err))
 	}
 
-	minPrice := big.Div(big.Mul(askPrice, abi.NewTokenAmount(int64(proposal.PieceSize))), abi.NewTokenAmount(1<<30))
-	if proposal.StoragePricePerEpoch.LessThan(minPrice) {
-		return ctx.Trigger(storagemarket.ProviderEventDealRejected,
-			xerrors.Errorf("storage price per epoch less than asking price: %s < %s", proposal.StoragePricePerEpoch, minPrice))
+	if proposal.ProviderCollateral.LessThan(policyMin) {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("proposed provider collateral below provider's minimum: %s < %s", proposal.ProviderCollateral, policyMin))
 	}
 
-	if proposal.PieceSize < environment.Ask().MinPieceSize {
-		return ctx.Trigger(storagemarket.ProviderEventDealRejected,
-			xerrors.Errorf("piece size less than minimum required size: %d < %d", proposal.PieceSize, environment.Ask().MinPieceSize))
+	if proposal.ProviderCollateral.GreaterThan(policyMax) {
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("proposed provider collateral above provider's maximum: %s > %s", proposal.ProviderCollateral, policyMax))
 	}
 
-	if proposal.PieceSize > environment.Ask().MaxPieceSize {
-		return ctx.Trigger(storagemarket.ProviderEventDealRejected,
-			xerrors.Errorf("piece size more than maximum allowed size: %d > %d", proposal.PieceSize, environment.Ask().MaxPieceSize))
+	ask := environment.Ask()
+	if err := checkAsk(ask, proposal); err != nil {
+		// the client may have proposed against the ask in effect just before
+		// the current one was set
+		previous := environment.AskAt(curEpoch - environment.AskGracePeriod())
+		if previous.SeqNo == ask.SeqNo || previous.Price.Nil() || checkAsk(previous, proposal) != nil {
+			return ctx.Trigger(storagemarket.ProviderEventDealRejected, err)
+		}
+		dealInfof(environment, deal, "deal %s meets ask %d in effect until recently, but not current ask %d", deal.ProposalCid, previous.SeqNo, ask.SeqNo)
 	}
 
 	// check market funds
@@ -148,7 +235,7 @@ func ValidateDealProposal(ctx fsm.Context, environment ProviderDealEnvironment,
 	// This doesn't guarantee that the client won't withdraw / lock those funds
 	// but it's a decent first filter
 	if clientMarketBalance.Available.LessThan(proposal.ClientBalanceRequirement()) {
-		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("clientMarketBalance.Available too small: %d < %d", clientMarketBalance.Available, proposal.ClientBalanceRequirement()))
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, retryLater(environment, fmt.Sprintf("clientMarketBalance.Available too small: %d < %d", clientMarketBalance.Available, proposal.ClientBalanceRequirement())))
 	}
 
 	// Verified deal checks
@@ -166,42 +253,229 @@ func ValidateDealProposal(ctx fsm.Context, environment ProviderDealEnvironment,
 		}
 	}
 
+	if deal.Ref != nil && deal.Ref.TransferType == storagemarket.TTExistingPiece {
+		if _, _, ok := existingPiece(ctx.Context(), environment, deal, true); !ok {
+			return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("provider cannot reuse an existing copy of piece %s", proposal.PieceCID))
+		}
+	}
+
+	// the deal is counted against the quotas only once every other check passes, and
+	// not at all in dry-run mode, where it is rejected once it is decided on
+	if !environment.DryRun() {
+		if ok, retryAt, quota := environment.AdmitToIntakeQuotas(deal); !ok {
+			return ctx.Trigger(storagemarket.ProviderEventDealRejected, quotaRejection(environment, quota, retryAt))
+		}
+	}
+
 	return ctx.Trigger(storagemarket.ProviderEventDealDeciding)
 }
 
+// quotaRejection rejects a deal that would exceed one of the provider's intake
+// quotas, asking the client to propose it again once it fits
+func quotaRejection(environment ProviderDealEnvironment, quota string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		return xerrors.Errorf("quota exceeded: %s, piece is too large to accept", quota)
+	}
+	reason := fmt.Sprintf("quota exceeded: %s, retry at %s", quota, retryAt.UTC().Format(time.RFC3339))
+	retryAfter := environment.RejectionRetryAfter()
+	if retryAfter > 0 {
+		epochDuration := time.Duration(builtin.EpochDurationSeconds) * time.Second
+		retryAfter = abi.ChainEpoch((time.Until(retryAt) + epochDuration - 1) / epochDuration)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+	}
+	return &storagemarket.RetryLaterError{Reason: reason, RetryAfter: retryAfter}
+}
+
+// retryLater rejects a deal for a transient reason, asking the client to propose it
+// again once the provider's retry interval has passed
+func retryLater(environment ProviderDealEnvironment, reason string) error {
+	return &storagemarket.RetryLaterError{Reason: reason, RetryAfter: environment.RejectionRetryAfter()}
+}
+
+// checkAsk checks that a proposal meets the price, piece size and deal duration limits of an ask
+func checkAsk(ask storagemarket.StorageAsk, proposal market.DealProposal) error {
+	askPrice := ask.Price
+	if proposal.VerifiedDeal {
+		askPrice = ask.VerifiedPrice
+	}
+
+	minPrice := big.Div(big.Mul(askPrice, abi.NewTokenAmount(int64(proposal.PieceSize))), abi.NewTokenAmount(1<<30))
+	if proposal.StoragePricePerEpoch.LessThan(minPrice) {
+		return xerrors.Errorf("storage price per epoch less than asking price: %s < %s", proposal.StoragePricePerEpoch, minPrice)
+	}
+
+	if proposal.PieceSize < ask.MinPieceSize {
+		return xerrors.Errorf("piece size less than minimum required size: %d < %d", proposal.PieceSize, ask.MinPieceSize)
+	}
+
+	if proposal.PieceSize > ask.MaxPieceSize {
+		return xerrors.Errorf("piece size more than maximum allowed size: %d > %d", proposal.PieceSize, ask.MaxPieceSize)
+	}
+
+	duration := proposal.EndEpoch - proposal.StartEpoch
+	if ask.MinDealDuration != 0 && duration < ask.MinDealDuration {
+		return xerrors.Errorf("deal duration less than minimum required duration: %d < %d", duration, ask.MinDealDuration)
+	}
+
+	if ask.MaxDealDuration != 0 && duration > ask.MaxDealDuration {
+		return xerrors.Errorf("deal duration more than maximum allowed duration: %d > %d", duration, ask.MaxDealDuration)
+	}
+	return nil
+}
+
+// maintenanceRejection rejects a deal because the provider is in maintenance. If the
+// provider knows when the maintenance ends, the client is asked to propose the deal
+// again once it has
+func maintenanceRejection(environment ProviderDealEnvironment, curEpoch abi.ChainEpoch, until abi.ChainEpoch) error {
+	reason := (&shared.MaintenanceError{Until: until}).Error()
+	retryAfter := environment.RejectionRetryAfter()
+	if retryAfter > 0 && until > curEpoch {
+		retryAfter = until - curEpoch
+	}
+	return &storagemarket.RetryLaterError{Reason: reason, RetryAfter: retryAfter}
+}
+
 // DecideOnProposal allows custom decision logic to run before accepting a deal, such as allowing a manual
 // operator to decide whether or not to accept the deal
 func DecideOnProposal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
 	accept, reason, err := environment.RunCustomDecisionLogic(ctx.Context(), deal)
+	var retry *storagemarket.RetryLaterError
+	if xerrors.As(err, &retry) && retry.RetryAfter == 0 {
+		err = retryLater(environment, err.Error())
+	}
 	if err != nil {
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("custom deal decision logic failed: %w", err))
 	}
 
 	if !accept {
+		if environment.DryRun() {
+			dealInfof(environment, deal, "dry-run: deal %s from client %s would be rejected: %s", deal.ProposalCid, deal.Client, reason)
+		}
 		return ctx.Trigger(storagemarket.ProviderEventDealRejected, fmt.Errorf(reason))
 	}
 
-	// Send intent to accept
-	err = environment.SendSignedResponse(ctx.Context(), &network.Response{
+	if environment.DryRun() {
+		dealInfof(environment, deal, "dry-run: deal %s from client %s would be accepted", deal.ProposalCid, deal.Client)
+		return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.New(storagemarket.DryRunRejectionReason))
+	}
+
+	// clients never send data for deals with an existing piece transfer, so the
+	// piece checked when the deal was validated must still be there
+	existing := deal.Ref != nil && deal.Ref.TransferType == storagemarket.TTExistingPiece
+	if existing {
+		if _, _, ok := existingPiece(ctx.Context(), environment, deal, false); !ok {
+			return ctx.Trigger(storagemarket.ProviderEventDealRejected, xerrors.Errorf("existing copy of piece %s is no longer available", deal.Proposal.PieceCID))
+		}
+	}
+
+	response := &network.Response{
 		State:    storagemarket.StorageDealWaitingForData,
 		Proposal: deal.ProposalCid,
-	})
+	}
+
+	// hold the deal until the client has a free transfer slot
+	queued := false
+	if deal.Ref != nil && deal.Ref.TransferType != storagemarket.TTManual && deal.Ref.TransferType != storagemarket.TTExistingPiece {
+		if ok, expectedStart := environment.TransferSlot(deal); !ok {
+			queued = true
+			response.State = storagemarket.StorageDealTransferQueued
+			response.Message = transferQueuedMessage(expectedStart)
+		}
+	}
+
+	// Send intent to accept
+	err = environment.SendSignedResponse(ctx.Context(), response)
 
 	if err != nil {
 		return ctx.Trigger(storagemarket.ProviderEventSendResponseFailed, err)
 	}
 
 	if err := environment.Disconnect(deal.ProposalCid); err != nil {
-		log.Warnf("closing client connection: %+v", err)
+		dealWarnf(environment, deal, "closing client connection: %+v", err)
+	}
+
+	if queued {
+		return ctx.Trigger(storagemarket.ProviderEventTransferQueued, response.Message)
+	}
+
+	if existing {
+		dealInfof(environment, deal, "deal %s is for piece %s, which is already sealed, skipping data transfer", deal.ProposalCid, deal.Proposal.PieceCID)
+		return ctx.Trigger(storagemarket.ProviderEventExistingPieceFound)
 	}
 
 	return ctx.Trigger(storagemarket.ProviderEventDataRequested)
 }
 
+// WaitForTransferSlot checks whether a deal whose transfer was queued can start
+// receiving data. Queued deals are otherwise started as transfers from the same
+// client finish
+func WaitForTransferSlot(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
+	if ok, _ := environment.TransferSlot(deal); ok {
+		return ctx.Trigger(storagemarket.ProviderEventTransferSlotOpened)
+	}
+	return nil
+}
+
+func transferQueuedMessage(expectedStart time.Time) string {
+	if expectedStart.IsZero() {
+		return "transfer queued behind other transfers from this client"
+	}
+	return fmt.Sprintf("transfer queued behind other transfers from this client, expected to start by %s", expectedStart.UTC().Format(time.RFC3339))
+}
+
+// existingPiece returns the location of a sealed copy of the deal's piece, if the
+// provider has one and its node can add deals to existing pieces. If check is true
+// and the provider has a PieceHoldChecker, only a copy that passes a spot check is
+// returned
+func existingPiece(ctx context.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal, check bool) (storagemarket.ExistingPieceNode, piecestore.DealInfo, bool) {
+	node, ok := environment.Node().(storagemarket.ExistingPieceNode)
+	if !ok {
+		return nil, piecestore.DealInfo{}, false
+	}
+	pieceInfo, err := environment.PieceStore().GetPieceInfo(deal.Proposal.PieceCID)
+	if err != nil || len(pieceInfo.Deals) == 0 {
+		return nil, piecestore.DealInfo{}, false
+	}
+	checker := environment.PieceHoldChecker()
+	if !check || checker == nil {
+		return node, pieceInfo.Deals[0], true
+	}
+	for _, existing := range pieceInfo.Deals {
+		err := checker.CheckPieceHeld(ctx, deal.Proposal.PieceCID, existing.SectorID, existing.Offset, existing.Length)
+		if err == nil {
+			return node, existing, true
+		}
+		dealWarnf(environment, deal, "copy of piece %s in sector %d failed spot check: %s", deal.Proposal.PieceCID, existing.SectorID, err)
+	}
+	return nil, piecestore.DealInfo{}, false
+}
+
 // VerifyData verifies that data received for a deal matches the pieceCID
 // in the proposal
 func VerifyData(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
-	pieceCid, metadataPath, err := environment.GeneratePieceCommitment(deal.StoreID, deal.Ref.Root, shared.AllSelector())
+	if verifier, pollInterval := environment.CommPVerifier(); verifier != nil {
+		// an external verifier takes as long as hashing the piece, so it is polled
+		// outside the state handler, which would otherwise hold up the deal's events
+		go func() {
+			pieceCid, err := externalCommP(ctx, environment, verifier, pollInterval, deal)
+			if err != nil && ctx.Context().Err() != nil {
+				// the provider is shutting down, and polls the job again when it restarts
+				return
+			}
+			_ = verifyPieceCid(ctx, deal, pieceCid, filestore.Path(""), err)
+		}()
+		return nil
+	}
+
+	pieceCid, metadataPath, err := environment.GeneratePieceCommitment(deal.StoreID, deal.Ref.Root, selectors.Entire())
+	return verifyPieceCid(ctx, deal, pieceCid, metadataPath, err)
+}
+
+// verifyPieceCid checks the piece commitment generated for a deal's data matches
+// the proposal
+func verifyPieceCid(ctx fsm.Context, deal storagemarket.MinerDeal, pieceCid cid.Cid, metadataPath filestore.Path, err error) error {
 	if err != nil {
 		return ctx.Trigger(storagemarket.ProviderEventDataVerificationFailed, xerrors.Errorf("error generating CommP: %w", err), filestore.Path(""), filestore.Path(""))
 	}
@@ -214,6 +488,48 @@ func VerifyData(ctx fsm.Context, environment ProviderDealEnvironment, deal stora
 	return ctx.Trigger(storagemarket.ProviderEventVerifiedData, filestore.Path(""), metadataPath)
 }
 
+// externalCommP computes the piece commitment of a deal's data on an external
+// CommPVerifier, submitting the job if it was not submitted before a restart, and
+// polling until the job is done
+func externalCommP(ctx fsm.Context, environment ProviderDealEnvironment, verifier storagemarket.CommPVerifier, pollInterval time.Duration, deal storagemarket.MinerDeal) (cid.Cid, error) {
+	jobID := deal.CommPJob
+	if jobID == "" {
+		proofType, err := environment.Node().GetProofType(ctx.Context(), deal.Proposal.Provider, nil)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("getting proof type: %w", err)
+		}
+		jobID, err = verifier.Submit(ctx.Context(), storagemarket.CommPRequest{
+			ProposalCid: deal.ProposalCid,
+			PayloadCID:  deal.Ref.Root,
+			StoreID:     deal.StoreID,
+			ProofType:   proofType,
+			PieceSize:   deal.Proposal.PieceSize,
+		})
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("submitting to external verifier: %w", err)
+		}
+		_ = ctx.Trigger(storagemarket.ProviderEventCommPSubmitted, jobID)
+	}
+
+	for {
+		result, err := verifier.Poll(ctx.Context(), jobID)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("polling external verifier: %w", err)
+		}
+		if result.Done {
+			if result.Error != "" {
+				return cid.Undef, xerrors.Errorf("external verifier: %s", result.Error)
+			}
+			return result.PieceCID, nil
+		}
+		select {
+		case <-ctx.Context().Done():
+			return cid.Undef, ctx.Context().Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
 // ReserveProviderFunds adds funds, as needed to the StorageMarketActor, so the miner has adequate collateral for the deal
 func ReserveProviderFunds(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
 	node := environment.Node()
@@ -223,9 +539,20 @@ func ReserveProviderFunds(ctx fsm.Context, environment ProviderDealEnvironment,
 		return ctx.Trigger(storagemarket.ProviderEventNodeErrored, xerrors.Errorf("acquiring chain head: %w", err))
 	}
 
-	waddr, err := node.GetMinerWorkerAddress(ctx.Context(), deal.Proposal.Provider, tok)
-	if err != nil {
-		return ctx.Trigger(storagemarket.ProviderEventNodeErrored, xerrors.Errorf("looking up miner worker: %w", err))
+	// a deal restarted before it was funded keeps the wallet it was first given
+	fundingWallet := address.Undef
+	if deal.FundingWallet != nil {
+		fundingWallet = *deal.FundingWallet
+	} else if wallet, ok := environment.FundingWallet(deal); ok {
+		fundingWallet = wallet
+	}
+
+	waddr := fundingWallet
+	if waddr == address.Undef {
+		waddr, err = node.GetMinerWorkerAddress(ctx.Context(), deal.Proposal.Provider, tok)
+		if err != nil {
+			return ctx.Trigger(storagemarket.ProviderEventNodeErrored, xerrors.Errorf("looking up miner worker: %w", err))
+		}
 	}
 
 	mcid, err := node.ReserveFunds(ctx.Context(), waddr, deal.Proposal.Provider, deal.Proposal.ProviderCollateral)
@@ -233,7 +560,7 @@ func ReserveProviderFunds(ctx fsm.Context, environment ProviderDealEnvironment,
 		return ctx.Trigger(storagemarket.ProviderEventNodeErrored, xerrors.Errorf("reserving funds: %w", err))
 	}
 
-	_ = ctx.Trigger(storagemarket.ProviderEventFundsReserved, deal.Proposal.ProviderCollateral)
+	_ = ctx.Trigger(storagemarket.ProviderEventFundsReserved, deal.Proposal.ProviderCollateral, fundingWallet)
 
 	// if no message was sent, and there was no error, funds were already available
 	if mcid == cid.Undef {
@@ -276,10 +603,12 @@ func PublishDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal stor
 	return ctx.Trigger(storagemarket.ProviderEventDealPublishInitiated, mcid)
 }
 
-// RestartDataTransfer restarts a data transfer that was earlier initiated by the client
+// RestartDataTransfer negotiates with the client how to resume a deal that was
+// transferring data when the provider restarted. If the client cannot be reached,
+// it restarts the data transfer that was earlier initiated by the client. The data
+// transfer for a deal whose data is sent by a transfer agent is restarted without
+// asking the client
 func RestartDataTransfer(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
-	log.Infof("restarting data transfer for deal %s", deal.ProposalCid)
-
 	if deal.TransferChannelId == nil {
 		return ctx.Trigger(storagemarket.ProviderEventDataTransferRestartFailed, xerrors.New("channelId on provider deal is nil"))
 	}
@@ -287,6 +616,16 @@ func RestartDataTransfer(ctx fsm.Context, environment ProviderDealEnvironment, d
 	// We need to do this in a goroutine as `environment.RestartDataTransfer` calls `GetSync` on the state machine under the hood
 	// and we should NEVER call `GetSync` in the call stack for a state handler as it causes a deadlock.
 	go func() {
+		if deal.Ref != nil && deal.Ref.TransferAgent != "" {
+			// the client's transfer agent sends the data, so there is nothing to
+			// negotiate with the client
+			dealInfof(environment, deal, "deal %s has its data sent by transfer agent %s", deal.ProposalCid, deal.Ref.TransferAgent)
+		} else if !negotiateRestart(ctx, environment, deal) {
+			return
+		}
+
+		dealInfof(environment, deal, "restarting data transfer for deal %s", deal.ProposalCid)
+
 		// restart the push data transfer. This will complete asynchronously and the
 		// completion of the data transfer will trigger a change in deal state
 		err := environment.RestartDataTransfer(ctx.Context(),
@@ -300,42 +639,100 @@ func RestartDataTransfer(ctx fsm.Context, environment ProviderDealEnvironment, d
 	return nil
 }
 
+// negotiateRestart asks the client how to resume a deal, and returns true if the
+// provider should restart the data transfer itself
+func negotiateRestart(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) bool {
+	clientView, providerView, err := environment.NegotiateRestart(ctx.Context(), deal)
+	if err != nil {
+		// the client may be offline or may not support restart negotiation, fall back to
+		// restarting the transfer
+		dealWarnf(environment, deal, "negotiating restart of deal %s with client: %s", deal.ProposalCid, err)
+		return true
+	}
+	resolution, reason := dealrestart.Reconcile(clientView, providerView)
+	dealInfof(environment, deal, "resuming deal %s after restart: %s", deal.ProposalCid, resolution)
+	switch resolution {
+	case dealrestart.ResolutionFail:
+		_ = ctx.Trigger(storagemarket.ProviderEventRestartNegotiationFailed, reason)
+		return false
+	case dealrestart.ResolutionRestartTransfer:
+		// a client that is transferring restarts the transfer itself, which moves the
+		// deal back to transferring. Otherwise the client is still starting the
+		// transfer, and the provider restarts it
+		return clientView.State != storagemarket.StorageDealTransferring
+	}
+	return true
+}
+
 // WaitForPublish waits for the publish message on chain and sends the deal id back to the client
 func WaitForPublish(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
-	return environment.Node().WaitForMessage(ctx.Context(), *deal.PublishCid, func(code exitcode.ExitCode, retBytes []byte, finalCid cid.Cid, err error) error {
+	return environment.WaitForPublishMessage(ctx.Context(), *deal.PublishCid, func(code exitcode.ExitCode, retBytes []byte, finalCid cid.Cid, err error) error {
 		if err != nil {
 			return ctx.Trigger(storagemarket.ProviderEventDealPublishError, xerrors.Errorf("PublishStorageDeals errored: %w", err))
 		}
 		if code != exitcode.Ok {
 			return ctx.Trigger(storagemarket.ProviderEventDealPublishError, xerrors.Errorf("PublishStorageDeals exit code: %s", code.String()))
 		}
-		var retval market.PublishStorageDealsReturn
-		err = retval.UnmarshalCBOR(bytes.NewReader(retBytes))
+		retval, err := providerutils.DecodePublishReturn(retBytes)
 		if err != nil {
 			return ctx.Trigger(storagemarket.ProviderEventDealPublishError, xerrors.Errorf("PublishStorageDeals error unmarshalling result: %w", err))
 		}
 
+		dealID, err := publishedDealID(ctx.Context(), environment, deal, finalCid, retval)
+		if err != nil {
+			return ctx.Trigger(storagemarket.ProviderEventDealPublishError, err)
+		}
+
 		releaseReservedFunds(ctx, environment, deal)
 
-		return ctx.Trigger(storagemarket.ProviderEventDealPublished, retval.IDs[0], finalCid)
+		return ctx.Trigger(storagemarket.ProviderEventDealPublished, dealID, finalCid)
 	})
 }
 
+// publishedDealID finds the ID of a deal in the return value of the message that
+// published it, from the deal's position among the proposals in the message. A deal
+// the market actor dropped from the message fails on its own
+func publishedDealID(ctx context.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal, publishCid cid.Cid, retval providerutils.PublishReturn) (abi.DealID, error) {
+	proposals, err := environment.Node().GetPublishedProposals(ctx, publishCid)
+	if err != nil {
+		return 0, xerrors.Errorf("looking up proposals in publish message %s: %w", publishCid, err)
+	}
+	for i := range proposals {
+		proposalNd, err := cborutil.AsIpld(&proposals[i])
+		if err != nil {
+			return 0, xerrors.Errorf("getting cid of published proposal: %w", err)
+		}
+		if proposalNd.Cid().Equals(deal.ProposalCid) {
+			return retval.DealID(i)
+		}
+	}
+	return 0, xerrors.Errorf("deal proposal %s is not in publish message %s", deal.ProposalCid, publishCid)
+}
+
 // HandoffDeal hands off a published deal for sealing and commitment in a sector
 func HandoffDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
 	var packingInfo *storagemarket.PackingResult
 	var packingErr error
-	if deal.PiecePath != filestore.Path("") {
+	var payloadSize uint64
+	if deal.PieceReused {
+		node, existing, ok := existingPiece(ctx.Context(), environment, deal, true)
+		if !ok {
+			return ctx.Trigger(storagemarket.ProviderEventDealHandoffFailed, xerrors.Errorf("existing copy of piece %s is no longer available", deal.Proposal.PieceCID))
+		}
+		packingInfo, packingErr = node.OnDealCompleteWithExistingPiece(ctx.Context(), deal, existing.SectorID, existing.Offset, existing.Length)
+	} else if deal.PiecePath != filestore.Path("") {
 		file, err := environment.FileStore().Open(deal.PiecePath)
 		if err != nil {
 			return ctx.Trigger(storagemarket.ProviderEventFileStoreErrored, xerrors.Errorf("reading piece at path %s: %w", deal.PiecePath, err))
 		}
-		packingInfo, packingErr = handoffDeal(ctx.Context(), environment, deal, file, uint64(file.Size()))
+		payloadSize = uint64(file.Size())
+		packingInfo, packingErr = handoffDeal(ctx.Context(), environment, deal, file, payloadSize)
 	} else {
-		pieceReader, pieceSize, err, writeErrChan := environment.GeneratePieceReader(deal.StoreID, deal.Ref.Root, shared.AllSelector())
+		pieceReader, pieceSize, err, writeErrChan := environment.GeneratePieceReader(deal.StoreID, deal.Ref.Root, selectors.Entire())
 		if err != nil {
 			return ctx.Trigger(storagemarket.ProviderEventDealHandoffFailed, err)
 		}
+		payloadSize = pieceSize
 		packingInfo, packingErr = handoffDeal(ctx.Context(), environment, deal, pieceReader, pieceSize)
 		err = pieceReader.Close()
 		if err != nil {
@@ -356,11 +753,14 @@ func HandoffDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal stor
 	}
 
 	if err := recordPiece(environment, deal, packingInfo.SectorNumber, packingInfo.Offset, packingInfo.Size); err != nil {
-		log.Errorf("failed to register deal data for retrieval: %s", err)
+		dealErrorf(environment, deal, "failed to register deal data for retrieval: %s", err)
 		_ = ctx.Trigger(storagemarket.ProviderEventPieceStoreErrored, err)
+	} else if !deal.PieceReused && deal.PiecePath != filestore.Path("") {
+		// until the sector is sealed, retrievals are served from the staged CAR
+		environment.StagePieceForRetrieval(deal.Proposal.PieceCID, deal.PiecePath)
 	}
 
-	return ctx.Trigger(storagemarket.ProviderEventDealHandedOff)
+	return ctx.Trigger(storagemarket.ProviderEventDealHandedOff, payloadSize)
 }
 
 func handoffDeal(ctx context.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal, reader io.Reader, size uint64) (*storagemarket.PackingResult, error) {
@@ -376,6 +776,7 @@ func handoffDeal(ctx context.Context, environment ProviderDealEnvironment, deal
 			PublishCid:         deal.PublishCid,
 			DealID:             deal.DealID,
 			FastRetrieval:      deal.FastRetrieval,
+			Invoice:            deal.Invoice,
 		},
 		paddedSize,
 		paddedReader,
@@ -417,21 +818,21 @@ func recordPiece(environment ProviderDealEnvironment, deal storagemarket.MinerDe
 // CleanupDeal clears the filestore once we know the mining component has read the data and it is in a sealed sector
 func CleanupDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
 	if deal.PiecePath != "" {
-		err := environment.FileStore().Delete(deal.PiecePath)
+		err := environment.DeletePiece(deal.ProposalCid, deal.PiecePath)
 		if err != nil {
-			log.Warnf("deleting piece at path %s: %w", deal.PiecePath, err)
+			dealWarnf(environment, deal, "deleting piece at path %s: %s", deal.PiecePath, err)
 		}
 	}
 	if deal.MetadataPath != "" {
 		err := environment.FileStore().Delete(deal.MetadataPath)
 		if err != nil {
-			log.Warnf("deleting piece at path %s: %w", deal.MetadataPath, err)
+			dealWarnf(environment, deal, "deleting piece at path %s: %s", deal.MetadataPath, err)
 		}
 	}
 	if deal.StoreID != nil {
 		err := environment.DeleteStore(*deal.StoreID)
 		if err != nil {
-			log.Warnf("deleting store %d: %w", deal.StoreID, err)
+			dealWarnf(environment, deal, "deleting store %d: %s", deal.StoreID, err)
 		}
 	}
 
@@ -517,10 +918,13 @@ func WaitForDealCompletion(ctx fsm.Context, environment ProviderDealEnvironment,
 
 // RejectDeal sends a failure response before terminating a deal
 func RejectDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
+	dealInfof(environment, deal, "rejecting deal %s: %s", deal.ProposalCid, deal.Message)
+
 	err := environment.SendSignedResponse(ctx.Context(), &network.Response{
-		State:    storagemarket.StorageDealFailing,
-		Message:  deal.Message,
-		Proposal: deal.ProposalCid,
+		State:      storagemarket.StorageDealFailing,
+		Message:    deal.Message,
+		Proposal:   deal.ProposalCid,
+		RetryAfter: deal.RetryAfter,
 	})
 
 	if err != nil {
@@ -528,7 +932,7 @@ func RejectDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal stora
 	}
 
 	if err := environment.Disconnect(deal.ProposalCid); err != nil {
-		log.Warnf("closing client connection: %+v", err)
+		dealWarnf(environment, deal, "closing client connection: %+v", err)
 	}
 
 	return ctx.Trigger(storagemarket.ProviderEventRejectionSent)
@@ -536,39 +940,69 @@ func RejectDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal stora
 
 // FailDeal cleans up before terminating a deal
 func FailDeal(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
-	log.Warnf("deal %s failed: %s", deal.ProposalCid, deal.Message)
+	dealWarnf(environment, deal, "deal %s failed: %s", deal.ProposalCid, deal.Message)
 
 	environment.UntagPeer(deal.Client, deal.ProposalCid.String())
 
 	if deal.PiecePath != filestore.Path("") {
-		err := environment.FileStore().Delete(deal.PiecePath)
+		err := environment.DeletePiece(deal.ProposalCid, deal.PiecePath)
 		if err != nil {
-			log.Warnf("deleting piece at path %s: %w", deal.PiecePath, err)
+			dealWarnf(environment, deal, "deleting piece at path %s: %s", deal.PiecePath, err)
 		}
 	}
 	if deal.MetadataPath != filestore.Path("") {
 		err := environment.FileStore().Delete(deal.MetadataPath)
 		if err != nil {
-			log.Warnf("deleting piece at path %s: %w", deal.MetadataPath, err)
+			dealWarnf(environment, deal, "deleting piece at path %s: %s", deal.MetadataPath, err)
 		}
 	}
 	if deal.StoreID != nil {
 		err := environment.DeleteStore(*deal.StoreID)
 		if err != nil {
-			log.Warnf("deleting store id %d: %w", *deal.StoreID, err)
+			dealWarnf(environment, deal, "deleting store id %d: %s", *deal.StoreID, err)
 		}
 	}
 	releaseReservedFunds(ctx, environment, deal)
 
+	// a deal rejected for a transient reason can be proposed again
+	if deal.RetryAfter > 0 {
+		return ctx.Trigger(storagemarket.ProviderEventAwaitingResubmission)
+	}
+
 	return ctx.Trigger(storagemarket.ProviderEventFailed)
 }
 
+// WaitForResubmission fails a deal the provider asked the client to propose again
+// later, if the client has not proposed it again by the deal's ResubmitBy time. A
+// proposal sent again in time moves the deal on before then
+func WaitForResubmission(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) error {
+	wait := time.Until(deal.ResubmitBy.Time())
+	if wait <= 0 {
+		return ctx.Trigger(storagemarket.ProviderEventResubmissionExpired)
+	}
+
+	// the deal may be proposed again, and even wait again, before the timer fires, so
+	// the deadline is checked again rather than failing the deal straight away
+	t := time.NewTimer(wait)
+	go func() {
+		select {
+		case <-t.C:
+			_ = ctx.Trigger(storagemarket.ProviderEventResubmissionWaitElapsed)
+		case <-ctx.Context().Done():
+			t.Stop()
+			return
+		}
+	}()
+
+	return nil
+}
+
 func releaseReservedFunds(ctx fsm.Context, environment ProviderDealEnvironment, deal storagemarket.MinerDeal) {
 	if !deal.FundsReserved.Nil() && !deal.FundsReserved.IsZero() {
 		err := environment.Node().ReleaseFunds(ctx.Context(), deal.Proposal.Provider, deal.FundsReserved)
 		if err != nil {
 			// nonfatal error
-			log.Warnf("failed to release funds: %s", err)
+			dealWarnf(environment, deal, "failed to release funds: %s", err)
 		}
 		_ = ctx.Trigger(storagemarket.ProviderEventFundsReleased, deal.FundsReserved)
 	}
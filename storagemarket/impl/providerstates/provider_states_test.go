@@ -17,9 +17,11 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -37,7 +39,9 @@ import (
 	"github.com/filecoin-project/go-fil-markets/shared"
 	tut "github.com/filecoin-project/go-fil-markets/shared_testutil"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blindedlabel"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/blockrecorder"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/collateral"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/testnodes"
@@ -56,6 +60,8 @@ func TestValidateDealProposal(t *testing.T) {
 	invalidLabelBytes := make([]byte, 257)
 	rand.Read(invalidLabelBytes)
 	invalidLabel := base64.StdEncoding.EncodeToString(invalidLabelBytes)
+	blindedLabel, _, err := blindedlabel.New([]byte("client secret"), tut.GenerateCids(1)[0])
+	require.NoError(t, err)
 
 	tests := map[string]struct {
 		nodeParams        nodeParams
@@ -81,6 +87,15 @@ func TestValidateDealProposal(t *testing.T) {
 				require.Equal(t, "deal rejected: verifying StorageDealProposal: could not verify signature", deal.Message)
 			},
 		},
+		"client peer not authenticated": {
+			environmentParams: environmentParams{
+				ClientPeerError: errors.New("proposal has no peer signature"),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: authenticating client peer: proposal has no peer signature", deal.Message)
+			},
+		},
 		"provider address does not match": {
 			environmentParams: environmentParams{
 				Address: otherAddr,
@@ -108,6 +123,60 @@ func TestValidateDealProposal(t *testing.T) {
 				require.Equal(t, "deal rejected: storage price per epoch less than asking price: 5000 < 9765", deal.Message)
 			},
 		},
+		"PricePerEpoch meets ask changed within grace period": {
+			dealParams: dealParams{
+				StoragePricePerEpoch: abi.NewTokenAmount(5000),
+			},
+			environmentParams: environmentParams{
+				Ask:            currentAsk,
+				PreviousAsk:    previousAsk,
+				AskGracePeriod: 10,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+				require.Equal(t, []abi.ChainEpoch{defaultHeight - 10}, env.askAtEpochs)
+			},
+		},
+		"PricePerEpoch too low for ask changed within grace period": {
+			dealParams: dealParams{
+				StoragePricePerEpoch: abi.NewTokenAmount(3000),
+			},
+			environmentParams: environmentParams{
+				Ask:            currentAsk,
+				PreviousAsk:    previousAsk,
+				AskGracePeriod: 10,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: storage price per epoch less than asking price: 3000 < 9765", deal.Message)
+			},
+		},
+		"provider collateral above collateral policy": {
+			environmentParams: environmentParams{
+				CollateralPolicy: collateral.ChainMinimum(),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: proposed provider collateral above provider's maximum: 10000 > 5000", deal.Message)
+			},
+		},
+		"provider collateral within collateral policy": {
+			environmentParams: environmentParams{
+				CollateralPolicy: collateral.FixedMultiple(2),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+			},
+		},
+		"collateral policy errors": {
+			environmentParams: environmentParams{
+				CollateralPolicy: &errorCollateralPolicy{errors.New("something went wrong")},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: applying collateral policy: something went wrong", deal.Message)
+			},
+		},
 		"PieceSize < MinPieceSize": {
 			dealParams: dealParams{
 				PieceSize: abi.PaddedPieceSize(128),
@@ -117,6 +186,36 @@ func TestValidateDealProposal(t *testing.T) {
 				require.Equal(t, "deal rejected: piece size less than minimum required size: 128 < 256", deal.Message)
 			},
 		},
+		"deal duration < MinDealDuration": {
+			environmentParams: environmentParams{
+				Ask: storagemarket.StorageAsk{
+					Price:           defaultAsk.Price,
+					VerifiedPrice:   defaultAsk.VerifiedPrice,
+					MinPieceSize:    defaultAsk.MinPieceSize,
+					MaxPieceSize:    defaultAsk.MaxPieceSize,
+					MinDealDuration: defaultEndEpoch - defaultStartEpoch + 1,
+				},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: deal duration less than minimum required duration: 576000 < 576001", deal.Message)
+			},
+		},
+		"deal duration > MaxDealDuration": {
+			environmentParams: environmentParams{
+				Ask: storagemarket.StorageAsk{
+					Price:           defaultAsk.Price,
+					VerifiedPrice:   defaultAsk.VerifiedPrice,
+					MinPieceSize:    defaultAsk.MinPieceSize,
+					MaxPieceSize:    defaultAsk.MaxPieceSize,
+					MaxDealDuration: defaultEndEpoch - defaultStartEpoch - 1,
+				},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: deal duration more than maximum allowed duration: 576000 > 575999", deal.Message)
+			},
+		},
 		"Get balance error": {
 			nodeParams: nodeParams{
 				ClientMarketBalanceError: errors.New("could not get balance"),
@@ -135,6 +234,104 @@ func TestValidateDealProposal(t *testing.T) {
 				require.True(t, strings.Contains(deal.Message, "deal rejected: clientMarketBalance.Available too small"))
 			},
 		},
+		"Not enough funds asks client to retry later": {
+			nodeParams: nodeParams{
+				ClientMarketBalance: big.NewInt(200*10000 - 1),
+			},
+			environmentParams: environmentParams{
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, abi.ChainEpoch(30), deal.RetryAfter)
+			},
+		},
+		"Provider in maintenance": {
+			environmentParams: environmentParams{
+				Maintenance:         true,
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: provider is in maintenance", deal.Message)
+				require.Equal(t, abi.ChainEpoch(30), deal.RetryAfter)
+			},
+		},
+		"Provider in maintenance window asks client to retry once it ends": {
+			environmentParams: environmentParams{
+				Maintenance:         true,
+				MaintenanceUntil:    defaultHeight + 100,
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: provider is in maintenance until epoch 150", deal.Message)
+				require.Equal(t, abi.ChainEpoch(100), deal.RetryAfter)
+			},
+		},
+		"Provider in maintenance with final rejections": {
+			environmentParams: environmentParams{
+				Maintenance:      true,
+				MaintenanceUntil: defaultHeight + 100,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, abi.ChainEpoch(0), deal.RetryAfter)
+			},
+		},
+		"Provider low on disk space": {
+			environmentParams: environmentParams{
+				IntakePaused:        "provider is low on disk space",
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: provider is low on disk space", deal.Message)
+				require.Equal(t, abi.ChainEpoch(30), deal.RetryAfter)
+			},
+		},
+		"Intake quota exceeded asks client to retry once the deal fits": {
+			environmentParams: environmentParams{
+				QuotaExceeded:       "at most 10 deals per hour",
+				QuotaRetryAt:        time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: quota exceeded: at most 10 deals per hour, retry at 2030-01-01T00:00:00Z", deal.Message)
+				require.True(t, deal.RetryAfter > 30)
+			},
+		},
+		"Intake quota exceeded by a deal that never fits": {
+			environmentParams: environmentParams{
+				QuotaExceeded:       "at most 100 bytes per day",
+				RejectionRetryAfter: 30,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: quota exceeded: at most 100 bytes per day, piece is too large to accept", deal.Message)
+				require.Equal(t, abi.ChainEpoch(0), deal.RetryAfter)
+			},
+		},
+		"dry run does not count against intake quotas": {
+			environmentParams: environmentParams{
+				DryRun:        true,
+				QuotaExceeded: "at most 10 deals per hour",
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+				require.Zero(t, env.quotaAdmissions)
+			},
+		},
+		"transfer type not accepted": {
+			environmentParams: environmentParams{
+				TransferTypes: []string{storagemarket.TTManual},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: transfer type \"graphsync\" is not accepted", deal.Message)
+			},
+		},
 		"Not enough funds due to client collateral": {
 			nodeParams: nodeParams{
 				ClientMarketBalance: big.NewInt(200*10000 + 99),
@@ -204,6 +401,78 @@ func TestValidateDealProposal(t *testing.T) {
 				require.Equal(t, "deal rejected: deal label can be at most 256 bytes, is 344", deal.Message)
 			},
 		},
+		"blinded label": {
+			dealParams: dealParams{
+				Label: blindedLabel,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+			},
+		},
+		"malformed blinded label": {
+			dealParams: dealParams{
+				Label: blindedlabel.Prefix + "uAAAA",
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: invalid blinded label: blinded label commitment must be 32 bytes, is 3", deal.Message)
+			},
+		},
+		"existing piece": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+				ExistingPiece:     &existingPieceInfo,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+			},
+		},
+		"existing piece not found": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, fmt.Sprintf("deal rejected: provider cannot reuse an existing copy of piece %s", deal.Proposal.PieceCID), deal.Message)
+			},
+		},
+		"existing piece that fails spot check": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+				ExistingPiece:     &existingPieceInfo,
+				PieceHoldChecker:  &fakePieceHoldChecker{failing: map[abi.SectorNumber]bool{3: true}},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, fmt.Sprintf("deal rejected: provider cannot reuse an existing copy of piece %s", deal.Proposal.PieceCID), deal.Message)
+				require.Equal(t, []abi.SectorNumber{3}, env.pieceHoldChecker.checked)
+			},
+		},
+		"existing piece with another copy that passes spot check": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+				ExistingPiece: &piecestore.PieceInfo{
+					Deals: append([]piecestore.DealInfo{{DealID: abi.DealID(5), SectorID: abi.SectorNumber(2), Length: abi.PaddedPieceSize(1 << 10)}}, existingPieceInfo.Deals...),
+				},
+				PieceHoldChecker: &fakePieceHoldChecker{failing: map[abi.SectorNumber]bool{2: true}},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAcceptWait, deal.State)
+				require.Equal(t, []abi.SectorNumber{2, 3}, env.pieceHoldChecker.checked)
+			},
+		},
 		"invalid piece size": {
 			dealParams: dealParams{
 				PieceSize: 129,
@@ -307,21 +576,212 @@ func TestDecideOnProposal(t *testing.T) {
 			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
 				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
 				require.Equal(t, "deal rejected: custom deal decision logic failed: I can't make up my mind", deal.Message)
+				require.Equal(t, abi.ChainEpoch(0), deal.RetryAfter)
+			},
+		},
+		"Custom Decision asks client to retry later": {
+			environmentParams: environmentParams{
+				DecisionError:       &storagemarket.RetryLaterError{Reason: "at capacity"},
+				RejectionRetryAfter: 20,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: custom deal decision logic failed: at capacity", deal.Message)
+				require.Equal(t, abi.ChainEpoch(20), deal.RetryAfter)
+			},
+		},
+		"SendSignedResponse errors": {
+			environmentParams: environmentParams{
+				SendSignedResponseError: errors.New("could not send"),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, "sending response to deal: could not send", deal.Message)
+			},
+		},
+		"Dry run rejects accepted deal": {
+			environmentParams: environmentParams{
+				DryRun: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: "+storagemarket.DryRunRejectionReason, deal.Message)
+			},
+		},
+		"Dry run keeps custom rejection reason": {
+			environmentParams: environmentParams{
+				DryRun:       true,
+				RejectDeal:   true,
+				RejectReason: "I just don't like it",
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, "deal rejected: I just don't like it", deal.Message)
+			},
+		},
+		"deal for existing piece skips transfer": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExistingPiece:     &existingPieceInfo,
+				ExpectPieceLookup: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealReserveProviderFunds, deal.State)
+				require.True(t, deal.PieceReused)
+			},
+		},
+		"deal for existing piece that is no longer available": {
+			dealParams: dealParams{
+				DataRef: &existingPieceDataRef,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealRejecting, deal.State)
+				require.Equal(t, fmt.Sprintf("deal rejected: existing copy of piece %s is no longer available", deal.Proposal.PieceCID), deal.Message)
+			},
+		},
+		"manual deal for existing piece waits for data": {
+			dealParams: dealParams{
+				DataRef: &storagemarket.DataRef{
+					Root:         defaultDataRef.Root,
+					TransferType: storagemarket.TTManual,
+				},
+			},
+			environmentParams: environmentParams{
+				ExistingPiece: &existingPieceInfo,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealWaitingForData, deal.State)
+				require.False(t, deal.PieceReused)
+			},
+		},
+		"transfer queued": {
+			environmentParams: environmentParams{
+				TransferQueued:        true,
+				TransferExpectedStart: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealTransferQueued, deal.State)
+				require.Equal(t, "transfer queued behind other transfers from this client, expected to start by 2021-01-02T03:04:05Z", deal.Message)
+			},
+		},
+		"transfer queued without an estimate": {
+			environmentParams: environmentParams{
+				TransferQueued: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealTransferQueued, deal.State)
+				require.Equal(t, "transfer queued behind other transfers from this client", deal.Message)
+			},
+		},
+		"manual transfers are not queued": {
+			dealParams: dealParams{
+				DataRef: &storagemarket.DataRef{
+					Root:         defaultDataRef.Root,
+					TransferType: storagemarket.TTManual,
+				},
+			},
+			environmentParams: environmentParams{
+				TransferQueued: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealWaitingForData, deal.State)
+				require.Equal(t, 0, env.transferSlotCalls)
+			},
+		},
+		"graphsync deal for existing piece still transfers data": {
+			environmentParams: environmentParams{
+				ExistingPiece: &existingPieceInfo,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealWaitingForData, deal.State)
+				require.False(t, deal.PieceReused)
+			},
+		},
+	}
+	for test, data := range tests {
+		t.Run(test, func(t *testing.T) {
+			runDecideOndeal(t, data.nodeParams, data.environmentParams, data.dealParams, data.fileStoreParams, data.pieceStoreParams, data.dealInspector)
+		})
+	}
+}
+
+func TestWaitForTransferSlot(t *testing.T) {
+	ctx := context.Background()
+	eventProcessor, err := fsm.NewEventProcessor(storagemarket.MinerDeal{}, "State", providerstates.ProviderEvents)
+	require.NoError(t, err)
+	runWaitForTransferSlot := makeExecutor(ctx, eventProcessor, providerstates.WaitForTransferSlot, storagemarket.StorageDealTransferQueued)
+	tests := map[string]struct {
+		nodeParams        nodeParams
+		dealParams        dealParams
+		environmentParams environmentParams
+		fileStoreParams   tut.TestFileStoreParams
+		pieceStoreParams  tut.TestPieceStoreParams
+		dealInspector     func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment)
+	}{
+		"slot open": {
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealWaitingForData, deal.State)
+			},
+		},
+		"still queued": {
+			environmentParams: environmentParams{
+				TransferQueued: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealTransferQueued, deal.State)
+			},
+		},
+	}
+	for test, data := range tests {
+		t.Run(test, func(t *testing.T) {
+			runWaitForTransferSlot(t, data.nodeParams, data.environmentParams, data.dealParams, data.fileStoreParams, data.pieceStoreParams, data.dealInspector)
+		})
+	}
+}
+
+func TestWaitForResubmission(t *testing.T) {
+	ctx := context.Background()
+	eventProcessor, err := fsm.NewEventProcessor(storagemarket.MinerDeal{}, "State", providerstates.ProviderEvents)
+	require.NoError(t, err)
+	runWaitForResubmission := makeExecutor(ctx, eventProcessor, providerstates.WaitForResubmission, storagemarket.StorageDealProposalRetryWait)
+	tests := map[string]struct {
+		nodeParams        nodeParams
+		dealParams        dealParams
+		environmentParams environmentParams
+		fileStoreParams   tut.TestFileStoreParams
+		pieceStoreParams  tut.TestPieceStoreParams
+		dealInspector     func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment)
+	}{
+		"waits for the client": {
+			dealParams: dealParams{
+				RetryAfter: 20,
+				ResubmitBy: time.Now().Add(time.Hour),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealProposalRetryWait, deal.State)
 			},
 		},
-		"SendSignedResponse errors": {
-			environmentParams: environmentParams{
-				SendSignedResponseError: errors.New("could not send"),
+		"fails once the client is too late": {
+			dealParams: dealParams{
+				RetryAfter: 20,
+				ResubmitBy: time.Now().Add(-time.Minute),
 			},
 			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
-				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
-				require.Equal(t, "sending response to deal: could not send", deal.Message)
+				tut.AssertDealState(t, storagemarket.StorageDealError, deal.State)
+				require.Equal(t, "client did not propose the deal again: ", deal.Message)
+				require.Zero(t, deal.RetryAfter)
+				require.True(t, deal.ResubmitBy.IsZero())
 			},
 		},
 	}
 	for test, data := range tests {
 		t.Run(test, func(t *testing.T) {
-			runDecideOndeal(t, data.nodeParams, data.environmentParams, data.dealParams, data.fileStoreParams, data.pieceStoreParams, data.dealInspector)
+			runWaitForResubmission(t, data.nodeParams, data.environmentParams, data.dealParams, data.fileStoreParams, data.pieceStoreParams, data.dealInspector)
 		})
 	}
 }
@@ -332,6 +792,20 @@ func TestVerifyData(t *testing.T) {
 	require.NoError(t, err)
 	expMetaPath := filestore.Path("somemetadata.txt")
 	runVerifyData := makeExecutor(ctx, eventProcessor, providerstates.VerifyData, storagemarket.StorageDealVerifyData)
+	externalVerifier := &fakeCommPVerifier{
+		jobID: "job-1",
+		results: []storagemarket.CommPResult{
+			{Done: false},
+			{Done: true, PieceCID: defaultPieceCid},
+		},
+	}
+	resumedVerifier := &fakeCommPVerifier{
+		results: []storagemarket.CommPResult{{Done: true, PieceCID: defaultPieceCid}},
+	}
+	failingVerifier := &fakeCommPVerifier{
+		jobID:   "job-1",
+		results: []storagemarket.CommPResult{{Done: true, Error: "out of disk"}},
+	}
 	tests := map[string]struct {
 		nodeParams        nodeParams
 		dealParams        dealParams
@@ -371,6 +845,53 @@ func TestVerifyData(t *testing.T) {
 				require.Equal(t, expMetaPath, deal.MetadataPath)
 			},
 		},
+		"succeeds with external verifier": {
+			environmentParams: environmentParams{
+				GenerateCommPError: errors.New("should not generate CommP"),
+				CommPVerifier:      externalVerifier,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealReserveProviderFunds, deal.State)
+				require.Equal(t, "job-1", deal.CommPJob)
+				require.Equal(t, filestore.Path(""), deal.MetadataPath)
+				require.Len(t, externalVerifier.submitted, 1)
+				require.Equal(t, deal.ProposalCid, externalVerifier.submitted[0].ProposalCid)
+				require.Equal(t, deal.Ref.Root, externalVerifier.submitted[0].PayloadCID)
+				require.Equal(t, deal.Proposal.PieceSize, externalVerifier.submitted[0].PieceSize)
+				require.Equal(t, []string{"job-1", "job-1"}, externalVerifier.polled)
+			},
+		},
+		"resumes a job submitted before restart": {
+			dealParams: dealParams{
+				CommPJob: "job-0",
+			},
+			environmentParams: environmentParams{
+				CommPVerifier: resumedVerifier,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealReserveProviderFunds, deal.State)
+				require.Empty(t, resumedVerifier.submitted)
+				require.Equal(t, []string{"job-0"}, resumedVerifier.polled)
+			},
+		},
+		"external verifier job fails": {
+			environmentParams: environmentParams{
+				CommPVerifier: failingVerifier,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, "deal data verification failed: error generating CommP: external verifier: out of disk", deal.Message)
+			},
+		},
+		"submitting to external verifier fails": {
+			environmentParams: environmentParams{
+				CommPVerifier: &fakeCommPVerifier{submitError: errors.New("unavailable")},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, "deal data verification failed: error generating CommP: submitting to external verifier: unavailable", deal.Message)
+			},
+		},
 	}
 	for test, data := range tests {
 		t.Run(test, func(t *testing.T) {
@@ -425,6 +946,10 @@ func TestReserveProviderFunds(t *testing.T) {
 	require.NoError(t, err)
 	runReserveProviderFunds := makeExecutor(ctx, eventProcessor, providerstates.ReserveProviderFunds, storagemarket.StorageDealReserveProviderFunds)
 	cids := tut.GenerateCids(1)
+	fundingWallet, err := address.NewIDAddress(301)
+	require.NoError(t, err)
+	otherFundingWallet, err := address.NewIDAddress(302)
+	require.NoError(t, err)
 	tests := map[string]struct {
 		nodeParams        nodeParams
 		dealParams        dealParams
@@ -480,6 +1005,40 @@ func TestReserveProviderFunds(t *testing.T) {
 				require.True(t, deal.FundsReserved.Nil())
 			},
 		},
+		"funds from the wallet picked for the deal": {
+			nodeParams: nodeParams{
+				// the worker address is not needed
+				MinerWorkerError: errors.New("could not get worker"),
+			},
+			environmentParams: environmentParams{
+				FundingWallet: fundingWallet,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealPublish, deal.State)
+				require.Equal(t, []address.Address{fundingWallet}, env.node.ReserveFundsWallets)
+				require.Equal(t, &fundingWallet, deal.FundingWallet)
+			},
+		},
+		"keeps the wallet a restarted deal was given": {
+			dealParams: dealParams{
+				FundingWallet: &otherFundingWallet,
+			},
+			environmentParams: environmentParams{
+				FundingWallet: fundingWallet,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealPublish, deal.State)
+				require.Equal(t, []address.Address{otherFundingWallet}, env.node.ReserveFundsWallets)
+				require.Equal(t, &otherFundingWallet, deal.FundingWallet)
+			},
+		},
+		"funds from the worker address without a wallet": {
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealPublish, deal.State)
+				require.Len(t, env.node.ReserveFundsWallets, 1)
+				require.Nil(t, deal.FundingWallet)
+			},
+		},
 	}
 	for test, data := range tests {
 		t.Run(test, func(t *testing.T) {
@@ -514,6 +1073,40 @@ func TestRestartDataTransfer(t *testing.T) {
 				tut.AssertDealState(t, storagemarket.StorageDealProviderTransferRestart, deal.State)
 			},
 		},
+		"waits for client to restart transfer": {
+			dealParams: dealParams{
+				TransferChannelId: &channelId,
+			},
+			environmentParams: environmentParams{
+				ClientView: &network.DealView{
+					State:             storagemarket.StorageDealTransferring,
+					TransferChannelID: &channelId,
+				},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				require.Never(t, func() bool {
+					return len(env.restartDataTransferCalls) > 0
+				}, time.Second, 200*time.Millisecond)
+				tut.AssertDealState(t, storagemarket.StorageDealProviderTransferRestart, deal.State)
+			},
+		},
+		"restarts a transfer the client is still starting": {
+			dealParams: dealParams{
+				TransferChannelId: &channelId,
+			},
+			environmentParams: environmentParams{
+				ClientView: &network.DealView{
+					State:             storagemarket.StorageDealStartDataTransfer,
+					TransferChannelID: &channelId,
+				},
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				require.Eventually(t, func() bool {
+					return len(env.restartDataTransferCalls) == 1
+				}, 5*time.Second, 200*time.Millisecond)
+				tut.AssertDealState(t, storagemarket.StorageDealProviderTransferRestart, deal.State)
+			},
+		},
 		// TODO FIXME
 		/*"RestartDataTransfer errors": {
 			dealParams: dealParams{
@@ -625,6 +1218,72 @@ func TestWaitForPublish(t *testing.T) {
 				require.Equal(t, "PublishStorageDeal error: PublishStorageDeals exit code: SysErrForbidden(8)", deal.Message)
 			},
 		},
+		"succeeds for a deal published with others": {
+			nodeParams: nodeParams{
+				WaitForMessageRetBytes: encodePublishReturn(t, []abi.DealID{10, 11, 12}, nil),
+			},
+			environmentParams: environmentParams{
+				PublishedProposals: 3,
+				PublishedIndex:     2,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealStaged, deal.State)
+				require.Equal(t, abi.DealID(12), deal.DealID)
+			},
+		},
+		"succeeds when other deals are dropped from the message": {
+			nodeParams: nodeParams{
+				WaitForMessageRetBytes: encodePublishReturn(t, []abi.DealID{20, 22}, []uint64{0, 2}),
+			},
+			environmentParams: environmentParams{
+				PublishedProposals: 3,
+				PublishedIndex:     2,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealStaged, deal.State)
+				require.Equal(t, abi.DealID(22), deal.DealID)
+			},
+		},
+		"fails a deal dropped from the message": {
+			dealParams: dealParams{
+				ReserveFunds: true,
+			},
+			nodeParams: nodeParams{
+				WaitForMessageRetBytes: encodePublishReturn(t, []abi.DealID{20, 22}, []uint64{0, 2}),
+			},
+			environmentParams: environmentParams{
+				PublishedProposals: 3,
+				PublishedIndex:     1,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, "PublishStorageDeal error: deal was dropped from the publish message as invalid: proposal 1 of the message", deal.Message)
+				require.Equal(t, abi.DealID(0), deal.DealID)
+			},
+		},
+		"fails a deal missing from the message": {
+			nodeParams: nodeParams{
+				WaitForMessageRetBytes: encodePublishReturn(t, []abi.DealID{10, 11}, nil),
+			},
+			environmentParams: environmentParams{
+				PublishedProposals: 2,
+				PublishedIndex:     -1,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, fmt.Sprintf("PublishStorageDeal error: deal proposal %s is not in publish message %s", deal.ProposalCid, deal.PublishCid), deal.Message)
+			},
+		},
+		"fails when the proposals in the message cannot be read": {
+			nodeParams: nodeParams{
+				WaitForMessageRetBytes:     encodePublishReturn(t, []abi.DealID{10, 11}, nil),
+				GetPublishedProposalsError: errors.New("node unavailable"),
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Equal(t, fmt.Sprintf("PublishStorageDeal error: looking up proposals in publish message %s: node unavailable", deal.PublishCid), deal.Message)
+			},
+		},
 	}
 	for test, data := range tests {
 		t.Run(test, func(t *testing.T) {
@@ -660,6 +1319,38 @@ func TestHandoffDeal(t *testing.T) {
 				require.Len(t, env.node.OnDealCompleteCalls, 1)
 				require.True(t, env.node.OnDealCompleteCalls[0].FastRetrieval)
 				require.True(t, deal.AvailableForRetrieval)
+				require.Equal(t, uint64(400), deal.PayloadSize)
+				require.Equal(t, map[cid.Cid]filestore.Path{deal.Proposal.PieceCID: defaultPath}, env.stagedPieces)
+			},
+		},
+		"succeeds with existing piece": {
+			dealParams: dealParams{
+				PieceReused:   true,
+				FastRetrieval: true,
+			},
+			environmentParams: environmentParams{
+				ExistingPiece:     &existingPieceInfo,
+				ExpectPieceLookup: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealAwaitingPreCommit, deal.State)
+				require.Len(t, env.node.OnDealCompleteCalls, 0)
+				require.Len(t, env.node.ExistingPieceCalls, 1)
+				require.True(t, deal.AvailableForRetrieval)
+				require.Zero(t, deal.PayloadSize)
+			},
+		},
+		"existing piece no longer available": {
+			dealParams: dealParams{
+				PieceReused: true,
+			},
+			environmentParams: environmentParams{
+				ExpectPieceLookup: true,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Len(t, env.node.ExistingPieceCalls, 0)
+				require.Equal(t, fmt.Sprintf("handing off deal to node: existing copy of piece %s is no longer available", deal.Proposal.PieceCID), deal.Message)
 			},
 		},
 		"succeed, assemble piece on demand": {
@@ -671,6 +1362,7 @@ func TestHandoffDeal(t *testing.T) {
 				require.Len(t, env.node.OnDealCompleteCalls, 1)
 				require.True(t, env.node.OnDealCompleteCalls[0].FastRetrieval)
 				require.True(t, deal.AvailableForRetrieval)
+				require.Equal(t, uint64(defaultPieceSize), deal.PayloadSize)
 			},
 		},
 		"succeeds w metadata": {
@@ -703,6 +1395,7 @@ func TestHandoffDeal(t *testing.T) {
 			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
 				tut.AssertDealState(t, storagemarket.StorageDealAwaitingPreCommit, deal.State)
 				require.Equal(t, fmt.Sprintf("recording piece for retrieval: failed to load block locations: file not found"), deal.Message)
+				require.Empty(t, env.stagedPieces)
 			},
 		},
 		"add piece block locations errors": {
@@ -1034,6 +1727,16 @@ func TestRejectDeal(t *testing.T) {
 				require.Equal(t, 1, env.disconnectCalls)
 			},
 		},
+		"sends when to retry": {
+			dealParams: dealParams{
+				RetryAfter: 20,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealFailing, deal.State)
+				require.Len(t, env.sentResponses, 1)
+				require.Equal(t, abi.ChainEpoch(20), env.sentResponses[0].RetryAfter)
+			},
+		},
 		"fails if it cannot send a response": {
 			environmentParams: environmentParams{
 				SendSignedResponseError: xerrors.New("error sending response"),
@@ -1067,6 +1770,20 @@ func TestFailDeal(t *testing.T) {
 		"succeeds": {
 			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
 				tut.AssertDealState(t, storagemarket.StorageDealError, deal.State)
+				require.Len(t, env.dealLogs, 1)
+				require.Equal(t, storagemarket.DealLogEntry{
+					Level:   "warn",
+					Message: fmt.Sprintf("deal %s failed: %s", deal.ProposalCid, deal.Message),
+				}, env.dealLogs[0])
+			},
+		},
+		"waits for the proposal to be resent after a transient rejection": {
+			dealParams: dealParams{
+				RetryAfter: 20,
+			},
+			dealInspector: func(t *testing.T, deal storagemarket.MinerDeal, env *fakeEnvironment) {
+				tut.AssertDealState(t, storagemarket.StorageDealProposalRetryWait, deal.State)
+				require.True(t, deal.ResubmitBy.Time().After(time.Now()))
 			},
 		},
 		"succeeds, funds released": {
@@ -1119,6 +1836,13 @@ var defaultDataRef = storagemarket.DataRef{
 	Root:         tut.GenerateCids(1)[0],
 	TransferType: storagemarket.TTGraphsync,
 }
+var existingPieceDataRef = storagemarket.DataRef{
+	Root:         defaultDataRef.Root,
+	TransferType: storagemarket.TTExistingPiece,
+}
+var existingPieceInfo = piecestore.PieceInfo{
+	Deals: []piecestore.DealInfo{{DealID: abi.DealID(7), SectorID: abi.SectorNumber(3), Offset: 0, Length: abi.PaddedPieceSize(1 << 10)}},
+}
 var defaultClientMarketBalance = big.Mul(big.NewInt(int64(defaultEndEpoch-defaultStartEpoch)), defaultStoragePricePerEpoch)
 
 var defaultAsk = storagemarket.StorageAsk{
@@ -1128,6 +1852,21 @@ var defaultAsk = storagemarket.StorageAsk{
 	MaxPieceSize:  1 << 20,
 }
 
+var currentAsk = storagemarket.StorageAsk{
+	Price:         abi.NewTokenAmount(10000000),
+	VerifiedPrice: abi.NewTokenAmount(1000000),
+	MinPieceSize:  abi.PaddedPieceSize(256),
+	MaxPieceSize:  1 << 20,
+	SeqNo:         1,
+}
+
+var previousAsk = storagemarket.StorageAsk{
+	Price:         abi.NewTokenAmount(4000000),
+	VerifiedPrice: abi.NewTokenAmount(1000000),
+	MinPieceSize:  abi.PaddedPieceSize(256),
+	MaxPieceSize:  1 << 20,
+}
+
 var testData = tut.NewTestIPLDTree()
 var dataBuf = new(bytes.Buffer)
 var blockLocationBuf = new(bytes.Buffer)
@@ -1143,6 +1882,49 @@ var defaultMetadataFile = tut.NewTestFile(tut.TestFileParams{
 	Size:   400,
 })
 
+func TestOperatorEvents(t *testing.T) {
+	ctx := context.Background()
+	eventProcessor, err := fsm.NewEventProcessor(storagemarket.MinerDeal{}, "State", providerstates.ProviderEvents)
+	require.NoError(t, err)
+	apply := func(t *testing.T, deal storagemarket.MinerDeal, event storagemarket.ProviderEvent, args ...interface{}) storagemarket.MinerDeal {
+		fsmCtx := fsmtest.NewTestContext(ctx, eventProcessor)
+		require.NoError(t, fsmCtx.Trigger(event, args...))
+		fsmCtx.ReplayEvents(t, &deal)
+		return deal
+	}
+
+	t.Run("fails deals", func(t *testing.T) {
+		for _, state := range providerstates.OperatorFailStates {
+			deal := apply(t, storagemarket.MinerDeal{State: state.(storagemarket.StorageDealStatus), RetryAfter: 10}, storagemarket.ProviderEventOperatorFailed, "sector was lost")
+			require.Equal(t, storagemarket.StorageDealFailing, deal.State)
+			require.Equal(t, "failed by operator: sector was lost", deal.Message)
+			require.Zero(t, deal.RetryAfter)
+		}
+	})
+
+	t.Run("retries deals in their state", func(t *testing.T) {
+		for _, state := range providerstates.OperatorRetryStates {
+			deal := apply(t, storagemarket.MinerDeal{State: state.(storagemarket.StorageDealStatus)}, storagemarket.ProviderEventOperatorRetried)
+			require.Equal(t, state, deal.State)
+		}
+	})
+
+	t.Run("advances deals to the next state", func(t *testing.T) {
+		for from, to := range providerstates.OperatorAdvances {
+			deal := apply(t, storagemarket.MinerDeal{State: from}, storagemarket.ProviderEventOperatorAdvanced, "checked by hand")
+			require.Equal(t, to, deal.State)
+			require.Equal(t, "advanced by operator: checked by hand", deal.Message)
+		}
+	})
+
+	t.Run("does not advance deals waiting on chain", func(t *testing.T) {
+		for _, state := range []storagemarket.StorageDealStatus{storagemarket.StorageDealAwaitingPreCommit, storagemarket.StorageDealSealing} {
+			_, ok := providerstates.OperatorAdvances[state]
+			require.False(t, ok)
+		}
+	})
+}
+
 func generatePublishDealsReturn(t *testing.T) (abi.DealID, []byte) {
 	dealId := abi.DealID(rand.Uint64())
 
@@ -1154,6 +1936,26 @@ func generatePublishDealsReturn(t *testing.T) (abi.DealID, []byte) {
 	return dealId, psdReturnBytes.Bytes()
 }
 
+// encodePublishReturn encodes the return value of a PublishStorageDeals message,
+// listing the valid deals like later versions of the market actor if valid is set
+func encodePublishReturn(t *testing.T, ids []abi.DealID, valid []uint64) []byte {
+	buf := new(bytes.Buffer)
+	fields := uint64(1)
+	if valid != nil {
+		fields = 2
+	}
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajArray, fields))
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajArray, uint64(len(ids))))
+	for _, id := range ids {
+		require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajUnsignedInt, uint64(id)))
+	}
+	if valid != nil {
+		validDeals := bitfield.NewFromSet(valid)
+		require.NoError(t, validDeals.MarshalCBOR(buf))
+	}
+	return buf.Bytes()
+}
+
 type nodeParams struct {
 	MinerAddr                           address.Address
 	MinerWorkerError                    error
@@ -1187,6 +1989,7 @@ type nodeParams struct {
 	OnDealSlashedEpoch                  abi.ChainEpoch
 	DataCap                             *verifreg.DataCap
 	GetDataCapError                     error
+	GetPublishedProposalsError          error
 }
 
 type dealParams struct {
@@ -1206,6 +2009,11 @@ type dealParams struct {
 	ReserveFunds         bool
 	TransferChannelId    *datatransfer.ChannelID
 	Label                string
+	PieceReused          bool
+	RetryAfter           abi.ChainEpoch
+	ResubmitBy           time.Time
+	CommPJob             string
+	FundingWallet        *address.Address
 }
 
 type environmentParams struct {
@@ -1226,6 +2034,42 @@ type environmentParams struct {
 	RejectReason                string
 	DecisionError               error
 	RestartDataTransferError    error
+	DryRun                      bool
+	Maintenance                 bool
+	MaintenanceUntil            abi.ChainEpoch
+	IntakePaused                string
+	RejectionRetryAfter         abi.ChainEpoch
+	// QuotaExceeded is the intake quota a deal exceeds, if it is set
+	QuotaExceeded string
+	QuotaRetryAt  time.Time
+	// TransferTypes are the transfer types the provider accepts, or nil to accept any
+	TransferTypes []string
+	// PreviousAsk is returned for the ask in effect at earlier epochs, if it is set
+	PreviousAsk           storagemarket.StorageAsk
+	AskGracePeriod        abi.ChainEpoch
+	CollateralPolicy      storagemarket.CollateralPolicy
+	TransferQueued        bool
+	TransferExpectedStart time.Time
+	// ExistingPiece is stubbed in the piece store as a sealed copy of the deal's piece
+	ExistingPiece *piecestore.PieceInfo
+	// ExpectPieceLookup expects the deal's piece to be looked up in the piece store
+	ExpectPieceLookup bool
+	// ClientView is the client's view of the deal returned by restart negotiation.
+	// If it is nil the client is treated as unreachable
+	ClientView *network.DealView
+	// CommPVerifier is the external verifier piece commitments are offloaded to, if set
+	CommPVerifier storagemarket.CommPVerifier
+	// PieceHoldChecker spot checks copies of existing pieces, if set
+	PieceHoldChecker *fakePieceHoldChecker
+	// ClientPeerError is returned when authenticating the peer that proposed a deal
+	ClientPeerError error
+	// PublishedProposals, if set, is the number of proposals the node reads back from
+	// publish messages, with the deal's own at PublishedIndex, or nowhere if negative.
+	// Otherwise publish messages hold only the deal's own proposal
+	PublishedProposals int
+	PublishedIndex     int
+	// FundingWallet is the wallet picked to fund deals from, if set
+	FundingWallet address.Address
 }
 
 type executor func(t *testing.T,
@@ -1298,6 +2142,7 @@ func makeExecutor(ctx context.Context,
 			LocatePieceForDealWithinSectorError: nodeParams.LocatePieceForDealWithinSectorError,
 			DataCap:                             nodeParams.DataCap,
 			GetDataCapErr:                       nodeParams.GetDataCapError,
+			GetPublishedProposalsError:          nodeParams.GetPublishedProposalsError,
 		}
 
 		if nodeParams.MinerAddr == address.Undef {
@@ -1367,9 +2212,20 @@ func makeExecutor(ctx context.Context,
 		if dealParams.TransferChannelId != nil {
 			dealState.TransferChannelId = dealParams.TransferChannelId
 		}
+		dealState.PieceReused = dealParams.PieceReused
+		dealState.RetryAfter = dealParams.RetryAfter
+		dealState.ResubmitBy = storagemarket.OptionalTime(dealParams.ResubmitBy)
+		dealState.CommPJob = dealParams.CommPJob
+		dealState.FundingWallet = dealParams.FundingWallet
 
 		fs := tut.NewTestFileStore(fileStoreParams)
 		pieceStore := tut.NewTestPieceStoreWithParams(pieceStoreParams)
+		if params.ExpectPieceLookup {
+			pieceStore.ExpectMissingPiece(proposal.PieceCID)
+		}
+		if params.ExistingPiece != nil {
+			pieceStore.StubPiece(proposal.PieceCID, *params.ExistingPiece)
+		}
 		expectedTags := make(map[string]struct{})
 		if params.TagsProposal {
 			expectedTags[dealState.ProposalCid.String()] = struct{}{}
@@ -1380,6 +2236,8 @@ func makeExecutor(ctx context.Context,
 			address:                     params.Address,
 			node:                        node,
 			ask:                         params.Ask,
+			previousAsk:                 params.PreviousAsk,
+			askGracePeriod:              params.AskGracePeriod,
 			dataTransferError:           params.DataTransferError,
 			pieceCid:                    params.PieceCid,
 			metadataPath:                params.MetadataPath,
@@ -1393,11 +2251,40 @@ func makeExecutor(ctx context.Context,
 			rejectDeal:                  params.RejectDeal,
 			rejectReason:                params.RejectReason,
 			decisionError:               params.DecisionError,
+			dryRun:                      params.DryRun,
+			maintenance:                 params.Maintenance,
+			maintenanceUntil:            params.MaintenanceUntil,
+			intakePaused:                params.IntakePaused,
+			quotaExceeded:               params.QuotaExceeded,
+			quotaRetryAt:                params.QuotaRetryAt,
+			transferTypes:               params.TransferTypes,
+			rejectionRetryAfter:         params.RejectionRetryAfter,
+			collateralPolicy:            params.CollateralPolicy,
+			transferQueued:              params.TransferQueued,
+			transferExpectedStart:       params.TransferExpectedStart,
 			fs:                          fs,
 			pieceStore:                  pieceStore,
 			peerTagger:                  tut.NewTestPeerTagger(),
 
 			restartDataTransferError: params.RestartDataTransferError,
+			clientView:               params.ClientView,
+			commPVerifier:            params.CommPVerifier,
+			pieceHoldChecker:         params.PieceHoldChecker,
+			clientPeerError:          params.ClientPeerError,
+			fundingWallet:            params.FundingWallet,
+		}
+		node.PublishedProposals = []market.ClientDealProposal{*signedProposal}
+		if params.PublishedProposals > 0 {
+			node.PublishedProposals = nil
+		}
+		for i := 0; i < params.PublishedProposals; i++ {
+			if i == params.PublishedIndex {
+				node.PublishedProposals = append(node.PublishedProposals, *signedProposal)
+				continue
+			}
+			other := *signedProposal
+			other.Proposal.StartEpoch += abi.ChainEpoch(i + 1)
+			node.PublishedProposals = append(node.PublishedProposals, other)
 		}
 		if environment.pieceCid == cid.Undef {
 			environment.pieceCid = defaultPieceCid
@@ -1421,6 +2308,10 @@ func makeExecutor(ctx context.Context,
 		fsmCtx := fsmtest.NewTestContext(ctx, eventProcessor)
 		err = stateEntryFunc(fsmCtx, environment, *dealState)
 		require.NoError(t, err)
+		if params.CommPVerifier != nil {
+			// external verifiers are polled in the background
+			time.Sleep(20 * time.Millisecond)
+		}
 		fsmCtx.ReplayEvents(t, dealState)
 		dealInspector(t, *dealState, environment)
 
@@ -1434,10 +2325,21 @@ type restartDataTransferCall struct {
 	chId datatransfer.ChannelID
 }
 
+type errorCollateralPolicy struct {
+	err error
+}
+
+func (p *errorCollateralPolicy) ProviderCollateralBounds(context.Context, storagemarket.MinerDeal, abi.TokenAmount, abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error) {
+	return abi.TokenAmount{}, abi.TokenAmount{}, p.err
+}
+
 type fakeEnvironment struct {
 	address                     address.Address
 	node                        *testnodes.FakeProviderNode
 	ask                         storagemarket.StorageAsk
+	previousAsk                 storagemarket.StorageAsk
+	askAtEpochs                 []abi.ChainEpoch
+	askGracePeriod              abi.ChainEpoch
 	dataTransferError           error
 	pieceCid                    cid.Cid
 	metadataPath                filestore.Path
@@ -1452,15 +2354,88 @@ type fakeEnvironment struct {
 	rejectDeal                  bool
 	rejectReason                string
 	decisionError               error
+	dryRun                      bool
+	maintenance                 bool
+	maintenanceUntil            abi.ChainEpoch
+	intakePaused                string
+	quotaExceeded               string
+	quotaRetryAt                time.Time
+	quotaAdmissions             int
+	transferTypes               []string
+	rejectionRetryAfter         abi.ChainEpoch
+	sentResponses               []*network.Response
+	collateralPolicy            storagemarket.CollateralPolicy
+	transferQueued              bool
+	transferExpectedStart       time.Time
+	transferSlotCalls           int
 	deleteStoreError            error
 	fs                          filestore.FileStore
 	pieceStore                  piecestore.PieceStore
+	stagedPieces                map[cid.Cid]filestore.Path
 	expectedTags                map[string]struct{}
 	receivedTags                map[string]struct{}
 	peerTagger                  *tut.TestPeerTagger
 
 	restartDataTransferCalls []restartDataTransferCall
 	restartDataTransferError error
+	clientView               *network.DealView
+	commPVerifier            storagemarket.CommPVerifier
+	pieceHoldChecker         *fakePieceHoldChecker
+	clientPeerError          error
+	fundingWallet            address.Address
+	dealLogs                 []storagemarket.DealLogEntry
+}
+
+func (fe *fakeEnvironment) CommPVerifier() (storagemarket.CommPVerifier, time.Duration) {
+	return fe.commPVerifier, time.Millisecond
+}
+
+func (fe *fakeEnvironment) PieceHoldChecker() storagemarket.PieceHoldChecker {
+	if fe.pieceHoldChecker == nil {
+		return nil
+	}
+	return fe.pieceHoldChecker
+}
+
+// fakePieceHoldChecker fails the spot check for copies of a piece in the given sectors
+type fakePieceHoldChecker struct {
+	failing map[abi.SectorNumber]bool
+	checked []abi.SectorNumber
+}
+
+func (c *fakePieceHoldChecker) CheckPieceHeld(ctx context.Context, pieceCID cid.Cid, sectorNumber abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) error {
+	c.checked = append(c.checked, sectorNumber)
+	if c.failing[sectorNumber] {
+		return errors.New("sector is not provable")
+	}
+	return nil
+}
+
+type fakeCommPVerifier struct {
+	jobID       string
+	submitError error
+	submitted   []storagemarket.CommPRequest
+	// results are returned by successive polls, and the last one repeats
+	results   []storagemarket.CommPResult
+	pollError error
+	polled    []string
+}
+
+func (fv *fakeCommPVerifier) Submit(ctx context.Context, request storagemarket.CommPRequest) (string, error) {
+	fv.submitted = append(fv.submitted, request)
+	return fv.jobID, fv.submitError
+}
+
+func (fv *fakeCommPVerifier) Poll(ctx context.Context, jobID string) (storagemarket.CommPResult, error) {
+	fv.polled = append(fv.polled, jobID)
+	if fv.pollError != nil {
+		return storagemarket.CommPResult{}, fv.pollError
+	}
+	result := fv.results[0]
+	if len(fv.results) > 1 {
+		fv.results = fv.results[1:]
+	}
+	return result, nil
 }
 
 func (fe *fakeEnvironment) RestartDataTransfer(_ context.Context, chId datatransfer.ChannelID) error {
@@ -1480,6 +2455,18 @@ func (fe *fakeEnvironment) Ask() storagemarket.StorageAsk {
 	return fe.ask
 }
 
+func (fe *fakeEnvironment) AskAt(epoch abi.ChainEpoch) storagemarket.StorageAsk {
+	fe.askAtEpochs = append(fe.askAtEpochs, epoch)
+	if fe.previousAsk.Price.Nil() {
+		return fe.ask
+	}
+	return fe.previousAsk
+}
+
+func (fe *fakeEnvironment) AskGracePeriod() abi.ChainEpoch {
+	return fe.askGracePeriod
+}
+
 func (fe *fakeEnvironment) DeleteStore(storeID multistore.StoreID) error {
 	return fe.deleteStoreError
 }
@@ -1495,6 +2482,7 @@ func (fe *fakeEnvironment) GeneratePieceCommitment(storeID *multistore.StoreID,
 }
 
 func (fe *fakeEnvironment) SendSignedResponse(ctx context.Context, response *network.Response) error {
+	fe.sentResponses = append(fe.sentResponses, response)
 	return fe.sendSignedResponseError
 }
 
@@ -1511,6 +2499,21 @@ func (fe *fakeEnvironment) FileStore() filestore.FileStore {
 	return fe.fs
 }
 
+func (fe *fakeEnvironment) StagePieceForRetrieval(pieceCID cid.Cid, path filestore.Path) {
+	if fe.stagedPieces == nil {
+		fe.stagedPieces = make(map[cid.Cid]filestore.Path)
+	}
+	fe.stagedPieces[pieceCID] = path
+}
+
+func (fe *fakeEnvironment) DeletePiece(proposalCid cid.Cid, path filestore.Path) error {
+	return fe.fs.Delete(path)
+}
+
+func (fe *fakeEnvironment) AuthenticateClientPeer(ctx context.Context, deal storagemarket.MinerDeal, tok shared.TipSetToken) error {
+	return fe.clientPeerError
+}
+
 func (fe *fakeEnvironment) PieceStore() piecestore.PieceStore {
 	return fe.pieceStore
 }
@@ -1519,6 +2522,78 @@ func (fe *fakeEnvironment) RunCustomDecisionLogic(context.Context, storagemarket
 	return !fe.rejectDeal, fe.rejectReason, fe.decisionError
 }
 
+func (fe *fakeEnvironment) TransferSlot(deal storagemarket.MinerDeal) (bool, time.Time) {
+	fe.transferSlotCalls++
+	if fe.transferQueued {
+		return false, fe.transferExpectedStart
+	}
+	return true, time.Time{}
+}
+
+func (fe *fakeEnvironment) CollateralPolicy() storagemarket.CollateralPolicy {
+	if fe.collateralPolicy == nil {
+		return collateral.ChainBounds()
+	}
+	return fe.collateralPolicy
+}
+
+func (fe *fakeEnvironment) DryRun() bool {
+	return fe.dryRun
+}
+
+func (fe *fakeEnvironment) Maintenance(epoch abi.ChainEpoch) (bool, abi.ChainEpoch) {
+	return fe.maintenance, fe.maintenanceUntil
+}
+
+func (fe *fakeEnvironment) IntakePaused() (bool, string) {
+	return fe.intakePaused != "", fe.intakePaused
+}
+
+func (fe *fakeEnvironment) AdmitToIntakeQuotas(deal storagemarket.MinerDeal) (bool, time.Time, string) {
+	fe.quotaAdmissions++
+	return fe.quotaExceeded == "", fe.quotaRetryAt, fe.quotaExceeded
+}
+
+func (fe *fakeEnvironment) AcceptsTransferType(transferType string) bool {
+	if fe.transferTypes == nil {
+		return true
+	}
+	for _, tt := range fe.transferTypes {
+		if tt == transferType {
+			return true
+		}
+	}
+	return false
+}
+
+func (fe *fakeEnvironment) FundingWallet(deal storagemarket.MinerDeal) (address.Address, bool) {
+	return fe.fundingWallet, fe.fundingWallet != address.Undef
+}
+
+func (fe *fakeEnvironment) RejectionRetryAfter() abi.ChainEpoch {
+	return fe.rejectionRetryAfter
+}
+
+func (fe *fakeEnvironment) WaitForPublishMessage(ctx context.Context, mcid cid.Cid, onCompletion func(exitcode.ExitCode, []byte, cid.Cid, error) error) error {
+	return fe.node.WaitForMessage(ctx, mcid, onCompletion)
+}
+
+func (fe *fakeEnvironment) NegotiateRestart(_ context.Context, deal storagemarket.MinerDeal) (network.DealView, network.DealView, error) {
+	providerView := network.DealView{
+		Proposal:          deal.ProposalCid,
+		State:             deal.State,
+		TransferChannelID: deal.TransferChannelId,
+	}
+	if fe.clientView == nil {
+		return network.DealView{}, providerView, xerrors.New("client unreachable")
+	}
+	return *fe.clientView, providerView, nil
+}
+
+func (fe *fakeEnvironment) RecordDealLog(proposalCid cid.Cid, level string, message string) {
+	fe.dealLogs = append(fe.dealLogs, storagemarket.DealLogEntry{Level: level, Message: message})
+}
+
 func (fe *fakeEnvironment) TagPeer(id peer.ID, s string) {
 	fe.peerTagger.TagPeer(id, s)
 }
@@ -13,8 +13,10 @@ import (
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
@@ -251,3 +253,64 @@ func TestLoadBlockLocations(t *testing.T) {
 	}
 	fs.VerifyExpectations(t)
 }
+
+func TestDecodePublishReturn(t *testing.T) {
+	t.Run("return listing every proposal", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, (&market.PublishStorageDealsReturn{IDs: []abi.DealID{10, 11}}).MarshalCBOR(buf))
+		ret, err := providerutils.DecodePublishReturn(buf.Bytes())
+		require.NoError(t, err)
+		require.Nil(t, ret.ValidDeals)
+
+		id, err := ret.DealID(1)
+		require.NoError(t, err)
+		require.Equal(t, abi.DealID(11), id)
+		_, err = ret.DealID(2)
+		require.EqualError(t, err, "publish message returned 2 deal IDs, none for proposal 2")
+	})
+
+	t.Run("return listing valid deals", func(t *testing.T) {
+		ret, err := providerutils.DecodePublishReturn(publishReturnWithValidDeals(t, []abi.DealID{20, 23}, []uint64{0, 3}))
+		require.NoError(t, err)
+		require.NotNil(t, ret.ValidDeals)
+
+		id, err := ret.DealID(3)
+		require.NoError(t, err)
+		require.Equal(t, abi.DealID(23), id)
+		_, err = ret.DealID(1)
+		require.True(t, errors.Is(err, providerutils.ErrDealDropped))
+		require.EqualError(t, err, "deal was dropped from the publish message as invalid: proposal 1 of the message")
+	})
+
+	t.Run("single deal", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, (&market.PublishStorageDealsReturn{IDs: []abi.DealID{10}}).MarshalCBOR(buf))
+		ret, err := providerutils.DecodePublishReturn(buf.Bytes())
+		require.NoError(t, err)
+		id, err := ret.DealID(0)
+		require.NoError(t, err)
+		require.Equal(t, abi.DealID(10), id)
+	})
+
+	t.Run("valid deals that do not match the IDs", func(t *testing.T) {
+		_, err := providerutils.DecodePublishReturn(publishReturnWithValidDeals(t, []abi.DealID{20}, []uint64{0, 3}))
+		require.EqualError(t, err, "publish return lists 2 valid deals but 1 IDs")
+	})
+
+	t.Run("malformed return", func(t *testing.T) {
+		_, err := providerutils.DecodePublishReturn([]byte{0x83, 0x80, 0x40, 0x40})
+		require.Error(t, err)
+	})
+}
+
+func publishReturnWithValidDeals(t *testing.T, ids []abi.DealID, valid []uint64) []byte {
+	buf := new(bytes.Buffer)
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajArray, 2))
+	require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajArray, uint64(len(ids))))
+	for _, id := range ids {
+		require.NoError(t, cbg.WriteMajorTypeHeader(buf, cbg.MajUnsignedInt, uint64(id)))
+	}
+	validDeals := bitfield.NewFromSet(valid)
+	require.NoError(t, validDeals.MarshalCBOR(buf))
+	return buf.Bytes()
+}
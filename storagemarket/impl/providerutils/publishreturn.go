@@ -0,0 +1,118 @@
+package providerutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// ErrDealDropped is returned for a proposal the market actor dropped from a
+// PublishStorageDeals message as invalid, while publishing the rest of the message
+var ErrDealDropped = errors.New("deal was dropped from the publish message as invalid")
+
+// PublishReturn is the return value of a PublishStorageDeals message.
+//
+// Earlier versions of the market actor fail the whole message if any proposal in it
+// is invalid, and return an ID for every proposal, in order. Later versions publish
+// the valid proposals and drop the rest, returning IDs only for the proposals
+// published, and listing which proposals those are in ValidDeals. ValidDeals is nil
+// for returns from earlier versions
+type PublishReturn struct {
+	IDs        []abi.DealID
+	ValidDeals *bitfield.BitField
+}
+
+// DecodePublishReturn decodes the return value of a PublishStorageDeals message from
+// any version of the market actor
+func DecodePublishReturn(data []byte) (PublishReturn, error) {
+	br := cbg.GetPeeker(bytes.NewReader(data))
+	scratch := make([]byte, 8)
+
+	maj, fields, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return PublishReturn{}, err
+	}
+	if maj != cbg.MajArray || (fields != 1 && fields != 2) {
+		return PublishReturn{}, xerrors.New("publish return should be an array of 1 or 2 fields")
+	}
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return PublishReturn{}, err
+	}
+	if maj != cbg.MajArray {
+		return PublishReturn{}, xerrors.New("publish return IDs should be an array")
+	}
+	if extra > cbg.MaxLength {
+		return PublishReturn{}, xerrors.Errorf("publish return IDs too long (%d)", extra)
+	}
+	var ret PublishReturn
+	if extra > 0 {
+		ret.IDs = make([]abi.DealID, extra)
+	}
+	for i := range ret.IDs {
+		maj, val, err := cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return PublishReturn{}, err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return PublishReturn{}, xerrors.New("publish return IDs should be unsigned integers")
+		}
+		ret.IDs[i] = abi.DealID(val)
+	}
+
+	if fields == 2 {
+		var validDeals bitfield.BitField
+		if err := validDeals.UnmarshalCBOR(br); err != nil {
+			return PublishReturn{}, xerrors.Errorf("decoding valid deals: %w", err)
+		}
+		count, err := validDeals.Count()
+		if err != nil {
+			return PublishReturn{}, xerrors.Errorf("counting valid deals: %w", err)
+		}
+		if count != uint64(len(ret.IDs)) {
+			return PublishReturn{}, xerrors.Errorf("publish return lists %d valid deals but %d IDs", count, len(ret.IDs))
+		}
+		ret.ValidDeals = &validDeals
+	}
+	return ret, nil
+}
+
+// DealID returns the ID of the deal published for the proposal at the given index in
+// the message's params, or an error wrapping ErrDealDropped if the proposal was
+// dropped
+func (r PublishReturn) DealID(index int) (abi.DealID, error) {
+	if r.ValidDeals == nil {
+		if index >= len(r.IDs) {
+			return 0, xerrors.Errorf("publish message returned %d deal IDs, none for proposal %d", len(r.IDs), index)
+		}
+		return r.IDs[index], nil
+	}
+
+	valid, err := r.ValidDeals.IsSet(uint64(index))
+	if err != nil {
+		return 0, xerrors.Errorf("reading valid deals: %w", err)
+	}
+	if !valid {
+		return 0, fmt.Errorf("%w: proposal %d of the message", ErrDealDropped, index)
+	}
+	// IDs are only returned for the proposals published, so the ID for this
+	// proposal comes after those for the valid proposals before it
+	var position int
+	err = r.ValidDeals.ForEach(func(i uint64) error {
+		if i < uint64(index) {
+			position++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, xerrors.Errorf("reading valid deals: %w", err)
+	}
+	return r.IDs[position], nil
+}
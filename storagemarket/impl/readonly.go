@@ -0,0 +1,80 @@
+package storageimpl
+
+import (
+	"time"
+
+	"github.com/hannahhoward/go-pubsub"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+	"github.com/filecoin-project/go-statemachine/fsm"
+
+	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/providerstates"
+)
+
+// ErrReadOnly is returned by a provider opened with NewReadOnlyProvider for
+// operations that would change its deals, ask, config or funds
+var ErrReadOnly = xerrors.New("storage provider is read-only")
+
+// NewReadOnlyProvider opens the deals of a storage provider for inspection, such as
+// from a tool running alongside the provider that owns the datastore. It registers
+// no network handlers, does not subscribe to data transfer events and never runs
+// migrations, so it fails unless the provider has already migrated the datastore.
+// Deal state handlers never run, and operations that would change anything return
+// ErrReadOnly. storedAsk may be nil, in which case GetAsk returns nil
+func NewReadOnlyProvider(ds datastore.Batching, storedAsk StoredAsk, options ...StorageProviderOption) (storagemarket.StorageProvider, error) {
+	h := &Provider{
+		storedAsk:    storedAsk,
+		readySub:     pubsub.New(shared.ReadyDispatcher),
+		configSub:    pubsub.New(configDispatcher),
+		diskSpaceSub: pubsub.New(diskSpaceDispatcher),
+		handlerPool:  handlerpool.New(0, 0),
+		readOnly:     true,
+
+		rejectionRetryAfter: DefaultRejectionRetryAfter,
+		askGracePeriod:      DefaultAskGracePeriod,
+		ds:                  ds,
+		stateTimes:          shared.NewStateTimes(),
+		expectedDwellTimes:  make(map[storagemarket.StorageDealStatus]time.Duration, len(DefaultExpectedDwellTimes)),
+	}
+	for state, dwell := range DefaultExpectedDwellTimes {
+		h.expectedDwellTimes[state] = dwell
+	}
+	h.Configure(options...)
+
+	dealsDs, err := migrationtools.AtVersion(dealsDatastore(ds, h.logDeals), versioning.VersionKey("1"))
+	if err != nil {
+		return nil, xerrors.Errorf("opening storage provider deals: %w", err)
+	}
+	h.deals, err = fsm.New(dealsDs, fsm.Parameters{
+		Environment:     &providerDealEnvironment{h},
+		StateType:       storagemarket.MinerDeal{},
+		StateKeyField:   "State",
+		Events:          providerstates.ProviderEvents,
+		StateEntryFuncs: fsm.StateEntryFuncs{},
+		FinalityStates:  providerstates.ProviderFinalityStates,
+		Notifier:        h.dispatch,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.pubSub = eventbus.New(providerDispatcher, h.eventQueueSize, h.eventOverflowPolicy)
+	if h.statsDs == nil {
+		h.statsDs = dss.MutexWrap(datastore.NewMapDatastore())
+	}
+	h.stats, err = dealstats.New(h.statsDs)
+	if err != nil {
+		return nil, err
+	}
+	h.dealLogs = h.newDealLog()
+	return h, nil
+}
@@ -15,7 +15,7 @@ import (
 // Will succeed only if:
 // - voucher has correct type
 // - voucher references an active deal
-// - referenced deal matches the client
+// - referenced deal matches the client, or the transfer agent the client authorized
 // - referenced deal matches the given base CID
 // - referenced deal is in an acceptable state
 func ValidatePush(
@@ -34,7 +34,7 @@ func ValidatePush(
 	if err != nil {
 		return xerrors.Errorf("Proposal CID %s: %w", dealVoucher.Proposal.String(), ErrNoDeal)
 	}
-	if deal.Client != sender {
+	if deal.Client != sender && (deal.Ref.TransferAgent == "" || deal.Ref.TransferAgent != sender) {
 		return xerrors.Errorf("Deal Peer %s, Data Transfer Peer %s: %w", deal.Client.String(), sender.String(), ErrWrongPeer)
 	}
 
@@ -232,6 +232,38 @@ func AssertPushValidator(t *testing.T, validator datatransfer.RequestValidator,
 			t.Fatal("Push should should succeed when all parameters are correct")
 		}
 	})
+	t.Run("ValidatePush succeeds from the transfer agent", func(t *testing.T) {
+		otherClient := peer.ID("otherclient")
+		minerDeal, err := newMinerDeal(otherClient, storagemarket.StorageDealValidating)
+		if err != nil {
+			t.Fatal("error creating client deal")
+		}
+		minerDeal.Ref.TransferAgent = sender
+		if err := state.Begin(minerDeal.ProposalCid, &minerDeal); err != nil {
+			t.Fatal("deal tracking failed")
+		}
+		ref := minerDeal.Ref
+		_, err = validator.ValidatePush(sender, &rv.StorageDataTransferVoucher{minerDeal.ProposalCid}, ref.Root, nil)
+		if err != nil {
+			t.Fatal("Push should succeed when the sender is the deal's transfer agent")
+		}
+	})
+	t.Run("ValidatePush fails from a peer other than the transfer agent", func(t *testing.T) {
+		otherClient := peer.ID("otherclient")
+		minerDeal, err := newMinerDeal(otherClient, storagemarket.StorageDealValidating)
+		if err != nil {
+			t.Fatal("error creating client deal")
+		}
+		minerDeal.Ref.TransferAgent = peer.ID("otheragent")
+		if err := state.Begin(minerDeal.ProposalCid, &minerDeal); err != nil {
+			t.Fatal("deal tracking failed")
+		}
+		ref := minerDeal.Ref
+		_, err = validator.ValidatePush(sender, &rv.StorageDataTransferVoucher{minerDeal.ProposalCid}, ref.Root, nil)
+		if !xerrors.Is(err, rv.ErrWrongPeer) {
+			t.Fatal("Push should fail if the sender is neither the client nor the transfer agent")
+		}
+	})
 }
 
 func AssertValidatesPulls(t *testing.T, validator datatransfer.RequestValidator, receiver peer.ID, state *statestore.StateStore) {
@@ -0,0 +1,41 @@
+package storageimpl
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// ReportSealingProgress has a provider ask the given reporter how far it has got
+// sealing a deal's sector when a client asks for the deal's status. Without a
+// reporter, the provider only reports whether the deal's sector has been
+// pre-committed
+func ReportSealingProgress(reporter storagemarket.SealingProgressReporter) StorageProviderOption {
+	return func(p *Provider) {
+		p.sealingReporter = reporter
+	}
+}
+
+// sealingProgress returns how far the deal's sector has got through sealing, or
+// nil if the deal is not being sealed
+func (p *Provider) sealingProgress(ctx context.Context, md storagemarket.MinerDeal) *storagemarket.SealingProgress {
+	var progress storagemarket.SealingProgress
+	switch md.State {
+	case storagemarket.StorageDealStaged, storagemarket.StorageDealAwaitingPreCommit:
+		progress.Stage = storagemarket.SealingQueued
+	case storagemarket.StorageDealSealing:
+		progress.Stage = storagemarket.SealingPreCommitted
+	default:
+		return nil
+	}
+
+	if p.sealingReporter == nil {
+		return &progress
+	}
+	reported, err := p.sealingReporter.SealingProgress(ctx, md)
+	if err != nil {
+		log.Warnf("getting sealing progress for deal %s: %s", md.ProposalCid, err)
+		return &progress
+	}
+	return &reported
+}
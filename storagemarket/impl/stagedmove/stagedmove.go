@@ -0,0 +1,258 @@
+/*
+Package stagedmove moves the staged data of a storage provider's deals from one
+filestore to another, such as when the provider's staging area moves to a bigger disk.
+
+Each deal's piece and metadata files are copied to the new filestore and read back to
+check they hash the same as the originals. Only then are the deal's PiecePath and
+MetadataPath updated, together in a single write of the deal record, so a deal never
+points at a partly copied file. The original files are deleted once every deal using
+them has been moved.
+
+The provider must be stopped while its data is moved, and started again with the new
+filestore.
+*/
+package stagedmove
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	versioning "github.com/filecoin-project/go-ds-versioning/pkg"
+	"github.com/filecoin-project/go-statestore"
+
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("stagedmove")
+
+// MovedDeal is a deal whose staged data was moved
+type MovedDeal struct {
+	ProposalCid  cid.Cid
+	PiecePath    filestore.Path
+	MetadataPath filestore.Path
+}
+
+// FailedDeal is a deal whose staged data could not be moved. Its record and files
+// are left as they were
+type FailedDeal struct {
+	ProposalCid cid.Cid
+	Message     string
+}
+
+// Report is the result of a Move
+type Report struct {
+	Moved  []MovedDeal
+	Failed []FailedDeal
+	// BytesCopied is the size of the files copied to the new filestore
+	BytesCopied int64
+	// Deleted are the files removed from the old filestore
+	Deleted []filestore.Path
+}
+
+// Move copies the staged data of every deal in the provider datastore ds that still
+// has staged files from one filestore to the other, and updates the deals to point
+// at the copies. Deals that are finished, or whose files were already cleaned up,
+// are left alone. A deal that fails to move is reported and keeps its old files, and
+// the other deals are still moved. The deals of a provider started with
+// LogProviderDeals are only found if ds is wrapped with eventstore.New
+func Move(ctx context.Context, ds datastore.Batching, from, to filestore.FileStore) (Report, error) {
+	var report Report
+	dealsDs, err := migrationtools.AtVersion(ds, versioning.VersionKey("1"))
+	if err != nil {
+		return report, xerrors.Errorf("opening storage provider deals: %w", err)
+	}
+	deals := statestore.New(dealsDs)
+
+	var all []storagemarket.MinerDeal
+	if err := deals.List(&all); err != nil {
+		return report, xerrors.Errorf("listing deals: %w", err)
+	}
+
+	m := &mover{from: from, to: to, copied: make(map[filestore.Path]copied)}
+	// sources are only deleted once no deal that failed to move still uses them
+	moved := make(map[filestore.Path]struct{})
+	kept := make(map[filestore.Path]struct{})
+	for _, deal := range all {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if !hasStagedData(deal) {
+			continue
+		}
+
+		piecePath, metadataPath, err := m.moveDeal(deal)
+		if err == nil {
+			err = deals.Get(deal.ProposalCid).Mutate(func(d *storagemarket.MinerDeal) error {
+				d.PiecePath = piecePath
+				d.MetadataPath = metadataPath
+				return nil
+			})
+		}
+		if err != nil {
+			log.Warnf("moving staged data for deal %s: %s", deal.ProposalCid, err)
+			report.Failed = append(report.Failed, FailedDeal{ProposalCid: deal.ProposalCid, Message: err.Error()})
+			markPaths(kept, deal)
+			continue
+		}
+		report.Moved = append(report.Moved, MovedDeal{ProposalCid: deal.ProposalCid, PiecePath: piecePath, MetadataPath: metadataPath})
+		markPaths(moved, deal)
+	}
+
+	for _, c := range m.copied {
+		report.BytesCopied += c.size
+	}
+	for p := range moved {
+		if _, ok := kept[p]; ok {
+			continue
+		}
+		if err := from.Delete(p); err != nil {
+			log.Warnf("deleting moved file %s: %s", p, err)
+			continue
+		}
+		report.Deleted = append(report.Deleted, p)
+	}
+	return report, nil
+}
+
+// hasStagedData returns true if a deal may still have files in the staging filestore.
+// Files are deleted when a deal is finalized
+func hasStagedData(deal storagemarket.MinerDeal) bool {
+	if deal.PiecePath == "" && deal.MetadataPath == "" {
+		return false
+	}
+	switch deal.State {
+	case storagemarket.StorageDealFinalizing, storagemarket.StorageDealActive,
+		storagemarket.StorageDealError, storagemarket.StorageDealSlashed, storagemarket.StorageDealExpired:
+		return false
+	}
+	return true
+}
+
+func markPaths(paths map[filestore.Path]struct{}, deal storagemarket.MinerDeal) {
+	for _, p := range []filestore.Path{deal.PiecePath, deal.MetadataPath} {
+		if p != "" {
+			paths[p] = struct{}{}
+		}
+	}
+}
+
+type copied struct {
+	path filestore.Path
+	size int64
+}
+
+// mover copies files between filestores, copying a file shared by several deals once
+type mover struct {
+	from, to filestore.FileStore
+	copied   map[filestore.Path]copied
+}
+
+func (m *mover) moveDeal(deal storagemarket.MinerDeal) (filestore.Path, filestore.Path, error) {
+	piecePath, err := m.move(deal.PiecePath)
+	if err != nil {
+		return "", "", xerrors.Errorf("moving piece: %w", err)
+	}
+	metadataPath, err := m.move(deal.MetadataPath)
+	if err != nil {
+		return "", "", xerrors.Errorf("moving metadata: %w", err)
+	}
+	return piecePath, metadataPath, nil
+}
+
+// move copies the file at p to the new filestore and checks the copy, returning the
+// path of the copy. The copy keeps the same path unless the new filestore already
+// has a different file there
+func (m *mover) move(p filestore.Path) (filestore.Path, error) {
+	if p == "" {
+		return "", nil
+	}
+	if c, ok := m.copied[p]; ok {
+		return c.path, nil
+	}
+
+	srcHash, size, err := hashFile(m.from, p)
+	if err != nil {
+		// the deal was already moved by an earlier run
+		if _, _, destErr := hashFile(m.to, p); destErr == nil {
+			m.copied[p] = copied{path: p}
+			return p, nil
+		}
+		return "", xerrors.Errorf("reading %s: %w", p, err)
+	}
+
+	// a file already in place from an earlier, interrupted move is reused
+	if destHash, _, err := hashFile(m.to, p); err == nil && bytes.Equal(srcHash, destHash) {
+		m.copied[p] = copied{path: p}
+		return p, nil
+	}
+
+	dest, err := m.to.Create(p)
+	if err != nil {
+		// the path is taken by a different file
+		dest, err = m.to.CreateTemp()
+		if err != nil {
+			return "", xerrors.Errorf("creating copy of %s: %w", p, err)
+		}
+	}
+	if err := m.copy(p, dest); err != nil {
+		_ = m.to.Delete(dest.Path())
+		return "", err
+	}
+
+	destHash, _, err := hashFile(m.to, dest.Path())
+	if err != nil {
+		_ = m.to.Delete(dest.Path())
+		return "", xerrors.Errorf("reading back copy of %s: %w", p, err)
+	}
+	if !bytes.Equal(srcHash, destHash) {
+		_ = m.to.Delete(dest.Path())
+		return "", xerrors.Errorf("copy of %s does not match the original", p)
+	}
+
+	m.copied[p] = copied{path: dest.Path(), size: size}
+	return dest.Path(), nil
+}
+
+func (m *mover) copy(p filestore.Path, dest filestore.File) error {
+	src, err := m.from.Open(p)
+	if err != nil {
+		_ = dest.Close()
+		return xerrors.Errorf("opening %s: %w", p, err)
+	}
+	defer src.Close() // nolint: errcheck
+
+	_, err = io.Copy(dest, src)
+	closeErr := dest.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return xerrors.Errorf("copying %s: %w", p, err)
+	}
+	return nil
+}
+
+// hashFile returns the sha256 hash and size of a file in a filestore
+func hashFile(fs filestore.FileStore, p filestore.Path) ([]byte, int64, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hasher.Sum(nil), n, nil
+}
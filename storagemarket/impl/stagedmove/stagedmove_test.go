@@ -0,0 +1,149 @@
+package stagedmove_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/impl/stagedmove"
+)
+
+func newFileStore(t *testing.T) filestore.FileStore {
+	dir, err := ioutil.TempDir("", "stagedmove")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	fs, err := filestore.NewLocalFileStore(filestore.OsPath(dir))
+	require.NoError(t, err)
+	return fs
+}
+
+func writeFile(t *testing.T, fs filestore.FileStore, p filestore.Path, data []byte) {
+	f, err := fs.Create(p)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func readFile(t *testing.T, fs filestore.FileStore, p filestore.Path) []byte {
+	f, err := fs.Open(p)
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return data
+}
+
+func putDeal(t *testing.T, ds datastore.Batching, deal storagemarket.MinerDeal) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, deal.MarshalCBOR(buf))
+	require.NoError(t, ds.Put(datastore.NewKey(deal.ProposalCid.String()), buf.Bytes()))
+}
+
+func getDeal(t *testing.T, ds datastore.Batching, deal storagemarket.MinerDeal) storagemarket.MinerDeal {
+	stored, err := ds.Get(datastore.NewKey(deal.ProposalCid.String()))
+	require.NoError(t, err)
+	var out storagemarket.MinerDeal
+	require.NoError(t, out.UnmarshalCBOR(bytes.NewReader(stored)))
+	return out
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	cids := shared_testutil.GenerateCids(3)
+	proposal := *shared_testutil.MakeTestClientDealProposal()
+	staged := storagemarket.MinerDeal{
+		ClientDealProposal: proposal,
+		ProposalCid:        cids[0],
+		State:              storagemarket.StorageDealStaged,
+		PiecePath:          "piece",
+		MetadataPath:       "metadata",
+	}
+	broken := storagemarket.MinerDeal{
+		ClientDealProposal: proposal,
+		ProposalCid:        cids[1],
+		State:              storagemarket.StorageDealVerifyData,
+		PiecePath:          "missing",
+	}
+	active := storagemarket.MinerDeal{
+		ClientDealProposal: proposal,
+		ProposalCid:        cids[2],
+		State:              storagemarket.StorageDealActive,
+		PiecePath:          "cleaned-up",
+	}
+
+	t.Run("moves staged files and updates deals", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		deals := shared_testutil.DatastoreAtVersion(t, ds, "1")
+		putDeal(t, deals, staged)
+		putDeal(t, deals, broken)
+		putDeal(t, deals, active)
+		from, to := newFileStore(t), newFileStore(t)
+		writeFile(t, from, "piece", []byte("piece data"))
+		writeFile(t, from, "metadata", []byte("metadata"))
+		// a different file is already at the piece's path in the new filestore
+		writeFile(t, to, "piece", []byte("something else"))
+
+		report, err := stagedmove.Move(ctx, ds, from, to)
+		require.NoError(t, err)
+		require.Len(t, report.Moved, 1)
+		require.Equal(t, staged.ProposalCid, report.Moved[0].ProposalCid)
+		require.Len(t, report.Failed, 1)
+		require.Equal(t, broken.ProposalCid, report.Failed[0].ProposalCid)
+		require.Equal(t, int64(len("piece data")+len("metadata")), report.BytesCopied)
+		require.ElementsMatch(t, []filestore.Path{"piece", "metadata"}, report.Deleted)
+
+		moved := getDeal(t, deals, staged)
+		require.NotEqual(t, filestore.Path("piece"), moved.PiecePath)
+		require.Equal(t, filestore.Path("metadata"), moved.MetadataPath)
+		require.Equal(t, []byte("piece data"), readFile(t, to, moved.PiecePath))
+		require.Equal(t, []byte("metadata"), readFile(t, to, moved.MetadataPath))
+		require.Equal(t, []byte("something else"), readFile(t, to, "piece"))
+		_, err = from.Open("piece")
+		require.Error(t, err)
+
+		require.Equal(t, broken.PiecePath, getDeal(t, deals, broken).PiecePath)
+		require.Equal(t, active.PiecePath, getDeal(t, deals, active).PiecePath)
+
+		// moving again finds the deal already moved
+		report, err = stagedmove.Move(ctx, ds, from, to)
+		require.NoError(t, err)
+		require.Len(t, report.Moved, 1)
+		require.Zero(t, report.BytesCopied)
+	})
+
+	t.Run("keeps files a deal that failed to move still uses", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		deals := shared_testutil.DatastoreAtVersion(t, ds, "1")
+		sharing := broken
+		sharing.PiecePath = "piece"
+		sharing.MetadataPath = "missing"
+		putDeal(t, deals, staged)
+		putDeal(t, deals, sharing)
+		from, to := newFileStore(t), newFileStore(t)
+		writeFile(t, from, "piece", []byte("piece data"))
+		writeFile(t, from, "metadata", []byte("metadata"))
+
+		report, err := stagedmove.Move(ctx, ds, from, to)
+		require.NoError(t, err)
+		require.Len(t, report.Moved, 1)
+		require.Len(t, report.Failed, 1)
+		require.Equal(t, []filestore.Path{"metadata"}, report.Deleted)
+		require.Equal(t, []byte("piece data"), readFile(t, from, "piece"))
+	})
+
+	t.Run("fails before deals are migrated", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		_, err := stagedmove.Move(ctx, ds, newFileStore(t), newFileStore(t))
+		require.Error(t, err)
+	})
+}
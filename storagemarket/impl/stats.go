@@ -0,0 +1,71 @@
+package storageimpl
+
+import (
+	"time"
+
+	"github.com/ipfs/go-datastore"
+
+	"github.com/filecoin-project/go-fil-markets/shared/dealstats"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// counters of deal activity kept for Stats
+const (
+	statDealsAccepted  = "deals-accepted"
+	statBytesIngested  = "bytes-ingested"
+	statDealsActivated = "deals-activated"
+	// statTimedActivations counts the activated deals whose creation time is known,
+	// and statTimeToActive the total time they took to activate, in nanoseconds
+	statTimedActivations = "timed-activations"
+	statTimeToActive     = "time-to-active"
+)
+
+// PersistStats keeps the provider's deal statistics in the given datastore, so that
+// they survive restarts. Without it, statistics are kept in memory. It must be
+// passed to NewProvider
+func PersistStats(ds datastore.Batching) StorageProviderOption {
+	return func(p *Provider) {
+		p.statsDs = ds
+	}
+}
+
+// recordStats counts the deal activity an event represents
+func (p *Provider) recordStats(evt storagemarket.ProviderEvent, deal storagemarket.MinerDeal) {
+	counts := make(dealstats.Counts)
+	switch evt {
+	case storagemarket.ProviderEventDealAccepted:
+		counts[statDealsAccepted] = 1
+	case storagemarket.ProviderEventVerifiedData:
+		counts[statBytesIngested] = uint64(deal.Proposal.PieceSize)
+	case storagemarket.ProviderEventDealActivated:
+		counts[statDealsActivated] = 1
+		if created := time.Time(deal.CreationTime); !created.IsZero() {
+			counts[statTimedActivations] = 1
+			counts[statTimeToActive] = uint64(time.Since(created))
+		}
+	}
+	for counter, n := range counts {
+		if err := p.stats.Add(counter, n); err != nil {
+			log.Warnf("recording deal stats: %s", err)
+		}
+	}
+}
+
+// Stats returns rolling statistics of the provider's deal throughput over the last
+// day
+func (p *Provider) Stats() storagemarket.ProviderStats {
+	window := dealstats.DefaultWindow
+	stats := storagemarket.ProviderStats{
+		Window:         window,
+		Time:           time.Now(),
+		DealsAccepted:  p.stats.Sum(statDealsAccepted, window),
+		BytesIngested:  p.stats.Sum(statBytesIngested, window),
+		DealsActivated: p.stats.Sum(statDealsActivated, window),
+	}
+	stats.DealsAcceptedPerHour = dealstats.PerHour(stats.DealsAccepted, window)
+	stats.GiBIngestedPerDay = dealstats.GiBPerDay(stats.BytesIngested, window)
+	if timed := p.stats.Sum(statTimedActivations, window); timed > 0 {
+		stats.AverageTimeToActive = time.Duration(p.stats.Sum(statTimeToActive, window) / timed)
+	}
+	return stats
+}
@@ -3,9 +3,12 @@ package storedask
 import (
 	"bytes"
 	"context"
+	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	logging "github.com/ipfs/go-log/v2"
 	"golang.org/x/xerrors"
 
@@ -40,15 +43,21 @@ const DefaultMinPieceSize abi.PaddedPieceSize = 256
 // TODO: It would be nice to default this to the miner's sector size
 const DefaultMaxPieceSize abi.PaddedPieceSize = 1 << 20
 
+// AskHistoryLength is how many of the most recent asks are kept, so that the ask in
+// effect at a recent epoch can be looked up
+const AskHistoryLength = 16
+
 // StoredAsk implements a persisted SignedStorageAsk that lasts through restarts
-// It also maintains a cache of the current SignedStorageAsk in memory
+// It also maintains a cache of the current SignedStorageAsk in memory, along with
+// the history of recent asks
 type StoredAsk struct {
-	askLk sync.RWMutex
-	ask   *storagemarket.SignedStorageAsk
-	ds    datastore.Batching
-	dsKey datastore.Key
-	spn   storagemarket.StorageProviderNode
-	actor address.Address
+	askLk   sync.RWMutex
+	ask     *storagemarket.SignedStorageAsk
+	history []*storagemarket.SignedStorageAsk // ordered by seqno, ending with ask
+	ds      datastore.Batching
+	dsKey   datastore.Key
+	spn     storagemarket.StorageProviderNode
+	actor   address.Address
 }
 
 // NewStoredAsk returns a new instance of StoredAsk
@@ -64,13 +73,14 @@ func NewStoredAsk(ds datastore.Batching, dsKey datastore.Key, spn storagemarket.
 
 	askMigrations, err := versioned.BuilderList{
 		versioned.NewVersionedBuilder(migrations.GetMigrateSignedStorageAsk0To1(s.sign), versioning.VersionKey("1")),
+		versioned.NewVersionedBuilder(migrations.GetMigrateSignedStorageAsk1To2(s.sign), versioning.VersionKey("2")).OldVersion("1"),
 	}.Build()
 
 	if err != nil {
 		return nil, err
 	}
 
-	versionedDs, migrateDs := versionedds.NewVersionedDatastore(ds, askMigrations, versioning.VersionKey("1"))
+	versionedDs, migrateDs := versionedds.NewVersionedDatastore(ds, askMigrations, versioning.VersionKey("2"))
 
 	// TODO: this is a bit risky -- but this is just a single key so it's probably ok to run migrations in the constructor
 	err = migrateDs(context.TODO())
@@ -96,7 +106,7 @@ func NewStoredAsk(ds datastore.Batching, dsKey datastore.Key, spn storagemarket.
 
 // SetAsk configures the storage miner's ask with the provided prices (for unverified and verified deals),
 // duration, and options. Any previously-existing ask is replaced.  If no options are passed to configure
-// MinPieceSize and MaxPieceSize, the previous ask's values will be used, if available.
+// MinPieceSize, MaxPieceSize, MinDealDuration and MaxDealDuration, the previous ask's values will be used, if available.
 // It also increments the sequence number on the ask
 func (s *StoredAsk) SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount, duration abi.ChainEpoch, options ...storagemarket.StorageAskOption) error {
 	s.askLk.Lock()
@@ -104,10 +114,13 @@ func (s *StoredAsk) SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount,
 	var seqno uint64
 	minPieceSize := DefaultMinPieceSize
 	maxPieceSize := DefaultMaxPieceSize
+	var minDealDuration, maxDealDuration abi.ChainEpoch
 	if s.ask != nil {
 		seqno = s.ask.Ask.SeqNo + 1
 		minPieceSize = s.ask.Ask.MinPieceSize
 		maxPieceSize = s.ask.Ask.MaxPieceSize
+		minDealDuration = s.ask.Ask.MinDealDuration
+		maxDealDuration = s.ask.Ask.MaxDealDuration
 	}
 
 	ctx := context.TODO()
@@ -125,6 +138,9 @@ func (s *StoredAsk) SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount,
 		SeqNo:         seqno,
 		MinPieceSize:  minPieceSize,
 		MaxPieceSize:  maxPieceSize,
+
+		MinDealDuration: minDealDuration,
+		MaxDealDuration: maxDealDuration,
 	}
 
 	for _, option := range options {
@@ -142,7 +158,7 @@ func (s *StoredAsk) SetAsk(price abi.TokenAmount, verifiedPrice abi.TokenAmount,
 
 }
 
-func (s *StoredAsk) sign(ctx context.Context, ask *storagemarket.StorageAsk) (*crypto.Signature, error) {
+func (s *StoredAsk) sign(ctx context.Context, ask interface{}) (*crypto.Signature, error) {
 	tok, _, err := s.spn.GetChainHead(ctx)
 	if err != nil {
 		return nil, err
@@ -151,6 +167,21 @@ func (s *StoredAsk) sign(ctx context.Context, ask *storagemarket.StorageAsk) (*c
 	return providerutils.SignMinerData(ctx, ask, s.actor, tok, s.spn.GetMinerWorkerAddress, s.spn.SignBytes)
 }
 
+// GetAskAt returns the signed storage ask that was in effect at the given epoch, or
+// nil if no ask in the history was. An ask takes effect at the epoch it was set,
+// given by its timestamp, and stays in effect until the next ask is set
+func (s *StoredAsk) GetAskAt(epoch abi.ChainEpoch) *storagemarket.SignedStorageAsk {
+	s.askLk.RLock()
+	defer s.askLk.RUnlock()
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Ask.Timestamp <= epoch {
+			ask := *s.history[i]
+			return &ask
+		}
+	}
+	return nil
+}
+
 // GetAsk returns the current signed storage ask, or nil if one does not exist.
 func (s *StoredAsk) GetAsk() *storagemarket.SignedStorageAsk {
 	s.askLk.RLock()
@@ -190,6 +221,38 @@ func (s *StoredAsk) loadAsk() error {
 	}
 
 	s.ask = &ssa
+	return s.loadHistory()
+}
+
+func (s *StoredAsk) historyKey() datastore.Key {
+	return s.dsKey.ChildString("history")
+}
+
+func (s *StoredAsk) loadHistory() error {
+	res, err := s.ds.Query(query.Query{Prefix: s.historyKey().String()})
+	if err != nil {
+		return xerrors.Errorf("failed to query ask history: %w", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return xerrors.Errorf("failed to load ask history: %w", err)
+	}
+
+	s.history = nil
+	for _, entry := range entries {
+		var ssa storagemarket.SignedStorageAsk
+		if err := cborutil.ReadCborRPC(bytes.NewReader(entry.Value), &ssa); err != nil {
+			return err
+		}
+		if ssa.Ask.SeqNo < s.ask.Ask.SeqNo {
+			s.history = append(s.history, &ssa)
+		}
+	}
+	sort.Slice(s.history, func(i, j int) bool {
+		return s.history[i].Ask.SeqNo < s.history[j].Ask.SeqNo
+	})
+	// asks saved before the history was kept only have the current ask
+	s.history = append(s.history, s.ask)
 	return nil
 }
 
@@ -202,7 +265,18 @@ func (s *StoredAsk) saveAsk(a *storagemarket.SignedStorageAsk) error {
 	if err := s.ds.Put(s.dsKey, b); err != nil {
 		return err
 	}
+	if err := s.ds.Put(s.historyKey().ChildString(strconv.FormatUint(a.Ask.SeqNo, 10)), b); err != nil {
+		return err
+	}
 
 	s.ask = a
+	s.history = append(s.history, a)
+	for len(s.history) > AskHistoryLength {
+		oldest := s.history[0].Ask.SeqNo
+		if err := s.ds.Delete(s.historyKey().ChildString(strconv.FormatUint(oldest, 10))); err != nil {
+			log.Warnf("failed to delete ask %d from history: %s", oldest, err)
+		}
+		s.history = s.history[1:]
+	}
 	return nil
 }
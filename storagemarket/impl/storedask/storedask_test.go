@@ -134,6 +134,57 @@ func TestPieceSizeLimits(t *testing.T) {
 	require.EqualValues(t, newMax, ask.Ask.MaxPieceSize)
 }
 
+func TestAskHistory(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	spn := &testnodes.FakeProviderNode{
+		FakeCommonNode: testnodes.FakeCommonNode{
+			SMState: testnodes.NewStorageMarketState(),
+		},
+	}
+	actor := address.TestAddress2
+	spn.SMState.Epoch = 10
+	sa, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor)
+	require.NoError(t, err)
+
+	// set a new ask every 10 epochs, with a price that gives away when it was set
+	for epoch := abi.ChainEpoch(20); epoch <= 40; epoch += 10 {
+		spn.SMState.Epoch = epoch
+		price := abi.NewTokenAmount(int64(epoch))
+		require.NoError(t, sa.SetAsk(price, price, storedask.DefaultDuration))
+	}
+
+	checkHistory := func(t *testing.T, sa *storedask.StoredAsk) {
+		require.Nil(t, sa.GetAskAt(9))
+		require.Equal(t, storedask.DefaultPrice, sa.GetAskAt(10).Ask.Price)
+		require.Equal(t, storedask.DefaultPrice, sa.GetAskAt(19).Ask.Price)
+		require.Equal(t, abi.NewTokenAmount(20), sa.GetAskAt(20).Ask.Price)
+		require.Equal(t, uint64(1), sa.GetAskAt(29).Ask.SeqNo)
+		require.Equal(t, abi.NewTokenAmount(40), sa.GetAskAt(100).Ask.Price)
+		require.Equal(t, sa.GetAsk(), sa.GetAskAt(40))
+	}
+	t.Run("looks up the ask in effect at an epoch", func(t *testing.T) {
+		checkHistory(t, sa)
+	})
+	t.Run("reloads history from disk", func(t *testing.T) {
+		sa2, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor)
+		require.NoError(t, err)
+		checkHistory(t, sa2)
+	})
+	t.Run("keeps a limited number of asks", func(t *testing.T) {
+		for i := 0; i < storedask.AskHistoryLength; i++ {
+			spn.SMState.Epoch++
+			require.NoError(t, sa.SetAsk(storedask.DefaultPrice, storedask.DefaultVerifiedPrice, storedask.DefaultDuration))
+		}
+		require.Nil(t, sa.GetAskAt(40))
+		require.NotNil(t, sa.GetAskAt(41))
+
+		sa2, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor)
+		require.NoError(t, err)
+		require.Nil(t, sa2.GetAskAt(40))
+		require.Equal(t, sa.GetAskAt(41), sa2.GetAskAt(41))
+	})
+}
+
 func TestMigrations(t *testing.T) {
 	ctx := context.Background()
 	ds := dss.MutexWrap(datastore.NewMapDatastore())
@@ -181,3 +232,64 @@ func TestMigrations(t *testing.T) {
 	}
 	require.Equal(t, expectedAsk, ask.Ask)
 }
+
+func TestDealDurationLimits(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	spn := &testnodes.FakeProviderNode{
+		FakeCommonNode: testnodes.FakeCommonNode{
+			SMState: testnodes.NewStorageMarketState(),
+		},
+	}
+	actor := address.TestAddress2
+	min := abi.ChainEpoch(180 * 2880)
+	max := abi.ChainEpoch(365 * 2880)
+	sa, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor, storagemarket.MinDealDuration(min), storagemarket.MaxDealDuration(max))
+	require.NoError(t, err)
+	ask := sa.GetAsk()
+	require.Equal(t, min, ask.Ask.MinDealDuration)
+	require.Equal(t, max, ask.Ask.MaxDealDuration)
+
+	// SetAsk should not clobber previously-set limits
+	require.NoError(t, sa.SetAsk(ask.Ask.Price, ask.Ask.VerifiedPrice, ask.Ask.Expiry))
+	ask = sa.GetAsk()
+	require.Equal(t, min, ask.Ask.MinDealDuration)
+	require.Equal(t, max, ask.Ask.MaxDealDuration)
+
+	// the limits survive a restart
+	sa2, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor)
+	require.NoError(t, err)
+	require.Equal(t, ask, sa2.GetAsk())
+}
+
+func TestMigrationsFromVersion1(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	spn := &testnodes.FakeProviderNode{
+		FakeCommonNode: testnodes.FakeCommonNode{
+			SMState: testnodes.NewStorageMarketState(),
+		},
+	}
+	actor := address.TestAddress2
+	oldAsk := &migrations.StorageAsk1{
+		Price:         abi.NewTokenAmount(rand.Int63()),
+		VerifiedPrice: abi.NewTokenAmount(rand.Int63()),
+		MinPieceSize:  abi.PaddedPieceSize(rand.Uint64()),
+		MaxPieceSize:  abi.PaddedPieceSize(rand.Uint64()),
+		Miner:         address.TestAddress2,
+		Timestamp:     abi.ChainEpoch(rand.Int63()),
+		Expiry:        abi.ChainEpoch(rand.Int63()),
+		SeqNo:         rand.Uint64(),
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, (&migrations.SignedStorageAsk1{Ask: oldAsk}).MarshalCBOR(buf))
+	require.NoError(t, ds.Put(datastore.NewKey("/1/latest-ask"), buf.Bytes()))
+	require.NoError(t, ds.Put(datastore.NewKey("/versions/current"), []byte("1")))
+
+	storedAsk, err := storedask.NewStoredAsk(ds, datastore.NewKey("latest-ask"), spn, actor)
+	require.NoError(t, err)
+	ask := storedAsk.GetAsk()
+	require.Equal(t, migrations.MigrateStorageAsk1To2(oldAsk), ask.Ask)
+	require.Zero(t, ask.Ask.MinDealDuration)
+	require.Zero(t, ask.Ask.MaxDealDuration)
+	// the migrated ask is signed again, as its encoding has changed
+	require.NotNil(t, ask.Signature)
+}
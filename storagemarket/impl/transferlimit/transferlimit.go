@@ -0,0 +1,178 @@
+/*
+Package transferlimit caps how many deals from the same client a storage provider
+receives data for at once, so that one client cannot monopolize the provider's
+ingest bandwidth.
+
+Each client has a number of transfer slots. A deal that is accepted while all of its
+client's slots are taken waits in a queue, and is given a slot when an earlier deal
+from the same client finishes transferring. Deals from a client are given slots in
+the order they were queued.
+*/
+package transferlimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type clientTransfers struct {
+	active map[cid.Cid]time.Time
+	queue  []cid.Cid
+}
+
+// Limiter tracks the transfer slots of each client
+type Limiter struct {
+	maxPerClient int
+
+	lk          sync.Mutex
+	clients     map[peer.ID]*clientTransfers
+	avgTransfer time.Duration
+	now         func() time.Time
+}
+
+// New returns a Limiter that gives each client maxPerClient transfer slots
+func New(maxPerClient int) *Limiter {
+	return &Limiter{
+		maxPerClient: maxPerClient,
+		clients:      make(map[peer.ID]*clientTransfers),
+		now:          time.Now,
+	}
+}
+
+// Acquire returns true if the deal holds a transfer slot, giving it one if the client
+// has one free and no earlier deals are queued. Otherwise the deal is queued, and
+// Acquire returns false along with the time a slot is expected to open for it. The
+// time is zero until enough transfers have finished to estimate it
+func (l *Limiter) Acquire(client peer.ID, proposalCid cid.Cid) (bool, time.Time) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	ct := l.client(client)
+	if _, ok := ct.active[proposalCid]; ok {
+		return true, time.Time{}
+	}
+
+	position := -1
+	for i, queued := range ct.queue {
+		if queued == proposalCid {
+			position = i
+			break
+		}
+	}
+	if position == -1 {
+		position = len(ct.queue)
+		ct.queue = append(ct.queue, proposalCid)
+	}
+	if position == 0 && len(ct.active) < l.maxPerClient {
+		ct.queue = ct.queue[1:]
+		ct.active[proposalCid] = l.now()
+		return true, time.Time{}
+	}
+	return false, l.expectedStart(position)
+}
+
+// Restore gives a deal a transfer slot without checking the client's limit. It is used
+// for deals that were already transferring when the provider restarted
+func (l *Limiter) Restore(client peer.ID, proposalCid cid.Cid) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.client(client).active[proposalCid] = l.now()
+}
+
+// Release frees the deal's transfer slot, or removes it from the queue. If that lets
+// the next queued deal from the client start, the deal is given the slot and returned
+func (l *Limiter) Release(client peer.ID, proposalCid cid.Cid) (cid.Cid, bool) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	ct, ok := l.clients[client]
+	if !ok {
+		return cid.Undef, false
+	}
+	defer func() {
+		if len(ct.active) == 0 && len(ct.queue) == 0 {
+			delete(l.clients, client)
+		}
+	}()
+
+	started, ok := ct.active[proposalCid]
+	if !ok {
+		for i, queued := range ct.queue {
+			if queued == proposalCid {
+				ct.queue = append(ct.queue[:i], ct.queue[i+1:]...)
+				break
+			}
+		}
+		return cid.Undef, false
+	}
+
+	delete(ct.active, proposalCid)
+	l.recordTransfer(l.now().Sub(started))
+	if len(ct.queue) == 0 || len(ct.active) >= l.maxPerClient {
+		return cid.Undef, false
+	}
+	next := ct.queue[0]
+	ct.queue = ct.queue[1:]
+	ct.active[next] = l.now()
+	return next, true
+}
+
+// MaxPerClient returns the number of transfer slots each client has
+func (l *Limiter) MaxPerClient() int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	return l.maxPerClient
+}
+
+// SetMaxPerClient changes the number of transfer slots each client has. If that frees
+// slots for queued deals, they are given the slots and returned. Lowering the limit
+// does not take slots from deals that already hold them
+func (l *Limiter) SetMaxPerClient(maxPerClient int) []cid.Cid {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.maxPerClient = maxPerClient
+	var started []cid.Cid
+	for _, ct := range l.clients {
+		for len(ct.queue) > 0 && len(ct.active) < l.maxPerClient {
+			next := ct.queue[0]
+			ct.queue = ct.queue[1:]
+			ct.active[next] = l.now()
+			started = append(started, next)
+		}
+	}
+	return started
+}
+
+func (l *Limiter) client(client peer.ID) *clientTransfers {
+	ct, ok := l.clients[client]
+	if !ok {
+		ct = &clientTransfers{active: make(map[cid.Cid]time.Time)}
+		l.clients[client] = ct
+	}
+	return ct
+}
+
+// recordTransfer adds a finished transfer to a moving average of how long transfers take
+func (l *Limiter) recordTransfer(d time.Duration) {
+	if l.avgTransfer == 0 {
+		l.avgTransfer = d
+		return
+	}
+	l.avgTransfer = (4*l.avgTransfer + d) / 5
+}
+
+// expectedStart estimates when a slot opens for the deal at the given queue position,
+// assuming slots open at the average transfer rate
+func (l *Limiter) expectedStart(position int) time.Time {
+	if l.avgTransfer == 0 {
+		return time.Time{}
+	}
+	rounds := position/l.maxPerClient + 1
+	return l.now().Add(time.Duration(rounds) * l.avgTransfer)
+}
@@ -0,0 +1,106 @@
+package transferlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+)
+
+func TestLimiter(t *testing.T) {
+	peers := shared_testutil.GeneratePeers(2)
+	client, otherClient := peers[0], peers[1]
+	deals := shared_testutil.GenerateCids(4)
+
+	now := time.Now()
+	l := New(2)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Acquire(client, deals[0])
+	require.True(t, ok)
+	ok, _ = l.Acquire(client, deals[1])
+	require.True(t, ok)
+
+	// acquiring again is idempotent
+	ok, _ = l.Acquire(client, deals[0])
+	require.True(t, ok)
+
+	// the client's slots are full, so the next deals queue, with no estimate yet
+	ok, expectedStart := l.Acquire(client, deals[2])
+	require.False(t, ok)
+	require.True(t, expectedStart.IsZero())
+	ok, _ = l.Acquire(client, deals[3])
+	require.False(t, ok)
+
+	// other clients have their own slots
+	ok, _ = l.Acquire(otherClient, shared_testutil.GenerateCids(1)[0])
+	require.True(t, ok)
+
+	// finishing a transfer gives its slot to the first queued deal
+	now = now.Add(time.Minute)
+	next, ok := l.Release(client, deals[0])
+	require.True(t, ok)
+	require.Equal(t, deals[2], next)
+	ok, _ = l.Acquire(client, deals[2])
+	require.True(t, ok)
+
+	// the queued deal now has an estimate based on the finished transfer
+	ok, expectedStart = l.Acquire(client, deals[3])
+	require.False(t, ok)
+	require.Equal(t, now.Add(time.Minute), expectedStart)
+
+	// removing a queued deal does not open a slot
+	_, ok = l.Release(client, deals[3])
+	require.False(t, ok)
+	_, ok = l.Release(client, deals[1])
+	require.False(t, ok)
+	_, ok = l.Release(client, deals[2])
+	require.False(t, ok)
+	require.NotContains(t, l.clients, client)
+}
+
+func TestRestore(t *testing.T) {
+	client := shared_testutil.GeneratePeers(1)[0]
+	deals := shared_testutil.GenerateCids(3)
+	l := New(1)
+
+	l.Restore(client, deals[0])
+	l.Restore(client, deals[1])
+	ok, _ := l.Acquire(client, deals[2])
+	require.False(t, ok)
+
+	_, ok = l.Release(client, deals[0])
+	require.False(t, ok)
+	next, ok := l.Release(client, deals[1])
+	require.True(t, ok)
+	require.Equal(t, deals[2], next)
+}
+
+func TestSetMaxPerClient(t *testing.T) {
+	client := shared_testutil.GeneratePeers(1)[0]
+	deals := shared_testutil.GenerateCids(4)
+	l := New(1)
+
+	ok, _ := l.Acquire(client, deals[0])
+	require.True(t, ok)
+	for _, deal := range deals[1:] {
+		ok, _ = l.Acquire(client, deal)
+		require.False(t, ok)
+	}
+
+	// raising the limit starts queued deals in order
+	require.Equal(t, deals[1:3], l.SetMaxPerClient(3))
+	require.Equal(t, 3, l.MaxPerClient())
+
+	// lowering it keeps the slots that are held, and the queue waits for them
+	require.Empty(t, l.SetMaxPerClient(1))
+	_, ok = l.Release(client, deals[0])
+	require.False(t, ok)
+	_, ok = l.Release(client, deals[1])
+	require.False(t, ok)
+	next, ok := l.Release(client, deals[2])
+	require.True(t, ok)
+	require.Equal(t, deals[3], next)
+}
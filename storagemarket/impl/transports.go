@@ -0,0 +1,90 @@
+package storageimpl
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// DataTransferTransport registers a data transfer manager the client can send deal
+// data with, such as graphsync on another host, under the given transfer type. A
+// deal uses the transport named by the TransferType of its DataRef. Deals that leave
+// the TransferType empty use the first transport the provider accepts, trying
+// graphsync on the client's own manager first and then the other transports in the
+// order they were registered
+func DataTransferTransport(transferType string, dataTransfer datatransfer.Manager) StorageClientOption {
+	return func(c *Client) {
+		if _, ok := c.transports[transferType]; !ok {
+			c.transferTypes = append(c.transferTypes, transferType)
+		}
+		c.transports[transferType] = dataTransfer
+	}
+}
+
+// transport returns the data transfer manager for a transfer type, or the client's
+// own manager for deals that were made before transports could be chosen
+func (c *Client) transport(transferType string) datatransfer.Manager {
+	if dt, ok := c.transports[transferType]; ok {
+		return dt
+	}
+	return c.dataTransfer
+}
+
+// selectTransferType returns the data reference for a new deal with the transfer
+// type it will use. An empty transfer type is filled in with the first of the
+// client's transports the provider advertises. Any other transfer type must be one
+// the client has a transport for, and that the provider advertises if it can be
+// asked
+func (c *Client) selectTransferType(ctx context.Context, info *storagemarket.StorageProviderInfo, ref *storagemarket.DataRef) (*storagemarket.DataRef, error) {
+	if ref == nil {
+		return nil, xerrors.New("deal must have a data reference")
+	}
+	switch ref.TransferType {
+	case storagemarket.TTManual, storagemarket.TTExistingPiece, storagemarket.TTGraphsync:
+		return ref, nil
+	case "":
+		if len(c.transferTypes) == 1 {
+			// graphsync is the only transport, so there is nothing to choose
+			return ref, nil
+		}
+	default:
+		if _, ok := c.transports[ref.TransferType]; !ok {
+			return nil, xerrors.Errorf("no data transfer transport registered for transfer type %q", ref.TransferType)
+		}
+	}
+
+	capabilities, err := c.GetProviderCapabilities(ctx, *info)
+	if err != nil {
+		// providers that do not advertise capabilities only accept graphsync
+		log.Warnf("getting capabilities of provider %s: %s", info.Address, err)
+		capabilities = &storagemarket.ProviderCapabilities{TransferTypes: []string{storagemarket.TTGraphsync}}
+	}
+
+	selected := *ref
+	if ref.TransferType != "" {
+		if !containsString(capabilities.TransferTypes, ref.TransferType) {
+			return nil, xerrors.Errorf("provider %s does not accept transfer type %q", info.Address, ref.TransferType)
+		}
+		return &selected, nil
+	}
+	for _, transferType := range c.transferTypes {
+		if containsString(capabilities.TransferTypes, transferType) {
+			selected.TransferType = transferType
+			return &selected, nil
+		}
+	}
+	return nil, xerrors.Errorf("provider %s accepts none of the client's transfer types %v", info.Address, c.transferTypes)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
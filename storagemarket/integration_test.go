@@ -4,10 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p-core/network"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -16,10 +26,14 @@ import (
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/actors/builtin"
 
+	"github.com/filecoin-project/go-fil-markets/dataprep"
+	"github.com/filecoin-project/go-fil-markets/filestore"
 	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/shared_testutil"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	storageimpl "github.com/filecoin-project/go-fil-markets/storagemarket/impl"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/testharness"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/testnodes"
 )
@@ -237,6 +251,181 @@ func TestMakeDealOffline(t *testing.T) {
 	pd = providerDeals[0]
 	assert.True(t, pd.ProposalCid.Equals(proposalCid))
 	shared_testutil.AssertDealState(t, storagemarket.StorageDealExpired, pd.State)
+	// imported data is staged under its content hash, so deals for it share a file
+	require.True(t, filestore.IsStagedPath(pd.PiecePath))
+}
+
+func TestProposeStorageDealFromPath(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	h := testharness.NewHarness(t, ctx, true, noOpDelay, noOpDelay, false)
+	shared_testutil.StartAndWaitForReady(ctx, t, h.Provider)
+	shared_testutil.StartAndWaitForReady(ctx, t, h.Client)
+
+	source, err := ioutil.TempFile("", "deal-from-path")
+	require.NoError(t, err)
+	defer os.Remove(source.Name()) // nolint: errcheck
+	_, err = source.Write(shared_testutil.RandomBytes(1000))
+	require.NoError(t, err)
+	require.NoError(t, source.Close())
+	outDir, err := ioutil.TempDir("", "deal-from-path-out")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir) // nolint: errcheck
+
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	preparer := dataprep.New(dss.MutexWrap(datastore.NewMapDatastore()), merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+		outDir, abi.RegisteredSealProof_StackedDrg2KiBV1)
+	h.Client.(*storageimpl.Client).Configure(storageimpl.DataPreparer(preparer))
+
+	var stages []storagemarket.PathDealStage
+	params := storagemarket.ProposeStorageDealFromPathParams{
+		Deal: storagemarket.ProposeStorageDealParams{
+			Addr:       h.ClientAddr,
+			StartEpoch: h.Epoch + 100,
+			EndEpoch:   h.Epoch + 100 + abi.ChainEpoch(180*builtin.EpochsInDay),
+			Price:      big.NewInt(1),
+			Collateral: big.NewInt(0),
+			Rt:         abi.RegisteredSealProof_StackedDrg2KiBV1,
+		},
+		Progress: func(progress storagemarket.PathDealProgress) {
+			stages = append(stages, progress.Stage)
+		},
+	}
+	result, err := h.Client.ProposeStorageDealFromPath(ctx, source.Name(), &h.ProviderInfo, params)
+	require.NoError(t, err)
+	require.Equal(t, []storagemarket.PathDealStage{
+		storagemarket.PathDealImported,
+		storagemarket.PathDealSplit,
+		storagemarket.PathDealPieceReady,
+		storagemarket.PathDealProposed,
+	}, stages)
+	require.Nil(t, result.Manifest)
+	require.Len(t, result.ProposalCids, 1)
+
+	deal, err := h.Client.GetLocalDeal(ctx, result.ProposalCids[0])
+	require.NoError(t, err)
+	require.Equal(t, result.Root, deal.DataRef.Root)
+	require.Equal(t, storagemarket.TTGraphsync, deal.DataRef.TransferType)
+	require.NotNil(t, deal.StoreID)
+
+	// running the pipeline again proposes nothing new
+	stages = nil
+	again, err := h.Client.ProposeStorageDealFromPath(ctx, source.Name(), &h.ProviderInfo, params)
+	require.NoError(t, err)
+	require.Empty(t, stages)
+	require.Equal(t, result, again)
+	deals, err := h.Client.ListLocalDeals(ctx)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+}
+
+func TestEstimateDealCost(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	h := testharness.NewHarness(t, ctx, true, noOpDelay, noOpDelay, false)
+
+	pieceCid := shared_testutil.GenerateCids(1)[0]
+	params := storagemarket.ProposeStorageDealParams{
+		Addr: h.ClientAddr,
+		Info: &h.ProviderInfo,
+		Data: &storagemarket.DataRef{
+			TransferType: storagemarket.TTManual,
+			Root:         h.PayloadCid,
+			PieceCid:     &pieceCid,
+			PieceSize:    abi.PaddedPieceSize(1024).Unpadded(),
+		},
+		StartEpoch: h.Epoch + 100,
+		EndEpoch:   h.Epoch + 1100,
+		Price:      big.NewInt(2),
+		Rt:         abi.RegisteredSealProof_StackedDrg2KiBV1,
+	}
+
+	t.Run("unverified deal", func(t *testing.T) {
+		h.ClientNode.ReserveFundsFee = big.NewInt(300)
+		estimate, err := h.Client.EstimateDealCost(ctx, params)
+		require.NoError(t, err)
+		require.Equal(t, abi.PaddedPieceSize(1024), estimate.PieceSize)
+		require.Equal(t, big.NewInt(2000), estimate.StorageCost)
+		require.Equal(t, big.Zero(), estimate.ClientCollateral)
+		require.Equal(t, abi.NewTokenAmount(5000), estimate.ProviderCollateral)
+		require.Equal(t, big.NewInt(2000), estimate.EscrowRequired)
+		require.Equal(t, big.NewInt(300), estimate.ReserveFundsFee)
+		require.Equal(t, big.NewInt(2300), estimate.Total)
+		require.Equal(t, big.Zero(), estimate.DataCap)
+		require.Nil(t, estimate.DataCapAvailable)
+	})
+
+	t.Run("verified deal", func(t *testing.T) {
+		dataCap := big.NewInt(1 << 20)
+		h.ClientNode.DataCap = &dataCap
+		verifiedParams := params
+		verifiedParams.VerifiedDeal = true
+		estimate, err := h.Client.EstimateDealCost(ctx, verifiedParams)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(1024), estimate.DataCap)
+		require.Equal(t, &dataCap, estimate.DataCapAvailable)
+	})
+
+	t.Run("piece larger than a sector", func(t *testing.T) {
+		largeParams := params
+		largeParams.Data = &storagemarket.DataRef{
+			TransferType: storagemarket.TTManual,
+			Root:         h.PayloadCid,
+			PieceCid:     &pieceCid,
+			PieceSize:    abi.PaddedPieceSize(h.ProviderInfo.SectorSize * 2).Unpadded(),
+		}
+		_, err := h.Client.EstimateDealCost(ctx, largeParams)
+		require.Error(t, err)
+	})
+}
+
+type fakeAnnouncer struct {
+	announcements chan storagemarket.Announcement
+}
+
+func (fa *fakeAnnouncer) Announce(ctx context.Context, announcement storagemarket.Announcement) error {
+	fa.announcements <- announcement
+	return nil
+}
+
+func TestAnnounceActiveDeal(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	h := testharness.NewHarness(t, ctx, true, noOpDelay, noOpDelay, false)
+
+	announcer := &fakeAnnouncer{announcements: make(chan storagemarket.Announcement, 1)}
+	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/1234")
+	require.NoError(t, err)
+	h.Provider.(*storageimpl.Provider).Configure(storageimpl.AnnounceDeals(announcer, addr))
+	shared_testutil.StartAndWaitForReady(ctx, t, h.Provider)
+	shared_testutil.StartAndWaitForReady(ctx, t, h.Client)
+
+	result := h.ProposeStorageDeal(t, &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: h.PayloadCid}, true, false)
+
+	var announcement storagemarket.Announcement
+	select {
+	case <-ctx.Done():
+		t.Fatal("deal was not announced")
+	case announcement = <-announcer.announcements:
+	}
+
+	providerDeals, err := h.Provider.ListLocalDeals()
+	require.NoError(t, err)
+	require.Len(t, providerDeals, 1)
+	pd := providerDeals[0]
+	require.Equal(t, storagemarket.Announcement{
+		PieceCID:      pd.Proposal.PieceCID,
+		PayloadCID:    h.PayloadCid,
+		DealID:        pd.DealID,
+		ProposalCid:   result.ProposalCid,
+		Provider:      h.ProviderAddr,
+		FastRetrieval: true,
+		PeerID:        h.TestData.Host2.ID(),
+		Addrs:         []ma.Multiaddr{addr},
+	}, announcement)
 }
 
 func TestMakeDealNonBlocking(t *testing.T) {
@@ -279,6 +468,58 @@ func TestMakeDealNonBlocking(t *testing.T) {
 	}, 1*time.Second, 100*time.Millisecond, "actual deal status is %s", storagemarket.DealStates[pd.State])
 }
 
+func TestMakeDealWithNetworkFaults(t *testing.T) {
+	newHarness := func(t *testing.T, ctx context.Context) *testharness.StorageHarness {
+		td := shared_testutil.NewLibp2pTestData(ctx, t, shared_testutil.WithFaultInjection())
+		h := testharness.NewHarnessWithTestData(t, ctx, td, testnodes.NewStorageMarketState(), true, "", noOpDelay, noOpDelay, false)
+		shared_testutil.StartAndWaitForReady(ctx, t, h.Provider)
+		shared_testutil.StartAndWaitForReady(ctx, t, h.Client)
+		require.NoError(t, h.Provider.SetAsk(big.NewInt(0), big.NewInt(0), 50000))
+		return h
+	}
+
+	t.Run("completes a deal over a slow network", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		h := newHarness(t, ctx)
+		h.TestData.Faults1.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDelayStream, Latency: 2 * time.Millisecond})
+		h.TestData.Faults2.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDelayStream, Latency: 2 * time.Millisecond})
+
+		wg := sync.WaitGroup{}
+		h.WaitForClientEvent(&wg, storagemarket.ClientEventDealExpired)
+		h.WaitForProviderEvent(&wg, storagemarket.ProviderEventDealExpired)
+		result := h.ProposeStorageDeal(t, &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: h.PayloadCid}, false, false)
+		waitGroupWait(ctx, &wg)
+
+		cd, err := h.Client.GetLocalDeal(ctx, result.ProposalCid)
+		require.NoError(t, err)
+		shared_testutil.AssertDealState(t, storagemarket.StorageDealExpired, cd.State)
+		require.NotEmpty(t, h.TestData.Faults1.Injected())
+		require.NotEmpty(t, h.TestData.Faults2.Injected())
+	})
+
+	t.Run("fails a deal whose proposal stream is dropped", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		h := newHarness(t, ctx)
+		h.TestData.Faults1.Inject(shared_testutil.Fault{Kind: shared_testutil.FaultDropStream, Protocol: storagemarket.DealProtocolID, Direction: network.DirOutbound})
+
+		wg := sync.WaitGroup{}
+		h.WaitForClientEvent(&wg, storagemarket.ClientEventWriteProposalFailed)
+		result := h.ProposeStorageDeal(t, &storagemarket.DataRef{TransferType: storagemarket.TTGraphsync, Root: h.PayloadCid}, false, false)
+		waitGroupWait(ctx, &wg)
+
+		cd, err := h.Client.GetLocalDeal(ctx, result.ProposalCid)
+		require.NoError(t, err)
+		shared_testutil.AssertDealState(t, storagemarket.StorageDealError, cd.State)
+		require.Contains(t, cd.Message, shared_testutil.ErrStreamDropped.Error())
+
+		providerDeals, err := h.Provider.ListLocalDeals()
+		require.NoError(t, err)
+		require.Empty(t, providerDeals)
+	})
+}
+
 func TestRestartOnlyProviderDataTransfer(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
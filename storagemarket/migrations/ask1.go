@@ -0,0 +1,87 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+//go:generate cbor-gen-for --map-encoding StorageAsk1 SignedStorageAsk1 AskResponse1
+
+// StorageAsk1 is version 1 of StorageAsk, before asks had deal duration limits
+type StorageAsk1 struct {
+	Price         abi.TokenAmount
+	VerifiedPrice abi.TokenAmount
+
+	MinPieceSize abi.PaddedPieceSize
+	MaxPieceSize abi.PaddedPieceSize
+	Miner        address.Address
+	Timestamp    abi.ChainEpoch
+	Expiry       abi.ChainEpoch
+	SeqNo        uint64
+}
+
+// SignedStorageAsk1 is version 1 of SignedStorageAsk
+type SignedStorageAsk1 struct {
+	Ask       *StorageAsk1
+	Signature *crypto.Signature
+}
+
+// AskResponse1 is version 1 of AskResponse, sent on the ask protocol before asks had
+// deal duration limits
+type AskResponse1 struct {
+	Ask *SignedStorageAsk1
+}
+
+// MigrateStorageAsk1To2 migrates a storage ask without deal duration limits to a
+// storage ask with no limits
+func MigrateStorageAsk1To2(oldSa *StorageAsk1) *storagemarket.StorageAsk {
+	return &storagemarket.StorageAsk{
+		Price:         oldSa.Price,
+		VerifiedPrice: oldSa.VerifiedPrice,
+
+		MinPieceSize: oldSa.MinPieceSize,
+		MaxPieceSize: oldSa.MaxPieceSize,
+		Miner:        oldSa.Miner,
+		Timestamp:    oldSa.Timestamp,
+		Expiry:       oldSa.Expiry,
+		SeqNo:        oldSa.SeqNo,
+	}
+}
+
+// StorageAsk2To1 converts a storage ask to one without deal duration limits, for
+// peers that only speak the ask protocol from before asks had them
+func StorageAsk2To1(sa *storagemarket.StorageAsk) *StorageAsk1 {
+	return &StorageAsk1{
+		Price:         sa.Price,
+		VerifiedPrice: sa.VerifiedPrice,
+
+		MinPieceSize: sa.MinPieceSize,
+		MaxPieceSize: sa.MaxPieceSize,
+		Miner:        sa.Miner,
+		Timestamp:    sa.Timestamp,
+		Expiry:       sa.Expiry,
+		SeqNo:        sa.SeqNo,
+	}
+}
+
+// GetMigrateSignedStorageAsk1To2 returns a function that migrates a signed storage ask
+// without deal duration limits to a signed storage ask with no limits. The ask is
+// resigned, as its encoding changes
+func GetMigrateSignedStorageAsk1To2(sign func(ctx context.Context, ask interface{}) (*crypto.Signature, error)) func(*SignedStorageAsk1) (*storagemarket.SignedStorageAsk, error) {
+	return func(oldSsa *SignedStorageAsk1) (*storagemarket.SignedStorageAsk, error) {
+		newSa := MigrateStorageAsk1To2(oldSsa.Ask)
+		sig, err := sign(context.TODO(), newSa)
+		if err != nil {
+			return nil, err
+		}
+		return &storagemarket.SignedStorageAsk{
+			Ask:       newSa,
+			Signature: sig,
+		}, nil
+	}
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+//go:generate cbor-gen-for --map-encoding ProviderDealState1 DealStatusResponse1
+
+// ProviderDealState1 is version 1 of ProviderDealState, before deal status reported
+// the progress of the deal's data transfer and sealing
+type ProviderDealState1 struct {
+	State         storagemarket.StorageDealStatus
+	Message       string
+	Proposal      *market.DealProposal
+	ProposalCid   *cid.Cid
+	AddFundsCid   *cid.Cid
+	PublishCid    *cid.Cid
+	DealID        abi.DealID
+	FastRetrieval bool
+}
+
+// DealStatusResponse1 is version 1 of DealStatusResponse, sent on the deal status
+// protocol before deal status reported the progress of the deal's data transfer and
+// sealing
+type DealStatusResponse1 struct {
+	DealState ProviderDealState1
+	Signature crypto.Signature
+}
+
+// MigrateProviderDealState1To2 migrates a deal state without transfer or sealing
+// progress to a deal state with neither reported
+func MigrateProviderDealState1To2(oldDs ProviderDealState1) storagemarket.ProviderDealState {
+	return storagemarket.ProviderDealState{
+		State:         oldDs.State,
+		Message:       oldDs.Message,
+		Proposal:      oldDs.Proposal,
+		ProposalCid:   oldDs.ProposalCid,
+		AddFundsCid:   oldDs.AddFundsCid,
+		PublishCid:    oldDs.PublishCid,
+		DealID:        oldDs.DealID,
+		FastRetrieval: oldDs.FastRetrieval,
+	}
+}
+
+// ProviderDealState2To1 converts a deal state to one without transfer or sealing
+// progress, for peers that only speak the deal status protocol from before deal
+// status reported them
+func ProviderDealState2To1(ds storagemarket.ProviderDealState) ProviderDealState1 {
+	return ProviderDealState1{
+		State:         ds.State,
+		Message:       ds.Message,
+		Proposal:      ds.Proposal,
+		ProposalCid:   ds.ProposalCid,
+		AddFundsCid:   ds.AddFundsCid,
+		PublishCid:    ds.PublishCid,
+		DealID:        ds.DealID,
+		FastRetrieval: ds.FastRetrieval,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/filestore"
+	"github.com/filecoin-project/go-fil-markets/shared/migrationtools"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 )
 
@@ -218,8 +220,8 @@ func MigrateMinerDeal0To1(oldCd *MinerDeal0) (*storagemarket.MinerDeal, error) {
 }
 
 // MigrateStorageAsk0To1 migrates a tuple encoded storage ask to a map encoded storage ask
-func MigrateStorageAsk0To1(oldSa *StorageAsk0) *storagemarket.StorageAsk {
-	return &storagemarket.StorageAsk{
+func MigrateStorageAsk0To1(oldSa *StorageAsk0) *StorageAsk1 {
+	return &StorageAsk1{
 		Price:         oldSa.Price,
 		VerifiedPrice: oldSa.VerifiedPrice,
 
@@ -234,14 +236,14 @@ func MigrateStorageAsk0To1(oldSa *StorageAsk0) *storagemarket.StorageAsk {
 
 // GetMigrateSignedStorageAsk0To1 returns a function that migrates a tuple encoded signed storage ask to a map encoded signed storage ask
 // It needs a signing function to resign the ask -- there's no way around that
-func GetMigrateSignedStorageAsk0To1(sign func(ctx context.Context, ask *storagemarket.StorageAsk) (*crypto.Signature, error)) func(*SignedStorageAsk0) (*storagemarket.SignedStorageAsk, error) {
-	return func(oldSsa *SignedStorageAsk0) (*storagemarket.SignedStorageAsk, error) {
+func GetMigrateSignedStorageAsk0To1(sign func(ctx context.Context, ask interface{}) (*crypto.Signature, error)) func(*SignedStorageAsk0) (*SignedStorageAsk1, error) {
+	return func(oldSsa *SignedStorageAsk0) (*SignedStorageAsk1, error) {
 		newSa := MigrateStorageAsk0To1(oldSsa.Ask)
 		sig, err := sign(context.TODO(), newSa)
 		if err != nil {
 			return nil, err
 		}
-		return &storagemarket.SignedStorageAsk{
+		return &SignedStorageAsk1{
 			Ask:       newSa,
 			Signature: sig,
 		}, nil
@@ -253,8 +255,24 @@ var ClientMigrations = versioned.BuilderList{
 	versioned.NewVersionedBuilder(MigrateClientDeal0To1, versioning.VersionKey("1")),
 }
 
+// ProviderFilterKeys are the keys in the provider's store of storage deals that hold
+// the storage ask rather than deals
+var ProviderFilterKeys = []string{
+	"/latest-ask", "/storage-ask/latest", "/storage-ask/1/latest", "/storage-ask/2/latest", "/storage-ask/versions/current"}
+
 // ProviderMigrations are migrations for the providers's store of storage deals
 var ProviderMigrations = versioned.BuilderList{
-	versioned.NewVersionedBuilder(MigrateMinerDeal0To1, versioning.VersionKey("1")).FilterKeys([]string{
-		"/latest-ask", "/storage-ask/latest", "/storage-ask/1/latest", "/storage-ask/versions/current"}),
+	versioned.NewVersionedBuilder(MigrateMinerDeal0To1, versioning.VersionKey("1")).FilterKeys(ProviderFilterKeys),
+}
+
+// DryRunClientMigrations reports what the client's store of storage deals would
+// migrate to, without migrating it
+func DryRunClientMigrations(ds datastore.Batching) (migrationtools.Report, error) {
+	return migrationtools.DryRun(ds, MigrateClientDeal0To1, versioning.VersionKey("1"), nil)
+}
+
+// DryRunProviderMigrations reports what the provider's store of storage deals would
+// migrate to, without migrating it
+func DryRunProviderMigrations(ds datastore.Batching) (migrationtools.Report, error) {
+	return migrationtools.DryRun(ds, MigrateMinerDeal0To1, versioning.VersionKey("1"), ProviderFilterKeys)
 }
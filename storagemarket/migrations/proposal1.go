@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+//go:generate cbor-gen-for --map-encoding DataRef1 Proposal1 Response1 SignedResponse1
+
+// DataRef1 is version 1 of DataRef, before clients could authorize a transfer agent
+// to push the data
+type DataRef1 struct {
+	TransferType string
+	Root         cid.Cid
+
+	PieceCid  *cid.Cid
+	PieceSize abi.UnpaddedPieceSize
+}
+
+// Proposal1 is version 1 of Proposal, sent on the deal protocol before proposals
+// carried invoices or peer signatures, or data refs named a transfer agent
+type Proposal1 struct {
+	DealProposal  *market.ClientDealProposal
+	Piece         *DataRef1
+	FastRetrieval bool
+}
+
+// Response1 is version 1 of Response, sent before providers could ask clients to
+// propose a rejected deal again later
+type Response1 struct {
+	State storagemarket.StorageDealStatus
+
+	Message  string
+	Proposal cid.Cid
+
+	PublishMessage *cid.Cid
+}
+
+// SignedResponse1 is version 1 of SignedResponse
+type SignedResponse1 struct {
+	Response Response1
+
+	Signature *crypto.Signature
+}
+
+// MigrateDataRef1To2 migrates a data ref without a transfer agent to a data ref
+// whose data the client pushes itself
+func MigrateDataRef1To2(oldDr *DataRef1) *storagemarket.DataRef {
+	if oldDr == nil {
+		return nil
+	}
+	return &storagemarket.DataRef{
+		TransferType: oldDr.TransferType,
+		Root:         oldDr.Root,
+		PieceCid:     oldDr.PieceCid,
+		PieceSize:    oldDr.PieceSize,
+	}
+}
+
+// ErrTransferAgentUnsupported is returned when a data ref names a transfer agent but
+// the peer only speaks a deal protocol from before data refs had one
+var ErrTransferAgentUnsupported = xerrors.New("provider does not support transfer agents")
+
+// DataRef2To1 converts a data ref to one without a transfer agent, for peers that
+// only speak the deal protocol from before data refs had one. It fails for data refs
+// that name a transfer agent, as the provider would refuse the agent's push
+func DataRef2To1(dr *storagemarket.DataRef) (*DataRef1, error) {
+	if dr == nil {
+		return nil, nil
+	}
+	if dr.TransferAgent != "" {
+		return nil, ErrTransferAgentUnsupported
+	}
+	return &DataRef1{
+		TransferType: dr.TransferType,
+		Root:         dr.Root,
+		PieceCid:     dr.PieceCid,
+		PieceSize:    dr.PieceSize,
+	}, nil
+}
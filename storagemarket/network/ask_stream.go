@@ -7,6 +7,8 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 )
 
 type askStream struct {
@@ -20,7 +22,7 @@ var _ StorageAskStream = (*askStream)(nil)
 func (as *askStream) ReadAskRequest() (AskRequest, error) {
 	var a AskRequest
 
-	if err := a.UnmarshalCBOR(as.buffered); err != nil {
+	if err := cborlimit.Read(as.buffered, &a); err != nil {
 		log.Warn(err)
 		return AskRequestUndefined, err
 
@@ -36,7 +38,7 @@ func (as *askStream) WriteAskRequest(q AskRequest) error {
 func (as *askStream) ReadAskResponse() (AskResponse, []byte, error) {
 	var resp AskResponse
 
-	if err := resp.UnmarshalCBOR(as.buffered); err != nil {
+	if err := cborlimit.Read(as.buffered, &resp); err != nil {
 		log.Warn(err)
 		return AskResponseUndefined, nil, err
 	}
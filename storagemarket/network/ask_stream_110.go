@@ -0,0 +1,80 @@
+package network
+
+import (
+	"bufio"
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+// askStream110 speaks version 1.1.0 of the ask protocol, whose asks have no
+// deal duration limits
+type askStream110 struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ StorageAskStream = (*askStream110)(nil)
+
+func (as *askStream110) ReadAskRequest() (AskRequest, error) {
+	var a AskRequest
+
+	if err := cborlimit.Read(as.buffered, &a); err != nil {
+		log.Warn(err)
+		return AskRequestUndefined, err
+
+	}
+
+	return a, nil
+}
+
+func (as *askStream110) WriteAskRequest(q AskRequest) error {
+	return cborutil.WriteCborRPC(as.rw, &q)
+}
+
+func (as *askStream110) ReadAskResponse() (AskResponse, []byte, error) {
+	var resp migrations.AskResponse1
+
+	if err := cborlimit.Read(as.buffered, &resp); err != nil {
+		log.Warn(err)
+		return AskResponseUndefined, nil, err
+	}
+
+	origBytes, err := cborutil.Dump(resp.Ask.Ask)
+	if err != nil {
+		log.Warn(err)
+		return AskResponseUndefined, nil, err
+	}
+	return AskResponse{
+		Ask: &storagemarket.SignedStorageAsk{
+			Ask:       migrations.MigrateStorageAsk1To2(resp.Ask.Ask),
+			Signature: resp.Ask.Signature,
+		},
+	}, origBytes, nil
+}
+
+func (as *askStream110) WriteAskResponse(qr AskResponse, resign ResigningFunc) error {
+	oldAsk := migrations.StorageAsk2To1(qr.Ask.Ask)
+	oldSig, err := resign(context.TODO(), oldAsk)
+	if err != nil {
+		return err
+	}
+	return cborutil.WriteCborRPC(as.rw, &migrations.AskResponse1{
+		Ask: &migrations.SignedStorageAsk1{
+			Ask:       oldAsk,
+			Signature: oldSig,
+		},
+	})
+}
+
+func (as *askStream110) Close() error {
+	return as.rw.Close()
+}
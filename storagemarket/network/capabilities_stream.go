@@ -0,0 +1,41 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type capabilitiesStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ CapabilitiesStream = (*capabilitiesStream)(nil)
+
+func (c *capabilitiesStream) ReadCapabilitiesResponse() (CapabilitiesResponse, error) {
+	var cr CapabilitiesResponse
+
+	if err := cborlimit.Read(c.buffered, &cr); err != nil {
+		return CapabilitiesResponseUndefined, err
+	}
+	return cr, nil
+}
+
+func (c *capabilitiesStream) WriteCapabilitiesResponse(cr CapabilitiesResponse) error {
+	return cborutil.WriteCborRPC(c.rw, &cr)
+}
+
+func (c *capabilitiesStream) Close() error {
+	return c.rw.Close()
+}
+
+func (c *capabilitiesStream) RemotePeer() peer.ID {
+	return c.p
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type dealNotificationStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ DealNotificationStream = (*dealNotificationStream)(nil)
+
+func (d *dealNotificationStream) ReadDealNotification() (SignedDealNotification, error) {
+	var n SignedDealNotification
+
+	if err := cborlimit.Read(d.buffered, &n); err != nil {
+		log.Warn(err)
+		return SignedDealNotificationUndefined, err
+	}
+	return n, nil
+}
+
+func (d *dealNotificationStream) WriteDealNotification(n SignedDealNotification) error {
+	return cborutil.WriteCborRPC(d.rw, &n)
+}
+
+func (d *dealNotificationStream) Close() error {
+	return d.rw.Close()
+}
+
+func (d *dealNotificationStream) RemotePeer() peer.ID {
+	return d.p
+}
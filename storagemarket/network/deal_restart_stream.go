@@ -0,0 +1,55 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+type dealRestartStream struct {
+	p        peer.ID
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ DealRestartStream = (*dealRestartStream)(nil)
+
+func (d *dealRestartStream) ReadDealRestartRequest() (DealRestartRequest, error) {
+	var q DealRestartRequest
+
+	if err := cborlimit.Read(d.buffered, &q); err != nil {
+		log.Warn(err)
+		return DealRestartRequestUndefined, err
+	}
+	return q, nil
+}
+
+func (d *dealRestartStream) WriteDealRestartRequest(q DealRestartRequest) error {
+	return cborutil.WriteCborRPC(d.rw, &q)
+}
+
+func (d *dealRestartStream) ReadDealRestartResponse() (DealRestartResponse, error) {
+	var qr DealRestartResponse
+
+	if err := cborlimit.Read(d.buffered, &qr); err != nil {
+		return DealRestartResponseUndefined, err
+	}
+	return qr, nil
+}
+
+func (d *dealRestartStream) WriteDealRestartResponse(qr DealRestartResponse) error {
+	return cborutil.WriteCborRPC(d.rw, &qr)
+}
+
+func (d *dealRestartStream) Close() error {
+	return d.rw.Close()
+}
+
+func (d *dealRestartStream) RemotePeer() peer.ID {
+	return d.p
+}
@@ -6,8 +6,12 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
 )
 
 type dealStatusStream struct {
@@ -22,7 +26,7 @@ var _ DealStatusStream = (*dealStatusStream)(nil)
 func (d *dealStatusStream) ReadDealStatusRequest() (DealStatusRequest, error) {
 	var q DealStatusRequest
 
-	if err := q.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &q); err != nil {
 		log.Warn(err)
 		return DealStatusRequestUndefined, err
 	}
@@ -36,7 +40,7 @@ func (d *dealStatusStream) WriteDealStatusRequest(q DealStatusRequest) error {
 func (d *dealStatusStream) ReadDealStatusResponse() (DealStatusResponse, []byte, error) {
 	var qr DealStatusResponse
 
-	if err := qr.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &qr); err != nil {
 		return DealStatusResponseUndefined, nil, err
 	}
 
@@ -51,6 +55,10 @@ func (d *dealStatusStream) WriteDealStatusResponse(qr DealStatusResponse, _ Resi
 	return cborutil.WriteCborRPC(d.rw, &qr)
 }
 
+func (d *dealStatusStream) Protocol() protocol.ID {
+	return storagemarket.DealStatusProtocolID
+}
+
 func (d *dealStatusStream) Close() error {
 	return d.rw.Close()
 }
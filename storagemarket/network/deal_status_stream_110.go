@@ -0,0 +1,83 @@
+package network
+
+import (
+	"bufio"
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+// dealStatusStream110 speaks version 1.1.0 of the deal status protocol, whose deal
+// states do not report the progress of the deal's data transfer or sealing
+type dealStatusStream110 struct {
+	p        peer.ID
+	host     host.Host
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ DealStatusStream = (*dealStatusStream110)(nil)
+
+func (d *dealStatusStream110) ReadDealStatusRequest() (DealStatusRequest, error) {
+	var q DealStatusRequest
+
+	if err := cborlimit.Read(d.buffered, &q); err != nil {
+		log.Warn(err)
+		return DealStatusRequestUndefined, err
+	}
+	return q, nil
+}
+
+func (d *dealStatusStream110) WriteDealStatusRequest(q DealStatusRequest) error {
+	return cborutil.WriteCborRPC(d.rw, &q)
+}
+
+func (d *dealStatusStream110) ReadDealStatusResponse() (DealStatusResponse, []byte, error) {
+	var qr migrations.DealStatusResponse1
+
+	if err := cborlimit.Read(d.buffered, &qr); err != nil {
+		return DealStatusResponseUndefined, nil, err
+	}
+
+	origBytes, err := cborutil.Dump(&qr.DealState)
+	if err != nil {
+		return DealStatusResponseUndefined, nil, err
+	}
+	return DealStatusResponse{
+		DealState: migrations.MigrateProviderDealState1To2(qr.DealState),
+		Signature: qr.Signature,
+	}, origBytes, nil
+}
+
+func (d *dealStatusStream110) WriteDealStatusResponse(qr DealStatusResponse, resign ResigningFunc) error {
+	oldDs := migrations.ProviderDealState2To1(qr.DealState)
+	oldSig, err := resign(context.TODO(), &oldDs)
+	if err != nil {
+		return err
+	}
+	return cborutil.WriteCborRPC(d.rw, &migrations.DealStatusResponse1{
+		DealState: oldDs,
+		Signature: *oldSig,
+	})
+}
+
+func (d *dealStatusStream110) Protocol() protocol.ID {
+	return storagemarket.DealStatusProtocolID110
+}
+
+func (d *dealStatusStream110) Close() error {
+	return d.rw.Close()
+}
+
+func (d *dealStatusStream110) RemotePeer() peer.ID {
+	return d.p
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 )
 
 // TagPriority is the priority for deal streams -- they should generally be preserved above all else
@@ -25,7 +27,7 @@ var _ StorageDealStream = (*dealStream)(nil)
 func (d *dealStream) ReadDealProposal() (Proposal, error) {
 	var ds Proposal
 
-	if err := ds.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &ds); err != nil {
 		log.Warn(err)
 		return ProposalUndefined, err
 	}
@@ -39,7 +41,7 @@ func (d *dealStream) WriteDealProposal(dp Proposal) error {
 func (d *dealStream) ReadDealResponse() (SignedResponse, []byte, error) {
 	var dr SignedResponse
 
-	if err := dr.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &dr); err != nil {
 		return SignedResponseUndefined, nil, err
 	}
 	origBytes, err := cborutil.Dump(&dr.Response)
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"bufio"
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+// dealStream110 speaks version 1.1.0 of the deal protocol, whose proposals carry no
+// invoice
+type dealStream110 struct {
+	p        peer.ID
+	host     host.Host
+	rw       mux.MuxedStream
+	buffered *bufio.Reader
+}
+
+var _ StorageDealStream = (*dealStream110)(nil)
+
+func (d *dealStream110) ReadDealProposal() (Proposal, error) {
+	var ds migrations.Proposal1
+
+	if err := cborlimit.Read(d.buffered, &ds); err != nil {
+		log.Warn(err)
+		return ProposalUndefined, err
+	}
+	return Proposal{
+		DealProposal:  ds.DealProposal,
+		Piece:         migrations.MigrateDataRef1To2(ds.Piece),
+		FastRetrieval: ds.FastRetrieval,
+	}, nil
+}
+
+func (d *dealStream110) WriteDealProposal(dp Proposal) error {
+	piece, err := migrations.DataRef2To1(dp.Piece)
+	if err != nil {
+		return err
+	}
+	return cborutil.WriteCborRPC(d.rw, &migrations.Proposal1{
+		DealProposal:  dp.DealProposal,
+		Piece:         piece,
+		FastRetrieval: dp.FastRetrieval,
+	})
+}
+
+func (d *dealStream110) ReadDealResponse() (SignedResponse, []byte, error) {
+	var dr migrations.SignedResponse1
+
+	if err := cborlimit.Read(d.buffered, &dr); err != nil {
+		return SignedResponseUndefined, nil, err
+	}
+	origBytes, err := cborutil.Dump(&dr.Response)
+	if err != nil {
+		return SignedResponseUndefined, nil, err
+	}
+	return SignedResponse{
+		Response: Response{
+			State:          dr.Response.State,
+			Message:        dr.Response.Message,
+			Proposal:       dr.Response.Proposal,
+			PublishMessage: dr.Response.PublishMessage,
+		},
+		Signature: dr.Signature,
+	}, origBytes, nil
+}
+
+func (d *dealStream110) WriteDealResponse(dr SignedResponse, resign ResigningFunc) error {
+	oldResponse := migrations.Response1{
+		State:          dr.Response.State,
+		Message:        dr.Response.Message,
+		Proposal:       dr.Response.Proposal,
+		PublishMessage: dr.Response.PublishMessage,
+	}
+	oldSig, err := resign(context.TODO(), &oldResponse)
+	if err != nil {
+		return err
+	}
+	return cborutil.WriteCborRPC(d.rw, &migrations.SignedResponse1{
+		Response:  oldResponse,
+		Signature: oldSig,
+	})
+}
+
+func (d *dealStream110) Close() error {
+	return d.rw.Close()
+}
+
+func (d *dealStream110) RemotePeer() peer.ID {
+	return d.p
+}
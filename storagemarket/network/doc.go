@@ -3,9 +3,17 @@ Package network providers an abstraction over a libp2p host for managing storage
 
 network.go - defines the interfaces that must be implemented to serve as a storage network layer
 deal_stream.go - implements the `StorageDealStream` interface, a data stream for proposing storage deals
+multiplexed_deal_stream.go - implements the `StorageDealStream` interface for many deals sharing one long-lived stream
 ask_stream.go  - implements the `StorageAskStream` interface, a data stream for querying provider asks
 deal_status_stream.go - implements the `StorageDealStatusStream` interface, a data stream for querying for deal status
+deal_restart_stream.go - implements the `DealRestartStream` interface, a data stream for negotiating how to resume a deal after a restart
 libp2p_impl.go - provides the production implementation of the `StorageMarketNetwork` interface.
+protocols.go - registers each version of the storage market protocols, and the stream type that speaks it, with shared/protoregistry
+legacy.go - helpers for the legacy_*_stream.go implementations of the 1.0.1 protocols spoken by v0.x clients, which the LegacyProtocols option turns off
 types.go - types for messages sent on the storage market libp2p protocols
+fuzz.go - the go-fuzz entry point for the messages read from storage market streams, built with the gofuzz tag
+
+Messages are read from streams with shared/cborlimit, which rejects messages over its size and nesting limits
+before decoding them, so that a malformed message from a peer cannot crash the node or exhaust its memory.
 */
 package network
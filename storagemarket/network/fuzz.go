@@ -0,0 +1,48 @@
+//go:build gofuzz
+// +build gofuzz
+
+package network
+
+import (
+	"bytes"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+// fuzzMessages are the messages read from storage market streams
+var fuzzMessages = []func() cbg.CBORUnmarshaler{
+	func() cbg.CBORUnmarshaler { return new(Proposal) },
+	func() cbg.CBORUnmarshaler { return new(SignedResponse) },
+	func() cbg.CBORUnmarshaler { return new(DealMessage) },
+	func() cbg.CBORUnmarshaler { return new(AskRequest) },
+	func() cbg.CBORUnmarshaler { return new(AskResponse) },
+	func() cbg.CBORUnmarshaler { return new(DealStatusRequest) },
+	func() cbg.CBORUnmarshaler { return new(DealStatusResponse) },
+	func() cbg.CBORUnmarshaler { return new(DealRestartRequest) },
+	func() cbg.CBORUnmarshaler { return new(DealRestartResponse) },
+	func() cbg.CBORUnmarshaler { return new(CapabilitiesResponse) },
+	func() cbg.CBORUnmarshaler { return new(SignedDealNotification) },
+	func() cbg.CBORUnmarshaler { return new(migrations.Proposal0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.SignedResponse0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.AskRequest0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.AskResponse0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.AskResponse1) },
+	func() cbg.CBORUnmarshaler { return new(migrations.DealStatusRequest0) },
+	func() cbg.CBORUnmarshaler { return new(migrations.DealStatusResponse0) },
+}
+
+// Fuzz is the entry point for go-fuzz. The first byte of data picks the message to
+// decode the rest of it as, the way the network reads messages from streams
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	msg := fuzzMessages[int(data[0])%len(fuzzMessages)]()
+	if err := cborlimit.Read(bytes.NewReader(data[1:]), msg); err != nil {
+		return 0
+	}
+	return 1
+}
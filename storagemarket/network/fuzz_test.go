@@ -0,0 +1,95 @@
+package network_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
+)
+
+func TestMessageDecodingFuzz(t *testing.T) {
+	proposal := shared_testutil.MakeTestStorageNetworkProposal()
+	response := shared_testutil.MakeTestStorageNetworkSignedResponse()
+	askRequest := shared_testutil.MakeTestStorageAskRequest()
+	askResponse := shared_testutil.MakeTestStorageAskResponse()
+	statusRequest := shared_testutil.MakeTestDealStatusRequest()
+	statusResponse := shared_testutil.MakeTestDealStatusResponse()
+	notification := network.SignedDealNotification{
+		Notification: network.DealNotification{
+			Proposal: shared_testutil.GenerateCids(1)[0],
+			State:    storagemarket.StorageDealActive,
+			Message:  "deal activated",
+		},
+		Signature: shared_testutil.MakeTestSignature(),
+	}
+	restart := network.DealRestartRequest{View: network.DealView{
+		Proposal: shared_testutil.GenerateCids(1)[0],
+		State:    storagemarket.StorageDealTransferring,
+	}}
+
+	testCases := map[string]struct {
+		newMsg func() cbg.CBORUnmarshaler
+		seeds  []cbg.CBORMarshaler
+	}{
+		"Proposal": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.Proposal) },
+			seeds:  []cbg.CBORMarshaler{&proposal},
+		},
+		"SignedResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.SignedResponse) },
+			seeds:  []cbg.CBORMarshaler{&response},
+		},
+		"DealMessage": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.DealMessage) },
+			seeds: []cbg.CBORMarshaler{
+				&network.DealMessage{ID: 1, Proposal: &proposal},
+				&network.DealMessage{ID: 2, Response: &response},
+			},
+		},
+		"AskRequest": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.AskRequest) },
+			seeds:  []cbg.CBORMarshaler{&askRequest},
+		},
+		"AskResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.AskResponse) },
+			seeds:  []cbg.CBORMarshaler{&askResponse},
+		},
+		"DealStatusRequest": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.DealStatusRequest) },
+			seeds:  []cbg.CBORMarshaler{&statusRequest},
+		},
+		"DealStatusResponse": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.DealStatusResponse) },
+			seeds:  []cbg.CBORMarshaler{&statusResponse},
+		},
+		"DealRestartRequest": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.DealRestartRequest) },
+			seeds:  []cbg.CBORMarshaler{&restart},
+		},
+		"SignedDealNotification": {
+			newMsg: func() cbg.CBORUnmarshaler { return new(network.SignedDealNotification) },
+			seeds:  []cbg.CBORMarshaler{&notification},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var seeds [][]byte
+			for _, seed := range tc.seeds {
+				encoded, err := cborutil.Dump(seed)
+				require.NoError(t, err)
+				seeds = append(seeds, encoded)
+			}
+			shared_testutil.FuzzDecoder(t, func(data []byte) error {
+				return cborlimit.Read(bytes.NewReader(data), tc.newMsg())
+			}, seeds, 2000)
+		})
+	}
+}
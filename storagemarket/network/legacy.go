@@ -0,0 +1,17 @@
+package network
+
+import (
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
+)
+
+// legacyDataRef translates the data ref of a legacy proposal, filling in the
+// defaults legacy clients relied on. They could only transfer data with graphsync
+// unless they asked for a manual transfer, so an empty transfer type means graphsync
+func legacyDataRef(ref *migrations.DataRef0) *storagemarket.DataRef {
+	dataRef := migrations.MigrateDataRef0To1(ref)
+	if dataRef != nil && dataRef.TransferType == "" {
+		dataRef.TransferType = storagemarket.TTGraphsync
+	}
+	return dataRef
+}
@@ -9,6 +9,7 @@ import (
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
 
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 )
@@ -24,7 +25,7 @@ var _ StorageAskStream = (*legacyAskStream)(nil)
 func (as *legacyAskStream) ReadAskRequest() (AskRequest, error) {
 	var a migrations.AskRequest0
 
-	if err := a.UnmarshalCBOR(as.buffered); err != nil {
+	if err := cborlimit.Read(as.buffered, &a); err != nil {
 		log.Warn(err)
 		return AskRequestUndefined, err
 
@@ -45,7 +46,7 @@ func (as *legacyAskStream) WriteAskRequest(q AskRequest) error {
 func (as *legacyAskStream) ReadAskResponse() (AskResponse, []byte, error) {
 	var resp migrations.AskResponse0
 
-	if err := resp.UnmarshalCBOR(as.buffered); err != nil {
+	if err := cborlimit.Read(as.buffered, &resp); err != nil {
 		log.Warn(err)
 		return AskResponseUndefined, nil, err
 	}
@@ -57,7 +58,7 @@ func (as *legacyAskStream) ReadAskResponse() (AskResponse, []byte, error) {
 	}
 	return AskResponse{
 		Ask: &storagemarket.SignedStorageAsk{
-			Ask:       migrations.MigrateStorageAsk0To1(resp.Ask.Ask),
+			Ask:       migrations.MigrateStorageAsk1To2(migrations.MigrateStorageAsk0To1(resp.Ask.Ask)),
 			Signature: resp.Ask.Signature,
 		},
 	}, origBytes, nil
@@ -7,9 +7,11 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/mux"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
 
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 )
@@ -26,7 +28,7 @@ var _ DealStatusStream = (*legacyDealStatusStream)(nil)
 func (d *legacyDealStatusStream) ReadDealStatusRequest() (DealStatusRequest, error) {
 	var q migrations.DealStatusRequest0
 
-	if err := q.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &q); err != nil {
 		log.Warn(err)
 		return DealStatusRequestUndefined, err
 	}
@@ -46,7 +48,7 @@ func (d *legacyDealStatusStream) WriteDealStatusRequest(q DealStatusRequest) err
 func (d *legacyDealStatusStream) ReadDealStatusResponse() (DealStatusResponse, []byte, error) {
 	var qr migrations.DealStatusResponse0
 
-	if err := qr.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &qr); err != nil {
 		return DealStatusResponseUndefined, nil, err
 	}
 
@@ -90,6 +92,10 @@ func (d *legacyDealStatusStream) WriteDealStatusResponse(qr DealStatusResponse,
 	})
 }
 
+func (d *legacyDealStatusStream) Protocol() protocol.ID {
+	return storagemarket.OldDealStatusProtocolID
+}
+
 func (d *legacyDealStatusStream) Close() error {
 	return d.rw.Close()
 }
@@ -10,6 +10,7 @@ import (
 
 	cborutil "github.com/filecoin-project/go-cbor-util"
 
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 )
 
@@ -25,13 +26,13 @@ var _ StorageDealStream = (*legacyDealStream)(nil)
 func (d *legacyDealStream) ReadDealProposal() (Proposal, error) {
 	var ds migrations.Proposal0
 
-	if err := ds.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &ds); err != nil {
 		log.Warn(err)
 		return ProposalUndefined, err
 	}
 	return Proposal{
 		DealProposal:  ds.DealProposal,
-		Piece:         migrations.MigrateDataRef0To1(ds.Piece),
+		Piece:         legacyDataRef(ds.Piece),
 		FastRetrieval: ds.FastRetrieval,
 	}, nil
 }
@@ -39,6 +40,9 @@ func (d *legacyDealStream) ReadDealProposal() (Proposal, error) {
 func (d *legacyDealStream) WriteDealProposal(dp Proposal) error {
 	var piece *migrations.DataRef0
 	if dp.Piece != nil {
+		if dp.Piece.TransferAgent != "" {
+			return migrations.ErrTransferAgentUnsupported
+		}
 		piece = &migrations.DataRef0{
 			TransferType: dp.Piece.TransferType,
 			Root:         dp.Piece.Root,
@@ -56,7 +60,7 @@ func (d *legacyDealStream) WriteDealProposal(dp Proposal) error {
 func (d *legacyDealStream) ReadDealResponse() (SignedResponse, []byte, error) {
 	var dr migrations.SignedResponse0
 
-	if err := dr.UnmarshalCBOR(d.buffered); err != nil {
+	if err := cborlimit.Read(d.buffered, &dr); err != nil {
 		return SignedResponseUndefined, nil, err
 	}
 	origBytes, err := cborutil.Dump(&dr.Response)
@@ -1,8 +1,8 @@
 package network
 
 import (
-	"bufio"
 	"context"
+	"sync"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -14,6 +14,7 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/go-fil-markets/shared/protoregistry"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 )
 
@@ -56,29 +57,64 @@ func SupportedDealStatusProtocols(supportedProtocols []protocol.ID) Option {
 	}
 }
 
+// SupportedDealRestartProtocols sets what deal restart protocols this network instances listens on
+func SupportedDealRestartProtocols(supportedProtocols []protocol.ID) Option {
+	return func(impl *libp2pStorageMarketNetwork) {
+		impl.supportedDealRestartProtocols = supportedProtocols
+	}
+}
+
+// SupportedCapabilitiesProtocols sets what capabilities protocols this network instances listens on
+func SupportedCapabilitiesProtocols(supportedProtocols []protocol.ID) Option {
+	return func(impl *libp2pStorageMarketNetwork) {
+		impl.supportedCapabilitiesProtocols = supportedProtocols
+	}
+}
+
+// SupportedDealNotificationProtocols sets what deal notification protocols this network instances listens on
+func SupportedDealNotificationProtocols(supportedProtocols []protocol.ID) Option {
+	return func(impl *libp2pStorageMarketNetwork) {
+		impl.supportedDealNotificationProtocols = supportedProtocols
+	}
+}
+
+// LegacyProtocols sets whether this network instance speaks the 1.0.1 ask, deal and
+// deal status protocols of v0.x clients, translating their messages to and from the
+// current ones. It is on by default, so that providers keep serving old clients
+// through network upgrades. Turning it off drops the legacy protocols from the
+// supported protocols, whichever options set them
+func LegacyProtocols(enabled bool) Option {
+	return func(impl *libp2pStorageMarketNetwork) {
+		impl.legacyProtocols = enabled
+	}
+}
+
 // NewFromLibp2pHost builds a storage market network on top of libp2p
 func NewFromLibp2pHost(h host.Host, options ...Option) StorageMarketNetwork {
+	protocols := newProtocolRegistry(h)
 	impl := &libp2pStorageMarketNetwork{
-		host:                  h,
-		maxStreamOpenAttempts: defaultMaxStreamOpenAttempts,
-		minAttemptDuration:    defaultMinAttemptDuration,
-		maxAttemptDuration:    defaultMaxAttemptDuration,
-		supportedAskProtocols: []protocol.ID{
-			storagemarket.AskProtocolID,
-			storagemarket.OldAskProtocolID,
-		},
-		supportedDealProtocols: []protocol.ID{
-			storagemarket.DealProtocolID,
-			storagemarket.OldDealProtocolID,
-		},
-		supportedDealStatusProtocols: []protocol.ID{
-			storagemarket.DealStatusProtocolID,
-			storagemarket.OldDealStatusProtocolID,
-		},
+		host:                               h,
+		protocols:                          protocols,
+		maxStreamOpenAttempts:              defaultMaxStreamOpenAttempts,
+		minAttemptDuration:                 defaultMinAttemptDuration,
+		maxAttemptDuration:                 defaultMaxAttemptDuration,
+		supportedAskProtocols:              protocols.IDs(askProtocol, true),
+		supportedDealProtocols:             protocols.IDs(dealProtocol, true),
+		supportedDealStatusProtocols:       protocols.IDs(dealStatusProtocol, true),
+		supportedDealRestartProtocols:      protocols.IDs(dealRestartProtocol, true),
+		supportedCapabilitiesProtocols:     protocols.IDs(capabilitiesProtocol, true),
+		supportedDealNotificationProtocols: protocols.IDs(dealNotificationProtocol, true),
+		legacyProtocols:                    true,
+		dealSessions:                       make(map[peer.ID]*dealSession),
 	}
 	for _, option := range options {
 		option(impl)
 	}
+	if !impl.legacyProtocols {
+		impl.supportedAskProtocols = protocols.WithoutLegacy(impl.supportedAskProtocols)
+		impl.supportedDealProtocols = protocols.WithoutLegacy(impl.supportedDealProtocols)
+		impl.supportedDealStatusProtocols = protocols.WithoutLegacy(impl.supportedDealStatusProtocols)
+	}
 	return impl
 }
 
@@ -86,14 +122,32 @@ func NewFromLibp2pHost(h host.Host, options ...Option) StorageMarketNetwork {
 // NetMessage objects, into the graphsync network interface.
 type libp2pStorageMarketNetwork struct {
 	host host.Host
+	// protocols are the versions of each protocol the network speaks, and their codecs
+	protocols *protoregistry.Registry
 	// inbound messages from the network are forwarded to the receiver
-	receiver                     StorageReceiver
-	maxStreamOpenAttempts        float64
-	minAttemptDuration           time.Duration
-	maxAttemptDuration           time.Duration
-	supportedAskProtocols        []protocol.ID
-	supportedDealProtocols       []protocol.ID
-	supportedDealStatusProtocols []protocol.ID
+	receiver StorageReceiver
+	// inbound deal restart messages are forwarded to the restart receiver, which
+	// may be a client or a provider
+	restartReceiver DealRestartReceiver
+	// inbound deal notifications are forwarded to the notification receiver, which
+	// is a client
+	notificationReceiver               DealNotificationReceiver
+	maxStreamOpenAttempts              float64
+	minAttemptDuration                 time.Duration
+	maxAttemptDuration                 time.Duration
+	supportedAskProtocols              []protocol.ID
+	supportedDealProtocols             []protocol.ID
+	supportedDealStatusProtocols       []protocol.ID
+	supportedDealRestartProtocols      []protocol.ID
+	supportedCapabilitiesProtocols     []protocol.ID
+	supportedDealNotificationProtocols []protocol.ID
+	// legacyProtocols is false if the protocols of v0.x clients are disabled
+	legacyProtocols bool
+
+	// dealSessions are the sessions on the multiplexed deal protocol this side
+	// opened, which carry all the deal streams to a peer
+	dealSessionsLk sync.Mutex
+	dealSessions   map[peer.ID]*dealSession
 }
 
 func (impl *libp2pStorageMarketNetwork) NewAskStream(ctx context.Context, id peer.ID) (StorageAskStream, error) {
@@ -102,36 +156,136 @@ func (impl *libp2pStorageMarketNetwork) NewAskStream(ctx context.Context, id pee
 		log.Warn(err)
 		return nil, err
 	}
-	buffered := bufio.NewReaderSize(s, 16)
-	if s.Protocol() == storagemarket.OldAskProtocolID {
-		return &legacyAskStream{p: id, rw: s, buffered: buffered}, nil
+	as, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
 	}
-	return &askStream{p: id, rw: s, buffered: buffered}, nil
+	return as.(StorageAskStream), nil
 }
 
 func (impl *libp2pStorageMarketNetwork) NewDealStream(ctx context.Context, id peer.ID) (StorageDealStream, error) {
+	if session := impl.getDealSession(id); session != nil {
+		ds, err := session.newStream()
+		if err == nil {
+			return ds, nil
+		}
+		// the session ended, so fall through to open a new one
+	}
+
 	s, err := impl.openStream(ctx, id, impl.supportedDealProtocols)
 	if err != nil {
 		return nil, err
 	}
-	buffered := bufio.NewReaderSize(s, 16)
-	if s.Protocol() == storagemarket.OldDealProtocolID {
-		return &legacyDealStream{p: id, rw: s, buffered: buffered, host: impl.host}, nil
+	if s.Protocol() == storagemarket.MultiplexedDealProtocolID {
+		return impl.addDealSession(id, s).newStream()
+	}
+	ds, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return ds.(StorageDealStream), nil
+}
+
+func (impl *libp2pStorageMarketNetwork) getDealSession(id peer.ID) *dealSession {
+	impl.dealSessionsLk.Lock()
+	defer impl.dealSessionsLk.Unlock()
+	return impl.dealSessions[id]
+}
+
+// addDealSession starts a session on a multiplexed deal stream opened to the given
+// peer, and uses it for later deal streams to the peer. If another session to the
+// peer was added while the stream was being opened, the new stream is closed and
+// the existing session is used instead
+func (impl *libp2pStorageMarketNetwork) addDealSession(id peer.ID, s network.Stream) *dealSession {
+	impl.dealSessionsLk.Lock()
+	defer impl.dealSessionsLk.Unlock()
+	if existing, ok := impl.dealSessions[id]; ok {
+		s.Close() // nolint: errcheck,gosec
+		return existing
+	}
+	var session *dealSession
+	session = newDealSession(id, s, nil, func() {
+		impl.dealSessionsLk.Lock()
+		defer impl.dealSessionsLk.Unlock()
+		if impl.dealSessions[id] == session {
+			delete(impl.dealSessions, id)
+		}
+	})
+	impl.dealSessions[id] = session
+	go session.run()
+	return session
+}
+
+func (impl *libp2pStorageMarketNetwork) NewDealStatusStream(ctx context.Context, id peer.ID, protocols ...protocol.ID) (DealStatusStream, error) {
+	if len(protocols) == 0 {
+		protocols = impl.supportedDealStatusProtocols
+	} else if !impl.legacyProtocols {
+		protocols = impl.protocols.WithoutLegacy(protocols)
+		if len(protocols) == 0 {
+			return nil, xerrors.New("legacy deal status protocol is disabled")
+		}
+	}
+	s, err := impl.openStream(ctx, id, protocols)
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	qs, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return qs.(DealStatusStream), nil
+}
+
+func (impl *libp2pStorageMarketNetwork) NewDealRestartStream(ctx context.Context, id peer.ID) (DealRestartStream, error) {
+	s, err := impl.openStream(ctx, id, impl.supportedDealRestartProtocols)
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	rs, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
+	}
+	return rs.(DealRestartStream), nil
+}
+
+func (impl *libp2pStorageMarketNetwork) NewCapabilitiesStream(ctx context.Context, id peer.ID) (CapabilitiesStream, error) {
+	s, err := impl.openStream(ctx, id, impl.supportedCapabilitiesProtocols)
+	if err != nil {
+		log.Warn(err)
+		return nil, err
+	}
+	cs, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
 	}
-	return &dealStream{p: id, rw: s, buffered: buffered, host: impl.host}, nil
+	return cs.(CapabilitiesStream), nil
 }
 
-func (impl *libp2pStorageMarketNetwork) NewDealStatusStream(ctx context.Context, id peer.ID) (DealStatusStream, error) {
-	s, err := impl.openStream(ctx, id, impl.supportedDealStatusProtocols)
+func (impl *libp2pStorageMarketNetwork) NewDealNotificationStream(ctx context.Context, id peer.ID) (DealNotificationStream, error) {
+	s, err := impl.openStream(ctx, id, impl.supportedDealNotificationProtocols)
 	if err != nil {
 		log.Warn(err)
 		return nil, err
 	}
-	buffered := bufio.NewReaderSize(s, 16)
-	if s.Protocol() == storagemarket.OldDealStatusProtocolID {
-		return &legacyDealStatusStream{p: id, rw: s, buffered: buffered}, nil
+	ns, err := impl.wrap(id, s)
+	if err != nil {
+		return nil, err
 	}
-	return &dealStatusStream{p: id, rw: s, buffered: buffered}, nil
+	return ns.(DealNotificationStream), nil
+}
+
+// wrap wraps a stream in the codec for the protocol version negotiated on it. The
+// stream is reset if the network has no codec for it
+func (impl *libp2pStorageMarketNetwork) wrap(id peer.ID, s network.Stream) (interface{}, error) {
+	wrapped, err := impl.protocols.Wrap(id, s)
+	if err != nil {
+		log.Warn(err)
+		s.Reset() // nolint: errcheck,gosec
+		return nil, err
+	}
+	return wrapped, nil
 }
 
 func (impl *libp2pStorageMarketNetwork) openStream(ctx context.Context, id peer.ID, protocols []protocol.ID) (network.Stream, error) {
@@ -168,16 +322,41 @@ func (impl *libp2pStorageMarketNetwork) SetDelegate(r StorageReceiver) error {
 		impl.host.SetStreamHandler(proto, impl.handleNewAskStream)
 	}
 	for _, proto := range impl.supportedDealProtocols {
+		if proto == storagemarket.MultiplexedDealProtocolID {
+			impl.host.SetStreamHandler(proto, impl.handleNewDealSession)
+			continue
+		}
 		impl.host.SetStreamHandler(proto, impl.handleNewDealStream)
 	}
 	for _, proto := range impl.supportedDealStatusProtocols {
 		impl.host.SetStreamHandler(proto, impl.handleNewDealStatusStream)
 	}
+	for _, proto := range impl.supportedCapabilitiesProtocols {
+		impl.host.SetStreamHandler(proto, impl.handleNewCapabilitiesStream)
+	}
+	return nil
+}
+
+func (impl *libp2pStorageMarketNetwork) SetDealRestartDelegate(r DealRestartReceiver) error {
+	impl.restartReceiver = r
+	for _, proto := range impl.supportedDealRestartProtocols {
+		impl.host.SetStreamHandler(proto, impl.handleNewDealRestartStream)
+	}
+	return nil
+}
+
+func (impl *libp2pStorageMarketNetwork) SetDealNotificationDelegate(r DealNotificationReceiver) error {
+	impl.notificationReceiver = r
+	for _, proto := range impl.supportedDealNotificationProtocols {
+		impl.host.SetStreamHandler(proto, impl.handleNewDealNotificationStream)
+	}
 	return nil
 }
 
 func (impl *libp2pStorageMarketNetwork) StopHandlingRequests() error {
 	impl.receiver = nil
+	impl.restartReceiver = nil
+	impl.notificationReceiver = nil
 	for _, proto := range impl.supportedAskProtocols {
 		impl.host.RemoveStreamHandler(proto)
 	}
@@ -187,55 +366,111 @@ func (impl *libp2pStorageMarketNetwork) StopHandlingRequests() error {
 	for _, proto := range impl.supportedDealStatusProtocols {
 		impl.host.RemoveStreamHandler(proto)
 	}
+	for _, proto := range impl.supportedDealRestartProtocols {
+		impl.host.RemoveStreamHandler(proto)
+	}
+	for _, proto := range impl.supportedCapabilitiesProtocols {
+		impl.host.RemoveStreamHandler(proto)
+	}
+	for _, proto := range impl.supportedDealNotificationProtocols {
+		impl.host.RemoveStreamHandler(proto)
+	}
 	return nil
 }
 
 func (impl *libp2pStorageMarketNetwork) handleNewAskStream(s network.Stream) {
-	reader := impl.getReaderOrReset(s)
-	if reader != nil {
-		var as StorageAskStream
-		if s.Protocol() == storagemarket.OldAskProtocolID {
-			as = &legacyAskStream{s.Conn().RemotePeer(), s, reader}
-		} else {
-			as = &askStream{s.Conn().RemotePeer(), s, reader}
-		}
-		impl.receiver.HandleAskStream(as)
+	if as := impl.wrapOrReset(s); as != nil {
+		impl.receiver.HandleAskStream(as.(StorageAskStream))
 	}
 }
 
 func (impl *libp2pStorageMarketNetwork) handleNewDealStream(s network.Stream) {
-	reader := impl.getReaderOrReset(s)
-	if reader != nil {
-		var ds StorageDealStream
-		if s.Protocol() == storagemarket.OldDealProtocolID {
-			ds = &legacyDealStream{s.Conn().RemotePeer(), impl.host, s, reader}
-		} else {
-			ds = &dealStream{s.Conn().RemotePeer(), impl.host, s, reader}
-		}
-		impl.receiver.HandleDealStream(ds)
+	if ds := impl.wrapOrReset(s); ds != nil {
+		impl.receiver.HandleDealStream(ds.(StorageDealStream))
 	}
 }
 
-func (impl *libp2pStorageMarketNetwork) handleNewDealStatusStream(s network.Stream) {
-	reader := impl.getReaderOrReset(s)
-	if reader != nil {
-		var qs DealStatusStream
-		if s.Protocol() == storagemarket.OldDealStatusProtocolID {
-			qs = &legacyDealStatusStream{s.Conn().RemotePeer(), impl.host, s, reader}
-		} else {
-			qs = &dealStatusStream{s.Conn().RemotePeer(), impl.host, s, reader}
+// handleNewDealSession accepts a session on the multiplexed deal protocol, and hands
+// each deal stream the other side starts on it to the receiver
+func (impl *libp2pStorageMarketNetwork) handleNewDealSession(s network.Stream) {
+	if impl.receiver == nil {
+		log.Warn("no receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	session := newDealSession(s.Conn().RemotePeer(), s, func(ds StorageDealStream) {
+		receiver := impl.receiver
+		if receiver == nil {
+			log.Warn("no receiver set")
+			ds.Close() // nolint: errcheck,gosec
+			return
 		}
-		impl.receiver.HandleDealStatusStream(qs)
+		receiver.HandleDealStream(ds)
+	}, nil)
+	go session.run()
+}
+
+func (impl *libp2pStorageMarketNetwork) handleNewDealStatusStream(s network.Stream) {
+	if qs := impl.wrapOrReset(s); qs != nil {
+		impl.receiver.HandleDealStatusStream(qs.(DealStatusStream))
 	}
 }
 
-func (impl *libp2pStorageMarketNetwork) getReaderOrReset(s network.Stream) *bufio.Reader {
+func (impl *libp2pStorageMarketNetwork) handleNewCapabilitiesStream(s network.Stream) {
+	if cs := impl.wrapOrReset(s); cs != nil {
+		impl.receiver.HandleCapabilitiesStream(cs.(CapabilitiesStream))
+	}
+}
+
+func (impl *libp2pStorageMarketNetwork) handleNewDealRestartStream(s network.Stream) {
+	if impl.restartReceiver == nil {
+		log.Warn("no deal restart receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	if rs, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.restartReceiver.HandleDealRestartStream(rs.(DealRestartStream))
+	}
+}
+
+func (impl *libp2pStorageMarketNetwork) handleNewDealNotificationStream(s network.Stream) {
+	if impl.notificationReceiver == nil {
+		log.Warn("no deal notification receiver set")
+		s.Reset() // nolint: errcheck,gosec
+		return
+	}
+	if ns, err := impl.wrap(s.Conn().RemotePeer(), s); err == nil {
+		impl.notificationReceiver.HandleDealNotificationStream(ns.(DealNotificationStream))
+	}
+}
+
+// wrapOrReset wraps a stream for the receiver in the codec for its protocol version,
+// or resets it if there is no receiver
+func (impl *libp2pStorageMarketNetwork) wrapOrReset(s network.Stream) interface{} {
 	if impl.receiver == nil {
 		log.Warn("no receiver set")
 		s.Reset() // nolint: errcheck,gosec
 		return nil
 	}
-	return bufio.NewReaderSize(s, 16)
+	wrapped, err := impl.wrap(s.Conn().RemotePeer(), s)
+	if err != nil {
+		return nil
+	}
+	return wrapped
+}
+
+func (impl *libp2pStorageMarketNetwork) ProviderProtocols() []protocol.ID {
+	var protocols []protocol.ID
+	for _, supported := range [][]protocol.ID{
+		impl.supportedAskProtocols,
+		impl.supportedDealProtocols,
+		impl.supportedDealStatusProtocols,
+		impl.supportedDealRestartProtocols,
+		impl.supportedCapabilitiesProtocols,
+	} {
+		protocols = append(protocols, supported...)
+	}
+	return protocols
 }
 
 func (impl *libp2pStorageMarketNetwork) ID() peer.ID {
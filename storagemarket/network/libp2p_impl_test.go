@@ -10,18 +10,22 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
 
 	"github.com/filecoin-project/go-fil-markets/shared_testutil"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/migrations"
 	"github.com/filecoin-project/go-fil-markets/storagemarket/network"
 )
 
 type testReceiver struct {
-	t                       *testing.T
-	dealStreamHandler       func(network.StorageDealStream)
-	askStreamHandler        func(network.StorageAskStream)
-	dealStatusStreamHandler func(stream network.DealStatusStream)
+	t                         *testing.T
+	dealStreamHandler         func(network.StorageDealStream)
+	askStreamHandler          func(network.StorageAskStream)
+	dealStatusStreamHandler   func(stream network.DealStatusStream)
+	capabilitiesStreamHandler func(stream network.CapabilitiesStream)
 }
 
 var _ network.StorageReceiver = &testReceiver{}
@@ -47,6 +51,39 @@ func (tr *testReceiver) HandleDealStatusStream(s network.DealStatusStream) {
 	}
 }
 
+func (tr *testReceiver) HandleCapabilitiesStream(s network.CapabilitiesStream) {
+	defer s.Close()
+	if tr.capabilitiesStreamHandler != nil {
+		tr.capabilitiesStreamHandler(s)
+	}
+}
+
+type testRestartReceiver struct {
+	handler func(network.DealRestartStream)
+}
+
+var _ network.DealRestartReceiver = &testRestartReceiver{}
+
+func (tr *testRestartReceiver) HandleDealRestartStream(s network.DealRestartStream) {
+	defer s.Close()
+	if tr.handler != nil {
+		tr.handler(s)
+	}
+}
+
+type testNotificationReceiver struct {
+	handler func(network.DealNotificationStream)
+}
+
+var _ network.DealNotificationReceiver = &testNotificationReceiver{}
+
+func (tr *testNotificationReceiver) HandleDealNotificationStream(s network.DealNotificationStream) {
+	defer s.Close()
+	if tr.handler != nil {
+		tr.handler(s)
+	}
+}
+
 func TestOpenStreamWithRetries(t *testing.T) {
 	ctx := context.Background()
 	td := shared_testutil.NewLibp2pTestData(ctx, t)
@@ -90,6 +127,7 @@ func TestAskStreamSendReceiveAskRequest(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -98,6 +136,9 @@ func TestAskStreamSendReceiveAskRequest(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports asks without duration limits": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -110,6 +151,8 @@ func TestAskStreamSendReceiveAskRequest(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedAskProtocols([]protocol.ID{storagemarket.OldAskProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedAskProtocols([]protocol.ID{storagemarket.AskProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -140,6 +183,7 @@ func TestAskStreamSendReceiveAskResponse(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -148,6 +192,9 @@ func TestAskStreamSendReceiveAskResponse(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports asks without duration limits": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -160,6 +207,8 @@ func TestAskStreamSendReceiveAskResponse(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedAskProtocols([]protocol.ID{storagemarket.OldAskProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedAskProtocols([]protocol.ID{storagemarket.AskProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -233,6 +282,7 @@ func TestDealStreamSendReceiveDealProposal(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -241,6 +291,9 @@ func TestDealStreamSendReceiveDealProposal(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports proposals without invoices": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -253,6 +306,8 @@ func TestDealStreamSendReceiveDealProposal(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealProtocols([]protocol.ID{storagemarket.OldDealProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealProtocols([]protocol.ID{storagemarket.DealProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -277,12 +332,39 @@ func TestDealStreamSendReceiveDealProposal(t *testing.T) {
 	}
 }
 
+func TestDealStreamRefusesTransferAgentOnOldProtocols(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := map[string]protocol.ID{
+		"receiver only supports old queries":                storagemarket.OldDealProtocolID,
+		"receiver only supports proposals without invoices": storagemarket.DealProtocolID110,
+	}
+	for testCase, protocolID := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			td := shared_testutil.NewLibp2pTestData(ctx, t)
+			fromNetwork := network.NewFromLibp2pHost(td.Host1)
+			toNetwork := network.NewFromLibp2pHost(td.Host2, network.SupportedDealProtocols([]protocol.ID{protocolID}))
+			require.NoError(t, fromNetwork.SetDelegate(&testReceiver{t: t}))
+			require.NoError(t, toNetwork.SetDelegate(&testReceiver{t: t}))
+
+			ds, err := fromNetwork.NewDealStream(ctx, td.Host2.ID())
+			require.NoError(t, err)
+			defer ds.Close()
+
+			dp := shared_testutil.MakeTestStorageNetworkProposal()
+			dp.Piece.TransferAgent = td.Host1.ID()
+			require.EqualError(t, ds.WriteDealProposal(dp), migrations.ErrTransferAgentUnsupported.Error())
+		})
+	}
+}
+
 func TestDealStreamSendReceiveDealResponse(t *testing.T) {
 	ctx := context.Background()
 
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -291,6 +373,9 @@ func TestDealStreamSendReceiveDealResponse(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports proposals without invoices": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -303,6 +388,8 @@ func TestDealStreamSendReceiveDealResponse(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealProtocols([]protocol.ID{storagemarket.OldDealProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealProtocols([]protocol.ID{storagemarket.DealProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -375,12 +462,69 @@ func TestDealStreamSendReceiveMultipleSuccessful(t *testing.T) {
 	}
 }
 
+func TestDealStreamMultiplexedProposals(t *testing.T) {
+	// open several deal streams to one peer, and check each gets the response to
+	// its own proposal over a single libp2p stream
+
+	bgCtx := context.Background()
+	td := shared_testutil.NewLibp2pTestData(bgCtx, t)
+	fromNetwork := network.NewFromLibp2pHost(td.Host1)
+	toNetwork := network.NewFromLibp2pHost(td.Host2)
+	toPeer := td.Host2.ID()
+
+	var resigningFunc network.ResigningFunc = func(ctx context.Context, data interface{}) (*crypto.Signature, error) {
+		return nil, nil
+	}
+	tr2 := &testReceiver{t: t, dealStreamHandler: func(s network.StorageDealStream) {
+		dp, err := s.ReadDealProposal()
+		require.NoError(t, err)
+
+		dr := shared_testutil.MakeTestStorageNetworkSignedResponse()
+		dr.Response.Message = dp.Piece.Root.String()
+		require.NoError(t, s.WriteDealResponse(dr, resigningFunc))
+	}}
+	require.NoError(t, toNetwork.SetDelegate(tr2))
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	const deals = 3
+	streams := make([]network.StorageDealStream, 0, deals)
+	proposals := make([]network.Proposal, 0, deals)
+	for i := 0; i < deals; i++ {
+		ds, err := fromNetwork.NewDealStream(ctx, toPeer)
+		require.NoError(t, err)
+		dp := shared_testutil.MakeTestStorageNetworkProposal()
+		require.NoError(t, ds.WriteDealProposal(dp))
+		streams = append(streams, ds)
+		proposals = append(proposals, dp)
+	}
+
+	for i := deals - 1; i >= 0; i-- {
+		resp, _, err := streams[i].ReadDealResponse()
+		require.NoError(t, err)
+		require.Equal(t, proposals[i].Piece.Root.String(), resp.Response.Message)
+		require.NoError(t, streams[i].Close())
+	}
+
+	sessions := 0
+	for _, conn := range td.Host1.Network().ConnsToPeer(toPeer) {
+		for _, s := range conn.GetStreams() {
+			if s.Protocol() == storagemarket.MultiplexedDealProtocolID {
+				sessions++
+			}
+		}
+	}
+	require.Equal(t, 1, sessions)
+}
+
 func TestDealStatusStreamSendReceiveRequest(t *testing.T) {
 	ctx := context.Background()
 
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -389,6 +533,9 @@ func TestDealStatusStreamSendReceiveRequest(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports deal status without transfer or sealing progress": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -401,6 +548,8 @@ func TestDealStatusStreamSendReceiveRequest(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealStatusProtocols([]protocol.ID{storagemarket.OldDealStatusProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealStatusProtocols([]protocol.ID{storagemarket.DealStatusProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -431,6 +580,7 @@ func TestDealStatusStreamSendReceiveResponse(t *testing.T) {
 	testCases := map[string]struct {
 		senderDisabledNew   bool
 		receiverDisabledNew bool
+		receiverOnly110     bool
 	}{
 		"both clients current version": {},
 		"sender old supports old queries": {
@@ -439,6 +589,9 @@ func TestDealStatusStreamSendReceiveResponse(t *testing.T) {
 		"receiver only supports old queries": {
 			receiverDisabledNew: true,
 		},
+		"receiver only supports deal status without transfer or sealing progress": {
+			receiverOnly110: true,
+		},
 	}
 	for testCase, data := range testCases {
 		t.Run(testCase, func(t *testing.T) {
@@ -451,6 +604,8 @@ func TestDealStatusStreamSendReceiveResponse(t *testing.T) {
 			}
 			if data.receiverDisabledNew {
 				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealStatusProtocols([]protocol.ID{storagemarket.OldDealStatusProtocolID}))
+			} else if data.receiverOnly110 {
+				toNetwork = network.NewFromLibp2pHost(td.Host2, network.SupportedDealStatusProtocols([]protocol.ID{storagemarket.DealStatusProtocolID110}))
 			} else {
 				toNetwork = network.NewFromLibp2pHost(td.Host2)
 			}
@@ -517,6 +672,171 @@ func TestDealStatusStreamSendReceiveMultipleSuccessful(t *testing.T) {
 	assert.Equal(t, ar, resp)
 }
 
+func TestDealStatusStreamProtocols(t *testing.T) {
+	ctx := context.Background()
+	td := shared_testutil.NewLibp2pTestData(ctx, t)
+	fromNetwork := network.NewFromLibp2pHost(td.Host1)
+	toNetwork := network.NewFromLibp2pHost(td.Host2)
+	require.NoError(t, toNetwork.SetDelegate(&testReceiver{t: t, dealStatusStreamHandler: func(s network.DealStatusStream) {}}))
+
+	s, err := fromNetwork.NewDealStatusStream(ctx, td.Host2.ID())
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID(storagemarket.DealStatusProtocolID), s.Protocol())
+
+	s, err = fromNetwork.NewDealStatusStream(ctx, td.Host2.ID(), storagemarket.OldDealStatusProtocolID)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID(storagemarket.OldDealStatusProtocolID), s.Protocol())
+
+	s, err = fromNetwork.NewDealStatusStream(ctx, td.Host2.ID(), storagemarket.DealStatusProtocolID110)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID(storagemarket.DealStatusProtocolID110), s.Protocol())
+
+	s, err = fromNetwork.NewDealStatusStream(ctx, td.Host2.ID(), storagemarket.OldDealStatusProtocolID, storagemarket.DealStatusProtocolID)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ID(storagemarket.OldDealStatusProtocolID), s.Protocol())
+}
+
+func TestDealRestartStreamSendReceive(t *testing.T) {
+	ctxBg := context.Background()
+	td := shared_testutil.NewLibp2pTestData(ctxBg, t)
+	nw1 := network.NewFromLibp2pHost(td.Host1)
+	nw2 := network.NewFromLibp2pHost(td.Host2)
+	require.NoError(t, td.Host1.Connect(ctxBg, peer.AddrInfo{ID: td.Host2.ID()}))
+
+	proposalCid := shared_testutil.GenerateCids(1)[0]
+	channelID := datatransfer.ChannelID{Initiator: td.Host1.ID(), Responder: td.Host2.ID(), ID: 1}
+	req := network.DealRestartRequest{View: network.DealView{
+		Proposal:          proposalCid,
+		State:             storagemarket.StorageDealClientTransferRestart,
+		TransferChannelID: &channelID,
+		BytesTransferred:  1000,
+	}}
+	resp := network.DealRestartResponse{View: network.DealView{
+		Proposal: proposalCid,
+		State:    storagemarket.StorageDealFailing,
+		Message:  "something went wrong",
+	}}
+
+	// host2 gets a request and sends a response
+	received := make(chan network.DealRestartRequest, 1)
+	tr2 := &testRestartReceiver{handler: func(s network.DealRestartStream) {
+		readReq, err := s.ReadDealRestartRequest()
+		require.NoError(t, err)
+		require.Equal(t, td.Host1.ID(), s.RemotePeer())
+		received <- readReq
+		require.NoError(t, s.WriteDealRestartResponse(resp))
+	}}
+	require.NoError(t, nw2.SetDealRestartDelegate(tr2))
+
+	ctx, cancel := context.WithTimeout(ctxBg, 10*time.Second)
+	defer cancel()
+
+	rs, err := nw1.NewDealRestartStream(ctx, td.Host2.ID())
+	require.NoError(t, err)
+	require.NoError(t, rs.WriteDealRestartRequest(req))
+	readResp, err := rs.ReadDealRestartResponse()
+	require.NoError(t, err)
+	require.Equal(t, resp, readResp)
+
+	select {
+	case <-ctx.Done():
+		t.Error("request not received")
+	case readReq := <-received:
+		require.Equal(t, req, readReq)
+	}
+}
+
+func TestDealNotificationStreamSendReceive(t *testing.T) {
+	ctxBg := context.Background()
+	td := shared_testutil.NewLibp2pTestData(ctxBg, t)
+	nw1 := network.NewFromLibp2pHost(td.Host1)
+	nw2 := network.NewFromLibp2pHost(td.Host2)
+	require.NoError(t, td.Host1.Connect(ctxBg, peer.AddrInfo{ID: td.Host2.ID()}))
+
+	publishCid := shared_testutil.GenerateCids(1)[0]
+	notification := network.SignedDealNotification{
+		Notification: network.DealNotification{
+			Proposal:   shared_testutil.GenerateCids(1)[0],
+			State:      storagemarket.StorageDealStaged,
+			DealID:     abi.DealID(12),
+			PublishCid: &publishCid,
+		},
+		Signature: shared_testutil.MakeTestSignature(),
+	}
+
+	// host2 is the client, and receives the notification
+	received := make(chan network.SignedDealNotification, 1)
+	tr2 := &testNotificationReceiver{handler: func(s network.DealNotificationStream) {
+		readNotification, err := s.ReadDealNotification()
+		require.NoError(t, err)
+		require.Equal(t, td.Host1.ID(), s.RemotePeer())
+		received <- readNotification
+	}}
+	require.NoError(t, nw2.SetDealNotificationDelegate(tr2))
+
+	ctx, cancel := context.WithTimeout(ctxBg, 10*time.Second)
+	defer cancel()
+
+	ns, err := nw1.NewDealNotificationStream(ctx, td.Host2.ID())
+	require.NoError(t, err)
+	require.NoError(t, ns.WriteDealNotification(notification))
+
+	select {
+	case <-ctx.Done():
+		t.Error("notification not received")
+	case readNotification := <-received:
+		require.Equal(t, notification, readNotification)
+	}
+}
+
+func TestCapabilitiesStreamSendReceive(t *testing.T) {
+	ctxBg := context.Background()
+	td := shared_testutil.NewLibp2pTestData(ctxBg, t)
+	nw1 := network.NewFromLibp2pHost(td.Host1)
+	nw2 := network.NewFromLibp2pHost(td.Host2)
+	require.NoError(t, td.Host1.Connect(ctxBg, peer.AddrInfo{ID: td.Host2.ID()}))
+
+	resp := network.CapabilitiesResponse{Capabilities: storagemarket.ProviderCapabilities{
+		Protocols:         []string{string(storagemarket.DealProtocolID)},
+		TransferTypes:     []string{storagemarket.TTGraphsync, storagemarket.TTManual},
+		MinPieceSize:      256,
+		MaxPieceSize:      1 << 30,
+		VerifiedOnly:      true,
+		RetrievalFreeTier: true,
+		Currencies:        []string{"FIL"},
+	}}
+
+	// host2 writes its capabilities as soon as the stream opens
+	tr2 := &testReceiver{t: t, capabilitiesStreamHandler: func(s network.CapabilitiesStream) {
+		require.Equal(t, td.Host1.ID(), s.RemotePeer())
+		require.NoError(t, s.WriteCapabilitiesResponse(resp))
+	}}
+	require.NoError(t, nw2.SetDelegate(tr2))
+
+	ctx, cancel := context.WithTimeout(ctxBg, 10*time.Second)
+	defer cancel()
+
+	cs, err := nw1.NewCapabilitiesStream(ctx, td.Host2.ID())
+	require.NoError(t, err)
+	readResp, err := cs.ReadCapabilitiesResponse()
+	require.NoError(t, err)
+	require.Equal(t, resp, readResp)
+}
+
+func TestProviderProtocols(t *testing.T) {
+	td := shared_testutil.NewLibp2pTestData(context.Background(), t)
+
+	nw := network.NewFromLibp2pHost(td.Host1, network.LegacyProtocols(false), network.SupportedDealRestartProtocols(nil))
+	protocols := nw.ProviderProtocols()
+	require.Contains(t, protocols, protocol.ID(storagemarket.AskProtocolID))
+	require.Contains(t, protocols, protocol.ID(storagemarket.DealProtocolID))
+	require.Contains(t, protocols, protocol.ID(storagemarket.DealStatusProtocolID))
+	require.Contains(t, protocols, protocol.ID(storagemarket.CapabilitiesProtocolID))
+	require.NotContains(t, protocols, protocol.ID(storagemarket.OldDealProtocolID))
+	require.NotContains(t, protocols, protocol.ID(storagemarket.DealRestartProtocolID))
+	require.NotContains(t, protocols, protocol.ID(storagemarket.DealNotificationProtocolID))
+}
+
 func TestLibp2pStorageMarketNetwork_StopHandlingRequests(t *testing.T) {
 	bgCtx := context.Background()
 	td := shared_testutil.NewLibp2pTestData(bgCtx, t)
@@ -545,6 +865,52 @@ func TestLibp2pStorageMarketNetwork_StopHandlingRequests(t *testing.T) {
 }
 
 // assertDealProposalReceived performs the verification that a deal proposal is received
+func TestLegacyProtocols(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("legacy proposals default to graphsync", func(t *testing.T) {
+		td := shared_testutil.NewLibp2pTestData(ctx, t)
+		fromNetwork := network.NewFromLibp2pHost(td.Host1, network.SupportedDealProtocols([]protocol.ID{storagemarket.OldDealProtocolID}))
+		toNetwork := network.NewFromLibp2pHost(td.Host2)
+		dchan := make(chan network.Proposal, 1)
+		require.NoError(t, toNetwork.SetDelegate(&testReceiver{t: t, dealStreamHandler: func(s network.StorageDealStream) {
+			readD, err := s.ReadDealProposal()
+			require.NoError(t, err)
+			dchan <- readD
+		}}))
+
+		ds, err := fromNetwork.NewDealStream(ctx, td.Host2.ID())
+		require.NoError(t, err)
+		dp := shared_testutil.MakeTestStorageNetworkProposal()
+		dp.Piece.TransferType = ""
+		require.NoError(t, ds.WriteDealProposal(dp))
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("deal proposal not received")
+		case received := <-dchan:
+			require.Equal(t, storagemarket.TTGraphsync, received.Piece.TransferType)
+			require.Equal(t, dp.Piece.Root, received.Piece.Root)
+		}
+	})
+
+	t.Run("disabled legacy protocols are not served", func(t *testing.T) {
+		td := shared_testutil.NewLibp2pTestData(ctx, t)
+		fromNetwork := network.NewFromLibp2pHost(td.Host1,
+			network.RetryParameters(time.Millisecond, time.Millisecond, 1),
+			network.SupportedAskProtocols([]protocol.ID{storagemarket.OldAskProtocolID}))
+		toNetwork := network.NewFromLibp2pHost(td.Host2, network.LegacyProtocols(false))
+		require.NoError(t, toNetwork.SetDelegate(&testReceiver{t: t}))
+
+		_, err := fromNetwork.NewAskStream(ctx, td.Host2.ID())
+		require.Error(t, err)
+
+		_, err = toNetwork.NewDealStatusStream(ctx, td.Host1.ID(), storagemarket.OldDealStatusProtocolID)
+		require.EqualError(t, err, "legacy deal status protocol is disabled")
+	})
+}
+
 func assertDealProposalReceived(inCtx context.Context, t *testing.T, fromNetwork network.StorageMarketNetwork, toPeer peer.ID, inChan chan network.Proposal) {
 	ctx, cancel := context.WithTimeout(inCtx, 10*time.Second)
 	defer cancel()
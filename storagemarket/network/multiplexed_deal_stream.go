@@ -0,0 +1,247 @@
+package network
+
+import (
+	"bufio"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	cborutil "github.com/filecoin-project/go-cbor-util"
+
+	"github.com/filecoin-project/go-fil-markets/shared/cborlimit"
+)
+
+// errDealStreamClosed is returned when reading from a multiplexed deal stream that
+// was closed locally
+var errDealStreamClosed = xerrors.New("deal stream closed")
+
+// dealSession is a long-lived libp2p stream on the multiplexed deal protocol, which
+// carries many deal streams at once. Every message on the session belongs to the
+// deal stream with the message's ID.
+//
+// The side that opened the session picks the IDs of new deal streams, in increasing
+// order. The side that accepted it starts a new deal stream for every message with
+// an ID it has not yet seen, and hands the stream to onStream
+type dealSession struct {
+	p        peer.ID
+	s        network.Stream
+	buffered *bufio.Reader
+	// onStream receives the deal streams started by the other side, or is nil if
+	// this side opened the session
+	onStream func(StorageDealStream)
+	// onClose is called once when the session ends
+	onClose func()
+
+	writeLk sync.Mutex
+
+	lk      sync.Mutex
+	nextID  uint64
+	streams map[uint64]*multiplexedDealStream
+	err     error
+}
+
+func newDealSession(p peer.ID, s network.Stream, onStream func(StorageDealStream), onClose func()) *dealSession {
+	return &dealSession{
+		p:        p,
+		s:        s,
+		buffered: bufio.NewReaderSize(s, 16),
+		onStream: onStream,
+		onClose:  onClose,
+		streams:  make(map[uint64]*multiplexedDealStream),
+	}
+}
+
+// newStream starts a new deal stream on a session this side opened
+func (ds *dealSession) newStream() (*multiplexedDealStream, error) {
+	ds.lk.Lock()
+	defer ds.lk.Unlock()
+	if ds.err != nil {
+		return nil, ds.err
+	}
+	ms := newMultiplexedDealStream(ds, ds.nextID)
+	ds.streams[ds.nextID] = ms
+	ds.nextID++
+	return ms, nil
+}
+
+// run reads messages from the session and delivers them to their deal streams,
+// until the session's stream fails or is closed by the other side
+func (ds *dealSession) run() {
+	for {
+		var msg DealMessage
+		if err := cborlimit.Read(ds.buffered, &msg); err != nil {
+			ds.shutdown(xerrors.Errorf("reading from deal session with %s: %w", ds.p, err))
+			return
+		}
+
+		ds.lk.Lock()
+		ms, ok := ds.streams[msg.ID]
+		started := false
+		if !ok && ds.onStream != nil && msg.ID >= ds.nextID {
+			ms = newMultiplexedDealStream(ds, msg.ID)
+			ds.streams[msg.ID] = ms
+			ds.nextID = msg.ID + 1
+			started = true
+		}
+		ds.lk.Unlock()
+
+		if ms == nil {
+			log.Warnf("dropping message for unknown deal stream %d from %s", msg.ID, ds.p)
+			continue
+		}
+		ms.deliver(msg)
+		if started {
+			go ds.onStream(ms)
+		}
+	}
+}
+
+func (ds *dealSession) write(msg DealMessage) error {
+	ds.writeLk.Lock()
+	err := cborutil.WriteCborRPC(ds.s, &msg)
+	ds.writeLk.Unlock()
+	if err != nil {
+		ds.shutdown(xerrors.Errorf("writing to deal session with %s: %w", ds.p, err))
+	}
+	return err
+}
+
+func (ds *dealSession) remove(id uint64) {
+	ds.lk.Lock()
+	delete(ds.streams, id)
+	ds.lk.Unlock()
+}
+
+// shutdown fails every open deal stream with the given error and resets the
+// session's stream
+func (ds *dealSession) shutdown(err error) {
+	ds.lk.Lock()
+	if ds.err != nil {
+		ds.lk.Unlock()
+		return
+	}
+	ds.err = err
+	streams := ds.streams
+	ds.streams = make(map[uint64]*multiplexedDealStream)
+	ds.lk.Unlock()
+
+	for _, ms := range streams {
+		ms.fail(err)
+	}
+	ds.s.Reset() // nolint: errcheck,gosec
+	if ds.onClose != nil {
+		ds.onClose()
+	}
+}
+
+// multiplexedDealStream is a StorageDealStream for one deal, carried on a dealSession.
+// Closing it leaves the session open for other deals
+type multiplexedDealStream struct {
+	id      uint64
+	session *dealSession
+
+	lk     sync.Mutex
+	inbox  []DealMessage
+	err    error
+	notify chan struct{}
+}
+
+var _ StorageDealStream = (*multiplexedDealStream)(nil)
+
+func newMultiplexedDealStream(session *dealSession, id uint64) *multiplexedDealStream {
+	return &multiplexedDealStream{
+		id:      id,
+		session: session,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (d *multiplexedDealStream) deliver(msg DealMessage) {
+	d.lk.Lock()
+	d.inbox = append(d.inbox, msg)
+	d.lk.Unlock()
+	d.signal()
+}
+
+func (d *multiplexedDealStream) fail(err error) {
+	d.lk.Lock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.lk.Unlock()
+	d.signal()
+}
+
+func (d *multiplexedDealStream) signal() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the next message for this deal. Messages that arrived before the
+// stream failed are still returned
+func (d *multiplexedDealStream) next() (DealMessage, error) {
+	for {
+		d.lk.Lock()
+		if len(d.inbox) > 0 {
+			msg := d.inbox[0]
+			d.inbox = d.inbox[1:]
+			d.lk.Unlock()
+			return msg, nil
+		}
+		err := d.err
+		d.lk.Unlock()
+		if err != nil {
+			return DealMessageUndefined, err
+		}
+		<-d.notify
+	}
+}
+
+func (d *multiplexedDealStream) ReadDealProposal() (Proposal, error) {
+	msg, err := d.next()
+	if err != nil {
+		log.Warn(err)
+		return ProposalUndefined, err
+	}
+	if msg.Proposal == nil {
+		return ProposalUndefined, xerrors.Errorf("expected a proposal on deal stream %d", d.id)
+	}
+	return *msg.Proposal, nil
+}
+
+func (d *multiplexedDealStream) WriteDealProposal(dp Proposal) error {
+	return d.session.write(DealMessage{ID: d.id, Proposal: &dp})
+}
+
+func (d *multiplexedDealStream) ReadDealResponse() (SignedResponse, []byte, error) {
+	msg, err := d.next()
+	if err != nil {
+		return SignedResponseUndefined, nil, err
+	}
+	if msg.Response == nil {
+		return SignedResponseUndefined, nil, xerrors.Errorf("expected a response on deal stream %d", d.id)
+	}
+	origBytes, err := cborutil.Dump(&msg.Response.Response)
+	if err != nil {
+		return SignedResponseUndefined, nil, err
+	}
+	return *msg.Response, origBytes, nil
+}
+
+func (d *multiplexedDealStream) WriteDealResponse(dr SignedResponse, _ ResigningFunc) error {
+	return d.session.write(DealMessage{ID: d.id, Response: &dr})
+}
+
+func (d *multiplexedDealStream) Close() error {
+	d.session.remove(d.id)
+	d.fail(errDealStreamClosed)
+	return nil
+}
+
+func (d *multiplexedDealStream) RemotePeer() peer.ID {
+	return d.session.p
+}
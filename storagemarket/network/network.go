@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/filecoin-project/go-state-types/crypto"
@@ -43,6 +44,37 @@ type DealStatusStream interface {
 	WriteDealStatusRequest(DealStatusRequest) error
 	ReadDealStatusResponse() (DealStatusResponse, []byte, error)
 	WriteDealStatusResponse(DealStatusResponse, ResigningFunc) error
+	// Protocol is the version of the deal status protocol the stream speaks
+	Protocol() protocol.ID
+	Close() error
+}
+
+// DealRestartStream is a stream for reading and writing requests
+// and responses on the deal restart protocol
+type DealRestartStream interface {
+	ReadDealRestartRequest() (DealRestartRequest, error)
+	WriteDealRestartRequest(DealRestartRequest) error
+	ReadDealRestartResponse() (DealRestartResponse, error)
+	WriteDealRestartResponse(DealRestartResponse) error
+	RemotePeer() peer.ID
+	Close() error
+}
+
+// CapabilitiesStream is a stream on the capabilities protocol. The provider writes
+// its capabilities as soon as the stream opens, so there is no request
+type CapabilitiesStream interface {
+	ReadCapabilitiesResponse() (CapabilitiesResponse, error)
+	WriteCapabilitiesResponse(CapabilitiesResponse) error
+	RemotePeer() peer.ID
+	Close() error
+}
+
+// DealNotificationStream is a stream on the deal notification protocol, on which a
+// provider pushes a signed notification of a deal state change to the client
+type DealNotificationStream interface {
+	ReadDealNotification() (SignedDealNotification, error)
+	WriteDealNotification(SignedDealNotification) error
+	RemotePeer() peer.ID
 	Close() error
 }
 
@@ -52,15 +84,38 @@ type StorageReceiver interface {
 	HandleAskStream(StorageAskStream)
 	HandleDealStream(StorageDealStream)
 	HandleDealStatusStream(DealStatusStream)
+	HandleCapabilitiesStream(CapabilitiesStream)
+}
+
+// DealRestartReceiver implements functions for receiving incoming data on the
+// deal restart protocol. Both clients and providers receive on this protocol
+type DealRestartReceiver interface {
+	HandleDealRestartStream(DealRestartStream)
+}
+
+// DealNotificationReceiver implements functions for receiving incoming data on the
+// deal notification protocol. Clients receive on this protocol
+type DealNotificationReceiver interface {
+	HandleDealNotificationStream(DealNotificationStream)
 }
 
 // StorageMarketNetwork is a network abstraction for the storage market
 type StorageMarketNetwork interface {
 	NewAskStream(context.Context, peer.ID) (StorageAskStream, error)
 	NewDealStream(context.Context, peer.ID) (StorageDealStream, error)
-	NewDealStatusStream(context.Context, peer.ID) (DealStatusStream, error)
+	// NewDealStatusStream opens a stream on the first of the given deal status
+	// protocols the peer supports, or of all the supported ones if none are given
+	NewDealStatusStream(context.Context, peer.ID, ...protocol.ID) (DealStatusStream, error)
+	NewDealRestartStream(context.Context, peer.ID) (DealRestartStream, error)
+	NewCapabilitiesStream(context.Context, peer.ID) (CapabilitiesStream, error)
+	NewDealNotificationStream(context.Context, peer.ID) (DealNotificationStream, error)
 	SetDelegate(StorageReceiver) error
+	SetDealRestartDelegate(DealRestartReceiver) error
+	SetDealNotificationDelegate(DealNotificationReceiver) error
 	StopHandlingRequests() error
+	// ProviderProtocols returns the IDs of the protocols the network handles
+	// requests from clients on, once a provider is set as its delegate
+	ProviderProtocols() []protocol.ID
 	ID() peer.ID
 	AddAddrs(peer.ID, []ma.Multiaddr)
 
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"bufio"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/go-fil-markets/shared/protoregistry"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// The names of the storage market protocols, which are their IDs without the version
+const (
+	askProtocol              = "/fil/storage/ask"
+	dealProtocol             = "/fil/storage/mk"
+	dealStatusProtocol       = "/fil/storage/status"
+	dealRestartProtocol      = "/fil/storage/restart"
+	capabilitiesProtocol     = "/fil/storage/capabilities"
+	dealNotificationProtocol = "/fil/storage/notify"
+)
+
+// newProtocolRegistry returns a registry of every version of the storage market
+// protocols, with the codec that speaks each one. The 1.0.1 protocols of v0.x clients
+// are legacy versions
+func newProtocolRegistry(h host.Host) *protoregistry.Registry {
+	r := protoregistry.New()
+
+	r.MustRegister(protoregistry.Version{ID: storagemarket.AskProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &askStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.AskProtocolID110, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &askStream110{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.OldAskProtocolID, Legacy: true, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &legacyAskStream{p: p, rw: s, buffered: buffered}
+	}})
+
+	// streams on the multiplexed deal protocol carry a session of deal streams, which
+	// the network starts itself
+	r.MustRegister(protoregistry.Version{ID: storagemarket.MultiplexedDealProtocolID})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealStream{p: p, host: h, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealProtocolID110, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealStream110{p: p, host: h, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.OldDealProtocolID, Legacy: true, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &legacyDealStream{p: p, host: h, rw: s, buffered: buffered}
+	}})
+
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealStatusProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealStatusStream{p: p, host: h, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealStatusProtocolID110, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealStatusStream110{p: p, host: h, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.OldDealStatusProtocolID, Legacy: true, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &legacyDealStatusStream{p: p, host: h, rw: s, buffered: buffered}
+	}})
+
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealRestartProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealRestartStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.CapabilitiesProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &capabilitiesStream{p: p, rw: s, buffered: buffered}
+	}})
+	r.MustRegister(protoregistry.Version{ID: storagemarket.DealNotificationProtocolID, Codec: func(p peer.ID, s network.Stream, buffered *bufio.Reader) interface{} {
+		return &dealNotificationStream{p: p, rw: s, buffered: buffered}
+	}})
+	return r
+}
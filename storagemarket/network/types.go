@@ -4,13 +4,15 @@ import (
 	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/go-address"
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
 )
 
-//go:generate cbor-gen-for --map-encoding AskRequest AskResponse Proposal Response SignedResponse DealStatusRequest DealStatusResponse
+//go:generate cbor-gen-for --map-encoding AskRequest AskResponse Proposal Response SignedResponse DealStatusRequest DealStatusResponse DealView DealRestartRequest DealRestartResponse CapabilitiesResponse DealMessage DealNotification SignedDealNotification
 
 // Proposal is the data sent over the network from client to provider when proposing
 // a deal
@@ -18,6 +20,13 @@ type Proposal struct {
 	DealProposal  *market.ClientDealProposal
 	Piece         *storagemarket.DataRef
 	FastRetrieval bool
+	// Invoice links the deal to an invoice in an off-chain billing system
+	Invoice *storagemarket.InvoiceMetadata
+	// ClientPeerSignature is a signature by the proposal's client address over the
+	// peer binding bytes of the peer sending the proposal, which proves the peer
+	// acts for the client address. It is optional, and is left out of proposals sent
+	// on version 1.1.0 of the deal protocol
+	ClientPeerSignature *crypto.Signature
 }
 
 // ProposalUndefined is an empty Proposal message
@@ -33,6 +42,12 @@ type Response struct {
 
 	// StorageDealProposalAccepted
 	PublishMessage *cid.Cid
+
+	// RetryAfter is set when a proposal is rejected for a transient reason. It is the
+	// number of epochs the client should wait before proposing the deal again. It is
+	// left out of responses sent on version 1.1.0 of the deal protocol, whose clients
+	// give up on rejected deals
+	RetryAfter abi.ChainEpoch
 }
 
 // SignedResponse is a response that is signed
@@ -45,6 +60,18 @@ type SignedResponse struct {
 // SignedResponseUndefined represents an empty SignedResponse message
 var SignedResponseUndefined = SignedResponse{}
 
+// DealMessage is a message on the multiplexed deal protocol, which carries the proposals
+// and responses of many deals over one stream. Each message holds either a proposal or
+// a response, and ID identifies the deal the message belongs to
+type DealMessage struct {
+	ID       uint64
+	Proposal *Proposal
+	Response *SignedResponse
+}
+
+// DealMessageUndefined represents an empty DealMessage message
+var DealMessageUndefined = DealMessage{}
+
 // AskRequest is a request for current ask parameters for a given miner
 type AskRequest struct {
 	Miner address.Address
@@ -79,3 +106,64 @@ type DealStatusResponse struct {
 
 // DealStatusResponseUndefined represents an empty DealStatusResponse message
 var DealStatusResponseUndefined = DealStatusResponse{}
+
+// DealView is one party's view of a deal, exchanged when a client and provider
+// negotiate how to resume a deal after a restart
+type DealView struct {
+	Proposal cid.Cid
+	// State is StorageDealUnknown if the party has no record of the deal
+	State             storagemarket.StorageDealStatus
+	Message           string
+	TransferChannelID *datatransfer.ChannelID
+	// BytesTransferred is the number of bytes sent (client) or received (provider)
+	// on the transfer channel
+	BytesTransferred uint64
+}
+
+// DealRestartRequest is sent by a party that restarted a deal, with its own
+// view of the deal
+type DealRestartRequest struct {
+	View DealView
+}
+
+// DealRestartRequestUndefined represents an empty DealRestartRequest message
+var DealRestartRequestUndefined = DealRestartRequest{}
+
+// DealRestartResponse is the other party's view of the deal in response to a
+// DealRestartRequest
+type DealRestartResponse struct {
+	View DealView
+}
+
+// DealRestartResponseUndefined represents an empty DealRestartResponse message
+var DealRestartResponseUndefined = DealRestartResponse{}
+
+// CapabilitiesResponse is the features a provider supports, which it sends as soon
+// as a capabilities stream is opened
+type CapabilitiesResponse struct {
+	Capabilities storagemarket.ProviderCapabilities
+}
+
+// CapabilitiesResponseUndefined represents an empty CapabilitiesResponse message
+var CapabilitiesResponseUndefined = CapabilitiesResponse{}
+
+// DealNotification is a provider's report that a deal reached a key state, such as
+// being accepted, published, activated or failed, pushed to the client so that it
+// does not have to poll for it
+type DealNotification struct {
+	Proposal cid.Cid
+	State    storagemarket.StorageDealStatus
+	Message  string
+	// DealID and PublishCid are set once the deal is published
+	DealID     abi.DealID
+	PublishCid *cid.Cid
+}
+
+// SignedDealNotification is a deal notification signed by the provider's worker
+type SignedDealNotification struct {
+	Notification DealNotification
+	Signature    *crypto.Signature
+}
+
+// SignedDealNotificationUndefined represents an empty SignedDealNotification message
+var SignedDealNotificationUndefined = SignedDealNotification{}
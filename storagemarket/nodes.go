@@ -98,6 +98,21 @@ type StorageProviderNode interface {
 
 	// GetProofType gets the current seal proof type for the given miner.
 	GetProofType(ctx context.Context, addr address.Address, tok shared.TipSetToken) (abi.RegisteredSealProof, error)
+
+	// GetPublishedProposals returns the proposals in the params of the given PublishStorageDeals
+	// message, in the order they appear in the message, so that when a message publishes several
+	// deals, the provider can tell which of the returned deal IDs belongs to each deal, and which
+	// deals were dropped from the message as invalid
+	GetPublishedProposals(ctx context.Context, publishCid cid.Cid) ([]market.ClientDealProposal, error)
+}
+
+// ExistingPieceNode is implemented by StorageProviderNodes that can add a deal to a piece
+// the provider has already sealed, so duplicate deals for the same data don't need the
+// data transferred and sealed again
+type ExistingPieceNode interface {
+	// OnDealCompleteWithExistingPiece is called instead of OnDealComplete for a deal whose piece
+	// is already sealed, with the location of an existing copy of the piece
+	OnDealCompleteWithExistingPiece(ctx context.Context, deal MinerDeal, sectorNumber abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (*PackingResult, error)
 }
 
 // StorageClientNode are node dependencies for a StorageClient
@@ -118,4 +133,11 @@ type StorageClientNode interface {
 
 	// GetMinerInfo returns info for a single miner with the given address
 	GetMinerInfo(ctx context.Context, maddr address.Address, tok shared.TipSetToken) (*StorageProviderInfo, error)
+
+	// GetDataCap gets the current data cap for addr
+	GetDataCap(ctx context.Context, addr address.Address, tok shared.TipSetToken) (*verifreg.DataCap, error)
+
+	// EstimateReserveFundsFee estimates the fee of the message ReserveFunds would send to
+	// reserve amt for addr in the storage market, or returns zero if no message is needed
+	EstimateReserveFundsFee(ctx context.Context, wallet, addr address.Address, amt abi.TokenAmount) (abi.TokenAmount, error)
 }
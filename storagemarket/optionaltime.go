@@ -0,0 +1,71 @@
+package storagemarket
+
+import (
+	"io"
+	"time"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// OptionalTime is a time that may be unset. It is encoded like cbg.CborTime, except
+// that the zero time is encoded as null, as cbg.CborTime does not read the zero time
+// back unchanged
+type OptionalTime cbg.CborTime
+
+// Time returns the time, or the zero time if it is unset
+func (ot OptionalTime) Time() time.Time {
+	return time.Time(ot)
+}
+
+// IsZero returns true if the time is unset
+func (ot OptionalTime) IsZero() bool {
+	return ot.Time().IsZero()
+}
+
+// MarshalCBOR writes the time as nanoseconds since the Unix epoch, or null if it
+// is unset
+func (ot *OptionalTime) MarshalCBOR(w io.Writer) error {
+	if ot == nil || ot.IsZero() {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	ct := cbg.CborTime(*ot)
+	return ct.MarshalCBOR(w)
+}
+
+// UnmarshalCBOR reads a time written by MarshalCBOR or by cbg.CborTime
+func (ot *OptionalTime) UnmarshalCBOR(r io.Reader) error {
+	br := cbg.GetPeeker(r)
+	b, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == cbg.CborNull[0] {
+		*ot = OptionalTime{}
+		return nil
+	}
+	if err := br.UnreadByte(); err != nil {
+		return err
+	}
+	var ct cbg.CborTime
+	if err := ct.UnmarshalCBOR(br); err != nil {
+		return err
+	}
+	*ot = OptionalTime(ct)
+	return nil
+}
+
+// MarshalJSON writes the time as cbg.CborTime does
+func (ot OptionalTime) MarshalJSON() ([]byte, error) {
+	return ot.Time().MarshalJSON()
+}
+
+// UnmarshalJSON reads a time written by MarshalJSON
+func (ot *OptionalTime) UnmarshalJSON(b []byte) error {
+	var t time.Time
+	if err := t.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	*ot = OptionalTime(t)
+	return nil
+}
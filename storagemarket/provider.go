@@ -5,15 +5,69 @@ import (
 	"io"
 
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
 
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/specs-actors/actors/builtin/market"
 
 	"github.com/filecoin-project/go-fil-markets/shared"
+	"github.com/filecoin-project/go-fil-markets/shared/eventbus"
+	"github.com/filecoin-project/go-fil-markets/shared/fsmexport"
+	"github.com/filecoin-project/go-fil-markets/shared/handlerpool"
 )
 
+// DiskSpaceSubscriber is a callback that is run when a StorageProvider pauses or resumes
+// deal intake because of the free disk space on its volumes
+type DiskSpaceSubscriber func(event DiskSpaceEvent, status DiskSpaceStatus)
+
 // ProviderSubscriber is a callback that is run when events are emitted on a StorageProvider
 type ProviderSubscriber func(event ProviderEvent, deal MinerDeal)
 
+// CollateralPolicy decides how much collateral a storage provider is willing to
+// commit to a deal, so a provider does not have to accept any collateral a client
+// proposes as long as it is within the chain's bounds
+type CollateralPolicy interface {
+	// ProviderCollateralBounds narrows the chain's minimum and maximum provider collateral
+	// for a deal to the range the provider accepts in a proposal
+	ProviderCollateralBounds(ctx context.Context, deal MinerDeal, chainMin, chainMax abi.TokenAmount) (abi.TokenAmount, abi.TokenAmount, error)
+}
+
+// Announcer publishes the pieces a storage provider stores, such as to an index that
+// retrieval clients search to find providers of content
+type Announcer interface {
+	// Announce is called once for each deal that becomes active
+	Announce(ctx context.Context, announcement Announcement) error
+}
+
+// CommPVerifier computes the piece commitments of deal data outside the markets
+// process, such as on a pool of workers with access to the provider's stores, so that
+// verifying large pieces does not tie up the provider
+type CommPVerifier interface {
+	// Submit starts computing the piece commitment of a deal's data, and returns an
+	// ID for the job
+	Submit(ctx context.Context, request CommPRequest) (string, error)
+	// Poll returns the state of a submitted job
+	Poll(ctx context.Context, jobID string) (CommPResult, error)
+}
+
+// PieceHoldChecker spot checks that a sector the provider has sealed still holds a
+// piece, before the provider accepts a deal that reuses its sealed copy of the piece
+// instead of receiving the data again
+type PieceHoldChecker interface {
+	// CheckPieceHeld checks the piece at the given location, such as by unsealing and
+	// reading back a range of the sector, or checking the sector is still provable.
+	// It returns an error if the sector no longer holds the piece
+	CheckPieceHeld(ctx context.Context, pieceCID cid.Cid, sectorNumber abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) error
+}
+
+// SealingProgressReporter reports how far the storage miner has got sealing the
+// sector holding a deal, so that clients can see it in the deal's status
+type SealingProgressReporter interface {
+	// SealingProgress is called when a client asks for the status of a deal that is
+	// being sealed
+	SealingProgress(ctx context.Context, deal MinerDeal) (SealingProgress, error)
+}
+
 // StorageProvider provides an interface to the storage market for a single
 // storage miner.
 type StorageProvider interface {
@@ -39,6 +93,50 @@ type StorageProvider interface {
 	// ListLocalDeals lists deals processed by this storage provider
 	ListLocalDeals() ([]MinerDeal, error)
 
+	// DealStateSnapshot returns the provider deal state machine definition
+	// along with the number of deals currently in each state
+	DealStateSnapshot() (fsmexport.Snapshot, error)
+
+	// DealSummary returns the number of deals in each state, the oldest deal in each
+	// state and how many deals have been in their state for longer than expected
+	DealSummary() (DealSummary, error)
+
+	// DealPipeline returns the deals in progress, with where each is in the deal
+	// flow and what it is waiting on
+	DealPipeline() (DealPipeline, error)
+
+	// GetDealLogs returns the most recent log lines the provider emitted while
+	// handling the deal with the given proposal CID, oldest first
+	GetDealLogs(proposalCid cid.Cid) ([]DealLogEntry, error)
+
+	// InspectDeal returns a deal with how long it has been in its state, its recent
+	// log lines and which operator overrides can be applied to it
+	InspectDeal(proposalCid cid.Cid) (DealInspection, error)
+
+	// ForceFailDeal fails a stuck deal, cleaning up after it as for any failed deal.
+	// The reason is recorded in the deal's message and log
+	ForceFailDeal(proposalCid cid.Cid, reason string) error
+
+	// ForceRetryDeal runs the handler of a stuck deal's state again, as when the
+	// provider restarts. The reason is recorded in the deal's log
+	ForceRetryDeal(proposalCid cid.Cid, reason string) error
+
+	// ForceAdvanceDeal moves a stuck deal on to the given state, which must be the
+	// state the deal moves to once what it waits for happens. Deals waiting on chain
+	// cannot be advanced. The reason is recorded in the deal's message and log
+	ForceAdvanceDeal(proposalCid cid.Cid, to StorageDealStatus, reason string) error
+
+	// Stats returns rolling statistics of the provider's deal throughput
+	Stats() ProviderStats
+
+	// EventStats returns the number of events published to subscribers, and the
+	// number dropped because a subscriber fell behind
+	EventStats() eventbus.Stats
+
+	// HandlerStats returns how many deal state handlers are running, and how many
+	// are waiting for a worker
+	HandlerStats() handlerpool.Stats
+
 	// AddStorageCollateral adds storage collateral
 	AddStorageCollateral(ctx context.Context, amount abi.TokenAmount) error
 
@@ -48,6 +146,15 @@ type StorageProvider interface {
 	// ImportDataForDeal manually imports data for an offline storage deal
 	ImportDataForDeal(ctx context.Context, propCid cid.Cid, data io.Reader) error
 
+	// AddPreAcceptedDeal starts a deal the provider agreed to outside of the deal protocol,
+	// such as through a marketplace's HTTP API. The deal waits for its data straight away,
+	// either imported with ImportDataForDeal or pushed by the client peer over data transfer
+	AddPreAcceptedDeal(ctx context.Context, proposal market.ClientDealProposal, ref *DataRef, client peer.ID) (cid.Cid, error)
+
 	// SubscribeToEvents listens for events that happen related to storage deals on a provider
 	SubscribeToEvents(subscriber ProviderSubscriber) shared.Unsubscribe
+
+	// SubscribeToDiskSpaceEvents listens for deal intake being paused and resumed
+	// because of the free disk space on the provider's volumes
+	SubscribeToDiskSpaceEvents(subscriber DiskSpaceSubscriber) shared.Unsubscribe
 }
@@ -0,0 +1,382 @@
+/*
+Package providerselect ranks storage providers for a prospective storage deal.
+
+It combines three sources of information a client already has at hand:
+
+1. The provider's current StorageAsk
+
+2. The outcomes of the client's own past deals with the provider, as recorded
+in its deal state machines
+
+3. The network latency to the provider
+
+Candidates are first checked against a list of FilterFuncs, which exclude
+providers that cannot take the deal at all (no ask, piece size or duration out of
+bounds). The remaining candidates are scored by a list of weighted ScoreFuncs. Raw scores
+from each ScoreFunc are normalized across candidates to the range [0, 1] before
+weights are applied, so ScoreFuncs only need to agree that higher is better.
+
+This package is groundwork for automated deal-making clients, and does not make
+any deals itself.
+*/
+package providerselect
+
+import (
+	"context"
+	"math"
+	mathbig "math/big"
+	"sort"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+var log = logging.Logger("providerselect")
+
+// History summarizes the outcomes of a client's past deals with a provider
+type History struct {
+	Succeeded  int
+	Failed     int
+	InProgress int
+	// MeanTimeToActive is the mean time deals with the provider took to become
+	// active, or zero if unknown
+	MeanTimeToActive time.Duration
+}
+
+// Total returns the total number of deals in this history
+func (h History) Total() int {
+	return h.Succeeded + h.Failed + h.InProgress
+}
+
+// SuccessRate returns the estimated probability a deal with this provider succeeds.
+// It uses Laplace smoothing so a provider with no finished deals scores 0.5
+func (h History) SuccessRate() float64 {
+	return float64(h.Succeeded+1) / float64(h.Succeeded+h.Failed+2)
+}
+
+// Request describes the deal a client wants to make
+type Request struct {
+	PieceSize    abi.PaddedPieceSize
+	Duration     abi.ChainEpoch
+	VerifiedDeal bool
+}
+
+// Candidate is a storage provider being considered for a deal
+type Candidate struct {
+	Info storagemarket.StorageProviderInfo
+	// Ask is the provider's current ask, or nil if it could not be fetched
+	Ask *storagemarket.StorageAsk
+	// Latency is the measured round trip time to the provider, or zero if unknown
+	Latency time.Duration
+	History History
+}
+
+// TotalCost returns the total price of the requested deal under the candidate's ask
+func (c Candidate) TotalCost(req Request) abi.TokenAmount {
+	if c.Ask == nil {
+		return big.Zero()
+	}
+	askPrice := c.Ask.Price
+	if req.VerifiedDeal {
+		askPrice = c.Ask.VerifiedPrice
+	}
+	perEpoch := big.Div(big.Mul(askPrice, abi.NewTokenAmount(int64(req.PieceSize))), abi.NewTokenAmount(1<<30))
+	return big.Mul(perEpoch, abi.NewTokenAmount(int64(req.Duration)))
+}
+
+// FilterFunc checks whether a candidate can take a deal at all. It returns a non-nil
+// error describing why a candidate is excluded
+type FilterFunc func(req Request, c Candidate) error
+
+// ScoreFunc scores a candidate for a deal. Higher scores are better. Scores are only
+// compared against scores from the same ScoreFunc for other candidates
+type ScoreFunc func(req Request, c Candidate) float64
+
+// Score is a single ScoreFunc's contribution to a candidate's total score
+type Score struct {
+	Name string
+	// Raw is the value returned by the ScoreFunc
+	Raw float64
+	// Normalized is the raw value scaled to [0, 1] across all eligible candidates
+	Normalized float64
+	Weight     float64
+}
+
+// Ranked is a candidate with its computed score
+type Ranked struct {
+	Candidate
+	Score     float64
+	Breakdown []Score
+}
+
+// Rejected is a candidate that was excluded by a FilterFunc
+type Rejected struct {
+	Candidate
+	Reason error
+}
+
+type namedScorer struct {
+	name   string
+	weight float64
+	fn     ScoreFunc
+}
+
+// Selector ranks candidates using a configurable set of filters and scorers
+type Selector struct {
+	filters []FilterFunc
+	scorers []namedScorer
+}
+
+// Option configures a Selector
+type Option func(*Selector)
+
+// WithFilter adds a filter that candidates must pass to be ranked
+func WithFilter(filter FilterFunc) Option {
+	return func(s *Selector) {
+		s.filters = append(s.filters, filter)
+	}
+}
+
+// WithScorer adds a named, weighted scoring function
+func WithScorer(name string, weight float64, fn ScoreFunc) Option {
+	return func(s *Selector) {
+		s.scorers = append(s.scorers, namedScorer{name, weight, fn})
+	}
+}
+
+// WithoutDefaults removes the default filters and scorers, so only those added
+// by later options are used
+func WithoutDefaults() Option {
+	return func(s *Selector) {
+		s.filters = nil
+		s.scorers = nil
+	}
+}
+
+// NewSelector returns a Selector that by default requires a compatible ask, and
+// weighs price, past success rate and latency
+func NewSelector(options ...Option) *Selector {
+	s := &Selector{
+		filters: []FilterFunc{HasAsk, PieceSizeInBounds, DurationInBounds},
+		scorers: []namedScorer{
+			{"price", 1, PriceScore},
+			{"success", 1, SuccessScore},
+			{"latency", 0.5, LatencyScore},
+		},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Rank scores and sorts candidates for the given request, best first. Candidates
+// excluded by a filter are returned separately with the reason they were excluded
+func (s *Selector) Rank(req Request, candidates []Candidate) ([]Ranked, []Rejected) {
+	var eligible []Candidate
+	var rejected []Rejected
+	for _, c := range candidates {
+		if err := s.filter(req, c); err != nil {
+			rejected = append(rejected, Rejected{c, err})
+			continue
+		}
+		eligible = append(eligible, c)
+	}
+
+	ranked := make([]Ranked, len(eligible))
+	for i, c := range eligible {
+		ranked[i] = Ranked{Candidate: c, Breakdown: make([]Score, len(s.scorers))}
+	}
+
+	for j, scorer := range s.scorers {
+		low, high := math.Inf(1), math.Inf(-1)
+		for i := range ranked {
+			raw := scorer.fn(req, ranked[i].Candidate)
+			ranked[i].Breakdown[j] = Score{Name: scorer.name, Raw: raw, Weight: scorer.weight}
+			low = math.Min(low, raw)
+			high = math.Max(high, raw)
+		}
+		for i := range ranked {
+			score := &ranked[i].Breakdown[j]
+			// when all candidates are tied, none gains an advantage
+			score.Normalized = 1
+			if high > low {
+				score.Normalized = (score.Raw - low) / (high - low)
+			}
+			ranked[i].Score += score.Normalized * score.Weight
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked, rejected
+}
+
+func (s *Selector) filter(req Request, c Candidate) error {
+	for _, filter := range s.filters {
+		if err := filter(req, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasAsk excludes candidates without a known ask
+func HasAsk(req Request, c Candidate) error {
+	if c.Ask == nil {
+		return xerrors.New("provider has no ask")
+	}
+	return nil
+}
+
+// PieceSizeInBounds excludes candidates whose ask does not allow the requested piece size
+func PieceSizeInBounds(req Request, c Candidate) error {
+	if c.Ask == nil {
+		return nil
+	}
+	if req.PieceSize < c.Ask.MinPieceSize {
+		return xerrors.Errorf("piece size less than minimum required size: %d < %d", req.PieceSize, c.Ask.MinPieceSize)
+	}
+	if req.PieceSize > c.Ask.MaxPieceSize {
+		return xerrors.Errorf("piece size more than maximum allowed size: %d > %d", req.PieceSize, c.Ask.MaxPieceSize)
+	}
+	return nil
+}
+
+// DurationInBounds excludes candidates whose ask does not allow the requested deal duration
+func DurationInBounds(req Request, c Candidate) error {
+	if c.Ask == nil {
+		return nil
+	}
+	if c.Ask.MinDealDuration != 0 && req.Duration < c.Ask.MinDealDuration {
+		return xerrors.Errorf("deal duration less than minimum required duration: %d < %d", req.Duration, c.Ask.MinDealDuration)
+	}
+	if c.Ask.MaxDealDuration != 0 && req.Duration > c.Ask.MaxDealDuration {
+		return xerrors.Errorf("deal duration more than maximum allowed duration: %d > %d", req.Duration, c.Ask.MaxDealDuration)
+	}
+	return nil
+}
+
+// MaxTotalCost returns a filter excluding candidates whose total deal cost exceeds the given amount
+func MaxTotalCost(max abi.TokenAmount) FilterFunc {
+	return func(req Request, c Candidate) error {
+		cost := c.TotalCost(req)
+		if cost.GreaterThan(max) {
+			return xerrors.Errorf("total deal cost exceeds maximum: %s > %s", cost, max)
+		}
+		return nil
+	}
+}
+
+// PriceScore prefers candidates with a lower total deal cost
+func PriceScore(req Request, c Candidate) float64 {
+	cost, _ := new(mathbig.Float).SetInt(c.TotalCost(req).Int).Float64()
+	return -cost
+}
+
+// SuccessScore prefers candidates with a higher success rate in past deals
+func SuccessScore(req Request, c Candidate) float64 {
+	return c.History.SuccessRate()
+}
+
+// UnknownLatency is the latency assumed for candidates that could not be pinged
+const UnknownLatency = 10 * time.Second
+
+// LatencyScore prefers candidates with a lower round trip time
+func LatencyScore(req Request, c Candidate) float64 {
+	latency := c.Latency
+	if latency == 0 {
+		latency = UnknownLatency
+	}
+	return -latency.Seconds()
+}
+
+// UnknownTimeToActive is the time to active assumed for candidates whose history
+// does not record it
+const UnknownTimeToActive = 72 * time.Hour
+
+// TimeToActiveScore prefers candidates whose past deals became active sooner
+func TimeToActiveScore(req Request, c Candidate) float64 {
+	tta := c.History.MeanTimeToActive
+	if tta == 0 {
+		tta = UnknownTimeToActive
+	}
+	return -tta.Hours()
+}
+
+// SetHistories replaces the history of each candidate that has one in histories,
+// such as the histories kept by the dealanalytics package
+func SetHistories(candidates []Candidate, histories map[address.Address]History) {
+	for i := range candidates {
+		if h, ok := histories[candidates[i].Info.Address]; ok {
+			candidates[i].History = h
+		}
+	}
+}
+
+// HistoryFromDeals summarizes a client's local deal records per provider
+func HistoryFromDeals(deals []storagemarket.ClientDeal) map[address.Address]History {
+	histories := make(map[address.Address]History)
+	for _, deal := range deals {
+		h := histories[deal.Proposal.Provider]
+		switch deal.State {
+		case storagemarket.StorageDealActive, storagemarket.StorageDealExpired:
+			h.Succeeded++
+		case storagemarket.StorageDealError, storagemarket.StorageDealSlashed, storagemarket.StorageDealFailing:
+			h.Failed++
+		default:
+			h.InProgress++
+		}
+		histories[deal.Proposal.Provider] = h
+	}
+	return histories
+}
+
+// LatencyFunc measures the round trip time to a peer, for example with libp2p ping
+type LatencyFunc func(ctx context.Context, p peer.ID) (time.Duration, error)
+
+// GatherCandidates builds candidates for the given providers, fetching each
+// provider's ask through the storage client, measuring latency with the given
+// LatencyFunc (if not nil) and summarizing the client's local deal history.
+// Failures to fetch an ask or measure latency are not fatal: the candidate is
+// returned without that information, so filters and scorers can account for it
+func GatherCandidates(ctx context.Context, client storagemarket.StorageClient, latency LatencyFunc, providers []storagemarket.StorageProviderInfo) ([]Candidate, error) {
+	deals, err := client.ListLocalDeals(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing local deals: %w", err)
+	}
+	histories := HistoryFromDeals(deals)
+
+	candidates := make([]Candidate, 0, len(providers))
+	for _, info := range providers {
+		c := Candidate{
+			Info:    info,
+			History: histories[info.Address],
+		}
+		ask, err := client.GetAsk(ctx, info)
+		if err != nil {
+			log.Debugf("getting ask for provider %s: %s", info.Address, err)
+		} else {
+			c.Ask = ask
+		}
+		if latency != nil {
+			rtt, err := latency(ctx, info.PeerID)
+			if err != nil {
+				log.Debugf("measuring latency to provider %s: %s", info.Address, err)
+			} else {
+				c.Latency = rtt
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
@@ -0,0 +1,137 @@
+package providerselect_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/storagemarket/providerselect"
+)
+
+func makeCandidate(t *testing.T, id uint64, price int64) providerselect.Candidate {
+	addr, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return providerselect.Candidate{
+		Info: storagemarket.StorageProviderInfo{Address: addr},
+		Ask: &storagemarket.StorageAsk{
+			Price:         abi.NewTokenAmount(price),
+			VerifiedPrice: abi.NewTokenAmount(price),
+			MinPieceSize:  256,
+			MaxPieceSize:  1 << 20,
+			Miner:         addr,
+		},
+	}
+}
+
+func TestRank(t *testing.T) {
+	req := providerselect.Request{
+		PieceSize: 1 << 10,
+		Duration:  1000,
+	}
+
+	t.Run("filters incompatible candidates", func(t *testing.T) {
+		noAsk := makeCandidate(t, 100, 1)
+		noAsk.Ask = nil
+		tooSmall := makeCandidate(t, 101, 1)
+		tooSmall.Ask.MinPieceSize = 1 << 12
+		tooLong := makeCandidate(t, 103, 1)
+		tooLong.Ask.MaxDealDuration = 500
+		ok := makeCandidate(t, 102, 1)
+
+		ranked, rejected := providerselect.NewSelector().Rank(req, []providerselect.Candidate{noAsk, tooSmall, tooLong, ok})
+		require.Len(t, ranked, 1)
+		require.Equal(t, ok.Info.Address, ranked[0].Info.Address)
+		require.Len(t, rejected, 3)
+		require.EqualError(t, rejected[0].Reason, "provider has no ask")
+		require.EqualError(t, rejected[1].Reason, "piece size less than minimum required size: 1024 < 4096")
+		require.EqualError(t, rejected[2].Reason, "deal duration more than maximum allowed duration: 1000 > 500")
+	})
+
+	t.Run("prefers cheaper candidates", func(t *testing.T) {
+		cheap := makeCandidate(t, 100, 1<<20)
+		expensive := makeCandidate(t, 101, 1<<30)
+
+		ranked, _ := providerselect.NewSelector().Rank(req, []providerselect.Candidate{expensive, cheap})
+		require.Len(t, ranked, 2)
+		require.Equal(t, cheap.Info.Address, ranked[0].Info.Address)
+	})
+
+	t.Run("prefers candidates with better history and latency", func(t *testing.T) {
+		reliable := makeCandidate(t, 100, 1)
+		reliable.History = providerselect.History{Succeeded: 5}
+		reliable.Latency = 50 * time.Millisecond
+		flaky := makeCandidate(t, 101, 1)
+		flaky.History = providerselect.History{Succeeded: 1, Failed: 4}
+
+		ranked, _ := providerselect.NewSelector().Rank(req, []providerselect.Candidate{flaky, reliable})
+		require.Equal(t, reliable.Info.Address, ranked[0].Info.Address)
+		require.Len(t, ranked[0].Breakdown, 3)
+	})
+
+	t.Run("custom scorers replace defaults", func(t *testing.T) {
+		a := makeCandidate(t, 100, 1)
+		b := makeCandidate(t, 101, 1)
+		preferB := func(req providerselect.Request, c providerselect.Candidate) float64 {
+			if c.Info.Address == b.Info.Address {
+				return 1
+			}
+			return 0
+		}
+
+		selector := providerselect.NewSelector(providerselect.WithoutDefaults(), providerselect.WithScorer("b", 1, preferB))
+		ranked, rejected := selector.Rank(req, []providerselect.Candidate{a, b})
+		require.Empty(t, rejected)
+		require.Equal(t, b.Info.Address, ranked[0].Info.Address)
+		require.Equal(t, float64(1), ranked[0].Score)
+		require.Equal(t, float64(0), ranked[1].Score)
+	})
+
+	t.Run("time to active scorer prefers faster providers", func(t *testing.T) {
+		fast := makeCandidate(t, 100, 1)
+		fast.History.MeanTimeToActive = 6 * time.Hour
+		slow := makeCandidate(t, 101, 1)
+		slow.History.MeanTimeToActive = 48 * time.Hour
+		unknown := makeCandidate(t, 102, 1)
+
+		selector := providerselect.NewSelector(providerselect.WithoutDefaults(), providerselect.WithScorer("time-to-active", 1, providerselect.TimeToActiveScore))
+		ranked, _ := selector.Rank(req, []providerselect.Candidate{unknown, slow, fast})
+		require.Equal(t, fast.Info.Address, ranked[0].Info.Address)
+		require.Equal(t, slow.Info.Address, ranked[1].Info.Address)
+		require.Equal(t, unknown.Info.Address, ranked[2].Info.Address)
+	})
+
+	t.Run("max total cost filter", func(t *testing.T) {
+		c := makeCandidate(t, 100, 1<<30)
+		selector := providerselect.NewSelector(providerselect.WithFilter(providerselect.MaxTotalCost(abi.NewTokenAmount(10))))
+		ranked, rejected := selector.Rank(req, []providerselect.Candidate{c})
+		require.Empty(t, ranked)
+		require.Len(t, rejected, 1)
+	})
+}
+
+func TestHistoryFromDeals(t *testing.T) {
+	addr, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	deal := func(state storagemarket.StorageDealStatus) storagemarket.ClientDeal {
+		d := storagemarket.ClientDeal{State: state}
+		d.Proposal.Provider = addr
+		d.ProposalCid = shared_testutil.GenerateCids(1)[0]
+		return d
+	}
+	histories := providerselect.HistoryFromDeals([]storagemarket.ClientDeal{
+		deal(storagemarket.StorageDealActive),
+		deal(storagemarket.StorageDealExpired),
+		deal(storagemarket.StorageDealError),
+		deal(storagemarket.StorageDealTransferring),
+	})
+	h := histories[addr]
+	require.Equal(t, providerselect.History{Succeeded: 2, Failed: 1, InProgress: 1}, h)
+	require.Equal(t, 4, h.Total())
+	require.Equal(t, 0.6, h.SuccessRate())
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"sync"
 	"testing"
 
 	"github.com/ipfs/go-cid"
@@ -77,6 +78,7 @@ type FakeCommonNode struct {
 	DealFunds                  *shared_testutil.TestDealFunds
 	AddFundsCid                cid.Cid
 	ReserveFundsError          error
+	ReserveFundsWallets        []address.Address
 	VerifySignatureFails       bool
 	GetBalanceError            error
 	GetChainHeadError          error
@@ -137,6 +139,7 @@ func (n *FakeCommonNode) AddFunds(ctx context.Context, addr address.Address, amo
 // ReserveFunds reserves funds required for a deal with the storage market actor
 func (n *FakeCommonNode) ReserveFunds(ctx context.Context, wallet, addr address.Address, amt abi.TokenAmount) (cid.Cid, error) {
 	if n.ReserveFundsError == nil {
+		n.ReserveFundsWallets = append(n.ReserveFundsWallets, wallet)
 		_, _ = n.DealFunds.Reserve(amt)
 		balance := n.SMState.Balance(addr)
 		if balance.Available.LessThan(amt) {
@@ -276,6 +279,9 @@ type FakeClientNode struct {
 	ValidatePublishedError  error
 	ExpectedMinerInfos      []address.Address
 	receivedMinerInfos      []address.Address
+	DataCap                 *verifreg.DataCap
+	GetDataCapErr           error
+	ReserveFundsFee         abi.TokenAmount
 }
 
 // ListStorageProviders lists the providers in the storage market state
@@ -323,6 +329,19 @@ func (n *FakeClientNode) GetMinerInfo(ctx context.Context, maddr address.Address
 	return info, nil
 }
 
+// GetDataCap gets the current data cap for addr
+func (n *FakeClientNode) GetDataCap(ctx context.Context, addr address.Address, tok shared.TipSetToken) (*verifreg.DataCap, error) {
+	return n.DataCap, n.GetDataCapErr
+}
+
+// EstimateReserveFundsFee returns the stubbed ReserveFundsFee, or zero if it is not set
+func (n *FakeClientNode) EstimateReserveFundsFee(ctx context.Context, wallet, addr address.Address, amt abi.TokenAmount) (abi.TokenAmount, error) {
+	if n.ReserveFundsFee.Int == nil {
+		return big.Zero(), nil
+	}
+	return n.ReserveFundsFee, nil
+}
+
 func (n *FakeClientNode) VerifyExpectations(t *testing.T) {
 	require.Equal(t, n.ExpectedMinerInfos, n.receivedMinerInfos)
 }
@@ -341,17 +360,49 @@ type FakeProviderNode struct {
 	OnDealCompleteError                 error
 	LastOnDealCompleteBytes             []byte
 	OnDealCompleteCalls                 []storagemarket.MinerDeal
+	ExistingPieceCalls                  []storagemarket.MinerDeal
 	LocatePieceForDealWithinSectorError error
 	DataCap                             *verifreg.DataCap
 	GetDataCapErr                       error
+	// PublishedProposals, if set, are returned as the proposals in any publish message
+	PublishedProposals         []market.ClientDealProposal
+	GetPublishedProposalsError error
+
+	publishedLk sync.Mutex
+	published   map[cid.Cid][]market.ClientDealProposal
 }
 
 // PublishDeals simulates publishing a deal by adding it to the storage market state
 func (n *FakeProviderNode) PublishDeals(ctx context.Context, deal storagemarket.MinerDeal) (cid.Cid, error) {
-	if n.PublishDealsError == nil {
-		return shared_testutil.GenerateCids(1)[0], nil
+	if n.PublishDealsError != nil {
+		return cid.Undef, n.PublishDealsError
+	}
+	mcid := shared_testutil.GenerateCids(1)[0]
+	n.publishedLk.Lock()
+	defer n.publishedLk.Unlock()
+	if n.published == nil {
+		n.published = make(map[cid.Cid][]market.ClientDealProposal)
 	}
-	return cid.Undef, n.PublishDealsError
+	n.published[mcid] = []market.ClientDealProposal{deal.ClientDealProposal}
+	return mcid, nil
+}
+
+// GetPublishedProposals returns PublishedProposals if set, or otherwise the proposal
+// of the deal published with the given message by PublishDeals
+func (n *FakeProviderNode) GetPublishedProposals(ctx context.Context, publishCid cid.Cid) ([]market.ClientDealProposal, error) {
+	if n.GetPublishedProposalsError != nil {
+		return nil, n.GetPublishedProposalsError
+	}
+	if n.PublishedProposals != nil {
+		return n.PublishedProposals, nil
+	}
+	n.publishedLk.Lock()
+	defer n.publishedLk.Unlock()
+	proposals, ok := n.published[publishCid]
+	if !ok {
+		return nil, errors.New("publish message not found")
+	}
+	return proposals, nil
 }
 
 // OnDealComplete simulates passing of the deal to the storage miner, and does nothing
@@ -362,6 +413,12 @@ func (n *FakeProviderNode) OnDealComplete(ctx context.Context, deal storagemarke
 	return &storagemarket.PackingResult{}, n.OnDealCompleteError
 }
 
+// OnDealCompleteWithExistingPiece simulates adding a deal to an already sealed piece, and does nothing
+func (n *FakeProviderNode) OnDealCompleteWithExistingPiece(ctx context.Context, deal storagemarket.MinerDeal, sectorNumber abi.SectorNumber, offset abi.PaddedPieceSize, length abi.PaddedPieceSize) (*storagemarket.PackingResult, error) {
+	n.ExistingPieceCalls = append(n.ExistingPieceCalls, deal)
+	return &storagemarket.PackingResult{SectorNumber: sectorNumber, Offset: offset, Size: length}, n.OnDealCompleteError
+}
+
 // GetMinerWorkerAddress returns the address specified by MinerAddr
 func (n *FakeProviderNode) GetMinerWorkerAddress(ctx context.Context, miner address.Address, tok shared.TipSetToken) (address.Address, error) {
 	if n.MinerWorkerError == nil {
@@ -389,3 +446,4 @@ func (n *FakeProviderNode) GetProofType(ctx context.Context, addr address.Addres
 }
 
 var _ storagemarket.StorageProviderNode = (*FakeProviderNode)(nil)
+var _ storagemarket.ExistingPieceNode = (*FakeProviderNode)(nil)
@@ -1,6 +1,8 @@
 package storagemarket
 
 import (
+	"time"
+
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	ma "github.com/multiformats/go-multiaddr"
@@ -9,26 +11,62 @@ import (
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-padreader"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/specs-actors/actors/builtin/market"
+	"github.com/filecoin-project/specs-actors/actors/builtin/verifreg"
 
+	"github.com/filecoin-project/go-fil-markets/dagsharding"
+	"github.com/filecoin-project/go-fil-markets/envelope"
 	"github.com/filecoin-project/go-fil-markets/filestore"
 )
 
-//go:generate cbor-gen-for --map-encoding ClientDeal MinerDeal Balance SignedStorageAsk StorageAsk DataRef ProviderDealState
+//go:generate cbor-gen-for --map-encoding ClientDeal MinerDeal Balance SignedStorageAsk StorageAsk DataRef ProviderDealState DealLifecycleEvent ScheduledDeal DealRenewal SealingProgress InvoiceMetadata
 
 // DealProtocolID is the ID for the libp2p protocol for proposing storage deals.
 const OldDealProtocolID = "/fil/storage/mk/1.0.1"
-const DealProtocolID = "/fil/storage/mk/1.1.0"
+const DealProtocolID = "/fil/storage/mk/1.2.0"
+
+// DealProtocolID110 is the ID of the version of the deal protocol before proposals
+// carried invoices or peer signatures, or data refs named a transfer agent. Proposals
+// sent on it leave the invoice and peer signature out, and proposals naming a transfer
+// agent are refused rather than sent without it
+const DealProtocolID110 = "/fil/storage/mk/1.1.0"
+
+// MultiplexedDealProtocolID is the ID for the libp2p protocol for proposing many storage
+// deals over one long-lived stream, with each proposal and response carrying an ID
+// that correlates them
+const MultiplexedDealProtocolID = "/fil/storage/mk/2.0.0"
 
 // AskProtocolID is the ID for the libp2p protocol for querying miners for their current StorageAsk.
 const OldAskProtocolID = "/fil/storage/ask/1.0.1"
-const AskProtocolID = "/fil/storage/ask/1.1.0"
+const AskProtocolID = "/fil/storage/ask/1.2.0"
+
+// AskProtocolID110 is the ID of the version of the ask protocol before asks had deal
+// duration limits. Asks sent on it leave the limits out
+const AskProtocolID110 = "/fil/storage/ask/1.1.0"
 
 // DealStatusProtocolID is the ID for the libp2p protocol for querying miners for the current status of a deal.
 const OldDealStatusProtocolID = "/fil/storage/status/1.0.1"
-const DealStatusProtocolID = "/fil/storage/status/1.1.0"
+const DealStatusProtocolID = "/fil/storage/status/1.2.0"
+
+// DealStatusProtocolID110 is the ID of the version of the deal status protocol before
+// deal status reported the progress of the deal's data transfer and sealing. Deal
+// states sent on it leave the progress out
+const DealStatusProtocolID110 = "/fil/storage/status/1.1.0"
+
+// DealRestartProtocolID is the ID for the libp2p protocol a client or provider uses
+// after a restart to exchange its view of a deal with the other party
+const DealRestartProtocolID = "/fil/storage/restart/1.0.0"
+
+// CapabilitiesProtocolID is the ID for the libp2p protocol for asking a provider which
+// features it supports
+const CapabilitiesProtocolID = "/fil/storage/capabilities/1.0.0"
+
+// DealNotificationProtocolID is the ID for the libp2p protocol a provider uses to push
+// notifications of deal state changes to clients
+const DealNotificationProtocolID = "/fil/storage/notify/1.0.0"
 
 // Balance represents a current balance of funds in the StorageMarketActor.
 type Balance struct {
@@ -51,6 +89,11 @@ type StorageAsk struct {
 	Timestamp    abi.ChainEpoch
 	Expiry       abi.ChainEpoch
 	SeqNo        uint64
+
+	// MinDealDuration and MaxDealDuration limit the number of epochs a deal may
+	// last, from its start epoch to its end epoch. Zero means no limit
+	MinDealDuration abi.ChainEpoch
+	MaxDealDuration abi.ChainEpoch
 }
 
 // SignedStorageAsk is an ask signed by the miner's private key
@@ -79,6 +122,75 @@ func MaxPieceSize(maxPieceSize abi.PaddedPieceSize) StorageAskOption {
 	}
 }
 
+// MinDealDuration configures the minimum number of epochs a deal may last under a
+// StorageAsk
+func MinDealDuration(minDuration abi.ChainEpoch) StorageAskOption {
+	return func(sa *StorageAsk) {
+		sa.MinDealDuration = minDuration
+	}
+}
+
+// MaxDealDuration configures the maximum number of epochs a deal may last under a
+// StorageAsk
+func MaxDealDuration(maxDuration abi.ChainEpoch) StorageAskOption {
+	return func(sa *StorageAsk) {
+		sa.MaxDealDuration = maxDuration
+	}
+}
+
+// DryRunRejectionReason is the reason given to clients when a provider running in
+// dry-run mode rejects a proposal that would otherwise have been accepted
+const DryRunRejectionReason = "dry-run: provider is not accepting deals"
+
+// RetryLaterError rejects a deal for a transient reason, such as a provider that is
+// at capacity or in maintenance, or a client without enough funds yet. Providers
+// send RetryAfter to the client with the rejection, so the client can propose the
+// deal again later instead of giving up on it. A deal decider can return a
+// RetryLaterError to reject a deal this way
+type RetryLaterError struct {
+	Reason string
+	// RetryAfter is the number of epochs the client should wait before proposing
+	// the deal again. Zero uses the provider's default
+	RetryAfter abi.ChainEpoch
+}
+
+func (e *RetryLaterError) Error() string {
+	return e.Reason
+}
+
+// IntakeQuotas limit how many deals a storage provider accepts over time, so that
+// deal intake keeps pace with the provider's sealing throughput. Each quota counts
+// the deals that passed validation within a sliding window. Zero values are not
+// limited
+type IntakeQuotas struct {
+	// MaxDealsPerHour is the most deals accepted in any hour
+	MaxDealsPerHour uint64
+	// MaxBytesPerDay is the most padded piece bytes accepted in any day
+	MaxBytesPerDay uint64
+	// MaxDealsPerClientPerHour is the most deals accepted from one client in any hour
+	MaxDealsPerClientPerHour uint64
+	// MaxBytesPerClientPerDay is the most padded piece bytes accepted from one client
+	// in any day
+	MaxBytesPerClientPerDay uint64
+}
+
+// DiskSpaceStatus is the free disk space on a storage provider's volumes, and whether
+// deal intake is paused because of it
+type DiskSpaceStatus struct {
+	// Paused is true while deal intake is paused
+	Paused bool
+	// Path is the volume with the least free space, or the volume that could not be
+	// checked
+	Path string
+	// Free is the number of bytes free on the volume at Path
+	Free uint64
+	// MinFree is the number of bytes that must be free on every volume for deals to
+	// be accepted
+	MinFree uint64
+	// Message describes why the free space could not be checked
+	Message string
+}
+
 // StorageAskUndefined represents an empty value for StorageAsk
 var StorageAskUndefined = StorageAsk{}
 
@@ -106,6 +218,49 @@ type MinerDeal struct {
 
 	TransferChannelId *datatransfer.ChannelID
 	SectorNumber      abi.SectorNumber
+
+	// PieceReused is true if the deal is added to a piece the provider already
+	// had sealed, instead of to data received from the client
+	PieceReused bool
+
+	// RetryAfter is the number of epochs the client was asked to wait before
+	// proposing the deal again, if it was rejected for a transient reason
+	RetryAfter abi.ChainEpoch
+	// ResubmitBy is when the provider stops waiting for the client to propose a deal
+	// it asked to be retried later, and fails it. It is unset unless the deal is
+	// waiting to be proposed again
+	ResubmitBy OptionalTime
+
+	// TransferStatus, TransferQueued, TransferSent and TransferReceived are the
+	// status and byte counts of the data transfer channel for the deal, as last
+	// reported by the data transfer manager. The byte counts are updated every few
+	// seconds while the status is unchanged
+	TransferStatus   datatransfer.Status
+	TransferQueued   uint64
+	TransferSent     uint64
+	TransferReceived uint64
+
+	// CommPJob identifies the job computing the piece commitment of the deal's data
+	// on an external CommPVerifier, once it has been submitted
+	CommPJob string
+
+	// Invoice links the deal to an invoice in an off-chain billing system, if the
+	// client sent one with its proposal
+	Invoice *InvoiceMetadata
+
+	// PayloadSize is the size of the deal's data as a CAR, before it is padded to
+	// the piece size in the proposal. It is recorded when the deal is handed off for
+	// sealing, and is zero until then or if the deal reuses an existing piece
+	PayloadSize uint64
+
+	// ClientPeerSignature is the signature by the proposal's client address binding
+	// the Client peer to it, if the client sent one with its proposal
+	ClientPeerSignature *crypto.Signature
+
+	// FundingWallet is the wallet the provider's collateral for the deal was reserved
+	// from, if the provider funds deals from a set of wallets rather than from the
+	// miner's worker address
+	FundingWallet *address.Address
 }
 
 // ClientDeal is the local state tracked for a deal by a StorageClient
@@ -129,6 +284,46 @@ type ClientDeal struct {
 	CreationTime      cbg.CborTime
 	TransferChannelID *datatransfer.ChannelID
 	SectorNumber      abi.SectorNumber
+
+	// ResubmitCount is how many times the proposal was sent again after the
+	// provider asked the client to retry later
+	ResubmitCount uint64
+	// ResubmitAt is when the proposal will next be sent again. It is unset unless the
+	// client is waiting to send it again
+	ResubmitAt OptionalTime
+
+	// TransferStatus, TransferQueued, TransferSent and TransferReceived are the
+	// status and byte counts of the data transfer channel for the deal, as last
+	// reported by the data transfer manager. The byte counts are updated every few
+	// seconds while the status is unchanged
+	TransferStatus   datatransfer.Status
+	TransferQueued   uint64
+	TransferSent     uint64
+	TransferReceived uint64
+
+	// SignedProposalCid is the CID the deal carries on under once a proposal that was
+	// awaiting a signature is signed
+	SignedProposalCid *cid.Cid
+
+	// SealingProgress is how far the provider has got sealing the deal's sector, as
+	// last reported while waiting for the deal to become active
+	SealingProgress *SealingProgress
+
+	// Invoice links the deal to an invoice in an off-chain billing system
+	Invoice *InvoiceMetadata
+
+	// Envelope holds the wrapped key the deal's data was encrypted with, if the client
+	// encrypted it. It is never sent to the provider
+	Envelope *envelope.Envelope
+
+	// DealStatusProtocol is the deal status protocol the provider last answered a
+	// status query on, for debugging
+	DealStatusProtocol string
+
+	// PayloadSize is the size of the deal's data as a CAR, before it is padded to
+	// the piece size in the proposal. It is zero if the data ref gave the piece CID,
+	// as the data is then not read
+	PayloadSize uint64
 }
 
 // StorageProviderInfo describes on chain information about a StorageProvider
@@ -147,19 +342,270 @@ type ProposeStorageDealResult struct {
 	ProposalCid cid.Cid
 }
 
+// ProposeShardedStorageDealResult returns the manifest of a payload split into
+// shards, and the proposal CIDs of the deals for its shards, in shard order
+type ProposeShardedStorageDealResult struct {
+	Manifest     *dagsharding.Manifest
+	ProposalCids []cid.Cid
+}
+
+// ProposeStorageDealFromPathParams are the terms of the deals proposed for a file or
+// directory by ProposeStorageDealFromPath
+type ProposeStorageDealFromPathParams struct {
+	// JobID identifies the pipeline, so that proposing from the same path with the
+	// same JobID again resumes it where it stopped. It defaults to the path
+	JobID string
+	// Deal holds the terms of each deal. Its Info, Data and StoreID are set by the
+	// pipeline for each piece
+	Deal ProposeStorageDealParams
+	// TransferType is how the data of each piece reaches the provider. With
+	// TTGraphsync, the default, it is transferred from a store the pipeline loads the
+	// piece's CAR into. With TTManual, the piece's CAR must be imported on the provider
+	TransferType string
+	// Progress is called as the pipeline finishes each stage, if it is set
+	Progress func(PathDealProgress)
+}
+
+// PathDealStage is a stage of the pipeline run by ProposeStorageDealFromPath
+type PathDealStage uint64
+
+const (
+	// PathDealImported is when the path is imported into UnixFS
+	PathDealImported PathDealStage = iota
+	// PathDealSplit is when the imported data is split into pieces that each fit in a
+	// sector
+	PathDealSplit
+	// PathDealPieceReady is when a piece's CAR is written and its CommP computed
+	PathDealPieceReady
+	// PathDealProposed is when a deal is proposed for a piece
+	PathDealProposed
+)
+
+// PathDealProgress is the progress of the pipeline run by ProposeStorageDealFromPath
+type PathDealProgress struct {
+	JobID string
+	Stage PathDealStage
+	// Root is the root of the imported data
+	Root cid.Cid
+	// Piece is the index of the piece a PathDealPieceReady or PathDealProposed stage
+	// is for, and Pieces the number of pieces, once the data is split
+	Piece  int
+	Pieces int
+	// ProposalCid is the proposal of the deal for the piece, at PathDealProposed
+	ProposalCid cid.Cid
+}
+
+// ProposeStorageDealFromPathResult returns the root of the data imported from a path,
+// the manifest of its shards if it was split across several pieces, and the proposal
+// CIDs of the deals for its pieces, in piece order
+type ProposeStorageDealFromPathResult struct {
+	Root         cid.Cid
+	Manifest     *dagsharding.Manifest
+	ProposalCids []cid.Cid
+}
+
+// DealCostEstimate is a breakdown of what a storage deal is expected to cost the client,
+// so it can be shown to the user before the deal is proposed
+type DealCostEstimate struct {
+	// PieceSize is the padded size of the piece the deal would store
+	PieceSize abi.PaddedPieceSize
+	// PayloadSize is the size of the deal's data as a CAR, which is padded to the
+	// piece size. It is zero if the data ref gives the piece CID
+	PayloadSize uint64
+	// StorageCost is the total price of storage over the duration of the deal
+	StorageCost abi.TokenAmount
+	// ClientCollateral is the collateral the client would lock for the deal
+	ClientCollateral abi.TokenAmount
+	// ProviderCollateral is the collateral the provider would lock for the deal
+	ProviderCollateral abi.TokenAmount
+	// EscrowRequired is the market escrow the client must reserve for the deal: its
+	// storage cost plus its client collateral
+	EscrowRequired abi.TokenAmount
+	// EscrowBalance is the client's current balance in the storage market
+	EscrowBalance Balance
+	// ReserveFundsFee is the estimated fee of the message reserving the deal's escrow,
+	// zero if the client has enough funds in escrow already
+	ReserveFundsFee abi.TokenAmount
+	// Total is the funds the client needs for the deal, the escrow required plus
+	// the message fee. Client collateral is returned when the deal ends
+	Total abi.TokenAmount
+	// DataCap is the datacap a verified deal would use, zero for other deals
+	DataCap abi.StoragePower
+	// DataCapAvailable is the client's remaining datacap, nil if the deal is not
+	// verified or the client is not a verified client
+	DataCapAvailable *verifreg.DataCap
+}
+
+// PaddingEstimate shows how much larger the piece a storage deal pays for is than the
+// deal's data. The data is stored as a CAR, which is filled with zeros up to an
+// unpadded piece size, and then fr32 padded, which adds two bits to every 254, to the
+// padded piece size the deal is priced on
+type PaddingEstimate struct {
+	// PayloadSize is the size of the data as a CAR, or zero if it is not known
+	// because the data ref gives the piece CID and size
+	PayloadSize uint64
+	// UnpaddedPieceSize is the size the CAR is filled to with zeros
+	UnpaddedPieceSize abi.UnpaddedPieceSize
+	// PieceSize is the padded size of the piece, which the deal is priced on
+	PieceSize abi.PaddedPieceSize
+	// Overhead is the number of bytes the deal pays for beyond the payload, zero if
+	// the payload size is not known
+	Overhead uint64
+}
+
+// NewPaddingEstimate returns the padding of a payload of the given size
+func NewPaddingEstimate(payloadSize uint64) PaddingEstimate {
+	unpadded := padreader.PaddedSize(payloadSize)
+	return PaddingEstimate{
+		PayloadSize:       payloadSize,
+		UnpaddedPieceSize: unpadded,
+		PieceSize:         unpadded.Padded(),
+		Overhead:          uint64(unpadded.Padded()) - payloadSize,
+	}
+}
+
 // ProposeStorageDealParams describes the parameters for proposing a storage deal
 type ProposeStorageDealParams struct {
-	Addr          address.Address
-	Info          *StorageProviderInfo
-	Data          *DataRef
-	StartEpoch    abi.ChainEpoch
-	EndEpoch      abi.ChainEpoch
-	Price         abi.TokenAmount
-	Collateral    abi.TokenAmount
-	Rt            abi.RegisteredSealProof
-	FastRetrieval bool
-	VerifiedDeal  bool
-	StoreID       *multistore.StoreID
+	Addr       address.Address
+	Info       *StorageProviderInfo
+	Data       *DataRef
+	StartEpoch abi.ChainEpoch
+	EndEpoch   abi.ChainEpoch
+	Price      abi.TokenAmount
+	Collateral abi.TokenAmount
+	// CollateralStrategy chooses the provider collateral to propose from the bounds
+	// allowed on chain. The default proposes Collateral, or the chain minimum if it is
+	// not set
+	CollateralStrategy CollateralStrategy
+	// CollateralMultiple is the multiple of the chain minimum proposed with
+	// CollateralChainMultiple
+	CollateralMultiple uint64
+	Rt                 abi.RegisteredSealProof
+	FastRetrieval      bool
+	VerifiedDeal       bool
+	StoreID            *multistore.StoreID
+	// Invoice is sent to the provider with the proposal, to link the deal to an
+	// invoice in an off-chain billing system. It is optional
+	Invoice *InvoiceMetadata
+	// Envelope is kept with the deal if its data was encrypted, so the data can be
+	// decrypted once retrieved. It is optional, and is not sent to the provider
+	Envelope *envelope.Envelope
+}
+
+// CollateralStrategy chooses the provider collateral a client proposes for a deal,
+// from the minimum and maximum the node reports are allowed on chain for the deal
+type CollateralStrategy uint64
+
+const (
+	// CollateralGiven proposes the collateral given in ProposeStorageDealParams, or
+	// the chain minimum if none is given. Given collateral outside the chain bounds
+	// is rejected before the deal is proposed
+	CollateralGiven CollateralStrategy = iota
+
+	// CollateralChainMinimum proposes the minimum collateral allowed on chain
+	CollateralChainMinimum
+
+	// CollateralChainMultiple proposes a multiple of the minimum collateral allowed on
+	// chain, capped at the maximum
+	CollateralChainMultiple
+
+	// CollateralChainMaximum proposes the maximum collateral allowed on chain
+	CollateralChainMaximum
+)
+
+// InvoiceMetadata links a deal to an invoice in an off-chain billing system, such as
+// one run by a broker that bills for deals in another currency. It is sent with the
+// proposal and kept with the deal by both the client and the provider, but plays no
+// part in deciding whether the deal is valid
+type InvoiceMetadata struct {
+	// InvoiceID identifies the invoice in the billing system
+	InvoiceID string
+	// Currency is the currency the invoice is denominated in, such as "USD" or "FIL"
+	Currency string
+	// QuoteReference identifies the price quote the deal was billed at, such as a
+	// fiat exchange rate quote
+	QuoteReference string
+}
+
+// ClientDealLimits are guardrails a client checks every deal against before it
+// proposes it, so that a mistake in the code driving the client cannot propose
+// absurdly expensive or long deals. The zero value sets no limits
+type ClientDealLimits struct {
+	// MaxPricePerEpochPerGiB is the most the client pays per epoch for each GiB of
+	// padded piece size. A nil amount sets no limit
+	MaxPricePerEpochPerGiB abi.TokenAmount
+	// MaxDuration is the longest deal, in epochs, the client proposes. Zero sets no
+	// limit
+	MaxDuration abi.ChainEpoch
+	// AllowedProviders are the only providers the client proposes deals to. An empty
+	// list allows any provider
+	AllowedProviders []address.Address
+}
+
+// DealSchedule is when a scheduled deal proposal may be sent. The proposal is sent
+// once both the time and the chain epoch have been reached
+type DealSchedule struct {
+	NotBefore      time.Time
+	NotBeforeEpoch abi.ChainEpoch
+}
+
+// ScheduledDeal is a deal proposal the client has been asked to send later. The
+// proposal is built and signed when it is sent, so the data for the deal does not
+// need to be ready until then. Proposing is set while the proposal is being sent,
+// ProposalCid is set once it has been sent, and Message records why the last
+// attempt to send it failed
+type ScheduledDeal struct {
+	ID             uint64
+	Client         address.Address
+	Provider       address.Address
+	Data           *DataRef
+	StartEpoch     abi.ChainEpoch
+	EndEpoch       abi.ChainEpoch
+	Price          abi.TokenAmount
+	Collateral     abi.TokenAmount
+	Rt             abi.RegisteredSealProof
+	FastRetrieval  bool
+	VerifiedDeal   bool
+	StoreID        *multistore.StoreID
+	NotBefore      cbg.CborTime
+	NotBeforeEpoch abi.ChainEpoch
+	Proposing      bool
+	ProposalCid    *cid.Cid
+	Message        string
+	Invoice        *InvoiceMetadata
+	Envelope       *envelope.Envelope
+}
+
+// DealRenewal links a client deal that is nearing its end to the deal proposed to
+// replace it. Provider is the old deal's provider until the renewal policy chooses
+// one for the replacement. NewDeal is set once the replacement has been proposed, Declined is set
+// if the renewal policy chose not to renew the deal, and Message records why the last
+// attempt to renew it failed
+type DealRenewal struct {
+	OldDeal  cid.Cid
+	NewDeal  *cid.Cid
+	Provider address.Address
+	Declined bool
+	Epoch    abi.ChainEpoch
+	Message  string
+}
+
+// ProviderCapabilities are the features a storage provider supports, which it
+// advertises to clients so they can choose terms the provider accepts before
+// proposing a deal
+type ProviderCapabilities struct {
+	// Protocols are the IDs of the storage and retrieval protocols the provider handles
+	Protocols []string
+	// TransferTypes are the data transfer types the provider accepts deals with
+	TransferTypes []string
+	MinPieceSize  abi.PaddedPieceSize
+	MaxPieceSize  abi.PaddedPieceSize
+	// VerifiedOnly is true if the provider only accepts verified deals
+	VerifiedOnly bool
+	// RetrievalFreeTier is true if the provider serves some retrievals without payment
+	RetrievalFreeTier bool
+	// Currencies are the currencies the provider accepts payment in
+	Currencies []string
 }
 
 const (
@@ -169,6 +615,11 @@ const (
 	// TTManual means data for a deal will be transferred manually and imported
 	// on the provider
 	TTManual = "manual"
+
+	// TTExistingPiece means no data will be transferred for a deal, because the
+	// provider already has the deal's piece sealed. The provider rejects the deal
+	// if it does not
+	TTExistingPiece = "existingpiece"
 )
 
 // DataRef is a reference for how data will be transferred for a given storage deal
@@ -178,6 +629,13 @@ type DataRef struct {
 
 	PieceCid  *cid.Cid              // Optional for non-manual transfer, will be recomputed from the data if not given
 	PieceSize abi.UnpaddedPieceSize // Optional for non-manual transfer, will be recomputed from the data if not given
+
+	// TransferAgent is the peer the client authorizes to push the data to the provider
+	// instead of itself, such as a data preparation service. The piece CID and size
+	// must be given, as the client may not have the data. Providers that only speak
+	// deal protocols from before 1.2.0 cannot be sent proposals naming one. It is
+	// omitted from JSON when unset, as an empty peer ID does not decode
+	TransferAgent peer.ID `json:",omitempty"`
 }
 
 // ProviderDealState represents a Provider's current state of a deal
@@ -190,4 +648,300 @@ type ProviderDealState struct {
 	PublishCid    *cid.Cid
 	DealID        abi.DealID
 	FastRetrieval bool
+
+	// TransferChannelID and TransferReceived are the data transfer channel the
+	// provider is receiving the deal's data on and how many bytes it has received,
+	// so that a client that lost track of the transfer can resume it
+	TransferChannelID *datatransfer.ChannelID
+	TransferReceived  uint64
+
+	// Sealing is how far the provider has got sealing the deal's sector, or nil if
+	// the deal is not being sealed
+	Sealing *SealingProgress
+}
+
+// SealingStage is how far a provider has got sealing the sector holding a deal
+type SealingStage uint64
+
+const (
+	// SealingUnknown means the provider did not report how far it has got
+	SealingUnknown SealingStage = iota
+
+	// SealingQueued means the deal is in a sector that has not been pre-committed
+	SealingQueued
+
+	// SealingPreCommitted means the deal's sector was pre-committed, and the provider
+	// is waiting to prove-commit it
+	SealingPreCommitted
+
+	// SealingWaitingProveCommit means the provider sent the prove-commit for the
+	// deal's sector, and is waiting for it to land on chain
+	SealingWaitingProveCommit
+)
+
+// SealingStages maps sealing stages to human readable names
+var SealingStages = map[SealingStage]string{
+	SealingUnknown:            "SealingUnknown",
+	SealingQueued:             "SealingQueued",
+	SealingPreCommitted:       "SealingPreCommitted",
+	SealingWaitingProveCommit: "SealingWaitingProveCommit",
+}
+
+// SealingProgress is a provider's report of how far it has got sealing a deal's
+// sector, which clients poll for while waiting for the deal to become active
+type SealingProgress struct {
+	Stage SealingStage
+	// PreCommitEpoch is the epoch the sector was pre-committed at, or zero if it
+	// has not been pre-committed
+	PreCommitEpoch abi.ChainEpoch
+	// EstimatedActivation is the epoch the provider expects the deal to become
+	// active at, or zero if it has no estimate
+	EstimatedActivation abi.ChainEpoch
+}
+
+// MissesStartEpoch returns true if the provider expects the deal to become active
+// after the given start epoch, which fails the deal
+func (sp SealingProgress) MissesStartEpoch(startEpoch abi.ChainEpoch) bool {
+	return sp.EstimatedActivation != 0 && sp.EstimatedActivation > startEpoch
+}
+
+// DealLifecycleStage is a milestone in a client deal's lifecycle that is reported
+// to DealLifecycleHooks
+type DealLifecycleStage uint64
+
+const (
+	// DealLifecycleProposed means the deal proposal was sent to the provider
+	DealLifecycleProposed DealLifecycleStage = iota
+
+	// DealLifecycleAccepted means the provider accepted the deal
+	DealLifecycleAccepted
+
+	// DealLifecycleTransferStarted means the transfer of deal data to the provider started
+	DealLifecycleTransferStarted
+
+	// DealLifecyclePublished means the deal was published on chain
+	DealLifecyclePublished
+
+	// DealLifecycleActive means the deal's sector was proven and the deal is active
+	DealLifecycleActive
+
+	// DealLifecycleFailed means the deal terminated in failure
+	DealLifecycleFailed
+)
+
+// DealLifecycleStages maps a deal lifecycle stage to a human readable name
+var DealLifecycleStages = map[DealLifecycleStage]string{
+	DealLifecycleProposed:        "DealLifecycleProposed",
+	DealLifecycleAccepted:        "DealLifecycleAccepted",
+	DealLifecycleTransferStarted: "DealLifecycleTransferStarted",
+	DealLifecyclePublished:       "DealLifecyclePublished",
+	DealLifecycleActive:          "DealLifecycleActive",
+	DealLifecycleFailed:          "DealLifecycleFailed",
+}
+
+func (s DealLifecycleStage) String() string {
+	return DealLifecycleStages[s]
+}
+
+// DealLifecycleEvent describes a client deal at the point it reached a lifecycle stage.
+// Sequence increases with every event a client records, so receivers can use it to
+// discard events delivered more than once
+type DealLifecycleEvent struct {
+	Sequence       uint64
+	Stage          DealLifecycleStage
+	ProposalCid    cid.Cid
+	Provider       address.Address
+	PayloadCID     cid.Cid
+	PieceCID       cid.Cid
+	DealID         abi.DealID
+	PublishMessage *cid.Cid
+	State          StorageDealStatus
+	Message        string
+}
+
+// DealSummary summarizes the deals a storage provider is tracking by the state they
+// are in, for health checks and alerting
+type DealSummary struct {
+	// States maps each state that has deals in it to a summary of those deals
+	States map[StorageDealStatus]DealStateSummary
+	// Total is the number of deals tracked
+	Total int
+	// Stuck is the number of deals that have been in their state for longer than expected
+	Stuck int
+	Time  time.Time
+}
+
+// DealStateSummary summarizes the deals in one state
+type DealStateSummary struct {
+	Count int
+	// OldestDeal is the deal that has been in the state the longest, and OldestSince
+	// the time it entered the state
+	OldestDeal  cid.Cid
+	OldestSince time.Time
+	// Stuck is the number of deals that have been in the state for longer than its
+	// expected dwell time
+	Stuck int
+}
+
+// DealBlocker is what a deal in a storage provider's pipeline is waiting on
+type DealBlocker uint64
+
+const (
+	// DealBlockerNone means the provider is working on the deal, and it is not
+	// waiting on anything else
+	DealBlockerNone DealBlocker = iota
+
+	// DealBlockerDecision means the deal is waiting for the provider's deal decision
+	// logic to accept or reject it
+	DealBlockerDecision
+
+	// DealBlockerClient means the deal is waiting for the client, to start sending
+	// its data or to propose it again. Deals with a manual transfer wait for the
+	// provider's operator to import their data instead
+	DealBlockerClient
+
+	// DealBlockerTransferSlot means the deal is waiting for other transfers from the
+	// same client to finish before its data is received
+	DealBlockerTransferSlot
+
+	// DealBlockerTransfer means the deal's data is being received on a data transfer
+	// channel
+	DealBlockerTransfer
+
+	// DealBlockerChainMessage means the deal is waiting for a message to land on chain
+	DealBlockerChainMessage
+
+	// DealBlockerSealing means the deal is waiting for the miner to seal it into a
+	// sector
+	DealBlockerSealing
+)
+
+// DealBlockers maps deal blocker codes to string names
+var DealBlockers = map[DealBlocker]string{
+	DealBlockerNone:         "DealBlockerNone",
+	DealBlockerDecision:     "DealBlockerDecision",
+	DealBlockerClient:       "DealBlockerClient",
+	DealBlockerTransferSlot: "DealBlockerTransferSlot",
+	DealBlockerTransfer:     "DealBlockerTransfer",
+	DealBlockerChainMessage: "DealBlockerChainMessage",
+	DealBlockerSealing:      "DealBlockerSealing",
+}
+
+func (b DealBlocker) String() string {
+	return DealBlockers[b]
+}
+
+// PipelineDeal is where a deal is in a storage provider's deal flow
+type PipelineDeal struct {
+	ProposalCid cid.Cid
+	Client      peer.ID
+	State       StorageDealStatus
+	// Entered is the time the deal entered its state
+	Entered time.Time
+	// Next is the state the deal moves to next if all goes well, and ExpectedBy the
+	// time it is expected to have left its current state by, or zero if the state
+	// has no expected dwell time
+	Next       StorageDealStatus
+	ExpectedBy time.Time
+	// Stuck is true if the deal has been in its state for longer than expected
+	Stuck bool
+	// Blocker is what the deal is waiting on
+	Blocker DealBlocker
+	// Message is the chain message the deal is waiting on, for DealBlockerChainMessage
+	Message *cid.Cid
+	// TransferChannelID is the channel the deal's data is received on, for
+	// DealBlockerTransfer, once it is open
+	TransferChannelID *datatransfer.ChannelID
+	// SectorNumber is the sector the deal is sealed into, for DealBlockerSealing,
+	// once the miner has assigned one
+	SectorNumber abi.SectorNumber
+}
+
+// DealPipeline is the deals a storage provider has in progress, with where each is
+// in the deal flow and what it is waiting on, for operator UIs
+type DealPipeline struct {
+	// Deals are the deals in progress, those that have been in their state the
+	// longest first. Deals that are active or have finished are left out
+	Deals []PipelineDeal
+	Time  time.Time
+}
+
+// DealLogEntry is a log line a storage provider emitted while handling a deal
+type DealLogEntry struct {
+	Time time.Time
+	// Level is the log level, such as "info", "warn" or "error"
+	Level   string
+	Message string
+}
+
+// DealInspection is a deal with what an operator can do to move it on if it is stuck
+type DealInspection struct {
+	Deal MinerDeal
+	// Entered is when the deal entered its state, or when the provider started if the
+	// deal has not changed state since
+	Entered time.Time
+	// Stuck is true if the deal has been in its state for longer than expected
+	Stuck bool
+	// CanFail is true if the deal can be forced to fail with ForceFailDeal
+	CanFail bool
+	// CanRetry is true if the handler of the deal's state can be run again with
+	// ForceRetryDeal
+	CanRetry bool
+	// AdvanceTo is the state ForceAdvanceDeal moves the deal on to, or
+	// StorageDealUnknown if the deal cannot be advanced from its state
+	AdvanceTo StorageDealStatus
+	// Logs are the most recent log lines emitted for the deal, oldest first
+	Logs []DealLogEntry
+}
+
+// ProviderStats are rolling statistics of a storage provider's deal throughput, for
+// operator dashboards
+type ProviderStats struct {
+	// Window is the period the statistics cover, ending at Time
+	Window time.Duration
+	Time   time.Time
+
+	DealsAccepted        uint64
+	DealsAcceptedPerHour float64
+	// BytesIngested is the padded size of the pieces received and verified for deals
+	BytesIngested     uint64
+	GiBIngestedPerDay float64
+	// DealsActivated is the number of deals that became active on chain, and
+	// AverageTimeToActive the average time from their proposal until then
+	DealsActivated      uint64
+	AverageTimeToActive time.Duration
+}
+
+// CommPRequest asks a CommPVerifier for the piece commitment of a deal's data
+type CommPRequest struct {
+	ProposalCid cid.Cid
+	PayloadCID  cid.Cid
+	// StoreID is the multistore the provider received the deal's data into, if any
+	StoreID   *multistore.StoreID
+	ProofType abi.RegisteredSealProof
+	PieceSize abi.PaddedPieceSize
+}
+
+// CommPResult is the state of a job submitted to a CommPVerifier
+type CommPResult struct {
+	// Done is true once the job has finished, successfully or not
+	Done bool
+	// PieceCID is the computed piece commitment, if the job succeeded
+	PieceCID cid.Cid
+	// Error is why the job failed, if it failed
+	Error string
+}
+
+// Announcement describes a piece a storage provider stores in an active deal, and
+// where its data can be retrieved from
+type Announcement struct {
+	PieceCID      cid.Cid
+	PayloadCID    cid.Cid
+	DealID        abi.DealID
+	ProposalCid   cid.Cid
+	Provider      address.Address
+	FastRetrieval bool
+	// PeerID and Addrs are the retrieval endpoints of the provider
+	PeerID peer.ID
+	Addrs  []ma.Multiaddr
 }
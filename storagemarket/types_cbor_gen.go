@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 
+	address "github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
+	envelope "github.com/filecoin-project/go-fil-markets/envelope"
 	filestore "github.com/filecoin-project/go-fil-markets/filestore"
 	multistore "github.com/filecoin-project/go-multistore"
 	abi "github.com/filecoin-project/go-state-types/abi"
@@ -24,7 +26,7 @@ func (t *ClientDeal) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{179}); err != nil {
+	if _, err := w.Write([]byte{184, 31}); err != nil {
 		return err
 	}
 
@@ -372,6 +374,211 @@ func (t *ClientDeal) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.ResubmitCount (uint64) (uint64)
+	if len("ResubmitCount") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ResubmitCount\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ResubmitCount"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ResubmitCount")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ResubmitCount)); err != nil {
+		return err
+	}
+
+	// t.ResubmitAt (storagemarket.OptionalTime) (struct)
+	if len("ResubmitAt") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ResubmitAt\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ResubmitAt"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ResubmitAt")); err != nil {
+		return err
+	}
+
+	if err := t.ResubmitAt.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.TransferStatus (datatransfer.Status) (uint64)
+	if len("TransferStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferStatus\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferStatus"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferStatus")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferStatus)); err != nil {
+		return err
+	}
+
+	// t.TransferQueued (uint64) (uint64)
+	if len("TransferQueued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferQueued\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferQueued"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferQueued")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferQueued)); err != nil {
+		return err
+	}
+
+	// t.TransferSent (uint64) (uint64)
+	if len("TransferSent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferSent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferSent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferSent")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferSent)); err != nil {
+		return err
+	}
+
+	// t.TransferReceived (uint64) (uint64)
+	if len("TransferReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferReceived\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferReceived"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferReceived")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferReceived)); err != nil {
+		return err
+	}
+
+	// t.SignedProposalCid (cid.Cid) (struct)
+	if len("SignedProposalCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SignedProposalCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("SignedProposalCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SignedProposalCid")); err != nil {
+		return err
+	}
+
+	if t.SignedProposalCid == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.SignedProposalCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.SignedProposalCid: %w", err)
+		}
+	}
+
+	// t.SealingProgress (storagemarket.SealingProgress) (struct)
+	if len("SealingProgress") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SealingProgress\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("SealingProgress"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SealingProgress")); err != nil {
+		return err
+	}
+
+	if err := t.SealingProgress.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+	if len("Invoice") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Invoice\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Invoice"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Invoice")); err != nil {
+		return err
+	}
+
+	if err := t.Invoice.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Envelope (envelope.Envelope) (struct)
+	if len("Envelope") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Envelope\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Envelope"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Envelope")); err != nil {
+		return err
+	}
+
+	if err := t.Envelope.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.DealStatusProtocol (string) (string)
+	if len("DealStatusProtocol") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealStatusProtocol\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("DealStatusProtocol"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealStatusProtocol")); err != nil {
+		return err
+	}
+
+	if len(t.DealStatusProtocol) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.DealStatusProtocol was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.DealStatusProtocol))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.DealStatusProtocol)); err != nil {
+		return err
+	}
+
+	// t.PayloadSize (uint64) (uint64)
+	if len("PayloadSize") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PayloadSize\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PayloadSize"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PayloadSize")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PayloadSize)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -713,111 +920,305 @@ func (t *ClientDeal) UnmarshalCBOR(r io.Reader) error {
 				t.SectorNumber = abi.SectorNumber(extra)
 
 			}
+			// t.ResubmitCount (uint64) (uint64)
+		case "ResubmitCount":
 
-		default:
-			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
-		}
-	}
+			{
 
-	return nil
-}
-func (t *MinerDeal) MarshalCBOR(w io.Writer) error {
-	if t == nil {
-		_, err := w.Write(cbg.CborNull)
-		return err
-	}
-	if _, err := w.Write([]byte{180}); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.ResubmitCount = uint64(extra)
 
-	scratch := make([]byte, 9)
+			}
+			// t.ResubmitAt (storagemarket.OptionalTime) (struct)
+		case "ResubmitAt":
 
-	// t.ClientDealProposal (market.ClientDealProposal) (struct)
-	if len("ClientDealProposal") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"ClientDealProposal\" was too long")
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ClientDealProposal"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("ClientDealProposal")); err != nil {
-		return err
-	}
+				if err := t.ResubmitAt.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.ResubmitAt: %w", err)
+				}
 
-	if err := t.ClientDealProposal.MarshalCBOR(w); err != nil {
-		return err
-	}
+			}
+			// t.TransferStatus (datatransfer.Status) (uint64)
+		case "TransferStatus":
 
-	// t.ProposalCid (cid.Cid) (struct)
-	if len("ProposalCid") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"ProposalCid\" was too long")
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ProposalCid"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("ProposalCid")); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferStatus = datatransfer.Status(extra)
 
-	if err := cbg.WriteCidBuf(scratch, w, t.ProposalCid); err != nil {
-		return xerrors.Errorf("failed to write cid field t.ProposalCid: %w", err)
-	}
+			}
+			// t.TransferQueued (uint64) (uint64)
+		case "TransferQueued":
 
-	// t.AddFundsCid (cid.Cid) (struct)
-	if len("AddFundsCid") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"AddFundsCid\" was too long")
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("AddFundsCid"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("AddFundsCid")); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferQueued = uint64(extra)
 
-	if t.AddFundsCid == nil {
-		if _, err := w.Write(cbg.CborNull); err != nil {
-			return err
-		}
-	} else {
-		if err := cbg.WriteCidBuf(scratch, w, *t.AddFundsCid); err != nil {
-			return xerrors.Errorf("failed to write cid field t.AddFundsCid: %w", err)
-		}
-	}
+			}
+			// t.TransferSent (uint64) (uint64)
+		case "TransferSent":
 
-	// t.PublishCid (cid.Cid) (struct)
-	if len("PublishCid") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"PublishCid\" was too long")
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PublishCid"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("PublishCid")); err != nil {
-		return err
-	}
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferSent = uint64(extra)
 
-	if t.PublishCid == nil {
-		if _, err := w.Write(cbg.CborNull); err != nil {
-			return err
-		}
-	} else {
-		if err := cbg.WriteCidBuf(scratch, w, *t.PublishCid); err != nil {
-			return xerrors.Errorf("failed to write cid field t.PublishCid: %w", err)
-		}
-	}
+			}
+			// t.TransferReceived (uint64) (uint64)
+		case "TransferReceived":
 
-	// t.Miner (peer.ID) (string)
-	if len("Miner") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Miner\" was too long")
-	}
+			{
 
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Miner"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Miner")); err != nil {
-		return err
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferReceived = uint64(extra)
+
+			}
+			// t.SignedProposalCid (cid.Cid) (struct)
+		case "SignedProposalCid":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.SignedProposalCid: %w", err)
+					}
+
+					t.SignedProposalCid = &c
+				}
+
+			}
+			// t.SealingProgress (storagemarket.SealingProgress) (struct)
+		case "SealingProgress":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.SealingProgress = new(SealingProgress)
+					if err := t.SealingProgress.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.SealingProgress pointer: %w", err)
+					}
+				}
+
+			}
+			// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+		case "Invoice":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Invoice = new(InvoiceMetadata)
+					if err := t.Invoice.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Invoice pointer: %w", err)
+					}
+				}
+
+			}
+			// t.Envelope (envelope.Envelope) (struct)
+		case "Envelope":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Envelope = new(envelope.Envelope)
+					if err := t.Envelope.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Envelope pointer: %w", err)
+					}
+				}
+
+			}
+			// t.DealStatusProtocol (string) (string)
+		case "DealStatusProtocol":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.DealStatusProtocol = string(sval)
+			}
+			// t.PayloadSize (uint64) (uint64)
+		case "PayloadSize":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.PayloadSize = uint64(extra)
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *MinerDeal) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{184, 32}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.ClientDealProposal (market.ClientDealProposal) (struct)
+	if len("ClientDealProposal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ClientDealProposal\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ClientDealProposal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ClientDealProposal")); err != nil {
+		return err
+	}
+
+	if err := t.ClientDealProposal.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.ProposalCid (cid.Cid) (struct)
+	if len("ProposalCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ProposalCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ProposalCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ProposalCid")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.ProposalCid); err != nil {
+		return xerrors.Errorf("failed to write cid field t.ProposalCid: %w", err)
+	}
+
+	// t.AddFundsCid (cid.Cid) (struct)
+	if len("AddFundsCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"AddFundsCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("AddFundsCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("AddFundsCid")); err != nil {
+		return err
+	}
+
+	if t.AddFundsCid == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.AddFundsCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.AddFundsCid: %w", err)
+		}
+	}
+
+	// t.PublishCid (cid.Cid) (struct)
+	if len("PublishCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PublishCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PublishCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PublishCid")); err != nil {
+		return err
+	}
+
+	if t.PublishCid == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.PublishCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.PublishCid: %w", err)
+		}
+	}
+
+	// t.Miner (peer.ID) (string)
+	if len("Miner") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Miner\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Miner"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Miner")); err != nil {
+		return err
 	}
 
 	if len(t.Miner) > cbg.MaxLength {
@@ -1111,50 +1512,254 @@ func (t *MinerDeal) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
-	return nil
-}
+	// t.PieceReused (bool) (bool)
+	if len("PieceReused") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PieceReused\" was too long")
+	}
 
-func (t *MinerDeal) UnmarshalCBOR(r io.Reader) error {
-	*t = MinerDeal{}
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PieceReused"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PieceReused")); err != nil {
+		return err
+	}
 
-	br := cbg.GetPeeker(r)
-	scratch := make([]byte, 8)
+	if err := cbg.WriteBool(w, t.PieceReused); err != nil {
+		return err
+	}
 
-	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
-	if err != nil {
+	// t.RetryAfter (abi.ChainEpoch) (int64)
+	if len("RetryAfter") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"RetryAfter\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("RetryAfter"))); err != nil {
 		return err
 	}
-	if maj != cbg.MajMap {
-		return fmt.Errorf("cbor input should be of type map")
+	if _, err := io.WriteString(w, string("RetryAfter")); err != nil {
+		return err
 	}
 
-	if extra > cbg.MaxLength {
-		return fmt.Errorf("MinerDeal: map struct too large (%d)", extra)
+	if t.RetryAfter >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.RetryAfter)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.RetryAfter-1)); err != nil {
+			return err
+		}
 	}
 
-	var name string
-	n := extra
+	// t.ResubmitBy (storagemarket.OptionalTime) (struct)
+	if len("ResubmitBy") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ResubmitBy\" was too long")
+	}
 
-	for i := uint64(0); i < n; i++ {
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ResubmitBy"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ResubmitBy")); err != nil {
+		return err
+	}
 
-		{
-			sval, err := cbg.ReadStringBuf(br, scratch)
-			if err != nil {
-				return err
-			}
+	if err := t.ResubmitBy.MarshalCBOR(w); err != nil {
+		return err
+	}
 
-			name = string(sval)
-		}
+	// t.TransferStatus (datatransfer.Status) (uint64)
+	if len("TransferStatus") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferStatus\" was too long")
+	}
 
-		switch name {
-		// t.ClientDealProposal (market.ClientDealProposal) (struct)
-		case "ClientDealProposal":
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferStatus"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferStatus")); err != nil {
+		return err
+	}
 
-			{
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferStatus)); err != nil {
+		return err
+	}
 
-				if err := t.ClientDealProposal.UnmarshalCBOR(br); err != nil {
-					return xerrors.Errorf("unmarshaling t.ClientDealProposal: %w", err)
-				}
+	// t.TransferQueued (uint64) (uint64)
+	if len("TransferQueued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferQueued\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferQueued"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferQueued")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferQueued)); err != nil {
+		return err
+	}
+
+	// t.TransferSent (uint64) (uint64)
+	if len("TransferSent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferSent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferSent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferSent")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferSent)); err != nil {
+		return err
+	}
+
+	// t.TransferReceived (uint64) (uint64)
+	if len("TransferReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferReceived\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferReceived"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferReceived")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferReceived)); err != nil {
+		return err
+	}
+
+	// t.CommPJob (string) (string)
+	if len("CommPJob") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"CommPJob\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("CommPJob"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("CommPJob")); err != nil {
+		return err
+	}
+
+	if len(t.CommPJob) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.CommPJob was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.CommPJob))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.CommPJob)); err != nil {
+		return err
+	}
+
+	// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+	if len("Invoice") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Invoice\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Invoice"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Invoice")); err != nil {
+		return err
+	}
+
+	if err := t.Invoice.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.PayloadSize (uint64) (uint64)
+	if len("PayloadSize") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PayloadSize\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PayloadSize"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PayloadSize")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PayloadSize)); err != nil {
+		return err
+	}
+
+	// t.ClientPeerSignature (crypto.Signature) (struct)
+	if len("ClientPeerSignature") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ClientPeerSignature\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ClientPeerSignature"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ClientPeerSignature")); err != nil {
+		return err
+	}
+
+	if err := t.ClientPeerSignature.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.FundingWallet (address.Address) (struct)
+	if len("FundingWallet") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"FundingWallet\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("FundingWallet"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("FundingWallet")); err != nil {
+		return err
+	}
+
+	if err := t.FundingWallet.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *MinerDeal) UnmarshalCBOR(r io.Reader) error {
+	*t = MinerDeal{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("MinerDeal: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.ClientDealProposal (market.ClientDealProposal) (struct)
+		case "ClientDealProposal":
+
+			{
+
+				if err := t.ClientDealProposal.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.ClientDealProposal: %w", err)
+				}
 
 			}
 			// t.ProposalCid (cid.Cid) (struct)
@@ -1463,116 +2068,316 @@ func (t *MinerDeal) UnmarshalCBOR(r io.Reader) error {
 				t.SectorNumber = abi.SectorNumber(extra)
 
 			}
+			// t.PieceReused (bool) (bool)
+		case "PieceReused":
 
-		default:
-			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
-		}
-	}
-
-	return nil
-}
-func (t *Balance) MarshalCBOR(w io.Writer) error {
-	if t == nil {
-		_, err := w.Write(cbg.CborNull)
-		return err
-	}
-	if _, err := w.Write([]byte{162}); err != nil {
-		return err
-	}
-
-	scratch := make([]byte, 9)
-
-	// t.Locked (big.Int) (struct)
-	if len("Locked") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Locked\" was too long")
-	}
-
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Locked"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Locked")); err != nil {
-		return err
-	}
-
-	if err := t.Locked.MarshalCBOR(w); err != nil {
-		return err
-	}
-
-	// t.Available (big.Int) (struct)
-	if len("Available") > cbg.MaxLength {
-		return xerrors.Errorf("Value in field \"Available\" was too long")
-	}
-
-	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Available"))); err != nil {
-		return err
-	}
-	if _, err := io.WriteString(w, string("Available")); err != nil {
-		return err
-	}
-
-	if err := t.Available.MarshalCBOR(w); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (t *Balance) UnmarshalCBOR(r io.Reader) error {
-	*t = Balance{}
-
-	br := cbg.GetPeeker(r)
-	scratch := make([]byte, 8)
-
-	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
-	if err != nil {
-		return err
-	}
-	if maj != cbg.MajMap {
-		return fmt.Errorf("cbor input should be of type map")
-	}
-
-	if extra > cbg.MaxLength {
-		return fmt.Errorf("Balance: map struct too large (%d)", extra)
-	}
-
-	var name string
-	n := extra
-
-	for i := uint64(0); i < n; i++ {
-
-		{
-			sval, err := cbg.ReadStringBuf(br, scratch)
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
 			if err != nil {
 				return err
 			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.PieceReused = false
+			case 21:
+				t.PieceReused = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.RetryAfter (abi.ChainEpoch) (int64)
+		case "RetryAfter":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
 
-			name = string(sval)
-		}
-
-		switch name {
-		// t.Locked (big.Int) (struct)
-		case "Locked":
+				t.RetryAfter = abi.ChainEpoch(extraI)
+			}
+			// t.ResubmitBy (storagemarket.OptionalTime) (struct)
+		case "ResubmitBy":
 
 			{
 
-				if err := t.Locked.UnmarshalCBOR(br); err != nil {
-					return xerrors.Errorf("unmarshaling t.Locked: %w", err)
+				if err := t.ResubmitBy.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.ResubmitBy: %w", err)
 				}
 
 			}
-			// t.Available (big.Int) (struct)
-		case "Available":
+			// t.TransferStatus (datatransfer.Status) (uint64)
+		case "TransferStatus":
 
 			{
 
-				if err := t.Available.UnmarshalCBOR(br); err != nil {
-					return xerrors.Errorf("unmarshaling t.Available: %w", err)
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
 				}
+				t.TransferStatus = datatransfer.Status(extra)
 
 			}
+			// t.TransferQueued (uint64) (uint64)
+		case "TransferQueued":
 
-		default:
-			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
-		}
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferQueued = uint64(extra)
+
+			}
+			// t.TransferSent (uint64) (uint64)
+		case "TransferSent":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferSent = uint64(extra)
+
+			}
+			// t.TransferReceived (uint64) (uint64)
+		case "TransferReceived":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferReceived = uint64(extra)
+
+			}
+			// t.CommPJob (string) (string)
+		case "CommPJob":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.CommPJob = string(sval)
+			}
+			// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+		case "Invoice":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Invoice = new(InvoiceMetadata)
+					if err := t.Invoice.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Invoice pointer: %w", err)
+					}
+				}
+
+			}
+			// t.PayloadSize (uint64) (uint64)
+		case "PayloadSize":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.PayloadSize = uint64(extra)
+
+			}
+			// t.ClientPeerSignature (crypto.Signature) (struct)
+		case "ClientPeerSignature":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.ClientPeerSignature = new(crypto.Signature)
+					if err := t.ClientPeerSignature.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.ClientPeerSignature pointer: %w", err)
+					}
+				}
+
+			}
+			// t.FundingWallet (address.Address) (struct)
+		case "FundingWallet":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.FundingWallet = new(address.Address)
+					if err := t.FundingWallet.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.FundingWallet pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *Balance) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{162}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Locked (big.Int) (struct)
+	if len("Locked") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Locked\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Locked"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Locked")); err != nil {
+		return err
+	}
+
+	if err := t.Locked.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Available (big.Int) (struct)
+	if len("Available") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Available\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Available"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Available")); err != nil {
+		return err
+	}
+
+	if err := t.Available.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *Balance) UnmarshalCBOR(r io.Reader) error {
+	*t = Balance{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("Balance: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Locked (big.Int) (struct)
+		case "Locked":
+
+			{
+
+				if err := t.Locked.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Locked: %w", err)
+				}
+
+			}
+			// t.Available (big.Int) (struct)
+		case "Available":
+
+			{
+
+				if err := t.Available.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Available: %w", err)
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
 	}
 
 	return nil
@@ -1708,7 +2513,7 @@ func (t *StorageAsk) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{168}); err != nil {
+	if _, err := w.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -1854,6 +2659,49 @@ func (t *StorageAsk) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.MinDealDuration (abi.ChainEpoch) (int64)
+	if len("MinDealDuration") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MinDealDuration\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("MinDealDuration"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("MinDealDuration")); err != nil {
+		return err
+	}
+
+	if t.MinDealDuration >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.MinDealDuration)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.MinDealDuration-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.MaxDealDuration (abi.ChainEpoch) (int64)
+	if len("MaxDealDuration") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"MaxDealDuration\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("MaxDealDuration"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("MaxDealDuration")); err != nil {
+		return err
+	}
+
+	if t.MaxDealDuration >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.MaxDealDuration)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.MaxDealDuration-1)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -2017,26 +2865,78 @@ func (t *StorageAsk) UnmarshalCBOR(r io.Reader) error {
 				t.SeqNo = uint64(extra)
 
 			}
-
-		default:
-			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
-		}
-	}
-
-	return nil
-}
-func (t *DataRef) MarshalCBOR(w io.Writer) error {
-	if t == nil {
-		_, err := w.Write(cbg.CborNull)
-		return err
-	}
-	if _, err := w.Write([]byte{164}); err != nil {
-		return err
-	}
-
-	scratch := make([]byte, 9)
-
-	// t.TransferType (string) (string)
+			// t.MinDealDuration (abi.ChainEpoch) (int64)
+		case "MinDealDuration":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.MinDealDuration = abi.ChainEpoch(extraI)
+			}
+			// t.MaxDealDuration (abi.ChainEpoch) (int64)
+		case "MaxDealDuration":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.MaxDealDuration = abi.ChainEpoch(extraI)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *DataRef) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{165}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.TransferType (string) (string)
 	if len("TransferType") > cbg.MaxLength {
 		return xerrors.Errorf("Value in field \"TransferType\" was too long")
 	}
@@ -2113,6 +3013,28 @@ func (t *DataRef) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.TransferAgent (peer.ID) (string)
+	if len("TransferAgent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferAgent\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferAgent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferAgent")); err != nil {
+		return err
+	}
+
+	if len(t.TransferAgent) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.TransferAgent was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.TransferAgent))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.TransferAgent)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -2211,6 +3133,17 @@ func (t *DataRef) UnmarshalCBOR(r io.Reader) error {
 				t.PieceSize = abi.UnpaddedPieceSize(extra)
 
 			}
+			// t.TransferAgent (peer.ID) (string)
+		case "TransferAgent":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.TransferAgent = peer.ID(sval)
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
@@ -2224,7 +3157,7 @@ func (t *ProviderDealState) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{168}); err != nil {
+	if _, err := w.Write([]byte{171}); err != nil {
 		return err
 	}
 
@@ -2382,6 +3315,54 @@ func (t *ProviderDealState) MarshalCBOR(w io.Writer) error {
 	if err := cbg.WriteBool(w, t.FastRetrieval); err != nil {
 		return err
 	}
+
+	// t.TransferChannelID (datatransfer.ChannelID) (struct)
+	if len("TransferChannelID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferChannelID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferChannelID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferChannelID")); err != nil {
+		return err
+	}
+
+	if err := t.TransferChannelID.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.TransferReceived (uint64) (uint64)
+	if len("TransferReceived") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"TransferReceived\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("TransferReceived"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("TransferReceived")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.TransferReceived)); err != nil {
+		return err
+	}
+
+	// t.Sealing (storagemarket.SealingProgress) (struct)
+	if len("Sealing") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Sealing\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Sealing"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Sealing")); err != nil {
+		return err
+	}
+
+	if err := t.Sealing.MarshalCBOR(w); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -2566,6 +3547,1781 @@ func (t *ProviderDealState) UnmarshalCBOR(r io.Reader) error {
 			default:
 				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
 			}
+			// t.TransferChannelID (datatransfer.ChannelID) (struct)
+		case "TransferChannelID":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.TransferChannelID = new(datatransfer.ChannelID)
+					if err := t.TransferChannelID.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.TransferChannelID pointer: %w", err)
+					}
+				}
+
+			}
+			// t.TransferReceived (uint64) (uint64)
+		case "TransferReceived":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.TransferReceived = uint64(extra)
+
+			}
+			// t.Sealing (storagemarket.SealingProgress) (struct)
+		case "Sealing":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Sealing = new(SealingProgress)
+					if err := t.Sealing.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Sealing pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *DealLifecycleEvent) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{170}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Sequence (uint64) (uint64)
+	if len("Sequence") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Sequence\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Sequence"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Sequence")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Sequence)); err != nil {
+		return err
+	}
+
+	// t.Stage (storagemarket.DealLifecycleStage) (uint64)
+	if len("Stage") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Stage\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Stage"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Stage")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Stage)); err != nil {
+		return err
+	}
+
+	// t.ProposalCid (cid.Cid) (struct)
+	if len("ProposalCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ProposalCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ProposalCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ProposalCid")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.ProposalCid); err != nil {
+		return xerrors.Errorf("failed to write cid field t.ProposalCid: %w", err)
+	}
+
+	// t.Provider (address.Address) (struct)
+	if len("Provider") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Provider\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Provider"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Provider")); err != nil {
+		return err
+	}
+
+	if err := t.Provider.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.PayloadCID (cid.Cid) (struct)
+	if len("PayloadCID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PayloadCID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PayloadCID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PayloadCID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.PayloadCID); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PayloadCID: %w", err)
+	}
+
+	// t.PieceCID (cid.Cid) (struct)
+	if len("PieceCID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PieceCID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PieceCID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PieceCID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.PieceCID); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PieceCID: %w", err)
+	}
+
+	// t.DealID (abi.DealID) (uint64)
+	if len("DealID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DealID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("DealID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DealID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.DealID)); err != nil {
+		return err
+	}
+
+	// t.PublishMessage (cid.Cid) (struct)
+	if len("PublishMessage") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PublishMessage\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PublishMessage"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PublishMessage")); err != nil {
+		return err
+	}
+
+	if t.PublishMessage == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.PublishMessage); err != nil {
+			return xerrors.Errorf("failed to write cid field t.PublishMessage: %w", err)
+		}
+	}
+
+	// t.State (uint64) (uint64)
+	if len("State") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"State\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("State"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("State")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.State)); err != nil {
+		return err
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealLifecycleEvent) UnmarshalCBOR(r io.Reader) error {
+	*t = DealLifecycleEvent{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealLifecycleEvent: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Sequence (uint64) (uint64)
+		case "Sequence":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Sequence = uint64(extra)
+
+			}
+			// t.Stage (storagemarket.DealLifecycleStage) (uint64)
+		case "Stage":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Stage = DealLifecycleStage(extra)
+
+			}
+			// t.ProposalCid (cid.Cid) (struct)
+		case "ProposalCid":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.ProposalCid: %w", err)
+				}
+
+				t.ProposalCid = c
+
+			}
+			// t.Provider (address.Address) (struct)
+		case "Provider":
+
+			{
+
+				if err := t.Provider.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Provider: %w", err)
+				}
+
+			}
+			// t.PayloadCID (cid.Cid) (struct)
+		case "PayloadCID":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.PayloadCID: %w", err)
+				}
+
+				t.PayloadCID = c
+
+			}
+			// t.PieceCID (cid.Cid) (struct)
+		case "PieceCID":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.PieceCID: %w", err)
+				}
+
+				t.PieceCID = c
+
+			}
+			// t.DealID (abi.DealID) (uint64)
+		case "DealID":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.DealID = abi.DealID(extra)
+
+			}
+			// t.PublishMessage (cid.Cid) (struct)
+		case "PublishMessage":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.PublishMessage: %w", err)
+					}
+
+					t.PublishMessage = &c
+				}
+
+			}
+			// t.State (uint64) (uint64)
+		case "State":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.State = uint64(extra)
+
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *ScheduledDeal) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{179}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.ID (uint64) (uint64)
+	if len("ID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ID")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.ID)); err != nil {
+		return err
+	}
+
+	// t.Client (address.Address) (struct)
+	if len("Client") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Client\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Client"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Client")); err != nil {
+		return err
+	}
+
+	if err := t.Client.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Provider (address.Address) (struct)
+	if len("Provider") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Provider\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Provider"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Provider")); err != nil {
+		return err
+	}
+
+	if err := t.Provider.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Data (storagemarket.DataRef) (struct)
+	if len("Data") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Data\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Data"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Data")); err != nil {
+		return err
+	}
+
+	if err := t.Data.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.StartEpoch (abi.ChainEpoch) (int64)
+	if len("StartEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"StartEpoch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("StartEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("StartEpoch")); err != nil {
+		return err
+	}
+
+	if t.StartEpoch >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.StartEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.StartEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.EndEpoch (abi.ChainEpoch) (int64)
+	if len("EndEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"EndEpoch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("EndEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("EndEpoch")); err != nil {
+		return err
+	}
+
+	if t.EndEpoch >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.EndEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.EndEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Price (big.Int) (struct)
+	if len("Price") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Price\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Price"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Price")); err != nil {
+		return err
+	}
+
+	if err := t.Price.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Collateral (big.Int) (struct)
+	if len("Collateral") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Collateral\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Collateral"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Collateral")); err != nil {
+		return err
+	}
+
+	if err := t.Collateral.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Rt (abi.RegisteredSealProof) (int64)
+	if len("Rt") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Rt\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Rt"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Rt")); err != nil {
+		return err
+	}
+
+	if t.Rt >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Rt)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Rt-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.FastRetrieval (bool) (bool)
+	if len("FastRetrieval") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"FastRetrieval\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("FastRetrieval"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("FastRetrieval")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.FastRetrieval); err != nil {
+		return err
+	}
+
+	// t.VerifiedDeal (bool) (bool)
+	if len("VerifiedDeal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"VerifiedDeal\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("VerifiedDeal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("VerifiedDeal")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.VerifiedDeal); err != nil {
+		return err
+	}
+
+	// t.StoreID (multistore.StoreID) (uint64)
+	if len("StoreID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"StoreID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("StoreID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("StoreID")); err != nil {
+		return err
+	}
+
+	if t.StoreID == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(*t.StoreID)); err != nil {
+			return err
+		}
+	}
+
+	// t.NotBefore (typegen.CborTime) (struct)
+	if len("NotBefore") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"NotBefore\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("NotBefore"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("NotBefore")); err != nil {
+		return err
+	}
+
+	if err := t.NotBefore.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.NotBeforeEpoch (abi.ChainEpoch) (int64)
+	if len("NotBeforeEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"NotBeforeEpoch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("NotBeforeEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("NotBeforeEpoch")); err != nil {
+		return err
+	}
+
+	if t.NotBeforeEpoch >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.NotBeforeEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.NotBeforeEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Proposing (bool) (bool)
+	if len("Proposing") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Proposing\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Proposing"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Proposing")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Proposing); err != nil {
+		return err
+	}
+
+	// t.ProposalCid (cid.Cid) (struct)
+	if len("ProposalCid") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"ProposalCid\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("ProposalCid"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("ProposalCid")); err != nil {
+		return err
+	}
+
+	if t.ProposalCid == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.ProposalCid); err != nil {
+			return xerrors.Errorf("failed to write cid field t.ProposalCid: %w", err)
+		}
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+
+	// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+	if len("Invoice") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Invoice\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Invoice"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Invoice")); err != nil {
+		return err
+	}
+
+	if err := t.Invoice.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Envelope (envelope.Envelope) (struct)
+	if len("Envelope") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Envelope\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Envelope"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Envelope")); err != nil {
+		return err
+	}
+
+	if err := t.Envelope.MarshalCBOR(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *ScheduledDeal) UnmarshalCBOR(r io.Reader) error {
+	*t = ScheduledDeal{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("ScheduledDeal: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.ID (uint64) (uint64)
+		case "ID":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.ID = uint64(extra)
+
+			}
+			// t.Client (address.Address) (struct)
+		case "Client":
+
+			{
+
+				if err := t.Client.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Client: %w", err)
+				}
+
+			}
+			// t.Provider (address.Address) (struct)
+		case "Provider":
+
+			{
+
+				if err := t.Provider.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Provider: %w", err)
+				}
+
+			}
+			// t.Data (storagemarket.DataRef) (struct)
+		case "Data":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Data = new(DataRef)
+					if err := t.Data.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Data pointer: %w", err)
+					}
+				}
+
+			}
+			// t.StartEpoch (abi.ChainEpoch) (int64)
+		case "StartEpoch":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.StartEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.EndEpoch (abi.ChainEpoch) (int64)
+		case "EndEpoch":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.EndEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.Price (big.Int) (struct)
+		case "Price":
+
+			{
+
+				if err := t.Price.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Price: %w", err)
+				}
+
+			}
+			// t.Collateral (big.Int) (struct)
+		case "Collateral":
+
+			{
+
+				if err := t.Collateral.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Collateral: %w", err)
+				}
+
+			}
+			// t.Rt (abi.RegisteredSealProof) (int64)
+		case "Rt":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Rt = abi.RegisteredSealProof(extraI)
+			}
+			// t.FastRetrieval (bool) (bool)
+		case "FastRetrieval":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.FastRetrieval = false
+			case 21:
+				t.FastRetrieval = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.VerifiedDeal (bool) (bool)
+		case "VerifiedDeal":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.VerifiedDeal = false
+			case 21:
+				t.VerifiedDeal = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.StoreID (multistore.StoreID) (uint64)
+		case "StoreID":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+					if maj != cbg.MajUnsignedInt {
+						return fmt.Errorf("wrong type for uint64 field")
+					}
+					typed := multistore.StoreID(extra)
+					t.StoreID = &typed
+				}
+
+			}
+			// t.NotBefore (typegen.CborTime) (struct)
+		case "NotBefore":
+
+			{
+
+				if err := t.NotBefore.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.NotBefore: %w", err)
+				}
+
+			}
+			// t.NotBeforeEpoch (abi.ChainEpoch) (int64)
+		case "NotBeforeEpoch":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.NotBeforeEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.Proposing (bool) (bool)
+		case "Proposing":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Proposing = false
+			case 21:
+				t.Proposing = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.ProposalCid (cid.Cid) (struct)
+		case "ProposalCid":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.ProposalCid: %w", err)
+					}
+
+					t.ProposalCid = &c
+				}
+
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+			// t.Invoice (storagemarket.InvoiceMetadata) (struct)
+		case "Invoice":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Invoice = new(InvoiceMetadata)
+					if err := t.Invoice.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Invoice pointer: %w", err)
+					}
+				}
+
+			}
+			// t.Envelope (envelope.Envelope) (struct)
+		case "Envelope":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+					t.Envelope = new(envelope.Envelope)
+					if err := t.Envelope.UnmarshalCBOR(br); err != nil {
+						return xerrors.Errorf("unmarshaling t.Envelope pointer: %w", err)
+					}
+				}
+
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *DealRenewal) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{166}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.OldDeal (cid.Cid) (struct)
+	if len("OldDeal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"OldDeal\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("OldDeal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("OldDeal")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCidBuf(scratch, w, t.OldDeal); err != nil {
+		return xerrors.Errorf("failed to write cid field t.OldDeal: %w", err)
+	}
+
+	// t.NewDeal (cid.Cid) (struct)
+	if len("NewDeal") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"NewDeal\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("NewDeal"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("NewDeal")); err != nil {
+		return err
+	}
+
+	if t.NewDeal == nil {
+		if _, err := w.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteCidBuf(scratch, w, *t.NewDeal); err != nil {
+			return xerrors.Errorf("failed to write cid field t.NewDeal: %w", err)
+		}
+	}
+
+	// t.Provider (address.Address) (struct)
+	if len("Provider") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Provider\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Provider"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Provider")); err != nil {
+		return err
+	}
+
+	if err := t.Provider.MarshalCBOR(w); err != nil {
+		return err
+	}
+
+	// t.Declined (bool) (bool)
+	if len("Declined") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Declined\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Declined"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Declined")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteBool(w, t.Declined); err != nil {
+		return err
+	}
+
+	// t.Epoch (abi.ChainEpoch) (int64)
+	if len("Epoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Epoch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Epoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Epoch")); err != nil {
+		return err
+	}
+
+	if t.Epoch >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Epoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Epoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Message (string) (string)
+	if len("Message") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Message\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Message"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Message")); err != nil {
+		return err
+	}
+
+	if len(t.Message) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Message was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Message))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Message)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DealRenewal) UnmarshalCBOR(r io.Reader) error {
+	*t = DealRenewal{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("DealRenewal: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.OldDeal (cid.Cid) (struct)
+		case "OldDeal":
+
+			{
+
+				c, err := cbg.ReadCid(br)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.OldDeal: %w", err)
+				}
+
+				t.OldDeal = c
+
+			}
+			// t.NewDeal (cid.Cid) (struct)
+		case "NewDeal":
+
+			{
+
+				b, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := br.UnreadByte(); err != nil {
+						return err
+					}
+
+					c, err := cbg.ReadCid(br)
+					if err != nil {
+						return xerrors.Errorf("failed to read cid field t.NewDeal: %w", err)
+					}
+
+					t.NewDeal = &c
+				}
+
+			}
+			// t.Provider (address.Address) (struct)
+		case "Provider":
+
+			{
+
+				if err := t.Provider.UnmarshalCBOR(br); err != nil {
+					return xerrors.Errorf("unmarshaling t.Provider: %w", err)
+				}
+
+			}
+			// t.Declined (bool) (bool)
+		case "Declined":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajOther {
+				return fmt.Errorf("booleans must be major type 7")
+			}
+			switch extra {
+			case 20:
+				t.Declined = false
+			case 21:
+				t.Declined = true
+			default:
+				return fmt.Errorf("booleans are either major type 7, value 20 or 21 (got %d)", extra)
+			}
+			// t.Epoch (abi.ChainEpoch) (int64)
+		case "Epoch":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Epoch = abi.ChainEpoch(extraI)
+			}
+			// t.Message (string) (string)
+		case "Message":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Message = string(sval)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *SealingProgress) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Stage (storagemarket.SealingStage) (uint64)
+	if len("Stage") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Stage\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Stage"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Stage")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Stage)); err != nil {
+		return err
+	}
+
+	// t.PreCommitEpoch (abi.ChainEpoch) (int64)
+	if len("PreCommitEpoch") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"PreCommitEpoch\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("PreCommitEpoch"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("PreCommitEpoch")); err != nil {
+		return err
+	}
+
+	if t.PreCommitEpoch >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.PreCommitEpoch)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.PreCommitEpoch-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.EstimatedActivation (abi.ChainEpoch) (int64)
+	if len("EstimatedActivation") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"EstimatedActivation\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("EstimatedActivation"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("EstimatedActivation")); err != nil {
+		return err
+	}
+
+	if t.EstimatedActivation >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.EstimatedActivation)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.EstimatedActivation-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *SealingProgress) UnmarshalCBOR(r io.Reader) error {
+	*t = SealingProgress{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("SealingProgress: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Stage (storagemarket.SealingStage) (uint64)
+		case "Stage":
+
+			{
+
+				maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+				if maj != cbg.MajUnsignedInt {
+					return fmt.Errorf("wrong type for uint64 field")
+				}
+				t.Stage = SealingStage(extra)
+
+			}
+			// t.PreCommitEpoch (abi.ChainEpoch) (int64)
+		case "PreCommitEpoch":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.PreCommitEpoch = abi.ChainEpoch(extraI)
+			}
+			// t.EstimatedActivation (abi.ChainEpoch) (int64)
+		case "EstimatedActivation":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.EstimatedActivation = abi.ChainEpoch(extraI)
+			}
+
+		default:
+			return fmt.Errorf("unknown struct field %d: '%s'", i, name)
+		}
+	}
+
+	return nil
+}
+func (t *InvoiceMetadata) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.InvoiceID (string) (string)
+	if len("InvoiceID") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"InvoiceID\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("InvoiceID"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("InvoiceID")); err != nil {
+		return err
+	}
+
+	if len(t.InvoiceID) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.InvoiceID was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.InvoiceID))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.InvoiceID)); err != nil {
+		return err
+	}
+
+	// t.Currency (string) (string)
+	if len("Currency") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Currency\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Currency"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Currency")); err != nil {
+		return err
+	}
+
+	if len(t.Currency) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Currency was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Currency))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Currency)); err != nil {
+		return err
+	}
+
+	// t.QuoteReference (string) (string)
+	if len("QuoteReference") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"QuoteReference\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("QuoteReference"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("QuoteReference")); err != nil {
+		return err
+	}
+
+	if len(t.QuoteReference) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.QuoteReference was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.QuoteReference))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.QuoteReference)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *InvoiceMetadata) UnmarshalCBOR(r io.Reader) error {
+	*t = InvoiceMetadata{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("InvoiceMetadata: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.InvoiceID (string) (string)
+		case "InvoiceID":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.InvoiceID = string(sval)
+			}
+			// t.Currency (string) (string)
+		case "Currency":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Currency = string(sval)
+			}
+			// t.QuoteReference (string) (string)
+		case "QuoteReference":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.QuoteReference = string(sval)
+			}
 
 		default:
 			return fmt.Errorf("unknown struct field %d: '%s'", i, name)